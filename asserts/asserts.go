@@ -92,6 +92,7 @@ var (
 	ValidationType      = &AssertionType{"validation", []string{"series", "snap-id", "approved-snap-id", "approved-snap-revision"}, assembleValidation, 0}
 	ValidationSetType   = &AssertionType{"validation-set", []string{"series", "account-id", "name", "sequence"}, assembleValidationSet, sequenceForming}
 	StoreType           = &AssertionType{"store", []string{"store"}, assembleStore, 0}
+	SystemPolicyType    = &AssertionType{"system-policy", []string{"brand-id", "model"}, assembleSystemPolicy, 0}
 
 // ...
 )
@@ -118,6 +119,7 @@ var typeRegistry = map[string]*AssertionType{
 	ValidationSetType.Name:   ValidationSetType,
 	RepairType.Name:          RepairType,
 	StoreType.Name:           StoreType,
+	SystemPolicyType.Name:    SystemPolicyType,
 	// no authority
 	DeviceSessionRequestType.Name: DeviceSessionRequestType,
 	SerialRequestType.Name:        SerialRequestType,
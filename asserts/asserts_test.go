@@ -62,6 +62,7 @@ func (as *assertsSuite) TestTypeNames(c *C) {
 		"snap-developer",
 		"snap-revision",
 		"store",
+		"system-policy",
 		"system-user",
 		"test-only",
 		"test-only-2",
@@ -952,6 +953,7 @@ func (as *assertsSuite) TestWithAuthority(c *C) {
 		"validation",
 		"validation-set",
 		"repair",
+		"system-policy",
 	}
 	c.Check(withAuthority, HasLen, asserts.NumAssertionType-3) // excluding device-session-request, serial-request, account-key-request
 	for _, name := range withAuthority {
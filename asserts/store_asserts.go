@@ -22,6 +22,7 @@ package asserts
 import (
 	"fmt"
 	"net/url"
+	"regexp"
 	"time"
 )
 
@@ -31,6 +32,7 @@ type Store struct {
 	assertionBase
 	url            *url.URL
 	friendlyStores []string
+	tlsPins        []string
 	timestamp      time.Time
 }
 
@@ -55,6 +57,13 @@ func (store *Store) FriendlyStores() []string {
 	return store.friendlyStores
 }
 
+// TLSPins returns the pins of the public keys the TLS certificates of the
+// store's API must match, in the "sha256/<base64 encoded digest of the
+// subject public key info>" form.
+func (store *Store) TLSPins() []string {
+	return store.tlsPins
+}
+
 // Location returns a summary of the store's location/purpose.
 func (store *Store) Location() string {
 	return store.HeaderString("location")
@@ -127,6 +136,9 @@ func checkStoreURL(headers map[string]interface{}) (*url.URL, error) {
 	return u, nil
 }
 
+// validTLSPin matches a sha256 digest of a subject public key info
+var validTLSPin = regexp.MustCompile("^sha256/[A-Za-z0-9+/]{43}=$")
+
 func assembleStore(assert assertionBase) (Assertion, error) {
 	_, err := checkNotEmptyString(assert.headers, "operator-id")
 	if err != nil {
@@ -143,6 +155,11 @@ func assembleStore(assert assertionBase) (Assertion, error) {
 		return nil, err
 	}
 
+	tlsPins, err := checkStringListMatches(assert.headers, "tls-pins", validTLSPin)
+	if err != nil {
+		return nil, err
+	}
+
 	_, err = checkOptionalString(assert.headers, "location")
 	if err != nil {
 		return nil, err
@@ -157,6 +174,7 @@ func assembleStore(assert assertionBase) (Assertion, error) {
 		assertionBase:  assert,
 		url:            url,
 		friendlyStores: friendlyStores,
+		tlsPins:        tlsPins,
 		timestamp:      timestamp,
 	}, nil
 }
@@ -64,6 +64,7 @@ func (s *storeSuite) TestDecodeOK(c *C) {
 	c.Check(store.Location(), Equals, "upstairs")
 	c.Check(store.Timestamp().Equal(s.ts), Equals, true)
 	c.Check(store.FriendlyStores(), HasLen, 0)
+	c.Check(store.TLSPins(), HasLen, 0)
 }
 
 var storeErrPrefix = "assertion store: "
@@ -80,6 +81,8 @@ func (s *storeSuite) TestDecodeInvalidHeaders(c *C) {
 		{s.tsLine, "timestamp: \n", `"timestamp" header should not be empty`},
 		{s.tsLine, "timestamp: 12:30\n", `"timestamp" header is not a RFC3339 date: .*`},
 		{"url: https://store.example.com\n", "friendly-stores: foo\n", `"friendly-stores" header must be a list of strings`},
+		{"url: https://store.example.com\n", "tls-pins: foo\n", `"tls-pins" header must be a list of strings`},
+		{"url: https://store.example.com\n", "tls-pins:\n  - sha1/AAAAAAAAAAAAAAAAAAAAAAAAAAA=\n", `"tls-pins" header contains an invalid element: "sha1/AAAAAAAAAAAAAAAAAAAAAAAAAAA="`},
 	}
 
 	for _, test := range tests {
@@ -202,6 +205,20 @@ func (s *storeSuite) TestFriendlyStores(c *C) {
 	c.Check(store.FriendlyStores(), DeepEquals, []string{"store1", "store2", "store3"})
 }
 
+func (s *storeSuite) TestTLSPins(c *C) {
+	pin1 := "sha256/" + strings.Repeat("A", 43) + "="
+	pin2 := "sha256/" + strings.Repeat("b", 43) + "="
+	encoded := strings.Replace(s.validExample, "location: upstairs\n", fmt.Sprintf(`location: upstairs
+tls-pins:
+  - %s
+  - %s
+`, pin1, pin2), 1)
+	assert, err := asserts.Decode([]byte(encoded))
+	c.Assert(err, IsNil)
+	store := assert.(*asserts.Store)
+	c.Check(store.TLSPins(), DeepEquals, []string{pin1, pin2})
+}
+
 func (s *storeSuite) TestCheckOperatorAccount(c *C) {
 	storeDB, db := makeStoreAndCheckDB(c)
 
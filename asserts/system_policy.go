@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package asserts
+
+import (
+	"time"
+)
+
+// SystemPolicy holds a system-policy assertion which allows a brand
+// to restrict what can be done on the devices of one of its models,
+// like installing unasserted snaps or snaps in devmode.
+type SystemPolicy struct {
+	assertionBase
+
+	disallowDangerousInstalls bool
+	disallowDevModeInstalls   bool
+	timestamp                 time.Time
+}
+
+// BrandID returns the brand identifier that signed this assertion.
+func (sp *SystemPolicy) BrandID() string {
+	return sp.HeaderString("brand-id")
+}
+
+// Model returns the model name the policy applies to.
+func (sp *SystemPolicy) Model() string {
+	return sp.HeaderString("model")
+}
+
+// DisallowDangerousInstalls returns whether installing snaps without
+// assertions, ie. with --dangerous, is prohibited.
+func (sp *SystemPolicy) DisallowDangerousInstalls() bool {
+	return sp.disallowDangerousInstalls
+}
+
+// DisallowDevModeInstalls returns whether installing snaps in devmode
+// is prohibited.
+func (sp *SystemPolicy) DisallowDevModeInstalls() bool {
+	return sp.disallowDevModeInstalls
+}
+
+// Timestamp returns the time when the system-policy assertion was issued.
+func (sp *SystemPolicy) Timestamp() time.Time {
+	return sp.timestamp
+}
+
+func assembleSystemPolicy(assert assertionBase) (Assertion, error) {
+	err := checkAuthorityMatchesBrand(&assert)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = checkModel(assert.headers)
+	if err != nil {
+		return nil, err
+	}
+
+	disallowDangerous, err := checkOptionalBool(assert.headers, "disallow-dangerous-installs")
+	if err != nil {
+		return nil, err
+	}
+
+	disallowDevMode, err := checkOptionalBool(assert.headers, "disallow-devmode-installs")
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := checkRFC3339Date(assert.headers, "timestamp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SystemPolicy{
+		assertionBase:             assert,
+		disallowDangerousInstalls: disallowDangerous,
+		disallowDevModeInstalls:   disallowDevMode,
+		timestamp:                 timestamp,
+	}, nil
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package asserts_test
+
+import (
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+var _ = Suite(&systemPolicySuite{})
+
+type systemPolicySuite struct {
+	ts           time.Time
+	tsLine       string
+	validExample string
+}
+
+func (s *systemPolicySuite) SetUpSuite(c *C) {
+	s.ts = time.Now().Truncate(time.Second).UTC()
+	s.tsLine = "timestamp: " + s.ts.Format(time.RFC3339) + "\n"
+	s.validExample = "type: system-policy\n" +
+		"authority-id: brand-id1\n" +
+		"brand-id: brand-id1\n" +
+		"model: baz-3000\n" +
+		"disallow-dangerous-installs: true\n" +
+		"disallow-devmode-installs: false\n" +
+		s.tsLine +
+		"sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij\n" +
+		"\n" +
+		"AXNpZw=="
+}
+
+func (s *systemPolicySuite) TestDecodeOK(c *C) {
+	a, err := asserts.Decode([]byte(s.validExample))
+	c.Assert(err, IsNil)
+	c.Check(a.Type(), Equals, asserts.SystemPolicyType)
+	policy := a.(*asserts.SystemPolicy)
+
+	c.Check(policy.AuthorityID(), Equals, "brand-id1")
+	c.Check(policy.BrandID(), Equals, "brand-id1")
+	c.Check(policy.Model(), Equals, "baz-3000")
+	c.Check(policy.DisallowDangerousInstalls(), Equals, true)
+	c.Check(policy.DisallowDevModeInstalls(), Equals, false)
+	c.Check(policy.Timestamp().Equal(s.ts), Equals, true)
+}
+
+func (s *systemPolicySuite) TestDecodeDefaults(c *C) {
+	encoded := strings.Replace(s.validExample, "disallow-dangerous-installs: true\n", "", 1)
+	encoded = strings.Replace(encoded, "disallow-devmode-installs: false\n", "", 1)
+	a, err := asserts.Decode([]byte(encoded))
+	c.Assert(err, IsNil)
+	policy := a.(*asserts.SystemPolicy)
+	c.Check(policy.DisallowDangerousInstalls(), Equals, false)
+	c.Check(policy.DisallowDevModeInstalls(), Equals, false)
+}
+
+const systemPolicyErrPrefix = "assertion system-policy: "
+
+func (s *systemPolicySuite) TestDecodeInvalidHeaders(c *C) {
+	tests := []struct{ original, invalid, expectedErr string }{
+		{"brand-id: brand-id1\n", "", `"brand-id" header is mandatory`},
+		{"brand-id: brand-id1\n", "brand-id: \n", `"brand-id" header should not be empty`},
+		{"brand-id: brand-id1\n", "brand-id: other\n", `authority-id and brand-id must match, system-policy assertions are expected to be signed by the brand: "brand-id1" != "other"`},
+		{"model: baz-3000\n", "", `"model" header is mandatory`},
+		{"model: baz-3000\n", "model: \n", `"model" header should not be empty`},
+		{"model: baz-3000\n", "model: -\n", `"model" header contains invalid characters: "-"`},
+		{"disallow-dangerous-installs: true\n", "disallow-dangerous-installs: yes\n", `"disallow-dangerous-installs" header must be 'true' or 'false'`},
+		{"disallow-devmode-installs: false\n", "disallow-devmode-installs:\n  - foo\n", `"disallow-devmode-installs" header must be 'true' or 'false'`},
+		{s.tsLine, "", `"timestamp" header is mandatory`},
+		{s.tsLine, "timestamp: 12:30\n", `"timestamp" header is not a RFC3339 date: .*`},
+	}
+
+	for _, test := range tests {
+		invalid := strings.Replace(s.validExample, test.original, test.invalid, 1)
+		_, err := asserts.Decode([]byte(invalid))
+		c.Check(err, ErrorMatches, systemPolicyErrPrefix+test.expectedErr)
+	}
+}
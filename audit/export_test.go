@@ -0,0 +1,42 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+)
+
+func MockJournalStreamFile(f func(identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error)) (restore func()) {
+	old := journalStreamFile
+	journalStreamFile = f
+	return func() {
+		journalStreamFile = old
+	}
+}
+
+func MockStreamCommand(f func(name string, args ...string) (io.ReadCloser, error)) (restore func()) {
+	old := osutilStreamCommand
+	osutilStreamCommand = f
+	return func() {
+		osutilStreamCommand = old
+	}
+}
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package audit records and queries the invocations of snap run when
+// auditing is enabled. Records are kept in the system journal, which is
+// append-only for its writers and takes care of rotation. Any process can
+// write entries with the identifier of the records, so only the entries the
+// journal attributes to the snap command itself are trusted.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/systemd"
+)
+
+// snapRunIdentifier is the syslog identifier of the journal entries holding
+// the records.
+const snapRunIdentifier = "snap-run-audit"
+
+// SnapRunRecord describes an invocation of snap run.
+type SnapRunRecord struct {
+	Time time.Time `json:"time"`
+	Snap string    `json:"snap"`
+	App  string    `json:"app,omitempty"`
+	Hook string    `json:"hook,omitempty"`
+	// UID is the user who ran the snap, when reading records it is
+	// the one known to the journal rather than the recorded one
+	UID         int    `json:"uid"`
+	User        string `json:"user,omitempty"`
+	CmdlineHash string `json:"cmdline-hash"`
+	// ExitCode is the exit code of the application, 128 plus the
+	// signal number if it was killed by a signal
+	ExitCode int           `json:"exit-code"`
+	Duration time.Duration `json:"duration"`
+	// Service is set for services, which are exec'ed as systemd
+	// supervises them, their exit code and duration are not known
+	// and are left to systemd
+	Service bool `json:"service,omitempty"`
+}
+
+var (
+	journalStreamFile   = systemd.NewJournalStreamFile
+	osutilStreamCommand = osutil.StreamCommand
+)
+
+// CmdlineHash returns the hash of the command line, so that records do not
+// leak the possibly sensitive arguments.
+func CmdlineHash(args []string) string {
+	h := sha256.New()
+	for _, arg := range args {
+		h.Write([]byte(arg))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RecordSnapRun appends the record to the journal.
+func RecordSnapRun(rec *SnapRunRecord) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := journalStreamFile(snapRunIdentifier, syslog.LOG_INFO, false)
+	if err != nil {
+		return fmt.Errorf("cannot open journal stream: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("cannot write audit record: %v", err)
+	}
+	return nil
+}
+
+// QueryOptions selects snap run records.
+type QueryOptions struct {
+	// Snap selects the records of the given snap only.
+	Snap string
+	// Since selects the records from the given time on.
+	Since time.Time
+	// N is the maximum number of most recent records, all when not
+	// positive.
+	N int
+}
+
+// SnapRunRecords returns the records selected by the options, oldest first.
+func SnapRunRecords(opts *QueryOptions) ([]*SnapRunRecord, error) {
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+	args := []string{"-o", "json", "--no-pager", "-t", snapRunIdentifier}
+	if !opts.Since.IsZero() {
+		args = append(args, "--since", "@"+strconv.FormatInt(opts.Since.Unix(), 10))
+	}
+	if opts.Snap == "" && opts.N > 0 {
+		// otherwise the filtering happens after reading
+		args = append(args, "-n", strconv.Itoa(opts.N))
+	}
+	rc, err := osutilStreamCommand("journalctl", args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audit records: %v", err)
+	}
+	defer rc.Close()
+
+	records, err := parseJournal(rc)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Snap != "" {
+		filtered := records[:0]
+		for _, rec := range records {
+			if rec.Snap == opts.Snap {
+				filtered = append(filtered, rec)
+			}
+		}
+		records = filtered
+		if opts.N > 0 && len(records) > opts.N {
+			records = records[len(records)-opts.N:]
+		}
+	}
+	return records, nil
+}
+
+type journalEntry struct {
+	Message json.RawMessage `json:"MESSAGE"`
+	// the fields prefixed with an underscore are added by the journal
+	// and cannot be set by the writer
+	UID string `json:"_UID"`
+	Exe string `json:"_EXE"`
+}
+
+// isSnapCommand returns whether the executable is the snap command of the
+// distribution or the one of the snapd or core snap snap run re-executes
+// into.
+func isSnapCommand(exe string) bool {
+	if exe == "/usr/bin/snap" {
+		return true
+	}
+	mountDir := dirs.StripRootDir(dirs.SnapMountDir)
+	for _, snapName := range []string{"snapd", "core"} {
+		pattern := filepath.Join(mountDir, snapName, "*", "usr/bin/snap")
+		if ok, _ := filepath.Match(pattern, exe); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseJournal(r io.Reader) ([]*SnapRunRecord, error) {
+	var records []*SnapRunRecord
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("cannot decode journal entry: %v", err)
+		}
+		if !isSnapCommand(entry.Exe) {
+			// forged by another writer
+			continue
+		}
+		// the message is a string, or an array of bytes if it is
+		// not valid UTF-8 in which case it is not a record
+		var msg string
+		if err := json.Unmarshal(entry.Message, &msg); err != nil {
+			continue
+		}
+		var rec SnapRunRecord
+		if err := json.Unmarshal([]byte(msg), &rec); err != nil {
+			// not written by snap run
+			continue
+		}
+		// the journal knows who really wrote the entry
+		uid, err := strconv.Atoi(entry.UID)
+		if err != nil {
+			continue
+		}
+		rec.UID = uid
+		records = append(records, &rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read audit records: %v", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/audit"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type snapRunSuite struct{}
+
+var _ = Suite(&snapRunSuite{})
+
+func (s *snapRunSuite) TestCmdlineHash(c *C) {
+	h := audit.CmdlineHash([]string{"foo", "bar"})
+	c.Check(h, HasLen, 64)
+	c.Check(audit.CmdlineHash([]string{"foo", "bar"}), Equals, h)
+	// argument boundaries matter
+	c.Check(audit.CmdlineHash([]string{"foob", "ar"}), Not(Equals), h)
+	c.Check(audit.CmdlineHash([]string{"foobar"}), Not(Equals), h)
+}
+
+func (s *snapRunSuite) TestRecordSnapRun(c *C) {
+	out := filepath.Join(c.MkDir(), "journal")
+	restore := audit.MockJournalStreamFile(func(identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error) {
+		c.Check(identifier, Equals, "snap-run-audit")
+		c.Check(priority, Equals, syslog.LOG_INFO)
+		c.Check(levelPrefix, Equals, false)
+		return os.Create(out)
+	})
+	defer restore()
+
+	rec := &audit.SnapRunRecord{
+		Time:        time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+		Snap:        "foo",
+		App:         "bar",
+		UID:         1000,
+		User:        "user",
+		CmdlineHash: "1234",
+		ExitCode:    3,
+		Duration:    2 * time.Second,
+	}
+	err := audit.RecordSnapRun(rec)
+	c.Assert(err, IsNil)
+
+	data, err := ioutil.ReadFile(out)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `{"time":"2021-06-01T10:00:00Z","snap":"foo","app":"bar","uid":1000,"user":"user","cmdline-hash":"1234","exit-code":3,"duration":2000000000}`+"\n")
+}
+
+func (s *snapRunSuite) TestRecordSnapRunError(c *C) {
+	restore := audit.MockJournalStreamFile(func(identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error) {
+		return nil, errors.New("boom")
+	})
+	defer restore()
+
+	err := audit.RecordSnapRun(&audit.SnapRunRecord{Snap: "foo"})
+	c.Check(err, ErrorMatches, "cannot open journal stream: boom")
+}
+
+func journalLine(c *C, uid, exe string, rec *audit.SnapRunRecord) string {
+	msg, err := json.Marshal(rec)
+	c.Assert(err, IsNil)
+	line, err := json.Marshal(map[string]string{
+		"MESSAGE":           string(msg),
+		"_UID":              uid,
+		"_EXE":              exe,
+		"SYSLOG_IDENTIFIER": "snap-run-audit",
+	})
+	c.Assert(err, IsNil)
+	return string(line) + "\n"
+}
+
+func (s *snapRunSuite) mockJournal(c *C, journal string, args *[]string) (restore func()) {
+	return audit.MockStreamCommand(func(name string, cmdArgs ...string) (io.ReadCloser, error) {
+		c.Check(name, Equals, "journalctl")
+		*args = cmdArgs
+		return ioutil.NopCloser(strings.NewReader(journal)), nil
+	})
+}
+
+func (s *snapRunSuite) TestSnapRunRecords(c *C) {
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	journal := journalLine(c, "1000", "/usr/bin/snap", &audit.SnapRunRecord{Time: t0, Snap: "foo", App: "foo", UID: 1000, CmdlineHash: "1"}) +
+		// the journal knows better who wrote the record
+		journalLine(c, "1001", "/snap/snapd/123/usr/bin/snap", &audit.SnapRunRecord{Time: t0.Add(time.Minute), Snap: "bar", UID: 0, ExitCode: 1}) +
+		`{"MESSAGE":"not a record","_UID":"0","_EXE":"/usr/bin/snap"}` + "\n" +
+		`{"MESSAGE":[1,2,3],"_UID":"0","_EXE":"/usr/bin/snap"}` + "\n" +
+		journalLine(c, "1000", "/snap/core/456/usr/bin/snap", &audit.SnapRunRecord{Time: t0.Add(2 * time.Minute), Snap: "foo", Hook: "configure", UID: 1000})
+
+	var args []string
+	restore := s.mockJournal(c, journal, &args)
+	defer restore()
+
+	recs, err := audit.SnapRunRecords(nil)
+	c.Assert(err, IsNil)
+	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "-t", "snap-run-audit"})
+	c.Assert(recs, HasLen, 3)
+	c.Check(recs[0], DeepEquals, &audit.SnapRunRecord{Time: t0, Snap: "foo", App: "foo", UID: 1000, CmdlineHash: "1"})
+	c.Check(recs[1], DeepEquals, &audit.SnapRunRecord{Time: t0.Add(time.Minute), Snap: "bar", UID: 1001, ExitCode: 1})
+	c.Check(recs[2], DeepEquals, &audit.SnapRunRecord{Time: t0.Add(2 * time.Minute), Snap: "foo", Hook: "configure", UID: 1000})
+
+	recs, err = audit.SnapRunRecords(&audit.QueryOptions{N: 2, Since: t0})
+	c.Assert(err, IsNil)
+	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "-t", "snap-run-audit", "--since", "@1622541600", "-n", "2"})
+	c.Check(recs, HasLen, 3)
+
+	recs, err = audit.SnapRunRecords(&audit.QueryOptions{Snap: "foo", N: 1})
+	c.Assert(err, IsNil)
+	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "-t", "snap-run-audit"})
+	c.Assert(recs, HasLen, 1)
+	c.Check(recs[0].Hook, Equals, "configure")
+}
+
+func (s *snapRunSuite) TestSnapRunRecordsIgnoresOtherWriters(c *C) {
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	rec := &audit.SnapRunRecord{Time: t0, Snap: "foo", App: "foo", UID: 1000}
+	journal := journalLine(c, "1000", "/usr/bin/logger", rec) +
+		journalLine(c, "1000", "/home/user/snap", rec) +
+		journalLine(c, "1000", "/snap/evil/1/usr/bin/snap", rec) +
+		journalLine(c, "1000", "", rec) +
+		journalLine(c, "1000", "/usr/bin/snap", rec)
+
+	var args []string
+	restore := s.mockJournal(c, journal, &args)
+	defer restore()
+
+	recs, err := audit.SnapRunRecords(nil)
+	c.Assert(err, IsNil)
+	c.Check(recs, DeepEquals, []*audit.SnapRunRecord{rec})
+}
+
+func (s *snapRunSuite) TestSnapRunRecordsErrors(c *C) {
+	restore := audit.MockStreamCommand(func(name string, args ...string) (io.ReadCloser, error) {
+		return nil, errors.New("boom")
+	})
+	defer restore()
+	_, err := audit.SnapRunRecords(nil)
+	c.Check(err, ErrorMatches, "cannot read audit records: boom")
+
+	var args []string
+	restore = s.mockJournal(c, "garbage\n", &args)
+	defer restore()
+	_, err = audit.SnapRunRecords(nil)
+	c.Check(err, ErrorMatches, "cannot decode journal entry: .*")
+}
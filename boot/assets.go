@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -263,8 +264,13 @@ type TrustedAssetsInstallObserver struct {
 	trustedRecoveryAssets []string
 	trackedRecoveryAssets bootAssetsMap
 
-	dataEncryptionKey secboot.EncryptionKey
-	saveEncryptionKey secboot.EncryptionKey
+	dataEncryptionKey   secboot.EncryptionKey
+	saveEncryptionKey   secboot.EncryptionKey
+	extraEncryptionKeys []ExtraVolumeKey
+	factoryKeys         bool
+	keyProtector        string
+	tpmOptions          TPMOptions
+	dataIntegrity       bool
 }
 
 // Observe observes the operation related to the content of a given gadget
@@ -344,6 +350,41 @@ func (o *TrustedAssetsInstallObserver) ChosenEncryptionKeys(key, saveKey secboot
 	o.saveEncryptionKey = saveKey
 }
 
+// ChosenExtraEncryptionKeys records the keys of the extra encrypted volumes
+// declared by the gadget, they are sealed along with the key of ubuntu-data.
+func (o *TrustedAssetsInstallObserver) ChosenExtraEncryptionKeys(keys []ExtraVolumeKey) {
+	o.extraEncryptionKeys = keys
+}
+
+// ChosenKeyProtector records the key protector sealing the encryption keys
+// instead of the TPM. The boot chains are tracked all the same, even if the
+// protector does not bind the keys to them.
+func (o *TrustedAssetsInstallObserver) ChosenKeyProtector(name string) {
+	o.keyProtector = name
+}
+
+// ChosenTPMOptions records how the TPM is provisioned and how the keys are
+// sealed to it.
+func (o *TrustedAssetsInstallObserver) ChosenTPMOptions(opts TPMOptions) {
+	o.tpmOptions = opts
+}
+
+// ChosenDataIntegrity records that ubuntu-data is integrity protected, the
+// keys are sealed so that they can be unsealed only when the initramfs
+// requires the integrity protection.
+func (o *TrustedAssetsInstallObserver) ChosenDataIntegrity() {
+	o.dataIntegrity = true
+}
+
+// ChosenFactoryEncryptionKeys is like ChosenEncryptionKeys, but the keys are
+// stored unprotected for factory mode instead of being sealed to the TPM.
+// The trusted boot assets are still tracked so that the keys can be sealed
+// later with SealFactoryKeys.
+func (o *TrustedAssetsInstallObserver) ChosenFactoryEncryptionKeys(key, saveKey secboot.EncryptionKey) {
+	o.ChosenEncryptionKeys(key, saveKey)
+	o.factoryKeys = true
+}
+
 // TrustedAssetsUpdateObserverForModel returns a new trusted assets observer for
 // tracking changes to the trusted boot assets and preserving managed assets,
 // provided the device model indicates this might be needed. Otherwise, nil and
@@ -354,8 +395,9 @@ func TrustedAssetsUpdateObserverForModel(model *asserts.Model, gadgetDir string)
 		return nil, ErrObserverNotApplicable
 	}
 	// trusted assets need tracking only when the system is using encryption
-	// for its data partitions
-	trackTrustedAssets := hasSealedKeys(dirs.GlobalRootDir)
+	// for its data partitions, in factory mode the keys will be sealed
+	// to the boot chains eventually
+	trackTrustedAssets := hasSealedKeys(dirs.GlobalRootDir) || hasFactoryKeys(dirs.GlobalRootDir)
 
 	// see what we need to observe for the run bootloader
 	runBl, runTrusted, runManaged, err := gadgetMaybeTrustedBootloaderAndAssets(gadgetDir, InitramfsUbuntuBootDir,
@@ -847,3 +889,70 @@ func observeSuccessfulBootAssets(m *Modeenv) (newM *Modeenv, drop []*trackedAsse
 	}
 	return newM, drop, nil
 }
+
+// RestoreTrustedBootAssets restores the trusted assets of the run mode
+// bootloader on ubuntu-boot that are missing or do not match any of the
+// expected hashes, using the boot assets cache and the modeenv of the system
+// installed under rootdir. This is meant for repairing the installed system
+// from external media, so that it boots again with the assets the encryption
+// keys are sealed to. The paths of the restored assets, relative to
+// ubuntu-boot, are returned.
+func RestoreTrustedBootAssets(rootdir string) (restored []string, err error) {
+	m, err := ReadModeenv(rootdir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read modeenv of the installed system: %v", err)
+	}
+	if len(m.CurrentTrustedBootAssets) == 0 {
+		// no trusted assets are tracked
+		return nil, nil
+	}
+
+	opts := &bootloader.Options{Role: bootloader.RoleRunMode, NoSlashBoot: true}
+	bl, trustedAssets, err := findMaybeTrustedBootloaderAndAssets(InitramfsUbuntuBootDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := newTrustedAssetsCache(dirs.SnapBootAssetsDirUnder(rootdir))
+	for _, trustedAsset := range trustedAssets {
+		assetName := filepath.Base(trustedAsset)
+		hashList := m.CurrentTrustedBootAssets[assetName]
+		if len(hashList) == 0 {
+			continue
+		}
+
+		assetPath := filepath.Join(InitramfsUbuntuBootDir, trustedAsset)
+		assetHash, err := cache.fileHash(assetPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot calculate the digest of existing trusted asset: %v", err)
+		}
+		if strutil.ListContains(hashList, assetHash) {
+			// asset is one of the expected ones
+			continue
+		}
+
+		// restore the first of the expected assets found in the cache
+		var content []byte
+		for _, hash := range hashList {
+			content, err = ioutil.ReadFile(cache.pathInCache(trustedAssetCacheRelPath(bl.Name(), assetName, hash)))
+			if err == nil {
+				break
+			}
+			if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("cannot read cached trusted asset %q: %v", assetName, err)
+			}
+		}
+		if content == nil {
+			return nil, fmt.Errorf("cannot restore trusted asset %q: no expected asset found in cache", trustedAsset)
+		}
+		if err := os.MkdirAll(filepath.Dir(assetPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := osutil.AtomicWriteFile(assetPath, content, 0644, 0); err != nil {
+			return nil, fmt.Errorf("cannot restore trusted asset %q: %v", trustedAsset, err)
+		}
+		logger.Noticef("restored run mode bootloader asset %q", trustedAsset)
+		restored = append(restored, trustedAsset)
+	}
+	return restored, nil
+}
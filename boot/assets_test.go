@@ -2153,6 +2153,77 @@ func (s *assetsSuite) TestObserveSuccessfulBootWithUnexpected(c *C) {
 	c.Check(drop, HasLen, 0)
 }
 
+func (s *assetsSuite) TestRestoreTrustedBootAssets(c *C) {
+	s.bootloaderWithTrustedAssets(c, []string{"asset", "nested/shim", "other"})
+
+	data := []byte("foobar")
+	// SHA3-384
+	dataHash := "0fa8abfbdaf924ad307b74dd2ed183b9a4a398891a2f6bac8fd2db7041b77f068580f9c6c66f699b496c2da1cbcc7ed8"
+	shim := []byte("shim")
+	shimHash := "dac0063e831d4b2e7a330426720512fc50fa315042f0bb30f9d1db73e4898dcb89119cac41fdfa62137c8931a50f9d7b"
+
+	hostRoot := c.MkDir()
+	cacheDir := dirs.SnapBootAssetsDirUnder(hostRoot)
+	c.Assert(os.MkdirAll(filepath.Join(cacheDir, "trusted"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(cacheDir, "trusted", "asset-"+dataHash), data, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(cacheDir, "trusted", "shim-"+shimHash), shim, 0644), IsNil)
+
+	m := &boot.Modeenv{
+		Mode: "run",
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			// the first hash is not in the cache anymore
+			"asset": {"assethash", dataHash},
+			"shim":  {shimHash},
+		},
+	}
+	c.Assert(m.WriteTo(hostRoot), IsNil)
+
+	// asset is corrupted and shim is missing, other is not tracked
+	c.Assert(ioutil.WriteFile(filepath.Join(boot.InitramfsUbuntuBootDir, "asset"), []byte("corrupted"), 0644), IsNil)
+
+	restored, err := boot.RestoreTrustedBootAssets(hostRoot)
+	c.Assert(err, IsNil)
+	c.Check(restored, DeepEquals, []string{"asset", "nested/shim"})
+	c.Check(filepath.Join(boot.InitramfsUbuntuBootDir, "asset"), testutil.FileEquals, data)
+	c.Check(filepath.Join(boot.InitramfsUbuntuBootDir, "nested/shim"), testutil.FileEquals, shim)
+	c.Check(filepath.Join(boot.InitramfsUbuntuBootDir, "other"), testutil.FileAbsent)
+
+	// nothing to do the second time
+	restored, err = boot.RestoreTrustedBootAssets(hostRoot)
+	c.Assert(err, IsNil)
+	c.Check(restored, HasLen, 0)
+}
+
+func (s *assetsSuite) TestRestoreTrustedBootAssetsNotInCache(c *C) {
+	s.bootloaderWithTrustedAssets(c, []string{"asset"})
+
+	hostRoot := c.MkDir()
+	m := &boot.Modeenv{
+		Mode: "run",
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			"asset": {"assethash"},
+		},
+	}
+	c.Assert(m.WriteTo(hostRoot), IsNil)
+
+	restored, err := boot.RestoreTrustedBootAssets(hostRoot)
+	c.Assert(err, ErrorMatches, `cannot restore trusted asset "asset": no expected asset found in cache`)
+	c.Check(restored, HasLen, 0)
+}
+
+func (s *assetsSuite) TestRestoreTrustedBootAssetsNoneTracked(c *C) {
+	hostRoot := c.MkDir()
+	m := &boot.Modeenv{Mode: "run"}
+	c.Assert(m.WriteTo(hostRoot), IsNil)
+
+	restored, err := boot.RestoreTrustedBootAssets(hostRoot)
+	c.Assert(err, IsNil)
+	c.Check(restored, HasLen, 0)
+
+	_, err = boot.RestoreTrustedBootAssets(c.MkDir())
+	c.Assert(err, ErrorMatches, "cannot read modeenv of the installed system: .*no such file or directory")
+}
+
 func (s *assetsSuite) TestObserveSuccessfulBootSingleEntries(c *C) {
 	// call to observe successful boot
 
@@ -25,6 +25,7 @@ import (
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/snap"
 )
 
@@ -340,11 +341,15 @@ func MarkBootSuccessful(dev Device) error {
 	}
 
 	if dev.HasModeenv() {
-		b := trustedAssetsBootState(dev)
-		var err error
-		u, err = b.markSuccessful(u)
-		if err != nil {
-			return fmt.Errorf(errPrefix, err)
+		for _, b := range []successfulBootState{
+			trustedAssetsBootState(dev),
+			trustedCommandLineBootState(dev),
+		} {
+			var err error
+			u, err = b.markSuccessful(u)
+			if err != nil {
+				return fmt.Errorf(errPrefix, err)
+			}
 		}
 	}
 
@@ -391,3 +396,49 @@ func SetRecoveryBootSystemAndMode(dev Device, systemLabel, mode string) error {
 	}
 	return bl.SetBootVars(m)
 }
+
+// UpdateManagedBootConfigs updates the managed boot config of the run mode
+// bootloader if the bootloader manages its assets and the built-in assets
+// are newer. When the update changes the kernel command line, the encryption
+// keys are first resealed to both the current and the new command line, so
+// that the system boots with either should it be rebooted before the update
+// completes. Once booted with the new command line, the keys are resealed to
+// it only, see MarkBootSuccessful. Returns true when the command line changed
+// and a reboot is needed to complete the update.
+func UpdateManagedBootConfigs(dev Device) (updated bool, err error) {
+	if !dev.HasModeenv() {
+		// only UC20 devices use managed boot config
+		return false, nil
+	}
+	if !dev.RunMode() {
+		return false, fmt.Errorf("internal error: boot config can only be updated in run mode")
+	}
+
+	opts := &bootloader.Options{
+		Role:        bootloader.RoleRunMode,
+		NoSlashBoot: true,
+	}
+	tbl, err := getBootloaderManagingItsAssets(InitramfsUbuntuBootDir, opts)
+	if err != nil {
+		if err == errBootConfigNotManaged {
+			// we're not managing this bootloader's boot config
+			return false, nil
+		}
+		return false, err
+	}
+
+	model := dev.Model()
+	cmdlineChanged, err := observeCommandLineUpdate(model)
+	if err != nil {
+		return false, fmt.Errorf("cannot prepare for boot config update: %v", err)
+	}
+	if err := tbl.UpdateBootConfig(opts); err != nil {
+		if cmdlineChanged {
+			if cerr := cancelCommandLineUpdate(model); cerr != nil {
+				logger.Noticef("cannot revert command line update: %v", cerr)
+			}
+		}
+		return false, fmt.Errorf("cannot update boot config: %v", err)
+	}
+	return cmdlineChanged, nil
+}
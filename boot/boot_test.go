@@ -60,6 +60,14 @@ func (s *baseBootenvSuite) SetUpTest(c *C) {
 	s.AddCleanup(func() { dirs.SetRootDir("") })
 	restore := snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {})
 	s.AddCleanup(restore)
+	// the EFI images of the tests cannot be measured
+	s.AddCleanup(boot.MockSecbootPCRSelection(func(modelParams []*secboot.SealKeyModelParams) ([]int, error) {
+		return []int{4, 7, 12}, nil
+	}))
+	// nor do the kernel snaps of the tests exist
+	s.AddCleanup(boot.MockKernelIsUnifiedKernelImage(func(bootloader.BootFile) (bool, error) {
+		return false, nil
+	}))
 
 	s.bootdir = filepath.Join(s.rootdir, "boot")
 }
@@ -1129,7 +1137,7 @@ func (s *bootenv20Suite) TestCoreParticipant20SetNextSameKernelSnapNoReseal(c *C
 		KernelRevision: "1",
 		KernelCmdlines: []string{"snapd_recovery_mode=run"},
 	}}
-	err := boot.WriteBootChains(bootChains, filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0)
+	err := boot.WriteBootChains(bootChains, filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	// make the kernel used on next boot
@@ -1244,7 +1252,7 @@ func (s *bootenv20Suite) TestCoreParticipant20SetNextSameUnassertedKernelSnapNoR
 		KernelRevision: "",
 		KernelCmdlines: []string{"snapd_recovery_mode=run"},
 	}}
-	err := boot.WriteBootChains(bootChains, filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0)
+	err := boot.WriteBootChains(bootChains, filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	// make the kernel used on next boot
@@ -2301,12 +2309,18 @@ func (s *bootenv20Suite) TestMarkBootSuccessful20BootAssetsStableStateHappy(c *C
 		KernelCmdlines: []string{"snapd_recovery_mode=recover snapd_recovery_system=system"},
 	}}
 
-	recoveryBootChains := []boot.BootChain{bootChains[1]}
+	// the fallback object can also be unsealed in factory-reset mode
+	recoveryBootChain := bootChains[1]
+	recoveryBootChain.KernelCmdlines = []string{
+		"snapd_recovery_mode=factory-reset snapd_recovery_system=system",
+		"snapd_recovery_mode=recover snapd_recovery_system=system",
+	}
+	recoveryBootChains := []boot.BootChain{recoveryBootChain}
 
-	err := boot.WriteBootChains(boot.ToPredictableBootChains(bootChains), filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0)
+	err := boot.WriteBootChains(boot.ToPredictableBootChains(bootChains), filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 
-	err = boot.WriteBootChains(boot.ToPredictableBootChains(recoveryBootChains), filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"), 0)
+	err = boot.WriteBootChains(boot.ToPredictableBootChains(recoveryBootChains), filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	// mark successful
@@ -2464,12 +2478,18 @@ func (s *bootenv20Suite) TestMarkBootSuccessful20BootUnassertedKernelAssetsStabl
 		KernelCmdlines: []string{"snapd_recovery_mode=recover snapd_recovery_system=system"},
 	}}
 
-	recoveryBootChains := []boot.BootChain{bootChains[1]}
+	// the fallback object can also be unsealed in factory-reset mode
+	recoveryBootChain := bootChains[1]
+	recoveryBootChain.KernelCmdlines = []string{
+		"snapd_recovery_mode=factory-reset snapd_recovery_system=system",
+		"snapd_recovery_mode=recover snapd_recovery_system=system",
+	}
+	recoveryBootChains := []boot.BootChain{recoveryBootChain}
 
-	err := boot.WriteBootChains(boot.ToPredictableBootChains(bootChains), filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0)
+	err := boot.WriteBootChains(boot.ToPredictableBootChains(bootChains), filepath.Join(dirs.SnapFDEDir, "boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 
-	err = boot.WriteBootChains(boot.ToPredictableBootChains(recoveryBootChains), filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"), 0)
+	err = boot.WriteBootChains(boot.ToPredictableBootChains(recoveryBootChains), filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	// mark successful
@@ -2556,6 +2576,434 @@ func (s *bootenv20Suite) TestMarkBootSuccessful20BootAssetsUpdateUnexpectedAsset
 	})
 }
 
+func (s *bootenv20Suite) setupCommandLineUpdate(c *C, m *boot.Modeenv) (tab *bootloadertest.MockTrustedAssetsBootloader, restore func()) {
+	// checked by resealKeyToModeenv
+	s.stampSealedKeys(c, dirs.GlobalRootDir)
+
+	tab = s.bootloaderWithTrustedAssets(c, []string{"asset"})
+	tab.StaticCommandLine = "static"
+	tab.CandidateStaticCommandLine = "static candidate"
+
+	data := []byte("foobar")
+	// SHA3-384
+	dataHash := "0fa8abfbdaf924ad307b74dd2ed183b9a4a398891a2f6bac8fd2db7041b77f068580f9c6c66f699b496c2da1cbcc7ed8"
+
+	c.Assert(os.MkdirAll(filepath.Join(boot.InitramfsUbuntuBootDir), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(boot.InitramfsUbuntuSeedDir), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(boot.InitramfsUbuntuBootDir, "asset"), data, 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SnapBootAssetsDir, "trusted"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapBootAssetsDir, "trusted", "asset-"+dataHash), nil, 0644), IsNil)
+
+	tab.BootChainList = []bootloader.BootFile{
+		bootloader.NewBootFile("", "asset", bootloader.RoleRunMode),
+		bootloader.NewBootFile(filepath.Join(s.kern1.Filename()), "kernel.efi", bootloader.RoleRunMode),
+	}
+
+	m.Mode = "run"
+	m.Base = s.base1.Filename()
+	m.CurrentKernels = []string{s.kern1.Filename()}
+	m.CurrentTrustedBootAssets = boot.BootAssetsMap{
+		"asset": {dataHash},
+	}
+	restore = setupUC20Bootenv(
+		c,
+		tab.MockBootloader,
+		&bootenv20Setup{
+			modeenv:    m,
+			kern:       s.kern1,
+			kernStatus: boot.DefaultStatus,
+		},
+	)
+	return tab, restore
+}
+
+func (s *bootenv20Suite) TestUpdateManagedBootConfigsCommandLineChange(c *C) {
+	tab, r := s.setupCommandLineUpdate(c, &boot.Modeenv{})
+	defer r()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	updated, err := boot.UpdateManagedBootConfigs(coreDev)
+	c.Assert(err, IsNil)
+	c.Check(updated, Equals, true)
+	c.Check(tab.UpdateCalls, Equals, 1)
+
+	// keys were resealed to both command lines before the boot config
+	// was updated
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		{"snapd_recovery_mode=run static", "snapd_recovery_mode=run static candidate"},
+	})
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run static",
+		"snapd_recovery_mode=run static candidate",
+	})
+}
+
+func (s *bootenv20Suite) TestUpdateManagedBootConfigsNoCommandLineChange(c *C) {
+	tab, r := s.setupCommandLineUpdate(c, &boot.Modeenv{})
+	defer r()
+	tab.CandidateStaticCommandLine = tab.StaticCommandLine
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Fatalf("unexpected reseal")
+		return nil
+	})
+	defer restore()
+
+	updated, err := boot.UpdateManagedBootConfigs(coreDev)
+	c.Assert(err, IsNil)
+	c.Check(updated, Equals, false)
+	c.Check(tab.UpdateCalls, Equals, 1)
+
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, HasLen, 0)
+}
+
+func (s *bootenv20Suite) TestUpdateManagedBootConfigsErrorRevertsCommandLine(c *C) {
+	tab, r := s.setupCommandLineUpdate(c, &boot.Modeenv{})
+	defer r()
+	tab.UpdateErr = fmt.Errorf("update fail")
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	updated, err := boot.UpdateManagedBootConfigs(coreDev)
+	c.Assert(err, ErrorMatches, "cannot update boot config: update fail")
+	c.Check(updated, Equals, false)
+
+	// resealed to both, then back to the current command line only
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		{"snapd_recovery_mode=run static", "snapd_recovery_mode=run static candidate"},
+		{"snapd_recovery_mode=run static"},
+	})
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run static",
+	})
+}
+
+func (s *bootenv20Suite) TestUpdateManagedBootConfigsResealErrorKeepsModeenv(c *C) {
+	tab, r := s.setupCommandLineUpdate(c, &boot.Modeenv{})
+	defer r()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		return fmt.Errorf("reseal fail")
+	})
+	defer restore()
+
+	updated, err := boot.UpdateManagedBootConfigs(coreDev)
+	c.Assert(err, ErrorMatches, "cannot prepare for boot config update: cannot reseal the encryption key: reseal fail")
+	c.Check(updated, Equals, false)
+	// the boot config was not touched
+	c.Check(tab.UpdateCalls, Equals, 0)
+
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, HasLen, 0)
+}
+
+func (s *bootenv20Suite) setupCmdlineDropIns(c *C) (dbl *bootloadertest.MockCmdlineDropInsBootloader, restore func()) {
+	tab, restore := s.setupCommandLineUpdate(c, &boot.Modeenv{})
+	dbl = tab.WithCmdlineDropIns()
+	bootloader.Force(dbl)
+	return dbl, restore
+}
+
+func (s *bootenv20Suite) TestSetCmdlineDropInHappy(c *C) {
+	dbl, r := s.setupCmdlineDropIns(c)
+	defer r()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	rebootRequired, err := boot.SetCmdlineDropIn(coreDev, "50-debug", "debug=1")
+	c.Assert(err, IsNil)
+	c.Check(rebootRequired, Equals, true)
+	c.Check(dbl.BootVars["snapd_extra_cmdline_args"], Equals, "debug=1")
+
+	// another one is applied in the order of the names
+	rebootRequired, err = boot.SetCmdlineDropIn(coreDev, "10-gadget", "quiet  splash")
+	c.Assert(err, IsNil)
+	c.Check(rebootRequired, Equals, true)
+	c.Check(dbl.BootVars["snapd_extra_cmdline_args"], Equals, "quiet splash debug=1")
+
+	// keys were resealed to both command lines before the drop-ins were
+	// installed
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		{"snapd_recovery_mode=run static", "snapd_recovery_mode=run static debug=1"},
+		{"snapd_recovery_mode=run static debug=1", "snapd_recovery_mode=run static quiet splash debug=1"},
+	})
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run static debug=1",
+		"snapd_recovery_mode=run static quiet splash debug=1",
+	})
+
+	dropIns, err := boot.CmdlineDropIns(coreDev)
+	c.Assert(err, IsNil)
+	c.Check(dropIns, DeepEquals, []bootloader.CmdlineDropIn{
+		{Name: "10-gadget", Args: "quiet  splash"},
+		{Name: "50-debug", Args: "debug=1"},
+	})
+}
+
+func (s *bootenv20Suite) TestSetCmdlineDropInNoCommandLineChange(c *C) {
+	dbl, r := s.setupCmdlineDropIns(c)
+	defer r()
+	dbl.DropIns = []bootloader.CmdlineDropIn{{Name: "50-debug", Args: "debug=1"}}
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Fatalf("unexpected reseal")
+		return nil
+	})
+	defer restore()
+
+	rebootRequired, err := boot.SetCmdlineDropIn(coreDev, "50-debug", "debug=1")
+	c.Assert(err, IsNil)
+	c.Check(rebootRequired, Equals, false)
+	c.Check(dbl.InstallDropInCalls, DeepEquals, []string{"50-debug"})
+
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, HasLen, 0)
+}
+
+func (s *bootenv20Suite) TestRemoveCmdlineDropInHappy(c *C) {
+	dbl, r := s.setupCmdlineDropIns(c)
+	defer r()
+	dbl.DropIns = []bootloader.CmdlineDropIn{
+		{Name: "10-gadget", Args: "quiet"},
+		{Name: "50-debug", Args: "debug=1"},
+	}
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	rebootRequired, err := boot.RemoveCmdlineDropIn(coreDev, "50-debug")
+	c.Assert(err, IsNil)
+	c.Check(rebootRequired, Equals, true)
+	c.Check(dbl.RemoveDropInCalls, DeepEquals, []string{"50-debug"})
+	c.Check(dbl.BootVars["snapd_extra_cmdline_args"], Equals, "quiet")
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		// sorted when resealing
+		{"snapd_recovery_mode=run static quiet", "snapd_recovery_mode=run static quiet debug=1"},
+	})
+
+	// removing a missing drop-in changes nothing
+	rebootRequired, err = boot.RemoveCmdlineDropIn(coreDev, "50-debug")
+	c.Assert(err, IsNil)
+	c.Check(rebootRequired, Equals, false)
+	c.Check(resealCmdlines, HasLen, 1)
+}
+
+func (s *bootenv20Suite) TestSetCmdlineDropInErrorRevertsCommandLine(c *C) {
+	dbl, r := s.setupCmdlineDropIns(c)
+	defer r()
+	dbl.InstallDropInErr = fmt.Errorf("install fail")
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore := boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	rebootRequired, err := boot.SetCmdlineDropIn(coreDev, "50-debug", "debug=1")
+	c.Assert(err, ErrorMatches, "cannot update command line drop-ins: install fail")
+	c.Check(rebootRequired, Equals, false)
+
+	// resealed to both, then back to the current command line only
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		{"snapd_recovery_mode=run static", "snapd_recovery_mode=run static debug=1"},
+		{"snapd_recovery_mode=run static"},
+	})
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run static",
+	})
+}
+
+func (s *bootenv20Suite) TestSetCmdlineDropInErrors(c *C) {
+	tab, r := s.setupCommandLineUpdate(c, &boot.Modeenv{})
+	defer r()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	// the bootloader does not support drop-ins
+	_, err := boot.SetCmdlineDropIn(coreDev, "50-debug", "debug=1")
+	c.Assert(err, ErrorMatches, "kernel command line drop-ins are not supported by the bootloader")
+	c.Check(tab.SetBootVarsCalls, Equals, 0)
+	_, err = boot.CmdlineDropIns(boottest.MockDevice("some-snap"))
+	c.Assert(err, ErrorMatches, "kernel command line drop-ins are not supported by the bootloader")
+
+	dbl := tab.WithCmdlineDropIns()
+	bootloader.Force(dbl)
+	_, err = boot.SetCmdlineDropIn(coreDev, "../debug", "debug=1")
+	c.Assert(err, ErrorMatches, `invalid command line drop-in name "../debug"`)
+	_, err = boot.SetCmdlineDropIn(coreDev, "50-debug", "snapd_recovery_mode=install")
+	c.Assert(err, ErrorMatches, `invalid command line drop-in "50-debug": argument "snapd_recovery_mode" is reserved`)
+	c.Check(dbl.InstallDropInCalls, HasLen, 0)
+}
+
+func (s *bootenv20Suite) TestUpdateManagedBootConfigsNonUC20(c *C) {
+	updated, err := boot.UpdateManagedBootConfigs(boottest.MockDevice("some-snap"))
+	c.Assert(err, IsNil)
+	c.Check(updated, Equals, false)
+}
+
+func (s *bootenv20Suite) TestMarkBootSuccessful20CommandLineUpdated(c *C) {
+	_, r := s.setupCommandLineUpdate(c, &boot.Modeenv{
+		CurrentKernelCommandLines: boot.BootCommandLines{
+			"snapd_recovery_mode=run static",
+			"snapd_recovery_mode=run static candidate",
+		},
+	})
+	defer r()
+
+	// booted with the new command line
+	procCmdline := filepath.Join(c.MkDir(), "cmdline")
+	c.Assert(ioutil.WriteFile(procCmdline, []byte("snapd_recovery_mode=run static candidate\n"), 0644), IsNil)
+	restore := boot.MockProcCmdline(procCmdline)
+	defer restore()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	err := boot.MarkBootSuccessful(coreDev)
+	c.Assert(err, IsNil)
+
+	// keys are now sealed to the new command line only
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		{"snapd_recovery_mode=run static candidate"},
+	})
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run static candidate",
+	})
+}
+
+func (s *bootenv20Suite) TestMarkBootSuccessful20CommandLineFallback(c *C) {
+	_, r := s.setupCommandLineUpdate(c, &boot.Modeenv{
+		CurrentKernelCommandLines: boot.BootCommandLines{
+			"snapd_recovery_mode=run static",
+			"snapd_recovery_mode=run static candidate",
+		},
+	})
+	defer r()
+
+	// booted with the old command line, eg. the boot config update did
+	// not make it to the disk
+	procCmdline := filepath.Join(c.MkDir(), "cmdline")
+	c.Assert(ioutil.WriteFile(procCmdline, []byte("snapd_recovery_mode=run static\n"), 0644), IsNil)
+	restore := boot.MockProcCmdline(procCmdline)
+	defer restore()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	var resealCmdlines [][]string
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		resealCmdlines = append(resealCmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	err := boot.MarkBootSuccessful(coreDev)
+	c.Assert(err, IsNil)
+
+	c.Check(resealCmdlines, DeepEquals, [][]string{
+		{"snapd_recovery_mode=run static"},
+	})
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run static",
+	})
+}
+
+func (s *bootenv20Suite) TestMarkBootSuccessful20CommandLineUnexpected(c *C) {
+	m := &boot.Modeenv{
+		CurrentKernelCommandLines: boot.BootCommandLines{
+			"snapd_recovery_mode=run static",
+			"snapd_recovery_mode=run static candidate",
+		},
+	}
+	_, r := s.setupCommandLineUpdate(c, m)
+	defer r()
+
+	procCmdline := filepath.Join(c.MkDir(), "cmdline")
+	c.Assert(ioutil.WriteFile(procCmdline, []byte("snapd_recovery_mode=run unexpected"), 0644), IsNil)
+	restore := boot.MockProcCmdline(procCmdline)
+	defer restore()
+
+	coreDev := boottest.MockUC20Device("", nil)
+
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Fatalf("unexpected reseal")
+		return nil
+	})
+	defer restore()
+
+	err := boot.MarkBootSuccessful(coreDev)
+	c.Assert(err, ErrorMatches, `cannot mark boot successful: cannot mark successful boot command line: current command line content "snapd_recovery_mode=run unexpected" not matching any expected entry`)
+
+	// modeenv is unchanged
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentKernelCommandLines, DeepEquals, m.CurrentKernelCommandLines)
+}
+
 type recoveryBootenv20Suite struct {
 	baseBootenvSuite
 
@@ -30,8 +30,11 @@ import (
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/kernel"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/snap/snapfile"
 )
 
 // TODO:UC20 add a doc comment when this is stabilized
@@ -46,6 +49,16 @@ type bootChain struct {
 	// kernel is unasserted, in which case always reseal.
 	KernelRevision string   `json:"kernel-revision"`
 	KernelCmdlines []string `json:"kernel-cmdlines"`
+	// SeedVerityRootHashes are the root hashes of the dm-verity hash
+	// trees of the seed snaps of a recovery system, which are measured
+	// when booting it.
+	SeedVerityRootHashes map[string][]byte `json:"seed-verity-root-hashes,omitempty"`
+	// DataIntegrity is set when ubuntu-data is integrity protected, the
+	// requirement is then measured before unlocking it.
+	DataIntegrity bool `json:"data-integrity,omitempty"`
+	// UnifiedKernelImage is set when the kernel is a unified kernel
+	// image, whose sections are measured when booting it.
+	UnifiedKernelImage bool `json:"unified-kernel-image,omitempty"`
 
 	model          *asserts.Model
 	kernelBootFile bootloader.BootFile
@@ -246,11 +259,14 @@ func predictableBootChainsEqualForReseal(pb1, pb2 predictableBootChains) bootCha
 
 // bootAssetsToLoadChains generates a list of load chains covering given boot
 // assets sequence. At the end of each chain, adds an entry for the kernel boot
-// file.
-func bootAssetsToLoadChains(assets []bootAsset, kernelBootFile bootloader.BootFile, roleToBlName map[bootloader.Role]string) ([]*secboot.LoadChain, error) {
+// file, which is a unified kernel image if unifiedKernelImage is set.
+func bootAssetsToLoadChains(assets []bootAsset, kernelBootFile bootloader.BootFile, unifiedKernelImage bool, roleToBlName map[bootloader.Role]string) ([]*secboot.LoadChain, error) {
 	// kernel is added after all the assets
 	addKernelBootFile := len(assets) == 0
 	if addKernelBootFile {
+		if unifiedKernelImage {
+			return []*secboot.LoadChain{secboot.NewUnifiedKernelImageLoadChain(kernelBootFile)}, nil
+		}
 		return []*secboot.LoadChain{secboot.NewLoadChain(kernelBootFile)}, nil
 	}
 
@@ -276,7 +292,7 @@ func bootAssetsToLoadChains(assets []bootAsset, kernelBootFile bootloader.BootFi
 			p,
 			thisAsset.Role,
 		)
-		next, err = bootAssetsToLoadChains(assets[1:], kernelBootFile, roleToBlName)
+		next, err = bootAssetsToLoadChains(assets[1:], kernelBootFile, unifiedKernelImage, roleToBlName)
 		if err != nil {
 			return nil, err
 		}
@@ -285,31 +301,103 @@ func bootAssetsToLoadChains(assets []bootAsset, kernelBootFile bootloader.BootFi
 	return chains, nil
 }
 
+var kernelIsUnifiedKernelImage = kernelIsUnifiedKernelImageImpl
+
+// kernelIsUnifiedKernelImageImpl returns whether the metadata of the kernel
+// snap of the given kernel boot file declares its kernel as a unified kernel
+// image.
+func kernelIsUnifiedKernelImageImpl(kernelBootFile bootloader.BootFile) (bool, error) {
+	snapf, err := snapfile.Open(kernelBootFile.Snap)
+	if err != nil {
+		return false, err
+	}
+	content, err := snapf.ReadFile("meta/kernel.yaml")
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	info, err := kernel.InfoFromKernelYaml(content)
+	if err != nil {
+		return false, err
+	}
+	return info.UnifiedKernelImage, nil
+}
+
+// bootChainsFormatVersion is the version of the format of the boot chains
+// files. Files written before the format was versioned carry no version and
+// are treated as version 1, they do not describe the sealed keys.
+const bootChainsFormatVersion = 2
+
+// bootChainsPCRProfile describes the PCR policy of a key sealed to the
+// boot chains.
+type bootChainsPCRProfile struct {
+	// PCRs is the list of SHA-256 PCRs the policy is bound to.
+	PCRs []int `json:"pcrs"`
+	// PolicyCounterHandle is the handle of the NV index used for revoking
+	// the policy.
+	PolicyCounterHandle uint32 `json:"policy-counter-handle"`
+}
+
+// bootChainsVolume describes where the key of an encrypted volume sealed
+// to the boot chains is stored.
+type bootChainsVolume struct {
+	Name          string                `json:"name"`
+	SealedKeyFile string                `json:"sealed-key-file"`
+	PCRProfile    *bootChainsPCRProfile `json:"pcr-profile,omitempty"`
+}
+
 // predictableBootChainsWrapperForStorage wraps the boot chains so
 // that we do not store the arrays directly as JSON and we can add
 // other information
 type predictableBootChainsWrapperForStorage struct {
-	ResealCount int                   `json:"reseal-count"`
-	BootChains  predictableBootChains `json:"boot-chains"`
+	Version      int                   `json:"version,omitempty"`
+	ResealCount  int                   `json:"reseal-count"`
+	KeyProtector string                `json:"key-protector,omitempty"`
+	Volumes      []*bootChainsVolume   `json:"volumes,omitempty"`
+	BootChains   predictableBootChains `json:"boot-chains"`
+	// MachineOwnerKeys identifies the shim machine owner key state the
+	// keys were sealed with, if machine owner keys are in use
+	MachineOwnerKeys string `json:"machine-owner-keys,omitempty"`
+	// EFISignatureDbUpdates identifies the staged EFI signature database
+	// updates the keys were sealed with, if there are any
+	EFISignatureDbUpdates string `json:"efi-signature-db-updates,omitempty"`
 }
 
 func readBootChains(path string) (pbc predictableBootChains, resealCount int, err error) {
+	wrapped, err := readBootChainsFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return wrapped.BootChains, wrapped.ResealCount, nil
+}
+
+// readBootChainsFile reads the boot chains file at the given path, a file
+// that does not exist yields empty boot chains of the current version.
+func readBootChainsFile(path string) (*predictableBootChainsWrapperForStorage, error) {
 	inf, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, 0, nil
+			return &predictableBootChainsWrapperForStorage{Version: bootChainsFormatVersion}, nil
 		}
-		return nil, 0, fmt.Errorf("cannot open existing boot chains data file: %v", err)
+		return nil, fmt.Errorf("cannot open existing boot chains data file: %v", err)
 	}
 	defer inf.Close()
 	var wrapped predictableBootChainsWrapperForStorage
 	if err := json.NewDecoder(inf).Decode(&wrapped); err != nil {
-		return nil, 0, fmt.Errorf("cannot read boot chains data: %v", err)
+		return nil, fmt.Errorf("cannot read boot chains data: %v", err)
 	}
-	return wrapped.BootChains, wrapped.ResealCount, nil
+	switch {
+	case wrapped.Version == 0:
+		wrapped.Version = 1
+	case wrapped.Version > bootChainsFormatVersion:
+		return nil, fmt.Errorf("cannot read boot chains data: unsupported format version %d", wrapped.Version)
+	}
+	return &wrapped, nil
 }
 
-func writeBootChains(pbc predictableBootChains, path string, resealCount int) error {
+func writeBootChains(pbc predictableBootChains, path string, resealCount int, keyProtector string, volumes []*bootChainsVolume) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("cannot create device fde state directory: %v", err)
 	}
@@ -320,12 +408,41 @@ func writeBootChains(pbc predictableBootChains, path string, resealCount int) er
 	// becomes noop when the file is committed
 	defer outf.Cancel()
 
+	mokState, err := secbootMachineOwnerKeyState()
+	if err != nil {
+		return fmt.Errorf("cannot read machine owner key state: %v", err)
+	}
+	dbUpdatesState, err := efiSignatureDbUpdatesState()
+	if err != nil {
+		return fmt.Errorf("cannot read staged EFI signature database updates: %v", err)
+	}
+
 	wrapped := predictableBootChainsWrapperForStorage{
-		ResealCount: resealCount,
-		BootChains:  pbc,
+		Version:               bootChainsFormatVersion,
+		ResealCount:           resealCount,
+		KeyProtector:          keyProtector,
+		Volumes:               volumes,
+		BootChains:            pbc,
+		MachineOwnerKeys:      mokState,
+		EFISignatureDbUpdates: dbUpdatesState,
 	}
 	if err := json.NewEncoder(outf).Encode(wrapped); err != nil {
 		return fmt.Errorf("cannot write boot chains data: %v", err)
 	}
 	return outf.Commit()
 }
+
+// migrateBootChains rewrites a boot chains file of a previous version of the
+// format with the current one, recording the given key protector and volumes
+// and keeping the boot chains and the reseal count.
+func migrateBootChains(path string, keyProtector string, volumes []*bootChainsVolume) error {
+	wrapped, err := readBootChainsFile(path)
+	if err != nil {
+		return err
+	}
+	if wrapped.Version == bootChainsFormatVersion {
+		return nil
+	}
+	logger.Noticef("migrating boot chains file %q from version %d to %d", path, wrapped.Version, bootChainsFormatVersion)
+	return writeBootChains(wrapped.BootChains, path, wrapped.ResealCount, keyProtector, volumes)
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -999,7 +1000,7 @@ var nbf = bootloader.NewBootFile
 func (s *bootchainSuite) TestBootAssetsToLoadChainTrivialKernel(c *C) {
 	kbl := bootloader.NewBootFile("pc-kernel", "kernel.efi", bootloader.RoleRunMode)
 
-	chains, err := boot.BootAssetsToLoadChains(nil, kbl, nil)
+	chains, err := boot.BootAssetsToLoadChains(nil, kbl, false, nil)
 	c.Assert(err, IsNil)
 
 	c.Check(chains, DeepEquals, []*secboot.LoadChain{
@@ -1007,6 +1008,48 @@ func (s *bootchainSuite) TestBootAssetsToLoadChainTrivialKernel(c *C) {
 	})
 }
 
+func (s *bootchainSuite) TestBootAssetsToLoadChainUnifiedKernelImage(c *C) {
+	kbl := bootloader.NewBootFile("pc-kernel", "kernel.efi", bootloader.RoleRunMode)
+	assets := []boot.BootAsset{
+		{Name: "shim", Hashes: []string{"hash0"}, Role: bootloader.RoleRecovery},
+	}
+	c.Assert(os.MkdirAll(filepath.Dir(cPath("recovery-bl/shim-hash0")), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(cPath("recovery-bl/shim-hash0"), nil, 0644), IsNil)
+	blNames := map[bootloader.Role]string{
+		bootloader.RoleRecovery: "recovery-bl",
+	}
+
+	chains, err := boot.BootAssetsToLoadChains(assets, kbl, true, blNames)
+	c.Assert(err, IsNil)
+
+	c.Check(chains, DeepEquals, []*secboot.LoadChain{
+		secboot.NewLoadChain(nbf("", cPath("recovery-bl/shim-hash0"), bootloader.RoleRecovery),
+			secboot.NewUnifiedKernelImageLoadChain(nbf("pc-kernel", "kernel.efi", bootloader.RoleRunMode))),
+	})
+}
+
+func (s *bootchainSuite) TestKernelIsUnifiedKernelImage(c *C) {
+	const kernelYaml = "name: pc-kernel\nversion: 1.0\ntype: kernel\n"
+	for _, tc := range []struct {
+		kernelYaml string
+		uki        bool
+	}{
+		{"", false},
+		{"assets:\n  dtbs:\n    update: true\n", false},
+		{"unified-kernel-image: true\n", true},
+	} {
+		var files [][]string
+		if tc.kernelYaml != "" {
+			files = append(files, []string{"meta/kernel.yaml", tc.kernelYaml})
+		}
+		snapPath := snaptest.MakeTestSnapWithFiles(c, kernelYaml, files)
+
+		uki, err := boot.KernelIsUnifiedKernelImage(bootloader.NewBootFile(snapPath, "kernel.efi", bootloader.RoleRunMode))
+		c.Check(err, IsNil)
+		c.Check(uki, Equals, tc.uki, Commentf("%q", tc.kernelYaml))
+	}
+}
+
 func (s *bootchainSuite) TestBootAssetsToLoadChainErr(c *C) {
 	kbl := bootloader.NewBootFile("pc-kernel", "kernel.efi", bootloader.RoleRunMode)
 
@@ -1021,7 +1064,7 @@ func (s *bootchainSuite) TestBootAssetsToLoadChainErr(c *C) {
 		// missing bootloader name for role "run-mode"
 	}
 	// fails when probing the shim asset in the cache
-	chains, err := boot.BootAssetsToLoadChains(assets, kbl, blNames)
+	chains, err := boot.BootAssetsToLoadChains(assets, kbl, false, blNames)
 	c.Assert(err, ErrorMatches, "file .*/recovery-bl/shim-hash0 not found in boot assets cache")
 	c.Check(chains, IsNil)
 	// make it work now
@@ -1029,7 +1072,7 @@ func (s *bootchainSuite) TestBootAssetsToLoadChainErr(c *C) {
 	c.Assert(ioutil.WriteFile(cPath("recovery-bl/shim-hash0"), nil, 0644), IsNil)
 
 	// nested error bubbled up
-	chains, err = boot.BootAssetsToLoadChains(assets, kbl, blNames)
+	chains, err = boot.BootAssetsToLoadChains(assets, kbl, false, blNames)
 	c.Assert(err, ErrorMatches, "file .*/recovery-bl/loader-recovery-hash0 not found in boot assets cache")
 	c.Check(chains, IsNil)
 	// again, make it work
@@ -1037,7 +1080,7 @@ func (s *bootchainSuite) TestBootAssetsToLoadChainErr(c *C) {
 	c.Assert(ioutil.WriteFile(cPath("recovery-bl/loader-recovery-hash0"), nil, 0644), IsNil)
 
 	// fails on missing bootloader name for role "run-mode"
-	chains, err = boot.BootAssetsToLoadChains(assets, kbl, blNames)
+	chains, err = boot.BootAssetsToLoadChains(assets, kbl, false, blNames)
 	c.Assert(err, ErrorMatches, `internal error: no bootloader name for boot asset role "run-mode"`)
 	c.Check(chains, IsNil)
 }
@@ -1067,7 +1110,7 @@ func (s *bootchainSuite) TestBootAssetsToLoadChainSimpleChain(c *C) {
 		bootloader.RoleRunMode:  "run-bl",
 	}
 
-	chains, err := boot.BootAssetsToLoadChains(assets, kbl, blNames)
+	chains, err := boot.BootAssetsToLoadChains(assets, kbl, false, blNames)
 	c.Assert(err, IsNil)
 
 	c.Logf("got:")
@@ -1110,7 +1153,7 @@ func (s *bootchainSuite) TestBootAssetsToLoadChainWithAlternativeChains(c *C) {
 		bootloader.RoleRecovery: "recovery-bl",
 		bootloader.RoleRunMode:  "run-bl",
 	}
-	chains, err := boot.BootAssetsToLoadChains(assets, kbl, blNames)
+	chains, err := boot.BootAssetsToLoadChains(assets, kbl, false, blNames)
 	c.Assert(err, IsNil)
 
 	c.Logf("got:")
@@ -1185,10 +1228,10 @@ func (s *sealSuite) TestReadWriteBootChains(c *C) {
 
 	rootdir := c.MkDir()
 
-	expected := `{"reseal-count":0,"boot-chains":[{"brand-id":"mybrand","model":"foo","grade":"dangerous","model-sign-key-id":"my-key-id","asset-chain":[{"role":"recovery","name":"shim","hashes":["x","y"]},{"role":"recovery","name":"loader","hashes":["c","d"]}],"kernel":"pc-kernel-recovery","kernel-revision":"1234","kernel-cmdlines":["snapd_recovery_mode=recover foo"]},{"brand-id":"mybrand","model":"foo","grade":"signed","model-sign-key-id":"my-key-id","asset-chain":[{"role":"recovery","name":"shim","hashes":["x","y"]},{"role":"recovery","name":"loader","hashes":["c","d"]},{"role":"run-mode","name":"loader","hashes":["x","z"]}],"kernel":"pc-kernel-other","kernel-revision":"2345","kernel-cmdlines":["snapd_recovery_mode=run foo"]}]}
+	expected := `{"version":2,"reseal-count":0,"key-protector":"tpm2","boot-chains":[{"brand-id":"mybrand","model":"foo","grade":"dangerous","model-sign-key-id":"my-key-id","asset-chain":[{"role":"recovery","name":"shim","hashes":["x","y"]},{"role":"recovery","name":"loader","hashes":["c","d"]}],"kernel":"pc-kernel-recovery","kernel-revision":"1234","kernel-cmdlines":["snapd_recovery_mode=recover foo"]},{"brand-id":"mybrand","model":"foo","grade":"signed","model-sign-key-id":"my-key-id","asset-chain":[{"role":"recovery","name":"shim","hashes":["x","y"]},{"role":"recovery","name":"loader","hashes":["c","d"]},{"role":"run-mode","name":"loader","hashes":["x","z"]}],"kernel":"pc-kernel-other","kernel-revision":"2345","kernel-cmdlines":["snapd_recovery_mode=run foo"]}]}
 `
 	// creates a complete tree and writes a file
-	err := boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), 0)
+	err := boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, IsNil)
 	c.Check(filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), testutil.FileEquals, expected)
 
@@ -1204,7 +1247,7 @@ func (s *sealSuite) TestReadWriteBootChains(c *C) {
 	c.Check(boot.PredictableBootChainsEqualForReseal(pbc, loaded), Equals, boot.BootChainEquivalent)
 
 	// write them again with count > 0
-	err = boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), 99)
+	err = boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), 99, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	_, cnt, err = boot.ReadBootChains(filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"))
@@ -1217,7 +1260,7 @@ func (s *sealSuite) TestReadWriteBootChains(c *C) {
 	c.Assert(os.Chmod(dirs.SnapFDEDirUnder(otherRootdir), 0000), IsNil)
 	defer os.Chmod(dirs.SnapFDEDirUnder(otherRootdir), 0755)
 
-	err = boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(otherRootdir), "boot-chains"), 0)
+	err = boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(otherRootdir), "boot-chains"), 0, "tpm2", nil)
 	c.Assert(err, ErrorMatches, `cannot create a temporary boot chains file: open .*/boot-chains\.[a-zA-Z0-9]+~: permission denied`)
 
 	// make the original file non readable
@@ -1234,3 +1277,95 @@ func (s *sealSuite) TestReadWriteBootChains(c *C) {
 	c.Check(loaded, IsNil)
 	c.Check(cnt, Equals, 0)
 }
+
+func (s *sealSuite) TestReadWriteBootChainsWithVolumes(c *C) {
+	pbc := boot.ToPredictableBootChains([]boot.BootChain{
+		{
+			BrandID:        "mybrand",
+			Model:          "foo",
+			Grade:          "signed",
+			ModelSignKeyID: "my-key-id",
+			AssetChain: []boot.BootAsset{
+				{Role: bootloader.RoleRecovery, Name: "shim", Hashes: []string{"x"}},
+			},
+			Kernel:         "pc-kernel",
+			KernelRevision: "1",
+			KernelCmdlines: []string{`snapd_recovery_mode=run`},
+		},
+	})
+	volumes := []*boot.BootChainsVolume{
+		{
+			Name:          "ubuntu-data",
+			SealedKeyFile: "/run/mnt/ubuntu-boot/device/fde/ubuntu-data.sealed-key",
+			PCRProfile: &boot.BootChainsPCRProfile{
+				PCRs:                []int{4, 7, 12},
+				PolicyCounterHandle: 0x01880001,
+			},
+		},
+	}
+	path := filepath.Join(dirs.SnapFDEDirUnder(c.MkDir()), "boot-chains")
+	err := boot.WriteBootChains(pbc, path, 3, "tpm2", volumes)
+	c.Assert(err, IsNil)
+	c.Check(path, testutil.FileContains, `"version":2,"reseal-count":3,"key-protector":"tpm2","volumes":[{"name":"ubuntu-data","sealed-key-file":"/run/mnt/ubuntu-boot/device/fde/ubuntu-data.sealed-key","pcr-profile":{"pcrs":[4,7,12],"policy-counter-handle":25690113}}]`)
+
+	wrapped, err := boot.ReadBootChainsFile(path)
+	c.Assert(err, IsNil)
+	c.Check(wrapped.Version, Equals, 2)
+	c.Check(wrapped.ResealCount, Equals, 3)
+	c.Check(wrapped.KeyProtector, Equals, "tpm2")
+	c.Check(wrapped.Volumes, DeepEquals, volumes)
+	c.Check(wrapped.BootChains, DeepEquals, pbc)
+
+	// a file that does not exist is of the current version
+	wrapped, err = boot.ReadBootChainsFile("does-not-exist")
+	c.Assert(err, IsNil)
+	c.Check(wrapped.Version, Equals, 2)
+	c.Check(wrapped.BootChains, IsNil)
+
+	// files of a future version are not understood
+	err = ioutil.WriteFile(path, []byte(`{"version":3,"reseal-count":3,"boot-chains":[]}`), 0600)
+	c.Assert(err, IsNil)
+	_, err = boot.ReadBootChainsFile(path)
+	c.Assert(err, ErrorMatches, "cannot read boot chains data: unsupported format version 3")
+}
+
+func (s *sealSuite) TestMigrateBootChainsFromV1(c *C) {
+	path := filepath.Join(dirs.SnapFDEDirUnder(c.MkDir()), "boot-chains")
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	v1 := `{"reseal-count":5,"boot-chains":[{"brand-id":"mybrand","model":"foo","grade":"signed","model-sign-key-id":"my-key-id","asset-chain":[{"role":"recovery","name":"shim","hashes":["x"]}],"kernel":"pc-kernel","kernel-revision":"1","kernel-cmdlines":["snapd_recovery_mode=run"]}]}`
+	err := ioutil.WriteFile(path, []byte(v1), 0600)
+	c.Assert(err, IsNil)
+
+	wrapped, err := boot.ReadBootChainsFile(path)
+	c.Assert(err, IsNil)
+	c.Check(wrapped.Version, Equals, 1)
+	c.Check(wrapped.KeyProtector, Equals, "")
+	c.Check(wrapped.Volumes, IsNil)
+	pbc := wrapped.BootChains
+	c.Assert(pbc, HasLen, 1)
+
+	volumes := []*boot.BootChainsVolume{
+		{Name: "ubuntu-data", SealedKeyFile: "/run/mnt/ubuntu-boot/device/fde/ubuntu-data.sealed-key"},
+	}
+	err = boot.MigrateBootChains(path, "tpm2", volumes)
+	c.Assert(err, IsNil)
+
+	wrapped, err = boot.ReadBootChainsFile(path)
+	c.Assert(err, IsNil)
+	c.Check(wrapped.Version, Equals, 2)
+	c.Check(wrapped.ResealCount, Equals, 5)
+	c.Check(wrapped.KeyProtector, Equals, "tpm2")
+	c.Check(wrapped.Volumes, DeepEquals, volumes)
+	c.Check(wrapped.BootChains, DeepEquals, pbc)
+
+	// already migrated files are left alone
+	err = boot.MigrateBootChains(path, "tpm2", nil)
+	c.Assert(err, IsNil)
+	wrapped, err = boot.ReadBootChainsFile(path)
+	c.Assert(err, IsNil)
+	c.Check(wrapped.Volumes, DeepEquals, volumes)
+
+	// as are files that do not exist
+	err = boot.MigrateBootChains(filepath.Join(c.MkDir(), "boot-chains"), "tpm2", volumes)
+	c.Assert(err, IsNil)
+}
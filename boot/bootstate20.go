@@ -99,6 +99,9 @@ type bootStateUpdate20 struct {
 
 	// model set if a reseal might be necessary
 	resealModel *asserts.Model
+	// resealAfterBoot is set when the reseal only drops the boot chains
+	// that are not used anymore after a successful boot
+	resealAfterBoot bool
 }
 
 func (u20 *bootStateUpdate20) preModeenv(task bootCommitTask) {
@@ -111,6 +114,17 @@ func (u20 *bootStateUpdate20) postModeenv(task bootCommitTask) {
 
 func (u20 *bootStateUpdate20) resealForModel(model *asserts.Model) {
 	u20.resealModel = model
+	u20.resealAfterBoot = false
+}
+
+// resealAfterBootForModel is like resealForModel for a reseal which only
+// drops boot chains after a successful boot, unless a reseal for new boot
+// chains is also needed.
+func (u20 *bootStateUpdate20) resealAfterBootForModel(model *asserts.Model) {
+	if u20.resealModel == nil {
+		u20.resealAfterBoot = true
+	}
+	u20.resealModel = model
 }
 
 func newBootStateUpdate20(m *Modeenv) (*bootStateUpdate20, error) {
@@ -172,7 +186,13 @@ func (u20 *bootStateUpdate20) commit() error {
 		// flag as hint whether to reseal based on whether we
 		// wrote the modeenv
 		expectReseal := modeenvRewritten
-		if err := resealKeyToModeenv(dirs.GlobalRootDir, u20.resealModel, u20.writeModeenv, expectReseal); err != nil {
+		reseal := resealKeyToModeenv
+		if u20.resealAfterBoot {
+			// the keys remain sealed to a superset of the
+			// boot chains until then, which is safe
+			reseal = resealKeyToModeenvAfterBoot
+		}
+		if err := reseal(dirs.GlobalRootDir, u20.resealModel, u20.writeModeenv, expectReseal); err != nil {
 			return err
 		}
 	}
@@ -292,7 +312,7 @@ func (ks20 *bootState20Kernel) markSuccessful(update bootStateUpdate) (bootState
 		u20.writeModeenv.CurrentKernels = []string{sn.Filename()}
 
 		// keep track of the model for resealing
-		u20.resealForModel(ks20.dev.Model())
+		u20.resealAfterBootForModel(ks20.dev.Model())
 	}
 
 	return u20, nil
@@ -693,7 +713,7 @@ func (ba20 *bootState20BootAssets) markSuccessful(update bootStateUpdate) (bootS
 	// update modeenv
 	u20.writeModeenv = newM
 	// keep track of the model for resealing
-	u20.resealForModel(ba20.dev.Model())
+	u20.resealAfterBootForModel(ba20.dev.Model())
 
 	if len(dropAssets) == 0 {
 		// nothing to drop, we're done
@@ -720,3 +740,36 @@ func trustedAssetsBootState(dev Device) *bootState20BootAssets {
 		dev: dev,
 	}
 }
+
+// bootState20CommandLine implements the successfulBootState interface for the
+// kernel command line on UC20.
+type bootState20CommandLine struct {
+	dev Device
+}
+
+func (bcl *bootState20CommandLine) markSuccessful(update bootStateUpdate) (bootStateUpdate, error) {
+	u20, err := toBootStateUpdate20(update)
+	if err != nil {
+		return nil, err
+	}
+
+	newM, err := observeSuccessfulCommandLine(u20.writeModeenv)
+	if err != nil {
+		return nil, fmt.Errorf("cannot mark successful boot command line: %v", err)
+	}
+	if newM == u20.writeModeenv {
+		// nothing changed
+		return u20, nil
+	}
+	// update modeenv
+	u20.writeModeenv = newM
+	// keep track of the model for resealing
+	u20.resealAfterBootForModel(bcl.dev.Model())
+	return u20, nil
+}
+
+func trustedCommandLineBootState(dev Device) *bootState20CommandLine {
+	return &bootState20CommandLine{
+		dev: dev,
+	}
+}
@@ -25,10 +25,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/strutil"
 )
@@ -42,13 +44,19 @@ const (
 	// ModeRecover is a mode in which the device boots into the recovery
 	// system.
 	ModeRecover = "recover"
+	// ModeRepair is a mode in which the device boots from external recovery
+	// media in order to repair the system installed on the internal disk.
+	ModeRepair = "repair"
+	// ModeFactoryReset is a mode in which the system is installed again
+	// on the device, while the content of ubuntu-save is preserved.
+	ModeFactoryReset = "factory-reset"
 )
 
 var (
 	// the kernel commandline - can be overridden in tests
 	procCmdline = "/proc/cmdline"
 
-	validModes = []string{ModeInstall, ModeRecover, ModeRun}
+	validModes = []string{ModeInstall, ModeRecover, ModeRun, ModeRepair, ModeFactoryReset}
 )
 
 func whichModeAndRecoverySystem(cmdline []byte) (mode string, sysLabel string, err error) {
@@ -84,6 +92,10 @@ func whichModeAndRecoverySystem(cmdline []byte) (mode string, sysLabel string, e
 		return "", "", fmt.Errorf("cannot detect mode nor recovery system to use")
 	case mode == ModeInstall && sysLabel == "":
 		return "", "", fmt.Errorf("cannot specify install mode without system label")
+	case mode == ModeRepair && sysLabel == "":
+		return "", "", fmt.Errorf("cannot specify repair mode without system label")
+	case mode == ModeFactoryReset && sysLabel == "":
+		return "", "", fmt.Errorf("cannot specify factory-reset mode without system label")
 	case mode == ModeRun && sysLabel != "":
 		// XXX: should we silently ignore the label? at least log for now
 		logger.Noticef(`ignoring recovery system label %q in "run" mode`, sysLabel)
@@ -133,10 +145,17 @@ const (
 )
 
 func composeCommandLine(model *asserts.Model, currentOrCandidate int, mode, system string) (string, error) {
+	return composeCommandLineWithDropIns(model, currentOrCandidate, mode, system, nil)
+}
+
+// composeCommandLineWithDropIns composes the kernel command line like
+// composeCommandLine, if not nil editDropIns is applied to the command line
+// drop-ins installed in the run mode bootloader before they are used.
+func composeCommandLineWithDropIns(model *asserts.Model, currentOrCandidate int, mode, system string, editDropIns func([]bootloader.CmdlineDropIn) []bootloader.CmdlineDropIn) (string, error) {
 	if model.Grade() == asserts.ModelGradeUnset {
 		return "", nil
 	}
-	if mode != ModeRun && mode != ModeRecover {
+	if mode != ModeRun && mode != ModeRecover && mode != ModeFactoryReset {
 		return "", fmt.Errorf("internal error: unsupported command line mode %q", mode)
 	}
 	// get the run mode bootloader under the native run partition layout
@@ -147,12 +166,12 @@ func composeCommandLine(model *asserts.Model, currentOrCandidate int, mode, syst
 	bootloaderRootDir := InitramfsUbuntuBootDir
 	modeArg := "snapd_recovery_mode=run"
 	systemArg := ""
-	if mode == ModeRecover {
+	if mode == ModeRecover || mode == ModeFactoryReset {
 		// dealing with recovery system bootloader
 		opts.Role = bootloader.RoleRecovery
 		bootloaderRootDir = InitramfsUbuntuSeedDir
 		// recovery mode & system command line arguments
-		modeArg = "snapd_recovery_mode=recover"
+		modeArg = fmt.Sprintf("snapd_recovery_mode=%v", mode)
 		systemArg = fmt.Sprintf("snapd_recovery_system=%v", system)
 	}
 	mbl, err := getBootloaderManagingItsAssets(bootloaderRootDir, opts)
@@ -164,6 +183,16 @@ func composeCommandLine(model *asserts.Model, currentOrCandidate int, mode, syst
 	}
 	// TODO:UC20: fetch extra args from gadget
 	extraArgs := ""
+	if dbl, ok := mbl.(bootloader.CmdlineDropInsBootloader); ok && mode == ModeRun {
+		dropIns, err := dbl.CmdlineDropIns()
+		if err != nil {
+			return "", err
+		}
+		if editDropIns != nil {
+			dropIns = editDropIns(dropIns)
+		}
+		extraArgs = bootloader.ComposeCmdlineDropIns(dropIns)
+	}
 	if currentOrCandidate == currentEdition {
 		return mbl.CommandLine(modeArg, systemArg, extraArgs)
 	} else {
@@ -191,3 +220,217 @@ func ComposeCommandLine(model *asserts.Model) (string, error) {
 func ComposeCandidateCommandLine(model *asserts.Model) (string, error) {
 	return composeCommandLine(model, candidateEdition, ModeRun, "")
 }
+
+// observeCommandLineUpdate observes a pending kernel command line change
+// caused by an update of the managed boot config. When the candidate command
+// line differs from the current one, the modeenv is updated to track both and
+// the keys are resealed so that they can be unsealed with either of them. This
+// is the first phase of the command line transition, it must complete before
+// the boot config is updated, as the system may be rebooted unexpectedly at
+// any point after that. Returns true if the command line changes.
+func observeCommandLineUpdate(model *asserts.Model) (changed bool, err error) {
+	m, err := loadModeenv()
+	if err != nil {
+		return false, err
+	}
+	cmdline, err := ComposeCommandLine(model)
+	if err != nil {
+		return false, fmt.Errorf("cannot compose the run mode command line: %v", err)
+	}
+	candidate, err := ComposeCandidateCommandLine(model)
+	if err != nil {
+		return false, fmt.Errorf("cannot compose the candidate command line: %v", err)
+	}
+	if cmdline == candidate {
+		return false, nil
+	}
+	if err := observeCommandLineTransition(model, m, cmdline, candidate); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// observeCommandLineTransition updates the modeenv to track both the current
+// and the candidate command line and reseals the keys accordingly.
+func observeCommandLineTransition(model *asserts.Model, m *Modeenv, cmdline, candidate string) error {
+	newM, err := m.Copy()
+	if err != nil {
+		return err
+	}
+	newM.CurrentKernelCommandLines = bootCommandLines{cmdline, candidate}
+	if err := newM.Write(); err != nil {
+		return err
+	}
+	const expectReseal = true
+	if err := resealKeyToModeenv(dirs.GlobalRootDir, model, newM, expectReseal); err != nil {
+		// the keys are still sealed to the current command line
+		if werr := m.Write(); werr != nil {
+			logger.Noticef("cannot restore modeenv: %v", werr)
+		}
+		return err
+	}
+	return nil
+}
+
+// cancelCommandLineUpdate reverts the effects of observeCommandLineUpdate when
+// the boot config could not be updated, the keys are resealed to the current
+// command line only.
+func cancelCommandLineUpdate(model *asserts.Model) error {
+	m, err := loadModeenv()
+	if err != nil {
+		return err
+	}
+	cmdline, err := ComposeCommandLine(model)
+	if err != nil {
+		return fmt.Errorf("cannot compose the run mode command line: %v", err)
+	}
+	m.CurrentKernelCommandLines = bootCommandLines{cmdline}
+	if err := m.Write(); err != nil {
+		return err
+	}
+	const expectReseal = true
+	return resealKeyToModeenv(dirs.GlobalRootDir, model, m, expectReseal)
+}
+
+// observeSuccessfulCommandLine observes a successful boot with a command line
+// and returns an updated modeenv tracking only the command line the system
+// was booted with. This is the second phase of the command line transition,
+// after which the keys can be resealed to the new command line only. The
+// returned modeenv is the same as the one passed if there was no transition in
+// progress.
+func observeSuccessfulCommandLine(m *Modeenv) (*Modeenv, error) {
+	if len(m.CurrentKernelCommandLines) < 2 {
+		// no transition in progress
+		return m, nil
+	}
+	content, err := ioutil.ReadFile(procCmdline)
+	if err != nil {
+		return nil, err
+	}
+	current := strings.TrimSpace(string(content))
+	if !strutil.ListContains(m.CurrentKernelCommandLines, current) {
+		return nil, fmt.Errorf("current command line content %q not matching any expected entry", current)
+	}
+	newM, err := m.Copy()
+	if err != nil {
+		return nil, err
+	}
+	newM.CurrentKernelCommandLines = bootCommandLines{current}
+	return newM, nil
+}
+
+var errCmdlineDropInsNotSupported = errors.New("kernel command line drop-ins are not supported by the bootloader")
+
+func runModeCmdlineDropInsBootloader(dev Device) (bootloader.CmdlineDropInsBootloader, error) {
+	if !dev.HasModeenv() || !dev.RunMode() {
+		return nil, errCmdlineDropInsNotSupported
+	}
+	opts := &bootloader.Options{
+		Role:        bootloader.RoleRunMode,
+		NoSlashBoot: true,
+	}
+	tbl, err := getBootloaderManagingItsAssets(InitramfsUbuntuBootDir, opts)
+	if err != nil {
+		if err == errBootConfigNotManaged {
+			return nil, errCmdlineDropInsNotSupported
+		}
+		return nil, err
+	}
+	dbl, ok := tbl.(bootloader.CmdlineDropInsBootloader)
+	if !ok {
+		return nil, errCmdlineDropInsNotSupported
+	}
+	return dbl, nil
+}
+
+// CmdlineDropIns returns the kernel command line drop-ins of the run mode
+// bootloader sorted by name.
+func CmdlineDropIns(dev Device) ([]bootloader.CmdlineDropIn, error) {
+	dbl, err := runModeCmdlineDropInsBootloader(dev)
+	if err != nil {
+		return nil, err
+	}
+	return dbl.CmdlineDropIns()
+}
+
+// SetCmdlineDropIn installs or replaces the named fragment of extra kernel
+// command line arguments used when booting in run mode. The drop-ins are
+// applied in the order of their names. When the command line changes, the
+// encryption keys are first resealed to both the current and the new command
+// line, and to the new one only once the system booted with it, see
+// MarkBootSuccessful. Returns true when the command line changed and a reboot
+// is needed for the change to take effect.
+func SetCmdlineDropIn(dev Device, name, args string) (rebootRequired bool, err error) {
+	if err := bootloader.ValidateCmdlineDropIn(name, args); err != nil {
+		return false, err
+	}
+	edit := func(dropIns []bootloader.CmdlineDropIn) []bootloader.CmdlineDropIn {
+		return append(withoutCmdlineDropIn(dropIns, name), bootloader.CmdlineDropIn{Name: name, Args: args})
+	}
+	return updateCmdlineDropIns(dev, edit, func(dbl bootloader.CmdlineDropInsBootloader) error {
+		return dbl.InstallCmdlineDropIn(name, args)
+	})
+}
+
+// RemoveCmdlineDropIn removes the named fragment of extra kernel command line
+// arguments used when booting in run mode. The keys are resealed like in
+// SetCmdlineDropIn. Returns true when the command line changed and a reboot is
+// needed for the change to take effect.
+func RemoveCmdlineDropIn(dev Device, name string) (rebootRequired bool, err error) {
+	edit := func(dropIns []bootloader.CmdlineDropIn) []bootloader.CmdlineDropIn {
+		return withoutCmdlineDropIn(dropIns, name)
+	}
+	return updateCmdlineDropIns(dev, edit, func(dbl bootloader.CmdlineDropInsBootloader) error {
+		return dbl.RemoveCmdlineDropIn(name)
+	})
+}
+
+func withoutCmdlineDropIn(dropIns []bootloader.CmdlineDropIn, name string) []bootloader.CmdlineDropIn {
+	var res []bootloader.CmdlineDropIn
+	for _, dropIn := range dropIns {
+		if dropIn.Name != name {
+			res = append(res, dropIn)
+		}
+	}
+	return res
+}
+
+func updateCmdlineDropIns(dev Device, edit func([]bootloader.CmdlineDropIn) []bootloader.CmdlineDropIn, apply func(bootloader.CmdlineDropInsBootloader) error) (rebootRequired bool, err error) {
+	dbl, err := runModeCmdlineDropInsBootloader(dev)
+	if err != nil {
+		return false, err
+	}
+	model := dev.Model()
+	m, err := loadModeenv()
+	if err != nil {
+		return false, err
+	}
+	cmdline, err := ComposeCommandLine(model)
+	if err != nil {
+		return false, fmt.Errorf("cannot compose the run mode command line: %v", err)
+	}
+	sortedEdit := func(dropIns []bootloader.CmdlineDropIn) []bootloader.CmdlineDropIn {
+		dropIns = edit(dropIns)
+		sort.Slice(dropIns, func(i, j int) bool { return dropIns[i].Name < dropIns[j].Name })
+		return dropIns
+	}
+	candidate, err := composeCommandLineWithDropIns(model, currentEdition, ModeRun, "", sortedEdit)
+	if err != nil {
+		return false, fmt.Errorf("cannot compose the candidate command line: %v", err)
+	}
+	changed := cmdline != candidate
+	if changed {
+		if err := observeCommandLineTransition(model, m, cmdline, candidate); err != nil {
+			return false, fmt.Errorf("cannot prepare for command line update: %v", err)
+		}
+	}
+	if err := apply(dbl); err != nil {
+		if changed {
+			if cerr := cancelCommandLineUpdate(model); cerr != nil {
+				logger.Noticef("cannot revert command line update: %v", cerr)
+			}
+		}
+		return false, fmt.Errorf("cannot update command line drop-ins: %v", err)
+	}
+	return changed, nil
+}
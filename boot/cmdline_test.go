@@ -84,6 +84,20 @@ func (s *kernelCommandLineSuite) TestModeAndLabel(c *C) {
 		// no recovery system label
 		cmd: "snapd_recovery_mode=install foo=bar",
 		err: `cannot specify install mode without system label`,
+	}, {
+		cmd:   "snapd_recovery_mode=repair snapd_recovery_system=20200314",
+		mode:  boot.ModeRepair,
+		label: "20200314",
+	}, {
+		cmd: "snapd_recovery_mode=repair",
+		err: `cannot specify repair mode without system label`,
+	}, {
+		cmd:   "snapd_recovery_mode=factory-reset snapd_recovery_system=20200314",
+		mode:  boot.ModeFactoryReset,
+		label: "20200314",
+	}, {
+		cmd: "snapd_recovery_mode=factory-reset",
+		err: `cannot specify factory-reset mode without system label`,
 	}, {
 		// boot scripts couldn't decide on mode
 		cmd: "snapd_recovery_mode=install snapd_recovery_system=1234 snapd_recovery_mode=run",
@@ -192,3 +206,29 @@ func (s *kernelCommandLineSuite) TestComposeCandidateCommandLineManagedHappy(c *
 	c.Assert(err, IsNil)
 	c.Assert(cmdline, Equals, "snapd_recovery_mode=run candidate panic=-1")
 }
+
+func (s *kernelCommandLineSuite) TestComposeCommandLineWithDropIns(c *C) {
+	model := boottest.MakeMockUC20Model()
+
+	dbl := bootloadertest.Mock("btloader", c.MkDir()).WithTrustedAssets().WithCmdlineDropIns()
+	bootloader.Force(dbl)
+	defer bootloader.Force(nil)
+
+	dbl.StaticCommandLine = "panic=-1"
+	dbl.CandidateStaticCommandLine = "candidate panic=-1"
+	dbl.DropIns = []bootloader.CmdlineDropIn{
+		{Name: "10-gadget", Args: "quiet"},
+		{Name: "50-debug", Args: "debug=1"},
+	}
+
+	cmdline, err := boot.ComposeCommandLine(model)
+	c.Assert(err, IsNil)
+	c.Assert(cmdline, Equals, "snapd_recovery_mode=run panic=-1 quiet debug=1")
+	cmdline, err = boot.ComposeCandidateCommandLine(model)
+	c.Assert(err, IsNil)
+	c.Assert(cmdline, Equals, "snapd_recovery_mode=run candidate panic=-1 quiet debug=1")
+	// drop-ins only apply to run mode
+	cmdline, err = boot.ComposeRecoveryCommandLine(model, "20200314")
+	c.Assert(err, IsNil)
+	c.Assert(cmdline, Equals, "snapd_recovery_mode=recover snapd_recovery_system=20200314 panic=-1")
+}
@@ -62,13 +62,22 @@ var (
 
 	ObserveSuccessfulBootWithAssets = observeSuccessfulBootAssets
 	SealKeyToModeenv                = sealKeyToModeenv
+	StoreFactoryKeys                = storeFactoryKeys
 	ResealKeyToModeenv              = resealKeyToModeenv
+	ResealKeyToModeenvAfterBoot     = resealKeyToModeenvAfterBoot
 	RecoveryBootChainsForSystems    = recoveryBootChainsForSystems
 	SealKeyModelParams              = sealKeyModelParams
+	RecordSeedVerityRootHashes      = recordSeedVerityRootHashes
+	ReadSeedVerityRootHashes        = readSeedVerityRootHashes
+	WithSealedKeyFilesAside         = withSealedKeyFilesAside
+	RecordDataIntegrity             = recordDataIntegrity
+	WithDataIntegrity               = withDataIntegrity
 )
 
 type BootAssetsMap = bootAssetsMap
+type BootCommandLines = bootCommandLines
 type TrackedAsset = trackedAsset
+type SealKeyToModeenvFlags = sealKeyToModeenvFlags
 
 func (t *TrackedAsset) Equals(blName, name, hash string) error {
 	equal := t.hash == hash &&
@@ -104,6 +113,20 @@ func MockSecbootSealKeys(f func(keys []secboot.SealKeyRequest, params *secboot.S
 	}
 }
 
+func MockSecbootFactoryKeyRotation(newKey func() (secboot.EncryptionKey, error), addKey func(node string, key []byte, newKey secboot.EncryptionKey) error, removeKey func(node string, key []byte) error) (restore func()) {
+	oldNewKey := secbootNewEncryptionKey
+	oldAddKey := secbootAddEncryptionKey
+	oldRemoveKey := secbootRemoveEncryptionKey
+	secbootNewEncryptionKey = newKey
+	secbootAddEncryptionKey = addKey
+	secbootRemoveEncryptionKey = removeKey
+	return func() {
+		secbootNewEncryptionKey = oldNewKey
+		secbootAddEncryptionKey = oldAddKey
+		secbootRemoveEncryptionKey = oldRemoveKey
+	}
+}
+
 func MockSecbootResealKeys(f func(params *secboot.ResealKeysParams) error) (restore func()) {
 	old := secbootResealKeys
 	secbootResealKeys = f
@@ -112,6 +135,67 @@ func MockSecbootResealKeys(f func(params *secboot.ResealKeysParams) error) (rest
 	}
 }
 
+func MockSecbootPCRSelection(f func(modelParams []*secboot.SealKeyModelParams) ([]int, error)) (restore func()) {
+	old := secbootPCRSelection
+	secbootPCRSelection = f
+	return func() {
+		secbootPCRSelection = old
+	}
+}
+
+func MockKernelIsUnifiedKernelImage(f func(kernelBootFile bootloader.BootFile) (bool, error)) (restore func()) {
+	old := kernelIsUnifiedKernelImage
+	kernelIsUnifiedKernelImage = f
+	return func() {
+		kernelIsUnifiedKernelImage = old
+	}
+}
+
+func MockSecbootSealedKeyProtectorName(f func(keyFile string) string) (restore func()) {
+	old := secbootSealedKeyProtectorName
+	secbootSealedKeyProtectorName = f
+	return func() {
+		secbootSealedKeyProtectorName = old
+	}
+}
+
+func MockSecbootUnsealKey(f func(keyFile string) ([]byte, error)) (restore func()) {
+	old := secbootUnsealKey
+	secbootUnsealKey = f
+	return func() {
+		secbootUnsealKey = old
+	}
+}
+
+func MockResealKeyToModeenvImpl(f func(rootdir string, model *asserts.Model, modeenv *Modeenv, expectReseal bool) error) (restore func()) {
+	old := resealKeyToModeenvImpl
+	resealKeyToModeenvImpl = f
+	return func() {
+		resealKeyToModeenvImpl = old
+	}
+}
+
+// ResetResealScheduler drops any pending reseal and hold.
+func ResetResealScheduler() {
+	resealSched = resealScheduler{}
+}
+
+func MockSecbootMachineOwnerKeysEnrolled(f func() bool) (restore func()) {
+	old := secbootMachineOwnerKeysEnrolled
+	secbootMachineOwnerKeysEnrolled = f
+	return func() {
+		secbootMachineOwnerKeysEnrolled = old
+	}
+}
+
+func MockSecbootMachineOwnerKeyState(f func() (string, error)) (restore func()) {
+	old := secbootMachineOwnerKeyState
+	secbootMachineOwnerKeyState = f
+	return func() {
+		secbootMachineOwnerKeyState = old
+	}
+}
+
 func MockSeedReadSystemEssential(f func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error)) (restore func()) {
 	old := seedReadSystemEssential
 	seedReadSystemEssential = f
@@ -136,6 +220,8 @@ func (o *TrustedAssetsUpdateObserver) InjectChangedAsset(blName, assetName, hash
 type BootAsset = bootAsset
 type BootChain = bootChain
 type PredictableBootChains = predictableBootChains
+type BootChainsVolume = bootChainsVolume
+type BootChainsPCRProfile = bootChainsPCRProfile
 
 const (
 	BootChainEquivalent   = bootChainEquivalent
@@ -149,9 +235,12 @@ var (
 	ToPredictableBootChains             = toPredictableBootChains
 	PredictableBootChainsEqualForReseal = predictableBootChainsEqualForReseal
 	BootAssetsToLoadChains              = bootAssetsToLoadChains
+	KernelIsUnifiedKernelImage          = kernelIsUnifiedKernelImageImpl
 	BootAssetLess                       = bootAssetLess
 	WriteBootChains                     = writeBootChains
 	ReadBootChains                      = readBootChains
+	ReadBootChainsFile                  = readBootChainsFile
+	MigrateBootChains                   = migrateBootChains
 	IsResealNeeded                      = isResealNeeded
 )
 
@@ -46,6 +46,10 @@ type BootableSet struct {
 
 	// Recover is set when making the recovery partition bootable.
 	Recovery bool
+
+	// FactoryReset is set when making the run system bootable again after
+	// a factory reset, the TPM is then cleared before sealing the keys.
+	FactoryReset bool
 }
 
 // MakeBootable sets up the given bootable set and target filesystem
@@ -365,9 +369,37 @@ func makeBootable20RunMode(model *asserts.Model, rootdir string, bootWith *Boota
 		}
 	}
 
-	if sealer != nil {
+	if sealer != nil && sealer.dataIntegrity {
+		if err := recordDataIntegrity(InstallHostWritableDir); err != nil {
+			return err
+		}
+	}
+
+	if sealer != nil && sealer.factoryKeys {
+		// in factory mode the keys are sealed to the TPM only once the
+		// device leaves the factory
+		if err := storeFactoryKeys(sealer.dataEncryptionKey, sealer.saveEncryptionKey); err != nil {
+			return err
+		}
+	} else if sealer != nil {
 		// seal the encryption key to the parameters specified in modeenv
-		if err := sealKeyToModeenv(sealer.dataEncryptionKey, sealer.saveEncryptionKey, model, modeenv); err != nil {
+		flags := sealKeyToModeenvFlags{
+			FactoryReset: bootWith.FactoryReset,
+			KeyProtector: sealer.keyProtector,
+			TPM:          sealer.tpmOptions,
+		}
+		seal := func() error {
+			return sealKeyToModeenv(sealer.dataEncryptionKey, sealer.saveEncryptionKey, sealer.extraEncryptionKeys, model, modeenv, flags)
+		}
+		if bootWith.FactoryReset {
+			// the sealed keys of the previous installation still
+			// unlock ubuntu-save if the reset is interrupted, they
+			// are only replaced once the new keys are sealed
+			err = withSealedKeyFilesAside(".old", seal)
+		} else {
+			err = seal()
+		}
+		if err != nil {
 			return err
 		}
 	}
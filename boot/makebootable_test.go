@@ -33,6 +33,7 @@ import (
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/bootloader/assets"
 	"github.com/snapcore/snapd/bootloader/bootloadertest"
+	"github.com/snapcore/snapd/bootloader/efi"
 	"github.com/snapcore/snapd/bootloader/ubootenv"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/gadget"
@@ -283,6 +284,14 @@ func (s *makeBootable20Suite) TestMakeBootable20MultipleRecoverySystemsError(c *
 }
 
 func (s *makeBootable20Suite) TestMakeBootable20RunMode(c *C) {
+	s.testMakeBootable20RunMode(c, false)
+}
+
+func (s *makeBootable20Suite) TestMakeBootable20RunModeDataIntegrity(c *C) {
+	s.testMakeBootable20RunMode(c, true)
+}
+
+func (s *makeBootable20Suite) testMakeBootable20RunMode(c *C, dataIntegrity bool) {
 	bootloader.Force(nil)
 
 	model := boottest.MakeMockUC20Model()
@@ -396,6 +405,10 @@ version: 5.0
 		myKey2[i] = byte(128 + i)
 	}
 	obs.ChosenEncryptionKeys(myKey, myKey2)
+	obs.ChosenTPMOptions(boot.TPMOptions{SRKHandle: 0x81000002})
+	if dataIntegrity {
+		obs.ChosenDataIntegrity()
+	}
 
 	// set a mock recovery kernel
 	readSystemEssentialCalls := 0
@@ -420,6 +433,8 @@ version: 5.0
 		case 1:
 			c.Check(keys, HasLen, 1)
 			c.Check(keys[0].Key, DeepEquals, myKey)
+			c.Check(params.TPMSRKHandle, Equals, uint32(0x81000002))
+			c.Check(params.TPMWrapPolicyAuthKey, Equals, false)
 		case 2:
 			c.Check(keys, HasLen, 2)
 			c.Check(keys[0].Key, DeepEquals, myKey)
@@ -456,6 +471,7 @@ version: 5.0
 				secboot.NewLoadChain(shim, secboot.NewLoadChain(grub, secboot.NewLoadChain(kernel))),
 			})
 			c.Assert(params.ModelParams[0].KernelCmdlines, DeepEquals, []string{
+				"snapd_recovery_mode=factory-reset snapd_recovery_system=20191216 console=ttyS0 console=tty1 panic=-1",
 				"snapd_recovery_mode=recover snapd_recovery_system=20191216 console=ttyS0 console=tty1 panic=-1",
 			})
 		default:
@@ -463,6 +479,8 @@ version: 5.0
 		}
 
 		c.Assert(params.ModelParams[0].Model.DisplayName(), Equals, "My Model")
+		// both objects are bound to the integrity requirement
+		c.Check(params.ModelParams[0].DataIntegrity, Equals, dataIntegrity)
 
 		return nil
 	})
@@ -471,6 +489,18 @@ version: 5.0
 	err = boot.MakeBootable(model, s.rootdir, bootWith, obs)
 	c.Assert(err, IsNil)
 
+	// the integrity requirement is recorded on both ubuntu-data and
+	// ubuntu-boot
+	dataIntegrityStamp := filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "data-integrity")
+	dataIntegrityMarker := filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.integrity")
+	if dataIntegrity {
+		c.Check(dataIntegrityStamp, testutil.FilePresent)
+		c.Check(dataIntegrityMarker, testutil.FilePresent)
+	} else {
+		c.Check(dataIntegrityStamp, testutil.FileAbsent)
+		c.Check(dataIntegrityMarker, testutil.FileAbsent)
+	}
+
 	// ensure grub.cfg in boot was installed from internal assets
 	c.Check(mockBootGrubCfg, testutil.FileEquals, string(grubCfgAsset))
 
@@ -624,6 +654,152 @@ version: 5.0
 	c.Assert(err, ErrorMatches, `cannot install managed bootloader assets: internal error: no boot asset for "grub.cfg"`)
 }
 
+func (s *makeBootable20Suite) TestMakeBootable20RunModeFactoryKeys(c *C) {
+	bootloader.Force(nil)
+
+	model := boottest.MakeMockUC20Model()
+	seedSnapsDirs := filepath.Join(s.rootdir, "/snaps")
+	err := os.MkdirAll(seedSnapsDirs, 0755)
+	c.Assert(err, IsNil)
+
+	// grub on ubuntu-seed
+	mockSeedGrubDir := filepath.Join(boot.InitramfsUbuntuSeedDir, "EFI", "ubuntu")
+	mockSeedGrubCfg := filepath.Join(mockSeedGrubDir, "grub.cfg")
+	err = os.MkdirAll(filepath.Dir(mockSeedGrubCfg), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(mockSeedGrubCfg, []byte("# Snapd-Boot-Config-Edition: 1\n"), 0644)
+	c.Assert(err, IsNil)
+
+	// setup recovery boot assets
+	err = os.MkdirAll(filepath.Join(boot.InitramfsUbuntuSeedDir, "EFI/boot"), 0755)
+	c.Assert(err, IsNil)
+	// SHA3-384: 39efae6545f16e39633fbfbef0d5e9fdd45a25d7df8764978ce4d81f255b038046a38d9855e42e5c7c4024e153fd2e37
+	err = ioutil.WriteFile(filepath.Join(boot.InitramfsUbuntuSeedDir, "EFI/boot/bootx64.efi"),
+		[]byte("recovery shim content"), 0644)
+	c.Assert(err, IsNil)
+	// SHA3-384: aa3c1a83e74bf6dd40dd64e5c5bd1971d75cdf55515b23b9eb379f66bf43d4661d22c4b8cf7d7a982d2013ab65c1c4c5
+	err = ioutil.WriteFile(filepath.Join(boot.InitramfsUbuntuSeedDir, "EFI/boot/grubx64.efi"),
+		[]byte("recovery grub content"), 0644)
+	c.Assert(err, IsNil)
+
+	// grub on ubuntu-boot
+	mockBootGrubDir := filepath.Join(boot.InitramfsUbuntuBootDir, "EFI", "ubuntu")
+	mockBootGrubCfg := filepath.Join(mockBootGrubDir, "grub.cfg")
+	err = os.MkdirAll(filepath.Dir(mockBootGrubCfg), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(mockBootGrubCfg, nil, 0644)
+	c.Assert(err, IsNil)
+
+	unpackedGadgetDir := c.MkDir()
+	grubRecoveryCfg := []byte("#grub-recovery cfg")
+	grubRecoveryCfgAsset := []byte("#grub-recovery cfg from assets")
+	err = ioutil.WriteFile(filepath.Join(unpackedGadgetDir, "grub-recovery.conf"), grubRecoveryCfg, 0644)
+	c.Assert(err, IsNil)
+	restore := assets.MockInternal("grub-recovery.cfg", grubRecoveryCfgAsset)
+	defer restore()
+	grubCfg := []byte("#grub cfg")
+	err = ioutil.WriteFile(filepath.Join(unpackedGadgetDir, "grub.conf"), grubCfg, 0644)
+	c.Assert(err, IsNil)
+	grubCfgAsset := []byte("# Snapd-Boot-Config-Edition: 1\n#grub cfg from assets")
+	restore = assets.MockInternal("grub.cfg", grubCfgAsset)
+	defer restore()
+
+	err = ioutil.WriteFile(filepath.Join(unpackedGadgetDir, "bootx64.efi"), []byte("shim content"), 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(unpackedGadgetDir, "grubx64.efi"), []byte("grub content"), 0644)
+	c.Assert(err, IsNil)
+
+	// make the snaps symlinks so that we can ensure that makebootable follows
+	// the symlinks and copies the files and not the symlinks
+	baseFn, baseInfo := makeSnap(c, "core20", `name: core20
+type: base
+version: 5.0
+`, snap.R(3))
+	baseInSeed := filepath.Join(seedSnapsDirs, baseInfo.Filename())
+	err = os.Symlink(baseFn, baseInSeed)
+	c.Assert(err, IsNil)
+	kernelFn, kernelInfo := makeSnapWithFiles(c, "pc-kernel", `name: pc-kernel
+type: kernel
+version: 5.0
+`, snap.R(5),
+		[][]string{
+			{"kernel.efi", "I'm a kernel.efi"},
+		},
+	)
+	kernelInSeed := filepath.Join(seedSnapsDirs, kernelInfo.Filename())
+	err = os.Symlink(kernelFn, kernelInSeed)
+	c.Assert(err, IsNil)
+
+	bootWith := &boot.BootableSet{
+		RecoverySystemDir: "20191216",
+		BasePath:          baseInSeed,
+		Base:              baseInfo,
+		KernelPath:        kernelInSeed,
+		Kernel:            kernelInfo,
+		Recovery:          false,
+		UnpackedGadgetDir: unpackedGadgetDir,
+	}
+
+	// set up observer state
+	useEncryption := true
+	obs, err := boot.TrustedAssetsInstallObserverForModel(model, unpackedGadgetDir, useEncryption)
+	c.Assert(obs, NotNil)
+	c.Assert(err, IsNil)
+	runBootStruct := &gadget.LaidOutStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Role: gadget.SystemBoot,
+		},
+	}
+
+	// only grubx64.efi gets installed to system-boot
+	_, err = obs.Observe(gadget.ContentWrite, runBootStruct, boot.InitramfsUbuntuBootDir, "EFI/boot/grubx64.efi",
+		&gadget.ContentChange{After: filepath.Join(unpackedGadgetDir, "grubx64.efi")})
+	c.Assert(err, IsNil)
+
+	// observe recovery assets
+	err = obs.ObserveExistingTrustedRecoveryAssets(boot.InitramfsUbuntuSeedDir)
+	c.Assert(err, IsNil)
+
+	// set encryption key
+	myKey := secboot.EncryptionKey{}
+	myKey2 := secboot.EncryptionKey{}
+	for i := range myKey {
+		myKey[i] = byte(i)
+		myKey2[i] = byte(128 + i)
+	}
+	obs.ChosenFactoryEncryptionKeys(myKey, myKey2)
+
+	// the keys are stored with the factory key protector only
+	defer secboot.UnregisterKeyProtector(secboot.FactoryKeyProtectorName)
+	sealKeysCalls := 0
+	restore = boot.MockSecbootSealKeys(func(keys []secboot.SealKeyRequest, params *secboot.SealKeysParams) error {
+		sealKeysCalls++
+		c.Check(params, DeepEquals, &secboot.SealKeysParams{KeyProtector: secboot.FactoryKeyProtectorName})
+		c.Check(keys, HasLen, 3)
+		return nil
+	})
+	defer restore()
+
+	err = boot.MakeBootable(model, s.rootdir, bootWith, obs)
+	c.Assert(err, IsNil)
+	c.Check(sealKeysCalls, Equals, 1)
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "factory-keys"), testutil.FilePresent)
+	// the initramfs accepts the factory keys
+	c.Check(filepath.Join(boot.InitramfsBootEncryptionKeyDir, "factory-keys"), testutil.FilePresent)
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "sealed-keys"), testutil.FileAbsent)
+
+	// the trusted assets are tracked for sealing later
+	m, err := boot.ReadModeenv(filepath.Join(boot.InstallHostWritableDir))
+	c.Assert(err, IsNil)
+	c.Check(m.CurrentTrustedBootAssets, DeepEquals, boot.BootAssetsMap{
+		"grubx64.efi": []string{"5ee042c15e104b825d6bc15c41cdb026589f1ec57ed966dd3f29f961d4d6924efc54b187743fa3a583b62722882d405d"},
+	})
+	c.Check(m.CurrentTrustedRecoveryBootAssets, DeepEquals, boot.BootAssetsMap{
+		"bootx64.efi": []string{"39efae6545f16e39633fbfbef0d5e9fdd45a25d7df8764978ce4d81f255b038046a38d9855e42e5c7c4024e153fd2e37"},
+		"grubx64.efi": []string{"aa3c1a83e74bf6dd40dd64e5c5bd1971d75cdf55515b23b9eb379f66bf43d4661d22c4b8cf7d7a982d2013ab65c1c4c5"},
+	})
+}
+
 func (s *makeBootable20Suite) TestMakeBootable20RunModeSealKeyErr(c *C) {
 	bootloader.Force(nil)
 
@@ -1017,3 +1193,104 @@ model=my-brand/my-model-uc20
 grade=dangerous
 `)
 }
+
+type makeBootable20SdbootSuite struct {
+	baseBootenvSuite
+}
+
+var _ = Suite(&makeBootable20SdbootSuite{})
+
+func (s *makeBootable20SdbootSuite) SetUpTest(c *C) {
+	s.baseBootenvSuite.SetUpTest(c)
+	s.AddCleanup(efi.MockVars(nil, nil))
+}
+
+func (s *makeBootable20SdbootSuite) TestSdbootMakeBootable20RunMode(c *C) {
+	model := boottest.MakeMockUC20Model()
+	seedSnapsDirs := filepath.Join(s.rootdir, "/snaps")
+	err := os.MkdirAll(seedSnapsDirs, 0755)
+	c.Assert(err, IsNil)
+
+	// systemd-boot on ubuntu-seed, booting the recovery system
+	seedLoaderConf := filepath.Join(boot.InitramfsUbuntuSeedDir, "loader/loader.conf")
+	c.Assert(os.MkdirAll(filepath.Dir(seedLoaderConf), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(seedLoaderConf, []byte("timeout 0\ndefault ubuntu-core-recovery.conf\neditor no\n"), 0644), IsNil)
+	seedEntry := filepath.Join(boot.InitramfsUbuntuSeedDir, "loader/entries/ubuntu-core-recovery.conf")
+	c.Assert(os.MkdirAll(filepath.Dir(seedEntry), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(seedEntry, []byte(`# snapd-var snapd_recovery_mode=install
+# snapd-var snapd_recovery_system=20191216
+title Ubuntu Core
+efi /systems/20191216/kernel/kernel.efi
+options snapd_recovery_mode=install snapd_recovery_system=20191216 console=ttyS0 console=tty1 panic=-1
+`), 0644), IsNil)
+
+	// the loader directory on ubuntu-boot (as if it was installed when
+	// creating the partition)
+	bootLoaderConf := filepath.Join(boot.InitramfsUbuntuBootDir, "loader/loader.conf")
+	c.Assert(os.MkdirAll(filepath.Dir(bootLoaderConf), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(bootLoaderConf, nil, 0644), IsNil)
+
+	unpackedGadgetDir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(unpackedGadgetDir, "sdboot.conf"), nil, 0644), IsNil)
+
+	baseFn, baseInfo := makeSnap(c, "core20", `name: core20
+type: base
+version: 5.0
+`, snap.R(3))
+	baseInSeed := filepath.Join(seedSnapsDirs, baseInfo.Filename())
+	err = os.Rename(baseFn, baseInSeed)
+	c.Assert(err, IsNil)
+	kernelFn, kernelInfo := makeSnapWithFiles(c, "pc-kernel", `name: pc-kernel
+type: kernel
+version: 5.0
+`, snap.R(5), [][]string{
+		{"kernel.efi", "I'm a kernel.efi"},
+	})
+	kernelInSeed := filepath.Join(seedSnapsDirs, kernelInfo.Filename())
+	err = os.Rename(kernelFn, kernelInSeed)
+	c.Assert(err, IsNil)
+
+	bootWith := &boot.BootableSet{
+		RecoverySystemDir: "20191216",
+		BasePath:          baseInSeed,
+		Base:              baseInfo,
+		KernelPath:        kernelInSeed,
+		Kernel:            kernelInfo,
+		Recovery:          false,
+		UnpackedGadgetDir: unpackedGadgetDir,
+	}
+	err = boot.MakeBootable(model, s.rootdir, bootWith, nil)
+	c.Assert(err, IsNil)
+
+	// the run mode entry of ubuntu-boot boots the kernel from there
+	c.Check(filepath.Join(boot.InitramfsUbuntuBootDir, "pc-kernel_5.snap/kernel.efi"), testutil.FileEquals, "I'm a kernel.efi")
+	c.Check(filepath.Join(boot.InitramfsUbuntuBootDir, "loader/entries/ubuntu-core.conf"), testutil.FileEquals, `# this file is managed by snapd, do not edit
+title Ubuntu Core
+efi /pc-kernel_5.snap/kernel.efi
+options snapd_recovery_mode=run console=ttyS0 console=tty1 panic=-1
+`)
+
+	// and is the default entry, the recovery kernel is not booted in
+	// run mode
+	c.Check(seedLoaderConf, testutil.FileEquals, "timeout 0\ndefault ubuntu-core.conf\neditor no\n")
+	c.Check(seedEntry, testutil.FileEquals, `# this file is managed by snapd, do not edit
+# snapd-var snapd_recovery_mode=run
+# snapd-var snapd_recovery_system=20191216
+title Ubuntu Core
+`)
+
+	// the bootloaders are found again in run mode
+	bl, err := bootloader.Find(boot.InitramfsUbuntuBootDir, &bootloader.Options{Role: bootloader.RoleRunMode, NoSlashBoot: true})
+	c.Assert(err, IsNil)
+	c.Check(bl.Name(), Equals, "sdboot")
+	ebl, ok := bl.(bootloader.ExtractedRunKernelImageBootloader)
+	c.Assert(ok, Equals, true)
+	kernel, err := ebl.Kernel()
+	c.Assert(err, IsNil)
+	c.Check(kernel.Filename(), Equals, "pc-kernel_5.snap")
+	bl, err = bootloader.Find(boot.InitramfsUbuntuSeedDir, &bootloader.Options{Role: bootloader.RoleRecovery})
+	c.Assert(err, IsNil)
+	m, err := bl.GetBootVars("snapd_recovery_mode")
+	c.Assert(err, IsNil)
+	c.Check(m["snapd_recovery_mode"], Equals, "run")
+}
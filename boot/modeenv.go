@@ -62,6 +62,17 @@ type Modeenv struct {
 	// asset names to a list of hashes of the asset contents. Used similarly
 	// to CurrentTrustedBootAssets.
 	CurrentTrustedRecoveryBootAssets bootAssetsMap `key:"current_trusted_recovery_boot_assets"`
+	// CurrentKernelCommandLines is a list of the expected kernel command
+	// lines when booting into run mode. It will typically only be one
+	// element for normal operations, but may contain two elements during
+	// update scenarios, when the keys are sealed to both the old and the
+	// new command line until the new one is known to be in use.
+	CurrentKernelCommandLines bootCommandLines `key:"current_kernel_command_lines"`
+	// SealedForRecoverySystems is the list of recovery systems the
+	// fallback key is sealed for, that is the ones that can be booted
+	// when the run key cannot be unlocked. When unset, the fallback key is
+	// sealed for all the current recovery systems.
+	SealedForRecoverySystems []string `key:"sealed_for_recovery_systems"`
 
 	// read is set to true when a modenv was read successfully
 	read bool
@@ -133,6 +144,7 @@ func ReadModeenv(rootdir string) (*Modeenv, error) {
 	}
 	unmarshalModeenvValueFromCfg(cfg, "recovery_system", &m.RecoverySystem)
 	unmarshalModeenvValueFromCfg(cfg, "current_recovery_systems", &m.CurrentRecoverySystems)
+	unmarshalModeenvValueFromCfg(cfg, "sealed_for_recovery_systems", &m.SealedForRecoverySystems)
 	unmarshalModeenvValueFromCfg(cfg, "mode", &m.Mode)
 	if m.Mode == "" {
 		return nil, fmt.Errorf("internal error: mode is unset")
@@ -151,6 +163,7 @@ func ReadModeenv(rootdir string) (*Modeenv, error) {
 	unmarshalModeenvValueFromCfg(cfg, "grade", &m.Grade)
 	unmarshalModeenvValueFromCfg(cfg, "current_trusted_boot_assets", &m.CurrentTrustedBootAssets)
 	unmarshalModeenvValueFromCfg(cfg, "current_trusted_recovery_boot_assets", &m.CurrentTrustedRecoveryBootAssets)
+	unmarshalModeenvValueFromCfg(cfg, "current_kernel_command_lines", &m.CurrentKernelCommandLines)
 
 	// save all the rest of the keys we don't understand
 	keys, err := cfg.Options("")
@@ -230,6 +243,7 @@ func (m *Modeenv) WriteTo(rootdir string) error {
 	marshalModeenvEntryTo(buf, "mode", m.Mode)
 	marshalModeenvEntryTo(buf, "recovery_system", m.RecoverySystem)
 	marshalModeenvEntryTo(buf, "current_recovery_systems", m.CurrentRecoverySystems)
+	marshalModeenvEntryTo(buf, "sealed_for_recovery_systems", m.SealedForRecoverySystems)
 	marshalModeenvEntryTo(buf, "base", m.Base)
 	marshalModeenvEntryTo(buf, "try_base", m.TryBase)
 	marshalModeenvEntryTo(buf, "base_status", m.BaseStatus)
@@ -246,6 +260,7 @@ func (m *Modeenv) WriteTo(rootdir string) error {
 	marshalModeenvEntryTo(buf, "grade", m.Grade)
 	marshalModeenvEntryTo(buf, "current_trusted_boot_assets", m.CurrentTrustedBootAssets)
 	marshalModeenvEntryTo(buf, "current_trusted_recovery_boot_assets", m.CurrentTrustedRecoveryBootAssets)
+	marshalModeenvEntryTo(buf, "current_kernel_command_lines", m.CurrentKernelCommandLines)
 
 	// write all the extra keys at the end
 	// sort them for test convenience
@@ -400,3 +415,18 @@ func (b *bootAssetsMap) UnmarshalJSON(data []byte) error {
 	*b = bootAssetsMap(asMap)
 	return nil
 }
+
+type bootCommandLines []string
+
+func (b bootCommandLines) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string(b))
+}
+
+func (b *bootCommandLines) UnmarshalJSON(data []byte) error {
+	var asList []string
+	if err := json.Unmarshal(data, &asList); err != nil {
+		return err
+	}
+	*b = bootCommandLines(asList)
+	return nil
+}
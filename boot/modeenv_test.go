@@ -65,6 +65,8 @@ func (s *modeenvSuite) TestKnownKnown(c *C) {
 		"grade":           true,
 		"current_trusted_boot_assets":          true,
 		"current_trusted_recovery_boot_assets": true,
+		"current_kernel_command_lines":         true,
+		"sealed_for_recovery_systems":          true,
 	})
 }
 
@@ -553,6 +555,27 @@ current_recovery_systems=`+t.systemsString+"\n")
 	}
 }
 
+func (s *modeenvSuite) TestSealedForRecoverySystemsRoundtrip(c *C) {
+	modeenv := &boot.Modeenv{
+		Mode:                     "run",
+		RecoverySystem:           "20191128",
+		CurrentRecoverySystems:   []string{"20191128", "2020-02-03"},
+		SealedForRecoverySystems: []string{"20191128"},
+	}
+	err := modeenv.WriteTo(s.tmpdir)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.mockModeenvPath, testutil.FileEquals, `mode=run
+recovery_system=20191128
+current_recovery_systems=20191128,2020-02-03
+sealed_for_recovery_systems=20191128
+`)
+
+	modeenv2, err := boot.ReadModeenv(s.tmpdir)
+	c.Assert(err, IsNil)
+	c.Check(modeenv2.SealedForRecoverySystems, DeepEquals, []string{"20191128"})
+}
+
 type fancyDataBothMarshallers struct {
 	Foo []string
 }
@@ -665,3 +688,30 @@ current_trusted_recovery_boot_assets={"bootx64.efi":["shimhash1","shimhash2"],"g
 		"bootx64.efi": []string{"shimhash1", "shimhash2"},
 	})
 }
+
+func (s *modeenvSuite) TestMarshalCurrentKernelCommandLines(c *C) {
+	c.Assert(s.mockModeenvPath, testutil.FileAbsent)
+
+	modeenv := &boot.Modeenv{
+		Mode:           "run",
+		RecoverySystem: "20191128",
+		CurrentKernelCommandLines: boot.BootCommandLines{
+			"snapd_recovery_mode=run console=ttyS0,115200 panic=-1",
+			"snapd_recovery_mode=run panic=-1",
+		},
+	}
+	err := modeenv.WriteTo(s.tmpdir)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.mockModeenvPath, testutil.FileEquals, `mode=run
+recovery_system=20191128
+current_kernel_command_lines=["snapd_recovery_mode=run console=ttyS0,115200 panic=-1","snapd_recovery_mode=run panic=-1"]
+`)
+
+	modeenvRead, err := boot.ReadModeenv(s.tmpdir)
+	c.Assert(err, IsNil)
+	c.Assert(modeenvRead.CurrentKernelCommandLines, DeepEquals, boot.BootCommandLines{
+		"snapd_recovery_mode=run console=ttyS0,115200 panic=-1",
+		"snapd_recovery_mode=run panic=-1",
+	})
+}
@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/logger"
+)
+
+var resealKeyToModeenvImpl = resealKeyToModeenvNow
+
+// resealRequest is a request for resealing the keys to the parameters
+// specified in a modeenv.
+type resealRequest struct {
+	rootdir      string
+	model        *asserts.Model
+	modeenv      *Modeenv
+	expectReseal bool
+}
+
+// sameProfile returns whether both requests reseal the keys to the same
+// parameters.
+func (req *resealRequest) sameProfile(other *resealRequest) bool {
+	if other == nil || req.rootdir != other.rootdir {
+		return false
+	}
+	if req.model != other.model {
+		if req.model == nil || other.model == nil {
+			return false
+		}
+		if req.model.Ref().Unique() != other.model.Ref().Unique() || req.model.Revision() != other.model.Revision() || req.model.SignKeyID() != other.model.SignKeyID() {
+			return false
+		}
+	}
+	return req.modeenv.deepEqual(other.modeenv)
+}
+
+// resealScheduler coalesces the requests for resealing the keys which only
+// drop boot chains once a boot was successful, made while resealing is held,
+// so that several of them within one operation result in a single update of
+// the TPM policies, to the latest parameters. Until then the keys stay
+// sealed to a superset of the boot chains, which is safe.
+//
+// Requests for resealing the keys before boot assets, kernels or command
+// lines are written are always performed right away, so that the TPM
+// policies cover them before they are used and so that failing to reseal
+// fails the operation. They cannot be merged with each other: the next
+// write is not known in advance, and deferring the reseal past a write
+// would leave a device rebooted in between unable to unseal its keys.
+// Such a request is made with the latest parameters and supersedes a
+// pending one, a batch writing N boot assets, kernels or command lines
+// thus reseals N times before the writes and at most once after.
+//
+// Requests for the same parameters as the pending request are dropped. When
+// a request is performed, the boot chains are compared with the ones the
+// keys were last sealed to, which drops the reseals to identical profiles.
+type resealScheduler struct {
+	mu        sync.Mutex
+	holds     int
+	pending   *resealRequest
+	coalesced int
+}
+
+var resealSched resealScheduler
+
+// resealKeyToModeenv reseals the existing encryption key to the
+// parameters specified in modeenv right away. It must be used before
+// writing boot assets, kernels or command lines that the keys must be
+// sealed to.
+func resealKeyToModeenv(rootdir string, model *asserts.Model, modeenv *Modeenv, expectReseal bool) error {
+	return resealSched.resealNow(&resealRequest{
+		rootdir:      rootdir,
+		model:        model,
+		modeenv:      modeenv,
+		expectReseal: expectReseal,
+	})
+}
+
+// resealKeyToModeenvAfterBoot reseals the existing encryption key to the
+// parameters specified in modeenv, which only drop boot chains after a
+// successful boot, or records the request if resealing is held.
+func resealKeyToModeenvAfterBoot(rootdir string, model *asserts.Model, modeenv *Modeenv, expectReseal bool) error {
+	return resealSched.request(&resealRequest{
+		rootdir:      rootdir,
+		model:        model,
+		modeenv:      modeenv,
+		expectReseal: expectReseal,
+	})
+}
+
+func (s *resealScheduler) resealNow(req *resealRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending != nil {
+		if s.pending.rootdir == req.rootdir {
+			// resealed to the latest parameters below
+			logger.Debugf("superseding pending reseal request")
+			req.expectReseal = req.expectReseal || s.pending.expectReseal
+			s.pending = nil
+			s.coalesced = 0
+		} else if err := s.flushLocked(); err != nil {
+			return err
+		}
+	}
+	return resealKeyToModeenvImpl(req.rootdir, req.model, req.modeenv, req.expectReseal)
+}
+
+func (s *resealScheduler) request(req *resealRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.holds == 0 {
+		return resealKeyToModeenvImpl(req.rootdir, req.model, req.modeenv, req.expectReseal)
+	}
+
+	if s.pending != nil && s.pending.rootdir != req.rootdir {
+		// requests for different roots cannot be merged
+		if err := s.flushLocked(); err != nil {
+			return err
+		}
+	}
+	// the modeenv may be modified by the caller before the request is
+	// performed
+	m, err := req.modeenv.Copy()
+	if err != nil {
+		return err
+	}
+	req.modeenv = m
+	if s.pending != nil {
+		// the keys only need to be resealed to the latest parameters,
+		// if there was ambiguity about whether the boot chains
+		// changed with an earlier request, it still stands
+		req.expectReseal = req.expectReseal || s.pending.expectReseal
+		if req.sameProfile(s.pending) {
+			logger.Debugf("dropping duplicate reseal request")
+		} else {
+			s.coalesced++
+			logger.Debugf("coalescing reseal request (%d so far)", s.coalesced)
+		}
+	}
+	s.pending = req
+	return nil
+}
+
+func (s *resealScheduler) flushLocked() error {
+	req := s.pending
+	if req == nil {
+		return nil
+	}
+	s.pending = nil
+	if s.coalesced != 0 {
+		logger.Noticef("resealing keys for %d coalesced requests", s.coalesced+1)
+	}
+	s.coalesced = 0
+	return resealKeyToModeenvImpl(req.rootdir, req.model, req.modeenv, req.expectReseal)
+}
+
+// HoldReseal defers the resealing of the keys requested by boot operations
+// after a successful boot until ReleaseReseal is called a matching number of
+// times, or FlushReseal is called. The requests made in the meantime are
+// coalesced into a single reseal to the latest parameters. Resealing the
+// keys before boot assets, kernels or command lines are written is never
+// deferred.
+//
+// Errors resealing the keys with deferred requests are reported by
+// ReleaseReseal or FlushReseal, the keys then remain sealed to a superset
+// of the boot chains.
+func HoldReseal() {
+	resealSched.mu.Lock()
+	defer resealSched.mu.Unlock()
+	resealSched.holds++
+}
+
+// ReleaseReseal releases a hold taken with HoldReseal, performing the
+// pending reseal when the last hold is released.
+func ReleaseReseal() error {
+	resealSched.mu.Lock()
+	defer resealSched.mu.Unlock()
+	if resealSched.holds == 0 {
+		return fmt.Errorf("internal error: resealing is not held")
+	}
+	resealSched.holds--
+	if resealSched.holds > 0 {
+		return nil
+	}
+	return resealSched.flushLocked()
+}
+
+// FlushReseal performs the pending reseal, if any, right away even if
+// resealing is held. It is meant for tests and for when snapd shuts down.
+func FlushReseal() error {
+	resealSched.mu.Lock()
+	defer resealSched.mu.Unlock()
+	return resealSched.flushLocked()
+}
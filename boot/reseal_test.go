@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot_test
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/boot/boottest"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type resealSchedulerSuite struct {
+	testutil.BaseTest
+
+	calls []resealCall
+	err   error
+}
+
+type resealCall struct {
+	rootdir      string
+	modeenv      *boot.Modeenv
+	expectReseal bool
+}
+
+var _ = Suite(&resealSchedulerSuite{})
+
+func (s *resealSchedulerSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.calls = nil
+	s.err = nil
+
+	boot.ResetResealScheduler()
+	s.AddCleanup(boot.ResetResealScheduler)
+	s.AddCleanup(boot.MockResealKeyToModeenvImpl(func(rootdir string, model *asserts.Model, modeenv *boot.Modeenv, expectReseal bool) error {
+		s.calls = append(s.calls, resealCall{rootdir: rootdir, modeenv: modeenv, expectReseal: expectReseal})
+		return s.err
+	}))
+}
+
+func (s *resealSchedulerSuite) TestNotHeldResealsImmediately(c *C) {
+	model := boottest.MakeMockUC20Model()
+	m := &boot.Modeenv{Mode: "run", CurrentKernels: []string{"pc-kernel_1.snap"}}
+
+	err := boot.ResealKeyToModeenv("/root", model, m, true)
+	c.Assert(err, IsNil)
+	c.Assert(s.calls, HasLen, 1)
+	c.Check(s.calls[0].rootdir, Equals, "/root")
+	c.Check(s.calls[0].modeenv, Equals, m)
+	c.Check(s.calls[0].expectReseal, Equals, true)
+}
+
+func (s *resealSchedulerSuite) TestNotHeldResealsAfterBootImmediately(c *C) {
+	model := boottest.MakeMockUC20Model()
+	m := &boot.Modeenv{Mode: "run", CurrentKernels: []string{"pc-kernel_1.snap"}}
+
+	err := boot.ResealKeyToModeenvAfterBoot("/root", model, m, false)
+	c.Assert(err, IsNil)
+	c.Assert(s.calls, HasLen, 1)
+	c.Check(s.calls[0].modeenv, Equals, m)
+}
+
+func (s *resealSchedulerSuite) TestHeldResealsBeforeWritesImmediately(c *C) {
+	model := boottest.MakeMockUC20Model()
+
+	boot.HoldReseal()
+	defer boot.ReleaseReseal()
+
+	// a reseal after boot is held
+	m := &boot.Modeenv{Mode: "run", CurrentKernels: []string{"pc-kernel_1.snap"}}
+	err := boot.ResealKeyToModeenvAfterBoot("/root", model, m, true)
+	c.Assert(err, IsNil)
+	c.Check(s.calls, HasLen, 0)
+
+	// but not one needed before new boot assets are used, its errors
+	// are reported to the caller
+	s.err = errors.New("reseal failed")
+	m2 := &boot.Modeenv{Mode: "run", CurrentKernels: []string{"pc-kernel_1.snap", "pc-kernel_2.snap"}}
+	err = boot.ResealKeyToModeenv("/root", model, m2, false)
+	c.Assert(err, ErrorMatches, "reseal failed")
+	c.Assert(s.calls, HasLen, 1)
+	c.Check(s.calls[0].modeenv, Equals, m2)
+	// the pending request is superseded
+	c.Check(s.calls[0].expectReseal, Equals, true)
+
+	s.err = nil
+	c.Assert(boot.FlushReseal(), IsNil)
+	c.Check(s.calls, HasLen, 1)
+}
+
+func (s *resealSchedulerSuite) TestHeldDropsDuplicates(c *C) {
+	model := boottest.MakeMockUC20Model()
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	boot.HoldReseal()
+	m := &boot.Modeenv{Mode: "run", CurrentKernels: []string{"pc-kernel_1.snap"}}
+	c.Assert(boot.ResealKeyToModeenvAfterBoot("/root", model, m, false), IsNil)
+	c.Assert(boot.ResealKeyToModeenvAfterBoot("/root", model, m, false), IsNil)
+	c.Assert(boot.ReleaseReseal(), IsNil)
+	c.Assert(s.calls, HasLen, 1)
+	// nothing was coalesced
+	c.Check(logbuf.String(), Not(testutil.Contains), "coalesced requests")
+}
+
+func (s *resealSchedulerSuite) TestHeldCoalescesToLatest(c *C) {
+	model := boottest.MakeMockUC20Model()
+
+	boot.HoldReseal()
+	m := &boot.Modeenv{Mode: "run", CurrentKernels: []string{"pc-kernel_1.snap"}}
+	err := boot.ResealKeyToModeenvAfterBoot("/root", model, m, true)
+	c.Assert(err, IsNil)
+	m.CurrentKernels = append(m.CurrentKernels, "pc-kernel_2.snap")
+	err = boot.ResealKeyToModeenvAfterBoot("/root", model, m, false)
+	c.Assert(err, IsNil)
+	// the modeenv is changed by the caller after the request
+	m.CurrentKernels = []string{"pc-kernel_3.snap"}
+	c.Check(s.calls, HasLen, 0)
+
+	err = boot.ReleaseReseal()
+	c.Assert(err, IsNil)
+	c.Assert(s.calls, HasLen, 1)
+	c.Check(s.calls[0].rootdir, Equals, "/root")
+	c.Check(s.calls[0].modeenv.CurrentKernels, DeepEquals, []string{"pc-kernel_1.snap", "pc-kernel_2.snap"})
+	// an earlier ambiguous request is still ambiguous
+	c.Check(s.calls[0].expectReseal, Equals, true)
+
+	// nothing left to do
+	err = boot.FlushReseal()
+	c.Assert(err, IsNil)
+	c.Check(s.calls, HasLen, 1)
+}
+
+func (s *resealSchedulerSuite) TestNestedHolds(c *C) {
+	model := boottest.MakeMockUC20Model()
+	m := &boot.Modeenv{Mode: "run"}
+
+	boot.HoldReseal()
+	boot.HoldReseal()
+	c.Assert(boot.ResealKeyToModeenvAfterBoot("/root", model, m, false), IsNil)
+	c.Assert(boot.ReleaseReseal(), IsNil)
+	c.Check(s.calls, HasLen, 0)
+	c.Assert(boot.ReleaseReseal(), IsNil)
+	c.Check(s.calls, HasLen, 1)
+
+	err := boot.ReleaseReseal()
+	c.Assert(err, ErrorMatches, "internal error: resealing is not held")
+}
+
+func (s *resealSchedulerSuite) TestDifferentRootdirFlushes(c *C) {
+	model := boottest.MakeMockUC20Model()
+	m := &boot.Modeenv{Mode: "run"}
+
+	boot.HoldReseal()
+	c.Assert(boot.ResealKeyToModeenvAfterBoot("/root", model, m, false), IsNil)
+	c.Assert(boot.ResealKeyToModeenvAfterBoot("/other", model, m, false), IsNil)
+	c.Assert(s.calls, HasLen, 1)
+	c.Check(s.calls[0].rootdir, Equals, "/root")
+
+	c.Assert(boot.ReleaseReseal(), IsNil)
+	c.Assert(s.calls, HasLen, 2)
+	c.Check(s.calls[1].rootdir, Equals, "/other")
+}
+
+func (s *resealSchedulerSuite) TestFlushWhileHeld(c *C) {
+	model := boottest.MakeMockUC20Model()
+	m := &boot.Modeenv{Mode: "run"}
+
+	boot.HoldReseal()
+	c.Assert(boot.ResealKeyToModeenvAfterBoot("/root", model, m, false), IsNil)
+	s.err = errors.New("reseal failed")
+	err := boot.FlushReseal()
+	c.Assert(err, ErrorMatches, "reseal failed")
+	c.Check(s.calls, HasLen, 1)
+
+	// the failed request is not retried
+	c.Assert(boot.ReleaseReseal(), IsNil)
+	c.Check(s.calls, HasLen, 1)
+}
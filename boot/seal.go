@@ -23,8 +23,12 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -33,6 +37,7 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/seed"
 	"github.com/snapcore/snapd/snap"
@@ -44,6 +49,17 @@ var (
 	secbootSealKeys   = secboot.SealKeys
 	secbootResealKeys = secboot.ResealKeys
 
+	secbootSealedKeyProtectorName = secboot.SealedKeyProtectorName
+	secbootPCRSelection           = secboot.PCRSelectionForModelParams
+	secbootUnsealKey              = secboot.UnsealKey
+
+	secbootNewEncryptionKey    = secboot.NewEncryptionKey
+	secbootAddEncryptionKey    = secboot.AddEncryptionKey
+	secbootRemoveEncryptionKey = secboot.RemoveEncryptionKey
+
+	secbootMachineOwnerKeysEnrolled = secboot.MachineOwnerKeysEnrolled
+	secbootMachineOwnerKeyState     = secboot.MachineOwnerKeyState
+
 	seedReadSystemEssential = seed.ReadSystemEssential
 )
 
@@ -55,10 +71,55 @@ func recoveryBootChainsFileUnder(rootdir string) string {
 	return filepath.Join(dirs.SnapFDEDirUnder(rootdir), "recovery-boot-chains")
 }
 
+// ExtraVolumeKey is the encryption key of an additional encrypted volume
+// declared by the gadget, sealed in the run object alongside the key of
+// ubuntu-data.
+type ExtraVolumeKey struct {
+	secboot.ExtraVolume
+	Key secboot.EncryptionKey
+}
+
+// sealKeyToModeenvFlags carries the details of the situation in which the
+// keys are sealed.
+type sealKeyToModeenvFlags struct {
+	// FactoryReset is set when the keys are sealed after a factory reset,
+	// the TPM provisioned at install is cleared and provisioned again
+	// with the lockout authorization kept in ubuntu-save
+	FactoryReset bool
+	// KeyProtector is the key protector sealing the keys, by default the
+	// TPM
+	KeyProtector string
+	// TPM tunes the provisioning of the TPM and the sealing of the keys
+	// to it
+	TPM TPMOptions
+}
+
+// TPMOptions tune how the TPM is provisioned and how the keys are sealed to
+// it, see secboot.SealKeysParams.
+type TPMOptions struct {
+	// SRKTemplate and SRKHandle select the storage root key of the TPM
+	SRKTemplate secboot.SRKTemplate
+	SRKHandle   uint32
+	// WrapPolicyAuthKey wraps the saved policy auth key with a key bound
+	// to the TPM
+	WrapPolicyAuthKey bool
+	// EKCertFile is the endorsement key certificate the TPM is verified
+	// with before sealing, unless SkipEKVerification is set
+	EKCertFile         string
+	SkipEKVerification bool
+}
+
 // sealKeyToModeenv seals the supplied keys to the parameters specified
 // in modeenv.
-// It assumes to be invoked in install mode.
-func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, model *asserts.Model, modeenv *Modeenv) error {
+// It assumes to be invoked in install or factory-reset mode.
+func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, extraKeys []ExtraVolumeKey, model *asserts.Model, modeenv *Modeenv, flags sealKeyToModeenvFlags) error {
+	return sealKeyToModeenvUnder(key, saveKey, extraKeys, model, modeenv, InstallHostWritableDir, InstallHostFDESaveDir, flags)
+}
+
+// sealKeyToModeenvUnder seals the supplied keys to the parameters specified
+// in modeenv, the state of the sealed keys is kept under writableDir and the
+// TPM authorization files are written to fdeSaveDir.
+func sealKeyToModeenvUnder(key, saveKey secboot.EncryptionKey, extraKeys []ExtraVolumeKey, model *asserts.Model, modeenv *Modeenv, writableDir, fdeSaveDir string, flags sealKeyToModeenvFlags) error {
 	// build the recovery mode boot chain
 	rbl, err := bootloader.Find(InitramfsUbuntuSeedDir, &bootloader.Options{
 		Role: bootloader.RoleRecovery,
@@ -72,10 +133,26 @@ func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, model *asserts.Model,
 		return fmt.Errorf("internal error: cannot seal keys without a trusted assets bootloader")
 	}
 
-	recoveryBootChains, err := recoveryBootChainsForSystems([]string{modeenv.RecoverySystem}, tbl, model, modeenv)
+	// the seed is trusted at install, the root hashes of the hash trees
+	// of its snaps are recorded so that the keys stay bound to them
+	if err := recordSeedVerityRootHashes(writableDir, modeenv.RecoverySystem); err != nil {
+		return fmt.Errorf("cannot record seed verity root hashes: %v", err)
+	}
+	seedVerity, err := readSeedVerityRootHashes(writableDir)
+	if err != nil {
+		return err
+	}
+
+	recoveryBootChains, err := recoveryBootChainsForSystems([]string{modeenv.RecoverySystem}, tbl, model, modeenv, seedVerity, false)
 	if err != nil {
 		return fmt.Errorf("cannot compose recovery boot chains: %v", err)
 	}
+	// the fallback object is also used to unlock ubuntu-save during a
+	// factory reset
+	fallbackRecoveryBootChains, err := recoveryBootChainsForSystems([]string{modeenv.RecoverySystem}, tbl, model, modeenv, seedVerity, true)
+	if err != nil {
+		return fmt.Errorf("cannot compose fallback recovery boot chains: %v", err)
+	}
 
 	// build the run mode boot chains
 	bl, err := bootloader.Find(InitramfsUbuntuBootDir, &bootloader.Options{
@@ -90,11 +167,17 @@ func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, model *asserts.Model,
 		return fmt.Errorf("cannot compose the candidate command line: %v", err)
 	}
 
-	runModeBootChains, err := runModeBootChains(rbl, bl, model, modeenv, cmdline)
+	runModeBootChains, err := runModeBootChains(rbl, bl, model, modeenv, []string{cmdline})
 	if err != nil {
 		return fmt.Errorf("cannot compose run mode boot chains: %v", err)
 	}
 
+	// the keys are bound to the measurement of the data integrity
+	// requirement, recorded on ubuntu-data by now
+	runModeBootChains = withDataIntegrity(runModeBootChains, writableDir)
+	recoveryBootChains = withDataIntegrity(recoveryBootChains, writableDir)
+	fallbackRecoveryBootChains = withDataIntegrity(fallbackRecoveryBootChains, writableDir)
+
 	pbc := toPredictableBootChains(append(runModeBootChains, recoveryBootChains...))
 
 	roleToBlName := map[bootloader.Role]string{
@@ -106,8 +189,8 @@ func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, model *asserts.Model,
 	for _, p := range []string{
 		InitramfsSeedEncryptionKeyDir,
 		InitramfsBootEncryptionKeyDir,
-		InstallHostFDEDataDir,
-		InstallHostFDESaveDir,
+		dirs.SnapFDEDirUnder(writableDir),
+		fdeSaveDir,
 	} {
 		// XXX: should that be 0700 ?
 		if err := os.MkdirAll(p, 0755); err != nil {
@@ -116,7 +199,7 @@ func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, model *asserts.Model,
 	}
 
 	// the boot chains we seal the fallback object to
-	rpbc := toPredictableBootChains(recoveryBootChains)
+	rpbc := toPredictableBootChains(fallbackRecoveryBootChains)
 
 	// gets written to a file by sealRunObjectKeys()
 	authKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -124,43 +207,61 @@ func sealKeyToModeenv(key, saveKey secboot.EncryptionKey, model *asserts.Model,
 		return fmt.Errorf("cannot generate key for signing dynamic authorization policies: %v", err)
 	}
 
-	if err := sealRunObjectKeys(key, pbc, authKey, roleToBlName); err != nil {
+	volumes, err := sealRunObjectKeys(key, extraKeys, pbc, authKey, roleToBlName, writableDir, fdeSaveDir, flags)
+	if err != nil {
 		return err
 	}
 
-	if err := sealFallbackObjectKeys(key, saveKey, rpbc, authKey, roleToBlName); err != nil {
+	fallbackVolumes, err := sealFallbackObjectKeys(key, saveKey, rpbc, authKey, roleToBlName, flags)
+	if err != nil {
 		return err
 	}
 
-	if err := stampSealedKeys(InstallHostWritableDir); err != nil {
+	if err := stampSealedKeys(writableDir); err != nil {
 		return err
 	}
 
-	installBootChainsPath := bootChainsFileUnder(InstallHostWritableDir)
-	if err := writeBootChains(pbc, installBootChainsPath, 0); err != nil {
+	keyProtector := flags.KeyProtector
+	if keyProtector == "" {
+		keyProtector = secboot.TPM2KeyProtectorName
+	}
+	installBootChainsPath := bootChainsFileUnder(writableDir)
+	if err := writeBootChains(pbc, installBootChainsPath, 0, keyProtector, volumes); err != nil {
 		return err
 	}
 
-	installRecoveryBootChainsPath := recoveryBootChainsFileUnder(InstallHostWritableDir)
-	if err := writeBootChains(rpbc, installRecoveryBootChainsPath, 0); err != nil {
+	installRecoveryBootChainsPath := recoveryBootChainsFileUnder(writableDir)
+	if err := writeBootChains(rpbc, installRecoveryBootChainsPath, 0, keyProtector, fallbackVolumes); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func sealRunObjectKeys(key secboot.EncryptionKey, pbc predictableBootChains, authKey *ecdsa.PrivateKey, roleToBlName map[bootloader.Role]string) error {
+// sealRunObjectKeys seals the keys of the run object and returns the
+// description of the sealed volumes for the boot chains file.
+func sealRunObjectKeys(key secboot.EncryptionKey, extraKeys []ExtraVolumeKey, pbc predictableBootChains, authKey *ecdsa.PrivateKey, roleToBlName map[bootloader.Role]string, writableDir, fdeSaveDir string, flags sealKeyToModeenvFlags) ([]*bootChainsVolume, error) {
 	modelParams, err := sealKeyModelParams(pbc, roleToBlName)
 	if err != nil {
-		return fmt.Errorf("cannot prepare for key sealing: %v", err)
+		return nil, fmt.Errorf("cannot prepare for key sealing: %v", err)
 	}
 
+	// the TPM parameters are ignored by the other key protectors, which
+	// bind the keys to the same PCR profile built from the model
+	// parameters
 	sealKeyParams := &secboot.SealKeysParams{
+		KeyProtector:           flags.KeyProtector,
 		ModelParams:            modelParams,
 		TPMPolicyAuthKey:       authKey,
-		TPMPolicyAuthKeyFile:   filepath.Join(InstallHostFDESaveDir, "tpm-policy-auth-key"),
-		TPMLockoutAuthFile:     filepath.Join(InstallHostFDESaveDir, "tpm-lockout-auth"),
+		TPMPolicyAuthKeyFile:   filepath.Join(fdeSaveDir, "tpm-policy-auth-key"),
+		TPMWrapPolicyAuthKey:   flags.TPM.WrapPolicyAuthKey,
+		TPMLockoutAuthFile:     filepath.Join(fdeSaveDir, "tpm-lockout-auth"),
 		TPMProvision:           true,
+		TPMClear:               flags.FactoryReset,
+		TPMSRKTemplate:         flags.TPM.SRKTemplate,
+		TPMSRKHandle:           flags.TPM.SRKHandle,
+		TPMEKCertFile:          flags.TPM.EKCertFile,
+		TPMSkipEKVerification:  flags.TPM.SkipEKVerification,
 		PCRPolicyCounterHandle: secboot.RunObjectPCRPolicyCounterHandle,
 	}
 	// The run object contains only the ubuntu-data key; the ubuntu-save key
@@ -174,28 +275,101 @@ func sealRunObjectKeys(key secboot.EncryptionKey, pbc predictableBootChains, aut
 			KeyFile: filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
 		},
 	}
+	volumes := []*bootChainsVolume{
+		{Name: "ubuntu-data", SealedKeyFile: keys[0].KeyFile},
+	}
+	// The keys of the extra volumes declared by the gadget are needed in
+	// run mode only, they are sealed in the run object too.
+	var extraVolumes []secboot.ExtraVolume
+	for _, ek := range extraKeys {
+		keyFile := ek.SealedKeyFile(InitramfsBootEncryptionKeyDir)
+		keys = append(keys, secboot.SealKeyRequest{
+			Key:     ek.Key,
+			KeyFile: keyFile,
+		})
+		volumes = append(volumes, &bootChainsVolume{Name: ek.Name, SealedKeyFile: keyFile})
+		extraVolumes = append(extraVolumes, ek.ExtraVolume)
+	}
 	if err := secbootSealKeys(keys, sealKeyParams); err != nil {
-		return fmt.Errorf("cannot seal the encryption keys: %v", err)
+		return nil, fmt.Errorf("cannot seal the encryption keys: %v", err)
+	}
+	if len(extraVolumes) > 0 {
+		// so that the initramfs unlocks them and they get resealed,
+		// the record is kept on ubuntu-data which can only be
+		// accessed once unlocked with its own sealed key, unlike
+		// ubuntu-boot
+		if err := secboot.WriteExtraVolumes(dirs.SnapFDEDirUnder(writableDir), extraVolumes); err != nil {
+			return nil, fmt.Errorf("cannot record the extra encrypted volumes: %v", err)
+		}
 	}
 
-	return nil
+	if err := setVolumesPCRProfile(volumes, modelParams, sealKeyParams.PCRPolicyCounterHandle); err != nil {
+		return nil, err
+	}
+	return volumes, nil
 }
 
-func sealFallbackObjectKeys(key, saveKey secboot.EncryptionKey, pbc predictableBootChains, authKey *ecdsa.PrivateKey, roleToBlName map[bootloader.Role]string) error {
+// sealFallbackObjectKeys seals the keys of the fallback object and returns
+// the description of the sealed volumes for the boot chains file.
+func sealFallbackObjectKeys(key, saveKey secboot.EncryptionKey, pbc predictableBootChains, authKey *ecdsa.PrivateKey, roleToBlName map[bootloader.Role]string, flags sealKeyToModeenvFlags) ([]*bootChainsVolume, error) {
 	// also seal the keys to the recovery bootchains as a fallback
 	modelParams, err := sealKeyModelParams(pbc, roleToBlName)
 	if err != nil {
-		return fmt.Errorf("cannot prepare for fallback key sealing: %v", err)
+		return nil, fmt.Errorf("cannot prepare for fallback key sealing: %v", err)
 	}
 	sealKeyParams := &secboot.SealKeysParams{
+		KeyProtector:           flags.KeyProtector,
 		ModelParams:            modelParams,
 		TPMPolicyAuthKey:       authKey,
+		TPMEKCertFile:          flags.TPM.EKCertFile,
+		TPMSkipEKVerification:  flags.TPM.SkipEKVerification,
 		PCRPolicyCounterHandle: secboot.FallbackObjectPCRPolicyCounterHandle,
 	}
 	// The fallback object contains the ubuntu-data and ubuntu-save keys. The
 	// key files are stored on ubuntu-seed, separate from ubuntu-data so they
 	// can be used if ubuntu-data and ubuntu-boot are corrupted or unavailable.
+	volumes := fallbackObjectKeyFiles()
 	keys := []secboot.SealKeyRequest{
+		{
+			Key:     key,
+			KeyFile: volumes[0].SealedKeyFile,
+		},
+		{
+			Key:     saveKey,
+			KeyFile: volumes[1].SealedKeyFile,
+		},
+	}
+	if err := secbootSealKeys(keys, sealKeyParams); err != nil {
+		return nil, fmt.Errorf("cannot seal the fallback encryption keys: %v", err)
+	}
+
+	if err := setVolumesPCRProfile(volumes, modelParams, sealKeyParams.PCRPolicyCounterHandle); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// SealKeysWithProtector seals the encryption keys of the run system with the
+// given key protector which does not bind keys to the boot chains, writing
+// the sealed key files where the initramfs expects them. There is nothing
+// to reseal when boot assets change.
+// It assumes to be invoked in install mode.
+func SealKeysWithProtector(keyProtector string, key, saveKey secboot.EncryptionKey) error {
+	for _, p := range []string{
+		InitramfsSeedEncryptionKeyDir,
+		InitramfsBootEncryptionKeyDir,
+	} {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+	}
+
+	// same layout of run and fallback objects as with the TPM
+	keys := []secboot.SealKeyRequest{
+		{
+			Key:     key,
+			KeyFile: filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+		},
 		{
 			Key:     key,
 			KeyFile: filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
@@ -205,10 +379,299 @@ func sealFallbackObjectKeys(key, saveKey secboot.EncryptionKey, pbc predictableB
 			KeyFile: filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
 		},
 	}
-	if err := secbootSealKeys(keys, sealKeyParams); err != nil {
-		return fmt.Errorf("cannot seal the fallback encryption keys: %v", err)
+	params := &secboot.SealKeysParams{
+		KeyProtector: keyProtector,
+	}
+	if err := secbootSealKeys(keys, params); err != nil {
+		return fmt.Errorf("cannot seal the encryption keys with %q: %v", keyProtector, err)
+	}
+	return nil
+}
+
+// ErrNoFactoryKeys is returned by SealFactoryKeys when the encryption keys
+// are not stored for factory mode, either because they were sealed already or
+// because the device does not use encryption.
+var ErrNoFactoryKeys = errors.New("no factory keys found")
+
+// factoryKeysMarker marks on ubuntu-boot that the encryption keys are stored
+// unprotected for factory mode, it is the only factory mode state available
+// to the initramfs before ubuntu-data is unlocked.
+func factoryKeysMarker() string {
+	return filepath.Join(InitramfsBootEncryptionKeyDir, "factory-keys")
+}
+
+// storeFactoryKeys stores the encryption keys of the run system
+// unprotected, in the locations of the sealed key files, for use while the
+// device is in factory mode.
+func storeFactoryKeys(key, saveKey secboot.EncryptionKey) error {
+	secboot.EnableFactoryKeyProtector()
+	if err := SealKeysWithProtector(secboot.FactoryKeyProtectorName, key, saveKey); err != nil {
+		return err
+	}
+	if err := osutil.AtomicWriteFile(factoryKeysMarker(), nil, 0644, 0); err != nil {
+		return fmt.Errorf("cannot create fde factory keys marker: %v", err)
+	}
+	stamp := filepath.Join(dirs.SnapFDEDirUnder(InstallHostWritableDir), "factory-keys")
+	if err := os.MkdirAll(filepath.Dir(stamp), 0755); err != nil {
+		return fmt.Errorf("cannot create device fde state directory: %v", err)
+	}
+	if err := osutil.AtomicWriteFile(stamp, nil, 0644, 0); err != nil {
+		return fmt.Errorf("cannot create fde factory keys stamp file: %v", err)
+	}
+	return nil
+}
+
+// hasFactoryKeys returns whether the encryption keys are stored unprotected
+// for factory mode
+func hasFactoryKeys(rootdir string) bool {
+	stamp := filepath.Join(dirs.SnapFDEDirUnder(rootdir), "factory-keys")
+	return osutil.FileExists(stamp)
+}
+
+// InitramfsEnableFactoryKeys enables the factory key protector if the device
+// was installed in factory mode and was not sealed yet, so that the volumes
+// can be unlocked with the unprotected keys. It returns whether the protector
+// was enabled.
+func InitramfsEnableFactoryKeys() bool {
+	if !osutil.FileExists(factoryKeysMarker()) {
+		return false
+	}
+	secboot.EnableFactoryKeyProtector()
+	return true
+}
+
+// dataIntegrityMarker marks on ubuntu-boot that ubuntu-data was created with
+// integrity protection, for the initramfs to require it before ubuntu-data is
+// unlocked.
+func dataIntegrityMarker() string {
+	return filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.integrity")
+}
+
+// dataIntegrityStamp returns the file recording on ubuntu-data that it is
+// integrity protected. Unlike the marker on ubuntu-boot it cannot be
+// modified offline, the keys are sealed according to it.
+func dataIntegrityStamp(rootdir string) string {
+	return filepath.Join(dirs.SnapFDEDirUnder(rootdir), "data-integrity")
+}
+
+// recordDataIntegrity records that ubuntu-data is integrity protected, both
+// on ubuntu-data under rootdir and on ubuntu-boot.
+func recordDataIntegrity(rootdir string) error {
+	if err := os.MkdirAll(InitramfsBootEncryptionKeyDir, 0755); err != nil {
+		return err
 	}
+	if err := osutil.AtomicWriteFile(dataIntegrityMarker(), nil, 0644, 0); err != nil {
+		return fmt.Errorf("cannot create data integrity marker: %v", err)
+	}
+	stamp := dataIntegrityStamp(rootdir)
+	if err := os.MkdirAll(filepath.Dir(stamp), 0755); err != nil {
+		return fmt.Errorf("cannot create device fde state directory: %v", err)
+	}
+	if err := osutil.AtomicWriteFile(stamp, nil, 0644, 0); err != nil {
+		return fmt.Errorf("cannot create data integrity stamp file: %v", err)
+	}
+	return nil
+}
+
+// withDataIntegrity marks the boot chains as measuring the data integrity
+// requirement when ubuntu-data under rootdir is integrity protected.
+func withDataIntegrity(chains []bootChain, rootdir string) []bootChain {
+	if !osutil.FileExists(dataIntegrityStamp(rootdir)) {
+		return chains
+	}
+	for i := range chains {
+		chains[i].DataIntegrity = true
+	}
+	return chains
+}
 
+// InitramfsDataIntegrityRequired returns whether ubuntu-data must be
+// integrity protected, as marked on ubuntu-boot at install. The marker is not
+// authenticated, the requirement is measured when it is present and the keys
+// of an integrity protected ubuntu-data are sealed to that measurement, so
+// that removing the marker prevents unsealing them.
+func InitramfsDataIntegrityRequired() bool {
+	return osutil.FileExists(dataIntegrityMarker())
+}
+
+// factoryVolumeKey is an encrypted volume whose factory key is replaced when
+// the device is sealed.
+type factoryVolumeKey struct {
+	node   string
+	oldKey secboot.EncryptionKey
+	newKey secboot.EncryptionKey
+}
+
+// factoryVolumeKeys returns the encrypted volumes of the run system, with
+// their factory keys and the new keys replacing them.
+func factoryVolumeKeys(key, saveKey secboot.EncryptionKey) ([]*factoryVolumeKey, error) {
+	disk, err := disks.DiskFromMountPoint(InitramfsUbuntuSeedDir, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the disk of ubuntu-seed: %v", err)
+	}
+	var vols []*factoryVolumeKey
+	for _, v := range []struct {
+		name string
+		key  secboot.EncryptionKey
+	}{
+		{"ubuntu-data", key},
+		{"ubuntu-save", saveKey},
+	} {
+		partUUID, err := disk.FindMatchingPartitionUUID(v.name + "-enc")
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the encrypted %s partition: %v", v.name, err)
+		}
+		newKey, err := secbootNewEncryptionKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot create a new key for %s: %v", v.name, err)
+		}
+		vols = append(vols, &factoryVolumeKey{
+			node:   filepath.Join("/dev/disk/by-partuuid", partUUID),
+			oldKey: v.key,
+			newKey: newKey,
+		})
+	}
+	return vols, nil
+}
+
+// SealFactoryKeys seals the encryption keys of the device to the TPM,
+// provisioning it on the way, when the device leaves the factory. The keys
+// stored unprotected in factory mode may have been copied, so new keys are
+// sealed instead and the factory keys are removed from the volumes and from
+// the disk. The TPM is provisioned and the keys sealed with the given
+// options. It is meant to be invoked in run mode and cannot be undone.
+func SealFactoryKeys(model *asserts.Model, tpmOpts TPMOptions) error {
+	if !hasFactoryKeys(dirs.GlobalRootDir) {
+		return ErrNoFactoryKeys
+	}
+	modeenv, err := ReadModeenv("")
+	if err != nil {
+		return fmt.Errorf("cannot seal factory keys: %v", err)
+	}
+
+	secboot.EnableFactoryKeyProtector()
+	p, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	if err != nil {
+		return err
+	}
+	readKey := func(keyFile string) (secboot.EncryptionKey, error) {
+		var key secboot.EncryptionKey
+		buf, err := p.UnsealKey(keyFile)
+		if err != nil {
+			return key, fmt.Errorf("cannot seal factory keys: %v", err)
+		}
+		copy(key[:], buf)
+		return key, nil
+	}
+	key, err := readKey(filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"))
+	if err != nil {
+		return err
+	}
+	saveKey, err := readKey(filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"))
+	if err != nil {
+		return err
+	}
+	vols, err := factoryVolumeKeys(key, saveKey)
+	if err != nil {
+		return fmt.Errorf("cannot seal factory keys: %v", err)
+	}
+	dataVol, saveVol := vols[0], vols[1]
+
+	// the new keys are added next to the factory ones, which are removed
+	// only once the new keys are sealed, so that the device still boots if
+	// sealing fails
+	var added []*factoryVolumeKey
+	removeNewKeys := func() {
+		for _, vol := range added {
+			if err := secbootRemoveEncryptionKey(vol.node, vol.newKey[:]); err != nil {
+				logger.Noticef("cannot remove new key of %s: %v", vol.node, err)
+			}
+		}
+	}
+	for _, vol := range vols {
+		if err := secbootAddEncryptionKey(vol.node, vol.oldKey[:], vol.newKey); err != nil {
+			removeNewKeys()
+			return fmt.Errorf("cannot seal factory keys: %v", err)
+		}
+		added = append(added, vol)
+	}
+
+	// the key of ubuntu-save is kept on ubuntu-data to unlock ubuntu-save
+	// in run mode
+	saveKeyFile := filepath.Join(dirs.SnapFDEDirUnder(dirs.GlobalRootDir), "ubuntu-save.key")
+	if err := saveVol.newKey.Save(saveKeyFile); err != nil {
+		removeNewKeys()
+		return fmt.Errorf("cannot seal factory keys: %v", err)
+	}
+	restoreSaveKey := func() {
+		if err := saveVol.oldKey.Save(saveKeyFile); err != nil {
+			logger.Noticef("cannot restore ubuntu-save key file: %v", err)
+		}
+	}
+
+	fdeSaveDir := dirs.SnapSaveFDEDirUnder(dirs.GlobalRootDir)
+	flags := sealKeyToModeenvFlags{
+		TPM: tpmOpts,
+	}
+	err = withSealedKeyFilesAside(".factory", func() error {
+		return sealKeyToModeenvUnder(dataVol.newKey, saveVol.newKey, nil, model, modeenv, dirs.GlobalRootDir, fdeSaveDir, flags)
+	})
+	if err != nil {
+		restoreSaveKey()
+		removeNewKeys()
+		return err
+	}
+	if err := os.Remove(factoryKeysMarker()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove fde factory keys marker: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dirs.SnapFDEDirUnder(dirs.GlobalRootDir), "factory-keys")); err != nil {
+		return fmt.Errorf("cannot remove fde factory keys stamp file: %v", err)
+	}
+
+	// the factory keys cannot unlock the volumes anymore from now on
+	for _, vol := range vols {
+		if err := secbootRemoveEncryptionKey(vol.node, vol.oldKey[:]); err != nil {
+			return fmt.Errorf("cannot remove factory key: %v", err)
+		}
+	}
+	return nil
+}
+
+// withSealedKeyFilesAside calls seal with the existing sealed key files
+// moved aside, as sealing does not overwrite existing key files. The files
+// are kept next to their original path with the given suffix until seal
+// returns, they are then restored if seal failed and removed otherwise, so
+// that the previous keys stay available until the new ones are written.
+func withSealedKeyFilesAside(suffix string, seal func() error) error {
+	var aside []string
+	restore := func() {
+		for _, keyFile := range aside {
+			if err := os.Rename(keyFile+suffix, keyFile); err != nil {
+				logger.Noticef("cannot restore sealed key file %q: %v", keyFile, err)
+			}
+		}
+	}
+	for _, keyFile := range SealedKeyFiles() {
+		if !osutil.FileExists(keyFile) {
+			continue
+		}
+		if err := os.Rename(keyFile, keyFile+suffix); err != nil {
+			restore()
+			return fmt.Errorf("cannot move aside sealed key file: %v", err)
+		}
+		aside = append(aside, keyFile)
+	}
+
+	if err := seal(); err != nil {
+		restore()
+		return err
+	}
+
+	for _, keyFile := range aside {
+		if err := os.Remove(keyFile + suffix); err != nil {
+			logger.Noticef("cannot remove previous sealed key file %q: %v", keyFile, err)
+		}
+	}
 	return nil
 }
 
@@ -232,9 +695,10 @@ func hasSealedKeys(rootdir string) bool {
 	return osutil.FileExists(stamp)
 }
 
-// resealKeyToModeenv reseals the existing encryption key to the
-// parameters specified in modeenv.
-func resealKeyToModeenv(rootdir string, model *asserts.Model, modeenv *Modeenv, expectReseal bool) error {
+// resealKeyToModeenvNow reseals the existing encryption key to the
+// parameters specified in modeenv. Boot operations use resealKeyToModeenv
+// instead, which goes through the reseal scheduler.
+func resealKeyToModeenvNow(rootdir string, model *asserts.Model, modeenv *Modeenv, expectReseal bool) error {
 	if !hasSealedKeys(rootdir) {
 		// nothing to do
 		return nil
@@ -252,7 +716,11 @@ func resealKeyToModeenv(rootdir string, model *asserts.Model, modeenv *Modeenv,
 		// TODO:UC20: later the exact kind of bootloaders we expect here might change
 		return fmt.Errorf("internal error: sealed keys but not a trusted assets bootloader")
 	}
-	recoveryBootChains, err := recoveryBootChainsForSystems(modeenv.CurrentRecoverySystems, tbl, model, modeenv)
+	seedVerity, err := readSeedVerityRootHashes(rootdir)
+	if err != nil {
+		return err
+	}
+	recoveryBootChains, err := recoveryBootChainsForSystems(modeenv.CurrentRecoverySystems, tbl, model, modeenv, seedVerity, false)
 	if err != nil {
 		return fmt.Errorf("cannot compose recovery boot chains: %v", err)
 	}
@@ -265,81 +733,211 @@ func resealKeyToModeenv(rootdir string, model *asserts.Model, modeenv *Modeenv,
 	if err != nil {
 		return fmt.Errorf("cannot find the bootloader: %v", err)
 	}
-	cmdline, err := ComposeCommandLine(model)
+	cmdlines, err := kernelCommandLinesForResealWithFallback(model, modeenv)
 	if err != nil {
-		return fmt.Errorf("cannot compose the run mode command line: %v", err)
+		return err
 	}
 
-	runModeBootChains, err := runModeBootChains(rbl, bl, model, modeenv, cmdline)
+	runModeBootChains, err := runModeBootChains(rbl, bl, model, modeenv, cmdlines)
 	if err != nil {
 		return fmt.Errorf("cannot compose run mode boot chains: %v", err)
 	}
 
-	// reseal the run object
-	pbc := toPredictableBootChains(append(runModeBootChains, recoveryBootChains...))
-
-	needed, nextCount, err := isResealNeeded(pbc, bootChainsFileUnder(rootdir), expectReseal)
-	if err != nil {
-		return err
-	}
-	if !needed {
-		logger.Debugf("reseal not necessary")
-		return nil
-	}
-	pbcJSON, _ := json.Marshal(pbc)
-	logger.Debugf("resealing (%d) to boot chains: %s", nextCount, pbcJSON)
-
 	roleToBlName := map[bootloader.Role]string{
 		bootloader.RoleRecovery: rbl.Name(),
 		bootloader.RoleRunMode:  bl.Name(),
 	}
 
+	runModeBootChains = withDataIntegrity(runModeBootChains, rootdir)
+	recoveryBootChains = withDataIntegrity(recoveryBootChains, rootdir)
+
+	// reseal the run object
+	pbc := toPredictableBootChains(append(runModeBootChains, recoveryBootChains...))
+	fallbackRecoveryBootChains, err := recoveryBootChainsForSystems(sealedForRecoverySystems(modeenv), tbl, model, modeenv, seedVerity, true)
+	if err != nil {
+		return fmt.Errorf("cannot compose fallback recovery boot chains: %v", err)
+	}
+	fallbackRecoveryBootChains = withDataIntegrity(fallbackRecoveryBootChains, rootdir)
+	rpbc := toPredictableBootChains(fallbackRecoveryBootChains)
+
 	authKeyFile := filepath.Join(dirs.SnapSaveFDEDirUnder(rootdir), "tpm-policy-auth-key")
-	if err := resealRunObjectKeys(pbc, authKeyFile, roleToBlName); err != nil {
+
+	// the run and fallback objects are sealed to different boot chains,
+	// one may need resealing while the other is up to date
+	needed, nextCount, err := isResealNeeded(pbc, bootChainsFileUnder(rootdir), expectReseal)
+	if err != nil {
 		return err
 	}
-	logger.Debugf("resealing (%d) succeeded", nextCount)
+	if needed {
+		pbcJSON, _ := json.Marshal(pbc)
+		logger.Debugf("resealing (%d) to boot chains: %s", nextCount, pbcJSON)
 
-	bootChainsPath := bootChainsFileUnder(rootdir)
-	if err := writeBootChains(pbc, bootChainsPath, nextCount); err != nil {
-		return err
+		volumes, err := resealRunObjectKeys(rootdir, pbc, authKeyFile, roleToBlName)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("resealing (%d) succeeded", nextCount)
+
+		bootChainsPath := bootChainsFileUnder(rootdir)
+		if err := writeBootChains(pbc, bootChainsPath, nextCount, sealedKeyProtector(volumes), volumes); err != nil {
+			return err
+		}
+	} else {
+		logger.Debugf("reseal not necessary")
+		migrateRunObjectBootChainsOrLog(rootdir, pbc, roleToBlName)
 	}
 
 	// reseal the fallback object
-	rpbc := toPredictableBootChains(recoveryBootChains)
-
-	var nextFallbackCount int
-	needed, nextFallbackCount, err = isResealNeeded(rpbc, recoveryBootChainsFileUnder(rootdir), expectReseal)
+	needed, nextFallbackCount, err := isResealNeeded(rpbc, recoveryBootChainsFileUnder(rootdir), expectReseal)
 	if err != nil {
 		return err
 	}
 	if !needed {
 		logger.Debugf("fallback reseal not necessary")
+		migrateFallbackObjectBootChainsOrLog(recoveryBootChainsFileUnder(rootdir), rpbc, roleToBlName)
 		return nil
 	}
 
 	rpbcJSON, _ := json.Marshal(rpbc)
-	logger.Debugf("resealing (%d) to recovery boot chains: %s", nextCount, rpbcJSON)
+	logger.Debugf("resealing (%d) to recovery boot chains: %s", nextFallbackCount, rpbcJSON)
 
-	if err := resealFallbackObjectKeys(rpbc, authKeyFile, roleToBlName); err != nil {
+	fallbackVolumes, err := resealFallbackObjectKeys(rootdir, rpbc, authKeyFile, roleToBlName)
+	if err != nil {
 		return err
 	}
 	logger.Debugf("fallback resealing (%d) succeeded", nextFallbackCount)
 
 	recoveryBootChainsPath := recoveryBootChainsFileUnder(rootdir)
-	return writeBootChains(rpbc, recoveryBootChainsPath, nextFallbackCount)
+	return writeBootChains(rpbc, recoveryBootChainsPath, nextFallbackCount, sealedKeyProtector(fallbackVolumes), fallbackVolumes)
+}
+
+// runObjectKeys returns the volumes whose keys are sealed in the run
+// object, ubuntu-data followed by the extra volumes declared by the gadget
+// recorded on the ubuntu-data under rootdir.
+func runObjectKeys(rootdir string) ([]*bootChainsVolume, error) {
+	volumes := []*bootChainsVolume{
+		{
+			Name:          "ubuntu-data",
+			SealedKeyFile: filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+		},
+	}
+	extraVolumes, err := secboot.ReadExtraVolumes(dirs.SnapFDEDirUnder(rootdir))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the extra encrypted volumes: %v", err)
+	}
+	for _, ev := range extraVolumes {
+		volumes = append(volumes, &bootChainsVolume{
+			Name:          ev.Name,
+			SealedKeyFile: ev.SealedKeyFile(InitramfsBootEncryptionKeyDir),
+		})
+	}
+	return volumes, nil
 }
 
-func resealRunObjectKeys(pbc predictableBootChains, authKeyFile string, roleToBlName map[bootloader.Role]string) error {
+// fallbackObjectKeyFiles returns the volumes whose keys are sealed in the
+// fallback object.
+func fallbackObjectKeyFiles() []*bootChainsVolume {
+	return []*bootChainsVolume{
+		{
+			Name:          "ubuntu-data",
+			SealedKeyFile: filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
+		},
+		{
+			Name:          "ubuntu-save",
+			SealedKeyFile: filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+		},
+	}
+}
+
+// setVolumesPCRProfile records in the volumes the PCR policy their keys are
+// sealed with, the PCRs are the ones of the PCR profile built for the model
+// parameters.
+func setVolumesPCRProfile(volumes []*bootChainsVolume, modelParams []*secboot.SealKeyModelParams, policyCounterHandle uint32) error {
+	pcrs, err := secbootPCRSelection(modelParams)
+	if err != nil {
+		return fmt.Errorf("cannot compute the PCRs of the sealed keys: %v", err)
+	}
+	profile := &bootChainsPCRProfile{
+		PCRs:                pcrs,
+		PolicyCounterHandle: policyCounterHandle,
+	}
+	for _, vol := range volumes {
+		vol.PCRProfile = profile
+	}
+	return nil
+}
+
+// sealedKeyProtector returns the name of the key protector the keys of the
+// volumes are sealed with.
+func sealedKeyProtector(volumes []*bootChainsVolume) string {
+	if len(volumes) != 0 {
+		if name := secbootSealedKeyProtectorName(volumes[0].SealedKeyFile); name != "" {
+			return name
+		}
+	}
+	return secboot.TPM2KeyProtectorName
+}
+
+// migrateRunObjectBootChainsOrLog migrates the boot chains file of the run
+// object to the current version of the format, failing to do so is not
+// fatal as the file is rewritten with the next reseal anyway.
+func migrateRunObjectBootChainsOrLog(rootdir string, pbc predictableBootChains, roleToBlName map[bootloader.Role]string) {
+	err := func() error {
+		modelParams, err := sealKeyModelParams(pbc, roleToBlName)
+		if err != nil {
+			return err
+		}
+		volumes, err := runObjectKeys(rootdir)
+		if err != nil {
+			return err
+		}
+		if err := setVolumesPCRProfile(volumes, modelParams, secboot.RunObjectPCRPolicyCounterHandle); err != nil {
+			return err
+		}
+		return migrateBootChains(bootChainsFileUnder(rootdir), sealedKeyProtector(volumes), volumes)
+	}()
+	if err != nil {
+		logger.Noticef("cannot migrate boot chains file: %v", err)
+	}
+}
+
+// migrateFallbackObjectBootChainsOrLog is like
+// migrateRunObjectBootChainsOrLog for the boot chains file of the fallback
+// object.
+func migrateFallbackObjectBootChainsOrLog(path string, rpbc predictableBootChains, roleToBlName map[bootloader.Role]string) {
+	err := func() error {
+		modelParams, err := sealKeyModelParams(rpbc, roleToBlName)
+		if err != nil {
+			return err
+		}
+		volumes := fallbackObjectKeyFiles()
+		if err := setVolumesPCRProfile(volumes, modelParams, secboot.FallbackObjectPCRPolicyCounterHandle); err != nil {
+			return err
+		}
+		return migrateBootChains(path, sealedKeyProtector(volumes), volumes)
+	}()
+	if err != nil {
+		logger.Noticef("cannot migrate boot chains file: %v", err)
+	}
+}
+
+// resealRunObjectKeys reseals the keys of the run object and returns the
+// description of the resealed volumes for the boot chains file.
+func resealRunObjectKeys(rootdir string, pbc predictableBootChains, authKeyFile string, roleToBlName map[bootloader.Role]string) ([]*bootChainsVolume, error) {
 	// get model parameters from bootchains
 	modelParams, err := sealKeyModelParams(pbc, roleToBlName)
 	if err != nil {
-		return fmt.Errorf("cannot prepare for key resealing: %v", err)
+		return nil, fmt.Errorf("cannot prepare for key resealing: %v", err)
 	}
 
 	// list all the key files to reseal
-	keyFiles := []string{
-		filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+	volumes, err := runObjectKeys(rootdir)
+	if err != nil {
+		return nil, err
+	}
+	keyFiles := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		keyFiles = append(keyFiles, vol.SealedKeyFile)
 	}
 
 	resealKeyParams := &secboot.ResealKeysParams{
@@ -348,23 +946,29 @@ func resealRunObjectKeys(pbc predictableBootChains, authKeyFile string, roleToBl
 		TPMPolicyAuthKeyFile: authKeyFile,
 	}
 	if err := secbootResealKeys(resealKeyParams); err != nil {
-		return fmt.Errorf("cannot reseal the encryption key: %v", err)
+		return nil, fmt.Errorf("cannot reseal the encryption key: %v", err)
 	}
 
-	return nil
+	if err := setVolumesPCRProfile(volumes, modelParams, secboot.RunObjectPCRPolicyCounterHandle); err != nil {
+		return nil, err
+	}
+	return volumes, nil
 }
 
-func resealFallbackObjectKeys(pbc predictableBootChains, authKeyFile string, roleToBlName map[bootloader.Role]string) error {
+// resealFallbackObjectKeys reseals the keys of the fallback object and
+// returns the description of the resealed volumes for the boot chains file.
+func resealFallbackObjectKeys(rootdir string, pbc predictableBootChains, authKeyFile string, roleToBlName map[bootloader.Role]string) ([]*bootChainsVolume, error) {
 	// get model parameters from bootchains
 	modelParams, err := sealKeyModelParams(pbc, roleToBlName)
 	if err != nil {
-		return fmt.Errorf("cannot prepare for fallback key resealing: %v", err)
+		return nil, fmt.Errorf("cannot prepare for fallback key resealing: %v", err)
 	}
 
 	// list all the key files to reseal
-	keyFiles := []string{
-		filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
-		filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+	volumes := fallbackObjectKeyFiles()
+	keyFiles := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		keyFiles = append(keyFiles, vol.SealedKeyFile)
 	}
 
 	resealKeyParams := &secboot.ResealKeysParams{
@@ -372,20 +976,158 @@ func resealFallbackObjectKeys(pbc predictableBootChains, authKeyFile string, rol
 		KeyFiles:             keyFiles,
 		TPMPolicyAuthKeyFile: authKeyFile,
 	}
+	if secbootSealedKeyProtectorName(keyFiles[0]) != "" {
+		// key protectors other than the TPM seal the keys again, but
+		// the fallback object cannot be unsealed in run mode
+		keys, err := fallbackObjectKeys(rootdir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot reseal the fallback encryption keys: %v", err)
+		}
+		resealKeyParams.Keys = keys
+	}
 	if err := secbootResealKeys(resealKeyParams); err != nil {
-		return fmt.Errorf("cannot reseal the fallback encryption keys: %v", err)
+		return nil, fmt.Errorf("cannot reseal the fallback encryption keys: %v", err)
+	}
+
+	if err := setVolumesPCRProfile(volumes, modelParams, secboot.FallbackObjectPCRPolicyCounterHandle); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// fallbackObjectKeys returns the keys of the fallback object in run mode, the
+// ubuntu-data key is unsealed from the run object and the ubuntu-save key is
+// kept on ubuntu-data.
+func fallbackObjectKeys(rootdir string) ([]secboot.EncryptionKey, error) {
+	dataKey, err := secbootUnsealKey(filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"))
+	if err != nil {
+		return nil, err
+	}
+	saveKey, err := ioutil.ReadFile(filepath.Join(dirs.SnapFDEDirUnder(rootdir), "ubuntu-save.key"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]secboot.EncryptionKey, 2)
+	for i, k := range [][]byte{dataKey, saveKey} {
+		if len(k) != len(keys[i]) {
+			return nil, fmt.Errorf("invalid key size %d", len(k))
+		}
+		copy(keys[i][:], k)
 	}
+	return keys, nil
+}
+
+// sealedForRecoverySystems returns the recovery systems the fallback key
+// must be sealed for.
+func sealedForRecoverySystems(modeenv *Modeenv) []string {
+	if len(modeenv.SealedForRecoverySystems) > 0 {
+		return modeenv.SealedForRecoverySystems
+	}
+	// systems installed before the recovery systems were tracked
+	// separately have the fallback key sealed for all of them
+	return modeenv.CurrentRecoverySystems
+}
 
+// MarkRecoverySystemSealedFor adds the given recovery system to the ones
+// the fallback key is sealed for, so that it can be booted even when the run
+// key cannot be unlocked, and reseals the keys. The system is added to the
+// current recovery systems as well if needed. It is meant to be called once
+// a newly created recovery system is known to be good.
+func MarkRecoverySystemSealedFor(dev Device, systemLabel string) error {
+	if !dev.HasModeenv() {
+		return fmt.Errorf("internal error: recovery systems can only be tracked on UC20")
+	}
+	if systemLabel == "" {
+		return fmt.Errorf("internal error: system label is unset")
+	}
+	m, err := loadModeenv()
+	if err != nil {
+		return err
+	}
+	newM, err := m.Copy()
+	if err != nil {
+		return err
+	}
+	newM.SealedForRecoverySystems = append([]string(nil), sealedForRecoverySystems(m)...)
+	if !strutil.ListContains(newM.CurrentRecoverySystems, systemLabel) {
+		newM.CurrentRecoverySystems = append(newM.CurrentRecoverySystems, systemLabel)
+	}
+	if !strutil.ListContains(newM.SealedForRecoverySystems, systemLabel) {
+		newM.SealedForRecoverySystems = append(newM.SealedForRecoverySystems, systemLabel)
+	}
+	return writeModeenvAndReseal(dev.Model(), m, newM)
+}
+
+// UnmarkRecoverySystemSealedFor removes the given recovery system from the
+// ones the fallback key is sealed for and reseals the keys. The system
+// remains bootable with the run key as long as it is among the current
+// recovery systems.
+func UnmarkRecoverySystemSealedFor(dev Device, systemLabel string) error {
+	if !dev.HasModeenv() {
+		return fmt.Errorf("internal error: recovery systems can only be tracked on UC20")
+	}
+	m, err := loadModeenv()
+	if err != nil {
+		return err
+	}
+	sealedFor := sealedForRecoverySystems(m)
+	if !strutil.ListContains(sealedFor, systemLabel) {
+		// nothing to do
+		return nil
+	}
+	var remaining []string
+	for _, label := range sealedFor {
+		if label != systemLabel {
+			remaining = append(remaining, label)
+		}
+	}
+	if len(remaining) == 0 {
+		return fmt.Errorf("cannot unmark recovery system %q: the fallback key must be sealed for at least one recovery system", systemLabel)
+	}
+	newM, err := m.Copy()
+	if err != nil {
+		return err
+	}
+	newM.SealedForRecoverySystems = remaining
+	return writeModeenvAndReseal(dev.Model(), m, newM)
+}
+
+// writeModeenvAndReseal writes the new modeenv and reseals the keys to it,
+// restoring the old modeenv if resealing fails.
+func writeModeenvAndReseal(model *asserts.Model, m, newM *Modeenv) error {
+	if err := newM.Write(); err != nil {
+		return err
+	}
+	const expectReseal = true
+	if err := resealKeyToModeenv(dirs.GlobalRootDir, model, newM, expectReseal); err != nil {
+		if werr := m.Write(); werr != nil {
+			logger.Noticef("cannot restore modeenv: %v", werr)
+		}
+		return err
+	}
 	return nil
 }
 
-func recoveryBootChainsForSystems(systems []string, trbl bootloader.TrustedAssetsBootloader, model *asserts.Model, modeenv *Modeenv) (chains []bootChain, err error) {
+// recoveryBootChainsForSystems returns the boot chains of the given recovery
+// systems booted in recover mode, and also in factory-reset mode when
+// includeFactoryReset is set.
+func recoveryBootChainsForSystems(systems []string, trbl bootloader.TrustedAssetsBootloader, model *asserts.Model, modeenv *Modeenv, seedVerity map[string]map[string][]byte, includeFactoryReset bool) (chains []bootChain, err error) {
 	for _, system := range systems {
-		// get the command line
+		// get the command lines
 		cmdline, err := ComposeRecoveryCommandLine(model, system)
 		if err != nil {
 			return nil, fmt.Errorf("cannot obtain recovery kernel command line: %v", err)
 		}
+		cmdlines := []string{cmdline}
+		if includeFactoryReset {
+			frCmdline, err := composeCommandLine(model, currentEdition, ModeFactoryReset, system)
+			if err != nil {
+				return nil, fmt.Errorf("cannot obtain factory reset kernel command line: %v", err)
+			}
+			if frCmdline != "" {
+				cmdlines = append(cmdlines, frCmdline)
+			}
+		}
 
 		// get kernel information from seed
 		perf := timings.New(nil)
@@ -413,6 +1155,10 @@ func recoveryBootChainsForSystems(systems []string, trbl bootloader.TrustedAsset
 		if err != nil {
 			return nil, err
 		}
+		uki, err := kernelIsUnifiedKernelImage(kbf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read kernel metadata of recovery system %q: %v", system, err)
+		}
 
 		chains = append(chains, bootChain{
 			BrandID:        model.BrandID(),
@@ -422,15 +1168,34 @@ func recoveryBootChainsForSystems(systems []string, trbl bootloader.TrustedAsset
 			AssetChain:     assetChain,
 			Kernel:         seedKernel.SnapName(),
 			KernelRevision: kernelRev,
-			KernelCmdlines: []string{cmdline},
-			model:          model,
-			kernelBootFile: kbf,
+			KernelCmdlines: cmdlines,
+			// the seed snaps are verified and measured when
+			// booting the recovery system
+			SeedVerityRootHashes: seedVerity[system],
+			UnifiedKernelImage:   uki,
+			model:                model,
+			kernelBootFile:       kbf,
 		})
 	}
 	return chains, nil
 }
 
-func runModeBootChains(rbl, bl bootloader.Bootloader, model *asserts.Model, modeenv *Modeenv, cmdline string) ([]bootChain, error) {
+// kernelCommandLinesForResealWithFallback returns the kernel command lines
+// the run mode keys need to be resealed to. Those are tracked in the modeenv,
+// but for systems installed before the command lines were tracked, the
+// command line is composed from the current boot config.
+func kernelCommandLinesForResealWithFallback(model *asserts.Model, modeenv *Modeenv) ([]string, error) {
+	if len(modeenv.CurrentKernelCommandLines) > 0 {
+		return modeenv.CurrentKernelCommandLines, nil
+	}
+	cmdline, err := ComposeCommandLine(model)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compose the run mode command line: %v", err)
+	}
+	return []string{cmdline}, nil
+}
+
+func runModeBootChains(rbl, bl bootloader.Bootloader, model *asserts.Model, modeenv *Modeenv, cmdlines []string) ([]bootChain, error) {
 	tbl, ok := rbl.(bootloader.TrustedAssetsBootloader)
 	if !ok {
 		return nil, fmt.Errorf("recovery bootloader doesn't support trusted assets")
@@ -452,21 +1217,26 @@ func runModeBootChains(rbl, bl bootloader.Bootloader, model *asserts.Model, mode
 		if err != nil {
 			return nil, err
 		}
+		uki, err := kernelIsUnifiedKernelImage(kbf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read kernel metadata of %s: %v", k, err)
+		}
 		var kernelRev string
 		if info.SnapRevision().Store() {
 			kernelRev = info.SnapRevision().String()
 		}
 		chains = append(chains, bootChain{
-			BrandID:        model.BrandID(),
-			Model:          model.Model(),
-			Grade:          model.Grade(),
-			ModelSignKeyID: model.SignKeyID(),
-			AssetChain:     assetChain,
-			Kernel:         info.SnapName(),
-			KernelRevision: kernelRev,
-			KernelCmdlines: []string{cmdline},
-			model:          model,
-			kernelBootFile: kbf,
+			BrandID:            model.BrandID(),
+			Model:              model.Model(),
+			Grade:              model.Grade(),
+			ModelSignKeyID:     model.SignKeyID(),
+			AssetChain:         assetChain,
+			Kernel:             info.SnapName(),
+			KernelRevision:     kernelRev,
+			KernelCmdlines:     cmdlines,
+			UnifiedKernelImage: uki,
+			model:              model,
+			kernelBootFile:     kbf,
 		})
 	}
 	return chains, nil
@@ -502,29 +1272,93 @@ func buildBootAssets(bootFiles []bootloader.BootFile, modeenv *Modeenv) (assets
 	return assets, bootFiles[len(bootFiles)-1], nil
 }
 
+// efiSignatureDbUpdatesState returns a digest identifying the EFI signature
+// database updates staged in dirs.SnapEFISignatureDbUpdatesDir, or an empty
+// string if there are none. Keys sealed with the staged updates must be
+// resealed when it changes.
+func efiSignatureDbUpdatesState() (string, error) {
+	files, err := ioutil.ReadDir(dirs.SnapEFISignatureDbUpdatesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	h := sha256.New()
+	staged := false
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dirs.SnapEFISignatureDbUpdatesDir, fi.Name()))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", fi.Name(), len(content))
+		h.Write(content)
+		staged = true
+	}
+	if !staged {
+		return "", nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func sealKeyModelParams(pbc predictableBootChains, roleToBlName map[bootloader.Role]string) ([]*secboot.SealKeyModelParams, error) {
-	modelToParams := map[*asserts.Model]*secboot.SealKeyModelParams{}
+	// staged EFI signature database updates are taken into account so
+	// that applying them does not invalidate the sealed keys
+	var dbUpdateKeystores []string
+	if osutil.IsDirectory(dirs.SnapEFISignatureDbUpdatesDir) {
+		dbUpdateKeystores = []string{dirs.SnapEFISignatureDbUpdatesDir}
+	}
+	// boot assets may be signed by keys enrolled in shim by the owner
+	mokEnrolled := secbootMachineOwnerKeysEnrolled()
+
+	// the chains of the recovery systems whose seed snaps are measured
+	// cannot share the parameters of the other chains of the model, nor
+	// can the chains of unified kernel images
+	type modelParamsKey struct {
+		model              *asserts.Model
+		seedVerity         string
+		dataIntegrity      bool
+		unifiedKernelImage bool
+	}
+	modelToParams := map[modelParamsKey]*secboot.SealKeyModelParams{}
 	modelParams := make([]*secboot.SealKeyModelParams, 0, len(pbc))
 
 	for _, bc := range pbc {
-		loadChains, err := bootAssetsToLoadChains(bc.AssetChain, bc.kernelBootFile, roleToBlName)
+		loadChains, err := bootAssetsToLoadChains(bc.AssetChain, bc.kernelBootFile, bc.UnifiedKernelImage, roleToBlName)
 		if err != nil {
 			return nil, fmt.Errorf("cannot build load chains with current boot assets: %s", err)
 		}
 
-		// group parameters by model, reuse an existing SealKeyModelParams
-		// if the model is the same.
-		if params, ok := modelToParams[bc.model]; ok {
+		key := modelParamsKey{model: bc.model, dataIntegrity: bc.DataIntegrity, unifiedKernelImage: bc.UnifiedKernelImage}
+		if len(bc.SeedVerityRootHashes) != 0 {
+			// the keys of the map are sorted when encoded
+			seedVerity, err := json.Marshal(bc.SeedVerityRootHashes)
+			if err != nil {
+				return nil, err
+			}
+			key.seedVerity = string(seedVerity)
+		}
+
+		// group parameters by model and measured seed, reuse an
+		// existing SealKeyModelParams if they are the same.
+		if params, ok := modelToParams[key]; ok {
 			params.KernelCmdlines = strutil.SortedListsUniqueMerge(params.KernelCmdlines, bc.KernelCmdlines)
 			params.EFILoadChains = append(params.EFILoadChains, loadChains...)
 		} else {
 			param := &secboot.SealKeyModelParams{
-				Model:          bc.model,
-				KernelCmdlines: bc.KernelCmdlines,
-				EFILoadChains:  loadChains,
+				Model:                         bc.model,
+				KernelCmdlines:                bc.KernelCmdlines,
+				EFILoadChains:                 loadChains,
+				EFISignatureDbUpdateKeystores: dbUpdateKeystores,
+				EFIMachineOwnerKeys:           mokEnrolled,
+				SeedVerityRootHashes:          bc.SeedVerityRootHashes,
+				DataIntegrity:                 bc.DataIntegrity,
 			}
 			modelParams = append(modelParams, param)
-			modelToParams[bc.model] = param
+			modelToParams[key] = param
 		}
 	}
 
@@ -538,12 +1372,31 @@ func sealKeyModelParams(pbc predictableBootChains, roleToBlName map[bootloader.R
 // A hint expectReseal can be provided, it is used when the matching
 // is ambigous because the boot chains contain unrevisioned kernels.
 func isResealNeeded(pbc predictableBootChains, bootChainsFile string, expectReseal bool) (ok bool, nextCount int, err error) {
-	previousPbc, c, err := readBootChains(bootChainsFile)
+	previous, err := readBootChainsFile(bootChainsFile)
 	if err != nil {
 		return false, 0, err
 	}
+	c := previous.ResealCount
+
+	// the machine owner key state, which changes when keys are enrolled
+	// with mokutil, is measured in the sealed PCR profile as well
+	mokState, err := secbootMachineOwnerKeyState()
+	if err != nil {
+		return false, 0, fmt.Errorf("cannot read machine owner key state: %v", err)
+	}
+	if mokState != previous.MachineOwnerKeys {
+		return true, c + 1, nil
+	}
+	// so are the staged EFI signature database updates
+	dbUpdatesState, err := efiSignatureDbUpdatesState()
+	if err != nil {
+		return false, 0, fmt.Errorf("cannot read staged EFI signature database updates: %v", err)
+	}
+	if dbUpdatesState != previous.EFISignatureDbUpdates {
+		return true, c + 1, nil
+	}
 
-	switch predictableBootChainsEqualForReseal(pbc, previousPbc) {
+	switch predictableBootChainsEqualForReseal(pbc, previous.BootChains) {
 	case bootChainEquivalent:
 		return false, c + 1, nil
 	case bootChainUnrevisioned:
@@ -552,3 +1405,13 @@ func isResealNeeded(pbc predictableBootChains, bootChainsFile string, expectRese
 	}
 	return true, c + 1, nil
 }
+
+// SealedKeyFiles returns the paths of the run and fallback sealed key files
+// of the system.
+func SealedKeyFiles() []string {
+	return []string{
+		filepath.Join(InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+		filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
+		filepath.Join(InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/seed"
 	"github.com/snapcore/snapd/snap"
@@ -49,6 +50,14 @@ var _ = Suite(&sealSuite{})
 
 func (s *sealSuite) SetUpTest(c *C) {
 	s.BaseTest.SetUpTest(c)
+	s.AddCleanup(boot.MockSecbootMachineOwnerKeysEnrolled(func() bool { return false }))
+	s.AddCleanup(boot.MockSecbootMachineOwnerKeyState(func() (string, error) { return "", nil }))
+	s.AddCleanup(boot.MockSecbootPCRSelection(func(modelParams []*secboot.SealKeyModelParams) ([]int, error) {
+		return []int{4, 7, 12}, nil
+	}))
+	s.AddCleanup(boot.MockKernelIsUnifiedKernelImage(func(bootloader.BootFile) (bool, error) {
+		return false, nil
+	}))
 
 	rootdir := c.MkDir()
 	dirs.SetRootDir(rootdir)
@@ -57,10 +66,14 @@ func (s *sealSuite) SetUpTest(c *C) {
 
 func (s *sealSuite) TestSealKeyToModeenv(c *C) {
 	for _, tc := range []struct {
-		sealErr error
-		err     string
+		sealErr      error
+		factoryReset bool
+		keyProtector string
+		err          string
 	}{
 		{sealErr: nil, err: ""},
+		{sealErr: nil, factoryReset: true, err: ""},
+		{sealErr: nil, keyProtector: "optee", err: ""},
 		{sealErr: errors.New("seal error"), err: "cannot seal the encryption keys: seal error"},
 	} {
 		rootdir := c.MkDir()
@@ -141,6 +154,11 @@ func (s *sealSuite) TestSealKeyToModeenv(c *C) {
 				c.Errorf("unexpected additional call to secboot.SealKeys (call # %d)", sealKeysCalls)
 			}
 			c.Assert(params.ModelParams, HasLen, 1)
+			// the TPM is cleared only when provisioning it for the run
+			// object during a factory reset
+			c.Check(params.TPMClear, Equals, tc.factoryReset && sealKeysCalls == 1)
+			// other key protectors are bound to the same boot chains
+			c.Check(params.KeyProtector, Equals, tc.keyProtector)
 			for _, d := range []string{boot.InitramfsSeedEncryptionKeyDir, boot.InstallHostFDEDataDir} {
 				ex, isdir, _ := osutil.DirExists(d)
 				c.Check(ex && isdir, Equals, true, Commentf("location %q does not exist or is not a directory", d))
@@ -174,6 +192,7 @@ func (s *sealSuite) TestSealKeyToModeenv(c *C) {
 							secboot.NewLoadChain(kernel))),
 				})
 				c.Assert(params.ModelParams[0].KernelCmdlines, DeepEquals, []string{
+					"snapd_recovery_mode=factory-reset snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
 					"snapd_recovery_mode=recover snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
 				})
 			default:
@@ -185,7 +204,10 @@ func (s *sealSuite) TestSealKeyToModeenv(c *C) {
 		})
 		defer restore()
 
-		err = boot.SealKeyToModeenv(myKey, myKey2, model, modeenv)
+		err = boot.SealKeyToModeenv(myKey, myKey2, nil, model, modeenv, boot.SealKeyToModeenvFlags{
+			FactoryReset: tc.factoryReset,
+			KeyProtector: tc.keyProtector,
+		})
 		if tc.sealErr != nil {
 			c.Assert(sealKeysCalls, Equals, 1)
 		} else {
@@ -281,20 +303,381 @@ func (s *sealSuite) TestSealKeyToModeenv(c *C) {
 				Kernel:         "pc-kernel",
 				KernelRevision: "1",
 				KernelCmdlines: []string{
+					"snapd_recovery_mode=factory-reset snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
 					"snapd_recovery_mode=recover snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
 				},
 			},
 		})
 
+		// the sealed keys are described along the boot chains
+		wrapped, err := boot.ReadBootChainsFile(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "boot-chains"))
+		c.Assert(err, IsNil)
+		c.Check(wrapped.Version, Equals, 2)
+		// with the key protector actually used
+		expectedKeyProtector := tc.keyProtector
+		if expectedKeyProtector == "" {
+			expectedKeyProtector = "tpm2"
+		}
+		c.Check(wrapped.KeyProtector, Equals, expectedKeyProtector)
+		c.Check(wrapped.Volumes, DeepEquals, []*boot.BootChainsVolume{
+			{
+				Name:          "ubuntu-data",
+				SealedKeyFile: filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+				PCRProfile: &boot.BootChainsPCRProfile{
+					PCRs:                []int{4, 7, 12},
+					PolicyCounterHandle: secboot.RunObjectPCRPolicyCounterHandle,
+				},
+			},
+		})
+		wrapped, err = boot.ReadBootChainsFile(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "recovery-boot-chains"))
+		c.Assert(err, IsNil)
+		c.Check(wrapped.Version, Equals, 2)
+		c.Check(wrapped.KeyProtector, Equals, expectedKeyProtector)
+		fallbackProfile := &boot.BootChainsPCRProfile{
+			PCRs:                []int{4, 7, 12},
+			PolicyCounterHandle: secboot.FallbackObjectPCRPolicyCounterHandle,
+		}
+		c.Check(wrapped.Volumes, DeepEquals, []*boot.BootChainsVolume{
+			{
+				Name:          "ubuntu-data",
+				SealedKeyFile: filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
+				PCRProfile:    fallbackProfile,
+			}, {
+				Name:          "ubuntu-save",
+				SealedKeyFile: filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+				PCRProfile:    fallbackProfile,
+			},
+		})
+
 		// marker
 		c.Check(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "sealed-keys"), testutil.FilePresent)
 
 	}
 }
 
+func (s *sealSuite) TestSealKeysWithProtector(c *C) {
+	myKey := secboot.EncryptionKey{}
+	myKey2 := secboot.EncryptionKey{}
+	for i := range myKey {
+		myKey[i] = byte(i)
+		myKey2[i] = byte(128 + i)
+	}
+
+	sealKeysCalls := 0
+	restore := boot.MockSecbootSealKeys(func(keys []secboot.SealKeyRequest, params *secboot.SealKeysParams) error {
+		sealKeysCalls++
+		c.Check(params, DeepEquals, &secboot.SealKeysParams{KeyProtector: "factory"})
+		c.Check(keys, DeepEquals, []secboot.SealKeyRequest{
+			{Key: myKey, KeyFile: filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key")},
+			{Key: myKey, KeyFile: filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key")},
+			{Key: myKey2, KeyFile: filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key")},
+		})
+		return nil
+	})
+	defer restore()
+
+	err := boot.SealKeysWithProtector("factory", myKey, myKey2)
+	c.Assert(err, IsNil)
+	c.Check(sealKeysCalls, Equals, 1)
+	c.Check(boot.InitramfsBootEncryptionKeyDir, testutil.FilePresent)
+	c.Check(boot.InitramfsSeedEncryptionKeyDir, testutil.FilePresent)
+	// nothing to reseal later
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "sealed-keys"), testutil.FileAbsent)
+
+	restore = boot.MockSecbootSealKeys(func(keys []secboot.SealKeyRequest, params *secboot.SealKeysParams) error {
+		return errors.New("seal error")
+	})
+	defer restore()
+	err = boot.SealKeysWithProtector("factory", myKey, myKey2)
+	c.Assert(err, ErrorMatches, `cannot seal the encryption keys with "factory": seal error`)
+}
+
+func (s *sealSuite) TestInitramfsEnableFactoryKeys(c *C) {
+	defer secboot.UnregisterKeyProtector(secboot.FactoryKeyProtectorName)
+
+	c.Check(boot.InitramfsEnableFactoryKeys(), Equals, false)
+	_, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Check(err, ErrorMatches, `unknown key protector "factory"`)
+
+	c.Assert(os.MkdirAll(boot.InitramfsBootEncryptionKeyDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(boot.InitramfsBootEncryptionKeyDir, "factory-keys"), nil, 0644), IsNil)
+	c.Check(boot.InitramfsEnableFactoryKeys(), Equals, true)
+	_, err = secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Check(err, IsNil)
+}
+
+func (s *sealSuite) TestInitramfsDataIntegrityRequired(c *C) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	chains := []boot.BootChain{{Model: "run"}, {Model: "recover"}}
+	c.Check(boot.InitramfsDataIntegrityRequired(), Equals, false)
+	chains = boot.WithDataIntegrity(chains, boot.InstallHostWritableDir)
+	c.Check(chains, DeepEquals, []boot.BootChain{{Model: "run"}, {Model: "recover"}})
+
+	c.Assert(boot.RecordDataIntegrity(boot.InstallHostWritableDir), IsNil)
+	c.Check(boot.InitramfsDataIntegrityRequired(), Equals, true)
+	chains = boot.WithDataIntegrity(chains, boot.InstallHostWritableDir)
+	c.Check(chains, DeepEquals, []boot.BootChain{
+		{Model: "run", DataIntegrity: true},
+		{Model: "recover", DataIntegrity: true},
+	})
+
+	// the keys stay bound to the requirement even if the marker on
+	// ubuntu-boot is removed
+	c.Assert(os.Remove(filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.integrity")), IsNil)
+	c.Check(boot.InitramfsDataIntegrityRequired(), Equals, false)
+	chains = boot.WithDataIntegrity([]boot.BootChain{{Model: "run"}}, boot.InstallHostWritableDir)
+	c.Check(chains, DeepEquals, []boot.BootChain{{Model: "run", DataIntegrity: true}})
+}
+
+func (s *sealSuite) TestWithSealedKeyFilesAside(c *C) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	keyFiles := boot.SealedKeyFiles()
+	// only the keys of ubuntu-seed are left by a factory reset
+	present := keyFiles[1:]
+	writeOld := func() {
+		for _, keyFile := range present {
+			c.Assert(os.MkdirAll(filepath.Dir(keyFile), 0755), IsNil)
+			c.Assert(ioutil.WriteFile(keyFile, []byte("old"), 0600), IsNil)
+		}
+	}
+	writeOld()
+
+	err := boot.WithSealedKeyFilesAside(".old", func() error {
+		for _, keyFile := range keyFiles {
+			c.Check(keyFile, testutil.FileAbsent)
+		}
+		for _, keyFile := range present {
+			c.Check(keyFile+".old", testutil.FileEquals, "old")
+		}
+		for _, keyFile := range keyFiles {
+			c.Assert(os.MkdirAll(filepath.Dir(keyFile), 0755), IsNil)
+			c.Assert(ioutil.WriteFile(keyFile, []byte("new"), 0600), IsNil)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	for _, keyFile := range keyFiles {
+		c.Check(keyFile, testutil.FileEquals, "new")
+		c.Check(keyFile+".old", testutil.FileAbsent)
+	}
+
+	// the previous keys are restored when sealing fails
+	writeOld()
+	err = boot.WithSealedKeyFilesAside(".old", func() error {
+		return errors.New("cannot seal")
+	})
+	c.Assert(err, ErrorMatches, "cannot seal")
+	for _, keyFile := range present {
+		c.Check(keyFile, testutil.FileEquals, "old")
+		c.Check(keyFile+".old", testutil.FileAbsent)
+	}
+}
+
+func (s *sealSuite) TestStoreAndSealFactoryKeys(c *C) {
+	s.testStoreAndSealFactoryKeys(c, nil)
+}
+
+func (s *sealSuite) TestStoreAndSealFactoryKeysSealError(c *C) {
+	s.testStoreAndSealFactoryKeys(c, errors.New("seal error"))
+}
+
+func (s *sealSuite) testStoreAndSealFactoryKeys(c *C, sealErr error) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-seed")), IsNil)
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-boot")), IsNil)
+
+	modeenv := &boot.Modeenv{
+		Mode:           "run",
+		RecoverySystem: "20200825",
+		CurrentTrustedRecoveryBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"grub-hash-1"},
+			"bootx64.efi": []string{"shim-hash-1"},
+		},
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"run-grub-hash-1"},
+		},
+		CurrentKernels: []string{"pc-kernel_500.snap"},
+	}
+	c.Assert(modeenv.WriteTo(""), IsNil)
+
+	assetsDir := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub")
+	c.Assert(os.MkdirAll(assetsDir, 0755), IsNil)
+	for _, name := range []string{"bootx64.efi-shim-hash-1", "grubx64.efi-grub-hash-1", "grubx64.efi-run-grub-hash-1"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(assetsDir, name), nil, 0644), IsNil)
+	}
+
+	model := boottest.MakeMockUC20Model()
+	restore := boot.MockSeedReadSystemEssential(func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error) {
+		kernelSnap := &seed.Snap{
+			Path:     "/var/lib/snapd/seed/snaps/pc-kernel_1.snap",
+			SideInfo: &snap.SideInfo{RealName: "pc-kernel", Revision: snap.R(1)},
+		}
+		return model, []*seed.Snap{kernelSnap}, nil
+	})
+	defer restore()
+
+	myKey := secboot.EncryptionKey{}
+	myKey2 := secboot.EncryptionKey{}
+	newKey := secboot.EncryptionKey{}
+	newKey2 := secboot.EncryptionKey{}
+	for i := range myKey {
+		myKey[i] = byte(i)
+		myKey2[i] = byte(128 + i)
+		newKey[i] = byte(64 + i)
+		newKey2[i] = byte(192 + i)
+	}
+
+	restore = disks.MockMountPointDisksToPartitionMapping(map[disks.Mountpoint]*disks.MockDiskMapping{
+		{Mountpoint: boot.InitramfsUbuntuSeedDir}: {
+			FilesystemLabelToPartUUID: map[string]string{
+				"ubuntu-seed":     "ubuntu-seed-partuuid",
+				"ubuntu-boot":     "ubuntu-boot-partuuid",
+				"ubuntu-data-enc": "ubuntu-data-enc-partuuid",
+				"ubuntu-save-enc": "ubuntu-save-enc-partuuid",
+			},
+		},
+	})
+	defer restore()
+	dataNode := "/dev/disk/by-partuuid/ubuntu-data-enc-partuuid"
+	saveNode := "/dev/disk/by-partuuid/ubuntu-save-enc-partuuid"
+	newKeys := []secboot.EncryptionKey{newKey, newKey2}
+	var keyOps []string
+	restore = boot.MockSecbootFactoryKeyRotation(func() (secboot.EncryptionKey, error) {
+		k := newKeys[0]
+		newKeys = newKeys[1:]
+		return k, nil
+	}, func(node string, key []byte, newKey secboot.EncryptionKey) error {
+		keyOps = append(keyOps, fmt.Sprintf("add %s %x %x", node, key[:2], newKey[:2]))
+		return nil
+	}, func(node string, key []byte) error {
+		keyOps = append(keyOps, fmt.Sprintf("remove %s %x", node, key[:2]))
+		return nil
+	})
+	defer restore()
+	defer secboot.UnregisterKeyProtector(secboot.FactoryKeyProtectorName)
+
+	// in install mode the keys are stored unprotected
+	c.Assert(boot.StoreFactoryKeys(myKey, myKey2), IsNil)
+	factoryMarker := filepath.Join(boot.InitramfsBootEncryptionKeyDir, "factory-keys")
+	c.Check(factoryMarker, testutil.FilePresent)
+	dataKeyFile := filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key")
+	recoveryDataKeyFile := filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key")
+	saveKeyFile := filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key")
+	p, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Assert(err, IsNil)
+	for _, keyFile := range []string{dataKeyFile, recoveryDataKeyFile, saveKeyFile} {
+		c.Check(p.IsSealedKey(keyFile), Equals, true)
+	}
+	installStamp := filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "factory-keys")
+	c.Check(installStamp, testutil.FilePresent)
+	// nothing to reseal
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(boot.InstallHostWritableDir), "sealed-keys"), testutil.FileAbsent)
+
+	// the stamp is found in the root of the run system
+	runStamp := filepath.Join(dirs.SnapFDEDir, "factory-keys")
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	c.Assert(os.Rename(installStamp, runStamp), IsNil)
+	c.Assert(myKey2.Save(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key")), IsNil)
+
+	tpmOpts := boot.TPMOptions{
+		SRKTemplate:       secboot.SRKTemplateECCP256,
+		WrapPolicyAuthKey: true,
+		EKCertFile:        "/run/mnt/ubuntu-seed/device/fde/tpm-ek-cert",
+	}
+	sealKeysCalls := 0
+	restore = boot.MockSecbootSealKeys(func(keys []secboot.SealKeyRequest, params *secboot.SealKeysParams) error {
+		sealKeysCalls++
+		switch sealKeysCalls {
+		case 1:
+			c.Check(keys, DeepEquals, []secboot.SealKeyRequest{{Key: newKey, KeyFile: dataKeyFile}})
+			c.Check(params.TPMProvision, Equals, true)
+			c.Check(params.TPMPolicyAuthKeyFile, Equals, filepath.Join(dirs.SnapSaveFDEDirUnder(rootdir), "tpm-policy-auth-key"))
+			c.Check(params.TPMWrapPolicyAuthKey, Equals, true)
+			c.Check(params.TPMLockoutAuthFile, Equals, filepath.Join(dirs.SnapSaveFDEDirUnder(rootdir), "tpm-lockout-auth"))
+			c.Check(params.TPMSRKTemplate, Equals, secboot.SRKTemplateECCP256)
+			c.Check(params.TPMSRKHandle, Equals, uint32(0))
+			c.Check(params.TPMEKCertFile, Equals, "/run/mnt/ubuntu-seed/device/fde/tpm-ek-cert")
+		case 2:
+			c.Check(keys, DeepEquals, []secboot.SealKeyRequest{{Key: newKey, KeyFile: recoveryDataKeyFile}, {Key: newKey2, KeyFile: saveKeyFile}})
+			c.Check(params.TPMEKCertFile, Equals, "/run/mnt/ubuntu-seed/device/fde/tpm-ek-cert")
+		}
+		// the new keys were added to the volumes
+		c.Check(keyOps, DeepEquals, []string{
+			"add " + dataNode + " 0001 4041",
+			"add " + saveNode + " 8081 c0c1",
+		})
+		c.Check(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key"), testutil.FileEquals, newKey2[:])
+		// the factory key files were moved out of the way
+		for _, k := range keys {
+			c.Check(k.KeyFile, testutil.FileAbsent)
+		}
+		if sealErr != nil {
+			return sealErr
+		}
+		for _, k := range keys {
+			c.Assert(ioutil.WriteFile(k.KeyFile, []byte("USK$sealed"), 0600), IsNil)
+		}
+		return nil
+	})
+	defer restore()
+
+	err = boot.SealFactoryKeys(model, tpmOpts)
+	if sealErr != nil {
+		c.Assert(err, ErrorMatches, "cannot seal the encryption keys: seal error")
+		c.Check(sealKeysCalls, Equals, 1)
+		// the factory keys are still usable
+		for _, keyFile := range []string{dataKeyFile, recoveryDataKeyFile, saveKeyFile} {
+			c.Check(p.IsSealedKey(keyFile), Equals, true)
+		}
+		c.Check(runStamp, testutil.FilePresent)
+		c.Check(factoryMarker, testutil.FilePresent)
+		// and so are the volumes, without the new keys
+		c.Check(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key"), testutil.FileEquals, myKey2[:])
+		c.Check(keyOps, DeepEquals, []string{
+			"add " + dataNode + " 0001 4041",
+			"add " + saveNode + " 8081 c0c1",
+			"remove " + dataNode + " 4041",
+			"remove " + saveNode + " c0c1",
+		})
+		return
+	}
+	c.Assert(err, IsNil)
+	c.Check(sealKeysCalls, Equals, 2)
+	for _, keyFile := range []string{dataKeyFile, recoveryDataKeyFile, saveKeyFile} {
+		c.Check(keyFile, testutil.FileEquals, "USK$sealed")
+		c.Check(keyFile+".factory", testutil.FileAbsent)
+	}
+	c.Check(runStamp, testutil.FileAbsent)
+	c.Check(factoryMarker, testutil.FileAbsent)
+	// the factory keys were replaced
+	c.Check(keyOps, DeepEquals, []string{
+		"add " + dataNode + " 0001 4041",
+		"add " + saveNode + " 8081 c0c1",
+		"remove " + dataNode + " 0001",
+		"remove " + saveNode + " 8081",
+	})
+	c.Check(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key"), testutil.FileEquals, newKey2[:])
+	c.Check(filepath.Join(dirs.SnapFDEDir, "sealed-keys"), testutil.FilePresent)
+	c.Check(filepath.Join(dirs.SnapFDEDir, "boot-chains"), testutil.FilePresent)
+	c.Check(filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"), testutil.FilePresent)
+
+	// and it can be done only once
+	err = boot.SealFactoryKeys(model, tpmOpts)
+	c.Assert(err, Equals, boot.ErrNoFactoryKeys)
+}
+
 // TODO:UC20: also test fallback reseal
 func (s *sealSuite) TestResealKeyToModeenv(c *C) {
-	var prevPbc boot.PredictableBootChains
+	var prevPbc, prevRecoveryPbc boot.PredictableBootChains
 
 	for _, tc := range []struct {
 		sealedKeys bool
@@ -339,7 +722,9 @@ func (s *sealSuite) TestResealKeyToModeenv(c *C) {
 		}
 
 		if tc.prevPbc {
-			err := boot.WriteBootChains(prevPbc, filepath.Join(dirs.SnapFDEDir, "boot-chains"), 9)
+			err := boot.WriteBootChains(prevPbc, filepath.Join(dirs.SnapFDEDir, "boot-chains"), 9, "tpm2", nil)
+			c.Assert(err, IsNil)
+			err = boot.WriteBootChains(prevRecoveryPbc, filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"), 9, "tpm2", nil)
 			c.Assert(err, IsNil)
 		}
 
@@ -393,6 +778,7 @@ func (s *sealSuite) TestResealKeyToModeenv(c *C) {
 				c.Assert(params.ModelParams[0].EFILoadChains, HasLen, 6)
 			case 2:
 				c.Assert(params.ModelParams[0].KernelCmdlines, DeepEquals, []string{
+					"snapd_recovery_mode=factory-reset snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
 					"snapd_recovery_mode=recover snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
 				})
 				// load chains
@@ -577,7 +963,443 @@ func (s *sealSuite) TestResealKeyToModeenv(c *C) {
 			},
 		})
 		prevPbc = pbc
+		prevRecoveryPbc, _, err = boot.ReadBootChains(filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"))
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *sealSuite) TestResealKeyToModeenvFallbackSealedForRecoverySystems(c *C) {
+	rootdir := dirs.GlobalRootDir
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	err := ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "sealed-keys"), nil, 0644)
+	c.Assert(err, IsNil)
+
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-seed")), IsNil)
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-boot")), IsNil)
+
+	modeenv := &boot.Modeenv{
+		// 20201225 is being tried, the fallback key is not sealed for
+		// it yet
+		CurrentRecoverySystems:   []string{"20200825", "20201225"},
+		SealedForRecoverySystems: []string{"20200825"},
+		CurrentTrustedRecoveryBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"grub-hash-1"},
+			"bootx64.efi": []string{"shim-hash-1"},
+		},
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"run-grub-hash-1"},
+		},
+		CurrentKernels: []string{"pc-kernel_500.snap"},
+	}
+
+	// mock asset cache
+	for _, name := range []string{"bootx64.efi-shim-hash-1", "grubx64.efi-grub-hash-1", "grubx64.efi-run-grub-hash-1"} {
+		p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub", name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+	}
+
+	model := boottest.MakeMockUC20Model()
+
+	var readSystems []string
+	restore := boot.MockSeedReadSystemEssential(func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error) {
+		readSystems = append(readSystems, label)
+		kernelSnap := &seed.Snap{
+			Path: "/var/lib/snapd/seed/snaps/pc-kernel_1.snap",
+			SideInfo: &snap.SideInfo{
+				RealName: "pc-kernel",
+				Revision: snap.Revision{N: 1},
+			},
+		}
+		return model, []*seed.Snap{kernelSnap}, nil
+	})
+	defer restore()
+
+	resealKeysCalls := 0
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		resealKeysCalls++
+		c.Assert(params.ModelParams, HasLen, 1)
+		switch resealKeysCalls {
+		case 1:
+			// the run key can boot all current recovery systems
+			c.Check(params.ModelParams[0].KernelCmdlines, DeepEquals, []string{
+				"snapd_recovery_mode=recover snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
+				"snapd_recovery_mode=recover snapd_recovery_system=20201225 console=ttyS0 console=tty1 panic=-1",
+				"snapd_recovery_mode=run console=ttyS0 console=tty1 panic=-1",
+			})
+		case 2:
+			// the fallback key only the ones it is sealed for
+			c.Check(params.ModelParams[0].KernelCmdlines, DeepEquals, []string{
+				"snapd_recovery_mode=factory-reset snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
+				"snapd_recovery_mode=recover snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
+			})
+		default:
+			c.Errorf("unexpected additional call to secboot.ResealKeys (call # %d)", resealKeysCalls)
+		}
+		return nil
+	})
+	defer restore()
+
+	const expectReseal = false
+	err = boot.ResealKeyToModeenv(rootdir, model, modeenv, expectReseal)
+	c.Assert(err, IsNil)
+	c.Check(resealKeysCalls, Equals, 2)
+	c.Check(readSystems, DeepEquals, []string{"20200825", "20201225", "20200825"})
+}
+
+func (s *sealSuite) TestResealKeyToModeenvFallbackOtherKeyProtector(c *C) {
+	rootdir := dirs.GlobalRootDir
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	err := ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "sealed-keys"), nil, 0644)
+	c.Assert(err, IsNil)
+
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-seed")), IsNil)
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-boot")), IsNil)
+
+	modeenv := &boot.Modeenv{
+		CurrentRecoverySystems: []string{"20200825"},
+		CurrentTrustedRecoveryBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"grub-hash-1"},
+			"bootx64.efi": []string{"shim-hash-1"},
+		},
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"run-grub-hash-1"},
+		},
+		CurrentKernels: []string{"pc-kernel_500.snap"},
+	}
+
+	// mock asset cache
+	for _, name := range []string{"bootx64.efi-shim-hash-1", "grubx64.efi-grub-hash-1", "grubx64.efi-run-grub-hash-1"} {
+		p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub", name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+	}
+
+	model := boottest.MakeMockUC20Model()
+	restore := boot.MockSeedReadSystemEssential(func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error) {
+		kernelSnap := &seed.Snap{
+			Path: "/var/lib/snapd/seed/snaps/pc-kernel_1.snap",
+			SideInfo: &snap.SideInfo{
+				RealName: "pc-kernel",
+				Revision: snap.Revision{N: 1},
+			},
+		}
+		return model, []*seed.Snap{kernelSnap}, nil
+	})
+	defer restore()
+
+	restore = boot.MockSecbootSealedKeyProtectorName(func(keyFile string) string {
+		return "optee"
+	})
+	defer restore()
+
+	dataKey := secboot.EncryptionKey{}
+	saveKey := secboot.EncryptionKey{}
+	for i := range dataKey {
+		dataKey[i] = byte(i)
+		saveKey[i] = byte(128 + i)
 	}
+	restore = boot.MockSecbootUnsealKey(func(keyFile string) ([]byte, error) {
+		c.Check(keyFile, Equals, filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"))
+		return dataKey[:], nil
+	})
+	defer restore()
+
+	resealKeysCalls := 0
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		resealKeysCalls++
+		switch resealKeysCalls {
+		case 1:
+			// the run object is unsealed by the key protector
+			c.Check(params.Keys, IsNil)
+		case 2:
+			// but not the fallback object
+			c.Check(params.KeyFiles, DeepEquals, []string{
+				filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
+				filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+			})
+			c.Check(params.Keys, DeepEquals, []secboot.EncryptionKey{dataKey, saveKey})
+		default:
+			c.Errorf("unexpected additional call to secboot.ResealKeys (call # %d)", resealKeysCalls)
+		}
+		return nil
+	})
+	defer restore()
+
+	// the ubuntu-save key is needed
+	const expectReseal = false
+	err = boot.ResealKeyToModeenv(rootdir, model, modeenv, expectReseal)
+	c.Assert(err, ErrorMatches, `cannot reseal the fallback encryption keys: open .*/ubuntu-save.key: no such file or directory`)
+	c.Check(resealKeysCalls, Equals, 1)
+
+	c.Assert(saveKey.Save(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key")), IsNil)
+	// start over
+	c.Assert(os.Remove(filepath.Join(dirs.SnapFDEDir, "boot-chains")), IsNil)
+	resealKeysCalls = 0
+	err = boot.ResealKeyToModeenv(rootdir, model, modeenv, expectReseal)
+	c.Assert(err, IsNil)
+	c.Check(resealKeysCalls, Equals, 2)
+}
+
+func (s *sealSuite) TestResealKeyToModeenvExtraVolumes(c *C) {
+	rootdir := dirs.GlobalRootDir
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	err := ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "sealed-keys"), nil, 0644)
+	c.Assert(err, IsNil)
+
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-seed")), IsNil)
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-boot")), IsNil)
+
+	// the key of an extra volume was sealed in the run object at install
+	err = secboot.WriteExtraVolumes(dirs.SnapFDEDir, []secboot.ExtraVolume{
+		{Name: "vendor-data", MountPoint: "/run/mnt/vendor-data"},
+	})
+	c.Assert(err, IsNil)
+
+	modeenv := &boot.Modeenv{
+		CurrentRecoverySystems: []string{"20200825"},
+		CurrentTrustedRecoveryBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"grub-hash-1"},
+			"bootx64.efi": []string{"shim-hash-1"},
+		},
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"run-grub-hash-1"},
+		},
+		CurrentKernels: []string{"pc-kernel_500.snap"},
+	}
+
+	// mock asset cache
+	for _, name := range []string{"bootx64.efi-shim-hash-1", "grubx64.efi-grub-hash-1", "grubx64.efi-run-grub-hash-1"} {
+		p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub", name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+	}
+
+	model := boottest.MakeMockUC20Model()
+
+	restore := boot.MockSeedReadSystemEssential(func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error) {
+		kernelSnap := &seed.Snap{
+			Path: "/var/lib/snapd/seed/snaps/pc-kernel_1.snap",
+			SideInfo: &snap.SideInfo{
+				RealName: "pc-kernel",
+				Revision: snap.Revision{N: 1},
+			},
+		}
+		return model, []*seed.Snap{kernelSnap}, nil
+	})
+	defer restore()
+
+	var keyFiles [][]string
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		keyFiles = append(keyFiles, params.KeyFiles)
+		return nil
+	})
+	defer restore()
+	restore = boot.MockSecbootSealedKeyProtectorName(func(keyFile string) string {
+		// only the run object is sealed with another key protector
+		if filepath.Dir(keyFile) == boot.InitramfsBootEncryptionKeyDir {
+			return "optee"
+		}
+		return ""
+	})
+	defer restore()
+	restore = boot.MockSecbootPCRSelection(func(modelParams []*secboot.SealKeyModelParams) ([]int, error) {
+		return []int{4, 7, 11, 12}, nil
+	})
+	defer restore()
+
+	const expectReseal = false
+	err = boot.ResealKeyToModeenv(rootdir, model, modeenv, expectReseal)
+	c.Assert(err, IsNil)
+	c.Check(keyFiles, DeepEquals, [][]string{
+		// the run object holds the key of the extra volume
+		{
+			filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+			filepath.Join(boot.InitramfsBootEncryptionKeyDir, "vendor-data.sealed-key"),
+		},
+		{
+			filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
+			filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+		},
+	})
+
+	// the boot chains describe the resealed keys
+	wrapped, err := boot.ReadBootChainsFile(filepath.Join(dirs.SnapFDEDir, "boot-chains"))
+	c.Assert(err, IsNil)
+	c.Check(wrapped.KeyProtector, Equals, "optee")
+	profile := &boot.BootChainsPCRProfile{
+		PCRs:                []int{4, 7, 11, 12},
+		PolicyCounterHandle: secboot.RunObjectPCRPolicyCounterHandle,
+	}
+	c.Check(wrapped.Volumes, DeepEquals, []*boot.BootChainsVolume{
+		{
+			Name:          "ubuntu-data",
+			SealedKeyFile: filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key"),
+			PCRProfile:    profile,
+		}, {
+			Name:          "vendor-data",
+			SealedKeyFile: filepath.Join(boot.InitramfsBootEncryptionKeyDir, "vendor-data.sealed-key"),
+			PCRProfile:    profile,
+		},
+	})
+	wrapped, err = boot.ReadBootChainsFile(filepath.Join(dirs.SnapFDEDir, "recovery-boot-chains"))
+	c.Assert(err, IsNil)
+	c.Check(wrapped.KeyProtector, Equals, "tpm2")
+}
+
+func (s *sealSuite) TestMarkRecoverySystemSealedFor(c *C) {
+	m := &boot.Modeenv{
+		Mode:                   "run",
+		RecoverySystem:         "20200825",
+		CurrentRecoverySystems: []string{"20200825", "20201225"},
+	}
+	c.Assert(m.WriteTo(""), IsNil)
+
+	// no sealed keys, the keys are not resealed
+	dev := boottest.MockUC20Device("", boottest.MakeMockUC20Model())
+	err := boot.MarkRecoverySystemSealedFor(dev, "20210101")
+	c.Assert(err, IsNil)
+
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentRecoverySystems, DeepEquals, []string{"20200825", "20201225", "20210101"})
+	// the systems the fallback key was implicitly sealed for are kept
+	c.Check(m2.SealedForRecoverySystems, DeepEquals, []string{"20200825", "20201225", "20210101"})
+
+	// marking again is a noop
+	err = boot.MarkRecoverySystemSealedFor(dev, "20210101")
+	c.Assert(err, IsNil)
+	m2, err = boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.SealedForRecoverySystems, DeepEquals, []string{"20200825", "20201225", "20210101"})
+
+	err = boot.UnmarkRecoverySystemSealedFor(dev, "20201225")
+	c.Assert(err, IsNil)
+	m2, err = boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	// still bootable with the run key
+	c.Check(m2.CurrentRecoverySystems, DeepEquals, []string{"20200825", "20201225", "20210101"})
+	c.Check(m2.SealedForRecoverySystems, DeepEquals, []string{"20200825", "20210101"})
+
+	// not sealed for, nothing to do
+	err = boot.UnmarkRecoverySystemSealedFor(dev, "20201225")
+	c.Assert(err, IsNil)
+}
+
+func (s *sealSuite) TestUnmarkRecoverySystemSealedForReseals(c *C) {
+	rootdir := dirs.GlobalRootDir
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	err := ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "sealed-keys"), nil, 0644)
+	c.Assert(err, IsNil)
+
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-seed")), IsNil)
+	c.Assert(createMockGrubCfg(filepath.Join(rootdir, "run/mnt/ubuntu-boot")), IsNil)
+
+	m := &boot.Modeenv{
+		Mode:                     "run",
+		RecoverySystem:           "20200825",
+		CurrentRecoverySystems:   []string{"20200825", "20201225"},
+		SealedForRecoverySystems: []string{"20200825", "20201225"},
+		CurrentTrustedRecoveryBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"grub-hash-1"},
+			"bootx64.efi": []string{"shim-hash-1"},
+		},
+		CurrentTrustedBootAssets: boot.BootAssetsMap{
+			"grubx64.efi": []string{"run-grub-hash-1"},
+		},
+		CurrentKernels: []string{"pc-kernel_500.snap"},
+	}
+	c.Assert(m.WriteTo(""), IsNil)
+
+	// mock asset cache
+	for _, name := range []string{"bootx64.efi-shim-hash-1", "grubx64.efi-grub-hash-1", "grubx64.efi-run-grub-hash-1"} {
+		p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub", name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+	}
+
+	model := boottest.MakeMockUC20Model()
+	restore := boot.MockSeedReadSystemEssential(func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error) {
+		kernelSnap := &seed.Snap{
+			Path: "/var/lib/snapd/seed/snaps/pc-kernel_1.snap",
+			SideInfo: &snap.SideInfo{
+				RealName: "pc-kernel",
+				Revision: snap.Revision{N: 1},
+			},
+		}
+		return model, []*seed.Snap{kernelSnap}, nil
+	})
+	defer restore()
+
+	var resealedKeyFiles [][]string
+	var cmdlines [][]string
+	restore = boot.MockSecbootResealKeys(func(params *secboot.ResealKeysParams) error {
+		c.Assert(params.ModelParams, HasLen, 1)
+		resealedKeyFiles = append(resealedKeyFiles, params.KeyFiles)
+		cmdlines = append(cmdlines, params.ModelParams[0].KernelCmdlines)
+		return nil
+	})
+	defer restore()
+
+	// both objects are sealed to the current boot chains
+	const expectReseal = false
+	err = boot.ResealKeyToModeenv(rootdir, model, m, expectReseal)
+	c.Assert(err, IsNil)
+	c.Assert(resealedKeyFiles, HasLen, 2)
+	resealedKeyFiles = nil
+	cmdlines = nil
+
+	dev := boottest.MockUC20Device("", model)
+	err = boot.UnmarkRecoverySystemSealedFor(dev, "20201225")
+	c.Assert(err, IsNil)
+
+	// the run object boot chains are unchanged, but the fallback object
+	// is resealed regardless
+	c.Assert(resealedKeyFiles, HasLen, 1)
+	c.Check(resealedKeyFiles[0], DeepEquals, []string{
+		filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
+		filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key"),
+	})
+	c.Check(cmdlines[0], DeepEquals, []string{
+		"snapd_recovery_mode=factory-reset snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
+		"snapd_recovery_mode=recover snapd_recovery_system=20200825 console=ttyS0 console=tty1 panic=-1",
+	})
+}
+
+func (s *sealSuite) TestUnmarkRecoverySystemSealedForLast(c *C) {
+	m := &boot.Modeenv{
+		Mode:                     "run",
+		RecoverySystem:           "20200825",
+		CurrentRecoverySystems:   []string{"20200825", "20201225"},
+		SealedForRecoverySystems: []string{"20200825"},
+	}
+	c.Assert(m.WriteTo(""), IsNil)
+
+	dev := boottest.MockUC20Device("", boottest.MakeMockUC20Model())
+	err := boot.UnmarkRecoverySystemSealedFor(dev, "20200825")
+	c.Assert(err, ErrorMatches, `cannot unmark recovery system "20200825": the fallback key must be sealed for at least one recovery system`)
+}
+
+func (s *sealSuite) TestMarkRecoverySystemSealedForResealError(c *C) {
+	m := &boot.Modeenv{
+		Mode:                   "run",
+		RecoverySystem:         "20200825",
+		CurrentRecoverySystems: []string{"20200825"},
+	}
+	c.Assert(m.WriteTo(""), IsNil)
+	// sealed keys but no bootloader to build the boot chains with
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "sealed-keys"), nil, 0644), IsNil)
+
+	dev := boottest.MockUC20Device("", boottest.MakeMockUC20Model())
+	err := boot.MarkRecoverySystemSealedFor(dev, "20210101")
+	c.Assert(err, ErrorMatches, "cannot find the recovery bootloader: .*")
+
+	// the modeenv was restored
+	m2, err := boot.ReadModeenv("")
+	c.Assert(err, IsNil)
+	c.Check(m2.CurrentRecoverySystems, DeepEquals, []string{"20200825"})
+	c.Check(m2.SealedForRecoverySystems, HasLen, 0)
 }
 
 func (s *sealSuite) TestRecoveryBootChainsForSystems(c *C) {
@@ -673,7 +1495,7 @@ func (s *sealSuite) TestRecoveryBootChainsForSystems(c *C) {
 			CurrentTrustedRecoveryBootAssets: tc.assetsMap,
 		}
 
-		bc, err := boot.RecoveryBootChainsForSystems(tc.recoverySystems, tbl, model, modeenv)
+		bc, err := boot.RecoveryBootChainsForSystems(tc.recoverySystems, tbl, model, modeenv, nil, false)
 		if tc.err == "" {
 			c.Assert(err, IsNil)
 			c.Assert(bc, HasLen, len(tc.recoverySystems))
@@ -683,6 +1505,20 @@ func (s *sealSuite) TestRecoveryBootChainsForSystems(c *C) {
 				expectedKernelRev := tc.expectedKernelRevs[i]
 				c.Check(chain.KernelRevision, Equals, fmt.Sprintf("%d", expectedKernelRev))
 				c.Check(chain.KernelBootFile(), DeepEquals, bootloader.BootFile{Snap: fmt.Sprintf("/var/lib/snapd/seed/snaps/pc-kernel_%d.snap", expectedKernelRev), Path: "kernel.efi", Role: bootloader.RoleRecovery})
+				c.Check(chain.KernelCmdlines, DeepEquals, []string{
+					fmt.Sprintf("snapd_recovery_mode=recover snapd_recovery_system=%s console=ttyS0 console=tty1 panic=-1", tc.recoverySystems[i]),
+				})
+			}
+
+			// the factory reset command lines are included on request
+			bc, err = boot.RecoveryBootChainsForSystems(tc.recoverySystems, tbl, model, modeenv, nil, true)
+			c.Assert(err, IsNil)
+			c.Assert(bc, HasLen, len(tc.recoverySystems))
+			for i, chain := range bc {
+				c.Check(chain.KernelCmdlines, DeepEquals, []string{
+					fmt.Sprintf("snapd_recovery_mode=recover snapd_recovery_system=%s console=ttyS0 console=tty1 panic=-1", tc.recoverySystems[i]),
+					fmt.Sprintf("snapd_recovery_mode=factory-reset snapd_recovery_system=%s console=ttyS0 console=tty1 panic=-1", tc.recoverySystems[i]),
+				})
 			}
 		} else {
 			c.Assert(err, ErrorMatches, tc.err)
@@ -800,6 +1636,165 @@ func (s *sealSuite) TestSealKeyModelParams(c *C) {
 			secboot.NewLoadChain(loader1,
 				secboot.NewLoadChain(oldkbf))),
 	})
+	// no staged signature database updates nor machine owner keys
+	c.Check(params[0].EFISignatureDbUpdateKeystores, HasLen, 0)
+	c.Check(params[1].EFISignatureDbUpdateKeystores, HasLen, 0)
+	c.Check(params[0].EFIMachineOwnerKeys, Equals, false)
+	c.Check(params[1].EFIMachineOwnerKeys, Equals, false)
+
+	// with staged updates and machine owner keys
+	err = os.MkdirAll(dirs.SnapEFISignatureDbUpdatesDir, 0755)
+	c.Assert(err, IsNil)
+	restore := boot.MockSecbootMachineOwnerKeysEnrolled(func() bool { return true })
+	defer restore()
+	params, err = boot.SealKeyModelParams(pbc, roleToBlName)
+	c.Assert(err, IsNil)
+	c.Assert(params, HasLen, 2)
+	for _, p := range params {
+		c.Check(p.EFISignatureDbUpdateKeystores, DeepEquals, []string{
+			filepath.Join(rootdir, "var/lib/snapd/device/fde/efi-signature-db-updates"),
+		})
+		c.Check(p.EFIMachineOwnerKeys, Equals, true)
+	}
+}
+
+func (s *sealSuite) TestSealKeyModelParamsSeedVerity(c *C) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	model := boottest.MakeMockUC20Model()
+
+	roleToBlName := map[bootloader.Role]string{
+		bootloader.RoleRecovery: "grub",
+	}
+	p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub/shim-shim-hash")
+	c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+
+	rootHashes := map[string][]byte{
+		"snaps/pc-kernel_1.snap": []byte("kernel root hash"),
+	}
+	var chains []boot.BootChain
+	for _, tc := range []struct {
+		cmdline    string
+		rootHashes map[string][]byte
+	}{
+		{"snapd_recovery_mode=run", nil},
+		{"snapd_recovery_mode=recover", rootHashes},
+	} {
+		bc := boot.BootChain{
+			BrandID: model.BrandID(),
+			Model:   model.Model(),
+			AssetChain: []boot.BootAsset{
+				{Name: "shim", Role: bootloader.RoleRecovery, Hashes: []string{"shim-hash"}},
+			},
+			KernelCmdlines:       []string{tc.cmdline},
+			SeedVerityRootHashes: tc.rootHashes,
+		}
+		bc.SetModelAssertion(model)
+		bc.SetKernelBootFile(bootloader.BootFile{Snap: "pc-kernel_1.snap"})
+		chains = append(chains, bc)
+	}
+	pbc := boot.ToPredictableBootChains(chains)
+
+	// the chain booting the measured seed is not merged with the run
+	// mode one of the same model
+	params, err := boot.SealKeyModelParams(pbc, roleToBlName)
+	c.Assert(err, IsNil)
+	c.Assert(params, HasLen, 2)
+	byCmdline := map[string]*secboot.SealKeyModelParams{}
+	for _, p := range params {
+		c.Assert(p.KernelCmdlines, HasLen, 1)
+		byCmdline[p.KernelCmdlines[0]] = p
+	}
+	c.Check(byCmdline["snapd_recovery_mode=run"].SeedVerityRootHashes, HasLen, 0)
+	c.Check(byCmdline["snapd_recovery_mode=recover"].SeedVerityRootHashes, DeepEquals, rootHashes)
+}
+
+func (s *sealSuite) TestSealKeyModelParamsDataIntegrity(c *C) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	model := boottest.MakeMockUC20Model()
+
+	roleToBlName := map[bootloader.Role]string{
+		bootloader.RoleRecovery: "grub",
+	}
+	p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub/shim-shim-hash")
+	c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+
+	var chains []boot.BootChain
+	for _, cmdline := range []string{"snapd_recovery_mode=run", "snapd_recovery_mode=recover"} {
+		bc := boot.BootChain{
+			BrandID: model.BrandID(),
+			Model:   model.Model(),
+			AssetChain: []boot.BootAsset{
+				{Name: "shim", Role: bootloader.RoleRecovery, Hashes: []string{"shim-hash"}},
+			},
+			KernelCmdlines: []string{cmdline},
+			DataIntegrity:  true,
+		}
+		bc.SetModelAssertion(model)
+		bc.SetKernelBootFile(bootloader.BootFile{Snap: "pc-kernel_1.snap"})
+		chains = append(chains, bc)
+	}
+	pbc := boot.ToPredictableBootChains(chains)
+
+	params, err := boot.SealKeyModelParams(pbc, roleToBlName)
+	c.Assert(err, IsNil)
+	c.Assert(params, HasLen, 1)
+	c.Check(params[0].KernelCmdlines, HasLen, 2)
+	c.Check(params[0].DataIntegrity, Equals, true)
+}
+
+func (s *sealSuite) TestSealKeyModelParamsUnifiedKernelImage(c *C) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	model := boottest.MakeMockUC20Model()
+
+	roleToBlName := map[bootloader.Role]string{
+		bootloader.RoleRecovery: "grub",
+	}
+	p := filepath.Join(rootdir, "var/lib/snapd/boot-assets/grub/shim-shim-hash")
+	c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+
+	// a kernel that is a unified kernel image replacing one that is not
+	var chains []boot.BootChain
+	for _, uki := range []bool{false, true} {
+		bc := boot.BootChain{
+			BrandID: model.BrandID(),
+			Model:   model.Model(),
+			AssetChain: []boot.BootAsset{
+				{Name: "shim", Role: bootloader.RoleRecovery, Hashes: []string{"shim-hash"}},
+			},
+			KernelCmdlines:     []string{"snapd_recovery_mode=run"},
+			UnifiedKernelImage: uki,
+		}
+		bc.SetModelAssertion(model)
+		bc.SetKernelBootFile(bootloader.BootFile{Snap: fmt.Sprintf("pc-kernel_%v.snap", uki)})
+		chains = append(chains, bc)
+	}
+	pbc := boot.ToPredictableBootChains(chains)
+
+	params, err := boot.SealKeyModelParams(pbc, roleToBlName)
+	c.Assert(err, IsNil)
+	// the chains are not mixed in the same parameters
+	c.Assert(params, HasLen, 2)
+	var ukiChains int
+	for _, mp := range params {
+		c.Assert(mp.EFILoadChains, HasLen, 1)
+		c.Assert(mp.EFILoadChains[0].Next, HasLen, 1)
+		if mp.EFILoadChains[0].Next[0].UnifiedKernelImage {
+			ukiChains++
+		}
+	}
+	c.Check(ukiChains, Equals, 1)
 }
 
 func (s *sealSuite) TestIsResealNeeded(c *C) {
@@ -841,7 +1836,7 @@ func (s *sealSuite) TestIsResealNeeded(c *C) {
 	pbc := boot.ToPredictableBootChains(chains)
 
 	rootdir := c.MkDir()
-	err := boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), 2)
+	err := boot.WriteBootChains(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), 2, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	needed, _, err := boot.IsResealNeeded(pbc, filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains"), false)
@@ -874,7 +1869,7 @@ func (s *sealSuite) TestIsResealNeeded(c *C) {
 	unrevchain[1].KernelRevision = ""
 	// write on disk
 	bootChainsFile := filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains")
-	err = boot.WriteBootChains(unrevchain, bootChainsFile, 2)
+	err = boot.WriteBootChains(unrevchain, bootChainsFile, 2, "tpm2", nil)
 	c.Assert(err, IsNil)
 
 	needed, cnt, err = boot.IsResealNeeded(pbc, bootChainsFile, false)
@@ -891,4 +1886,96 @@ func (s *sealSuite) TestIsResealNeeded(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(needed, Equals, true)
 	c.Check(cnt, Equals, 3)
+
+	// machine owner keys enrolled since the keys were sealed
+	restore := boot.MockSecbootMachineOwnerKeyState(func() (string, error) { return "mok-state", nil })
+	defer restore()
+	needed, cnt, err = boot.IsResealNeeded(unrevchain, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, true)
+	c.Check(cnt, Equals, 3)
+
+	// resealed with them
+	err = boot.WriteBootChains(unrevchain, bootChainsFile, 3, "tpm2", nil)
+	c.Assert(err, IsNil)
+	needed, _, err = boot.IsResealNeeded(unrevchain, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, false)
+
+	// and changed again with mokutil
+	restore = boot.MockSecbootMachineOwnerKeyState(func() (string, error) { return "other-mok-state", nil })
+	defer restore()
+	needed, cnt, err = boot.IsResealNeeded(unrevchain, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, true)
+	c.Check(cnt, Equals, 4)
+
+	restore = boot.MockSecbootMachineOwnerKeyState(func() (string, error) { return "", errors.New("some error") })
+	defer restore()
+	_, _, err = boot.IsResealNeeded(unrevchain, bootChainsFile, false)
+	c.Assert(err, ErrorMatches, "cannot read machine owner key state: some error")
+}
+
+func (s *sealSuite) TestIsResealNeededEFISignatureDbUpdates(c *C) {
+	rootdir := c.MkDir()
+	dirs.SetRootDir(rootdir)
+	defer dirs.SetRootDir("")
+
+	pbc := boot.ToPredictableBootChains([]boot.BootChain{{
+		BrandID:        "mybrand",
+		Model:          "foo",
+		Grade:          "dangerous",
+		ModelSignKeyID: "my-key-id",
+		AssetChain: []boot.BootAsset{{
+			Role: bootloader.RoleRecovery, Name: "shim", Hashes: []string{"x", "y"},
+		}},
+		Kernel:         "pc-kernel",
+		KernelRevision: "1",
+		KernelCmdlines: []string{"cmdline"},
+	}})
+	bootChainsFile := filepath.Join(dirs.SnapFDEDirUnder(rootdir), "boot-chains")
+	err := boot.WriteBootChains(pbc, bootChainsFile, 1, "tpm2", nil)
+	c.Assert(err, IsNil)
+
+	needed, _, err := boot.IsResealNeeded(pbc, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, false)
+
+	// an empty directory stages no updates
+	err = os.MkdirAll(dirs.SnapEFISignatureDbUpdatesDir, 0755)
+	c.Assert(err, IsNil)
+	needed, _, err = boot.IsResealNeeded(pbc, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, false)
+
+	// an update was staged since the keys were sealed
+	update := filepath.Join(dirs.SnapEFISignatureDbUpdatesDir, "db-update")
+	err = ioutil.WriteFile(update, []byte("update"), 0644)
+	c.Assert(err, IsNil)
+	needed, cnt, err := boot.IsResealNeeded(pbc, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, true)
+	c.Check(cnt, Equals, 2)
+
+	// resealed with it
+	err = boot.WriteBootChains(pbc, bootChainsFile, 2, "tpm2", nil)
+	c.Assert(err, IsNil)
+	needed, _, err = boot.IsResealNeeded(pbc, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, false)
+
+	// the staged update changed
+	err = ioutil.WriteFile(update, []byte("other update"), 0644)
+	c.Assert(err, IsNil)
+	needed, cnt, err = boot.IsResealNeeded(pbc, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, true)
+	c.Check(cnt, Equals, 3)
+
+	// and was applied
+	err = os.Remove(update)
+	c.Assert(err, IsNil)
+	needed, _, err = boot.IsResealNeeded(pbc, bootChainsFile, false)
+	c.Assert(err, IsNil)
+	c.Check(needed, Equals, true)
 }
@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/verity"
+	"github.com/snapcore/snapd/seed"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/timings"
+)
+
+// seedVerityHashTreeExt is the extension of the dm-verity hash tree stored
+// next to a seed snap.
+const seedVerityHashTreeExt = ".verity"
+
+// SeedVerityRootHashes verifies the dm-verity hash trees stored next to the
+// given seed snaps and returns their root hashes keyed by the path of the
+// snaps relative to seedDir. Either all the snaps have a hash tree or none
+// of them, in which case no root hashes are returned.
+func SeedVerityRootHashes(seedDir string, snaps []*seed.Snap) (map[string][]byte, error) {
+	rootHashes := make(map[string][]byte)
+	var missing string
+	for _, sn := range snaps {
+		rel, err := filepath.Rel(seedDir, sn.Path)
+		if err != nil {
+			return nil, err
+		}
+		hashPath := sn.Path + seedVerityHashTreeExt
+		if !osutil.FileExists(hashPath) {
+			missing = rel
+			continue
+		}
+		info, err := verity.Verify(sn.Path, hashPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot verify seed snap %s: %v", rel, err)
+		}
+		rootHashes[rel] = info.RootHash
+	}
+	if len(rootHashes) != 0 && missing != "" {
+		return nil, fmt.Errorf("cannot verify seed snap %s: missing dm-verity hash tree", missing)
+	}
+	return rootHashes, nil
+}
+
+// seedVerityEssentialTypes are the types of the seed snaps whose hash trees
+// are verified and measured by the initramfs in install and recover modes.
+var seedVerityEssentialTypes = []snap.Type{snap.TypeBase, snap.TypeKernel, snap.TypeSnapd, snap.TypeGadget}
+
+// seedVerityRootHashesFile returns the file where the root hashes of the
+// seed snaps of the recovery systems are recorded. It is kept on ubuntu-data
+// so that it cannot be modified offline, unlike the seed.
+func seedVerityRootHashesFile(rootdir string) string {
+	return filepath.Join(dirs.SnapFDEDirUnder(rootdir), "seed-verity-root-hashes.json")
+}
+
+// readSeedVerityRootHashes returns the recorded root hashes of the seed
+// snaps, keyed by the label of their recovery system.
+func readSeedVerityRootHashes(rootdir string) (map[string]map[string][]byte, error) {
+	content, err := ioutil.ReadFile(seedVerityRootHashesFile(rootdir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rootHashes map[string]map[string][]byte
+	if err := json.Unmarshal(content, &rootHashes); err != nil {
+		return nil, fmt.Errorf("cannot decode seed verity root hashes: %v", err)
+	}
+	return rootHashes, nil
+}
+
+// recordSeedVerityRootHashes verifies the hash trees of the essential snaps
+// of the given recovery system and records their root hashes under rootdir,
+// the keys are then sealed to their measurement when booting the recovery
+// system. It is called at install, when the seed is trusted.
+func recordSeedVerityRootHashes(rootdir, system string) error {
+	perf := timings.New(nil)
+	_, snaps, err := seedReadSystemEssential(dirs.SnapSeedDir, system, seedVerityEssentialTypes, perf)
+	if err != nil {
+		return err
+	}
+	systemRootHashes, err := SeedVerityRootHashes(dirs.SnapSeedDir, snaps)
+	if err != nil {
+		return err
+	}
+	rootHashes, err := readSeedVerityRootHashes(rootdir)
+	if err != nil {
+		return err
+	}
+	if len(systemRootHashes) == 0 {
+		if _, ok := rootHashes[system]; !ok {
+			return nil
+		}
+		delete(rootHashes, system)
+	} else {
+		if rootHashes == nil {
+			rootHashes = make(map[string]map[string][]byte)
+		}
+		rootHashes[system] = systemRootHashes
+	}
+	content, err := json.Marshal(rootHashes)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(seedVerityRootHashesFile(rootdir)), 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(seedVerityRootHashesFile(rootdir), content, 0600, 0)
+}
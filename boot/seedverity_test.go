@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil/verity"
+	"github.com/snapcore/snapd/seed"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+	"github.com/snapcore/snapd/timings"
+)
+
+type seedVeritySuite struct {
+	testutil.BaseTest
+
+	seedDir string
+	snaps   []*seed.Snap
+}
+
+var _ = Suite(&seedVeritySuite{})
+
+func (s *seedVeritySuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	s.seedDir = dirs.SnapSeedDir
+	c.Assert(os.MkdirAll(filepath.Join(s.seedDir, "snaps"), 0755), IsNil)
+	s.snaps = nil
+	for _, name := range []string{"pc-kernel_1.snap", "core20_1.snap"} {
+		snapPath := filepath.Join(s.seedDir, "snaps", name)
+		c.Assert(ioutil.WriteFile(snapPath, make([]byte, 2*verity.BlockSize), 0644), IsNil)
+		s.snaps = append(s.snaps, &seed.Snap{Path: snapPath, EssentialType: snap.TypeKernel})
+	}
+}
+
+func (s *seedVeritySuite) TestSeedVerityRootHashes(c *C) {
+	kernelInfo, err := verity.Format(s.snaps[0].Path, s.snaps[0].Path+".verity", []byte("salt"))
+	c.Assert(err, IsNil)
+	baseInfo, err := verity.Format(s.snaps[1].Path, s.snaps[1].Path+".verity", nil)
+	c.Assert(err, IsNil)
+
+	rootHashes, err := boot.SeedVerityRootHashes(s.seedDir, s.snaps)
+	c.Assert(err, IsNil)
+	c.Check(rootHashes, DeepEquals, map[string][]byte{
+		"snaps/pc-kernel_1.snap": kernelInfo.RootHash,
+		"snaps/core20_1.snap":    baseInfo.RootHash,
+	})
+}
+
+func (s *seedVeritySuite) TestSeedVerityRootHashesMissing(c *C) {
+	// only the kernel has a hash tree, the one of the base was removed
+	_, err := verity.Format(s.snaps[0].Path, s.snaps[0].Path+".verity", []byte("salt"))
+	c.Assert(err, IsNil)
+
+	_, err = boot.SeedVerityRootHashes(s.seedDir, s.snaps)
+	c.Check(err, ErrorMatches, `cannot verify seed snap snaps/core20_1.snap: missing dm-verity hash tree`)
+}
+
+func (s *seedVeritySuite) TestSeedVerityRootHashesNone(c *C) {
+	rootHashes, err := boot.SeedVerityRootHashes(s.seedDir, s.snaps)
+	c.Assert(err, IsNil)
+	c.Check(rootHashes, HasLen, 0)
+}
+
+func (s *seedVeritySuite) TestSeedVerityRootHashesTampered(c *C) {
+	_, err := verity.Format(s.snaps[1].Path, s.snaps[1].Path+".verity", nil)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(s.snaps[1].Path, append([]byte("tampered"), make([]byte, 2*verity.BlockSize-8)...), 0644), IsNil)
+
+	_, err = boot.SeedVerityRootHashes(s.seedDir, s.snaps)
+	c.Check(err, ErrorMatches, `cannot verify seed snap snaps/core20_1.snap: hash tree .* does not match .*`)
+}
+
+func (s *seedVeritySuite) mockSeedReadSystemEssential(c *C) {
+	s.AddCleanup(boot.MockSeedReadSystemEssential(func(seedDir, label string, essentialTypes []snap.Type, tm timings.Measurer) (*asserts.Model, []*seed.Snap, error) {
+		c.Check(seedDir, Equals, dirs.SnapSeedDir)
+		c.Check(label, Equals, "20191118")
+		c.Check(essentialTypes, DeepEquals, []snap.Type{snap.TypeBase, snap.TypeKernel, snap.TypeSnapd, snap.TypeGadget})
+		return nil, s.snaps, nil
+	}))
+}
+
+func (s *seedVeritySuite) TestRecordSeedVerityRootHashes(c *C) {
+	s.mockSeedReadSystemEssential(c)
+	rootdir := c.MkDir()
+
+	kernelInfo, err := verity.Format(s.snaps[0].Path, s.snaps[0].Path+".verity", nil)
+	c.Assert(err, IsNil)
+	baseInfo, err := verity.Format(s.snaps[1].Path, s.snaps[1].Path+".verity", nil)
+	c.Assert(err, IsNil)
+
+	err = boot.RecordSeedVerityRootHashes(rootdir, "20191118")
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(rootdir), "seed-verity-root-hashes.json"), testutil.FilePresent)
+
+	rootHashes, err := boot.ReadSeedVerityRootHashes(rootdir)
+	c.Assert(err, IsNil)
+	c.Check(rootHashes, DeepEquals, map[string]map[string][]byte{
+		"20191118": {
+			"snaps/pc-kernel_1.snap": kernelInfo.RootHash,
+			"snaps/core20_1.snap":    baseInfo.RootHash,
+		},
+	})
+}
+
+func (s *seedVeritySuite) TestRecordSeedVerityRootHashesNone(c *C) {
+	s.mockSeedReadSystemEssential(c)
+	rootdir := c.MkDir()
+
+	err := boot.RecordSeedVerityRootHashes(rootdir, "20191118")
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(rootdir), "seed-verity-root-hashes.json"), testutil.FileAbsent)
+
+	rootHashes, err := boot.ReadSeedVerityRootHashes(rootdir)
+	c.Assert(err, IsNil)
+	c.Check(rootHashes, HasLen, 0)
+}
+
+func (s *seedVeritySuite) TestRecordSeedVerityRootHashesMissing(c *C) {
+	s.mockSeedReadSystemEssential(c)
+	rootdir := c.MkDir()
+
+	_, err := verity.Format(s.snaps[1].Path, s.snaps[1].Path+".verity", nil)
+	c.Assert(err, IsNil)
+
+	err = boot.RecordSeedVerityRootHashes(rootdir, "20191118")
+	c.Assert(err, ErrorMatches, `cannot verify seed snap snaps/pc-kernel_1.snap: missing dm-verity hash tree`)
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(rootdir), "seed-verity-root-hashes.json"), testutil.FileAbsent)
+}
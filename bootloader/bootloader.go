@@ -198,6 +198,24 @@ type TrustedAssetsBootloader interface {
 	BootChain(runBl Bootloader, kernelPath string) ([]BootFile, error)
 }
 
+// CmdlineDropInsBootloader is a Bootloader that supports named fragments of
+// extra kernel command line arguments, so that several components can
+// contribute arguments independently. The fragments are applied in the order
+// of their names.
+type CmdlineDropInsBootloader interface {
+	Bootloader
+
+	// CmdlineDropIns returns the installed command line drop-ins sorted
+	// by name.
+	CmdlineDropIns() ([]CmdlineDropIn, error)
+	// InstallCmdlineDropIn installs or replaces the command line drop-in
+	// with the given name.
+	InstallCmdlineDropIn(name, args string) error
+	// RemoveCmdlineDropIn removes the command line drop-in with the
+	// given name, it is not an error if it does not exist.
+	RemoveCmdlineDropIn(name string) error
+}
+
 func genericInstallBootConfig(gadgetFile, systemFile string) (bool, error) {
 	if !osutil.FileExists(gadgetFile) {
 		return false, nil
@@ -250,7 +268,7 @@ func InstallBootConfig(gadgetDir, rootDir string, opts *Options) error {
 		return err
 	}
 	// TODO:UC20 use ForGadget() to obtain the right bootloader
-	for _, bl := range []installableBootloader{&grub{}, &uboot{}, &androidboot{}, &lk{}} {
+	for _, bl := range []installableBootloader{&grub{}, &uboot{}, &androidboot{}, &lk{}, &sdboot{}} {
 		bl.setRootDir(rootDir)
 		ok, err := bl.InstallBootConfig(gadgetDir, opts)
 		if ok {
@@ -271,6 +289,7 @@ var (
 		newGrub,
 		newAndroidBoot,
 		newLk,
+		newSdboot,
 	}
 )
 
@@ -22,6 +22,7 @@ package bootloadertest
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/snapcore/snapd/bootloader"
@@ -56,6 +57,7 @@ type MockBootloader struct {
 var _ bootloader.Bootloader = (*MockBootloader)(nil)
 var _ bootloader.RecoveryAwareBootloader = (*MockRecoveryAwareBootloader)(nil)
 var _ bootloader.TrustedAssetsBootloader = (*MockTrustedAssetsBootloader)(nil)
+var _ bootloader.CmdlineDropInsBootloader = (*MockCmdlineDropInsBootloader)(nil)
 var _ bootloader.ExtractedRunKernelImageBootloader = (*MockExtractedRunKernelImageBootloader)(nil)
 var _ bootloader.ExtractedRecoveryKernelImageBootloader = (*MockExtractedRecoveryKernelImageBootloader)(nil)
 
@@ -454,3 +456,59 @@ func (b *MockTrustedAssetsBootloader) BootChain(runBl bootloader.Bootloader, ker
 	b.BootChainKernelPath = append(b.BootChainKernelPath, kernelPath)
 	return b.BootChainList, b.BootChainErr
 }
+
+// MockCmdlineDropInsBootloader mocks a bootloader implementing the
+// bootloader.TrustedAssetsBootloader and the
+// bootloader.CmdlineDropInsBootloader interfaces.
+type MockCmdlineDropInsBootloader struct {
+	*MockTrustedAssetsBootloader
+
+	DropIns            []bootloader.CmdlineDropIn
+	InstallDropInErr   error
+	InstallDropInCalls []string
+	RemoveDropInCalls  []string
+}
+
+func (b *MockTrustedAssetsBootloader) WithCmdlineDropIns() *MockCmdlineDropInsBootloader {
+	return &MockCmdlineDropInsBootloader{
+		MockTrustedAssetsBootloader: b,
+	}
+}
+
+func (b *MockCmdlineDropInsBootloader) CmdlineDropIns() ([]bootloader.CmdlineDropIn, error) {
+	return append([]bootloader.CmdlineDropIn(nil), b.DropIns...), nil
+}
+
+func (b *MockCmdlineDropInsBootloader) InstallCmdlineDropIn(name, args string) error {
+	b.InstallDropInCalls = append(b.InstallDropInCalls, name)
+	if b.InstallDropInErr != nil {
+		return b.InstallDropInErr
+	}
+	dropIns := []bootloader.CmdlineDropIn{{Name: name, Args: args}}
+	for _, d := range b.DropIns {
+		if d.Name != name {
+			dropIns = append(dropIns, d)
+		}
+	}
+	sort.Slice(dropIns, func(i, j int) bool { return dropIns[i].Name < dropIns[j].Name })
+	b.DropIns = dropIns
+	b.SetBootVars(map[string]string{
+		"snapd_extra_cmdline_args": bootloader.ComposeCmdlineDropIns(b.DropIns),
+	})
+	return nil
+}
+
+func (b *MockCmdlineDropInsBootloader) RemoveCmdlineDropIn(name string) error {
+	b.RemoveDropInCalls = append(b.RemoveDropInCalls, name)
+	var dropIns []bootloader.CmdlineDropIn
+	for _, d := range b.DropIns {
+		if d.Name != name {
+			dropIns = append(dropIns, d)
+		}
+	}
+	b.DropIns = dropIns
+	b.SetBootVars(map[string]string{
+		"snapd_extra_cmdline_args": bootloader.ComposeCmdlineDropIns(b.DropIns),
+	})
+	return nil
+}
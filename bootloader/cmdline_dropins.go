@@ -0,0 +1,147 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// CmdlineDropIn is a named fragment of extra kernel command line arguments.
+type CmdlineDropIn struct {
+	Name string
+	Args string
+}
+
+var validCmdlineDropInName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// cmdline arguments that are controlled by snapd and cannot be set by
+// drop-ins
+var reservedCmdlineArgs = []string{
+	"snapd_recovery_mode",
+	"snapd_recovery_system",
+}
+
+// ValidateCmdlineDropIn checks that the name and the arguments of a command
+// line drop-in are acceptable.
+func ValidateCmdlineDropIn(name, args string) error {
+	if !validCmdlineDropInName.MatchString(name) {
+		return fmt.Errorf("invalid command line drop-in name %q", name)
+	}
+	if strings.ContainsAny(args, "\n\r\"'") {
+		return fmt.Errorf("invalid command line drop-in %q: arguments cannot contain quotes or newlines", name)
+	}
+	for _, arg := range strings.Fields(args) {
+		key := strings.SplitN(arg, "=", 2)[0]
+		for _, reserved := range reservedCmdlineArgs {
+			if key == reserved {
+				return fmt.Errorf("invalid command line drop-in %q: argument %q is reserved", name, reserved)
+			}
+		}
+	}
+	return nil
+}
+
+// ComposeCmdlineDropIns returns the extra command line arguments resulting
+// from applying the drop-ins in the given order.
+func ComposeCmdlineDropIns(dropIns []CmdlineDropIn) string {
+	var args []string
+	for _, dropIn := range dropIns {
+		if a := strings.Join(strings.Fields(dropIn.Args), " "); a != "" {
+			args = append(args, a)
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// cmdlineDropInsDir keeps command line drop-ins as files in a directory and
+// reflects their composition in the snapd_extra_cmdline_args boot variable
+// of the bootloader.
+type cmdlineDropInsDir struct {
+	dir string
+	bl  Bootloader
+}
+
+func (d *cmdlineDropInsDir) list() ([]CmdlineDropIn, error) {
+	matches, err := filepath.Glob(filepath.Join(d.dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	dropIns := make([]CmdlineDropIn, 0, len(matches))
+	for _, m := range matches {
+		content, err := ioutil.ReadFile(m)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read command line drop-in: %v", err)
+		}
+		dropIns = append(dropIns, CmdlineDropIn{
+			Name: strings.TrimSuffix(filepath.Base(m), ".conf"),
+			Args: strings.TrimSpace(string(content)),
+		})
+	}
+	return dropIns, nil
+}
+
+func (d *cmdlineDropInsDir) install(name, args string) error {
+	if err := ValidateCmdlineDropIn(name, args); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	dropInFile := filepath.Join(d.dir, name+".conf")
+	if err := osutil.AtomicWriteFile(dropInFile, []byte(strings.TrimSpace(args)+"\n"), 0644, 0); err != nil {
+		return err
+	}
+	return d.apply()
+}
+
+func (d *cmdlineDropInsDir) remove(name string) error {
+	if !validCmdlineDropInName.MatchString(name) {
+		return fmt.Errorf("invalid command line drop-in name %q", name)
+	}
+	err := os.Remove(filepath.Join(d.dir, name+".conf"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return d.apply()
+}
+
+// apply sets the boot variable with the extra arguments to the composition
+// of the current drop-ins.
+func (d *cmdlineDropInsDir) apply() error {
+	dropIns, err := d.list()
+	if err != nil {
+		return err
+	}
+	return d.bl.SetBootVars(map[string]string{
+		"snapd_extra_cmdline_args": ComposeCmdlineDropIns(dropIns),
+	})
+}
@@ -0,0 +1,65 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader"
+)
+
+type cmdlineDropInsTestSuite struct{}
+
+var _ = Suite(&cmdlineDropInsTestSuite{})
+
+func (s *cmdlineDropInsTestSuite) TestValidateCmdlineDropIn(c *C) {
+	for _, tc := range []struct {
+		name, args string
+		err        string
+	}{
+		{"10-gadget", "quiet splash", ""},
+		{"debug", "", ""},
+		{"50-debug", "foo=bar=baz", ""},
+		{"", "quiet", `invalid command line drop-in name ""`},
+		{"-debug", "quiet", `invalid command line drop-in name "-debug"`},
+		{"../debug", "quiet", `invalid command line drop-in name "../debug"`},
+		{"Debug", "quiet", `invalid command line drop-in name "Debug"`},
+		{"debug", "foo=\"with spaces\"", `invalid command line drop-in "debug": arguments cannot contain quotes or newlines`},
+		{"debug", "foo\nbar", `invalid command line drop-in "debug": arguments cannot contain quotes or newlines`},
+		{"debug", "quiet snapd_recovery_mode=install", `invalid command line drop-in "debug": argument "snapd_recovery_mode" is reserved`},
+		{"debug", "snapd_recovery_system", `invalid command line drop-in "debug": argument "snapd_recovery_system" is reserved`},
+	} {
+		err := bootloader.ValidateCmdlineDropIn(tc.name, tc.args)
+		if tc.err == "" {
+			c.Check(err, IsNil, Commentf("%q %q", tc.name, tc.args))
+		} else {
+			c.Check(err, ErrorMatches, tc.err, Commentf("%q %q", tc.name, tc.args))
+		}
+	}
+}
+
+func (s *cmdlineDropInsTestSuite) TestComposeCmdlineDropIns(c *C) {
+	c.Check(bootloader.ComposeCmdlineDropIns(nil), Equals, "")
+	c.Check(bootloader.ComposeCmdlineDropIns([]bootloader.CmdlineDropIn{
+		{Name: "10-gadget", Args: " quiet   splash "},
+		{Name: "20-empty", Args: ""},
+		{Name: "50-debug", Args: "debug=1"},
+	}), Equals, "quiet splash debug=1")
+}
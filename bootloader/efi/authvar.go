@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	winCertificateRevision = 0x0200
+	winCertTypeEFIGUID     = 0x0ef1
+	// size of the EFI_TIME and WIN_CERTIFICATE_UEFI_GUID headers
+	efiTimeSize        = 16
+	winCertUEFIGUIDHdr = 24
+)
+
+// efiCertTypePKCS7GUID is EFI_CERT_TYPE_PKCS7_GUID in its binary form.
+var efiCertTypePKCS7GUID = []byte{0x9d, 0xd2, 0xaf, 0x4a, 0xdf, 0x68, 0xee, 0x49, 0x8a, 0xa9, 0x34, 0x7d, 0x37, 0x56, 0x65, 0xa7}
+
+// AuthenticatedVarData returns the value to write with SetVar for a
+// variable with VariableTimeBasedAuthenticatedWriteAccess: data
+// prefixed with the EFI_VARIABLE_AUTHENTICATION_2 descriptor holding
+// the timestamp and the detached PKCS7 signature of the update, as
+// described in section 8.2.2 of the UEFI specification. Producing the
+// signature is up to the caller.
+func AuthenticatedVarData(timestamp time.Time, signature []byte, data []byte) []byte {
+	t := timestamp.UTC()
+	buf := bytes.NewBuffer(make([]byte, 0, efiTimeSize+winCertUEFIGUIDHdr+len(signature)+len(data)))
+	// EFI_TIME, the nanosecond, time zone and daylight fields must
+	// be zero
+	binary.Write(buf, binary.LittleEndian, uint16(t.Year()))
+	buf.Write([]byte{byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0})
+	buf.Write(make([]byte, 8))
+	// WIN_CERTIFICATE_UEFI_GUID
+	binary.Write(buf, binary.LittleEndian, uint32(winCertUEFIGUIDHdr+len(signature)))
+	binary.Write(buf, binary.LittleEndian, uint16(winCertificateRevision))
+	binary.Write(buf, binary.LittleEndian, uint16(winCertTypeEFIGUID))
+	buf.Write(efiCertTypePKCS7GUID)
+	buf.Write(signature)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// authenticatedVarPayload checks the EFI_VARIABLE_AUTHENTICATION_2
+// descriptor at the start of the given value and returns the data
+// following it.
+func authenticatedVarPayload(b []byte) ([]byte, error) {
+	if len(b) < efiTimeSize+winCertUEFIGUIDHdr {
+		return nil, errors.New("authentication descriptor too short")
+	}
+	cert := b[efiTimeSize:]
+	certLen := binary.LittleEndian.Uint32(cert[0:4])
+	if certLen < winCertUEFIGUIDHdr || uint64(certLen) > uint64(len(cert)) {
+		return nil, fmt.Errorf("invalid authentication descriptor length %d", certLen)
+	}
+	if rev := binary.LittleEndian.Uint16(cert[4:6]); rev != winCertificateRevision {
+		return nil, fmt.Errorf("unsupported authentication descriptor revision 0x%04x", rev)
+	}
+	if certType := binary.LittleEndian.Uint16(cert[6:8]); certType != winCertTypeEFIGUID {
+		return nil, fmt.Errorf("unsupported authentication descriptor certificate type 0x%04x", certType)
+	}
+	if !bytes.Equal(cert[8:24], efiCertTypePKCS7GUID) {
+		return nil, errors.New("authentication descriptor is not a PKCS7 signature")
+	}
+	return cert[certLen:], nil
+}
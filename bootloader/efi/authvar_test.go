@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package efi_test
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader/efi"
+)
+
+type authVarSuite struct{}
+
+var _ = Suite(&authVarSuite{})
+
+func (s *authVarSuite) TestAuthenticatedVarData(c *C) {
+	t0 := time.Date(2021, 6, 1, 10, 20, 30, 400, time.FixedZone("CEST", 2*60*60))
+	data := efi.AuthenticatedVarData(t0, []byte("sig"), []byte("payload"))
+	c.Check(data, DeepEquals, []byte(""+
+		// EFI_TIME, in UTC without nanoseconds
+		"\xe5\x07\x06\x01\x08\x14\x1e\x00"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00"+
+		// WIN_CERTIFICATE_UEFI_GUID header
+		"\x1b\x00\x00\x00\x00\x02\xf1\x0e"+
+		// EFI_CERT_TYPE_PKCS7_GUID
+		"\x9d\xd2\xaf\x4a\xdf\x68\xee\x49\x8a\xa9\x34\x7d\x37\x56\x65\xa7"+
+		"sig"+
+		"payload"))
+}
+
+func (s *authVarSuite) TestInvalidDescriptors(c *C) {
+	restore := efi.MockVars(map[string][]byte{}, nil)
+	defer restore()
+
+	valid := efi.AuthenticatedVarData(time.Now(), []byte("sig"), []byte("payload"))
+	for _, t := range []struct {
+		mod func(b []byte) []byte
+		err string
+	}{
+		{func(b []byte) []byte { return b[:39] }, "authentication descriptor too short"},
+		{func(b []byte) []byte { b[16] = 0x01; return b }, "invalid authentication descriptor length 1"},
+		{func(b []byte) []byte { b[16] = 0xff; return b }, "invalid authentication descriptor length 255"},
+		{func(b []byte) []byte { b[21] = 0x01; return b }, "unsupported authentication descriptor revision 0x0100"},
+		{func(b []byte) []byte { b[22] = 0x02; return b }, "unsupported authentication descriptor certificate type 0x0e02"},
+		{func(b []byte) []byte { b[24] = 0x00; return b }, "authentication descriptor is not a PKCS7 signature"},
+	} {
+		b := t.mod(append([]byte(nil), valid...))
+		err := efi.SetVar("db", efi.VariableNonVolatile|efi.VariableTimeBasedAuthenticatedWriteAccess, b)
+		c.Check(err, ErrorMatches, `cannot write EFI var "db": `+t.err)
+	}
+}
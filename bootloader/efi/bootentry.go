@@ -0,0 +1,235 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package efi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"unicode/utf16"
+)
+
+// GlobalVariableGUID is the vendor ID of the variables defined by the
+// UEFI specification, such as BootOrder, BootNext and Boot####.
+const GlobalVariableGUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// bootVarAttrs are the attributes mandated by the UEFI specification
+// for the boot manager variables.
+const bootVarAttrs = VariableNonVolatile | VariableBootServiceAccess | VariableRuntimeAccess
+
+// LoadOptionActive marks a boot entry as active, entries without it
+// are skipped by the boot manager.
+const LoadOptionActive uint32 = 0x00000001
+
+// LoadOption is a boot entry as stored in a Boot#### variable, see
+// section 3.1.3 of the UEFI specification.
+type LoadOption struct {
+	Attributes  uint32
+	Description string
+	// FilePath is the raw device path list of the entry.
+	FilePath []byte
+	// OptionalData is passed as is to the loaded image.
+	OptionalData []byte
+}
+
+// ParseLoadOption decodes a load option in its EFI binary form.
+func ParseLoadOption(b []byte) (*LoadOption, error) {
+	if len(b) < 6 {
+		return nil, fmt.Errorf("load option too short")
+	}
+	opt := &LoadOption{
+		Attributes: binary.LittleEndian.Uint32(b[0:4]),
+	}
+	filePathLen := int(binary.LittleEndian.Uint16(b[4:6]))
+	b = b[6:]
+
+	var r16 []uint16
+	terminated := false
+	for len(b) >= 2 {
+		r := binary.LittleEndian.Uint16(b[0:2])
+		b = b[2:]
+		if r == 0 {
+			terminated = true
+			break
+		}
+		r16 = append(r16, r)
+	}
+	if !terminated {
+		return nil, fmt.Errorf("load option description is not terminated")
+	}
+	opt.Description = string(utf16.Decode(r16))
+
+	if len(b) < filePathLen {
+		return nil, fmt.Errorf("load option file path list too short")
+	}
+	opt.FilePath = append([]byte(nil), b[:filePathLen]...)
+	if len(b) > filePathLen {
+		opt.OptionalData = append([]byte(nil), b[filePathLen:]...)
+	}
+	return opt, nil
+}
+
+// Bytes returns the EFI binary form of the load option.
+func (o *LoadOption) Bytes() []byte {
+	desc := utf16.Encode([]rune(o.Description))
+	buf := bytes.NewBuffer(make([]byte, 0, 6+2*(len(desc)+1)+len(o.FilePath)+len(o.OptionalData)))
+	binary.Write(buf, binary.LittleEndian, o.Attributes)
+	binary.Write(buf, binary.LittleEndian, uint16(len(o.FilePath)))
+	binary.Write(buf, binary.LittleEndian, desc)
+	binary.Write(buf, binary.LittleEndian, uint16(0))
+	buf.Write(o.FilePath)
+	buf.Write(o.OptionalData)
+	return buf.Bytes()
+}
+
+func globalVarName(name string) string {
+	return name + "-" + GlobalVariableGUID
+}
+
+func bootEntryVarName(n uint16) string {
+	return globalVarName(fmt.Sprintf("Boot%04X", n))
+}
+
+// readOptionalVar reads the given variable returning nil if it does
+// not exist.
+func readOptionalVar(name string) ([]byte, error) {
+	varf, _, _, err := openEFIVar(name)
+	if err != nil {
+		if err == ErrNoEFISystem {
+			return nil, err
+		}
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, cannotReadError(name, err)
+	}
+	defer varf.Close()
+	b, err := ioutil.ReadAll(varf)
+	if err != nil {
+		return nil, cannotReadError(name, err)
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+func decodeUint16List(name string, b []byte) ([]uint16, error) {
+	if len(b)%2 != 0 {
+		return nil, fmt.Errorf("EFI var %q is not a valid list of boot entries, it has an extra byte", name)
+	}
+	l := make([]uint16, len(b)/2)
+	for i := range l {
+		l[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return l, nil
+}
+
+func encodeUint16List(l []uint16) []byte {
+	b := make([]byte, 2*len(l))
+	for i, v := range l {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
+
+// ReadBootEntry reads the Boot#### variable of the given boot entry.
+func ReadBootEntry(n uint16) (*LoadOption, error) {
+	name := bootEntryVarName(n)
+	b, _, err := ReadVarBytes(name)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := ParseLoadOption(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse EFI var %q: %v", name, err)
+	}
+	return opt, nil
+}
+
+// WriteBootEntry creates or replaces the Boot#### variable of the
+// given boot entry.
+func WriteBootEntry(n uint16, opt *LoadOption) error {
+	return SetVar(bootEntryVarName(n), bootVarAttrs, opt.Bytes())
+}
+
+// ReadBootOrder returns the boot entries in the order in which the
+// boot manager tries them. An unset BootOrder yields an empty list.
+func ReadBootOrder() ([]uint16, error) {
+	name := globalVarName("BootOrder")
+	b, err := readOptionalVar(name)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUint16List(name, b)
+}
+
+// WriteBootOrder sets the order in which the boot manager tries the
+// boot entries.
+func WriteBootOrder(order []uint16) error {
+	return SetVar(globalVarName("BootOrder"), bootVarAttrs, encodeUint16List(order))
+}
+
+// ReadBootNext returns the boot entry to be tried first on the next
+// boot only, ok is false if BootNext is not set.
+func ReadBootNext() (n uint16, ok bool, err error) {
+	name := globalVarName("BootNext")
+	b, err := readOptionalVar(name)
+	if err != nil || b == nil {
+		return 0, false, err
+	}
+	if len(b) != 2 {
+		return 0, false, fmt.Errorf("EFI var %q has unexpected size: %d", name, len(b))
+	}
+	return binary.LittleEndian.Uint16(b), true, nil
+}
+
+// WriteBootNext sets the boot entry to be tried first on the next
+// boot only, the firmware clears BootNext once it has been used.
+func WriteBootNext(n uint16) error {
+	return SetVar(globalVarName("BootNext"), bootVarAttrs, encodeUint16List([]uint16{n}))
+}
+
+// EnsureBootOrderFirst moves the given boot entry to the front of
+// BootOrder, adding it if missing. This restores a boot entry that a
+// firmware update has reordered. BootOrder is only rewritten when it
+// changes, which is reported via the returned boolean.
+func EnsureBootOrderFirst(n uint16) (changed bool, err error) {
+	order, err := ReadBootOrder()
+	if err != nil {
+		return false, err
+	}
+	if len(order) > 0 && order[0] == n {
+		return false, nil
+	}
+	newOrder := make([]uint16, 0, len(order)+1)
+	newOrder = append(newOrder, n)
+	for _, e := range order {
+		if e != n {
+			newOrder = append(newOrder, e)
+		}
+	}
+	if err := WriteBootOrder(newOrder); err != nil {
+		return false, err
+	}
+	return true, nil
+}
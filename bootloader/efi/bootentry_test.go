@@ -0,0 +1,191 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package efi_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader/efi"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type bootEntrySuite struct {
+	testutil.BaseTest
+
+	vars  map[string][]byte
+	attrs map[string]efi.VariableAttr
+}
+
+var _ = Suite(&bootEntrySuite{})
+
+const (
+	bootOrderVar = "BootOrder-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	bootNextVar  = "BootNext-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	boot0001Var  = "Boot0001-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+	bootVarAttrs = efi.VariableNonVolatile | efi.VariableBootServiceAccess | efi.VariableRuntimeAccess
+)
+
+// ubuntu boot entry for \EFI\ubuntu\shimx64.efi on the first partition
+var ubuntuBootEntry = []byte("" +
+	"\x01\x00\x00\x00" + // attributes
+	"\x08\x00" + // file path list length
+	"u\x00b\x00u\x00n\x00t\x00u\x00\x00\x00" + // description
+	"\x04\x04\x08\x00a\x00\x00\x00" + // file path list
+	"\x7f\xff") // optional data
+
+func (s *bootEntrySuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	s.vars = map[string][]byte{}
+	s.attrs = map[string]efi.VariableAttr{}
+	s.AddCleanup(efi.MockVars(s.vars, s.attrs))
+}
+
+func (s *bootEntrySuite) TestLoadOptionRoundTrip(c *C) {
+	opt, err := efi.ParseLoadOption(ubuntuBootEntry)
+	c.Assert(err, IsNil)
+	c.Check(opt, DeepEquals, &efi.LoadOption{
+		Attributes:   efi.LoadOptionActive,
+		Description:  "ubuntu",
+		FilePath:     []byte("\x04\x04\x08\x00a\x00\x00\x00"),
+		OptionalData: []byte("\x7f\xff"),
+	})
+	c.Check(opt.Bytes(), DeepEquals, ubuntuBootEntry)
+}
+
+func (s *bootEntrySuite) TestParseLoadOptionErrors(c *C) {
+	for _, t := range []struct {
+		b   string
+		err string
+	}{
+		{"\x01\x00\x00", "load option too short"},
+		{"\x01\x00\x00\x00\x00\x00u\x00b\x00", "load option description is not terminated"},
+		{"\x01\x00\x00\x00\x04\x00u\x00\x00\x00\x7f", "load option file path list too short"},
+	} {
+		_, err := efi.ParseLoadOption([]byte(t.b))
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
+func (s *bootEntrySuite) TestReadWriteBootEntry(c *C) {
+	s.vars[boot0001Var] = ubuntuBootEntry
+
+	opt, err := efi.ReadBootEntry(1)
+	c.Assert(err, IsNil)
+	c.Check(opt.Description, Equals, "ubuntu")
+
+	opt.Description = "ubuntu core"
+	err = efi.WriteBootEntry(0x2a, opt)
+	c.Assert(err, IsNil)
+	c.Check(s.attrs["Boot002A-8be4df61-93ca-11d2-aa0d-00e098032b8c"], Equals, bootVarAttrs)
+
+	opt, err = efi.ReadBootEntry(0x2a)
+	c.Assert(err, IsNil)
+	c.Check(opt.Description, Equals, "ubuntu core")
+	c.Check(opt.OptionalData, DeepEquals, []byte("\x7f\xff"))
+}
+
+func (s *bootEntrySuite) TestReadBootEntryInvalid(c *C) {
+	s.vars[boot0001Var] = []byte("\x01")
+
+	_, err := efi.ReadBootEntry(1)
+	c.Check(err, ErrorMatches, `cannot parse EFI var "Boot0001-8be4df61-93ca-11d2-aa0d-00e098032b8c": load option too short`)
+}
+
+func (s *bootEntrySuite) TestBootOrder(c *C) {
+	order, err := efi.ReadBootOrder()
+	c.Assert(err, IsNil)
+	c.Check(order, HasLen, 0)
+
+	err = efi.WriteBootOrder([]uint16{3, 0x100, 1})
+	c.Assert(err, IsNil)
+	c.Check(s.vars[bootOrderVar], DeepEquals, []byte("\x03\x00\x00\x01\x01\x00"))
+	c.Check(s.attrs[bootOrderVar], Equals, bootVarAttrs)
+
+	order, err = efi.ReadBootOrder()
+	c.Assert(err, IsNil)
+	c.Check(order, DeepEquals, []uint16{3, 0x100, 1})
+}
+
+func (s *bootEntrySuite) TestBootOrderInvalid(c *C) {
+	s.vars[bootOrderVar] = []byte("\x01\x00\x02")
+
+	_, err := efi.ReadBootOrder()
+	c.Check(err, ErrorMatches, `EFI var "BootOrder-8be4df61-93ca-11d2-aa0d-00e098032b8c" is not a valid list of boot entries, it has an extra byte`)
+}
+
+func (s *bootEntrySuite) TestBootNext(c *C) {
+	_, ok, err := efi.ReadBootNext()
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+
+	err = efi.WriteBootNext(0x1f)
+	c.Assert(err, IsNil)
+	c.Check(s.vars[bootNextVar], DeepEquals, []byte("\x1f\x00"))
+
+	n, ok, err := efi.ReadBootNext()
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
+	c.Check(n, Equals, uint16(0x1f))
+
+	s.vars[bootNextVar] = []byte("\x1f")
+	_, _, err = efi.ReadBootNext()
+	c.Check(err, ErrorMatches, `EFI var "BootNext-8be4df61-93ca-11d2-aa0d-00e098032b8c" has unexpected size: 1`)
+}
+
+func (s *bootEntrySuite) TestEnsureBootOrderFirst(c *C) {
+	for _, t := range []struct {
+		order    []uint16
+		entry    uint16
+		expected []uint16
+		changed  bool
+	}{
+		{[]uint16{1, 2, 3}, 1, []uint16{1, 2, 3}, false},
+		{[]uint16{2, 3, 1}, 1, []uint16{1, 2, 3}, true},
+		{[]uint16{2, 3}, 1, []uint16{1, 2, 3}, true},
+		{nil, 1, []uint16{1}, true},
+	} {
+		delete(s.vars, bootOrderVar)
+		if t.order != nil {
+			c.Assert(efi.WriteBootOrder(t.order), IsNil)
+		}
+		changed, err := efi.EnsureBootOrderFirst(t.entry)
+		c.Assert(err, IsNil)
+		c.Check(changed, Equals, t.changed)
+		order, err := efi.ReadBootOrder()
+		c.Assert(err, IsNil)
+		c.Check(order, DeepEquals, t.expected)
+	}
+}
+
+func (s *bootEntrySuite) TestNoEFISystem(c *C) {
+	restore := efi.MockVars(nil, nil)
+	defer restore()
+
+	_, err := efi.ReadBootOrder()
+	c.Check(err, Equals, efi.ErrNoEFISystem)
+	_, _, err = efi.ReadBootNext()
+	c.Check(err, Equals, efi.ErrNoEFISystem)
+	_, err = efi.EnsureBootOrderFirst(1)
+	c.Check(err, Equals, efi.ErrNoEFISystem)
+	err = efi.WriteBootNext(1)
+	c.Check(err, Equals, efi.ErrNoEFISystem)
+}
@@ -17,7 +17,7 @@
  *
  */
 
-// Package efi supports reading EFI variables.
+// Package efi supports reading and writing EFI variables.
 package efi
 
 import (
@@ -31,6 +31,8 @@ import (
 	"path/filepath"
 	"unicode/utf16"
 
+	"golang.org/x/xerrors"
+
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
 )
@@ -41,33 +43,44 @@ type VariableAttr uint32
 
 // see https://git.kernel.org/pub/scm/linux/kernel/git/stable/linux.git/tree/include/linux/efi.h?h=v5.4.32
 const (
-	VariableNonVolatile       VariableAttr = 0x00000001
-	VariableBootServiceAccess VariableAttr = 0x00000002
-	VariableRuntimeAccess     VariableAttr = 0x00000004
+	VariableNonVolatile                       VariableAttr = 0x00000001
+	VariableBootServiceAccess                 VariableAttr = 0x00000002
+	VariableRuntimeAccess                     VariableAttr = 0x00000004
+	VariableHardwareErrorRecord               VariableAttr = 0x00000008
+	VariableAuthenticatedWriteAccess          VariableAttr = 0x00000010
+	VariableTimeBasedAuthenticatedWriteAccess VariableAttr = 0x00000020
+	VariableAppendWrite                       VariableAttr = 0x00000040
 )
 
 var (
-	openEFIVar = openEFIVarImpl
+	openEFIVar   = openEFIVarImpl
+	writeEFIVar  = writeEFIVarImpl
+	deleteEFIVar = deleteEFIVarImpl
+
+	getFileAttr = osutil.GetAttr
+	setFileAttr = osutil.SetAttr
 )
 
 const expectedEFIvarfsDir = "/sys/firmware/efi/efivars"
 
-func openEFIVarImpl(name string) (r io.ReadCloser, attr VariableAttr, size int64, err error) {
+func checkEFIvarfs() error {
 	mounts, err := osutil.LoadMountInfo()
 	if err != nil {
-		return nil, 0, 0, err
+		return err
 	}
-	found := false
 	for _, mnt := range mounts {
 		if mnt.MountDir == expectedEFIvarfsDir {
 			if mnt.FsType == "efivarfs" {
-				found = true
-				break
+				return nil
 			}
 		}
 	}
-	if !found {
-		return nil, 0, 0, ErrNoEFISystem
+	return ErrNoEFISystem
+}
+
+func openEFIVarImpl(name string) (r io.ReadCloser, attr VariableAttr, size int64, err error) {
+	if err := checkEFIvarfs(); err != nil {
+		return nil, 0, 0, err
 	}
 	varf, err := os.Open(filepath.Join(dirs.GlobalRootDir, expectedEFIvarfsDir, name))
 	if err != nil {
@@ -93,8 +106,103 @@ func openEFIVarImpl(name string) (r io.ReadCloser, attr VariableAttr, size int64
 	return varf, attr, sz - 4, nil
 }
 
+// clearImmutable clears the immutable flag of the given efivarfs file.
+// Most variables are marked immutable by efivarfs to protect them from
+// accidental removal. The returned function restores the flag.
+func clearImmutable(varf *os.File) (restore func() error, err error) {
+	fattr, err := getFileAttr(varf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get file attributes: %v", err)
+	}
+	if fattr&osutil.FS_IMMUTABLE_FL == 0 {
+		return func() error { return nil }, nil
+	}
+	if err := setFileAttr(varf, fattr&^osutil.FS_IMMUTABLE_FL); err != nil {
+		return nil, fmt.Errorf("cannot clear immutable flag: %v", err)
+	}
+	return func() error {
+		if err := setFileAttr(varf, fattr); err != nil {
+			return fmt.Errorf("cannot restore immutable flag: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// writeEFIVarImpl writes the given variable through efivarfs, the
+// immutable flag of an existing variable is cleared for the duration
+// of the write.
+func writeEFIVarImpl(name string, attr VariableAttr, data []byte) (err error) {
+	if err := checkEFIvarfs(); err != nil {
+		return err
+	}
+	varPath := filepath.Join(dirs.GlobalRootDir, expectedEFIvarfsDir, name)
+	if f, err := os.Open(varPath); err == nil {
+		defer f.Close()
+		restore, err := clearImmutable(f)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if rerr := restore(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// efivarfs expects the attributes and the value to be passed in
+	// a single write
+	buf := bytes.NewBuffer(make([]byte, 0, 4+len(data)))
+	binary.Write(buf, binary.LittleEndian, attr)
+	buf.Write(data)
+
+	varf, err := os.OpenFile(varPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := varf.Write(buf.Bytes()); err != nil {
+		varf.Close()
+		return err
+	}
+	return varf.Close()
+}
+
+// deleteEFIVarImpl removes the given variable through efivarfs after
+// clearing its immutable flag.
+func deleteEFIVarImpl(name string) error {
+	if err := checkEFIvarfs(); err != nil {
+		return err
+	}
+	varPath := filepath.Join(dirs.GlobalRootDir, expectedEFIvarfsDir, name)
+	varf, err := os.Open(varPath)
+	if err != nil {
+		return err
+	}
+	defer varf.Close()
+	restore, err := clearImmutable(varf)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(varPath); err != nil {
+		if rerr := restore(); rerr != nil {
+			return fmt.Errorf("%v (and %v)", err, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
 func cannotReadError(name string, err error) error {
-	return fmt.Errorf("cannot read EFI var %q: %v", name, err)
+	return xerrors.Errorf("cannot read EFI var %q: %w", name, err)
+}
+
+func cannotWriteError(name string, err error) error {
+	return fmt.Errorf("cannot write EFI var %q: %v", name, err)
+}
+
+func cannotDeleteError(name string, err error) error {
+	return xerrors.Errorf("cannot delete EFI var %q: %w", name, err)
 }
 
 // ReadVarBytes will attempt to read the bytes of the value of the
@@ -159,11 +267,67 @@ func ReadVarString(name string) (string, VariableAttr, error) {
 	return b.String(), attr, nil
 }
 
-// MockVars mocks EFI variables as read by ReadVar*, only to be used
-// from tests. Set vars to nil to mock a non-EFI system.
+// SetVar will attempt to write the given value and attributes to the
+// specified EFI variable, specified by its full name composed of the
+// variable name and vendor ID. The variable is created if it does not
+// exist yet, with VariableAppendWrite the value is appended to the
+// existing one. The value of a variable with
+// VariableTimeBasedAuthenticatedWriteAccess must start with an
+// authentication descriptor, see AuthenticatedVarData. It expects to
+// use the efivars filesystem at /sys/firmware/efi/efivars.
+// https://www.kernel.org/doc/Documentation/filesystems/efivarfs.txt
+// for more details.
+func SetVar(name string, attr VariableAttr, data []byte) error {
+	if attr&VariableAuthenticatedWriteAccess != 0 {
+		return cannotWriteError(name, errors.New("count based authenticated variables are not supported"))
+	}
+	if attr&VariableTimeBasedAuthenticatedWriteAccess != 0 {
+		if _, err := authenticatedVarPayload(data); err != nil {
+			return cannotWriteError(name, err)
+		}
+	}
+	if err := writeEFIVar(name, attr, data); err != nil {
+		if err == ErrNoEFISystem {
+			return err
+		}
+		return cannotWriteError(name, err)
+	}
+	return nil
+}
+
+// SetVarString will attempt to write the given string value, encoded
+// as a NUL terminated UTF16 string, and attributes to the specified
+// EFI variable, see SetVar.
+func SetVarString(name string, attr VariableAttr, value string) error {
+	r16 := append(utf16.Encode([]rune(value)), 0)
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, r16)
+	return SetVar(name, attr, buf.Bytes())
+}
+
+// DeleteVar will attempt to delete the specified EFI variable,
+// specified by its full name composed of the variable name and vendor
+// ID. Authenticated variables cannot be deleted this way, instead an
+// authenticated empty value must be written with SetVar.
+func DeleteVar(name string) error {
+	if err := deleteEFIVar(name); err != nil {
+		if err == ErrNoEFISystem {
+			return err
+		}
+		return cannotDeleteError(name, err)
+	}
+	return nil
+}
+
+// MockVars mocks EFI variables as read by ReadVar* and modified by
+// SetVar and DeleteVar, only to be used from tests. Modifications are
+// recorded in the given maps, like the firmware would do, attrs is
+// left untouched if nil. Set vars to nil to mock a non-EFI system.
 func MockVars(vars map[string][]byte, attrs map[string]VariableAttr) (restore func()) {
 	osutil.MustBeTestBinary("MockVars only to be used from tests")
 	old := openEFIVar
+	oldWrite := writeEFIVar
+	oldDelete := deleteEFIVar
 	openEFIVar = func(name string) (io.ReadCloser, VariableAttr, int64, error) {
 		if vars == nil {
 			return nil, 0, 0, ErrNoEFISystem
@@ -175,10 +339,57 @@ func MockVars(vars map[string][]byte, attrs map[string]VariableAttr) (restore fu
 			}
 			return ioutil.NopCloser(bytes.NewBuffer(val)), attr, int64(len(val)), nil
 		}
-		return nil, 0, 0, fmt.Errorf("EFI variable %s not mocked", name)
+		return nil, 0, 0, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	writeEFIVar = func(name string, attr VariableAttr, data []byte) error {
+		if vars == nil {
+			return ErrNoEFISystem
+		}
+		if attr&VariableTimeBasedAuthenticatedWriteAccess != 0 {
+			// the firmware verifies and strips the descriptor
+			payload, err := authenticatedVarPayload(data)
+			if err != nil {
+				return err
+			}
+			data = payload
+		}
+		if attr&VariableAppendWrite != 0 {
+			attr &^= VariableAppendWrite
+			data = append(append([]byte(nil), vars[name]...), data...)
+		} else if len(data) == 0 {
+			// writing an empty value deletes the variable
+			delete(vars, name)
+			if attrs != nil {
+				delete(attrs, name)
+			}
+			return nil
+		}
+		vars[name] = append([]byte(nil), data...)
+		if attrs != nil {
+			attrs[name] = attr
+		}
+		return nil
+	}
+	deleteEFIVar = func(name string) error {
+		if vars == nil {
+			return ErrNoEFISystem
+		}
+		if _, ok := vars[name]; !ok {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+		}
+		if attrs != nil && attrs[name]&VariableTimeBasedAuthenticatedWriteAccess != 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+		}
+		delete(vars, name)
+		if attrs != nil {
+			delete(attrs, name)
+		}
+		return nil
 	}
 
 	return func() {
 		openEFIVar = old
+		writeEFIVar = oldWrite
+		deleteEFIVar = oldDelete
 	}
 }
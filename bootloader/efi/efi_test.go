@@ -20,11 +20,14 @@
 package efi_test
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -172,3 +175,223 @@ func (s *efiVarsSuite) TestStringOddSize(c *C) {
 	_, _, err := efi.ReadVarString("a")
 	c.Check(err, ErrorMatches, `EFI var "a" is not a valid UTF16 string, it has an extra byte`)
 }
+
+func (s *efiVarsSuite) TestSetVarImmutable(c *C) {
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "my-cool-efi-var")
+	err := ioutil.WriteFile(varPath, []byte("\x07\x00\x00\x00\x01"), 0644)
+	c.Assert(err, IsNil)
+
+	var calls []int32
+	restore := efi.MockFileAttr(func(f *os.File) (int32, error) {
+		c.Check(f.Name(), Equals, varPath)
+		return osutil.FS_IMMUTABLE_FL | osutil.FS_NOATIME_FL, nil
+	}, func(f *os.File, attr int32) error {
+		c.Check(f.Name(), Equals, varPath)
+		calls = append(calls, attr)
+		return nil
+	})
+	defer restore()
+
+	err = efi.SetVar("my-cool-efi-var", efi.VariableNonVolatile|efi.VariableBootServiceAccess|efi.VariableRuntimeAccess, []byte("\x02\x03"))
+	c.Assert(err, IsNil)
+	// the immutable flag was cleared and then restored
+	c.Check(calls, DeepEquals, []int32{osutil.FS_NOATIME_FL, osutil.FS_IMMUTABLE_FL | osutil.FS_NOATIME_FL})
+	c.Check(varPath, testutil.FileEquals, "\x07\x00\x00\x00\x02\x03")
+}
+
+func (s *efiVarsSuite) TestSetVarNew(c *C) {
+	restore := efi.MockFileAttr(func(f *os.File) (int32, error) {
+		c.Fatalf("unexpected call")
+		return 0, nil
+	}, nil)
+	defer restore()
+
+	err := efi.SetVar("my-cool-efi-var", efi.VariableBootServiceAccess|efi.VariableRuntimeAccess, []byte("\x01"))
+	c.Assert(err, IsNil)
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "my-cool-efi-var")
+	c.Check(varPath, testutil.FileEquals, "\x06\x00\x00\x00\x01")
+
+	data, attr, err := efi.ReadVarBytes("my-cool-efi-var")
+	c.Assert(err, IsNil)
+	c.Check(attr, Equals, efi.VariableBootServiceAccess|efi.VariableRuntimeAccess)
+	c.Check(data, DeepEquals, []byte("\x01"))
+}
+
+func (s *efiVarsSuite) TestSetVarClearImmutableError(c *C) {
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "my-cool-efi-var")
+	err := ioutil.WriteFile(varPath, []byte("\x07\x00\x00\x00\x01"), 0644)
+	c.Assert(err, IsNil)
+
+	restore := efi.MockFileAttr(func(f *os.File) (int32, error) {
+		return osutil.FS_IMMUTABLE_FL, nil
+	}, func(f *os.File, attr int32) error {
+		return fmt.Errorf("boom")
+	})
+	defer restore()
+
+	err = efi.SetVar("my-cool-efi-var", efi.VariableNonVolatile, nil)
+	c.Check(err, ErrorMatches, `cannot write EFI var "my-cool-efi-var": cannot clear immutable flag: boom`)
+	c.Check(varPath, testutil.FileEquals, "\x07\x00\x00\x00\x01")
+}
+
+func (s *efiVarsSuite) TestSetVarNoEFISystem(c *C) {
+	osutil.MockMountInfo("")
+
+	err := efi.SetVar("my-cool-efi-var", efi.VariableNonVolatile, nil)
+	c.Check(err, Equals, efi.ErrNoEFISystem)
+}
+
+func (s *efiVarsSuite) TestMockVarsWrite(c *C) {
+	vars := map[string][]byte{}
+	attrs := map[string]efi.VariableAttr{}
+	restore := efi.MockVars(vars, attrs)
+	defer restore()
+
+	err := efi.SetVar("a", efi.VariableNonVolatile, []byte("\x01"))
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string][]byte{"a": []byte("\x01")})
+	c.Check(attrs, DeepEquals, map[string]efi.VariableAttr{"a": efi.VariableNonVolatile})
+
+	b, attr, err := efi.ReadVarBytes("a")
+	c.Assert(err, IsNil)
+	c.Check(attr, Equals, efi.VariableNonVolatile)
+	c.Check(b, DeepEquals, []byte("\x01"))
+}
+
+func (s *efiVarsSuite) TestSetVarString(c *C) {
+	vars := map[string][]byte{}
+	restore := efi.MockVars(vars, nil)
+	defer restore()
+
+	err := efi.SetVarString("a", efi.VariableNonVolatile, "foo")
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string][]byte{"a": []byte("f\x00o\x00o\x00\x00\x00")})
+
+	v, _, err := efi.ReadVarString("a")
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, "foo")
+}
+
+func (s *efiVarsSuite) TestReadVarNotExist(c *C) {
+	restore := efi.MockVars(map[string][]byte{}, nil)
+	defer restore()
+
+	_, _, err := efi.ReadVarString("a")
+	c.Check(err, ErrorMatches, `cannot read EFI var "a": open a: file does not exist`)
+	c.Check(errors.Is(err, os.ErrNotExist), Equals, true)
+}
+
+func (s *efiVarsSuite) TestSetVarUnsupportedAttrs(c *C) {
+	err := efi.SetVar("my-cool-efi-var", efi.VariableNonVolatile|efi.VariableAuthenticatedWriteAccess, []byte("\x01"))
+	c.Check(err, ErrorMatches, `cannot write EFI var "my-cool-efi-var": count based authenticated variables are not supported`)
+
+	err = efi.SetVar("my-cool-efi-var", efi.VariableNonVolatile|efi.VariableTimeBasedAuthenticatedWriteAccess, []byte("\x01"))
+	c.Check(err, ErrorMatches, `cannot write EFI var "my-cool-efi-var": authentication descriptor too short`)
+
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "my-cool-efi-var")
+	c.Check(varPath, testutil.FileAbsent)
+}
+
+func (s *efiVarsSuite) TestSetVarAuthenticated(c *C) {
+	restore := efi.MockFileAttr(func(f *os.File) (int32, error) {
+		return 0, nil
+	}, nil)
+	defer restore()
+
+	data := efi.AuthenticatedVarData(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), []byte("sig"), []byte("\x01"))
+	err := efi.SetVar("db-d719b2cb-3d3a-4596-a3bc-dad00e67656f", efi.VariableNonVolatile|efi.VariableTimeBasedAuthenticatedWriteAccess|efi.VariableAppendWrite, data)
+	c.Assert(err, IsNil)
+	// the descriptor is passed as is to the firmware
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "db-d719b2cb-3d3a-4596-a3bc-dad00e67656f")
+	c.Check(varPath, testutil.FileEquals, append([]byte("\x61\x00\x00\x00"), data...))
+}
+
+func (s *efiVarsSuite) TestDeleteVarImmutable(c *C) {
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "my-cool-efi-var")
+	err := ioutil.WriteFile(varPath, []byte("\x07\x00\x00\x00\x01"), 0644)
+	c.Assert(err, IsNil)
+
+	var calls []int32
+	restore := efi.MockFileAttr(func(f *os.File) (int32, error) {
+		return osutil.FS_IMMUTABLE_FL, nil
+	}, func(f *os.File, attr int32) error {
+		calls = append(calls, attr)
+		return nil
+	})
+	defer restore()
+
+	err = efi.DeleteVar("my-cool-efi-var")
+	c.Assert(err, IsNil)
+	// the immutable flag was cleared and not restored
+	c.Check(calls, DeepEquals, []int32{0})
+	c.Check(varPath, testutil.FileAbsent)
+}
+
+func (s *efiVarsSuite) TestDeleteVarErrors(c *C) {
+	err := efi.DeleteVar("my-cool-efi-var")
+	c.Check(err, ErrorMatches, `cannot delete EFI var "my-cool-efi-var": open .*/my-cool-efi-var: no such file or directory`)
+
+	varPath := filepath.Join(s.rootdir, "/sys/firmware/efi/efivars", "my-cool-efi-var")
+	err = ioutil.WriteFile(varPath, []byte("\x07\x00\x00\x00\x01"), 0644)
+	c.Assert(err, IsNil)
+	restore := efi.MockFileAttr(func(f *os.File) (int32, error) {
+		return 0, fmt.Errorf("boom")
+	}, nil)
+	defer restore()
+	err = efi.DeleteVar("my-cool-efi-var")
+	c.Check(err, ErrorMatches, `cannot delete EFI var "my-cool-efi-var": cannot get file attributes: boom`)
+	c.Check(varPath, testutil.FilePresent)
+
+	osutil.MockMountInfo("")
+	err = efi.DeleteVar("my-cool-efi-var")
+	c.Check(err, Equals, efi.ErrNoEFISystem)
+}
+
+func (s *efiVarsSuite) TestMockVarsAppendAndDelete(c *C) {
+	vars := map[string][]byte{"a": []byte("\x01")}
+	attrs := map[string]efi.VariableAttr{}
+	restore := efi.MockVars(vars, attrs)
+	defer restore()
+
+	err := efi.SetVar("a", efi.VariableNonVolatile|efi.VariableAppendWrite, []byte("\x02"))
+	c.Assert(err, IsNil)
+	c.Check(vars["a"], DeepEquals, []byte("\x01\x02"))
+	c.Check(attrs["a"], Equals, efi.VariableNonVolatile)
+
+	// writing an empty value deletes the variable
+	err = efi.SetVar("a", efi.VariableNonVolatile, nil)
+	c.Assert(err, IsNil)
+	c.Check(vars, HasLen, 0)
+	c.Check(attrs, HasLen, 0)
+
+	vars["b"] = []byte("\x01")
+	err = efi.DeleteVar("b")
+	c.Assert(err, IsNil)
+	c.Check(vars, HasLen, 0)
+
+	err = efi.DeleteVar("b")
+	c.Check(err, ErrorMatches, `cannot delete EFI var "b": remove b: file does not exist`)
+}
+
+func (s *efiVarsSuite) TestMockVarsAuthenticated(c *C) {
+	vars := map[string][]byte{}
+	attrs := map[string]efi.VariableAttr{}
+	restore := efi.MockVars(vars, attrs)
+	defer restore()
+
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	authAttrs := efi.VariableNonVolatile | efi.VariableBootServiceAccess | efi.VariableRuntimeAccess | efi.VariableTimeBasedAuthenticatedWriteAccess
+	err := efi.SetVar("db", authAttrs, efi.AuthenticatedVarData(t0, []byte("sig"), []byte("\x01")))
+	c.Assert(err, IsNil)
+	// the descriptor was stripped like the firmware does
+	c.Check(vars["db"], DeepEquals, []byte("\x01"))
+	c.Check(attrs["db"], Equals, authAttrs)
+
+	// authenticated variables cannot be simply removed
+	err = efi.DeleteVar("db")
+	c.Check(err, ErrorMatches, `cannot delete EFI var "db": remove db: permission denied`)
+
+	err = efi.SetVar("db", authAttrs, efi.AuthenticatedVarData(t0.Add(time.Hour), []byte("sig"), nil))
+	c.Assert(err, IsNil)
+	c.Check(vars, HasLen, 0)
+}
@@ -109,3 +109,15 @@ var (
 	ConfigAssetFrom                      = configAssetFrom
 	StaticCommandLineForGrubAssetEdition = staticCommandLineForGrubAssetEdition
 )
+
+func NewSdboot(rootdir string, opts *Options) ExtractedRunKernelImageBootloader {
+	return newSdboot(rootdir, opts).(ExtractedRunKernelImageBootloader)
+}
+
+func MockSdbootFiles(c *C, rootdir string, opts *Options) {
+	s := newSdboot(rootdir, opts).(*sdboot)
+	err := os.MkdirAll(filepath.Dir(s.ConfigFile()), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(s.ConfigFile(), sdbootDefaultLoaderConf(s.varsEntry()), 0644)
+	c.Assert(err, IsNil)
+}
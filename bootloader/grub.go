@@ -39,6 +39,7 @@ var (
 	_ RecoveryAwareBootloader           = (*grub)(nil)
 	_ ExtractedRunKernelImageBootloader = (*grub)(nil)
 	_ TrustedAssetsBootloader           = (*grub)(nil)
+	_ CmdlineDropInsBootloader          = (*grub)(nil)
 )
 
 type grub struct {
@@ -440,6 +441,48 @@ func (g *grub) CandidateCommandLine(modeArg, systemArg, extraArgs string) (strin
 	return g.commandLineForEdition(edition, modeArg, systemArg, extraArgs)
 }
 
+func (g *grub) cmdlineDropIns() (*cmdlineDropInsDir, error) {
+	if !g.nativePartitionLayout || g.recovery {
+		return nil, fmt.Errorf("command line drop-ins are only supported by the run mode bootloader")
+	}
+	return &cmdlineDropInsDir{dir: filepath.Join(g.dir(), "cmdline.d"), bl: g}, nil
+}
+
+// CmdlineDropIns returns the installed command line drop-ins sorted by name.
+//
+// Implements CmdlineDropInsBootloader for the grub bootloader.
+func (g *grub) CmdlineDropIns() ([]CmdlineDropIn, error) {
+	d, err := g.cmdlineDropIns()
+	if err != nil {
+		return nil, err
+	}
+	return d.list()
+}
+
+// InstallCmdlineDropIn installs or replaces the named command line drop-in
+// and updates the extra arguments in the grub environment.
+//
+// Implements CmdlineDropInsBootloader for the grub bootloader.
+func (g *grub) InstallCmdlineDropIn(name, args string) error {
+	d, err := g.cmdlineDropIns()
+	if err != nil {
+		return err
+	}
+	return d.install(name, args)
+}
+
+// RemoveCmdlineDropIn removes the named command line drop-in and updates the
+// extra arguments in the grub environment.
+//
+// Implements CmdlineDropInsBootloader for the grub bootloader.
+func (g *grub) RemoveCmdlineDropIn(name string) error {
+	d, err := g.cmdlineDropIns()
+	if err != nil {
+		return err
+	}
+	return d.remove(name)
+}
+
 // staticCommandLineForGrubAssetEdition fetches a static command line for given
 // grub asset edition
 func staticCommandLineForGrubAssetEdition(asset string, edition uint) string {
@@ -1079,3 +1079,64 @@ func (s *grubTestSuite) TestBootChainsNotRecoveryBootloader(c *C) {
 	_, err := tab.BootChain(g2, "kernel.snap")
 	c.Assert(err, ErrorMatches, "not a recovery bootloader")
 }
+
+func (s *grubTestSuite) TestCmdlineDropIns(c *C) {
+	s.makeFakeGrubEFINativeEnv(c, nil)
+
+	g := bootloader.NewGrub(s.rootdir, &bootloader.Options{Role: bootloader.RoleRunMode, NoSlashBoot: true})
+	dbl, ok := g.(bootloader.CmdlineDropInsBootloader)
+	c.Assert(ok, Equals, true)
+
+	dropIns, err := dbl.CmdlineDropIns()
+	c.Assert(err, IsNil)
+	c.Check(dropIns, HasLen, 0)
+
+	c.Assert(dbl.InstallCmdlineDropIn("50-debug", "debug=1"), IsNil)
+	c.Assert(dbl.InstallCmdlineDropIn("10-gadget", "quiet splash"), IsNil)
+	c.Check(filepath.Join(s.grubEFINativeDir(), "cmdline.d/10-gadget.conf"), testutil.FileEquals, "quiet splash\n")
+
+	dropIns, err = dbl.CmdlineDropIns()
+	c.Assert(err, IsNil)
+	c.Check(dropIns, DeepEquals, []bootloader.CmdlineDropIn{
+		{Name: "10-gadget", Args: "quiet splash"},
+		{Name: "50-debug", Args: "debug=1"},
+	})
+	vars, err := g.GetBootVars("snapd_extra_cmdline_args")
+	c.Assert(err, IsNil)
+	c.Check(vars["snapd_extra_cmdline_args"], Equals, "quiet splash debug=1")
+
+	// replace one
+	c.Assert(dbl.InstallCmdlineDropIn("50-debug", "debug=2"), IsNil)
+	vars, err = g.GetBootVars("snapd_extra_cmdline_args")
+	c.Assert(err, IsNil)
+	c.Check(vars["snapd_extra_cmdline_args"], Equals, "quiet splash debug=2")
+
+	// remove, also when it is gone already
+	c.Assert(dbl.RemoveCmdlineDropIn("10-gadget"), IsNil)
+	c.Assert(dbl.RemoveCmdlineDropIn("10-gadget"), IsNil)
+	vars, err = g.GetBootVars("snapd_extra_cmdline_args")
+	c.Assert(err, IsNil)
+	c.Check(vars["snapd_extra_cmdline_args"], Equals, "debug=2")
+
+	err = dbl.InstallCmdlineDropIn("50-debug", "snapd_recovery_mode=install")
+	c.Assert(err, ErrorMatches, `invalid command line drop-in "50-debug": argument "snapd_recovery_mode" is reserved`)
+	err = dbl.RemoveCmdlineDropIn("../grubenv")
+	c.Assert(err, ErrorMatches, `invalid command line drop-in name "../grubenv"`)
+}
+
+func (s *grubTestSuite) TestCmdlineDropInsRunModeOnly(c *C) {
+	s.makeFakeGrubEFINativeEnv(c, nil)
+
+	for _, opts := range []*bootloader.Options{
+		{Role: bootloader.RoleRecovery},
+		nil,
+	} {
+		g := bootloader.NewGrub(s.rootdir, opts)
+		dbl, ok := g.(bootloader.CmdlineDropInsBootloader)
+		c.Assert(ok, Equals, true)
+		_, err := dbl.CmdlineDropIns()
+		c.Check(err, ErrorMatches, "command line drop-ins are only supported by the run mode bootloader")
+		err = dbl.InstallCmdlineDropIn("50-debug", "debug=1")
+		c.Check(err, ErrorMatches, "command line drop-ins are only supported by the run mode bootloader")
+	}
+}
@@ -0,0 +1,574 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/bootloader/efi"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+)
+
+// sanity - sdboot implements the required interfaces
+var (
+	_ Bootloader                             = (*sdboot)(nil)
+	_ installableBootloader                  = (*sdboot)(nil)
+	_ ExtractedRunKernelImageBootloader      = (*sdboot)(nil)
+	_ ExtractedRecoveryKernelImageBootloader = (*sdboot)(nil)
+)
+
+const (
+	// sdbootEntry is the boot loader entry managed by snapd, it is the
+	// default entry and also carries the boot variables.
+	sdbootEntry = "ubuntu-core.conf"
+	// sdbootTryEntry is the boot loader entry of the snaps being tried,
+	// it is booted once through the LoaderEntryOneShot variable.
+	sdbootTryEntry = "ubuntu-core-try.conf"
+	// sdbootRecoveryEntry is the boot loader entry of the recovery
+	// systems in ubuntu-seed, it carries the boot variables of the
+	// recovery bootloader. It is named apart from the run mode entries
+	// which systemd-boot finds next to it in ubuntu-boot, the extended
+	// boot loader partition.
+	sdbootRecoveryEntry = "ubuntu-core-recovery.conf"
+
+	sdbootStaticCmdline = "console=ttyS0 console=tty1 panic=-1"
+
+	// see https://systemd.io/BOOT_LOADER_INTERFACE/
+	sdbootLoaderVendorGUID = "4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+)
+
+var (
+	sdbootLoaderEntryOneShotVar  = "LoaderEntryOneShot-" + sdbootLoaderVendorGUID
+	sdbootLoaderEntrySelectedVar = "LoaderEntrySelected-" + sdbootLoaderVendorGUID
+)
+
+func sdbootDefaultLoaderConf(defaultEntry string) []byte {
+	return []byte(`timeout 0
+default ` + defaultEntry + `
+editor no
+`)
+}
+
+// sdboot is the systemd-boot bootloader, booting from boot loader entries
+// in the EFI system partition as described by the boot loader
+// specification, see https://uapi-group.org/specifications/specs/boot_loader_specification/
+//
+// On UC20 systemd-boot is installed in ubuntu-seed, which is the EFI system
+// partition, and ubuntu-boot must be the extended boot loader partition
+// (XBOOTLDR) so that systemd-boot also loads the run mode entries from it.
+// The recovery bootloader selects the default entry in loader.conf: its own
+// entry in install and recover modes and the run mode entry of ubuntu-boot
+// in run mode.
+type sdboot struct {
+	rootdir string
+	basedir string
+
+	role Role
+}
+
+func (s *sdboot) processBlOpts(blOpts *Options) {
+	s.role = RoleSole
+	s.basedir = "boot/efi"
+	if blOpts != nil {
+		s.role = blOpts.Role
+		if blOpts.Role == RoleRecovery || blOpts.NoSlashBoot {
+			// native layout, the loader directory is at the
+			// root of the partition
+			s.basedir = ""
+		}
+	}
+}
+
+// newSdboot creates a new systemd-boot bootloader object
+func newSdboot(rootdir string, blOpts *Options) Bootloader {
+	s := &sdboot{rootdir: rootdir}
+	s.processBlOpts(blOpts)
+	return s
+}
+
+func (s *sdboot) Name() string {
+	return "sdboot"
+}
+
+func (s *sdboot) setRootDir(rootdir string) {
+	s.rootdir = rootdir
+}
+
+func (s *sdboot) dir() string {
+	if s.rootdir == "" {
+		panic("internal error: unset rootdir")
+	}
+	return filepath.Join(s.rootdir, s.basedir)
+}
+
+func (s *sdboot) ConfigFile() string {
+	return filepath.Join(s.dir(), "loader/loader.conf")
+}
+
+func (s *sdboot) entryFile(name string) string {
+	return filepath.Join(s.dir(), "loader/entries", name)
+}
+
+// varsEntry returns the boot loader entry carrying the boot variables.
+func (s *sdboot) varsEntry() string {
+	if s.role == RoleRecovery {
+		return sdbootRecoveryEntry
+	}
+	return sdbootEntry
+}
+
+func (s *sdboot) InstallBootConfig(gadgetDir string, blOpts *Options) (bool, error) {
+	gadgetFile := filepath.Join(gadgetDir, s.Name()+".conf")
+	st, err := os.Stat(gadgetFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	// InstallBootConfig gets called on a sdboot that does not come from
+	// newSdboot so we need to apply the options here
+	s.processBlOpts(blOpts)
+
+	if st.Size() != 0 {
+		return genericInstallBootConfig(gadgetFile, s.ConfigFile())
+	}
+	// an empty sdboot.conf in the gadget means that we use our own
+	// loader.conf, booting the snapd managed entry
+	if err := os.MkdirAll(filepath.Dir(s.ConfigFile()), 0755); err != nil {
+		return true, err
+	}
+	return true, osutil.AtomicWriteFile(s.ConfigFile(), sdbootDefaultLoaderConf(s.varsEntry()), 0644, 0)
+}
+
+// setDefaultEntry sets the default entry in loader.conf, keeping the other
+// settings.
+func (s *sdboot) setDefaultEntry(name string) error {
+	content, err := ioutil.ReadFile(s.ConfigFile())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	found := false
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "default" {
+			if found {
+				continue
+			}
+			found = true
+			line = "default " + name + "\n"
+		}
+		buf.WriteString(line)
+	}
+	if !found {
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("default " + name + "\n")
+	}
+	if bytes.Equal(buf.Bytes(), content) {
+		return nil
+	}
+	return osutil.AtomicWriteFile(s.ConfigFile(), buf.Bytes(), 0644, 0)
+}
+
+// tryStatusVar returns the boot variable tracking the status of a try boot
+// for the role of the bootloader.
+func (s *sdboot) tryStatusVar() string {
+	switch s.role {
+	case RoleSole:
+		return "snap_mode"
+	case RoleRunMode:
+		return "kernel_status"
+	}
+	return ""
+}
+
+func (s *sdboot) GetBootVars(names ...string) (map[string]string, error) {
+	e, err := s.loadEntry(s.varsEntry())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(names))
+	for _, name := range names {
+		v := e.vars[name]
+		if name == s.tryStatusVar() && v == "try" {
+			// unlike grub, systemd-boot cannot update the boot
+			// variables, find out how far the try boot went
+			v, err = s.tryStatus()
+			if err != nil {
+				return nil, err
+			}
+		}
+		out[name] = v
+	}
+	return out, nil
+}
+
+// tryStatus returns the status of a requested try boot, as it would have been
+// set by a bootloader that updates the status itself: "try" when the try
+// entry was not booted yet, "trying" when it has been booted and "" when it
+// was booted, but a fallback to the default entry happened since.
+func (s *sdboot) tryStatus() (string, error) {
+	oneShot, _, err := efi.ReadVarString(sdbootLoaderEntryOneShotVar)
+	switch {
+	case err == efi.ErrNoEFISystem:
+		// nothing to tell, eg. when building an image
+		return "try", nil
+	case err == nil && oneShot == sdbootTryEntry:
+		// systemd-boot consumes the variable when booting the entry
+		return "try", nil
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return "", err
+	}
+	selected, _, err := efi.ReadVarString(sdbootLoaderEntrySelectedVar)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if selected == sdbootTryEntry {
+		return "trying", nil
+	}
+	return "", nil
+}
+
+func (s *sdboot) SetBootVars(values map[string]string) error {
+	e, err := s.loadEntry(s.varsEntry())
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		if v == "" {
+			delete(e.vars, k)
+		} else {
+			e.vars[k] = v
+		}
+	}
+	s.render(e, e.vars, false)
+	if err := s.saveEntry(s.varsEntry(), e); err != nil {
+		return err
+	}
+
+	if s.role == RoleRecovery {
+		// in run mode the run mode entry of ubuntu-boot is booted
+		defaultEntry := sdbootRecoveryEntry
+		if e.vars["snapd_recovery_mode"] == "run" {
+			defaultEntry = sdbootEntry
+		}
+		if err := s.setDefaultEntry(defaultEntry); err != nil {
+			return fmt.Errorf("cannot set default boot loader entry: %v", err)
+		}
+	}
+
+	status, ok := values[s.tryStatusVar()]
+	if !ok || s.tryStatusVar() == "" {
+		return nil
+	}
+	return s.updateTryBoot(e.vars, status)
+}
+
+// updateTryBoot sets up or clears a try boot of the try entry according to the
+// given try boot status.
+func (s *sdboot) updateTryBoot(vars map[string]string, status string) error {
+	switch status {
+	case "try":
+		if s.role == RoleSole {
+			// the try entry is rendered from the boot
+			// variables only
+			if err := s.saveEntry(sdbootTryEntry, s.render(&sdbootConfEntry{}, vars, true)); err != nil {
+				return err
+			}
+		} else {
+			// the try kernel is set by EnableTryKernel, refresh
+			// the command line
+			e, err := s.loadEntry(sdbootTryEntry)
+			if err != nil {
+				return err
+			}
+			if e.efi == "" {
+				return fmt.Errorf("cannot try boot: no try-kernel enabled")
+			}
+			if err := s.saveEntry(sdbootTryEntry, s.render(e, vars, true)); err != nil {
+				return err
+			}
+		}
+		err := efi.SetVarString(sdbootLoaderEntryOneShotVar, efi.VariableNonVolatile|efi.VariableBootServiceAccess|efi.VariableRuntimeAccess, sdbootTryEntry)
+		if err != nil && err != efi.ErrNoEFISystem {
+			return fmt.Errorf("cannot set up try boot: %v", err)
+		}
+	case "":
+		if s.role == RoleSole {
+			if err := s.removeEntry(sdbootTryEntry); err != nil {
+				return err
+			}
+		}
+		err := efi.DeleteVar(sdbootLoaderEntryOneShotVar)
+		if err != nil && err != efi.ErrNoEFISystem && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot clear try boot: %v", err)
+		}
+	}
+	return nil
+}
+
+// render updates the boot loader keys of the given entry from the given boot
+// variables and returns the entry.
+func (s *sdboot) render(e *sdbootConfEntry, vars map[string]string, try bool) *sdbootConfEntry {
+	e.title = "Ubuntu Core"
+	if try {
+		e.title += " (try)"
+	}
+	switch s.role {
+	case RoleSole:
+		// UC16/18 boot the kernel and initrd extracted from the
+		// kernel snap, the initramfs takes the snaps to mount from
+		// the command line
+		kernel, core := vars["snap_kernel"], vars["snap_core"]
+		if try {
+			if v := vars["snap_try_kernel"]; v != "" {
+				kernel = v
+			}
+			if v := vars["snap_try_core"]; v != "" {
+				core = v
+			}
+		}
+		e.linux, e.initrd, e.options = "", "", ""
+		if kernel != "" {
+			e.linux = "/" + filepath.Join(kernel, "kernel.img")
+			e.initrd = "/" + filepath.Join(kernel, "initrd.img")
+			e.options = fmt.Sprintf("snap_core=%s snap_kernel=%s", core, kernel)
+		}
+	case RoleRunMode:
+		// the kernel.efi is set by EnableKernel/EnableTryKernel
+		e.options = strings.TrimSpace("snapd_recovery_mode=run " + sdbootStaticCmdline + " " + vars["snapd_extra_cmdline_args"])
+	case RoleRecovery:
+		mode := vars["snapd_recovery_mode"]
+		if mode == "" {
+			mode = "install"
+		}
+		system := vars["snapd_recovery_system"]
+		e.efi, e.options = "", ""
+		// in run mode the entry is left without an image so that
+		// systemd-boot does not list it, the run mode entries of
+		// ubuntu-boot are booted instead
+		if system != "" && mode != "run" {
+			e.efi = "/" + filepath.Join("systems", system, "kernel/kernel.efi")
+			e.options = fmt.Sprintf("snapd_recovery_mode=%s snapd_recovery_system=%s %s", mode, system, sdbootStaticCmdline)
+		}
+	}
+	return e
+}
+
+func (s *sdboot) ExtractKernelAssets(sn snap.PlaceInfo, snapf snap.Container) error {
+	assets := []string{"kernel.img", "initrd.img", "dtbs/*"}
+	if s.role == RoleRunMode {
+		assets = []string{"kernel.efi"}
+	}
+	return extractKernelAssetsToBootDir(filepath.Join(s.dir(), sn.Filename()), snapf, assets)
+}
+
+func (s *sdboot) ExtractRecoveryKernelAssets(recoverySystemDir string, sn snap.PlaceInfo, snapf snap.Container) error {
+	if recoverySystemDir == "" {
+		return fmt.Errorf("internal error: recoverySystemDir unset")
+	}
+	dstDir := filepath.Join(s.rootdir, recoverySystemDir, "kernel")
+	return extractKernelAssetsToBootDir(dstDir, snapf, []string{"kernel.efi"})
+}
+
+func (s *sdboot) RemoveKernelAssets(sn snap.PlaceInfo) error {
+	return removeKernelAssetsFromBootDir(s.dir(), sn)
+}
+
+func (s *sdboot) enableKernel(sn snap.PlaceInfo, name string) error {
+	kernelEfi := filepath.Join(sn.Filename(), "kernel.efi")
+	// check that the kernel snap has been extracted already so that
+	// the entry is bootable
+	if !osutil.FileExists(filepath.Join(s.dir(), kernelEfi)) {
+		return fmt.Errorf("cannot enable kernel in %s at %s: %v", name, kernelEfi, os.ErrNotExist)
+	}
+	// the boot variables are kept in the default entry
+	vars, err := s.loadEntry(s.varsEntry())
+	if err != nil {
+		return err
+	}
+	e, err := s.loadEntry(name)
+	if err != nil {
+		return err
+	}
+	e.efi = "/" + kernelEfi
+	return s.saveEntry(name, s.render(e, vars.vars, name == sdbootTryEntry))
+}
+
+func (s *sdboot) readKernel(name string) (snap.PlaceInfo, error) {
+	e, err := s.loadEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	if e.efi == "" {
+		return nil, fmt.Errorf("cannot find kernel in boot loader entry %s", name)
+	}
+	kernelSnapFileName := filepath.Base(filepath.Dir(e.efi))
+	sn, err := snap.ParsePlaceInfoFromSnapFileName(kernelSnapFileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse kernel snap file name from boot loader entry %s: %v", name, err)
+	}
+	return sn, nil
+}
+
+// EnableKernel sets the kernel of the default boot loader entry, the kernel
+// should already have been extracted.
+func (s *sdboot) EnableKernel(sn snap.PlaceInfo) error {
+	return s.enableKernel(sn, sdbootEntry)
+}
+
+// EnableTryKernel sets the kernel of the try boot loader entry, the kernel
+// should already have been extracted.
+func (s *sdboot) EnableTryKernel(sn snap.PlaceInfo) error {
+	return s.enableKernel(sn, sdbootTryEntry)
+}
+
+// DisableTryKernel removes the try boot loader entry if it exists.
+func (s *sdboot) DisableTryKernel() error {
+	return s.removeEntry(sdbootTryEntry)
+}
+
+// Kernel returns the kernel of the default boot loader entry.
+func (s *sdboot) Kernel() (snap.PlaceInfo, error) {
+	return s.readKernel(sdbootEntry)
+}
+
+// TryKernel returns the kernel of the try boot loader entry or
+// ErrNoTryKernelRef if there is no such entry.
+func (s *sdboot) TryKernel() (snap.PlaceInfo, error) {
+	if !osutil.FileExists(s.entryFile(sdbootTryEntry)) {
+		return nil, ErrNoTryKernelRef
+	}
+	return s.readKernel(sdbootTryEntry)
+}
+
+// sdbootConfEntry is a boot loader entry file managed by snapd, snapd boot
+// variables are kept as specially formatted comments that systemd-boot
+// ignores.
+type sdbootConfEntry struct {
+	vars map[string]string
+
+	title   string
+	linux   string
+	initrd  string
+	efi     string
+	options string
+}
+
+const sdbootVarPrefix = "# snapd-var "
+
+func (s *sdboot) loadEntry(name string) (*sdbootConfEntry, error) {
+	e := &sdbootConfEntry{vars: make(map[string]string)}
+	f, err := os.Open(s.entryFile(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return e, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, sdbootVarPrefix) {
+			kv := strings.SplitN(strings.TrimPrefix(line, sdbootVarPrefix), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("cannot parse boot variable %q in boot loader entry %s", line, name)
+			}
+			e.vars[kv[0]] = kv[1]
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		value := ""
+		if len(fields) == 2 {
+			value = strings.TrimSpace(fields[1])
+		}
+		switch fields[0] {
+		case "title":
+			e.title = value
+		case "linux":
+			e.linux = value
+		case "initrd":
+			e.initrd = value
+		case "efi":
+			e.efi = value
+		case "options":
+			e.options = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read boot loader entry %s: %v", name, err)
+	}
+	return e, nil
+}
+
+func (s *sdboot) saveEntry(name string, e *sdbootConfEntry) error {
+	var buf bytes.Buffer
+	buf.WriteString("# this file is managed by snapd, do not edit\n")
+	keys := make([]string, 0, len(e.vars))
+	for k := range e.vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s%s=%s\n", sdbootVarPrefix, k, e.vars[k])
+	}
+	for _, kv := range [][2]string{
+		{"title", e.title},
+		{"linux", e.linux},
+		{"initrd", e.initrd},
+		{"efi", e.efi},
+		{"options", e.options},
+	} {
+		if kv[1] != "" {
+			fmt.Fprintf(&buf, "%s %s\n", kv[0], kv[1])
+		}
+	}
+
+	entryFile := s.entryFile(name)
+	if err := os.MkdirAll(filepath.Dir(entryFile), 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(entryFile, buf.Bytes(), 0644, 0)
+}
+
+func (s *sdboot) removeEntry(name string) error {
+	if err := os.Remove(s.entryFile(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
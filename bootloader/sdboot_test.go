@@ -0,0 +1,378 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/bootloader/bootloadertest"
+	"github.com/snapcore/snapd/bootloader/efi"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snapfile"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+const (
+	loaderEntryOneShotVar  = "LoaderEntryOneShot-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+	loaderEntrySelectedVar = "LoaderEntrySelected-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+)
+
+type sdbootTestSuite struct {
+	baseBootenvTestSuite
+
+	efiVars map[string][]byte
+}
+
+var _ = Suite(&sdbootTestSuite{})
+
+func (s *sdbootTestSuite) SetUpTest(c *C) {
+	s.baseBootenvTestSuite.SetUpTest(c)
+	s.efiVars = map[string][]byte{}
+	s.AddCleanup(efi.MockVars(s.efiVars, nil))
+}
+
+func (s *sdbootTestSuite) TestNewSdboot(c *C) {
+	bootloader.MockSdbootFiles(c, s.rootdir, nil)
+	b := bootloader.NewSdboot(s.rootdir, nil)
+	c.Assert(b, NotNil)
+	c.Check(b.Name(), Equals, "sdboot")
+	c.Check(b.ConfigFile(), Equals, filepath.Join(s.rootdir, "boot/efi/loader/loader.conf"))
+
+	found, err := bootloader.Find(s.rootdir, nil)
+	c.Assert(err, IsNil)
+	c.Check(found.Name(), Equals, "sdboot")
+}
+
+func (s *sdbootTestSuite) TestConfigFilePlacement(c *C) {
+	for _, tc := range []struct {
+		opts       *bootloader.Options
+		configFile string
+	}{
+		{nil, "boot/efi/loader/loader.conf"},
+		{&bootloader.Options{Role: bootloader.RoleRunMode}, "boot/efi/loader/loader.conf"},
+		{&bootloader.Options{Role: bootloader.RoleRunMode, NoSlashBoot: true}, "loader/loader.conf"},
+		{&bootloader.Options{Role: bootloader.RoleRecovery}, "loader/loader.conf"},
+	} {
+		b := bootloader.NewSdboot(s.rootdir, tc.opts)
+		c.Check(b.ConfigFile(), Equals, filepath.Join(s.rootdir, tc.configFile), Commentf("%+v", tc.opts))
+	}
+}
+
+func (s *sdbootTestSuite) TestInstallBootConfig(c *C) {
+	gadgetDir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(gadgetDir, "sdboot.conf"), nil, 0644)
+	c.Assert(err, IsNil)
+
+	opts := &bootloader.Options{Role: bootloader.RoleRecovery}
+	err = bootloader.InstallBootConfig(gadgetDir, s.rootdir, opts)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "loader/loader.conf"), testutil.FileEquals, `timeout 0
+default ubuntu-core-recovery.conf
+editor no
+`)
+
+	b, err := bootloader.ForGadget(gadgetDir, s.rootdir, opts)
+	c.Assert(err, IsNil)
+	c.Check(b.Name(), Equals, "sdboot")
+}
+
+func (s *sdbootTestSuite) TestInstallBootConfigFromGadget(c *C) {
+	gadgetDir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(gadgetDir, "sdboot.conf"), []byte("timeout 3\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = bootloader.InstallBootConfig(gadgetDir, s.rootdir, nil)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "boot/efi/loader/loader.conf"), testutil.FileEquals, "timeout 3\n")
+}
+
+func (s *sdbootTestSuite) TestSetGetBootVars(c *C) {
+	bootloader.MockSdbootFiles(c, s.rootdir, nil)
+	b := bootloader.NewSdboot(s.rootdir, nil)
+
+	err := b.SetBootVars(map[string]string{
+		"snap_mode":   "",
+		"snap_core":   "core_4.snap",
+		"snap_kernel": "pc-kernel_2.snap",
+	})
+	c.Assert(err, IsNil)
+
+	m, err := b.GetBootVars("snap_mode", "snap_core", "snap_kernel", "unset")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snap_mode":   "",
+		"snap_core":   "core_4.snap",
+		"snap_kernel": "pc-kernel_2.snap",
+		"unset":       "",
+	})
+
+	c.Check(filepath.Join(s.rootdir, "boot/efi/loader/entries/ubuntu-core.conf"), testutil.FileEquals, `# this file is managed by snapd, do not edit
+# snapd-var snap_core=core_4.snap
+# snapd-var snap_kernel=pc-kernel_2.snap
+title Ubuntu Core
+linux /pc-kernel_2.snap/kernel.img
+initrd /pc-kernel_2.snap/initrd.img
+options snap_core=core_4.snap snap_kernel=pc-kernel_2.snap
+`)
+}
+
+func (s *sdbootTestSuite) TestTryBoot(c *C) {
+	bootloader.MockSdbootFiles(c, s.rootdir, nil)
+	b := bootloader.NewSdboot(s.rootdir, nil)
+
+	err := b.SetBootVars(map[string]string{
+		"snap_core":       "core_4.snap",
+		"snap_kernel":     "pc-kernel_2.snap",
+		"snap_try_kernel": "pc-kernel_3.snap",
+		"snap_mode":       "try",
+	})
+	c.Assert(err, IsNil)
+
+	tryEntry := filepath.Join(s.rootdir, "boot/efi/loader/entries/ubuntu-core-try.conf")
+	c.Check(tryEntry, testutil.FileEquals, `# this file is managed by snapd, do not edit
+title Ubuntu Core (try)
+linux /pc-kernel_3.snap/kernel.img
+initrd /pc-kernel_3.snap/initrd.img
+options snap_core=core_4.snap snap_kernel=pc-kernel_3.snap
+`)
+	c.Check(s.efiVars[loaderEntryOneShotVar], DeepEquals, bootloadertest.UTF16Bytes("ubuntu-core-try.conf"))
+
+	// not booted yet
+	m, err := b.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(m["snap_mode"], Equals, "try")
+
+	// systemd-boot booted the try entry
+	delete(s.efiVars, loaderEntryOneShotVar)
+	s.efiVars[loaderEntrySelectedVar] = bootloadertest.UTF16Bytes("ubuntu-core-try.conf")
+	m, err = b.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(m["snap_mode"], Equals, "trying")
+
+	// and fell back to the default entry on the next boot
+	s.efiVars[loaderEntrySelectedVar] = bootloadertest.UTF16Bytes("ubuntu-core.conf")
+	m, err = b.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(m["snap_mode"], Equals, "")
+
+	// clearing the try boot
+	s.efiVars[loaderEntryOneShotVar] = bootloadertest.UTF16Bytes("ubuntu-core-try.conf")
+	err = b.SetBootVars(map[string]string{
+		"snap_try_kernel": "",
+		"snap_mode":       "",
+	})
+	c.Assert(err, IsNil)
+	c.Check(osutil.FileExists(tryEntry), Equals, false)
+	c.Check(s.efiVars, Not(testutil.Contains), loaderEntryOneShotVar)
+}
+
+func (s *sdbootTestSuite) TestTryBootNoEFISystem(c *C) {
+	s.AddCleanup(efi.MockVars(nil, nil))
+	bootloader.MockSdbootFiles(c, s.rootdir, nil)
+	b := bootloader.NewSdboot(s.rootdir, nil)
+
+	err := b.SetBootVars(map[string]string{
+		"snap_kernel":     "pc-kernel_2.snap",
+		"snap_try_kernel": "pc-kernel_3.snap",
+		"snap_mode":       "try",
+	})
+	c.Assert(err, IsNil)
+
+	m, err := b.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(m["snap_mode"], Equals, "try")
+}
+
+func (s *sdbootTestSuite) makeKernelSnap(c *C, revision int) (snap.PlaceInfo, snap.Container) {
+	files := [][]string{
+		{"kernel.efi", "I'm a kernel.efi"},
+		{"kernel.img", "I'm a kernel"},
+		// must be last
+		{"meta/kernel.yaml", "version: 4.2"},
+	}
+	si := &snap.SideInfo{
+		RealName: "pc-kernel",
+		Revision: snap.R(revision),
+	}
+	fn := snaptest.MakeTestSnapWithFiles(c, packageKernel, files)
+	snapf, err := snapfile.Open(fn)
+	c.Assert(err, IsNil)
+	info, err := snap.ReadInfoFromSnapFile(snapf, si)
+	c.Assert(err, IsNil)
+	return info, snapf
+}
+
+func (s *sdbootTestSuite) TestRunModeKernels(c *C) {
+	opts := &bootloader.Options{Role: bootloader.RoleRunMode}
+	bootloader.MockSdbootFiles(c, s.rootdir, opts)
+	b := bootloader.NewSdboot(s.rootdir, opts)
+
+	kernel, snapf := s.makeKernelSnap(c, 1)
+	err := b.EnableKernel(kernel)
+	c.Assert(err, ErrorMatches, "cannot enable kernel in ubuntu-core.conf at pc-kernel_1.snap/kernel.efi: file does not exist")
+
+	err = b.ExtractKernelAssets(kernel, snapf)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "boot/efi/pc-kernel_1.snap/kernel.efi"), testutil.FileEquals, "I'm a kernel.efi")
+	c.Check(filepath.Join(s.rootdir, "boot/efi/pc-kernel_1.snap/kernel.img"), testutil.FileAbsent)
+
+	err = b.SetBootVars(map[string]string{"snapd_extra_cmdline_args": "foo=bar"})
+	c.Assert(err, IsNil)
+	err = b.EnableKernel(kernel)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "boot/efi/loader/entries/ubuntu-core.conf"), testutil.FileEquals, `# this file is managed by snapd, do not edit
+# snapd-var snapd_extra_cmdline_args=foo=bar
+title Ubuntu Core
+efi /pc-kernel_1.snap/kernel.efi
+options snapd_recovery_mode=run console=ttyS0 console=tty1 panic=-1 foo=bar
+`)
+
+	current, err := b.Kernel()
+	c.Assert(err, IsNil)
+	c.Check(current.Filename(), Equals, "pc-kernel_1.snap")
+	_, err = b.TryKernel()
+	c.Check(err, Equals, bootloader.ErrNoTryKernelRef)
+
+	// trying requires a try-kernel
+	err = b.SetBootVars(map[string]string{"kernel_status": "try"})
+	c.Assert(err, ErrorMatches, "cannot try boot: no try-kernel enabled")
+
+	tryKernel, snapf := s.makeKernelSnap(c, 2)
+	err = b.ExtractKernelAssets(tryKernel, snapf)
+	c.Assert(err, IsNil)
+	err = b.EnableTryKernel(tryKernel)
+	c.Assert(err, IsNil)
+	err = b.SetBootVars(map[string]string{"kernel_status": "try"})
+	c.Assert(err, IsNil)
+	c.Check(s.efiVars[loaderEntryOneShotVar], DeepEquals, bootloadertest.UTF16Bytes("ubuntu-core-try.conf"))
+
+	try, err := b.TryKernel()
+	c.Assert(err, IsNil)
+	c.Check(try.Filename(), Equals, "pc-kernel_2.snap")
+	c.Check(filepath.Join(s.rootdir, "boot/efi/loader/entries/ubuntu-core-try.conf"), testutil.FileEquals, `# this file is managed by snapd, do not edit
+title Ubuntu Core (try)
+efi /pc-kernel_2.snap/kernel.efi
+options snapd_recovery_mode=run console=ttyS0 console=tty1 panic=-1 foo=bar
+`)
+
+	err = b.DisableTryKernel()
+	c.Assert(err, IsNil)
+	_, err = b.TryKernel()
+	c.Check(err, Equals, bootloader.ErrNoTryKernelRef)
+	// disabling again is fine
+	c.Assert(b.DisableTryKernel(), IsNil)
+
+	err = b.RemoveKernelAssets(tryKernel)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "boot/efi/pc-kernel_2.snap"), testutil.FileAbsent)
+}
+
+func (s *sdbootTestSuite) TestRecovery(c *C) {
+	opts := &bootloader.Options{Role: bootloader.RoleRecovery}
+	bootloader.MockSdbootFiles(c, s.rootdir, opts)
+	b := bootloader.NewSdboot(s.rootdir, opts)
+	rb, ok := b.(bootloader.ExtractedRecoveryKernelImageBootloader)
+	c.Assert(ok, Equals, true)
+
+	kernel, snapf := s.makeKernelSnap(c, 1)
+	err := rb.ExtractRecoveryKernelAssets("", kernel, snapf)
+	c.Assert(err, ErrorMatches, "internal error: recoverySystemDir unset")
+	err = rb.ExtractRecoveryKernelAssets("systems/20210101", kernel, snapf)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "systems/20210101/kernel/kernel.efi"), testutil.FileEquals, "I'm a kernel.efi")
+}
+
+func (s *sdbootTestSuite) TestRecoveryBootVars(c *C) {
+	opts := &bootloader.Options{Role: bootloader.RoleRecovery}
+	bootloader.MockSdbootFiles(c, s.rootdir, opts)
+	b := bootloader.NewSdboot(s.rootdir, opts)
+
+	err := b.SetBootVars(map[string]string{
+		"snapd_recovery_system": "20210101",
+		"snapd_recovery_mode":   "recover",
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "loader/entries/ubuntu-core-recovery.conf"), testutil.FileEquals, `# this file is managed by snapd, do not edit
+# snapd-var snapd_recovery_mode=recover
+# snapd-var snapd_recovery_system=20210101
+title Ubuntu Core
+efi /systems/20210101/kernel/kernel.efi
+options snapd_recovery_mode=recover snapd_recovery_system=20210101 console=ttyS0 console=tty1 panic=-1
+`)
+	c.Check(filepath.Join(s.rootdir, "loader/loader.conf"), testutil.FileEquals, `timeout 0
+default ubuntu-core-recovery.conf
+editor no
+`)
+	// no try boots in recovery
+	c.Check(s.efiVars, HasLen, 0)
+
+	// in run mode the run mode entry of ubuntu-boot is the default and
+	// the recovery entry is not bootable
+	err = b.SetBootVars(map[string]string{"snapd_recovery_mode": "run"})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.rootdir, "loader/entries/ubuntu-core-recovery.conf"), testutil.FileEquals, `# this file is managed by snapd, do not edit
+# snapd-var snapd_recovery_mode=run
+# snapd-var snapd_recovery_system=20210101
+title Ubuntu Core
+`)
+	c.Check(filepath.Join(s.rootdir, "loader/loader.conf"), testutil.FileEquals, `timeout 0
+default ubuntu-core.conf
+editor no
+`)
+	m, err := b.GetBootVars("snapd_recovery_mode", "snapd_recovery_system")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snapd_recovery_mode":   "run",
+		"snapd_recovery_system": "20210101",
+	})
+}
+
+func (s *sdbootTestSuite) TestRecoveryDefaultEntryGadgetLoaderConf(c *C) {
+	opts := &bootloader.Options{Role: bootloader.RoleRecovery}
+	b := bootloader.NewSdboot(s.rootdir, opts)
+	c.Assert(os.MkdirAll(filepath.Dir(b.ConfigFile()), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(b.ConfigFile(), []byte("timeout 3\nconsole-mode max"), 0644), IsNil)
+
+	err := b.SetBootVars(map[string]string{"snapd_recovery_mode": "run"})
+	c.Assert(err, IsNil)
+	c.Check(b.ConfigFile(), testutil.FileEquals, "timeout 3\nconsole-mode max\ndefault ubuntu-core.conf\n")
+
+	err = b.SetBootVars(map[string]string{"snapd_recovery_mode": "recover"})
+	c.Assert(err, IsNil)
+	c.Check(b.ConfigFile(), testutil.FileEquals, "timeout 3\nconsole-mode max\ndefault ubuntu-core-recovery.conf\n")
+}
+
+func (s *sdbootTestSuite) TestBrokenEntry(c *C) {
+	bootloader.MockSdbootFiles(c, s.rootdir, nil)
+	b := bootloader.NewSdboot(s.rootdir, nil)
+	entry := filepath.Join(s.rootdir, "boot/efi/loader/entries/ubuntu-core.conf")
+	c.Assert(os.MkdirAll(filepath.Dir(entry), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(entry, []byte("# snapd-var broken\n"), 0644), IsNil)
+
+	_, err := b.GetBootVars("snap_mode")
+	c.Check(err, ErrorMatches, `cannot parse boot variable "# snapd-var broken" in boot loader entry ubuntu-core.conf`)
+}
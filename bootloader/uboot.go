@@ -23,8 +23,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/snapcore/snapd/bootloader/ubootenv"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
 )
 
@@ -86,6 +88,9 @@ func (u *uboot) dir() string {
 
 func (u *uboot) InstallBootConfig(gadgetDir string, blOpts *Options) (bool, error) {
 	gadgetFile := filepath.Join(gadgetDir, u.Name()+".conf")
+	// the gadget opts into a redundant environment by shipping the
+	// redundant copy, or an empty marker file along an empty uboot.conf
+	gadgetRedundFile := filepath.Join(gadgetDir, u.Name()+"-redund.conf")
 	// if the gadget file is empty, then we don't install anything
 	// this is because there are some gadgets, namely the 20 pi gadget right
 	// now, that don't use a uboot.env to boot and instead use a boot.scr, and
@@ -109,7 +114,12 @@ func (u *uboot) InstallBootConfig(gadgetDir string, blOpts *Options) (bool, erro
 		}
 
 		// TODO:UC20: what's a reasonable size for this file?
-		env, err := ubootenv.Create(u.envFile(), 4096)
+		var env *ubootenv.Env
+		if osutil.FileExists(gadgetRedundFile) {
+			env, err = ubootenv.CreateRedundant(u.envFile(), u.redundEnvFile(), 4096)
+		} else {
+			env, err = ubootenv.Create(u.envFile(), 4096)
+		}
 		if err != nil {
 			return false, err
 		}
@@ -132,6 +142,11 @@ func (u *uboot) InstallBootConfig(gadgetDir string, blOpts *Options) (bool, erro
 	}
 
 	systemFile := u.ConfigFile()
+	if osutil.FileExists(gadgetRedundFile) {
+		if _, err := genericInstallBootConfig(gadgetRedundFile, u.redundEnvFile()); err != nil {
+			return true, err
+		}
+	}
 	return genericInstallBootConfig(gadgetFile, systemFile)
 }
 
@@ -143,8 +158,22 @@ func (u *uboot) envFile() string {
 	return filepath.Join(u.dir(), u.ubootEnvFileName)
 }
 
+// redundEnvFile returns the file of the redundant copy of the environment,
+// eg. uboot-redund.env for uboot.env.
+func (u *uboot) redundEnvFile() string {
+	ext := filepath.Ext(u.ubootEnvFileName)
+	return filepath.Join(u.dir(), strings.TrimSuffix(u.ubootEnvFileName, ext)+"-redund"+ext)
+}
+
+func (u *uboot) openEnv() (*ubootenv.Env, error) {
+	if osutil.FileExists(u.redundEnvFile()) {
+		return ubootenv.OpenRedundant(u.envFile(), u.redundEnvFile(), ubootenv.OpenBestEffort)
+	}
+	return ubootenv.OpenWithFlags(u.envFile(), ubootenv.OpenBestEffort)
+}
+
 func (u *uboot) SetBootVars(values map[string]string) error {
-	env, err := ubootenv.OpenWithFlags(u.envFile(), ubootenv.OpenBestEffort)
+	env, err := u.openEnv()
 	if err != nil {
 		return err
 	}
@@ -169,7 +198,7 @@ func (u *uboot) SetBootVars(values map[string]string) error {
 func (u *uboot) GetBootVars(names ...string) (map[string]string, error) {
 	out := map[string]string{}
 
-	env, err := ubootenv.OpenWithFlags(u.envFile(), ubootenv.OpenBestEffort)
+	env, err := u.openEnv()
 	if err != nil {
 		return nil, err
 	}
@@ -20,6 +20,7 @@
 package bootloader_test
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
@@ -260,3 +261,37 @@ func (s *ubootTestSuite) TestUbootUC20OptsPlacement(c *C) {
 		c.Assert(env.Get("hello"), Equals, "there")
 	}
 }
+
+func (s *ubootTestSuite) TestInstallBootConfigRedundant(c *C) {
+	gadgetDir := c.MkDir()
+	for _, name := range []string{"uboot.conf", "uboot-redund.conf"} {
+		err := ioutil.WriteFile(filepath.Join(gadgetDir, name), nil, 0644)
+		c.Assert(err, IsNil)
+	}
+	opts := &bootloader.Options{Role: bootloader.RoleRunMode, NoSlashBoot: true}
+	err := bootloader.InstallBootConfig(gadgetDir, s.rootdir, opts)
+	c.Assert(err, IsNil)
+
+	envFile := filepath.Join(s.rootdir, "/uboot/ubuntu/boot.sel")
+	redundFile := filepath.Join(s.rootdir, "/uboot/ubuntu/boot-redund.sel")
+	for _, fname := range []string{envFile, redundFile} {
+		_, err := ubootenv.Open(fname)
+		c.Assert(err, IsNil)
+	}
+
+	u := bootloader.NewUboot(s.rootdir, opts)
+	err = u.SetBootVars(map[string]string{"snapd_recovery_mode": "run"})
+	c.Assert(err, IsNil)
+
+	// only the inactive copy was updated
+	env, err := ubootenv.Open(envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("snapd_recovery_mode"), Equals, "")
+	env, err = ubootenv.Open(redundFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("snapd_recovery_mode"), Equals, "run")
+
+	m, err := u.GetBootVars("snapd_recovery_mode")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{"snapd_recovery_mode": "run"})
+}
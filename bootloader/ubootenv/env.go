@@ -42,6 +42,19 @@ type Env struct {
 	fname string
 	size  int
 	data  map[string]string
+
+	// redundFname is the file of the redundant copy of the
+	// environment, if any
+	redundFname string
+	// flags is the flags byte of the active copy of a redundant
+	// environment, it is incremented with every save
+	flags byte
+	// redundActive is set when the redundant copy is the active one
+	redundActive bool
+	// activeValid is set when the active copy holds a valid
+	// environment, which is not the case for a newly created
+	// redundant environment
+	activeValid bool
 }
 
 // little endian helpers
@@ -75,6 +88,32 @@ func Create(fname string, size int) (*Env, error) {
 	return env, nil
 }
 
+// CreateRedundant creates a new empty redundant uboot env with the given
+// size, made of two copies kept in the given files. As with U-Boot's
+// CONFIG_SYS_REDUNDAND_ENVIRONMENT, saving the environment only ever writes
+// the inactive copy, so that a valid copy is left even if the write is
+// interrupted.
+func CreateRedundant(fname, redundFname string, size int) (*Env, error) {
+	for _, name := range []string{fname, redundFname} {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	env := &Env{
+		fname:       fname,
+		size:        size,
+		data:        make(map[string]string),
+		redundFname: redundFname,
+		// the first save goes to the primary copy
+		redundActive: true,
+	}
+
+	return env, nil
+}
+
 // OpenFlags instructs open how to alter its behavior.
 type OpenFlags int
 
@@ -90,31 +129,46 @@ func Open(fname string) (*Env, error) {
 
 // OpenWithFlags opens a existing uboot env file, passing additional flags.
 func OpenWithFlags(fname string, flags OpenFlags) (*Env, error) {
-	f, err := os.Open(fname)
+	payload, _, size, err := readEnvFile(fname)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	contentWithHeader, err := ioutil.ReadAll(f)
+	data, err := parseData(payload, flags)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(contentWithHeader) < headerSize {
-		return nil, fmt.Errorf("cannot open %q: smaller than expected header", fname)
+	env := &Env{
+		fname: fname,
+		size:  size,
+		data:  data,
 	}
 
-	crc := readUint32(contentWithHeader)
+	return env, nil
+}
 
-	payload := contentWithHeader[headerSize:]
-	actualCRC := crc32.ChecksumIEEE(payload)
-	if crc != actualCRC {
-		return nil, fmt.Errorf("cannot open %q: bad CRC %v != %v", fname, crc, actualCRC)
+// OpenRedundant opens an existing redundant uboot env made of the two copies
+// in the given files, passing additional flags. The active copy is the valid
+// one with the most recent flags byte, like U-Boot would pick it.
+func OpenRedundant(fname, redundFname string, flags OpenFlags) (*Env, error) {
+	payload, envFlags, size, err := readEnvFile(fname)
+	redundPayload, redundFlags, redundSize, redundErr := readEnvFile(redundFname)
+	if err != nil && redundErr != nil {
+		return nil, fmt.Errorf("cannot open redundant uboot env: %v, %v", err, redundErr)
 	}
 
-	if eof := bytes.Index(payload, []byte{0, 0}); eof >= 0 {
-		payload = payload[:eof]
+	useRedund := false
+	switch {
+	case err != nil:
+		useRedund = true
+	case redundErr != nil:
+		useRedund = false
+	default:
+		useRedund = isNewerFlags(redundFlags, envFlags)
+	}
+	if useRedund {
+		payload, envFlags, size = redundPayload, redundFlags, redundSize
 	}
 
 	data, err := parseData(payload, flags)
@@ -123,14 +177,65 @@ func OpenWithFlags(fname string, flags OpenFlags) (*Env, error) {
 	}
 
 	env := &Env{
-		fname: fname,
-		size:  len(contentWithHeader),
-		data:  data,
+		fname:        fname,
+		size:         size,
+		data:         data,
+		redundFname:  redundFname,
+		flags:        envFlags,
+		redundActive: useRedund,
+		activeValid:  true,
 	}
 
 	return env, nil
 }
 
+// isNewerFlags returns whether the flags byte a of a redundant env copy is
+// more recent than the flags byte b of the other copy, taking the wrap around
+// into account.
+func isNewerFlags(a, b byte) bool {
+	switch {
+	case a == 0 && b == 255:
+		return true
+	case a == 255 && b == 0:
+		return false
+	}
+	// U-Boot prefers the primary copy when the flags are equal
+	return a > b
+}
+
+// readEnvFile reads the uboot env file and returns its verified payload,
+// flags byte and size.
+func readEnvFile(fname string) (payload []byte, flags byte, size int, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	contentWithHeader, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if len(contentWithHeader) < headerSize {
+		return nil, 0, 0, fmt.Errorf("cannot open %q: smaller than expected header", fname)
+	}
+
+	crc := readUint32(contentWithHeader)
+
+	payload = contentWithHeader[headerSize:]
+	actualCRC := crc32.ChecksumIEEE(payload)
+	if crc != actualCRC {
+		return nil, 0, 0, fmt.Errorf("cannot open %q: bad CRC %v != %v", fname, crc, actualCRC)
+	}
+
+	if eof := bytes.Index(payload, []byte{0, 0}); eof >= 0 {
+		payload = payload[:eof]
+	}
+
+	return payload, contentWithHeader[headerSize-1], len(contentWithHeader), nil
+}
+
 func parseData(data []byte, flags OpenFlags) (map[string]string, error) {
 	out := make(map[string]string)
 
@@ -203,7 +308,8 @@ func (env *Env) iterEnv(f func(key, value string)) {
 	}
 }
 
-// Save will write out the environment data
+// Save will write out the environment data, for a redundant environment
+// the inactive copy is written and becomes the active one.
 func (env *Env) Save() error {
 	w := bytes.NewBuffer(nil)
 	// will panic if the buffer can't grow, all writes to
@@ -230,11 +336,38 @@ func (env *Env) Save() error {
 		w.Write([]byte{0xff})
 	}
 
+	if env.redundFname == "" {
+		return writeEnvFile(env.fname, 0, w.Bytes())
+	}
+
+	active, inactive := env.fname, env.redundFname
+	if env.redundActive {
+		active, inactive = inactive, active
+	}
+	// the flags byte wraps around
+	flags := env.flags + 1
+	if err := writeEnvFile(inactive, flags, w.Bytes()); err != nil {
+		return err
+	}
+	if !env.activeValid {
+		// make the other copy valid as well, U-Boot prefers the
+		// primary copy when the flags are equal
+		if err := writeEnvFile(active, flags, w.Bytes()); err != nil {
+			return err
+		}
+		env.activeValid = true
+	}
+	env.flags = flags
+	env.redundActive = !env.redundActive
+	return nil
+}
+
+func writeEnvFile(fname string, flags byte, payload []byte) error {
 	// checksum
-	crc := crc32.ChecksumIEEE(w.Bytes())
+	crc := crc32.ChecksumIEEE(payload)
 
 	// ensure dir sync
-	dir, err := os.Open(filepath.Dir(env.fname))
+	dir, err := os.Open(filepath.Dir(fname))
 	if err != nil {
 		return err
 	}
@@ -252,7 +385,7 @@ func (env *Env) Save() error {
 	//
 	// We also do not O_TRUNC to avoid reallocations on the FS
 	// to minimize risk of fs corruption.
-	f, err := os.OpenFile(env.fname, os.O_WRONLY, 0666)
+	f, err := os.OpenFile(fname, os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
@@ -261,12 +394,14 @@ func (env *Env) Save() error {
 	if _, err := f.Write(writeUint32(crc)); err != nil {
 		return err
 	}
-	// padding bytes (e.g. for redundant header)
+	// padding bytes, the last one is the flags byte of a redundant
+	// env
 	pad := make([]byte, headerSize-binary.Size(crc))
+	pad[len(pad)-1] = flags
 	if _, err := f.Write(pad); err != nil {
 		return err
 	}
-	if _, err := f.Write(w.Bytes()); err != nil {
+	if _, err := f.Write(payload); err != nil {
 		return err
 	}
 
@@ -25,6 +25,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -306,3 +307,101 @@ func (u *uenvTestSuite) TestWritesContentCorrectly(c *C) {
 	c.Assert(env.String(), Equals, "a=b\nc=d\n")
 	c.Assert(env.Size(), Equals, totalSize)
 }
+
+func (u *uenvTestSuite) readFlags(c *C, fname string) byte {
+	content, err := ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	c.Assert(len(content) > 5, Equals, true)
+	return content[4]
+}
+
+func (u *uenvTestSuite) TestRedundantSaveAlternates(c *C) {
+	redundFile := filepath.Join(filepath.Dir(u.envFile), "uboot-redund.env")
+	env, err := ubootenv.CreateRedundant(u.envFile, redundFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "1")
+	c.Assert(env.Save(), IsNil)
+
+	// the first save makes both copies valid
+	for _, fname := range []string{u.envFile, redundFile} {
+		single, err := ubootenv.Open(fname)
+		c.Assert(err, IsNil)
+		c.Check(single.String(), Equals, "foo=1\n")
+		c.Check(u.readFlags(c, fname), Equals, byte(1))
+	}
+
+	env, err = ubootenv.OpenRedundant(u.envFile, redundFile, 0)
+	c.Assert(err, IsNil)
+	env.Set("foo", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// the primary copy was active, the redundant one got written
+	single, err := ubootenv.Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(single.String(), Equals, "foo=1\n")
+	single, err = ubootenv.Open(redundFile)
+	c.Assert(err, IsNil)
+	c.Check(single.String(), Equals, "foo=2\n")
+	c.Check(u.readFlags(c, redundFile), Equals, byte(2))
+
+	env.Set("foo", "3")
+	c.Assert(env.Save(), IsNil)
+	c.Check(u.readFlags(c, u.envFile), Equals, byte(3))
+
+	env, err = ubootenv.OpenRedundant(u.envFile, redundFile, 0)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "foo=3\n")
+}
+
+func (u *uenvTestSuite) TestRedundantOpenPicksValidCopy(c *C) {
+	redundFile := filepath.Join(filepath.Dir(u.envFile), "uboot-redund.env")
+	env, err := ubootenv.CreateRedundant(u.envFile, redundFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// simulate an interrupted write of the newer copy
+	content, err := ioutil.ReadFile(redundFile)
+	c.Assert(err, IsNil)
+	content[10] ^= 0xff
+	c.Assert(ioutil.WriteFile(redundFile, content, 0644), IsNil)
+
+	env, err = ubootenv.OpenRedundant(u.envFile, redundFile, 0)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "foo=1\n")
+
+	// and the broken copy gets overwritten next
+	env.Set("foo", "3")
+	c.Assert(env.Save(), IsNil)
+	single, err := ubootenv.Open(redundFile)
+	c.Assert(err, IsNil)
+	c.Check(single.String(), Equals, "foo=3\n")
+}
+
+func (u *uenvTestSuite) TestRedundantOpenFlagsWrapAround(c *C) {
+	redundFile := filepath.Join(filepath.Dir(u.envFile), "uboot-redund.env")
+	env, err := ubootenv.CreateRedundant(u.envFile, redundFile, 4096)
+	c.Assert(err, IsNil)
+	// 256 saves, the redundant copy wraps around to flags 0 last
+	for i := 0; i < 256; i++ {
+		env.Set("foo", strconv.Itoa(i))
+		c.Assert(env.Save(), IsNil)
+	}
+	c.Check(u.readFlags(c, u.envFile), Equals, byte(255))
+	c.Check(u.readFlags(c, redundFile), Equals, byte(0))
+
+	env, err = ubootenv.OpenRedundant(u.envFile, redundFile, 0)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "foo=255\n")
+}
+
+func (u *uenvTestSuite) TestRedundantOpenNoValidCopy(c *C) {
+	redundFile := filepath.Join(filepath.Dir(u.envFile), "uboot-redund.env")
+	_, err := ubootenv.CreateRedundant(u.envFile, redundFile, 4096)
+	c.Assert(err, IsNil)
+
+	_, err = ubootenv.OpenRedundant(u.envFile, redundFile, 0)
+	c.Check(err, ErrorMatches, `cannot open redundant uboot env: cannot open ".*/uboot.env": smaller than expected header, cannot open ".*/uboot-redund.env": smaller than expected header`)
+}
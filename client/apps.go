@@ -22,10 +22,10 @@ package client
 import (
 	"bufio"
 	"bytes"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -40,6 +40,9 @@ type AppActivator struct {
 	Type    string
 	Active  bool
 	Enabled bool
+	// LastTrigger is the time a timer last activated the app, zero if
+	// it never did
+	LastTrigger time.Time
 }
 
 // AppInfo describes a single snap application.
@@ -109,7 +112,15 @@ func (l Log) String() string {
 	return fmt.Sprintf("%s %s[%s]: %s", l.Timestamp.Format(time.RFC3339), l.SID, l.PID, l.Message)
 }
 
+// LogsStoppedTrailer is the HTTP trailer set by snapd when it ends a
+// followed logs stream because it is stopping, eg. to restart after a
+// refresh of snapd.
+const LogsStoppedTrailer = "X-Snapd-Logs-Stopped"
+
 // Logs asks for the logs of a series of services, by name.
+//
+// When following the logs, the logs are followed again from the restarted
+// snapd if the stream is ended by snapd stopping.
 func (client *Client) Logs(names []string, opts LogOptions) (<-chan Log, error) {
 	query := url.Values{}
 	if len(names) > 0 {
@@ -120,7 +131,7 @@ func (client *Client) Logs(names []string, opts LogOptions) (<-chan Log, error)
 		query.Set("follow", strconv.FormatBool(opts.Follow))
 	}
 
-	rsp, err := client.raw(context.Background(), "GET", "/v2/logs", query, nil, nil)
+	rsp, err := client.raw(client.context(), "GET", "/v2/logs", query, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -136,36 +147,136 @@ func (client *Client) Logs(names []string, opts LogOptions) (<-chan Log, error)
 
 	ch := make(chan Log, 20)
 	go func() {
-		// logs come in application/json-seq, described in RFC7464: it's
-		// a series of <RS><arbitrary, valid JSON><LF>. Decoders are
-		// expected to skip invalid or truncated or empty records.
-		scanner := bufio.NewScanner(rsp.Body)
-		for scanner.Scan() {
-			buf := scanner.Bytes() // the scanner prunes the ending LF
-			if len(buf) < 1 {
-				// truncated record? skip
-				continue
-			}
-			idx := bytes.IndexByte(buf, 0x1E) // find the initial RS
-			if idx < 0 {
-				// no RS? skip
-				continue
+		defer close(ch)
+		for rsp != nil {
+			readLogs(rsp, ch)
+			rsp.Body.Close()
+			if !opts.Follow || rsp.Trailer.Get(LogsStoppedTrailer) == "" {
+				break
 			}
-			buf = buf[idx+1:] // drop the initial RS
-			var log Log
-			if err := json.Unmarshal(buf, &log); err != nil {
-				// truncated/corrupted/binary record? skip
-				continue
+			// only the new logs are wanted from the restarted snapd
+			query.Set("n", "0")
+			rsp = client.followAgain("/v2/logs", query)
+		}
+	}()
+
+	return ch, nil
+}
+
+func readLogs(rsp *http.Response, ch chan<- Log) {
+	readSeq(rsp, func(buf []byte) {
+		var log Log
+		if err := json.Unmarshal(buf, &log); err != nil {
+			// truncated/corrupted/binary record? skip
+			return
+		}
+		ch <- log
+	})
+}
+
+// readSeq calls record with each record of the application/json-seq body of
+// the response.
+func readSeq(rsp *http.Response, record func(buf []byte)) {
+	// json-seq is described in RFC7464: it's a series of
+	// <RS><arbitrary, valid JSON><LF>. Decoders are expected to
+	// skip invalid or truncated or empty records.
+	scanner := bufio.NewScanner(rsp.Body)
+	for scanner.Scan() {
+		buf := scanner.Bytes() // the scanner prunes the ending LF
+		if len(buf) < 1 {
+			// truncated record? skip
+			continue
+		}
+		idx := bytes.IndexByte(buf, 0x1E) // find the initial RS
+		if idx < 0 {
+			// no RS? skip
+			continue
+		}
+		record(buf[idx+1:]) // drop the initial RS
+	}
+}
+
+// WatchServices asks for the status of a series of services, by name, as
+// it changes, from the events of snapd. The current status of the services
+// is sent first, then their new status whenever it changes. The channel is
+// closed when snapd ends the stream; if it is because snapd stopped, the
+// services are watched again from the restarted snapd first.
+func (client *Client) WatchServices(names []string) (<-chan []*AppInfo, error) {
+	query := url.Values{}
+	if len(names) > 0 {
+		query.Set("names", strings.Join(names, ","))
+	}
+	query.Set("types", EventServiceStatus)
+
+	rsp, err := client.raw(client.context(), "GET", "/v2/events", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != 200 {
+		var r response
+		defer rsp.Body.Close()
+		if err := decodeInto(rsp.Body, &r); err != nil {
+			return nil, err
+		}
+		return nil, r.err(client, rsp.StatusCode)
+	}
+
+	ch := make(chan []*AppInfo, 20)
+	go func() {
+		defer close(ch)
+		for rsp != nil {
+			readSeq(rsp, func(buf []byte) {
+				var ev Event
+				if err := json.Unmarshal(buf, &ev); err != nil {
+					// truncated/corrupted/binary record? skip
+					return
+				}
+				if ev.Type != EventServiceStatus {
+					return
+				}
+				ch <- ev.Services
+			})
+			rsp.Body.Close()
+			if rsp.Trailer.Get(StreamStoppedTrailer) == "" {
+				break
 			}
-			ch <- log
+			rsp = client.followAgain("/v2/events", query)
 		}
-		close(ch)
-		rsp.Body.Close()
 	}()
 
 	return ch, nil
 }
 
+// followAgain requests a followed stream, like logs, again once snapd
+// stopped, retrying while snapd is restarting like do() does. It returns nil
+// if the stream cannot be followed anymore.
+func (client *Client) followAgain(urlpath string, query url.Values) *http.Response {
+	ctx := client.context()
+	retry := time.NewTicker(doRetry)
+	defer retry.Stop()
+	timeout := time.NewTimer(doTimeout)
+	defer timeout.Stop()
+
+	for {
+		rsp, err := client.raw(ctx, "GET", urlpath, query, nil, nil)
+		if err == nil {
+			if rsp.StatusCode == 200 {
+				return rsp
+			}
+			rsp.Body.Close()
+			return nil
+		}
+		select {
+		case <-retry.C:
+			continue
+		case <-timeout.C:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+}
+
 // ErrNoNames is returned by Start, Stop, or Restart, when the given
 // list of things on which to operate is empty.
 var ErrNoNames = errors.New(`"names" must not be empty`)
@@ -22,6 +22,8 @@ package client_test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -217,6 +219,55 @@ func (cs *clientSuite) TestClientLogsNotFound(c *check.C) {
 	c.Check(actual, check.HasLen, 0)
 }
 
+func (cs *clientSuite) TestClientLogsFollowAcrossRestart(c *check.C) {
+	var queries []string
+	cs.cli.Hijack(func(req *http.Request) (*http.Response, error) {
+		queries = append(queries, req.URL.RawQuery)
+		switch len(queries) {
+		case 1:
+			// snapd stops
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("\x1e{\"message\":\"hello\"}\n")),
+				Trailer:    http.Header{client.LogsStoppedTrailer: {"true"}},
+			}, nil
+		case 2:
+			// snapd is restarting
+			return nil, fmt.Errorf("connection refused")
+		default:
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("\x1e{\"message\":\"bye\"}\n")),
+			}, nil
+		}
+	})
+
+	ch, err := cs.cli.Logs([]string{"foo"}, client.LogOptions{N: 10, Follow: true})
+	c.Assert(err, check.IsNil)
+	var logs []client.Log
+	for log := range ch {
+		logs = append(logs, log)
+	}
+	c.Check(logs, check.DeepEquals, []client.Log{{Message: "hello"}, {Message: "bye"}})
+	c.Check(queries, check.DeepEquals, []string{
+		"follow=true&n=10&names=foo",
+		"follow=true&n=0&names=foo",
+		"follow=true&n=0&names=foo",
+	})
+}
+
+func (cs *clientSuite) TestClientLogsNoFollowAgainWithoutTrailer(c *check.C) {
+	cs.rsp = "\x1e{\"message\":\"hello\"}\n"
+	ch, err := cs.cli.Logs(nil, client.LogOptions{N: 10, Follow: true})
+	c.Assert(err, check.IsNil)
+	var logs []client.Log
+	for log := range ch {
+		logs = append(logs, log)
+	}
+	c.Check(logs, check.DeepEquals, []client.Log{{Message: "hello"}})
+	c.Check(cs.reqs, check.HasLen, 1)
+}
+
 func (cs *clientSuite) TestClientServiceStart(c *check.C) {
 	cs.status = 202
 	cs.rsp = `{"type": "async", "status-code": 202, "change": "24"}`
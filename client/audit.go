@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SnapRunAuditRecord describes an audited invocation of snap run.
+type SnapRunAuditRecord struct {
+	Time        time.Time     `json:"time"`
+	Snap        string        `json:"snap"`
+	App         string        `json:"app,omitempty"`
+	Hook        string        `json:"hook,omitempty"`
+	UID         int           `json:"uid"`
+	User        string        `json:"user,omitempty"`
+	CmdlineHash string        `json:"cmdline-hash"`
+	ExitCode    int           `json:"exit-code"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// SnapRunAuditOptions selects the snap run audit records to return.
+type SnapRunAuditOptions struct {
+	// Snap selects the records of the given snap only.
+	Snap string
+	// Since selects the records from the given time on.
+	Since time.Time
+	// N is the maximum number of most recent records, all when zero.
+	N int
+}
+
+// SnapRunAudit returns the audited invocations of snap run, oldest first.
+// Auditing is enabled with the experimental.snap-run-audit system option.
+func (client *Client) SnapRunAudit(opts *SnapRunAuditOptions) ([]*SnapRunAuditRecord, error) {
+	q := make(url.Values)
+	if opts != nil {
+		if opts.Snap != "" {
+			q.Set("snap", opts.Snap)
+		}
+		if !opts.Since.IsZero() {
+			q.Set("since", opts.Since.Format(time.RFC3339))
+		}
+		if opts.N > 0 {
+			q.Set("n", strconv.Itoa(opts.N))
+		}
+	}
+
+	var records []*SnapRunAuditRecord
+	if _, err := client.doSync("GET", "/v2/audit/snap-run", q, nil, nil, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
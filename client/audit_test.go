@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestSnapRunAudit(c *check.C) {
+	cs.rsp = `{
+		"result": [
+		    {
+			"time": "2021-06-01T10:00:00Z",
+			"snap": "foo",
+			"app": "bar",
+			"uid": 1000,
+			"user": "user",
+			"cmdline-hash": "1234",
+			"exit-code": 3,
+			"duration": 2000000000
+		    }
+		],
+		"status": "OK",
+		"status-code": 200,
+		"type": "sync"
+	}`
+
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	recs, err := cs.cli.SnapRunAudit(&client.SnapRunAuditOptions{
+		Snap:  "foo",
+		Since: t0.Add(-time.Hour),
+		N:     10,
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(recs, check.DeepEquals, []*client.SnapRunAuditRecord{{
+		Time:        t0,
+		Snap:        "foo",
+		App:         "bar",
+		UID:         1000,
+		User:        "user",
+		CmdlineHash: "1234",
+		ExitCode:    3,
+		Duration:    2 * time.Second,
+	}})
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/audit/snap-run")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"snap":  []string{"foo"},
+		"since": []string{"2021-06-01T09:00:00Z"},
+		"n":     []string{"10"},
+	})
+}
+
+func (cs *clientSuite) TestSnapRunAuditNoOptions(c *check.C) {
+	cs.rsp = `{"result": [], "status": "OK", "status-code": 200, "type": "sync"}`
+
+	recs, err := cs.cli.SnapRunAudit(nil)
+	c.Assert(err, check.IsNil)
+	c.Check(recs, check.HasLen, 0)
+	c.Check(cs.req.URL.RawQuery, check.Equals, "")
+}
+
+func (cs *clientSuite) TestSnapRunAuditError(c *check.C) {
+	cs.status = 401
+	cs.rsp = `{"type": "error", "status-code": 401, "result": {"message": "access denied", "kind": "login-required"}}`
+
+	_, err := cs.cli.SnapRunAudit(nil)
+	c.Check(err, check.ErrorMatches, "access denied")
+}
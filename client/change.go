@@ -25,6 +25,8 @@ import (
 	"fmt"
 	"net/url"
 	"time"
+
+	"github.com/snapcore/snapd/snap"
 )
 
 // A Change is a modification to the system state.
@@ -54,6 +56,56 @@ func (c *Change) Get(key string, value interface{}) error {
 	return json.Unmarshal([]byte(*raw), value)
 }
 
+// ProfileDiff is the difference of a single security profile between two
+// revisions of a snap, in unified-like "-"/"+" line format.
+type ProfileDiff struct {
+	Path string `json:"path"`
+	Diff string `json:"diff"`
+}
+
+// PolicyDiff describes how the security policy of a snap changes with a
+// refresh. It is stored in the change data under the "policy-diffs" key,
+// mapped by snap name.
+type PolicyDiff struct {
+	OldRevision snap.Revision `json:"old-revision"`
+	NewRevision snap.Revision `json:"new-revision"`
+	Profiles    []ProfileDiff `json:"profiles,omitempty"`
+	HighRisk    []string      `json:"high-risk,omitempty"`
+}
+
+// PolicyDiffs returns the security policy diffs recorded for the snaps
+// refreshed by the change, mapped by snap name.
+func (c *Change) PolicyDiffs() (map[string]*PolicyDiff, error) {
+	var diffs map[string]*PolicyDiff
+	if err := c.Get("policy-diffs", &diffs); err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// DefaultProvider records how a default content provider of a snap was dealt
+// with by a change. It is stored in the change data under the
+// "default-providers" key, mapped by snap name.
+type DefaultProvider struct {
+	Snap        string   `json:"snap"`
+	ContentTags []string `json:"content-tags,omitempty"`
+	Policy      string   `json:"policy"`
+	Installed   bool     `json:"installed,omitempty"`
+	// NeedsApproval is set when the provider was not installed by an
+	// auto-refresh because the policy requires an approval.
+	NeedsApproval bool `json:"needs-approval,omitempty"`
+}
+
+// DefaultProviders returns what the change did about the default content
+// providers of the snaps it installed or refreshed, mapped by snap name.
+func (c *Change) DefaultProviders() (map[string][]*DefaultProvider, error) {
+	var providers map[string][]*DefaultProvider
+	if err := c.Get("default-providers", &providers); err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
 // A Task is an operation done to change the system's state.
 type Task struct {
 	ID       string       `json:"id"`
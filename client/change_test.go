@@ -23,6 +23,7 @@ import (
 	"gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/snap"
 	"io/ioutil"
 	"time"
 )
@@ -201,6 +202,82 @@ func (cs *clientSuite) TestClientChangesData(c *check.C) {
 	c.Assert(err, check.Equals, client.ErrNoData)
 }
 
+func (cs *clientSuite) TestClientChangePolicyDiffs(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "refresh-snap",
+  "summary": "...",
+  "status": "Error",
+  "ready": true,
+  "data": {"policy-diffs": {"foo": {
+    "old-revision": "1",
+    "new-revision": "2",
+    "profiles": [{"path": "/var/lib/snapd/apparmor/profiles/snap.foo.app", "diff": "+ptrace,\n"}],
+    "high-risk": ["snap.foo.app: ptrace,"]
+  }}}
+}}`
+
+	chg, err := cs.cli.Change("uno")
+	c.Assert(err, check.IsNil)
+
+	diffs, err := chg.PolicyDiffs()
+	c.Assert(err, check.IsNil)
+	c.Check(diffs, check.DeepEquals, map[string]*client.PolicyDiff{
+		"foo": {
+			OldRevision: snap.R(1),
+			NewRevision: snap.R(2),
+			Profiles: []client.ProfileDiff{
+				{Path: "/var/lib/snapd/apparmor/profiles/snap.foo.app", Diff: "+ptrace,\n"},
+			},
+			HighRisk: []string{"snap.foo.app: ptrace,"},
+		},
+	})
+}
+
+func (cs *clientSuite) TestClientChangeNoPolicyDiffs(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "refresh-snap",
+  "summary": "...",
+  "status": "Done",
+  "ready": true
+}}`
+
+	chg, err := cs.cli.Change("uno")
+	c.Assert(err, check.IsNil)
+
+	_, err = chg.PolicyDiffs()
+	c.Check(err, check.Equals, client.ErrNoData)
+}
+
+func (cs *clientSuite) TestClientChangeDefaultProviders(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "install-snap",
+  "summary": "...",
+  "status": "Done",
+  "ready": true,
+  "data": {"default-providers": {"foo": [
+    {"snap": "gtk-common-themes", "content-tags": ["gtk-3-themes"], "policy": "always", "installed": true},
+    {"snap": "other-content", "policy": "never"},
+    {"snap": "prompted-content", "policy": "prompt", "needs-approval": true}
+  ]}}
+}}`
+
+	chg, err := cs.cli.Change("uno")
+	c.Assert(err, check.IsNil)
+
+	providers, err := chg.DefaultProviders()
+	c.Assert(err, check.IsNil)
+	c.Check(providers, check.DeepEquals, map[string][]*client.DefaultProvider{
+		"foo": {
+			{Snap: "gtk-common-themes", ContentTags: []string{"gtk-3-themes"}, Policy: "always", Installed: true},
+			{Snap: "other-content", Policy: "never"},
+			{Snap: "prompted-content", Policy: "prompt", NeedsApproval: true},
+		},
+	})
+}
+
 func (cs *clientSuite) TestClientAbort(c *check.C) {
 	cs.rsp = `{"type": "sync", "result": {
   "id":   "uno",
@@ -32,8 +32,11 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 
+	"golang.org/x/xerrors"
+
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/jsonutil"
 )
@@ -85,12 +88,20 @@ type Client struct {
 	disableAuth bool
 	interactive bool
 
+	// mu guards the daemon status below, which is also updated by the
+	// requests subscriptions make in the background
+	mu          *sync.Mutex
 	maintenance error
 
 	warningCount     int
 	warningTimestamp time.Time
 
 	userAgent string
+
+	// remote is the remote snapd set with SetRemote, if any
+	remote *remote
+
+	ctx context.Context
 }
 
 // New returns a new instance of Client
@@ -111,6 +122,7 @@ func New(config *Config) *Client {
 			disableAuth: config.DisableAuth,
 			interactive: config.Interactive,
 			userAgent:   config.UserAgent,
+			mu:          &sync.Mutex{},
 		}
 	}
 
@@ -124,18 +136,54 @@ func New(config *Config) *Client {
 		disableAuth: config.DisableAuth,
 		interactive: config.Interactive,
 		userAgent:   config.UserAgent,
+		mu:          &sync.Mutex{},
 	}
 }
 
+// WithContext returns a shallow copy of the client whose requests are done
+// within the given context, they fail once the context is canceled or its
+// deadline is exceeded. Streaming requests and subscriptions end when the
+// context is done. The warnings and maintenance status of the copy are
+// tracked separately from the ones of the original client.
+func (client *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("nil context")
+	}
+	client.mu.Lock()
+	c := *client
+	client.mu.Unlock()
+	c.mu = &sync.Mutex{}
+	c.ctx = ctx
+	return &c
+}
+
+// context returns the context requests of the client are done within.
+func (client *Client) context() context.Context {
+	if client.ctx != nil {
+		return client.ctx
+	}
+	return context.Background()
+}
+
 // Maintenance returns an error reflecting the daemon maintenance status or nil.
 func (client *Client) Maintenance() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 	return client.maintenance
 }
 
+func (client *Client) setMaintenance(err error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.maintenance = err
+}
+
 // WarningsSummary returns the number of warnings that are ready to be shown to
 // the user, and the timestamp of the most recently added warning (useful for
 // silencing the warning alerts, and OKing the returned warnings).
 func (client *Client) WarningsSummary() (count int, timestamp time.Time) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 	return client.warningCount, client.warningTimestamp
 }
 
@@ -175,12 +223,20 @@ func (e RequestError) Error() string {
 	return fmt.Sprintf("cannot build request: %v", e.error)
 }
 
+func (e RequestError) Unwrap() error {
+	return e.error
+}
+
 type AuthorizationError struct{ error }
 
 func (e AuthorizationError) Error() string {
 	return fmt.Sprintf("cannot add authorization: %v", e.error)
 }
 
+func (e AuthorizationError) Unwrap() error {
+	return e.error
+}
+
 type ConnectionError struct{ Err error }
 
 func (e ConnectionError) Error() string {
@@ -349,7 +405,7 @@ func (client *Client) do(method, path string, query url.Values, headers map[stri
 	client.checkMaintenanceJSON()
 
 	var rsp *http.Response
-	var ctx context.Context = context.Background()
+	ctx := client.context()
 	if opts.Timeout <= 0 {
 		// no timeout and retries
 		rsp, err = client.raw(ctx, method, path, query, headers, body)
@@ -378,6 +434,7 @@ func (client *Client) do(method, path string, query url.Values, headers map[stri
 			case <-retry.C:
 				continue
 			case <-timeout.C:
+			case <-ctx.Done():
 			}
 			break
 		}
@@ -445,9 +502,9 @@ func (client *Client) checkMaintenanceJSON() {
 	if maintenance != nil {
 		switch maintenance.Kind {
 		case ErrorKindDaemonRestart:
-			client.maintenance = maintenance
+			client.setMaintenance(maintenance)
 		case ErrorKindSystemRestart:
-			client.maintenance = maintenance
+			client.setMaintenance(maintenance)
 		}
 		// don't set maintenance for other kinds, as we don't know what it
 		// is yet
@@ -484,8 +541,10 @@ func (client *Client) doSyncWithOpts(method, path string, query url.Values, head
 		}
 	}
 
+	client.mu.Lock()
 	client.warningCount = rsp.WarningCount
 	client.warningTimestamp = rsp.WarningTimestamp
+	client.mu.Unlock()
 
 	return &rsp.ResultInfo, nil
 }
@@ -576,6 +635,27 @@ func (e *Error) Error() string {
 	return e.Message
 }
 
+// Is returns whether the target is an *Error of the same kind, so that
+// errors.Is(err, &Error{Kind: kind}) can be used to check for the kind of an
+// error returned by the daemon, possibly wrapped.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t == nil || e == nil {
+		return false
+	}
+	return t.Kind == e.Kind
+}
+
+// ErrorKindOf returns the kind of the error returned by the daemon in the
+// chain of the given error, or the empty kind if there is none.
+func ErrorKindOf(err error) ErrorKind {
+	var e *Error
+	if !xerrors.As(err, &e) || e == nil {
+		return ""
+	}
+	return e.Kind
+}
+
 // IsRetryable returns true if the given error is an error
 // that can be retried later.
 func IsRetryable(err error) bool {
@@ -651,6 +731,17 @@ type SysInfo struct {
 	Refresh         RefreshInfo         `json:"refresh,omitempty"`
 	Confinement     string              `json:"confinement"`
 	SandboxFeatures map[string][]string `json:"sandbox-features,omitempty"`
+
+	Degraded []DegradedSubsystem `json:"degraded,omitempty"`
+}
+
+// DegradedSubsystem describes a part of snapd that is temporarily not
+// working after an internal error, and will be retried automatically.
+type DegradedSubsystem struct {
+	Name    string    `json:"name"`
+	Reason  string    `json:"reason"`
+	Since   time.Time `json:"since"`
+	RetryAt time.Time `json:"retry-at"`
 }
 
 func (rsp *response) err(cli *Client, statusCode int) error {
@@ -658,9 +749,9 @@ func (rsp *response) err(cli *Client, statusCode int) error {
 		maintErr := rsp.Maintenance
 		// avoid setting to (*client.Error)(nil)
 		if maintErr != nil {
-			cli.maintenance = maintErr
+			cli.setMaintenance(maintErr)
 		} else {
-			cli.maintenance = nil
+			cli.setMaintenance(nil)
 		}
 	}
 	if rsp.Type != "error" {
@@ -20,6 +20,7 @@
 package client_test
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -35,6 +36,7 @@ import (
 	"testing"
 	"time"
 
+	"golang.org/x/xerrors"
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/client"
@@ -559,6 +561,47 @@ func (cs *clientSuite) TestIsRetryable(c *C) {
 	c.Check(client.IsRetryable(&client.Error{Kind: client.ErrorKindSnapChangeConflict}), Equals, true)
 }
 
+func (cs *clientSuite) TestErrorKindOf(c *C) {
+	err := &client.Error{Kind: client.ErrorKindSnapNotFound, Message: "not found"}
+	c.Check(client.ErrorKindOf(err), Equals, client.ErrorKindSnapNotFound)
+	wrapped := xerrors.Errorf("cannot install: %w", err)
+	c.Check(client.ErrorKindOf(wrapped), Equals, client.ErrorKindSnapNotFound)
+	c.Check(xerrors.Is(wrapped, &client.Error{Kind: client.ErrorKindSnapNotFound}), Equals, true)
+	c.Check(xerrors.Is(wrapped, &client.Error{Kind: client.ErrorKindSnapLocal}), Equals, false)
+
+	c.Check(client.ErrorKindOf(errors.New("test")), Equals, client.ErrorKind(""))
+	c.Check(client.ErrorKindOf(nil), Equals, client.ErrorKind(""))
+	c.Check(client.ErrorKindOf((*client.Error)(nil)), Equals, client.ErrorKind(""))
+}
+
+type ctxKey struct{}
+
+func (cs *clientSuite) TestWithContext(c *C) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	cli := cs.cli.WithContext(ctx)
+	c.Check(cli, Not(Equals), cs.cli)
+
+	cs.rsp = `{"type": "sync", "result": {}}`
+	_, err := cli.SysInfo()
+	c.Assert(err, IsNil)
+	c.Check(cs.req.Context().Value(ctxKey{}), Equals, "value")
+
+	// the original client is not affected
+	_, err = cs.cli.SysInfo()
+	c.Assert(err, IsNil)
+	c.Check(cs.req.Context().Value(ctxKey{}), IsNil)
+}
+
+func (cs *clientSuite) TestWithContextCanceled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cs.err = errors.New("fail")
+
+	_, err := cs.cli.WithContext(ctx).Do("GET", "/", nil, nil, nil, nil)
+	c.Check(err, ErrorMatches, "cannot communicate with server: request canceled")
+	c.Check(xerrors.Is(err, context.Canceled), Equals, true)
+}
+
 func (cs *clientSuite) TestUserAgent(c *C) {
 	cli := client.New(&client.Config{UserAgent: "some-agent/9.87"})
 	cli.SetDoer(cs)
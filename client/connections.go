@@ -20,6 +20,8 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/url"
 )
 
@@ -49,6 +51,20 @@ type Connections struct {
 	Slots     []Slot       `json:"slots"`
 }
 
+// OrphanedConnection describes a connection recorded by snapd whose plug or
+// slot is gone.
+type OrphanedConnection struct {
+	Slot      SlotRef `json:"slot"`
+	Plug      PlugRef `json:"plug"`
+	Interface string  `json:"interface"`
+	// Reason is one of "plug-missing", "slot-missing" or "hotplug-gone".
+	Reason string `json:"reason"`
+	// Rebind is the slot the connection will be moved to when repaired,
+	// it is set for connections of hotplug devices that are present
+	// again under a different slot.
+	Rebind *SlotRef `json:"rebind,omitempty"`
+}
+
 // ConnectionOptions contains criteria for selecting matching connections, plugs
 // and slots.
 type ConnectionOptions struct {
@@ -79,3 +95,31 @@ func (client *Client) Connections(opts *ConnectionOptions) (Connections, error)
 	_, err := client.doSync("GET", "/v2/connections", query, nil, nil, &conns)
 	return conns, err
 }
+
+// OrphanedConnections returns the connections whose plug or slot is gone,
+// matching the snap and interface of the given options.
+func (client *Client) OrphanedConnections(opts *ConnectionOptions) ([]OrphanedConnection, error) {
+	var orphans struct {
+		Orphaned []OrphanedConnection `json:"orphaned"`
+	}
+	query := url.Values{}
+	if opts != nil && opts.Snap != "" {
+		query.Set("snap", opts.Snap)
+	}
+	if opts != nil && opts.Interface != "" {
+		query.Set("interface", opts.Interface)
+	}
+	query.Set("select", "orphans")
+	_, err := client.doSync("GET", "/v2/connections", query, nil, nil, &orphans)
+	return orphans.Orphaned, err
+}
+
+// RepairConnections forgets the orphaned connections, or moves them to the
+// slot the hotplug device is present as again.
+func (client *Client) RepairConnections() (changeID string, err error) {
+	b, err := json.Marshal(map[string]string{"action": "repair"})
+	if err != nil {
+		return "", err
+	}
+	return client.doAsync("POST", "/v2/connections", nil, nil, bytes.NewReader(b))
+}
@@ -20,6 +20,7 @@
 package client_test
 
 import (
+	"encoding/json"
 	"net/url"
 
 	"gopkg.in/check.v1"
@@ -268,3 +269,69 @@ func (cs *clientSuite) TestClientConnectionsFilter(c *check.C) {
 		"snap":      []string{"foo"},
 	})
 }
+
+func (cs *clientSuite) TestClientOrphanedConnections(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"result": {
+			"orphaned": [
+				{
+					"slot": {"snap": "core", "slot": "hotplug-old"},
+					"plug": {"snap": "consumer", "plug": "plug"},
+					"interface": "serial-port",
+					"reason": "hotplug-gone",
+					"rebind": {"snap": "core", "slot": "hotplug-new"}
+				},
+				{
+					"slot": {"snap": "producer", "slot": "slot"},
+					"plug": {"snap": "consumer", "plug": "gone"},
+					"interface": "test",
+					"reason": "plug-missing"
+				}
+			]
+		}
+	}`
+	orphans, err := cs.cli.OrphanedConnections(&client.ConnectionOptions{Snap: "consumer"})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/connections")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"select": []string{"orphans"},
+		"snap":   []string{"consumer"},
+	})
+	c.Check(orphans, check.DeepEquals, []client.OrphanedConnection{
+		{
+			Slot:      client.SlotRef{Snap: "core", Name: "hotplug-old"},
+			Plug:      client.PlugRef{Snap: "consumer", Name: "plug"},
+			Interface: "serial-port",
+			Reason:    "hotplug-gone",
+			Rebind:    &client.SlotRef{Snap: "core", Name: "hotplug-new"},
+		}, {
+			Slot:      client.SlotRef{Snap: "producer", Name: "slot"},
+			Plug:      client.PlugRef{Snap: "consumer", Name: "gone"},
+			Interface: "test",
+			Reason:    "plug-missing",
+		},
+	})
+}
+
+func (cs *clientSuite) TestClientRepairConnections(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"result": { },
+		"change": "foo"
+	}`
+	id, err := cs.cli.RepairConnections()
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "foo")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/connections")
+	var body map[string]interface{}
+	err = json.NewDecoder(cs.req.Body).Decode(&body)
+	c.Assert(err, check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "repair",
+	})
+}
@@ -0,0 +1,35 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package client implements a client for the REST API of snapd, meant to be
+// used by external management tools as well as by the snap command.
+//
+// The exported API of the package is stable: within a major version of snapd
+// exported identifiers are not removed and their behavior is only changed in
+// backwards compatible ways. Identifiers documented as being for testing only
+// are excluded.
+//
+// Requests can be bound to a context with Client.WithContext. Errors returned
+// by the daemon are of type *Error, their kind can be checked with ErrorKindOf
+// or errors.Is. Logs streams the logs of services and SubscribeChanges
+// delivers events about changes.
+package client
+
+// APIVersion is the version of the snapd REST API the client talks to.
+const APIVersion = "v2"
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"time"
+)
+
+// ChangeEvent is sent on the channel returned by SubscribeChanges.
+type ChangeEvent struct {
+	// Change is the change which appeared or whose status changed.
+	Change *Change
+	// Err is set on the last event of a subscription that failed, Change
+	// is nil then.
+	Err error
+}
+
+// SubscribeChangesOptions selects the changes to subscribe to.
+type SubscribeChangesOptions struct {
+	ChangesOptions
+	// Interval is the delay between polls of the daemon, one second if
+	// not set.
+	Interval time.Duration
+}
+
+var defaultSubscribeInterval = time.Second
+
+// SubscribeChanges sends an event on the returned channel for every selected
+// change, first for the existing ones and then whenever a change appears or
+// its status changes. The subscription lasts until the context of the client
+// is done, see WithContext, or the daemon cannot be queried, in which case an
+// event carrying the error is sent last. The channel is closed when the
+// subscription ends.
+func (client *Client) SubscribeChanges(opts *SubscribeChangesOptions) <-chan ChangeEvent {
+	if opts == nil {
+		opts = &SubscribeChangesOptions{}
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultSubscribeInterval
+	}
+	ctx := client.context()
+
+	ch := make(chan ChangeEvent)
+	go func() {
+		defer close(ch)
+
+		send := func(ev ChangeEvent) bool {
+			select {
+			case ch <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		seen := make(map[string]string)
+		for {
+			chgs, err := client.Changes(&opts.ChangesOptions)
+			if err != nil {
+				if ctx.Err() == nil {
+					send(ChangeEvent{Err: err})
+				}
+				return
+			}
+			for _, chg := range chgs {
+				if status, ok := seen[chg.ID]; ok && status == chg.Status {
+					continue
+				}
+				seen[chg.ID] = chg.Status
+				if !send(ChangeEvent{Change: chg}) {
+					return
+				}
+			}
+
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Types of the events streamed by snapd from /v2/events.
+const (
+	// EventServiceStatus carries the status of the watched services.
+	EventServiceStatus = "service-status"
+)
+
+// StreamStoppedTrailer is the HTTP trailer set by snapd when it ends a
+// stream of events because it is stopping, eg. to restart after a refresh
+// of snapd.
+const StreamStoppedTrailer = "X-Snapd-Stream-Stopped"
+
+// Event is a record of the application/json-seq stream of events of
+// /v2/events.
+type Event struct {
+	Type string `json:"type"`
+	// Services is set for EventServiceStatus events.
+	Services []*AppInfo `json:"services,omitempty"`
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestSubscribeChanges(c *C) {
+	cs.rsps = []string{
+		`{"type": "sync", "result": [{"id": "1", "kind": "install-snap", "status": "Doing"}]}`,
+		`{"type": "sync", "result": [{"id": "1", "kind": "install-snap", "status": "Doing"}]}`,
+		`{"type": "sync", "result": [{"id": "1", "kind": "install-snap", "status": "Done", "ready": true}, {"id": "2", "kind": "remove-snap", "status": "Do"}]}`,
+	}
+	cs.rsp = cs.rsps[2]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := cs.cli.WithContext(ctx).SubscribeChanges(&client.SubscribeChangesOptions{
+		ChangesOptions: client.ChangesOptions{SnapName: "foo"},
+		Interval:       time.Millisecond,
+	})
+
+	var events []string
+	for ev := range ch {
+		c.Assert(ev.Err, IsNil)
+		events = append(events, ev.Change.ID+":"+ev.Change.Status)
+		if len(events) == 3 {
+			cancel()
+		}
+	}
+	c.Check(events, DeepEquals, []string{"1:Doing", "1:Done", "2:Do"})
+	c.Check(cs.req.URL.Path, Equals, "/v2/changes")
+	c.Check(cs.req.URL.Query().Get("for"), Equals, "foo")
+}
+
+func (cs *clientSuite) TestSubscribeChangesError(c *C) {
+	cs.err = errors.New("fail")
+
+	var events []client.ChangeEvent
+	for ev := range cs.cli.SubscribeChanges(nil) {
+		events = append(events, ev)
+	}
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0].Change, IsNil)
+	c.Check(events[0].Err, ErrorMatches, "cannot communicate with server: fail")
+}
+
+func (cs *clientSuite) TestSubscribeChangesConcurrentStatus(c *C) {
+	cs.rsp = `{"type": "sync", "result": [{"id": "1", "kind": "install-snap", "status": "Doing"}], "warning-count": 2, "warning-timestamp": "2021-01-01T00:00:00Z"}`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli := cs.cli.WithContext(ctx)
+	ch := cli.SubscribeChanges(&client.SubscribeChangesOptions{
+		Interval: time.Millisecond,
+	})
+
+	// the status of the client is updated by the subscription while
+	// it is used, which the race detector checks
+	for i := 0; i < 10; i++ {
+		c.Check(cli.Maintenance(), IsNil)
+		cli.WarningsSummary()
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	for range ch {
+	}
+
+	count, _ := cli.WarningsSummary()
+	c.Check(count, Equals, 2)
+}
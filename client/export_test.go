@@ -53,3 +53,45 @@ func UnmarshalSnapshotAction(body io.Reader) (act snapshotAction, err error) {
 }
 
 type DownloadAction = downloadAction
+
+type RemoteInfo struct {
+	Scheme    string
+	User      string
+	Host      string
+	Port      string
+	CID       uint32
+	VsockPort uint32
+}
+
+func ParseRemote(s string) (*RemoteInfo, error) {
+	r, err := parseRemote(s)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteInfo{
+		Scheme:    r.scheme,
+		User:      r.user,
+		Host:      r.host,
+		Port:      r.port,
+		CID:       r.cid,
+		VsockPort: r.vsockPort,
+	}, nil
+}
+
+func SSHForwardArgs(s, localSocket string) ([]string, error) {
+	r, err := parseRemote(s)
+	if err != nil {
+		return nil, err
+	}
+	return r.sshForwardArgs(localSocket), nil
+}
+
+func MockSSHForward(f func(dest, localSocket string) (stop func(), err error)) (restore func()) {
+	old := sshForward
+	sshForward = func(r *remote, localSocket string) (func(), error) {
+		return f(r.String(), localSocket)
+	}
+	return func() {
+		sshForward = old
+	}
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+type factoryData struct {
+	Action string `json:"action"`
+}
+
+// FactorySeal takes the device out of factory mode, sealing its
+// encryption keys and removing the factory mode relaxations. The
+// operation cannot be undone.
+func (client *Client) FactorySeal() (changeID string, err error) {
+	data, err := json.Marshal(&factoryData{
+		Action: "seal",
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal factory data: %v", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	return client.doAsync("POST", "/v2/factory", nil, headers, bytes.NewReader(data))
+}
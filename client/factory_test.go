@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+func (cs *clientSuite) TestClientFactorySeal(c *C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"result": {},
+		"change": "42"
+	}`
+	id, err := cs.cli.FactorySeal()
+	c.Assert(err, IsNil)
+	c.Check(id, Equals, "42")
+	c.Check(cs.req.Method, Equals, "POST")
+	c.Check(cs.req.URL.Path, Equals, "/v2/factory")
+	c.Check(cs.req.Header.Get("Content-Type"), Equals, "application/json")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, IsNil)
+	var jsonBody map[string]interface{}
+	c.Assert(json.Unmarshal(body, &jsonBody), IsNil)
+	c.Check(jsonBody, DeepEquals, map[string]interface{}{"action": "seal"})
+}
+
+func (cs *clientSuite) TestClientFactorySealError(c *C) {
+	cs.status = 400
+	cs.rsp = `{
+		"type": "error",
+		"status-code": 400,
+		"result": {"message": "cannot seal the device: not in factory mode"}
+	}`
+	_, err := cs.cli.FactorySeal()
+	c.Assert(err, ErrorMatches, "cannot seal the device: not in factory mode")
+}
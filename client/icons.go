@@ -20,7 +20,6 @@
 package client
 
 import (
-	"context"
 	"fmt"
 	"io/ioutil"
 	"regexp"
@@ -40,7 +39,7 @@ var contentDispositionMatcher = regexp.MustCompile(`attachment; filename=(.+)`).
 func (c *Client) Icon(pkgID string) (*Icon, error) {
 	const errPrefix = "cannot retrieve icon"
 
-	response, cancel, err := c.rawWithTimeout(context.Background(), "GET", fmt.Sprintf("/v2/icons/%s/icon", pkgID), nil, nil, nil, nil)
+	response, cancel, err := c.rawWithTimeout(c.context(), "GET", fmt.Sprintf("/v2/icons/%s/icon", pkgID), nil, nil, nil, nil)
 	if err != nil {
 		fmt := "%s: failed to communicate with server: %w"
 		return nil, xerrors.Errorf(fmt, errPrefix, err)
@@ -21,10 +21,13 @@ package client
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/url"
+	"os"
+	"path/filepath"
 
 	"golang.org/x/xerrors"
 
@@ -50,6 +53,57 @@ func (client *Client) Remodel(b []byte) (changeID string, err error) {
 	return client.doAsync("POST", "/v2/model", nil, headers, bytes.NewReader(data))
 }
 
+// RemodelOffline tries to remodel the system with the given assertion
+// data without using the store. The snaps and assertions needed by the
+// new model are taken from the tarball at bundlePath, which can be empty
+// if nothing beyond the model is needed.
+func (client *Client) RemodelOffline(b []byte, bundlePath string) (changeID string, err error) {
+	var bundle *os.File
+	if bundlePath != "" {
+		bundle, err = os.Open(bundlePath)
+		if err != nil {
+			return "", fmt.Errorf("cannot open: %q", bundlePath)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go sendRemodelBundle(b, bundle, pw, mw)
+
+	headers := map[string]string{
+		"Content-Type": mw.FormDataContentType(),
+	}
+
+	_, changeID, err = client.doAsyncFull("POST", "/v2/model", nil, headers, pr, doNoTimeoutAndRetry)
+	return changeID, err
+}
+
+func sendRemodelBundle(model []byte, bundle *os.File, pw *io.PipeWriter, mw *multipart.Writer) {
+	if bundle != nil {
+		defer bundle.Close()
+	}
+
+	if err := mw.WriteField("new-model", string(model)); err != nil {
+		pw.CloseWithError(err)
+		return
+	}
+
+	if bundle != nil {
+		fw, err := mw.CreateFormFile("bundle", filepath.Base(bundle.Name()))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(fw, bundle); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	}
+
+	mw.Close()
+	pw.Close()
+}
+
 // CurrentModelAssertion returns the current model assertion
 func (client *Client) CurrentModelAssertion() (*asserts.Model, error) {
 	assert, err := currentAssertion(client, "/v2/model")
@@ -80,7 +134,7 @@ func (client *Client) CurrentSerialAssertion() (*asserts.Serial, error) {
 func currentAssertion(client *Client, path string) (asserts.Assertion, error) {
 	q := url.Values{}
 
-	response, cancel, err := client.rawWithTimeout(context.Background(), "GET", path, q, nil, nil, nil)
+	response, cancel, err := client.rawWithTimeout(client.context(), "GET", path, q, nil, nil, nil)
 	if err != nil {
 		fmt := "failed to query current assertion: %w"
 		return nil, xerrors.Errorf(fmt, err)
@@ -23,7 +23,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
 
 	"golang.org/x/xerrors"
 
@@ -130,6 +133,60 @@ func (cs *clientSuite) TestClientRemodel(c *C) {
 	c.Check(jsonBody["new-model"], Equals, string(remodelJsonData))
 }
 
+func (cs *clientSuite) TestClientRemodelOffline(c *C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+                "result": {},
+		"change": "d729"
+	}`
+	bundlePath := filepath.Join(c.MkDir(), "snaps.tar")
+	err := ioutil.WriteFile(bundlePath, []byte("bundle-data"), 0644)
+	c.Assert(err, IsNil)
+
+	id, err := cs.cli.RemodelOffline([]byte("some-model"), bundlePath)
+	c.Assert(err, IsNil)
+	c.Check(id, Equals, "d729")
+	c.Check(cs.req.Method, Equals, "POST")
+	c.Check(cs.req.URL.Path, Equals, "/v2/model")
+
+	mediaType, params, err := mime.ParseMediaType(cs.req.Header.Get("Content-Type"))
+	c.Assert(err, IsNil)
+	c.Assert(mediaType, Equals, "multipart/form-data")
+	form, err := multipart.NewReader(cs.req.Body, params["boundary"]).ReadForm(1 << 20)
+	c.Assert(err, IsNil)
+	c.Check(form.Value["new-model"], DeepEquals, []string{"some-model"})
+	c.Assert(form.File["bundle"], HasLen, 1)
+	c.Check(form.File["bundle"][0].Filename, Equals, "snaps.tar")
+	f, err := form.File["bundle"][0].Open()
+	c.Assert(err, IsNil)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "bundle-data")
+}
+
+func (cs *clientSuite) TestClientRemodelOfflineNoBundle(c *C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+                "result": {},
+		"change": "d730"
+	}`
+	id, err := cs.cli.RemodelOffline([]byte("some-model"), "")
+	c.Assert(err, IsNil)
+	c.Check(id, Equals, "d730")
+
+	_, params, err := mime.ParseMediaType(cs.req.Header.Get("Content-Type"))
+	c.Assert(err, IsNil)
+	form, err := multipart.NewReader(cs.req.Body, params["boundary"]).ReadForm(1 << 20)
+	c.Assert(err, IsNil)
+	c.Check(form.Value["new-model"], DeepEquals, []string{"some-model"})
+	c.Check(form.File["bundle"], HasLen, 0)
+}
+
 func (cs *clientSuite) TestClientGetModelHappy(c *C) {
 	cs.status = 200
 	cs.rsp = happyModelAssertionResponse
@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// PromptingRule records the decision taken for the requests of a snap,
+// made on behalf of a user, when prompted for access.
+type PromptingRule struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	User        uint32    `json:"user"`
+	Snap        string    `json:"snap"`
+	Interface   string    `json:"interface"`
+	PathPattern string    `json:"path-pattern"`
+	Permissions []string  `json:"permissions"`
+	Outcome     string    `json:"outcome"`
+	Lifespan    string    `json:"lifespan"`
+	Expiration  time.Time `json:"expiration,omitempty"`
+}
+
+// NewPromptingRule describes a prompting rule to add.
+type NewPromptingRule struct {
+	// User is the user the rule applies to, only root can add rules
+	// for other users. It defaults to the calling user.
+	User        *uint32  `json:"user,omitempty"`
+	Snap        string   `json:"snap"`
+	Interface   string   `json:"interface"`
+	PathPattern string   `json:"path-pattern"`
+	Permissions []string `json:"permissions"`
+	Outcome     string   `json:"outcome"`
+	Lifespan    string   `json:"lifespan"`
+	// Duration is how long a rule with a "timespan" lifespan remains
+	// in effect, as accepted by time.ParseDuration.
+	Duration string `json:"duration,omitempty"`
+}
+
+// PromptingRulesOptions selects the prompting rules to return.
+type PromptingRulesOptions struct {
+	Snap      string
+	Interface string
+}
+
+type promptingRulesAction struct {
+	Action  string            `json:"action"`
+	Rule    *NewPromptingRule `json:"rule,omitempty"`
+	ID      string            `json:"id,omitempty"`
+	Rules   []*PromptingRule  `json:"rules,omitempty"`
+	Replace bool              `json:"replace,omitempty"`
+}
+
+// PromptingRules returns the prompting rules in effect, of the calling
+// user unless called by root, in the order in which they were added.
+func (client *Client) PromptingRules(opts *PromptingRulesOptions) ([]*PromptingRule, error) {
+	q := make(url.Values)
+	if opts != nil {
+		if opts.Snap != "" {
+			q.Set("snap", opts.Snap)
+		}
+		if opts.Interface != "" {
+			q.Set("interface", opts.Interface)
+		}
+	}
+
+	var rules []*PromptingRule
+	if _, err := client.doSync("GET", "/v2/interfaces/prompting/rules", q, nil, nil, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (client *Client) promptingRulesAction(action *promptingRulesAction, result interface{}) error {
+	data, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("cannot marshal prompting rules action: %v", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	_, err = client.doSync("POST", "/v2/interfaces/prompting/rules", nil, headers, bytes.NewReader(data), result)
+	return err
+}
+
+// AddPromptingRule adds the given prompting rule and returns it as
+// stored.
+func (client *Client) AddPromptingRule(rule *NewPromptingRule) (*PromptingRule, error) {
+	var added PromptingRule
+	if err := client.promptingRulesAction(&promptingRulesAction{Action: "add", Rule: rule}, &added); err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// RemovePromptingRule removes the prompting rule with the given ID and
+// returns it.
+func (client *Client) RemovePromptingRule(id string) (*PromptingRule, error) {
+	var removed PromptingRule
+	if err := client.promptingRulesAction(&promptingRulesAction{Action: "remove", ID: id}, &removed); err != nil {
+		return nil, err
+	}
+	return &removed, nil
+}
+
+// ImportPromptingRules adds the given prompting rules, as returned by
+// PromptingRules, replacing the existing ones if replace is set. The
+// rules are assigned new IDs, expired rules are skipped.
+func (client *Client) ImportPromptingRules(rules []*PromptingRule, replace bool) ([]*PromptingRule, error) {
+	var imported []*PromptingRule
+	action := &promptingRulesAction{Action: "import", Rules: rules, Replace: replace}
+	if err := client.promptingRulesAction(action, &imported); err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
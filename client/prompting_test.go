@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientPromptingRules(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": [{
+			"id": "0000000000000001",
+			"timestamp": "2021-06-01T10:00:00Z",
+			"user": 1000,
+			"snap": "foo",
+			"interface": "home",
+			"path-pattern": "/home/test/**",
+			"permissions": ["read"],
+			"outcome": "allow",
+			"lifespan": "timespan",
+			"expiration": "2021-06-01T11:00:00Z"
+		}]
+	}`
+	rules, err := cs.cli.PromptingRules(&client.PromptingRulesOptions{Snap: "foo", Interface: "home"})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces/prompting/rules")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"snap":      {"foo"},
+		"interface": {"home"},
+	})
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	c.Check(rules, check.DeepEquals, []*client.PromptingRule{{
+		ID:          "0000000000000001",
+		Timestamp:   t0,
+		User:        1000,
+		Snap:        "foo",
+		Interface:   "home",
+		PathPattern: "/home/test/**",
+		Permissions: []string{"read"},
+		Outcome:     "allow",
+		Lifespan:    "timespan",
+		Expiration:  t0.Add(time.Hour),
+	}})
+}
+
+func (cs *clientSuite) TestClientAddPromptingRule(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"id": "0000000000000002", "snap": "foo"}
+	}`
+	rule, err := cs.cli.AddPromptingRule(&client.NewPromptingRule{
+		Snap:        "foo",
+		Interface:   "home",
+		PathPattern: "/home/test/*",
+		Permissions: []string{"read", "write"},
+		Outcome:     "deny",
+		Lifespan:    "timespan",
+		Duration:    "1h",
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(rule.ID, check.Equals, "0000000000000002")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces/prompting/rules")
+	c.Check(cs.req.Header.Get("Content-Type"), check.Equals, "application/json")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	c.Assert(json.Unmarshal(body, &jsonBody), check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action": "add",
+		"rule": map[string]interface{}{
+			"snap":         "foo",
+			"interface":    "home",
+			"path-pattern": "/home/test/*",
+			"permissions":  []interface{}{"read", "write"},
+			"outcome":      "deny",
+			"lifespan":     "timespan",
+			"duration":     "1h",
+		},
+	})
+}
+
+func (cs *clientSuite) TestClientRemovePromptingRule(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"id": "0000000000000002", "snap": "foo"}
+	}`
+	rule, err := cs.cli.RemovePromptingRule("0000000000000002")
+	c.Assert(err, check.IsNil)
+	c.Check(rule.Snap, check.Equals, "foo")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	c.Assert(json.Unmarshal(body, &jsonBody), check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action": "remove",
+		"id":     "0000000000000002",
+	})
+}
+
+func (cs *clientSuite) TestClientImportPromptingRules(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": [{"id": "0000000000000003", "snap": "foo"}]
+	}`
+	imported, err := cs.cli.ImportPromptingRules([]*client.PromptingRule{{
+		ID:          "00000000000000AA",
+		Snap:        "foo",
+		Interface:   "home",
+		PathPattern: "/home/test/*",
+		Permissions: []string{"read"},
+		Outcome:     "allow",
+		Lifespan:    "forever",
+	}}, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(imported, check.HasLen, 1)
+	c.Check(imported[0].ID, check.Equals, "0000000000000003")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	c.Assert(json.Unmarshal(body, &jsonBody), check.IsNil)
+	c.Check(jsonBody["action"], check.Equals, "import")
+	c.Check(jsonBody["replace"], check.Equals, true)
+	c.Check(jsonBody["rules"], check.HasLen, 1)
+}
+
+func (cs *clientSuite) TestClientPromptingRulesError(c *check.C) {
+	cs.rsp = `{"type": "error", "status-code": 404, "result": {"message": "cannot find prompting rule 0000000000000042"}}`
+	_, err := cs.cli.RemovePromptingRule("0000000000000042")
+	c.Check(err, check.ErrorMatches, "cannot find prompting rule 0000000000000042")
+}
@@ -0,0 +1,296 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// remoteSnapdSocket is the path of the snapd socket on remote devices.
+const remoteSnapdSocket = "/run/snapd.socket"
+
+// remote describes how to reach the snapd of a remote device.
+type remote struct {
+	// scheme is either "ssh" or "vsock"
+	scheme string
+
+	// user, host and port are the destination of the SSH connection,
+	// user and port are optional
+	user string
+	host string
+	port string
+
+	// cid and vsockPort are the address of the broker forwarding
+	// connections to snapd on the device
+	cid       uint32
+	vsockPort uint32
+
+	// mu guards the forwarding of the remote snapd socket over SSH,
+	// which is started on the first connection and shared by the
+	// following ones
+	mu          sync.Mutex
+	socket      string
+	stopForward func()
+
+	// transport keeps the connections to the remote snapd alive
+	transport *http.Transport
+}
+
+// parseRemote parses a remote snapd description, which is either
+// [ssh://][<user>@]<host>[:<port>] to reach snapd with the SSH access to the
+// device, or vsock://<cid>:<port> to reach a broker forwarding connections
+// to snapd on the given virtual socket.
+func parseRemote(s string) (*remote, error) {
+	if s == "" {
+		return nil, fmt.Errorf("remote is empty")
+	}
+	if !strings.Contains(s, "://") {
+		s = "ssh://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse remote %q: %v", s, err)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("cannot use remote %q: unexpected path or query", s)
+	}
+
+	switch u.Scheme {
+	case "ssh":
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("cannot use remote %q: no host", s)
+		}
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return nil, fmt.Errorf("cannot use remote %q: passwords are not supported", s)
+		}
+		return &remote{
+			scheme: "ssh",
+			user:   u.User.Username(),
+			host:   u.Hostname(),
+			port:   u.Port(),
+		}, nil
+	case "vsock":
+		if u.User != nil {
+			return nil, fmt.Errorf("cannot use remote %q: users are not supported", s)
+		}
+		cid, err := strconv.ParseUint(u.Hostname(), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use remote %q: invalid context ID %q", s, u.Hostname())
+		}
+		port, err := strconv.ParseUint(u.Port(), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use remote %q: invalid port %q", s, u.Port())
+		}
+		return &remote{
+			scheme:    "vsock",
+			cid:       uint32(cid),
+			vsockPort: uint32(port),
+		}, nil
+	default:
+		return nil, fmt.Errorf("cannot use remote %q: unsupported scheme %q", s, u.Scheme)
+	}
+}
+
+func (r *remote) String() string {
+	if r.scheme == "vsock" {
+		return fmt.Sprintf("vsock://%d:%d", r.cid, r.vsockPort)
+	}
+	dest := r.host
+	if r.user != "" {
+		dest = r.user + "@" + dest
+	}
+	if r.port != "" {
+		dest = net.JoinHostPort(dest, r.port)
+	}
+	return "ssh://" + dest
+}
+
+// sshForwardTimeout is how long to wait for SSH to forward the remote snapd
+// socket.
+var sshForwardTimeout = 30 * time.Second
+
+// sshForwardArgs returns the arguments of the ssh command forwarding the
+// remote snapd socket to the given local socket.
+func (r *remote) sshForwardArgs(localSocket string) []string {
+	args := []string{
+		"-T", "-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "StreamLocalBindUnlink=yes",
+		"-L", localSocket + ":" + remoteSnapdSocket,
+	}
+	if r.port != "" {
+		args = append(args, "-p", r.port)
+	}
+	dest := r.host
+	if r.user != "" {
+		dest = r.user + "@" + dest
+	}
+	return append(args, "--", dest)
+}
+
+// sshForward forwards the remote snapd socket to the given local socket with
+// ssh, the returned function stops the forwarding.
+var sshForward = func(r *remote, localSocket string) (stop func(), err error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("ssh", r.sshForwardArgs(localSocket)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot run ssh: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	stop = func() {
+		cmd.Process.Kill()
+		<-exited
+	}
+
+	timeout := time.NewTimer(sshForwardTimeout)
+	defer timeout.Stop()
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for !osutil.FileExists(localSocket) {
+		select {
+		case err := <-exited:
+			exited <- err
+			return nil, fmt.Errorf("cannot forward snapd socket of %s: %v", r, osutil.OutputErr(stderr.Bytes(), err))
+		case <-timeout.C:
+			stop()
+			return nil, fmt.Errorf("cannot forward snapd socket of %s: timeout after %s", r, sshForwardTimeout)
+		case <-tick.C:
+		}
+	}
+	return stop, nil
+}
+
+// startForward forwards the remote snapd socket to a local socket with ssh.
+func (r *remote) startForward() error {
+	dir, err := ioutil.TempDir("", "snap-remote-")
+	if err != nil {
+		return err
+	}
+	localSocket := filepath.Join(dir, "snapd.socket")
+	stop, err := sshForward(r, localSocket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	r.socket = localSocket
+	r.stopForward = func() {
+		stop()
+		os.RemoveAll(dir)
+	}
+	return nil
+}
+
+// stopForwardLocked stops the forwarding of the remote snapd socket, if
+// any, r.mu must be held.
+func (r *remote) stopForwardLocked() {
+	if r.stopForward == nil {
+		return
+	}
+	r.stopForward()
+	r.stopForward = nil
+	r.socket = ""
+}
+
+// dialSSH connects to the remote snapd over a socket forwarded with SSH,
+// the forwarding is started on the first connection and reused by the
+// following ones.
+func (r *remote) dialSSH() (net.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopForward == nil {
+		if err := r.startForward(); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.Dial("unix", r.socket)
+	if err != nil {
+		// ssh may have exited, eg. when the connection to the
+		// device dropped, start a new forwarding next time
+		r.stopForwardLocked()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// close closes the idle connections to the remote snapd and stops the
+// forwarding of its socket, if any.
+func (r *remote) close() {
+	if r.transport != nil {
+		r.transport.CloseIdleConnections()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopForwardLocked()
+}
+
+func (r *remote) dial(_, _ string) (net.Conn, error) {
+	if r.scheme == "vsock" {
+		return dialVsock(r.cid, r.vsockPort)
+	}
+	return r.dialSSH()
+}
+
+// SetRemote makes the client talk to the snapd of a remote device, given as
+// [ssh://][<user>@]<host>[:<port>] to use the SSH access to the device, or as
+// vsock://<cid>:<port> to go through a broker forwarding connections to
+// snapd on a virtual socket. The local authorization data is not sent to
+// remote devices. Over SSH a single ssh process forwards the connections of
+// the client, Close stops it once the client is no longer needed.
+func (client *Client) SetRemote(remoteDesc string) error {
+	r, err := parseRemote(remoteDesc)
+	if err != nil {
+		return err
+	}
+	client.Close()
+	client.remote = r
+	client.baseURL = url.URL{
+		Scheme: "http",
+		Host:   "localhost",
+	}
+	r.transport = &http.Transport{Dial: r.dial}
+	client.doer = &http.Client{Transport: r.transport}
+	client.disableAuth = true
+	return nil
+}
+
+// Close stops the forwarding of the remote snapd socket set up for the
+// connections to the device given to SetRemote, the forwarding is started
+// again by the next request. It does nothing for local snapd.
+func (client *Client) Close() {
+	if client.remote != nil {
+		client.remote.close()
+	}
+}
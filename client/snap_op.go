@@ -21,7 +21,6 @@ package client
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -31,19 +30,21 @@ import (
 )
 
 type SnapOptions struct {
-	Channel          string `json:"channel,omitempty"`
-	Revision         string `json:"revision,omitempty"`
-	CohortKey        string `json:"cohort-key,omitempty"`
-	LeaveCohort      bool   `json:"leave-cohort,omitempty"`
-	DevMode          bool   `json:"devmode,omitempty"`
-	JailMode         bool   `json:"jailmode,omitempty"`
-	Classic          bool   `json:"classic,omitempty"`
-	Dangerous        bool   `json:"dangerous,omitempty"`
-	IgnoreValidation bool   `json:"ignore-validation,omitempty"`
-	IgnoreRunning    bool   `json:"ignore-running,omitempty"`
-	Unaliased        bool   `json:"unaliased,omitempty"`
-	Purge            bool   `json:"purge,omitempty"`
-	Amend            bool   `json:"amend,omitempty"`
+	Channel                 string `json:"channel,omitempty"`
+	Revision                string `json:"revision,omitempty"`
+	CohortKey               string `json:"cohort-key,omitempty"`
+	LeaveCohort             bool   `json:"leave-cohort,omitempty"`
+	DevMode                 bool   `json:"devmode,omitempty"`
+	JailMode                bool   `json:"jailmode,omitempty"`
+	Classic                 bool   `json:"classic,omitempty"`
+	Dangerous               bool   `json:"dangerous,omitempty"`
+	IgnoreValidation        bool   `json:"ignore-validation,omitempty"`
+	IgnoreRunning           bool   `json:"ignore-running,omitempty"`
+	ApprovePolicyChanges    bool   `json:"approve-policy-changes,omitempty"`
+	ApproveDefaultProviders bool   `json:"approve-default-providers,omitempty"`
+	Unaliased               bool   `json:"unaliased,omitempty"`
+	Purge                   bool   `json:"purge,omitempty"`
+	Amend                   bool   `json:"amend,omitempty"`
 
 	Users []string `json:"users,omitempty"`
 }
@@ -78,6 +79,9 @@ func (opts *SnapOptions) writeOptionFields(mw *multipart.Writer) error {
 	if err := writeFieldBool(mw, "ignore-running", opts.IgnoreRunning); err != nil {
 		return err
 	}
+	if err := writeFieldBool(mw, "approve-default-providers", opts.ApproveDefaultProviders); err != nil {
+		return err
+	}
 	return writeFieldBool(mw, "unaliased", opts.Unaliased)
 }
 
@@ -369,7 +373,7 @@ func (client *Client) Download(name string, options *DownloadOptions) (dlInfo *D
 	}
 
 	// no deadline for downloads
-	ctx := context.Background()
+	ctx := client.context()
 	rsp, err := client.raw(ctx, "POST", "/v2/download", nil, headers, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, nil, err
@@ -59,6 +59,9 @@ type SystemAction struct {
 	Title string `json:"title,omitempty"`
 	// Mode given action can be executed in
 	Mode string `json:"mode,omitempty"`
+	// GadgetAction is the kind of the action when it was declared by the
+	// gadget
+	GadgetAction string `json:"gadget-action,omitempty"`
 }
 
 // ListSystems list all systems available for seeding or recovery.
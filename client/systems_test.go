@@ -50,7 +50,8 @@ func (cs *clientSuite) TestListSystemsSome(c *check.C) {
 	                },
 	                "actions": [
 	                    {"title": "recover", "mode": "recover"},
-	                    {"title": "reinstall", "mode": "install"}
+	                    {"title": "reinstall", "mode": "install"},
+	                    {"title": "diagnostics", "mode": "run", "gadget-action": "run-app"}
 	                ]
 	           }, {
 	                "label": "20200311",
@@ -92,6 +93,7 @@ func (cs *clientSuite) TestListSystemsSome(c *check.C) {
 			Actions: []client.SystemAction{
 				{Title: "recover", Mode: "recover"},
 				{Title: "reinstall", Mode: "install"},
+				{Title: "diagnostics", Mode: "run", GadgetAction: "run-app"},
 			},
 		}, {
 			Label: "20200311",
@@ -151,6 +153,32 @@ func (cs *clientSuite) TestRequestSystemActionHappy(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestRequestSystemGadgetActionHappy(c *check.C) {
+	cs.rsp = `{
+	    "type": "sync",
+	    "status-code": 200,
+	    "result": {}
+	}`
+	err := cs.cli.DoSystemAction("1234", &client.SystemAction{
+		Title:        "wipe data",
+		Mode:         "factory-reset",
+		GadgetAction: "wipe-data",
+	})
+	c.Assert(err, check.IsNil)
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var req map[string]interface{}
+	err = json.Unmarshal(body, &req)
+	c.Assert(err, check.IsNil)
+	c.Assert(req, check.DeepEquals, map[string]interface{}{
+		"action":        "do",
+		"title":         "wipe data",
+		"mode":          "factory-reset",
+		"gadget-action": "wipe-data",
+	})
+}
+
 func (cs *clientSuite) TestRequestSystemActionError(c *check.C) {
 	cs.rsp = `{
 	    "type": "error",
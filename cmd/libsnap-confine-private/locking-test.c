@@ -130,6 +130,28 @@ static void test_sc_verify_snap_lock__unlocked(void)
 	    ("unexpectedly managed to acquire exclusive lock over snap foo\n");
 }
 
+// Check that a claimed serial port cannot be claimed again.
+static void test_sc_lock_serial_port(void)
+{
+	if (geteuid() != 0) {
+		g_test_skip("this test only runs as root");
+		return;
+	}
+
+	(void)sc_test_use_fake_lock_dir();
+	if (g_test_subprocess()) {
+		int fd = sc_lock_serial_port("ttyUSB0");
+		// The descriptor is inherited across exec.
+		g_assert_cmpint(fcntl(fd, F_GETFD) & FD_CLOEXEC, ==, 0);
+		(void)sc_lock_serial_port("ttyUSB0");
+		return;
+	}
+	g_test_trap_subprocess(NULL, 0, 0);
+	g_test_trap_assert_failed();
+	g_test_trap_assert_stderr
+	    ("serial port ttyUSB0 is claimed by another application\n");
+}
+
 static void test_sc_enable_sanity_timeout(void)
 {
 	if (geteuid() != 0) {
@@ -161,4 +183,6 @@ static void __attribute__((constructor)) init(void)
 			test_sc_verify_snap_lock__locked);
 	g_test_add_func("/locking/sc_verify_snap_lock__unlocked",
 			test_sc_verify_snap_lock__unlocked);
+	g_test_add_func("/locking/sc_lock_serial_port",
+			test_sc_lock_serial_port);
 }
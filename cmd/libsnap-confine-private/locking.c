@@ -25,6 +25,7 @@
 #include <fcntl.h>
 #include <signal.h>
 #include <stdarg.h>
+#include <string.h>
 #include <sys/file.h>
 #include <sys/stat.h>
 #include <sys/types.h>
@@ -197,6 +198,43 @@ int sc_lock_snap_user(const char *snap_name, uid_t uid)
 	return sc_lock_generic(snap_name, uid);
 }
 
+int sc_lock_serial_port(const char *sysname)
+{
+	int dir_fd SC_CLEANUP(sc_cleanup_close) = -1;
+	char lock_fname[PATH_MAX] = { 0 };
+	int lock_fd;
+
+	if (sysname == NULL || sysname[0] == '\0' || strchr(sysname, '/') != NULL) {
+		die("cannot claim serial port with invalid name");
+	}
+	dir_fd = get_lock_directory();
+	sc_must_snprintf(lock_fname, sizeof lock_fname, "serial-port.%s.lock",
+			 sysname);
+
+	// The lock file is not opened with O_CLOEXEC, the application inherits
+	// the descriptor and with it the claim over the port.
+	debug("opening lock file: %s/%s", sc_lock_dir, lock_fname);
+	sc_identity old = sc_set_effective_identity(sc_root_group_identity());
+	lock_fd = openat(dir_fd, lock_fname, O_CREAT | O_RDWR | O_NOFOLLOW, 0600);
+	(void)sc_set_effective_identity(old);
+	if (lock_fd < 0) {
+		die("cannot open lock file: %s/%s", sc_lock_dir, lock_fname);
+	}
+	debug("claiming serial port %s", sysname);
+	if (flock(lock_fd, LOCK_EX | LOCK_NB) < 0) {
+		int saved_errno = errno;
+		close(lock_fd);
+		errno = saved_errno;
+		if (errno == EWOULDBLOCK) {
+			errno = 0;
+			die("serial port %s is claimed by another application",
+			    sysname);
+		}
+		die("cannot claim serial port %s", sysname);
+	}
+	return lock_fd;
+}
+
 void sc_unlock(int lock_fd)
 {
 	// Release the lock and finish.
@@ -74,6 +74,18 @@ void sc_verify_snap_lock(const char *snap_name);
  **/
 int sc_lock_snap_user(const char *snap_name, uid_t uid);
 
+/**
+ * Claim a serial port with a flock-based, exclusive, non-blocking lock.
+ *
+ * The actual lock is placed in "/run/snapd/lock/serial-port.$SYSNAME.lock"
+ * where sysname is the kernel name of the port, like ttyUSB0.
+ *
+ * Unlike the other locks the file descriptor is not closed on exec so that
+ * the claim is held for as long as the application, or any of its children,
+ * runs. The process dies if the port is already claimed.
+ **/
+int sc_lock_serial_port(const char *sysname);
+
 /**
  * Release a flock-based lock.
  *
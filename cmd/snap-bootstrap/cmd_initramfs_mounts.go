@@ -31,7 +31,9 @@ import (
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/cmd/snap-bootstrap/degraded"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/overlord/state"
@@ -74,14 +76,59 @@ var (
 		snap.TypeSnapd:  "snapd",
 	}
 
-	secbootMeasureSnapSystemEpochWhenPossible    func() error
-	secbootMeasureSnapModelWhenPossible          func(findModel func() (*asserts.Model, error)) error
-	secbootUnlockVolumeUsingSealedKeyIfEncrypted func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error)
-	secbootUnlockEncryptedVolumeUsingKey         func(disk disks.Disk, name string, key []byte) (string, error)
+	secbootMeasureSnapSystemEpochWhenPossible      func() error
+	secbootMeasureSnapModelWhenPossible            func(findModel func() (*asserts.Model, error)) error
+	secbootMeasureSeedVerityWhenPossible           func(findRootHashes func() (map[string][]byte, error)) error
+	secbootMeasureDataIntegrityWhenPossible        func() error
+	secbootUnlockVolumeUsingSealedKeyIfEncrypted   func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error)
+	secbootUnlockEncryptedVolumeUsingKey           func(disk disks.Disk, name string, key []byte) (string, error)
+	secbootUnlockVolumeUsingRecoveryKeyIfEncrypted func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error)
+	secbootUnsealKey                               func(keyFile string) ([]byte, error)
 
 	bootFindPartitionUUIDForBootedKernelDisk = boot.FindPartitionUUIDForBootedKernelDisk
+	bootRestoreTrustedBootAssets             = boot.RestoreTrustedBootAssets
 )
 
+// secbootUnlockBackend unlocks volumes for the degraded state machine with
+// the secboot functions above.
+type secbootUnlockBackend struct {
+	// requireDataIntegrity is set when ubuntu-data must be integrity
+	// protected to be unlocked with a sealed key.
+	requireDataIntegrity bool
+}
+
+// newSecbootUnlockBackend returns the unlock backend, ubuntu-data is
+// required to be integrity protected when it was created so at install.
+func newSecbootUnlockBackend() secbootUnlockBackend {
+	return secbootUnlockBackend{requireDataIntegrity: boot.InitramfsDataIntegrityRequired()}
+}
+
+func (b secbootUnlockBackend) UnlockVolumeUsingSealedKey(disk disks.Disk, name, sealedKeyFile string) (secboot.UnlockResult, error) {
+	if b.requireDataIntegrity {
+		// the marker requiring integrity is not authenticated, the
+		// keys of an integrity protected ubuntu-data are sealed to
+		// its measurement so that they cannot be unsealed without it
+		err := stampedAction("data-integrity-measured", func() error {
+			return secbootMeasureDataIntegrityWhenPossible()
+		})
+		if err != nil {
+			return secboot.UnlockResult{}, err
+		}
+	}
+	opts := &secboot.UnlockVolumeUsingSealedKeyOptions{
+		RequireIntegrity: b.requireDataIntegrity && name == "ubuntu-data",
+	}
+	return secbootUnlockVolumeUsingSealedKeyIfEncrypted(disk, name, sealedKeyFile, opts)
+}
+
+func (secbootUnlockBackend) UnlockEncryptedVolumeUsingKey(disk disks.Disk, name string, key []byte) (string, error) {
+	return secbootUnlockEncryptedVolumeUsingKey(disk, name, key)
+}
+
+func (secbootUnlockBackend) UnlockVolumeUsingRecoveryKey(disk disks.Disk, name string) (secboot.UnlockResult, error) {
+	return secbootUnlockVolumeUsingRecoveryKeyIfEncrypted(disk, name, secboot.VolumeLocation{})
+}
+
 func stampedAction(stamp string, action func() error) error {
 	stampFile := filepath.Join(dirs.SnapBootstrapRunDir, stamp)
 	if osutil.FileExists(stampFile) {
@@ -96,6 +143,14 @@ func stampedAction(stamp string, action func() error) error {
 	return ioutil.WriteFile(stampFile, nil, 0644)
 }
 
+// enableFactoryKeys makes the keys stored unprotected in factory mode usable
+// to unlock the volumes, on devices which were not sealed yet.
+func enableFactoryKeys() {
+	if boot.InitramfsEnableFactoryKeys() {
+		logger.Noticef("device is in factory mode, accepting unprotected keys")
+	}
+}
+
 func generateInitramfsMounts() error {
 	// Ensure there is a very early initial measurement
 	err := stampedAction("secboot-epoch-measured", func() error {
@@ -122,6 +177,10 @@ func generateInitramfsMounts() error {
 		return generateMountsModeInstall(mst)
 	case "run":
 		return generateMountsModeRun(mst)
+	case "repair":
+		return generateMountsModeRepair(mst)
+	case "factory-reset":
+		return generateMountsModeFactoryReset(mst)
 	}
 	// this should never be reached
 	return fmt.Errorf("internal error: mode in generateInitramfsMounts not handled")
@@ -269,7 +328,7 @@ func copyFromGlobHelper(src, dst, globEx string) error {
 	return nil
 }
 
-func generateMountsModeRecover(mst *initramfsMountsState) error {
+func generateMountsModeRecover(mst *initramfsMountsState) (err error) {
 	// steps 1 and 2 are shared with install mode
 	if err := generateMountsCommonInstallRecover(mst); err != nil {
 		return err
@@ -316,14 +375,26 @@ func generateMountsModeRecover(mst *initramfsMountsState) error {
 
 	// 3. mount ubuntu-data for recovery using run mode key
 	runModeKey := filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key")
-	opts := &secboot.UnlockVolumeUsingSealedKeyOptions{
-		LockKeysOnFinish: true,
+	enableFactoryKeys()
+	// access to the sealed keys is locked once all volumes were unlocked,
+	// also when unlocking any of them failed
+	if err := secboot.ArmTPMSealedKeysLock(); err != nil {
+		return fmt.Errorf("cannot arm locking access to sealed keys: %v", err)
+	}
+	defer lockTPMSealedKeysOnReturn(&err)
+	// try the run mode key first, then the key sealed for the recovery
+	// systems and finally the recovery key
+	unlocker := degraded.New(disk, newSecbootUnlockBackend())
+	dataRes := unlocker.Unlock(&degraded.Volume{
+		Name:             "ubuntu-data",
+		RunKeyFile:       runModeKey,
+		FallbackKeyFile:  filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-data.recovery.sealed-key"),
 		AllowRecoveryKey: true,
+	})
+	if dataRes.State != degraded.StateUnlocked {
+		return dataRes.Err
 	}
-	unlockRes, err := secbootUnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", runModeKey, opts)
-	if err != nil {
-		return err
-	}
+	unlockRes := dataRes.Result
 
 	// don't do fsck on the data partition, it could be corrupted
 	if err := doSystemdMount(unlockRes.Device, boot.InitramfsHostUbuntuDataDir, nil); err != nil {
@@ -331,7 +402,7 @@ func generateMountsModeRecover(mst *initramfsMountsState) error {
 	}
 
 	// 3.1. mount ubuntu-save (if present)
-	haveSave, err := maybeMountSave(disk, boot.InitramfsHostWritableDir, unlockRes.IsDecryptedDevice, nil)
+	haveSave, err := maybeMountSave(unlocker, disk, boot.InitramfsHostWritableDir, unlockRes.IsDecryptedDevice, nil)
 	if err != nil {
 		return err
 	}
@@ -398,6 +469,172 @@ func generateMountsModeRecover(mst *initramfsMountsState) error {
 	return nil
 }
 
+// generateMountsModeRepair mounts the system installed on the internal disk
+// after booting from external recovery media, so that it can be repaired.
+func generateMountsModeRepair(mst *initramfsMountsState) error {
+	// steps 1 and 2 are shared with install and recover modes, the seed is
+	// the one of the recovery media
+	if err := generateMountsCommonInstallRecover(mst); err != nil {
+		return err
+	}
+
+	// get the disk of the recovery media, to tell it apart from the disk of
+	// the installed system
+	seedDisk, err := disks.DiskFromMountPoint(boot.InitramfsUbuntuSeedDir, nil)
+	if err != nil {
+		return err
+	}
+
+	// 3. locate the installed system through its ubuntu-boot partition,
+	//    recovery media only carry ubuntu-seed
+	fsckSystemdOpts := &systemdMountOptions{
+		NeedsFsck: true,
+	}
+	if err := doSystemdMount("/dev/disk/by-label/ubuntu-boot", boot.InitramfsUbuntuBootDir, fsckSystemdOpts); err != nil {
+		return err
+	}
+	disk, err := disks.DiskFromMountPoint(boot.InitramfsUbuntuBootDir, nil)
+	if err != nil {
+		return err
+	}
+	if disk.Dev() == seedDisk.Dev() {
+		return fmt.Errorf("cannot repair the system installed on disk %s when booting from it, use recover mode instead", disk.Dev())
+	}
+
+	// 4. unlock ubuntu-data with the recovery key, the sealed keys cannot be
+	//    used as the boot chain is the one of the recovery media
+	unlocker := degraded.New(disk, newSecbootUnlockBackend())
+	dataRes := unlocker.Unlock(&degraded.Volume{
+		Name:             "ubuntu-data",
+		AllowRecoveryKey: true,
+	})
+	if dataRes.State != degraded.StateUnlocked {
+		return dataRes.Err
+	}
+	unlockRes := dataRes.Result
+	// don't do fsck on the data partition, it could be corrupted
+	if err := doSystemdMount(unlockRes.Device, boot.InitramfsHostUbuntuDataDir, nil); err != nil {
+		return err
+	}
+	haveSave, err := maybeMountSave(unlocker, disk, boot.InitramfsHostWritableDir, unlockRes.IsDecryptedDevice, nil)
+	if err != nil {
+		return err
+	}
+
+	// 4.1 verify that ubuntu-data and ubuntu-save come from the disk of the
+	//     installed system
+	diskOpts := &disks.Options{IsDecryptedDevice: unlockRes.IsDecryptedDevice}
+	matches, err := disk.MountPointIsFromDisk(boot.InitramfsHostUbuntuDataDir, diskOpts)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("cannot validate repair: ubuntu-data mountpoint is expected to be from disk %s but is not", disk.Dev())
+	}
+	if haveSave {
+		matches, err = disk.MountPointIsFromDisk(boot.InitramfsUbuntuSaveDir, diskOpts)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return fmt.Errorf("cannot validate repair: ubuntu-save mountpoint is expected to be from disk %s but is not", disk.Dev())
+		}
+	}
+
+	// 5. restore the trusted boot assets of the installed system, so that
+	//    its sealed keys can be unsealed again on the next boot
+	restored, err := bootRestoreTrustedBootAssets(boot.InitramfsHostWritableDir)
+	if err != nil {
+		return fmt.Errorf("cannot restore boot assets: %v", err)
+	}
+	if len(restored) != 0 {
+		logger.Noticef("restored boot assets: %s", strings.Join(restored, ", "))
+	}
+
+	// 6. final step: copy the auth data and network config from the
+	//    installed system to the ephemeral ubuntu-data dir, so that the
+	//    repair can be done as a known user, and write the modeenv
+	if err := copyUbuntuDataAuth(boot.InitramfsHostUbuntuDataDir, boot.InitramfsDataDir); err != nil {
+		return err
+	}
+	if err := copyNetworkConfig(boot.InitramfsHostUbuntuDataDir, boot.InitramfsDataDir); err != nil {
+		return err
+	}
+	if err := copyUbuntuDataMisc(boot.InitramfsHostUbuntuDataDir, boot.InitramfsDataDir); err != nil {
+		return err
+	}
+
+	modeEnv := &boot.Modeenv{
+		Mode:           "repair",
+		RecoverySystem: mst.recoverySystem,
+	}
+	return modeEnv.WriteTo(boot.InitramfsWritableDir)
+}
+
+func generateMountsModeFactoryReset(mst *initramfsMountsState) error {
+	// steps 1 and 2 are shared with install and recover modes
+	if err := generateMountsCommonInstallRecover(mst); err != nil {
+		return err
+	}
+
+	disk, err := disks.DiskFromMountPoint(boot.InitramfsUbuntuSeedDir, nil)
+	if err != nil {
+		return err
+	}
+
+	// 3. mount ubuntu-save, which is preserved by the factory reset, the
+	//    installed system cannot be trusted so ubuntu-data is not used to
+	//    unlock it, instead the key of ubuntu-save is unsealed from the
+	//    fallback object and handed over to snapd which replaces it
+	var saveDevice string
+	isEncrypted := false
+	saveSealedKey := filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key")
+	if osutil.FileExists(saveSealedKey) {
+		isEncrypted = true
+		key, err := secbootUnsealKey(saveSealedKey)
+		if err != nil {
+			return fmt.Errorf("cannot unseal the ubuntu-save key: %v", err)
+		}
+		saveDevice, err = secbootUnlockEncryptedVolumeUsingKey(disk, "ubuntu-save", key)
+		if err != nil {
+			return fmt.Errorf("cannot unlock ubuntu-save volume: %v", err)
+		}
+		saveKeyFile := filepath.Join(dirs.SnapFDEDirUnder(boot.InitramfsWritableDir), "ubuntu-save.key")
+		if err := os.MkdirAll(filepath.Dir(saveKeyFile), 0755); err != nil {
+			return err
+		}
+		if err := osutil.AtomicWriteFile(saveKeyFile, key, 0600, 0); err != nil {
+			return err
+		}
+	} else {
+		partUUID, err := disk.FindMatchingPartitionUUID("ubuntu-save")
+		if err != nil {
+			return fmt.Errorf("cannot factory reset without ubuntu-save: %v", err)
+		}
+		saveDevice = filepath.Join("/dev/disk/by-partuuid", partUUID)
+	}
+	if err := doSystemdMount(saveDevice, boot.InitramfsUbuntuSaveDir, nil); err != nil {
+		return err
+	}
+
+	// 3.1 verify that ubuntu-save comes from the same disk as ubuntu-seed
+	diskOpts := &disks.Options{IsDecryptedDevice: isEncrypted}
+	matches, err := disk.MountPointIsFromDisk(boot.InitramfsUbuntuSaveDir, diskOpts)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("cannot validate factory reset: ubuntu-save mountpoint is expected to be from disk %s but is not", disk.Dev())
+	}
+
+	// 4. final step: write the modeenv to the tmpfs data dir
+	modeEnv := &boot.Modeenv{
+		Mode:           "factory-reset",
+		RecoverySystem: mst.recoverySystem,
+	}
+	return modeEnv.WriteTo(boot.InitramfsWritableDir)
+}
+
 // mountPartitionMatchingKernelDisk will select the partition to mount at dir,
 // using the boot package function FindPartitionUUIDForBootedKernelDisk to
 // determine what partition the booted kernel came from. If which disk the
@@ -446,8 +683,30 @@ func generateMountsCommonInstallRecover(mst *initramfsMountsState) error {
 		return err
 	}
 
-	// 2.2. (auto) select recovery system and mount seed snaps
+	// 2.1.1. verify the seed snaps which have a dm-verity hash tree and
+	// measure the root hashes of the trees, the fallback keys were sealed
+	// with the root hashes recorded at install so removing the trees
+	// prevents unsealing them
+	rootHashes, err := boot.SeedVerityRootHashes(boot.InitramfsUbuntuSeedDir, essSnaps)
+	if err != nil {
+		return err
+	}
+	if len(rootHashes) != 0 {
+		err = stampedAction(fmt.Sprintf("%s-seed-verity-measured", mst.recoverySystem), func() error {
+			return secbootMeasureSeedVerityWhenPossible(func() (map[string][]byte, error) {
+				return rootHashes, nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 2.2. (auto) select recovery system and mount seed snaps, the snaps
+	// and the tmpfs for ubuntu-data are independent and mounted
+	// concurrently
 	// TODO:UC20: do we need more cross checks here?
+	var steps []mountStep
 	for _, essentialSnap := range essSnaps {
 		if essentialSnap.EssentialType == snap.TypeGadget {
 			// don't need to mount the gadget anywhere, but we use the snap
@@ -455,10 +714,14 @@ func generateMountsCommonInstallRecover(mst *initramfsMountsState) error {
 			continue
 		}
 		dir := snapTypeToMountDir[essentialSnap.EssentialType]
+		snapPath := essentialSnap.Path
 		// TODO:UC20: we need to cross-check the kernel path with snapd_recovery_kernel used by grub
-		if err := doSystemdMount(essentialSnap.Path, filepath.Join(boot.InitramfsRunMntDir, dir), nil); err != nil {
-			return err
-		}
+		steps = append(steps, mountStep{
+			name: string(essentialSnap.EssentialType),
+			do: func() error {
+				return doSystemdMount(snapPath, filepath.Join(boot.InitramfsRunMntDir, dir), nil)
+			},
+		})
 	}
 
 	// TODO:UC20: after we have the kernel and base snaps mounted, we should do
@@ -475,11 +738,16 @@ func generateMountsCommonInstallRecover(mst *initramfsMountsState) error {
 	//            writing it in Go instead of shellscript is desirable
 
 	// 2.3. mount "ubuntu-data" on a tmpfs
-	mntOpts := &systemdMountOptions{
-		Tmpfs: true,
-	}
-	err = doSystemdMount("tmpfs", boot.InitramfsDataDir, mntOpts)
-	if err != nil {
+	steps = append(steps, mountStep{
+		name: "ubuntu-data",
+		do: func() error {
+			mntOpts := &systemdMountOptions{
+				Tmpfs: true,
+			}
+			return doSystemdMount("tmpfs", boot.InitramfsDataDir, mntOpts)
+		},
+	})
+	if err := runMountSteps(steps); err != nil {
 		return err
 	}
 
@@ -509,7 +777,7 @@ func generateMountsCommonInstallRecover(mst *initramfsMountsState) error {
 	return sysconfig.ConfigureTargetSystem(configOpts)
 }
 
-func maybeMountSave(disk disks.Disk, rootdir string, encrypted bool, mountOpts *systemdMountOptions) (haveSave bool, err error) {
+func maybeMountSave(unlocker *degraded.Machine, disk disks.Disk, rootdir string, encrypted bool, mountOpts *systemdMountOptions) (haveSave bool, err error) {
 	var saveDevice string
 	if encrypted {
 		saveKey := filepath.Join(dirs.SnapFDEDirUnder(rootdir), "ubuntu-save.key")
@@ -520,14 +788,15 @@ func maybeMountSave(disk disks.Disk, rootdir string, encrypted bool, mountOpts *
 			return false, fmt.Errorf("cannot find ubuntu-save encryption key at %v", saveKey)
 		}
 		// we have save.key, volume exists and is encrypted
-		key, err := ioutil.ReadFile(saveKey)
-		if err != nil {
-			return true, err
-		}
-		saveDevice, err = secbootUnlockEncryptedVolumeUsingKey(disk, "ubuntu-save", key)
-		if err != nil {
-			return true, fmt.Errorf("cannot unlock ubuntu-save volume: %v", err)
+		saveRes := unlocker.Unlock(&degraded.Volume{
+			Name:    "ubuntu-save",
+			KeyFile: saveKey,
+		})
+		if saveRes.State != degraded.StateUnlocked {
+			lastAttempt := saveRes.Attempts[len(saveRes.Attempts)-1]
+			return true, fmt.Errorf("cannot unlock ubuntu-save volume: %v", lastAttempt.Err)
 		}
+		saveDevice = saveRes.Result.Device
 	} else {
 		partUUID, err := disk.FindMatchingPartitionUUID("ubuntu-save")
 		if err != nil {
@@ -546,7 +815,47 @@ func maybeMountSave(disk disks.Disk, rootdir string, encrypted bool, mountOpts *
 	return true, nil
 }
 
-func generateMountsModeRun(mst *initramfsMountsState) error {
+// mountExtraVolumes unlocks the extra encrypted volumes recorded on
+// ubuntu-data with their own sealed keys, stored next to the run mode key of
+// ubuntu-data, and mounts them at their declared locations.
+func mountExtraVolumes(unlocker *degraded.Machine, disk disks.Disk, mountOpts *systemdMountOptions) error {
+	// the record is read from ubuntu-data, which was unlocked with its
+	// sealed key and verified already
+	vols, err := secboot.ReadExtraVolumes(dirs.SnapFDEDirUnder(boot.InitramfsWritableDir))
+	if err != nil {
+		return fmt.Errorf("cannot read extra volumes: %v", err)
+	}
+	for _, vol := range vols {
+		volRes := unlocker.Unlock(&degraded.Volume{
+			Name:       vol.Name,
+			RunKeyFile: vol.SealedKeyFile(boot.InitramfsBootEncryptionKeyDir),
+		})
+		if volRes.State != degraded.StateUnlocked {
+			return volRes.Err
+		}
+		unlockRes := volRes.Result
+		// only encrypted volumes are expected, an unencrypted
+		// partition with the same label could be controlled by anyone
+		if !unlockRes.IsDecryptedDevice {
+			return fmt.Errorf("cannot unlock %s volume: volume is not encrypted", vol.Name)
+		}
+		mountPoint := filepath.Join(dirs.GlobalRootDir, vol.MountPoint)
+		if err := doSystemdMount(unlockRes.Device, mountPoint, mountOpts); err != nil {
+			return err
+		}
+		diskOpts := &disks.Options{IsDecryptedDevice: unlockRes.IsDecryptedDevice}
+		matches, err := disk.MountPointIsFromDisk(mountPoint, diskOpts)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return fmt.Errorf("cannot validate boot: %s mountpoint is expected to be from disk %s but is not", vol.Name, disk.Dev())
+		}
+	}
+	return nil
+}
+
+func generateMountsModeRun(mst *initramfsMountsState) (err error) {
 	// 1. mount ubuntu-boot
 	if err := mountPartitionMatchingKernelDisk(boot.InitramfsUbuntuBootDir, "ubuntu-boot"); err != nil {
 		return err
@@ -559,14 +868,6 @@ func generateMountsModeRun(mst *initramfsMountsState) error {
 		return err
 	}
 
-	// 2. mount ubuntu-seed
-	// use the disk we mounted ubuntu-boot from as a reference to find
-	// ubuntu-seed and mount it
-	partUUID, err := disk.FindMatchingPartitionUUID("ubuntu-seed")
-	if err != nil {
-		return err
-	}
-
 	// fsck is safe to run on ubuntu-seed as per the manpage, it should not
 	// meaningfully contribute to corruption if we fsck it every time we boot,
 	// and it is important to fsck it because it is vfat and mounted writable
@@ -575,46 +876,177 @@ func generateMountsModeRun(mst *initramfsMountsState) error {
 	fsckSystemdOpts := &systemdMountOptions{
 		NeedsFsck: true,
 	}
-	if err := doSystemdMount(fmt.Sprintf("/dev/disk/by-partuuid/%s", partUUID), boot.InitramfsUbuntuSeedDir, fsckSystemdOpts); err != nil {
-		return err
-	}
 
-	// 3.1. measure model
-	err = stampedAction("run-model-measured", func() error {
-		return secbootMeasureSnapModelWhenPossible(mst.UnverifiedBootModel)
-	})
-	if err != nil {
-		return err
-	}
-	// TODO:UC20: cross check the model we read from ubuntu-boot/model with
-	// one recorded in ubuntu-data modeenv during install
+	// access to the sealed keys is locked once all volumes were unlocked,
+	// also when unlocking any of them failed
+	if err := secboot.ArmTPMSealedKeysLock(); err != nil {
+		return fmt.Errorf("cannot arm locking access to sealed keys: %v", err)
+	}
+	defer lockTPMSealedKeysOnReturn(&err)
+
+	// the volumes are unlocked with the run key, or the recovery key the
+	// user is prompted for, the unlocking steps depend on each other so
+	// that they are not run concurrently
+	unlocker := degraded.New(disk, newSecbootUnlockBackend())
+
+	// the remaining steps are run as soon as the ones they depend on are
+	// done, ubuntu-seed is waited for and checked while ubuntu-data is
+	// unlocked and mounted, the snaps are mounted concurrently, etc.
+	var unlockRes secboot.UnlockResult
+	var haveSave bool
+	var modeEnv *boot.Modeenv
+	var mounts map[snap.Type]snap.PlaceInfo
+	steps := []mountStep{{
+		// 2. mount ubuntu-seed
+		name: "ubuntu-seed",
+		do: func() error {
+			// use the disk we mounted ubuntu-boot from as a reference to
+			// find ubuntu-seed and mount it
+			partUUID, err := disk.FindMatchingPartitionUUID("ubuntu-seed")
+			if err != nil {
+				return err
+			}
+			return doSystemdMount(fmt.Sprintf("/dev/disk/by-partuuid/%s", partUUID), boot.InitramfsUbuntuSeedDir, fsckSystemdOpts)
+		},
+	}, {
+		// 3.1. measure model
+		name: "model-measured",
+		do: func() error {
+			return stampedAction("run-model-measured", func() error {
+				return secbootMeasureSnapModelWhenPossible(mst.UnverifiedBootModel)
+			})
+		},
+		// TODO:UC20: cross check the model we read from ubuntu-boot/model
+		// with one recorded in ubuntu-data modeenv during install
+	}, {
+		// 3.2. mount Data
+		name:  "ubuntu-data",
+		after: []string{"model-measured"},
+		do: func() error {
+			runModeKey := filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key")
+			enableFactoryKeys()
+			dataRes := unlocker.Unlock(&degraded.Volume{
+				Name:             "ubuntu-data",
+				RunKeyFile:       runModeKey,
+				AllowRecoveryKey: true,
+			})
+			if dataRes.State != degraded.StateUnlocked {
+				return dataRes.Err
+			}
+			unlockRes = dataRes.Result
+
+			// TODO: do we actually need fsck if we are mounting a mapper
+			// device? probably not?
+			return doSystemdMount(unlockRes.Device, boot.InitramfsDataDir, fsckSystemdOpts)
+		},
+	}, {
+		// 3.3. mount ubuntu-save (if present)
+		name:  "ubuntu-save",
+		after: []string{"ubuntu-data"},
+		do: func() error {
+			var err error
+			haveSave, err = maybeMountSave(unlocker, disk, boot.InitramfsWritableDir, unlockRes.IsDecryptedDevice, fsckSystemdOpts)
+			return err
+		},
+	}, {
+		// 4.1 verify that ubuntu-data comes from where we expect it to
+		name:  "verified",
+		after: []string{"ubuntu-data", "ubuntu-save"},
+		do: func() error {
+			return verifyDataAndSave(disk, unlockRes.IsDecryptedDevice, haveSave)
+		},
+	}, {
+		// 4.1b unlock and mount the additional encrypted volumes
+		// declared by the gadget, the sealed keys are unlocked one after
+		// the other
+		name:  "extra-volumes",
+		after: []string{"verified"},
+		do: func() error {
+			if !unlockRes.IsDecryptedDevice {
+				return nil
+			}
+			return mountExtraVolumes(unlocker, disk, fsckSystemdOpts)
+		},
+	}, {
+		// 4.2. read modeenv
+		name:  "modeenv",
+		after: []string{"verified"},
+		do: func() error {
+			var err error
+			modeEnv, err = boot.ReadModeenv(boot.InitramfsWritableDir)
+			if err != nil {
+				return err
+			}
 
-	// 3.2. mount Data
-	runModeKey := filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.sealed-key")
-	opts := &secboot.UnlockVolumeUsingSealedKeyOptions{
-		LockKeysOnFinish: true,
-		AllowRecoveryKey: true,
-	}
-	unlockRes, err := secbootUnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", runModeKey, opts)
-	if err != nil {
-		return err
-	}
+			typs := []snap.Type{snap.TypeBase, snap.TypeKernel}
 
-	// TODO: do we actually need fsck if we are mounting a mapper device?
-	// probably not?
-	if err := doSystemdMount(unlockRes.Device, boot.InitramfsDataDir, fsckSystemdOpts); err != nil {
-		return err
+			// 4.2 choose base and kernel snaps (this includes updating
+			//     modeenv if needed to try the base snap)
+			mounts, err = boot.InitramfsRunModeSelectSnapsToMount(typs, modeEnv)
+			return err
+		},
+		// TODO:UC20: with grade > dangerous, verify the kernel snap hash
+		//            against what we booted using the tpm log, this may need
+		//            to be passed to the function above to make decisions
+		//            there, or perhaps this code actually belongs in the
+		//            bootloader implementation itself
+	}}
+
+	// 4.3 mount base and kernel snaps
+	// make sure this is a deterministic order
+	for _, typ := range []snap.Type{snap.TypeBase, snap.TypeKernel} {
+		typ := typ
+		steps = append(steps, mountStep{
+			name:  string(typ),
+			after: []string{"modeenv"},
+			do: func() error {
+				sn, ok := mounts[typ]
+				if !ok {
+					return nil
+				}
+				dir := snapTypeToMountDir[typ]
+				snapPath := filepath.Join(dirs.SnapBlobDirUnder(boot.InitramfsWritableDir), sn.Filename())
+				return doSystemdMount(snapPath, filepath.Join(boot.InitramfsRunMntDir, dir), nil)
+			},
+		})
 	}
 
-	// 3.3. mount ubuntu-save (if present)
-	haveSave, err := maybeMountSave(disk, boot.InitramfsWritableDir, unlockRes.IsDecryptedDevice, fsckSystemdOpts)
-	if err != nil {
-		return err
+	// 4.4 mount snapd snap only on first boot
+	steps = append(steps, mountStep{
+		name:  "snapd",
+		after: []string{"ubuntu-seed", "modeenv"},
+		do: func() error {
+			if modeEnv.RecoverySystem == "" {
+				return nil
+			}
+			// load the recovery system and generate mount for snapd
+			_, essSnaps, err := mst.ReadEssential(modeEnv.RecoverySystem, []snap.Type{snap.TypeSnapd})
+			if err != nil {
+				return fmt.Errorf("cannot load metadata and verify snapd snap: %v", err)
+			}
+
+			return doSystemdMount(essSnaps[0].Path, filepath.Join(boot.InitramfsRunMntDir, "snapd"), nil)
+		},
+	})
+
+	return runMountSteps(steps)
+}
+
+// lockTPMSealedKeysOnReturn locks access to the sealed keys if that was armed
+// and is meant to be deferred once all the volumes are about to be unlocked.
+// Failing to lock is an error even if all volumes were unlocked so that the
+// boot does not proceed with the keys still unsealable.
+func lockTPMSealedKeysOnReturn(err *error) {
+	if lockErr := secbootLockTPMSealedKeysIfArmed(); lockErr != nil && *err == nil {
+		*err = fmt.Errorf("cannot lock access to sealed keys: %v", lockErr)
 	}
+}
 
-	// 4.1 verify that ubuntu-data comes from where we expect it to
+// verifyDataAndSave verifies that ubuntu-data and ubuntu-save, if present,
+// were mounted from the disk ubuntu-boot was mounted from.
+func verifyDataAndSave(disk disks.Disk, encrypted, haveSave bool) error {
 	diskOpts := &disks.Options{}
-	if unlockRes.IsDecryptedDevice {
+	if encrypted {
 		// then we need to specify that the data mountpoint is expected to be a
 		// decrypted device, applies to both ubuntu-data and ubuntu-save
 		diskOpts.IsDecryptedDevice = true
@@ -639,49 +1071,5 @@ func generateMountsModeRun(mst *initramfsMountsState) error {
 			return fmt.Errorf("cannot validate boot: ubuntu-save mountpoint is expected to be from disk %s but is not", disk.Dev())
 		}
 	}
-
-	// 4.2. read modeenv
-	modeEnv, err := boot.ReadModeenv(boot.InitramfsWritableDir)
-	if err != nil {
-		return err
-	}
-
-	typs := []snap.Type{snap.TypeBase, snap.TypeKernel}
-
-	// 4.2 choose base and kernel snaps (this includes updating modeenv if
-	//     needed to try the base snap)
-	mounts, err := boot.InitramfsRunModeSelectSnapsToMount(typs, modeEnv)
-	if err != nil {
-		return err
-	}
-
-	// TODO:UC20: with grade > dangerous, verify the kernel snap hash against
-	//            what we booted using the tpm log, this may need to be passed
-	//            to the function above to make decisions there, or perhaps this
-	//            code actually belongs in the bootloader implementation itself
-
-	// 4.3 mount base and kernel snaps
-	// make sure this is a deterministic order
-	for _, typ := range []snap.Type{snap.TypeBase, snap.TypeKernel} {
-		if sn, ok := mounts[typ]; ok {
-			dir := snapTypeToMountDir[typ]
-			snapPath := filepath.Join(dirs.SnapBlobDirUnder(boot.InitramfsWritableDir), sn.Filename())
-			if err := doSystemdMount(snapPath, filepath.Join(boot.InitramfsRunMntDir, dir), nil); err != nil {
-				return err
-			}
-		}
-	}
-
-	// 4.4 mount snapd snap only on first boot
-	if modeEnv.RecoverySystem != "" {
-		// load the recovery system and generate mount for snapd
-		_, essSnaps, err := mst.ReadEssential(modeEnv.RecoverySystem, []snap.Type{snap.TypeSnapd})
-		if err != nil {
-			return fmt.Errorf("cannot load metadata and verify snapd snap: %v", err)
-		}
-
-		return doSystemdMount(essSnaps[0].Path, filepath.Join(boot.InitramfsRunMntDir, "snapd"), nil)
-	}
-
 	return nil
 }
@@ -39,10 +39,25 @@ func init() {
 	secbootMeasureSnapModelWhenPossible = func(_ func() (*asserts.Model, error)) error {
 		return errNotImplemented
 	}
+	secbootMeasureSeedVerityWhenPossible = func(_ func() (map[string][]byte, error)) error {
+		return errNotImplemented
+	}
+	secbootMeasureDataIntegrityWhenPossible = func() error {
+		return errNotImplemented
+	}
 	secbootUnlockVolumeUsingSealedKeyIfEncrypted = func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
 		return secboot.UnlockResult{}, errNotImplemented
 	}
 	secbootUnlockEncryptedVolumeUsingKey = func(disk disks.Disk, name string, key []byte) (string, error) {
 		return "", errNotImplemented
 	}
+	secbootUnlockVolumeUsingRecoveryKeyIfEncrypted = func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		return secboot.UnlockResult{}, errNotImplemented
+	}
+	secbootUnsealKey = func(keyFile string) ([]byte, error) {
+		return nil, errNotImplemented
+	}
+	secbootLockTPMSealedKeysIfArmed = func() error {
+		return errNotImplemented
+	}
 }
@@ -27,6 +27,11 @@ import (
 func init() {
 	secbootMeasureSnapSystemEpochWhenPossible = secboot.MeasureSnapSystemEpochWhenPossible
 	secbootMeasureSnapModelWhenPossible = secboot.MeasureSnapModelWhenPossible
+	secbootMeasureSeedVerityWhenPossible = secboot.MeasureSeedVerityWhenPossible
+	secbootMeasureDataIntegrityWhenPossible = secboot.MeasureDataIntegrityWhenPossible
 	secbootUnlockVolumeUsingSealedKeyIfEncrypted = secboot.UnlockVolumeUsingSealedKeyIfEncrypted
 	secbootUnlockEncryptedVolumeUsingKey = secboot.UnlockEncryptedVolumeUsingKey
+	secbootUnlockVolumeUsingRecoveryKeyIfEncrypted = secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted
+	secbootUnsealKey = secboot.UnsealKey
+	secbootLockTPMSealedKeysIfArmed = secboot.LockTPMSealedKeysIfArmed
 }
@@ -25,7 +25,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	. "gopkg.in/check.v1"
@@ -190,6 +192,9 @@ func (s *initramfsMountsSuite) SetUpTest(c *C) {
 	_, restore := logger.MockLogger()
 	s.AddCleanup(restore)
 
+	// the mocked mounts are expected in order
+	s.AddCleanup(main.MockMountStepsConcurrently(false))
+
 	s.tmpDir = c.MkDir()
 
 	// mock /run/mnt
@@ -230,6 +235,9 @@ func (s *initramfsMountsSuite) SetUpTest(c *C) {
 	s.AddCleanup(main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
 		return secboot.UnlockResult{Device: filepath.Join("/dev/disk/by-partuuid", name+"-partuuid")}, nil
 	}))
+	s.AddCleanup(main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		return nil
+	}))
 }
 
 // makeSnapFilesOnEarlyBootUbuntuData creates the snap files on ubuntu-data as
@@ -374,6 +382,24 @@ func (s *initramfsMountsSuite) mockSystemdMountSequence(c *C, mounts []systemdMo
 		// mocked mounts
 		c.Check(n, Equals, len(mounts), comment)
 	})
+	if main.MountStepsConcurrently() {
+		// the independent mounts happen in any order
+		var mu sync.Mutex
+		mounted := make([]bool, len(mounts))
+		return main.MockSystemdMount(func(what, where string, opts *main.SystemdMountOptions) error {
+			mu.Lock()
+			defer mu.Unlock()
+			n++
+			for i, mnt := range mounts {
+				if !mounted[i] && what == mnt.what && where == mnt.where && reflect.DeepEqual(opts, mnt.opts) {
+					mounted[i] = true
+					return nil
+				}
+			}
+			c.Errorf("unexpected systemd-mount call: %s, %s, %+v (%s)", what, where, opts, comment.CheckCommentString())
+			return fmt.Errorf("unexpected systemd-mount call: %s, %s, %+v", what, where, opts)
+		})
+	}
 	return main.MockSystemdMount(func(what, where string, opts *main.SystemdMountOptions) error {
 		n++
 		c.Assert(n <= len(mounts), Equals, true)
@@ -415,6 +441,11 @@ recovery_system=20191118
 	c.Check(cloudInitDisable, testutil.FilePresent)
 }
 
+func (s *initramfsMountsSuite) TestInitramfsMountsInstallModeHappyConcurrently(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+	s.TestInitramfsMountsInstallModeHappy(c)
+}
+
 func (s *initramfsMountsSuite) TestInitramfsMountsInstallModeGadgetDefaultsHappy(c *C) {
 	// setup a seed with default gadget yaml
 	const gadgetYamlDefaults = `
@@ -557,6 +588,11 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeUnencryptedWithSaveHapp
 	c.Assert(err, IsNil)
 }
 
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeUnencryptedWithSaveHappyConcurrently(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+	s.TestInitramfsMountsRunModeUnencryptedWithSaveHappy(c)
+}
+
 func (s *initramfsMountsSuite) TestInitramfsMountsRunModeNoSaveUnencryptedHappy(c *C) {
 	s.mockProcCmdlineContent(c, "snapd_recovery_mode=run")
 
@@ -1452,7 +1488,25 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeWithBootedKernelPartUUI
 }
 
 func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataHappy(c *C) {
+	s.testInitramfsMountsRunModeEncryptedDataHappy(c, false, false)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataFactoryKeysHappy(c *C) {
+	s.testInitramfsMountsRunModeEncryptedDataHappy(c, true, false)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataIntegrityHappy(c *C) {
+	s.testInitramfsMountsRunModeEncryptedDataHappy(c, false, true)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataHappyConcurrently(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+	s.testInitramfsMountsRunModeEncryptedDataHappy(c, false, false)
+}
+
+func (s *initramfsMountsSuite) testInitramfsMountsRunModeEncryptedDataHappy(c *C, factoryKeys, integrity bool) {
 	s.mockProcCmdlineContent(c, "snapd_recovery_mode=run")
+	defer secboot.UnregisterKeyProtector(secboot.FactoryKeyProtectorName)
 
 	restore := disks.MockMountPointDisksToPartitionMapping(
 		map[disks.Mountpoint]*disks.MockDiskMapping{
@@ -1489,15 +1543,51 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataHappy(c *C
 	defer mf.Close()
 	err = asserts.NewEncoder(mf).Encode(s.model)
 	c.Assert(err, IsNil)
+	if factoryKeys {
+		// the device was installed in factory mode and not sealed yet
+		err = os.MkdirAll(boot.InitramfsBootEncryptionKeyDir, 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(boot.InitramfsBootEncryptionKeyDir, "factory-keys"), nil, 0644)
+		c.Assert(err, IsNil)
+	}
+	if integrity {
+		// ubuntu-data was created integrity protected
+		err = os.MkdirAll(boot.InitramfsBootEncryptionKeyDir, 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.integrity"), nil, 0644)
+		c.Assert(err, IsNil)
+	}
+
+	measureIntegrityCalls := 0
+	restore = main.MockSecbootMeasureDataIntegrityWhenPossible(func() error {
+		measureIntegrityCalls++
+		return nil
+	})
+	defer restore()
 
 	dataActivated := false
 	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
 		c.Assert(name, Equals, "ubuntu-data")
 		c.Assert(encryptionKeyFile, Equals, filepath.Join(s.tmpDir, "run/mnt/ubuntu-boot/device/fde/ubuntu-data.sealed-key"))
+		// the recovery key is tried separately if needed
 		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{
-			LockKeysOnFinish: true,
-			AllowRecoveryKey: true,
+			RequireIntegrity: integrity,
 		})
+		// the integrity requirement is measured before unsealing
+		if integrity {
+			c.Check(measureIntegrityCalls, Equals, 1)
+		} else {
+			c.Check(measureIntegrityCalls, Equals, 0)
+		}
+		// access to the sealed keys is locked later
+		c.Check(secboot.TPMSealedKeysLockArmed(), Equals, true)
+		// the unprotected factory keys are accepted only in factory mode
+		_, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+		if factoryKeys {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, ErrorMatches, `unknown key protector "factory"`)
+		}
 		dataActivated = true
 		// return true because we are using an encrypted device
 		return secboot.UnlockResult{
@@ -1519,6 +1609,14 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataHappy(c *C
 	})
 	defer restore()
 
+	lockCalls := 0
+	restore = main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		c.Check(saveActivated, Equals, true, Commentf("ubuntu-save not activated yet"))
+		lockCalls++
+		return nil
+	})
+	defer restore()
+
 	measureEpochCalls := 0
 	measureModelCalls := 0
 	restore = main.MockSecbootMeasureSnapSystemEpochWhenPossible(func() error {
@@ -1563,12 +1661,241 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataHappy(c *C
 	c.Assert(err, IsNil)
 	c.Check(dataActivated, Equals, true)
 	c.Check(saveActivated, Equals, true)
+	c.Check(lockCalls, Equals, 1)
 	c.Check(measureEpochCalls, Equals, 1)
 	c.Check(measureModelCalls, Equals, 1)
 	c.Check(measuredModel, DeepEquals, s.model)
 
 	c.Assert(filepath.Join(dirs.SnapBootstrapRunDir, "secboot-epoch-measured"), testutil.FilePresent)
 	c.Assert(filepath.Join(dirs.SnapBootstrapRunDir, "run-model-measured"), testutil.FilePresent)
+	if integrity {
+		c.Check(measureIntegrityCalls, Equals, 1)
+		c.Assert(filepath.Join(dirs.SnapBootstrapRunDir, "data-integrity-measured"), testutil.FilePresent)
+	} else {
+		c.Check(measureIntegrityCalls, Equals, 0)
+		c.Assert(filepath.Join(dirs.SnapBootstrapRunDir, "data-integrity-measured"), testutil.FileAbsent)
+	}
+}
+
+func (s *initramfsMountsSuite) testInitramfsMountsRunModeEncryptedExtraVolumes(c *C, extraEncrypted bool) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=run")
+
+	extraMountPoint := filepath.Join(boot.InitramfsRunMntDir, "extra")
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuBootDir}:                          defaultEncBootDisk,
+			{Mountpoint: boot.InitramfsDataDir, IsDecryptedDevice: true}:       defaultEncBootDisk,
+			{Mountpoint: boot.InitramfsUbuntuSaveDir, IsDecryptedDevice: true}: defaultEncBootDisk,
+			{Mountpoint: extraMountPoint, IsDecryptedDevice: true}:             defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	mounts := []systemdMount{
+		ubuntuLabelMount("ubuntu-boot", "run"),
+		ubuntuPartUUIDMount("ubuntu-seed-partuuid", "run"),
+		{
+			"path-to-data-device",
+			boot.InitramfsDataDir,
+			needsFsckDiskMountOpts,
+		},
+		{
+			"path-to-save-device",
+			boot.InitramfsUbuntuSaveDir,
+			needsFsckDiskMountOpts,
+		},
+	}
+	if extraEncrypted {
+		mounts = append(mounts, []systemdMount{
+			{
+				"path-to-extra-device",
+				extraMountPoint,
+				needsFsckDiskMountOpts,
+			},
+			s.makeRunSnapSystemdMount(snap.TypeBase, s.core20),
+			s.makeRunSnapSystemdMount(snap.TypeKernel, s.kernel),
+		}...)
+	}
+	restore = s.mockSystemdMountSequence(c, mounts, nil)
+	defer restore()
+
+	// write the installed model like makebootable does it
+	err := os.MkdirAll(filepath.Join(boot.InitramfsUbuntuBootDir, "device"), 0755)
+	c.Assert(err, IsNil)
+	mf, err := os.Create(filepath.Join(boot.InitramfsUbuntuBootDir, "device/model"))
+	c.Assert(err, IsNil)
+	defer mf.Close()
+	err = asserts.NewEncoder(mf).Encode(s.model)
+	c.Assert(err, IsNil)
+
+	// the extra volume declared by the gadget was recorded on ubuntu-data
+	// at install
+	err = secboot.WriteExtraVolumes(dirs.SnapFDEDirUnder(boot.InitramfsWritableDir), []secboot.ExtraVolume{
+		{Name: "extra", MountPoint: "/run/mnt/extra"},
+	})
+	c.Assert(err, IsNil)
+
+	var unlocked []string
+	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
+		c.Assert(encryptionKeyFile, Equals, filepath.Join(s.tmpDir, "run/mnt/ubuntu-boot/device/fde", name+".sealed-key"))
+		// the recovery key is tried separately if needed
+		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{})
+		unlocked = append(unlocked, name)
+		return secboot.UnlockResult{
+			Device:            fmt.Sprintf("path-to-%s-device", strings.TrimPrefix(name, "ubuntu-")),
+			IsDecryptedDevice: name != "extra" || extraEncrypted,
+		}, nil
+	})
+	defer restore()
+
+	s.mockUbuntuSaveKey(c, boot.InitramfsWritableDir, "foo")
+
+	restore = main.MockSecbootUnlockEncryptedVolumeUsingKey(func(disk disks.Disk, name string, key []byte) (string, error) {
+		c.Assert(name, Equals, "ubuntu-save")
+		unlocked = append(unlocked, name)
+		return "path-to-save-device", nil
+	})
+	defer restore()
+
+	restore = main.MockSecbootMeasureSnapSystemEpochWhenPossible(func() error { return nil })
+	defer restore()
+	restore = main.MockSecbootMeasureSnapModelWhenPossible(func(findModel func() (*asserts.Model, error)) error { return nil })
+	defer restore()
+
+	// mock a bootloader
+	bloader := boottest.MockUC20RunBootenv(bootloadertest.Mock("mock", c.MkDir()))
+	bootloader.Force(bloader)
+	defer bootloader.Force(nil)
+
+	// set the current kernel
+	restore = bloader.SetEnabledKernel(s.kernel)
+	defer restore()
+
+	makeSnapFilesOnEarlyBootUbuntuData(c, s.kernel, s.core20)
+
+	// write modeenv
+	modeEnv := boot.Modeenv{
+		Mode:           "run",
+		Base:           s.core20.Filename(),
+		CurrentKernels: []string{s.kernel.Filename()},
+	}
+	err = modeEnv.WriteTo(boot.InitramfsWritableDir)
+	c.Assert(err, IsNil)
+
+	_, err = main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	if extraEncrypted {
+		c.Assert(err, IsNil)
+	} else {
+		c.Assert(err, ErrorMatches, "cannot unlock extra volume: volume is not encrypted")
+	}
+	c.Check(unlocked, DeepEquals, []string{"ubuntu-data", "ubuntu-save", "extra"})
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedExtraVolumesHappy(c *C) {
+	const extraEncrypted = true
+	s.testInitramfsMountsRunModeEncryptedExtraVolumes(c, extraEncrypted)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedExtraVolumesNotEncrypted(c *C) {
+	// a partition with the label of the extra volume but not encrypted
+	// is not mounted
+	const extraEncrypted = false
+	s.testInitramfsMountsRunModeEncryptedExtraVolumes(c, extraEncrypted)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedExtraVolumesHappyConcurrently(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+	s.TestInitramfsMountsRunModeEncryptedExtraVolumesHappy(c)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataUnhappyRecoveryKey(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=run")
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-boot", "run"),
+		ubuntuPartUUIDMount("ubuntu-seed-partuuid", "run"),
+	}, nil)
+	defer restore()
+
+	var unlocks []string
+	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
+		c.Assert(name, Equals, "ubuntu-data")
+		unlocks = append(unlocks, "run-key")
+		return secboot.UnlockResult{IsDecryptedDevice: true}, fmt.Errorf("run key fail")
+	})
+	defer restore()
+	restore = main.MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		c.Assert(name, Equals, "ubuntu-data")
+		unlocks = append(unlocks, "recovery-key")
+		return secboot.UnlockResult{}, fmt.Errorf("recovery key fail")
+	})
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, ErrorMatches, "cannot unlock ubuntu-data with recovery-key: recovery key fail")
+	// the recovery key is only tried once the run key failed
+	c.Check(unlocks, DeepEquals, []string{"run-key", "recovery-key"})
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataIntegrityMarkerRemoved(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=run")
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-boot", "run"),
+		ubuntuPartUUIDMount("ubuntu-seed-partuuid", "run"),
+	}, nil)
+	defer restore()
+
+	// ubuntu-data was created integrity protected and its keys were
+	// sealed to the measurement of the requirement, but the marker on
+	// ubuntu-boot was removed offline to unlock an ubuntu-data without
+	// integrity protection
+	integrityMeasured := false
+	restore = main.MockSecbootMeasureDataIntegrityWhenPossible(func() error {
+		integrityMeasured = true
+		return nil
+	})
+	defer restore()
+
+	var unlocks []string
+	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
+		c.Assert(name, Equals, "ubuntu-data")
+		c.Check(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{})
+		unlocks = append(unlocks, "run-key")
+		// like the TPM, which refuses to unseal the keys as the
+		// PCR values do not match
+		if !integrityMeasured {
+			return secboot.UnlockResult{IsDecryptedDevice: true}, fmt.Errorf("cannot unseal key: PCR policy mismatch")
+		}
+		return secboot.UnlockResult{Device: "path-to-data-device", IsDecryptedDevice: true}, nil
+	})
+	defer restore()
+	restore = main.MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		c.Assert(name, Equals, "ubuntu-data")
+		unlocks = append(unlocks, "recovery-key")
+		return secboot.UnlockResult{}, fmt.Errorf("recovery key fail")
+	})
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, ErrorMatches, "cannot unlock ubuntu-data with recovery-key: recovery key fail")
+	c.Check(integrityMeasured, Equals, false)
+	// ubuntu-data is only unlocked with the recovery key
+	c.Check(unlocks, DeepEquals, []string{"run-key", "recovery-key"})
 }
 
 func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataUnhappyNoSave(c *C) {
@@ -1697,6 +2024,14 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataUnhappyUnl
 	})
 	defer restore()
 
+	// access to the sealed keys is locked even when unlocking fails
+	lockCalls := 0
+	restore = main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		lockCalls++
+		return fmt.Errorf("not reported")
+	})
+	defer restore()
+
 	restore = main.MockSecbootMeasureSnapSystemEpochWhenPossible(func() error { return nil })
 	defer restore()
 	restore = main.MockSecbootMeasureSnapModelWhenPossible(func(findModel func() (*asserts.Model, error)) error {
@@ -1727,38 +2062,119 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedDataUnhappyUnl
 	_, err = main.Parser().ParseArgs([]string{"initramfs-mounts"})
 	c.Assert(err, ErrorMatches, "cannot unlock ubuntu-save volume: ubuntu-save unlock fail")
 	c.Check(dataActivated, Equals, true)
+	c.Check(lockCalls, Equals, 1)
 }
 
-func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedNoModel(c *C) {
-	s.testInitramfsMountsEncryptedNoModel(c, "run", "", 1)
-}
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedLockSealedKeysFail(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=run")
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuBootDir}:                          defaultEncBootDisk,
+			{Mountpoint: boot.InitramfsDataDir, IsDecryptedDevice: true}:       defaultEncBootDisk,
+			{Mountpoint: boot.InitramfsUbuntuSaveDir, IsDecryptedDevice: true}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
 
-func (s *initramfsMountsSuite) TestInitramfsMountsInstallModeEncryptedNoModel(c *C) {
-	s.testInitramfsMountsEncryptedNoModel(c, "install", s.sysLabel, 0)
-}
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-boot", "run"),
+		ubuntuPartUUIDMount("ubuntu-seed-partuuid", "run"),
+		{
+			"path-to-data-device",
+			boot.InitramfsDataDir,
+			needsFsckDiskMountOpts,
+		},
+		{
+			"path-to-save-device",
+			boot.InitramfsUbuntuSaveDir,
+			needsFsckDiskMountOpts,
+		},
+		s.makeRunSnapSystemdMount(snap.TypeBase, s.core20),
+		s.makeRunSnapSystemdMount(snap.TypeKernel, s.kernel),
+	}, nil)
+	defer restore()
 
-func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeEncryptedNoModel(c *C) {
-	s.testInitramfsMountsEncryptedNoModel(c, "recover", s.sysLabel, 0)
-}
+	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
+		return secboot.UnlockResult{
+			Device:            "path-to-data-device",
+			IsDecryptedDevice: true,
+		}, nil
+	})
+	defer restore()
 
-func (s *initramfsMountsSuite) testInitramfsMountsEncryptedNoModel(c *C, mode, label string, expectedMeasureModelCalls int) {
-	s.mockProcCmdlineContent(c, fmt.Sprintf("snapd_recovery_mode=%s", mode))
+	s.mockUbuntuSaveKey(c, boot.InitramfsWritableDir, "foo")
+	restore = main.MockSecbootUnlockEncryptedVolumeUsingKey(func(disk disks.Disk, name string, key []byte) (string, error) {
+		return "path-to-save-device", nil
+	})
+	defer restore()
 
-	// install and recover mounts are just ubuntu-seed before we fail
-	var restore func()
-	if mode == "run" {
-		// run mode will mount ubuntu-boot and ubuntu-seed
-		restore = s.mockSystemdMountSequence(c, []systemdMount{
-			ubuntuLabelMount("ubuntu-boot", mode),
-			ubuntuPartUUIDMount("ubuntu-seed-partuuid", mode),
-		}, nil)
-		restore2 := disks.MockMountPointDisksToPartitionMapping(
-			map[disks.Mountpoint]*disks.MockDiskMapping{
-				{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
-			},
-		)
-		defer restore2()
-	} else {
+	restore = main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		return fmt.Errorf("lock fail")
+	})
+	defer restore()
+
+	restore = main.MockSecbootMeasureSnapSystemEpochWhenPossible(func() error { return nil })
+	defer restore()
+	restore = main.MockSecbootMeasureSnapModelWhenPossible(func(findModel func() (*asserts.Model, error)) error {
+		return nil
+	})
+	defer restore()
+
+	// mock a bootloader
+	bloader := boottest.MockUC20RunBootenv(bootloadertest.Mock("mock", c.MkDir()))
+	bootloader.Force(bloader)
+	defer bootloader.Force(nil)
+
+	// set the current kernel
+	restore = bloader.SetEnabledKernel(s.kernel)
+	defer restore()
+
+	makeSnapFilesOnEarlyBootUbuntuData(c, s.kernel, s.core20)
+
+	// write modeenv
+	modeEnv := boot.Modeenv{
+		Mode:           "run",
+		Base:           s.core20.Filename(),
+		CurrentKernels: []string{s.kernel.Filename()},
+	}
+	err := modeEnv.WriteTo(boot.InitramfsWritableDir)
+	c.Assert(err, IsNil)
+
+	// the boot does not proceed with the sealed keys unlocked
+	_, err = main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, ErrorMatches, "cannot lock access to sealed keys: lock fail")
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRunModeEncryptedNoModel(c *C) {
+	s.testInitramfsMountsEncryptedNoModel(c, "run", "", 1)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsInstallModeEncryptedNoModel(c *C) {
+	s.testInitramfsMountsEncryptedNoModel(c, "install", s.sysLabel, 0)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeEncryptedNoModel(c *C) {
+	s.testInitramfsMountsEncryptedNoModel(c, "recover", s.sysLabel, 0)
+}
+
+func (s *initramfsMountsSuite) testInitramfsMountsEncryptedNoModel(c *C, mode, label string, expectedMeasureModelCalls int) {
+	s.mockProcCmdlineContent(c, fmt.Sprintf("snapd_recovery_mode=%s", mode))
+
+	// install and recover mounts are just ubuntu-seed before we fail
+	var restore func()
+	if mode == "run" {
+		// run mode will mount ubuntu-boot and ubuntu-seed
+		restore = s.mockSystemdMountSequence(c, []systemdMount{
+			ubuntuLabelMount("ubuntu-boot", mode),
+			ubuntuPartUUIDMount("ubuntu-seed-partuuid", mode),
+		}, nil)
+		restore2 := disks.MockMountPointDisksToPartitionMapping(
+			map[disks.Mountpoint]*disks.MockDiskMapping{
+				{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
+			},
+		)
+		defer restore2()
+	} else {
 		restore = s.mockSystemdMountSequence(c, []systemdMount{
 			ubuntuLabelMount("ubuntu-seed", mode),
 		}, nil)
@@ -2275,6 +2691,11 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeHappy(c *C) {
 	s.testRecoverModeHappy(c)
 }
 
+func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeHappyConcurrently(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+	s.TestInitramfsMountsRecoverModeHappy(c)
+}
+
 func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeGadgetDefaultsHappy(c *C) {
 	// setup a seed with default gadget yaml
 	const gadgetYamlDefaults = `
@@ -2454,10 +2875,9 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeHappyEncrypted(c *C
 		encDevPartUUID, err := disk.FindMatchingPartitionUUID(name + "-enc")
 		c.Assert(err, IsNil)
 		c.Assert(encDevPartUUID, Equals, "ubuntu-data-enc-partuuid")
-		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{
-			LockKeysOnFinish: true,
-			AllowRecoveryKey: true,
-		})
+		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{})
+		// access to the sealed keys is locked later
+		c.Check(secboot.TPMSealedKeysLockArmed(), Equals, true)
 		dataActivated = true
 		return secboot.UnlockResult{
 			Device:            filepath.Join("/dev/disk/by-partuuid", encDevPartUUID),
@@ -2540,6 +2960,106 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeHappyEncrypted(c *C
 	c.Assert(filepath.Join(dirs.SnapBootstrapRunDir, fmt.Sprintf("%s-model-measured", s.sysLabel)), testutil.FilePresent)
 }
 
+func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeEncryptedFallbackKeyHappy(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=recover snapd_recovery_system="+s.sysLabel)
+
+	restore := main.MockPartitionUUIDForBootedKernelDisk("")
+	defer restore()
+
+	// setup a bootloader for setting the bootenv after we are done
+	bloader := bootloadertest.Mock("mock", c.MkDir())
+	bootloader.Force(bloader)
+	defer bootloader.Force(nil)
+
+	restore = disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuSeedDir}: defaultEncBootDisk,
+			{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
+			{
+				Mountpoint:        boot.InitramfsHostUbuntuDataDir,
+				IsDecryptedDevice: true,
+			}: defaultEncBootDisk,
+			{
+				Mountpoint:        boot.InitramfsUbuntuSaveDir,
+				IsDecryptedDevice: true,
+			}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	var keyFiles []string
+	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
+		c.Assert(name, Equals, "ubuntu-data")
+		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{})
+		keyFiles = append(keyFiles, encryptionKeyFile)
+		if encryptionKeyFile == filepath.Join(s.tmpDir, "run/mnt/ubuntu-boot/device/fde/ubuntu-data.sealed-key") {
+			// the run mode key cannot be unsealed anymore
+			return secboot.UnlockResult{IsDecryptedDevice: true}, fmt.Errorf("cannot unseal run key")
+		}
+		return secboot.UnlockResult{
+			Device:            "/dev/disk/by-partuuid/ubuntu-data-enc-partuuid",
+			IsDecryptedDevice: true,
+			UnlockMethod:      secboot.UnlockedWithSealedKey,
+		}, nil
+	})
+	defer restore()
+
+	restore = main.MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		c.Fatalf("unexpected use of the recovery key")
+		return secboot.UnlockResult{}, nil
+	})
+	defer restore()
+
+	s.mockUbuntuSaveKey(c, boot.InitramfsHostWritableDir, "foo")
+
+	restore = main.MockSecbootUnlockEncryptedVolumeUsingKey(func(disk disks.Disk, name string, key []byte) (string, error) {
+		c.Assert(name, Equals, "ubuntu-save")
+		c.Assert(key, DeepEquals, []byte("foo"))
+		return "/dev/disk/by-partuuid/ubuntu-save-enc-partuuid", nil
+	})
+	defer restore()
+
+	restore = main.MockSecbootMeasureSnapSystemEpochWhenPossible(func() error { return nil })
+	defer restore()
+	restore = main.MockSecbootMeasureSnapModelWhenPossible(func(findModel func() (*asserts.Model, error)) error { return nil })
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-seed", "recover"),
+		s.makeSeedSnapSystemdMount(snap.TypeSnapd),
+		s.makeSeedSnapSystemdMount(snap.TypeKernel),
+		s.makeSeedSnapSystemdMount(snap.TypeBase),
+		{
+			"tmpfs",
+			boot.InitramfsDataDir,
+			tmpfsMountOpts,
+		},
+		{
+			"/dev/disk/by-partuuid/ubuntu-boot-partuuid",
+			boot.InitramfsUbuntuBootDir,
+			needsFsckDiskMountOpts,
+		},
+		{
+			"/dev/disk/by-partuuid/ubuntu-data-enc-partuuid",
+			boot.InitramfsHostUbuntuDataDir,
+			nil,
+		},
+		{
+			"/dev/disk/by-partuuid/ubuntu-save-enc-partuuid",
+			boot.InitramfsUbuntuSaveDir,
+			nil,
+		},
+	}, nil)
+	defer restore()
+
+	s.testRecoverModeHappy(c)
+
+	c.Check(keyFiles, DeepEquals, []string{
+		filepath.Join(s.tmpDir, "run/mnt/ubuntu-boot/device/fde/ubuntu-data.sealed-key"),
+		filepath.Join(s.tmpDir, "run/mnt/ubuntu-seed/device/fde/ubuntu-data.recovery.sealed-key"),
+	})
+}
+
 func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeEncryptedAttackerFSAttachedHappy(c *C) {
 	s.mockProcCmdlineContent(c, "snapd_recovery_mode=recover snapd_recovery_system="+s.sysLabel)
 
@@ -2596,10 +3116,9 @@ func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeEncryptedAttackerFS
 		encDevPartUUID, err := disk.FindMatchingPartitionUUID(name + "-enc")
 		c.Assert(err, IsNil)
 		c.Assert(encDevPartUUID, Equals, "ubuntu-data-enc-partuuid")
-		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{
-			LockKeysOnFinish: true,
-			AllowRecoveryKey: true,
-		})
+		c.Assert(opts, DeepEquals, &secboot.UnlockVolumeUsingSealedKeyOptions{})
+		// access to the sealed keys is locked later
+		c.Check(secboot.TPMSealedKeysLockArmed(), Equals, true)
 		activated = true
 		return secboot.UnlockResult{
 			Device:            filepath.Join("/dev/disk/by-partuuid", encDevPartUUID),
@@ -2802,3 +3321,273 @@ func (s *initramfsMountsSuite) TestInitramfsMountsInstallModeUnsetMeasure(c *C)
 func (s *initramfsMountsSuite) TestInitramfsMountsRecoverModeMeasure(c *C) {
 	s.testInitramfsMountsInstallRecoverModeMeasure(c, "recover")
 }
+
+var usbRecoveryDisk = &disks.MockDiskMapping{
+	FilesystemLabelToPartUUID: map[string]string{
+		"ubuntu-seed": "usb-ubuntu-seed-partuuid",
+	},
+	DiskHasPartitions: true,
+	DevNum:            "usbDev",
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRepairModeHappyEncrypted(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=repair snapd_recovery_system="+s.sysLabel)
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuSeedDir}: usbRecoveryDisk,
+			{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
+			{
+				Mountpoint:        boot.InitramfsHostUbuntuDataDir,
+				IsDecryptedDevice: true,
+			}: defaultEncBootDisk,
+			{
+				Mountpoint:        boot.InitramfsUbuntuSaveDir,
+				IsDecryptedDevice: true,
+			}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	restore = main.MockSecbootUnlockVolumeUsingSealedKeyIfEncrypted(func(disk disks.Disk, name string, encryptionKeyFile string, opts *secboot.UnlockVolumeUsingSealedKeyOptions) (secboot.UnlockResult, error) {
+		c.Fatalf("unexpected use of the sealed key")
+		return secboot.UnlockResult{}, nil
+	})
+	defer restore()
+
+	dataActivated := false
+	restore = main.MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		c.Assert(name, Equals, "ubuntu-data")
+		c.Assert(disk.Dev(), Equals, "defaultEncDev")
+		dataActivated = true
+		return secboot.UnlockResult{
+			Device:            "/dev/disk/by-partuuid/ubuntu-data-enc-partuuid",
+			IsDecryptedDevice: true,
+			UnlockMethod:      secboot.UnlockedWithRecoveryKey,
+		}, nil
+	})
+	defer restore()
+
+	s.mockUbuntuSaveKey(c, boot.InitramfsHostWritableDir, "foo")
+	restore = main.MockSecbootUnlockEncryptedVolumeUsingKey(func(disk disks.Disk, name string, key []byte) (string, error) {
+		c.Check(dataActivated, Equals, true, Commentf("ubuntu-data not activated yet"))
+		c.Assert(name, Equals, "ubuntu-save")
+		c.Assert(key, DeepEquals, []byte("foo"))
+		return "/dev/disk/by-partuuid/ubuntu-save-enc-partuuid", nil
+	})
+	defer restore()
+
+	restoreAssetsCalls := 0
+	restore = main.MockBootRestoreTrustedBootAssets(func(rootdir string) ([]string, error) {
+		restoreAssetsCalls++
+		c.Check(rootdir, Equals, boot.InitramfsHostWritableDir)
+		return []string{"EFI/boot/grubx64.efi"}, nil
+	})
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-seed", "repair"),
+		s.makeSeedSnapSystemdMount(snap.TypeSnapd),
+		s.makeSeedSnapSystemdMount(snap.TypeKernel),
+		s.makeSeedSnapSystemdMount(snap.TypeBase),
+		{
+			"tmpfs",
+			boot.InitramfsDataDir,
+			tmpfsMountOpts,
+		},
+		ubuntuLabelMount("ubuntu-boot", "repair"),
+		{
+			"/dev/disk/by-partuuid/ubuntu-data-enc-partuuid",
+			boot.InitramfsHostUbuntuDataDir,
+			nil,
+		},
+		{
+			"/dev/disk/by-partuuid/ubuntu-save-enc-partuuid",
+			boot.InitramfsUbuntuSaveDir,
+			nil,
+		},
+	}, nil)
+	defer restore()
+
+	// mock auth data in the host's ubuntu-data
+	hostUbuntuData := filepath.Join(boot.InitramfsRunMntDir, "host/ubuntu-data/")
+	authKeys := filepath.Join(hostUbuntuData, "user-data/user1/.ssh/authorized_keys")
+	c.Assert(os.MkdirAll(filepath.Dir(authKeys), 0750), IsNil)
+	c.Assert(ioutil.WriteFile(authKeys, []byte("keys"), 0640), IsNil)
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, IsNil)
+	c.Check(restoreAssetsCalls, Equals, 1)
+
+	ephemeralUbuntuData := filepath.Join(boot.InitramfsRunMntDir, "data/")
+	c.Check(filepath.Join(ephemeralUbuntuData, "user-data/user1/.ssh/authorized_keys"), testutil.FileEquals, "keys")
+	c.Check(filepath.Join(ephemeralUbuntuData, "system-data/var/lib/snapd/modeenv"), testutil.FileEquals, `mode=repair
+recovery_system=20191118
+`)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsFactoryResetModeHappyEncrypted(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=factory-reset snapd_recovery_system="+s.sysLabel)
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuSeedDir}: defaultEncBootDisk,
+			{
+				Mountpoint:        boot.InitramfsUbuntuSaveDir,
+				IsDecryptedDevice: true,
+			}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	saveSealedKey := filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "ubuntu-save.recovery.sealed-key")
+	c.Assert(os.MkdirAll(filepath.Dir(saveSealedKey), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(saveSealedKey, nil, 0600), IsNil)
+	restore = main.MockSecbootUnsealKey(func(keyFile string) ([]byte, error) {
+		c.Check(keyFile, Equals, saveSealedKey)
+		return []byte("foo"), nil
+	})
+	defer restore()
+
+	restore = main.MockSecbootUnlockEncryptedVolumeUsingKey(func(disk disks.Disk, name string, key []byte) (string, error) {
+		c.Assert(name, Equals, "ubuntu-save")
+		c.Assert(disk.Dev(), Equals, "defaultEncDev")
+		c.Assert(key, DeepEquals, []byte("foo"))
+		return "/dev/disk/by-partuuid/ubuntu-save-enc-partuuid", nil
+	})
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-seed", "factory-reset"),
+		s.makeSeedSnapSystemdMount(snap.TypeSnapd),
+		s.makeSeedSnapSystemdMount(snap.TypeKernel),
+		s.makeSeedSnapSystemdMount(snap.TypeBase),
+		{
+			"tmpfs",
+			boot.InitramfsDataDir,
+			tmpfsMountOpts,
+		},
+		{
+			"/dev/disk/by-partuuid/ubuntu-save-enc-partuuid",
+			boot.InitramfsUbuntuSaveDir,
+			nil,
+		},
+	}, nil)
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, IsNil)
+
+	// the key of ubuntu-save is handed over to snapd
+	c.Check(filepath.Join(dirs.SnapFDEDirUnder(boot.InitramfsWritableDir), "ubuntu-save.key"), testutil.FileEquals, "foo")
+	c.Check(dirs.SnapModeenvFileUnder(boot.InitramfsWritableDir), testutil.FileEquals, `mode=factory-reset
+recovery_system=20191118
+`)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsFactoryResetModeUnhappyNoSave(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=factory-reset snapd_recovery_system="+s.sysLabel)
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuSeedDir}: defaultBootDisk,
+		},
+	)
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-seed", "factory-reset"),
+		s.makeSeedSnapSystemdMount(snap.TypeSnapd),
+		s.makeSeedSnapSystemdMount(snap.TypeKernel),
+		s.makeSeedSnapSystemdMount(snap.TypeBase),
+		{
+			"tmpfs",
+			boot.InitramfsDataDir,
+			tmpfsMountOpts,
+		},
+	}, nil)
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, ErrorMatches, `cannot factory reset without ubuntu-save: filesystem label "ubuntu-save" not found`)
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRepairModeBootedFromInternalDisk(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=repair snapd_recovery_system="+s.sysLabel)
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuSeedDir}: defaultEncBootDisk,
+			{Mountpoint: boot.InitramfsUbuntuBootDir}: defaultEncBootDisk,
+		},
+	)
+	defer restore()
+
+	restore = main.MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		c.Fatalf("unexpected unlock")
+		return secboot.UnlockResult{}, nil
+	})
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-seed", "repair"),
+		s.makeSeedSnapSystemdMount(snap.TypeSnapd),
+		s.makeSeedSnapSystemdMount(snap.TypeKernel),
+		s.makeSeedSnapSystemdMount(snap.TypeBase),
+		{
+			"tmpfs",
+			boot.InitramfsDataDir,
+			tmpfsMountOpts,
+		},
+		ubuntuLabelMount("ubuntu-boot", "repair"),
+	}, nil)
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, ErrorMatches, "cannot repair the system installed on disk defaultEncDev when booting from it, use recover mode instead")
+}
+
+func (s *initramfsMountsSuite) TestInitramfsMountsRepairModeRestoreAssetsError(c *C) {
+	s.mockProcCmdlineContent(c, "snapd_recovery_mode=repair snapd_recovery_system="+s.sysLabel)
+
+	restore := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: boot.InitramfsUbuntuSeedDir}:     usbRecoveryDisk,
+			{Mountpoint: boot.InitramfsUbuntuBootDir}:     defaultBootDisk,
+			{Mountpoint: boot.InitramfsHostUbuntuDataDir}: defaultBootDisk,
+		},
+	)
+	defer restore()
+
+	restore = main.MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error) {
+		return secboot.UnlockResult{Device: "/dev/disk/by-partuuid/ubuntu-data-partuuid"}, nil
+	})
+	defer restore()
+
+	restore = main.MockBootRestoreTrustedBootAssets(func(rootdir string) ([]string, error) {
+		return nil, fmt.Errorf("no expected asset found in cache")
+	})
+	defer restore()
+
+	restore = s.mockSystemdMountSequence(c, []systemdMount{
+		ubuntuLabelMount("ubuntu-seed", "repair"),
+		s.makeSeedSnapSystemdMount(snap.TypeSnapd),
+		s.makeSeedSnapSystemdMount(snap.TypeKernel),
+		s.makeSeedSnapSystemdMount(snap.TypeBase),
+		{
+			"tmpfs",
+			boot.InitramfsDataDir,
+			tmpfsMountOpts,
+		},
+		ubuntuLabelMount("ubuntu-boot", "repair"),
+		{
+			"/dev/disk/by-partuuid/ubuntu-data-partuuid",
+			boot.InitramfsHostUbuntuDataDir,
+			nil,
+		},
+	}, nil)
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"initramfs-mounts"})
+	c.Assert(err, ErrorMatches, "cannot restore boot assets: no expected asset found in cache")
+}
@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/systemd"
+)
+
+func init() {
+	const (
+		short = "Lock access to the TPM sealed keys"
+		long  = "Lock access to the TPM sealed keys if the lock was armed while unlocking volumes in the initramfs"
+	)
+
+	addCommandBuilder(func(parser *flags.Parser) {
+		if _, err := parser.AddCommand("lock-tpm-sealed-keys", short, long, &cmdLockTPMSealedKeys{}); err != nil {
+			panic(err)
+		}
+	})
+}
+
+var (
+	secbootLockTPMSealedKeysIfArmed func() error
+
+	systemdSdNotify = systemd.SdNotify
+)
+
+type cmdLockTPMSealedKeys struct{}
+
+func (c *cmdLockTPMSealedKeys) Execute(args []string) error {
+	if err := secbootLockTPMSealedKeysIfArmed(); err != nil {
+		return err
+	}
+	// let the units ordered after us know that the keys are locked now
+	if err := systemdSdNotify("READY=1"); err != nil {
+		logger.Noticef("cannot notify systemd: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	main "github.com/snapcore/snapd/cmd/snap-bootstrap"
+)
+
+func (s *cmdSuite) TestLockTPMSealedKeys(c *C) {
+	locks := 0
+	restore := main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		locks++
+		return nil
+	})
+	defer restore()
+	var notified []string
+	restore = main.MockSystemdSdNotify(func(state string) error {
+		notified = append(notified, state)
+		return nil
+	})
+	defer restore()
+
+	rest, err := main.Parser().ParseArgs([]string{"lock-tpm-sealed-keys"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, HasLen, 0)
+	c.Check(locks, Equals, 1)
+	c.Check(notified, DeepEquals, []string{"READY=1"})
+}
+
+func (s *cmdSuite) TestLockTPMSealedKeysNotifyError(c *C) {
+	restore := main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		return nil
+	})
+	defer restore()
+	restore = main.MockSystemdSdNotify(func(state string) error {
+		return errors.New("cannot find NOTIFY_SOCKET environment")
+	})
+	defer restore()
+
+	// not being run by systemd is not an error
+	_, err := main.Parser().ParseArgs([]string{"lock-tpm-sealed-keys"})
+	c.Assert(err, IsNil)
+}
+
+func (s *cmdSuite) TestLockTPMSealedKeysError(c *C) {
+	restore := main.MockSecbootLockTPMSealedKeysIfArmed(func() error {
+		return errors.New("cannot lock TPM: boom")
+	})
+	defer restore()
+	restore = main.MockSystemdSdNotify(func(state string) error {
+		c.Fatalf("unexpected notification")
+		return nil
+	})
+	defer restore()
+
+	_, err := main.Parser().ParseArgs([]string{"lock-tpm-sealed-keys"})
+	c.Assert(err, ErrorMatches, "cannot lock TPM: boom")
+}
@@ -0,0 +1,269 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package degraded implements the state machine used in the initramfs to
+// unlock the encrypted volumes of a system when the regular way of
+// unlocking them may fail, for example in recover mode after the boot chain
+// changed.
+//
+// Each volume goes through the following states, skipping the ones it has
+// no key for, until it is unlocked or all of them failed:
+//
+//	run-key -> fallback-key -> recovery-key -> failed
+//
+// In the run-key state the volume is unlocked with the key it is normally
+// unlocked with in run mode, either its sealed run key or a plain key (like
+// the key of ubuntu-save stored on ubuntu-data). In the fallback-key state
+// the key sealed for the recovery systems on ubuntu-seed is used and in the
+// recovery-key state the user is prompted for the recovery key.
+//
+// Sealed keys are unlocked the same way whichever key protector sealed
+// them, the TPM or another one like OP-TEE, the method that was eventually
+// used is recorded in the attempts of the volume.
+//
+// The unlocking itself is done by a Backend, which is implemented with
+// secboot in the initramfs and can be mocked in tests.
+//
+// The same state machine is used by all the modes of the initramfs which
+// unlock volumes, each describing the keys it allows. Run mode for instance
+// does not use the fallback keys and repair mode only uses the recovery key.
+package degraded
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/snapcore/snapd/osutil/disks"
+	"github.com/snapcore/snapd/secboot"
+)
+
+// State is a state of the unlock state machine of a volume.
+type State string
+
+const (
+	// StateRunKey is the state where the run mode key of the volume is
+	// tried.
+	StateRunKey State = "run-key"
+	// StateFallbackKey is the state where the key sealed for the recovery
+	// systems is tried.
+	StateFallbackKey State = "fallback-key"
+	// StateRecoveryKey is the state where the user is prompted for the
+	// recovery key.
+	StateRecoveryKey State = "recovery-key"
+	// StateUnlocked is the final state of a volume that was unlocked, or
+	// found not to be encrypted.
+	StateUnlocked State = "unlocked"
+	// StateFailed is the final state of a volume that could not be
+	// unlocked.
+	StateFailed State = "failed"
+)
+
+// Backend unlocks volumes on behalf of the state machine.
+type Backend interface {
+	// UnlockVolumeUsingSealedKey unlocks the volume with the given sealed
+	// key if it is encrypted, whichever key protector sealed the key. It
+	// must not fall back to the recovery key.
+	UnlockVolumeUsingSealedKey(disk disks.Disk, name, sealedKeyFile string) (secboot.UnlockResult, error)
+	// UnlockEncryptedVolumeUsingKey unlocks the encrypted volume with the
+	// given plain key and returns the decrypted device.
+	UnlockEncryptedVolumeUsingKey(disk disks.Disk, name string, key []byte) (string, error)
+	// UnlockVolumeUsingRecoveryKey unlocks the volume, if encrypted, with
+	// the recovery key the user is prompted for.
+	UnlockVolumeUsingRecoveryKey(disk disks.Disk, name string) (secboot.UnlockResult, error)
+}
+
+// Volume describes a volume to unlock and the keys that can be used for it.
+type Volume struct {
+	// Name is the name of the volume, like ubuntu-data.
+	Name string
+	// RunKeyFile is the key of the volume sealed for run mode.
+	RunKeyFile string
+	// KeyFile is a plain key of the volume, it is tried instead of
+	// RunKeyFile when set. A volume with a plain key is expected to be
+	// encrypted.
+	KeyFile string
+	// FallbackKeyFile is the key of the volume sealed for the recovery
+	// systems.
+	FallbackKeyFile string
+	// AllowRecoveryKey indicates whether the user can be prompted for the
+	// recovery key once the other keys failed.
+	AllowRecoveryKey bool
+}
+
+// next returns the state following the given one for the volume, skipping
+// the states the volume has no key for. The initial state is the empty
+// state.
+func (v *Volume) next(state State) State {
+	switch state {
+	case "":
+		if v.RunKeyFile != "" || v.KeyFile != "" {
+			return StateRunKey
+		}
+		fallthrough
+	case StateRunKey:
+		if v.FallbackKeyFile != "" {
+			return StateFallbackKey
+		}
+		fallthrough
+	case StateFallbackKey:
+		if v.AllowRecoveryKey {
+			return StateRecoveryKey
+		}
+	}
+	return StateFailed
+}
+
+// Attempt records an attempt at unlocking a volume.
+type Attempt struct {
+	// State is the state the attempt was made in.
+	State State
+	// KeyFile is the key file that was used, if any.
+	KeyFile string
+	// Method is the method that unlocked the volume, when successful.
+	Method secboot.UnlockMethod
+	// Err is the error of a failed attempt.
+	Err error
+}
+
+// VolumeResult is the outcome of unlocking a volume.
+type VolumeResult struct {
+	// Name is the name of the volume.
+	Name string
+	// State is either StateUnlocked or StateFailed.
+	State State
+	// Result is the result of the attempt that unlocked the volume.
+	Result secboot.UnlockResult
+	// Attempts are the attempts made, in order.
+	Attempts []Attempt
+	// Err is the error of the last attempt when the volume could not be
+	// unlocked.
+	Err error
+}
+
+// Degraded returns whether the volume could not be unlocked with its run
+// mode key.
+func (r *VolumeResult) Degraded() bool {
+	if r.State != StateUnlocked {
+		return true
+	}
+	return r.Attempts[len(r.Attempts)-1].State != StateRunKey
+}
+
+// Machine unlocks the volumes of a disk and keeps track of their outcome. It
+// is not safe for concurrent use, the volumes are unlocked one after the
+// other.
+type Machine struct {
+	disk    disks.Disk
+	backend Backend
+	results []*VolumeResult
+}
+
+// New returns a state machine unlocking volumes of the given disk with the
+// given backend.
+func New(disk disks.Disk, backend Backend) *Machine {
+	return &Machine{
+		disk:    disk,
+		backend: backend,
+	}
+}
+
+var errNoKeys = errors.New("no keys to unlock the volume with")
+
+// Unlock runs the state machine for the given volume until it is either
+// unlocked or all the ways of unlocking it failed.
+func (m *Machine) Unlock(vol *Volume) *VolumeResult {
+	res := &VolumeResult{Name: vol.Name}
+	state := vol.next("")
+	if state == StateFailed {
+		res.Err = fmt.Errorf("cannot unlock %s: %v", vol.Name, errNoKeys)
+	}
+	for state != StateFailed {
+		attempt := Attempt{State: state}
+		unlockRes, err := m.try(vol, &attempt)
+		attempt.Err = err
+		if err == nil {
+			attempt.Method = unlockRes.UnlockMethod
+		}
+		res.Attempts = append(res.Attempts, attempt)
+		if err == nil {
+			res.Result = unlockRes
+			state = StateUnlocked
+			break
+		}
+		res.Err = fmt.Errorf("cannot unlock %s with %s: %v", vol.Name, state, err)
+		state = vol.next(state)
+	}
+	res.State = state
+	if state == StateUnlocked {
+		res.Err = nil
+	}
+	m.results = append(m.results, res)
+	return res
+}
+
+func (m *Machine) try(vol *Volume, attempt *Attempt) (secboot.UnlockResult, error) {
+	switch attempt.State {
+	case StateRunKey:
+		if vol.KeyFile != "" {
+			attempt.KeyFile = vol.KeyFile
+			return m.unlockWithKeyFile(vol)
+		}
+		attempt.KeyFile = vol.RunKeyFile
+		return m.backend.UnlockVolumeUsingSealedKey(m.disk, vol.Name, vol.RunKeyFile)
+	case StateFallbackKey:
+		attempt.KeyFile = vol.FallbackKeyFile
+		return m.backend.UnlockVolumeUsingSealedKey(m.disk, vol.Name, vol.FallbackKeyFile)
+	case StateRecoveryKey:
+		return m.backend.UnlockVolumeUsingRecoveryKey(m.disk, vol.Name)
+	}
+	return secboot.UnlockResult{}, fmt.Errorf("internal error: unexpected state %q", attempt.State)
+}
+
+func (m *Machine) unlockWithKeyFile(vol *Volume) (secboot.UnlockResult, error) {
+	key, err := ioutil.ReadFile(vol.KeyFile)
+	if err != nil {
+		return secboot.UnlockResult{}, fmt.Errorf("cannot read key: %v", err)
+	}
+	device, err := m.backend.UnlockEncryptedVolumeUsingKey(m.disk, vol.Name, key)
+	if err != nil {
+		return secboot.UnlockResult{}, err
+	}
+	return secboot.UnlockResult{
+		Device:            device,
+		IsDecryptedDevice: true,
+		UnlockMethod:      secboot.UnlockedWithKey,
+	}, nil
+}
+
+// Results returns the outcome of all the volumes unlocked so far, in order.
+func (m *Machine) Results() []*VolumeResult {
+	return m.results
+}
+
+// Degraded returns whether any of the volumes unlocked so far could not be
+// unlocked with its run mode key.
+func (m *Machine) Degraded() bool {
+	for _, res := range m.results {
+		if res.Degraded() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package degraded_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/cmd/snap-bootstrap/degraded"
+	"github.com/snapcore/snapd/osutil/disks"
+	"github.com/snapcore/snapd/secboot"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type degradedSuite struct {
+	disk disks.Disk
+}
+
+var _ = Suite(&degradedSuite{})
+
+func (s *degradedSuite) SetUpTest(c *C) {
+	s.disk = &disks.MockDiskMapping{}
+}
+
+type mockBackend struct {
+	calls []string
+
+	sealedKeyErrs map[string]error
+	sealedKeyRes  map[string]secboot.UnlockResult
+	keyErr        error
+	recoveryErr   error
+}
+
+func (b *mockBackend) UnlockVolumeUsingSealedKey(disk disks.Disk, name, sealedKeyFile string) (secboot.UnlockResult, error) {
+	b.calls = append(b.calls, fmt.Sprintf("sealed-key:%s:%s", name, filepath.Base(sealedKeyFile)))
+	if err := b.sealedKeyErrs[sealedKeyFile]; err != nil {
+		return secboot.UnlockResult{IsDecryptedDevice: true}, err
+	}
+	if res, ok := b.sealedKeyRes[sealedKeyFile]; ok {
+		return res, nil
+	}
+	return secboot.UnlockResult{
+		Device:            "/dev/mapper/" + name,
+		IsDecryptedDevice: true,
+		UnlockMethod:      secboot.UnlockedWithSealedKey,
+	}, nil
+}
+
+func (b *mockBackend) UnlockEncryptedVolumeUsingKey(disk disks.Disk, name string, key []byte) (string, error) {
+	b.calls = append(b.calls, fmt.Sprintf("key:%s:%s", name, key))
+	if b.keyErr != nil {
+		return "", b.keyErr
+	}
+	return "/dev/mapper/" + name, nil
+}
+
+func (b *mockBackend) UnlockVolumeUsingRecoveryKey(disk disks.Disk, name string) (secboot.UnlockResult, error) {
+	b.calls = append(b.calls, "recovery-key:"+name)
+	if b.recoveryErr != nil {
+		return secboot.UnlockResult{}, b.recoveryErr
+	}
+	return secboot.UnlockResult{
+		Device:            "/dev/mapper/" + name,
+		IsDecryptedDevice: true,
+		UnlockMethod:      secboot.UnlockedWithRecoveryKey,
+	}, nil
+}
+
+func (s *degradedSuite) TestUnlockRunKeyHappy(c *C) {
+	b := &mockBackend{}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:             "ubuntu-data",
+		RunKeyFile:       "/boot/ubuntu-data.sealed-key",
+		FallbackKeyFile:  "/seed/ubuntu-data.recovery.sealed-key",
+		AllowRecoveryKey: true,
+	})
+	c.Check(res.State, Equals, degraded.StateUnlocked)
+	c.Check(res.Err, IsNil)
+	c.Check(res.Result.Device, Equals, "/dev/mapper/ubuntu-data")
+	c.Check(res.Attempts, DeepEquals, []degraded.Attempt{
+		{State: degraded.StateRunKey, KeyFile: "/boot/ubuntu-data.sealed-key", Method: secboot.UnlockedWithSealedKey},
+	})
+	c.Check(res.Degraded(), Equals, false)
+	c.Check(m.Degraded(), Equals, false)
+	c.Check(b.calls, DeepEquals, []string{"sealed-key:ubuntu-data:ubuntu-data.sealed-key"})
+}
+
+func (s *degradedSuite) TestUnlockNotEncrypted(c *C) {
+	b := &mockBackend{
+		sealedKeyRes: map[string]secboot.UnlockResult{
+			"/boot/ubuntu-data.sealed-key": {Device: "/dev/disk/by-partuuid/data-partuuid"},
+		},
+	}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:            "ubuntu-data",
+		RunKeyFile:      "/boot/ubuntu-data.sealed-key",
+		FallbackKeyFile: "/seed/ubuntu-data.recovery.sealed-key",
+	})
+	c.Check(res.State, Equals, degraded.StateUnlocked)
+	c.Check(res.Result, DeepEquals, secboot.UnlockResult{Device: "/dev/disk/by-partuuid/data-partuuid"})
+	c.Check(res.Degraded(), Equals, false)
+}
+
+func (s *degradedSuite) TestUnlockFallbackKeyWithHook(c *C) {
+	b := &mockBackend{
+		sealedKeyErrs: map[string]error{
+			"/boot/ubuntu-data.sealed-key": errors.New("run key error"),
+		},
+		sealedKeyRes: map[string]secboot.UnlockResult{
+			"/seed/ubuntu-data.recovery.sealed-key": {
+				Device:            "/dev/mapper/ubuntu-data",
+				IsDecryptedDevice: true,
+				UnlockMethod:      secboot.UnlockedWithKeyProtector,
+			},
+		},
+	}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:             "ubuntu-data",
+		RunKeyFile:       "/boot/ubuntu-data.sealed-key",
+		FallbackKeyFile:  "/seed/ubuntu-data.recovery.sealed-key",
+		AllowRecoveryKey: true,
+	})
+	c.Check(res.State, Equals, degraded.StateUnlocked)
+	c.Check(res.Err, IsNil)
+	c.Check(res.Result.UnlockMethod, Equals, secboot.UnlockedWithKeyProtector)
+	c.Check(res.Attempts, HasLen, 2)
+	c.Check(res.Attempts[0].State, Equals, degraded.StateRunKey)
+	c.Check(res.Attempts[0].Err, ErrorMatches, "run key error")
+	c.Check(res.Attempts[1], DeepEquals, degraded.Attempt{
+		State:   degraded.StateFallbackKey,
+		KeyFile: "/seed/ubuntu-data.recovery.sealed-key",
+		Method:  secboot.UnlockedWithKeyProtector,
+	})
+	c.Check(res.Degraded(), Equals, true)
+	c.Check(m.Degraded(), Equals, true)
+	c.Check(b.calls, DeepEquals, []string{
+		"sealed-key:ubuntu-data:ubuntu-data.sealed-key",
+		"sealed-key:ubuntu-data:ubuntu-data.recovery.sealed-key",
+	})
+}
+
+func (s *degradedSuite) TestUnlockRecoveryKey(c *C) {
+	b := &mockBackend{
+		sealedKeyErrs: map[string]error{
+			"/boot/ubuntu-data.sealed-key":          errors.New("run key error"),
+			"/seed/ubuntu-data.recovery.sealed-key": errors.New("fallback key error"),
+		},
+	}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:             "ubuntu-data",
+		RunKeyFile:       "/boot/ubuntu-data.sealed-key",
+		FallbackKeyFile:  "/seed/ubuntu-data.recovery.sealed-key",
+		AllowRecoveryKey: true,
+	})
+	c.Check(res.State, Equals, degraded.StateUnlocked)
+	c.Check(res.Err, IsNil)
+	c.Check(res.Result.UnlockMethod, Equals, secboot.UnlockedWithRecoveryKey)
+	c.Check(res.Attempts, HasLen, 3)
+	c.Check(res.Attempts[2].State, Equals, degraded.StateRecoveryKey)
+	c.Check(b.calls, DeepEquals, []string{
+		"sealed-key:ubuntu-data:ubuntu-data.sealed-key",
+		"sealed-key:ubuntu-data:ubuntu-data.recovery.sealed-key",
+		"recovery-key:ubuntu-data",
+	})
+}
+
+func (s *degradedSuite) TestUnlockAllFailed(c *C) {
+	b := &mockBackend{
+		sealedKeyErrs: map[string]error{
+			"/boot/ubuntu-data.sealed-key":          errors.New("run key error"),
+			"/seed/ubuntu-data.recovery.sealed-key": errors.New("fallback key error"),
+		},
+		recoveryErr: errors.New("recovery key error"),
+	}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:             "ubuntu-data",
+		RunKeyFile:       "/boot/ubuntu-data.sealed-key",
+		FallbackKeyFile:  "/seed/ubuntu-data.recovery.sealed-key",
+		AllowRecoveryKey: true,
+	})
+	c.Check(res.State, Equals, degraded.StateFailed)
+	c.Check(res.Err, ErrorMatches, "cannot unlock ubuntu-data with recovery-key: recovery key error")
+	c.Check(res.Attempts, HasLen, 3)
+	c.Check(res.Degraded(), Equals, true)
+}
+
+func (s *degradedSuite) TestUnlockNoRecoveryKeyFailed(c *C) {
+	b := &mockBackend{
+		sealedKeyErrs: map[string]error{
+			"/boot/ubuntu-data.sealed-key": errors.New("run key error"),
+		},
+	}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:       "ubuntu-data",
+		RunKeyFile: "/boot/ubuntu-data.sealed-key",
+	})
+	c.Check(res.State, Equals, degraded.StateFailed)
+	c.Check(res.Err, ErrorMatches, "cannot unlock ubuntu-data with run-key: run key error")
+	c.Check(b.calls, DeepEquals, []string{"sealed-key:ubuntu-data:ubuntu-data.sealed-key"})
+}
+
+func (s *degradedSuite) TestUnlockNoKeys(c *C) {
+	b := &mockBackend{}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{Name: "ubuntu-save"})
+	c.Check(res.State, Equals, degraded.StateFailed)
+	c.Check(res.Err, ErrorMatches, "cannot unlock ubuntu-save: no keys to unlock the volume with")
+	c.Check(res.Attempts, HasLen, 0)
+	c.Check(b.calls, HasLen, 0)
+}
+
+func (s *degradedSuite) TestUnlockPlainKeyThenFallback(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-save.key")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("foo"), 0600), IsNil)
+
+	b := &mockBackend{}
+	m := degraded.New(s.disk, b)
+
+	res := m.Unlock(&degraded.Volume{
+		Name:            "ubuntu-save",
+		KeyFile:         keyFile,
+		FallbackKeyFile: "/seed/ubuntu-save.recovery.sealed-key",
+	})
+	c.Check(res.State, Equals, degraded.StateUnlocked)
+	c.Check(res.Result, DeepEquals, secboot.UnlockResult{
+		Device:            "/dev/mapper/ubuntu-save",
+		IsDecryptedDevice: true,
+		UnlockMethod:      secboot.UnlockedWithKey,
+	})
+	c.Check(res.Degraded(), Equals, false)
+
+	// the plain key is gone, ubuntu-data could not be unlocked
+	b = &mockBackend{}
+	m = degraded.New(s.disk, b)
+	res = m.Unlock(&degraded.Volume{
+		Name:            "ubuntu-save",
+		KeyFile:         filepath.Join(c.MkDir(), "missing.key"),
+		FallbackKeyFile: "/seed/ubuntu-save.recovery.sealed-key",
+	})
+	c.Check(res.State, Equals, degraded.StateUnlocked)
+	c.Check(res.Attempts, HasLen, 2)
+	c.Check(res.Attempts[0].Err, ErrorMatches, "cannot read key: .*no such file or directory")
+	c.Check(res.Attempts[1].State, Equals, degraded.StateFallbackKey)
+	c.Check(res.Degraded(), Equals, true)
+	c.Check(b.calls, DeepEquals, []string{"sealed-key:ubuntu-save:ubuntu-save.recovery.sealed-key"})
+}
+
+func (s *degradedSuite) TestResults(c *C) {
+	b := &mockBackend{
+		sealedKeyErrs: map[string]error{
+			"/boot/ubuntu-data.sealed-key": errors.New("run key error"),
+		},
+	}
+	m := degraded.New(s.disk, b)
+
+	data := m.Unlock(&degraded.Volume{
+		Name:       "ubuntu-data",
+		RunKeyFile: "/boot/ubuntu-data.sealed-key",
+	})
+	save := m.Unlock(&degraded.Volume{
+		Name:       "ubuntu-save",
+		RunKeyFile: "/boot/ubuntu-save.sealed-key",
+	})
+	c.Check(m.Results(), DeepEquals, []*degraded.VolumeResult{data, save})
+	c.Check(data.Degraded(), Equals, true)
+	c.Check(save.Degraded(), Equals, false)
+	c.Check(m.Degraded(), Equals, true)
+}
@@ -94,6 +94,14 @@ func MockSecbootUnlockEncryptedVolumeUsingKey(f func(disk disks.Disk, name strin
 	}
 }
 
+func MockSecbootUnsealKey(f func(keyFile string) ([]byte, error)) (restore func()) {
+	old := secbootUnsealKey
+	secbootUnsealKey = f
+	return func() {
+		secbootUnsealKey = old
+	}
+}
+
 func MockSecbootMeasureSnapSystemEpochWhenPossible(f func() error) (restore func()) {
 	old := secbootMeasureSnapSystemEpochWhenPossible
 	secbootMeasureSnapSystemEpochWhenPossible = f
@@ -110,6 +118,22 @@ func MockSecbootMeasureSnapModelWhenPossible(f func(findModel func() (*asserts.M
 	}
 }
 
+func MockSecbootMeasureSeedVerityWhenPossible(f func(findRootHashes func() (map[string][]byte, error)) error) (restore func()) {
+	old := secbootMeasureSeedVerityWhenPossible
+	secbootMeasureSeedVerityWhenPossible = f
+	return func() {
+		secbootMeasureSeedVerityWhenPossible = old
+	}
+}
+
+func MockSecbootMeasureDataIntegrityWhenPossible(f func() error) (restore func()) {
+	old := secbootMeasureDataIntegrityWhenPossible
+	secbootMeasureDataIntegrityWhenPossible = f
+	return func() {
+		secbootMeasureDataIntegrityWhenPossible = old
+	}
+}
+
 func MockPartitionUUIDForBootedKernelDisk(uuid string) (restore func()) {
 	old := bootFindPartitionUUIDForBootedKernelDisk
 	bootFindPartitionUUIDForBootedKernelDisk = func() (string, error) {
@@ -124,3 +148,55 @@ func MockPartitionUUIDForBootedKernelDisk(uuid string) (restore func()) {
 		bootFindPartitionUUIDForBootedKernelDisk = old
 	}
 }
+
+func MockSecbootUnlockVolumeUsingRecoveryKeyIfEncrypted(f func(disk disks.Disk, name string, location secboot.VolumeLocation) (secboot.UnlockResult, error)) (restore func()) {
+	old := secbootUnlockVolumeUsingRecoveryKeyIfEncrypted
+	secbootUnlockVolumeUsingRecoveryKeyIfEncrypted = f
+	return func() {
+		secbootUnlockVolumeUsingRecoveryKeyIfEncrypted = old
+	}
+}
+
+func MockBootRestoreTrustedBootAssets(f func(rootdir string) ([]string, error)) (restore func()) {
+	old := bootRestoreTrustedBootAssets
+	bootRestoreTrustedBootAssets = f
+	return func() {
+		bootRestoreTrustedBootAssets = old
+	}
+}
+
+func MockSecbootLockTPMSealedKeysIfArmed(f func() error) (restore func()) {
+	old := secbootLockTPMSealedKeysIfArmed
+	secbootLockTPMSealedKeysIfArmed = f
+	return func() {
+		secbootLockTPMSealedKeysIfArmed = old
+	}
+}
+
+func MockSystemdSdNotify(f func(string) error) (restore func()) {
+	old := systemdSdNotify
+	systemdSdNotify = f
+	return func() {
+		systemdSdNotify = old
+	}
+}
+
+func MockMountStepsConcurrently(concurrently bool) (restore func()) {
+	old := mountStepsConcurrently
+	mountStepsConcurrently = concurrently
+	return func() {
+		mountStepsConcurrently = old
+	}
+}
+
+func MountStepsConcurrently() bool {
+	return mountStepsConcurrently
+}
+
+type MountStep = mountStep
+
+func NewMountStep(name string, after []string, do func() error) MountStep {
+	return mountStep{name: name, after: after, do: do}
+}
+
+var RunMountSteps = runMountSteps
@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// mountStepsConcurrently runs the independent steps of the mount
+	// sequence concurrently, otherwise they run one after the other in the
+	// order they are listed in
+	mountStepsConcurrently = true
+)
+
+// mountStep is a step of the initramfs mount sequence, like waiting for a
+// device and mounting it.
+type mountStep struct {
+	// name identifies the step for the steps depending on it.
+	name string
+	// after lists the steps which must be done before this one runs.
+	after []string
+	// do performs the step.
+	do func() error
+}
+
+// errMountStepSkipped is recorded for the steps which did not run because
+// one of the steps they depend on failed.
+var errMountStepSkipped = errors.New("skipped")
+
+// runMountSteps runs the given steps, each one as soon as the steps it
+// depends on are done, so that independent device waits and mounts happen
+// concurrently. The steps can only depend on steps listed before them, which
+// also makes the list a valid sequential order. Steps depending on a failed
+// step are skipped and the error of the first failed step in the list is
+// returned.
+func runMountSteps(steps []mountStep) error {
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if _, ok := index[step.name]; ok {
+			return fmt.Errorf("internal error: duplicated mount step %q", step.name)
+		}
+		for _, dep := range step.after {
+			if _, ok := index[dep]; !ok {
+				return fmt.Errorf("internal error: mount step %q must be listed after %q", step.name, dep)
+			}
+		}
+		index[step.name] = i
+	}
+
+	if !mountStepsConcurrently {
+		for _, step := range steps {
+			if err := step.do(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, len(steps))
+	done := make([]chan struct{}, len(steps))
+	for i := range steps {
+		done[i] = make(chan struct{})
+	}
+	for i := range steps {
+		go func(i int) {
+			defer close(done[i])
+			// errs of the dependencies are set before their done
+			// channel is closed
+			for _, dep := range steps[i].after {
+				j := index[dep]
+				<-done[j]
+				if errs[j] != nil {
+					errs[i] = errMountStepSkipped
+					return
+				}
+			}
+			errs[i] = steps[i].do()
+		}(i)
+	}
+	for i := range steps {
+		<-done[i]
+	}
+	for _, err := range errs {
+		if err != nil && err != errMountStepSkipped {
+			return err
+		}
+	}
+	return nil
+}
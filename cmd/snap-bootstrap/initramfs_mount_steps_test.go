@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	main "github.com/snapcore/snapd/cmd/snap-bootstrap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type mountStepsSuite struct {
+	testutil.BaseTest
+
+	mu  sync.Mutex
+	ran []string
+}
+
+var _ = Suite(&mountStepsSuite{})
+
+func (s *mountStepsSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.ran = nil
+}
+
+func (s *mountStepsSuite) step(name string, after []string, err error) main.MountStep {
+	return main.NewMountStep(name, after, func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.ran = append(s.ran, name)
+		return err
+	})
+}
+
+func (s *mountStepsSuite) TestRunMountStepsSequential(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(false))
+
+	err := main.RunMountSteps([]main.MountStep{
+		s.step("a", nil, nil),
+		s.step("b", nil, nil),
+		s.step("c", []string{"a"}, nil),
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.ran, DeepEquals, []string{"a", "b", "c"})
+
+	s.ran = nil
+	err = main.RunMountSteps([]main.MountStep{
+		s.step("a", nil, nil),
+		s.step("b", nil, errors.New("b failed")),
+		s.step("c", []string{"a"}, nil),
+	})
+	c.Assert(err, ErrorMatches, "b failed")
+	c.Check(s.ran, DeepEquals, []string{"a", "b"})
+}
+
+func (s *mountStepsSuite) TestRunMountStepsConcurrentIndependent(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+
+	// each of the independent steps waits for the other one to start,
+	// which only works if they run concurrently
+	aStarted := make(chan struct{})
+	bStarted := make(chan struct{})
+	rendezvous := func(mine, other chan struct{}) func() error {
+		return func() error {
+			close(mine)
+			select {
+			case <-other:
+				return nil
+			case <-time.After(5 * time.Second):
+				return errors.New("timeout")
+			}
+		}
+	}
+	err := main.RunMountSteps([]main.MountStep{
+		main.NewMountStep("a", nil, rendezvous(aStarted, bStarted)),
+		main.NewMountStep("b", nil, rendezvous(bStarted, aStarted)),
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *mountStepsSuite) TestRunMountStepsConcurrentDependencies(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+
+	err := main.RunMountSteps([]main.MountStep{
+		s.step("boot", nil, nil),
+		s.step("seed", []string{"boot"}, nil),
+		s.step("data", []string{"boot"}, nil),
+		s.step("save", []string{"data"}, nil),
+		s.step("snapd", []string{"seed", "save"}, nil),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.ran, HasLen, 5)
+
+	pos := make(map[string]int)
+	for i, name := range s.ran {
+		pos[name] = i
+	}
+	c.Check(pos["boot"] < pos["seed"], Equals, true)
+	c.Check(pos["boot"] < pos["data"], Equals, true)
+	c.Check(pos["data"] < pos["save"], Equals, true)
+	c.Check(pos["seed"] < pos["snapd"], Equals, true)
+	c.Check(pos["save"] < pos["snapd"], Equals, true)
+}
+
+func (s *mountStepsSuite) TestRunMountStepsConcurrentFailureSkipsDependants(c *C) {
+	s.AddCleanup(main.MockMountStepsConcurrently(true))
+
+	err := main.RunMountSteps([]main.MountStep{
+		s.step("seed", nil, nil),
+		s.step("data", nil, errors.New("cannot mount data")),
+		s.step("save", []string{"data"}, errors.New("unexpected")),
+		s.step("kernel", []string{"save"}, nil),
+	})
+	c.Assert(err, ErrorMatches, "cannot mount data")
+	sort.Strings(s.ran)
+	c.Check(s.ran, DeepEquals, []string{"data", "seed"})
+}
+
+func (s *mountStepsSuite) TestRunMountStepsInvalid(c *C) {
+	for _, concurrently := range []bool{true, false} {
+		restore := main.MockMountStepsConcurrently(concurrently)
+		defer restore()
+
+		err := main.RunMountSteps([]main.MountStep{
+			s.step("save", []string{"data"}, nil),
+			s.step("data", nil, nil),
+		})
+		c.Check(err, ErrorMatches, `internal error: mount step "save" must be listed after "data"`)
+
+		err = main.RunMountSteps([]main.MountStep{
+			s.step("data", nil, nil),
+			s.step("data", nil, nil),
+		})
+		c.Check(err, ErrorMatches, `internal error: duplicated mount step "data"`)
+		c.Check(s.ran, HasLen, 0)
+	}
+}
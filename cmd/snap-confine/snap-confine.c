@@ -632,6 +632,7 @@ static void enter_non_classic_execution_environment(sc_invocation * inv,
 		if (!sc_cgroup_is_v2()) {
 			setup_devices_cgroup(inv->security_tag, &udev_s);
 		}
+		sc_setup_serial_ports(&udev_s);
 	}
 	snappy_udev_cleanup(&udev_s);
 
@@ -18,6 +18,7 @@
 
 #include <ctype.h>
 #include <errno.h>
+#include <fcntl.h>
 #include <limits.h>
 #include <sys/sysmacros.h>
 #include <sched.h>
@@ -25,8 +26,11 @@
 #include <sys/stat.h>
 #include <sys/types.h>
 #include <sys/wait.h>
+#include <termios.h>
 #include <unistd.h>
 
+#include "../libsnap-confine-private/cleanup-funcs.h"
+#include "../libsnap-confine-private/locking.h"
 #include "../libsnap-confine-private/snap.h"
 #include "../libsnap-confine-private/string-utils.h"
 #include "../libsnap-confine-private/utils.h"
@@ -332,3 +336,116 @@ void setup_devices_cgroup(const char *security_tag, struct snappy_udev *udev_s)
 		udev_s->assigned = udev_list_entry_get_next(udev_s->assigned);
 	}
 }
+
+static speed_t sc_serial_port_speed(const char *baud_rate)
+{
+	// Keep in sync with serialPortBaudRates of the serial-port interface.
+	static const struct {
+		const char *rate;
+		speed_t speed;
+	} speeds[] = {
+		{.rate="1200", .speed=B1200},
+		{.rate="2400", .speed=B2400},
+		{.rate="4800", .speed=B4800},
+		{.rate="9600", .speed=B9600},
+		{.rate="19200", .speed=B19200},
+		{.rate="38400", .speed=B38400},
+		{.rate="57600", .speed=B57600},
+		{.rate="115200", .speed=B115200},
+		{.rate="230400", .speed=B230400},
+		{.rate="460800", .speed=B460800},
+		{.rate="921600", .speed=B921600},
+	};
+	for (size_t i = 0; i < sizeof speeds / sizeof speeds[0]; i++) {
+		if (sc_streq(speeds[i].rate, baud_rate)) {
+			return speeds[i].speed;
+		}
+	}
+	die("unsupported serial port baud rate %s", baud_rate);
+	return B0;		/* unreachable */
+}
+
+static void sc_configure_serial_port(const char *devnode,
+				     const char *baud_rate, const char *parity)
+{
+	int fd SC_CLEANUP(sc_cleanup_close) = -1;
+	// O_NONBLOCK so that a port without carrier does not block us
+	fd = open(devnode, O_RDWR | O_NOCTTY | O_NONBLOCK | O_CLOEXEC);
+	if (fd < 0) {
+		die("cannot open serial port %s", devnode);
+	}
+	struct termios tio;
+	if (tcgetattr(fd, &tio) < 0) {
+		die("cannot get attributes of serial port %s", devnode);
+	}
+	if (baud_rate != NULL) {
+		speed_t speed = sc_serial_port_speed(baud_rate);
+		if (cfsetispeed(&tio, speed) < 0 || cfsetospeed(&tio, speed) < 0) {
+			die("cannot set baud rate of serial port %s", devnode);
+		}
+	}
+	if (parity != NULL) {
+		if (sc_streq(parity, "none")) {
+			tio.c_cflag &= ~(PARENB | PARODD);
+		} else if (sc_streq(parity, "even")) {
+			tio.c_cflag |= PARENB;
+			tio.c_cflag &= ~PARODD;
+		} else if (sc_streq(parity, "odd")) {
+			tio.c_cflag |= PARENB | PARODD;
+		} else {
+			die("unsupported serial port parity %s", parity);
+		}
+	}
+	if (tcsetattr(fd, TCSANOW, &tio) < 0) {
+		die("cannot set attributes of serial port %s", devnode);
+	}
+}
+
+void sc_setup_serial_ports(struct snappy_udev *udev_s)
+{
+	debug("%s", __func__);
+	if (udev_s == NULL)
+		die("snappy_udev is NULL");
+	if (udev_s->udev == NULL)
+		die("snappy_udev->udev is NULL");
+	if (udev_s->devices == NULL)
+		die("snappy_udev->devices is NULL");
+
+	// The serial-port interface records how the ports assigned to the
+	// application are configured and claimed in the udev database.
+	struct udev_list_entry *entry =
+	    udev_enumerate_get_list_entry(udev_s->devices);
+	for (; entry != NULL; entry = udev_list_entry_get_next(entry)) {
+		const char *path = udev_list_entry_get_name(entry);
+		if (path == NULL)
+			die("udev_list_entry_get_name failed");
+		struct udev_device *d =
+		    udev_device_new_from_syspath(udev_s->udev, path);
+		if (d == NULL) {
+			debug("cannot find device from syspath %s", path);
+			continue;
+		}
+		const char *subsystem = udev_device_get_subsystem(d);
+		const char *devnode = udev_device_get_devnode(d);
+		if (!sc_streq(subsystem, "tty") || devnode == NULL) {
+			udev_device_unref(d);
+			continue;
+		}
+		const char *exclusive =
+		    udev_device_get_property_value(d, "SNAPD_SERIAL_PORT_EXCLUSIVE");
+		const char *baud_rate =
+		    udev_device_get_property_value(d, "SNAPD_SERIAL_PORT_BAUD_RATE");
+		const char *parity =
+		    udev_device_get_property_value(d, "SNAPD_SERIAL_PORT_PARITY");
+		if (sc_streq(exclusive, "1")) {
+			// The descriptor is deliberately leaked, it holds the
+			// claim for as long as the application runs.
+			(void)sc_lock_serial_port(udev_device_get_sysname(d));
+		}
+		if (baud_rate != NULL || parity != NULL) {
+			debug("configuring serial port %s", devnode);
+			sc_configure_serial_port(devnode, baud_rate, parity);
+		}
+		udev_device_unref(d);
+	}
+}
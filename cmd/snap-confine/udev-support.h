@@ -37,4 +37,14 @@ int snappy_udev_init(const char *security_tag, struct snappy_udev *udev_s);
 void snappy_udev_cleanup(struct snappy_udev *udev_s);
 void setup_devices_cgroup(const char *security_tag, struct snappy_udev *udev_s);
 
+/**
+ * Claim and configure the serial ports assigned to the application.
+ *
+ * Serial ports with the SNAPD_SERIAL_PORT_EXCLUSIVE udev property are claimed
+ * with sc_lock_serial_port(), the claim is held by the application. Ports
+ * with the SNAPD_SERIAL_PORT_BAUD_RATE or SNAPD_SERIAL_PORT_PARITY properties
+ * are configured accordingly.
+ **/
+void sc_setup_serial_ports(struct snappy_udev *udev_s);
+
 #endif
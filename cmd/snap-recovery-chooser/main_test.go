@@ -77,6 +77,7 @@ var mockSystems = &main.ChooserSystems{
 			Label: "foo",
 			Actions: []client.SystemAction{
 				{Title: "reinstall", Mode: "install"},
+				{Title: "diagnostics", Mode: "run", GadgetAction: "run-app"},
 			},
 		},
 	},
@@ -276,6 +277,37 @@ echo '{"label":"label","action":{"mode":"install","title":"reinstall"}}'
 	c.Assert(s.markerFile, testutil.FileAbsent)
 }
 
+func (s *mockedClientCmdSuite) TestMainChooserWithToolGadgetAction(c *C) {
+	r := main.MockDefaultMarkerFile(s.markerFile)
+	defer r()
+
+	mockCmd := testutil.MockCommand(c, "tool", `
+echo '{"label":"foo","action":{"mode":"run","title":"diagnostics","gadget-action":"run-app"}}'
+`)
+	defer mockCmd.Restore()
+	r = main.MockChooserTool(func() (*exec.Cmd, error) {
+		return exec.Command(mockCmd.Exe()), nil
+	})
+	defer r()
+
+	s.mockSuccessfulResponse(c, mockSystems, &mockSystemRequestResponse{
+		code:  200,
+		label: "foo",
+		expect: map[string]interface{}{
+			"action":        "do",
+			"mode":          "run",
+			"title":         "diagnostics",
+			"gadget-action": "run-app",
+		},
+	})
+
+	rbt, err := main.Chooser(client.New(&s.config))
+	c.Assert(err, IsNil)
+	// running an app does not restart the system
+	c.Assert(rbt, Equals, false)
+	c.Assert(s.markerFile, testutil.FileAbsent)
+}
+
 func (s *mockedClientCmdSuite) TestMainChooserToolNotFound(c *C) {
 	r := main.MockDefaultMarkerFile(s.markerFile)
 	defer r()
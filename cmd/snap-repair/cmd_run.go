@@ -24,6 +24,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
@@ -69,8 +70,12 @@ func (c *cmdRun) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	flock.SetOperation("snap-repair run")
 	err = flock.TryLock()
 	if err == osutil.ErrAlreadyLocked {
+		if info, _ := osutil.InspectLock(flock.Path()); info != nil && info.Holder != nil {
+			return fmt.Errorf("cannot run, another snap-repair run already executing (pid %d since %s)", info.Holder.PID, info.Holder.Since.Format(time.RFC3339))
+		}
 		return fmt.Errorf("cannot run, another snap-repair run already executing")
 	}
 	if err != nil {
@@ -20,6 +20,7 @@
 package main_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -92,3 +93,17 @@ func (r *repairSuite) TestRunAlreadyLocked(c *C) {
 	err = repair.ParseArgs([]string{"run"})
 	c.Check(err, ErrorMatches, `cannot run, another snap-repair run already executing`)
 }
+
+func (r *repairSuite) TestRunAlreadyLockedByRecordedHolder(c *C) {
+	err := os.MkdirAll(dirs.SnapRunRepairDir, 0700)
+	c.Assert(err, IsNil)
+	flock, err := osutil.NewFileLock(filepath.Join(dirs.SnapRunRepairDir, "lock"))
+	c.Assert(err, IsNil)
+	flock.SetOperation("snap-repair run")
+	err = flock.Lock()
+	c.Assert(err, IsNil)
+	defer flock.Unlock()
+
+	err = repair.ParseArgs([]string{"run"})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot run, another snap-repair run already executing \(pid %d since .*\)`, os.Getpid()))
+}
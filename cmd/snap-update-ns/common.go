@@ -68,6 +68,7 @@ func (upCtx *CommonProfileUpdateContext) Lock() (func(), error) {
 			return nil, fmt.Errorf("mount namespace of snap %q is not locked but --from-snap-confine was used", instanceName)
 		}
 	} else {
+		lock.SetOperation(fmt.Sprintf("snap-update-ns: update of mount namespace of snap %q", instanceName))
 		if err := lock.Lock(); err != nil {
 			return nil, fmt.Errorf("cannot lock mount namespace of snap %q: %s", instanceName, err)
 		}
@@ -51,6 +51,8 @@ type cmdChanges struct {
 type cmdTasks struct {
 	timeMixin
 	changeIDMixin
+
+	PolicyDiff bool `long:"policy-diff"`
 }
 
 func init() {
@@ -58,7 +60,10 @@ func init() {
 		func() flags.Commander { return &cmdChanges{} }, timeDescs, nil)
 	addCommand("tasks", shortTasksHelp, longTasksHelp,
 		func() flags.Commander { return &cmdTasks{} },
-		changeIDMixinOptDesc.also(timeDescs),
+		changeIDMixinOptDesc.also(timeDescs).also(map[string]string{
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"policy-diff": i18n.G("Show the full security policy diffs recorded by the change"),
+		}),
 		changeIDMixinArgDesc).alias = "change"
 }
 
@@ -190,11 +195,56 @@ func (c *cmdTasks) showChange(chid string) error {
 		}
 	}
 
+	if err := c.showPolicyDiffs(chg); err != nil {
+		return err
+	}
+
 	fmt.Fprintln(Stdout)
 
 	return nil
 }
 
+func (c *cmdTasks) showPolicyDiffs(chg *client.Change) error {
+	diffs, err := chg.PolicyDiffs()
+	if err == client.ErrNoData {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(diffs))
+	for name := range diffs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		diff := diffs[name]
+		fmt.Fprintln(Stdout)
+		fmt.Fprintln(Stdout, line)
+		fmt.Fprintf(Stdout, i18n.G("Security policy changes of %q from revision %s to %s\n"), name, diff.OldRevision, diff.NewRevision)
+		fmt.Fprintln(Stdout)
+		fmt.Fprintf(Stdout, i18n.G("%d profiles changed\n"), len(diff.Profiles))
+		if len(diff.HighRisk) > 0 {
+			fmt.Fprintln(Stdout, i18n.G("High-risk changes:"))
+			for _, rule := range diff.HighRisk {
+				fmt.Fprintf(Stdout, "  %s\n", rule)
+			}
+		}
+		if !c.PolicyDiff {
+			continue
+		}
+		for _, profile := range diff.Profiles {
+			fmt.Fprintln(Stdout)
+			fmt.Fprintf(Stdout, "--- %s\n", profile.Path)
+			fmt.Fprint(Stdout, profile.Diff)
+		}
+	}
+
+	return nil
+}
+
 const line = "......................................................................"
 
 func warnMaintenance(cli *client.Client) error {
@@ -203,6 +253,10 @@ func warnMaintenance(cli *client.Client) error {
 		if err != nil {
 			return err
 		}
+		if optionsData.Strict {
+			writeStrictReport(&strictReport{Type: "warning", Message: msg})
+			return nil
+		}
 		fmt.Fprintf(Stderr, "WARNING: %s\n", msg)
 	}
 	return nil
@@ -69,6 +69,65 @@ Do +2016-04-21T01:02:03Z +2016-04-21T01:02:04Z +some summary
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+var mockChangePolicyDiffsJSON = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "refresh-snap",
+  "summary": "...",
+  "status": "Error",
+  "ready": true,
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "ready-time": "2016-04-21T01:02:04Z",
+  "tasks": [{"kind": "bar", "summary": "some summary", "status": "Error", "progress": {"done": 1, "total": 1}, "spawn-time": "2016-04-21T01:02:03Z", "ready-time": "2016-04-21T01:02:04Z"}],
+  "data": {"policy-diffs": {"foo": {
+    "old-revision": "1",
+    "new-revision": "2",
+    "profiles": [{"path": "/var/lib/snapd/apparmor/profiles/snap.foo.app", "diff": "-# old\n+ptrace,\n"}],
+    "high-risk": ["snap.foo.app: ptrace,"]
+  }}}
+}}`
+
+func (s *SnapSuite) TestChangePolicyDiffs(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/changes/42")
+		fmt.Fprintln(w, mockChangePolicyDiffsJSON)
+	})
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"change", "--abs-time", "42"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?ms)Status +Spawn +Ready +Summary
+Error +2016-04-21T01:02:03Z +2016-04-21T01:02:04Z +some summary
+
+\.+
+Security policy changes of "foo" from revision 1 to 2
+
+1 profiles changed
+High-risk changes:
+  snap.foo.app: ptrace,
+
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestChangePolicyDiffsFull(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, mockChangePolicyDiffsJSON)
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"change", "--policy-diff", "42"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Matches, `(?ms).*High-risk changes:
+  snap.foo.app: ptrace,
+
+--- /var/lib/snapd/apparmor/profiles/snap.foo.app
+-# old
+\+ptrace,
+
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
 func (s *SnapSuite) TestChangeSimpleRebooting(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
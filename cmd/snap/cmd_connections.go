@@ -31,8 +31,10 @@ import (
 )
 
 type cmdConnections struct {
-	clientMixin
+	waitMixin
 	All         bool `long:"all"`
+	Orphans     bool `long:"orphans"`
+	Repair      bool `long:"repair"`
 	Positionals struct {
 		Snap installedSnapName
 	} `positional-args:"true"`
@@ -51,14 +53,29 @@ $ snap connections <snap>
 
 Lists connected and unconnected plugs and slots for the specified
 snap.
+
+$ snap connections --orphans [<snap>]
+
+Lists connections remembered by the system whose plug or slot is gone,
+for instance because a snap revision no longer declares it or because
+a hotplug device was removed.
+
+$ snap connections --repair
+
+Forgets all such connections, except for those of hotplug devices that
+are present again under a different slot, which are moved to that slot.
 `)
 
 func init() {
 	addCommand("connections", shortConnectionsHelp, longConnectionsHelp, func() flags.Commander {
 		return &cmdConnections{}
-	}, map[string]string{
+	}, waitDescs.also(map[string]string{
 		"all": i18n.G("Show connected and unconnected plugs and slots"),
-	}, []argDesc{{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"orphans": i18n.G("Show connections whose plug or slot is gone"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"repair": i18n.G("Forget or rebind connections whose plug or slot is gone"),
+	}), []argDesc{{
 		// TRANSLATORS: This needs to be wrapped in <>s.
 		name: "<snap>",
 		// TRANSLATORS: This should not start with a lowercase letter.
@@ -136,10 +153,23 @@ func (x *cmdConnections) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
+	wanted := string(x.Positionals.Snap)
+	if x.Repair {
+		if x.All || x.Orphans || wanted != "" {
+			return fmt.Errorf("%s", i18n.G("cannot use --repair with other options or a snap name"))
+		}
+		return x.repair()
+	}
+	if x.Orphans {
+		if x.All {
+			return fmt.Errorf("%s", i18n.G("cannot use --all with --orphans"))
+		}
+		return x.listOrphans(wanted)
+	}
+
 	opts := client.ConnectionOptions{
 		All: x.All,
 	}
-	wanted := string(x.Positionals.Snap)
 	if wanted != "" {
 		if x.All {
 			// passing a snap name already implies --all, error out
@@ -212,3 +242,48 @@ func (x *cmdConnections) Execute(args []string) error {
 	}
 	return nil
 }
+
+func (x *cmdConnections) listOrphans(wanted string) error {
+	orphans, err := x.client.OrphanedConnections(&client.ConnectionOptions{Snap: wanted})
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Fprintln(Stderr, i18n.G("No orphaned connections found."))
+		return nil
+	}
+
+	w := tabWriter()
+	fmt.Fprintln(w, i18n.G("Interface\tPlug\tSlot\tReason\tNotes"))
+	for _, orphan := range orphans {
+		notes := "-"
+		if orphan.Rebind != nil {
+			notes = fmt.Sprintf(i18n.G("rebind to %s"), endpoint(orphan.Rebind.Snap, orphan.Rebind.Name))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", orphan.Interface,
+			endpoint(orphan.Plug.Snap, orphan.Plug.Name),
+			endpoint(orphan.Slot.Snap, orphan.Slot.Name),
+			orphan.Reason, notes)
+	}
+	w.Flush()
+	return nil
+}
+
+func (x *cmdConnections) repair() error {
+	id, err := x.client.RepairConnections()
+	if err != nil {
+		if client.IsInterfacesUnchangedError(err) {
+			fmt.Fprintln(Stdout, i18n.G("No connections to repair"))
+			return nil
+		}
+		return err
+	}
+
+	if _, err := x.wait(id); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
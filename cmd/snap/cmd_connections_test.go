@@ -851,3 +851,101 @@ func (s *SnapSuite) TestConnectionsDefiningAttribute(c *C) {
 	c.Assert(s.Stdout(), Equals, expectedStdout)
 	c.Assert(s.Stderr(), Equals, "")
 }
+
+func (s *SnapSuite) TestConnectionsOrphans(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/connections")
+		c.Check(r.URL.Query(), DeepEquals, url.Values{
+			"select": []string{"orphans"},
+		})
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type": "sync",
+			"result": map[string]interface{}{
+				"orphaned": []client.OrphanedConnection{
+					{
+						Plug:      client.PlugRef{Snap: "consumer", Name: "plug"},
+						Slot:      client.SlotRef{Snap: "core", Name: "hotplug-old"},
+						Interface: "serial-port",
+						Reason:    "hotplug-gone",
+						Rebind:    &client.SlotRef{Snap: "core", Name: "hotplug-new"},
+					}, {
+						Plug:      client.PlugRef{Snap: "consumer", Name: "gone"},
+						Slot:      client.SlotRef{Snap: "producer", Name: "slot"},
+						Interface: "test",
+						Reason:    "plug-missing",
+					},
+				},
+			},
+		})
+	})
+	rest, err := Parser(Client()).ParseArgs([]string{"connections", "--orphans"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	expectedStdout := "" +
+		"Interface    Plug           Slot           Reason        Notes\n" +
+		"serial-port  consumer:plug  :hotplug-old   hotplug-gone  rebind to :hotplug-new\n" +
+		"test         consumer:gone  producer:slot  plug-missing  -\n"
+	c.Assert(s.Stdout(), Equals, expectedStdout)
+	c.Assert(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestConnectionsOrphansNone(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query(), DeepEquals, url.Values{
+			"select": []string{"orphans"},
+			"snap":   []string{"foo"},
+		})
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":   "sync",
+			"result": map[string]interface{}{"orphaned": []interface{}{}},
+		})
+	})
+	_, err := Parser(Client()).ParseArgs([]string{"connections", "--orphans", "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "No orphaned connections found.\n")
+}
+
+func (s *SnapSuite) TestConnectionsRepair(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/connections":
+			c.Check(r.Method, Equals, "POST")
+			c.Check(DecodedRequestBody(c, r), DeepEquals, map[string]interface{}{
+				"action": "repair",
+			})
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+		case "/v2/changes/zzz":
+			c.Check(r.Method, Equals, "GET")
+			fmt.Fprintln(w, `{"type":"sync", "result":{"ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+	rest, err := Parser(Client()).ParseArgs([]string{"connections", "--repair"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestConnectionsRepairNothingToDo(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		w.WriteHeader(400)
+		fmt.Fprintln(w, `{"type":"error", "status-code": 400, "result": {"message": "nothing to do", "kind": "interfaces-unchanged"}}`)
+	})
+	_, err := Parser(Client()).ParseArgs([]string{"connections", "--repair"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "No connections to repair\n")
+	c.Assert(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestConnectionsRepairOrphansConflict(c *C) {
+	_, err := Parser(Client()).ParseArgs([]string{"connections", "--repair", "--orphans"})
+	c.Assert(err, ErrorMatches, "cannot use --repair with other options or a snap name")
+	_, err = Parser(Client()).ParseArgs([]string{"connections", "--orphans", "--all"})
+	c.Assert(err, ErrorMatches, "cannot use --all with --orphans")
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/osutil"
+)
+
+type cmdDebugLocks struct {
+	timeMixin
+	unicodeMixin
+
+	All bool `long:"all"`
+}
+
+func init() {
+	cmd := addDebugCommand("locks",
+		"(internal) show the state of the snapd file locks",
+		"(internal) show the state of the snapd file locks and their holders",
+		func() flags.Commander {
+			return &cmdDebugLocks{}
+		}, timeDescs.also(unicodeDescs).also(map[string]string{
+			"all": "Also show locks that are not held",
+		}), nil)
+	cmd.hidden = true
+}
+
+// lockFiles returns the lock files of the snap locks, the snap run
+// inhibition locks and the snap-repair lock.
+func lockFiles() []string {
+	var paths []string
+	for _, pattern := range []string{
+		filepath.Join(dirs.SnapRunLockDir, "*.lock"),
+		filepath.Join(runinhibit.InhibitDir, "*.lock"),
+		filepath.Join(dirs.SnapRunRepairDir, "lock"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (x *cmdDebugLocks) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	esc := x.getEscapes()
+
+	var infos []*osutil.LockInfo
+	for _, path := range lockFiles() {
+		info, err := osutil.InspectLock(path)
+		if err != nil {
+			return fmt.Errorf("cannot inspect lock %s: %v", path, err)
+		}
+		if !info.Locked && !x.All {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	if len(infos) == 0 {
+		if x.All {
+			fmt.Fprintln(Stderr, i18n.G("No locks found."))
+		} else {
+			fmt.Fprintln(Stderr, i18n.G("No locks are held."))
+		}
+		return nil
+	}
+
+	w := tabWriter()
+	fmt.Fprintln(w, "Lock\tState\tPID\tSince\tOperation")
+	for _, info := range infos {
+		state := "free"
+		pid, since, operation := esc.dash, esc.dash, esc.dash
+		if info.Locked {
+			state = "held"
+		}
+		if info.Holder != nil {
+			pid = strconv.Itoa(info.Holder.PID)
+			since = x.fmtTime(info.Holder.Since)
+			if info.Holder.Operation != "" {
+				operation = info.Holder.Operation
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.Path, state, pid, since, operation)
+	}
+	w.Flush()
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+func (s *SnapSuite) mockLock(c *check.C, path string, holder string) *osutil.FileLock {
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), check.IsNil)
+	lock, err := osutil.NewFileLock(path)
+	c.Assert(err, check.IsNil)
+	s.AddCleanup(func() { lock.Close() })
+	if holder != "" {
+		c.Assert(ioutil.WriteFile(path+".holder", []byte(holder), 0644), check.IsNil)
+	}
+	return lock
+}
+
+func (s *SnapSuite) TestDebugLocks(c *check.C) {
+	held := s.mockLock(c, filepath.Join(dirs.SnapRunLockDir, "foo.lock"),
+		fmt.Sprintf(`{"pid":%d,"operation":"snapd: refresh of snap \"foo\"","since":"2021-03-04T05:06:07Z"}`, os.Getpid()))
+	c.Assert(held.Lock(), check.IsNil)
+	unknown := s.mockLock(c, filepath.Join(dirs.SnapRunRepairDir, "lock"), "")
+	c.Assert(unknown.Lock(), check.IsNil)
+	// not held
+	s.mockLock(c, filepath.Join(runinhibit.InhibitDir, "bar.lock"), "")
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "locks", "--abs-time", "--unicode=never"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Matches, fmt.Sprintf(`(?s)Lock +State +PID +Since +Operation
+%[1]s/run/snapd/lock/foo.lock +held +%[2]d +2021-03-04T05:06:07Z +snapd: refresh of snap "foo"
+%[1]s/run/snapd/repair/lock +held +-- +-- +--
+`, dirs.GlobalRootDir, os.Getpid()))
+	c.Check(s.Stderr(), check.Equals, "")
+
+	s.ResetStdStreams()
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"debug", "locks", "--all", "--unicode=never"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Matches, fmt.Sprintf(`(?s)Lock +State +PID +Since +Operation
+%[1]s/run/snapd/lock/foo.lock +held +%[2]d +.* +snapd: refresh of snap "foo"
+%[1]s/run/snapd/repair/lock +held +-- +-- +--
+%[1]s/var/lib/snapd/inhibit/bar.lock +free +-- +-- +--
+`, dirs.GlobalRootDir, os.Getpid()))
+}
+
+func (s *SnapSuite) TestDebugLocksStaleHolderIgnored(c *check.C) {
+	// the lock is not held, the holder is stale
+	s.mockLock(c, filepath.Join(dirs.SnapRunLockDir, "foo.lock"),
+		`{"pid":1,"operation":"snapd: refresh of snap \"foo\"","since":"2021-03-04T05:06:07Z"}`)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "locks", "--all", "--unicode=never"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Matches, fmt.Sprintf(`Lock +State +PID +Since +Operation
+%s/run/snapd/lock/foo.lock +free +-- +-- +--
+`, dirs.GlobalRootDir))
+}
+
+func (s *SnapSuite) TestDebugLocksNoneHeld(c *check.C) {
+	s.mockLock(c, filepath.Join(dirs.SnapRunLockDir, "foo.lock"), "")
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "locks"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "")
+	c.Check(s.Stderr(), check.Equals, "No locks are held.\n")
+}
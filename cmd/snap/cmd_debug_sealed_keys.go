@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type cmdDebugSealedKeys struct {
+	clientMixin
+	unicodeMixin
+}
+
+func init() {
+	cmd := addDebugCommand("sealed-keys",
+		"(internal) obtain details about the sealed keys of the device",
+		"(internal) obtain details about what the sealed keys of the device are bound to",
+		func() flags.Commander {
+			return &cmdDebugSealedKeys{}
+		}, nil, nil)
+	cmd.hidden = true
+}
+
+func (x *cmdDebugSealedKeys) Execute(args []string) error {
+	esc := x.getEscapes()
+
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	var resp []struct {
+		KeyFile                string    `json:"key-file"`
+		PCRPolicyCounterHandle uint32    `json:"pcr-policy-counter-handle"`
+		PCRSelection           []int     `json:"pcr-selection"`
+		Created                time.Time `json:"created"`
+		Resealed               time.Time `json:"resealed"`
+		AuthorizedPolicyKey    string    `json:"authorized-policy-key"`
+		Error                  string    `json:"error"`
+	}
+	if err := x.client.DebugGet("sealed-keys", &resp, nil); err != nil {
+		return err
+	}
+	if len(resp) == 0 {
+		fmt.Fprintln(Stderr, "No sealed keys found.")
+		return nil
+	}
+
+	orDash := func(s string) string {
+		if s == "" {
+			return esc.dash
+		}
+		return s
+	}
+	timeOrDash := func(t time.Time) string {
+		if t.IsZero() {
+			return esc.dash
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	w := tabWriter()
+	fmt.Fprintln(w, "Key file\tCounter\tPCRs\tCreated\tResealed\tPolicy key")
+	for _, key := range resp {
+		if key.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.KeyFile, esc.dash, esc.dash, esc.dash, esc.dash, "error: "+key.Error)
+			continue
+		}
+		pcrs := make([]string, len(key.PCRSelection))
+		for i, pcr := range key.PCRSelection {
+			pcrs[i] = strconv.Itoa(pcr)
+		}
+		counter := esc.dash
+		if key.PCRPolicyCounterHandle != 0 {
+			counter = fmt.Sprintf("%#x", key.PCRPolicyCounterHandle)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.KeyFile, counter,
+			orDash(strings.Join(pcrs, ",")), timeOrDash(key.Created),
+			timeOrDash(key.Resealed), orDash(key.AuthorizedPolicyKey))
+	}
+	w.Flush()
+
+	return nil
+}
@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestDebugSealedKeys(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/debug")
+			c.Check(r.URL.RawQuery, check.Equals, "aspect=sealed-keys")
+			fmt.Fprintln(w, `{"type": "sync", "result": [
+  {"key-file": "/run/ubuntu-data.sealed-key", "pcr-policy-counter-handle": 25690113,
+   "pcr-selection": [4, 7, 12], "created": "2021-03-01T12:00:00Z", "resealed": "2021-03-02T08:30:00Z",
+   "authorized-policy-key": "sha256:abcd"},
+  {"key-file": "/seed/ubuntu-save.recovery.sealed-key", "pcr-policy-counter-handle": 25690114,
+   "created": "0001-01-01T00:00:00Z", "resealed": "0001-01-01T00:00:00Z"},
+  {"key-file": "/seed/ubuntu-data.recovery.sealed-key", "error": "cannot read key"}
+]}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "sealed-keys", "--unicode=never"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, `Key file                               Counter    PCRs    Created               Resealed              Policy key
+/run/ubuntu-data.sealed-key            0x1880001  4,7,12  2021-03-01T12:00:00Z  2021-03-02T08:30:00Z  sha256:abcd
+/seed/ubuntu-save.recovery.sealed-key  0x1880002  --      --                    --                    --
+/seed/ubuntu-data.recovery.sealed-key  --         --      --                    --                    error: cannot read key
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapSuite) TestDebugSealedKeysNone(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.RawQuery, check.Equals, "aspect=sealed-keys")
+		fmt.Fprintln(w, `{"type": "sync", "result": []}`)
+	})
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "sealed-keys"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "")
+	c.Check(s.Stderr(), check.Equals, "No sealed keys found.\n")
+}
@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type cmdDebugTPM struct {
+	clientMixin
+	unicodeMixin
+}
+
+func init() {
+	cmd := addDebugCommand("tpm",
+		"(internal) obtain details about the TPM of the device",
+		"(internal) obtain details about the TPM of the device",
+		func() flags.Commander {
+			return &cmdDebugTPM{}
+		}, nil, nil)
+	cmd.hidden = true
+}
+
+func (x *cmdDebugTPM) Execute(args []string) error {
+	esc := x.getEscapes()
+
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	var resp struct {
+		Manufacturer string   `json:"manufacturer"`
+		Vendor       string   `json:"vendor"`
+		Firmware     bool     `json:"firmware"`
+		Virtual      bool     `json:"virtual"`
+		PCRBanks     []string `json:"pcr-banks"`
+		Quirks       struct {
+			SlowNVWrites      bool `json:"slow-nv-writes"`
+			MissingSHA384Bank bool `json:"missing-sha384-bank"`
+		} `json:"quirks"`
+	}
+	if err := x.client.DebugGet("tpm", &resp, nil); err != nil {
+		return err
+	}
+
+	var quirks []string
+	if resp.Quirks.SlowNVWrites {
+		quirks = append(quirks, "slow-nv-writes")
+	}
+	if resp.Quirks.MissingSHA384Bank {
+		quirks = append(quirks, "missing-sha384-bank")
+	}
+
+	orDash := func(s string) string {
+		if s == "" {
+			return esc.dash
+		}
+		return s
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "manufacturer:\t%s\n", orDash(resp.Manufacturer))
+	fmt.Fprintf(w, "vendor:\t%s\n", orDash(resp.Vendor))
+	fmt.Fprintf(w, "firmware:\t%v\n", resp.Firmware)
+	fmt.Fprintf(w, "virtual:\t%v\n", resp.Virtual)
+	fmt.Fprintf(w, "pcr-banks:\t%s\n", orDash(strings.Join(resp.PCRBanks, ", ")))
+	fmt.Fprintf(w, "quirks:\t%s\n", orDash(strings.Join(quirks, ", ")))
+	w.Flush()
+
+	return nil
+}
@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestDebugTPM(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/debug")
+			c.Check(r.URL.RawQuery, check.Equals, "aspect=tpm")
+			fmt.Fprintln(w, `{"type": "sync", "result": {
+  "manufacturer": "AMD",
+  "vendor": "amd-ftpm",
+  "firmware": true,
+  "pcr-banks": ["sha1", "sha256"],
+  "quirks": {"slow-nv-writes": true, "missing-sha384-bank": true}
+}}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "tpm"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, `manufacturer:  AMD
+vendor:        amd-ftpm
+firmware:      true
+virtual:       false
+pcr-banks:     sha1, sha256
+quirks:        slow-nv-writes, missing-sha384-bank
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapSuite) TestDebugTPMDiscrete(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.RawQuery, check.Equals, "aspect=tpm")
+		fmt.Fprintln(w, `{"type": "sync", "result": {
+  "manufacturer": "IFX",
+  "firmware": false,
+  "pcr-banks": ["sha256"],
+  "quirks": {}
+}}`)
+	})
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "tpm", "--unicode=never"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, `manufacturer:  IFX
+vendor:        --
+firmware:      false
+virtual:       false
+pcr-banks:     sha256
+quirks:        --
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestDebugTPMVirtual(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.RawQuery, check.Equals, "aspect=tpm")
+		fmt.Fprintln(w, `{"type": "sync", "result": {
+  "manufacturer": "IBM",
+  "vendor": "swtpm",
+  "firmware": false,
+  "virtual": true,
+  "pcr-banks": ["sha256", "sha384"],
+  "quirks": {}
+}}`)
+	})
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "tpm", "--unicode=never"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, `manufacturer:  IBM
+vendor:        swtpm
+firmware:      false
+virtual:       true
+pcr-banks:     sha256, sha384
+quirks:        --
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
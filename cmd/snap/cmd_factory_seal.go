@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/i18n"
+)
+
+var (
+	shortFactorySealHelp = i18n.G("Seal this device and leave factory mode")
+	longFactorySealHelp  = i18n.G(`
+The factory-seal command takes a device that was installed in factory mode
+out of it: the encryption keys are sealed to the TPM and the factory mode
+relaxations, like allowing snaps without assertions or the serial console,
+are removed.
+
+This operation cannot be undone. The device is restarted to complete it.
+`)
+)
+
+type cmdFactorySeal struct {
+	waitMixin
+}
+
+func init() {
+	cmd := addCommand("factory-seal",
+		shortFactorySealHelp,
+		longFactorySealHelp,
+		func() flags.Commander {
+			return &cmdFactorySeal{}
+		}, waitDescs, nil)
+	cmd.hidden = true
+}
+
+func (x *cmdFactorySeal) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	changeID, err := x.client.FactorySeal()
+	if err != nil {
+		return fmt.Errorf("cannot seal the device: %v", err)
+	}
+
+	if _, err := x.wait(changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+	fmt.Fprintf(Stdout, "%s", i18n.G("Device sealed, restarting\n"))
+	return nil
+}
@@ -20,6 +20,8 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -42,6 +44,15 @@ type cmdPrepareImage struct {
 	// TODO: introduce SnapWithChannel?
 	Snaps      []string `long:"snap" value-name:"<snap>[=<channel>]"`
 	ExtraSnaps []string `long:"extra-snaps" hidden:"yes"` // DEPRECATED
+
+	Manifest       string `long:"manifest" value-name:"<manifest-file>"`
+	VerifyManifest string `long:"verify-manifest" value-name:"<manifest-file>"`
+	SeedVerity     bool   `long:"seed-verity"`
+
+	// developer conveniences for models of grade dangerous
+	DefaultUser string   `long:"default-user" value-name:"<user>"`
+	SSHKeys     []string `long:"ssh-key" value-name:"<key-file>"`
+	Assertions  []string `long:"assertion" value-name:"<assertion-file>"`
 }
 
 func init() {
@@ -67,6 +78,18 @@ For preparing classic images it supports a --classic mode`),
 			"extra-snaps": i18n.G("Extra snaps to be installed (DEPRECATED)"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"channel": i18n.G("The channel to use"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"manifest": i18n.G("Write a manifest of the inputs and output digests of the image to the given file"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"verify-manifest": i18n.G("Check that the image is identical to the one described by the given manifest"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"seed-verity": i18n.G("Store dm-verity hash trees of the essential seed snaps (UC20+ only)"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"default-user": i18n.G("Create the given user with sudo rights on first boot (grade dangerous models only)"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"ssh-key": i18n.G("Authorize the public SSH keys in the given file for the default user (grade dangerous models only)"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"assertion": i18n.G("Add the assertions in the given file to the seed (grade dangerous models only)"),
 		}, []argDesc{
 			{
 				// TRANSLATORS: This needs to begin with < and end with >
@@ -116,6 +139,40 @@ func (x *cmdPrepareImage) Execute(args []string) error {
 
 	opts.PrepareDir = x.Positional.TargetDir
 	opts.Classic = x.Classic
+	opts.ManifestFile = x.Manifest
+	opts.VerifyManifestFile = x.VerifyManifest
+	opts.SeedVerity = x.SeedVerity
+
+	opts.DefaultUser = x.DefaultUser
+	opts.ExtraAssertionFiles = x.Assertions
+	for _, fn := range x.SSHKeys {
+		keys, err := readSSHKeys(fn)
+		if err != nil {
+			return err
+		}
+		opts.SSHKeys = append(opts.SSHKeys, keys...)
+	}
 
 	return imagePrepare(opts)
 }
+
+// readSSHKeys reads the public SSH keys in the given file, one per line as
+// in authorized_keys files.
+func readSSHKeys(fn string) ([]string, error) {
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read SSH keys: %v", err)
+	}
+	var keys []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cannot use %q: no SSH keys found", fn)
+	}
+	return keys, nil
+}
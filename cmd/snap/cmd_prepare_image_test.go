@@ -21,7 +21,9 @@ package main_test
 
 import (
 	. "gopkg.in/check.v1"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	snap "github.com/snapcore/snapd/cmd/snap"
 	"github.com/snapcore/snapd/image"
@@ -139,3 +141,88 @@ func (s *SnapPrepareImageSuite) TestPrepareImageExtraSnaps(c *C) {
 		SnapChannels: map[string]string{"bar": "t/edge"},
 	})
 }
+
+func (s *SnapPrepareImageSuite) TestPrepareImageDeveloperConveniences(c *C) {
+	var opts *image.Options
+	prep := func(o *image.Options) error {
+		opts = o
+		return nil
+	}
+	r := snap.MockImagePrepare(prep)
+	defer r()
+
+	keysFile := filepath.Join(c.MkDir(), "keys")
+	err := ioutil.WriteFile(keysFile, []byte("# my keys\nssh-ed25519 AAAA foo@bar\n\nssh-rsa BBBB foo@baz\n"), 0644)
+	c.Assert(err, IsNil)
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "prepare-dir", "--default-user", "dev", "--ssh-key", keysFile, "--assertion", "user.assert", "--snap", "local.snap"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+
+	c.Check(opts, DeepEquals, &image.Options{
+		ModelFile:           "model",
+		PrepareDir:          "prepare-dir",
+		Snaps:               []string{"local.snap"},
+		DefaultUser:         "dev",
+		SSHKeys:             []string{"ssh-ed25519 AAAA foo@bar", "ssh-rsa BBBB foo@baz"},
+		ExtraAssertionFiles: []string{"user.assert"},
+	})
+}
+
+func (s *SnapPrepareImageSuite) TestPrepareImageManifest(c *C) {
+	var opts *image.Options
+	prep := func(o *image.Options) error {
+		opts = o
+		return nil
+	}
+	r := snap.MockImagePrepare(prep)
+	defer r()
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "prepare-dir", "--manifest", "new.manifest", "--verify-manifest", "old.manifest"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+
+	c.Check(opts, DeepEquals, &image.Options{
+		ModelFile:          "model",
+		PrepareDir:         "prepare-dir",
+		ManifestFile:       "new.manifest",
+		VerifyManifestFile: "old.manifest",
+	})
+}
+
+func (s *SnapPrepareImageSuite) TestPrepareImageSeedVerity(c *C) {
+	var opts *image.Options
+	prep := func(o *image.Options) error {
+		opts = o
+		return nil
+	}
+	r := snap.MockImagePrepare(prep)
+	defer r()
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "prepare-dir", "--seed-verity"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+
+	c.Check(opts, DeepEquals, &image.Options{
+		ModelFile:  "model",
+		PrepareDir: "prepare-dir",
+		SeedVerity: true,
+	})
+}
+
+func (s *SnapPrepareImageSuite) TestPrepareImageSSHKeysErrors(c *C) {
+	r := snap.MockImagePrepare(func(o *image.Options) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	defer r()
+
+	emptyFile := filepath.Join(c.MkDir(), "empty")
+	c.Assert(ioutil.WriteFile(emptyFile, []byte("# nothing\n"), 0644), IsNil)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "prepare-dir", "--default-user", "dev", "--ssh-key", emptyFile})
+	c.Check(err, ErrorMatches, `cannot use ".*/empty": no SSH keys found`)
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "prepare-dir", "--default-user", "dev", "--ssh-key", "/does/not/exist"})
+	c.Check(err, ErrorMatches, `cannot read SSH keys: open /does/not/exist: no such file or directory`)
+}
@@ -0,0 +1,245 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+)
+
+var shortPromptingRulesHelp = i18n.G("List prompting rules")
+var longPromptingRulesHelp = i18n.G(`
+The prompting-rules command lists the rules recording the decisions taken when
+snaps were prompted for access. Only the rules of the calling user are listed,
+unless called by root.
+
+With --json the rules are printed in the format expected by
+'snap import-prompting-rules', allowing to export them.
+`)
+
+var shortAddPromptingRuleHelp = i18n.G("Add a prompting rule")
+var longAddPromptingRuleHelp = i18n.G(`
+The add-prompting-rule command adds a rule allowing or denying the requests of
+a snap matching the given path pattern and permissions, without prompting.
+
+In path patterns "*" matches any characters except "/" and "**" matches any
+number of directories.
+`)
+
+var shortRemovePromptingRuleHelp = i18n.G("Remove prompting rules")
+var longRemovePromptingRuleHelp = i18n.G(`
+The remove-prompting-rule command removes the prompting rules with the given
+IDs.
+`)
+
+var shortImportPromptingRulesHelp = i18n.G("Import prompting rules")
+var longImportPromptingRulesHelp = i18n.G(`
+The import-prompting-rules command adds the prompting rules from the given
+file, as exported by 'snap prompting-rules --json'. The imported rules are
+assigned new IDs and the expired ones are skipped.
+`)
+
+type cmdPromptingRules struct {
+	clientMixin
+	timeMixin
+	Snap      string `long:"snap"`
+	Interface string `long:"interface"`
+	JSON      bool   `long:"json"`
+}
+
+type cmdAddPromptingRule struct {
+	clientMixin
+	Snap        installedSnapName `long:"snap" required:"yes"`
+	Interface   string            `long:"interface" default:"home"`
+	Path        string            `long:"path" required:"yes"`
+	Permissions string            `long:"permissions" required:"yes"`
+	Outcome     string            `long:"outcome" required:"yes" choice:"allow" choice:"deny"`
+	Lifespan    string            `long:"lifespan" default:"forever" choice:"forever" choice:"timespan"`
+	Duration    string            `long:"duration"`
+}
+
+type cmdRemovePromptingRule struct {
+	clientMixin
+	Positional struct {
+		IDs []string `positional-arg-name:"<id>" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type cmdImportPromptingRules struct {
+	clientMixin
+	Replace    bool `long:"replace"`
+	Positional struct {
+		File flags.Filename `positional-arg-name:"<file>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func init() {
+	addCommand("prompting-rules", shortPromptingRulesHelp, longPromptingRulesHelp, func() flags.Commander { return &cmdPromptingRules{} }, timeDescs.also(map[string]string{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"snap": i18n.G("Only list the rules of the given snap"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"interface": i18n.G("Only list the rules of the given interface"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"json": i18n.G("Print the rules as JSON"),
+	}), nil)
+	addCommand("add-prompting-rule", shortAddPromptingRuleHelp, longAddPromptingRuleHelp, func() flags.Commander { return &cmdAddPromptingRule{} }, map[string]string{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"snap": i18n.G("The snap the rule applies to"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"interface": i18n.G("The interface the rule applies to"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"path": i18n.G("The path pattern the rule applies to"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"permissions": i18n.G("Comma separated list of the permissions the rule applies to"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"outcome": i18n.G("Whether to allow or deny the matching requests"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"lifespan": i18n.G("How long the rule remains in effect"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"duration": i18n.G("The duration of a rule with a timespan lifespan, e.g. 2h"),
+	}, nil)
+	addCommand("remove-prompting-rule", shortRemovePromptingRuleHelp, longRemovePromptingRuleHelp, func() flags.Commander { return &cmdRemovePromptingRule{} }, nil, []argDesc{{
+		// TRANSLATORS: This needs to begin with < and end with >
+		name: i18n.G("<id>"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		desc: i18n.G("The ID of the rule to remove"),
+	}})
+	addCommand("import-prompting-rules", shortImportPromptingRulesHelp, longImportPromptingRulesHelp, func() flags.Commander { return &cmdImportPromptingRules{} }, map[string]string{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"replace": i18n.G("Replace the existing rules"),
+	}, []argDesc{{
+		// TRANSLATORS: This needs to begin with < and end with >
+		name: i18n.G("<file>"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		desc: i18n.G("The file holding the rules"),
+	}})
+}
+
+func (x *cmdPromptingRules) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	rules, err := x.client.PromptingRules(&client.PromptingRulesOptions{
+		Snap:      x.Snap,
+		Interface: x.Interface,
+	})
+	if err != nil {
+		return err
+	}
+
+	if x.JSON {
+		if rules == nil {
+			rules = []*client.PromptingRule{}
+		}
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rules)
+	}
+
+	if len(rules) == 0 {
+		fmt.Fprintln(Stderr, i18n.G("No prompting rules."))
+		return nil
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("ID\tUser\tSnap\tInterface\tPath\tPermissions\tOutcome\tExpires"))
+	for _, rule := range rules {
+		expires := "-"
+		if !rule.Expiration.IsZero() {
+			expires = x.fmtTime(rule.Expiration)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", rule.ID, rule.User, rule.Snap, rule.Interface, rule.PathPattern, strings.Join(rule.Permissions, ","), rule.Outcome, expires)
+	}
+	return nil
+}
+
+func (x *cmdAddPromptingRule) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if x.Duration != "" && x.Lifespan != "timespan" {
+		return errors.New(i18n.G("--duration can only be used with --lifespan=timespan"))
+	}
+	if x.Duration == "" && x.Lifespan == "timespan" {
+		return errors.New(i18n.G("--lifespan=timespan requires --duration"))
+	}
+
+	rule, err := x.client.AddPromptingRule(&client.NewPromptingRule{
+		Snap:        string(x.Snap),
+		Interface:   x.Interface,
+		PathPattern: x.Path,
+		Permissions: strings.Split(x.Permissions, ","),
+		Outcome:     x.Outcome,
+		Lifespan:    x.Lifespan,
+		Duration:    x.Duration,
+	})
+	if err != nil {
+		return err
+	}
+	// TRANSLATORS: %s is the ID of the prompting rule
+	fmt.Fprintf(Stdout, i18n.G("Added prompting rule %s.\n"), rule.ID)
+	return nil
+}
+
+func (x *cmdRemovePromptingRule) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	for _, id := range x.Positional.IDs {
+		if _, err := x.client.RemovePromptingRule(id); err != nil {
+			return err
+		}
+		// TRANSLATORS: %s is the ID of the prompting rule
+		fmt.Fprintf(Stdout, i18n.G("Removed prompting rule %s.\n"), id)
+	}
+	return nil
+}
+
+func (x *cmdImportPromptingRules) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	data, err := ioutil.ReadFile(string(x.Positional.File))
+	if err != nil {
+		return err
+	}
+	var rules []*client.PromptingRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf(i18n.G("cannot decode prompting rules: %v"), err)
+	}
+	imported, err := x.client.ImportPromptingRules(rules, x.Replace)
+	if err != nil {
+		return err
+	}
+	// TRANSLATORS: %d is the number of imported prompting rules
+	fmt.Fprintf(Stdout, i18n.NG("Imported %d prompting rule.\n", "Imported %d prompting rules.\n", len(imported)), len(imported))
+	return nil
+}
@@ -0,0 +1,195 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+const mockPromptingRulesJSON = `{"type": "sync", "status-code": 200, "result": [{
+	"id": "0000000000000001",
+	"timestamp": "2021-06-01T10:00:00Z",
+	"user": 1000,
+	"snap": "foo",
+	"interface": "home",
+	"path-pattern": "/home/test/**",
+	"permissions": ["read", "write"],
+	"outcome": "allow",
+	"lifespan": "forever"
+}, {
+	"id": "0000000000000002",
+	"timestamp": "2021-06-01T10:00:00Z",
+	"user": 1000,
+	"snap": "bar",
+	"interface": "home",
+	"path-pattern": "/home/test/*",
+	"permissions": ["read"],
+	"outcome": "deny",
+	"lifespan": "timespan",
+	"expiration": "2021-06-01T11:00:00Z"
+}]}`
+
+func (s *SnapSuite) TestPromptingRules(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/interfaces/prompting/rules")
+		c.Check(r.URL.Query().Get("interface"), check.Equals, "home")
+		fmt.Fprintln(w, mockPromptingRulesJSON)
+	})
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"prompting-rules", "--interface=home", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `
+ID                User  Snap  Interface  Path           Permissions  Outcome  Expires
+0000000000000001  1000  foo   home       /home/test/**  read,write   allow    -
+0000000000000002  1000  bar   home       /home/test/*   read         deny     2021-06-01T11:00:00Z
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapSuite) TestPromptingRulesNone(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": []}`)
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"prompting-rules"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "")
+	c.Check(s.Stderr(), check.Equals, "No prompting rules.\n")
+}
+
+func (s *SnapSuite) TestPromptingRulesExportImport(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		switch n {
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			fmt.Fprintln(w, mockPromptingRulesJSON)
+		case 2:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/interfaces/prompting/rules")
+			body := DecodedRequestBody(c, r)
+			c.Check(body["action"], check.Equals, "import")
+			c.Check(body["replace"], check.Equals, true)
+			c.Assert(body["rules"], check.HasLen, 2)
+			c.Check(body["rules"].([]interface{})[1].(map[string]interface{})["path-pattern"], check.Equals, "/home/test/*")
+			fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": [{"id": "0000000000000003"}]}`)
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d", n)
+		}
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"prompting-rules", "--json"})
+	c.Assert(err, check.IsNil)
+	exported := filepath.Join(c.MkDir(), "rules.json")
+	c.Assert(ioutil.WriteFile(exported, []byte(s.Stdout()), 0644), check.IsNil)
+	s.ResetStdStreams()
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"import-prompting-rules", "--replace", exported})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "Imported 1 prompting rule.\n")
+	c.Check(n, check.Equals, 2)
+}
+
+func (s *SnapSuite) TestImportPromptingRulesInvalid(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request")
+	})
+	f := filepath.Join(c.MkDir(), "rules.json")
+	c.Assert(ioutil.WriteFile(f, []byte("{"), 0644), check.IsNil)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"import-prompting-rules", f})
+	c.Check(err, check.ErrorMatches, "cannot decode prompting rules: .*")
+}
+
+func (s *SnapSuite) TestAddPromptingRule(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/interfaces/prompting/rules")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action": "add",
+			"rule": map[string]interface{}{
+				"snap":         "foo",
+				"interface":    "home",
+				"path-pattern": "/home/test/**",
+				"permissions":  []interface{}{"read", "write"},
+				"outcome":      "allow",
+				"lifespan":     "timespan",
+				"duration":     "2h",
+			},
+		})
+		fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": {"id": "0000000000000004"}}`)
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"add-prompting-rule", "--snap=foo", "--path=/home/test/**", "--permissions=read,write", "--outcome=allow", "--lifespan=timespan", "--duration=2h"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "Added prompting rule 0000000000000004.\n")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapSuite) TestAddPromptingRuleDurationErrors(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request")
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"add-prompting-rule", "--snap=foo", "--path=/home/test/**", "--permissions=read", "--outcome=allow", "--duration=2h"})
+	c.Check(err, check.ErrorMatches, "--duration can only be used with --lifespan=timespan")
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"add-prompting-rule", "--snap=foo", "--path=/home/test/**", "--permissions=read", "--outcome=allow", "--lifespan=timespan"})
+	c.Check(err, check.ErrorMatches, "--lifespan=timespan requires --duration")
+}
+
+func (s *SnapSuite) TestRemovePromptingRule(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		switch n {
+		case 1:
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action": "remove",
+				"id":     "0000000000000001",
+			})
+			fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": {"id": "0000000000000001"}}`)
+		case 2:
+			fmt.Fprintln(w, `{"type": "error", "status-code": 404, "result": {"message": "cannot find prompting rule 0000000000000002"}}`)
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d", n)
+		}
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"remove-prompting-rule", "0000000000000001", "0000000000000002"})
+	c.Check(err, check.ErrorMatches, "cannot find prompting rule 0000000000000002")
+	c.Check(s.Stdout(), check.Equals, "Removed prompting rule 0000000000000001.\n")
+	c.Check(n, check.Equals, 2)
+}
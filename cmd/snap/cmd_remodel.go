@@ -36,11 +36,16 @@ revision or a full new model.
 
 In the process it applies any implied changes to the device: new required
 snaps, new kernel or gadget etc.
+
+With --offline the store is not used, any snap to install or update, together
+with its assertions, must then be provided in the tarball given with --bundle.
 `)
 )
 
 type cmdRemodel struct {
 	waitMixin
+	Offline        bool           `long:"offline"`
+	Bundle         flags.Filename `long:"bundle"`
 	RemodelOptions struct {
 		NewModelFile flags.Filename
 	} `positional-args:"true" required:"true"`
@@ -52,7 +57,12 @@ func init() {
 		longRemodelHelp,
 		func() flags.Commander {
 			return &cmdRemodel{}
-		}, nil, []argDesc{{
+		}, waitDescs.also(map[string]string{
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"offline": i18n.G("Remodel without using the store"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"bundle": i18n.G("Tarball with the snaps and assertions needed by the new model"),
+		}), []argDesc{{
 			// TRANSLATORS: This needs to begin with < and end with >
 			name: i18n.G("<new model file>"),
 			// TRANSLATORS: This should not start with a lowercase letter.
@@ -65,12 +75,20 @@ func (x *cmdRemodel) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
 	}
+	if x.Bundle != "" && !x.Offline {
+		return fmt.Errorf("%s", i18n.G("cannot use --bundle without --offline"))
+	}
 	newModelFile := x.RemodelOptions.NewModelFile
 	modelData, err := ioutil.ReadFile(string(newModelFile))
 	if err != nil {
 		return err
 	}
-	changeID, err := x.client.Remodel(modelData)
+	var changeID string
+	if x.Offline {
+		changeID, err = x.client.RemodelOffline(modelData, string(x.Bundle))
+	} else {
+		changeID, err = x.client.Remodel(modelData)
+	}
 	if err != nil {
 		return fmt.Errorf("cannot remodel: %v", err)
 	}
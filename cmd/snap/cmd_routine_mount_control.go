@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/osutil"
+)
+
+type cmdRoutineMountControl struct {
+	Unmount bool   `long:"unmount"`
+	Type    string `long:"type"`
+	Options string `long:"options"`
+	What    string `long:"what"`
+
+	Positional struct {
+		Base string `required:"yes"`
+		Path string `required:"yes"`
+	} `positional-args:"yes"`
+}
+
+var shortRoutineMountControlHelp = i18n.G("Mount or unmount a persistent mount-control mount")
+var longRoutineMountControlHelp = i18n.G(`
+The mount-control command mounts or unmounts the filesystem of a persistent
+mount declared by a mount-control plug.
+
+This command is used by the mount units of the mount-control interface. The
+mount point is the given path below the given base directory. It is resolved
+without following symbolic links, and its missing directories are created
+below the base directory.
+
+Credentials of a mount are provisioned by the administrator as a file only
+readable by root in /var/lib/snapd/mount-credentials/<snap>/, the directory
+is created on the first mount attempt.
+`)
+
+func init() {
+	c := addRoutineCommand("mount-control", shortRoutineMountControlHelp, longRoutineMountControlHelp, func() flags.Commander {
+		return &cmdRoutineMountControl{}
+	}, map[string]string{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"unmount": i18n.G("Unmount the filesystem instead of mounting it"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"type": i18n.G("Type of the filesystem to mount"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"options": i18n.G("Comma separated options of the mount"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"what": i18n.G("Device or remote filesystem to mount"),
+	}, []argDesc{
+		{
+			// TRANSLATORS: This needs to begin with < and end with >
+			name: i18n.G("<base>"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			desc: i18n.G("Existing directory the mount point is relative to"),
+		},
+		{
+			// TRANSLATORS: This needs to begin with < and end with >
+			name: i18n.G("<path>"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			desc: i18n.G("Path of the mount point below the base directory"),
+		},
+	})
+	c.hidden = true
+}
+
+func (x *cmdRoutineMountControl) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	base := x.Positional.Base
+	path := x.Positional.Path
+	if !filepath.IsAbs(base) || filepath.Clean(base) != base {
+		return fmt.Errorf("cannot use %q as base directory: not a clean absolute path", base)
+	}
+	if filepath.IsAbs(path) || filepath.Clean(path) != path || path == "." || strings.HasPrefix(path, "../") || path == ".." {
+		return fmt.Errorf("cannot use %q as mount point: not a clean relative path", path)
+	}
+
+	if x.Unmount {
+		fd, err := openDirNoFollow(base, path, false)
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(fd)
+		if err := syscallUnmount(procSelfFd(fd), 0); err != nil {
+			return fmt.Errorf("cannot unmount %s: %v", filepath.Join(base, path), err)
+		}
+		return nil
+	}
+
+	if x.Type == "" || x.What == "" {
+		return fmt.Errorf("cannot mount without a filesystem type and source")
+	}
+	if err := checkMountCredentials(x.Options); err != nil {
+		return err
+	}
+	fd, err := openDirNoFollow(base, path, true)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(base, path))
+	defer f.Close()
+
+	// the mount point is passed as the descriptor inherited by mount, which
+	// is told not to canonicalize it, so that the directory that was
+	// resolved here is the one that gets mounted on
+	mountArgs := []string{"--no-canonicalize", "-t", x.Type}
+	if x.Options != "" {
+		mountArgs = append(mountArgs, "-o", x.Options)
+	}
+	mountArgs = append(mountArgs, "--", x.What, procSelfFd(3))
+	cmd := exec.Command("mount", mountArgs...)
+	cmd.ExtraFiles = []*os.File{f}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot mount %s on %s: %v", x.What, f.Name(), osutil.OutputErr(output, err))
+	}
+	return nil
+}
+
+// checkMountCredentials checks that the credentials file used by the mount
+// options, if any, is provisioned in the per-snap credentials directory, which
+// is created if needed, and that it is only accessible by root.
+func checkMountCredentials(options string) error {
+	var credentials string
+	for _, option := range strings.Split(options, ",") {
+		if strings.HasPrefix(option, "credentials=") {
+			credentials = strings.TrimPrefix(option, "credentials=")
+		}
+	}
+	if credentials == "" {
+		return nil
+	}
+	dir := filepath.Dir(credentials)
+	if filepath.Clean(credentials) != credentials || filepath.Dir(dir) != dirs.SnapMountCredentialsDir {
+		return fmt.Errorf("cannot use credentials %s: not in a directory of %s", credentials, dirs.SnapMountCredentialsDir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create credentials directory: %v", err)
+	}
+	fi, err := os.Lstat(credentials)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("cannot mount with credentials: %s has not been provisioned", credentials)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot mount with credentials: %v", err)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.Mode().IsRegular() || !ok || st.Uid != 0 || fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("cannot mount with credentials: %s must be a regular file owned and only accessible by root", credentials)
+	}
+	return nil
+}
+
+func procSelfFd(fd int) string {
+	return fmt.Sprintf("/proc/self/fd/%d", fd)
+}
+
+// openDirNoFollow returns an O_PATH descriptor of the directory at the given
+// relative path below the given base directory. No symbolic link is followed
+// while walking the path, so that a directory writable by a snap cannot
+// redirect the walk elsewhere. When create is set the missing directories
+// below the base directory are created.
+func openDirNoFollow(base, path string, create bool) (int, error) {
+	const openFlags = unix.O_PATH | unix.O_NOFOLLOW | unix.O_DIRECTORY | unix.O_CLOEXEC
+
+	fd, err := syscall.Open("/", openFlags, 0)
+	if err != nil {
+		return -1, fmt.Errorf("cannot open /: %v", err)
+	}
+	walk := func(name string, mayCreate bool) error {
+		next, err := syscall.Openat(fd, name, openFlags, 0)
+		if err == syscall.ENOENT && mayCreate {
+			if err := syscall.Mkdirat(fd, name, 0755); err != nil && err != syscall.EEXIST {
+				return err
+			}
+			next, err = syscall.Openat(fd, name, openFlags, 0)
+		}
+		if err != nil {
+			return err
+		}
+		syscall.Close(fd)
+		fd = next
+		return nil
+	}
+
+	for _, name := range strings.Split(strings.TrimPrefix(base, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if err := walk(name, false); err != nil {
+			syscall.Close(fd)
+			return -1, fmt.Errorf("cannot open base directory %s: %v", base, err)
+		}
+	}
+	for _, name := range strings.Split(path, "/") {
+		if err := walk(name, create); err != nil {
+			syscall.Close(fd)
+			return -1, fmt.Errorf("cannot open mount point %s: %v", filepath.Join(base, path), err)
+		}
+	}
+	return fd, nil
+}
@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *SnapSuite) TestRoutineMountControlMount(c *C) {
+	base := c.MkDir()
+	// the mount helper checks that it got the resolved mount point
+	mountCmd := testutil.MockCommand(c, "mount", `test "$(readlink /proc/self/fd/3)" = "$MOUNT_POINT"`)
+	defer mountCmd.Restore()
+	os.Setenv("MOUNT_POINT", filepath.Join(base, "nas/share"))
+	defer os.Unsetenv("MOUNT_POINT")
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=cifs", "--options=nosuid,nodev,ro", "--what=//nas.local/share", base, "nas/share"})
+	c.Assert(err, IsNil)
+	c.Check(osutil.IsDirectory(filepath.Join(base, "nas/share")), Equals, true)
+	c.Check(mountCmd.Calls(), DeepEquals, [][]string{
+		{"mount", "--no-canonicalize", "-t", "cifs", "-o", "nosuid,nodev,ro", "--", "//nas.local/share", "/proc/self/fd/3"},
+	})
+}
+
+func (s *SnapSuite) TestRoutineMountControlMountRefusesSymlinks(c *C) {
+	base := c.MkDir()
+	elsewhere := c.MkDir()
+	c.Assert(os.Symlink(elsewhere, filepath.Join(base, "nas")), IsNil)
+	mountCmd := testutil.MockCommand(c, "mount", "")
+	defer mountCmd.Restore()
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=cifs", "--what=//nas.local/share", base, "nas/share"})
+	c.Assert(err, ErrorMatches, `cannot open mount point .*/nas/share: not a directory`)
+	c.Check(osutil.FileExists(filepath.Join(elsewhere, "share")), Equals, false)
+	c.Check(mountCmd.Calls(), HasLen, 0)
+}
+
+func (s *SnapSuite) TestRoutineMountControlMountError(c *C) {
+	base := c.MkDir()
+	mountCmd := testutil.MockCommand(c, "mount", "echo permission denied; exit 32")
+	defer mountCmd.Restore()
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=nfs", "--what=nas:/share", base, "nas"})
+	c.Assert(err, ErrorMatches, `cannot mount nas:/share on .*/nas: permission denied`)
+}
+
+func (s *SnapSuite) TestRoutineMountControlMountCredentials(c *C) {
+	if os.Geteuid() != 0 {
+		c.Skip("credentials must be owned by root")
+	}
+	base := c.MkDir()
+	mountCmd := testutil.MockCommand(c, "mount", "")
+	defer mountCmd.Restore()
+	credentials := filepath.Join(dirs.SnapMountCredentialsDir, "consumer", "nas")
+	options := "--options=nosuid,nodev,credentials=" + credentials
+
+	// the credentials directory is created for the administrator
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=cifs", options, "--what=//nas.local/share", base, "nas"})
+	c.Assert(err, ErrorMatches, `cannot mount with credentials: .*/mount-credentials/consumer/nas has not been provisioned`)
+	fi, err := os.Stat(filepath.Dir(credentials))
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0700))
+	c.Check(mountCmd.Calls(), HasLen, 0)
+
+	c.Assert(ioutil.WriteFile(credentials, []byte("password=secret"), 0644), IsNil)
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=cifs", options, "--what=//nas.local/share", base, "nas"})
+	c.Assert(err, ErrorMatches, `cannot mount with credentials: .*/nas must be a regular file owned and only accessible by root`)
+	c.Check(mountCmd.Calls(), HasLen, 0)
+
+	c.Assert(os.Chmod(credentials, 0600), IsNil)
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=cifs", options, "--what=//nas.local/share", base, "nas"})
+	c.Assert(err, IsNil)
+	c.Check(mountCmd.Calls(), DeepEquals, [][]string{
+		{"mount", "--no-canonicalize", "-t", "cifs", "-o", "nosuid,nodev,credentials=" + credentials, "--", "//nas.local/share", "/proc/self/fd/3"},
+	})
+}
+
+func (s *SnapSuite) TestRoutineMountControlMountCredentialsOutsideOfDir(c *C) {
+	mountCmd := testutil.MockCommand(c, "mount", "")
+	defer mountCmd.Restore()
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control",
+		"--type=cifs", "--options=credentials=/etc/shadow", "--what=//nas.local/share", c.MkDir(), "nas"})
+	c.Assert(err, ErrorMatches, `cannot use credentials /etc/shadow: not in a directory of .*/mount-credentials`)
+	c.Check(mountCmd.Calls(), HasLen, 0)
+}
+
+func (s *SnapSuite) TestRoutineMountControlUnmount(c *C) {
+	base := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(base, "nas"), 0755), IsNil)
+	var umounts []string
+	restore := snap.MockSyscallUmount(func(p string, flags int) error {
+		c.Check(flags, Equals, 0)
+		c.Check(strings.HasPrefix(p, "/proc/self/fd/"), Equals, true)
+		target, err := os.Readlink(p)
+		c.Assert(err, IsNil)
+		umounts = append(umounts, target)
+		return nil
+	})
+	defer restore()
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control", "--unmount", base, "nas"})
+	c.Assert(err, IsNil)
+	c.Check(umounts, DeepEquals, []string{filepath.Join(base, "nas")})
+}
+
+func (s *SnapSuite) TestRoutineMountControlUnmountRefusesSymlinks(c *C) {
+	base := c.MkDir()
+	c.Assert(os.Symlink(c.MkDir(), filepath.Join(base, "nas")), IsNil)
+	restore := snap.MockSyscallUmount(func(p string, flags int) error {
+		c.Fatalf("unexpected unmount of %s", p)
+		return nil
+	})
+	defer restore()
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control", "--unmount", base, "nas"})
+	c.Assert(err, ErrorMatches, `cannot open mount point .*/nas: not a directory`)
+}
+
+func (s *SnapSuite) TestRoutineMountControlInvalidPaths(c *C) {
+	for _, tc := range []struct {
+		base, path, err string
+	}{
+		{"relative", "nas", `cannot use "relative" as base directory: not a clean absolute path`},
+		{"/var/snap/../etc", "nas", `cannot use "/var/snap/../etc" as base directory: not a clean absolute path`},
+		{"/var/snap/foo/common", "/nas", `cannot use "/nas" as mount point: not a clean relative path`},
+		{"/var/snap/foo/common", "../nas", `cannot use "../nas" as mount point: not a clean relative path`},
+		{"/var/snap/foo/common", "nas/../../x", `cannot use "nas/../../x" as mount point: not a clean relative path`},
+	} {
+		_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "mount-control", "--unmount", tc.base, tc.path})
+		c.Check(err, ErrorMatches, tc.err, Commentf("%v", tc))
+	}
+}
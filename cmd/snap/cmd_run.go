@@ -27,6 +27,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"regexp"
@@ -38,9 +39,12 @@ import (
 	"github.com/godbus/dbus"
 	"github.com/jessevdk/go-flags"
 
+	"github.com/snapcore/snapd/audit"
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
 	"github.com/snapcore/snapd/dbusutil"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/logger"
@@ -63,6 +67,8 @@ var (
 	selinuxIsEnabled         = selinux.IsEnabled
 	selinuxVerifyPathContext = selinux.VerifyPathContext
 	selinuxRestoreContext    = selinux.RestoreContext
+
+	runinhibitWaitWhileInhibited = runinhibit.WaitWhileInhibited
 )
 
 type cmdRun struct {
@@ -355,6 +361,26 @@ func createOrUpdateUserDataSymlink(info *snap.Info, usr *user.User) error {
 	return nil
 }
 
+// snapd assigns the [ug]ids of the system usernames of snaps from this range,
+// see overlord/snapstate/check_snap.go
+const (
+	snapSystemUsersMinUid = 524288
+	snapSystemUsersMaxUid = 589823
+)
+
+// isSnapSystemUser returns whether the user is one of the system usernames
+// required by the snap or any other one created by snapd for snaps.
+func isSnapSystemUser(info *snap.Info, usr *user.User) bool {
+	if _, ok := info.SystemUsernames[usr.Username]; ok {
+		return true
+	}
+	uid, err := strconv.ParseUint(usr.Uid, 10, 32)
+	if err != nil {
+		return false
+	}
+	return uid >= snapSystemUsersMinUid && uid <= snapSystemUsersMaxUid
+}
+
 func createUserDataDirs(info *snap.Info) error {
 	// Adjust umask so that the created directories have the permissions we
 	// expect and are unaffected by the initial umask. While go runtime creates
@@ -368,6 +394,11 @@ func createUserDataDirs(info *snap.Info) error {
 	if err != nil {
 		return fmt.Errorf(i18n.G("cannot get the current user: %v"), err)
 	}
+	if isSnapSystemUser(info, usr) {
+		// system users, like the ones services of snaps run as, have
+		// no home directory
+		return nil
+	}
 
 	// see snapenv.User
 	instanceUserData := info.UserDataDir(usr.HomeDir)
@@ -451,6 +482,14 @@ func (x *cmdRun) straceOpts() (opts []string, raw bool, err error) {
 
 func (x *cmdRun) snapRunApp(snapApp string, args []string) error {
 	snapName, appName := snap.SplitSnapApp(snapApp)
+	// the inhibition lock is only taken by refreshes when refresh app
+	// awareness is enabled, wait for it before looking at the current
+	// revision as the refresh changes it
+	if features.RefreshAppAwareness.IsEnabled() {
+		if err := waitWhileInhibited(snapName); err != nil {
+			return err
+		}
+	}
 	info, err := getSnapInfo(snapName, snap.R(0))
 	if err != nil {
 		return err
@@ -464,6 +503,16 @@ func (x *cmdRun) snapRunApp(snapApp string, args []string) error {
 	return x.runSnapConfine(info, app.SecurityTag(), snapApp, "", args)
 }
 
+// waitWhileInhibited waits until the given snap can be run, telling the user
+// why it cannot be run right away.
+func waitWhileInhibited(snapName string) error {
+	return runinhibitWaitWhileInhibited(snapName, func(hint runinhibit.Hint) error {
+		// refreshes are the only reason for inhibition at the moment
+		fmt.Fprintf(Stderr, i18n.G("snap %q is being refreshed, waiting for the refresh to complete...\n"), snapName)
+		return nil
+	})
+}
+
 func (x *cmdRun) snapRunHook(snapName string) error {
 	revision, err := snap.ParseRevision(x.Revision)
 	if err != nil {
@@ -1154,10 +1203,120 @@ func (x *cmdRun) runSnapConfine(info *snap.Info, securityTag, snapApp, hook stri
 		return x.runCmdUnderGdbserver(cmd, envForExec)
 	} else if x.useStrace() {
 		return x.runCmdUnderStrace(cmd, envForExec)
+	} else if features.SnapRunAudit.IsEnabled() {
+		if !needsTracking {
+			// services are supervised by systemd, which expects
+			// the process it started to be the service for
+			// notify and forking services, so they are exec'ed
+			// and their outcome is left to systemd
+			recordSnapRunAudit(info, appName, hook, args, &audit.SnapRunRecord{Service: true})
+			return syscallExec(cmd[0], cmd, envForExec(nil))
+		}
+		return x.runCmdWithAudit(info, appName, hook, args, cmd, envForExec)
 	} else {
 		return syscallExec(cmd[0], cmd, envForExec(nil))
 	}
 }
 
+var auditRecordSnapRun = audit.RecordSnapRun
+
+// auditForwardedSignals are the signals that are forwarded to the
+// application run with auditing, as it would have received them had snap
+// run exec'ed it. SIGKILL and SIGSTOP cannot be caught, and the signals
+// about the child itself are left alone.
+var auditForwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGTSTP,
+	syscall.SIGCONT,
+	syscall.SIGWINCH,
+}
+
+// forwardAuditSignal forwards the signal to the application, stopping snap
+// run as well on SIGTSTP so that the job is seen as stopped by the shell.
+func forwardAuditSignal(proc *os.Process, sig os.Signal) {
+	proc.Signal(sig)
+	if sig == syscall.SIGTSTP {
+		syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+	}
+}
+
+// runCmdWithAudit runs the command as a child process, instead of exec'ing
+// it, so that the invocation, its outcome and its duration can be recorded.
+func (x *cmdRun) runCmdWithAudit(info *snap.Info, appName, hook string, args, origCmd []string, envForExec envForExecFunc) error {
+	cmd := exec.Command(origCmd[0], origCmd[1:]...)
+	cmd.Stdin = Stdin
+	cmd.Stdout = Stdout
+	cmd.Stderr = Stderr
+	cmd.Env = envForExec(nil)
+
+	// the application gets the signals meant for it
+	sigCh := make(chan os.Signal, 10)
+	signal.Notify(sigCh, auditForwardedSignals...)
+	defer signal.Stop(sigCh)
+
+	start := timeNow()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range sigCh {
+			forwardAuditSignal(cmd.Process, sig)
+		}
+	}()
+	err := cmd.Wait()
+	duration := timeNow().Sub(start)
+
+	exitCode := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return err
+		}
+		ws := exitErr.Sys().(syscall.WaitStatus)
+		if ws.Signaled() {
+			exitCode = 128 + int(ws.Signal())
+		} else {
+			exitCode = ws.ExitStatus()
+		}
+	}
+
+	recordSnapRunAudit(info, appName, hook, args, &audit.SnapRunRecord{
+		Time:     start,
+		ExitCode: exitCode,
+		Duration: duration,
+	})
+
+	if exitCode != 0 {
+		panic(&exitStatus{exitCode})
+	}
+	return nil
+}
+
+// recordSnapRunAudit completes the audit record of the invocation and
+// records it, failing to do so does not prevent running the application.
+func recordSnapRunAudit(info *snap.Info, appName, hook string, args []string, rec *audit.SnapRunRecord) {
+	if rec.Time.IsZero() {
+		rec.Time = timeNow()
+	}
+	rec.Snap = info.InstanceName()
+	rec.Hook = hook
+	if hook == "" {
+		rec.App = appName
+	}
+	rec.UID = os.Getuid()
+	if u, err := userCurrent(); err == nil {
+		rec.User = u.Username
+	}
+	rec.CmdlineHash = audit.CmdlineHash(args)
+	if err := auditRecordSnapRun(rec); err != nil {
+		logger.Noticef("WARNING: cannot record snap run invocation: %v", err)
+	}
+}
+
 var cgroupCreateTransientScopeForTracking = cgroup.CreateTransientScopeForTracking
 var cgroupConfirmSystemdServiceTracking = cgroup.ConfirmSystemdServiceTracking
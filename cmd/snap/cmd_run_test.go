@@ -22,6 +22,7 @@ package main_test
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -30,8 +31,11 @@ import (
 
 	"gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/audit"
 	snaprun "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/sandbox/cgroup"
@@ -204,6 +208,98 @@ func (s *RunSuite) TestSnapRunAppIntegration(c *check.C) {
 	c.Check(execEnv, testutil.Contains, fmt.Sprintf("TMPDIR=%s", tmpdir))
 }
 
+func (s *RunSuite) TestSnapRunAppWaitsWhileInhibited(c *check.C) {
+	defer mockSnapConfine(dirs.DistroLibExecDir)()
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(features.RefreshAppAwareness.ControlFile(), nil, 0644), check.IsNil)
+
+	snaptest.MockSnapCurrent(c, string(mockYaml), &snap.SideInfo{
+		Revision: snap.R("x2"),
+	})
+	// the revision the refresh installs
+	snaptest.MockSnap(c, string(mockYaml), &snap.SideInfo{
+		Revision: snap.R("x3"),
+	})
+
+	var calls []string
+	restorer := snaprun.MockRuninhibitWaitWhileInhibited(func(snapName string, inhibited func(hint runinhibit.Hint) error) error {
+		calls = append(calls, "wait "+snapName)
+		c.Assert(inhibited(runinhibit.HintInhibitedForRefresh), check.IsNil)
+		// the refresh completes while waiting
+		current := filepath.Join(dirs.SnapMountDir, "snapname", "current")
+		c.Assert(os.Remove(current), check.IsNil)
+		c.Assert(os.Symlink("x3", current), check.IsNil)
+		return nil
+	})
+	defer restorer()
+
+	var execEnv []string
+	restorer = snaprun.MockSyscallExec(func(arg0 string, args []string, envv []string) error {
+		calls = append(calls, "exec")
+		execEnv = envv
+		return nil
+	})
+	defer restorer()
+
+	_, err := snaprun.Parser(snaprun.Client()).ParseArgs([]string{"run", "--", "snapname.app", "--arg1", "arg2"})
+	c.Assert(err, check.IsNil)
+	c.Check(calls, check.DeepEquals, []string{"wait snapname", "exec"})
+	c.Check(s.Stderr(), check.Equals, "snap \"snapname\" is being refreshed, waiting for the refresh to complete...\n")
+	// the revision current after the refresh is run
+	c.Check(execEnv, testutil.Contains, "SNAP_REVISION=x3")
+}
+
+func (s *RunSuite) TestSnapRunAppWaitWhileInhibitedError(c *check.C) {
+	defer mockSnapConfine(dirs.DistroLibExecDir)()
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(features.RefreshAppAwareness.ControlFile(), nil, 0644), check.IsNil)
+
+	restorer := snaprun.MockRuninhibitWaitWhileInhibited(func(snapName string, inhibited func(hint runinhibit.Hint) error) error {
+		return errors.New("boom")
+	})
+	defer restorer()
+
+	snaptest.MockSnapCurrent(c, string(mockYaml), &snap.SideInfo{
+		Revision: snap.R("x2"),
+	})
+
+	restorer = snaprun.MockSyscallExec(func(arg0 string, args []string, envv []string) error {
+		c.Fatalf("unexpected exec")
+		return nil
+	})
+	defer restorer()
+
+	_, err := snaprun.Parser(snaprun.Client()).ParseArgs([]string{"run", "--", "snapname.app"})
+	c.Assert(err, check.ErrorMatches, "boom")
+}
+
+func (s *RunSuite) TestSnapRunAppNoWaitWithoutRefreshAppAwareness(c *check.C) {
+	defer mockSnapConfine(dirs.DistroLibExecDir)()
+
+	restorer := snaprun.MockRuninhibitWaitWhileInhibited(func(snapName string, inhibited func(hint runinhibit.Hint) error) error {
+		c.Fatalf("unexpected wait")
+		return nil
+	})
+	defer restorer()
+
+	snaptest.MockSnapCurrent(c, string(mockYaml), &snap.SideInfo{
+		Revision: snap.R("x2"),
+	})
+
+	execCalled := false
+	restorer = snaprun.MockSyscallExec(func(arg0 string, args []string, envv []string) error {
+		execCalled = true
+		return nil
+	})
+	defer restorer()
+
+	_, err := snaprun.Parser(snaprun.Client()).ParseArgs([]string{"run", "--", "snapname.app"})
+	c.Assert(err, check.IsNil)
+	c.Check(execCalled, check.Equals, true)
+}
+
 func (s *RunSuite) TestSnapRunClassicAppIntegration(c *check.C) {
 	defer mockSnapConfine(dirs.DistroLibExecDir)()
 
@@ -354,6 +450,49 @@ func (s *RunSuite) TestSnapRunCreateDataDirs(c *check.C) {
 	c.Check(osutil.FileExists(filepath.Join(s.fakeHome, "/snap/snapname/common")), check.Equals, true)
 }
 
+func (s *RunSuite) TestSnapRunCreateDataDirsSystemUser(c *check.C) {
+	for _, usr := range []*user.User{
+		// one of the system usernames of the snap
+		{Username: "snap_snapname", Uid: "1234", HomeDir: s.fakeHome},
+		// any other user from the range of snapd
+		{Username: "snap_daemon", Uid: "584788", HomeDir: s.fakeHome},
+		{Username: "snap_other", Uid: "585290", HomeDir: s.fakeHome},
+	} {
+		restore := snaprun.MockUserCurrent(func() (*user.User, error) {
+			return usr, nil
+		})
+		defer restore()
+
+		info, err := snap.InfoFromSnapYaml([]byte(string(mockYaml) + `
+system-usernames:
+  snap_snapname: private
+`))
+		c.Assert(err, check.IsNil)
+		info.SideInfo.Revision = snap.R(42)
+
+		// nothing is created
+		err = snaprun.CreateUserDataDirs(info)
+		c.Assert(err, check.IsNil)
+		c.Check(osutil.IsDirectory(filepath.Join(s.fakeHome, "/snap/snapname")), check.Equals, false, check.Commentf("%s", usr.Username))
+	}
+}
+
+func (s *RunSuite) TestSnapRunCreateDataDirsOtherUser(c *check.C) {
+	restore := snaprun.MockUserCurrent(func() (*user.User, error) {
+		return &user.User{Username: "nobody", Uid: "65534", HomeDir: filepath.Join(s.fakeHome, "nonexistent")}, nil
+	})
+	defer restore()
+
+	info, err := snap.InfoFromSnapYaml(mockYaml)
+	c.Assert(err, check.IsNil)
+	info.SideInfo.Revision = snap.R(42)
+
+	// the directories are created for users outside of the range of snapd
+	err = snaprun.CreateUserDataDirs(info)
+	c.Assert(err, check.IsNil)
+	c.Check(osutil.IsDirectory(filepath.Join(s.fakeHome, "nonexistent/snap/snapname/42")), check.Equals, true)
+}
+
 func (s *RunSuite) TestParallelInstanceSnapRunCreateDataDirs(c *check.C) {
 	info, err := snap.InfoFromSnapYaml(mockYaml)
 	c.Assert(err, check.IsNil)
@@ -1055,6 +1194,108 @@ func (s *RunSuite) TestRunCmdWithTraceExecUnhappy(c *check.C) {
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+func (s *RunSuite) TestSnapRunAppWithAudit(c *check.C) {
+	defer mockSnapConfine(dirs.DistroLibExecDir)()
+	// the application is run as a child and fails
+	snapConfine := filepath.Join(dirs.DistroLibExecDir, "snap-confine")
+	err := ioutil.WriteFile(snapConfine, []byte("#!/bin/sh\necho \"$@\"\nexit 3\n"), 0755)
+	c.Assert(err, check.IsNil)
+	c.Assert(os.Chmod(snapConfine, 0755), check.IsNil)
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(features.SnapRunAudit.ControlFile(), nil, 0644), check.IsNil)
+
+	snaptest.MockSnapCurrent(c, string(mockYaml), &snap.SideInfo{
+		Revision: snap.R("x2"),
+	})
+
+	restorer := snaprun.MockSyscallExec(func(arg0 string, args []string, envv []string) error {
+		c.Fatalf("unexpected exec")
+		return nil
+	})
+	defer restorer()
+
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	n := 0
+	restorer = snaprun.MockTimeNow(func() time.Time {
+		n++
+		return t0.Add(time.Duration(n) * time.Second)
+	})
+	defer restorer()
+
+	var recs []*audit.SnapRunRecord
+	restorer = snaprun.MockAuditRecordSnapRun(func(rec *audit.SnapRunRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	defer restorer()
+
+	c.Check(func() {
+		_, err := snaprun.Parser(snaprun.Client()).ParseArgs([]string{"run", "--", "snapname.app", "--arg1", "arg2"})
+		c.Check(err, check.IsNil)
+	}, check.PanicMatches, `internal error: exitStatus\{3\} .*`)
+	c.Check(s.Stdout(), check.Equals, fmt.Sprintf("snap.snapname.app %s snapname.app --arg1 arg2\n", filepath.Join(dirs.CoreLibExecDir, "snap-exec")))
+
+	c.Assert(recs, check.HasLen, 1)
+	c.Check(recs[0].Snap, check.Equals, "snapname")
+	c.Check(recs[0].App, check.Equals, "app")
+	c.Check(recs[0].Hook, check.Equals, "")
+	c.Check(recs[0].UID, check.Equals, os.Getuid())
+	c.Check(recs[0].CmdlineHash, check.Equals, audit.CmdlineHash([]string{"--arg1", "arg2"}))
+	c.Check(recs[0].ExitCode, check.Equals, 3)
+	c.Check(recs[0].Time.Before(t0.Add(3*time.Second)), check.Equals, true)
+	c.Check(recs[0].Duration > 0, check.Equals, true)
+	c.Check(recs[0].Service, check.Equals, false)
+}
+
+func (s *RunSuite) TestSnapRunServiceWithAudit(c *check.C) {
+	defer mockSnapConfine(dirs.DistroLibExecDir)()
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(features.SnapRunAudit.ControlFile(), nil, 0644), check.IsNil)
+
+	snaptest.MockSnapCurrent(c, string(mockYaml), &snap.SideInfo{
+		Revision: snap.R("x2"),
+	})
+
+	restorer := snaprun.MockConfirmSystemdServiceTracking(func(securityTag string) error {
+		return nil
+	})
+	defer restorer()
+
+	var recs []*audit.SnapRunRecord
+	restorer = snaprun.MockAuditRecordSnapRun(func(rec *audit.SnapRunRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	defer restorer()
+
+	// services are exec'ed, systemd expects the process it started to be
+	// the service
+	execCalled := 0
+	restorer = snaprun.MockSyscallExec(func(arg0 string, args []string, envv []string) error {
+		execCalled++
+		// recorded before exec'ing
+		c.Check(recs, check.HasLen, 1)
+		return nil
+	})
+	defer restorer()
+
+	_, err := snaprun.Parser(snaprun.Client()).ParseArgs([]string{"run", "--", "snapname.svc", "--arg1", "arg2"})
+	c.Assert(err, check.IsNil)
+	c.Check(execCalled, check.Equals, 1)
+
+	c.Assert(recs, check.HasLen, 1)
+	c.Check(recs[0].Snap, check.Equals, "snapname")
+	c.Check(recs[0].App, check.Equals, "svc")
+	c.Check(recs[0].UID, check.Equals, os.Getuid())
+	c.Check(recs[0].CmdlineHash, check.Equals, audit.CmdlineHash([]string{"--arg1", "arg2"}))
+	c.Check(recs[0].Time.IsZero(), check.Equals, false)
+	c.Check(recs[0].Service, check.Equals, true)
+	c.Check(recs[0].ExitCode, check.Equals, 0)
+	c.Check(recs[0].Duration, check.Equals, time.Duration(0))
+}
+
 func (s *RunSuite) TestSnapRunRestoreSecurityContextHappy(c *check.C) {
 	logbuf, restorer := logger.MockLogger()
 	defer restorer()
@@ -20,6 +20,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 
@@ -32,6 +33,8 @@ import (
 
 type svcStatus struct {
 	clientMixin
+	JSON       bool `long:"json"`
+	Watch      bool `long:"watch"`
 	Positional struct {
 		ServiceNames []serviceName
 	} `positional-args:"yes"`
@@ -51,6 +54,9 @@ var (
 	longServicesHelp  = i18n.G(`
 The services command lists information about the services specified, or about
 the services in all currently installed snaps.
+
+With --watch, the information is listed again whenever the status of the
+services changes, until interrupted.
 `)
 	shortLogsHelp = i18n.G("Retrieve logs for services")
 	longLogsHelp  = i18n.G(`
@@ -81,7 +87,13 @@ func init() {
 		// TRANSLATORS: This should not start with a lowercase letter.
 		desc: i18n.G("A service specification, which can be just a snap name (for all services in the snap), or <snap>.<app> for a single service."),
 	}}
-	addCommand("services", shortServicesHelp, longServicesHelp, func() flags.Commander { return &svcStatus{} }, nil, argdescs)
+	addCommand("services", shortServicesHelp, longServicesHelp, func() flags.Commander { return &svcStatus{} },
+		map[string]string{
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"json": i18n.G("Print the status of the services as JSON, one line per update with --watch"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"watch": i18n.G("Keep printing the status of the services whenever it changes"),
+		}, argdescs)
 	addCommand("logs", shortLogsHelp, longLogsHelp, func() flags.Commander { return &svcLogs{} },
 		map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
@@ -120,16 +132,40 @@ func (s *svcStatus) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
+	if s.Watch {
+		updates, err := s.client.WatchServices(svcNames(s.Positional.ServiceNames))
+		if err != nil {
+			return err
+		}
+		for services := range updates {
+			if err := s.showStatus(services); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	services, err := s.client.Apps(svcNames(s.Positional.ServiceNames), client.AppOptions{Service: true})
 	if err != nil {
 		return err
 	}
 
-	if len(services) == 0 {
+	if len(services) == 0 && !s.JSON {
 		fmt.Fprintln(Stderr, i18n.G("There are no services provided by installed snaps."))
 		return nil
 	}
 
+	return s.showStatus(services)
+}
+
+func (s *svcStatus) showStatus(services []*client.AppInfo) error {
+	if s.JSON {
+		if services == nil {
+			services = []*client.AppInfo{}
+		}
+		return json.NewEncoder(Stdout).Encode(services)
+	}
+
 	w := tabWriter()
 	defer w.Flush()
 
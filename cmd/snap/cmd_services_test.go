@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
@@ -219,6 +220,69 @@ foo.zed  enabled  active    -
 	c.Check(n, check.Equals, 1)
 }
 
+func (s *appOpSuite) TestAppStatusJSON(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/apps")
+		c.Check(r.URL.Query(), check.HasLen, 1)
+		c.Check(r.URL.Query().Get("select"), check.Equals, "service")
+		w.WriteHeader(200)
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]interface{}{
+			"type": "sync",
+			"result": []map[string]interface{}{
+				{"snap": "foo", "name": "bar", "daemon": "simple", "active": true, "enabled": true},
+			},
+			"status":      "OK",
+			"status-code": 200,
+		})
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"services", "--json"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `[{"snap":"foo","name":"bar","daemon":"simple","enabled":true,"active":true}]`+"\n")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *appOpSuite) TestAppStatusWatch(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/events")
+		c.Check(r.URL.Query(), check.DeepEquals, url.Values{
+			"names": []string{"foo"},
+			"types": []string{"service-status"},
+		})
+		c.Check(r.Method, check.Equals, "GET")
+		w.Header().Set("Content-Type", "application/json-seq")
+		w.WriteHeader(200)
+		fmt.Fprintln(w, "\x1e"+`{"type":"service-status","services":[{"snap":"foo","name":"bar","daemon":"simple","enabled":true}]}`)
+		fmt.Fprintln(w, "\x1e"+`{"type":"service-status","services":[{"snap":"foo","name":"bar","daemon":"simple","enabled":true,"active":true}]}`)
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"services", "--watch", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `Service  Startup  Current   Notes
+foo.bar  enabled  inactive  -
+Service  Startup  Current  Notes
+foo.bar  enabled  active   -
+`)
+	c.Check(n, check.Equals, 1)
+
+	s.ResetStdStreams()
+	n = 0
+	rest, err = snap.Parser(snap.Client()).ParseArgs([]string{"services", "--watch", "--json", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `[{"snap":"foo","name":"bar","daemon":"simple","enabled":true}]
+[{"snap":"foo","name":"bar","daemon":"simple","enabled":true,"active":true}]
+`)
+	c.Check(n, check.Equals, 1)
+}
+
 func (s *appOpSuite) TestServiceCompletion(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
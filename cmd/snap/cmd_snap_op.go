@@ -134,7 +134,7 @@ func (x *cmdRemove) removeOne(opts *client.SnapOptions) error {
 		if err != nil {
 			return err
 		}
-		fmt.Fprintln(Stderr, msg)
+		printCmdMessage(msg)
 		return nil
 	}
 
@@ -474,9 +474,10 @@ type cmdInstall struct {
 
 	Name string `long:"name"`
 
-	Cohort        string `long:"cohort"`
-	IgnoreRunning bool   `long:"ignore-running" hidden:"yes"`
-	Positional    struct {
+	Cohort                  string `long:"cohort"`
+	IgnoreRunning           bool   `long:"ignore-running" hidden:"yes"`
+	ApproveDefaultProviders bool   `long:"approve-default-providers"`
+	Positional              struct {
 		Snaps []remoteSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes" required:"yes"`
 }
@@ -502,7 +503,7 @@ func (x *cmdInstall) installOne(nameOrPath, desiredName string, opts *client.Sna
 		if err != nil {
 			return err
 		}
-		fmt.Fprintln(Stderr, msg)
+		printCmdMessage(msg)
 		return nil
 	}
 
@@ -543,7 +544,7 @@ func (x *cmdInstall) installMany(names []string, opts *client.SnapOptions) error
 		if err != nil {
 			return err
 		}
-		fmt.Fprintln(Stderr, msg)
+		printCmdMessage(msg)
 		return nil
 	}
 
@@ -592,12 +593,13 @@ func (x *cmdInstall) Execute([]string) error {
 
 	dangerous := x.Dangerous || x.ForceDangerous
 	opts := &client.SnapOptions{
-		Channel:       x.Channel,
-		Revision:      x.Revision,
-		Dangerous:     dangerous,
-		Unaliased:     x.Unaliased,
-		CohortKey:     x.Cohort,
-		IgnoreRunning: x.IgnoreRunning,
+		Channel:                 x.Channel,
+		Revision:                x.Revision,
+		Dangerous:               dangerous,
+		Unaliased:               x.Unaliased,
+		CohortKey:               x.Cohort,
+		IgnoreRunning:           x.IgnoreRunning,
+		ApproveDefaultProviders: x.ApproveDefaultProviders,
 	}
 	x.setModes(opts)
 
@@ -622,6 +624,9 @@ func (x *cmdInstall) Execute([]string) error {
 	if x.Name != "" {
 		return errors.New(i18n.G("cannot use instance name when installing multiple snaps"))
 	}
+	if x.ApproveDefaultProviders {
+		return errors.New(i18n.G("a single snap name must be specified when approving default providers"))
+	}
 	return x.installMany(names, nil)
 }
 
@@ -632,15 +637,17 @@ type cmdRefresh struct {
 	channelMixin
 	modeMixin
 
-	Amend            bool   `long:"amend"`
-	Revision         string `long:"revision"`
-	Cohort           string `long:"cohort"`
-	LeaveCohort      bool   `long:"leave-cohort"`
-	List             bool   `long:"list"`
-	Time             bool   `long:"time"`
-	IgnoreValidation bool   `long:"ignore-validation"`
-	IgnoreRunning    bool   `long:"ignore-running" hidden:"yes"`
-	Positional       struct {
+	Amend                   bool   `long:"amend"`
+	Revision                string `long:"revision"`
+	Cohort                  string `long:"cohort"`
+	LeaveCohort             bool   `long:"leave-cohort"`
+	List                    bool   `long:"list"`
+	Time                    bool   `long:"time"`
+	IgnoreValidation        bool   `long:"ignore-validation"`
+	IgnoreRunning           bool   `long:"ignore-running" hidden:"yes"`
+	ApprovePolicyChanges    bool   `long:"approve-policy-changes"`
+	ApproveDefaultProviders bool   `long:"approve-default-providers"`
+	Positional              struct {
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
 }
@@ -680,7 +687,7 @@ func (x *cmdRefresh) refreshOne(name string, opts *client.SnapOptions) error {
 		if err != nil {
 			return err
 		}
-		fmt.Fprintln(Stderr, msg)
+		printCmdMessage(msg)
 		return nil
 	}
 
@@ -802,13 +809,15 @@ func (x *cmdRefresh) Execute([]string) error {
 	names := installedSnapNames(x.Positional.Snaps)
 	if len(names) == 1 {
 		opts := &client.SnapOptions{
-			Amend:            x.Amend,
-			Channel:          x.Channel,
-			IgnoreValidation: x.IgnoreValidation,
-			IgnoreRunning:    x.IgnoreRunning,
-			Revision:         x.Revision,
-			CohortKey:        x.Cohort,
-			LeaveCohort:      x.LeaveCohort,
+			Amend:                   x.Amend,
+			Channel:                 x.Channel,
+			IgnoreValidation:        x.IgnoreValidation,
+			IgnoreRunning:           x.IgnoreRunning,
+			ApprovePolicyChanges:    x.ApprovePolicyChanges,
+			Revision:                x.Revision,
+			CohortKey:               x.Cohort,
+			LeaveCohort:             x.LeaveCohort,
+			ApproveDefaultProviders: x.ApproveDefaultProviders,
 		}
 		x.setModes(opts)
 		return x.refreshOne(names[0], opts)
@@ -824,6 +833,12 @@ func (x *cmdRefresh) Execute([]string) error {
 	if x.IgnoreRunning {
 		return errors.New(i18n.G("a single snap name must be specified when ignoring running apps and hooks"))
 	}
+	if x.ApprovePolicyChanges {
+		return errors.New(i18n.G("a single snap name must be specified when approving security policy changes"))
+	}
+	if x.ApproveDefaultProviders {
+		return errors.New(i18n.G("a single snap name must be specified when approving default providers"))
+	}
 
 	return x.refreshMany(names, nil)
 }
@@ -884,7 +899,7 @@ func (x *cmdTry) Execute([]string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Fprintln(Stderr, msg)
+		printCmdMessage(msg)
 		return nil
 	}
 
@@ -1108,6 +1123,8 @@ func init() {
 			"cohort": i18n.G("Install the snap in the given cohort"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"ignore-running": i18n.G("Ignore running hooks or applications blocking the installation"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"approve-default-providers": i18n.G("Approve the automatic installation of the default content providers of the snap"),
 		}), nil)
 	addCommand("refresh", shortRefreshHelp, longRefreshHelp, func() flags.Commander { return &cmdRefresh{} },
 		colorDescs.also(waitDescs).also(channelDescs).also(modeDescs).also(timeDescs).also(map[string]string{
@@ -1124,6 +1141,10 @@ func init() {
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"ignore-running": i18n.G("Ignore running hooks or applications blocking the refresh"),
 			// TRANSLATORS: This should not start with a lowercase letter.
+			"approve-policy-changes": i18n.G("Approve high-risk changes to the security policy of the snap"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"approve-default-providers": i18n.G("Approve the automatic installation of the default content providers of the snap"),
+			// TRANSLATORS: This should not start with a lowercase letter.
 			"cohort": i18n.G("Refresh the snap into the given cohort"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"leave-cohort": i18n.G("Refresh the snap out of its cohort"),
@@ -26,7 +26,9 @@ import (
 
 	"github.com/jessevdk/go-flags"
 
+	"github.com/snapcore/snapd/audit"
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
 	"github.com/snapcore/snapd/image"
 	"github.com/snapcore/snapd/sandbox/cgroup"
 	"github.com/snapcore/snapd/sandbox/selinux"
@@ -68,6 +70,8 @@ var (
 
 	WriteWarningTimestamp = writeWarningTimestamp
 	MaybePresentWarnings  = maybePresentWarnings
+	ExitCodeFromError     = exitCodeFromError
+	ReportStrictError     = reportStrictError
 
 	LongSnapDescription     = longSnapDescription
 	SnapUsage               = snapUsage
@@ -160,6 +164,22 @@ func MockSyscallExec(f func(string, []string, []string) error) (restore func())
 	}
 }
 
+func MockAuditRecordSnapRun(f func(*audit.SnapRunRecord) error) (restore func()) {
+	old := auditRecordSnapRun
+	auditRecordSnapRun = f
+	return func() {
+		auditRecordSnapRun = old
+	}
+}
+
+func MockRuninhibitWaitWhileInhibited(f func(snapName string, inhibited func(hint runinhibit.Hint) error) error) (restore func()) {
+	old := runinhibitWaitWhileInhibited
+	runinhibitWaitWhileInhibited = f
+	return func() {
+		runinhibitWaitWhileInhibited = old
+	}
+}
+
 func MockUserCurrent(f func() (*user.User, error)) (restore func()) {
 	userCurrentOrig := userCurrent
 	userCurrent = f
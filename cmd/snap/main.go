@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -75,6 +76,8 @@ var (
 
 type options struct {
 	Version func() `long:"version"`
+	Strict  bool   `long:"strict"`
+	Remote  string `long:"remote" value-name:"<remote>"`
 }
 
 type argDesc struct {
@@ -322,6 +325,10 @@ func Parser(cli *client.Client) *flags.Parser {
 		printVersions(cli)
 		panic(&exitStatus{0})
 	}
+	// reset, it is global state
+	optionsData.Strict = false
+	optionsData.Remote = ""
+	strictSpawnTime = time.Time{}
 	flagopts := flags.Options(flags.PassDoubleDash)
 	if firstNonOptionIsRun() {
 		flagopts |= flags.PassAfterNonOption
@@ -336,8 +343,29 @@ func Parser(cli *client.Client) *flags.Parser {
 		version.Description = i18n.G("Print the version and exit")
 		version.Hidden = true
 	}
+	if strict := parser.FindOptionByLongName("strict"); strict != nil {
+		strict.Description = i18n.G("Report warnings and errors on stderr as JSON and use distinct exit codes for warnings and reboots")
+		strict.Hidden = true
+	}
+	if remote := parser.FindOptionByLongName("remote"); remote != nil {
+		remote.Description = i18n.G("Talk to snapd on the given device, as [ssh://][<user>@]<host>[:<port>] or vsock://<cid>:<port>, instead of the local one")
+		remote.Hidden = true
+	}
 	// add --help like what go-flags would do for us, but hidden
 	addHelp(parser)
+	// the options are all parsed by the time the command runs, point the
+	// client at the --remote device then
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		if optionsData.Remote != "" {
+			if err := cli.SetRemote(optionsData.Remote); err != nil {
+				return err
+			}
+		}
+		if cmd == nil {
+			return nil
+		}
+		return cmd.Execute(args)
+	}
 
 	seen := make(map[string]bool, len(commands)+len(debugCommands)+len(routineCommands))
 	checkUnique := func(ci *cmdInfo, kind string) {
@@ -432,12 +460,15 @@ func exitCodeFromError(err error) int {
 	var mksquashfsError squashfs.MksquashfsError
 	var cmdlineFlagsError *flags.Error
 	var unknownCmdError unknownCommandError
+	var strictSt *strictStatus
 
 	switch {
 	case err == nil:
 		return 0
 	case client.IsRetryable(err):
 		return 10
+	case xerrors.As(err, &strictSt):
+		return strictSt.code
 	case xerrors.As(err, &mksquashfsError):
 		return 20
 	case xerrors.As(err, &cmdlineFlagsError) || xerrors.As(err, &unknownCmdError):
@@ -504,6 +535,9 @@ func main() {
 
 	// no magic /o\
 	if err := run(); err != nil {
+		if optionsData.Strict {
+			os.Exit(reportStrictError(err))
+		}
 		fmt.Fprintf(Stderr, errorPrefix, err)
 		os.Exit(exitCodeFromError(err))
 	}
@@ -540,6 +574,8 @@ func (e unknownCommandError) Error() string {
 
 func run() error {
 	cli := mkClient()
+	// stop forwarding the snapd socket of the --remote device, if any
+	defer cli.Close()
 	parser := Parser(cli)
 	xtra, err := parser.Parse()
 	if err != nil {
@@ -583,10 +619,16 @@ fixed-width fonts, so it can be hard to tell.
 			return err
 		}
 
-		fmt.Fprintln(Stderr, msg)
+		printCmdMessage(msg)
+		if optionsData.Strict {
+			return strictResult(cli)
+		}
 		return nil
 	}
 
+	if optionsData.Strict {
+		return strictResult(cli)
+	}
 	maybePresentWarnings(cli.WarningsSummary())
 
 	return nil
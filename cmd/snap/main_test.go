@@ -253,6 +253,17 @@ func (s *SnapSuite) TestVersionOnAllSnap(c *C) {
 	c.Assert(s.Stderr(), Equals, "")
 }
 
+func (s *SnapSuite) TestRemoteInvalid(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request to the local snapd: %v", r.URL)
+	})
+	restore := mockArgs("snap", "--remote=ftp://device", "list")
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, ErrorMatches, `cannot use remote "ftp://device": unsupported scheme "ftp"`)
+}
+
 func (s *SnapSuite) TestUnknownCommand(c *C) {
 	restore := mockArgs("snap", "unknowncmd")
 	defer restore()
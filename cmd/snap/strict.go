@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+)
+
+// Exit codes specific to --strict mode, the ones for errors are the same in
+// both modes, in particular 10 means a conflict and the command should be
+// retried later.
+const (
+	// the command succeeded but there are new warnings
+	exitCodeSucceededWithWarnings = 11
+	// the command succeeded but the system needs to reboot for it to
+	// complete, snapd is about to reboot it
+	exitCodeNeedsReboot = 12
+)
+
+// strictStatus is returned by run in --strict mode when the command
+// succeeded but a non-zero exit code must be used, the details were already
+// reported on stderr.
+type strictStatus struct {
+	code int
+}
+
+func (s *strictStatus) Error() string {
+	return "internal error: strictStatus being handled as normal error"
+}
+
+// strictReport is a structured report written to stderr as one line of JSON
+// in --strict mode.
+type strictReport struct {
+	Type       string           `json:"type"`
+	Message    string           `json:"message"`
+	Kind       client.ErrorKind `json:"kind,omitempty"`
+	FirstAdded *time.Time       `json:"first-added,omitempty"`
+	LastAdded  *time.Time       `json:"last-added,omitempty"`
+	ExitCode   int              `json:"exit-code,omitempty"`
+}
+
+func writeStrictReport(r *strictReport) {
+	// errors writing to stderr cannot be reported anywhere
+	json.NewEncoder(Stderr).Encode(r)
+}
+
+// printCmdMessage prints a message about the outcome of an operation
+// obtained via errorToCmdMessage that is not an error.
+func printCmdMessage(msg string) {
+	if optionsData.Strict {
+		writeStrictReport(&strictReport{Type: "notice", Message: msg})
+		return
+	}
+	fmt.Fprintln(Stderr, msg)
+}
+
+// strictSpawnTime is the spawn time, as recorded by snapd, of the earliest
+// change the command waited for.
+var strictSpawnTime time.Time
+
+// noteStrictChange records the spawn time of a change the command waits for,
+// the warnings raised since then are the ones reported in --strict mode.
+func noteStrictChange(chg *client.Change) {
+	if strictSpawnTime.IsZero() || chg.SpawnTime.Before(strictSpawnTime) {
+		strictSpawnTime = chg.SpawnTime
+	}
+}
+
+// strictResult reports in --strict mode the warnings raised since the
+// changes of the command were spawned and whether the system is about to
+// reboot after a command succeeded, and returns the appropriate status.
+// Commands which did not wait for a change do not report warnings, as the
+// times of the warnings come from the clock of snapd.
+func strictResult(cli *client.Client) error {
+	maintErr, _ := cli.Maintenance().(*client.Error)
+	rebooting := maintErr != nil && maintErr.Kind == client.ErrorKindSystemRestart
+
+	var warnings []*client.Warning
+	since := strictSpawnTime
+	if count, timestamp := cli.WarningsSummary(); !since.IsZero() && count > 0 && !timestamp.Before(since) {
+		all, err := cli.Warnings(client.WarningsOptions{All: true})
+		if err != nil {
+			return err
+		}
+		for _, w := range all {
+			// older warnings are unrelated to the command, even
+			// when they were not acknowledged
+			if !w.LastAdded.Before(since) {
+				warnings = append(warnings, w)
+			}
+		}
+	}
+	for _, w := range warnings {
+		writeStrictReport(&strictReport{
+			Type:       "warning",
+			Message:    w.Message,
+			FirstAdded: &w.FirstAdded,
+			LastAdded:  &w.LastAdded,
+		})
+	}
+
+	switch {
+	case rebooting:
+		writeStrictReport(&strictReport{
+			Type:     "reboot",
+			Message:  i18n.G("snapd is about to reboot the system"),
+			ExitCode: exitCodeNeedsReboot,
+		})
+		return &strictStatus{code: exitCodeNeedsReboot}
+	case len(warnings) > 0:
+		return &strictStatus{code: exitCodeSucceededWithWarnings}
+	}
+	return nil
+}
+
+// reportStrictError reports an error in --strict mode and returns the exit
+// code to use.
+func reportStrictError(err error) int {
+	code := exitCodeFromError(err)
+	var status *strictStatus
+	if xerrors.As(err, &status) {
+		// already reported
+		return code
+	}
+	r := &strictReport{
+		Type:     "error",
+		Message:  err.Error(),
+		ExitCode: code,
+	}
+	var e *client.Error
+	if xerrors.As(err, &e) {
+		r.Kind = e.Kind
+	}
+	writeStrictReport(r)
+	return code
+}
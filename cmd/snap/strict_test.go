@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) mockStrictServer(c *C, changeRsp string) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		switch n {
+		case 1:
+			c.Check(r.URL.Path, Equals, "/v2/changes/42")
+			fmt.Fprintln(w, changeRsp)
+		case 2:
+			c.Check(r.URL.Path, Equals, "/v2/warnings")
+			fmt.Fprintln(w, twoWarnings)
+		default:
+			c.Fatalf("expected at most 2 requests, got %d", n)
+		}
+	})
+}
+
+func (s *SnapSuite) TestStrictSucceededWithWarnings(c *C) {
+	// the change was spawned after the first warning was raised
+	s.mockStrictServer(c, `{"type": "sync", "status-code": 200, "result": {"id": "42", "kind": "foo", "summary": "bar", "status": "Done", "ready": true, "spawn-time": "2018-09-19T12:42:00Z"},
+		"warning-count": 2, "warning-timestamp": "2018-09-19T12:44:19.680362867Z"}`)
+
+	restore := mockArgs("snap", "--strict", "watch", "42")
+	defer restore()
+	// the clock of the client is not used
+	restore = snap.MockTimeNow(func() time.Time {
+		return time.Date(2018, 9, 20, 0, 0, 0, 0, time.UTC)
+	})
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, NotNil)
+	c.Check(snap.ExitCodeFromError(err), Equals, 11)
+	// already reported
+	c.Check(snap.ReportStrictError(err), Equals, 11)
+	c.Check(s.Stderr(), Equals, `{"type":"warning","message":"hello world number two","first-added":"2018-09-19T12:44:19.680362867Z","last-added":"2018-09-19T12:44:19.680362867Z"}
+`)
+}
+
+func (s *SnapSuite) TestStrictOlderWarnings(c *C) {
+	s.mockStrictServer(c, `{"type": "sync", "status-code": 200, "result": {"id": "42", "kind": "foo", "summary": "bar", "status": "Done", "ready": true, "spawn-time": "2018-09-20T00:00:00Z"},
+		"warning-count": 2, "warning-timestamp": "2018-09-19T12:44:19.680362867Z"}`)
+
+	restore := mockArgs("snap", "--strict", "watch", "42")
+	defer restore()
+
+	// unacknowledged warnings raised before the change are not reported
+	err := snap.RunMain()
+	c.Assert(err, IsNil)
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestStrictNoChangeNoWarnings(c *C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.URL.Path, Equals, "/v2/changes")
+		fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": [{"id": "1", "kind": "foo", "summary": "bar", "status": "Done", "ready": true, "spawn-time": "2016-04-21T01:02:03Z"}],
+		"warning-count": 2, "warning-timestamp": "2018-09-19T12:44:19.680362867Z"}`)
+	})
+
+	restore := mockArgs("snap", "--strict", "changes")
+	defer restore()
+
+	// without a change the warnings cannot be attributed to the command
+	err := snap.RunMain()
+	c.Assert(err, IsNil)
+	c.Check(s.Stderr(), Equals, "")
+	c.Check(n, Equals, 1)
+}
+
+func (s *SnapSuite) TestStrictNoWarnings(c *C) {
+	s.mockStrictServer(c, `{"type": "sync", "status-code": 200, "result": {"id": "42", "kind": "foo", "summary": "bar", "status": "Done", "ready": true, "spawn-time": "2016-04-21T01:02:03Z"}}`)
+
+	restore := mockArgs("snap", "--strict", "watch", "42")
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, IsNil)
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestNotStrictWarnings(c *C) {
+	s.mockStrictServer(c, `{"type": "sync", "status-code": 200, "result": {"id": "42", "kind": "foo", "summary": "bar", "status": "Done", "ready": true, "spawn-time": "2016-04-21T01:02:03Z"},
+		"warning-count": 2, "warning-timestamp": "2018-09-19T12:44:19.680362867Z"}`)
+
+	restore := mockArgs("snap", "watch", "42")
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, IsNil)
+	c.Check(s.Stderr(), Equals, "WARNING: There are 2 new warnings. See 'snap warnings'.\n")
+}
+
+func (s *SnapSuite) TestStrictNeedsReboot(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "error", "result": {"message": "system is restarting", "kind": "system-restart"},
+			"maintenance": {"message": "system is restarting", "kind": "system-restart"}}`)
+	})
+
+	restore := mockArgs("snap", "--strict", "install", "foo")
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, NotNil)
+	c.Check(snap.ExitCodeFromError(err), Equals, 12)
+	c.Check(s.Stderr(), Equals, `{"type":"notice","message":"snapd is about to reboot the system"}
+{"type":"reboot","message":"snapd is about to reboot the system","exit-code":12}
+`)
+}
+
+func (s *SnapSuite) TestStrictNotice(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "error", "result": {"message": "no update available", "kind": "snap-no-update-available", "value": "foo"}}`)
+	})
+
+	restore := mockArgs("snap", "--strict", "refresh", "foo")
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, IsNil)
+	c.Check(s.Stderr(), Equals, `{"type":"notice","message":"snap \"foo\" has no updates available"}
+`)
+}
+
+func (s *SnapSuite) TestStrictErrors(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "error", "result": {"message": "snap \"foo\" has \"install\" change in progress", "kind": "snap-change-conflict"}}`)
+	})
+
+	restore := mockArgs("snap", "--strict", "install", "foo")
+	defer restore()
+
+	err := snap.RunMain()
+	c.Assert(err, NotNil)
+	c.Check(snap.ReportStrictError(err), Equals, 10)
+	c.Check(s.Stderr(), Equals, `{"type":"error","message":"snap \"foo\" has \"install\" change in progress","kind":"snap-change-conflict","exit-code":10}
+`)
+}
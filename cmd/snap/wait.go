@@ -107,6 +107,7 @@ func (wmx waitMixin) wait(id string) (*client.Change, error) {
 			time.Sleep(pollTime)
 			continue
 		}
+		noteStrictChange(chg)
 		if maintErr, ok := cli.Maintenance().(*client.Error); ok && maintErr.Kind == client.ErrorKindSystemRestart {
 			rebootingErr = maintErr
 		}
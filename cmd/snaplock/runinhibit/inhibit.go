@@ -26,8 +26,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 )
 
@@ -152,3 +154,57 @@ func RemoveLockFile(snapName string) error {
 	}
 	return nil
 }
+
+// pollInterval is how often the inhibition lock is checked while waiting for
+// it to be lifted. Changes of the lock are watched when possible, in which
+// case polling only guards against missed changes.
+var pollInterval = time.Second
+
+// newWatcher returns a watcher of the changes of the inhibition locks.
+var newWatcher = func() (*dirWatcher, error) {
+	return newDirWatcher(InhibitDir)
+}
+
+// WaitWhileInhibited waits until the run inhibition lock of the given snap
+// is lifted. The inhibited function is called once with the hint of the
+// lock if it is in place, an error it returns stops the waiting.
+func WaitWhileInhibited(snapName string, inhibited func(hint Hint) error) error {
+	var w *dirWatcher
+	var changes chan struct{}
+	var errors chan error
+	notified := false
+	for {
+		hint, err := IsLocked(snapName)
+		if err != nil {
+			return err
+		}
+		if hint == HintNotInhibited {
+			return nil
+		}
+		if !notified {
+			notified = true
+			if err := inhibited(hint); err != nil {
+				return err
+			}
+			// watch the changes of the lock now that it exists,
+			// polling is enough when they cannot be watched
+			if w, err = newWatcher(); err != nil {
+				logger.Debugf("cannot watch run inhibition locks, polling: %v", err)
+			} else {
+				defer w.Close()
+				changes, errors = w.Changes, w.Errors
+			}
+			// the lock may have been lifted before it was watched
+			continue
+		}
+
+		select {
+		case <-changes:
+		case err := <-errors:
+			logger.Debugf("cannot watch run inhibition locks: %v", err)
+			// keep polling
+			errors = nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -20,9 +20,11 @@
 package runinhibit_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -154,3 +156,81 @@ func (s *runInhibitSuite) TestRemoveLockFile(c *C) {
 	// Removing an absent lock file is not an error.
 	c.Assert(runinhibit.RemoveLockFile("pkg"), IsNil)
 }
+
+// Waiting is not needed when the lock is not in place.
+func (s *runInhibitSuite) TestWaitWhileInhibitedNotInhibited(c *C) {
+	err := runinhibit.WaitWhileInhibited("pkg", func(hint runinhibit.Hint) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(runinhibit.LockWithHint("pkg", runinhibit.HintInhibitedForRefresh), IsNil)
+	c.Assert(runinhibit.Unlock("pkg"), IsNil)
+	err = runinhibit.WaitWhileInhibited("pkg", func(hint runinhibit.Hint) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	c.Assert(err, IsNil)
+}
+
+// Waiting returns once the lock is lifted, polling when changes cannot be
+// watched.
+func (s *runInhibitSuite) TestWaitWhileInhibited(c *C) {
+	restore := runinhibit.MockPollInterval(10 * time.Millisecond)
+	defer restore()
+	restore = runinhibit.MockNewWatcher(func() (*runinhibit.DirWatcher, error) {
+		return nil, errors.New("cannot initialize inotify: too many open files")
+	})
+	defer restore()
+
+	c.Assert(runinhibit.LockWithHint("pkg", runinhibit.HintInhibitedForRefresh), IsNil)
+
+	var hints []runinhibit.Hint
+	err := runinhibit.WaitWhileInhibited("pkg", func(hint runinhibit.Hint) error {
+		hints = append(hints, hint)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			c.Check(runinhibit.Unlock("pkg"), IsNil)
+		}()
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(hints, DeepEquals, []runinhibit.Hint{runinhibit.HintInhibitedForRefresh})
+}
+
+// Waiting returns as soon as the lock is lifted when its changes are watched.
+func (s *runInhibitSuite) TestWaitWhileInhibitedWatched(c *C) {
+	// only a change of the lock can end the wait in time
+	restore := runinhibit.MockPollInterval(time.Hour)
+	defer restore()
+
+	c.Assert(runinhibit.LockWithHint("pkg", runinhibit.HintInhibitedForRefresh), IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runinhibit.WaitWhileInhibited("pkg", func(hint runinhibit.Hint) error {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				c.Check(runinhibit.Unlock("pkg"), IsNil)
+			}()
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(10 * time.Second):
+		c.Fatal("the lifted lock was not noticed")
+	}
+}
+
+// Waiting stops if the inhibited function fails.
+func (s *runInhibitSuite) TestWaitWhileInhibitedError(c *C) {
+	c.Assert(runinhibit.LockWithHint("pkg", runinhibit.HintInhibitedForRefresh), IsNil)
+
+	err := runinhibit.WaitWhileInhibited("pkg", func(hint runinhibit.Hint) error {
+		return errors.New("cannot notify the user")
+	})
+	c.Assert(err, ErrorMatches, "cannot notify the user")
+}
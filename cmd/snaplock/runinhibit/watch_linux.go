@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil/fanotify"
+)
+
+var fanotifyNewWatcher = fanotify.NewWatcher
+
+// dirWatcher watches the entries of a single directory. It uses fanotify when
+// the kernel allows it, which needs Linux 5.13 or privileges, and falls back
+// to inotify otherwise so that unprivileged snap run can use it on any
+// kernel.
+type dirWatcher struct {
+	// Changes receives a value when entries of the directory changed
+	// since it was last received from.
+	Changes chan struct{}
+	// Errors receives the error that stopped the watching, if any.
+	Errors chan error
+
+	close func() error
+}
+
+// inotifyMask selects the changes of the entries of a directory, including
+// the truncation of the inhibition locks when they are lifted.
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	w, err := newFanotifyDirWatcher(dir)
+	if err == nil {
+		return w, nil
+	}
+	logger.Debugf("cannot watch %s with fanotify, using inotify: %v", dir, err)
+	return newInotifyDirWatcher(dir)
+}
+
+func newFanotifyDirWatcher(dir string) (*dirWatcher, error) {
+	fw, err := fanotifyNewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fw.Add(dir); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	stop := make(chan struct{})
+	w := &dirWatcher{
+		Changes: make(chan struct{}, 1),
+		Errors:  make(chan error, 1),
+		close: func() error {
+			close(stop)
+			return fw.Close()
+		},
+	}
+	go func() {
+		for {
+			select {
+			case <-fw.Events:
+				// an overflow is a change as well, the lock is
+				// checked again anyway
+				w.notify()
+			case err := <-fw.Errors:
+				w.Errors <- err
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+func newInotifyDirWatcher(dir string) (*dirWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize inotify: %v", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("cannot watch %s: %v", dir, err)
+	}
+	// the descriptor is non-blocking so reading it goes through the
+	// runtime poller, and closing the file interrupts a read
+	f := os.NewFile(uintptr(fd), "inotify")
+	w := &dirWatcher{
+		Changes: make(chan struct{}, 1),
+		Errors:  make(chan error, 1),
+		close:   f.Close,
+	}
+	go func() {
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			if _, err := f.Read(buf); err != nil {
+				// nobody is listening anymore once the watcher
+				// is closed, the channel is buffered so this
+				// never blocks
+				w.Errors <- err
+				return
+			}
+			// which entries changed does not matter, the lock is
+			// checked again anyway
+			w.notify()
+		}
+	}()
+	return w, nil
+}
+
+func (w *dirWatcher) notify() {
+	select {
+	case w.Changes <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops watching the directory.
+func (w *dirWatcher) Close() error {
+	return w.close()
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
+	"github.com/snapcore/snapd/osutil/fanotify"
+)
+
+type watchSuite struct{}
+
+var _ = Suite(&watchSuite{})
+
+func checkChangeNoticed(c *C, w *runinhibit.DirWatcher, dir string) {
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "pkg.lock"), []byte("refresh"), 0644), IsNil)
+	select {
+	case <-w.Changes:
+	case err := <-w.Errors:
+		c.Fatalf("unexpected error: %v", err)
+	case <-time.After(10 * time.Second):
+		c.Fatal("the change was not noticed")
+	}
+}
+
+func (s *watchSuite) TestDirWatcherFanotify(c *C) {
+	// fanotify needs Linux 5.13 or privileges
+	fw, err := fanotify.NewWatcher()
+	if err != nil {
+		c.Skip(err.Error())
+	}
+	fw.Close()
+
+	used := false
+	restore := runinhibit.MockFanotifyNewWatcher(func() (*fanotify.Watcher, error) {
+		used = true
+		return fanotify.NewWatcher()
+	})
+	defer restore()
+
+	dir := c.MkDir()
+	w, err := runinhibit.NewDirWatcher(dir)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	c.Check(used, Equals, true)
+
+	checkChangeNoticed(c, w, dir)
+}
+
+func (s *watchSuite) TestDirWatcherInotifyFallback(c *C) {
+	restore := runinhibit.MockFanotifyNewWatcher(func() (*fanotify.Watcher, error) {
+		return nil, errors.New("cannot initialize fanotify: operation not permitted")
+	})
+	defer restore()
+
+	dir := c.MkDir()
+	w, err := runinhibit.NewDirWatcher(dir)
+	c.Assert(err, IsNil)
+	defer w.Close()
+
+	checkChangeNoticed(c, w, dir)
+}
+
+func (s *watchSuite) TestDirWatcherMissingDir(c *C) {
+	restore := runinhibit.MockFanotifyNewWatcher(func() (*fanotify.Watcher, error) {
+		return nil, errors.New("cannot initialize fanotify: operation not permitted")
+	})
+	defer restore()
+
+	_, err := runinhibit.NewDirWatcher(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, ErrorMatches, "cannot watch .*/missing: no such file or directory")
+}
@@ -0,0 +1,41 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !linux
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package runinhibit
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// dirWatcher is not available outside of Linux, where waiting polls.
+type dirWatcher struct {
+	Changes chan struct{}
+	Errors  chan error
+}
+
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	return nil, fmt.Errorf("cannot watch %s: not supported on %s", dir, runtime.GOOS)
+}
+
+// Close stops watching the directory.
+func (w *dirWatcher) Close() error {
+	return nil
+}
@@ -35,6 +35,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
@@ -59,6 +60,7 @@ import (
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/servicestate"
@@ -102,6 +104,7 @@ var api = []*Command{
 	aliasesCmd,
 	appsCmd,
 	logsCmd,
+	eventsCmd,
 	warningsCmd,
 	debugPprofCmd,
 	debugCmd,
@@ -115,10 +118,15 @@ var api = []*Command{
 	systemsActionCmd,
 	routineConsoleConfStartCmd,
 	systemRecoveryKeysCmd,
+	factoryCmd,
+	auditSnapRunCmd,
+	promptingRulesCmd,
 }
 
 var servicestateControl = servicestate.Control
 
+var devicestateCheckUnassertedSnapAllowed = devicestate.CheckUnassertedSnapAllowed
+
 var (
 	// see daemon.go:canAccess for details how the access is controlled
 	rootCmd = &Command{
@@ -128,9 +136,10 @@ var (
 	}
 
 	sysInfoCmd = &Command{
-		Path:    "/v2/system-info",
-		GuestOK: true,
-		GET:     sysInfo,
+		Path:     "/v2/system-info",
+		GuestOK:  true,
+		PublicOK: true,
+		GET:      sysInfo,
 	}
 
 	appIconCmd = &Command{
@@ -148,6 +157,7 @@ var (
 	snapsCmd = &Command{
 		Path:     "/v2/snaps",
 		UserOK:   true,
+		PublicOK: true,
 		PolkitOK: "io.snapcraft.snapd.manage",
 		GET:      getSnapsInfo,
 		POST:     postSnaps,
@@ -156,6 +166,7 @@ var (
 	snapCmd = &Command{
 		Path:     "/v2/snaps/{name}",
 		UserOK:   true,
+		PublicOK: true,
 		PolkitOK: "io.snapcraft.snapd.manage",
 		GET:      getSnapInfo,
 		POST:     postSnap,
@@ -267,7 +278,16 @@ func formatRefreshTime(t time.Time) string {
 	return fmt.Sprintf("%s", t.Truncate(time.Minute).Format(time.RFC3339))
 }
 
-func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
+func sysInfo(c *Command, r *http.Request, user *auth.UserState) (rsp Response) {
+	// system info is what is used to diagnose a degraded snapd, do not
+	// let a misbehaving subsystem take the endpoint down with it
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Noticef("cannot get system info: internal error: %v\n%s", r, debug.Stack())
+			rsp = InternalError("cannot get system info: internal error: %v", r)
+		}
+	}()
+
 	st := c.d.overlord.State()
 	snapMgr := c.d.overlord.SnapManager()
 	deviceMgr := c.d.overlord.DeviceManager()
@@ -332,6 +352,19 @@ func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 		m["sandbox-features"] = features
 	}
 
+	if degraded := c.d.overlord.StateEngine().Degraded(); len(degraded) > 0 {
+		subsystems := make([]client.DegradedSubsystem, len(degraded))
+		for i, d := range degraded {
+			subsystems[i] = client.DegradedSubsystem{
+				Name:    d.Name,
+				Reason:  d.Reason,
+				Since:   d.Since,
+				RetryAt: d.RetryAt,
+			}
+		}
+		m["degraded"] = subsystems
+	}
+
 	return SyncResponse(m, nil)
 }
 
@@ -689,6 +722,10 @@ func sendStorePackages(route *mux.Route, meta *Meta, found []*snap.Info) Respons
 func getSnapsInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	if shouldSearchStore(r) {
+		if isPublicRequest(r) {
+			// the store is not reachable via the public socket
+			return Unauthorized("access denied")
+		}
 		logger.Noticef("Jumping to \"find\" to better support legacy request %q", r.URL)
 		return searchStore(c, r, user)
 	}
@@ -794,13 +831,15 @@ type snapInstruction struct {
 	Action string `json:"action"`
 	Amend  bool   `json:"amend"`
 	snapRevisionOptions
-	DevMode          bool `json:"devmode"`
-	JailMode         bool `json:"jailmode"`
-	Classic          bool `json:"classic"`
-	IgnoreValidation bool `json:"ignore-validation"`
-	IgnoreRunning    bool `json:"ignore-running"`
-	Unaliased        bool `json:"unaliased"`
-	Purge            bool `json:"purge,omitempty"`
+	DevMode                 bool `json:"devmode"`
+	JailMode                bool `json:"jailmode"`
+	Classic                 bool `json:"classic"`
+	IgnoreValidation        bool `json:"ignore-validation"`
+	IgnoreRunning           bool `json:"ignore-running"`
+	Unaliased               bool `json:"unaliased"`
+	ApprovePolicyChanges    bool `json:"approve-policy-changes"`
+	ApproveDefaultProviders bool `json:"approve-default-providers"`
+	Purge                   bool `json:"purge,omitempty"`
 	// dropping support temporarely until flag confusion is sorted,
 	// this isn't supported by client atm anyway
 	LeaveOld bool         `json:"temp-dropped-leave-old"`
@@ -837,6 +876,9 @@ func (inst *snapInstruction) installFlags() (snapstate.Flags, error) {
 	if inst.IgnoreRunning {
 		flags.IgnoreRunning = true
 	}
+	if inst.ApproveDefaultProviders {
+		flags.ApproveDefaultProviders = true
+	}
 
 	return flags, nil
 }
@@ -1036,6 +1078,12 @@ func snapUpdate(inst *snapInstruction, st *state.State) (string, []*state.TaskSe
 	if inst.IgnoreRunning {
 		flags.IgnoreRunning = true
 	}
+	if inst.ApprovePolicyChanges {
+		flags.ApprovePolicyChanges = true
+	}
+	if inst.ApproveDefaultProviders {
+		flags.ApproveDefaultProviders = true
+	}
 	if inst.Amend {
 		flags.Amend = true
 	}
@@ -1283,6 +1331,9 @@ func trySnap(c *Command, r *http.Request, user *auth.UserState, trydir string, f
 	if !osutil.IsDirectory(trydir) {
 		return BadRequest("cannot try %q: not a snap directory", trydir)
 	}
+	if err := devicestateCheckUnassertedSnapAllowed(st); err != nil {
+		return BadRequest(err.Error())
+	}
 
 	// the developer asked us to do this with a trusted snap dir
 	info, err := unsafeReadSnapInfo(trydir)
@@ -1435,6 +1486,7 @@ func postSnaps(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	flags.Unaliased = isTrue(form, "unaliased")
 	flags.IgnoreRunning = isTrue(form, "ignore-running")
+	flags.ApproveDefaultProviders = isTrue(form, "approve-default-providers")
 
 	// find the file for the "snap" form field
 	var snapBody multipart.File
@@ -1528,6 +1580,9 @@ out:
 
 	if snapName == "" {
 		// potentially dangerous but dangerous or devmode params were set
+		if err := devicestateCheckUnassertedSnapAllowed(st); err != nil {
+			return BadRequest(err.Error())
+		}
 		info, err := unsafeReadSnapInfo(tempPath)
 		if err != nil {
 			return BadRequest("cannot read snap file: %v", err)
@@ -1963,6 +2018,20 @@ func change2changeInfo(chg *state.Change) *changeInfo {
 	if chg.Get("api-data", &data) == nil {
 		chgInfo.Data = data
 	}
+	var policyDiffs *json.RawMessage
+	if chg.Get("policy-diffs", &policyDiffs) == nil {
+		if chgInfo.Data == nil {
+			chgInfo.Data = make(map[string]*json.RawMessage)
+		}
+		chgInfo.Data["policy-diffs"] = policyDiffs
+	}
+	var defaultProviders *json.RawMessage
+	if chg.Get("default-providers", &defaultProviders) == nil {
+		if chgInfo.Data == nil {
+			chgInfo.Data = make(map[string]*json.RawMessage)
+		}
+		chgInfo.Data["default-providers"] = defaultProviders
+	}
 
 	return chgInfo
 }
@@ -2420,6 +2489,7 @@ func getLogs(c *Command, r *http.Request, user *auth.UserState) Response {
 	return &journalLineReaderSeqResponse{
 		ReadCloser: reader,
 		follow:     follow,
+		stop:       c.d.Dying(),
 	}
 }
 
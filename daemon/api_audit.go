@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/audit"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/snap/naming"
+)
+
+var auditSnapRunCmd = &Command{
+	Path:     "/v2/audit/snap-run",
+	GET:      getSnapRunAudit,
+	RootOnly: true,
+}
+
+var auditSnapRunRecords = audit.SnapRunRecords
+
+func getSnapRunAudit(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+
+	var opts audit.QueryOptions
+	if s := query.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return BadRequest(`invalid value for n: %q`, s)
+		}
+		opts.N = n
+	}
+	if s := query.Get("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return BadRequest(`invalid value for since: %q: %v`, s, err)
+		}
+		opts.Since = since
+	}
+	if s := query.Get("snap"); s != "" {
+		if err := naming.ValidateInstance(s); err != nil {
+			return BadRequest(`invalid value for snap: %v`, err)
+		}
+		opts.Snap = s
+	}
+
+	records, err := auditSnapRunRecords(&opts)
+	if err != nil {
+		return InternalError("%v", err)
+	}
+	if records == nil {
+		records = []*audit.SnapRunRecord{}
+	}
+	return SyncResponse(records, nil)
+}
@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/audit"
+)
+
+func (s *apiSuite) mockSnapRunRecords(f func(opts *audit.QueryOptions) ([]*audit.SnapRunRecord, error)) {
+	old := auditSnapRunRecords
+	auditSnapRunRecords = f
+	s.AddCleanup(func() { auditSnapRunRecords = old })
+}
+
+func (s *apiSuite) TestGetSnapRunAudit(c *C) {
+	s.daemon(c)
+
+	t0 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	recs := []*audit.SnapRunRecord{
+		{Time: t0, Snap: "foo", App: "bar", UID: 1000, ExitCode: 1},
+	}
+	var gotOpts *audit.QueryOptions
+	s.mockSnapRunRecords(func(opts *audit.QueryOptions) ([]*audit.SnapRunRecord, error) {
+		gotOpts = opts
+		return recs, nil
+	})
+
+	req, err := http.NewRequest("GET", "/v2/audit/snap-run?n=5&snap=foo&since=2021-06-01T09:00:00Z", nil)
+	c.Assert(err, IsNil)
+	rsp := getSnapRunAudit(auditSnapRunCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, Equals, 200)
+	c.Check(rsp.Result, DeepEquals, recs)
+	c.Check(gotOpts, DeepEquals, &audit.QueryOptions{
+		N:     5,
+		Snap:  "foo",
+		Since: t0.Add(-time.Hour),
+	})
+}
+
+func (s *apiSuite) TestGetSnapRunAuditEmpty(c *C) {
+	s.daemon(c)
+
+	s.mockSnapRunRecords(func(opts *audit.QueryOptions) ([]*audit.SnapRunRecord, error) {
+		c.Check(opts, DeepEquals, &audit.QueryOptions{})
+		return nil, nil
+	})
+
+	req, err := http.NewRequest("GET", "/v2/audit/snap-run", nil)
+	c.Assert(err, IsNil)
+	rsp := getSnapRunAudit(auditSnapRunCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, Equals, 200)
+	c.Check(rsp.Result, DeepEquals, []*audit.SnapRunRecord{})
+}
+
+func (s *apiSuite) TestGetSnapRunAuditErrors(c *C) {
+	s.daemon(c)
+
+	s.mockSnapRunRecords(func(opts *audit.QueryOptions) ([]*audit.SnapRunRecord, error) {
+		return nil, errors.New("boom")
+	})
+
+	for _, t := range []struct {
+		query  string
+		status int
+		err    string
+	}{
+		{"n=x", 400, `invalid value for n: "x"`},
+		{"n=-1", 400, `invalid value for n: "-1"`},
+		{"since=yesterday", 400, `invalid value for since: "yesterday": .*`},
+		{"snap=Foo", 400, `invalid value for snap: .*`},
+		{"", 500, "boom"},
+	} {
+		req, err := http.NewRequest("GET", "/v2/audit/snap-run?"+t.query, nil)
+		c.Assert(err, IsNil)
+		rsp := getSnapRunAudit(auditSnapRunCmd, req, nil).(*resp)
+		c.Check(rsp.Status, Equals, t.status, Commentf(t.query))
+		c.Check(rsp.Result.(*errorResult).Message, Matches, t.err, Commentf(t.query))
+	}
+}
+
+func (s *apiSuite) TestGetSnapRunAuditAsUser(c *C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/audit/snap-run", nil)
+	c.Assert(err, IsNil)
+	req.RemoteAddr = "pid=100;uid=1000;socket=;"
+	rec := httptest.NewRecorder()
+	auditSnapRunCmd.ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 401)
+}
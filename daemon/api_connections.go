@@ -20,9 +20,12 @@
 package daemon
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"sort"
 
+	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/ifacestate"
@@ -34,6 +37,7 @@ var connectionsCmd = &Command{
 	Path:   "/v2/connections",
 	UserOK: true,
 	GET:    getConnections,
+	POST:   postConnections,
 }
 
 type collectFilter struct {
@@ -257,7 +261,7 @@ func getConnections(c *Command, r *http.Request, user *auth.UserState) Response
 	snapName := query.Get("snap")
 	ifaceName := query.Get("interface")
 	qselect := query.Get("select")
-	if qselect != "all" && qselect != "" {
+	if qselect != "all" && qselect != "orphans" && qselect != "" {
 		return BadRequest("unsupported select qualifier")
 	}
 	onlyConnected := qselect == ""
@@ -272,6 +276,13 @@ func getConnections(c *Command, r *http.Request, user *auth.UserState) Response
 		}
 	}
 
+	if qselect == "orphans" {
+		return getOrphanedConnections(c, collectFilter{
+			snapName:  snapName,
+			ifaceName: ifaceName,
+		})
+	}
+
 	connsjson, err := collectConnections(c.d.overlord.InterfaceManager(), collectFilter{
 		snapName:  snapName,
 		ifaceName: ifaceName,
@@ -285,3 +296,78 @@ func getConnections(c *Command, r *http.Request, user *auth.UserState) Response
 
 	return SyncResponse(connsjson, nil)
 }
+
+func getOrphanedConnections(c *Command, filter collectFilter) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	orphans, err := ifacestate.OrphanedConnections(st, c.d.overlord.InterfaceManager().Repository())
+	if err != nil {
+		return InternalError("cannot find orphaned connections: %v", err)
+	}
+	orphansjson := orphanedConnectionsJSON{
+		Orphaned: make([]orphanedConnectionJSON, 0, len(orphans)),
+	}
+	for _, orphan := range orphans {
+		plugRef, slotRef := orphan.ConnRef.PlugRef, orphan.ConnRef.SlotRef
+		if filter.snapName != "" && plugRef.Snap != filter.snapName && slotRef.Snap != filter.snapName {
+			continue
+		}
+		if !filter.ifaceMatches(orphan.Interface) {
+			continue
+		}
+		orphansjson.Orphaned = append(orphansjson.Orphaned, orphanedConnectionJSON{
+			Plug:      plugRef,
+			Slot:      slotRef,
+			Interface: orphan.Interface,
+			Reason:    orphan.Reason,
+			Rebind:    orphan.Rebind,
+		})
+	}
+	return SyncResponse(orphansjson, nil)
+}
+
+type connectionsAction struct {
+	Action string `json:"action"`
+}
+
+func postConnections(c *Command, r *http.Request, user *auth.UserState) Response {
+	var a connectionsAction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&a); err != nil {
+		return BadRequest("cannot decode request body into a connections action: %v", err)
+	}
+	if a.Action != "repair" {
+		return BadRequest("unsupported connections action: %q", a.Action)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	repo := c.d.overlord.InterfaceManager().Repository()
+	orphans, err := ifacestate.OrphanedConnections(st, repo)
+	if err != nil {
+		return InternalError("cannot find orphaned connections: %v", err)
+	}
+	if len(orphans) == 0 {
+		return InterfacesUnchanged("nothing to do")
+	}
+	tss, err := ifacestate.RepairConnections(st, repo, orphans)
+	if err != nil {
+		return errToResponse(err, nil, BadRequest, "cannot repair connections: %v")
+	}
+	conns := make([]*interfaces.ConnRef, 0, len(orphans))
+	for _, orphan := range orphans {
+		conns = append(conns, orphan.ConnRef)
+	}
+	for _, ts := range tss {
+		ts.JoinLane(st.NewLane())
+	}
+	summary := fmt.Sprintf(i18n.G("Repair %d orphaned connections"), len(orphans))
+	change := newChange(st, "repair-connections", summary, tss, snapNamesFromConns(conns))
+	st.EnsureBefore(0)
+
+	return AsyncResponse(nil, &Meta{Change: change.ID()})
+}
@@ -20,6 +20,7 @@
 package daemon
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -1043,3 +1044,144 @@ slots:
 		"type":        "sync",
 	})
 }
+
+func (s *apiSuite) TestConnectionsOrphans(c *check.C) {
+	restore := builtin.MockInterface(&ifacetest.TestInterface{InterfaceName: "test"})
+	defer restore()
+
+	s.daemon(c)
+
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, coreProducerYaml)
+
+	s.testConnectionsConnected(c, "/v2/connections?select=orphans", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{
+			"interface": "test",
+		},
+		"consumer:plug gone:slot": map[string]interface{}{
+			"interface": "test",
+		},
+		"consumer:plug core:hotplug-slot": map[string]interface{}{
+			"interface":    "test",
+			"hotplug-key":  "1234",
+			"hotplug-gone": true,
+		},
+		"consumer:plug other:slot": map[string]interface{}{
+			"interface": "test",
+			"undesired": true,
+		},
+	}, []string{"consumer:plug producer:slot"}, map[string]interface{}{
+		"result": map[string]interface{}{
+			"orphaned": []interface{}{
+				map[string]interface{}{
+					"plug":      map[string]interface{}{"snap": "consumer", "plug": "plug"},
+					"slot":      map[string]interface{}{"snap": "core", "slot": "hotplug-slot"},
+					"interface": "test",
+					"reason":    "hotplug-gone",
+				},
+				map[string]interface{}{
+					"plug":      map[string]interface{}{"snap": "consumer", "plug": "plug"},
+					"slot":      map[string]interface{}{"snap": "gone", "slot": "slot"},
+					"interface": "test",
+					"reason":    "slot-missing",
+				},
+			},
+		},
+		"status":      "OK",
+		"status-code": 200.0,
+		"type":        "sync",
+	})
+
+	// filtering by interface
+	s.testConnections(c, "/v2/connections?select=orphans&interface=other", map[string]interface{}{
+		"result": map[string]interface{}{
+			"orphaned": []interface{}{},
+		},
+		"status":      "OK",
+		"status-code": 200.0,
+		"type":        "sync",
+	})
+}
+
+func (s *apiSuite) testPostConnections(c *check.C, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest("POST", "/v2/connections", bytes.NewBufferString(body))
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	connectionsCmd.POST(connectionsCmd, req, nil).ServeHTTP(rec, req)
+	var rsp map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &rsp)
+	c.Assert(err, check.IsNil)
+	return rec, rsp
+}
+
+func (s *apiSuite) TestConnectionsRepair(c *check.C) {
+	restore := builtin.MockInterface(&ifacetest.TestInterface{InterfaceName: "test"})
+	defer restore()
+
+	d := s.daemon(c)
+
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	st := d.overlord.State()
+	st.Lock()
+	st.Set("conns", map[string]interface{}{
+		"consumer:plug gone:slot": map[string]interface{}{
+			"interface": "test",
+		},
+	})
+	st.Unlock()
+
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	rec, rsp := s.testPostConnections(c, `{"action":"repair"}`)
+	c.Assert(rec.Code, check.Equals, 202)
+	id := rsp["change"].(string)
+
+	st.Lock()
+	chg := st.Change(id)
+	st.Unlock()
+	c.Assert(chg, check.NotNil)
+
+	<-chg.Ready()
+
+	st.Lock()
+	defer st.Unlock()
+	c.Assert(chg.Err(), check.IsNil)
+	c.Check(chg.Kind(), check.Equals, "repair-connections")
+	c.Check(chg.Summary(), check.Equals, "Repair 1 orphaned connections")
+	var snapNames []string
+	c.Assert(chg.Get("snap-names", &snapNames), check.IsNil)
+	c.Check(snapNames, check.DeepEquals, []string{"consumer", "gone"})
+
+	var conns map[string]interface{}
+	c.Assert(st.Get("conns", &conns), check.IsNil)
+	c.Check(conns, check.HasLen, 0)
+}
+
+func (s *apiSuite) TestConnectionsRepairNothingToDo(c *check.C) {
+	s.daemon(c)
+
+	rec, rsp := s.testPostConnections(c, `{"action":"repair"}`)
+	c.Check(rec.Code, check.Equals, 400)
+	c.Check(rsp["result"], check.DeepEquals, map[string]interface{}{
+		"message": "nothing to do",
+		"kind":    "interfaces-unchanged",
+	})
+}
+
+func (s *apiSuite) TestConnectionsPostUnhappy(c *check.C) {
+	s.daemon(c)
+
+	rec, rsp := s.testPostConnections(c, `{"action":"frobnicate"}`)
+	c.Check(rec.Code, check.Equals, 400)
+	c.Check(rsp["result"], check.DeepEquals, map[string]interface{}{
+		"message": `unsupported connections action: "frobnicate"`,
+	})
+
+	rec, rsp = s.testPostConnections(c, `}`)
+	c.Check(rec.Code, check.Equals, 400)
+	c.Check(rsp["result"].(map[string]interface{})["message"], check.Matches, "cannot decode request body into a connections action: .*")
+}
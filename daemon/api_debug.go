@@ -23,18 +23,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"sort"
 	"time"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/timings"
 )
 
+var (
+	secbootTPMCapabilities = secboot.TPMCapabilities
+	secbootSealedKeyInfo   = secboot.SealedKeyInfo
+	bootSealedKeyFiles     = boot.SealedKeyFiles
+)
+
 var debugCmd = &Command{
 	Path:   "/v2/debug",
 	UserOK: true,
@@ -263,9 +272,48 @@ func getChangeTimings(st *state.State, changeID, ensureTag, startupTag string, a
 	return SyncResponse(responseData, nil)
 }
 
+func getTPMCapabilities() Response {
+	info, err := secbootTPMCapabilities()
+	if err != nil {
+		return InternalError("cannot obtain TPM capabilities: %v", err)
+	}
+	return SyncResponse(info, nil)
+}
+
+type debugSealedKey struct {
+	*secboot.SealedKeyDetails
+	KeyFile string `json:"key-file"`
+	Error   string `json:"error,omitempty"`
+}
+
+func getSealedKeys() Response {
+	keys := []debugSealedKey{}
+	for _, keyFile := range bootSealedKeyFiles() {
+		if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+			continue
+		}
+		key := debugSealedKey{KeyFile: keyFile}
+		details, err := secbootSealedKeyInfo(keyFile)
+		if err != nil {
+			key.Error = err.Error()
+		} else {
+			key.SealedKeyDetails = details
+		}
+		keys = append(keys, key)
+	}
+	return SyncResponse(keys, nil)
+}
+
 func getDebug(c *Command, r *http.Request, user *auth.UserState) Response {
 	query := r.URL.Query()
 	aspect := query.Get("aspect")
+	switch aspect {
+	case "tpm":
+		// talking to the TPM does not need the state
+		return getTPMCapabilities()
+	case "sealed-keys":
+		return getSealedKeys()
+	}
 	st := c.d.overlord.State()
 	st.Lock()
 	defer st.Unlock()
@@ -290,6 +338,8 @@ func getDebug(c *Command, r *http.Request, user *auth.UserState) Response {
 		return getChangeTimings(st, chgID, ensureTag, startupTag, all == "true")
 	case "seeding":
 		return getSeedingInfo(st)
+	case "device-init-log":
+		return getDeviceInitLog(st)
 	default:
 		return BadRequest("unknown debug aspect %q", aspect)
 	}
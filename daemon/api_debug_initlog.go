@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type deviceInitLog struct {
+	// Log holds the recorded device initialization steps, oldest first.
+	Log []*devicestate.InitLogEntry `json:"log"`
+
+	// Checkpoint tells how far the device initialization got.
+	Checkpoint *devicestate.InitCheckpoint `json:"checkpoint"`
+}
+
+func getDeviceInitLog(st *state.State) Response {
+	entries, err := devicestate.InitLog(st)
+	if err != nil {
+		return InternalError(err.Error())
+	}
+	if entries == nil {
+		entries = []*devicestate.InitLogEntry{}
+	}
+	return SyncResponse(&deviceInitLog{
+		Log:        entries,
+		Checkpoint: devicestate.Checkpoint(entries),
+	}, nil)
+}
@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/devicestate"
+)
+
+var _ = Suite(&initLogDebugSuite{})
+
+type initLogDebugSuite struct {
+	apiBaseSuite
+}
+
+func (s *initLogDebugSuite) SetUpTest(c *C) {
+	s.apiBaseSuite.SetUpTest(c)
+	s.daemonWithOverlordMock(c)
+}
+
+func (s *initLogDebugSuite) getInitLogDebug(c *C) interface{} {
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=device-init-log", nil)
+	c.Assert(err, IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, Equals, ResponseTypeSync)
+	return rsp.Result
+}
+
+func (s *initLogDebugSuite) TestNoData(c *C) {
+	data := s.getInitLogDebug(c)
+	c.Check(data, DeepEquals, &deviceInitLog{
+		Log:        []*devicestate.InitLogEntry{},
+		Checkpoint: &devicestate.InitCheckpoint{},
+	})
+}
+
+func (s *initLogDebugSuite) TestInitLog(c *C) {
+	t0, err := time.Parse(time.RFC3339, "2021-01-01T10:00:00Z")
+	c.Assert(err, IsNil)
+	entries := []*devicestate.InitLogEntry{
+		{Step: "seeding", Status: "started", Time: t0, Detail: "change 1"},
+		{Step: "seeding", Status: "done", Time: t0.Add(time.Minute)},
+		{Step: "registration", Status: "started", Time: t0.Add(2 * time.Minute), Detail: "change 2"},
+	}
+
+	st := s.d.overlord.State()
+	st.Lock()
+	st.Set("device-init-log", entries)
+	st.Unlock()
+
+	data := s.getInitLogDebug(c)
+	c.Check(data, DeepEquals, &deviceInitLog{
+		Log: entries,
+		Checkpoint: &devicestate.InitCheckpoint{
+			LastDone: "seeding",
+			Pending:  []string{"registration"},
+		},
+	})
+}
@@ -22,11 +22,16 @@ package daemon
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/testutil"
 	"github.com/snapcore/snapd/timings"
 )
@@ -157,6 +162,100 @@ func (s *postDebugSuite) TestGetDebugBaseDeclaration(c *check.C) {
 		testutil.Contains, "type: base-declaration")
 }
 
+func (s *postDebugSuite) TestGetDebugTPM(c *check.C) {
+	_ = s.daemon(c)
+
+	restore := MockSecbootTPMCapabilities(func() (*secboot.TPMInfo, error) {
+		return &secboot.TPMInfo{
+			Manufacturer: "AMD",
+			Vendor:       secboot.TPMVendorAMDfTPM,
+			Firmware:     true,
+			PCRBanks:     []string{"sha1", "sha256"},
+			Quirks:       secboot.TPMQuirks{SlowNVWrites: true, MissingSHA384Bank: true},
+		}, nil
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=tpm", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, &secboot.TPMInfo{
+		Manufacturer: "AMD",
+		Vendor:       secboot.TPMVendorAMDfTPM,
+		Firmware:     true,
+		PCRBanks:     []string{"sha1", "sha256"},
+		Quirks:       secboot.TPMQuirks{SlowNVWrites: true, MissingSHA384Bank: true},
+	})
+}
+
+func (s *postDebugSuite) TestGetDebugTPMError(c *check.C) {
+	_ = s.daemon(c)
+
+	restore := MockSecbootTPMCapabilities(func() (*secboot.TPMInfo, error) {
+		return nil, errors.New("no TPM")
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=tpm", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 500)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "cannot obtain TPM capabilities: no TPM")
+}
+
+func (s *postDebugSuite) TestGetDebugSealedKeys(c *check.C) {
+	_ = s.daemon(c)
+
+	d := c.MkDir()
+	runKey := filepath.Join(d, "run.sealed-key")
+	fallbackKey := filepath.Join(d, "fallback.sealed-key")
+	c.Assert(ioutil.WriteFile(runKey, nil, 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(fallbackKey, nil, 0600), check.IsNil)
+	restore := MockBootSealedKeyFiles(func() []string {
+		return []string{runKey, fallbackKey, filepath.Join(d, "missing.sealed-key")}
+	})
+	defer restore()
+
+	created := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	restore = MockSecbootSealedKeyInfo(func(keyFile string) (*secboot.SealedKeyDetails, error) {
+		if keyFile == fallbackKey {
+			return nil, errors.New("cannot read key")
+		}
+		c.Check(keyFile, check.Equals, runKey)
+		return &secboot.SealedKeyDetails{
+			KeyFile:                keyFile,
+			PCRPolicyCounterHandle: 0x01880001,
+			PCRSelection:           []int{4, 7, 12},
+			Created:                created,
+		}, nil
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=sealed-keys", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []debugSealedKey{
+		{
+			SealedKeyDetails: &secboot.SealedKeyDetails{
+				KeyFile:                runKey,
+				PCRPolicyCounterHandle: 0x01880001,
+				PCRSelection:           []int{4, 7, 12},
+				Created:                created,
+			},
+			KeyFile: runKey,
+		}, {
+			KeyFile: fallbackKey,
+			Error:   "cannot read key",
+		},
+	})
+}
+
 func mockDurationThreshold() func() {
 	oldDurationThreshold := timings.DurationThreshold
 	restore := func() {
@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/client/clientutil"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/systemd"
+)
+
+var eventsCmd = &Command{
+	Path:   "/v2/events",
+	UserOK: true,
+	GET:    getEvents,
+}
+
+// getEvents streams the events of the given types, only the status of
+// services can be watched for now.
+func getEvents(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	types := strutil.CommaSeparatedList(query.Get("types"))
+	if len(types) == 0 {
+		return BadRequest("missing event types")
+	}
+	for _, t := range types {
+		if t != client.EventServiceStatus {
+			return BadRequest("unsupported event type %q", t)
+		}
+	}
+
+	opts := appInfoOptions{service: true}
+	appInfos, rsp := appInfosFor(c.d.overlord.State(), strutil.CommaSeparatedList(query.Get("names")), opts)
+	if rsp != nil {
+		return rsp
+	}
+	if len(appInfos) == 0 {
+		return AppNotFound("no matching services")
+	}
+
+	serviceNames := make([]string, len(appInfos))
+	for i, appInfo := range appInfos {
+		serviceNames[i] = appInfo.ServiceName()
+	}
+	// systemd logs the jobs it runs for the units and the state changes
+	// of their processes in their journal, only the new entries are
+	// wanted
+	sysd := systemd.New(systemd.SystemMode, progress.Null)
+	reader, err := sysd.LogReader(serviceNames, 0, true)
+	if err != nil {
+		return InternalError("cannot watch services: %v", err)
+	}
+
+	sd := servicestate.NewStatusDecorator(progress.Null)
+	return &serviceStatusSeqResponse{
+		ReadCloser: reader,
+		status: func() ([]*client.AppInfo, error) {
+			clientAppInfos, err := clientutil.ClientAppInfosFromSnapAppInfos(appInfos, sd)
+			if err != nil {
+				return nil, err
+			}
+			services := make([]*client.AppInfo, len(clientAppInfos))
+			for i := range clientAppInfos {
+				services[i] = &clientAppInfos[i]
+			}
+			return services, nil
+		},
+		stop: c.d.Dying(),
+	}
+}
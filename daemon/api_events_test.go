@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *appSuite) TestGetEventsServiceStatus(c *check.C) {
+	for _, state := range []string{"inactive", "active", "active"} {
+		s.sysctlBufs = append(s.sysctlBufs, []byte(fmt.Sprintf(`
+Id=snap.snap-a.svc1.service
+Type=simple
+ActiveState=%s
+UnitFileState=enabled
+`[1:], state)))
+	}
+	s.jctlRCs = []io.ReadCloser{ioutil.NopCloser(strings.NewReader(`
+{"MESSAGE": "Started Service for snap application snap-a.svc1.", "SYSLOG_IDENTIFIER": "systemd", "_PID": "1", "__REALTIME_TIMESTAMP": "42"}
+{"MESSAGE": "hello", "SYSLOG_IDENTIFIER": "snap-a.svc1", "_PID": "42", "__REALTIME_TIMESTAMP": "44"}
+{"MESSAGE": "snap.snap-a.svc1.service: Got notification message from PID 42", "SYSLOG_IDENTIFIER": "systemd", "_PID": "1", "__REALTIME_TIMESTAMP": "46"}
+	`))}
+
+	req, err := http.NewRequest("GET", "/v2/events?names=snap-a.svc1&types=service-status", nil)
+	c.Assert(err, check.IsNil)
+
+	rec := httptest.NewRecorder()
+	getEvents(eventsCmd, req, nil).ServeHTTP(rec, req)
+
+	c.Check(s.jctlSvcses, check.DeepEquals, [][]string{{"snap.snap-a.svc1.service"}})
+	c.Check(s.jctlNs, check.DeepEquals, []int{0})
+	c.Check(s.jctlFollows, check.DeepEquals, []bool{true})
+
+	// the status is sent first and then only when it changes
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, "application/json-seq")
+	c.Check(rec.Body.String(), check.Equals, `
+{"type":"service-status","services":[{"snap":"snap-a","name":"svc1","daemon":"simple","enabled":true}]}
+{"type":"service-status","services":[{"snap":"snap-a","name":"svc1","daemon":"simple","enabled":true,"active":true}]}
+`[1:])
+	c.Check(s.sysctlBufs, check.HasLen, 0)
+}
+
+func (s *appSuite) TestGetEventsBad(c *check.C) {
+	for _, t := range []struct {
+		query  string
+		status int
+	}{
+		{"", 400},
+		{"types=potato", 400},
+		{"types=service-status&names=snap-d", 404},
+	} {
+		req, err := http.NewRequest("GET", "/v2/events?"+t.query, nil)
+		c.Assert(err, check.IsNil)
+
+		rsp := getEvents(eventsCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf(t.query))
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	}
+	c.Check(s.jctlSvcses, check.HasLen, 0)
+}
@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *apiSuite) TestPostFactorySeal(c *check.C) {
+	d := s.daemonWithOverlordMock(c)
+	st := d.overlord.State()
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+		ensureStateSoonImpl(st)
+	}
+
+	devicestateFactorySeal = func(st *state.State) (*state.Change, error) {
+		return st.NewChange("factory-seal", "..."), nil
+	}
+	defer func() { devicestateFactorySeal = devicestate.FactorySeal }()
+
+	req, err := http.NewRequest("POST", "/v2/factory", bytes.NewBufferString(`{"action":"seal"}`))
+	c.Assert(err, check.IsNil)
+	rsp := postFactory(factoryCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 202)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "factory-seal")
+	c.Check(soon, check.Equals, 1)
+}
+
+func (s *apiSuite) TestPostFactoryErrors(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	var sealErr error
+	devicestateFactorySeal = func(st *state.State) (*state.Change, error) {
+		return nil, sealErr
+	}
+	defer func() { devicestateFactorySeal = devicestate.FactorySeal }()
+
+	for _, tc := range []struct {
+		body    string
+		sealErr error
+		status  int
+		kind    client.ErrorKind
+		message string
+	}{
+		{`}`, nil, 400, "", `cannot decode request body into factory operation: .*`},
+		{`{"action":"foo"}`, nil, 400, "", `unsupported factory action "foo"`},
+		{`{"action":"seal"}`, errors.New("cannot seal the device: not in factory mode"), 400, "", `cannot seal the device: not in factory mode`},
+		{`{"action":"seal"}`, &snapstate.ChangeConflictError{Message: "cannot seal the device: sealing already in progress", ChangeKind: "factory-seal"},
+			409, client.ErrorKindSnapChangeConflict, `cannot seal the device: sealing already in progress`},
+	} {
+		sealErr = tc.sealErr
+		req, err := http.NewRequest("POST", "/v2/factory", bytes.NewBufferString(tc.body))
+		c.Assert(err, check.IsNil)
+		rsp := postFactory(factoryCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, tc.status)
+		c.Check(rsp.Result.(*errorResult).Kind, check.Equals, tc.kind)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, tc.message)
+	}
+}
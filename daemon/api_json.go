@@ -89,3 +89,18 @@ type connectionsJSON struct {
 	Plugs       []*plugJSON      `json:"plugs"`
 	Slots       []*slotJSON      `json:"slots"`
 }
+
+// orphanedConnectionJSON aids in marshaling a connection whose plug or slot
+// is gone into JSON.
+type orphanedConnectionJSON struct {
+	Slot      interfaces.SlotRef  `json:"slot"`
+	Plug      interfaces.PlugRef  `json:"plug"`
+	Interface string              `json:"interface"`
+	Reason    string              `json:"reason"`
+	Rebind    *interfaces.SlotRef `json:"rebind,omitempty"`
+}
+
+// orphanedConnectionsJSON aids in marshaling orphaned connections into JSON.
+type orphanedConnectionsJSON struct {
+	Orphaned []orphanedConnectionJSON `json:"orphaned"`
+}
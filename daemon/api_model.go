@@ -20,11 +20,23 @@
 package daemon
 
 import (
+	"archive/tar"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/snapasserts"
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/state"
@@ -51,7 +63,10 @@ const (
 	modelType
 )
 
-var devicestateRemodel = devicestate.Remodel
+var (
+	devicestateRemodel        = devicestate.Remodel
+	devicestateRemodelOffline = devicestate.RemodelOffline
+)
 
 type postModelData struct {
 	NewModel string `json:"new-model"`
@@ -64,32 +79,173 @@ type modelAssertJSONResponse struct {
 
 func postModel(c *Command, r *http.Request, _ *auth.UserState) Response {
 	defer r.Body.Close()
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		return postOfflineRemodel(c, r, params["boundary"])
+	}
+
 	var data postModelData
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&data); err != nil {
 		return BadRequest("cannot decode request body into remodel operation: %v", err)
 	}
-	rawNewModel, err := asserts.Decode([]byte(data.NewModel))
+	newModel, rsp := decodeNewModel(data.NewModel)
+	if rsp != nil {
+		return rsp
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	chg, err := devicestateRemodel(st, newModel)
+	if err != nil {
+		return BadRequest("cannot remodel device: %v", err)
+	}
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+
+}
+
+func decodeNewModel(encoded string) (*asserts.Model, Response) {
+	rawNewModel, err := asserts.Decode([]byte(encoded))
 	if err != nil {
-		return BadRequest("cannot decode new model assertion: %v", err)
+		return nil, BadRequest("cannot decode new model assertion: %v", err)
 	}
 	newModel, ok := rawNewModel.(*asserts.Model)
 	if !ok {
-		return BadRequest("new model is not a model assertion: %v", newModel.Type())
+		return nil, BadRequest("new model is not a model assertion: %v", rawNewModel.Type())
+	}
+	return newModel, nil
+}
+
+type bundleSnap struct {
+	name string
+	path string
+}
+
+// unpackRemodelBundle spools the snaps of a remodel bundle, a tarball of
+// snap files and assertion files, to temporary files and adds the
+// assertions to batch.
+func unpackRemodelBundle(bundle io.Reader, batch *asserts.Batch) (snaps []bundleSnap, err error) {
+	tr := tar.NewReader(bundle)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return snaps, nil
+		}
+		if err != nil {
+			return snaps, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := hdr.Name
+		switch {
+		case hdr.Typeflag != tar.TypeReg:
+			return snaps, fmt.Errorf("unsupported entry %q", name)
+		case filepath.Ext(name) == ".assert":
+			if _, err := batch.AddStream(tr); err != nil {
+				return snaps, fmt.Errorf("cannot decode assertions from %q: %v", name, err)
+			}
+		case filepath.Ext(name) == ".snap":
+			// see localInstallCleanup in snapstate/snapmgr.go
+			tmpf, err := ioutil.TempFile(dirs.SnapBlobDir, dirs.LocalInstallBlobTempPrefix)
+			if err != nil {
+				return snaps, err
+			}
+			snaps = append(snaps, bundleSnap{name: name, path: tmpf.Name()})
+			_, err = io.Copy(tmpf, tr)
+			tmpf.Close()
+			if err != nil {
+				return snaps, fmt.Errorf("cannot copy %q: %v", name, err)
+			}
+		default:
+			return snaps, fmt.Errorf("unexpected file %q", name)
+		}
+	}
+}
+
+// postOfflineRemodel remodels the device without using the store, the
+// new model and a bundle with the needed snaps and assertions come as a
+// multipart/form-data upload.
+func postOfflineRemodel(c *Command, r *http.Request, boundary string) Response {
+	form, err := multipart.NewReader(r.Body, boundary).ReadForm(maxReadBuflen)
+	if err != nil {
+		return BadRequest("cannot read POST form: %v", err)
+	}
+	defer form.RemoveAll()
+
+	if len(form.Value["new-model"]) != 1 {
+		return BadRequest("need exactly one 'new-model' value in form")
+	}
+	newModel, rsp := decodeNewModel(form.Value["new-model"][0])
+	if rsp != nil {
+		return rsp
+	}
+	if len(form.File["bundle"]) > 1 {
+		return BadRequest("cannot use more than one remodel bundle")
+	}
+
+	// we are in charge of the snap files until we hand them off to the
+	// change
+	var snaps []bundleSnap
+	changeTriggered := false
+	defer func() {
+		if !changeTriggered {
+			for _, sn := range snaps {
+				os.Remove(sn.path)
+			}
+		}
+	}()
+
+	batch := asserts.NewBatch(nil)
+	for _, fheader := range form.File["bundle"] {
+		bundle, err := fheader.Open()
+		if err != nil {
+			return BadRequest(`cannot open uploaded "bundle" file: %v`, err)
+		}
+		snaps, err = unpackRemodelBundle(bundle, batch)
+		bundle.Close()
+		if err != nil {
+			return BadRequest("cannot read remodel bundle: %v", err)
+		}
 	}
 
 	st := c.d.overlord.State()
 	st.Lock()
 	defer st.Unlock()
 
-	chg, err := devicestateRemodel(st, newModel)
+	if err := assertstate.AddBatch(st, batch, &asserts.CommitOptions{
+		Precheck: true,
+	}); err != nil {
+		return BadRequest("cannot add assertions from remodel bundle: %v", err)
+	}
+
+	localSnaps := make([]*devicestate.LocalSnap, 0, len(snaps))
+	for _, sn := range snaps {
+		si, err := snapasserts.DeriveSideInfo(sn.path, assertstate.DB(st))
+		if err != nil {
+			return BadRequest("cannot find signatures with metadata for snap %q from remodel bundle: %v", sn.name, err)
+		}
+		localSnaps = append(localSnaps, &devicestate.LocalSnap{
+			SideInfo: si,
+			Path:     sn.path,
+		})
+	}
+
+	// the snap files are handed off to the remodel, which removes them
+	// on error or once they are not needed anymore
+	changeTriggered = true
+	chg, err := devicestateRemodelOffline(st, newModel, localSnaps)
 	if err != nil {
 		return BadRequest("cannot remodel device: %v", err)
 	}
 	ensureStateSoon(st)
 
 	return AsyncResponse(nil, &Meta{Change: chg.ID()})
-
 }
 
 // getModel gets the current model assertion using the DeviceManager
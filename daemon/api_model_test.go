@@ -20,9 +20,12 @@
 package daemon
 
 import (
+	"archive/tar"
 	"bytes"
 	"encoding/json"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"gopkg.in/check.v1"
@@ -30,12 +33,16 @@ import (
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/assertstest"
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/assertstate/assertstatetest"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
 )
 
 func (s *apiSuite) TestPostRemodelUnhappy(c *check.C) {
@@ -111,6 +118,158 @@ func (s *apiSuite) TestPostRemodel(c *check.C) {
 	c.Assert(soon, check.Equals, 1)
 }
 
+func (s *apiSuite) TestPostOfflineRemodel(c *check.C) {
+	newModel := s.brands.Model("my-brand", "my-new-model", modelDefaults)
+
+	d := s.daemonWithOverlordMock(c)
+	st := d.overlord.State()
+	st.Lock()
+	assertstatetest.AddMany(st, s.storeSigning.StoreAccountKey(""))
+	st.Unlock()
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+		ensureStateSoonImpl(st)
+	}
+	defer func() { ensureStateSoon = func(st *state.State) {} }()
+
+	// the snap and its assertions are in the bundle
+	dev1Acct := assertstest.NewAccount(s.storeSigning, "devel1", nil, "")
+	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-id":      "x-id",
+		"snap-name":    "x",
+		"publisher-id": dev1Acct.AccountID(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-sha3-384": "YK0GWATaZf09g_fvspYPqm_qtaiqf-KjaNj5uMEQCjQpuXWPjqQbeBINL5H_A0Lo",
+		"snap-size":     "5",
+		"snap-id":       "x-id",
+		"snap-revision": "41",
+		"developer-id":  dev1Acct.AccountID(),
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	assertsData := bytes.NewBuffer(nil)
+	enc := asserts.NewEncoder(assertsData)
+	for _, a := range []asserts.Assertion{dev1Acct, snapDecl, snapRev} {
+		c.Assert(enc.Encode(a), check.IsNil)
+	}
+
+	bundle := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(bundle)
+	for _, f := range []struct {
+		name    string
+		content []byte
+	}{
+		{"x.assert", assertsData.Bytes()},
+		{"x_41.snap", []byte("xyzzy")},
+	} {
+		c.Assert(tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.content)),
+			Typeflag: tar.TypeReg,
+		}), check.IsNil)
+		_, err := tw.Write(f.content)
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(tw.Close(), check.IsNil)
+
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	c.Assert(mw.WriteField("new-model", string(asserts.Encode(newModel))), check.IsNil)
+	fw, err := mw.CreateFormFile("bundle", "snaps.tar")
+	c.Assert(err, check.IsNil)
+	_, err = fw.Write(bundle.Bytes())
+	c.Assert(err, check.IsNil)
+	c.Assert(mw.Close(), check.IsNil)
+
+	var snapPath string
+	devicestateRemodelOffline = func(st *state.State, nm *asserts.Model, localSnaps []*devicestate.LocalSnap) (*state.Change, error) {
+		c.Check(nm, check.DeepEquals, newModel)
+		c.Assert(localSnaps, check.HasLen, 1)
+		c.Check(localSnaps[0].SideInfo, check.DeepEquals, &snap.SideInfo{
+			RealName: "x",
+			SnapID:   "x-id",
+			Revision: snap.R(41),
+		})
+		snapPath = localSnaps[0].Path
+		c.Check(snapPath, testutil.FileEquals, "xyzzy")
+		return st.NewChange("remodel", "..."), nil
+	}
+	defer func() { devicestateRemodelOffline = devicestate.RemodelOffline }()
+
+	req, err := http.NewRequest("POST", "/v2/model", body)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rsp := postModel(appsCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Check(soon, check.Equals, 1)
+	// the snap file is left for the change
+	c.Check(snapPath, testutil.FilePresent)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "remodel")
+
+	// the assertions from the bundle were added
+	_, err = assertstate.DB(st).Find(asserts.SnapDeclarationType, map[string]string{
+		"series":  "16",
+		"snap-id": "x-id",
+	})
+	c.Check(err, check.IsNil)
+}
+
+func (s *apiSuite) TestPostOfflineRemodelMissingSignatures(c *check.C) {
+	newModel := s.brands.Model("my-brand", "my-new-model", modelDefaults)
+	s.daemonWithOverlordMock(c)
+
+	bundle := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(bundle)
+	c.Assert(tw.WriteHeader(&tar.Header{
+		Name:     "x_41.snap",
+		Mode:     0644,
+		Size:     5,
+		Typeflag: tar.TypeReg,
+	}), check.IsNil)
+	_, err := tw.Write([]byte("xyzzy"))
+	c.Assert(err, check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	c.Assert(mw.WriteField("new-model", string(asserts.Encode(newModel))), check.IsNil)
+	fw, err := mw.CreateFormFile("bundle", "snaps.tar")
+	c.Assert(err, check.IsNil)
+	_, err = fw.Write(bundle.Bytes())
+	c.Assert(err, check.IsNil)
+	c.Assert(mw.Close(), check.IsNil)
+
+	devicestateRemodelOffline = func(st *state.State, nm *asserts.Model, localSnaps []*devicestate.LocalSnap) (*state.Change, error) {
+		c.Fatalf("unexpected remodel")
+		return nil, nil
+	}
+	defer func() { devicestateRemodelOffline = devicestate.RemodelOffline }()
+
+	req, err := http.NewRequest("POST", "/v2/model", body)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rsp := postModel(appsCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Matches, `cannot find signatures with metadata for snap "x_41.snap" from remodel bundle: .*`)
+
+	// the spooled snap file was removed
+	matches, err := filepath.Glob(filepath.Join(dirs.SnapBlobDir, dirs.LocalInstallBlobTempPrefix+"*"))
+	c.Assert(err, check.IsNil)
+	c.Check(matches, check.HasLen, 0)
+}
+
 func (s *apiSuite) TestGetModelNoModelAssertion(c *check.C) {
 
 	d := s.daemonWithOverlordMock(c)
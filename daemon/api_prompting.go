@@ -0,0 +1,206 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var promptingRulesCmd = &Command{
+	Path:     "/v2/interfaces/prompting/rules",
+	UserOK:   true,
+	PolkitOK: "io.snapcraft.snapd.manage-interfaces",
+	GET:      getPromptingRules,
+	POST:     postPromptingRules,
+}
+
+// promptingRequestUser returns the user making the request, or nil for
+// root which can manage the prompting rules of any user.
+func promptingRequestUser(r *http.Request) (user *uint32, rsp Response) {
+	_, uid, _, err := ucrednetGet(r.RemoteAddr)
+	if err != nil {
+		return nil, Forbidden("cannot get remote user: %v", err)
+	}
+	if uid == 0 {
+		return nil, nil
+	}
+	return &uid, nil
+}
+
+func getPromptingRules(c *Command, r *http.Request, user *auth.UserState) Response {
+	reqUser, rsp := promptingRequestUser(r)
+	if rsp != nil {
+		return rsp
+	}
+
+	query := r.URL.Query()
+	filter := &ifacestate.PromptingRulesFilter{
+		User:      reqUser,
+		Snap:      query.Get("snap"),
+		Interface: query.Get("interface"),
+	}
+	if s := query.Get("user"); s != "" {
+		uid, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return BadRequest("invalid value for user: %q", s)
+		}
+		if reqUser != nil && uint32(uid) != *reqUser {
+			return Forbidden("cannot access the prompting rules of another user")
+		}
+		u32 := uint32(uid)
+		filter.User = &u32
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	rules, err := ifacestate.PromptingRules(st, filter)
+	if err != nil {
+		return InternalError("%v", err)
+	}
+	if rules == nil {
+		rules = []*prompting.Rule{}
+	}
+	return SyncResponse(rules, nil)
+}
+
+// newPromptingRule is a prompting rule to add, see
+// client.NewPromptingRule.
+type newPromptingRule struct {
+	User        *uint32            `json:"user"`
+	Snap        string             `json:"snap"`
+	Interface   string             `json:"interface"`
+	PathPattern string             `json:"path-pattern"`
+	Permissions []string           `json:"permissions"`
+	Outcome     prompting.Outcome  `json:"outcome"`
+	Lifespan    prompting.Lifespan `json:"lifespan"`
+	Duration    string             `json:"duration"`
+}
+
+type promptingRulesAction struct {
+	Action  string            `json:"action"`
+	Rule    *newPromptingRule `json:"rule"`
+	ID      string            `json:"id"`
+	Rules   []*prompting.Rule `json:"rules"`
+	Replace bool              `json:"replace"`
+}
+
+func postPromptingRules(c *Command, r *http.Request, user *auth.UserState) Response {
+	reqUser, rsp := promptingRequestUser(r)
+	if rsp != nil {
+		return rsp
+	}
+
+	var action promptingRulesAction
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&action); err != nil {
+		return BadRequest("cannot decode request body into prompting rules action: %v", err)
+	}
+	if dec.More() {
+		return BadRequest("spurious content after prompting rules action")
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	switch action.Action {
+	case "add":
+		return addPromptingRule(st, reqUser, action.Rule)
+	case "remove":
+		if action.ID == "" {
+			return BadRequest("prompting rule ID must be provided")
+		}
+		rule, err := ifacestate.RemovePromptingRule(st, reqUser, action.ID)
+		if err != nil {
+			if _, ok := err.(*ifacestate.NoPromptingRuleError); ok {
+				return NotFound("%v", err)
+			}
+			return InternalError("%v", err)
+		}
+		return SyncResponse(rule, nil)
+	case "import":
+		var replace *ifacestate.PromptingRulesFilter
+		if action.Replace {
+			replace = &ifacestate.PromptingRulesFilter{User: reqUser}
+		}
+		if reqUser != nil {
+			for _, rule := range action.Rules {
+				rule.User = *reqUser
+			}
+		}
+		imported, err := ifacestate.ImportPromptingRules(st, action.Rules, replace)
+		if err != nil {
+			return BadRequest("%v", err)
+		}
+		if imported == nil {
+			imported = []*prompting.Rule{}
+		}
+		return SyncResponse(imported, nil)
+	default:
+		return BadRequest("unknown prompting rules action %q", action.Action)
+	}
+}
+
+func addPromptingRule(st *state.State, reqUser *uint32, newRule *newPromptingRule) Response {
+	if newRule == nil {
+		return BadRequest("prompting rule must be provided")
+	}
+	rule := &prompting.Rule{
+		Snap:        newRule.Snap,
+		Interface:   newRule.Interface,
+		PathPattern: newRule.PathPattern,
+		Permissions: newRule.Permissions,
+		Outcome:     newRule.Outcome,
+		Lifespan:    newRule.Lifespan,
+	}
+	switch {
+	case reqUser != nil:
+		if newRule.User != nil && *newRule.User != *reqUser {
+			return Forbidden("cannot add a prompting rule for another user")
+		}
+		rule.User = *reqUser
+	case newRule.User != nil:
+		rule.User = *newRule.User
+	}
+	if newRule.Duration != "" {
+		if rule.Lifespan != prompting.LifespanTimespan {
+			return BadRequest("duration can only be used with a %q lifespan", prompting.LifespanTimespan)
+		}
+		d, err := time.ParseDuration(newRule.Duration)
+		if err != nil || d <= 0 {
+			return BadRequest("invalid duration %q", newRule.Duration)
+		}
+		rule.Expiration = time.Now().Add(d)
+	}
+	if err := ifacestate.AddPromptingRule(st, rule); err != nil {
+		return BadRequest("%v", err)
+	}
+	return SyncResponse(rule, nil)
+}
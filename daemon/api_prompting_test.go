@@ -0,0 +1,197 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+)
+
+func (s *apiSuite) promptingRulesRequest(c *C, method, query, body, remoteAddr string) *resp {
+	req, err := http.NewRequest(method, "/v2/interfaces/prompting/rules"+query, bytes.NewBufferString(body))
+	c.Assert(err, IsNil)
+	req.RemoteAddr = remoteAddr
+	if method == "GET" {
+		return getPromptingRules(promptingRulesCmd, req, nil).(*resp)
+	}
+	return postPromptingRules(promptingRulesCmd, req, nil).(*resp)
+}
+
+func (s *apiSuite) TestPromptingRulesAddAndList(c *C) {
+	d := s.daemon(c)
+	before := time.Now()
+
+	rsp := s.promptingRulesRequest(c, "POST", "", `{"action": "add", "rule": {
+		"snap": "foo", "interface": "home", "path-pattern": "/home/test/**",
+		"permissions": ["read"], "outcome": "allow", "lifespan": "timespan", "duration": "1h"}}`,
+		"pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200, Commentf("%v", rsp.Result))
+	added := rsp.Result.(*prompting.Rule)
+	c.Check(added.ID, Equals, "0000000000000001")
+	c.Check(added.User, Equals, uint32(1000))
+	c.Check(added.Lifespan, Equals, prompting.LifespanTimespan)
+	c.Check(added.Expiration.Before(before.Add(time.Hour)), Equals, false)
+	c.Check(added.Expiration.After(time.Now().Add(time.Hour)), Equals, false)
+
+	// root adds a rule for another user
+	rsp = s.promptingRulesRequest(c, "POST", "", `{"action": "add", "rule": {
+		"user": 1001, "snap": "bar", "interface": "home", "path-pattern": "/home/other/*",
+		"permissions": ["write"], "outcome": "deny", "lifespan": "forever"}}`,
+		"pid=100;uid=0;socket=;")
+	c.Assert(rsp.Status, Equals, 200, Commentf("%v", rsp.Result))
+	c.Check(rsp.Result.(*prompting.Rule).User, Equals, uint32(1001))
+
+	// users only see their own rules
+	rsp = s.promptingRulesRequest(c, "GET", "", "", "pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200)
+	c.Assert(rsp.Result, HasLen, 1)
+	c.Check(rsp.Result.([]*prompting.Rule)[0].ID, Equals, added.ID)
+
+	// root sees them all
+	rsp = s.promptingRulesRequest(c, "GET", "", "", "pid=100;uid=0;socket=;")
+	c.Assert(rsp.Status, Equals, 200)
+	c.Check(rsp.Result, HasLen, 2)
+
+	rsp = s.promptingRulesRequest(c, "GET", "?snap=bar&interface=home", "", "pid=100;uid=0;socket=;")
+	c.Assert(rsp.Status, Equals, 200)
+	c.Assert(rsp.Result, HasLen, 1)
+	c.Check(rsp.Result.([]*prompting.Rule)[0].Snap, Equals, "bar")
+
+	rsp = s.promptingRulesRequest(c, "GET", "?user=1000", "", "pid=100;uid=0;socket=;")
+	c.Assert(rsp.Status, Equals, 200)
+	c.Assert(rsp.Result, HasLen, 1)
+	c.Check(rsp.Result.([]*prompting.Rule)[0].ID, Equals, added.ID)
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	rules, err := ifacestate.PromptingRules(st, nil)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 2)
+}
+
+func (s *apiSuite) TestPromptingRulesListEmpty(c *C) {
+	s.daemon(c)
+
+	rsp := s.promptingRulesRequest(c, "GET", "", "", "pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200)
+	c.Check(rsp.Result, DeepEquals, []*prompting.Rule{})
+}
+
+func (s *apiSuite) TestPromptingRulesErrors(c *C) {
+	s.daemon(c)
+
+	for _, t := range []struct {
+		method string
+		query  string
+		body   string
+		uid    string
+		status int
+		err    string
+	}{
+		{"GET", "?user=x", "", "1000", 400, `invalid value for user: "x"`},
+		{"GET", "?user=1001", "", "1000", 403, `cannot access the prompting rules of another user`},
+		{"POST", "", `{"action": "frobnicate"}`, "0", 400, `unknown prompting rules action "frobnicate"`},
+		{"POST", "", `{"action": "add"}{}`, "0", 400, `spurious content after prompting rules action`},
+		{"POST", "", `{"action": "add"}`, "0", 400, `prompting rule must be provided`},
+		{"POST", "", `{"action": "add", "rule": {"user": 1001, "snap": "foo"}}`, "1000", 403, `cannot add a prompting rule for another user`},
+		{"POST", "", `{"action": "add", "rule": {"snap": "foo", "interface": "home", "path-pattern": "/home/test/*", "permissions": ["read"], "outcome": "allow", "lifespan": "forever", "duration": "1h"}}`, "0", 400, `duration can only be used with a "timespan" lifespan`},
+		{"POST", "", `{"action": "add", "rule": {"snap": "foo", "interface": "home", "path-pattern": "/home/test/*", "permissions": ["read"], "outcome": "allow", "lifespan": "timespan", "duration": "-1h"}}`, "0", 400, `invalid duration "-1h"`},
+		{"POST", "", `{"action": "add", "rule": {"snap": "foo", "interface": "home", "path-pattern": "/home/test/*", "permissions": ["read"], "outcome": "allow", "lifespan": "timespan"}}`, "0", 400, `cannot add prompting rule: expiration must be set for a "timespan" lifespan`},
+		{"POST", "", `{"action": "remove"}`, "0", 400, `prompting rule ID must be provided`},
+		{"POST", "", `{"action": "remove", "id": "0000000000000042"}`, "0", 404, `cannot find prompting rule 0000000000000042`},
+	} {
+		rsp := s.promptingRulesRequest(c, t.method, t.query, t.body, "pid=100;uid="+t.uid+";socket=;")
+		c.Check(rsp.Status, Equals, t.status, Commentf("%s %s", t.query, t.body))
+		c.Check(rsp.Result.(*errorResult).Message, Matches, t.err, Commentf("%s %s", t.query, t.body))
+	}
+}
+
+func (s *apiSuite) TestPromptingRulesRemove(c *C) {
+	s.daemon(c)
+
+	rsp := s.promptingRulesRequest(c, "POST", "", `{"action": "add", "rule": {
+		"snap": "foo", "interface": "home", "path-pattern": "/home/test/**",
+		"permissions": ["read"], "outcome": "allow", "lifespan": "forever"}}`,
+		"pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200, Commentf("%v", rsp.Result))
+
+	// other users cannot remove it
+	rsp = s.promptingRulesRequest(c, "POST", "", `{"action": "remove", "id": "0000000000000001"}`, "pid=100;uid=1001;socket=;")
+	c.Check(rsp.Status, Equals, 404)
+
+	rsp = s.promptingRulesRequest(c, "POST", "", `{"action": "remove", "id": "0000000000000001"}`, "pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200)
+	c.Check(rsp.Result.(*prompting.Rule).Snap, Equals, "foo")
+
+	rsp = s.promptingRulesRequest(c, "GET", "", "", "pid=100;uid=1000;socket=;")
+	c.Check(rsp.Result, DeepEquals, []*prompting.Rule{})
+}
+
+func (s *apiSuite) TestPromptingRulesImport(c *C) {
+	s.daemon(c)
+
+	rsp := s.promptingRulesRequest(c, "POST", "", `{"action": "add", "rule": {
+		"snap": "foo", "interface": "home", "path-pattern": "/home/test/**",
+		"permissions": ["read"], "outcome": "allow", "lifespan": "forever"}}`,
+		"pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200, Commentf("%v", rsp.Result))
+
+	// the user of the imported rules is the calling user
+	rsp = s.promptingRulesRequest(c, "POST", "", `{"action": "import", "replace": true, "rules": [
+		{"id": "00000000000000AA", "user": 0, "snap": "foo", "interface": "home", "path-pattern": "/home/test/**",
+		 "permissions": ["read"], "outcome": "deny", "lifespan": "forever"}]}`,
+		"pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Status, Equals, 200, Commentf("%v", rsp.Result))
+	imported := rsp.Result.([]*prompting.Rule)
+	c.Assert(imported, HasLen, 1)
+	c.Check(imported[0].ID, Equals, "0000000000000002")
+	c.Check(imported[0].User, Equals, uint32(1000))
+
+	rsp = s.promptingRulesRequest(c, "GET", "", "", "pid=100;uid=1000;socket=;")
+	c.Assert(rsp.Result, HasLen, 1)
+	c.Check(rsp.Result.([]*prompting.Rule)[0].ID, Equals, "0000000000000002")
+
+	// without replacing, the rule conflicts
+	rsp = s.promptingRulesRequest(c, "POST", "", `{"action": "import", "rules": [
+		{"snap": "foo", "interface": "home", "path-pattern": "/home/test/**",
+		 "permissions": ["read"], "outcome": "allow", "lifespan": "forever"}]}`,
+		"pid=100;uid=1000;socket=;")
+	c.Check(rsp.Status, Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, Equals, `cannot import prompting rule #1: rule conflicts with existing rule 0000000000000002`)
+}
+
+func (s *apiSuite) TestPromptingRulesAccess(c *C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("POST", "/v2/interfaces/prompting/rules", bytes.NewBufferString(`{"action": "remove", "id": "1"}`))
+	c.Assert(err, IsNil)
+	req.RemoteAddr = "pid=100;uid=1000;socket=;"
+	rec := httptest.NewRecorder()
+	promptingRulesCmd.ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 401)
+}
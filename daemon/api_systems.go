@@ -66,8 +66,9 @@ func getSystems(c *Command, r *http.Request, user *auth.UserState) Response {
 		actions := make([]client.SystemAction, 0, len(ss.Actions))
 		for _, sa := range ss.Actions {
 			actions = append(actions, client.SystemAction{
-				Title: sa.Title,
-				Mode:  sa.Mode,
+				Title:        sa.Title,
+				Mode:         sa.Mode,
+				GadgetAction: sa.GadgetAction,
 			})
 		}
 
@@ -152,8 +153,9 @@ func postSystemActionDo(c *Command, systemLabel string, req *systemActionRequest
 	}
 
 	sa := devicestate.SystemAction{
-		Title: req.Title,
-		Mode:  req.Mode,
+		Title:        req.Title,
+		Mode:         req.Mode,
+		GadgetAction: req.GadgetAction,
 	}
 	if err := c.d.overlord.DeviceManager().RequestSystemAction(systemLabel, sa); err != nil {
 		return handleSystemActionErr(err, systemLabel)
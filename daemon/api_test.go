@@ -337,6 +337,7 @@ func (s *apiBaseSuite) TearDownTest(c *check.C) {
 	s.restoreBackends()
 	unsafeReadSnapInfo = unsafeReadSnapInfoImpl
 	ensureStateSoon = ensureStateSoonImpl
+	devicestateCheckUnassertedSnapAllowed = devicestate.CheckUnassertedSnapAllowed
 	dirs.SetRootDir("")
 
 	assertstateRefreshSnapDeclarations = assertstate.RefreshSnapDeclarations
@@ -1291,6 +1292,59 @@ func (s *apiSuite) testSysInfoSystemMode(c *check.C, mode string) {
 	c.Check(rsp.Result, check.DeepEquals, expected)
 }
 
+type panickingManager struct{}
+
+func (panickingManager) Ensure() error {
+	panic("boom")
+}
+
+func (s *apiSuite) TestSysInfoDegraded(c *check.C) {
+	d := s.daemon(c)
+	se := d.overlord.StateEngine()
+	se.AddManager(panickingManager{})
+	c.Assert(se.Ensure(), check.ErrorMatches, `.*daemon.panickingManager panicked: boom.*`)
+
+	rec := httptest.NewRecorder()
+	sysInfoCmd.GET(sysInfoCmd, nil, nil).ServeHTTP(rec, nil)
+	c.Check(rec.Code, check.Equals, 200)
+
+	var rsp struct {
+		Result client.SysInfo `json:"result"`
+	}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Assert(rsp.Result.Degraded, check.HasLen, 1)
+	degraded := rsp.Result.Degraded[0]
+	c.Check(degraded.Name, check.Equals, "daemon.panickingManager")
+	c.Check(degraded.Reason, check.Equals, "boom")
+	c.Check(degraded.RetryAt.After(degraded.Since), check.Equals, true)
+}
+
+func (s *apiSuite) TestSysInfoRecoversFromPanic(c *check.C) {
+	d := s.daemon(c)
+	err := d.overlord.InterfaceManager().Repository().AddBackend(&ifacetest.TestSecurityBackend{
+		BackendName:             "broken",
+		SandboxFeaturesCallback: func() []string { panic("boom") },
+	})
+	c.Assert(err, check.IsNil)
+
+	rec := httptest.NewRecorder()
+	sysInfoCmd.GET(sysInfoCmd, nil, nil).ServeHTTP(rec, nil)
+	c.Check(rec.Code, check.Equals, 500)
+
+	var rsp struct {
+		Result struct {
+			Message string `json:"message"`
+		} `json:"result"`
+	}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Check(rsp.Result.Message, check.Equals, "cannot get system info: internal error: boom")
+
+	// the state lock was released
+	st := d.overlord.State()
+	st.Lock()
+	st.Unlock()
+}
+
 func (s *apiSuite) TestSysInfoSystemModeRun(c *check.C) {
 	s.testSysInfoSystemMode(c, "run")
 }
@@ -3221,6 +3275,37 @@ func (s *apiSuite) TestSideloadSnapChangeConflict(c *check.C) {
 	c.Check(rsp.Result.(*errorResult).Kind, check.Equals, client.ErrorKindSnapChangeConflict)
 }
 
+func (s *apiSuite) TestSideloadSnapUnassertedNotAllowed(c *check.C) {
+	body := "" +
+		"----hello--\r\n" +
+		"Content-Disposition: form-data; name=\"snap\"; filename=\"x\"\r\n" +
+		"\r\n" +
+		"xyzzy\r\n" +
+		"----hello--\r\n" +
+		"Content-Disposition: form-data; name=\"dangerous\"\r\n" +
+		"\r\n" +
+		"true\r\n" +
+		"----hello--\r\n"
+	s.daemonWithOverlordMock(c)
+
+	devicestateCheckUnassertedSnapAllowed = func(st *state.State) error {
+		return errors.New("cannot install snaps without assertions on a device with a secured model")
+	}
+	snapstateInstallPath = func(s *state.State, si *snap.SideInfo, path, name, channel string, flags snapstate.Flags) (*state.TaskSet, *snap.Info, error) {
+		c.Fatalf("unexpected call")
+		return nil, nil, nil
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(body))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "multipart/thing; boundary=--hello--")
+
+	rsp := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "cannot install snaps without assertions on a device with a secured model")
+}
+
 func (s *apiSuite) TestSideloadSnapInstanceName(c *check.C) {
 	// try a multipart/form-data upload
 	body := sideLoadBodyWithoutDevMode +
@@ -4090,6 +4175,35 @@ func (s *apiSuite) TestRefreshIgnoreRunning(c *check.C) {
 	c.Check(summary, check.Equals, `Refresh "some-snap" snap`)
 }
 
+func (s *apiSuite) TestRefreshApprovePolicyChanges(c *check.C) {
+	var calledFlags snapstate.Flags
+
+	snapstateUpdate = func(s *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags) (*state.TaskSet, error) {
+		calledFlags = flags
+
+		t := s.NewTask("fake-refresh-snap", "Doing a fake install")
+		return state.NewTaskSet(t), nil
+	}
+	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
+		return nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{
+		Action:               "refresh",
+		ApprovePolicyChanges: true,
+		Snaps:                []string{"some-snap"},
+	}
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	_, _, err := inst.dispatch()(inst, st)
+	c.Check(err, check.IsNil)
+
+	c.Check(calledFlags, check.DeepEquals, snapstate.Flags{ApprovePolicyChanges: true})
+}
+
 func (s *apiSuite) TestRefreshCohort(c *check.C) {
 	cohort := ""
 
@@ -5913,6 +6027,72 @@ func (s *apiSuite) TestStateChange(c *check.C) {
 	})
 }
 
+func (s *apiSuite) TestStateChangePolicyDiffs(c *check.C) {
+	// Setup
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	chg := st.Change(ids[0])
+	chg.Set("policy-diffs", map[string]interface{}{
+		"foo": map[string]interface{}{"high-risk": []string{"snap.foo.app: ptrace,"}},
+	})
+	st.Unlock()
+	s.vars = map[string]string{"id": ids[0]}
+
+	// Execute
+	req, err := http.NewRequest("GET", "/v2/change/"+ids[0], nil)
+	c.Assert(err, check.IsNil)
+	rsp := getChange(stateChangeCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, check.Equals, 200)
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Check(err, check.IsNil)
+	c.Check(body["result"].(map[string]interface{})["data"], check.DeepEquals, map[string]interface{}{
+		"policy-diffs": map[string]interface{}{
+			"foo": map[string]interface{}{"high-risk": []interface{}{"snap.foo.app: ptrace,"}},
+		},
+	})
+}
+
+func (s *apiSuite) TestStateChangeDefaultProviders(c *check.C) {
+	// Setup
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	chg := st.Change(ids[0])
+	chg.Set("default-providers", map[string][]*snapstate.DefaultProvider{
+		"foo": {{Snap: "bar", ContentTags: []string{"baz"}, Policy: "always", Installed: true}},
+	})
+	st.Unlock()
+	s.vars = map[string]string{"id": ids[0]}
+
+	// Execute
+	req, err := http.NewRequest("GET", "/v2/change/"+ids[0], nil)
+	c.Assert(err, check.IsNil)
+	rsp := getChange(stateChangeCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, check.Equals, 200)
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Check(err, check.IsNil)
+	c.Check(body["result"].(map[string]interface{})["data"], check.DeepEquals, map[string]interface{}{
+		"default-providers": map[string]interface{}{
+			"foo": []interface{}{
+				map[string]interface{}{"snap": "bar", "content-tags": []interface{}{"baz"}, "policy": "always", "installed": true},
+			},
+		},
+	})
+}
+
 func (s *apiSuite) TestStateChangeAbort(c *check.C) {
 	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
 	defer restore()
@@ -6815,6 +6995,32 @@ func (s *apiSuite) TestInstallIgnoreRunning(c *check.C) {
 	c.Check(calledFlags.IgnoreRunning, check.Equals, true)
 }
 
+func (s *apiSuite) TestInstallApproveDefaultProviders(c *check.C) {
+	var calledFlags snapstate.Flags
+
+	snapstateInstall = func(ctx context.Context, s *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags) (*state.TaskSet, error) {
+		calledFlags = flags
+
+		t := s.NewTask("fake-install-snap", "Doing a fake install")
+		return state.NewTaskSet(t), nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{
+		Action:                  "install",
+		ApproveDefaultProviders: true,
+		Snaps:                   []string{"fake"},
+	}
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	_, _, err := inst.dispatch()(inst, st)
+	c.Check(err, check.IsNil)
+
+	c.Check(calledFlags, check.DeepEquals, snapstate.Flags{ApproveDefaultProviders: true})
+}
+
 func (s *apiSuite) TestInstallPathUnaliased(c *check.C) {
 	body := "" +
 		"----hello--\r\n" +
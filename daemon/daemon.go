@@ -35,6 +35,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/juju/ratelimit"
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/client"
@@ -45,6 +46,7 @@ import (
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/standby"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/polkit"
@@ -55,7 +57,10 @@ import (
 
 var ErrRestartSocket = fmt.Errorf("daemon stop requested to wait for socket activation")
 
-var systemdSdNotify = systemd.SdNotify
+var (
+	systemdSdNotify        = systemd.SdNotify
+	systemdSdNotifyWithFds = systemd.SdNotifyWithFds
+)
 
 const (
 	daemonRestartMsg = "system is restarting"
@@ -70,6 +75,8 @@ type Daemon struct {
 	state           *state.State
 	snapdListener   net.Listener
 	snapListener    net.Listener
+	publicListener  net.Listener
+	publicLimiter   *ratelimit.Bucket
 	connTracker     *connTracker
 	serve           *http.Server
 	tomb            tomb.Tomb
@@ -86,6 +93,11 @@ type Daemon struct {
 
 	expectedRebootDidNotHappen bool
 
+	// ownListeners are the listeners snapd created itself as they were
+	// not passed by systemd, they are handed over to the next snapd when
+	// restarting
+	ownListeners []net.Listener
+
 	mu sync.Mutex
 }
 
@@ -106,6 +118,8 @@ type Command struct {
 	UserOK bool
 	// is this path accessible on the snapd-snap socket?
 	SnapOK bool
+	// can GET be served on the unauthenticated public socket?
+	PublicOK bool
 	// this path is only accessible to root
 	RootOnly bool
 
@@ -137,12 +151,22 @@ var polkitCheckAuthorization = polkit.CheckAuthorization
 // - UserOK: any uid on the local system can access GET
 // - RootOnly: only root can access this
 // - SnapOK: a snap can access this via `snapctl`
+//
+// Requests coming in via the public socket are never authenticated and
+// are only allowed for GET on commands with PublicOK.
 func (c *Command) canAccess(r *http.Request, user *auth.UserState) accessResult {
-	if c.RootOnly && (c.UserOK || c.GuestOK || c.SnapOK) {
+	if c.RootOnly && (c.UserOK || c.GuestOK || c.SnapOK || c.PublicOK) {
 		// programming error
 		logger.Panicf("Command can't have RootOnly together with any *OK flag")
 	}
 
+	if isPublicRequest(r) {
+		if c.PublicOK && r.Method == "GET" {
+			return accessOK
+		}
+		return accessUnauthorized
+	}
+
 	if user != nil && !c.RootOnly {
 		// Authenticated users do anything not requiring explicit root.
 		return accessOK
@@ -221,10 +245,17 @@ func (c *Command) canAccess(r *http.Request, user *auth.UserState) accessResult
 
 func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	st := c.d.state
-	st.Lock()
-	// TODO Look at the error and fail if there's an attempt to authenticate with invalid data.
-	user, _ := UserFromRequest(st, r)
-	st.Unlock()
+
+	var user *auth.UserState
+	public := isPublicRequest(r)
+	if !public {
+		// the public socket is unauthenticated, any
+		// authorization data is ignored
+		st.Lock()
+		// TODO Look at the error and fail if there's an attempt to authenticate with invalid data.
+		user, _ = UserFromRequest(st, r)
+		st.Unlock()
+	}
 
 	// check if we are in degradedMode
 	if c.d.degradedErr != nil && r.Method != "GET" {
@@ -246,6 +277,15 @@ func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if public {
+		// only requests that would be served count against the
+		// rate limit of the public socket
+		if rsp := c.d.checkPublicRequest(); rsp != nil {
+			rsp.ServeHTTP(w, r)
+			return
+		}
+	}
+
 	ctx := store.WithClientUserAgent(r.Context(), r)
 	r = r.WithContext(ctx)
 
@@ -330,6 +370,7 @@ func (d *Daemon) Init() error {
 	// The SnapdSocket is required-- without it, die.
 	if listener, err := netutil.GetListener(dirs.SnapdSocket, listenerMap); err == nil {
 		d.snapdListener = &ucrednetListener{Listener: listener}
+		d.trackOwnListener(dirs.SnapdSocket, listener, listenerMap)
 	} else {
 		return fmt.Errorf("when trying to listen on %s: %v", dirs.SnapdSocket, err)
 	}
@@ -338,10 +379,19 @@ func (d *Daemon) Init() error {
 		// This listener may also be nil if that socket wasn't among
 		// the listeners, so check it before using it.
 		d.snapListener = &ucrednetListener{Listener: listener}
+		d.trackOwnListener(dirs.SnapSocket, listener, listenerMap)
 	} else {
 		logger.Debugf("cannot get listener for %q: %v", dirs.SnapSocket, err)
 	}
 
+	if listener, err := netutil.GetListener(dirs.SnapdPublicSocket, listenerMap); err == nil {
+		d.publicListener = &ucrednetListener{Listener: listener}
+		d.publicLimiter = ratelimit.NewBucketWithRate(publicRequestRate, publicRequestBurst)
+		d.trackOwnListener(dirs.SnapdPublicSocket, listener, listenerMap)
+	} else {
+		logger.Debugf("cannot get listener for %q: %v", dirs.SnapdPublicSocket, err)
+	}
+
 	d.addRoutes()
 
 	logger.Noticef("started %v.", snapdenv.UserAgent())
@@ -349,6 +399,50 @@ func (d *Daemon) Init() error {
 	return nil
 }
 
+// trackOwnListener remembers the listener of the given socket if it was
+// created by snapd rather than passed by systemd, only those are handed off
+// by handOffListeners.
+func (d *Daemon) trackOwnListener(socketPath string, listener net.Listener, listenerMap map[string]net.Listener) {
+	if _, ok := listenerMap[socketPath]; !ok {
+		d.ownListeners = append(d.ownListeners, listener)
+	}
+}
+
+// handOffListeners stores the listeners snapd created itself in the file
+// descriptor store of the service, systemd then passes them to the next
+// snapd along with the socket activated ones. The connections made while
+// snapd restarts are queued and served by the next snapd instead of being
+// refused.
+//
+// This only covers snapd running without socket activation. The sockets
+// passed by socket activation, which is the normal setup, are not handed
+// off: they belong to snapd.socket, which keeps them listening while snapd
+// restarts and passes them to the next snapd, storing them as well would
+// pass them twice.
+func (d *Daemon) handOffListeners() {
+	for _, listener := range d.ownListeners {
+		ul, ok := listener.(*net.UnixListener)
+		if !ok {
+			continue
+		}
+		f, err := ul.File()
+		if err != nil {
+			logger.Noticef("cannot hand off listener of %s: %v", ul.Addr(), err)
+			continue
+		}
+		err = systemdSdNotifyWithFds("FDSTORE=1\nFDNAME=snapd-listener", int(f.Fd()))
+		f.Close()
+		if err != nil {
+			logger.Noticef("cannot hand off listener of %s: %v", ul.Addr(), err)
+			continue
+		}
+		// the socket is now kept open by systemd, it must not be
+		// removed when the listener is closed
+		ul.SetUnlinkOnClose(false)
+		logger.Debugf("handed off listener of %s", ul.Addr())
+	}
+}
+
 // SetDegradedMode puts the daemon into an degraded mode which will the
 // error given in the "err" argument for commands that are not marked
 // as readonlyOK.
@@ -382,8 +476,61 @@ func (d *Daemon) addRoutes() {
 
 var (
 	shutdownTimeout = 25 * time.Second
+
+	// publicRequestRate is the sustained number of requests per
+	// second served on the public socket, publicRequestBurst is
+	// how many requests can be served at once before throttling
+	publicRequestRate  = 5.0
+	publicRequestBurst = int64(20)
 )
 
+// isPublicRequest returns whether the request came in via the public
+// read-only socket.
+func isPublicRequest(r *http.Request) bool {
+	_, _, socket, _ := ucrednetGet(r.RemoteAddr)
+	return socket == dirs.SnapdPublicSocket
+}
+
+// checkPublicRequest returns an error response if a request on the
+// public socket cannot be served, either because the socket was
+// disabled via the system.disable-public-socket option or because the
+// request rate limit was exceeded.
+func (d *Daemon) checkPublicRequest() Response {
+	st := d.state
+	st.Lock()
+	tr := config.NewTransaction(st)
+	// the option may be stored either as a boolean or as a string,
+	// depending on how it was set
+	var value interface{}
+	err := tr.GetMaybe("core", "system.disable-public-socket", &value)
+	st.Unlock()
+	if err != nil {
+		return InternalError("cannot get public socket configuration: %v", err)
+	}
+	var disabled bool
+	switch v := value.(type) {
+	case nil:
+	case bool:
+		disabled = v
+	case string:
+		if v != "" {
+			disabled, err = strconv.ParseBool(v)
+		}
+	default:
+		err = fmt.Errorf("unexpected value %v", v)
+	}
+	if err != nil {
+		return InternalError("cannot get public socket configuration: %v", err)
+	}
+	if disabled {
+		return Forbidden("public socket is disabled")
+	}
+	if d.publicLimiter != nil && d.publicLimiter.TakeAvailable(1) == 0 {
+		return TooManyRequests("too many requests")
+	}
+	return nil
+}
+
 type connTracker struct {
 	mu    sync.Mutex
 	conns map[net.Conn]struct{}
@@ -475,6 +622,16 @@ func (d *Daemon) Start() error {
 			})
 		}
 
+		if d.publicListener != nil {
+			d.tomb.Go(func() error {
+				if err := d.serve.Serve(d.publicListener); err != http.ErrServerClosed && d.tomb.Err() == tomb.ErrStillAlive {
+					return err
+				}
+
+				return nil
+			})
+		}
+
 		if err := d.serve.Serve(d.snapdListener); err != http.ErrServerClosed && d.tomb.Err() == tomb.ErrStillAlive {
 			return err
 		}
@@ -578,7 +735,16 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 		logger.Noticef("error writing maintenance file: %v", err)
 	}
 
+	if d.requestedRestart == state.RestartDaemon {
+		// keep the sockets snapd created itself open across the
+		// restart
+		d.handOffListeners()
+	}
+
 	d.snapdListener.Close()
+	if d.publicListener != nil {
+		d.publicListener.Close()
+	}
 	d.standbyOpinions.Stop()
 
 	if d.snapListener != nil {
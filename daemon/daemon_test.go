@@ -37,6 +37,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/juju/ratelimit"
 	"gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/client"
@@ -44,6 +45,7 @@ import (
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/patch"
@@ -65,6 +67,7 @@ type daemonSuite struct {
 	err             error
 	lastPolkitFlags polkit.CheckFlags
 	notified        []string
+	handedOff       []string
 	restoreBackends func()
 }
 
@@ -84,12 +87,18 @@ func (s *daemonSuite) SetUpTest(c *check.C) {
 		return nil
 	}
 	s.notified = nil
+	systemdSdNotifyWithFds = func(notif string, fds ...int) error {
+		s.handedOff = append(s.handedOff, notif)
+		return nil
+	}
+	s.handedOff = nil
 	polkitCheckAuthorization = s.checkAuthorization
 	s.restoreBackends = ifacestate.MockSecurityBackends(nil)
 }
 
 func (s *daemonSuite) TearDownTest(c *check.C) {
 	systemdSdNotify = systemd.SdNotify
+	systemdSdNotifyWithFds = systemd.SdNotifyWithFds
 	dirs.SetRootDir("")
 	s.authorized = false
 	s.err = nil
@@ -326,6 +335,114 @@ func (s *daemonSuite) TestSnapctlAccessSnapOKWithRoot(c *check.C) {
 	c.Check(cmd.canAccess(del, nil), check.Equals, accessOK)
 }
 
+func (s *daemonSuite) TestPublicAccess(c *check.C) {
+	remoteAddr := "pid=100;uid=1000;socket=" + dirs.SnapdPublicSocket + ";"
+	get := &http.Request{Method: "GET", RemoteAddr: remoteAddr}
+	put := &http.Request{Method: "PUT", RemoteAddr: remoteAddr}
+	pst := &http.Request{Method: "POST", RemoteAddr: remoteAddr}
+
+	cmd := &Command{d: newTestDaemon(c), PublicOK: true}
+	c.Check(cmd.canAccess(get, nil), check.Equals, accessOK)
+	c.Check(cmd.canAccess(put, nil), check.Equals, accessUnauthorized)
+	c.Check(cmd.canAccess(pst, nil), check.Equals, accessUnauthorized)
+
+	// being a logged in user or root makes no difference
+	cmd = &Command{d: newTestDaemon(c), GuestOK: true, UserOK: true}
+	c.Check(cmd.canAccess(get, &auth.UserState{}), check.Equals, accessUnauthorized)
+	rootGet := &http.Request{Method: "GET", RemoteAddr: "pid=100;uid=0;socket=" + dirs.SnapdPublicSocket + ";"}
+	c.Check(cmd.canAccess(rootGet, nil), check.Equals, accessUnauthorized)
+
+	// PublicOK is only honored on the public socket
+	cmd = &Command{d: newTestDaemon(c), PublicOK: true}
+	c.Check(cmd.canAccess(&http.Request{Method: "GET", RemoteAddr: "pid=100;uid=1000;socket=;"}, nil), check.Equals, accessUnauthorized)
+}
+
+func (s *daemonSuite) TestPublicSocketDisabled(c *check.C) {
+	d := newTestDaemon(c)
+	cmd := &Command{d: d, PublicOK: true}
+	cmd.GET = func(*Command, *http.Request, *auth.UserState) Response {
+		return SyncResponse(nil, nil)
+	}
+
+	doPublicReq := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "", nil)
+		c.Assert(err, check.IsNil)
+		req.RemoteAddr = "pid=100;uid=1000;socket=" + dirs.SnapdPublicSocket + ";"
+		rec := httptest.NewRecorder()
+		cmd.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := doPublicReq()
+	c.Check(rec.Code, check.Equals, 200)
+
+	st := d.overlord.State()
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "system.disable-public-socket", true)
+	tr.Commit()
+	st.Unlock()
+
+	rec = doPublicReq()
+	c.Check(rec.Code, check.Equals, 403)
+	var v struct{ Result errorResult }
+	c.Assert(json.NewDecoder(rec.Body).Decode(&v), check.IsNil)
+	c.Check(v.Result.Message, check.Equals, "public socket is disabled")
+
+	// the string form used by snap set is accepted too
+	for _, tc := range []struct {
+		value string
+		code  int
+	}{
+		{"false", 200},
+		{"true", 403},
+	} {
+		st.Lock()
+		tr := config.NewTransaction(st)
+		tr.Set("core", "system.disable-public-socket", tc.value)
+		tr.Commit()
+		st.Unlock()
+
+		rec = doPublicReq()
+		c.Check(rec.Code, check.Equals, tc.code, check.Commentf("%q", tc.value))
+	}
+}
+
+func (s *daemonSuite) TestPublicSocketRateLimit(c *check.C) {
+	d := newTestDaemon(c)
+	d.publicLimiter = ratelimit.NewBucketWithRate(0.001, 2)
+	cmd := &Command{d: d, PublicOK: true}
+	cmd.GET = func(*Command, *http.Request, *auth.UserState) Response {
+		return SyncResponse(nil, nil)
+	}
+
+	// requests which are not allowed on the public socket do not
+	// count against the limit
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("POST", "", nil)
+		c.Assert(err, check.IsNil)
+		req.RemoteAddr = "pid=100;uid=1000;socket=" + dirs.SnapdPublicSocket + ";"
+		rec := httptest.NewRecorder()
+		cmd.ServeHTTP(rec, req)
+		c.Check(rec.Code, check.Equals, 401)
+	}
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", "", nil)
+		c.Assert(err, check.IsNil)
+		req.RemoteAddr = "pid=100;uid=1000;socket=" + dirs.SnapdPublicSocket + ";"
+		rec := httptest.NewRecorder()
+		cmd.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	c.Check(codes, check.DeepEquals, []int{200, 200, 429})
+
+	// the regular socket is not affected
+	rec := doTestReq(c, cmd, "GET")
+	c.Check(rec.Code, check.Equals, 200)
+}
+
 func (s *daemonSuite) TestUserAccess(c *check.C) {
 	get := &http.Request{Method: "GET", RemoteAddr: "pid=100;uid=42;socket=;"}
 	put := &http.Request{Method: "PUT", RemoteAddr: "pid=100;uid=42;socket=;"}
@@ -655,6 +772,100 @@ func (s *daemonSuite) TestRestartWiring(c *check.C) {
 	c.Assert(s.notified, check.DeepEquals, []string{"EXTEND_TIMEOUT_USEC=30000000", "READY=1", "STOPPING=1"})
 }
 
+func (s *daemonSuite) TestRestartDaemonHandsOffOwnListeners(c *check.C) {
+	d := newTestDaemon(c)
+	// mark as already seeded
+	s.markSeeded(d)
+
+	sock := filepath.Join(c.MkDir(), "snapd.socket")
+	l, err := net.Listen("unix", sock)
+	c.Assert(err, check.IsNil)
+	d.snapdListener = &ucrednetListener{Listener: l}
+	d.ownListeners = []net.Listener{l}
+
+	c.Assert(d.Start(), check.IsNil)
+
+	d.overlord.State().RequestRestart(state.RestartDaemon)
+
+	select {
+	case <-d.Dying():
+	case <-time.After(2 * time.Second):
+		c.Fatal("RequestRestart -> overlord -> Kill chain didn't work")
+	}
+
+	c.Assert(d.Stop(nil), check.IsNil)
+
+	c.Check(s.handedOff, check.DeepEquals, []string{"FDSTORE=1\nFDNAME=snapd-listener"})
+	// the socket kept by systemd was not removed
+	c.Check(sock, testutil.FilePresent)
+}
+
+func (s *daemonSuite) TestStopDoesNotHandOffListeners(c *check.C) {
+	d := newTestDaemon(c)
+	// mark as already seeded
+	s.markSeeded(d)
+
+	sock := filepath.Join(c.MkDir(), "snapd.socket")
+	l, err := net.Listen("unix", sock)
+	c.Assert(err, check.IsNil)
+	d.snapdListener = &ucrednetListener{Listener: l}
+	d.ownListeners = []net.Listener{l}
+
+	c.Assert(d.Start(), check.IsNil)
+	c.Assert(d.Stop(nil), check.IsNil)
+
+	c.Check(s.handedOff, check.HasLen, 0)
+	// the socket is removed when snapd stops for good
+	c.Check(sock, testutil.FileAbsent)
+}
+
+func (s *daemonSuite) TestRestartDaemonDoesNotHandOffActivatedListeners(c *check.C) {
+	d := newTestDaemon(c)
+	// mark as already seeded
+	s.markSeeded(d)
+
+	sock := filepath.Join(c.MkDir(), "snapd.socket")
+	l, err := net.Listen("unix", sock)
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	// a listener passed by socket activation is created from a file
+	// descriptor, like netutil.ActivationListeners does
+	f, err := l.(*net.UnixListener).File()
+	c.Assert(err, check.IsNil)
+	activated, err := net.FileListener(f)
+	f.Close()
+	c.Assert(err, check.IsNil)
+	d.snapdListener = &ucrednetListener{Listener: activated}
+	d.trackOwnListener(sock, activated, map[string]net.Listener{sock: activated})
+	c.Check(d.ownListeners, check.HasLen, 0)
+
+	c.Assert(d.Start(), check.IsNil)
+
+	d.overlord.State().RequestRestart(state.RestartDaemon)
+
+	select {
+	case <-d.Dying():
+	case <-time.After(2 * time.Second):
+		c.Fatal("RequestRestart -> overlord -> Kill chain didn't work")
+	}
+
+	c.Assert(d.Stop(nil), check.IsNil)
+
+	// the socket is kept listening by snapd.socket instead
+	c.Check(s.handedOff, check.HasLen, 0)
+	c.Check(sock, testutil.FilePresent)
+}
+
+func (s *daemonSuite) TestTrackOwnListener(c *check.C) {
+	d := newTestDaemon(c)
+
+	own, err := net.Listen("unix", filepath.Join(c.MkDir(), "snap.socket"))
+	c.Assert(err, check.IsNil)
+	defer own.Close()
+	d.trackOwnListener(dirs.SnapSocket, own, map[string]net.Listener{})
+	c.Check(d.ownListeners, check.DeepEquals, []net.Listener{own})
+}
+
 func (s *daemonSuite) TestGracefulStop(c *check.C) {
 	d := newTestDaemon(c)
 
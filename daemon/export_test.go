@@ -27,6 +27,7 @@ import (
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/servicestate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/snap"
 )
 
@@ -65,6 +66,30 @@ func MockShutdownTimeout(tm time.Duration) (restore func()) {
 	}
 }
 
+func MockSecbootTPMCapabilities(f func() (*secboot.TPMInfo, error)) (restore func()) {
+	old := secbootTPMCapabilities
+	secbootTPMCapabilities = f
+	return func() {
+		secbootTPMCapabilities = old
+	}
+}
+
+func MockSecbootSealedKeyInfo(f func(keyFile string) (*secboot.SealedKeyDetails, error)) (restore func()) {
+	old := secbootSealedKeyInfo
+	secbootSealedKeyInfo = f
+	return func() {
+		secbootSealedKeyInfo = old
+	}
+}
+
+func MockBootSealedKeyFiles(f func() []string) (restore func()) {
+	old := bootSealedKeyFiles
+	bootSealedKeyFiles = f
+	return func() {
+		bootSealedKeyFiles = old
+	}
+}
+
 func MockServicestateControl(f func(st *state.State, appInfos []*snap.AppInfo, inst *servicestate.Instruction, context *hookstate.Context) ([]*state.TaskSet, error)) (restore func()) {
 	old := servicestateControl
 	servicestateControl = f
@@ -28,6 +28,7 @@ import (
 	"net"
 	"net/http"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -299,9 +300,24 @@ func (f fileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // osutil.WatingStdoutPipe).
 //
 // Tip: “jq” knows how to read this; “jq --seq” both reads and writes this.
+//
+// When following the logs, the stream ends once stop is closed, eg. when the
+// daemon stops, so that it does not delay the shutdown of the daemon. The
+// client.LogsStoppedTrailer trailer is then set for clients to follow the
+// logs again from the restarted daemon.
 type journalLineReaderSeqResponse struct {
 	io.ReadCloser
 	follow bool
+	stop   <-chan struct{}
+}
+
+func (rr *journalLineReaderSeqResponse) stopped() bool {
+	select {
+	case <-rr.stop:
+		return true
+	default:
+		return false
+	}
 }
 
 func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -309,6 +325,19 @@ func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http
 
 	flusher, hasFlusher := w.(http.Flusher)
 
+	if rr.follow && rr.stop != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-rr.stop:
+				// unblocks the reading below
+				rr.Close()
+			case <-done:
+			}
+		}()
+	}
+
 	var err error
 	dec := json.NewDecoder(rr)
 	writer := bufio.NewWriter(w)
@@ -341,16 +370,116 @@ func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http
 			}
 		}
 	}
-	if err != nil && err != io.EOF {
-		fmt.Fprintf(writer, `\x1E{"error": %q}\n`, err)
+	if err != nil && err != io.EOF && !rr.stopped() {
+		fmt.Fprintf(writer, "\x1E{\"error\": %q}\n", err)
 		logger.Noticef("cannot stream response; problem reading: %v", err)
 	}
 	if err := writer.Flush(); err != nil {
 		logger.Noticef("cannot stream response; problem writing: %v", err)
 	}
+	if rr.follow && rr.stopped() {
+		w.Header().Set(http.TrailerPrefix+client.LogsStoppedTrailer, "true")
+	}
 	rr.Close()
 }
 
+// A serviceStatusSeqResponse's ServeHTTP method streams the status of
+// services as a json-seq response of client.EventServiceStatus events,
+// each carrying the client.AppInfo of the services. The first event has
+// their current status, a new one follows whenever it changes.
+//
+// Changes are detected from the journal entries of the services read from
+// the io.ReadCloser, as output by journalctl -o json, those logged by systemd
+// itself about the jobs it runs for the units or about their processes
+// trigger a new query of the status.
+//
+// The stream ends once stop is closed, setting the
+// client.StreamStoppedTrailer trailer.
+type serviceStatusSeqResponse struct {
+	io.ReadCloser
+	status func() ([]*client.AppInfo, error)
+	stop   <-chan struct{}
+}
+
+func (sr *serviceStatusSeqResponse) stopped() bool {
+	select {
+	case <-sr.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sr *serviceStatusSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json-seq")
+
+	flusher, hasFlusher := w.(http.Flusher)
+
+	if sr.stop != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-sr.stop:
+				// unblocks the reading below
+				sr.Close()
+			case <-done:
+			}
+		}()
+	}
+
+	writer := bufio.NewWriter(w)
+	enc := json.NewEncoder(writer)
+	var prev []*client.AppInfo
+	send := func() error {
+		status, err := sr.status()
+		if err != nil {
+			return err
+		}
+		if prev != nil && reflect.DeepEqual(status, prev) {
+			return nil
+		}
+		prev = status
+
+		writer.WriteByte(0x1E) // RS -- see ascii(7), and RFC7464
+		if err := enc.Encode(client.Event{Type: client.EventServiceStatus, Services: status}); err != nil {
+			return err
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if hasFlusher {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	err := send()
+	dec := json.NewDecoder(sr)
+	for err == nil {
+		var log systemd.Log
+		if err = dec.Decode(&log); err != nil {
+			break
+		}
+		// the services' own output does not change their status
+		if log.PID() != "1" {
+			continue
+		}
+		err = send()
+	}
+	if err != nil && err != io.EOF && !sr.stopped() {
+		fmt.Fprintf(writer, "\x1E{\"error\": %q}\n", err)
+		logger.Noticef("cannot stream response; problem reading: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		logger.Noticef("cannot stream response; problem writing: %v", err)
+	}
+	if sr.stopped() {
+		w.Header().Set(http.TrailerPrefix+client.StreamStoppedTrailer, "true")
+	}
+	sr.Close()
+}
+
 type assertResponse struct {
 	assertions []asserts.Assertion
 	bundle     bool
@@ -397,6 +526,7 @@ var (
 	NotImplemented   = makeErrorResponder(501)
 	Forbidden        = makeErrorResponder(403)
 	Conflict         = makeErrorResponder(409)
+	TooManyRequests  = makeErrorResponder(429)
 )
 
 // SnapNotFound is an error responder used when an operation is
@@ -22,13 +22,17 @@ package daemon
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
 )
 
 type responseSuite struct{}
@@ -137,3 +141,36 @@ func (responseSuite) TestErrorResponderDoesNotPrintfAlways(c *check.C) {
 
 	c.Check(v.Result.Message, check.Equals, "system memory below 1%.")
 }
+
+func (s *responseSuite) TestJournalLineReaderSeqResponseError(c *check.C) {
+	rsp := &journalLineReaderSeqResponse{ReadCloser: ioutil.NopCloser(strings.NewReader("potato"))}
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/v2/logs", nil)
+	c.Assert(err, check.IsNil)
+	rsp.ServeHTTP(rec, req)
+
+	// the error is a proper json-seq record
+	c.Check(rec.Body.String(), check.Equals, "\x1e"+`{"error": "invalid character 'p' looking for beginning of value"}`+"\n")
+}
+
+func (s *responseSuite) TestJournalLineReaderSeqResponseFollowStops(c *check.C) {
+	r, w := io.Pipe()
+	stop := make(chan struct{})
+	rsp := &journalLineReaderSeqResponse{ReadCloser: r, follow: true, stop: stop}
+
+	go func() {
+		// the write returns once the line was read by the response
+		w.Write([]byte(`{"MESSAGE": "hello", "SYSLOG_IDENTIFIER": "xyzzy", "_PID": "42", "__REALTIME_TIMESTAMP": "42"}` + "\n"))
+		close(stop)
+	}()
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/v2/logs", nil)
+	c.Assert(err, check.IsNil)
+	rsp.ServeHTTP(rec, req)
+
+	// the stream ends without an error record
+	c.Check(rec.Result().Trailer.Get(client.LogsStoppedTrailer), check.Equals, "true")
+	c.Check(rec.Body.String(), check.Equals, "\x1e"+`{"timestamp":"1970-01-01T00:00:00.000042Z","message":"hello","sid":"xyzzy","pid":"42"}`+"\n")
+}
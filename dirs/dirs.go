@@ -49,10 +49,12 @@ var (
 	SnapMountPolicyDir        string
 	SnapUdevRulesDir          string
 	SnapKModModulesDir        string
+	SnapKModModprobeDir       string
 	LocaleDir                 string
 	SnapMetaDir               string
 	SnapdSocket               string
 	SnapSocket                string
+	SnapdPublicSocket         string
 	SnapRunDir                string
 	SnapRunNsDir              string
 	SnapRunLockDir            string
@@ -106,11 +108,17 @@ var (
 	SnapSaveDir       string
 	SnapDeviceSaveDir string
 
+	SnapEFISignatureDbUpdatesDir string
+
+	SnapMountCredentialsDir string
+
 	CloudMetaDataFile     string
 	CloudInstanceDataFile string
 
 	ClassicDir string
 
+	SnapRuntimesDir string
+
 	XdgRuntimeDirBase string
 	XdgRuntimeDirGlob string
 
@@ -342,6 +350,7 @@ func SetRootDir(rootdir string) {
 	// keep in sync with the debian/snapd.socket file:
 	SnapdSocket = filepath.Join(rootdir, "/run/snapd.socket")
 	SnapSocket = filepath.Join(rootdir, "/run/snapd-snap.socket")
+	SnapdPublicSocket = filepath.Join(rootdir, "/run/snapd-public.socket")
 
 	SnapAssertsDBDir = filepath.Join(rootdir, snappyDir, "assertions")
 	SnapCookieDir = filepath.Join(rootdir, snappyDir, "cookie")
@@ -365,6 +374,9 @@ func SetRootDir(rootdir string) {
 	SnapFDEDir = SnapFDEDirUnder(rootdir)
 	SnapSaveDir = SnapSaveDirUnder(rootdir)
 	SnapDeviceSaveDir = filepath.Join(SnapSaveDir, "device")
+	SnapEFISignatureDbUpdatesDir = filepath.Join(SnapFDEDir, "efi-signature-db-updates")
+
+	SnapMountCredentialsDir = filepath.Join(rootdir, snappyDir, "mount-credentials")
 
 	SnapRepairDir = filepath.Join(rootdir, snappyDir, "repair")
 	SnapRepairStateFile = filepath.Join(SnapRepairDir, "repair.json")
@@ -391,10 +403,14 @@ func SetRootDir(rootdir string) {
 	SnapUdevRulesDir = filepath.Join(rootdir, "/etc/udev/rules.d")
 
 	SnapKModModulesDir = filepath.Join(rootdir, "/etc/modules-load.d/")
+	SnapKModModprobeDir = filepath.Join(rootdir, "/etc/modprobe.d/")
 
 	LocaleDir = filepath.Join(rootdir, "/usr/share/locale")
 	ClassicDir = filepath.Join(rootdir, "/writable/classic")
 
+	// distribution provided runtimes standing in for base snaps
+	SnapRuntimesDir = filepath.Join(rootdir, "/usr/lib/snapd/runtimes")
+
 	opensuseTWWithLibexec := func() bool {
 		// XXX: this is pretty naive if openSUSE ever starts going back
 		// and forth about the change
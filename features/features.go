@@ -59,6 +59,10 @@ const (
 	CheckDiskSpaceInstall
 	// CheckDiskSpaceRefresh controls free disk space check on snap refresh.
 	CheckDiskSpaceRefresh
+	// SnapRunAudit controls auditing of snap run invocations.
+	SnapRunAudit
+	// BaseRemapping controls replacing base snaps with distribution provided runtimes on classic.
+	BaseRemapping
 
 	// lastFeature is the final known feature, it is only used for testing.
 	lastFeature
@@ -94,6 +98,10 @@ var featureNames = map[SnapdFeature]string{
 	CheckDiskSpaceInstall: "check-disk-space-install",
 	CheckDiskSpaceRefresh: "check-disk-space-refresh",
 	CheckDiskSpaceRemove:  "check-disk-space-remove",
+
+	SnapRunAudit: "snap-run-audit",
+
+	BaseRemapping: "base-remapping",
 }
 
 // featuresEnabledWhenUnset contains a set of features that are enabled when not explicitly configured.
@@ -112,6 +120,7 @@ var featuresExported = map[SnapdFeature]bool{
 	ClassicPreservesXdgRuntimeDir: true,
 	RobustMountNamespaceUpdates:   true,
 	HiddenSnapFolder:              true,
+	SnapRunAudit:                  true,
 }
 
 // String returns the name of a snapd feature.
@@ -25,6 +25,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
@@ -70,13 +71,334 @@ var (
 	validGUUID      = regexp.MustCompile("^(?i)[0-9A-F]{8}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{12}$")
 )
 
+// SupportedSchemaVersion is the most recent version of the gadget.yaml schema
+// known to this version of snapd.
+const SupportedSchemaVersion = 1
+
 type Info struct {
+	// SchemaVersion is the version of the gadget.yaml schema the gadget
+	// was written for. Unknown fields are errors in gadgets declaring a
+	// supported schema version, while they are tolerated in gadgets
+	// declaring a newer one, or none for backward compatibility.
+	SchemaVersion int `yaml:"schema-version,omitempty"`
+
 	Volumes map[string]Volume `yaml:"volumes,omitempty"`
 
 	// Default configuration for snaps (snap-id => key => value).
 	Defaults map[string]map[string]interface{} `yaml:"defaults,omitempty"`
 
 	Connections []Connection `yaml:"connections"`
+
+	// Encryption describes the encryption of the volumes.
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+
+	// FactoryMode enables factory mode on the first boot of the device.
+	FactoryMode *FactoryMode `yaml:"factory-mode,omitempty"`
+
+	// RecoveryActions are additional actions offered by the recovery
+	// chooser.
+	RecoveryActions []RecoveryAction `yaml:"recovery-actions,omitempty"`
+
+	// UnknownFields lists the paths of the unknown fields of the gadget
+	// metadata that were tolerated and ignored.
+	UnknownFields []string `yaml:"-"`
+}
+
+// NewerSchema returns whether the gadget was written for a newer version of
+// the gadget.yaml schema than the one supported.
+func (gi *Info) NewerSchema() bool {
+	return gi.SchemaVersion > SupportedSchemaVersion
+}
+
+// strictSchema returns whether unknown fields are errors for the gadget.
+func (gi *Info) strictSchema() bool {
+	return gi.SchemaVersion != 0 && !gi.NewerSchema()
+}
+
+// knownIgnoredFields are gadget.yaml fields which are not used by snapd, but
+// are valid
+var knownIgnoredFields = map[string]bool{
+	// used by the bootloader on UC16 devices
+	"device-tree":        true,
+	"device-tree-origin": true,
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// yamlFieldName returns the name of the gadget.yaml key decoded into the
+// given struct field, or "" if the field is not decoded.
+func yamlFieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		// unexported
+		return ""
+	}
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// unknownFields returns the keys of the decoded gadget metadata in value which
+// do not match any field of the type t they are decoded into, as paths
+// relative to prefix.
+func unknownFields(prefix string, value interface{}, t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(yamlUnmarshalerType) {
+		// decoded by custom code
+		return nil
+	}
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]reflect.Type, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := yamlFieldName(f); name != "" {
+				fields[name] = f.Type
+			}
+		}
+		for k, v := range m {
+			key := fmt.Sprint(k)
+			ft, ok := fields[key]
+			if !ok {
+				if !knownIgnoredFields[key] {
+					unknown = append(unknown, prefix+key)
+				}
+				continue
+			}
+			unknown = append(unknown, unknownFields(prefix+key+".", v, ft)...)
+		}
+	case reflect.Map:
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, v := range m {
+			unknown = append(unknown, unknownFields(fmt.Sprintf("%s%v.", prefix, k), v, t.Elem())...)
+		}
+	case reflect.Slice:
+		l, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, v := range l {
+			unknown = append(unknown, unknownFields(fmt.Sprintf("%s%d.", prefix, i), v, t.Elem())...)
+		}
+	}
+	return unknown
+}
+
+// Encryption describes the encryption of the volumes of the device.
+type Encryption struct {
+	// KeyProtector selects the backend protecting the encryption keys. It
+	// is used when available on the device, otherwise keys are sealed to
+	// the TPM.
+	KeyProtector string `yaml:"key-protector,omitempty"`
+	// LUKS tunes the parameters of the LUKS volumes, for example so that
+	// low-memory devices can unlock them faster.
+	LUKS *LUKSParameters `yaml:"luks,omitempty"`
+	// TPM tunes how the TPM the keys are sealed to is provisioned.
+	TPM *TPMParameters `yaml:"tpm,omitempty"`
+}
+
+// TPMParameters tune the provisioning of the TPM when the device is
+// installed.
+type TPMParameters struct {
+	// SRKTemplate is the template of the storage root key, either
+	// rsa-2048, the default, or ecc-p256 which is considerably faster on
+	// slow TPMs.
+	SRKTemplate string `yaml:"srk-template,omitempty"`
+	// SRKHandle is the persistent handle of a storage root key
+	// provisioned by the device vendor, which is used instead of creating
+	// one.
+	SRKHandle uint32 `yaml:"srk-handle,omitempty"`
+	// WrapPolicyAuthKey wraps the policy auth key saved on ubuntu-save
+	// with a key bound to the TPM, so that it cannot be used off the
+	// device. The wrapped key is lost when the TPM is cleared.
+	WrapPolicyAuthKey bool `yaml:"wrap-policy-auth-key,omitempty"`
+	// SkipEKVerification skips the verification of the TPM endorsement
+	// key before sealing, for images meant for virtual machines whose
+	// vTPM certificate cannot be verified.
+	SkipEKVerification bool `yaml:"skip-ek-verification,omitempty"`
+}
+
+func validateTPMParameters(p *TPMParameters) error {
+	switch p.SRKTemplate {
+	case "", "rsa-2048", "ecc-p256":
+		// pass
+	default:
+		return fmt.Errorf("invalid TPM storage root key template %q", p.SRKTemplate)
+	}
+	if p.SRKHandle != 0 {
+		if p.SRKTemplate != "" {
+			return errors.New("cannot use both a TPM storage root key template and handle")
+		}
+		// persistent objects of the owner hierarchy
+		if p.SRKHandle < 0x81000000 || p.SRKHandle > 0x817fffff {
+			return fmt.Errorf("invalid TPM storage root key handle %#x", p.SRKHandle)
+		}
+	}
+	return nil
+}
+
+// LUKSParameters are the parameters the LUKS volumes are formatted with, the
+// defaults are used for the ones which are unset.
+type LUKSParameters struct {
+	// Cipher is the cipher of the volumes, eg. aes-xts-plain64.
+	Cipher string `yaml:"cipher,omitempty"`
+	// KeySize is the size of the volume key in bits.
+	KeySize int `yaml:"key-size,omitempty"`
+	// PBKDF is the key derivation function of the key slots, one of
+	// argon2i, argon2id or pbkdf2.
+	PBKDF string `yaml:"pbkdf,omitempty"`
+	// PBKDFMemory is the memory cost of argon2 in KiB, the minimal one
+	// when unset.
+	PBKDFMemory int `yaml:"pbkdf-memory,omitempty"`
+	// PBKDFIterations forces the number of iterations of the key
+	// derivation function, the minimal one when unset.
+	PBKDFIterations int `yaml:"pbkdf-iterations,omitempty"`
+	// SectorSize is the encryption sector size in bytes.
+	SectorSize int `yaml:"sector-size,omitempty"`
+	// DataIntegrity is the integrity algorithm, eg. hmac-sha256, the
+	// ubuntu-data volume is authenticated with using dm-integrity, so
+	// that offline modifications of its content are detected.
+	DataIntegrity string `yaml:"data-integrity,omitempty"`
+}
+
+var validLUKSCiphers = []string{
+	"aes-xts-plain64",
+	"aes-cbc-essiv:sha256",
+	"xchacha12,aes-adiantum-plain64",
+	"xchacha20,aes-adiantum-plain64",
+}
+
+var validLUKSIntegrity = []string{
+	"hmac-sha256",
+	"hmac-sha512",
+}
+
+func validateLUKSParameters(p *LUKSParameters) error {
+	if p.Cipher != "" && !strutil.ListContains(validLUKSCiphers, p.Cipher) {
+		return fmt.Errorf("invalid LUKS cipher %q", p.Cipher)
+	}
+	switch p.KeySize {
+	case 0, 256, 512:
+		// pass
+	default:
+		return fmt.Errorf("invalid LUKS key size %d", p.KeySize)
+	}
+	// adiantum only supports 256 bit keys
+	if p.KeySize == 512 && strings.HasSuffix(p.Cipher, "adiantum-plain64") {
+		return fmt.Errorf("cannot use LUKS key size %d with cipher %q", p.KeySize, p.Cipher)
+	}
+	switch p.PBKDF {
+	case "", "argon2i", "argon2id":
+		// the memory cost is bound by cryptsetup
+		if p.PBKDFMemory != 0 && (p.PBKDFMemory < 32 || p.PBKDFMemory > 4*1024*1024) {
+			return fmt.Errorf("invalid LUKS pbkdf memory %d, must be between 32 and 4194304 KiB", p.PBKDFMemory)
+		}
+		if p.PBKDFIterations != 0 && p.PBKDFIterations < 4 {
+			return fmt.Errorf("invalid LUKS pbkdf iterations %d, must be at least 4", p.PBKDFIterations)
+		}
+	case "pbkdf2":
+		if p.PBKDFMemory != 0 {
+			return errors.New("cannot use LUKS pbkdf memory with pbkdf2")
+		}
+		if p.PBKDFIterations != 0 && p.PBKDFIterations < 1000 {
+			return fmt.Errorf("invalid LUKS pbkdf iterations %d, must be at least 1000", p.PBKDFIterations)
+		}
+	default:
+		return fmt.Errorf("invalid LUKS pbkdf %q", p.PBKDF)
+	}
+	switch p.SectorSize {
+	case 0, 512, 1024, 2048, 4096:
+		// pass
+	default:
+		return fmt.Errorf("invalid LUKS sector size %d", p.SectorSize)
+	}
+	if p.DataIntegrity != "" {
+		if !strutil.ListContains(validLUKSIntegrity, p.DataIntegrity) {
+			return fmt.Errorf("invalid LUKS data integrity %q", p.DataIntegrity)
+		}
+		// adiantum is not supported for authenticated encryption
+		if strings.HasSuffix(p.Cipher, "adiantum-plain64") {
+			return fmt.Errorf("cannot use LUKS data integrity with cipher %q", p.Cipher)
+		}
+	}
+	return nil
+}
+
+// FactoryMode describes the policies relaxed while the device is in factory
+// mode. Factory mode is entered when the device is installed, in it the
+// encryption keys are not sealed to the TPM, until the device is sealed with
+// a one-way factory seal operation which also reverts the relaxed policies.
+type FactoryMode struct {
+	// AllowTestSnaps allows installing snaps without assertions even
+	// when the model grade is secured.
+	AllowTestSnaps bool `yaml:"allow-test-snaps,omitempty"`
+	// SerialConsole is the name of the serial tty on which a login
+	// console is started, eg. ttyS0.
+	SerialConsole string `yaml:"serial-console,omitempty"`
+}
+
+var validSerialConsole = regexp.MustCompile(`^tty[a-zA-Z0-9]+$`)
+
+const (
+	// RecoveryActionRunApp runs an application, for example hardware
+	// diagnostics.
+	RecoveryActionRunApp = "run-app"
+	// RecoveryActionWipeData factory resets the device from the current
+	// recovery system, the content of ubuntu-save is preserved.
+	RecoveryActionWipeData = "wipe-data"
+	// RecoveryActionPreviousSystem recovers the device using the recovery
+	// system preceding the current one.
+	RecoveryActionPreviousSystem = "previous-system"
+	// RecoveryActionCryptoErase makes the content of the encrypted
+	// ubuntu-data and ubuntu-save irrecoverable by destroying their keys,
+	// eg. when decommissioning the device.
+	RecoveryActionCryptoErase = "crypto-erase"
+)
+
+// RecoveryAction is an action declared by the gadget which the recovery
+// chooser offers in addition to the standard ones.
+type RecoveryAction struct {
+	// Title is presented to the user.
+	Title string `yaml:"title"`
+	// Action is the kind of action, one of the RecoveryAction* constants.
+	Action string `yaml:"action"`
+	// App is the application run by the run-app action, as <snap>.<app>.
+	App string `yaml:"app,omitempty"`
+}
+
+var validRecoveryActionApp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*\.[a-zA-Z0-9]+(-[a-zA-Z0-9]+)*$`)
+
+func validateRecoveryAction(ra *RecoveryAction) error {
+	if ra.Title == "" {
+		return errors.New("recovery action title cannot be empty")
+	}
+	switch ra.Action {
+	case RecoveryActionRunApp:
+		if !validRecoveryActionApp.MatchString(ra.App) {
+			return fmt.Errorf("invalid app %q of recovery action %q", ra.App, ra.Title)
+		}
+	case RecoveryActionWipeData, RecoveryActionPreviousSystem, RecoveryActionCryptoErase:
+		if ra.App != "" {
+			return fmt.Errorf("recovery action %q cannot have an app", ra.Title)
+		}
+	default:
+		return fmt.Errorf("invalid action %q of recovery action %q", ra.Action, ra.Title)
+	}
+	return nil
 }
 
 // Volume defines the structure and content for the image to be written into a
@@ -133,6 +455,14 @@ type VolumeStructure struct {
 	// Filesystem used for the partition, 'vfat', 'ext4' or 'none' for
 	// structures of type 'bare'
 	Filesystem string `yaml:"filesystem"`
+	// Encrypt declares a structure without a role encrypted like
+	// ubuntu-data at install, with its own key sealed alongside the one
+	// of ubuntu-data. It is unlocked in the initramfs and mounted at
+	// MountPoint.
+	Encrypt bool `yaml:"encrypt,omitempty"`
+	// MountPoint is the location, under /run/mnt, where an encrypted
+	// structure is mounted in the initramfs.
+	MountPoint string `yaml:"mount-point,omitempty"`
 	// Content of the structure
 	Content []VolumeContent `yaml:"content"`
 	Update  VolumeUpdate    `yaml:"update"`
@@ -315,6 +645,29 @@ func InfoFromGadgetYaml(gadgetYaml []byte, model Model) (*Info, error) {
 		return nil, fmt.Errorf("cannot parse gadget metadata: %v", err)
 	}
 
+	if gi.SchemaVersion < 0 {
+		return nil, fmt.Errorf("invalid schema version %d", gi.SchemaVersion)
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(gadgetYaml, &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse gadget metadata: %v", err)
+	}
+	unknown := unknownFields("", raw, reflect.TypeOf(gi))
+	sort.Strings(unknown)
+	for _, f := range unknown {
+		// encryption settings of a newer schema cannot be ignored as
+		// the device would end up less protected than intended
+		if strings.HasPrefix(f, "encryption.") {
+			return nil, fmt.Errorf("unsupported encryption setting %q", strings.TrimPrefix(f, "encryption."))
+		}
+	}
+	if len(unknown) != 0 {
+		if gi.strictSchema() {
+			return nil, fmt.Errorf("cannot parse gadget metadata of schema version %d: unknown fields %s", gi.SchemaVersion, strutil.Quoted(unknown))
+		}
+		gi.UnknownFields = unknown
+	}
+
 	for k, v := range gi.Defaults {
 		if !systemOrSnapID(k) {
 			return nil, fmt.Errorf(`default stanza not keyed by "system" or snap-id: %s`, k)
@@ -336,6 +689,57 @@ func InfoFromGadgetYaml(gadgetYaml []byte, model Model) (*Info, error) {
 		}
 	}
 
+	if gi.Encryption != nil {
+		switch gi.Encryption.KeyProtector {
+		case "", "tpm2", "optee", "caam":
+			// pass
+		default:
+			return nil, fmt.Errorf("invalid encryption key protector %q", gi.Encryption.KeyProtector)
+		}
+		if gi.Encryption.LUKS != nil {
+			if err := validateLUKSParameters(gi.Encryption.LUKS); err != nil {
+				return nil, err
+			}
+		}
+		if gi.Encryption.TPM != nil {
+			switch gi.Encryption.KeyProtector {
+			case "", "tpm2":
+				// pass
+			default:
+				return nil, fmt.Errorf("cannot use TPM parameters with the %q encryption key protector", gi.Encryption.KeyProtector)
+			}
+			if err := validateTPMParameters(gi.Encryption.TPM); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if gi.FactoryMode != nil {
+		if model != nil && !wantsSystemSeed(model) {
+			return nil, errors.New("factory mode is only supported on systems with a model grade")
+		}
+		if gi.FactoryMode.SerialConsole != "" && !validSerialConsole.MatchString(gi.FactoryMode.SerialConsole) {
+			return nil, fmt.Errorf("invalid factory mode serial console %q", gi.FactoryMode.SerialConsole)
+		}
+	}
+
+	if len(gi.RecoveryActions) != 0 {
+		if model != nil && !wantsSystemSeed(model) {
+			return nil, errors.New("recovery actions are only supported on systems with a model grade")
+		}
+		titles := make(map[string]bool, len(gi.RecoveryActions))
+		for i := range gi.RecoveryActions {
+			ra := &gi.RecoveryActions[i]
+			if err := validateRecoveryAction(ra); err != nil {
+				return nil, err
+			}
+			if titles[ra.Title] {
+				return nil, fmt.Errorf("duplicated recovery action %q", ra.Title)
+			}
+			titles[ra.Title] = true
+		}
+	}
+
 	if len(gi.Volumes) == 0 && classicOrUnconstrained(model) {
 		// volumes can be left out on classic
 		// can still specify defaults though
@@ -352,10 +756,10 @@ func InfoFromGadgetYaml(gadgetYaml []byte, model Model) (*Info, error) {
 		switch v.Bootloader {
 		case "":
 			// pass
-		case "grub", "u-boot", "android-boot", "lk":
+		case "grub", "u-boot", "android-boot", "lk", "systemd-boot":
 			bootloadersFound += 1
 		default:
-			return nil, errors.New("bootloader must be one of grub, u-boot, android-boot or lk")
+			return nil, errors.New("bootloader must be one of grub, u-boot, android-boot, lk or systemd-boot")
 		}
 	}
 	switch {
@@ -486,6 +890,14 @@ func validateVolume(name string, vol *Volume, model Model) error {
 	if err := ensureVolumeConsistency(state, model); err != nil {
 		return err
 	}
+	if vol.Bootloader == "systemd-boot" && state.SystemSeed != nil && state.SystemBoot != nil {
+		// systemd-boot in system-seed only finds the run mode boot
+		// loader entries in system-boot when it is the extended boot
+		// loader partition
+		if !isXBOOTLDRType(state.SystemBoot.Type) {
+			return fmt.Errorf("system-boot structure must have the XBOOTLDR type %s with systemd-boot", xbootldrGUID)
+		}
+	}
 
 	// sort by starting offset
 	sort.Sort(byStartOffset(structures))
@@ -645,6 +1057,40 @@ func validateReservedLabels(vs *VolumeStructure) error {
 	return nil
 }
 
+var (
+	validEncryptedMountPoint = regexp.MustCompile(`^/run/mnt/[a-z0-9]+(-[a-z0-9]+)*$`)
+
+	// reservedMountPoints are the mount points under /run/mnt used by
+	// the initramfs
+	reservedMountPoints = []string{
+		ubuntuBootLabel, ubuntuSeedLabel,
+		ubuntuDataLabel, ubuntuSaveLabel,
+		"data", "host", "base", "kernel", "snapd", "gadget",
+	}
+)
+
+func validateEncryptedStructure(vs *VolumeStructure) error {
+	if !vs.Encrypt {
+		if vs.MountPoint != "" {
+			return errors.New("mount-point is only supported for encrypted structures")
+		}
+		return nil
+	}
+	if vs.Role != "" {
+		return fmt.Errorf("structures with role %q cannot be declared encrypted", vs.Role)
+	}
+	if !vs.HasFilesystem() || vs.Label == "" {
+		return errors.New("encrypted structures must have a filesystem and a filesystem label")
+	}
+	if !validEncryptedMountPoint.MatchString(vs.MountPoint) {
+		return fmt.Errorf("invalid mount-point %q of encrypted structure", vs.MountPoint)
+	}
+	if strutil.ListContains(reservedMountPoints, filepath.Base(vs.MountPoint)) {
+		return fmt.Errorf("mount-point %q is reserved", vs.MountPoint)
+	}
+	return nil
+}
+
 func validateVolumeStructure(vs *VolumeStructure, vol *Volume) error {
 	if vs.Size == 0 {
 		return errors.New("missing size")
@@ -686,6 +1132,10 @@ func validateVolumeStructure(vs *VolumeStructure, vol *Volume) error {
 		return err
 	}
 
+	if err := validateEncryptedStructure(vs); err != nil {
+		return err
+	}
+
 	// TODO: validate structure size against sector-size; ubuntu-image uses
 	// a tmp file to find out the default sector size of the device the tmp
 	// file is created on
@@ -755,6 +1205,19 @@ func validateStructureType(s string, vol *Volume) error {
 	return nil
 }
 
+// xbootldrGUID is the GPT partition type of the extended boot loader
+// partition, see https://uapi-group.org/specifications/specs/discoverable_partitions_specification/
+const xbootldrGUID = "BC13C2FF-59E6-4262-A352-B275FD6F7172"
+
+// isXBOOTLDRType returns whether the structure type, a GPT UUID or a hybrid
+// ID, is the one of the extended boot loader partition.
+func isXBOOTLDRType(s string) bool {
+	if idx := strings.IndexRune(s, ','); idx != -1 {
+		s = s[idx+1:]
+	}
+	return strings.EqualFold(s, xbootldrGUID)
+}
+
 func validateRole(vs *VolumeStructure, vol *Volume) error {
 	if vs.Type == "bare" {
 		if vs.Role != "" && vs.Role != schemaMBR {
@@ -552,7 +552,303 @@ volumes:
 	c.Assert(err, IsNil)
 
 	_, err = gadget.ReadInfo(s.dir, nil)
-	c.Assert(err, ErrorMatches, "bootloader must be one of grub, u-boot, android-boot or lk")
+	c.Assert(err, ErrorMatches, "bootloader must be one of grub, u-boot, android-boot, lk or systemd-boot")
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlSchemaVersionStrict(c *C) {
+	yaml := "schema-version: 1\nfrobinate: true\n" + string(mockGadgetYaml)
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, ErrorMatches, `cannot parse gadget metadata of schema version 1: unknown fields "frobinate"`)
+
+	yaml = "schema-version: -1\n" + string(mockGadgetYaml)
+	err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, ErrorMatches, `invalid schema version -1`)
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlSchemaVersionLegacyTolerated(c *C) {
+	yaml := "frobinate: true\n" + string(mockGadgetYaml)
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.SchemaVersion, Equals, 0)
+	c.Check(ginfo.NewerSchema(), Equals, false)
+	c.Check(ginfo.UnknownFields, DeepEquals, []string{"frobinate"})
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlSchemaVersionNewer(c *C) {
+	yaml := string(mockGadgetYaml) + `
+schema-version: 99
+frobinate: true
+device-tree-origin: kernel
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.SchemaVersion, Equals, 99)
+	c.Check(ginfo.NewerSchema(), Equals, true)
+	c.Check(ginfo.UnknownFields, DeepEquals, []string{"frobinate"})
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlSchemaVersionNewerEncryption(c *C) {
+	for _, tc := range []struct {
+		encryption string
+		err        string
+	}{
+		{"  key-protector: quantum\n", `invalid encryption key protector "quantum"`},
+		{"  frobinate: true\n", `unsupported encryption setting "frobinate"`},
+		{"  tpm:\n    frobinate: true\n", `unsupported encryption setting "tpm.frobinate"`},
+	} {
+		yaml := string(mockGadgetYaml) + "\nschema-version: 99\nencryption:\n" + tc.encryption
+		err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+		c.Assert(err, IsNil)
+
+		// unknown encryption settings are never ignored
+		_, err = gadget.ReadInfo(s.dir, nil)
+		c.Check(err, ErrorMatches, tc.err, Commentf("%q", tc.encryption))
+	}
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlEncryption(c *C) {
+	yaml := string(mockGadgetYaml) + `
+encryption:
+  key-protector: optee
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Encryption, DeepEquals, &gadget.Encryption{KeyProtector: "optee"})
+
+	yaml = string(mockGadgetYaml) + `
+encryption:
+  key-protector: caam
+`
+	err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err = gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Encryption, DeepEquals, &gadget.Encryption{KeyProtector: "caam"})
+
+	yaml = string(mockGadgetYaml) + `
+encryption:
+  key-protector: silo
+`
+	err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, ErrorMatches, `invalid encryption key protector "silo"`)
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlEncryptionTPM(c *C) {
+	yaml := string(mockGadgetYaml) + `
+encryption:
+  tpm:
+    srk-template: ecc-p256
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Encryption, DeepEquals, &gadget.Encryption{
+		TPM: &gadget.TPMParameters{SRKTemplate: "ecc-p256"},
+	})
+
+	yaml = string(mockGadgetYaml) + `
+encryption:
+  tpm:
+    srk-handle: 0x81000002
+    wrap-policy-auth-key: true
+    skip-ek-verification: true
+`
+	err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err = gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Encryption, DeepEquals, &gadget.Encryption{
+		TPM: &gadget.TPMParameters{SRKHandle: 0x81000002, WrapPolicyAuthKey: true, SkipEKVerification: true},
+	})
+
+	for _, tc := range []struct {
+		encryption string
+		err        string
+	}{
+		{"tpm:\n    srk-template: rsa-4096", `invalid TPM storage root key template "rsa-4096"`},
+		{"tpm:\n    srk-handle: 0x1500016", `invalid TPM storage root key handle 0x1500016`},
+		{"tpm:\n    srk-template: ecc-p256\n    srk-handle: 0x81000002", `cannot use both a TPM storage root key template and handle`},
+		{"key-protector: optee\n  tpm:\n    srk-template: ecc-p256", `cannot use TPM parameters with the "optee" encryption key protector`},
+	} {
+		yaml = string(mockGadgetYaml) + "\nencryption:\n  " + tc.encryption + "\n"
+		err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, nil)
+		c.Check(err, ErrorMatches, tc.err, Commentf("%s", tc.encryption))
+	}
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlEncryptionLUKS(c *C) {
+	yaml := string(mockGadgetYaml) + `
+encryption:
+  luks:
+    cipher: xchacha12,aes-adiantum-plain64
+    key-size: 256
+    pbkdf: argon2id
+    pbkdf-memory: 65536
+    pbkdf-iterations: 4
+    sector-size: 4096
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Encryption, DeepEquals, &gadget.Encryption{
+		LUKS: &gadget.LUKSParameters{
+			Cipher:          "xchacha12,aes-adiantum-plain64",
+			KeySize:         256,
+			PBKDF:           "argon2id",
+			PBKDFMemory:     65536,
+			PBKDFIterations: 4,
+			SectorSize:      4096,
+		},
+	})
+
+	for _, tc := range []struct {
+		luks string
+		err  string
+	}{
+		{"cipher: rot13", `invalid LUKS cipher "rot13"`},
+		{"key-size: 128", `invalid LUKS key size 128`},
+		{"pbkdf: bcrypt", `invalid LUKS pbkdf "bcrypt"`},
+		{"pbkdf-memory: 16", `invalid LUKS pbkdf memory 16, must be between 32 and 4194304 KiB`},
+		{"pbkdf-iterations: 2", `invalid LUKS pbkdf iterations 2, must be at least 4`},
+		{"pbkdf: pbkdf2\n    pbkdf-memory: 1024", `cannot use LUKS pbkdf memory with pbkdf2`},
+		{"pbkdf: pbkdf2\n    pbkdf-iterations: 10", `invalid LUKS pbkdf iterations 10, must be at least 1000`},
+		{"sector-size: 8192", `invalid LUKS sector size 8192`},
+		{"data-integrity: crc32c", `invalid LUKS data integrity "crc32c"`},
+		{"cipher: xchacha20,aes-adiantum-plain64\n    data-integrity: hmac-sha256", `cannot use LUKS data integrity with cipher "xchacha20,aes-adiantum-plain64"`},
+		{"cipher: xchacha12,aes-adiantum-plain64\n    key-size: 512", `cannot use LUKS key size 512 with cipher "xchacha12,aes-adiantum-plain64"`},
+	} {
+		yaml = string(mockGadgetYaml) + "\nencryption:\n  luks:\n    " + tc.luks + "\n"
+		err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, nil)
+		c.Check(err, ErrorMatches, tc.err, Commentf("%s", tc.luks))
+	}
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlEncryptionLUKSDataIntegrity(c *C) {
+	yaml := string(mockGadgetYaml) + `
+encryption:
+  luks:
+    data-integrity: hmac-sha512
+    sector-size: 4096
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Encryption, DeepEquals, &gadget.Encryption{
+		LUKS: &gadget.LUKSParameters{
+			SectorSize:    4096,
+			DataIntegrity: "hmac-sha512",
+		},
+	})
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlFactoryMode(c *C) {
+	yaml := string(mockGadgetYaml) + `
+factory-mode:
+  allow-test-snaps: true
+  serial-console: ttyS0
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.FactoryMode, DeepEquals, &gadget.FactoryMode{AllowTestSnaps: true, SerialConsole: "ttyS0"})
+
+	// not available without a model grade
+	_, err = gadget.ReadInfo(s.dir, &modelConstraints{classic: false})
+	c.Assert(err, ErrorMatches, "factory mode is only supported on systems with a model grade")
+
+	yaml = string(mockGadgetYaml) + `
+factory-mode:
+  serial-console: /dev/ttyS0
+`
+	err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, ErrorMatches, `invalid factory mode serial console "/dev/ttyS0"`)
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlRecoveryActions(c *C) {
+	yaml := string(mockGadgetYaml) + `
+recovery-actions:
+  - title: Run hardware diagnostics
+    action: run-app
+    app: diag-tools.check-all
+  - title: Wipe data
+    action: wipe-data
+  - title: Use previous recovery system
+    action: previous-system
+  - title: Decommission
+    action: crypto-erase
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.RecoveryActions, DeepEquals, []gadget.RecoveryAction{
+		{Title: "Run hardware diagnostics", Action: "run-app", App: "diag-tools.check-all"},
+		{Title: "Wipe data", Action: "wipe-data"},
+		{Title: "Use previous recovery system", Action: "previous-system"},
+		{Title: "Decommission", Action: "crypto-erase"},
+	})
+
+	// not available without a model grade
+	_, err = gadget.ReadInfo(s.dir, &modelConstraints{classic: false})
+	c.Assert(err, ErrorMatches, "recovery actions are only supported on systems with a model grade")
+
+	for _, tc := range []struct {
+		actions string
+		err     string
+	}{
+		{"  - action: wipe-data\n", "recovery action title cannot be empty"},
+		{"  - title: foo\n    action: format-disk\n", `invalid action "format-disk" of recovery action "foo"`},
+		{"  - title: foo\n    action: run-app\n", `invalid app "" of recovery action "foo"`},
+		{"  - title: foo\n    action: run-app\n    app: diag-tools\n", `invalid app "diag-tools" of recovery action "foo"`},
+		{"  - title: foo\n    action: wipe-data\n    app: diag-tools.check\n", `recovery action "foo" cannot have an app`},
+		{"  - title: foo\n    action: crypto-erase\n    app: diag-tools.check\n", `recovery action "foo" cannot have an app`},
+		{"  - title: foo\n    action: wipe-data\n  - title: foo\n    action: previous-system\n", `duplicated recovery action "foo"`},
+	} {
+		yaml = string(mockGadgetYaml) + "recovery-actions:\n" + tc.actions
+		err = ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, nil)
+		c.Check(err, ErrorMatches, tc.err)
+	}
 }
 
 func (s *gadgetYamlTestSuite) TestReadGadgetYamlEmptyBootloader(c *C) {
@@ -750,6 +1046,58 @@ func (s *gadgetYamlTestSuite) TestReadGadgetYamlLkHappy(c *C) {
 	}
 }
 
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlSystemdBootHappy(c *C) {
+	yaml := strings.Replace(string(mockGadgetYaml), "bootloader: u-boot", "bootloader: systemd-boot", 1)
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(yaml), 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Volumes["volumename"].Bootloader, Equals, "systemd-boot")
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlSystemdBootUC20(c *C) {
+	const yaml = `
+volumes:
+  pc:
+    bootloader: systemd-boot
+    structure:
+      - name: ubuntu-seed
+        role: system-seed
+        type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        size: 10M
+        filesystem: vfat
+      - name: ubuntu-boot
+        role: system-boot
+        type: %s
+        size: 10M
+        filesystem: vfat
+      - name: ubuntu-data
+        role: system-data
+        type: 83,0FC63DAF-8483-4772-8E79-3D69D8477DE4
+        size: 10M
+        filesystem: ext4
+`
+	for _, tc := range []struct {
+		typ string
+		err string
+	}{
+		{"BC13C2FF-59E6-4262-A352-B275FD6F7172", ""},
+		{"EA,bc13c2ff-59e6-4262-a352-b275fd6f7172", ""},
+		{"83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", `invalid volume "pc": system-boot structure must have the XBOOTLDR type BC13C2FF-59E6-4262-A352-B275FD6F7172 with systemd-boot`},
+	} {
+		err := ioutil.WriteFile(s.gadgetYamlPath, []byte(fmt.Sprintf(yaml, tc.typ)), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, nil)
+		if tc.err == "" {
+			c.Check(err, IsNil, Commentf(tc.typ))
+		} else {
+			c.Check(err, ErrorMatches, tc.err, Commentf(tc.typ))
+		}
+	}
+}
+
 func (s *gadgetYamlTestSuite) TestReadGadgetYamlLkLegacyHappy(c *C) {
 	err := ioutil.WriteFile(s.gadgetYamlPath, gadgetYamlLkLegacy, 0644)
 	c.Assert(err, IsNil)
@@ -953,6 +1301,36 @@ func (s *gadgetYamlTestSuite) TestValidateFilesystem(c *C) {
 	}
 }
 
+func (s *gadgetYamlTestSuite) TestValidateEncryptedStructure(c *C) {
+	for i, tc := range []struct {
+		s   *gadget.VolumeStructure
+		err string
+	}{
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Encrypt: true, MountPoint: "/run/mnt/extra"}, ""},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra"}, ""},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", MountPoint: "/run/mnt/extra"}, "mount-point is only supported for encrypted structures"},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Role: "system-boot", Encrypt: true, MountPoint: "/run/mnt/extra"}, `structures with role "system-boot" cannot be declared encrypted`},
+		{&gadget.VolumeStructure{Filesystem: "none", Label: "extra", Encrypt: true, MountPoint: "/run/mnt/extra"}, "encrypted structures must have a filesystem and a filesystem label"},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Encrypt: true, MountPoint: "/run/mnt/extra"}, "encrypted structures must have a filesystem and a filesystem label"},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Encrypt: true}, `invalid mount-point "" of encrypted structure`},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Encrypt: true, MountPoint: "/mnt/extra"}, `invalid mount-point "/mnt/extra" of encrypted structure`},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Encrypt: true, MountPoint: "/run/mnt/../extra"}, `invalid mount-point "/run/mnt/../extra" of encrypted structure`},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Encrypt: true, MountPoint: "/run/mnt/ubuntu-data"}, `mount-point "/run/mnt/ubuntu-data" is reserved`},
+		{&gadget.VolumeStructure{Filesystem: "ext4", Label: "extra", Encrypt: true, MountPoint: "/run/mnt/kernel"}, `mount-point "/run/mnt/kernel" is reserved`},
+	} {
+		c.Logf("tc: %v %+v", i, tc.s)
+
+		tc.s.Type = "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+		tc.s.Size = 123
+		err := gadget.ValidateVolumeStructure(tc.s, &gadget.Volume{})
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, tc.err)
+		} else {
+			c.Check(err, IsNil)
+		}
+	}
+}
+
 func (s *gadgetYamlTestSuite) TestValidateVolumeSchema(c *C) {
 	for i, tc := range []struct {
 		s   string
@@ -32,6 +32,7 @@ import (
 	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/gadget/install"
 	"github.com/snapcore/snapd/gadget/quantity"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -330,3 +331,60 @@ func (s *contentTestSuite) TestMountFilesystem(c *C) {
 	err = install.MountFilesystem(&mockOnDiskStructureSystemSeed, boot.InitramfsRunMntDir)
 	c.Assert(err, ErrorMatches, "cannot mount a filesystem with no label")
 }
+
+func (s *contentTestSuite) TestMakeFilesystemOnLoopDevice(c *C) {
+	if os.Geteuid() != 0 {
+		c.Skip("the test needs to be run by the root user to attach loop devices")
+	}
+
+	const size = 8 * quantity.SizeMiB
+	img := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(ioutil.WriteFile(img, nil, 0644), IsNil)
+	c.Assert(os.Truncate(img, int64(size)), IsNil)
+
+	node, err := disks.AttachLoopDevice(img)
+	if err != nil {
+		c.Skip(err.Error())
+	}
+	defer func() {
+		c.Check(disks.DetachLoopDevice(node), IsNil)
+	}()
+	nodes, err := disks.LoopDevicesForFile(img)
+	c.Assert(err, IsNil)
+	c.Check(nodes, DeepEquals, []string{node})
+	backingFile, err := disks.LoopDeviceBackingFile(node)
+	c.Assert(err, IsNil)
+	c.Check(backingFile, Equals, img)
+
+	udevadm := testutil.MockCommand(c, "udevadm", "")
+	defer udevadm.Restore()
+
+	ds := &gadget.OnDiskStructure{
+		Node: node,
+		LaidOutStructure: gadget.LaidOutStructure{
+			VolumeStructure: &gadget.VolumeStructure{
+				Name:       "Writable",
+				Size:       size,
+				Role:       gadget.SystemData,
+				Label:      "ubuntu-data",
+				Filesystem: "ext4",
+			},
+		},
+	}
+	err = install.MakeFilesystem(ds)
+	c.Assert(err, IsNil)
+	c.Check(udevadm.Calls(), DeepEquals, [][]string{
+		{"udevadm", "trigger", "--settle", node},
+	})
+
+	// the ext4 superblock at 1024 bytes has the magic at offset 56 and
+	// the volume label at offset 120
+	f, err := os.Open(img)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	sb := make([]byte, 1024)
+	_, err = f.ReadAt(sb, 1024)
+	c.Assert(err, IsNil)
+	c.Check(sb[56:58], DeepEquals, []byte{0x53, 0xef})
+	c.Check(string(sb[120:120+len("ubuntu-data")+1]), Equals, "ubuntu-data\x00")
+}
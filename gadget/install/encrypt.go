@@ -38,6 +38,8 @@ var (
 var (
 	secbootFormatEncryptedDevice = secboot.FormatEncryptedDevice
 	secbootAddRecoveryKey        = secboot.AddRecoveryKey
+	secbootAddEncryptionKey      = secboot.AddEncryptionKey
+	secbootRemoveEncryptionKey   = secboot.RemoveEncryptionKey
 )
 
 // encryptedDevice represents a LUKS-backed encrypted block device.
@@ -47,9 +49,30 @@ type encryptedDevice struct {
 	Node   string
 }
 
+// luks2Options returns the options the LUKS volume of a structure with the
+// given role is formatted with for the given gadget parameters. Only
+// ubuntu-data is integrity protected.
+func luks2Options(params *gadget.LUKSParameters, role string) *secboot.LUKS2Options {
+	if params == nil {
+		return nil
+	}
+	opts := &secboot.LUKS2Options{
+		Cipher:          params.Cipher,
+		KeySize:         params.KeySize,
+		PBKDF:           params.PBKDF,
+		PBKDFMemoryKiB:  params.PBKDFMemory,
+		PBKDFIterations: params.PBKDFIterations,
+		SectorSize:      params.SectorSize,
+	}
+	if role == gadget.SystemData {
+		opts.Integrity = params.DataIntegrity
+	}
+	return opts
+}
+
 // newEncryptedDevice creates an encrypted device in the existing partition using the
-// specified key.
-func newEncryptedDevice(part *gadget.OnDiskStructure, key secboot.EncryptionKey, name string) (*encryptedDevice, error) {
+// specified key, formatted with the given LUKS parameters if set.
+func newEncryptedDevice(part *gadget.OnDiskStructure, key secboot.EncryptionKey, name string, params *gadget.LUKSParameters) (*encryptedDevice, error) {
 	dev := &encryptedDevice{
 		parent: part,
 		name:   name,
@@ -59,7 +82,7 @@ func newEncryptedDevice(part *gadget.OnDiskStructure, key secboot.EncryptionKey,
 		Node: fmt.Sprintf("/dev/mapper/%s", name),
 	}
 
-	if err := secbootFormatEncryptedDevice(key, name+"-enc", part.Node); err != nil {
+	if err := secbootFormatEncryptedDevice(key, name+"-enc", part.Node, luks2Options(params, part.Role)); err != nil {
 		return nil, fmt.Errorf("cannot format encrypted device: %v", err)
 	}
 
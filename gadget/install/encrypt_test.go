@@ -100,16 +100,17 @@ func (s *encryptSuite) TestNewEncryptedDevice(c *C) {
 		s.AddCleanup(s.mockCryptsetup.Restore)
 
 		calls := 0
-		restore := install.MockSecbootFormatEncryptedDevice(func(key secboot.EncryptionKey, label, node string) error {
+		restore := install.MockSecbootFormatEncryptedDevice(func(key secboot.EncryptionKey, label, node string, opts *secboot.LUKS2Options) error {
 			calls++
 			c.Assert(key, DeepEquals, s.mockedEncryptionKey)
 			c.Assert(label, Equals, "some-label-enc")
 			c.Assert(node, Equals, "/dev/node1")
+			c.Assert(opts, IsNil)
 			return tc.mockedFormatErr
 		})
 		defer restore()
 
-		dev, err := install.NewEncryptedDevice(&mockDeviceStructure, s.mockedEncryptionKey, "some-label")
+		dev, err := install.NewEncryptedDevice(&mockDeviceStructure, s.mockedEncryptionKey, "some-label", nil)
 		c.Assert(calls, Equals, 1)
 		if tc.expectedErr == "" {
 			c.Assert(err, IsNil)
@@ -129,6 +130,66 @@ func (s *encryptSuite) TestNewEncryptedDevice(c *C) {
 	}
 }
 
+func (s *encryptSuite) TestNewEncryptedDeviceWithLUKSParameters(c *C) {
+	s.mockCryptsetup = testutil.MockCommand(c, "cryptsetup", "")
+	s.AddCleanup(s.mockCryptsetup.Restore)
+
+	calls := 0
+	restore := install.MockSecbootFormatEncryptedDevice(func(key secboot.EncryptionKey, label, node string, opts *secboot.LUKS2Options) error {
+		calls++
+		c.Assert(opts, DeepEquals, &secboot.LUKS2Options{
+			Cipher:          "aes-xts-plain64",
+			KeySize:         256,
+			PBKDF:           "argon2id",
+			PBKDFMemoryKiB:  32768,
+			PBKDFIterations: 4,
+			SectorSize:      4096,
+		})
+		return nil
+	})
+	defer restore()
+
+	params := &gadget.LUKSParameters{
+		Cipher:          "aes-xts-plain64",
+		KeySize:         256,
+		PBKDF:           "argon2id",
+		PBKDFMemory:     32768,
+		PBKDFIterations: 4,
+		SectorSize:      4096,
+	}
+	dev, err := install.NewEncryptedDevice(&mockDeviceStructure, s.mockedEncryptionKey, "some-label", params)
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 1)
+	c.Assert(dev.Node, Equals, "/dev/mapper/some-label")
+}
+
+func (s *encryptSuite) TestNewEncryptedDeviceDataIntegrity(c *C) {
+	s.mockCryptsetup = testutil.MockCommand(c, "cryptsetup", "")
+	s.AddCleanup(s.mockCryptsetup.Restore)
+
+	var integrity []string
+	restore := install.MockSecbootFormatEncryptedDevice(func(key secboot.EncryptionKey, label, node string, opts *secboot.LUKS2Options) error {
+		c.Assert(opts, NotNil)
+		integrity = append(integrity, opts.Integrity)
+		return nil
+	})
+	defer restore()
+
+	params := &gadget.LUKSParameters{
+		DataIntegrity: "hmac-sha256",
+	}
+	for _, role := range []string{gadget.SystemData, gadget.SystemSave, ""} {
+		part := mockDeviceStructure
+		vs := *part.VolumeStructure
+		vs.Role = role
+		part.VolumeStructure = &vs
+		_, err := install.NewEncryptedDevice(&part, s.mockedEncryptionKey, "some-label", params)
+		c.Assert(err, IsNil)
+	}
+	// only ubuntu-data is integrity protected
+	c.Check(integrity, DeepEquals, []string{"hmac-sha256", "", ""})
+}
+
 func (s *encryptSuite) TestAddRecoveryKey(c *C) {
 	for _, tc := range []struct {
 		mockedAddErr error
@@ -140,7 +201,7 @@ func (s *encryptSuite) TestAddRecoveryKey(c *C) {
 		s.mockCryptsetup = testutil.MockCommand(c, "cryptsetup", "")
 		s.AddCleanup(s.mockCryptsetup.Restore)
 
-		restore := install.MockSecbootFormatEncryptedDevice(func(key secboot.EncryptionKey, label, node string) error {
+		restore := install.MockSecbootFormatEncryptedDevice(func(key secboot.EncryptionKey, label, node string, opts *secboot.LUKS2Options) error {
 			return nil
 		})
 		defer restore()
@@ -155,7 +216,7 @@ func (s *encryptSuite) TestAddRecoveryKey(c *C) {
 		})
 		defer restore()
 
-		dev, err := install.NewEncryptedDevice(&mockDeviceStructure, s.mockedEncryptionKey, "some-label")
+		dev, err := install.NewEncryptedDevice(&mockDeviceStructure, s.mockedEncryptionKey, "some-label", nil)
 		c.Assert(err, IsNil)
 
 		err = dev.AddRecoveryKey(s.mockedEncryptionKey, s.mockedRecoveryKey)
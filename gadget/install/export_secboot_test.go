@@ -21,16 +21,18 @@
 package install
 
 import (
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/secboot"
 )
 
 var (
+	CheckUnlockedSave         = checkUnlockedSave
 	EnsureLayoutCompatibility = ensureLayoutCompatibility
 	DeviceFromRole            = deviceFromRole
 	NewEncryptedDevice        = newEncryptedDevice
 )
 
-func MockSecbootFormatEncryptedDevice(f func(key secboot.EncryptionKey, label, node string) error) (restore func()) {
+func MockSecbootFormatEncryptedDevice(f func(key secboot.EncryptionKey, label, node string, opts *secboot.LUKS2Options) error) (restore func()) {
 	old := secbootFormatEncryptedDevice
 	secbootFormatEncryptedDevice = f
 	return func() {
@@ -45,3 +47,27 @@ func MockSecbootAddRecoveryKey(f func(key secboot.EncryptionKey, rkey secboot.Re
 		secbootAddRecoveryKey = old
 	}
 }
+
+func MockSecbootAddEncryptionKey(f func(node string, key []byte, newKey secboot.EncryptionKey) error) (restore func()) {
+	old := secbootAddEncryptionKey
+	secbootAddEncryptionKey = f
+	return func() {
+		secbootAddEncryptionKey = old
+	}
+}
+
+func MockSecbootRemoveEncryptionKey(f func(node string, key []byte) error) (restore func()) {
+	old := secbootRemoveEncryptionKey
+	secbootRemoveEncryptionKey = f
+	return func() {
+		secbootRemoveEncryptionKey = old
+	}
+}
+
+func MockDisksDmCryptMappingForDevice(f func(node string) (*disks.DmCryptMapping, error)) (restore func()) {
+	old := disksDmCryptMappingForDevice
+	disksDmCryptMappingForDevice = f
+	return func() {
+		disksDmCryptMappingForDevice = old
+	}
+}
@@ -27,10 +27,16 @@ import (
 
 	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/secboot"
 )
 
+var disksDmCryptMappingForDevice = disks.DmCryptMappingForDevice
+
 const (
+	ubuntuBootLabel = "ubuntu-boot"
 	ubuntuDataLabel = "ubuntu-data"
 	ubuntuSaveLabel = "ubuntu-save"
 )
@@ -50,6 +56,34 @@ func deviceFromRole(lv *gadget.LaidOutVolume, role string) (device string, err e
 	return "", fmt.Errorf("cannot find role %s in gadget", role)
 }
 
+func makeKeySet() (*EncryptionKeySet, error) {
+	key, err := secboot.NewEncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create encryption key: %v", err)
+	}
+
+	rkey, err := secboot.NewRecoveryKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create recovery key: %v", err)
+	}
+	return &EncryptionKeySet{
+		Key:         key,
+		RecoveryKey: rkey,
+	}, nil
+}
+
+// removeSealedKeyFiles removes the sealed key files placed outside of the
+// encrypted partitions.
+func removeSealedKeyFiles() error {
+	sealedKeyFiles, _ := filepath.Glob(filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "*.sealed-key"))
+	for _, keyFile := range sealedKeyFiles {
+		if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot cleanup obsolete key file: %v", keyFile)
+		}
+	}
+	return nil
+}
+
 // Run bootstraps the partitions of a device, by either creating
 // missing ones or recreating installed ones.
 func Run(gadgetRoot, device string, options Options, observer gadget.ContentObserver) (*InstalledSystemSideData, error) {
@@ -91,11 +125,8 @@ func Run(gadgetRoot, device string, options Options, observer gadget.ContentObse
 	// at this point we removed any existing partition, nuke any
 	// of the existing sealed key files placed outside of the
 	// encrypted partitions (LP: #1879338)
-	sealedKeyFiles, _ := filepath.Glob(filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "*.sealed-key"))
-	for _, keyFile := range sealedKeyFiles {
-		if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
-			return nil, fmt.Errorf("cannot cleanup obsolete key file: %v", keyFile)
-		}
+	if err := removeSealedKeyFiles(); err != nil {
+		return nil, err
 	}
 
 	created, err := createMissingPartitions(diskLayout, lv)
@@ -103,25 +134,11 @@ func Run(gadgetRoot, device string, options Options, observer gadget.ContentObse
 		return nil, fmt.Errorf("cannot create the partitions: %v", err)
 	}
 
-	makeKeySet := func() (*EncryptionKeySet, error) {
-		key, err := secboot.NewEncryptionKey()
-		if err != nil {
-			return nil, fmt.Errorf("cannot create encryption key: %v", err)
-		}
-
-		rkey, err := secboot.NewRecoveryKey()
-		if err != nil {
-			return nil, fmt.Errorf("cannot create recovery key: %v", err)
-		}
-		return &EncryptionKeySet{
-			Key:         key,
-			RecoveryKey: rkey,
-		}, nil
-	}
 	roleNeedsEncryption := func(role string) bool {
 		return role == gadget.SystemData || role == gadget.SystemSave
 	}
 	var keysForRoles map[string]*EncryptionKeySet
+	var keysForExtraVolumes map[string]*EncryptionKeySet
 
 	for _, part := range created {
 		if options.Encrypt && roleNeedsEncryption(part.Role) {
@@ -129,7 +146,7 @@ func Run(gadgetRoot, device string, options Options, observer gadget.ContentObse
 			if err != nil {
 				return nil, err
 			}
-			dataPart, err := newEncryptedDevice(&part, keys.Key, part.Label)
+			dataPart, err := newEncryptedDevice(&part, keys.Key, part.Label, options.LUKSParameters)
 			if err != nil {
 				return nil, err
 			}
@@ -144,6 +161,23 @@ func Run(gadgetRoot, device string, options Options, observer gadget.ContentObse
 				keysForRoles = map[string]*EncryptionKeySet{}
 			}
 			keysForRoles[part.Role] = keys
+		} else if options.Encrypt && part.Encrypt {
+			keys, err := makeKeySet()
+			if err != nil {
+				return nil, err
+			}
+			extraPart, err := newEncryptedDevice(&part, keys.Key, part.Label, options.LUKSParameters)
+			if err != nil {
+				return nil, err
+			}
+			if err := extraPart.AddRecoveryKey(keys.Key, keys.RecoveryKey); err != nil {
+				return nil, err
+			}
+			part.Node = extraPart.Node
+			if keysForExtraVolumes == nil {
+				keysForExtraVolumes = map[string]*EncryptionKeySet{}
+			}
+			keysForExtraVolumes[part.Label] = keys
 		}
 
 		if err := makeFilesystem(&part); err != nil {
@@ -162,13 +196,188 @@ func Run(gadgetRoot, device string, options Options, observer gadget.ContentObse
 	}
 
 	return &InstalledSystemSideData{
-		KeysForRoles: keysForRoles,
+		KeysForRoles:        keysForRoles,
+		KeysForExtraVolumes: keysForExtraVolumes,
 	}, nil
 }
 
+// FactoryReset resets the installed system on a device, by recreating the
+// ubuntu-boot and ubuntu-data partitions while preserving ubuntu-save and
+// its content. When encrypted, ubuntu-data gets a new key and ubuntu-save
+// gets a new key next to its current one, options.SaveKey. The current key
+// and the sealed key files holding it are left in place, they are only to
+// be removed once the new keys are sealed, so that ubuntu-save can still be
+// unlocked if the reset is interrupted.
+func FactoryReset(gadgetRoot, device string, options Options, observer gadget.ContentObserver) (*InstalledSystemSideData, error) {
+	if gadgetRoot == "" {
+		return nil, fmt.Errorf("cannot use empty gadget root directory")
+	}
+
+	lv, err := gadget.PositionedVolumeFromGadget(gadgetRoot)
+	if err != nil {
+		return nil, fmt.Errorf("cannot layout the volume: %v", err)
+	}
+
+	if device == "" {
+		device, err = deviceFromRole(lv, gadget.SystemSeed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find device to reset partitions on: %v", err)
+		}
+	}
+
+	diskLayout, err := gadget.OnDiskVolumeFromDevice(device)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %v partitions: %v", device, err)
+	}
+
+	if err := ensureLayoutCompatibility(lv, diskLayout); err != nil {
+		return nil, fmt.Errorf("gadget and %v partition table not compatible: %v", device, err)
+	}
+
+	// find the partitions of the installed system, which were all created
+	// at install
+	onDisk := func(role string) (*gadget.OnDiskStructure, error) {
+		for _, ls := range lv.LaidOutStructure {
+			if ls.Role != role {
+				continue
+			}
+			for _, ds := range diskLayout.Structure {
+				if ds.StartOffset != ls.StartOffset {
+					continue
+				}
+				part := &gadget.OnDiskStructure{
+					LaidOutStructure: ls,
+					Node:             ds.Node,
+				}
+				// use a copy of the gadget structure with the
+				// expected label, as done at install
+				vs := *ls.VolumeStructure
+				switch role {
+				case gadget.SystemBoot:
+					vs.Label = ubuntuBootLabel
+				case gadget.SystemData:
+					vs.Label = ubuntuDataLabel
+				case gadget.SystemSave:
+					vs.Label = ubuntuSaveLabel
+				}
+				part.VolumeStructure = &vs
+				return part, nil
+			}
+			return nil, fmt.Errorf("cannot find partition with role %q on %v", role, device)
+		}
+		return nil, fmt.Errorf("cannot find role %s in gadget", role)
+	}
+
+	savePart, err := onDisk(gadget.SystemSave)
+	if err != nil {
+		return nil, fmt.Errorf("cannot factory reset without ubuntu-save: %v", err)
+	}
+
+	var keysForRoles map[string]*EncryptionKeySet
+	var deviceForRole map[string]string
+	for _, role := range []string{gadget.SystemBoot, gadget.SystemData} {
+		part, err := onDisk(role)
+		if err != nil {
+			return nil, err
+		}
+
+		if options.Encrypt && role == gadget.SystemData {
+			keys, err := makeKeySet()
+			if err != nil {
+				return nil, err
+			}
+			dataPart, err := newEncryptedDevice(part, keys.Key, part.Label, options.LUKSParameters)
+			if err != nil {
+				return nil, err
+			}
+			if err := dataPart.AddRecoveryKey(keys.Key, keys.RecoveryKey); err != nil {
+				return nil, err
+			}
+			part.Node = dataPart.Node
+			keysForRoles = map[string]*EncryptionKeySet{role: keys}
+		}
+
+		if err := makeFilesystem(part); err != nil {
+			return nil, err
+		}
+
+		if err := writeContent(part, gadgetRoot, observer); err != nil {
+			return nil, err
+		}
+
+		if options.Mount && part.Label != "" && part.HasFilesystem() {
+			if err := mountFilesystem(part, boot.InitramfsRunMntDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if options.Encrypt {
+		// ubuntu-save keeps its content, only its keys are replaced
+		if err := checkUnlockedSave(savePart.Node); err != nil {
+			return nil, err
+		}
+		keys, err := makeKeySet()
+		if err != nil {
+			return nil, err
+		}
+		if err := secbootAddEncryptionKey(savePart.Node, options.SaveKey, keys.Key); err != nil {
+			return nil, err
+		}
+		if err := secbootAddRecoveryKey(keys.Key, keys.RecoveryKey, savePart.Node); err != nil {
+			if err := secbootRemoveEncryptionKey(savePart.Node, keys.Key[:]); err != nil {
+				logger.Noticef("cannot remove new key of %s: %v", savePart.Node, err)
+			}
+			return nil, err
+		}
+		keysForRoles[gadget.SystemSave] = keys
+		deviceForRole = map[string]string{gadget.SystemSave: savePart.Node}
+	}
+
+	return &InstalledSystemSideData{
+		KeysForRoles:  keysForRoles,
+		DeviceForRole: deviceForRole,
+	}, nil
+}
+
+// checkUnlockedSave checks that the ubuntu-save mounted by snap-bootstrap
+// is a LUKS2 dm-crypt mapping of the given partition, so that the keys of
+// the volume in use get replaced.
+func checkUnlockedSave(saveNode string) error {
+	mounts, err := osutil.LoadMountInfo()
+	if err != nil {
+		return fmt.Errorf("cannot verify the encryption of ubuntu-save: %v", err)
+	}
+	source := ""
+	for _, mnt := range mounts {
+		if mnt.MountDir == boot.InitramfsUbuntuSaveDir {
+			source = mnt.MountSource
+			break
+		}
+	}
+	if source == "" {
+		return fmt.Errorf("cannot verify the encryption of ubuntu-save: %s is not mounted", boot.InitramfsUbuntuSaveDir)
+	}
+	mapping, err := disksDmCryptMappingForDevice(source)
+	if err != nil {
+		return fmt.Errorf("cannot verify the encryption of ubuntu-save: %v", err)
+	}
+	if mapping.LUKSVersion != "LUKS2" {
+		return fmt.Errorf("cannot factory reset: ubuntu-save is not a LUKS2 volume")
+	}
+	if mapping.SourceKernelDeviceNode != saveNode {
+		return fmt.Errorf("cannot factory reset: ubuntu-save is unlocked from %s instead of %s", mapping.SourceKernelDeviceNode, saveNode)
+	}
+	return nil
+}
+
 // isCreatableAtInstall returns whether the gadget structure would be created at
-// install - currently that is only ubuntu-save, ubuntu-data, and ubuntu-boot
+// install - currently that is only ubuntu-save, ubuntu-data, ubuntu-boot and
+// the structures the gadget wants encrypted
 func isCreatableAtInstall(gv *gadget.VolumeStructure) bool {
+	if gv.Encrypt {
+		return true
+	}
 	// a structure is creatable at install if it is one of the roles for
 	// system-save, system-data, or system-boot
 	switch gv.Role {
@@ -21,6 +21,7 @@
 package install_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -28,10 +29,13 @@ import (
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/gadget/install"
 	"github.com/snapcore/snapd/gadget/quantity"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -63,6 +67,12 @@ func (s *installSuite) TestInstallRunError(c *C) {
 	c.Check(sys, IsNil)
 }
 
+func (s *installSuite) TestFactoryResetRunError(c *C) {
+	sys, err := install.FactoryReset("", "", install.Options{}, nil)
+	c.Assert(err, ErrorMatches, "cannot use empty gadget root directory")
+	c.Check(sys, IsNil)
+}
+
 const mockGadgetYaml = `volumes:
   pc:
     bootloader: grub
@@ -451,3 +461,43 @@ func (s *installSuite) TestDeviceFromRoleErrorNoRole(c *C) {
 	_, err := install.DeviceFromRole(lv, gadget.SystemSeed)
 	c.Assert(err, ErrorMatches, "cannot find role system-seed in gadget")
 }
+
+func (s *installSuite) TestCheckUnlockedSave(c *C) {
+	var mappingErr error
+	mapping := &disks.DmCryptMapping{
+		Name:                   "ubuntu-save-random",
+		Dev:                    "253:1",
+		KernelDeviceNode:       "/dev/dm-1",
+		SourceDev:              "252:4",
+		SourceKernelDeviceNode: "/dev/vda4",
+		LUKSVersion:            "LUKS2",
+		LUKSUUID:               "ae6e79de-00a9-406f-80ee-64ba7c1966bb",
+	}
+	restore := install.MockDisksDmCryptMappingForDevice(func(node string) (*disks.DmCryptMapping, error) {
+		c.Check(node, Equals, "/dev/mapper/ubuntu-save-random")
+		return mapping, mappingErr
+	})
+	defer restore()
+
+	restore = osutil.MockMountInfo("")
+	defer restore()
+	err := install.CheckUnlockedSave("/dev/vda4")
+	c.Check(err, ErrorMatches, `cannot verify the encryption of ubuntu-save: .*/run/mnt/ubuntu-save is not mounted`)
+
+	restore = osutil.MockMountInfo(fmt.Sprintf("130 30 253:1 / %s rw,relatime shared:54 - ext4 /dev/mapper/ubuntu-save-random rw\n", boot.InitramfsUbuntuSaveDir))
+	defer restore()
+	err = install.CheckUnlockedSave("/dev/vda4")
+	c.Check(err, IsNil)
+
+	// another partition than the one of ubuntu-save in the gadget
+	err = install.CheckUnlockedSave("/dev/vdb4")
+	c.Check(err, ErrorMatches, `cannot factory reset: ubuntu-save is unlocked from /dev/vda4 instead of /dev/vdb4`)
+
+	mapping.LUKSVersion = ""
+	err = install.CheckUnlockedSave("/dev/vda4")
+	c.Check(err, ErrorMatches, `cannot factory reset: ubuntu-save is not a LUKS2 volume`)
+
+	mappingErr = disks.NotDmCryptMappingError{Device: "/dev/mapper/ubuntu-save-random"}
+	err = install.CheckUnlockedSave("/dev/vda4")
+	c.Check(err, ErrorMatches, `cannot verify the encryption of ubuntu-save: device /dev/mapper/ubuntu-save-random is not a dm-crypt mapping`)
+}
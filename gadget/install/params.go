@@ -20,6 +20,7 @@
 package install
 
 import (
+	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/secboot"
 )
 
@@ -28,6 +29,12 @@ type Options struct {
 	Mount bool
 	// Encrypt the data partition
 	Encrypt bool
+	// LUKSParameters are the parameters of the LUKS volumes from the
+	// gadget, if any.
+	LUKSParameters *gadget.LUKSParameters
+	// SaveKey is the current key of the encrypted ubuntu-save partition,
+	// which is replaced by a new key during a factory reset
+	SaveKey []byte
 }
 
 // EncryptionKeySet is a set of encryption keys.
@@ -41,4 +48,11 @@ type EncryptionKeySet struct {
 type InstalledSystemSideData struct {
 	// KeysForRoles contains key sets for the relevant structure roles.
 	KeysForRoles map[string]*EncryptionKeySet
+	// KeysForExtraVolumes contains key sets for the structures the gadget
+	// wants encrypted, indexed by their filesystem label.
+	KeysForExtraVolumes map[string]*EncryptionKeySet
+	// DeviceForRole contains the block devices of the preserved structures
+	// whose previous keys are to be removed once the new keys are sealed,
+	// ie. ubuntu-save after a factory reset.
+	DeviceForRole map[string]string
 }
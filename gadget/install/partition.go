@@ -168,6 +168,10 @@ func wasCreatedDuringInstall(lv *gadget.LaidOutVolume, s gadget.OnDiskStructure)
 	// the role from the gadget, so only return true if the provided structure
 	// has the exact same StartOffset as one of those roles
 	for _, gs := range lv.LaidOutStructure {
+		if gs.Encrypt && s.StartOffset == gs.StartOffset {
+			// encrypted structures are created during install too
+			return true
+		}
 		// TODO: how to handle ubuntu-save here? maybe a higher level function
 		//       should decide whether to delete it or not?
 		switch gs.Role {
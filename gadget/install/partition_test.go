@@ -486,6 +486,107 @@ echo '{
 	c.Check(list, DeepEquals, []string{"/dev/node3", "/dev/node4"})
 }
 
+func (s *partitionTestSuite) TestCreatedDuringInstallGPTEncryptedStructure(c *C) {
+	cmdLsblk := testutil.MockCommand(c, "lsblk", `
+case $3 in
+	/dev/node1)
+		echo '{ "blockdevices": [ {"fstype":"ext4", "label":null} ] }'
+		;;
+	/dev/node2)
+		echo '{ "blockdevices": [ {"fstype":"ext4", "label":"ubuntu-seed"} ] }'
+		;;
+	/dev/node3)
+		echo '{ "blockdevices": [ {"fstype":"ext4", "label":"ubuntu-save"} ] }'
+		;;
+	/dev/node4)
+		echo '{ "blockdevices": [ {"fstype":"ext4", "label":"ubuntu-data"} ] }'
+		;;
+	/dev/node5)
+		echo '{ "blockdevices": [ {"fstype":"crypto_LUKS", "label":"vendor-data-enc"} ] }'
+		;;
+	*)
+		echo "unexpected args: $*"
+		exit 1
+		;;
+esac
+`)
+	defer cmdLsblk.Restore()
+	cmdSfdisk := testutil.MockCommand(c, "sfdisk", `
+echo '{
+  "partitiontable": {
+    "label": "gpt",
+    "id": "9151F25B-CDF0-48F1-9EDE-68CBD616E2CA",
+    "device": "/dev/node",
+    "unit": "sectors",
+    "firstlba": 34,
+    "lastlba": 8388574,
+    "partitions": [
+     {
+         "node": "/dev/node1",
+         "start": 2048,
+         "size": 2048,
+         "type": "21686148-6449-6E6F-744E-656564454649",
+         "uuid": "30a26851-4b08-4b8d-8aea-f686e723ed8c",
+         "name": "BIOS boot partition"
+     },
+     {
+         "node": "/dev/node2",
+         "start": 4096,
+         "size": 2457600,
+         "type": "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+         "uuid": "7ea3a75a-3f6d-4647-8134-89ae61fe88d5",
+         "name": "Linux filesystem"
+     },
+     {
+         "node": "/dev/node3",
+         "start": 2461696,
+         "size": 262144,
+         "type": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+         "uuid": "641764aa-a680-4d36-a7ad-f7bd01fd8d12",
+         "name": "Linux filesystem"
+     },
+     {
+         "node": "/dev/node4",
+         "start": 2723840,
+         "size": 2457600,
+         "type": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+         "uuid": "8ab3e8fd-d53d-4d72-9c5e-56146915fd07",
+         "name": "Another Linux filesystem"
+     },
+     {
+         "node": "/dev/node5",
+         "start": 5181440,
+         "size": 262144,
+         "type": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+         "uuid": "4b8e5c0e-37b7-4f6c-a5b0-c8b1e3b4e5a1",
+         "name": "Vendor"
+     }
+     ]
+  }
+}'
+`)
+	defer cmdSfdisk.Restore()
+
+	err := makeMockGadget(s.gadgetRoot, gptGadgetContentWithSave+`      - name: Vendor
+        filesystem: ext4
+        filesystem-label: vendor-data
+        type: 83,0FC63DAF-8483-4772-8E79-3D69D8477DE4
+        size: 128M
+        encrypt: true
+        mount-point: /run/mnt/vendor-data
+`)
+	c.Assert(err, IsNil)
+	pv, err := gadget.PositionedVolumeFromGadget(s.gadgetRoot)
+	c.Assert(err, IsNil)
+
+	dl, err := gadget.OnDiskVolumeFromDevice("node")
+	c.Assert(err, IsNil)
+
+	list := install.CreatedDuringInstall(pv, dl)
+	// the encrypted structure is created during install too
+	c.Check(list, DeepEquals, []string{"/dev/node3", "/dev/node4", "/dev/node5"})
+}
+
 // this is an mbr gadget like the pi, but doesn't have the amd64 mbr structure
 // so it's probably not representative, but still useful for unit tests here
 const mbrGadgetContentWithSave = `volumes:
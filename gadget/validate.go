@@ -54,20 +54,33 @@ func validateVolumeContentsPresence(gadgetSnapRootDir string, vol *LaidOutVolume
 // metadata and a matching content, under the provided model constraints, which
 // are handled identically to ReadInfo().
 func Validate(gadgetSnapRootDir string, model Model) error {
+	_, err := ValidateWithWarnings(gadgetSnapRootDir, model)
+	return err
+}
+
+// ValidateWithWarnings is like Validate, it additionally returns warnings
+// about the unknown fields of the gadget metadata that were tolerated.
+func ValidateWithWarnings(gadgetSnapRootDir string, model Model) (warnings []string, err error) {
 	info, err := ReadInfo(gadgetSnapRootDir, model)
 	if err != nil {
-		return fmt.Errorf("invalid gadget metadata: %v", err)
+		return nil, fmt.Errorf("invalid gadget metadata: %v", err)
 	}
 
 	for name, vol := range info.Volumes {
 		lv, err := LayoutVolume(gadgetSnapRootDir, &vol, defaultConstraints)
 		if err != nil {
-			return fmt.Errorf("invalid layout of volume %q: %v", name, err)
+			return nil, fmt.Errorf("invalid layout of volume %q: %v", name, err)
 		}
 		if err := validateVolumeContentsPresence(gadgetSnapRootDir, lv); err != nil {
-			return fmt.Errorf("invalid volume %q: %v", name, err)
+			return nil, fmt.Errorf("invalid volume %q: %v", name, err)
 		}
 	}
 
-	return nil
+	if info.NewerSchema() {
+		warnings = append(warnings, fmt.Sprintf("gadget metadata schema version %d is newer than the supported version %d", info.SchemaVersion, SupportedSchemaVersion))
+	}
+	for _, f := range info.UnknownFields {
+		warnings = append(warnings, fmt.Sprintf("ignoring unknown gadget metadata field %q", f))
+	}
+	return warnings, nil
 }
@@ -104,6 +104,33 @@ volumes:
 	c.Assert(err, ErrorMatches, `invalid gadget metadata: bootloader must be one of .*`)
 }
 
+func (s *validateGadgetTestSuite) TestValidateWithWarnings(c *C) {
+	var gadgetYamlContent = `
+schema-version: 2
+frobinate: true
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: foo
+        type: bare
+        size: 1M
+        frobinate: true
+`
+	makeSizedFile(c, filepath.Join(s.dir, "meta/gadget.yaml"), 0, []byte(gadgetYamlContent))
+
+	warnings, err := gadget.ValidateWithWarnings(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(warnings, DeepEquals, []string{
+		"gadget metadata schema version 2 is newer than the supported version 1",
+		`ignoring unknown gadget metadata field "frobinate"`,
+		`ignoring unknown gadget metadata field "volumes.pc.structure.0.frobinate"`,
+	})
+
+	// plain validation passes too
+	c.Check(gadget.Validate(s.dir, nil), IsNil)
+}
+
 func (s *validateGadgetTestSuite) TestValidateFilesystemContent(c *C) {
 	var gadgetYamlContent = `
 volumes:
@@ -20,14 +20,17 @@
 package httputil
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/snapcore/snapd/logger"
@@ -73,11 +76,44 @@ func (e *ExtraSSLCertsFromDir) Certs() ([]*CertData, error) {
 	return extraCerts, nil
 }
 
+// PinnedPublicKeys is an interface that provides the public keys a
+// host is pinned to, in addition to the regular certificate
+// verification.
+type PinnedPublicKeys interface {
+	// Pins returns the pins of the given host, as returned by
+	// PublicKeyPin. The host is not pinned when no pins are returned.
+	Pins(host string) ([]string, error)
+}
+
+const publicKeyPinPrefix = "sha256/"
+
+// PublicKeyPin returns the pin of the public key of the given
+// certificate, that is "sha256/" followed by the base64 encoded
+// digest of its subject public key info.
+func PublicKeyPin(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return publicKeyPinPrefix + base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// ValidatePublicKeyPin checks that the given pin has the form returned
+// by PublicKeyPin.
+func ValidatePublicKeyPin(pin string) error {
+	if !strings.HasPrefix(pin, publicKeyPinPrefix) {
+		return fmt.Errorf("invalid public key pin %q: must start with %q", pin, publicKeyPinPrefix)
+	}
+	digest, err := base64.StdEncoding.DecodeString(pin[len(publicKeyPinPrefix):])
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("invalid public key pin %q: must be a base64 encoded sha256 digest", pin)
+	}
+	return nil
+}
+
 // dialTLS holds a tls.Config that is used by the dialTLS.dialTLS()
 // function.
 type dialTLS struct {
-	conf          *tls.Config
-	extraSSLCerts ExtraSSLCerts
+	conf             *tls.Config
+	extraSSLCerts    ExtraSSLCerts
+	pinnedPublicKeys PinnedPublicKeys
 }
 
 // dialTLS will use it's tls.Config and use that to do a tls connection.
@@ -99,7 +135,47 @@ func (d *dialTLS) dialTLS(network, addr string) (net.Conn, error) {
 		logger.Noticef("cannot add local ssl certificates: %v", err)
 	}
 
-	return tls.Dial(network, addr, d.conf)
+	conn, err := tls.Dial(network, addr, d.conf)
+	if err != nil {
+		return nil, err
+	}
+	if d.pinnedPublicKeys != nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if err := checkPinnedPublicKeys(d.pinnedPublicKeys, host, conn.ConnectionState().VerifiedChains); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// checkPinnedPublicKeys checks that one of the verified certificates of a
+// connection to the given host matches the pins of the host, if it has
+// any.
+func checkPinnedPublicKeys(pinned PinnedPublicKeys, host string, verifiedChains [][]*x509.Certificate) error {
+	// pins are looked up on every connection so that changes to them
+	// apply without recreating the client
+	pins, err := pinned.Pins(host)
+	if err != nil {
+		return fmt.Errorf("cannot get pinned public keys of %s: %v", host, err)
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			pin := PublicKeyPin(cert)
+			for _, p := range pins {
+				if p == pin {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("cannot verify certificate of %s: no public key matches the pinned ones", host)
 }
 
 // addLocalSSLCertificates() is an internal helper that is called by
@@ -148,7 +224,8 @@ type ClientOptions struct {
 	Proxy              func(*http.Request) (*url.URL, error)
 	ProxyConnectHeader http.Header
 
-	ExtraSSLCerts ExtraSSLCerts
+	ExtraSSLCerts    ExtraSSLCerts
+	PinnedPublicKeys PinnedPublicKeys
 }
 
 // NewHTTPCLient returns a new http.Client with a LoggedTransport, a
@@ -169,10 +246,16 @@ func NewHTTPClient(opts *ClientOptions) *http.Client {
 	// by the cmd/snap-repair/runner_test.go
 	transport.TLSClientConfig = opts.TLSConfig
 	dialTLS := &dialTLS{
-		conf:          opts.TLSConfig,
-		extraSSLCerts: opts.ExtraSSLCerts,
+		conf:             opts.TLSConfig,
+		extraSSLCerts:    opts.ExtraSSLCerts,
+		pinnedPublicKeys: opts.PinnedPublicKeys,
 	}
 	transport.DialTLS = dialTLS.dialTLS
+	if opts.PinnedPublicKeys != nil {
+		// the transport sets up the TLS connections to hosts reached
+		// through a proxy itself, without dialTLS
+		setupProxiedPinnedPublicKeys(transport, opts.PinnedPublicKeys)
+	}
 
 	return &http.Client{
 		Transport: &LoggedTransport{
@@ -27,6 +27,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"io"
 	"io/ioutil"
 	"math/big"
@@ -36,6 +37,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/check.v1"
@@ -56,6 +58,20 @@ func mustParse(c *check.C, rawurl string) *url.URL {
 	return url
 }
 
+func (s *clientSuite) TestValidatePublicKeyPin(c *check.C) {
+	c.Check(httputil.ValidatePublicKeyPin("sha256/"+strings.Repeat("A", 43)+"="), check.IsNil)
+
+	for _, pin := range []string{
+		"",
+		strings.Repeat("A", 43) + "=",
+		"sha1/" + strings.Repeat("A", 27) + "=",
+		"sha256/" + strings.Repeat("A", 40),
+		"sha256/not-base64!",
+	} {
+		c.Check(httputil.ValidatePublicKeyPin(pin), check.ErrorMatches, `invalid public key pin ".*": must .*`, check.Commentf(pin))
+	}
+}
+
 type proxyProvider struct {
 	proxy *url.URL
 }
@@ -231,6 +247,108 @@ func (s *tlsSuite) TestClientExtraSSLCertIntegration(c *check.C) {
 	c.Assert(res.StatusCode, check.Equals, 200)
 }
 
+type mockPinnedPublicKeys struct {
+	pins map[string][]string
+	err  error
+}
+
+func (m *mockPinnedPublicKeys) Pins(host string) ([]string, error) {
+	return m.pins[host], m.err
+}
+
+func (s *tlsSuite) serverPin(c *check.C) string {
+	cert, err := tls.LoadX509KeyPair(s.certpath, s.keypath)
+	c.Assert(err, check.IsNil)
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	c.Assert(err, check.IsNil)
+	return httputil.PublicKeyPin(x509Cert)
+}
+
+func (s *tlsSuite) TestClientPinnedPublicKeys(c *check.C) {
+	otherPin := "sha256/" + strings.Repeat("A", 43) + "="
+	pinned := &mockPinnedPublicKeys{}
+	cli := httputil.NewHTTPClient(&httputil.ClientOptions{
+		ExtraSSLCerts: &httputil.ExtraSSLCertsFromDir{
+			Dir: dirs.SnapdStoreSSLCertsDir,
+		},
+		PinnedPublicKeys: pinned,
+	})
+	c.Assert(cli, check.NotNil)
+
+	// hosts without pins are not affected
+	res, err := cli.Get(s.srv.URL)
+	c.Assert(err, check.IsNil)
+	c.Check(res.StatusCode, check.Equals, 200)
+
+	// the certificate matches one of the pins
+	pinned.pins = map[string][]string{"127.0.0.1": {otherPin, s.serverPin(c)}}
+	cli.CloseIdleConnections()
+	res, err = cli.Get(s.srv.URL)
+	c.Assert(err, check.IsNil)
+	c.Check(res.StatusCode, check.Equals, 200)
+
+	// pins are looked up again for new connections
+	pinned.pins = map[string][]string{"127.0.0.1": {otherPin}}
+	cli.CloseIdleConnections()
+	_, err = cli.Get(s.srv.URL)
+	c.Assert(err, check.ErrorMatches, ".* cannot verify certificate of 127.0.0.1: no public key matches the pinned ones")
+
+	// and failing to get them refuses the connection
+	pinned.err = errors.New("boom")
+	_, err = cli.Get(s.srv.URL)
+	c.Assert(err, check.ErrorMatches, ".* cannot get pinned public keys of 127.0.0.1: boom")
+}
+
+func (s *tlsSuite) TestClientPinnedPublicKeysThroughProxy(c *check.C) {
+	// a proxy tunneling the connections with CONNECT
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "CONNECT")
+		target, err := net.Dial("tcp", r.Host)
+		if !c.Check(err, check.IsNil) {
+			return
+		}
+		defer target.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if !c.Check(err, check.IsNil) {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}))
+	defer proxyServer.Close()
+
+	certs := x509.NewCertPool()
+	certPEM, err := ioutil.ReadFile(s.certpath)
+	c.Assert(err, check.IsNil)
+	c.Assert(certs.AppendCertsFromPEM(certPEM), check.Equals, true)
+
+	otherPin := "sha256/" + strings.Repeat("A", 43) + "="
+	pinned := &mockPinnedPublicKeys{}
+	cli := httputil.NewHTTPClient(&httputil.ClientOptions{
+		TLSConfig: &tls.Config{RootCAs: certs},
+		Proxy: func(*http.Request) (*url.URL, error) {
+			return mustParse(c, proxyServer.URL), nil
+		},
+		PinnedPublicKeys: pinned,
+	})
+	c.Assert(cli, check.NotNil)
+
+	// hosts without pins are not affected
+	res, err := cli.Get(s.srv.URL)
+	c.Assert(err, check.IsNil)
+	c.Check(res.StatusCode, check.Equals, 200)
+
+	// the pins are not bypassed by going through the proxy
+	pinned.pins = map[string][]string{"127.0.0.1": {otherPin}}
+	cli.CloseIdleConnections()
+	_, err = cli.Get(s.srv.URL)
+	// the pins are verified during the handshake with go >= 1.15,
+	// reaching pinned hosts through a proxy is refused otherwise
+	c.Assert(err, check.ErrorMatches, ".* cannot verify certificate of 127.0.0.1: (no public key matches the pinned ones|pinned public keys cannot be verified through a proxy)")
+}
+
 func (s *tlsSuite) TestClientMaxTLS11Error(c *check.C) {
 	// create a server that uses our certs
 	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build go1.15
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package httputil
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// setupProxiedPinnedPublicKeys makes the TLS connections that the transport
+// sets up itself, to hosts reached through a proxy, verify the pinned
+// public keys of the host as part of the handshake.
+func setupProxiedPinnedPublicKeys(transport *http.Transport, pinned PinnedPublicKeys) {
+	conf := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		conf = transport.TLSClientConfig.Clone()
+	}
+	conf.VerifyConnection = func(state tls.ConnectionState) error {
+		if state.ServerName != "" {
+			return checkPinnedPublicKeys(pinned, state.ServerName, state.VerifiedChains)
+		}
+		// hosts given by their IP address are not sent as server name,
+		// the certificate was verified for that address though
+		if len(state.PeerCertificates) == 0 {
+			return nil
+		}
+		for _, ip := range state.PeerCertificates[0].IPAddresses {
+			if err := checkPinnedPublicKeys(pinned, ip.String(), state.VerifiedChains); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	transport.TLSClientConfig = conf
+}
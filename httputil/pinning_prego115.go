@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !go1.15
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// setupProxiedPinnedPublicKeys refuses to reach pinned hosts through a
+// proxy, as the transport sets up the TLS connections to them itself and
+// the pinned public keys cannot be verified as part of the handshake with
+// this version of Go.
+func setupProxiedPinnedPublicKeys(transport *http.Transport, pinned PinnedPublicKeys) {
+	proxy := transport.Proxy
+	if proxy == nil {
+		return
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(req)
+		if err != nil || proxyURL == nil || req.URL.Scheme != "https" {
+			return proxyURL, err
+		}
+		host := req.URL.Hostname()
+		pins, err := pinned.Pins(host)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get pinned public keys of %s: %v", host, err)
+		}
+		if len(pins) != 0 {
+			return nil, fmt.Errorf("cannot verify certificate of %s: pinned public keys cannot be verified through a proxy", host)
+		}
+		return proxyURL, nil
+	}
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+const (
+	// these files mark the seed as modified for development
+	conveniencesMarkerFile    = "dangerous-conveniences.yaml"
+	extraAssertionsFile       = "dangerous-extra.assert"
+	conveniencesCloudInitFile = "90_dangerous-conveniences.cfg"
+
+	conveniencesWarning = "generated by snap prepare-image for a model of grade dangerous, not for production use"
+)
+
+func hasDeveloperConveniences(opts *Options) bool {
+	return opts.DefaultUser != "" || len(opts.SSHKeys) != 0 || len(opts.ExtraAssertionFiles) != 0
+}
+
+// checkDeveloperConveniences checks that the developer conveniences of the
+// options can be used with the model and reads the extra assertions.
+func checkDeveloperConveniences(model *asserts.Model, opts *Options) ([]asserts.Assertion, error) {
+	if !hasDeveloperConveniences(opts) {
+		return nil, nil
+	}
+	if model.Grade() != asserts.ModelDangerous {
+		return nil, fmt.Errorf("cannot use a default user, SSH keys or extra assertions with a model not of grade dangerous")
+	}
+	if len(opts.SSHKeys) != 0 && opts.DefaultUser == "" {
+		return nil, fmt.Errorf("cannot use SSH keys without a default user")
+	}
+
+	var extra []asserts.Assertion
+	for _, fn := range opts.ExtraAssertionFiles {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read extra assertions: %v", err)
+		}
+		dec := asserts.NewDecoder(f)
+		for {
+			a, err := dec.Decode()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				f.Close()
+				return nil, fmt.Errorf("cannot decode extra assertions in %q: %v", fn, err)
+			}
+			if a.Type() == asserts.ModelType {
+				f.Close()
+				return nil, fmt.Errorf("cannot add model assertion from %q to the seed", fn)
+			}
+			extra = append(extra, a)
+		}
+		f.Close()
+	}
+	return extra, nil
+}
+
+type conveniencesMarker struct {
+	DefaultUser     string   `yaml:"default-user,omitempty"`
+	SSHKeys         int      `yaml:"ssh-keys,omitempty"`
+	ExtraAssertions []string `yaml:"extra-assertions,omitempty"`
+	UnassertedSnaps []string `yaml:"unasserted-snaps,omitempty"`
+}
+
+// writeDeveloperConveniences adds the developer conveniences to the seed of
+// the recovery system, along with a marker listing them and the unasserted
+// snaps of the seed.
+func writeDeveloperConveniences(db *asserts.Database, seedDir, label string, opts *Options, extra []asserts.Assertion, unasserted []string) error {
+	if !hasDeveloperConveniences(opts) && len(unasserted) == 0 {
+		return nil
+	}
+	systemDir := filepath.Join(seedDir, "systems", label)
+	marker := conveniencesMarker{
+		DefaultUser:     opts.DefaultUser,
+		SSHKeys:         len(opts.SSHKeys),
+		UnassertedSnaps: unasserted,
+	}
+
+	if len(extra) != 0 {
+		buf := &bytes.Buffer{}
+		enc := asserts.NewEncoder(buf)
+		for _, a := range extra {
+			// the assertions are committed to the device database on
+			// first boot so they need to be verifiable
+			if err := db.Check(a); err != nil {
+				return fmt.Errorf("cannot add extra assertion %v: %v", a.Ref(), err)
+			}
+			if err := enc.Encode(a); err != nil {
+				return err
+			}
+			marker.ExtraAssertions = append(marker.ExtraAssertions, a.Ref().String())
+		}
+		fn := filepath.Join(systemDir, "assertions", extraAssertionsFile)
+		if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if opts.DefaultUser != "" {
+		// cloud-init configuration from ubuntu-seed is used on install
+		// for models of grade dangerous
+		if err := writeDefaultUserCloudConfig(seedDir, opts); err != nil {
+			return err
+		}
+	}
+
+	out, err := yaml.Marshal(&marker)
+	if err != nil {
+		return err
+	}
+	content := append([]byte("# "+conveniencesWarning+"\n"), out...)
+	return ioutil.WriteFile(filepath.Join(systemDir, conveniencesMarkerFile), content, 0644)
+}
+
+type cloudInitUser struct {
+	Name              string   `yaml:"name"`
+	Groups            string   `yaml:"groups"`
+	Sudo              string   `yaml:"sudo"`
+	Shell             string   `yaml:"shell"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+func writeDefaultUserCloudConfig(seedDir string, opts *Options) error {
+	cfg := struct {
+		Users []cloudInitUser `yaml:"users"`
+	}{
+		Users: []cloudInitUser{{
+			Name:              opts.DefaultUser,
+			Groups:            "sudo",
+			Sudo:              "ALL=(ALL) NOPASSWD:ALL",
+			Shell:             "/bin/bash",
+			SSHAuthorizedKeys: opts.SSHKeys,
+		}},
+	}
+	out, err := yaml.Marshal(&cfg)
+	if err != nil {
+		return err
+	}
+	cloudCfgDir := filepath.Join(seedDir, "data/etc/cloud/cloud.cfg.d")
+	if err := os.MkdirAll(cloudCfgDir, 0755); err != nil {
+		return err
+	}
+	content := append([]byte("#cloud-config\n# "+conveniencesWarning+"\n"), out...)
+	return ioutil.WriteFile(filepath.Join(cloudCfgDir, conveniencesCloudInitFile), content, 0600)
+}
@@ -35,6 +35,12 @@ var (
 	InstallCloudConfig   = installCloudConfig
 )
 
+func MockToolVersions(f func() map[string]string) (restore func()) {
+	old := toolVersions
+	toolVersions = f
+	return func() { toolVersions = old }
+}
+
 func (tsto *ToolingStore) User() *auth.UserState {
 	return tsto.user
 }
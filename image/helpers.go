@@ -180,6 +180,10 @@ func (tac toolingStoreContext) ProxyStoreParams(defaultURL *url.URL) (proxyStore
 	return "", defaultURL, nil
 }
 
+func (tac toolingStoreContext) StoreTLSPins() ([]string, error) {
+	return nil, nil
+}
+
 func (tac toolingStoreContext) StoreID(fallback string) (string, error) {
 	return fallback, nil
 }
@@ -20,11 +20,14 @@
 package image
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -38,11 +41,13 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/verity"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/seed/seedwriter"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snapfile"
 	"github.com/snapcore/snapd/snap/squashfs"
+	"github.com/snapcore/snapd/snapdtool"
 	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/sysconfig"
 )
@@ -158,7 +163,119 @@ func makeLabel(now time.Time) string {
 	return now.UTC().Format("20060102")
 }
 
+// seedResult carries what is needed about a seed to describe it in a
+// manifest.
+type seedResult struct {
+	label string
+	snaps []*seedwriter.SeedSnap
+}
+
 func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
+	var expected *Manifest
+	if opts.VerifyManifestFile != "" {
+		var err error
+		expected, err = ReadManifest(opts.VerifyManifestFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var revisions map[string]snap.Revision
+	if expected != nil {
+		// build from the same inputs as the build being
+		// reproduced, snaps in the store could have moved on
+		opts = reproduceOptions(opts, expected)
+		var err error
+		revisions, err = manifestRevisions(expected)
+		if err != nil {
+			return err
+		}
+	}
+
+	var res seedResult
+	if model.Grade() != asserts.ModelGradeUnset {
+		// reuse the label of the build being reproduced, it
+		// would differ otherwise if built on another day
+		if expected != nil && expected.Label != "" {
+			res.label = expected.Label
+		} else {
+			res.label = makeLabel(time.Now())
+		}
+	}
+	if err := writeSeed(tsto, model, opts, revisions, &res); err != nil {
+		return err
+	}
+
+	if opts.ManifestFile == "" && expected == nil {
+		return nil
+	}
+	m, err := makeManifest(model, opts, &res)
+	if err != nil {
+		return err
+	}
+	if opts.ManifestFile != "" {
+		if err := WriteManifest(m, opts.ManifestFile); err != nil {
+			return err
+		}
+	}
+	if expected != nil {
+		if err := checkReproduced(expected, m); err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "Image is identical to the one described by %s\n", opts.VerifyManifestFile)
+	}
+	return nil
+}
+
+// makeManifest describes the inputs and the output of the image build
+// that wrote the given seed.
+func makeManifest(model *asserts.Model, opts *Options, res *seedResult) (*Manifest, error) {
+	modelDigest := sha256.Sum256(asserts.Encode(model))
+	m := &Manifest{
+		SnapdVersion: snapdtool.Version,
+		Tools:        toolVersions(),
+		Model: ManifestModel{
+			BrandID: model.BrandID(),
+			Model:   model.Model(),
+			SHA256:  hex.EncodeToString(modelDigest[:]),
+		},
+		Label:        res.label,
+		Classic:      opts.Classic,
+		Architecture: opts.Architecture,
+		Channel:      opts.Channel,
+		SeedVerity:   opts.SeedVerity,
+		Snaps:        make([]ManifestSnap, 0, len(res.snaps)),
+	}
+	if model.Grade() != asserts.ModelGradeUnset {
+		m.Model.Grade = string(model.Grade())
+	}
+	for _, sn := range res.snaps {
+		digest, _, err := asserts.SnapFileSHA3_384(sn.Path)
+		if err != nil {
+			return nil, err
+		}
+		m.Snaps = append(m.Snaps, ManifestSnap{
+			Name:     sn.SnapName(),
+			SnapID:   sn.Info.ID(),
+			Revision: sn.Info.Revision.String(),
+			Channel:  sn.Channel,
+			SHA3_384: digest,
+		})
+	}
+	sort.Slice(m.Snaps, func(i, j int) bool { return m.Snaps[i].Name < m.Snaps[j].Name })
+
+	// the manifests themselves are not part of the image
+	files, err := manifestFiles(opts.PrepareDir, opts.ManifestFile, opts.VerifyManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot describe image files: %v", err)
+	}
+	m.Files = files
+	return m, nil
+}
+
+// writeSeed writes the seed of the image, the snaps from the store with a
+// revision in revisions are fetched at that revision.
+func writeSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, revisions map[string]snap.Revision, res *seedResult) error {
 	if model.Classic() != opts.Classic {
 		return fmt.Errorf("internal error: classic model but classic mode not set")
 	}
@@ -167,7 +284,7 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 	var rootDir string
 	var bootRootDir string
 	var seedDir string
-	var label string
+	label := res.label
 	if !core20 {
 		if opts.Classic {
 			// Classic, PrepareDir is the root dir itself
@@ -190,7 +307,6 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 	} else {
 		// Core 20, writing for the system-seed partition
 		seedDir = filepath.Join(opts.PrepareDir, "system-seed")
-		label = makeLabel(time.Now())
 		bootRootDir = seedDir
 
 		// sanity check target
@@ -199,6 +315,11 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 		}
 	}
 
+	extraAsserts, err := checkDeveloperConveniences(model, opts)
+	if err != nil {
+		return err
+	}
+
 	// TODO: developer database in home or use snapd (but need
 	// a bit more API there, potential issues when crossing stores/series)
 	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
@@ -280,6 +401,7 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 			return err
 		}
 		sn.ARefs = aRefs
+		res.snaps = append(res.snaps, sn)
 	}
 
 	if err := w.InfoDerived(); err != nil {
@@ -307,6 +429,10 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 				Channel:        sn.Channel,
 				CohortKey:      opts.WideCohortKey,
 			}
+			if rev, ok := revisions[sn.SnapName()]; ok {
+				dlOpts.Revision = rev
+				dlOpts.CohortKey = ""
+			}
 			fn, info, redirectChannel, err := tsto.DownloadSnap(sn.SnapName(), dlOpts) // TODO|XXX make this take the SnapRef really
 			if err != nil {
 				return err
@@ -322,6 +448,7 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 			}
 			aRefs := f.Refs()[prev:]
 			sn.ARefs = aRefs
+			res.snaps = append(res.snaps, sn)
 		}
 
 		complete, err := w.Downloaded()
@@ -361,6 +488,22 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 		return err
 	}
 
+	if core20 {
+		unasserted := make([]string, len(unassertedSnaps))
+		for i, sn := range unassertedSnaps {
+			unasserted[i] = sn.SnapName()
+		}
+		if err := writeDeveloperConveniences(db, seedDir, label, opts, extraAsserts, unasserted); err != nil {
+			return err
+		}
+	}
+
+	if core20 && opts.SeedVerity {
+		if err := writeSeedVerity(w); err != nil {
+			return err
+		}
+	}
+
 	if opts.Classic {
 		// TODO:UC20: consider Core 20 extended models vs classic
 		seedFn := filepath.Join(seedDir, "seed.yaml")
@@ -443,3 +586,19 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options) error {
 
 	return nil
 }
+
+// writeSeedVerity stores the dm-verity hash tree of each of the essential
+// snaps next to it in the seed. No salt is used so that the builds stay
+// reproducible.
+func writeSeedVerity(w *seedwriter.Writer) error {
+	bootSnaps, err := w.BootSnaps()
+	if err != nil {
+		return err
+	}
+	for _, sn := range bootSnaps {
+		if _, err := verity.Format(sn.Path, sn.Path+".verity", nil); err != nil {
+			return fmt.Errorf("cannot write dm-verity hash tree of seed snap %s: %v", sn.SnapName(), err)
+		}
+	}
+	return nil
+}
@@ -2694,6 +2694,222 @@ func (s *imageSuite) TestSetupSeedCore20(c *C) {
 	})
 }
 
+func (s *imageSuite) TestSetupSeedCore20Manifest(c *C) {
+	bl := bootloadertest.Mock("grub", c.MkDir()).RecoveryAware()
+	bootloader.Force(bl)
+
+	restore := image.MockTrusted(s.StoreSigning.Trusted)
+	defer restore()
+
+	model := s.makeUC20Model(nil)
+
+	s.makeSnap(c, "snapd", nil, snap.R(1), "")
+	s.makeSnap(c, "core20", nil, snap.R(20), "")
+	s.makeSnap(c, "pc-kernel=20", nil, snap.R(1), "")
+	gadgetContent := [][]string{
+		{"grub-recovery.conf", "# recovery grub.cfg"},
+		{"grub.conf", "# boot grub.cfg"},
+	}
+	s.makeSnap(c, "pc=20", gadgetContent, snap.R(22), "")
+	s.makeSnap(c, "required20", nil, snap.R(21), "other")
+
+	tools := map[string]string{"go": "go1.13.8", "unsquashfs": "unsquashfs version 4.4 (2019/08/29)"}
+	restore = image.MockToolVersions(func() map[string]string { return tools })
+	defer restore()
+
+	manifestFn := filepath.Join(c.MkDir(), "manifest.json")
+	opts := &image.Options{
+		PrepareDir:   c.MkDir(),
+		ManifestFile: manifestFn,
+	}
+	err := image.SetupSeed(s.tsto, model, opts)
+	c.Assert(err, IsNil)
+
+	m, err := image.ReadManifest(manifestFn)
+	c.Assert(err, IsNil)
+	c.Check(m.Model.BrandID, Equals, "my-brand")
+	c.Check(m.Model.Grade, Equals, "signed")
+	c.Check(m.Label, Equals, image.MakeLabel(time.Now()))
+	c.Check(m.Tools, DeepEquals, tools)
+	c.Assert(m.Snaps, HasLen, 5)
+	c.Check(m.Snaps[0].Name, Equals, "core20")
+	c.Check(m.Snaps[0].Revision, Equals, "20")
+	c.Check(m.Snaps[0].SnapID, Equals, s.AssertedSnapID("core20"))
+	var found bool
+	for _, f := range m.Files {
+		if f.Path == "system-seed/snaps/pc_22.snap" {
+			found = true
+			c.Check(f.SHA256, HasLen, 64)
+		}
+	}
+	c.Check(found, Equals, true)
+
+	// rebuilding the same image reproduces it, reusing the label even
+	// if it is a different day
+	m.Label = "20201231"
+	err = image.WriteManifest(m, manifestFn)
+	c.Assert(err, IsNil)
+	s.storeActions = nil
+	opts = &image.Options{
+		PrepareDir:         c.MkDir(),
+		VerifyManifestFile: manifestFn,
+	}
+	err = image.SetupSeed(s.tsto, model, opts)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(opts.PrepareDir, "system-seed/systems/20201231"), testutil.FilePresent)
+	c.Check(s.stdout.String(), Matches, `(?s).*Image is identical to the one described by .*/manifest.json\n`)
+	// the snaps are fetched at the revisions of the manifest
+	c.Assert(s.storeActions, HasLen, 5)
+	for _, a := range s.storeActions {
+		if a.InstanceName == "pc" {
+			c.Check(a.Revision, Equals, snap.R(22))
+		}
+	}
+
+	// differences are explained
+	for i := range m.Snaps {
+		if m.Snaps[i].Name == "pc" {
+			m.Snaps[i].Revision = "21"
+		}
+	}
+	for i := range m.Files {
+		if m.Files[i].Path == "system-seed/snaps/pc_22.snap" {
+			m.Files[i].Path = "system-seed/snaps/pc_21.snap"
+		}
+	}
+	m.Tools["unsquashfs"] = "unsquashfs version 4.5 (2021/03/22)"
+	err = image.WriteManifest(m, manifestFn)
+	c.Assert(err, IsNil)
+	s.storeActions = nil
+	opts.PrepareDir = c.MkDir()
+	err = image.SetupSeed(s.tsto, model, opts)
+	c.Check(err, ErrorMatches, `(?s)image is not identical to the one described by the manifest:
+- unsquashfs version: expected "unsquashfs version 4.5 \(2021/03/22\)", got "unsquashfs version 4.4 \(2019/08/29\)"
+- snap "pc" revision: expected "21", got "22"
+- file "system-seed/snaps/pc_22.snap": unexpected
+- file "system-seed/snaps/pc_21.snap": missing`)
+	// the fake store ignores the pinned revision
+	for _, a := range s.storeActions {
+		if a.InstanceName == "pc" {
+			c.Check(a.Revision, Equals, snap.R(21))
+		}
+	}
+}
+
+func (s *imageSuite) setupSeedCore20Dangerous(c *C, opts *image.Options) (string, error) {
+	bl := bootloadertest.Mock("grub", c.MkDir()).RecoveryAware()
+	bootloader.Force(bl)
+
+	restore := image.MockTrusted(s.StoreSigning.Trusted)
+	defer restore()
+
+	model := s.makeUC20Model(map[string]interface{}{
+		"grade": "dangerous",
+	})
+
+	prepareDir := c.MkDir()
+
+	s.makeSnap(c, "snapd", nil, snap.R(1), "")
+	s.makeSnap(c, "core20", nil, snap.R(20), "")
+	s.makeSnap(c, "pc-kernel=20", nil, snap.R(1), "")
+	gadgetContent := [][]string{
+		{"grub-recovery.conf", "# recovery grub.cfg"},
+		{"grub.conf", "# boot grub.cfg"},
+	}
+	s.makeSnap(c, "pc=20", gadgetContent, snap.R(22), "")
+	s.makeSnap(c, "required20", nil, snap.R(21), "other")
+
+	opts.PrepareDir = prepareDir
+	return prepareDir, image.SetupSeed(s.tsto, model, opts)
+}
+
+func (s *imageSuite) TestSetupSeedCore20DangerousConveniences(c *C) {
+	userAssert, err := s.Brands.Signing("my-brand").Sign(asserts.SystemUserType, map[string]interface{}{
+		"authority-id": "my-brand",
+		"brand-id":     "my-brand",
+		"email":        "dev@example.com",
+		"series":       []interface{}{"16"},
+		"models":       []interface{}{"my-model"},
+		"name":         "Developer",
+		"username":     "dev",
+		"since":        time.Now().UTC().Format(time.RFC3339),
+		"until":        time.Now().AddDate(0, 1, 0).UTC().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	assertFile := filepath.Join(c.MkDir(), "user.assert")
+	c.Assert(ioutil.WriteFile(assertFile, asserts.Encode(userAssert), 0644), IsNil)
+
+	prepareDir, err := s.setupSeedCore20Dangerous(c, &image.Options{
+		DefaultUser:         "dev",
+		SSHKeys:             []string{"ssh-ed25519 AAAA dev@host"},
+		ExtraAssertionFiles: []string{assertFile},
+	})
+	c.Assert(err, IsNil)
+
+	seeddir := filepath.Join(prepareDir, "system-seed")
+	_, _, db := s.loadSeed(c, seeddir)
+	// the extra assertion is loaded from the seed
+	_, err = db.Find(asserts.SystemUserType, map[string]string{
+		"brand-id": "my-brand",
+		"email":    "dev@example.com",
+	})
+	c.Check(err, IsNil)
+
+	systems, err := filepath.Glob(filepath.Join(seeddir, "systems", "*"))
+	c.Assert(err, IsNil)
+	c.Assert(systems, HasLen, 1)
+	c.Check(filepath.Join(systems[0], "dangerous-conveniences.yaml"), testutil.FileEquals, `# generated by snap prepare-image for a model of grade dangerous, not for production use
+default-user: dev
+ssh-keys: 1
+extra-assertions:
+- system-user (dev@example.com; brand-id:my-brand)
+`)
+	c.Check(filepath.Join(seeddir, "data/etc/cloud/cloud.cfg.d/90_dangerous-conveniences.cfg"), testutil.FileEquals, `#cloud-config
+# generated by snap prepare-image for a model of grade dangerous, not for production use
+users:
+- name: dev
+  groups: sudo
+  sudo: ALL=(ALL) NOPASSWD:ALL
+  shell: /bin/bash
+  ssh_authorized_keys:
+  - ssh-ed25519 AAAA dev@host
+`)
+}
+
+func (s *imageSuite) TestSetupSeedCore20DangerousNoConveniences(c *C) {
+	prepareDir, err := s.setupSeedCore20Dangerous(c, &image.Options{})
+	c.Assert(err, IsNil)
+
+	markers, err := filepath.Glob(filepath.Join(prepareDir, "system-seed/systems/*/dangerous-conveniences.yaml"))
+	c.Assert(err, IsNil)
+	c.Check(markers, HasLen, 0)
+	c.Check(filepath.Join(prepareDir, "system-seed/data"), testutil.FileAbsent)
+}
+
+func (s *imageSuite) TestSetupSeedConveniencesErrors(c *C) {
+	restore := image.MockTrusted(s.StoreSigning.Trusted)
+	defer restore()
+
+	modelAssertFile := filepath.Join(c.MkDir(), "model.assert")
+	c.Assert(ioutil.WriteFile(modelAssertFile, asserts.Encode(s.model), 0644), IsNil)
+
+	for _, tc := range []struct {
+		model *asserts.Model
+		opts  *image.Options
+		err   string
+	}{
+		{s.makeUC20Model(nil), &image.Options{DefaultUser: "dev"}, "cannot use a default user, SSH keys or extra assertions with a model not of grade dangerous"},
+		{s.model, &image.Options{ExtraAssertionFiles: []string{modelAssertFile}}, "cannot use a default user, SSH keys or extra assertions with a model not of grade dangerous"},
+		{s.makeUC20Model(map[string]interface{}{"grade": "dangerous"}), &image.Options{SSHKeys: []string{"ssh-rsa AAAA"}}, "cannot use SSH keys without a default user"},
+		{s.makeUC20Model(map[string]interface{}{"grade": "dangerous"}), &image.Options{ExtraAssertionFiles: []string{modelAssertFile}}, `cannot add model assertion from ".*/model.assert" to the seed`},
+		{s.makeUC20Model(map[string]interface{}{"grade": "dangerous"}), &image.Options{ExtraAssertionFiles: []string{"/does/not/exist"}}, `cannot read extra assertions: open /does/not/exist: no such file or directory`},
+	} {
+		tc.opts.PrepareDir = c.MkDir()
+		err := image.SetupSeed(s.tsto, tc.model, tc.opts)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
 func (s *imageSuite) TestSetupSeedCore20UBoot(c *C) {
 	bootloader.Force(nil)
 	restore := image.MockTrusted(s.StoreSigning.Trusted)
@@ -0,0 +1,324 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package image
+
+import (
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+)
+
+// Manifest describes the exact inputs of an image build together with
+// digests of everything it wrote in the prepare directory, so that the
+// build can be attested and later reproduced and checked bit for bit.
+type Manifest struct {
+	// SnapdVersion is the version of the tooling that built the image.
+	SnapdVersion string `json:"snapd-version"`
+	// Tools are the versions of the other tools involved in the
+	// build, by name.
+	Tools map[string]string `json:"tools,omitempty"`
+
+	Model ManifestModel `json:"model"`
+	// Label is the label of the Core 20 recovery system of the image.
+	Label   string `json:"label,omitempty"`
+	Classic bool   `json:"classic,omitempty"`
+	// Architecture is the architecture that was requested for the
+	// build, if any.
+	Architecture string `json:"architecture,omitempty"`
+	Channel      string `json:"channel,omitempty"`
+	// SeedVerity is set if dm-verity hash trees of the essential
+	// snaps were stored in the seed.
+	SeedVerity bool `json:"seed-verity,omitempty"`
+
+	Snaps []ManifestSnap `json:"snaps"`
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestModel identifies the model assertion of an image build.
+type ManifestModel struct {
+	BrandID string `json:"brand-id"`
+	Model   string `json:"model"`
+	Grade   string `json:"grade,omitempty"`
+	// SHA256 is the digest of the encoded model assertion.
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestSnap describes a snap seeded into the image.
+type ManifestSnap struct {
+	Name     string `json:"name"`
+	SnapID   string `json:"snap-id,omitempty"`
+	Revision string `json:"revision"`
+	Channel  string `json:"channel,omitempty"`
+	// SHA3_384 is the digest of the snap file, as used by the
+	// snap-revision assertions.
+	SHA3_384 string `json:"sha3-384"`
+}
+
+// ManifestFile describes a file, directory or symlink written by an
+// image build, with its path relative to the prepare directory.
+type ManifestFile struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	// Size and SHA256 are set only for regular files.
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Target is set only for symlinks.
+	Target string `json:"target,omitempty"`
+}
+
+// toolVersions returns the versions of the tools other than snapd
+// involved in an image build, the ones that cannot be found are omitted.
+var toolVersions = func() map[string]string {
+	tools := map[string]string{
+		"go": runtime.Version(),
+	}
+	// the gadget is unpacked with unsquashfs
+	if out, err := exec.Command("unsquashfs", "-version").CombinedOutput(); err == nil {
+		tools["unsquashfs"] = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	}
+	return tools
+}
+
+// reproduceOptions returns a copy of the options of a build that must
+// reproduce the one described by the manifest, using the channels of the
+// manifest where the options do not give them.
+func reproduceOptions(opts *Options, m *Manifest) *Options {
+	o := *opts
+	if o.Channel == "" {
+		o.Channel = m.Channel
+	}
+	o.SeedVerity = o.SeedVerity || m.SeedVerity
+	channels := make(map[string]string, len(opts.SnapChannels))
+	for name, channel := range opts.SnapChannels {
+		channels[name] = channel
+	}
+	for _, sn := range m.Snaps {
+		if sn.Channel == "" || channels[sn.Name] != "" {
+			continue
+		}
+		for _, name := range opts.Snaps {
+			if name == sn.Name {
+				channels[sn.Name] = sn.Channel
+			}
+		}
+	}
+	o.SnapChannels = channels
+	return &o
+}
+
+// manifestRevisions returns the revisions of the snaps from the store
+// described by the manifest, by name.
+func manifestRevisions(m *Manifest) (map[string]snap.Revision, error) {
+	revisions := make(map[string]snap.Revision, len(m.Snaps))
+	for _, sn := range m.Snaps {
+		if sn.SnapID == "" {
+			// not from the store
+			continue
+		}
+		rev, err := snap.ParseRevision(sn.Revision)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use revision of snap %q from image manifest: %v", sn.Name, err)
+		}
+		revisions[sn.Name] = rev
+	}
+	return revisions, nil
+}
+
+// manifestFiles returns the description of everything under rootDir,
+// sorted by path, skipping the given paths.
+func manifestFiles(rootDir string, skip ...string) ([]ManifestFile, error) {
+	files := []ManifestFile{}
+	err := filepath.Walk(rootDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == rootDir {
+			return nil
+		}
+		for _, s := range skip {
+			if path == s {
+				return nil
+			}
+		}
+		rel, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		mf := ManifestFile{
+			Path: filepath.ToSlash(rel),
+			Mode: fi.Mode().String(),
+		}
+		switch {
+		case fi.Mode().IsRegular():
+			digest, _, err := osutil.FileDigest(path, crypto.SHA256)
+			if err != nil {
+				return fmt.Errorf("cannot compute digest of %q: %v", path, err)
+			}
+			mf.Size = fi.Size()
+			mf.SHA256 = hex.EncodeToString(digest)
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			mf.Target = target
+		}
+		files = append(files, mf)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// WriteManifest writes the manifest as JSON to the given file.
+func WriteManifest(m *Manifest, fn string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	if err := osutil.AtomicWriteFile(fn, content, 0644, 0); err != nil {
+		return fmt.Errorf("cannot write image manifest: %v", err)
+	}
+	return nil
+}
+
+// ReadManifest reads a manifest written by WriteManifest.
+func ReadManifest(fn string) (*Manifest, error) {
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image manifest: %v", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("cannot decode image manifest %q: %v", fn, err)
+	}
+	return &m, nil
+}
+
+// CompareManifests returns a description of the differences between the
+// manifest of a build and the one of its rebuild, one per line. Differences
+// of inputs come first as they usually explain the differences of the
+// files. No differences means the rebuilt image is bit-identical.
+func CompareManifests(expected, actual *Manifest) []string {
+	var diffs []string
+	differ := func(what, exp, act string) {
+		if exp != act {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %q, got %q", what, exp, act))
+		}
+	}
+
+	differ("snapd version", expected.SnapdVersion, actual.SnapdVersion)
+	tools := make([]string, 0, len(expected.Tools)+len(actual.Tools))
+	for name := range expected.Tools {
+		tools = append(tools, name)
+	}
+	for name := range actual.Tools {
+		if _, ok := expected.Tools[name]; !ok {
+			tools = append(tools, name)
+		}
+	}
+	sort.Strings(tools)
+	for _, name := range tools {
+		differ(fmt.Sprintf("%s version", name), expected.Tools[name], actual.Tools[name])
+	}
+	differ("model brand", expected.Model.BrandID, actual.Model.BrandID)
+	differ("model", expected.Model.Model, actual.Model.Model)
+	differ("model grade", expected.Model.Grade, actual.Model.Grade)
+	differ("model assertion digest", expected.Model.SHA256, actual.Model.SHA256)
+	differ("label", expected.Label, actual.Label)
+	differ("classic", fmt.Sprint(expected.Classic), fmt.Sprint(actual.Classic))
+	differ("architecture", expected.Architecture, actual.Architecture)
+	differ("channel", expected.Channel, actual.Channel)
+	differ("seed verity", fmt.Sprint(expected.SeedVerity), fmt.Sprint(actual.SeedVerity))
+
+	expSnaps := make(map[string]ManifestSnap, len(expected.Snaps))
+	for _, sn := range expected.Snaps {
+		expSnaps[sn.Name] = sn
+	}
+	seenSnaps := make(map[string]bool, len(actual.Snaps))
+	for _, act := range actual.Snaps {
+		seenSnaps[act.Name] = true
+		exp, ok := expSnaps[act.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("snap %q (revision %s): unexpected", act.Name, act.Revision))
+			continue
+		}
+		differ(fmt.Sprintf("snap %q snap-id", act.Name), exp.SnapID, act.SnapID)
+		differ(fmt.Sprintf("snap %q revision", act.Name), exp.Revision, act.Revision)
+		differ(fmt.Sprintf("snap %q channel", act.Name), exp.Channel, act.Channel)
+		differ(fmt.Sprintf("snap %q digest", act.Name), exp.SHA3_384, act.SHA3_384)
+	}
+	for _, exp := range expected.Snaps {
+		if !seenSnaps[exp.Name] {
+			diffs = append(diffs, fmt.Sprintf("snap %q (revision %s): missing", exp.Name, exp.Revision))
+		}
+	}
+
+	expFiles := make(map[string]ManifestFile, len(expected.Files))
+	for _, f := range expected.Files {
+		expFiles[f.Path] = f
+	}
+	seenFiles := make(map[string]bool, len(actual.Files))
+	for _, act := range actual.Files {
+		seenFiles[act.Path] = true
+		exp, ok := expFiles[act.Path]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("file %q: unexpected", act.Path))
+			continue
+		}
+		differ(fmt.Sprintf("file %q mode", act.Path), exp.Mode, act.Mode)
+		differ(fmt.Sprintf("file %q symlink target", act.Path), exp.Target, act.Target)
+		if exp.SHA256 != act.SHA256 {
+			diffs = append(diffs, fmt.Sprintf("file %q: content differs (size %d, got %d)", act.Path, exp.Size, act.Size))
+		}
+	}
+	for _, exp := range expected.Files {
+		if !seenFiles[exp.Path] {
+			diffs = append(diffs, fmt.Sprintf("file %q: missing", exp.Path))
+		}
+	}
+
+	return diffs
+}
+
+// checkReproduced checks that the actual manifest of a build matches the
+// expected one, with an error explaining the differences otherwise.
+func checkReproduced(expected, actual *Manifest) error {
+	diffs := CompareManifests(expected, actual)
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("image is not identical to the one described by the manifest:\n- %s", strings.Join(diffs, "\n- "))
+}
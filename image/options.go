@@ -36,7 +36,33 @@ type Options struct {
 
 	PrepareDir string
 
+	// ManifestFile is where to write, if set, a manifest of the
+	// exact inputs of the build and the digests of the files it
+	// wrote under PrepareDir, for attestation.
+	ManifestFile string
+	// VerifyManifestFile is a manifest written by a previous build
+	// that this one must reproduce bit for bit, the differences
+	// are reported otherwise. The Core 20 recovery system label
+	// of the previous build is reused.
+	VerifyManifestFile string
+	// SeedVerity makes the Core 20 build store a dm-verity hash tree
+	// next to each of the essential seed snaps, so that their root
+	// hashes get measured when booting the recovery system.
+	SeedVerity bool
+
 	// Architecture to use if none is specified by the model,
 	// useful only for classic mode. If set must match the model otherwise.
 	Architecture string
+
+	// The following are developer conveniences, only allowed for
+	// models of grade dangerous.
+
+	// DefaultUser is a user created by cloud-init on first boot, with
+	// sudo rights.
+	DefaultUser string
+	// SSHKeys are public SSH keys authorized for DefaultUser.
+	SSHKeys []string
+	// ExtraAssertionFiles are files with assertions to add to the seed,
+	// for example system-user assertions.
+	ExtraAssertionFiles []string
 }
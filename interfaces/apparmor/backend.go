@@ -536,6 +536,32 @@ func (b *Backend) SetupMany(snaps []*snap.Info, confinement func(snapName string
 	return errors
 }
 
+// PreviewSetup returns the apparmor profiles of a given snap which are in
+// place and the ones Setup would write, without writing nor loading them.
+func (b *Backend) PreviewSetup(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository) (current, proposed map[string][]byte, err error) {
+	snapName := snapInfo.InstanceName()
+	spec, err := repo.SnapSpecification(b.Name(), snapName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot obtain apparmor specification for snap %q: %s", snapName, err)
+	}
+	spec.(*Specification).AddOvername(snapInfo)
+	spec.(*Specification).AddLayout(snapInfo)
+
+	content, err := b.deriveContent(spec.(*Specification), snapInfo, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot obtain expected security files for snap %q: %s", snapName, err)
+	}
+	proposed, err = interfaces.ArtefactsContent(dirs.SnapAppArmorDir, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err = interfaces.ReadArtefacts(dirs.SnapAppArmorDir, profileGlobs(snapName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read apparmor profiles of snap %q: %s", snapName, err)
+	}
+	return current, proposed, nil
+}
+
 // Remove removes and unloads apparmor profiles of a given snap.
 func (b *Backend) Remove(snapName string) error {
 	dir := dirs.SnapAppArmorDir
@@ -727,8 +753,10 @@ func addContent(securityTag string, snapInfo *snap.Info, cmdName string, opts in
 				}
 				tagSnippets = strings.Replace(tagSnippets, "###HOME_IX###", repl, -1)
 
-				// Conditionally add privilege dropping policy
-				if len(snapInfo.SystemUsernames) > 0 {
+				// Conditionally add privilege dropping policy,
+				// services which run as a system username are
+				// started unprivileged and have nothing to drop
+				if len(snapInfo.SystemUsernames) > 0 && !runsAsSystemUsername(snapInfo, cmdName) {
 					tagSnippets += privDropAndChownRules
 				}
 			}
@@ -744,6 +772,13 @@ func addContent(securityTag string, snapInfo *snap.Info, cmdName string, opts in
 	}
 }
 
+// runsAsSystemUsername returns whether the given command of the snap is a
+// service started as a system username rather than root.
+func runsAsSystemUsername(snapInfo *snap.Info, cmdName string) bool {
+	app, ok := snapInfo.Apps[cmdName]
+	return ok && app.IsService() && app.RunAs != ""
+}
+
 // NewSpecification returns a new, empty apparmor specification.
 func (b *Backend) NewSpecification() interfaces.Specification {
 	return &Specification{}
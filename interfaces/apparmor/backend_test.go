@@ -370,6 +370,28 @@ func (s *backendSuite) TestUpdatingSnapToOneWithMoreApps(c *C) {
 	}
 }
 
+func (s *backendSuite) TestPreviewSetup(c *C) {
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 1)
+	s.parserCmd.ForgetCalls()
+	newInfo := snaptest.MockInfo(c, ifacetest.SambaYamlV1WithNmbd, &snap.SideInfo{Revision: snap.R(2)})
+
+	current, proposed, err := s.Backend.(interfaces.SecurityBackendPreview).PreviewSetup(newInfo, interfaces.ConfinementOptions{}, s.Repo)
+	c.Assert(err, IsNil)
+
+	updateNSProfile := filepath.Join(dirs.SnapAppArmorDir, "snap-update-ns.samba")
+	smbdProfile := filepath.Join(dirs.SnapAppArmorDir, "snap.samba.smbd")
+	nmbdProfile := filepath.Join(dirs.SnapAppArmorDir, "snap.samba.nmbd")
+	c.Assert(current, HasLen, 2)
+	c.Check(current[smbdProfile], NotNil)
+	c.Check(current[updateNSProfile], NotNil)
+	c.Assert(proposed, HasLen, 3)
+	c.Check(proposed[nmbdProfile], NotNil)
+	c.Check(string(proposed[updateNSProfile]), Equals, string(current[updateNSProfile]))
+	// nothing was written nor loaded
+	c.Check(nmbdProfile, testutil.FileAbsent)
+	c.Check(s.parserCmd.Calls(), HasLen, 0)
+}
+
 func (s *backendSuite) TestUpdatingSnapToOneWithMoreHooks(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1WithNmbd, 1)
@@ -2256,6 +2278,40 @@ apps:
 	s.RemoveSnap(c, snapInfo)
 }
 
+func (s *backendSuite) TestSystemUsernamesPolicyRunAs(c *C) {
+	restoreTemplate := apparmor.MockTemplate("template\n###SNIPPETS###\n")
+	defer restoreTemplate()
+	restore := apparmor_sandbox.MockLevel(apparmor_sandbox.Full)
+	defer restore()
+
+	snapYaml := `
+name: app
+version: 0.1
+system-usernames:
+  snap_app: private
+apps:
+  cmd:
+  svc:
+    daemon: simple
+    run-as: snap_app
+`
+
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, "", snapYaml, 1)
+	// the command may still drop privileges
+	profile := filepath.Join(dirs.SnapAppArmorDir, "snap.app.cmd")
+	data, err := ioutil.ReadFile(profile)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), testutil.Contains, "capability setuid,")
+	// but the service starts unprivileged
+	profile = filepath.Join(dirs.SnapAppArmorDir, "snap.app.svc")
+	data, err = ioutil.ReadFile(profile)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(testutil.Contains), "capability setuid,")
+	c.Assert(string(data), Not(testutil.Contains), "capability setgid,")
+	c.Assert(string(data), Not(testutil.Contains), "capability chown,")
+	s.RemoveSnap(c, snapInfo)
+}
+
 func (s *backendSuite) TestNoSystemUsernamesPolicy(c *C) {
 	restoreTemplate := apparmor.MockTemplate("template\n###SNIPPETS###\n")
 	defer restoreTemplate()
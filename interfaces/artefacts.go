@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package interfaces
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// ReadArtefacts returns the content of the files in dir matching any of the
+// globs, by path.
+func ReadArtefacts(dir string, globs []string) (map[string][]byte, error) {
+	artefacts := make(map[string][]byte)
+	for _, glob := range globs {
+		matches, err := filepath.Glob(filepath.Join(dir, glob))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			if !osutil.IsDirectory(path) {
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				artefacts[path] = content
+			}
+		}
+	}
+	return artefacts, nil
+}
+
+// ArtefactsContent returns the content of the files of dir described by the
+// given file states, by path.
+func ArtefactsContent(dir string, content map[string]osutil.FileState) (map[string][]byte, error) {
+	artefacts := make(map[string][]byte, len(content))
+	for name, fileState := range content {
+		reader, _, _, err := fileState.State()
+		if err != nil {
+			return nil, err
+		}
+		buf, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		artefacts[filepath.Join(dir, name)] = buf
+	}
+	return artefacts, nil
+}
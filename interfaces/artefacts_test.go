@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package interfaces_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/osutil"
+)
+
+type artefactsSuite struct{}
+
+var _ = Suite(&artefactsSuite{})
+
+func (s *artefactsSuite) TestReadArtefacts(c *C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "snap.foo.app"), []byte("app"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "snap.foo.hook.configure"), []byte("hook"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "snap.bar.app"), []byte("other"), 0644), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(dir, "snap.foo.dir"), 0755), IsNil)
+
+	artefacts, err := interfaces.ReadArtefacts(dir, []string{"snap.foo.*"})
+	c.Assert(err, IsNil)
+	c.Check(artefacts, DeepEquals, map[string][]byte{
+		filepath.Join(dir, "snap.foo.app"):            []byte("app"),
+		filepath.Join(dir, "snap.foo.hook.configure"): []byte("hook"),
+	})
+}
+
+func (s *artefactsSuite) TestArtefactsContent(c *C) {
+	artefacts, err := interfaces.ArtefactsContent("/some/dir", map[string]osutil.FileState{
+		"snap.foo.app": &osutil.MemoryFileState{Content: []byte("app"), Mode: 0644},
+	})
+	c.Assert(err, IsNil)
+	c.Check(artefacts, DeepEquals, map[string][]byte{
+		"/some/dir/snap.foo.app": []byte("app"),
+	})
+}
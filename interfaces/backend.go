@@ -111,3 +111,11 @@ type SecurityBackendSetupMany interface {
 	// on errors of individual snaps.
 	SetupMany(snaps []*snap.Info, confinement func(snapName string) ConfinementOptions, repo *Repository, tm timings.Measurer) []error
 }
+
+// SecurityBackendPreview interface may be implemented by backends that can
+// compute the security artefacts of a snap without writing them.
+type SecurityBackendPreview interface {
+	// PreviewSetup returns the security artefacts of a given snap which
+	// are currently in place and the ones Setup would write, by path.
+	PreviewSetup(snapInfo *snap.Info, opts ConfinementOptions, repo *Repository) (current, proposed map[string][]byte, err error)
+}
@@ -19,7 +19,19 @@
 
 package builtin
 
-const cameraSummary = `allows access to all cameras`
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/hotplug"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/snap"
+)
+
+const cameraSummary = `allows access to all cameras or to a specific one`
 
 const cameraBaseDeclarationSlots = `
   camera:
@@ -29,13 +41,9 @@ const cameraBaseDeclarationSlots = `
     deny-auto-connection: true
 `
 
-const cameraConnectedPlugAppArmor = `
-# Until we have proper device assignment, allow access to all cameras
-/dev/video[0-9]* rw,
-
-# VideoCore cameras (shared device with VideoCore/EGL)
-/dev/vchiq rw,
-
+// cameraDetectionAppArmor allows detection of cameras, it is used both when
+// accessing all cameras and a specific one.
+const cameraDetectionAppArmor = `
 # Allow detection of cameras. Leaks plugged in USB device info
 /sys/bus/usb/devices/ r,
 /sys/devices/pci**/usb*/**/busnum r,
@@ -51,19 +59,136 @@ const cameraConnectedPlugAppArmor = `
 /sys/devices/pci**/usb*/**/video4linux/** r,
 `
 
+const cameraConnectedPlugAppArmor = `
+# Until we have proper device assignment, allow access to all cameras
+/dev/video[0-9]* rw,
+
+# VideoCore cameras (shared device with VideoCore/EGL)
+/dev/vchiq rw,
+`
+
+// cameraPortalAppArmor allows using the camera portal of
+// xdg-desktop-portal, on desktop systems like Core Desktop cameras are
+// mediated by pipewire with libcamera and the portal hands out a pipewire
+// remote limited to the cameras.
+const cameraPortalAppArmor = `
+# Allow requesting access to the cameras through the camera portal, which
+# returns a pipewire remote to capture from them
+dbus (send)
+    bus=session
+    interface=org.freedesktop.portal.Camera
+    path=/org/freedesktop/portal/desktop
+    member={AccessCamera,OpenPipeWireRemote}
+    peer=(name=org.freedesktop.portal.Desktop, label=unconfined),
+
+dbus (send)
+    bus=session
+    interface=org.freedesktop.DBus.Properties
+    path=/org/freedesktop/portal/desktop
+    member=Get{,All}
+    peer=(name=org.freedesktop.portal.Desktop, label=unconfined),
+
+dbus (receive)
+    bus=session
+    interface=org.freedesktop.portal.Request
+    member=Response
+    peer=(label=unconfined),
+`
+
 var cameraConnectedPlugUDev = []string{
 	`KERNEL=="video[0-9]*"`,
 	`KERNEL=="vchiq"`,
 }
 
+// cameraDeviceNodePattern matches the device nodes of video4linux devices
+// that hotplug slots for a specific camera can refer to.
+var cameraDeviceNodePattern = regexp.MustCompile("^/dev/video[0-9]+$")
+
+// cameraInterface is the type of the camera interface. Its implicit slot
+// gives access to all cameras while the slots created through hotplug for
+// each camera that is plugged in give access to just that camera.
+type cameraInterface struct {
+	commonInterface
+}
+
+// BeforePrepareSlot checks the path attribute of slots for a specific
+// camera.
+func (iface *cameraInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
+	v, ok := slot.Attrs["path"]
+	if !ok {
+		return nil
+	}
+	path, ok := v.(string)
+	if !ok || !cameraDeviceNodePattern.MatchString(path) {
+		return fmt.Errorf("camera path attribute must be a valid video device node")
+	}
+	return nil
+}
+
+// cameraPath returns the device node of the camera the slot is for, or an
+// empty string if the slot is for all cameras.
+func cameraPath(slot *interfaces.ConnectedSlot) string {
+	var path string
+	if err := slot.Attr("path", &path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func (iface *cameraInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	spec.AddSnippet(cameraDetectionAppArmor)
+	if path := cameraPath(slot); path != "" {
+		spec.AddSnippet(fmt.Sprintf("# Access to a specific camera\n%s rw,\n", path))
+	} else {
+		spec.AddSnippet(cameraConnectedPlugAppArmor)
+	}
+	spec.AddSnippet(cameraPortalAppArmor)
+	return nil
+}
+
+func (iface *cameraInterface) UDevConnectedPlug(spec *udev.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if path := cameraPath(slot); path != "" {
+		spec.TagDevice(fmt.Sprintf(`SUBSYSTEM=="video4linux", KERNEL=="%s"`, strings.TrimPrefix(path, "/dev/")))
+		return nil
+	}
+	return iface.commonInterface.UDevConnectedPlug(spec, plug, slot)
+}
+
+// HotplugDeviceDetected proposes a slot for each video4linux device that
+// can capture video, metadata devices of the same camera are skipped.
+func (iface *cameraInterface) HotplugDeviceDetected(di *hotplug.HotplugDeviceInfo) (*hotplug.ProposedSlot, error) {
+	if di.Subsystem() != "video4linux" || !cameraDeviceNodePattern.MatchString(di.DeviceName()) {
+		return nil, nil
+	}
+	capabilities, _ := di.Attribute("ID_V4L_CAPABILITIES")
+	if !strings.Contains(capabilities, ":capture:") {
+		return nil, nil
+	}
+
+	slot := hotplug.ProposedSlot{
+		Attrs: map[string]interface{}{
+			"path": di.DeviceName(),
+		},
+	}
+	if product, ok := di.Attribute("ID_V4L_PRODUCT"); ok {
+		slot.Label = product
+	}
+	if vendor, ok := di.Attribute("ID_VENDOR_ID"); ok {
+		slot.Attrs["usb-vendor"] = vendor
+	}
+	if product, ok := di.Attribute("ID_MODEL_ID"); ok {
+		slot.Attrs["usb-product"] = product
+	}
+	return &slot, nil
+}
+
 func init() {
-	registerIface(&commonInterface{
-		name:                  "camera",
-		summary:               cameraSummary,
-		implicitOnCore:        true,
-		implicitOnClassic:     true,
-		baseDeclarationSlots:  cameraBaseDeclarationSlots,
-		connectedPlugAppArmor: cameraConnectedPlugAppArmor,
-		connectedPlugUDev:     cameraConnectedPlugUDev,
-	})
+	registerIface(&cameraInterface{commonInterface{
+		name:                 "camera",
+		summary:              cameraSummary,
+		implicitOnCore:       true,
+		implicitOnClassic:    true,
+		baseDeclarationSlots: cameraBaseDeclarationSlots,
+		connectedPlugUDev:    cameraConnectedPlugUDev,
+	}})
 }
@@ -25,17 +25,20 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/hotplug"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/testutil"
 )
 
 type CameraInterfaceSuite struct {
-	iface    interfaces.Interface
-	slot     *interfaces.ConnectedSlot
-	slotInfo *snap.SlotInfo
-	plug     *interfaces.ConnectedPlug
-	plugInfo *snap.PlugInfo
+	iface          interfaces.Interface
+	slot           *interfaces.ConnectedSlot
+	slotInfo       *snap.SlotInfo
+	deviceSlot     *interfaces.ConnectedSlot
+	deviceSlotInfo *snap.SlotInfo
+	plug           *interfaces.ConnectedPlug
+	plugInfo       *snap.PlugInfo
 }
 
 var _ = Suite(&CameraInterfaceSuite{
@@ -54,11 +57,15 @@ version: 0
 type: os
 slots:
   camera:
+  webcam:
+    interface: camera
+    path: /dev/video2
 `
 
 func (s *CameraInterfaceSuite) SetUpTest(c *C) {
 	s.plug, s.plugInfo = MockConnectedPlug(c, cameraConsumerYaml, nil, "camera")
 	s.slot, s.slotInfo = MockConnectedSlot(c, cameraCoreYaml, nil, "camera")
+	s.deviceSlot, s.deviceSlotInfo = MockConnectedSlot(c, cameraCoreYaml, nil, "webcam")
 }
 
 func (s *CameraInterfaceSuite) TestName(c *C) {
@@ -67,6 +74,20 @@ func (s *CameraInterfaceSuite) TestName(c *C) {
 
 func (s *CameraInterfaceSuite) TestSanitizeSlot(c *C) {
 	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.slotInfo), IsNil)
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.deviceSlotInfo), IsNil)
+}
+
+func (s *CameraInterfaceSuite) TestSanitizeSlotInvalidPath(c *C) {
+	for _, path := range []interface{}{"/dev/vchiq", "/dev/video", "/dev/video0/../tty1", 1} {
+		slotInfo := &snap.SlotInfo{
+			Snap:      s.slotInfo.Snap,
+			Name:      "webcam",
+			Interface: "camera",
+			Attrs:     map[string]interface{}{"path": path},
+		}
+		c.Check(interfaces.BeforePrepareSlot(s.iface, slotInfo), ErrorMatches,
+			"camera path attribute must be a valid video device node")
+	}
 }
 
 func (s *CameraInterfaceSuite) TestSanitizePlug(c *C) {
@@ -78,6 +99,20 @@ func (s *CameraInterfaceSuite) TestAppArmorSpec(c *C) {
 	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
 	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "/dev/video[0-9]* rw")
+	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "/sys/class/video4linux/ r,")
+	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "interface=org.freedesktop.portal.Camera")
+}
+
+func (s *CameraInterfaceSuite) TestAppArmorSpecSpecificCamera(c *C) {
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.deviceSlot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
+	snippet := spec.SnippetForTag("snap.consumer.app")
+	c.Check(snippet, testutil.Contains, "/dev/video2 rw,")
+	c.Check(snippet, Not(testutil.Contains), "/dev/video[0-9]* rw")
+	c.Check(snippet, Not(testutil.Contains), "/dev/vchiq rw")
+	c.Check(snippet, testutil.Contains, "/sys/class/video4linux/ r,")
+	c.Check(snippet, testutil.Contains, "interface=org.freedesktop.portal.Camera")
 }
 
 func (s *CameraInterfaceSuite) TestUDevSpec(c *C) {
@@ -91,11 +126,62 @@ KERNEL=="vchiq", TAG+="snap_consumer_app"`)
 	c.Assert(spec.Snippets(), testutil.Contains, `TAG=="snap_consumer_app", RUN+="/usr/lib/snapd/snap-device-helper $env{ACTION} snap_consumer_app $devpath $major:$minor"`)
 }
 
+func (s *CameraInterfaceSuite) TestUDevSpecSpecificCamera(c *C) {
+	spec := &udev.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.deviceSlot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 2)
+	c.Assert(spec.Snippets(), testutil.Contains, `# camera
+SUBSYSTEM=="video4linux", KERNEL=="video2", TAG+="snap_consumer_app"`)
+	c.Assert(spec.Snippets(), testutil.Contains, `TAG=="snap_consumer_app", RUN+="/usr/lib/snapd/snap-device-helper $env{ACTION} snap_consumer_app $devpath $major:$minor"`)
+}
+
+func (s *CameraInterfaceSuite) TestHotplugDeviceDetected(c *C) {
+	hotplugIface := s.iface.(hotplug.Definer)
+	di, err := hotplug.NewHotplugDeviceInfo(map[string]string{
+		"DEVPATH":             "/devices/pci0000:00/0000:00:14.0/usb1/1-6/1-6:1.0/video4linux/video0",
+		"DEVNAME":             "/dev/video0",
+		"ACTION":              "add",
+		"SUBSYSTEM":           "video4linux",
+		"ID_V4L_CAPABILITIES": ":capture:",
+		"ID_V4L_PRODUCT":      "Integrated Camera",
+		"ID_VENDOR_ID":        "04f2",
+		"ID_MODEL_ID":         "b604",
+	})
+	c.Assert(err, IsNil)
+	proposedSlot, err := hotplugIface.HotplugDeviceDetected(di)
+	c.Assert(err, IsNil)
+	c.Assert(proposedSlot, DeepEquals, &hotplug.ProposedSlot{
+		Label: "Integrated Camera",
+		Attrs: map[string]interface{}{
+			"path":        "/dev/video0",
+			"usb-vendor":  "04f2",
+			"usb-product": "b604",
+		},
+	})
+}
+
+func (s *CameraInterfaceSuite) TestHotplugDeviceDetectedNotCapture(c *C) {
+	hotplugIface := s.iface.(hotplug.Definer)
+	for _, env := range []map[string]string{
+		// the metadata node of a camera
+		{"DEVPATH": "/sys/foo/video1", "DEVNAME": "/dev/video1", "ACTION": "add", "SUBSYSTEM": "video4linux", "ID_V4L_CAPABILITIES": ":"},
+		// not a video device
+		{"DEVPATH": "/sys/foo/ttyUSB0", "DEVNAME": "/dev/ttyUSB0", "ACTION": "add", "SUBSYSTEM": "tty", "ID_V4L_CAPABILITIES": ":capture:"},
+		{"DEVPATH": "/sys/foo/v4l-subdev0", "DEVNAME": "/dev/v4l-subdev0", "ACTION": "add", "SUBSYSTEM": "video4linux", "ID_V4L_CAPABILITIES": ":capture:"},
+	} {
+		di, err := hotplug.NewHotplugDeviceInfo(env)
+		c.Assert(err, IsNil)
+		proposedSlot, err := hotplugIface.HotplugDeviceDetected(di)
+		c.Assert(err, IsNil)
+		c.Check(proposedSlot, IsNil)
+	}
+}
+
 func (s *CameraInterfaceSuite) TestStaticInfo(c *C) {
 	si := interfaces.StaticInfoOf(s.iface)
 	c.Assert(si.ImplicitOnCore, Equals, true)
 	c.Assert(si.ImplicitOnClassic, Equals, true)
-	c.Assert(si.Summary, Equals, `allows access to all cameras`)
+	c.Assert(si.Summary, Equals, `allows access to all cameras or to a specific one`)
 	c.Assert(si.BaseDeclarationSlots, testutil.Contains, "camera")
 }
 
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// dbusScopeRule grants access to a set of methods and signals of a single
+// D-Bus interface implemented by a system service.
+type dbusScopeRule struct {
+	// Path is the object path the rule applies to. With Subtree set the
+	// rule applies to all the objects below Path as well.
+	Path      string
+	Subtree   bool
+	Interface string
+	Methods   []string
+	Signals   []string
+}
+
+// dbusScopedService describes the access scopes that plugs of an interface
+// may request for a service on the system bus, instead of being granted
+// access to the whole API of the service.
+type dbusScopedService struct {
+	// Name is the well known bus name of the service.
+	Name   string
+	Scopes map[string][]dbusScopeRule
+}
+
+// scopeNames returns the sorted names of all the scopes of the service.
+func (svc *dbusScopedService) scopeNames() []string {
+	names := make([]string, 0, len(svc.Scopes))
+	for name := range svc.Scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// plugScopes returns the list of scopes requested by the plug with the
+// "scopes" attribute. A nil list is returned when the attribute is not set.
+func (svc *dbusScopedService) plugScopes(plug interfaces.Attrer) ([]string, error) {
+	if _, ok := plug.Lookup("scopes"); !ok {
+		return nil, nil
+	}
+	var value []interface{}
+	if err := plug.Attr("scopes", &value); err != nil {
+		return nil, fmt.Errorf(`"scopes" must be a list of strings`)
+	}
+	if len(value) == 0 {
+		return nil, fmt.Errorf(`"scopes" cannot be empty`)
+	}
+	scopes := make([]string, 0, len(value))
+	for _, v := range value {
+		scope, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf(`"scopes" must be a list of strings`)
+		}
+		if _, ok := svc.Scopes[scope]; !ok {
+			return nil, fmt.Errorf(`unsupported scope %q, expected one of: %s`, scope, strings.Join(svc.scopeNames(), ", "))
+		}
+		if strutil.ListContains(scopes, scope) {
+			return nil, fmt.Errorf(`scope %q is listed more than once`, scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// appArmorSnippet returns the AppArmor D-Bus rules granting access to the
+// given scopes of the service. The peer expression is used verbatim for the
+// peer=() part of the rules.
+func (svc *dbusScopedService) appArmorSnippet(scopes []string, peer string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\n# Allow access to the %s service, limited to scopes: %s\n", svc.Name, strings.Join(scopes, ", "))
+	buf.WriteString("#include <abstractions/dbus-strict>\n")
+	for _, scope := range scopes {
+		for _, rule := range svc.Scopes[scope] {
+			path := rule.Path
+			if rule.Subtree {
+				path += "{,/**}"
+			}
+			if len(rule.Methods) > 0 {
+				fmt.Fprintf(&buf, "\ndbus (send)\n    bus=system\n    path=%s\n    interface=%s\n    member=%s\n    peer=(%s),\n",
+					path, rule.Interface, appArmorMembers(rule.Methods), peer)
+			}
+			if len(rule.Signals) > 0 {
+				fmt.Fprintf(&buf, "\ndbus (receive)\n    bus=system\n    path=%s\n    interface=%s\n    member=%s\n    peer=(%s),\n",
+					path, rule.Interface, appArmorMembers(rule.Signals), peer)
+			}
+		}
+	}
+	return buf.String()
+}
+
+func appArmorMembers(members []string) string {
+	if len(members) == 1 {
+		return members[0]
+	}
+	return "{" + strings.Join(members, ",") + "}"
+}
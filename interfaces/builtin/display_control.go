@@ -24,9 +24,12 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
+	"regexp"
 
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/snap"
 )
 
 const displayControlSummary = `allows configuring display parameters`
@@ -85,6 +88,30 @@ dbus (send)
 /sys/class/backlight/ r,
 `
 
+// logind lets the owner of an active session set the brightness of any
+// backlight device, so it is only allowed when the slot does not restrict
+// the devices.
+const displayControlConnectedPlugAppArmorLogind = `
+# logind, which checks that the caller owns an active session
+dbus (send)
+    bus=system
+    path=/org/freedesktop/login1/session/**
+    interface=org.freedesktop.login1.Session
+    member=SetBrightness
+    peer=(label=unconfined),
+`
+
+const displayControlConnectedPlugAppArmorBacklight = `
+# backlight device declared by the slot: %[1]s
+/sys/devices/**/backlight/%[1]s/{,**} r,
+/sys/devices/**/backlight/%[1]s/bl_power w,
+/sys/devices/**/backlight/%[1]s/brightness w,
+`
+
+// Pattern to match allowed backlight device names, as found in
+// /sys/class/backlight.
+var displayControlBacklightNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 type displayControlInterface struct {
 	commonInterface
 }
@@ -107,10 +134,59 @@ func (iface *displayControlInterface) dereferencedBacklightPaths() []string {
 	return paths
 }
 
+// backlightDevices returns the backlight devices declared with the
+// "backlight-devices" attribute of the slot, if any.
+func (iface *displayControlInterface) backlightDevices(attrs interfaces.Attrer) ([]string, error) {
+	if _, ok := attrs.Lookup("backlight-devices"); !ok {
+		return nil, nil
+	}
+	var devices []interface{}
+	if err := attrs.Attr("backlight-devices", &devices); err != nil {
+		return nil, fmt.Errorf(`%s "backlight-devices" attribute must be a list of strings`, iface.Name())
+	}
+	names := make([]string, 0, len(devices))
+	for _, d := range devices {
+		name, ok := d.(string)
+		if !ok {
+			return nil, fmt.Errorf(`%s "backlight-devices" attribute must be a list of strings`, iface.Name())
+		}
+		if !displayControlBacklightNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s backlight device name %q is invalid", iface.Name(), name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (iface *displayControlInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
+	devices, err := iface.backlightDevices(slot)
+	if err != nil {
+		return err
+	}
+	if _, ok := slot.Lookup("backlight-devices"); ok && len(devices) == 0 {
+		return fmt.Errorf(`%s "backlight-devices" attribute cannot be empty`, iface.Name())
+	}
+	return nil
+}
+
 func (iface *displayControlInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	// add the static rules
 	spec.AddSnippet(displayControlConnectedPlugAppArmor)
 
+	devices, err := iface.backlightDevices(slot)
+	if err != nil {
+		return err
+	}
+	if len(devices) > 0 {
+		// the slot restricts access to specific backlight devices
+		for _, name := range devices {
+			spec.AddSnippet(fmt.Sprintf(displayControlConnectedPlugAppArmorBacklight, name))
+		}
+		return nil
+	}
+
+	spec.AddSnippet(displayControlConnectedPlugAppArmorLogind)
+
 	// add the detected rules
 	for _, p := range iface.dereferencedBacklightPaths() {
 		var buf bytes.Buffer
@@ -124,6 +200,17 @@ func (iface *displayControlInterface) AppArmorConnectedPlug(spec *apparmor.Speci
 	return nil
 }
 
+func (iface *displayControlInterface) UDevConnectedPlug(spec *udev.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	devices, err := iface.backlightDevices(slot)
+	if err != nil {
+		return err
+	}
+	for _, name := range devices {
+		spec.TagDevice(fmt.Sprintf(`SUBSYSTEM=="backlight", KERNEL=="%s"`, name))
+	}
+	return nil
+}
+
 func init() {
 	registerIface(&displayControlInterface{commonInterface{
 		name:                  "display-control",
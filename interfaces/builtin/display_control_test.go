@@ -22,6 +22,7 @@ package builtin_test
 import (
 	. "gopkg.in/check.v1"
 
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -29,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/testutil"
 )
@@ -42,6 +44,9 @@ type displayControlInterfaceSuite struct {
 	plugInfo *snap.PlugInfo
 	plug     *interfaces.ConnectedPlug
 
+	gadgetSlotInfo *snap.SlotInfo
+	gadgetSlot     *interfaces.ConnectedSlot
+
 	tmpdir string
 }
 
@@ -63,9 +68,19 @@ slots:
   display-control:
 `
 
+const displayControlGadgetYaml = `name: gadget
+version: 0
+type: gadget
+slots:
+  kiosk-display:
+    interface: display-control
+    backlight-devices: [intel_backlight, acpi_video0]
+`
+
 func (s *displayControlInterfaceSuite) SetUpTest(c *C) {
 	s.plug, s.plugInfo = MockConnectedPlug(c, displayControlConsumerYaml, nil, "display-control")
 	s.slot, s.slotInfo = MockConnectedSlot(c, displayControlCoreYaml, nil, "display-control")
+	s.gadgetSlot, s.gadgetSlotInfo = MockConnectedSlot(c, displayControlGadgetYaml, nil, "kiosk-display")
 
 	s.tmpdir = c.MkDir()
 }
@@ -78,6 +93,36 @@ func (s *displayControlInterfaceSuite) TestSanitizeSlot(c *C) {
 	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.slotInfo), IsNil)
 }
 
+func (s *displayControlInterfaceSuite) TestSanitizeGadgetSlot(c *C) {
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.gadgetSlotInfo), IsNil)
+}
+
+func (s *displayControlInterfaceSuite) TestSanitizeGadgetSlotBacklightDevicesInvalid(c *C) {
+	for _, tc := range []struct {
+		attr string
+		err  string
+	}{
+		{`backlight-devices: intel_backlight`, `display-control "backlight-devices" attribute must be a list of strings`},
+		{`backlight-devices: [1]`, `display-control "backlight-devices" attribute must be a list of strings`},
+		{`backlight-devices: []`, `display-control "backlight-devices" attribute cannot be empty`},
+		{`backlight-devices: ["../intel_backlight"]`, `display-control backlight device name "../intel_backlight" is invalid`},
+		{`backlight-devices: ["intel*"]`, `display-control backlight device name "intel\*" is invalid`},
+		{`backlight-devices: [".."]`, `display-control backlight device name "\.\." is invalid`},
+		{`backlight-devices: ["intel.backlight"]`, `display-control backlight device name "intel\.backlight" is invalid`},
+	} {
+		const gadgetYaml = `name: gadget
+version: 0
+type: gadget
+slots:
+  kiosk-display:
+    interface: display-control
+    %s
+`
+		_, slotInfo := MockConnectedSlot(c, fmt.Sprintf(gadgetYaml, tc.attr), nil, "kiosk-display")
+		c.Check(interfaces.BeforePrepareSlot(s.iface, slotInfo), ErrorMatches, tc.err, Commentf(tc.attr))
+	}
+}
+
 func (s *displayControlInterfaceSuite) TestSanitizePlug(c *C) {
 	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
 }
@@ -95,12 +140,50 @@ func (s *displayControlInterfaceSuite) TestAppArmorSpec(c *C) {
 	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
 	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "/sys/class/backlight/ r,\n")
+	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "member=SetBrightness\n")
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "autodetected backlight: bar_backlight\n")
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "(dereferenced)/sys/class/backlight/bar_backlight/{,**} r,\n")
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "autodetected backlight: foo_backlight\n")
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "(dereferenced)/sys/class/backlight/foo_backlight/{,**} r,\n")
 }
 
+func (s *displayControlInterfaceSuite) TestAppArmorSpecBacklightDevices(c *C) {
+	builtin.MockReadDir(&s.BaseTest, func(path string) ([]os.FileInfo, error) {
+		c.Fatalf("unexpected backlight autodetection")
+		return nil, nil
+	})
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.gadgetSlot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
+	snippet := spec.SnippetForTag("snap.consumer.app")
+	c.Check(snippet, testutil.Contains, "/sys/class/backlight/ r,\n")
+	// logind would set the brightness of any device
+	c.Check(snippet, Not(testutil.Contains), "member=SetBrightness\n")
+	c.Check(snippet, testutil.Contains, "# backlight device declared by the slot: intel_backlight\n")
+	c.Check(snippet, testutil.Contains, "/sys/devices/**/backlight/intel_backlight/{,**} r,\n")
+	c.Check(snippet, testutil.Contains, "/sys/devices/**/backlight/intel_backlight/bl_power w,\n")
+	c.Check(snippet, testutil.Contains, "/sys/devices/**/backlight/intel_backlight/brightness w,\n")
+	c.Check(snippet, testutil.Contains, "/sys/devices/**/backlight/acpi_video0/brightness w,\n")
+	c.Check(snippet, Not(testutil.Contains), "autodetected backlight")
+}
+
+func (s *displayControlInterfaceSuite) TestUDevSpec(c *C) {
+	spec := &udev.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 0)
+}
+
+func (s *displayControlInterfaceSuite) TestUDevSpecBacklightDevices(c *C) {
+	spec := &udev.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.gadgetSlot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 3)
+	c.Check(spec.Snippets(), testutil.Contains, `# display-control
+SUBSYSTEM=="backlight", KERNEL=="intel_backlight", TAG+="snap_consumer_app"`)
+	c.Check(spec.Snippets(), testutil.Contains, `# display-control
+SUBSYSTEM=="backlight", KERNEL=="acpi_video0", TAG+="snap_consumer_app"`)
+	c.Check(spec.Snippets(), testutil.Contains, `TAG=="snap_consumer_app", RUN+="/usr/lib/snapd/snap-device-helper $env{ACTION} snap_consumer_app $devpath $major:$minor"`)
+}
+
 func (s *displayControlInterfaceSuite) TestStaticInfo(c *C) {
 	si := interfaces.StaticInfoOf(s.iface)
 	c.Assert(si.ImplicitOnCore, Equals, true)
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/snap"
+)
+
+// Display session interfaces (wayland, x11) accept a "role" plug attribute.
+// Plugs with the default client role may only talk to the display server,
+// while the single plug connected with the compositor role to a given slot
+// owns the session and may create and serve the display socket. The base
+// declarations deny auto-connecting compositor plugs, those need to be
+// connected manually or granted by the snap-declaration.
+const (
+	displayRoleClient     = "client"
+	displayRoleCompositor = "compositor"
+)
+
+func sanitizeDisplayRole(ifaceName string, plug *snap.PlugInfo) error {
+	v, ok := plug.Attrs["role"]
+	if !ok {
+		return nil
+	}
+	role, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("%s plug requires string with 'role'", ifaceName)
+	}
+	switch role {
+	case displayRoleClient, displayRoleCompositor:
+		return nil
+	default:
+		return fmt.Errorf("%s plug has invalid role %q, expected %q or %q", ifaceName, role, displayRoleClient, displayRoleCompositor)
+	}
+}
+
+func isDisplayCompositor(plug interfaces.Attrer) bool {
+	var role string
+	if err := plug.Attr("role", &role); err != nil {
+		return false
+	}
+	return role == displayRoleCompositor
+}
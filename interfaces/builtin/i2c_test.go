@@ -25,6 +25,7 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
@@ -278,3 +279,66 @@ func (s *I2cInterfaceSuite) TestAutoConnect(c *C) {
 func (s *I2cInterfaceSuite) TestInterfaces(c *C) {
 	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
 }
+
+func (s *I2cInterfaceSuite) TestMetadata(c *C) {
+	// fuzz the slot attributes, the slot either needs a valid
+	// path or a valid sysfs-name, but not both
+	var slotAttrCases []ifacetest.AttrCase
+	for _, attrs := range ifacetest.AttrCombinations(map[string][]interface{}{
+		"path":       {nil, "", "/dev/i2c-1", "/dev/i2c-1/./", "/dev/i2c-a", "/dev/foo-0"},
+		"sysfs-name": {nil, "", "1-0050", "/slash/not/allowed"},
+	}) {
+		path, hasPath := attrs["path"].(string)
+		sysfsName, hasSysfsName := attrs["sysfs-name"].(string)
+		tc := ifacetest.AttrCase{Attrs: attrs}
+		switch {
+		case hasSysfsName && (sysfsName == "" || sysfsName == "/slash/not/allowed"):
+			tc.Error = "i2c sysfs-name attribute must be a valid sysfs-name"
+		case hasSysfsName && hasPath:
+			tc.Error = "i2c slot can only use path or sysfs-name"
+		case hasSysfsName:
+		case path == "":
+			tc.Error = "i2c slot must have a path or sysfs-name attribute"
+		case path != "/dev/i2c-1" && path != "/dev/i2c-1/./":
+			tc.Error = "i2c path attribute must be a valid device node"
+		}
+		slotAttrCases = append(slotAttrCases, tc)
+	}
+	c.Assert(slotAttrCases, HasLen, 24)
+
+	ifacetest.CheckInterface(c, &ifacetest.InterfaceMetadata{
+		Interface: s.iface,
+		PlugSnapYaml: `name: client-snap
+version: 0
+apps:
+  app:
+    command: foo
+    plugs: [i2c]
+`,
+		SlotSnapYaml: `name: some-device
+version: 0
+type: gadget
+slots:
+  i2c:
+    path: /dev/i2c-1
+`,
+		AutoConnect: true,
+		Snippets: []ifacetest.SnippetExpectation{
+			{
+				Backend:      interfaces.SecurityAppArmor,
+				SecurityTags: []string{"snap.client-snap.app"},
+				Contains:     []string{"/dev/i2c-1 rw,"},
+			}, {
+				Backend:  interfaces.SecurityUDev,
+				Contains: []string{`KERNEL=="i2c-1"`},
+			}, {
+				Backend: interfaces.SecuritySecComp,
+			}, {
+				Backend:   interfaces.SecurityAppArmor,
+				Slot:      true,
+				Permanent: true,
+			},
+		},
+		SlotAttrCases: slotAttrCases,
+	})
+}
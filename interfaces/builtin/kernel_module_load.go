@@ -0,0 +1,197 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/kmod"
+	"github.com/snapcore/snapd/snap"
+)
+
+const kernelModuleLoadSummary = `allows constrained control over kernel module loading`
+
+// The plug side is only installable by gadget and kernel snaps, which are
+// the ones expected to carry the kernel module policy of a device.
+const kernelModuleLoadBaseDeclarationPlugs = `
+  kernel-module-load:
+    allow-installation:
+      plug-snap-type:
+        - gadget
+        - kernel
+    deny-auto-connection: true
+`
+
+const kernelModuleLoadBaseDeclarationSlots = `
+  kernel-module-load:
+    allow-installation:
+      slot-snap-type:
+        - core
+    deny-auto-connection: true
+`
+
+var (
+	kernelModuleNameRegexp    = regexp.MustCompile(`^[-a-zA-Z0-9_]+$`)
+	kernelModuleOptionsRegexp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9_.]*(=[[:graph:]]+)? *)+$`)
+)
+
+type kernelModuleLoadInterface struct {
+	commonInterface
+}
+
+type kernelModuleLoad string
+
+const (
+	// loadOnBoot loads the module at boot and on connection
+	loadOnBoot kernelModuleLoad = "on-boot"
+	// loadDynamic only sets the options for the module, which is loaded
+	// on demand
+	loadDynamic kernelModuleLoad = "dynamic"
+	// loadDenied prevents the module from being loaded
+	loadDenied kernelModuleLoad = "denied"
+)
+
+type kernelModuleInfo struct {
+	name    string
+	load    kernelModuleLoad
+	options string
+}
+
+func enumerateKernelModules(plug interfaces.Attrer, handle func(*kernelModuleInfo) error) error {
+	var modules []interface{}
+	if err := plug.Attr("modules", &modules); err != nil {
+		return fmt.Errorf(`"modules" must be a list of maps`)
+	}
+
+	for _, m := range modules {
+		module, ok := m.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf(`"modules" must be a list of maps`)
+		}
+		info, err := parseKernelModule(module)
+		if err != nil {
+			return err
+		}
+		if err := handle(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseKernelModule(module map[string]interface{}) (*kernelModuleInfo, error) {
+	for key := range module {
+		switch key {
+		case "name", "load", "options":
+		default:
+			return nil, fmt.Errorf(`kernel module entry contains unsupported attribute %q`, key)
+		}
+	}
+
+	name, ok := module["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf(`kernel module entry must have a "name" string`)
+	}
+	if !kernelModuleNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf(`invalid kernel module name %q`, name)
+	}
+
+	info := &kernelModuleInfo{name: name, load: loadOnBoot}
+	if v, ok := module["load"]; ok {
+		load, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf(`"load" for kernel module %q must be a string`, name)
+		}
+		switch kernelModuleLoad(load) {
+		case loadOnBoot, loadDynamic, loadDenied:
+			info.load = kernelModuleLoad(load)
+		default:
+			return nil, fmt.Errorf(`"load" for kernel module %q must be one of "on-boot", "dynamic" or "denied"`, name)
+		}
+	}
+	if v, ok := module["options"]; ok {
+		options, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf(`"options" for kernel module %q must be a string`, name)
+		}
+		if !kernelModuleOptionsRegexp.MatchString(options) {
+			return nil, fmt.Errorf(`invalid options for kernel module %q: %q`, name, options)
+		}
+		info.options = options
+	}
+
+	switch {
+	case info.load == loadDenied && info.options != "":
+		return nil, fmt.Errorf(`kernel module %q cannot have options when loading is denied`, name)
+	case info.load == loadDynamic && info.options == "":
+		return nil, fmt.Errorf(`kernel module %q must have options when loaded dynamically`, name)
+	}
+	return info, nil
+}
+
+func (iface *kernelModuleLoadInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	seen := make(map[string]bool)
+	numModules := 0
+	err := enumerateKernelModules(plug, func(info *kernelModuleInfo) error {
+		if seen[info.name] {
+			return fmt.Errorf(`kernel module %q is listed more than once`, info.name)
+		}
+		seen[info.name] = true
+		numModules++
+		return nil
+	})
+	if err == nil && numModules == 0 {
+		err = fmt.Errorf(`"modules" must contain at least one entry`)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot add kernel-module-load plug: %v", err)
+	}
+	return nil
+}
+
+func (iface *kernelModuleLoadInterface) KModConnectedPlug(spec *kmod.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	return enumerateKernelModules(plug, func(info *kernelModuleInfo) error {
+		if info.load == loadDenied {
+			return spec.DisallowModule(info.name)
+		}
+		if err := spec.SetModuleOptions(info.name, info.options); err != nil {
+			return err
+		}
+		if info.load == loadOnBoot {
+			return spec.AddModule(info.name)
+		}
+		return nil
+	})
+}
+
+func init() {
+	registerIface(&kernelModuleLoadInterface{
+		commonInterface: commonInterface{
+			name:                 "kernel-module-load",
+			summary:              kernelModuleLoadSummary,
+			implicitOnCore:       true,
+			implicitOnClassic:    true,
+			baseDeclarationPlugs: kernelModuleLoadBaseDeclarationPlugs,
+			baseDeclarationSlots: kernelModuleLoadBaseDeclarationSlots,
+		},
+	})
+}
@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin_test
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/kmod"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type KernelModuleLoadInterfaceSuite struct {
+	iface    interfaces.Interface
+	slotInfo *snap.SlotInfo
+	slot     *interfaces.ConnectedSlot
+	plugInfo *snap.PlugInfo
+	plug     *interfaces.ConnectedPlug
+}
+
+var _ = Suite(&KernelModuleLoadInterfaceSuite{
+	iface: builtin.MustInterface("kernel-module-load"),
+})
+
+const kernelModuleLoadConsumerYaml = `name: consumer
+version: 0
+type: gadget
+plugs:
+ kmod:
+  interface: kernel-module-load
+  modules:
+  - name: forbidden
+    load: denied
+  - name: mymodule1
+    load: on-boot
+  - name: mymodule2
+    options: p1=3 p2=true p3
+  - name: mymodule3
+    load: dynamic
+    options: debug=1
+apps:
+ app:
+  plugs: [kmod]
+`
+
+const kernelModuleLoadCoreYaml = `name: core
+version: 0
+type: os
+slots:
+  kernel-module-load:
+`
+
+func (s *KernelModuleLoadInterfaceSuite) SetUpTest(c *C) {
+	s.plug, s.plugInfo = MockConnectedPlug(c, kernelModuleLoadConsumerYaml, nil, "kmod")
+	s.slot, s.slotInfo = MockConnectedSlot(c, kernelModuleLoadCoreYaml, nil, "kernel-module-load")
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestName(c *C) {
+	c.Assert(s.iface.Name(), Equals, "kernel-module-load")
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestSanitizeSlot(c *C) {
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.slotInfo), IsNil)
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestSanitizePlug(c *C) {
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestSanitizePlugUnhappy(c *C) {
+	const yamlTemplate = `name: consumer
+version: 0
+type: gadget
+plugs:
+ kmod:
+  interface: kernel-module-load
+  $t
+`
+	for _, tc := range []struct {
+		modules string
+		err     string
+	}{
+		{"", `"modules" must be a list of maps`},
+		{"modules: foo", `"modules" must be a list of maps`},
+		{"modules: [foo]", `"modules" must be a list of maps`},
+		{"modules: []", `"modules" must contain at least one entry`},
+		{"modules:\n  - load: on-boot", `kernel module entry must have a "name" string`},
+		{"modules:\n  - name: 'a/b'", `invalid kernel module name "a/b"`},
+		{"modules:\n  - name: foo\n    what: ever", `kernel module entry contains unsupported attribute "what"`},
+		{"modules:\n  - name: foo\n    load: sometimes", `"load" for kernel module "foo" must be one of "on-boot", "dynamic" or "denied"`},
+		{"modules:\n  - name: foo\n    load: [on-boot]", `"load" for kernel module "foo" must be a string`},
+		{"modules:\n  - name: foo\n    options: 1=2", `invalid options for kernel module "foo": "1=2"`},
+		{"modules:\n  - name: foo\n    load: denied\n    options: a=1", `kernel module "foo" cannot have options when loading is denied`},
+		{"modules:\n  - name: foo\n    load: dynamic", `kernel module "foo" must have options when loaded dynamically`},
+		{"modules:\n  - name: foo\n  - name: foo", `kernel module "foo" is listed more than once`},
+	} {
+		yaml := strings.Replace(yamlTemplate, "$t", tc.modules, 1)
+		info := snaptest.MockInfo(c, yaml, nil)
+		plug := info.Plugs["kmod"]
+		err := interfaces.BeforePreparePlug(s.iface, plug)
+		c.Check(err, ErrorMatches, "cannot add kernel-module-load plug: "+tc.err, Commentf("%s", tc.modules))
+	}
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestKModSpec(c *C) {
+	spec := &kmod.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Check(spec.Modules(), DeepEquals, map[string]bool{
+		"mymodule1": true,
+		"mymodule2": true,
+	})
+	c.Check(spec.ModuleOptions(), DeepEquals, map[string]string{
+		"mymodule2": "p1=3 p2=true p3",
+		"mymodule3": "debug=1",
+	})
+	c.Check(spec.DisallowedModules(), DeepEquals, map[string]bool{
+		"forbidden": true,
+	})
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestStaticInfo(c *C) {
+	si := interfaces.StaticInfoOf(s.iface)
+	c.Assert(si.ImplicitOnCore, Equals, true)
+	c.Assert(si.ImplicitOnClassic, Equals, true)
+	c.Assert(si.Summary, Equals, `allows constrained control over kernel module loading`)
+	c.Assert(si.BaseDeclarationPlugs, testutil.Contains, "kernel-module-load")
+	c.Assert(si.BaseDeclarationSlots, testutil.Contains, "kernel-module-load")
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestAutoConnect(c *C) {
+	c.Assert(s.iface.AutoConnect(s.plugInfo, s.slotInfo), Equals, true)
+}
+
+func (s *KernelModuleLoadInterfaceSuite) TestInterfaces(c *C) {
+	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
+}
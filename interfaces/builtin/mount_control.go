@@ -0,0 +1,370 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/systemd"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+const mountControlSummary = `allows mounting and unmounting FUSE and network filesystems`
+
+const mountControlBaseDeclarationPlugs = `
+  mount-control:
+    allow-installation: false
+    deny-auto-connection: true
+`
+
+const mountControlBaseDeclarationSlots = `
+  mount-control:
+    allow-installation:
+      slot-snap-type:
+        - core
+    deny-auto-connection: true
+`
+
+const mountControlConnectedPlugSecComp = `
+# Description: Allow mount and umount syscall access.
+mount
+umount
+umount2
+`
+
+const mountControlConnectedPlugAppArmor = `
+# Description: Allow mounting and unmounting the filesystems declared by the
+# plug. Credentials for those mounts are provisioned by the administrator
+# outside of the snap in the per-snap mount-credentials directory of snapd,
+# are only used by the mount units generated for persistent mounts and are
+# removed with the snap.
+
+# Required for mounts and unmounts
+capability sys_admin,
+`
+
+const mountControlFUSEConnectedPlugAppArmor = `
+# Required for FUSE mounts
+/dev/fuse rw,
+/{,usr/}bin/fusermount{,3} ixr,
+`
+
+// The attributes of mount entries end up as arguments of the mount command
+// run by the generated units and in AppArmor rules, so they are restricted
+// to characters that carry no special meaning for either of them: no
+// whitespace, quotes, commas, globs, backslashes nor systemd specifiers and
+// environment variable references.
+var (
+	mountControlFUSETypeRegexp    = regexp.MustCompile(`^fuse\.[a-z0-9][a-z0-9._-]*$`)
+	mountControlWhatRegexp        = regexp.MustCompile(`^[a-zA-Z0-9/][a-zA-Z0-9._+@:/~#=-]*$`)
+	mountControlCIFSWhatRegexp    = regexp.MustCompile(`^//[a-zA-Z0-9.-]+(/[a-zA-Z0-9._+@~=-]+)+$`)
+	mountControlNFSWhatRegexp     = regexp.MustCompile(`^[a-zA-Z0-9.-]+:(/[a-zA-Z0-9._+@~=-]*)+$`)
+	mountControlWhereRegexp       = regexp.MustCompile(`^\$(SNAP_COMMON|SNAP_DATA)(/[a-zA-Z0-9_.+-]+)+$`)
+	mountControlOptionRegexp      = regexp.MustCompile(`^[a-z0-9_]+(=[a-zA-Z0-9._+@:/~-]*)?$`)
+	mountControlCredentialsRegexp = regexp.MustCompile(`^[a-z0-9](-?[a-z0-9])*$`)
+)
+
+// mountControlImplicitOptions are always used for mounts, so that the mounted
+// filesystem cannot give the snap more privileges than it has.
+var mountControlImplicitOptions = []string{"nosuid", "nodev"}
+
+// mountControlAllowedOptions are the mount options a plug may use for all
+// filesystem types, in addition to the ones specific to a type.
+var mountControlAllowedOptions = []string{
+	"ro", "rw", "noexec", "noatime", "nodiratime", "relatime", "sync", "async",
+	"nosuid", "nodev",
+}
+
+var mountControlTypeAllowedOptions = map[string][]string{
+	"cifs": {
+		"vers", "uid", "gid", "forceuid", "forcegid", "file_mode", "dir_mode",
+		"sec", "username", "domain", "guest", "port", "iocharset", "cache",
+		"nounix", "serverino", "noserverino", "seal", "nobrl", "actimeo",
+		"rsize", "wsize",
+	},
+	"nfs": {
+		"vers", "nfsvers", "proto", "port", "timeo", "retrans", "hard", "soft",
+		"nolock", "noac", "actimeo", "sec", "rsize", "wsize",
+	},
+	"fuse": {"uid", "gid", "default_permissions", "allow_other", "max_read"},
+}
+
+// mountControlOptionAllowed returns whether the option with the given name
+// may be used for a mount of the given type.
+func mountControlOptionAllowed(fsType, name string) bool {
+	switch {
+	case fsType == "nfs4":
+		fsType = "nfs"
+	case strings.HasPrefix(fsType, "fuse."):
+		fsType = "fuse"
+	}
+	return strutil.ListContains(mountControlAllowedOptions, name) || strutil.ListContains(mountControlTypeAllowedOptions[fsType], name)
+}
+
+type mountControlInterface struct {
+	commonInterface
+}
+
+type mountControlEntry struct {
+	what        string
+	where       string
+	fsType      string
+	options     []string
+	credentials string
+	persistent  bool
+}
+
+// isFUSE returns whether the entry describes a FUSE filesystem.
+func (entry *mountControlEntry) isFUSE() bool {
+	return strings.HasPrefix(entry.fsType, "fuse.")
+}
+
+// apparmorTarget returns the mount point as used in AppArmor rules.
+func (entry *mountControlEntry) apparmorTarget() string {
+	// parallel-installs: SNAP_{DATA,COMMON} are remapped, need to use
+	// SNAP_NAME, for completeness allow SNAP_INSTANCE_NAME too
+	if strings.HasPrefix(entry.where, "$SNAP_COMMON/") {
+		return "/var/snap/{@{SNAP_NAME},@{SNAP_INSTANCE_NAME}}/common/" + strings.TrimPrefix(entry.where, "$SNAP_COMMON/")
+	}
+	return "/var/snap/{@{SNAP_NAME},@{SNAP_INSTANCE_NAME}}/@{SNAP_REVISION}/" + strings.TrimPrefix(entry.where, "$SNAP_DATA/")
+}
+
+// mountOptions returns the options the entry is mounted with.
+func (entry *mountControlEntry) mountOptions() []string {
+	return append(append([]string(nil), mountControlImplicitOptions...), entry.options...)
+}
+
+// credentialsFile returns the path of the credentials file of the entry.
+func (entry *mountControlEntry) credentialsFile(snapInfo *snap.Info) string {
+	return filepath.Join(dirs.SnapMountCredentialsDir, snapInfo.InstanceName(), entry.credentials)
+}
+
+func enumerateMounts(plug interfaces.Attrer, handle func(int, *mountControlEntry) error) error {
+	var mounts []interface{}
+	if err := plug.Attr("mount", &mounts); err != nil {
+		return fmt.Errorf(`"mount" must be a list of maps`)
+	}
+
+	for i, m := range mounts {
+		mount, ok := m.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf(`"mount" must be a list of maps`)
+		}
+		entry, err := parseMountControlEntry(mount)
+		if err != nil {
+			return err
+		}
+		if err := handle(i, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseMountControlEntry(mount map[string]interface{}) (*mountControlEntry, error) {
+	for key := range mount {
+		switch key {
+		case "what", "where", "type", "options", "credentials", "persistent":
+		default:
+			return nil, fmt.Errorf(`mount entry contains unsupported attribute %q`, key)
+		}
+	}
+
+	entry := &mountControlEntry{}
+	for _, field := range []struct {
+		key string
+		dst *string
+	}{{"what", &entry.what}, {"where", &entry.where}, {"type", &entry.fsType}} {
+		value, ok := mount[field.key].(string)
+		if !ok || value == "" {
+			return nil, fmt.Errorf(`mount entry must have a %q string`, field.key)
+		}
+		*field.dst = value
+	}
+
+	if !mountControlWhereRegexp.MatchString(entry.where) || filepath.Clean(entry.where) != entry.where {
+		return nil, fmt.Errorf(`mount point %q must be a clean path below $SNAP_COMMON or $SNAP_DATA`, entry.where)
+	}
+	if !mountControlWhatRegexp.MatchString(entry.what) {
+		return nil, fmt.Errorf(`invalid mount source %q`, entry.what)
+	}
+	switch {
+	case entry.fsType == "cifs":
+		if !mountControlCIFSWhatRegexp.MatchString(entry.what) {
+			return nil, fmt.Errorf(`source of cifs mount %q must be of the form //server/share`, entry.where)
+		}
+	case entry.fsType == "nfs" || entry.fsType == "nfs4":
+		if !mountControlNFSWhatRegexp.MatchString(entry.what) {
+			return nil, fmt.Errorf(`source of %s mount %q must be of the form server:/path`, entry.fsType, entry.where)
+		}
+	case mountControlFUSETypeRegexp.MatchString(entry.fsType):
+	default:
+		return nil, fmt.Errorf(`mount type %q of %q is not supported, must be one of "cifs", "nfs", "nfs4" or "fuse.<name>"`, entry.fsType, entry.where)
+	}
+
+	if v, ok := mount["options"]; ok {
+		options, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf(`"options" of mount %q must be a list of strings`, entry.where)
+		}
+		for _, o := range options {
+			option, ok := o.(string)
+			if !ok || !mountControlOptionRegexp.MatchString(option) {
+				return nil, fmt.Errorf(`invalid option %v for mount %q`, o, entry.where)
+			}
+			name := strings.SplitN(option, "=", 2)[0]
+			if !mountControlOptionAllowed(entry.fsType, name) {
+				return nil, fmt.Errorf(`option %q is not allowed for mount %q`, name, entry.where)
+			}
+			if strutil.ListContains(mountControlImplicitOptions, option) || strutil.ListContains(entry.options, option) {
+				continue
+			}
+			entry.options = append(entry.options, option)
+		}
+	}
+
+	if v, ok := mount["persistent"]; ok {
+		persistent, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf(`"persistent" of mount %q must be a boolean`, entry.where)
+		}
+		entry.persistent = persistent
+	}
+
+	if v, ok := mount["credentials"]; ok {
+		credentials, ok := v.(string)
+		if !ok || !mountControlCredentialsRegexp.MatchString(credentials) {
+			return nil, fmt.Errorf(`invalid credentials %v for mount %q`, v, entry.where)
+		}
+		if entry.fsType != "cifs" {
+			return nil, fmt.Errorf(`credentials are only supported for cifs mounts`)
+		}
+		// credentials are never exposed to the snap, so only mount
+		// units can use them
+		if !entry.persistent {
+			return nil, fmt.Errorf(`mount %q with credentials must be persistent`, entry.where)
+		}
+		entry.credentials = credentials
+	}
+
+	// persistent mounts outlive revisions of the snap
+	if entry.persistent && !strings.HasPrefix(entry.where, "$SNAP_COMMON/") {
+		return nil, fmt.Errorf(`persistent mount %q must be below $SNAP_COMMON`, entry.where)
+	}
+
+	return entry, nil
+}
+
+func (iface *mountControlInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	seen := make(map[string]bool)
+	numMounts := 0
+	err := enumerateMounts(plug, func(_ int, entry *mountControlEntry) error {
+		if seen[entry.where] {
+			return fmt.Errorf(`mount point %q is listed more than once`, entry.where)
+		}
+		seen[entry.where] = true
+		numMounts++
+		return nil
+	})
+	if err == nil && numMounts == 0 {
+		err = fmt.Errorf(`"mount" must contain at least one entry`)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot add mount-control plug: %v", err)
+	}
+	return nil
+}
+
+func (iface *mountControlInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	var rules []string
+	fuse := false
+	err := enumerateMounts(plug, func(_ int, entry *mountControlEntry) error {
+		target := entry.apparmorTarget()
+		// the snap must use the same options as the mount units, in
+		// particular nosuid and nodev
+		rules = append(rules,
+			fmt.Sprintf("mount fstype=%s options=(%s) \"%s\" -> \"%s{,/}\",", entry.fsType, strings.Join(entry.mountOptions(), ","), entry.what, target),
+			fmt.Sprintf("umount \"%s{,/}\",", target))
+		fuse = fuse || entry.isFUSE()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	spec.AddSnippet(mountControlConnectedPlugAppArmor)
+	if fuse {
+		spec.AddSnippet(mountControlFUSEConnectedPlugAppArmor)
+	}
+	spec.AddSnippet(strings.Join(rules, "\n"))
+	return nil
+}
+
+func (iface *mountControlInterface) SystemdConnectedPlug(spec *systemd.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	snapInfo := plug.Snap()
+	return enumerateMounts(plug, func(i int, entry *mountControlEntry) error {
+		if !entry.persistent {
+			return nil
+		}
+		options := entry.mountOptions()
+		if entry.credentials != "" {
+			options = append(options, "credentials="+entry.credentialsFile(snapInfo))
+		}
+		// the mount point is below $SNAP_COMMON, which the snap can
+		// write to, so the units resolve it with a helper that does
+		// not follow symlinks instead of running mkdir and mount on
+		// the path as root
+		base := snapInfo.CommonDataDir()
+		rel := strings.TrimPrefix(entry.where, "$SNAP_COMMON/")
+		serviceName := interfaces.InterfaceServiceName(snapInfo.InstanceName(), fmt.Sprintf("mount-control-%s-%d", plug.Name(), i))
+		// the command lines are run without a shell, and the
+		// arguments are restricted so that systemd does not expand
+		// nor split them
+		service := &systemd.Service{
+			Description:     fmt.Sprintf("Mount %s for snap %s", entry.what, snapInfo.InstanceName()),
+			Type:            "oneshot",
+			RemainAfterExit: true,
+			ExecStart:       fmt.Sprintf("/usr/bin/snap routine mount-control --type=%s --options=%s --what=%s %s %s", entry.fsType, strings.Join(options, ","), entry.what, base, rel),
+			ExecStop:        fmt.Sprintf("/usr/bin/snap routine mount-control --unmount %s %s", base, rel),
+		}
+		return spec.AddService(serviceName, service)
+	})
+}
+
+func init() {
+	registerIface(&mountControlInterface{
+		commonInterface: commonInterface{
+			name:                 "mount-control",
+			summary:              mountControlSummary,
+			implicitOnCore:       true,
+			implicitOnClassic:    true,
+			baseDeclarationPlugs: mountControlBaseDeclarationPlugs,
+			baseDeclarationSlots: mountControlBaseDeclarationSlots,
+			connectedPlugSecComp: mountControlConnectedPlugSecComp,
+		},
+	})
+}
@@ -0,0 +1,224 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin_test
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/systemd"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type MountControlInterfaceSuite struct {
+	iface    interfaces.Interface
+	slotInfo *snap.SlotInfo
+	slot     *interfaces.ConnectedSlot
+	plugInfo *snap.PlugInfo
+	plug     *interfaces.ConnectedPlug
+}
+
+var _ = Suite(&MountControlInterfaceSuite{
+	iface: builtin.MustInterface("mount-control"),
+})
+
+const mountControlConsumerYaml = `name: consumer
+version: 0
+plugs:
+ mntctl:
+  interface: mount-control
+  mount:
+  - what: //nas.local/share
+    where: $SNAP_COMMON/nas
+    type: cifs
+    options: [ro, vers=3.0]
+    credentials: nas
+    persistent: true
+  - what: nfs.local:/export/data
+    where: $SNAP_DATA/data
+    type: nfs4
+  - what: sshfs#user@host:/srv
+    where: $SNAP_COMMON/remote
+    type: fuse.sshfs
+    options: [nosuid, allow_other, allow_other]
+apps:
+ app:
+  plugs: [mntctl]
+`
+
+const mountControlCoreYaml = `name: core
+version: 0
+type: os
+slots:
+  mount-control:
+`
+
+func (s *MountControlInterfaceSuite) SetUpTest(c *C) {
+	s.plug, s.plugInfo = MockConnectedPlug(c, mountControlConsumerYaml, &snap.SideInfo{Revision: snap.R(1)}, "mntctl")
+	s.slot, s.slotInfo = MockConnectedSlot(c, mountControlCoreYaml, nil, "mount-control")
+}
+
+func (s *MountControlInterfaceSuite) TestName(c *C) {
+	c.Assert(s.iface.Name(), Equals, "mount-control")
+}
+
+func (s *MountControlInterfaceSuite) TestSanitizeSlot(c *C) {
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.slotInfo), IsNil)
+}
+
+func (s *MountControlInterfaceSuite) TestSanitizePlug(c *C) {
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
+}
+
+func (s *MountControlInterfaceSuite) TestSanitizePlugUnhappy(c *C) {
+	const yamlTemplate = `name: consumer
+version: 0
+plugs:
+ mntctl:
+  interface: mount-control
+  $t
+`
+	for _, tc := range []struct {
+		mount string
+		err   string
+	}{
+		{"", `"mount" must be a list of maps`},
+		{"mount: [foo]", `"mount" must be a list of maps`},
+		{"mount: []", `"mount" must contain at least one entry`},
+		{"mount:\n  - where: $SNAP_COMMON/a\n    type: cifs", `mount entry must have a "what" string`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    foo: bar", `mount entry contains unsupported attribute "foo"`},
+		{"mount:\n  - what: //a/b\n    where: /mnt\n    type: cifs", `mount point "/mnt" must be a clean path below \$SNAP_COMMON or \$SNAP_DATA`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/../a\n    type: cifs", `mount point "\$SNAP_COMMON/../a" must be a clean path below \$SNAP_COMMON or \$SNAP_DATA`},
+		{"mount:\n  - what: '//a/b,c'\n    where: $SNAP_COMMON/a\n    type: cifs", `invalid mount source "//a/b,c"`},
+		{"mount:\n  - what: a/b\n    where: $SNAP_COMMON/a\n    type: cifs", `source of cifs mount "\$SNAP_COMMON/a" must be of the form //server/share`},
+		{"mount:\n  - what: a/b\n    where: $SNAP_COMMON/a\n    type: nfs", `source of nfs mount "\$SNAP_COMMON/a" must be of the form server:/path`},
+		{"mount:\n  - what: /dev/sda1\n    where: $SNAP_COMMON/a\n    type: ext4", `mount type "ext4" of "\$SNAP_COMMON/a" is not supported, must be one of "cifs", "nfs", "nfs4" or "fuse.<name>"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: ro", `"options" of mount "\$SNAP_COMMON/a" must be a list of strings`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: ['a b']", `invalid option a b for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: [suid]", `option "suid" is not allowed for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: [password=x]", `option "password" is not allowed for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: [dev]", `option "dev" is not allowed for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: [nfsvers=4]", `option "nfsvers" is not allowed for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: ['uid=%u']", `invalid option uid=%u for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: ['uid=$USER']", `invalid option uid=\$USER for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    options: ['uid=1,x']", `invalid option uid=1,x for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: 'h:/a;reboot'\n    where: $SNAP_COMMON/a\n    type: nfs", `invalid mount source "h:/a;reboot"`},
+		{"mount:\n  - what: \"//a/b'\"\n    where: $SNAP_COMMON/a\n    type: cifs", `invalid mount source "//a/b'"`},
+		{"mount:\n  - what: '//a/%H'\n    where: $SNAP_COMMON/a\n    type: cifs", `invalid mount source "//a/%H"`},
+		{"mount:\n  - what: //a/$HOME\n    where: $SNAP_COMMON/a\n    type: cifs", `invalid mount source "//a/\$HOME"`},
+		{"mount:\n  - what: //a\n    where: $SNAP_COMMON/a\n    type: cifs", `source of cifs mount "\$SNAP_COMMON/a" must be of the form //server/share`},
+		{"mount:\n  - what: -o:/a\n    where: $SNAP_COMMON/a\n    type: nfs", `invalid mount source "-o:/a"`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    persistent: yes-please", `"persistent" of mount "\$SNAP_COMMON/a" must be a boolean`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    credentials: ../x\n    persistent: true", `invalid credentials ../x for mount "\$SNAP_COMMON/a"`},
+		{"mount:\n  - what: h:/a\n    where: $SNAP_COMMON/a\n    type: nfs\n    credentials: x\n    persistent: true", `credentials are only supported for cifs mounts`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n    credentials: x", `mount "\$SNAP_COMMON/a" with credentials must be persistent`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_DATA/a\n    type: cifs\n    persistent: true", `persistent mount "\$SNAP_DATA/a" must be below \$SNAP_COMMON`},
+		{"mount:\n  - what: //a/b\n    where: $SNAP_COMMON/a\n    type: cifs\n  - what: //a/c\n    where: $SNAP_COMMON/a\n    type: cifs", `mount point "\$SNAP_COMMON/a" is listed more than once`},
+	} {
+		yaml := strings.Replace(yamlTemplate, "$t", tc.mount, 1)
+		info := snaptest.MockInfo(c, yaml, nil)
+		plug := info.Plugs["mntctl"]
+		err := interfaces.BeforePreparePlug(s.iface, plug)
+		c.Check(err, ErrorMatches, "cannot add mount-control plug: "+tc.err, Commentf("%s", tc.mount))
+	}
+}
+
+func (s *MountControlInterfaceSuite) TestAppArmorSpec(c *C) {
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
+	snippet := spec.SnippetForTag("snap.consumer.app")
+	c.Check(snippet, testutil.Contains, "capability sys_admin,\n")
+	c.Check(snippet, testutil.Contains, "/dev/fuse rw,\n")
+	c.Check(snippet, testutil.Contains, `mount fstype=cifs options=(nosuid,nodev,ro,vers=3.0) "//nas.local/share" -> "/var/snap/{@{SNAP_NAME},@{SNAP_INSTANCE_NAME}}/common/nas{,/}",`+"\n")
+	c.Check(snippet, testutil.Contains, `umount "/var/snap/{@{SNAP_NAME},@{SNAP_INSTANCE_NAME}}/common/nas{,/}",`+"\n")
+	c.Check(snippet, testutil.Contains, `mount fstype=nfs4 options=(nosuid,nodev) "nfs.local:/export/data" -> "/var/snap/{@{SNAP_NAME},@{SNAP_INSTANCE_NAME}}/@{SNAP_REVISION}/data{,/}",`+"\n")
+	c.Check(snippet, testutil.Contains, `mount fstype=fuse.sshfs options=(nosuid,nodev,allow_other) "sshfs#user@host:/srv" -> "/var/snap/{@{SNAP_NAME},@{SNAP_INSTANCE_NAME}}/common/remote{,/}",`)
+	// credentials are never made accessible to the snap
+	c.Check(snippet, Not(testutil.Contains), "mount-credentials/")
+}
+
+func (s *MountControlInterfaceSuite) TestAppArmorSpecNoFUSE(c *C) {
+	const yaml = `name: consumer
+version: 0
+plugs:
+ mntctl:
+  interface: mount-control
+  mount:
+  - what: //nas.local/share
+    where: $SNAP_COMMON/nas
+    type: cifs
+apps:
+ app:
+  plugs: [mntctl]
+`
+	plug, _ := MockConnectedPlug(c, yaml, nil, "mntctl")
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, plug, s.slot), IsNil)
+	c.Check(spec.SnippetForTag("snap.consumer.app"), Not(testutil.Contains), "/dev/fuse")
+}
+
+func (s *MountControlInterfaceSuite) TestSecCompSpec(c *C) {
+	spec := &seccomp.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "mount\numount\numount2\n")
+}
+
+func (s *MountControlInterfaceSuite) TestSystemdSpec(c *C) {
+	dirs.SetRootDir("/")
+	defer dirs.SetRootDir("")
+	spec := &systemd.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	// only persistent mounts get a unit
+	c.Check(spec.Services(), DeepEquals, map[string]*systemd.Service{
+		"snap.consumer.interface.mount-control-mntctl-0.service": {
+			Description:     "Mount //nas.local/share for snap consumer",
+			Type:            "oneshot",
+			RemainAfterExit: true,
+			ExecStart:       "/usr/bin/snap routine mount-control --type=cifs --options=nosuid,nodev,ro,vers=3.0,credentials=/var/lib/snapd/mount-credentials/consumer/nas --what=//nas.local/share /var/snap/consumer/common nas",
+			ExecStop:        "/usr/bin/snap routine mount-control --unmount /var/snap/consumer/common nas",
+		},
+	})
+}
+
+func (s *MountControlInterfaceSuite) TestStaticInfo(c *C) {
+	si := interfaces.StaticInfoOf(s.iface)
+	c.Assert(si.ImplicitOnCore, Equals, true)
+	c.Assert(si.ImplicitOnClassic, Equals, true)
+	c.Assert(si.Summary, Equals, `allows mounting and unmounting FUSE and network filesystems`)
+	c.Assert(si.BaseDeclarationPlugs, testutil.Contains, "mount-control")
+	c.Assert(si.BaseDeclarationSlots, testutil.Contains, "mount-control")
+}
+
+func (s *MountControlInterfaceSuite) TestAutoConnect(c *C) {
+	c.Assert(s.iface.AutoConnect(s.plugInfo, s.slotInfo), Equals, true)
+}
+
+func (s *MountControlInterfaceSuite) TestInterfaces(c *C) {
+	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
+}
@@ -477,6 +477,81 @@ const networkManagerPermanentSlotDBus = `
 <limit name="max_match_rules_per_connection">2048</limit>
 `
 
+// networkManagerService describes the scopes of the NetworkManager D-Bus API
+// that plugs can request with the "scopes" attribute. Plugs without scopes
+// are granted access to the whole API.
+var networkManagerService = &dbusScopedService{
+	Name: "org.freedesktop.NetworkManager",
+	Scopes: map[string][]dbusScopeRule{
+		// read only access to devices, connections and their state
+		"observe": {{
+			Path:      "/org/freedesktop/NetworkManager",
+			Subtree:   true,
+			Interface: "org.freedesktop.DBus.Properties",
+			Methods:   []string{"Get", "GetAll"},
+			Signals:   []string{"PropertiesChanged"},
+		}, {
+			Path:      "/org/freedesktop",
+			Interface: "org.freedesktop.DBus.ObjectManager",
+			Methods:   []string{"GetManagedObjects"},
+			Signals:   []string{"InterfacesAdded", "InterfacesRemoved"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager",
+			Interface: "org.freedesktop.NetworkManager",
+			Methods:   []string{"GetDevices", "GetAllDevices", "GetPermissions"},
+			Signals:   []string{"StateChanged", "DeviceAdded", "DeviceRemoved"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager/Devices",
+			Subtree:   true,
+			Interface: "org.freedesktop.NetworkManager.Device",
+			Signals:   []string{"StateChanged"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager/Settings",
+			Interface: "org.freedesktop.NetworkManager.Settings",
+			Methods:   []string{"ListConnections", "GetConnectionByUuid"},
+			Signals:   []string{"NewConnection", "ConnectionRemoved"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager/Settings",
+			Subtree:   true,
+			Interface: "org.freedesktop.NetworkManager.Settings.Connection",
+			Methods:   []string{"GetSettings"},
+			Signals:   []string{"Updated", "Removed"},
+		}},
+		// scanning for and joining of wireless networks
+		"wifi": {{
+			Path:      "/org/freedesktop/NetworkManager/Devices",
+			Subtree:   true,
+			Interface: "org.freedesktop.NetworkManager.Device.Wireless",
+			Methods:   []string{"GetAccessPoints", "GetAllAccessPoints", "RequestScan"},
+			Signals:   []string{"AccessPointAdded", "AccessPointRemoved"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager",
+			Interface: "org.freedesktop.NetworkManager",
+			Methods:   []string{"ActivateConnection", "AddAndActivateConnection", "DeactivateConnection"},
+		}},
+		// management of connection profiles
+		"connections": {{
+			Path:      "/org/freedesktop/NetworkManager/Settings",
+			Interface: "org.freedesktop.NetworkManager.Settings",
+			Methods:   []string{"AddConnection", "AddConnectionUnsaved"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager/Settings",
+			Subtree:   true,
+			Interface: "org.freedesktop.NetworkManager.Settings.Connection",
+			Methods:   []string{"Update", "UpdateUnsaved", "Delete", "Save", "GetSecrets", "ClearSecrets"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager",
+			Interface: "org.freedesktop.NetworkManager",
+			Methods:   []string{"ActivateConnection", "DeactivateConnection"},
+		}, {
+			Path:      "/org/freedesktop/NetworkManager/Devices",
+			Subtree:   true,
+			Interface: "org.freedesktop.NetworkManager.Device",
+			Methods:   []string{"Disconnect", "Reapply"},
+		}},
+	},
+}
+
 type networkManagerInterface struct{}
 
 func (iface *networkManagerInterface) Name() string {
@@ -501,8 +576,16 @@ func (iface *networkManagerInterface) AppArmorConnectedPlug(spec *apparmor.Speci
 	} else {
 		new = slotAppLabelExpr(slot)
 	}
-	snippet := strings.Replace(networkManagerConnectedPlugAppArmor, old, new, -1)
-	spec.AddSnippet(snippet)
+	scopes, err := networkManagerService.plugScopes(plug)
+	if err != nil {
+		return err
+	}
+	if scopes == nil {
+		snippet := strings.Replace(networkManagerConnectedPlugAppArmor, old, new, -1)
+		spec.AddSnippet(snippet)
+	} else {
+		spec.AddSnippet(networkManagerService.appArmorSnippet(scopes, "label="+new))
+	}
 	if !release.OnClassic {
 		// See https://bugs.launchpad.net/snapd/+bug/1849291 for details.
 		snippet := strings.Replace(networkManagerConnectedPlugIntrospectionSnippet, old, new, -1)
@@ -511,6 +594,11 @@ func (iface *networkManagerInterface) AppArmorConnectedPlug(spec *apparmor.Speci
 	return nil
 }
 
+func (iface *networkManagerInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	_, err := networkManagerService.plugScopes(plug)
+	return err
+}
+
 func (iface *networkManagerInterface) AppArmorConnectedSlot(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	old := "###PLUG_SECURITY_TAGS###"
 	new := plugAppLabelExpr(plug)
@@ -238,6 +238,70 @@ KERNEL=="rfkill", TAG+="snap_network-manager_nm"`)
 	c.Assert(spec.Snippets(), testutil.Contains, `TAG=="snap_network-manager_nm", RUN+="/usr/lib/snapd/snap-device-helper $env{ACTION} snap_network-manager_nm $devpath $major:$minor"`)
 }
 
+const netmgrMockScopedPlugSnapInfoYaml = `name: network-manager-client
+version: 1.0
+plugs:
+ network-manager:
+  interface: network-manager
+  scopes: [observe, wifi]
+apps:
+ nmcli:
+  command: foo
+  plugs:
+   - network-manager
+`
+
+func (s *NetworkManagerInterfaceSuite) TestSanitizePlugScopes(c *C) {
+	plugInfo := snaptest.MockInfo(c, netmgrMockScopedPlugSnapInfoYaml, nil).Plugs["network-manager"]
+	c.Check(interfaces.BeforePreparePlug(s.iface, plugInfo), IsNil)
+
+	for _, tc := range []struct {
+		scopes interface{}
+		err    string
+	}{
+		{"observe", `"scopes" must be a list of strings`},
+		{[]interface{}{42}, `"scopes" must be a list of strings`},
+		{[]interface{}{}, `"scopes" cannot be empty`},
+		{[]interface{}{"all"}, `unsupported scope "all", expected one of: connections, observe, wifi`},
+		{[]interface{}{"wifi", "wifi"}, `scope "wifi" is listed more than once`},
+	} {
+		plugInfo.Attrs = map[string]interface{}{"scopes": tc.scopes}
+		c.Check(interfaces.BeforePreparePlug(s.iface, plugInfo), ErrorMatches, tc.err, Commentf("%v", tc.scopes))
+	}
+}
+
+func (s *NetworkManagerInterfaceSuite) TestConnectedPlugScopesAppArmor(c *C) {
+	release.OnClassic = true
+	plugInfo := snaptest.MockInfo(c, netmgrMockScopedPlugSnapInfoYaml, nil).Plugs["network-manager"]
+	plug := interfaces.NewConnectedPlug(plugInfo, nil, nil)
+
+	apparmorSpec := &apparmor.Specification{}
+	err := apparmorSpec.AddConnectedPlug(s.iface, plug, s.slot)
+	c.Assert(err, IsNil)
+	snippet := apparmorSpec.SnippetForTag("snap.network-manager-client.nmcli")
+	c.Check(snippet, testutil.Contains, "limited to scopes: observe, wifi\n")
+	c.Check(snippet, testutil.Contains, `
+dbus (send)
+    bus=system
+    path=/org/freedesktop/NetworkManager/Devices{,/**}
+    interface=org.freedesktop.NetworkManager.Device.Wireless
+    member={GetAccessPoints,GetAllAccessPoints,RequestScan}
+    peer=(label=unconfined),
+`)
+	c.Check(snippet, testutil.Contains, `
+dbus (receive)
+    bus=system
+    path=/org/freedesktop/NetworkManager
+    interface=org.freedesktop.NetworkManager
+    member={StateChanged,DeviceAdded,DeviceRemoved}
+    peer=(label=unconfined),
+`)
+	// the connections scope was not requested
+	c.Check(snippet, Not(testutil.Contains), "AddConnection")
+	// neither is the access to the whole API
+	c.Check(snippet, Not(testutil.Contains), "Allow all access to NetworkManager service")
+}
+
 func (s *NetworkManagerInterfaceSuite) TestInterfaces(c *C) {
 	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/interfaces/seccomp"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
@@ -94,3 +95,37 @@ func (s *NetworkInterfaceSuite) TestUsedSecuritySystems(c *C) {
 func (s *NetworkInterfaceSuite) TestInterfaces(c *C) {
 	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
 }
+
+func (s *NetworkInterfaceSuite) TestMetadata(c *C) {
+	ifacetest.CheckInterface(c, &ifacetest.InterfaceMetadata{
+		Interface:    s.iface,
+		PlugSnapYaml: netMockPlugSnapInfoYaml,
+		SlotSnapYaml: `name: core
+version: 0
+type: os
+slots:
+  network:
+`,
+		ImplicitOnCore:    true,
+		ImplicitOnClassic: true,
+		AutoConnect:       true,
+		Snippets: []ifacetest.SnippetExpectation{
+			{
+				Backend:      interfaces.SecurityAppArmor,
+				SecurityTags: []string{"snap.other.app2"},
+				Contains:     []string{"tcp_fastopen"},
+			}, {
+				Backend:      interfaces.SecuritySecComp,
+				SecurityTags: []string{"snap.other.app2"},
+				Contains:     []string{"bind\n"},
+				NotContains:  []string{"mount\n"},
+			}, {
+				Backend: interfaces.SecurityUDev,
+			}, {
+				Backend:   interfaces.SecurityAppArmor,
+				Slot:      true,
+				Permanent: true,
+			},
+		},
+	})
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/hotplug"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 const serialPortSummary = `allows accessing a specific serial port`
@@ -80,6 +81,15 @@ var serialDeviceNodePattern = regexp.MustCompile("^/dev/tty(mxc|USB|ACM|AMA|XRUS
 // are also specified
 var serialUDevSymlinkPattern = regexp.MustCompile("^/dev/serial-port-[a-z0-9]+$")
 
+// Baud rates that can be set with the baud-rate attribute, snap-confine
+// configures the serial port with the rate before running the application.
+var serialPortBaudRates = []int64{
+	1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600,
+}
+
+// Parities that can be set with the parity attribute.
+var serialPortParities = []string{"none", "even", "odd"}
+
 // BeforePrepareSlot checks validity of the defined slot
 func (iface *serialPortInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
 	// Check slot has a path attribute identify serial device
@@ -128,9 +138,63 @@ func (iface *serialPortInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
 			return fmt.Errorf("serial-port path attribute must be a valid device node")
 		}
 	}
+	return iface.validateLineSettings(slot)
+}
+
+// validateLineSettings checks the attributes describing how the serial port
+// is configured and claimed.
+func (iface *serialPortInterface) validateLineSettings(slot *snap.SlotInfo) error {
+	if v, ok := slot.Attrs["baud-rate"]; ok {
+		baudRate, ok := v.(int64)
+		if !ok || !int64InSlice(baudRate, serialPortBaudRates) {
+			return fmt.Errorf("serial-port baud-rate attribute must be one of %v", serialPortBaudRates)
+		}
+	}
+	if v, ok := slot.Attrs["parity"]; ok {
+		parity, ok := v.(string)
+		if !ok || !strutil.ListContains(serialPortParities, parity) {
+			return fmt.Errorf("serial-port parity attribute must be one of %s", strings.Join(serialPortParities, ", "))
+		}
+	}
+	if v, ok := slot.Attrs["exclusive"]; ok {
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("serial-port exclusive attribute must be a boolean")
+		}
+	}
 	return nil
 }
 
+func int64InSlice(v int64, list []int64) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// lineSettings returns the udev assignments recording how snap-confine must
+// configure and claim the serial port of the slot, if at all.
+func (iface *serialPortInterface) lineSettings(slot *interfaces.ConnectedSlot) string {
+	var assignments []string
+	var baudRate int64
+	if err := slot.Attr("baud-rate", &baudRate); err == nil {
+		assignments = append(assignments, fmt.Sprintf(`ENV{SNAPD_SERIAL_PORT_BAUD_RATE}="%d"`, baudRate))
+	}
+	var parity string
+	if err := slot.Attr("parity", &parity); err == nil {
+		assignments = append(assignments, fmt.Sprintf(`ENV{SNAPD_SERIAL_PORT_PARITY}="%s"`, parity))
+	}
+	var exclusive bool
+	if err := slot.Attr("exclusive", &exclusive); err == nil && exclusive {
+		assignments = append(assignments, `ENV{SNAPD_SERIAL_PORT_EXCLUSIVE}="1"`)
+	}
+	if len(assignments) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(assignments, ", ")
+}
+
 func (iface *serialPortInterface) UDevPermanentSlot(spec *udev.Specification, slot *snap.SlotInfo) error {
 	var usbVendor, usbProduct, usbInterfaceNumber int64
 	var path string
@@ -190,16 +254,19 @@ func (iface *serialPortInterface) UDevConnectedPlug(spec *udev.Specification, pl
 		return nil
 	}
 
+	// the line settings are recorded in the udev database, snap-confine
+	// applies them and claims the port when the application starts
+	settings := iface.lineSettings(slot)
 	if hasOnlyPath {
-		spec.TagDevice(fmt.Sprintf(`SUBSYSTEM=="tty", KERNEL=="%s"`, strings.TrimPrefix(path, "/dev/")))
+		spec.TagDevice(fmt.Sprintf(`SUBSYSTEM=="tty", KERNEL=="%s"%s`, strings.TrimPrefix(path, "/dev/"), settings))
 	} else {
 		var usbInterfaceNumber int64
 		if err := slot.Attr("usb-interface-number", &usbInterfaceNumber); err == nil {
 			spec.TagDevice(fmt.Sprintf(`IMPORT{builtin}="usb_id"
-SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x", ENV{ID_USB_INTERFACE_NUM}=="%02x"`, usbVendor, usbProduct, usbInterfaceNumber))
+SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x", ENV{ID_USB_INTERFACE_NUM}=="%02x"%s`, usbVendor, usbProduct, usbInterfaceNumber, settings))
 		} else {
 			spec.TagDevice(fmt.Sprintf(`IMPORT{builtin}="usb_id"
-SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x"`, usbVendor, usbProduct))
+SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x"%s`, usbVendor, usbProduct, settings))
 		}
 	}
 	return nil
@@ -461,6 +461,72 @@ SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="abcd", ATTRS{idProduct}==
 	c.Assert(extraSnippet, Equals, expectedExtraSnippet4)
 }
 
+func (s *SerialPortInterfaceSuite) TestSanitizeLineSettings(c *C) {
+	const gadgetYaml = `name: some-device
+version: 0
+type: gadget
+slots:
+  port:
+    interface: serial-port
+    path: /dev/ttyUSB0
+`
+	for _, tc := range []struct {
+		attrs string
+		err   string
+	}{
+		{"baud-rate: 115200\n    parity: even\n    exclusive: true", ""},
+		{"parity: none", ""},
+		{"exclusive: false", ""},
+		{"baud-rate: 1000", `serial-port baud-rate attribute must be one of \[1200 2400 .* 921600\]`},
+		{"baud-rate: fast", `serial-port baud-rate attribute must be one of .*`},
+		{"parity: mark", `serial-port parity attribute must be one of none, even, odd`},
+		{"exclusive: yes-please", `serial-port exclusive attribute must be a boolean`},
+	} {
+		info := snaptest.MockInfo(c, gadgetYaml+"    "+tc.attrs+"\n", nil)
+		err := interfaces.BeforePrepareSlot(s.iface, info.Slots["port"])
+		if tc.err == "" {
+			c.Check(err, IsNil, Commentf("%s", tc.attrs))
+		} else {
+			c.Check(err, ErrorMatches, tc.err, Commentf("%s", tc.attrs))
+		}
+	}
+}
+
+func (s *SerialPortInterfaceSuite) TestConnectedPlugUDevSnippetsLineSettings(c *C) {
+	info := snaptest.MockInfo(c, `name: some-device
+version: 0
+type: gadget
+slots:
+  port:
+    interface: serial-port
+    path: /dev/ttyUSB0
+    baud-rate: 9600
+    parity: odd
+    exclusive: true
+  usb-port:
+    interface: serial-port
+    path: /dev/serial-port-myserial
+    usb-vendor: 0xabcd
+    usb-product: 0x1234
+    exclusive: true
+`, nil)
+
+	spec := &udev.Specification{}
+	slot := interfaces.NewConnectedSlot(info.Slots["port"], nil, nil)
+	c.Assert(spec.AddConnectedPlug(s.iface, s.testPlugPort1, slot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 2)
+	c.Check(spec.Snippets()[0], Equals, `# serial-port
+SUBSYSTEM=="tty", KERNEL=="ttyUSB0", ENV{SNAPD_SERIAL_PORT_BAUD_RATE}="9600", ENV{SNAPD_SERIAL_PORT_PARITY}="odd", ENV{SNAPD_SERIAL_PORT_EXCLUSIVE}="1", TAG+="snap_client-snap_app-accessing-2-ports"`)
+
+	spec = &udev.Specification{}
+	slot = interfaces.NewConnectedSlot(info.Slots["usb-port"], nil, nil)
+	c.Assert(spec.AddConnectedPlug(s.iface, s.testPlugPort1, slot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 2)
+	c.Check(spec.Snippets()[0], Equals, `# serial-port
+IMPORT{builtin}="usb_id"
+SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="abcd", ATTRS{idProduct}=="1234", ENV{SNAPD_SERIAL_PORT_EXCLUSIVE}="1", TAG+="snap_client-snap_app-accessing-2-ports"`)
+}
+
 func (s *SerialPortInterfaceSuite) TestConnectedPlugAppArmorSnippets(c *C) {
 	checkConnectedPlugSnippet := func(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot, expectedSnippet string) {
 		apparmorSpec := &apparmor.Specification{}
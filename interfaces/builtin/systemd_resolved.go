@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/snap"
+)
+
+const systemdResolvedSummary = `allows scoped access to the systemd-resolved service`
+
+const systemdResolvedBaseDeclarationSlots = `
+  systemd-resolved:
+    allow-installation:
+      slot-snap-type:
+        - core
+    deny-auto-connection: true
+`
+
+// systemdResolvedDefaultScopes are used by plugs which do not set the
+// "scopes" attribute.
+var systemdResolvedDefaultScopes = []string{"resolve"}
+
+// systemdResolvedService describes the scopes of the systemd-resolved D-Bus
+// API, see https://www.freedesktop.org/software/systemd/man/org.freedesktop.resolve1.html
+var systemdResolvedService = &dbusScopedService{
+	Name: "org.freedesktop.resolve1",
+	Scopes: map[string][]dbusScopeRule{
+		// name resolution, as done by nss-resolve
+		"resolve": {{
+			Path:      "/org/freedesktop/resolve1",
+			Interface: "org.freedesktop.resolve1.Manager",
+			Methods:   []string{"ResolveAddress", "ResolveHostname", "ResolveRecord", "ResolveService"},
+		}},
+		// read only access to the resolver state
+		"observe": {{
+			Path:      "/org/freedesktop/resolve1",
+			Subtree:   true,
+			Interface: "org.freedesktop.DBus.Properties",
+			Methods:   []string{"Get", "GetAll"},
+			Signals:   []string{"PropertiesChanged"},
+		}, {
+			Path:      "/org/freedesktop/resolve1",
+			Interface: "org.freedesktop.resolve1.Manager",
+			Methods:   []string{"GetLink"},
+		}},
+		// per link DNS configuration, as done by network managers
+		"link-dns": {{
+			Path:      "/org/freedesktop/resolve1",
+			Interface: "org.freedesktop.resolve1.Manager",
+			Methods: []string{
+				"SetLinkDNS", "SetLinkDomains", "SetLinkDefaultRoute",
+				"SetLinkLLMNR", "SetLinkMulticastDNS", "SetLinkDNSOverTLS",
+				"SetLinkDNSSEC", "RevertLink",
+			},
+		}, {
+			Path:      "/org/freedesktop/resolve1/link",
+			Subtree:   true,
+			Interface: "org.freedesktop.resolve1.Link",
+			Methods: []string{
+				"SetDNS", "SetDomains", "SetDefaultRoute",
+				"SetLLMNR", "SetMulticastDNS", "SetDNSOverTLS",
+				"SetDNSSEC", "Revert",
+			},
+		}},
+	},
+}
+
+type systemdResolvedInterface struct {
+	commonInterface
+}
+
+func (iface *systemdResolvedInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	_, err := systemdResolvedService.plugScopes(plug)
+	return err
+}
+
+func (iface *systemdResolvedInterface) scopes(plug *interfaces.ConnectedPlug) ([]string, error) {
+	scopes, err := systemdResolvedService.plugScopes(plug)
+	if err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = systemdResolvedDefaultScopes
+	}
+	return scopes, nil
+}
+
+func (iface *systemdResolvedInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	scopes, err := iface.scopes(plug)
+	if err != nil {
+		return err
+	}
+	// systemd-resolved is always provided by the host
+	spec.AddSnippet(systemdResolvedService.appArmorSnippet(scopes, "label=unconfined"))
+	return nil
+}
+
+func init() {
+	registerIface(&systemdResolvedInterface{commonInterface: commonInterface{
+		name:                 "systemd-resolved",
+		summary:              systemdResolvedSummary,
+		implicitOnCore:       true,
+		implicitOnClassic:    true,
+		baseDeclarationSlots: systemdResolvedBaseDeclarationSlots,
+	}})
+}
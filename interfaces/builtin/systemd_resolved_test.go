@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type SystemdResolvedInterfaceSuite struct {
+	iface    interfaces.Interface
+	slotInfo *snap.SlotInfo
+	slot     *interfaces.ConnectedSlot
+	plugInfo *snap.PlugInfo
+	plug     *interfaces.ConnectedPlug
+}
+
+var _ = Suite(&SystemdResolvedInterfaceSuite{
+	iface: builtin.MustInterface("systemd-resolved"),
+})
+
+const systemdResolvedConsumerYaml = `name: other
+version: 1.0
+plugs:
+ systemd-resolved:
+  scopes: [observe, link-dns]
+apps:
+ app:
+  command: foo
+  plugs: [systemd-resolved]
+`
+
+func (s *SystemdResolvedInterfaceSuite) SetUpTest(c *C) {
+	s.slotInfo = &snap.SlotInfo{
+		Snap:      &snap.Info{SuggestedName: "core", SnapType: snap.TypeOS},
+		Name:      "systemd-resolved",
+		Interface: "systemd-resolved",
+	}
+	s.slot = interfaces.NewConnectedSlot(s.slotInfo, nil, nil)
+
+	snapInfo := snaptest.MockInfo(c, systemdResolvedConsumerYaml, nil)
+	s.plugInfo = snapInfo.Plugs["systemd-resolved"]
+	s.plug = interfaces.NewConnectedPlug(s.plugInfo, nil, nil)
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestName(c *C) {
+	c.Assert(s.iface.Name(), Equals, "systemd-resolved")
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestSanitizeSlot(c *C) {
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.slotInfo), IsNil)
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestSanitizePlug(c *C) {
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
+
+	s.plugInfo.Attrs = map[string]interface{}{"scopes": []interface{}{"resolve", "flush"}}
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), ErrorMatches,
+		`unsupported scope "flush", expected one of: link-dns, observe, resolve`)
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestAppArmorConnectedPlug(c *C) {
+	apparmorSpec := &apparmor.Specification{}
+	err := apparmorSpec.AddConnectedPlug(s.iface, s.plug, s.slot)
+	c.Assert(err, IsNil)
+	c.Assert(apparmorSpec.SecurityTags(), DeepEquals, []string{"snap.other.app"})
+	snippet := apparmorSpec.SnippetForTag("snap.other.app")
+	c.Check(snippet, testutil.Contains, `
+dbus (send)
+    bus=system
+    path=/org/freedesktop/resolve1
+    interface=org.freedesktop.resolve1.Manager
+    member=GetLink
+    peer=(label=unconfined),
+`)
+	c.Check(snippet, testutil.Contains, `
+dbus (send)
+    bus=system
+    path=/org/freedesktop/resolve1/link{,/**}
+    interface=org.freedesktop.resolve1.Link
+    member={SetDNS,SetDomains,SetDefaultRoute,SetLLMNR,SetMulticastDNS,SetDNSOverTLS,SetDNSSEC,Revert}
+    peer=(label=unconfined),
+`)
+	c.Check(snippet, Not(testutil.Contains), "ResolveHostname")
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestAppArmorConnectedPlugDefaultScopes(c *C) {
+	s.plugInfo.Attrs = nil
+	plug := interfaces.NewConnectedPlug(s.plugInfo, nil, nil)
+
+	apparmorSpec := &apparmor.Specification{}
+	err := apparmorSpec.AddConnectedPlug(s.iface, plug, s.slot)
+	c.Assert(err, IsNil)
+	snippet := apparmorSpec.SnippetForTag("snap.other.app")
+	c.Check(snippet, testutil.Contains, "member={ResolveAddress,ResolveHostname,ResolveRecord,ResolveService}\n")
+	c.Check(snippet, Not(testutil.Contains), "SetLinkDNS")
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestStaticInfo(c *C) {
+	si := interfaces.StaticInfoOf(s.iface)
+	c.Check(si.ImplicitOnCore, Equals, true)
+	c.Check(si.ImplicitOnClassic, Equals, true)
+	c.Check(si.Summary, Equals, "allows scoped access to the systemd-resolved service")
+	c.Check(si.BaseDeclarationSlots, testutil.Contains, "systemd-resolved")
+}
+
+func (s *SystemdResolvedInterfaceSuite) TestInterfaces(c *C) {
+	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
+}
@@ -40,7 +40,11 @@ const waylandBaseDeclarationSlots = `
     deny-connection:
       on-classic: false
     deny-auto-connection:
-      on-classic: false
+      -
+        on-classic: false
+      -
+        plug-attributes:
+          role: compositor
 `
 
 const waylandPermanentSlotAppArmor = `
@@ -128,8 +132,35 @@ func (iface *waylandInterface) StaticInfo() interfaces.StaticInfo {
 	}
 }
 
+func (iface *waylandInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	return sanitizeDisplayRole(iface.Name(), plug)
+}
+
+// HoldsExclusiveRole returns whether the plug asks to own the session as its
+// compositor, only one snap can do so for a given slot.
+func (iface *waylandInterface) HoldsExclusiveRole(plug *snap.PlugInfo) bool {
+	return isDisplayCompositor(plug)
+}
+
 func (iface *waylandInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	spec.AddSnippet(waylandConnectedPlugAppArmor)
+	if isDisplayCompositor(plug) {
+		spec.AddSnippet(waylandPermanentSlotAppArmor)
+	}
+	return nil
+}
+
+func (iface *waylandInterface) SecCompConnectedPlug(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if isDisplayCompositor(plug) {
+		spec.AddSnippet(waylandPermanentSlotSecComp)
+	}
+	return nil
+}
+
+func (iface *waylandInterface) UDevConnectedPlug(spec *udev.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if isDisplayCompositor(plug) {
+		waylandUDevTagDevices(spec)
+	}
 	return nil
 }
 
@@ -152,13 +183,17 @@ func (iface *waylandInterface) AppArmorPermanentSlot(spec *apparmor.Specificatio
 }
 
 func (iface *waylandInterface) UDevPermanentSlot(spec *udev.Specification, slot *snap.SlotInfo) error {
+	waylandUDevTagDevices(spec)
+	return nil
+}
+
+func waylandUDevTagDevices(spec *udev.Specification) {
 	spec.TriggerSubsystem("input")
 	spec.TagDevice(`KERNEL=="tty[0-9]*"`)
 	spec.TagDevice(`KERNEL=="mice"`)
 	spec.TagDevice(`KERNEL=="mouse[0-9]*"`)
 	spec.TagDevice(`KERNEL=="event[0-9]*"`)
 	spec.TagDevice(`KERNEL=="ts[0-9]*"`)
-	return nil
 }
 
 func (iface *waylandInterface) AutoConnect(*snap.PlugInfo, *snap.SlotInfo) bool {
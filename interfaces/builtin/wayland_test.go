@@ -89,6 +89,86 @@ func (s *WaylandInterfaceSuite) TestSanitizePlug(c *C) {
 	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
 }
 
+const waylandCompositorYaml = `name: compositor
+version: 0
+plugs:
+ wayland:
+  role: compositor
+apps:
+ app:
+  plugs: [wayland]
+`
+
+func (s *WaylandInterfaceSuite) TestSanitizePlugRole(c *C) {
+	_, plugInfo := MockConnectedPlug(c, waylandCompositorYaml, nil, "wayland")
+	c.Assert(interfaces.BeforePreparePlug(s.iface, plugInfo), IsNil)
+
+	for _, t := range []struct {
+		role interface{}
+		err  string
+	}{
+		{"client", ""},
+		{"compositor", ""},
+		{"server", `wayland plug has invalid role "server", expected "client" or "compositor"`},
+		{true, `wayland plug requires string with 'role'`},
+	} {
+		plugInfo.Attrs = map[string]interface{}{"role": t.role}
+		err := interfaces.BeforePreparePlug(s.iface, plugInfo)
+		if t.err == "" {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *WaylandInterfaceSuite) TestHoldsExclusiveRole(c *C) {
+	holder, ok := s.iface.(interfaces.ExclusiveRoleHolder)
+	c.Assert(ok, Equals, true)
+	c.Check(holder.HoldsExclusiveRole(s.plugInfo), Equals, false)
+
+	_, plugInfo := MockConnectedPlug(c, waylandCompositorYaml, nil, "wayland")
+	c.Check(holder.HoldsExclusiveRole(plugInfo), Equals, true)
+}
+
+func (s *WaylandInterfaceSuite) TestCompositorPlug(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	plug, _ := MockConnectedPlug(c, waylandCompositorYaml, nil, "wayland")
+
+	// the compositor gets to serve the session
+	apparmorSpec := &apparmor.Specification{}
+	c.Assert(apparmorSpec.AddConnectedPlug(s.iface, plug, s.classicSlot), IsNil)
+	c.Assert(apparmorSpec.SecurityTags(), DeepEquals, []string{"snap.compositor.app"})
+	c.Check(apparmorSpec.SnippetForTag("snap.compositor.app"), testutil.Contains, `owner /run/user/[0-9]*/wayland-[0-9]* rwk,`)
+	c.Check(apparmorSpec.SnippetForTag("snap.compositor.app"), testutil.Contains, "capability sys_tty_config,")
+
+	seccompSpec := &seccomp.Specification{}
+	c.Assert(seccompSpec.AddConnectedPlug(s.iface, plug, s.classicSlot), IsNil)
+	c.Check(seccompSpec.SnippetForTag("snap.compositor.app"), testutil.Contains, "listen\n")
+	c.Check(seccompSpec.SnippetForTag("snap.compositor.app"), testutil.Contains, "accept4\n")
+
+	udevSpec := &udev.Specification{}
+	c.Assert(udevSpec.AddConnectedPlug(s.iface, plug, s.classicSlot), IsNil)
+	c.Check(udevSpec.Snippets(), testutil.Contains, `# wayland
+KERNEL=="event[0-9]*", TAG+="snap_compositor_app"`)
+	c.Check(udevSpec.TriggeredSubsystems(), DeepEquals, []string{"input"})
+
+	// while clients only get to talk to it
+	apparmorSpec = &apparmor.Specification{}
+	c.Assert(apparmorSpec.AddConnectedPlug(s.iface, s.plug, s.classicSlot), IsNil)
+	c.Check(apparmorSpec.SnippetForTag("snap.consumer.app"), Not(testutil.Contains), "capability sys_tty_config,")
+
+	seccompSpec = &seccomp.Specification{}
+	c.Assert(seccompSpec.AddConnectedPlug(s.iface, s.plug, s.classicSlot), IsNil)
+	c.Check(seccompSpec.SnippetForTag("snap.consumer.app"), Not(testutil.Contains), "listen\n")
+
+	udevSpec = &udev.Specification{}
+	c.Assert(udevSpec.AddConnectedPlug(s.iface, s.plug, s.classicSlot), IsNil)
+	c.Check(udevSpec.Snippets(), HasLen, 0)
+}
+
 func (s *WaylandInterfaceSuite) TestAppArmorSpec(c *C) {
 	// on a core system with wayland slot coming from a regular app snap.
 	restore := release.MockOnClassic(false)
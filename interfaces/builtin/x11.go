@@ -41,7 +41,11 @@ const x11BaseDeclarationSlots = `
     deny-connection:
       on-classic: false
     deny-auto-connection:
-      on-classic: false
+      -
+        on-classic: false
+      -
+        plug-attributes:
+          role: compositor
 `
 
 const x11PermanentSlotAppArmor = `
@@ -162,6 +166,43 @@ type x11Interface struct {
 	commonInterface
 }
 
+func (iface *x11Interface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	return sanitizeDisplayRole(iface.Name(), plug)
+}
+
+// HoldsExclusiveRole returns whether the plug asks to own the session as its
+// X11 server, only one snap can do so for a given slot.
+func (iface *x11Interface) HoldsExclusiveRole(plug *snap.PlugInfo) bool {
+	return isDisplayCompositor(plug)
+}
+
+func (iface *x11Interface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if err := iface.commonInterface.AppArmorConnectedPlug(spec, plug, slot); err != nil {
+		return err
+	}
+	if isDisplayCompositor(plug) {
+		spec.AddSnippet(x11PermanentSlotAppArmor)
+	}
+	return nil
+}
+
+func (iface *x11Interface) SecCompConnectedPlug(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if err := iface.commonInterface.SecCompConnectedPlug(spec, plug, slot); err != nil {
+		return err
+	}
+	if isDisplayCompositor(plug) {
+		spec.AddSnippet(x11PermanentSlotSecComp)
+	}
+	return nil
+}
+
+func (iface *x11Interface) UDevConnectedPlug(spec *udev.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if isDisplayCompositor(plug) {
+		x11UDevTagDevices(spec)
+	}
+	return nil
+}
+
 func (iface *x11Interface) AppArmorConnectedSlot(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	if !release.OnClassic {
 		old := "###PLUG_SECURITY_TAGS###"
@@ -188,16 +229,20 @@ func (iface *x11Interface) AppArmorPermanentSlot(spec *apparmor.Specification, s
 
 func (iface *x11Interface) UDevPermanentSlot(spec *udev.Specification, slot *snap.SlotInfo) error {
 	if !release.OnClassic {
-		spec.TriggerSubsystem("input")
-		spec.TagDevice(`KERNEL=="tty[0-9]*"`)
-		spec.TagDevice(`KERNEL=="mice"`)
-		spec.TagDevice(`KERNEL=="mouse[0-9]*"`)
-		spec.TagDevice(`KERNEL=="event[0-9]*"`)
-		spec.TagDevice(`KERNEL=="ts[0-9]*"`)
+		x11UDevTagDevices(spec)
 	}
 	return nil
 }
 
+func x11UDevTagDevices(spec *udev.Specification) {
+	spec.TriggerSubsystem("input")
+	spec.TagDevice(`KERNEL=="tty[0-9]*"`)
+	spec.TagDevice(`KERNEL=="mice"`)
+	spec.TagDevice(`KERNEL=="mouse[0-9]*"`)
+	spec.TagDevice(`KERNEL=="event[0-9]*"`)
+	spec.TagDevice(`KERNEL=="ts[0-9]*"`)
+}
+
 func init() {
 	registerIface(&x11Interface{commonInterface{
 		name:                  "x11",
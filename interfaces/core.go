@@ -177,6 +177,14 @@ type SlotSanitizer interface {
 	BeforePrepareSlot(slot *snap.SlotInfo) error
 }
 
+// ExclusiveRoleHolder can be implemented by Interfaces for which at most one
+// of the plugs connected to a given slot may hold a privileged role at a time,
+// e.g. the compositor owning a display session. Connecting a plug holding the
+// role hands it over from any other snap currently holding it.
+type ExclusiveRoleHolder interface {
+	HoldsExclusiveRole(plug *snap.PlugInfo) bool
+}
+
 // StaticInfo describes various static-info of a given interface.
 //
 // The Summary must be a one-line string of length suitable for listing views.
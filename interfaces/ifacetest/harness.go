@@ -0,0 +1,283 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacetest
+
+import (
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/dbus"
+	"github.com/snapcore/snapd/interfaces/kmod"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+// InterfaceMetadata declaratively describes the expected behaviour of an
+// interface. CheckInterface derives table-driven connectivity, sanitization
+// and security snippet tests from it.
+type InterfaceMetadata struct {
+	// Interface is the interface under test.
+	Interface interfaces.Interface
+
+	// PlugSnapYaml and SlotSnapYaml are the snap.yaml of the snaps
+	// declaring the plug and the slot.
+	PlugSnapYaml string
+	SlotSnapYaml string
+	// PlugName and SlotName are the names of the plug and the slot,
+	// the name of the interface is used when empty.
+	PlugName string
+	SlotName string
+
+	// ImplicitOnCore and ImplicitOnClassic are the expected values of
+	// the static information of the interface.
+	ImplicitOnCore    bool
+	ImplicitOnClassic bool
+	// AutoConnect is whether the plug is expected to be auto-connected
+	// to the slot.
+	AutoConnect bool
+
+	// Snippets are the expected security snippets.
+	Snippets []SnippetExpectation
+
+	// PlugAttrCases and SlotAttrCases are checked by sanitizing the
+	// plug and the slot with each set of attributes.
+	PlugAttrCases []AttrCase
+	SlotAttrCases []AttrCase
+}
+
+// SnippetExpectation describes the snippets a backend is expected to
+// produce for a plug or a slot.
+type SnippetExpectation struct {
+	// Backend is the security backend, one of apparmor, seccomp, dbus,
+	// udev or kmod.
+	Backend interfaces.SecuritySystem
+	// Slot selects the snippets of the slot side instead of the plug
+	// side.
+	Slot bool
+	// Permanent selects the snippets added for the plug or slot alone
+	// instead of those added when they are connected.
+	Permanent bool
+	// SecurityTags are the expected security tags of the apparmor,
+	// seccomp and dbus snippets.
+	SecurityTags []string
+	// Contains and NotContains are checked against the snippets, the
+	// backend is expected to produce no snippets at all when both are
+	// empty. The snippets of the kmod backend are the loaded modules,
+	// one per line.
+	Contains    []string
+	NotContains []string
+}
+
+// AttrCase is a set of plug or slot attributes together with the error
+// expected when sanitizing them, if any.
+type AttrCase struct {
+	Attrs map[string]interface{}
+	// Error is matched against the sanitization error, no error is
+	// expected when empty.
+	Error string
+}
+
+// AttrCombinations returns all the combinations of the given attribute
+// values, a nil value leaves out the attribute. It is useful for fuzzing
+// the sanitization of interfaces with many attributes.
+func AttrCombinations(values map[string][]interface{}) []map[string]interface{} {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combinations := []map[string]interface{}{{}}
+	for _, name := range names {
+		var next []map[string]interface{}
+		for _, comb := range combinations {
+			for _, value := range values[name] {
+				attrs := make(map[string]interface{}, len(comb)+1)
+				for k, v := range comb {
+					attrs[k] = v
+				}
+				if value != nil {
+					attrs[name] = value
+				}
+				next = append(next, attrs)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// CheckInterface runs the checks derived from the interface metadata.
+func CheckInterface(c *C, meta *InterfaceMetadata) {
+	iface := meta.Interface
+	c.Assert(iface, NotNil)
+	name := iface.Name()
+	c.Check(name, Not(Equals), "")
+
+	si := interfaces.StaticInfoOf(iface)
+	c.Check(si.Summary, Not(Equals), "", Commentf("interface %q has no summary", name))
+	c.Check(si.ImplicitOnCore, Equals, meta.ImplicitOnCore, Commentf("interface %q", name))
+	c.Check(si.ImplicitOnClassic, Equals, meta.ImplicitOnClassic, Commentf("interface %q", name))
+
+	plugInfo := meta.plugInfo(c)
+	slotInfo := meta.slotInfo(c)
+	c.Assert(interfaces.BeforePreparePlug(iface, plugInfo), IsNil)
+	c.Assert(interfaces.BeforePrepareSlot(iface, slotInfo), IsNil)
+
+	c.Check(iface.AutoConnect(plugInfo, slotInfo), Equals, meta.AutoConnect, Commentf("interface %q", name))
+
+	for i, exp := range meta.Snippets {
+		comment := Commentf("interface %q, snippet expectation #%d (%s)", name, i, exp.Backend)
+		checkSnippets(c, iface, exp, plugInfo, slotInfo, comment)
+	}
+
+	for i, tc := range meta.PlugAttrCases {
+		comment := Commentf("interface %q, plug attributes case #%d: %v", name, i, tc.Attrs)
+		p := *meta.plugInfo(c)
+		p.Attrs = tc.Attrs
+		checkAttrCase(c, interfaces.BeforePreparePlug(iface, &p), tc, comment)
+	}
+	for i, tc := range meta.SlotAttrCases {
+		comment := Commentf("interface %q, slot attributes case #%d: %v", name, i, tc.Attrs)
+		s := *meta.slotInfo(c)
+		s.Attrs = tc.Attrs
+		checkAttrCase(c, interfaces.BeforePrepareSlot(iface, &s), tc, comment)
+	}
+}
+
+func (meta *InterfaceMetadata) plugInfo(c *C) *snap.PlugInfo {
+	name := meta.PlugName
+	if name == "" {
+		name = meta.Interface.Name()
+	}
+	info := snaptest.MockInfo(c, meta.PlugSnapYaml, nil)
+	plugInfo := info.Plugs[name]
+	c.Assert(plugInfo, NotNil, Commentf("cannot find plug %q", name))
+	return plugInfo
+}
+
+func (meta *InterfaceMetadata) slotInfo(c *C) *snap.SlotInfo {
+	name := meta.SlotName
+	if name == "" {
+		name = meta.Interface.Name()
+	}
+	info := snaptest.MockInfo(c, meta.SlotSnapYaml, nil)
+	slotInfo := info.Slots[name]
+	c.Assert(slotInfo, NotNil, Commentf("cannot find slot %q", name))
+	return slotInfo
+}
+
+func checkAttrCase(c *C, err error, tc AttrCase, comment CommentInterface) {
+	if tc.Error == "" {
+		c.Check(err, IsNil, comment)
+	} else {
+		c.Check(err, ErrorMatches, tc.Error, comment)
+	}
+}
+
+// taggedSpecification is implemented by the specifications of the backends
+// keeping their snippets per security tag.
+type taggedSpecification interface {
+	interfaces.Specification
+	SecurityTags() []string
+	SnippetForTag(tag string) string
+}
+
+func addSnippets(spec interfaces.Specification, iface interfaces.Interface, exp SnippetExpectation, plugInfo *snap.PlugInfo, slotInfo *snap.SlotInfo) error {
+	switch {
+	case exp.Permanent && exp.Slot:
+		return spec.AddPermanentSlot(iface, slotInfo)
+	case exp.Permanent:
+		return spec.AddPermanentPlug(iface, plugInfo)
+	}
+	plug := interfaces.NewConnectedPlug(plugInfo, nil, nil)
+	slot := interfaces.NewConnectedSlot(slotInfo, nil, nil)
+	if exp.Slot {
+		return spec.AddConnectedSlot(iface, plug, slot)
+	}
+	return spec.AddConnectedPlug(iface, plug, slot)
+}
+
+func checkSnippets(c *C, iface interfaces.Interface, exp SnippetExpectation, plugInfo *snap.PlugInfo, slotInfo *snap.SlotInfo, comment CommentInterface) {
+	var snippets []string
+	switch exp.Backend {
+	case interfaces.SecurityAppArmor, interfaces.SecuritySecComp, interfaces.SecurityDBus:
+		var spec taggedSpecification
+		switch exp.Backend {
+		case interfaces.SecurityAppArmor:
+			spec = &apparmor.Specification{}
+		case interfaces.SecuritySecComp:
+			spec = &seccomp.Specification{}
+		case interfaces.SecurityDBus:
+			spec = &dbus.Specification{}
+		}
+		c.Assert(addSnippets(spec, iface, exp, plugInfo, slotInfo), IsNil, comment)
+		tags := spec.SecurityTags()
+		if len(exp.Contains) == 0 && len(exp.NotContains) == 0 {
+			c.Check(tags, HasLen, 0, comment)
+			return
+		}
+		c.Check(tags, DeepEquals, exp.SecurityTags, comment)
+		for _, tag := range tags {
+			snippets = append(snippets, spec.SnippetForTag(tag))
+		}
+	case interfaces.SecurityUDev:
+		spec := &udev.Specification{}
+		c.Assert(addSnippets(spec, iface, exp, plugInfo, slotInfo), IsNil, comment)
+		// rules are checked as a whole as each snippet is a single rule
+		if rules := spec.Snippets(); len(rules) > 0 {
+			snippets = []string{strings.Join(rules, "\n")}
+		}
+	case interfaces.SecurityKMod:
+		spec := &kmod.Specification{}
+		c.Assert(addSnippets(spec, iface, exp, plugInfo, slotInfo), IsNil, comment)
+		modules := make([]string, 0, len(spec.Modules()))
+		for module := range spec.Modules() {
+			modules = append(modules, module)
+		}
+		sort.Strings(modules)
+		if len(modules) > 0 {
+			snippets = []string{strings.Join(modules, "\n") + "\n"}
+		}
+	default:
+		c.Fatalf("unsupported backend %q", exp.Backend)
+	}
+
+	if len(exp.Contains) == 0 && len(exp.NotContains) == 0 {
+		c.Check(snippets, HasLen, 0, comment)
+		return
+	}
+	c.Assert(snippets, Not(HasLen), 0, comment)
+	for _, snippet := range snippets {
+		for _, s := range exp.Contains {
+			c.Check(snippet, testutil.Contains, s, comment)
+		}
+		for _, s := range exp.NotContains {
+			c.Check(snippet, Not(testutil.Contains), s, comment)
+		}
+	}
+}
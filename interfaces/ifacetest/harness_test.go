@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacetest_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+)
+
+type HarnessSuite struct{}
+
+var _ = Suite(&HarnessSuite{})
+
+func (s *HarnessSuite) TestAttrCombinations(c *C) {
+	combinations := ifacetest.AttrCombinations(map[string][]interface{}{
+		"path": {nil, "/dev/foo"},
+		"mode": {"ro", "rw"},
+	})
+	c.Check(combinations, DeepEquals, []map[string]interface{}{
+		{"mode": "ro"},
+		{"mode": "ro", "path": "/dev/foo"},
+		{"mode": "rw"},
+		{"mode": "rw", "path": "/dev/foo"},
+	})
+}
+
+func (s *HarnessSuite) TestAttrCombinationsEmpty(c *C) {
+	c.Check(ifacetest.AttrCombinations(nil), DeepEquals, []map[string]interface{}{{}})
+	c.Check(ifacetest.AttrCombinations(map[string][]interface{}{"path": nil}), HasLen, 0)
+}
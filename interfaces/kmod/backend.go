@@ -30,6 +30,12 @@
 // corresponding /etc/modules-load.d/ config file gets removed, however no
 // kernel modules are unloaded. This is by design.
 //
+// Interfaces may also set options for kernel modules or disallow them from
+// being loaded altogether. Those settings are stored in
+// /etc/modprobe.d/snap.<snapname>.conf, which is written before the modules
+// are loaded so that the options are in effect. Settings contributed by one
+// snap must not contradict those already contributed by other snaps.
+//
 // Note: this mechanism should not be confused with kernel-module-interface;
 // kmod only loads a well-defined list of modules provided by interface definition
 // and doesn't grant any special permissions related to kernel modules to snaps,
@@ -37,10 +43,13 @@
 package kmod
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/interfaces"
@@ -69,7 +78,8 @@ func (b *Backend) Name() interfaces.SecuritySystem {
 
 // Setup creates a conf file with list of kernel modules required by given snap,
 // writes it in /etc/modules-load.d/ directory and immediately loads the modules
-// using /sbin/modprobe. The devMode is ignored.
+// using /sbin/modprobe. Kernel module options and disallowed modules are
+// written to /etc/modprobe.d/ beforehand. The devMode is ignored.
 //
 // If the method fails it should be re-tried (with a sensible strategy) by the caller.
 func (b *Backend) Setup(snapInfo *snap.Info, confinement interfaces.ConfinementOptions, repo *interfaces.Repository, tm timings.Measurer) error {
@@ -79,21 +89,32 @@ func (b *Backend) Setup(snapInfo *snap.Info, confinement interfaces.ConfinementO
 	if err != nil {
 		return fmt.Errorf("cannot obtain kmod specification for snap %q: %s", snapName, err)
 	}
+	kspec := spec.(*Specification)
 
-	content, modules := deriveContent(spec.(*Specification), snapInfo)
+	if err := checkConflicts(snapName, kspec); err != nil {
+		return err
+	}
+
+	modulesContent, modprobeContent, modules := deriveContent(kspec, snapInfo)
 	// synchronize the content with the filesystem
 	glob := interfaces.SecurityTagGlob(snapName)
-	dir := dirs.SnapKModModulesDir
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("cannot create directory for kmod files %q: %s", dir, err)
+	for _, dir := range []string{dirs.SnapKModModprobeDir, dirs.SnapKModModulesDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("cannot create directory for kmod files %q: %s", dir, err)
+		}
 	}
 
-	changed, _, err := osutil.EnsureDirState(dirs.SnapKModModulesDir, glob, content)
+	// options must be in place before the modules get loaded
+	changedOpts, removedOpts, err := osutil.EnsureDirState(dirs.SnapKModModprobeDir, glob, modprobeContent)
+	if err != nil {
+		return err
+	}
+	changed, _, err := osutil.EnsureDirState(dirs.SnapKModModulesDir, glob, modulesContent)
 	if err != nil {
 		return err
 	}
 
-	if len(changed) > 0 {
+	if len(changed) > 0 || len(changedOpts) > 0 || len(removedOpts) > 0 {
 		b.loadModules(modules)
 	}
 	return nil
@@ -107,31 +128,187 @@ func (b *Backend) Setup(snapInfo *snap.Info, confinement interfaces.ConfinementO
 func (b *Backend) Remove(snapName string) error {
 	glob := interfaces.SecurityTagGlob(snapName)
 	_, _, err := osutil.EnsureDirState(dirs.SnapKModModulesDir, glob, nil)
+	_, _, err1 := osutil.EnsureDirState(dirs.SnapKModModprobeDir, glob, nil)
+	if err == nil {
+		err = err1
+	}
 	return err
 }
 
-func deriveContent(spec *Specification, snapInfo *snap.Info) (map[string]osutil.FileState, []string) {
-	if len(spec.modules) == 0 {
-		return nil, nil
+func deriveContent(spec *Specification, snapInfo *snap.Info) (modulesContent, modprobeContent map[string]osutil.FileState, modules []string) {
+	fileName := fmt.Sprintf("%s.conf", snap.SecurityTag(snapInfo.InstanceName()))
+
+	if len(spec.modules) > 0 {
+		modules = sortedKeys(spec.modules)
+
+		var buffer bytes.Buffer
+		buffer.WriteString("# This file is automatically generated.\n")
+		for _, module := range modules {
+			buffer.WriteString(module)
+			buffer.WriteRune('\n')
+		}
+		modulesContent = map[string]osutil.FileState{
+			fileName: &osutil.MemoryFileState{
+				Content: buffer.Bytes(),
+				Mode:    0644,
+			},
+		}
+	}
+
+	if len(spec.moduleOptions) > 0 || len(spec.disallowedModules) > 0 {
+		var buffer bytes.Buffer
+		buffer.WriteString("# This file is automatically generated.\n")
+		for _, module := range sortedKeys(spec.disallowedModules) {
+			fmt.Fprintf(&buffer, "blacklist %s\n", module)
+		}
+		optModules := make([]string, 0, len(spec.moduleOptions))
+		for module := range spec.moduleOptions {
+			optModules = append(optModules, module)
+		}
+		sort.Strings(optModules)
+		for _, module := range optModules {
+			fmt.Fprintf(&buffer, "options %s %s\n", module, spec.moduleOptions[module])
+		}
+		modprobeContent = map[string]osutil.FileState{
+			fileName: &osutil.MemoryFileState{
+				Content: buffer.Bytes(),
+				Mode:    0644,
+			},
+		}
+	}
+
+	return modulesContent, modprobeContent, modules
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fragment holds the kernel module settings contributed by a single snap.
+type fragment struct {
+	modules    map[string]bool
+	options    map[string]string
+	disallowed map[string]bool
+}
+
+// readFragments reads the kernel module settings currently contributed by all
+// snaps, indexed by snap instance name.
+func readFragments() (map[string]*fragment, error) {
+	fragments := make(map[string]*fragment)
+	get := func(path string) *fragment {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "snap."), ".conf")
+		f := fragments[name]
+		if f == nil {
+			f = &fragment{
+				modules:    make(map[string]bool),
+				options:    make(map[string]string),
+				disallowed: make(map[string]bool),
+			}
+			fragments[name] = f
+		}
+		return f
+	}
+
+	err := forEachFragmentLine(dirs.SnapKModModulesDir, func(path, line string) {
+		get(path).modules[line] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = forEachFragmentLine(dirs.SnapKModModprobeDir, func(path, line string) {
+		fields := strings.SplitN(line, " ", 3)
+		switch {
+		case fields[0] == "blacklist" && len(fields) == 2:
+			get(path).disallowed[fields[1]] = true
+		case fields[0] == "options" && len(fields) == 3:
+			get(path).options[fields[1]] = fields[2]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fragments, nil
+}
+
+func forEachFragmentLine(dir string, f func(path, line string)) error {
+	matches, err := filepath.Glob(filepath.Join(dir, interfaces.SecurityTagGlob("*")))
+	if err != nil {
+		return err
 	}
-	content := make(map[string]osutil.FileState)
-	var modules []string
-	for k := range spec.modules {
-		modules = append(modules, k)
+	for _, path := range matches {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			f(path, line)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read kmod file %q: %v", path, err)
+		}
 	}
-	sort.Strings(modules)
+	return nil
+}
 
-	var buffer bytes.Buffer
-	buffer.WriteString("# This file is automatically generated.\n")
-	for _, module := range modules {
-		buffer.WriteString(module)
-		buffer.WriteRune('\n')
+// checkConflicts verifies that the kernel module settings of the given snap
+// do not contradict the settings already contributed by other snaps.
+func checkConflicts(snapName string, spec *Specification) error {
+	if len(spec.modules) == 0 && len(spec.moduleOptions) == 0 && len(spec.disallowedModules) == 0 {
+		return nil
+	}
+	fragments, err := readFragments()
+	if err != nil {
+		return err
+	}
+	others := make([]string, 0, len(fragments))
+	for name := range fragments {
+		if name != snapName {
+			others = append(others, name)
+		}
 	}
-	content[fmt.Sprintf("%s.conf", snap.SecurityTag(snapInfo.InstanceName()))] = &osutil.MemoryFileState{
-		Content: buffer.Bytes(),
-		Mode:    0644,
+	sort.Strings(others)
+	optModules := make([]string, 0, len(spec.moduleOptions))
+	for module := range spec.moduleOptions {
+		optModules = append(optModules, module)
 	}
-	return content, modules
+	sort.Strings(optModules)
+
+	for _, other := range others {
+		f := fragments[other]
+		for _, module := range sortedKeys(spec.modules) {
+			if f.disallowed[module] {
+				return fmt.Errorf("cannot load kernel module %q for snap %q: module is disallowed by snap %q", module, snapName, other)
+			}
+		}
+		for _, module := range optModules {
+			opts := spec.moduleOptions[module]
+			if f.disallowed[module] {
+				return fmt.Errorf("cannot set options for kernel module %q for snap %q: module is disallowed by snap %q", module, snapName, other)
+			}
+			if otherOpts, ok := f.options[module]; ok && otherOpts != opts {
+				return fmt.Errorf("cannot set options %q for kernel module %q for snap %q: conflicting options %q set by snap %q", opts, module, snapName, otherOpts, other)
+			}
+		}
+		for _, module := range sortedKeys(spec.disallowedModules) {
+			_, hasOpts := f.options[module]
+			if f.modules[module] || hasOpts {
+				return fmt.Errorf("cannot disallow kernel module %q for snap %q: module is used by snap %q", module, snapName, other)
+			}
+		}
+	}
+	return nil
 }
 
 func (b *Backend) NewSpecification() interfaces.Specification {
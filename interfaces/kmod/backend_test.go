@@ -20,6 +20,8 @@
 package kmod_test
 
 import (
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -32,6 +34,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/kmod"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -137,6 +140,112 @@ func (s *backendSuite) TestSecurityIsStable(c *C) {
 	}
 }
 
+func (s *backendSuite) TestInstallingSnapCreatesModprobeConf(c *C) {
+	s.Iface.KModPermanentSlotCallback = func(spec *kmod.Specification, slot *snap.SlotInfo) error {
+		c.Assert(spec.AddModule("module1"), IsNil)
+		c.Assert(spec.SetModuleOptions("module1", "opt=1"), IsNil)
+		c.Assert(spec.SetModuleOptions("module2", "opt=2 other"), IsNil)
+		c.Assert(spec.DisallowModule("module3"), IsNil)
+		return nil
+	}
+
+	modprobePath := filepath.Join(dirs.SnapKModModprobeDir, "snap.samba.conf")
+	modulesPath := filepath.Join(dirs.SnapKModModulesDir, "snap.samba.conf")
+
+	for _, opts := range testedConfinementOpts {
+		s.modprobeCmd.ForgetCalls()
+		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+
+		c.Check(modprobePath, testutil.FileEquals, `# This file is automatically generated.
+blacklist module3
+options module1 opt=1
+options module2 opt=2 other
+`)
+		c.Check(modulesPath, testutil.FileEquals, "# This file is automatically generated.\nmodule1\n")
+		c.Check(s.modprobeCmd.Calls(), DeepEquals, [][]string{
+			{"modprobe", "--syslog", "module1"},
+		})
+
+		s.RemoveSnap(c, snapInfo)
+		c.Check(osutil.FileExists(modprobePath), Equals, false)
+		c.Check(osutil.FileExists(modulesPath), Equals, false)
+	}
+}
+
+func (s *backendSuite) writeOtherSnapConf(c *C, dir, content string) {
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "snap.other.conf"), []byte(content), 0644), IsNil)
+}
+
+func (s *backendSuite) TestSetupConflicts(c *C) {
+	snapInfo := snaptest.MockInfo(c, ifacetest.SambaYamlV1, nil)
+
+	for _, tc := range []struct {
+		setup    func(spec *kmod.Specification) error
+		dir      string
+		existing string
+		err      string
+	}{{
+		setup:    func(spec *kmod.Specification) error { return spec.AddModule("module1") },
+		dir:      dirs.SnapKModModprobeDir,
+		existing: "blacklist module1\n",
+		err:      `cannot load kernel module "module1" for snap "samba": module is disallowed by snap "other"`,
+	}, {
+		setup:    func(spec *kmod.Specification) error { return spec.SetModuleOptions("module1", "opt=1") },
+		dir:      dirs.SnapKModModprobeDir,
+		existing: "# This file is automatically generated.\noptions module1 opt=2\n",
+		err:      `cannot set options "opt=1" for kernel module "module1" for snap "samba": conflicting options "opt=2" set by snap "other"`,
+	}, {
+		setup:    func(spec *kmod.Specification) error { return spec.SetModuleOptions("module1", "opt=1") },
+		dir:      dirs.SnapKModModprobeDir,
+		existing: "blacklist module1\n",
+		err:      `cannot set options for kernel module "module1" for snap "samba": module is disallowed by snap "other"`,
+	}, {
+		setup:    func(spec *kmod.Specification) error { return spec.DisallowModule("module1") },
+		dir:      dirs.SnapKModModulesDir,
+		existing: "module1\n",
+		err:      `cannot disallow kernel module "module1" for snap "samba": module is used by snap "other"`,
+	}, {
+		setup:    func(spec *kmod.Specification) error { return spec.DisallowModule("module1") },
+		dir:      dirs.SnapKModModprobeDir,
+		existing: "options module1 opt=1\n",
+		err:      `cannot disallow kernel module "module1" for snap "samba": module is used by snap "other"`,
+	}} {
+		s.Iface.KModPermanentSlotCallback = func(spec *kmod.Specification, slot *snap.SlotInfo) error {
+			return tc.setup(spec)
+		}
+		s.writeOtherSnapConf(c, tc.dir, tc.existing)
+		c.Assert(s.Repo.AddSnap(snapInfo), IsNil)
+
+		err := s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo, s.meas)
+		c.Check(err, ErrorMatches, tc.err)
+		// nothing was written for the snap
+		c.Check(osutil.FileExists(filepath.Join(dirs.SnapKModModprobeDir, "snap.samba.conf")), Equals, false)
+		c.Check(osutil.FileExists(filepath.Join(dirs.SnapKModModulesDir, "snap.samba.conf")), Equals, false)
+		c.Check(s.modprobeCmd.Calls(), HasLen, 0)
+
+		c.Assert(s.Repo.RemoveSnap("samba"), IsNil)
+		c.Assert(os.Remove(filepath.Join(tc.dir, "snap.other.conf")), IsNil)
+	}
+}
+
+func (s *backendSuite) TestSetupCompatibleWithOtherSnaps(c *C) {
+	s.Iface.KModPermanentSlotCallback = func(spec *kmod.Specification, slot *snap.SlotInfo) error {
+		c.Assert(spec.AddModule("module1"), IsNil)
+		c.Assert(spec.SetModuleOptions("module1", "opt=1"), IsNil)
+		return nil
+	}
+	s.writeOtherSnapConf(c, dirs.SnapKModModulesDir, "module1\n")
+	s.writeOtherSnapConf(c, dirs.SnapKModModprobeDir, "options module1 opt=1\nblacklist module2\n")
+
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 0)
+	c.Check(filepath.Join(dirs.SnapKModModprobeDir, "snap.samba.conf"), testutil.FileEquals,
+		"# This file is automatically generated.\noptions module1 opt=1\n")
+	s.RemoveSnap(c, snapInfo)
+	// the other snap's files are left alone
+	c.Check(filepath.Join(dirs.SnapKModModulesDir, "snap.other.conf"), testutil.FileEquals, "module1\n")
+}
+
 func (s *backendSuite) TestSandboxFeatures(c *C) {
 	c.Assert(s.Backend.SandboxFeatures(), DeepEquals, []string{"mediated-modprobe"})
 }
@@ -20,6 +20,7 @@
 package kmod
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/snapcore/snapd/interfaces"
@@ -33,6 +34,9 @@ import (
 // setup process.
 type Specification struct {
 	modules map[string]bool
+
+	moduleOptions     map[string]string
+	disallowedModules map[string]bool
 }
 
 // AddModule adds a kernel module, trimming spaces and ignoring duplicated modules.
@@ -41,6 +45,9 @@ func (spec *Specification) AddModule(module string) error {
 	if m == "" {
 		return nil
 	}
+	if spec.disallowedModules[m] {
+		return fmt.Errorf("cannot load kernel module %q: module is disallowed", m)
+	}
 	if spec.modules == nil {
 		spec.modules = make(map[string]bool)
 	}
@@ -57,6 +64,66 @@ func (spec *Specification) Modules() map[string]bool {
 	return result
 }
 
+// SetModuleOptions sets the options passed to the given kernel module
+// whenever it gets loaded. Setting different options for the same module is
+// an error.
+func (spec *Specification) SetModuleOptions(module, options string) error {
+	m := strings.TrimSpace(module)
+	opts := strings.TrimSpace(options)
+	if m == "" || opts == "" {
+		return nil
+	}
+	if spec.disallowedModules[m] {
+		return fmt.Errorf("cannot set options for kernel module %q: module is disallowed", m)
+	}
+	if old, ok := spec.moduleOptions[m]; ok && old != opts {
+		return fmt.Errorf("cannot set options for kernel module %q: conflicting options %q and %q", m, old, opts)
+	}
+	if spec.moduleOptions == nil {
+		spec.moduleOptions = make(map[string]string)
+	}
+	spec.moduleOptions[m] = opts
+	return nil
+}
+
+// ModuleOptions returns a copy of the kernel module options set.
+func (spec *Specification) ModuleOptions() map[string]string {
+	result := make(map[string]string, len(spec.moduleOptions))
+	for k, v := range spec.moduleOptions {
+		result[k] = v
+	}
+	return result
+}
+
+// DisallowModule prevents the given kernel module from being loaded by
+// blacklisting it. A module cannot be both loaded and disallowed.
+func (spec *Specification) DisallowModule(module string) error {
+	m := strings.TrimSpace(module)
+	if m == "" {
+		return nil
+	}
+	if spec.modules[m] {
+		return fmt.Errorf("cannot disallow kernel module %q: module is loaded", m)
+	}
+	if _, ok := spec.moduleOptions[m]; ok {
+		return fmt.Errorf("cannot disallow kernel module %q: module has options set", m)
+	}
+	if spec.disallowedModules == nil {
+		spec.disallowedModules = make(map[string]bool)
+	}
+	spec.disallowedModules[m] = true
+	return nil
+}
+
+// DisallowedModules returns a copy of the disallowed kernel module names.
+func (spec *Specification) DisallowedModules() map[string]bool {
+	result := make(map[string]bool, len(spec.disallowedModules))
+	for k, v := range spec.disallowedModules {
+		result[k] = v
+	}
+	return result
+}
+
 // Implementation of methods required by interfaces.Specification
 
 // AddConnectedPlug records kmod-specific side-effects of having a connected plug.
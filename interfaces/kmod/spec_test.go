@@ -127,3 +127,29 @@ func (s *specSuite) TestSpecificationIface(c *C) {
 	c.Assert(s.spec.Modules(), DeepEquals, map[string]bool{
 		"module1": true, "module2": true, "module3": true, "module4": true})
 }
+
+func (s *specSuite) TestModuleOptions(c *C) {
+	c.Assert(s.spec.SetModuleOptions("module1", " opt1=1 opt2 "), IsNil)
+	// setting the same options again is fine
+	c.Assert(s.spec.SetModuleOptions("module1", "opt1=1 opt2"), IsNil)
+	// empty options are ignored
+	c.Assert(s.spec.SetModuleOptions("module2", ""), IsNil)
+	c.Assert(s.spec.ModuleOptions(), DeepEquals, map[string]string{
+		"module1": "opt1=1 opt2",
+	})
+
+	err := s.spec.SetModuleOptions("module1", "opt1=2")
+	c.Assert(err, ErrorMatches, `cannot set options for kernel module "module1": conflicting options "opt1=1 opt2" and "opt1=2"`)
+}
+
+func (s *specSuite) TestDisallowModule(c *C) {
+	c.Assert(s.spec.AddModule("module1"), IsNil)
+	c.Assert(s.spec.SetModuleOptions("module2", "opt=1"), IsNil)
+	c.Assert(s.spec.DisallowModule("module3"), IsNil)
+	c.Assert(s.spec.DisallowedModules(), DeepEquals, map[string]bool{"module3": true})
+
+	c.Check(s.spec.DisallowModule("module1"), ErrorMatches, `cannot disallow kernel module "module1": module is loaded`)
+	c.Check(s.spec.DisallowModule("module2"), ErrorMatches, `cannot disallow kernel module "module2": module has options set`)
+	c.Check(s.spec.AddModule("module3"), ErrorMatches, `cannot load kernel module "module3": module is disallowed`)
+	c.Check(s.spec.SetModuleOptions("module3", "opt=1"), ErrorMatches, `cannot set options for kernel module "module3": module is disallowed`)
+}
@@ -276,6 +276,44 @@ plugs:
 	c.Check(err, NotNil)
 }
 
+func (s *baseDeclSuite) TestAutoConnectionDisplayCompositor(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	for _, iface := range []string{"wayland", "x11"} {
+		comm := Commentf(iface)
+		plugYaml := fmt.Sprintf(`name: plug-snap
+version: 0
+plugs:
+  %s:
+    role: compositor
+`, iface)
+		cand := s.connectCand(c, iface, "", plugYaml)
+		// the session can be handed over to a compositor manually
+		err := cand.Check()
+		c.Check(err, IsNil, comm)
+		_, err = cand.CheckAutoConnect()
+		c.Check(err, ErrorMatches, fmt.Sprintf(`auto-connection denied by slot rule of interface \"%s\"`, iface), comm)
+
+		// but not automatically unless granted by the snap-declaration
+		plugsSlots := fmt.Sprintf(`
+plugs:
+  %s:
+    allow-auto-connection:
+      plug-attributes:
+        role: compositor
+`, iface)
+		cand.PlugSnapDeclaration = s.mockSnapDecl(c, "plug-snap", "plug-snap-id", "pub1", plugsSlots)
+		_, err = cand.CheckAutoConnect()
+		c.Check(err, IsNil, comm)
+
+		// clients are still auto-connected
+		cand = s.connectCand(c, iface, "", "")
+		_, err = cand.CheckAutoConnect()
+		c.Check(err, IsNil, comm)
+	}
+}
+
 func (s *baseDeclSuite) TestAutoConnectionSnapdControl(c *C) {
 	cand := s.connectCand(c, "snapd-control", "", "")
 	_, err := cand.CheckAutoConnect()
@@ -646,7 +684,8 @@ var (
 	}
 
 	restrictedPlugInstallation = map[string][]string{
-		"core-support": {"core"},
+		"core-support":       {"core"},
+		"kernel-module-load": {"gadget", "kernel"},
 	}
 
 	snapTypeMap = map[string]snap.Type{
@@ -706,8 +745,10 @@ func (s *baseDeclSuite) TestPlugInstallation(c *C) {
 		"greengrass-support":    true,
 		"gpio-control":          true,
 		"kernel-module-control": true,
+		"kernel-module-load":    true,
 		"kubernetes-support":    true,
 		"lxd-support":           true,
+		"mount-control":         true,
 		"multipass-support":     true,
 		"packagekit-control":    true,
 		"personal-files":        true,
@@ -938,8 +979,10 @@ func (s *baseDeclSuite) TestSanity(c *C) {
 		"greengrass-support":    true,
 		"gpio-control":          true,
 		"kernel-module-control": true,
+		"kernel-module-load":    true,
 		"kubernetes-support":    true,
 		"lxd-support":           true,
+		"mount-control":         true,
 		"multipass-support":     true,
 		"packagekit-control":    true,
 		"personal-files":        true,
@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package prompting defines the rules recording the decisions taken
+// when a snap is prompted for access by AppArmor.
+package prompting
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/snap/naming"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// Outcome is the decision applied to the requests matching a rule.
+type Outcome string
+
+const (
+	OutcomeAllow Outcome = "allow"
+	OutcomeDeny  Outcome = "deny"
+)
+
+// Lifespan is how long a rule remains in effect.
+type Lifespan string
+
+const (
+	// LifespanForever rules remain in effect until removed.
+	LifespanForever Lifespan = "forever"
+	// LifespanTimespan rules expire after a given duration.
+	LifespanTimespan Lifespan = "timespan"
+)
+
+// interfacePermissions are the permissions that can be prompted for,
+// per interface supporting prompting.
+var interfacePermissions = map[string][]string{
+	"home": {"read", "write", "execute"},
+}
+
+// Rule records the outcome of the requests of a snap, made on behalf
+// of a user, through the given interface and matching the path
+// pattern and permissions.
+type Rule struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	User        uint32    `json:"user"`
+	Snap        string    `json:"snap"`
+	Interface   string    `json:"interface"`
+	PathPattern string    `json:"path-pattern"`
+	Permissions []string  `json:"permissions"`
+	Outcome     Outcome   `json:"outcome"`
+	Lifespan    Lifespan  `json:"lifespan"`
+	// Expiration is only set for rules with a timespan lifespan.
+	Expiration time.Time `json:"expiration,omitempty"`
+}
+
+// Expired returns whether the rule is no longer in effect at the
+// given time.
+func (r *Rule) Expired(now time.Time) bool {
+	return r.Lifespan == LifespanTimespan && !now.Before(r.Expiration)
+}
+
+// Overlaps returns whether the two rules apply to the same requests
+// for at least one permission.
+func (r *Rule) Overlaps(other *Rule) bool {
+	if r.User != other.User || r.Snap != other.Snap || r.Interface != other.Interface || r.PathPattern != other.PathPattern {
+		return false
+	}
+	for _, perm := range r.Permissions {
+		if strutil.ListContains(other.Permissions, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the rule is well formed, its ID and timestamp
+// are not checked as they are assigned when storing it.
+func (r *Rule) Validate() error {
+	if err := naming.ValidateInstance(r.Snap); err != nil {
+		return fmt.Errorf("invalid snap: %v", err)
+	}
+	supported, ok := interfacePermissions[r.Interface]
+	if !ok {
+		return fmt.Errorf("interface %q does not support prompting", r.Interface)
+	}
+	if err := ValidatePathPattern(r.PathPattern); err != nil {
+		return err
+	}
+	if len(r.Permissions) == 0 {
+		return fmt.Errorf("rule must have at least one permission")
+	}
+	for _, perm := range r.Permissions {
+		if !strutil.ListContains(supported, perm) {
+			return fmt.Errorf("invalid permission %q for interface %q, expected one of: %s", perm, r.Interface, strings.Join(supported, ", "))
+		}
+	}
+	switch r.Outcome {
+	case OutcomeAllow, OutcomeDeny:
+	default:
+		return fmt.Errorf("invalid outcome %q", r.Outcome)
+	}
+	switch r.Lifespan {
+	case LifespanForever:
+		if !r.Expiration.IsZero() {
+			return fmt.Errorf("expiration must not be set for a %q lifespan", r.Lifespan)
+		}
+	case LifespanTimespan:
+		if r.Expiration.IsZero() {
+			return fmt.Errorf("expiration must be set for a %q lifespan", r.Lifespan)
+		}
+	default:
+		return fmt.Errorf("invalid lifespan %q", r.Lifespan)
+	}
+	return nil
+}
+
+// ValidatePathPattern checks that the given pattern is an absolute
+// path, where "*" matches any characters except "/" and "**" matches
+// any number of directories.
+func ValidatePathPattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("invalid path pattern %q: must start with '/'", pattern)
+	}
+	if strings.ContainsAny(pattern, "\x00\n[]{}?") {
+		return fmt.Errorf("invalid path pattern %q: contains a reserved character", pattern)
+	}
+	trailing := strings.HasSuffix(pattern, "/") && pattern != "/"
+	clean := path.Clean(pattern)
+	if trailing {
+		clean += "/"
+	}
+	if clean != pattern {
+		return fmt.Errorf("invalid path pattern %q: must be clean", pattern)
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if strings.Contains(elem, "**") && elem != "**" {
+			return fmt.Errorf(`invalid path pattern %q: "**" must be a whole path element`, pattern)
+		}
+	}
+	return nil
+}
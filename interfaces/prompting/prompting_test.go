@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package prompting_test
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type promptingSuite struct{}
+
+var _ = Suite(&promptingSuite{})
+
+func validRule() *prompting.Rule {
+	return &prompting.Rule{
+		User:        1000,
+		Snap:        "foo",
+		Interface:   "home",
+		PathPattern: "/home/test/Documents/**",
+		Permissions: []string{"read", "write"},
+		Outcome:     prompting.OutcomeAllow,
+		Lifespan:    prompting.LifespanForever,
+	}
+}
+
+func (s *promptingSuite) TestValidateHappy(c *C) {
+	c.Check(validRule().Validate(), IsNil)
+
+	r := validRule()
+	r.Lifespan = prompting.LifespanTimespan
+	r.Expiration = time.Now()
+	c.Check(r.Validate(), IsNil)
+}
+
+func (s *promptingSuite) TestValidateErrors(c *C) {
+	for _, t := range []struct {
+		mod func(r *prompting.Rule)
+		err string
+	}{
+		{func(r *prompting.Rule) { r.Snap = "Foo" }, `invalid snap: invalid snap name: "Foo"`},
+		{func(r *prompting.Rule) { r.Interface = "camera" }, `interface "camera" does not support prompting`},
+		{func(r *prompting.Rule) { r.PathPattern = "" }, `invalid path pattern "": must start with '/'`},
+		{func(r *prompting.Rule) { r.Permissions = nil }, `rule must have at least one permission`},
+		{func(r *prompting.Rule) { r.Permissions = []string{"read", "lock"} }, `invalid permission "lock" for interface "home", expected one of: read, write, execute`},
+		{func(r *prompting.Rule) { r.Outcome = "maybe" }, `invalid outcome "maybe"`},
+		{func(r *prompting.Rule) { r.Lifespan = "session" }, `invalid lifespan "session"`},
+		{func(r *prompting.Rule) { r.Expiration = time.Now() }, `expiration must not be set for a "forever" lifespan`},
+		{func(r *prompting.Rule) { r.Lifespan = prompting.LifespanTimespan }, `expiration must be set for a "timespan" lifespan`},
+	} {
+		r := validRule()
+		t.mod(r)
+		c.Check(r.Validate(), ErrorMatches, t.err)
+	}
+}
+
+func (s *promptingSuite) TestValidatePathPattern(c *C) {
+	for _, pattern := range []string{
+		"/",
+		"/home/test/*",
+		"/home/test/**",
+		"/home/test/**/*.txt",
+		"/home/test/Documents/",
+	} {
+		c.Check(prompting.ValidatePathPattern(pattern), IsNil, Commentf(pattern))
+	}
+
+	for _, t := range []struct {
+		pattern string
+		err     string
+	}{
+		{"home/test", `invalid path pattern "home/test": must start with '/'`},
+		{"/home/test/[ab]", `invalid path pattern "/home/test/\[ab\]": contains a reserved character`},
+		{"/home/test/?", `invalid path pattern "/home/test/\?": contains a reserved character`},
+		{"/home//test", `invalid path pattern "/home//test": must be clean`},
+		{"/home/test/../other", `invalid path pattern "/home/test/../other": must be clean`},
+		{"/home/test/a**", `invalid path pattern "/home/test/a\*\*": "\*\*" must be a whole path element`},
+	} {
+		c.Check(prompting.ValidatePathPattern(t.pattern), ErrorMatches, t.err, Commentf(t.pattern))
+	}
+}
+
+func (s *promptingSuite) TestExpired(c *C) {
+	now := time.Now()
+	r := validRule()
+	c.Check(r.Expired(now), Equals, false)
+
+	r.Lifespan = prompting.LifespanTimespan
+	r.Expiration = now.Add(time.Second)
+	c.Check(r.Expired(now), Equals, false)
+	c.Check(r.Expired(r.Expiration), Equals, true)
+}
+
+func (s *promptingSuite) TestOverlaps(c *C) {
+	r := validRule()
+
+	other := validRule()
+	other.Permissions = []string{"write", "execute"}
+	c.Check(r.Overlaps(other), Equals, true)
+
+	other.Permissions = []string{"execute"}
+	c.Check(r.Overlaps(other), Equals, false)
+
+	other = validRule()
+	other.User = 1001
+	c.Check(r.Overlaps(other), Equals, false)
+
+	other = validRule()
+	other.PathPattern = "/home/test/**"
+	c.Check(r.Overlaps(other), Equals, false)
+}
@@ -289,6 +289,30 @@ func (b *Backend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions,
 	return parallelCompile(b.snapSeccomp, changed)
 }
 
+// PreviewSetup returns the seccomp profiles of a given snap which are in
+// place and the ones Setup would write, without writing nor compiling them.
+func (b *Backend) PreviewSetup(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository) (current, proposed map[string][]byte, err error) {
+	snapName := snapInfo.InstanceName()
+	spec, err := repo.SnapSpecification(b.Name(), snapName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot obtain seccomp specification for snap %q: %s", snapName, err)
+	}
+	content, err := b.deriveContent(spec.(*Specification), opts, snapInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot obtain expected security files for snap %q: %s", snapName, err)
+	}
+	proposed, err = interfaces.ArtefactsContent(dirs.SnapSeccompDir, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	glob := interfaces.SecurityTagGlob(snapName) + ".src"
+	current, err = interfaces.ReadArtefacts(dirs.SnapSeccompDir, []string{glob})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read seccomp profiles of snap %q: %s", snapName, err)
+	}
+	return current, proposed, nil
+}
+
 // Remove removes seccomp profiles of a given snap.
 func (b *Backend) Remove(snapName string) error {
 	glob := interfaces.SecurityTagGlob(snapName)
@@ -224,6 +224,26 @@ func (s *backendSuite) TestUpdatingSnapToOneWithMoreApps(c *C) {
 	}
 }
 
+func (s *backendSuite) TestPreviewSetup(c *C) {
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 1)
+	s.snapSeccomp.ForgetCalls()
+	newInfo := snaptest.MockInfo(c, ifacetest.SambaYamlV1WithNmbd, &snap.SideInfo{Revision: snap.R(2)})
+
+	current, proposed, err := s.Backend.(interfaces.SecurityBackendPreview).PreviewSetup(newInfo, interfaces.ConfinementOptions{}, s.Repo)
+	c.Assert(err, IsNil)
+
+	smbdProfile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd.src")
+	nmbdProfile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.nmbd.src")
+	c.Check(current, HasLen, 1)
+	c.Check(current[smbdProfile], NotNil)
+	c.Check(proposed, HasLen, 2)
+	c.Check(string(proposed[smbdProfile]), Equals, string(current[smbdProfile]))
+	c.Check(proposed[nmbdProfile], NotNil)
+	// nothing was written nor compiled
+	c.Check(nmbdProfile, testutil.FileAbsent)
+	c.Check(s.snapSeccomp.Calls(), HasLen, 0)
+}
+
 func (s *backendSuite) TestUpdatingSnapToOneWithHooks(c *C) {
 	for _, opts := range testedConfinementOpts {
 		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
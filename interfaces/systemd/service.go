@@ -29,6 +29,7 @@ type Service struct {
 	Description     string
 	Type            string
 	RemainAfterExit bool
+	ExecStartPre    string
 	ExecStart       string
 	ExecStop        string
 }
@@ -47,6 +48,9 @@ func (s *Service) String() string {
 	if s.RemainAfterExit {
 		buf.WriteString("RemainAfterExit=yes\n")
 	}
+	if s.ExecStartPre != "" {
+		fmt.Fprintf(&buf, "ExecStartPre=%s\n", s.ExecStartPre)
+	}
 	if s.ExecStart != "" {
 		fmt.Fprintf(&buf, "ExecStart=%s\n", s.ExecStart)
 	}
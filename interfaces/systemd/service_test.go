@@ -42,4 +42,6 @@ func (s *serviceSuite) TestString(c *C) {
 	c.Assert(service5.String(), Equals, "[Service]\nExecStop=/bin/true\n\n[Install]\nWantedBy=multi-user.target\n")
 	service6 := systemd.Service{Description: "ohai"}
 	c.Assert(service6.String(), Equals, "[Unit]\nDescription=ohai\n\n[Service]\n\n[Install]\nWantedBy=multi-user.target\n")
+	service7 := systemd.Service{ExecStartPre: "/bin/false", ExecStart: "/bin/true"}
+	c.Assert(service7.String(), Equals, "[Service]\nExecStartPre=/bin/false\nExecStart=/bin/true\n\n[Install]\nWantedBy=multi-user.target\n")
 }
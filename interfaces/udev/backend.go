@@ -70,17 +70,71 @@ func snapRulesFilePath(snapName string) string {
 //
 // If the method fails it should be re-tried (with a sensible strategy) by the caller.
 func (b *Backend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository, tm timings.Measurer) error {
+	changed, subsystemTriggers, err := b.prepareRules(snapInfo, opts, repo)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	// FIXME: somehow detect the interfaces that were disconnected and set
+	// subsystemTriggers appropriately. ATM, it is always going to be empty
+	// on disconnect.
+	return b.reloadRules(subsystemTriggers)
+}
+
+// SetupMany creates udev rules for multiple snaps. The udev database is
+// reloaded and devices are re-triggered at most once for all the snaps,
+// covering the union of the subsystems the snaps need triggered.
+// SetupMany tries to write the rules of all snaps without interrupting on
+// errors, but collects and returns them all.
+//
+// This is useful mainly when setting up many snaps at once, eg. during
+// seeding, where reloading the rules for each snap is slow.
+func (b *Backend) SetupMany(snaps []*snap.Info, confinement func(snapName string) interfaces.ConfinementOptions, repo *interfaces.Repository, tm timings.Measurer) []error {
+	var errors []error
+	var subsystemTriggers []string
+	anyChanged := false
+	for _, snapInfo := range snaps {
+		opts := confinement(snapInfo.InstanceName())
+		changed, triggers, err := b.prepareRules(snapInfo, opts, repo)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("cannot setup udev rules for snap %q: %s", snapInfo.InstanceName(), err))
+			continue
+		}
+		if changed {
+			anyChanged = true
+			subsystemTriggers = append(subsystemTriggers, triggers...)
+		}
+	}
+
+	if anyChanged {
+		var err error
+		timings.Run(tm, "reload-udev-rules[many]", fmt.Sprintf("reload udev rules of %d snaps", len(snaps)), func(nesttm timings.Measurer) {
+			err = b.reloadRules(subsystemTriggers)
+		})
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return errors
+}
+
+// prepareRules writes or removes the udev rules file of the given snap and
+// returns whether it changed, along with the subsystems that need to be
+// triggered for the rules to take effect.
+func (b *Backend) prepareRules(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository) (changed bool, subsystemTriggers []string, err error) {
 	snapName := snapInfo.InstanceName()
 	spec, err := repo.SnapSpecification(b.Name(), snapName)
 	if err != nil {
-		return fmt.Errorf("cannot obtain udev specification for snap %q: %s", snapName, err)
+		return false, nil, fmt.Errorf("cannot obtain udev specification for snap %q: %s", snapName, err)
 	}
 	content := b.deriveContent(spec.(*Specification), snapInfo)
-	subsystemTriggers := spec.(*Specification).TriggeredSubsystems()
+	subsystemTriggers = spec.(*Specification).TriggeredSubsystems()
 
 	dir := dirs.SnapUdevRulesDir
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("cannot create directory for udev rules %q: %s", dir, err)
+		return false, nil, fmt.Errorf("cannot create directory for udev rules %q: %s", dir, err)
 	}
 
 	rulesFilePath := snapRulesFilePath(snapInfo.InstanceName())
@@ -90,14 +144,9 @@ func (b *Backend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions,
 		// content and exists.
 		err = os.Remove(rulesFilePath)
 		if err != nil && !os.IsNotExist(err) {
-			return err
-		} else if err == nil {
-			// FIXME: somehow detect the interfaces that were
-			// disconnected and set subsystemTriggers appropriately.
-			// ATM, it is always going to be empty on disconnect.
-			return b.reloadRules(subsystemTriggers)
+			return false, nil, err
 		}
-		return nil
+		return err == nil, subsystemTriggers, nil
 	}
 
 	var buffer bytes.Buffer
@@ -124,15 +173,11 @@ func (b *Backend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions,
 	// udev rules when not needed.
 	err = osutil.EnsureFileState(rulesFilePath, rulesFileState)
 	if err == osutil.ErrSameState {
-		return nil
+		return false, nil, nil
 	} else if err != nil {
-		return err
+		return false, nil, err
 	}
-
-	// FIXME: somehow detect the interfaces that were disconnected and set
-	// subsystemTriggers appropriately. ATM, it is always going to be empty
-	// on disconnect.
-	return b.reloadRules(subsystemTriggers)
+	return true, subsystemTriggers, nil
 }
 
 // Remove removes udev rules specific to a given snap.
@@ -21,6 +21,7 @@ package udev_test
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 
@@ -517,6 +518,69 @@ func (s *backendSuite) TestInstallingSnapWritesAndLoadsRulesWithInputJoystickSub
 	}
 }
 
+func (s *backendSuite) TestSetupManyReloadsOnce(c *C) {
+	snippet := "dummy"
+	s.Iface.UDevPermanentSlotCallback = func(spec *udev.Specification, slot *snap.SlotInfo) error {
+		spec.TriggerSubsystem("input")
+		spec.TriggerSubsystem("tty")
+		spec.AddSnippet(snippet)
+		return nil
+	}
+	opts := interfaces.ConfinementOptions{}
+	snapInfo1 := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+	snapInfo2 := s.InstallSnap(c, opts, "samba_foo", ifacetest.SambaYamlV1, 0)
+
+	// nothing changed
+	s.udevadmCmd.ForgetCalls()
+	setupManyInterface, ok := s.Backend.(interfaces.SecurityBackendSetupMany)
+	c.Assert(ok, Equals, true)
+	errs := setupManyInterface.SetupMany([]*snap.Info{snapInfo1, snapInfo2}, func(snapName string) interfaces.ConfinementOptions { return opts }, s.Repo, s.meas)
+	c.Assert(errs, HasLen, 0)
+	c.Check(s.udevadmCmd.Calls(), HasLen, 0)
+
+	// the rules of both snaps change, but udev is reloaded and
+	// each subsystem is triggered only once
+	snippet = "other"
+	errs = setupManyInterface.SetupMany([]*snap.Info{snapInfo1, snapInfo2}, func(snapName string) interfaces.ConfinementOptions { return opts }, s.Repo, s.meas)
+	c.Assert(errs, HasLen, 0)
+	c.Check(filepath.Join(dirs.SnapUdevRulesDir, "70-snap.samba.rules"), testutil.FileContains, "other")
+	c.Check(filepath.Join(dirs.SnapUdevRulesDir, "70-snap.samba_foo.rules"), testutil.FileContains, "other")
+	c.Check(s.udevadmCmd.Calls(), DeepEquals, [][]string{
+		{"udevadm", "control", "--reload-rules"},
+		{"udevadm", "trigger", "--subsystem-nomatch=input"},
+		{"udevadm", "trigger", "--subsystem-match=input"},
+		{"udevadm", "trigger", "--subsystem-match=tty"},
+		{"udevadm", "settle", "--timeout=10"},
+	})
+}
+
+func (s *backendSuite) TestSetupManyCollectsErrors(c *C) {
+	s.Iface.UDevPermanentSlotCallback = func(spec *udev.Specification, slot *snap.SlotInfo) error {
+		spec.AddSnippet("dummy")
+		return nil
+	}
+	opts := interfaces.ConfinementOptions{}
+	snapInfo1 := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+	snapInfo2 := s.InstallSnap(c, opts, "samba_foo", ifacetest.SambaYamlV1, 0)
+	c.Assert(os.Remove(filepath.Join(dirs.SnapUdevRulesDir, "70-snap.samba.rules")), IsNil)
+
+	s.Iface.UDevPermanentSlotCallback = func(spec *udev.Specification, slot *snap.SlotInfo) error {
+		if slot.Snap.InstanceName() == "samba_foo" {
+			return errors.New("failed")
+		}
+		spec.AddSnippet("dummy")
+		return nil
+	}
+	s.udevadmCmd.ForgetCalls()
+	setupManyInterface := s.Backend.(interfaces.SecurityBackendSetupMany)
+	errs := setupManyInterface.SetupMany([]*snap.Info{snapInfo1, snapInfo2}, func(snapName string) interfaces.ConfinementOptions { return opts }, s.Repo, s.meas)
+	c.Assert(errs, HasLen, 1)
+	c.Check(errs[0], ErrorMatches, `cannot setup udev rules for snap "samba_foo": cannot obtain udev specification for snap "samba_foo": failed`)
+	// the rules of the other snap were still written and loaded
+	c.Check(filepath.Join(dirs.SnapUdevRulesDir, "70-snap.samba.rules"), testutil.FilePresent)
+	c.Check(s.udevadmCmd.Calls(), HasLen, 4)
+}
+
 func (s *backendSuite) TestSandboxFeatures(c *C) {
 	restore := cgroup.MockVersion(cgroup.V1, nil)
 	defer restore()
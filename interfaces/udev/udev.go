@@ -22,6 +22,8 @@ package udev
 import (
 	"fmt"
 	"os/exec"
+
+	"github.com/snapcore/snapd/strutil"
 )
 
 // reloadRules runs three commands that reload udev rule database.
@@ -57,7 +59,14 @@ func (b *Backend) reloadRules(subsystemTriggers []string) error {
 	// interface disconnect.
 	inputJoystickTriggered := false
 
+	// each subsystem is triggered only once, even when requested by
+	// several interfaces or snaps
+	var triggers strutil.OrderedSet
 	for _, subsystem := range subsystemTriggers {
+		triggers.Put(subsystem)
+	}
+
+	for _, subsystem := range triggers.Items() {
 		if subsystem == "input/joystick" {
 			// If one of the interfaces said it uses the input
 			// subsystem for joysticks, then trigger the joystick
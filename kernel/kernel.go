@@ -40,6 +40,9 @@ type Asset struct {
 
 type Info struct {
 	Assets map[string]*Asset `yaml:"assets,omitempty"`
+	// UnifiedKernelImage is set when kernel.efi is a systemd-stub unified
+	// kernel image which measures its sections to PCR 11.
+	UnifiedKernelImage bool `yaml:"unified-kernel-image,omitempty"`
 }
 
 // XXX: should we be more liberal? start conservative
@@ -96,6 +96,12 @@ func (s *kernelYamlTestSuite) TestInfoFromKernelYamlHappy(c *C) {
 	})
 }
 
+func (s *kernelYamlTestSuite) TestInfoFromKernelYamlUnifiedKernelImage(c *C) {
+	ki, err := kernel.InfoFromKernelYaml([]byte("unified-kernel-image: true\n"))
+	c.Check(err, IsNil)
+	c.Check(ki, DeepEquals, &kernel.Info{UnifiedKernelImage: true})
+}
+
 func (s *kernelYamlTestSuite) TestReadKernelYamlOptional(c *C) {
 	ki, err := kernel.ReadInfo("this-path-does-not-exist")
 	c.Check(err, IsNil)
@@ -22,9 +22,11 @@ package osutil
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 )
 
 // CopyFlag is used to tweak the behaviour of CopyFile
@@ -39,11 +41,16 @@ const (
 	CopyFlagOverwrite
 	// CopyFlagPreserveAll preserves mode,owner,time attributes
 	CopyFlagPreserveAll
+	// CopyFlagReflink shares the data blocks of src with dst when the
+	// filesystem supports it, falling back to a regular copy otherwise
+	CopyFlagReflink
 )
 
 var (
-	openfile = doOpenFile
-	copyfile = doCopyFile
+	openfile       = doOpenFile
+	copyfile       = doCopyFile
+	reflinkfile    = doReflinkFile
+	sharedfilesize = doSharedFileSize
 )
 
 type fileish interface {
@@ -65,7 +72,7 @@ func CopyFile(src, dst string, flags CopyFlag) (err error) {
 		// Our native copy code does not preserve all attributes
 		// (yet). If the user needs this functionatlity we just
 		// fallback to use the system's "cp" binary to do the copy.
-		if err := runCpPreserveAll(src, dst, "copy all"); err != nil {
+		if err := runCpPreserveAll(src, dst, "copy all", flags&CopyFlagReflink != 0); err != nil {
 			return err
 		}
 		if flags&CopyFlagSync != 0 {
@@ -104,8 +111,12 @@ func CopyFile(src, dst string, flags CopyFlag) (err error) {
 		}
 	}()
 
-	if err := copyfile(fin, fout, fi); err != nil {
-		return fmt.Errorf("unable to copy %s to %s: %v", src, dst, err)
+	// a failed reflink leaves dst untouched, so simply fall back to
+	// copying the data
+	if flags&CopyFlagReflink == 0 || reflinkfile(fin, fout) != nil {
+		if err := copyfile(fin, fout, fi); err != nil {
+			return fmt.Errorf("unable to copy %s to %s: %v", src, dst, err)
+		}
 	}
 
 	if flags&CopyFlagSync != 0 {
@@ -182,14 +193,81 @@ func runSync(args ...string) error {
 	return runCmd(exec.Command("sync", args...), "sync")
 }
 
-func runCpPreserveAll(path, dest, errdesc string) error {
-	return runCmd(exec.Command("cp", "-av", path, dest), errdesc)
+func runCpPreserveAll(path, dest, errdesc string, reflink bool) error {
+	args := []string{"-av"}
+	if reflink {
+		args = append(args, "--reflink=auto")
+	}
+	args = append(args, path, dest)
+	return runCmd(exec.Command("cp", args...), errdesc)
+}
+
+// ReflinkSupported returns whether the filesystem holding dir supports
+// sharing data blocks between files, as done by CopyFlagReflink.
+func ReflinkSupported(dir string) bool {
+	src, err := ioutil.TempFile(dir, ".reflink-probe-")
+	if err != nil {
+		return false
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	dst, err := ioutil.TempFile(dir, ".reflink-probe-")
+	if err != nil {
+		return false
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	if _, err := src.Write([]byte{0}); err != nil {
+		return false
+	}
+	return reflinkfile(src, dst) == nil
+}
+
+// SharedDataSize returns the amount of data of the regular files under
+// dir whose data blocks are shared with other files, e.g. because they
+// were copied with CopyFlagReflink, that is the amount of data that
+// did not take additional space. Files with several hard links are
+// only counted once.
+func SharedDataSize(dir string) (size uint64, err error) {
+	type fileID struct{ dev, ino uint64 }
+	seen := make(map[fileID]bool)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+			id := fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		shared, err := sharedfilesize(f)
+		if err != nil {
+			return fmt.Errorf("cannot get the shared data size of %s: %v", path, err)
+		}
+		size += shared
+		return nil
+	})
+	return size, err
 }
 
 // CopySpecialFile is used to copy all the things that are not files
 // (like device nodes, named pipes etc)
 func CopySpecialFile(path, dest string) error {
-	if err := runCpPreserveAll(path, dest, "copy device node"); err != nil {
+	if err := runCpPreserveAll(path, dest, "copy device node", false); err != nil {
 		return err
 	}
 	return runSync(filepath.Dir(dest))
@@ -22,6 +22,7 @@ package osutil
 import (
 	"os"
 	"syscall"
+	"unsafe"
 )
 
 const maxint = int64(^uint(0) >> 1)
@@ -46,3 +47,78 @@ func doCopyFile(fin, fout fileish, fi os.FileInfo) error {
 
 	return nil
 }
+
+// FICLONE from linux/fs.h
+const _FICLONE = 0x40049409
+
+func doReflinkFile(fin, fout fileish) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fout.Fd(), _FICLONE, fin.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// FS_IOC_FIEMAP and the FIEMAP flags from linux/fs.h and linux/fiemap.h
+const (
+	_FS_IOC_FIEMAP = 0xc020660b
+
+	_FIEMAP_FLAG_SYNC     = 0x00000001
+	_FIEMAP_EXTENT_LAST   = 0x00000001
+	_FIEMAP_EXTENT_SHARED = 0x00002000
+
+	fiemapExtentCount = 32
+)
+
+// fiemapExtent is struct fiemap_extent from linux/fiemap.h
+type fiemapExtent struct {
+	logical    uint64
+	physical   uint64
+	length     uint64
+	reserved64 [2]uint64
+	flags      uint32
+	reserved   [3]uint32
+}
+
+// fiemap is struct fiemap from linux/fiemap.h with room for
+// fiemapExtentCount extents
+type fiemap struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	reserved      uint32
+	extents       [fiemapExtentCount]fiemapExtent
+}
+
+// doSharedFileSize returns the length of the extents of the given file
+// which are shared with other files.
+func doSharedFileSize(f fileish) (uint64, error) {
+	var shared uint64
+	var start uint64
+	for {
+		fm := fiemap{
+			start:       start,
+			length:      ^uint64(0),
+			flags:       _FIEMAP_FLAG_SYNC,
+			extentCount: fiemapExtentCount,
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), _FS_IOC_FIEMAP, uintptr(unsafe.Pointer(&fm)))
+		if errno != 0 {
+			return 0, errno
+		}
+		if fm.mappedExtents == 0 {
+			return shared, nil
+		}
+		for _, ext := range fm.extents[:fm.mappedExtents] {
+			if ext.flags&_FIEMAP_EXTENT_SHARED != 0 {
+				shared += ext.length
+			}
+			if ext.flags&_FIEMAP_EXTENT_LAST != 0 {
+				return shared, nil
+			}
+			start = ext.logical + ext.length
+		}
+	}
+}
@@ -21,6 +21,7 @@
 package osutil
 
 import (
+	"errors"
 	"io"
 	"os"
 )
@@ -29,3 +30,11 @@ func doCopyFile(fin, fout fileish, fi os.FileInfo) error {
 	_, err := io.Copy(fout, fin)
 	return err
 }
+
+func doReflinkFile(fin, fout fileish) error {
+	return errors.New("reflinks are not supported")
+}
+
+func doSharedFileSize(f fileish) (uint64, error) {
+	return 0, errors.New("shared extents are not supported")
+}
@@ -84,6 +84,8 @@ func (s *cpSuite) mock() {
 func (s *cpSuite) TearDownTest(c *C) {
 	copyfile = doCopyFile
 	openfile = doOpenFile
+	reflinkfile = doReflinkFile
+	sharedfilesize = doSharedFileSize
 }
 
 func (s *cpSuite) TestCp(c *C) {
@@ -120,6 +122,87 @@ func (s *cpSuite) TestCpSync(c *C) {
 	c.Check(strings.Join(s.log, ":"), Matches, `(.*:)?sync(:.*)?`)
 }
 
+func (s *cpSuite) TestCpReflink(c *C) {
+	reflinkfile = func(fin, fout fileish) error {
+		s.log = append(s.log, "reflink")
+		return nil
+	}
+	c.Check(CopyFile(s.f1, s.f2, CopyFlagReflink), IsNil)
+	c.Check(s.log, DeepEquals, []string{"reflink"})
+	// the mocked reflink does not copy anything and the data was not
+	// copied either
+	c.Check(s.f2, testutil.FileEquals, "")
+}
+
+func (s *cpSuite) TestCpReflinkFallback(c *C) {
+	reflinkfile = func(fin, fout fileish) error {
+		s.log = append(s.log, "reflink")
+		return syscall.EOPNOTSUPP
+	}
+	c.Check(CopyFile(s.f1, s.f2, CopyFlagReflink), IsNil)
+	c.Check(s.log, DeepEquals, []string{"reflink"})
+	c.Check(s.f2, testutil.FileEquals, s.data)
+}
+
+func (s *cpSuite) TestCpNoReflinkByDefault(c *C) {
+	reflinkfile = func(fin, fout fileish) error {
+		c.Fatalf("unexpected reflink")
+		return nil
+	}
+	c.Check(CopyFile(s.f1, s.f2, CopyFlagDefault), IsNil)
+	c.Check(s.f2, testutil.FileEquals, s.data)
+}
+
+func (s *cpSuite) TestReflinkSupported(c *C) {
+	dir := c.MkDir()
+	for _, reflinkErr := range []error{nil, syscall.EXDEV} {
+		reflinkfile = func(fin, fout fileish) error {
+			return reflinkErr
+		}
+		c.Check(ReflinkSupported(dir), Equals, reflinkErr == nil)
+		// the probe files were removed
+		l, err := ioutil.ReadDir(dir)
+		c.Assert(err, IsNil)
+		c.Check(l, HasLen, 0)
+	}
+
+	c.Check(ReflinkSupported(filepath.Join(dir, "missing")), Equals, false)
+}
+
+func (s *cpSuite) TestSharedDataSize(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "sub"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "a"), []byte("aaaa"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "sub/b"), []byte("bb"), 0644), IsNil)
+	// hard links are counted once
+	c.Assert(os.Link(filepath.Join(dir, "sub/b"), filepath.Join(dir, "sub/c")), IsNil)
+	c.Assert(os.Symlink("a", filepath.Join(dir, "d")), IsNil)
+
+	var measured []string
+	sharedfilesize = func(f fileish) (uint64, error) {
+		fi, err := f.Stat()
+		c.Assert(err, IsNil)
+		measured = append(measured, fi.Name())
+		// only part of the data is shared
+		return uint64(fi.Size()) / 2, nil
+	}
+	size, err := SharedDataSize(dir)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, uint64(3))
+	c.Check(measured, DeepEquals, []string{"a", "b"})
+
+	// a missing directory has no shared data
+	size, err = SharedDataSize(filepath.Join(dir, "missing"))
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, uint64(0))
+
+	sharedfilesize = func(f fileish) (uint64, error) {
+		return 0, syscall.EOPNOTSUPP
+	}
+	_, err = SharedDataSize(dir)
+	c.Check(err, ErrorMatches, `cannot get the shared data size of .*/a: operation not supported`)
+}
+
 func (s *cpSuite) TestCpCantOpen(c *C) {
 	s.mock()
 	s.errs = []error{errors.New("xyzzy"), nil}
@@ -263,6 +346,25 @@ func (s *cpSuite) TestCopyPreserveAllSync(c *C) {
 	})
 }
 
+func (s *cpSuite) TestCopyPreserveAllReflink(c *C) {
+	dir := c.MkDir()
+	mocked := testutil.MockCommand(c, "cp", "")
+	defer mocked.Restore()
+
+	src := filepath.Join(dir, "meep")
+	dst := filepath.Join(dir, "copied-meep")
+
+	err := ioutil.WriteFile(src, []byte(nil), 0644)
+	c.Assert(err, IsNil)
+
+	err = CopyFile(src, dst, CopyFlagPreserveAll|CopyFlagReflink)
+	c.Assert(err, IsNil)
+
+	c.Check(mocked.Calls(), DeepEquals, [][]string{
+		{"cp", "-av", "--reflink=auto", src, dst},
+	})
+}
+
 func (s *cpSuite) TestCopyPreserveAllSyncCpFailure(c *C) {
 	dir := c.MkDir()
 	mocked := testutil.MockCommand(c, "cp", "echo OUCH: cp failed.;exit 42").Also("sync", "")
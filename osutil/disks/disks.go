@@ -34,6 +34,7 @@ import (
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/strutil"
 )
 
 var (
@@ -46,6 +47,12 @@ var (
 	// in the name and thus we might accidentally match it
 	// see also the comments in DiskFromMountPoint about this value
 	luksUUIDPatternRe = regexp.MustCompile(`^CRYPT-LUKS2-([0-9a-f]{32})$`)
+
+	// this regexp is for the DM_UUID udev property of the partitions of a
+	// dm-multipath disk as created by kpartx, like
+	// "part1-mpath-3600a098038303877552b495a6f645749", the multipath volume
+	// of the disk itself has "mpath-<wwid>" as DM_UUID
+	multipathPartitionUUIDPatternRe = regexp.MustCompile(`^part[0-9]+-mpath-`)
 )
 
 // diskFromMountPoint is exposed for mocking from other tests via
@@ -78,10 +85,36 @@ type Disk interface {
 	// were encountered, a FilesystemLabelNotFoundError will be returned.
 	FindMatchingPartitionUUID(string) (string, error)
 
+	// FindMatchingPartitionUUIDWithPartLabel finds the partition uuid for a
+	// partition matching the specified GPT partition label on the disk. The
+	// label is encoded like filesystem labels are in
+	// FindMatchingPartitionUUID. If the partition label was not found on the
+	// disk, and no other errors were encountered, a
+	// PartitionLabelNotFoundError will be returned.
+	FindMatchingPartitionUUIDWithPartLabel(string) (string, error)
+
+	// FindMatchingPartitionUUIDWithPartType finds the partition uuid of the
+	// partition with the specified partition type on the disk, like
+	// "c12a7328-f81f-11d2-ba4b-00a0c93ec93b" for the GPT type GUID of an EFI
+	// system partition. The type is matched case-insensitively. If no
+	// partition of that type was found on the disk, and no other errors
+	// were encountered, a PartitionTypeNotFoundError will be returned. It is
+	// an error if more than one partition of the disk has the type.
+	FindMatchingPartitionUUIDWithPartType(string) (string, error)
+
+	// FilesystemTypeOfPartition returns the type of the filesystem on the
+	// partition of the disk with the specified partition uuid, as reported by
+	// udev, for example "crypto_LUKS" or "ext4". It is empty for partitions
+	// without a filesystem. If the partition is not on the disk, and no other
+	// errors were encountered, a PartitionUUIDNotFoundError will be returned.
+	FilesystemTypeOfPartition(string) (string, error)
+
 	// MountPointIsFromDisk returns whether the specified mountpoint corresponds
 	// to a partition on the disk. Note that this only considers partitions
 	// and mountpoints found when the disk was identified with
 	// DiskFromMountPoint.
+	// A mountpoint on a RAID array or a logical volume corresponds to the
+	// disk only if all the disks it is built on are the disk.
 	// TODO:UC20: make this function return what a Disk of where the mount point
 	//            is actually from if it is not from the same disk for better
 	//            error reporting
@@ -94,6 +127,67 @@ type Disk interface {
 	// disk will have partitions, but a mapper device will just be a volume that
 	// does not have partitions for example.
 	HasPartitions() bool
+
+	// Partitions returns all the partitions of the disk, in the order of
+	// their device nodes.
+	Partitions() ([]Partition, error)
+
+	// Stack returns the md RAID arrays and LVM logical volumes, and the
+	// other device mapper volumes in between, that the mount point the disk
+	// was found for is on, from its mount source down to the physical
+	// disks. It is empty if the mount source is a partition of the disk or
+	// the disk itself.
+	Stack() []StackedDevice
+
+	// BackingDisks returns the "major:minor" numbers of all the physical
+	// disks the mount point the disk was found for is on, like the disks of
+	// a RAID1 array. The disk itself is the first one.
+	BackingDisks() []string
+}
+
+// StackedDevice is a block device between the mount source of a mount point
+// and the physical disks it is on, like an md RAID array or an LVM logical
+// volume.
+type StackedDevice struct {
+	// Dev is the "major:minor" number of the device.
+	Dev string
+	// KernelDeviceNode is the device node of the device, like /dev/md0.
+	KernelDeviceNode string
+	// Type is "md" for md RAID arrays, "lvm" for LVM logical volumes,
+	// "crypt" for decrypted devices and "dm" for other device mapper
+	// volumes.
+	Type string
+	// RAIDLevel is the level of md RAID arrays, like "raid1".
+	RAIDLevel string
+	// Slaves are the "major:minor" numbers of the devices the device is
+	// built on, partitions or whole disks, or other stacked devices.
+	Slaves []string
+}
+
+// Partition describes a partition of a disk, as reported by udev and sysfs.
+type Partition struct {
+	// KernelDeviceNode is the device node of the partition, like /dev/vda3.
+	KernelDeviceNode string
+	// PartitionUUID is the partition uuid, in lower case.
+	PartitionUUID string
+	// PartitionLabel is the GPT partition name, encoded like filesystem
+	// labels are in FindMatchingPartitionUUID. It is empty for MBR
+	// partitions.
+	PartitionLabel string
+	// PartitionType is the GPT partition type GUID, or the MBR partition
+	// type number, like 0xc.
+	PartitionType string
+	// FilesystemLabel is the label of the filesystem on the partition,
+	// encoded like PartitionLabel.
+	FilesystemLabel string
+	// FilesystemType is the type of the filesystem on the partition, like
+	// "ext4". It is empty for partitions without a filesystem.
+	FilesystemType string
+	// StartInBytes is the offset of the partition from the start of the
+	// disk.
+	StartInBytes uint64
+	// SizeInBytes is the size of the partition.
+	SizeInBytes uint64
 }
 
 func parseDeviceMajorMinor(s string) (int, int, error) {
@@ -154,13 +248,33 @@ func DiskFromMountPoint(mountpoint string, opts *Options) (Disk, error) {
 type disk struct {
 	major int
 	minor int
+	// partitions are all the partitions of the disk, the maps below are
+	// built from them
+	partitions []Partition
 	// fsLabelToPartUUID is a map of filesystem label -> partition uuid for now
 	// eventually this may be expanded to be more generally useful
 	fsLabelToPartUUID map[string]string
+	// partLabelToPartUUID is a map of partition label -> partition uuid
+	partLabelToPartUUID map[string]string
+	// partUUIDToFsType is a map of partition uuid -> filesystem type for
+	// all partitions of the disk
+	partUUIDToFsType map[string]string
+	// partUUIDToPartType is a map of partition uuid -> partition type for
+	// all partitions of the disk
+	partUUIDToPartType map[string]string
 
 	// whether the disk device has partitions, and thus is of type "disk", or
 	// whether the disk device is a volume that is not a physical disk
 	hasPartitions bool
+	// whether the disk is a dm-multipath volume, whose partitions are
+	// device mapper volumes on top of it
+	multipath bool
+
+	// stack are the md RAID arrays and LVM logical volumes the mount
+	// point is on, if any, with backingDisks the "major:minor" numbers of
+	// all the physical disks they are built on, the disk being the first
+	stack        []StackedDevice
+	backingDisks []string
 }
 
 // diskFromMountPointImpl returns a Disk for the underlying mount source of the
@@ -267,26 +381,41 @@ func diskFromMountPointImpl(mountpoint string, opts *Options) (*disk, error) {
 		// ae6e79de00a9406f80ee64ba7c1966bb but we want it to be like:
 		// ae6e79de-00a9-406f-80ee-64ba7c1966bb so we need to add in 4 "-"
 		// characters
-		compactUUID := string(matches[1])
-		canonicalUUID := fmt.Sprintf(
-			"%s-%s-%s-%s-%s",
-			compactUUID[0:8],
-			compactUUID[8:12],
-			compactUUID[12:16],
-			compactUUID[16:20],
-			compactUUID[20:],
-		)
-
+		//
 		// now finally, we need to use this uuid, which is the device uuid of
 		// the actual physical encrypted partition to get the path, which will
 		// be something like /dev/vda4, etc.
-		byUUIDPath := filepath.Join("/dev/disk/by-uuid", canonicalUUID)
+		byUUIDPath := filepath.Join("/dev/disk/by-uuid", canonicalUUID(string(matches[1])))
 		props, err = udevProperties(byUUIDPath)
 		if err != nil {
 			return nil, fmt.Errorf("cannot get udev properties for encrypted partition %s: %v", byUUIDPath, err)
 		}
 	}
 
+	// md RAID arrays and LVM logical volumes are built on partitions of
+	// possibly several disks, they are found by following the devices
+	// they are built on, partitions of md arrays are handled below
+	if isStackedDevice(props) {
+		if err := d.resolveStack(props); err != nil {
+			return nil, fmt.Errorf("cannot find disk for %s: %v", partMountPointSource, err)
+		}
+		return d, nil
+	}
+
+	// partitions of dm-multipath disks are device mapper volumes, the disk
+	// they are from is the multipath volume they are built on
+	if multipathPartitionUUIDPatternRe.MatchString(props["DM_UUID"]) {
+		maj, min, err := multipathDiskOfPartition(props)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find multipath disk for partition %s: %v", partMountPointSource, err)
+		}
+		d.major = maj
+		d.minor = min
+		d.hasPartitions = true
+		d.multipath = true
+		return d, nil
+	}
+
 	// ID_PART_ENTRY_DISK will give us the major and minor of the disk that this
 	// partition originated from
 	if majorMinor, ok := props["ID_PART_ENTRY_DISK"]; ok {
@@ -318,6 +447,181 @@ func diskFromMountPointImpl(mountpoint string, opts *Options) (*disk, error) {
 	return nil, fmt.Errorf("cannot find disk for partition %s, incomplete udev output", partMountPointSource)
 }
 
+// multipathDiskOfPartition returns the major and minor numbers of the
+// multipath volume the partition with the given udev properties is built on,
+// which is its only slave in sysfs.
+func multipathDiskOfPartition(props map[string]string) (int, int, error) {
+	if props["MAJOR"] == "" || props["MINOR"] == "" {
+		return 0, 0, fmt.Errorf("incomplete udev output")
+	}
+	partDev := props["MAJOR"] + ":" + props["MINOR"]
+	slaves, err := ioutil.ReadDir(filepath.Join(dirs.SysfsDir, "dev", "block", partDev, "slaves"))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(slaves) != 1 {
+		return 0, 0, fmt.Errorf("expected a single slave of device %s, found %d", partDev, len(slaves))
+	}
+	devNum, err := ioutil.ReadFile(filepath.Join(dirs.SysfsDir, "class", "block", slaves[0].Name(), "dev"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseDeviceMajorMinor(strings.TrimSpace(string(devNum)))
+}
+
+// isStackedDevice returns whether the device with the given udev properties
+// is an md RAID array or an LVM logical volume.
+func isStackedDevice(props map[string]string) bool {
+	if props["MD_LEVEL"] != "" && props["ID_PART_ENTRY_DISK"] == "" {
+		return true
+	}
+	return strings.HasPrefix(props["DM_UUID"], "LVM-")
+}
+
+// stackedDeviceType returns the type of the stacked device with the given
+// sysfs directory, and its RAID level for md arrays.
+func stackedDeviceType(sysfsDir string) (typ, raidLevel string) {
+	if level, err := ioutil.ReadFile(filepath.Join(sysfsDir, "md", "level")); err == nil {
+		return "md", strings.TrimSpace(string(level))
+	}
+	dmUUID, err := ioutil.ReadFile(filepath.Join(sysfsDir, "dm", "uuid"))
+	if err != nil {
+		return "", ""
+	}
+	switch {
+	case bytes.HasPrefix(dmUUID, []byte("LVM-")):
+		return "lvm", ""
+	case bytes.HasPrefix(dmUUID, []byte("CRYPT-")):
+		return "crypt", ""
+	case bytes.HasPrefix(dmUUID, []byte("mpath-")):
+		return "multipath", ""
+	}
+	if multipathPartitionUUIDPatternRe.Match(dmUUID) {
+		return "multipath-partition", ""
+	}
+	return "dm", ""
+}
+
+// hasSlaves returns whether the device with the given sysfs directory is
+// built on other devices.
+func hasSlaves(sysfsDir string) bool {
+	slaves, _ := filepath.Glob(filepath.Join(sysfsDir, "slaves", "*"))
+	return len(slaves) > 0
+}
+
+// resolveStack sets the disk to the first of the physical disks the md RAID
+// array or LVM logical volume with the given udev properties is built on,
+// recording the devices in between and all the disks.
+func (d *disk) resolveStack(props map[string]string) error {
+	if props["MAJOR"] == "" || props["MINOR"] == "" {
+		return fmt.Errorf("incomplete udev output")
+	}
+
+	var diskDevs []string
+	var onPartitions, onDisks bool
+	addDisk := func(dev string, partition bool) {
+		if partition {
+			onPartitions = true
+		} else {
+			onDisks = true
+		}
+		if !strutil.ListContains(diskDevs, dev) {
+			diskDevs = append(diskDevs, dev)
+		}
+	}
+
+	var stack []StackedDevice
+	var walk func(sysfsDir, dev, devNode string) error
+	walk = func(sysfsDir, dev, devNode string) error {
+		slaveDirs, err := filepath.Glob(filepath.Join(sysfsDir, "slaves", "*"))
+		if err != nil {
+			return fmt.Errorf("internal error getting slaves of device %s: %v", dev, err)
+		}
+		if len(slaveDirs) == 0 {
+			return fmt.Errorf("cannot find the devices %s is built on", dev)
+		}
+		sort.Strings(slaveDirs)
+
+		typ, raidLevel := stackedDeviceType(sysfsDir)
+		idx := len(stack)
+		stack = append(stack, StackedDevice{
+			Dev:              dev,
+			KernelDeviceNode: devNode,
+			Type:             typ,
+			RAIDLevel:        raidLevel,
+		})
+
+		var slaves []string
+		for _, slaveDir := range slaveDirs {
+			name := filepath.Base(slaveDir)
+			slaveSysfsDir := filepath.Join(dirs.SysfsDir, "class", "block", name)
+			devNum, err := ioutil.ReadFile(filepath.Join(slaveSysfsDir, "dev"))
+			if err != nil {
+				return err
+			}
+			slaveDev := strings.TrimSpace(string(devNum))
+			slaves = append(slaves, slaveDev)
+
+			slaveType, _ := stackedDeviceType(slaveSysfsDir)
+			switch {
+			case osutil.FileExists(filepath.Join(slaveSysfsDir, "partition")):
+				slaveProps, err := udevProperties(name)
+				if err != nil {
+					return err
+				}
+				diskDev := slaveProps["ID_PART_ENTRY_DISK"]
+				if diskDev == "" {
+					return fmt.Errorf("cannot find disk of partition %s, missing udev property \"ID_PART_ENTRY_DISK\"", name)
+				}
+				addDisk(diskDev, true)
+			case slaveType == "multipath-partition":
+				slaveProps, err := udevProperties(name)
+				if err != nil {
+					return err
+				}
+				maj, min, err := multipathDiskOfPartition(slaveProps)
+				if err != nil {
+					return fmt.Errorf("cannot find multipath disk of partition %s: %v", name, err)
+				}
+				addDisk(fmt.Sprintf("%d:%d", maj, min), true)
+			case slaveType == "multipath" || !hasSlaves(slaveSysfsDir):
+				// a whole disk, multipath volumes are built on the
+				// paths to the same disk
+				addDisk(slaveDev, false)
+			default:
+				if err := walk(slaveSysfsDir, slaveDev, filepath.Join("/dev", name)); err != nil {
+					return err
+				}
+			}
+		}
+		stack[idx].Slaves = slaves
+		return nil
+	}
+
+	dev := props["MAJOR"] + ":" + props["MINOR"]
+	devNode := props["DEVNAME"]
+	if devNode == "" {
+		devNode = filepath.Join("/dev/block", dev)
+	}
+	if err := walk(filepath.Join(dirs.SysfsDir, "dev", "block", dev), dev, devNode); err != nil {
+		return err
+	}
+	if onPartitions && onDisks {
+		return fmt.Errorf("device %s is built on both partitions and whole disks", dev)
+	}
+
+	maj, min, err := parseDeviceMajorMinor(diskDevs[0])
+	if err != nil {
+		return err
+	}
+	d.major = maj
+	d.minor = min
+	d.hasPartitions = onPartitions
+	d.stack = stack
+	d.backingDisks = diskDevs
+	return nil
+}
+
 // FilesystemLabelNotFoundError is an error where the specified label was not
 // found on the disk.
 type FilesystemLabelNotFoundError struct {
@@ -332,94 +636,290 @@ func (e FilesystemLabelNotFoundError) Error() string {
 	return fmt.Sprintf("filesystem label %q not found", e.Label)
 }
 
-func (d *disk) FindMatchingPartitionUUID(label string) (string, error) {
-	encodedLabel := BlkIDEncodeLabel(label)
-	// if we haven't found the partitions for this disk yet, do that now
-	if d.fsLabelToPartUUID == nil {
-		d.fsLabelToPartUUID = make(map[string]string)
+// PartitionLabelNotFoundError is an error where the specified partition label
+// was not found on the disk.
+type PartitionLabelNotFoundError struct {
+	Label string
+}
 
-		// step 1. find the devpath for the disk, then glob for matching
-		//         devices using the devname in that sysfs directory
-		// step 2. iterate over all those devices and save all the ones that are
-		//         partitions using the partition sysfs file
-		// step 3. for all partition devices found, query udev to get the fs
-		//         label and partition uuid
+var (
+	_ = error(PartitionLabelNotFoundError{})
+)
 
-		udevProps, err := udevProperties(filepath.Join("/dev/block", d.Dev()))
-		if err != nil {
-			return "", err
+func (e PartitionLabelNotFoundError) Error() string {
+	return fmt.Sprintf("partition label %q not found", e.Label)
+}
+
+// PartitionUUIDNotFoundError is an error where the specified partition uuid
+// was not found on the disk.
+type PartitionUUIDNotFoundError struct {
+	PartUUID string
+}
+
+var (
+	_ = error(PartitionUUIDNotFoundError{})
+)
+
+func (e PartitionUUIDNotFoundError) Error() string {
+	return fmt.Sprintf("partition uuid %q not found", e.PartUUID)
+}
+
+// PartitionTypeNotFoundError is an error where no partition with the
+// specified partition type was found on the disk.
+type PartitionTypeNotFoundError struct {
+	PartType string
+}
+
+var (
+	_ = error(PartitionTypeNotFoundError{})
+)
+
+func (e PartitionTypeNotFoundError) Error() string {
+	return fmt.Sprintf("partition type %q not found", e.PartType)
+}
+
+// findPartitionUUIDWithPartType returns the only partition uuid of the given
+// partition type in the map of partition uuid -> partition type.
+func findPartitionUUIDWithPartType(partUUIDToPartType map[string]string, partType string) (string, error) {
+	var found []string
+	for partUUID, typ := range partUUIDToPartType {
+		if strings.EqualFold(typ, partType) {
+			found = append(found, partUUID)
 		}
+	}
+	switch len(found) {
+	case 0:
+		return "", PartitionTypeNotFoundError{PartType: partType}
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("multiple partitions with partition type %q found: %s", partType, strings.Join(found, ", "))
+}
 
-		// get the base device name
-		devName := udevProps["DEVNAME"]
-		if devName == "" {
-			return "", fmt.Errorf("cannot get udev properties for device %s, missing udev property \"DEVNAME\"", d.Dev())
+// readSysfsSectors reads a number of 512 byte sectors from a sysfs file.
+func readSysfsSectors(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// populatePartitions finds the partitions of the disk if that was not done
+// yet.
+func (d *disk) populatePartitions() error {
+	if d.partitions != nil {
+		return nil
+	}
+
+	var partitions []Partition
+	var err error
+	if d.multipath {
+		partitions, err = d.multipathPartitions()
+	} else {
+		partitions, err = d.sysfsPartitions()
+	}
+	if err != nil {
+		return err
+	}
+
+	fsLabelToPartUUID := make(map[string]string)
+	partLabelToPartUUID := make(map[string]string)
+	partUUIDToFsType := make(map[string]string)
+	partUUIDToPartType := make(map[string]string)
+	for _, p := range partitions {
+		partUUIDToFsType[p.PartitionUUID] = p.FilesystemType
+		if p.PartitionType != "" {
+			partUUIDToPartType[p.PartitionUUID] = p.PartitionType
+		}
+		if p.PartitionLabel != "" {
+			partLabelToPartUUID[p.PartitionLabel] = p.PartitionUUID
 		}
-		// the DEVNAME as returned by udev includes the /dev/mmcblk0 path, we
-		// just want mmcblk0 for example
-		devName = filepath.Base(devName)
-
-		// get the device path in sysfs
-		devPath := udevProps["DEVPATH"]
-		if devPath == "" {
-			return "", fmt.Errorf("cannot get udev properties for device %s, missing udev property \"DEVPATH\"", d.Dev())
+
+		if p.FilesystemLabel == "" {
+			// it is valid for there to be a partition without a fs
+			// label - such as the bios-boot partition on amd64 pc
+			// gadget systems
+			// in this case just skip this, since we are only matching
+			// by filesystem labels, obviously we cannot ever match to
+			// a partition which does not have a filesystem
+			continue
 		}
 
-		// glob for /sys/${devPath}/${devName}*
-		paths, err := filepath.Glob(filepath.Join(dirs.SysfsDir, devPath, devName+"*"))
+		// we always overwrite the fsLabelEnc with the last one, this
+		// has the result that the last partition with a given
+		// filesystem label will be set/found
+		// this matches what udev does with the symlinks in /dev
+		fsLabelToPartUUID[p.FilesystemLabel] = p.PartitionUUID
+	}
+
+	d.partitions = partitions
+	d.fsLabelToPartUUID = fsLabelToPartUUID
+	d.partLabelToPartUUID = partLabelToPartUUID
+	d.partUUIDToFsType = partUUIDToFsType
+	d.partUUIDToPartType = partUUIDToPartType
+	return nil
+}
+
+// partitionFromUdevProps returns the description of the partition with the
+// given udev properties, its start and size are in 512 byte sectors.
+func (d *disk) partitionFromUdevProps(partDev string, udevProps map[string]string, start, size uint64) (Partition, error) {
+	partUUID := udevProps["ID_PART_ENTRY_UUID"]
+	if partUUID == "" {
+		return Partition{}, fmt.Errorf("cannot get udev properties for device %s (a partition of %s), missing udev property \"ID_PART_ENTRY_UUID\"", partDev, d.Dev())
+	}
+
+	devNode := udevProps["DEVNAME"]
+	if devNode == "" {
+		devNode = filepath.Join("/dev", partDev)
+	}
+
+	return Partition{
+		KernelDeviceNode: devNode,
+		PartitionUUID:    partUUID,
+		// the partition name is only set for GPT partitions, udev
+		// encodes it like filesystem labels
+		PartitionLabel: udevProps["ID_PART_ENTRY_NAME"],
+		// the type GUID for GPT partitions, or the type number for MBR
+		// ones, like 0xc
+		PartitionType:   udevProps["ID_PART_ENTRY_TYPE"],
+		FilesystemLabel: udevProps["ID_FS_LABEL_ENC"],
+		FilesystemType:  udevProps["ID_FS_TYPE"],
+		StartInBytes:    start * 512,
+		SizeInBytes:     size * 512,
+	}, nil
+}
+
+// sysfsPartitions returns the partitions of a disk, which are found as sub
+// devices of the disk in sysfs.
+func (d *disk) sysfsPartitions() ([]Partition, error) {
+	// step 1. find the devpath for the disk, then glob for matching
+	//         devices using the devname in that sysfs directory
+	// step 2. iterate over all those devices and save all the ones that are
+	//         partitions using the partition sysfs file
+	// step 3. for all partition devices found, query udev to get the fs
+	//         label, the partition label and the partition uuid, and sysfs
+	//         to get their start and size
+
+	udevProps, err := udevProperties(filepath.Join("/dev/block", d.Dev()))
+	if err != nil {
+		return nil, err
+	}
+
+	// get the base device name
+	devName := udevProps["DEVNAME"]
+	if devName == "" {
+		return nil, fmt.Errorf("cannot get udev properties for device %s, missing udev property \"DEVNAME\"", d.Dev())
+	}
+	// the DEVNAME as returned by udev includes the /dev/mmcblk0 path, we
+	// just want mmcblk0 for example
+	devName = filepath.Base(devName)
+
+	// get the device path in sysfs
+	devPath := udevProps["DEVPATH"]
+	if devPath == "" {
+		return nil, fmt.Errorf("cannot get udev properties for device %s, missing udev property \"DEVPATH\"", d.Dev())
+	}
+
+	// glob for /sys/${devPath}/${devName}*
+	paths, err := filepath.Glob(filepath.Join(dirs.SysfsDir, devPath, devName+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("internal error getting udev properties for device %s: %v", err, d.Dev())
+	}
+
+	// Glob does not sort, so sort manually to have consistent tests
+	sort.Strings(paths)
+
+	partitions := []Partition{}
+	for _, path := range paths {
+		// check if this device is a partition - note that the mere
+		// existence of this file is sufficient to indicate that it is a
+		// partition, the file is the partition number of the device, it
+		// will be absent for pseudo sub-devices, such as the
+		// /dev/mmcblk0boot0 disk device on the dragonboard which exists
+		// under the /dev/mmcblk0 disk, but is not a partition and is
+		// instead a proper disk
+		_, err := ioutil.ReadFile(filepath.Join(path, "partition"))
 		if err != nil {
-			return "", fmt.Errorf("internal error getting udev properties for device %s: %v", err, d.Dev())
+			continue
 		}
 
-		// Glob does not sort, so sort manually to have consistent tests
-		sort.Strings(paths)
-
-		for _, path := range paths {
-			// check if this device is a partition - note that the mere
-			// existence of this file is sufficient to indicate that it is a
-			// partition, the file is the partition number of the device, it
-			// will be absent for pseudo sub-devices, such as the
-			// /dev/mmcblk0boot0 disk device on the dragonboard which exists
-			// under the /dev/mmcblk0 disk, but is not a partition and is
-			// instead a proper disk
-			_, err := ioutil.ReadFile(filepath.Join(path, "partition"))
-			if err != nil {
-				continue
-			}
+		// then the device is a partition, get the udev props for it
+		partDev := filepath.Base(path)
+		udevProps, err := udevProperties(partDev)
+		if err != nil {
+			continue
+		}
 
-			// then the device is a partition, get the udev props for it
-			partDev := filepath.Base(path)
-			udevProps, err := udevProperties(partDev)
-			if err != nil {
-				continue
-			}
+		// the start and size of partitions in sysfs are always in
+		// 512 byte sectors, whatever the sector size of the disk
+		start, err := readSysfsSectors(filepath.Join(path, "start"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot get start of partition %s of %s: %v", partDev, d.Dev(), err)
+		}
+		size, err := readSysfsSectors(filepath.Join(path, "size"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot get size of partition %s of %s: %v", partDev, d.Dev(), err)
+		}
 
-			partUUID := udevProps["ID_PART_ENTRY_UUID"]
-			if partUUID == "" {
-				return "", fmt.Errorf("cannot get udev properties for device %s (a partition of %s), missing udev property \"ID_PART_ENTRY_UUID\"", partDev, d.Dev())
-			}
+		part, err := d.partitionFromUdevProps(partDev, udevProps, start, size)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, part)
+	}
+	return partitions, nil
+}
 
-			fsLabelEnc := udevProps["ID_FS_LABEL_ENC"]
-			if fsLabelEnc == "" {
-				// it is valid for there to be a partition without a fs
-				// label - such as the bios-boot partition on amd64 pc
-				// gadget systems
-				// in this case just skip this, since we are only matching
-				// by filesystem labels, obviously we cannot ever match to
-				// a partition which does not have a filesystem
-				continue
-			}
+// multipathPartitions returns the partitions of a dm-multipath disk, which
+// are device mapper volumes created by kpartx on top of the multipath volume
+// of the disk and thus found as its holders in sysfs.
+func (d *disk) multipathPartitions() ([]Partition, error) {
+	paths, err := filepath.Glob(filepath.Join(dirs.SysfsDir, "dev", "block", d.Dev(), "holders", "*"))
+	if err != nil {
+		return nil, fmt.Errorf("internal error getting holders of device %s: %v", d.Dev(), err)
+	}
+	sort.Strings(paths)
 
-			// TODO: maybe save ID_PART_ENTRY_NAME here too, which is the name
-			//       of the partition. this may be useful if this function gets
-			//       used in the gadget update code
+	partitions := []Partition{}
+	for _, path := range paths {
+		partDev := filepath.Base(path)
+		udevProps, err := udevProperties(partDev)
+		if err != nil {
+			continue
+		}
+		// other device mapper volumes may be built on top of the
+		// multipath volume, like LVM ones when it is not partitioned
+		if !multipathPartitionUUIDPatternRe.MatchString(udevProps["DM_UUID"]) {
+			continue
+		}
 
-			// we always overwrite the fsLabelEnc with the last one, this
-			// has the result that the last partition with a given
-			// filesystem label will be set/found
-			// this matches what udev does with the symlinks in /dev
-			d.fsLabelToPartUUID[fsLabelEnc] = partUUID
+		// device mapper volumes have no start in sysfs, use the
+		// offset found by blkid, it is in 512 byte sectors too
+		start, err := strconv.ParseUint(udevProps["ID_PART_ENTRY_OFFSET"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get start of partition %s of %s: invalid udev property \"ID_PART_ENTRY_OFFSET\": %q", partDev, d.Dev(), udevProps["ID_PART_ENTRY_OFFSET"])
 		}
+		size, err := readSysfsSectors(filepath.Join(path, "size"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot get size of partition %s of %s: %v", partDev, d.Dev(), err)
+		}
+
+		part, err := d.partitionFromUdevProps(partDev, udevProps, start, size)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, part)
+	}
+	return partitions, nil
+}
+
+func (d *disk) FindMatchingPartitionUUID(label string) (string, error) {
+	encodedLabel := BlkIDEncodeLabel(label)
+	// if we haven't found the partitions for this disk yet, do that now
+	if err := d.populatePartitions(); err != nil {
+		return "", err
 	}
 
 	// if we didn't find any partitions from above then return an error
@@ -434,18 +934,73 @@ func (d *disk) FindMatchingPartitionUUID(label string) (string, error) {
 	return "", FilesystemLabelNotFoundError{Label: label}
 }
 
+func (d *disk) FindMatchingPartitionUUIDWithPartLabel(label string) (string, error) {
+	encodedLabel := BlkIDEncodeLabel(label)
+	if err := d.populatePartitions(); err != nil {
+		return "", err
+	}
+
+	if len(d.partUUIDToFsType) == 0 {
+		return "", fmt.Errorf("no partitions found for disk %s", d.Dev())
+	}
+
+	if partuuid, ok := d.partLabelToPartUUID[encodedLabel]; ok {
+		return partuuid, nil
+	}
+
+	return "", PartitionLabelNotFoundError{Label: label}
+}
+
+func (d *disk) FindMatchingPartitionUUIDWithPartType(partType string) (string, error) {
+	if err := d.populatePartitions(); err != nil {
+		return "", err
+	}
+
+	if len(d.partUUIDToFsType) == 0 {
+		return "", fmt.Errorf("no partitions found for disk %s", d.Dev())
+	}
+
+	return findPartitionUUIDWithPartType(d.partUUIDToPartType, partType)
+}
+
+func (d *disk) FilesystemTypeOfPartition(partUUID string) (string, error) {
+	if err := d.populatePartitions(); err != nil {
+		return "", err
+	}
+
+	if len(d.partUUIDToFsType) == 0 {
+		return "", fmt.Errorf("no partitions found for disk %s", d.Dev())
+	}
+
+	// partition uuids are reported in lower case by udev
+	if fsType, ok := d.partUUIDToFsType[strings.ToLower(partUUID)]; ok {
+		return fsType, nil
+	}
+
+	return "", PartitionUUIDNotFoundError{PartUUID: partUUID}
+}
+
 func (d *disk) MountPointIsFromDisk(mountpoint string, opts *Options) (bool, error) {
 	d2, err := diskFromMountPointImpl(mountpoint, opts)
 	if err != nil {
 		return false, err
 	}
 
-	// compare if the major/minor devices are the same and if both devices have
-	// partitions
-	return d.major == d2.major &&
-			d.minor == d2.minor &&
-			d.hasPartitions == d2.hasPartitions,
-		nil
+	// compare if the major/minor devices are the same, for a mount point
+	// on a RAID array or a logical volume all the disks it is built on
+	// must be the disk, and if both devices have partitions
+	return onlyBackedBy(d2, d.Dev()) && d.hasPartitions == d2.hasPartitions, nil
+}
+
+// onlyBackedBy returns whether all the backing disks of the disk are the
+// given one.
+func onlyBackedBy(d Disk, dev string) bool {
+	for _, backing := range d.BackingDisks() {
+		if backing != dev {
+			return false
+		}
+	}
+	return true
 }
 
 func (d *disk) Dev() string {
@@ -455,3 +1010,26 @@ func (d *disk) Dev() string {
 func (d *disk) HasPartitions() bool {
 	return d.hasPartitions
 }
+
+func (d *disk) Partitions() ([]Partition, error) {
+	if err := d.populatePartitions(); err != nil {
+		return nil, err
+	}
+
+	if len(d.partitions) == 0 {
+		return nil, fmt.Errorf("no partitions found for disk %s", d.Dev())
+	}
+
+	return append([]Partition(nil), d.partitions...), nil
+}
+
+func (d *disk) Stack() []StackedDevice {
+	return append([]StackedDevice(nil), d.stack...)
+}
+
+func (d *disk) BackingDisks() []string {
+	if len(d.backingDisks) == 0 {
+		return []string{d.Dev()}
+	}
+	return append([]string(nil), d.backingDisks...)
+}
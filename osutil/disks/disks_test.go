@@ -75,10 +75,21 @@ func createVirtioDevicesInSysfs(c *C, devsToPartition map[string]bool) {
 		if isPartition {
 			err = ioutil.WriteFile(filepath.Join(diskDir, dev, "partition"), []byte("1"), 0644)
 			c.Assert(err, IsNil)
+			writePartitionGeometryInSysfs(c, dev, 2048, 2048)
 		}
 	}
 }
 
+// writePartitionGeometryInSysfs sets the start and size of a partition, in
+// 512 byte sectors.
+func writePartitionGeometryInSysfs(c *C, dev string, start, size uint64) {
+	partDir := filepath.Join(dirs.SysfsDir, virtioDiskDevPath, dev)
+	err := ioutil.WriteFile(filepath.Join(partDir, "start"), []byte(fmt.Sprintf("%d\n", start)), 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(partDir, "size"), []byte(fmt.Sprintf("%d\n", size)), 0644)
+	c.Assert(err, IsNil)
+}
+
 type diskSuite struct {
 	testutil.BaseTest
 }
@@ -504,6 +515,233 @@ func (s *diskSuite) TestDiskFromMountPointPartitionsHappy(c *C) {
 	c.Assert(xerrors.As(err, &notFoundErr), Equals, true)
 }
 
+func (s *diskSuite) TestDiskFromMountPointPartitionLabelsAndFilesystemTypes(c *C) {
+	restore := osutil.MockMountInfo(`130 30 42:4 / /run/mnt/data rw,relatime shared:54 - ext4 /dev/vda4 rw
+`)
+	defer restore()
+
+	partProps := map[string]map[string]string{
+		"vda1": {
+			"ID_PART_ENTRY_UUID": "bios-boot-partuuid",
+			"ID_PART_ENTRY_NAME": "BIOS\\x20Boot",
+			"ID_PART_ENTRY_TYPE": "21686148-6449-6e6f-744e-656564454649",
+		},
+		"vda2": {
+			"ID_PART_ENTRY_UUID": "seed-partuuid",
+			"ID_PART_ENTRY_NAME": "seed",
+			"ID_PART_ENTRY_TYPE": "c12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+			"ID_FS_LABEL_ENC":    "custom-seed",
+			"ID_FS_TYPE":         "vfat",
+		},
+		"vda3": {
+			"ID_PART_ENTRY_UUID": "data-partuuid",
+			"ID_PART_ENTRY_NAME": "data",
+			"ID_PART_ENTRY_TYPE": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+			"ID_FS_LABEL_ENC":    "custom-data",
+			"ID_FS_TYPE":         "crypto_LUKS",
+		},
+		"vda4": {
+			"ID_PART_ENTRY_UUID": "save-partuuid",
+			"ID_PART_ENTRY_NAME": "save",
+			"ID_PART_ENTRY_TYPE": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+		},
+	}
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		if props, ok := partProps[dev]; ok {
+			return props, nil
+		}
+		switch dev {
+		case "/dev/vda4", "/dev/block/42:0":
+			return diskUdevPropMap, nil
+		}
+		c.Errorf("unexpected udev device properties requested: %s", dev)
+		return nil, fmt.Errorf("unexpected udev device: %s", dev)
+	})
+	defer restore()
+
+	createVirtioDevicesInSysfs(c, map[string]bool{
+		"vda1": true,
+		"vda2": true,
+		"vda3": true,
+		"vda4": true,
+	})
+
+	d, err := disks.DiskFromMountPoint("/run/mnt/data", nil)
+	c.Assert(err, IsNil)
+
+	for label, expected := range map[string]string{
+		"BIOS Boot": "bios-boot-partuuid",
+		"seed":      "seed-partuuid",
+		"data":      "data-partuuid",
+	} {
+		partuuid, err := d.FindMatchingPartitionUUIDWithPartLabel(label)
+		c.Assert(err, IsNil)
+		c.Check(partuuid, Equals, expected)
+	}
+	_, err = d.FindMatchingPartitionUUIDWithPartLabel("custom-data")
+	c.Check(err, ErrorMatches, `partition label "custom-data" not found`)
+	var labelNotFoundErr disks.PartitionLabelNotFoundError
+	c.Check(xerrors.As(err, &labelNotFoundErr), Equals, true)
+
+	for partuuid, expected := range map[string]string{
+		"bios-boot-partuuid": "",
+		"seed-partuuid":      "vfat",
+		"data-partuuid":      "crypto_LUKS",
+	} {
+		fsType, err := d.FilesystemTypeOfPartition(partuuid)
+		c.Assert(err, IsNil)
+		c.Check(fsType, Equals, expected)
+	}
+	_, err = d.FilesystemTypeOfPartition("other-partuuid")
+	c.Check(err, ErrorMatches, `partition uuid "other-partuuid" not found`)
+	var uuidNotFoundErr disks.PartitionUUIDNotFoundError
+	c.Check(xerrors.As(err, &uuidNotFoundErr), Equals, true)
+
+	// partition types are matched case-insensitively
+	for typ, expected := range map[string]string{
+		"21686148-6449-6e6f-744e-656564454649": "bios-boot-partuuid",
+		"C12A7328-F81F-11D2-BA4B-00A0C93EC93B": "seed-partuuid",
+	} {
+		partuuid, err := d.FindMatchingPartitionUUIDWithPartType(typ)
+		c.Assert(err, IsNil)
+		c.Check(partuuid, Equals, expected)
+	}
+	_, err = d.FindMatchingPartitionUUIDWithPartType("0fc63daf-8483-4772-8e79-3d69d8477de4")
+	c.Check(err, ErrorMatches, `multiple partitions with partition type "0fc63daf-8483-4772-8e79-3d69d8477de4" found: data-partuuid, save-partuuid`)
+	_, err = d.FindMatchingPartitionUUIDWithPartType("e3c9e316-0b5c-4db8-817d-f92df00215ae")
+	c.Check(err, ErrorMatches, `partition type "e3c9e316-0b5c-4db8-817d-f92df00215ae" not found`)
+	var typeNotFoundErr disks.PartitionTypeNotFoundError
+	c.Check(xerrors.As(err, &typeNotFoundErr), Equals, true)
+
+	// filesystem labels still work
+	partuuid, err := d.FindMatchingPartitionUUID("custom-data")
+	c.Assert(err, IsNil)
+	c.Check(partuuid, Equals, "data-partuuid")
+}
+
+func (s *diskSuite) TestDiskFromMountPointPartitions(c *C) {
+	restore := osutil.MockMountInfo(`130 30 42:3 / /run/mnt/data rw,relatime shared:54 - ext4 /dev/vda3 rw
+`)
+	defer restore()
+
+	partProps := map[string]map[string]string{
+		"vda1": {
+			"DEVNAME":            "/dev/vda1",
+			"ID_PART_ENTRY_UUID": "bios-boot-partuuid",
+			"ID_PART_ENTRY_NAME": "BIOS\\x20Boot",
+			"ID_PART_ENTRY_TYPE": "21686148-6449-6e6f-744e-656564454649",
+		},
+		"vda2": {
+			"DEVNAME":            "/dev/vda2",
+			"ID_PART_ENTRY_UUID": "seed-partuuid",
+			"ID_PART_ENTRY_NAME": "ubuntu-seed",
+			"ID_PART_ENTRY_TYPE": "c12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+			"ID_FS_LABEL_ENC":    "ubuntu-seed",
+			"ID_FS_TYPE":         "vfat",
+		},
+		// no DEVNAME, the node is derived from the sysfs entry
+		"vda3": {
+			"ID_PART_ENTRY_UUID": "data-partuuid",
+			"ID_PART_ENTRY_TYPE": "0x83",
+			"ID_FS_LABEL_ENC":    "ubuntu-data",
+			"ID_FS_TYPE":         "ext4",
+		},
+	}
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		if props, ok := partProps[dev]; ok {
+			return props, nil
+		}
+		switch dev {
+		case "/dev/vda3", "/dev/block/42:0":
+			return diskUdevPropMap, nil
+		}
+		c.Errorf("unexpected udev device properties requested: %s", dev)
+		return nil, fmt.Errorf("unexpected udev device: %s", dev)
+	})
+	defer restore()
+
+	createVirtioDevicesInSysfs(c, map[string]bool{
+		"vda1": true,
+		"vda2": true,
+		"vda3": true,
+		// not a partition
+		"vdaboot0": false,
+	})
+	writePartitionGeometryInSysfs(c, "vda1", 2048, 2048)
+	writePartitionGeometryInSysfs(c, "vda2", 4096, 2457600)
+	writePartitionGeometryInSysfs(c, "vda3", 2461696, 8388608)
+
+	d, err := disks.DiskFromMountPoint("/run/mnt/data", nil)
+	c.Assert(err, IsNil)
+
+	parts, err := d.Partitions()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, []disks.Partition{
+		{
+			KernelDeviceNode: "/dev/vda1",
+			PartitionUUID:    "bios-boot-partuuid",
+			PartitionLabel:   "BIOS\\x20Boot",
+			PartitionType:    "21686148-6449-6e6f-744e-656564454649",
+			StartInBytes:     1024 * 1024,
+			SizeInBytes:      1024 * 1024,
+		},
+		{
+			KernelDeviceNode: "/dev/vda2",
+			PartitionUUID:    "seed-partuuid",
+			PartitionLabel:   "ubuntu-seed",
+			PartitionType:    "c12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+			FilesystemLabel:  "ubuntu-seed",
+			FilesystemType:   "vfat",
+			StartInBytes:     2 * 1024 * 1024,
+			SizeInBytes:      1200 * 1024 * 1024,
+		},
+		{
+			KernelDeviceNode: "/dev/vda3",
+			PartitionUUID:    "data-partuuid",
+			PartitionType:    "0x83",
+			FilesystemLabel:  "ubuntu-data",
+			FilesystemType:   "ext4",
+			StartInBytes:     1202 * 1024 * 1024,
+			SizeInBytes:      4 * 1024 * 1024 * 1024,
+		},
+	})
+
+	// the lookups use the same partitions
+	partuuid, err := d.FindMatchingPartitionUUIDWithPartLabel("BIOS Boot")
+	c.Assert(err, IsNil)
+	c.Check(partuuid, Equals, "bios-boot-partuuid")
+	partuuid, err = d.FindMatchingPartitionUUID("ubuntu-data")
+	c.Assert(err, IsNil)
+	c.Check(partuuid, Equals, "data-partuuid")
+}
+
+func (s *diskSuite) TestDiskFromMountPointPartitionsMissingSize(c *C) {
+	restore := osutil.MockMountInfo(`130 30 42:1 / /run/mnt/point rw,relatime shared:54 - ext4 /dev/vda1 rw
+`)
+	defer restore()
+
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		switch dev {
+		case "/dev/vda1", "/dev/block/42:0":
+			return diskUdevPropMap, nil
+		case "vda1":
+			return biotBootUdevPropMap, nil
+		}
+		c.Errorf("unexpected udev device properties requested: %s", dev)
+		return nil, fmt.Errorf("unexpected udev device: %s", dev)
+	})
+	defer restore()
+
+	createVirtioDevicesInSysfs(c, map[string]bool{"vda1": true})
+	err := os.Remove(filepath.Join(dirs.SysfsDir, virtioDiskDevPath, "vda1", "size"))
+	c.Assert(err, IsNil)
+
+	d, err := disks.DiskFromMountPoint("/run/mnt/point", nil)
+	c.Assert(err, IsNil)
+	_, err = d.Partitions()
+	c.Check(err, ErrorMatches, `cannot get size of partition vda1 of 42:0: open .*/vda1/size: no such file or directory`)
+}
+
 func (s *diskSuite) TestDiskFromMountPointDecryptedDevicePartitionsHappy(c *C) {
 	restore := osutil.MockMountInfo(`130 30 252:0 / /run/mnt/data rw,relatime shared:54 - ext4 /dev/mapper/ubuntu-data-3776bab4-8bcc-46b7-9da2-6a84ce7f93b4 rw
  130 30 42:4 / /run/mnt/ubuntu-boot rw,relatime shared:54 - ext4 /dev/vda3 rw
@@ -654,3 +892,305 @@ func (s *diskSuite) TestDiskFromMountPointDecryptedDevicePartitionsHappy(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(matches, Equals, true)
 }
+
+// createMultipathDevicesInSysfs creates the sysfs entries of a dm-multipath
+// volume 253:0, named dm-0, with the given device mapper volumes on top of
+// it, their sizes are in 512 byte sectors.
+func createMultipathDevicesInSysfs(c *C, holders map[string]uint64) {
+	err := os.MkdirAll(filepath.Join(dirs.SysfsDir, "class/block/dm-0"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dirs.SysfsDir, "class/block/dm-0/dev"), []byte("253:0\n"), 0644)
+	c.Assert(err, IsNil)
+	for i, name := range []string{"dm-1", "dm-2", "dm-3", "dm-4"} {
+		size, ok := holders[name]
+		if !ok {
+			continue
+		}
+		holderDir := filepath.Join(dirs.SysfsDir, "dev/block/253:0/holders", name)
+		err := os.MkdirAll(holderDir, 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(holderDir, "size"), []byte(fmt.Sprintf("%d\n", size)), 0644)
+		c.Assert(err, IsNil)
+		slavesDir := filepath.Join(dirs.SysfsDir, "dev/block", fmt.Sprintf("253:%d", i+1), "slaves", "dm-0")
+		err = os.MkdirAll(slavesDir, 0755)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *diskSuite) TestDiskFromMountPointMultipathPartitions(c *C) {
+	restore := osutil.MockMountInfo(`130 30 253:1 / /run/mnt/ubuntu-seed rw,relatime shared:54 - vfat /dev/mapper/mpatha-part1 rw
+131 30 253:2 / /run/mnt/ubuntu-boot rw,relatime shared:54 - ext4 /dev/mapper/mpatha-part2 rw
+`)
+	defer restore()
+
+	const wwid = "3600a098038303877552b495a6f645749"
+	partProps := map[string]map[string]string{
+		"dm-1": {
+			"DEVNAME":              "/dev/dm-1",
+			"DEVTYPE":              "disk",
+			"MAJOR":                "253",
+			"MINOR":                "1",
+			"DM_UUID":              "part1-mpath-" + wwid,
+			"ID_PART_ENTRY_UUID":   "ubuntu-seed-partuuid",
+			"ID_PART_ENTRY_NAME":   "ubuntu-seed",
+			"ID_PART_ENTRY_OFFSET": "2048",
+			"ID_FS_LABEL_ENC":      "ubuntu-seed",
+			"ID_FS_TYPE":           "vfat",
+		},
+		"dm-2": {
+			"DEVNAME":              "/dev/dm-2",
+			"DEVTYPE":              "disk",
+			"MAJOR":                "253",
+			"MINOR":                "2",
+			"DM_UUID":              "part2-mpath-" + wwid,
+			"ID_PART_ENTRY_UUID":   "ubuntu-boot-partuuid",
+			"ID_PART_ENTRY_NAME":   "ubuntu-boot",
+			"ID_PART_ENTRY_OFFSET": "2461696",
+			"ID_FS_LABEL_ENC":      "ubuntu-boot",
+			"ID_FS_TYPE":           "ext4",
+		},
+		// not a partition of the multipath disk
+		"dm-3": {
+			"DEVNAME": "/dev/dm-3",
+			"DEVTYPE": "disk",
+			"DM_UUID": "LVM-Tdh4n8dbUkFZE3o1WXWkXw7ugf3UzNbN",
+		},
+	}
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		switch dev {
+		case "/dev/mapper/mpatha-part1":
+			return partProps["dm-1"], nil
+		case "/dev/mapper/mpatha-part2":
+			return partProps["dm-2"], nil
+		}
+		if props, ok := partProps[dev]; ok {
+			return props, nil
+		}
+		c.Errorf("unexpected udev device properties requested: %s", dev)
+		return nil, fmt.Errorf("unexpected udev device: %s", dev)
+	})
+	defer restore()
+
+	createMultipathDevicesInSysfs(c, map[string]uint64{
+		"dm-1": 2457600,
+		"dm-2": 1536000,
+		"dm-3": 2048,
+	})
+
+	d, err := disks.DiskFromMountPoint("/run/mnt/ubuntu-seed", nil)
+	c.Assert(err, IsNil)
+	c.Check(d.Dev(), Equals, "253:0")
+	c.Check(d.HasPartitions(), Equals, true)
+
+	parts, err := d.Partitions()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, []disks.Partition{
+		{
+			KernelDeviceNode: "/dev/dm-1",
+			PartitionUUID:    "ubuntu-seed-partuuid",
+			PartitionLabel:   "ubuntu-seed",
+			FilesystemLabel:  "ubuntu-seed",
+			FilesystemType:   "vfat",
+			StartInBytes:     1024 * 1024,
+			SizeInBytes:      1200 * 1024 * 1024,
+		},
+		{
+			KernelDeviceNode: "/dev/dm-2",
+			PartitionUUID:    "ubuntu-boot-partuuid",
+			PartitionLabel:   "ubuntu-boot",
+			FilesystemLabel:  "ubuntu-boot",
+			FilesystemType:   "ext4",
+			StartInBytes:     1202 * 1024 * 1024,
+			SizeInBytes:      750 * 1024 * 1024,
+		},
+	})
+
+	partuuid, err := d.FindMatchingPartitionUUID("ubuntu-boot")
+	c.Assert(err, IsNil)
+	c.Check(partuuid, Equals, "ubuntu-boot-partuuid")
+
+	matches, err := d.MountPointIsFromDisk("/run/mnt/ubuntu-boot", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, true)
+}
+
+func (s *diskSuite) TestDiskFromMountPointMultipathUnhappy(c *C) {
+	restore := osutil.MockMountInfo(`130 30 253:1 / /run/mnt/ubuntu-seed rw,relatime shared:54 - vfat /dev/mapper/mpatha-part1 rw
+`)
+	defer restore()
+
+	props := map[string]string{
+		"DEVNAME": "/dev/dm-1",
+		"DEVTYPE": "disk",
+		"MAJOR":   "253",
+		"MINOR":   "1",
+		"DM_UUID": "part1-mpath-3600a098038303877552b495a6f645749",
+	}
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		c.Assert(dev, Equals, "/dev/mapper/mpatha-part1")
+		return props, nil
+	})
+	defer restore()
+
+	// no sysfs entries
+	_, err := disks.DiskFromMountPoint("/run/mnt/ubuntu-seed", nil)
+	c.Check(err, ErrorMatches, `cannot find multipath disk for partition /dev/mapper/mpatha-part1: open .*/dev/block/253:1/slaves: no such file or directory`)
+
+	// more than one slave
+	for _, slave := range []string{"sda1", "sdb1"} {
+		err := os.MkdirAll(filepath.Join(dirs.SysfsDir, "dev/block/253:1/slaves", slave), 0755)
+		c.Assert(err, IsNil)
+	}
+	_, err = disks.DiskFromMountPoint("/run/mnt/ubuntu-seed", nil)
+	c.Check(err, ErrorMatches, `cannot find multipath disk for partition /dev/mapper/mpatha-part1: expected a single slave of device 253:1, found 2`)
+
+	delete(props, "MINOR")
+	_, err = disks.DiskFromMountPoint("/run/mnt/ubuntu-seed", nil)
+	c.Check(err, ErrorMatches, `cannot find multipath disk for partition /dev/mapper/mpatha-part1: incomplete udev output`)
+}
+
+// createStackedDeviceInSysfs creates the sysfs entries of a device built on
+// the given slaves, the sysfs files of the device are given relative to its
+// directory.
+func createStackedDeviceInSysfs(c *C, name, dev string, files map[string]string, slaves ...string) {
+	for _, dir := range []string{
+		filepath.Join(dirs.SysfsDir, "dev/block", dev),
+		filepath.Join(dirs.SysfsDir, "class/block", name),
+	} {
+		for _, slave := range slaves {
+			err := os.MkdirAll(filepath.Join(dir, "slaves", slave), 0755)
+			c.Assert(err, IsNil)
+		}
+		files["dev"] = dev + "\n"
+		for fn, content := range files {
+			err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755)
+			c.Assert(err, IsNil)
+			err = ioutil.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+			c.Assert(err, IsNil)
+		}
+	}
+}
+
+func (s *diskSuite) TestDiskFromMountPointLVMOnRAID1(c *C) {
+	restore := osutil.MockMountInfo(`130 30 8:1 / /run/mnt/ubuntu-seed rw,relatime shared:54 - vfat /dev/sda1 rw
+131 30 8:17 / /run/mnt/other rw,relatime shared:54 - vfat /dev/sdb1 rw
+132 30 253:3 / /run/mnt/ubuntu-data rw,relatime shared:54 - ext4 /dev/mapper/vg-data rw
+`)
+	defer restore()
+
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		switch dev {
+		case "/dev/sda1":
+			return map[string]string{"ID_PART_ENTRY_DISK": "8:0"}, nil
+		case "/dev/sdb1":
+			return map[string]string{"ID_PART_ENTRY_DISK": "8:16"}, nil
+		case "/dev/mapper/vg-data":
+			return map[string]string{
+				"DEVNAME": "/dev/dm-3",
+				"DEVTYPE": "disk",
+				"MAJOR":   "253",
+				"MINOR":   "3",
+				"DM_UUID": "LVM-Tdh4n8dbUkFZE3o1WXWkXw7ugf3UzNbNUpcO1rEZ7y1ZSmbJBMDdGyYyLfbKwxwy",
+			}, nil
+		case "sda3":
+			return map[string]string{"ID_PART_ENTRY_DISK": "8:0"}, nil
+		case "sdb3":
+			return map[string]string{"ID_PART_ENTRY_DISK": "8:16"}, nil
+		}
+		c.Errorf("unexpected udev device properties requested: %s", dev)
+		return nil, fmt.Errorf("unexpected udev device: %s", dev)
+	})
+	defer restore()
+
+	createStackedDeviceInSysfs(c, "dm-3", "253:3", map[string]string{
+		"dm/uuid": "LVM-Tdh4n8dbUkFZE3o1WXWkXw7ugf3UzNbNUpcO1rEZ7y1ZSmbJBMDdGyYyLfbKwxwy\n",
+	}, "md0")
+	createStackedDeviceInSysfs(c, "md0", "9:0", map[string]string{
+		"md/level": "raid1\n",
+	}, "sda3", "sdb3")
+	createStackedDeviceInSysfs(c, "sda3", "8:3", map[string]string{"partition": "3\n"})
+	createStackedDeviceInSysfs(c, "sdb3", "8:19", map[string]string{"partition": "3\n"})
+
+	d, err := disks.DiskFromMountPoint("/run/mnt/ubuntu-data", nil)
+	c.Assert(err, IsNil)
+	c.Check(d.Dev(), Equals, "8:0")
+	c.Check(d.HasPartitions(), Equals, true)
+	c.Check(d.BackingDisks(), DeepEquals, []string{"8:0", "8:16"})
+	c.Check(d.Stack(), DeepEquals, []disks.StackedDevice{
+		{
+			Dev:              "253:3",
+			KernelDeviceNode: "/dev/dm-3",
+			Type:             "lvm",
+			Slaves:           []string{"9:0"},
+		},
+		{
+			Dev:              "9:0",
+			KernelDeviceNode: "/dev/md0",
+			Type:             "md",
+			RAIDLevel:        "raid1",
+			Slaves:           []string{"8:3", "8:19"},
+		},
+	})
+
+	// the logical volume is on both disks of the array, so it is not from
+	// either of them alone, another disk could have been added to it
+	for _, mnt := range []string{"/run/mnt/ubuntu-seed", "/run/mnt/other"} {
+		d2, err := disks.DiskFromMountPoint(mnt, nil)
+		c.Assert(err, IsNil)
+		c.Check(d2.Stack(), HasLen, 0)
+		c.Check(d2.BackingDisks(), DeepEquals, []string{d2.Dev()})
+		matches, err := d2.MountPointIsFromDisk("/run/mnt/ubuntu-data", nil)
+		c.Assert(err, IsNil)
+		c.Check(matches, Equals, false, Commentf(mnt))
+	}
+
+	matches, err := d.MountPointIsFromDisk("/run/mnt/ubuntu-data", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, false)
+	matches, err = d.MountPointIsFromDisk("/run/mnt/ubuntu-seed", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, true)
+	matches, err = d.MountPointIsFromDisk("/run/mnt/other", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, false)
+}
+
+func (s *diskSuite) TestDiskFromMountPointRAIDUnhappy(c *C) {
+	restore := osutil.MockMountInfo(`130 30 9:1 / /run/mnt/ubuntu-data rw,relatime shared:54 - ext4 /dev/md1 rw
+`)
+	defer restore()
+
+	props := map[string]string{
+		"DEVNAME":  "/dev/md1",
+		"DEVTYPE":  "disk",
+		"MAJOR":    "9",
+		"MINOR":    "1",
+		"MD_LEVEL": "raid1",
+	}
+	restore = disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		switch dev {
+		case "/dev/md1":
+			return props, nil
+		case "sda3":
+			return map[string]string{"ID_PART_ENTRY_DISK": "8:0"}, nil
+		}
+		c.Errorf("unexpected udev device properties requested: %s", dev)
+		return nil, fmt.Errorf("unexpected udev device: %s", dev)
+	})
+	defer restore()
+
+	// no sysfs entries
+	_, err := disks.DiskFromMountPoint("/run/mnt/ubuntu-data", nil)
+	c.Check(err, ErrorMatches, `cannot find disk for /dev/md1: cannot find the devices 9:1 is built on`)
+
+	// both partitions and whole disks
+	createStackedDeviceInSysfs(c, "md1", "9:1", map[string]string{"md/level": "raid1\n"}, "sda3", "sdb")
+	createStackedDeviceInSysfs(c, "sda3", "8:3", map[string]string{"partition": "3\n"})
+	createStackedDeviceInSysfs(c, "sdb", "8:16", map[string]string{})
+	_, err = disks.DiskFromMountPoint("/run/mnt/ubuntu-data", nil)
+	c.Check(err, ErrorMatches, `cannot find disk for /dev/md1: device 9:1 is built on both partitions and whole disks`)
+
+	delete(props, "MINOR")
+	_, err = disks.DiskFromMountPoint("/run/mnt/ubuntu-data", nil)
+	c.Check(err, ErrorMatches, `cannot find disk for /dev/md1: incomplete udev output`)
+}
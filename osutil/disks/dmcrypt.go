@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package disks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+)
+
+// this regexp is for the dm/uuid sysfs entry of dm-crypt volumes set up by
+// cryptsetup for LUKS devices, once the dm name suffix is removed, the
+// submatches are the LUKS version and the LUKS uuid without dashes
+var luksDmUUIDPatternRe = regexp.MustCompile(`^CRYPT-(LUKS[12])-([0-9a-f]{32})$`)
+
+// DmCryptMapping describes an active dm-crypt mapping, like the decrypted
+// volume of an encrypted partition.
+type DmCryptMapping struct {
+	// Name is the device mapper name of the mapping, like ubuntu-data-<uuid>.
+	Name string
+	// Dev is the "major:minor" number of the mapping.
+	Dev string
+	// KernelDeviceNode is the device node of the mapping, like /dev/dm-0.
+	KernelDeviceNode string
+	// SourceDev is the "major:minor" number of the encrypted device the
+	// mapping is set up on.
+	SourceDev string
+	// SourceKernelDeviceNode is the device node of the encrypted device,
+	// like /dev/vda4.
+	SourceKernelDeviceNode string
+	// LUKSVersion is the version of the LUKS header of the encrypted
+	// device, like "LUKS2". It is empty for plain dm-crypt mappings.
+	LUKSVersion string
+	// LUKSUUID is the uuid of the LUKS header of the encrypted device,
+	// which is the filesystem uuid reported for it by udev. It is empty
+	// for plain dm-crypt mappings.
+	LUKSUUID string
+	// Suspended is whether the mapping is suspended, in which case I/O on
+	// it is blocked.
+	Suspended bool
+}
+
+// NotDmCryptMappingError is returned by DmCryptMappingForDevice for devices
+// that are not dm-crypt mappings.
+type NotDmCryptMappingError struct {
+	Device string
+}
+
+func (e NotDmCryptMappingError) Error() string {
+	return fmt.Sprintf("device %s is not a dm-crypt mapping", e.Device)
+}
+
+// canonicalUUID inserts the dashes missing from the given compact uuid, as
+// found in device mapper uuids, like ae6e79de00a9406f80ee64ba7c1966bb, to
+// return it as ae6e79de-00a9-406f-80ee-64ba7c1966bb.
+func canonicalUUID(compact string) string {
+	return fmt.Sprintf(
+		"%s-%s-%s-%s-%s",
+		compact[0:8],
+		compact[8:12],
+		compact[12:16],
+		compact[16:20],
+		compact[20:],
+	)
+}
+
+// DmCryptMappingForDevice returns the dm-crypt mapping of the given device
+// node, which is typically a /dev/mapper symlink, with the device it is set
+// up on. A NotDmCryptMappingError is returned if the device is not a
+// dm-crypt mapping.
+func DmCryptMappingForDevice(node string) (*DmCryptMapping, error) {
+	props, err := udevProperties(node)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get udev properties for device %s: %v", node, err)
+	}
+	if props["MAJOR"] == "" || props["MINOR"] == "" {
+		return nil, fmt.Errorf("cannot get udev properties for device %s: incomplete udev output", node)
+	}
+	dev := props["MAJOR"] + ":" + props["MINOR"]
+	sysfsDir := filepath.Join(dirs.SysfsDir, "dev", "block", dev)
+
+	dmUUID, err := ioutil.ReadFile(filepath.Join(sysfsDir, "dm", "uuid"))
+	if os.IsNotExist(err) {
+		// not a device mapper volume
+		return nil, NotDmCryptMappingError{Device: node}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read device mapper metadata of %s: %v", node, err)
+	}
+	dmUUID = bytes.TrimSpace(dmUUID)
+	if !bytes.HasPrefix(dmUUID, []byte("CRYPT-")) {
+		return nil, NotDmCryptMappingError{Device: node}
+	}
+	dmName, err := ioutil.ReadFile(filepath.Join(sysfsDir, "dm", "name"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read device mapper metadata of %s: %v", node, err)
+	}
+	dmName = bytes.TrimSpace(dmName)
+
+	devNode := props["DEVNAME"]
+	if devNode == "" {
+		devNode = filepath.Join("/dev/block", dev)
+	}
+	mapping := &DmCryptMapping{
+		Name:             string(dmName),
+		Dev:              dev,
+		KernelDeviceNode: devNode,
+	}
+	if suspended, err := ioutil.ReadFile(filepath.Join(sysfsDir, "dm", "suspended")); err == nil {
+		mapping.Suspended = strings.TrimSpace(string(suspended)) == "1"
+	}
+
+	// the dm name is appended to the dm uuid and is user controlled, so it
+	// is removed before matching, see DiskFromMountPoint
+	dmUUIDSafe := bytes.TrimSuffix(dmUUID, append([]byte("-"), dmName...))
+	if matches := luksDmUUIDPatternRe.FindSubmatch(dmUUIDSafe); len(matches) == 3 {
+		mapping.LUKSVersion = string(matches[1])
+		mapping.LUKSUUID = canonicalUUID(string(matches[2]))
+	} else if !bytes.HasPrefix(dmUUIDSafe, []byte("CRYPT-PLAIN")) {
+		return nil, fmt.Errorf("cannot use dm-crypt mapping %s: unsupported device mapper uuid %q", node, dmUUIDSafe)
+	}
+
+	// dm-crypt mappings are set up on exactly one device
+	slaves, err := ioutil.ReadDir(filepath.Join(sysfsDir, "slaves"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the device of dm-crypt mapping %s: %v", node, err)
+	}
+	if len(slaves) != 1 {
+		return nil, fmt.Errorf("cannot find the device of dm-crypt mapping %s: expected a single slave, found %d", node, len(slaves))
+	}
+	sourceName := slaves[0].Name()
+	sourceDev, err := ioutil.ReadFile(filepath.Join(dirs.SysfsDir, "class", "block", sourceName, "dev"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the device of dm-crypt mapping %s: %v", node, err)
+	}
+	mapping.SourceDev = strings.TrimSpace(string(sourceDev))
+	mapping.SourceKernelDeviceNode = filepath.Join("/dev", sourceName)
+
+	return mapping, nil
+}
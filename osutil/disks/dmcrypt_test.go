@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package disks_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil/disks"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type dmCryptSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&dmCryptSuite{})
+
+func (s *dmCryptSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	s.AddCleanup(disks.MockUdevPropertiesForDevice(func(dev string) (map[string]string, error) {
+		switch dev {
+		case "/dev/mapper/ubuntu-data-3776bab4-8bcc-46b7-9da2-6a84ce7f93b4":
+			return map[string]string{
+				"MAJOR":   "252",
+				"MINOR":   "0",
+				"DEVNAME": "/dev/dm-0",
+			}, nil
+		case "/dev/vda4":
+			return map[string]string{
+				"MAJOR":   "253",
+				"MINOR":   "4",
+				"DEVNAME": "/dev/vda4",
+			}, nil
+		default:
+			return nil, fmt.Errorf("unexpected udev device properties requested: %s", dev)
+		}
+	}))
+}
+
+func (s *dmCryptSuite) mockSysfs(c *C, dev string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dirs.SysfsDir, "dev", "block", dev, name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(content+"\n"), 0644), IsNil)
+	}
+}
+
+func (s *dmCryptSuite) mockSource(c *C, mappingDev, name, dev string) {
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SysfsDir, "dev", "block", mappingDev, "slaves", name), 0755), IsNil)
+	sourceDir := filepath.Join(dirs.SysfsDir, "class", "block", name)
+	c.Assert(os.MkdirAll(sourceDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "dev"), []byte(dev+"\n"), 0644), IsNil)
+}
+
+const mapperNode = "/dev/mapper/ubuntu-data-3776bab4-8bcc-46b7-9da2-6a84ce7f93b4"
+
+func (s *dmCryptSuite) TestDmCryptMappingForDeviceLUKS(c *C) {
+	s.mockSysfs(c, "252:0", map[string]string{
+		"dm/name":      "ubuntu-data-3776bab4-8bcc-46b7-9da2-6a84ce7f93b4",
+		"dm/uuid":      "CRYPT-LUKS2-5cd9e5b6cf4e4e0c8a6d5f8ab1e2d3c4-ubuntu-data-3776bab4-8bcc-46b7-9da2-6a84ce7f93b4",
+		"dm/suspended": "0",
+	})
+	s.mockSource(c, "252:0", "vda4", "253:4")
+
+	mapping, err := disks.DmCryptMappingForDevice(mapperNode)
+	c.Assert(err, IsNil)
+	c.Check(mapping, DeepEquals, &disks.DmCryptMapping{
+		Name:                   "ubuntu-data-3776bab4-8bcc-46b7-9da2-6a84ce7f93b4",
+		Dev:                    "252:0",
+		KernelDeviceNode:       "/dev/dm-0",
+		SourceDev:              "253:4",
+		SourceKernelDeviceNode: "/dev/vda4",
+		LUKSVersion:            "LUKS2",
+		LUKSUUID:               "5cd9e5b6-cf4e-4e0c-8a6d-5f8ab1e2d3c4",
+	})
+}
+
+func (s *dmCryptSuite) TestDmCryptMappingForDevicePlainSuspended(c *C) {
+	s.mockSysfs(c, "252:0", map[string]string{
+		"dm/name":      "swap",
+		"dm/uuid":      "CRYPT-PLAIN-swap",
+		"dm/suspended": "1",
+	})
+	s.mockSource(c, "252:0", "vda4", "253:4")
+
+	mapping, err := disks.DmCryptMappingForDevice(mapperNode)
+	c.Assert(err, IsNil)
+	c.Check(mapping, DeepEquals, &disks.DmCryptMapping{
+		Name:                   "swap",
+		Dev:                    "252:0",
+		KernelDeviceNode:       "/dev/dm-0",
+		SourceDev:              "253:4",
+		SourceKernelDeviceNode: "/dev/vda4",
+		Suspended:              true,
+	})
+}
+
+func (s *dmCryptSuite) TestDmCryptMappingForDeviceNotDmCrypt(c *C) {
+	// not a device mapper volume
+	_, err := disks.DmCryptMappingForDevice("/dev/vda4")
+	c.Check(err, Equals, disks.NotDmCryptMappingError{Device: "/dev/vda4"})
+	c.Check(err, ErrorMatches, "device /dev/vda4 is not a dm-crypt mapping")
+
+	// an LVM logical volume
+	s.mockSysfs(c, "252:0", map[string]string{
+		"dm/name": "vg-lv",
+		"dm/uuid": "LVM-ODdBYB0wvhGuVwDtdCnzsTiADQh8Ei2Gq8xkf0h3eBJaUwIoTkU35wyPnkkjCimb",
+	})
+	_, err = disks.DmCryptMappingForDevice(mapperNode)
+	c.Check(err, Equals, disks.NotDmCryptMappingError{Device: mapperNode})
+}
+
+func (s *dmCryptSuite) TestDmCryptMappingForDeviceErrors(c *C) {
+	_, err := disks.DmCryptMappingForDevice("/dev/sdz")
+	c.Check(err, ErrorMatches, "cannot get udev properties for device /dev/sdz: unexpected udev device properties requested: /dev/sdz")
+
+	s.mockSysfs(c, "252:0", map[string]string{
+		"dm/name": "ubuntu-data",
+		"dm/uuid": "CRYPT-LUKS3-5cd9e5b6cf4e4e0c8a6d5f8ab1e2d3c4-ubuntu-data",
+	})
+	_, err = disks.DmCryptMappingForDevice(mapperNode)
+	c.Check(err, ErrorMatches, `cannot use dm-crypt mapping /dev/mapper/ubuntu-data-.*: unsupported device mapper uuid "CRYPT-LUKS3-5cd9e5b6cf4e4e0c8a6d5f8ab1e2d3c4"`)
+
+	s.mockSysfs(c, "252:0", map[string]string{
+		"dm/uuid": "CRYPT-LUKS2-5cd9e5b6cf4e4e0c8a6d5f8ab1e2d3c4-ubuntu-data",
+	})
+	_, err = disks.DmCryptMappingForDevice(mapperNode)
+	c.Check(err, ErrorMatches, `cannot find the device of dm-crypt mapping /dev/mapper/ubuntu-data-.*: open .*/sys/dev/block/252:0/slaves: no such file or directory`)
+
+	s.mockSource(c, "252:0", "vda4", "253:4")
+	s.mockSource(c, "252:0", "vdb4", "253:20")
+	_, err = disks.DmCryptMappingForDevice(mapperNode)
+	c.Check(err, ErrorMatches, `cannot find the device of dm-crypt mapping /dev/mapper/ubuntu-data-.*: expected a single slave, found 2`)
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package disks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+var loopDeviceNodeRe = regexp.MustCompile(`^/dev/loop[0-9]+$`)
+
+// AttachLoopDevice attaches the given image file to the first free loop
+// device and returns the device node of the loop device, like /dev/loop0.
+// The partition table of the image is scanned, so that its partitions get
+// device nodes as well, like /dev/loop0p1, and the loop device can be used
+// wherever a disk is expected.
+func AttachLoopDevice(imageFile string) (string, error) {
+	out, err := exec.Command("losetup", "--find", "--show", "--partscan", imageFile).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("cannot attach %s to a loop device: %v", imageFile, osutil.OutputErr(out, err))
+	}
+	node := strings.TrimSpace(string(out))
+	if !loopDeviceNodeRe.MatchString(node) {
+		return "", fmt.Errorf("cannot attach %s to a loop device: unexpected losetup output %q", imageFile, out)
+	}
+	return node, nil
+}
+
+// DetachLoopDevice detaches the loop device with the given device node from
+// its image file. The kernel releases the loop device once it is no longer
+// in use, eg. when all its partitions were unmounted.
+func DetachLoopDevice(node string) error {
+	if !loopDeviceNodeRe.MatchString(node) {
+		return fmt.Errorf("cannot detach %s: not a loop device", node)
+	}
+	if out, err := exec.Command("losetup", "--detach", node).CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot detach loop device %s: %v", node, osutil.OutputErr(out, err))
+	}
+	return nil
+}
+
+// LoopDeviceBackingFile returns the image file attached to the loop device
+// with the given device node.
+func LoopDeviceBackingFile(node string) (string, error) {
+	if !loopDeviceNodeRe.MatchString(node) {
+		return "", fmt.Errorf("cannot find the backing file of %s: not a loop device", node)
+	}
+	backingFile, err := ioutil.ReadFile(filepath.Join(dirs.SysfsDir, "class", "block", filepath.Base(node), "loop", "backing_file"))
+	if os.IsNotExist(err) {
+		// the loop directory only exists for attached loop devices
+		return "", fmt.Errorf("cannot find the backing file of %s: loop device is not attached", node)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot find the backing file of %s: %v", node, err)
+	}
+	return strings.TrimSpace(string(backingFile)), nil
+}
+
+// LoopDevicesForFile returns the device nodes of the loop devices the given
+// image file is attached to, sorted by name. The same file can be attached
+// to several loop devices.
+func LoopDevicesForFile(imageFile string) ([]string, error) {
+	imageFile, err := filepath.Abs(imageFile)
+	if err != nil {
+		return nil, err
+	}
+	backingFiles, err := filepath.Glob(filepath.Join(dirs.SysfsDir, "class", "block", "loop*", "loop", "backing_file"))
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	for _, path := range backingFiles {
+		backingFile, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// detached meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the loop devices of %s: %v", imageFile, err)
+		}
+		if strings.TrimSpace(string(backingFile)) != imageFile {
+			continue
+		}
+		// .../class/block/<name>/loop/backing_file
+		name := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		nodes = append(nodes, filepath.Join("/dev", name))
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
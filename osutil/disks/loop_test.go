@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package disks_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil/disks"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type loopSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&loopSuite{})
+
+func (s *loopSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+}
+
+func (s *loopSuite) mockLoopDevice(c *C, name, backingFile string) {
+	dir := filepath.Join(dirs.SysfsDir, "class", "block", name)
+	if backingFile == "" {
+		c.Assert(os.MkdirAll(dir, 0755), IsNil)
+		return
+	}
+	c.Assert(os.MkdirAll(filepath.Join(dir, "loop"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "loop", "backing_file"), []byte(backingFile+"\n"), 0644), IsNil)
+}
+
+func (s *loopSuite) TestAttachLoopDevice(c *C) {
+	cmd := testutil.MockCommand(c, "losetup", `echo /dev/loop3`)
+	defer cmd.Restore()
+
+	node, err := disks.AttachLoopDevice("/tmp/disk.img")
+	c.Assert(err, IsNil)
+	c.Check(node, Equals, "/dev/loop3")
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{"losetup", "--find", "--show", "--partscan", "/tmp/disk.img"},
+	})
+}
+
+func (s *loopSuite) TestAttachLoopDeviceErrors(c *C) {
+	cmd := testutil.MockCommand(c, "losetup", `echo "losetup: /tmp/disk.img: failed to set up loop device: No such file or directory"; exit 1`)
+	defer cmd.Restore()
+
+	_, err := disks.AttachLoopDevice("/tmp/disk.img")
+	c.Assert(err, ErrorMatches, `cannot attach /tmp/disk.img to a loop device: losetup: /tmp/disk.img: failed to set up loop device: No such file or directory`)
+
+	cmd = testutil.MockCommand(c, "losetup", `echo ""`)
+	defer cmd.Restore()
+
+	_, err = disks.AttachLoopDevice("/tmp/disk.img")
+	c.Assert(err, ErrorMatches, `cannot attach /tmp/disk.img to a loop device: unexpected losetup output "\\n"`)
+}
+
+func (s *loopSuite) TestDetachLoopDevice(c *C) {
+	cmd := testutil.MockCommand(c, "losetup", "")
+	defer cmd.Restore()
+
+	err := disks.DetachLoopDevice("/dev/loop3")
+	c.Assert(err, IsNil)
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{"losetup", "--detach", "/dev/loop3"},
+	})
+
+	err = disks.DetachLoopDevice("/dev/vda")
+	c.Assert(err, ErrorMatches, `cannot detach /dev/vda: not a loop device`)
+	c.Check(cmd.Calls(), HasLen, 1)
+}
+
+func (s *loopSuite) TestDetachLoopDeviceError(c *C) {
+	cmd := testutil.MockCommand(c, "losetup", `echo "losetup: /dev/loop3: detach failed: No such device or address"; exit 1`)
+	defer cmd.Restore()
+
+	err := disks.DetachLoopDevice("/dev/loop3")
+	c.Assert(err, ErrorMatches, `cannot detach loop device /dev/loop3: losetup: /dev/loop3: detach failed: No such device or address`)
+}
+
+func (s *loopSuite) TestLoopDeviceBackingFile(c *C) {
+	s.mockLoopDevice(c, "loop0", "/tmp/disk.img")
+	s.mockLoopDevice(c, "loop1", "")
+
+	backingFile, err := disks.LoopDeviceBackingFile("/dev/loop0")
+	c.Assert(err, IsNil)
+	c.Check(backingFile, Equals, "/tmp/disk.img")
+
+	_, err = disks.LoopDeviceBackingFile("/dev/loop1")
+	c.Assert(err, ErrorMatches, `cannot find the backing file of /dev/loop1: loop device is not attached`)
+
+	_, err = disks.LoopDeviceBackingFile("/dev/loop0p1")
+	c.Assert(err, ErrorMatches, `cannot find the backing file of /dev/loop0p1: not a loop device`)
+}
+
+func (s *loopSuite) TestLoopDevicesForFile(c *C) {
+	s.mockLoopDevice(c, "loop0", "/tmp/other.img")
+	s.mockLoopDevice(c, "loop1", "")
+	s.mockLoopDevice(c, "loop2", "/tmp/disk.img")
+	s.mockLoopDevice(c, "loop7", "/tmp/disk.img")
+
+	nodes, err := disks.LoopDevicesForFile("/tmp/disk.img")
+	c.Assert(err, IsNil)
+	c.Check(nodes, DeepEquals, []string{"/dev/loop2", "/dev/loop7"})
+
+	nodes, err = disks.LoopDevicesForFile("/tmp/missing.img")
+	c.Assert(err, IsNil)
+	c.Check(nodes, HasLen, 0)
+}
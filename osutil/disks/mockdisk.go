@@ -33,8 +33,24 @@ import (
 // being mocked it can be left empty.
 type MockDiskMapping struct {
 	FilesystemLabelToPartUUID map[string]string
-	DiskHasPartitions         bool
-	DevNum                    string
+	PartitionLabelToPartUUID  map[string]string
+	// PartUUIDToFilesystemType is the filesystem type of partitions, the
+	// partitions in FilesystemLabelToPartUUID, PartitionLabelToPartUUID and
+	// PartUUIDToPartitionType which are absent from it have no filesystem
+	// type.
+	PartUUIDToFilesystemType map[string]string
+	// PartUUIDToPartitionType is the partition type of partitions, like
+	// their GPT type GUID.
+	PartUUIDToPartitionType map[string]string
+	// DiskPartitions are the partitions returned by Partitions, they are
+	// not considered by the other methods.
+	DiskPartitions []Partition
+	// DiskStack is returned by Stack and DiskBackingDisks by BackingDisks,
+	// which returns just DevNum if it is empty.
+	DiskStack         []StackedDevice
+	DiskBackingDisks  []string
+	DiskHasPartitions bool
+	DevNum            string
 }
 
 // FindMatchingPartitionUUID returns a matching PartitionUUID for the specified
@@ -47,12 +63,77 @@ func (d *MockDiskMapping) FindMatchingPartitionUUID(label string) (string, error
 	return "", FilesystemLabelNotFoundError{Label: label}
 }
 
+// FindMatchingPartitionUUIDWithPartLabel returns a matching PartitionUUID for
+// the specified partition label if it exists. Part of the Disk interface.
+func (d *MockDiskMapping) FindMatchingPartitionUUIDWithPartLabel(label string) (string, error) {
+	osutil.MustBeTestBinary("mock disks only to be used in tests")
+	if partuuid, ok := d.PartitionLabelToPartUUID[label]; ok {
+		return partuuid, nil
+	}
+	return "", PartitionLabelNotFoundError{Label: label}
+}
+
+// FindMatchingPartitionUUIDWithPartType returns the PartitionUUID of the only
+// partition with the specified partition type if it exists. Part of the Disk
+// interface.
+func (d *MockDiskMapping) FindMatchingPartitionUUIDWithPartType(partType string) (string, error) {
+	osutil.MustBeTestBinary("mock disks only to be used in tests")
+	return findPartitionUUIDWithPartType(d.PartUUIDToPartitionType, partType)
+}
+
+// FilesystemTypeOfPartition returns the filesystem type of the partition with
+// the specified PartitionUUID if it exists. Part of the Disk interface.
+func (d *MockDiskMapping) FilesystemTypeOfPartition(partUUID string) (string, error) {
+	osutil.MustBeTestBinary("mock disks only to be used in tests")
+	if fsType, ok := d.PartUUIDToFilesystemType[partUUID]; ok {
+		return fsType, nil
+	}
+	if _, ok := d.PartUUIDToPartitionType[partUUID]; ok {
+		return "", nil
+	}
+	for _, m := range []map[string]string{d.FilesystemLabelToPartUUID, d.PartitionLabelToPartUUID} {
+		for _, partuuid := range m {
+			if partuuid == partUUID {
+				return "", nil
+			}
+		}
+	}
+	return "", PartitionUUIDNotFoundError{PartUUID: partUUID}
+}
+
 // HasPartitions returns if the mock disk has partitions or not. Part of the
 // Disk interface.
 func (d *MockDiskMapping) HasPartitions() bool {
 	return d.DiskHasPartitions
 }
 
+// Partitions returns the mocked partitions of the disk. Part of the Disk
+// interface.
+func (d *MockDiskMapping) Partitions() ([]Partition, error) {
+	osutil.MustBeTestBinary("mock disks only to be used in tests")
+	if len(d.DiskPartitions) == 0 {
+		return nil, fmt.Errorf("no partitions found for disk %s", d.Dev())
+	}
+	return d.DiskPartitions, nil
+}
+
+// Stack returns the mocked stack of devices of the disk. Part of the Disk
+// interface.
+func (d *MockDiskMapping) Stack() []StackedDevice {
+	osutil.MustBeTestBinary("mock disks only to be used in tests")
+	return d.DiskStack
+}
+
+// BackingDisks returns the mocked backing disks of the disk. Part of the
+// Disk interface.
+func (d *MockDiskMapping) BackingDisks() []string {
+	osutil.MustBeTestBinary("mock disks only to be used in tests")
+	if len(d.DiskBackingDisks) == 0 {
+		return []string{d.DevNum}
+	}
+	return d.DiskBackingDisks
+}
+
 // MountPointIsFromDisk returns if the disk that the specified mount point comes
 // from is the same disk as the object. Part of the Disk interface.
 func (d *MockDiskMapping) MountPointIsFromDisk(mountpoint string, opts *Options) (bool, error) {
@@ -65,7 +146,7 @@ func (d *MockDiskMapping) MountPointIsFromDisk(mountpoint string, opts *Options)
 		return false, err
 	}
 
-	if otherDisk.Dev() == d.Dev() && otherDisk.HasPartitions() == d.HasPartitions() {
+	if onlyBackedBy(otherDisk, d.Dev()) && otherDisk.HasPartitions() == d.HasPartitions() {
 		return true, nil
 	}
 
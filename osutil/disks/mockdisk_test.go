@@ -231,3 +231,111 @@ func (s *mockDiskSuite) TestMockMountPointDisksToPartitionMappingDecryptedDevice
 	c.Assert(err, IsNil)
 	c.Assert(matches, Equals, true)
 }
+
+func (s *mockDiskSuite) TestMockDiskMappingPartitionLabelsAndFilesystemTypes(c *C) {
+	d := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"custom-boot": "boot-part",
+		},
+		PartitionLabelToPartUUID: map[string]string{
+			"boot": "boot-part",
+			"data": "data-part",
+		},
+		PartUUIDToFilesystemType: map[string]string{
+			"data-part": "crypto_LUKS",
+		},
+		PartUUIDToPartitionType: map[string]string{
+			"boot-part": "c12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+			"data-part": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+		},
+		DiskHasPartitions: true,
+	}
+
+	partuuid, err := d.FindMatchingPartitionUUIDWithPartLabel("data")
+	c.Assert(err, IsNil)
+	c.Check(partuuid, Equals, "data-part")
+	_, err = d.FindMatchingPartitionUUIDWithPartLabel("custom-boot")
+	var labelNotFoundErr disks.PartitionLabelNotFoundError
+	c.Check(xerrors.As(err, &labelNotFoundErr), Equals, true)
+
+	partuuid, err = d.FindMatchingPartitionUUIDWithPartType("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	c.Assert(err, IsNil)
+	c.Check(partuuid, Equals, "boot-part")
+	_, err = d.FindMatchingPartitionUUIDWithPartType("21686148-6449-6e6f-744e-656564454649")
+	var typeNotFoundErr disks.PartitionTypeNotFoundError
+	c.Check(xerrors.As(err, &typeNotFoundErr), Equals, true)
+
+	fsType, err := d.FilesystemTypeOfPartition("data-part")
+	c.Assert(err, IsNil)
+	c.Check(fsType, Equals, "crypto_LUKS")
+	fsType, err = d.FilesystemTypeOfPartition("boot-part")
+	c.Assert(err, IsNil)
+	c.Check(fsType, Equals, "")
+	_, err = d.FilesystemTypeOfPartition("other-part")
+	var uuidNotFoundErr disks.PartitionUUIDNotFoundError
+	c.Check(xerrors.As(err, &uuidNotFoundErr), Equals, true)
+}
+
+func (s *mockDiskSuite) TestMockDiskMappingPartitions(c *C) {
+	d := &disks.MockDiskMapping{
+		DevNum: "d1",
+	}
+	_, err := d.Partitions()
+	c.Check(err, ErrorMatches, "no partitions found for disk d1")
+
+	d.DiskPartitions = []disks.Partition{
+		{
+			KernelDeviceNode: "/dev/vda1",
+			PartitionUUID:    "boot-part",
+			FilesystemLabel:  "ubuntu-boot",
+			FilesystemType:   "ext4",
+			StartInBytes:     1024 * 1024,
+			SizeInBytes:      750 * 1024 * 1024,
+		},
+	}
+	parts, err := d.Partitions()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, d.DiskPartitions)
+}
+
+func (s *mockDiskSuite) TestMockDiskMappingBackingDisks(c *C) {
+	d1 := &disks.MockDiskMapping{
+		DiskHasPartitions: true,
+		DevNum:            "d1",
+	}
+	// a RAID array of d1 and d2
+	md0 := &disks.MockDiskMapping{
+		DiskHasPartitions: true,
+		DevNum:            "md0",
+		DiskBackingDisks:  []string{"d1", "d2"},
+	}
+	// a RAID array of two partitions of d1
+	md1 := &disks.MockDiskMapping{
+		DiskHasPartitions: true,
+		DevNum:            "md1",
+		DiskBackingDisks:  []string{"d1"},
+	}
+
+	r := disks.MockMountPointDisksToPartitionMapping(
+		map[disks.Mountpoint]*disks.MockDiskMapping{
+			{Mountpoint: "mount1"}: d1,
+			{Mountpoint: "mount2"}: md0,
+			{Mountpoint: "mount3"}: md1,
+		},
+	)
+	defer r()
+
+	c.Check(d1.BackingDisks(), DeepEquals, []string{"d1"})
+	c.Check(md0.BackingDisks(), DeepEquals, []string{"d1", "d2"})
+
+	matches, err := d1.MountPointIsFromDisk("mount1", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, true)
+	// md0 is not only on d1
+	matches, err = d1.MountPointIsFromDisk("mount2", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, false)
+	matches, err = d1.MountPointIsFromDisk("mount3", nil)
+	c.Assert(err, IsNil)
+	c.Check(matches, Equals, true)
+}
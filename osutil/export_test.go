@@ -84,6 +84,38 @@ func MockSyscallGetpgid(f func(int) (int, error)) func() {
 	}
 }
 
+func MockLockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+func MockLockHolderLogf(f func(format string, v ...interface{})) (restore func()) {
+	old := lockHolderLogf
+	lockHolderLogf = f
+	return func() {
+		lockHolderLogf = old
+	}
+}
+
+func MockProcLocks(path string) (restore func()) {
+	old := procLocksPath
+	procLocksPath = path
+	return func() {
+		procLocksPath = old
+	}
+}
+
+func MockLockRetryInterval(d time.Duration) (restore func()) {
+	old := lockRetryInterval
+	lockRetryInterval = d
+	return func() {
+		lockRetryInterval = old
+	}
+}
+
 func MockCmdWaitTimeout(timeout time.Duration) func() {
 	oldCmdWaitTimeout := cmdWaitTimeout
 	cmdWaitTimeout = timeout
@@ -220,3 +252,9 @@ func MockProcSelfMountInfoLocation(new string) (restore func()) {
 		procSelfMountInfo = old
 	}
 }
+
+func MockIsIDInUse(f func(id uint32) (bool, error)) (restore func()) {
+	old := isIDInUse
+	isIDInUse = f
+	return func() { isIDInUse = old }
+}
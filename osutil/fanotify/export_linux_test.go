@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fanotify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ParseEvents = parseEvents
+	HandleKey   = handleKey
+)
+
+const (
+	FanCreate    = fanCreate
+	FanDelete    = fanDelete
+	FanMovedFrom = fanMovedFrom
+	FanMovedTo   = fanMovedTo
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// MockEvent returns a raw fanotify event of a change of the named entry of
+// the directory with the given file handle.
+func MockEvent(mask uint64, fsid [2]int32, handleType int32, handle []byte, name string) []byte {
+	var info bytes.Buffer
+	infoLen := int(unsafe.Sizeof(fanotifyEventInfoFID{})) + len(handle) + len(name) + 1
+	// info records are padded to 4 bytes
+	pad := (4 - infoLen%4) % 4
+	binary.Write(&info, nativeEndian, fanotifyEventInfoFID{
+		InfoType:    fanEventInfoTypeDFIDName,
+		Len:         uint16(infoLen + pad),
+		Fsid:        fsid,
+		HandleBytes: uint32(len(handle)),
+		HandleType:  handleType,
+	})
+	info.Write(handle)
+	info.WriteString(name)
+	info.Write(make([]byte, 1+pad))
+
+	metaLen := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	var buf bytes.Buffer
+	binary.Write(&buf, nativeEndian, unix.FanotifyEventMetadata{
+		Event_len:    uint32(metaLen + info.Len()),
+		Vers:         unix.FANOTIFY_METADATA_VERSION,
+		Metadata_len: uint16(metaLen),
+		Mask:         mask,
+		Fd:           -1,
+		Pid:          42,
+	})
+	buf.Write(info.Bytes())
+	return buf.Bytes()
+}
+
+// MockOverflowEvent returns a raw fanotify event of a queue overflow.
+func MockOverflowEvent() []byte {
+	metaLen := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	var buf bytes.Buffer
+	binary.Write(&buf, nativeEndian, unix.FanotifyEventMetadata{
+		Event_len:    uint32(metaLen),
+		Vers:         unix.FANOTIFY_METADATA_VERSION,
+		Metadata_len: uint16(metaLen),
+		Mask:         unix.FAN_Q_OVERFLOW,
+		Fd:           -1,
+	})
+	return buf.Bytes()
+}
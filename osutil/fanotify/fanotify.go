@@ -0,0 +1,65 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package fanotify watches directories for changes of their entries with
+// fanotify, which unlike inotify can watch many directories with a single
+// file descriptor and does not need a watch per directory to be allocated
+// in the kernel.
+package fanotify
+
+// Op is the kind of change of a directory entry.
+type Op int
+
+const (
+	// Create is reported when an entry is created in, or moved into, a
+	// watched directory.
+	Create Op = iota + 1
+	// Remove is reported when an entry is removed from, or moved out of,
+	// a watched directory.
+	Remove
+	// Write is reported when a file in a watched directory is modified.
+	Write
+	// Overflow is reported when events were lost because they were not
+	// read fast enough, the watched directories should be rescanned.
+	Overflow
+)
+
+func (op Op) String() string {
+	switch op {
+	case Create:
+		return "create"
+	case Remove:
+		return "remove"
+	case Write:
+		return "write"
+	case Overflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// Event is a change of an entry of a watched directory.
+type Event struct {
+	Op Op
+	// Dir is the watched directory, as it was given to Watcher.Add. It is
+	// empty for Overflow events.
+	Dir string
+	// Name is the name of the changed entry in the directory.
+	Name string
+}
@@ -0,0 +1,312 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fanotify
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/snapcore/snapd/osutil/udev/netlink"
+)
+
+// constants of linux/fanotify.h that are not available in all the supported
+// versions of golang.org/x/sys/unix
+const (
+	// FAN_REPORT_DIR_FID and FAN_REPORT_NAME need Linux 5.9, they are
+	// also the flags allowing unprivileged use of fanotify since
+	// Linux 5.13
+	fanReportDirFID = 0x00000400
+	fanReportName   = 0x00000800
+
+	fanMovedFrom = 0x00000040
+	fanMovedTo   = 0x00000080
+	fanCreate    = 0x00000100
+	fanDelete    = 0x00000200
+
+	fanEventInfoTypeFID      = 1
+	fanEventInfoTypeDFIDName = 2
+	fanEventInfoTypeDFID     = 3
+)
+
+// watchMask is the mask of the events reported for the entries of the
+// watched directories.
+const watchMask = fanCreate | fanDelete | fanMovedFrom | fanMovedTo |
+	unix.FAN_MODIFY | unix.FAN_CLOSE_WRITE | unix.FAN_ONDIR | unix.FAN_EVENT_ON_CHILD
+
+// fanotifyEventInfoFID is struct fanotify_event_info_fid, followed by a
+// struct file_handle, which is followed by the name of the entry for
+// FAN_EVENT_INFO_TYPE_DFID_NAME records.
+type fanotifyEventInfoFID struct {
+	InfoType    uint8
+	Pad         uint8
+	Len         uint16
+	Fsid        [2]int32
+	HandleBytes uint32
+	HandleType  int32
+}
+
+// Watcher reports changes of the entries of directories.
+type Watcher struct {
+	// Events receives the changes of the entries of the watched
+	// directories.
+	Events chan Event
+	// Errors receives the errors reading the changes, events may have
+	// been lost after an error.
+	Errors chan error
+
+	fd             int
+	readableOrStop func() (bool, error)
+	stop           func()
+	closing        chan struct{}
+	done           chan struct{}
+	closeOnce      sync.Once
+
+	mu sync.Mutex
+	// dirs maps the keys of the file handles of the watched directories
+	// to their path, and keys the reverse
+	dirs map[string]string
+	keys map[string]string
+}
+
+// NewWatcher returns a watcher with no watched directories. It needs Linux
+// 5.9, and CAP_SYS_ADMIN before Linux 5.13.
+func NewWatcher() (*Watcher, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|fanReportDirFID|fanReportName, unix.O_RDONLY|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize fanotify: %v", err)
+	}
+	readableOrStop, stop, err := netlink.RawSockStopper(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("cannot initialize fanotify: %v", err)
+	}
+	w := &Watcher{
+		Events:         make(chan Event),
+		Errors:         make(chan error),
+		fd:             fd,
+		readableOrStop: readableOrStop,
+		stop:           stop,
+		closing:        make(chan struct{}),
+		done:           make(chan struct{}),
+		dirs:           make(map[string]string),
+		keys:           make(map[string]string),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// handleKey returns the key of a file handle, unique among all filesystems.
+func handleKey(fsid [2]int32, handleType int32, handle []byte) string {
+	return fmt.Sprintf("%x.%x:%d:%x", uint32(fsid[0]), uint32(fsid[1]), handleType, handle)
+}
+
+func dirHandleKey(dir string) (string, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, dir, 0)
+	if err != nil {
+		return "", err
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return "", err
+	}
+	// the fsid reported by fanotify is the one reported by statfs
+	return handleKey(st.Fsid.Val, handle.Type(), handle.Bytes()), nil
+}
+
+// Add starts watching the entries of the given directory. Entries of its
+// subdirectories are not watched.
+func (w *Watcher) Add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.keys[dir]; ok {
+		return nil
+	}
+	key, err := dirHandleKey(dir)
+	if err != nil {
+		return fmt.Errorf("cannot watch %s: %v", dir, err)
+	}
+	if err := unix.FanotifyMark(w.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_ONLYDIR, watchMask, unix.AT_FDCWD, dir); err != nil {
+		return fmt.Errorf("cannot watch %s: %v", dir, err)
+	}
+	w.dirs[key] = dir
+	w.keys[dir] = key
+	return nil
+}
+
+// Remove stops watching the entries of the given directory.
+func (w *Watcher) Remove(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key, ok := w.keys[dir]
+	if !ok {
+		return fmt.Errorf("cannot stop watching %s: not watched", dir)
+	}
+	delete(w.dirs, key)
+	delete(w.keys, dir)
+	if err := unix.FanotifyMark(w.fd, unix.FAN_MARK_REMOVE|unix.FAN_MARK_ONLYDIR, watchMask, unix.AT_FDCWD, dir); err != nil {
+		return fmt.Errorf("cannot stop watching %s: %v", dir, err)
+	}
+	return nil
+}
+
+// Close stops watching all the directories, no more events are sent once it
+// returns.
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.closing)
+		w.stop()
+		<-w.done
+		err = unix.Close(w.fd)
+	})
+	return err
+}
+
+func (w *Watcher) send(ev Event) bool {
+	select {
+	case w.Events <- ev:
+		return true
+	case <-w.closing:
+		return false
+	}
+}
+
+func (w *Watcher) sendError(err error) bool {
+	select {
+	case w.Errors <- err:
+		return true
+	case <-w.closing:
+		return false
+	}
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.done)
+
+	buf := make([]byte, 16*1024)
+	for {
+		readable, err := w.readableOrStop()
+		select {
+		case <-w.closing:
+			return
+		default:
+		}
+		if err != nil {
+			w.sendError(fmt.Errorf("cannot wait for fanotify events: %v", err))
+			return
+		}
+		if !readable {
+			continue
+		}
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			if !w.sendError(fmt.Errorf("cannot read fanotify events: %v", err)) {
+				return
+			}
+			continue
+		}
+		w.mu.Lock()
+		events := parseEvents(buf[:n], w.dirs)
+		w.mu.Unlock()
+		for _, ev := range events {
+			if !w.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// opsFromMask returns the operations matching the given fanotify event mask.
+func opsFromMask(mask uint64) []Op {
+	var ops []Op
+	if mask&(fanCreate|fanMovedTo) != 0 {
+		ops = append(ops, Create)
+	}
+	if mask&(unix.FAN_MODIFY|unix.FAN_CLOSE_WRITE) != 0 {
+		ops = append(ops, Write)
+	}
+	if mask&(fanDelete|fanMovedFrom) != 0 {
+		ops = append(ops, Remove)
+	}
+	return ops
+}
+
+// parseEvents parses the fanotify events read from a buffer, events of
+// directories missing from the given map of handle keys to directories are
+// dropped.
+func parseEvents(buf []byte, dirs map[string]string) []Event {
+	var events []Event
+	metaSize := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	infoSize := int(unsafe.Sizeof(fanotifyEventInfoFID{}))
+	for len(buf) >= metaSize {
+		meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[0]))
+		eventLen := int(meta.Event_len)
+		if eventLen < metaSize || eventLen > len(buf) || meta.Vers != unix.FANOTIFY_METADATA_VERSION {
+			break
+		}
+		if meta.Mask&unix.FAN_Q_OVERFLOW != 0 {
+			events = append(events, Event{Op: Overflow})
+		}
+
+		var dir, name string
+		info := buf[meta.Metadata_len:eventLen]
+		for len(info) >= infoSize {
+			fid := (*fanotifyEventInfoFID)(unsafe.Pointer(&info[0]))
+			infoLen := int(fid.Len)
+			if infoLen < infoSize || infoLen > len(info) {
+				break
+			}
+			handleEnd := infoSize + int(fid.HandleBytes)
+			if handleEnd <= infoLen {
+				switch fid.InfoType {
+				case fanEventInfoTypeDFIDName, fanEventInfoTypeDFID:
+					key := handleKey(fid.Fsid, fid.HandleType, info[infoSize:handleEnd])
+					dir = dirs[key]
+					if fid.InfoType == fanEventInfoTypeDFIDName {
+						n := info[handleEnd:infoLen]
+						if end := bytes.IndexByte(n, 0); end >= 0 {
+							n = n[:end]
+						}
+						name = string(n)
+					}
+				case fanEventInfoTypeFID:
+					// the changed object itself, which is only
+					// needed to watch objects other than
+					// directory entries
+				}
+			}
+			info = info[infoLen:]
+		}
+		if dir != "" && name != "" && name != "." {
+			for _, op := range opsFromMask(meta.Mask) {
+				events = append(events, Event{Op: op, Dir: dir, Name: name})
+			}
+		}
+		buf = buf[eventLen:]
+	}
+	return events
+}
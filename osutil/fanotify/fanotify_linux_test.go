@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fanotify_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil/fanotify"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type fanotifySuite struct{}
+
+var _ = Suite(&fanotifySuite{})
+
+func (s *fanotifySuite) TestParseEvents(c *C) {
+	fsid := [2]int32{0x1234, -2}
+	dirs := map[string]string{
+		fanotify.HandleKey(fsid, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}): "/var/lib/snapd/inhibit",
+	}
+
+	var buf []byte
+	buf = append(buf, fanotify.MockEvent(fanotify.FanCreate, fsid, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, "foo.lock")...)
+	buf = append(buf, fanotify.MockEvent(unix.FAN_MODIFY|unix.FAN_CLOSE_WRITE, fsid, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, "foo.lock")...)
+	// not a watched directory
+	buf = append(buf, fanotify.MockEvent(fanotify.FanCreate, fsid, 1, []byte{8, 7, 6, 5, 4, 3, 2, 1}, "bar.lock")...)
+	buf = append(buf, fanotify.MockOverflowEvent()...)
+	buf = append(buf, fanotify.MockEvent(fanotify.FanMovedFrom, fsid, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, "foo-longer-name.lock")...)
+	buf = append(buf, fanotify.MockEvent(fanotify.FanMovedTo|unix.FAN_ONDIR, fsid, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, "dir")...)
+	// truncated events are dropped
+	buf = append(buf, fanotify.MockEvent(fanotify.FanDelete, fsid, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}, "foo.lock")[:30]...)
+
+	c.Check(fanotify.ParseEvents(buf, dirs), DeepEquals, []fanotify.Event{
+		{Op: fanotify.Create, Dir: "/var/lib/snapd/inhibit", Name: "foo.lock"},
+		{Op: fanotify.Write, Dir: "/var/lib/snapd/inhibit", Name: "foo.lock"},
+		{Op: fanotify.Overflow},
+		{Op: fanotify.Remove, Dir: "/var/lib/snapd/inhibit", Name: "foo-longer-name.lock"},
+		{Op: fanotify.Create, Dir: "/var/lib/snapd/inhibit", Name: "dir"},
+	})
+}
+
+func (s *fanotifySuite) TestWatcher(c *C) {
+	w, err := fanotify.NewWatcher()
+	if err != nil {
+		c.Skip("fanotify is not available: " + err.Error())
+	}
+	defer w.Close()
+
+	dir := c.MkDir()
+	if err := w.Add(dir); err != nil {
+		c.Skip("cannot watch with fanotify: " + err.Error())
+	}
+	c.Assert(w.Add(dir), IsNil)
+
+	expect := func(ev fanotify.Event) {
+		for {
+			select {
+			case got := <-w.Events:
+				if got == ev {
+					return
+				}
+			case err := <-w.Errors:
+				c.Fatalf("unexpected error: %v", err)
+			case <-time.After(5 * time.Second):
+				c.Fatalf("timeout waiting for %v", ev)
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "foo.lock")
+	c.Assert(ioutil.WriteFile(path, []byte("refresh"), 0644), IsNil)
+	expect(fanotify.Event{Op: fanotify.Create, Dir: dir, Name: "foo.lock"})
+	expect(fanotify.Event{Op: fanotify.Write, Dir: dir, Name: "foo.lock"})
+	c.Assert(os.Remove(path), IsNil)
+	expect(fanotify.Event{Op: fanotify.Remove, Dir: dir, Name: "foo.lock"})
+
+	c.Assert(w.Remove(dir), IsNil)
+	c.Check(w.Remove(dir), ErrorMatches, "cannot stop watching .*: not watched")
+
+	c.Assert(w.Close(), IsNil)
+	// closing again is fine
+	c.Assert(w.Close(), IsNil)
+}
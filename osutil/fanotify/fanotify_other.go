@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !linux
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fanotify
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Watcher reports changes of the entries of directories.
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+}
+
+// NewWatcher returns an error as fanotify is only available on Linux.
+func NewWatcher() (*Watcher, error) {
+	return nil, fmt.Errorf("cannot watch directories with fanotify on %s", runtime.GOOS)
+}
+
+func (w *Watcher) Add(dir string) error {
+	return fmt.Errorf("cannot watch directories with fanotify on %s", runtime.GOOS)
+}
+
+func (w *Watcher) Remove(dir string) error {
+	return fmt.Errorf("cannot watch directories with fanotify on %s", runtime.GOOS)
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}
@@ -20,18 +20,78 @@
 package osutil
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
+	"strings"
 	"syscall"
+	"time"
 )
 
 // FileLock describes a file system lock
 type FileLock struct {
 	file *os.File
+
+	// operation is recorded in the holder metadata when set
+	operation     string
+	holderWritten bool
 }
 
 var ErrAlreadyLocked = errors.New("cannot acquire lock, already locked")
 
+// LockHolder describes the process holding a lock, as recorded by
+// the holder itself.
+type LockHolder struct {
+	PID       int       `json:"pid"`
+	Operation string    `json:"operation,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// LockContentionError is returned when a lock could not be acquired
+// because it is held by someone else.
+type LockContentionError struct {
+	Path string
+	// Holder is nil if the holder did not record any metadata
+	Holder *LockHolder
+	// Waited is how long we waited for the lock
+	Waited time.Duration
+}
+
+func (e *LockContentionError) Error() string {
+	msg := fmt.Sprintf("cannot acquire lock %s", e.Path)
+	if e.Waited > 0 {
+		msg += fmt.Sprintf(" within %v", e.Waited)
+	}
+	if e.Holder == nil {
+		return msg + ": held by an unknown process"
+	}
+	msg += fmt.Sprintf(": held by pid %d", e.Holder.PID)
+	if e.Holder.Operation != "" {
+		msg += fmt.Sprintf(" (%s)", e.Holder.Operation)
+	}
+	return msg + fmt.Sprintf(" since %s", e.Holder.Since.Format(time.RFC3339))
+}
+
+var (
+	timeNow           = time.Now
+	lockRetryInterval = 100 * time.Millisecond
+
+	// osutil cannot use the logger package, which depends on it
+	lockHolderLogf = log.Printf
+
+	procLocksPath = "/proc/locks"
+)
+
+// lockHolderPath returns the path of the file where the holder metadata
+// of the lock at the given path is recorded.
+func lockHolderPath(path string) string {
+	return path + ".holder"
+}
+
 // OpenExistingLockForReading opens an existing lock file given by "path".
 // The lock is opened in read-only mode.
 func OpenExistingLockForReading(path string) (*FileLock, error) {
@@ -60,6 +120,12 @@ func NewFileLock(path string) (*FileLock, error) {
 	return NewFileLockWithMode(path, 0600)
 }
 
+// SetOperation sets the operation recorded in the holder metadata whenever
+// the lock is acquired exclusively, to help diagnose contention.
+func (l *FileLock) SetOperation(operation string) {
+	l.operation = operation
+}
+
 // Path returns the path of the lock file.
 func (l *FileLock) Path() string {
 	return l.file.Name()
@@ -72,15 +138,77 @@ func (l *FileLock) File() *os.File {
 
 // Close closes the lock, unlocking it automatically if needed.
 func (l *FileLock) Close() error {
+	l.removeHolder()
 	return l.file.Close()
 }
 
+func (l *FileLock) writeHolder() error {
+	if l.operation == "" {
+		return nil
+	}
+	holder := LockHolder{
+		PID:       os.Getpid(),
+		Operation: l.operation,
+		Since:     timeNow(),
+	}
+	data, err := json.Marshal(&holder)
+	if err != nil {
+		return err
+	}
+	if err := AtomicWriteFile(lockHolderPath(l.Path()), data, 0644, 0); err != nil {
+		return fmt.Errorf("cannot record lock holder: %v", err)
+	}
+	l.holderWritten = true
+	return nil
+}
+
+func (l *FileLock) removeHolder() {
+	if !l.holderWritten {
+		return
+	}
+	// the lock is still held, nobody else can have written it
+	os.Remove(lockHolderPath(l.Path()))
+	l.holderWritten = false
+}
+
 // Lock acquires an exclusive lock and blocks until the lock is free.
 //
 // Only one process can acquire an exclusive lock at a given time, preventing
 // shared or exclusive locks from being acquired.
 func (l *FileLock) Lock() error {
-	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_EX)
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	l.acquired()
+	return nil
+}
+
+// LockWithTimeout acquires an exclusive lock, waiting at most for the given
+// duration for the lock to be free. A *LockContentionError describing the
+// holder of the lock is returned on timeout.
+func (l *FileLock) LockWithTimeout(timeout time.Duration) error {
+	start := timeNow()
+	for {
+		err := l.TryLock()
+		if err != ErrAlreadyLocked {
+			return err
+		}
+		waited := timeNow().Sub(start)
+		if waited >= timeout {
+			holder, _ := readLiveLockHolder(l.Path())
+			return &LockContentionError{Path: l.Path(), Holder: holder, Waited: waited}
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// acquired records the holder metadata once the lock is held exclusively.
+// The metadata only helps diagnosing contention, failing to record it does
+// not fail the lock.
+func (l *FileLock) acquired() {
+	if err := l.writeHolder(); err != nil {
+		lockHolderLogf("WARNING: %v", err)
+	}
 }
 
 // Lock acquires an shared lock and blocks until the lock is free.
@@ -88,7 +216,12 @@ func (l *FileLock) Lock() error {
 // Multiple processes can acquire a shared lock at the same time, unless an
 // exclusive lock is held.
 func (l *FileLock) ReadLock() error {
-	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_SH)
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_SH); err != nil {
+		return err
+	}
+	// only exclusive holders are recorded
+	l.removeHolder()
+	return nil
 }
 
 // TryLock acquires an exclusive lock and errors if the lock cannot be acquired.
@@ -97,10 +230,118 @@ func (l *FileLock) TryLock() error {
 	if err == syscall.EWOULDBLOCK {
 		return ErrAlreadyLocked
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	l.acquired()
+	return nil
 }
 
 // Unlock releases an acquired lock.
 func (l *FileLock) Unlock() error {
+	l.removeHolder()
 	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
 }
+
+// ReadLockHolder returns the holder metadata recorded for the lock at the
+// given path. The metadata may be stale if the holder did not release the
+// lock cleanly, see InspectLock.
+func ReadLockHolder(path string) (*LockHolder, error) {
+	data, err := ioutil.ReadFile(lockHolderPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var holder LockHolder
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return nil, fmt.Errorf("cannot decode lock holder of %s: %v", path, err)
+	}
+	return &holder, nil
+}
+
+// readLiveLockHolder returns the recorded holder of the lock if the
+// holding process is still around, or nil.
+func readLiveLockHolder(path string) (*LockHolder, error) {
+	holder, err := ReadLockHolder(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if syscallKill(holder.PID, 0) == syscall.ESRCH {
+		// the holder went away without cleaning up
+		return nil, nil
+	}
+	return holder, nil
+}
+
+// LockInfo describes the state of a lock file.
+type LockInfo struct {
+	Path string `json:"path"`
+	// Locked is set when the lock is held exclusively
+	Locked bool `json:"locked"`
+	// Holder is the recorded holder of the lock, if any
+	Holder *LockHolder `json:"holder,omitempty"`
+}
+
+// InspectLock reports whether the existing lock file at the given path is
+// held exclusively, and by whom if the holder recorded it. The lock itself
+// is not taken, so that inspecting never gets in the way of a holder, the
+// kernel list of file locks is checked instead.
+func InspectLock(path string) (*LockInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("cannot inspect lock %s: unsupported file information", path)
+	}
+
+	info := &LockInfo{Path: path}
+	info.Locked, err = isFlockedExclusively(uint64(st.Dev), uint64(st.Ino))
+	if err != nil {
+		return nil, fmt.Errorf("cannot inspect lock %s: %v", path, err)
+	}
+	if !info.Locked {
+		return info, nil
+	}
+	info.Holder, err = readLiveLockHolder(path)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// isFlockedExclusively returns whether the file with the given device and
+// inode numbers is held with an exclusive flock, according to /proc/locks
+// where such a lock is listed as:
+//
+//	1: FLOCK  ADVISORY  WRITE 1234 fd:01:5678 0 EOF
+//
+// the file being identified by the hexadecimal major and minor numbers of
+// its device and its inode number. Processes waiting for a lock are listed
+// with a "->" after the index and are ignored.
+func isFlockedExclusively(dev, ino uint64) (bool, error) {
+	f, err := os.Open(procLocksPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	major := ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+	minor := (dev & 0xff) | ((dev >> 12) &^ 0xff)
+	id := fmt.Sprintf("%02x:%02x:%d", major, minor, ino)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] != "FLOCK" {
+			continue
+		}
+		if fields[3] == "WRITE" && fields[5] == id {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
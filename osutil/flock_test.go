@@ -22,14 +22,18 @@ package osutil_test
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 	"time"
 
+	"golang.org/x/sys/unix"
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type flockSuite struct{}
@@ -243,3 +247,236 @@ func (s *flockSuite) TestLockUnlockNonblockingWorks(c *C) {
 
 	c.Assert(lock.TryLock(), Equals, osutil.ErrAlreadyLocked)
 }
+
+func (s *flockSuite) TestLockRecordsHolder(c *C) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	restore := osutil.MockLockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	lock, err := osutil.NewFileLock(filepath.Join(c.MkDir(), "name"))
+	c.Assert(err, IsNil)
+	defer lock.Close()
+	lock.SetOperation("some operation")
+
+	c.Assert(lock.Lock(), IsNil)
+	holder, err := osutil.ReadLockHolder(lock.Path())
+	c.Assert(err, IsNil)
+	c.Check(holder, DeepEquals, &osutil.LockHolder{
+		PID:       os.Getpid(),
+		Operation: "some operation",
+		Since:     now,
+	})
+
+	c.Assert(lock.Unlock(), IsNil)
+	c.Check(lock.Path()+".holder", testutil.FileAbsent)
+
+	// same with TryLock, closing the lock removes the metadata too
+	c.Assert(lock.TryLock(), IsNil)
+	c.Check(lock.Path()+".holder", testutil.FilePresent)
+	c.Assert(lock.Close(), IsNil)
+	c.Check(lock.Path()+".holder", testutil.FileAbsent)
+}
+
+func (s *flockSuite) TestLockHolderNotRecordedOnlyLogged(c *C) {
+	var logged []string
+	restore := osutil.MockLockHolderLogf(func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	})
+	defer restore()
+
+	lock, err := osutil.NewFileLock(filepath.Join(c.MkDir(), "name"))
+	c.Assert(err, IsNil)
+	defer lock.Close()
+	lock.SetOperation("some operation")
+	// the holder metadata cannot be written over a directory
+	c.Assert(os.Mkdir(lock.Path()+".holder", 0755), IsNil)
+
+	c.Assert(lock.Lock(), IsNil)
+	c.Assert(logged, HasLen, 1)
+	c.Check(logged[0], Matches, "WARNING: cannot record lock holder: .*")
+
+	// the lock is held nonetheless
+	other, err := osutil.NewFileLock(lock.Path())
+	c.Assert(err, IsNil)
+	defer other.Close()
+	c.Check(other.TryLock(), Equals, osutil.ErrAlreadyLocked)
+
+	c.Assert(lock.Unlock(), IsNil)
+	c.Check(lock.Path()+".holder", testutil.FilePresent)
+	c.Assert(lock.TryLock(), IsNil)
+	c.Check(logged, HasLen, 2)
+}
+
+func (s *flockSuite) TestLockNoOperationNoHolder(c *C) {
+	lock, err := osutil.NewFileLock(filepath.Join(c.MkDir(), "name"))
+	c.Assert(err, IsNil)
+	defer lock.Close()
+
+	c.Assert(lock.Lock(), IsNil)
+	c.Check(lock.Path()+".holder", testutil.FileAbsent)
+	_, err = osutil.ReadLockHolder(lock.Path())
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *flockSuite) TestReadLockHolderCorrupted(c *C) {
+	path := filepath.Join(c.MkDir(), "name")
+	c.Assert(ioutil.WriteFile(path+".holder", []byte("{"), 0644), IsNil)
+
+	_, err := osutil.ReadLockHolder(path)
+	c.Check(err, ErrorMatches, "cannot decode lock holder of .*/name: unexpected end of JSON input")
+}
+
+func (s *flockSuite) TestLockWithTimeout(c *C) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	restore := osutil.MockLockTimeNow(func() time.Time { return now })
+	defer restore()
+	restore = osutil.MockLockRetryInterval(time.Millisecond)
+	defer restore()
+
+	path := filepath.Join(c.MkDir(), "name")
+	holder, err := osutil.NewFileLock(path)
+	c.Assert(err, IsNil)
+	defer holder.Close()
+	holder.SetOperation("some operation")
+	c.Assert(holder.Lock(), IsNil)
+
+	// flock locks conflict across open files, even in the same process
+	lock, err := osutil.NewFileLock(path)
+	c.Assert(err, IsNil)
+	defer lock.Close()
+
+	restore = osutil.MockLockTimeNow(func() time.Time {
+		now = now.Add(10 * time.Millisecond)
+		return now
+	})
+	defer restore()
+
+	err = lock.LockWithTimeout(50 * time.Millisecond)
+	c.Assert(err, FitsTypeOf, &osutil.LockContentionError{})
+	c.Check(err, ErrorMatches, `cannot acquire lock .*/name within 50ms: held by pid [0-9]+ \(some operation\) since 2021-03-04T05:06:07Z`)
+	c.Check(err.(*osutil.LockContentionError).Holder.PID, Equals, os.Getpid())
+
+	// once the lock is released it can be acquired
+	c.Assert(holder.Unlock(), IsNil)
+	c.Assert(lock.LockWithTimeout(50*time.Millisecond), IsNil)
+}
+
+func (s *flockSuite) TestLockWithTimeoutUnknownHolder(c *C) {
+	restore := osutil.MockLockRetryInterval(time.Millisecond)
+	defer restore()
+
+	path := filepath.Join(c.MkDir(), "name")
+	holder, err := osutil.NewFileLock(path)
+	c.Assert(err, IsNil)
+	defer holder.Close()
+	c.Assert(holder.Lock(), IsNil)
+
+	lock, err := osutil.NewFileLock(path)
+	c.Assert(err, IsNil)
+	defer lock.Close()
+
+	err = lock.LockWithTimeout(0)
+	c.Check(err, ErrorMatches, `cannot acquire lock .*/name within .*: held by an unknown process`)
+}
+
+func (s *flockSuite) TestLockContentionErrorNoWait(c *C) {
+	err := &osutil.LockContentionError{
+		Path: "/path/to/lock",
+		Holder: &osutil.LockHolder{
+			PID:   123,
+			Since: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		},
+	}
+	c.Check(err, ErrorMatches, `cannot acquire lock /path/to/lock: held by pid 123 since 2021-03-04T05:06:07Z`)
+}
+
+func (s *flockSuite) TestInspectLock(c *C) {
+	path := filepath.Join(c.MkDir(), "name")
+
+	_, err := osutil.InspectLock(path)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	lock, err := osutil.NewFileLock(path)
+	c.Assert(err, IsNil)
+	defer lock.Close()
+
+	info, err := osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &osutil.LockInfo{Path: path})
+
+	// held, but without metadata
+	c.Assert(lock.Lock(), IsNil)
+	info, err = osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &osutil.LockInfo{Path: path, Locked: true})
+	c.Assert(lock.Unlock(), IsNil)
+
+	lock.SetOperation("some operation")
+	c.Assert(lock.Lock(), IsNil)
+	info, err = osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info.Locked, Equals, true)
+	c.Assert(info.Holder, NotNil)
+	c.Check(info.Holder.PID, Equals, os.Getpid())
+	c.Check(info.Holder.Operation, Equals, "some operation")
+
+	// the lock is still held, by someone
+	c.Assert(lock.Unlock(), IsNil)
+	c.Assert(lock.ReadLock(), IsNil)
+	info, err = osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &osutil.LockInfo{Path: path})
+}
+
+func (s *flockSuite) TestInspectLockFromProcLocks(c *C) {
+	path := filepath.Join(c.MkDir(), "name")
+	c.Assert(ioutil.WriteFile(path, nil, 0600), IsNil)
+	var st unix.Stat_t
+	c.Assert(unix.Stat(path, &st), IsNil)
+	id := fmt.Sprintf("%02x:%02x:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)), st.Ino)
+
+	procLocks := filepath.Join(c.MkDir(), "locks")
+	restore := osutil.MockProcLocks(procLocks)
+	defer restore()
+
+	// shared locks, waiters and locks of other files do not count
+	notLocked := fmt.Sprintf(`1: POSIX  ADVISORY  WRITE 100 %[1]s 0 EOF
+2: FLOCK  ADVISORY  READ 101 %[1]s 0 EOF
+2: -> FLOCK  ADVISORY  WRITE 102 %[1]s 0 EOF
+3: FLOCK  ADVISORY  WRITE 103 %[1]s1 0 EOF
+`, id)
+	c.Assert(ioutil.WriteFile(procLocks, []byte(notLocked), 0644), IsNil)
+	info, err := osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &osutil.LockInfo{Path: path})
+
+	locked := notLocked + fmt.Sprintf("4: FLOCK  ADVISORY  WRITE 104 %s 0 EOF\n", id)
+	c.Assert(ioutil.WriteFile(procLocks, []byte(locked), 0644), IsNil)
+	info, err = osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &osutil.LockInfo{Path: path, Locked: true})
+
+	c.Assert(os.Remove(procLocks), IsNil)
+	_, err = osutil.InspectLock(path)
+	c.Check(err, ErrorMatches, "cannot inspect lock .*/name: open .*/locks: no such file or directory")
+}
+
+func (s *flockSuite) TestInspectLockStaleHolder(c *C) {
+	path := filepath.Join(c.MkDir(), "name")
+	lock, err := osutil.NewFileLock(path)
+	c.Assert(err, IsNil)
+	defer lock.Close()
+	lock.SetOperation("some operation")
+	c.Assert(lock.Lock(), IsNil)
+
+	restore := osutil.MockSyscallKill(func(pid int, sig syscall.Signal) error {
+		c.Check(pid, Equals, os.Getpid())
+		c.Check(sig, Equals, syscall.Signal(0))
+		return syscall.ESRCH
+	})
+	defer restore()
+
+	info, err := osutil.InspectLock(path)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &osutil.LockInfo{Path: path, Locked: true})
+}
@@ -21,6 +21,7 @@ package osutil
 
 import (
 	"fmt"
+	"hash/fnv"
 	"os"
 	"os/exec"
 	"os/user"
@@ -165,6 +166,111 @@ func EnsureUserGroup(name string, id uint32, extraUsers bool) error {
 	return nil
 }
 
+// isIDInUse returns whether the given identifier is assigned to a user or a
+// group, as determined by NSS.
+var isIDInUse = func(id uint32) (bool, error) {
+	idStr := strconv.FormatUint(uint64(id), 10)
+	for _, database := range []string{"passwd", "group"} {
+		_, err := getent(database, idStr)
+		switch {
+		case err == nil:
+			return true, nil
+		case IsUnknownUser(err), IsUnknownGroup(err):
+			// not in this database
+		default:
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// EnsureUserGroupInRange is like EnsureUserGroup but picks the uid and gid of
+// the new user and group in the [min, max] range. The identifier derives from
+// the name so that the same user and group get the same one across systems
+// when possible, the next free identifier is used otherwise. An existing user
+// and group keep their identifier as long as it is in the range. The assigned
+// identifier is returned.
+func EnsureUserGroupInRange(name string, min, max uint32, extraUsers bool) (uint32, error) {
+	if !IsValidUsername(name) {
+		return 0, fmt.Errorf(`cannot add user/group %q: name contains invalid characters`, name)
+	}
+	if min > max {
+		return 0, fmt.Errorf("internal error: invalid range %d-%d", min, max)
+	}
+
+	uid, err := FindUid(name)
+	if err != nil && !IsUnknownUser(err) {
+		return 0, err
+	}
+	if err == nil {
+		if uid < uint64(min) || uid > uint64(max) {
+			return 0, fmt.Errorf(`found unexpected uid for user %q: %d`, name, uid)
+		}
+		// EnsureUserGroup checks the group
+		return uint32(uid), EnsureUserGroup(name, uint32(uid), extraUsers)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	size := uint64(max-min) + 1
+	start := uint64(h.Sum32()) % size
+	for i := uint64(0); i < size; i++ {
+		id := min + uint32((start+i)%size)
+		inUse, err := isIDInUse(id)
+		if err != nil {
+			return 0, err
+		}
+		if inUse {
+			continue
+		}
+		return id, EnsureUserGroup(name, id, extraUsers)
+	}
+	return 0, fmt.Errorf(`cannot add user/group %q: no free identifier in range %d-%d`, name, min, max)
+}
+
+// DelUserGroup removes the non-login system user and group of the given name
+// created with EnsureUserGroup, if they exist.
+func DelUserGroup(name string, extraUsers bool) error {
+	if !IsValidUsername(name) {
+		return fmt.Errorf(`cannot remove user/group %q: name contains invalid characters`, name)
+	}
+
+	_, err := FindUid(name)
+	switch {
+	case err == nil:
+		userCmdStr := []string{"userdel"}
+		if extraUsers {
+			userCmdStr = append(userCmdStr, "--extrausers")
+		}
+		userCmdStr = append(userCmdStr, name)
+		cmd := exec.Command(userCmdStr[0], userCmdStr[1:]...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("userdel failed with: %s", OutputErr(output, err))
+		}
+	case !IsUnknownUser(err):
+		return err
+	}
+
+	// userdel removes the group of the same name only when it was
+	// created along with the user
+	_, err = FindGid(name)
+	switch {
+	case err == nil:
+		// TODO: groupdel doesn't currently support --extrausers, so
+		// the group is left behind then (LP: #1840375)
+		if extraUsers {
+			return nil
+		}
+		cmd := exec.Command("groupdel", name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("groupdel failed with: %s", OutputErr(output, err))
+		}
+	case !IsUnknownGroup(err):
+		return err
+	}
+	return nil
+}
+
 func sudoersFile(name string) string {
 	// Must escape "." as files containing it are ignored in sudoers.d.
 	return filepath.Join(sudoersDotD, "create-user-"+strings.Replace(name, ".", "%2E", -1))
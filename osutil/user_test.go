@@ -360,6 +360,7 @@ type ensureUserSuite struct {
 	mockUserAdd  *testutil.MockCmd
 	mockGroupAdd *testutil.MockCmd
 	mockGroupDel *testutil.MockCmd
+	mockUserDel  *testutil.MockCmd
 }
 
 var _ = check.Suite(&ensureUserSuite{})
@@ -368,12 +369,14 @@ func (s *ensureUserSuite) SetUpTest(c *check.C) {
 	s.mockUserAdd = testutil.MockCommand(c, "useradd", "")
 	s.mockGroupAdd = testutil.MockCommand(c, "groupadd", "")
 	s.mockGroupDel = testutil.MockCommand(c, "groupdel", "")
+	s.mockUserDel = testutil.MockCommand(c, "userdel", "")
 }
 
 func (s *ensureUserSuite) TearDownTest(c *check.C) {
 	s.mockUserAdd.Restore()
 	s.mockGroupAdd.Restore()
 	s.mockGroupDel.Restore()
+	s.mockUserDel.Restore()
 }
 
 func (s *ensureUserSuite) TestEnsureUserGroupExtraUsersFalse(c *check.C) {
@@ -559,3 +562,162 @@ func (s *ensureUserSuite) TestEnsureUserGroupFailedUseraddCore(c *check.C) {
 	*/
 	c.Check(s.mockGroupDel.Calls(), check.DeepEquals, [][]string(nil))
 }
+
+func (s *ensureUserSuite) TestEnsureUserGroupInRangeNew(c *check.C) {
+	falsePath = osutil.LookPathDefault("false", "/bin/false")
+	var checked []uint32
+	restore := osutil.MockIsIDInUse(func(id uint32) (bool, error) {
+		checked = append(checked, id)
+		// the identifier derived from the name and the next one are taken
+		return id == 1000 || id == 1001, nil
+	})
+	defer restore()
+
+	id, err := osutil.EnsureUserGroupInRange("lakatos", 1000, 1009, false)
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, uint32(1002))
+	c.Check(checked, check.DeepEquals, []uint32{1000, 1001, 1002})
+
+	c.Check(s.mockGroupAdd.Calls(), check.DeepEquals, [][]string{
+		{"groupadd", "--system", "--gid", "1002", "lakatos"},
+	})
+	c.Check(s.mockUserAdd.Calls(), check.DeepEquals, [][]string{
+		{"useradd", "--system", "--home-dir", "/nonexistent", "--no-create-home", "--shell", falsePath, "--gid", "1002", "--no-user-group", "--uid", "1002", "lakatos"},
+	})
+}
+
+func (s *ensureUserSuite) TestEnsureUserGroupInRangeWraps(c *check.C) {
+	restore := osutil.MockIsIDInUse(func(id uint32) (bool, error) {
+		return id != 1000, nil
+	})
+	defer restore()
+
+	// the identifier derived from the name is 1002
+	id, err := osutil.EnsureUserGroupInRange("lakatos", 998, 1005, true)
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, uint32(1000))
+	c.Check(s.mockGroupAdd.Calls(), check.DeepEquals, [][]string{
+		{"groupadd", "--system", "--gid", "1000", "--extrausers", "lakatos"},
+	})
+}
+
+func (s *ensureUserSuite) TestEnsureUserGroupInRangeNoneFree(c *check.C) {
+	restore := osutil.MockIsIDInUse(func(id uint32) (bool, error) {
+		return true, nil
+	})
+	defer restore()
+
+	_, err := osutil.EnsureUserGroupInRange("lakatos", 1000, 1009, false)
+	c.Assert(err, check.ErrorMatches, `cannot add user/group "lakatos": no free identifier in range 1000-1009`)
+	c.Check(s.mockGroupAdd.Calls(), check.HasLen, 0)
+	c.Check(s.mockUserAdd.Calls(), check.HasLen, 0)
+}
+
+func (s *ensureUserSuite) TestEnsureUserGroupInRangeLookupError(c *check.C) {
+	restore := osutil.MockIsIDInUse(func(id uint32) (bool, error) {
+		return false, fmt.Errorf("getent failed")
+	})
+	defer restore()
+
+	_, err := osutil.EnsureUserGroupInRange("lakatos", 1000, 1009, false)
+	c.Assert(err, check.ErrorMatches, `getent failed`)
+	c.Check(s.mockGroupAdd.Calls(), check.HasLen, 0)
+}
+
+func (s *ensureUserSuite) TestEnsureUserGroupInRangeExisting(c *check.C) {
+	restore := osutil.MockFindUid(func(string) (uint64, error) {
+		return uint64(1005), nil
+	})
+	defer restore()
+	restore = osutil.MockFindGid(func(string) (uint64, error) {
+		return uint64(1005), nil
+	})
+	defer restore()
+	restore = osutil.MockIsIDInUse(func(id uint32) (bool, error) {
+		c.Fatalf("unexpected call")
+		return false, nil
+	})
+	defer restore()
+
+	id, err := osutil.EnsureUserGroupInRange("lakatos", 1000, 1009, false)
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, uint32(1005))
+	c.Check(s.mockGroupAdd.Calls(), check.HasLen, 0)
+	c.Check(s.mockUserAdd.Calls(), check.HasLen, 0)
+
+	_, err = osutil.EnsureUserGroupInRange("lakatos", 2000, 2009, false)
+	c.Assert(err, check.ErrorMatches, `found unexpected uid for user "lakatos": 1005`)
+}
+
+func (s *ensureUserSuite) TestEnsureUserGroupInRangeBadUser(c *check.C) {
+	_, err := osutil.EnsureUserGroupInRange("k!", 1000, 1009, false)
+	c.Assert(err, check.ErrorMatches, `cannot add user/group "k!": name contains invalid characters`)
+}
+
+func (s *ensureUserSuite) TestDelUserGroup(c *check.C) {
+	restore := osutil.MockFindUid(func(string) (uint64, error) {
+		return uint64(1234), nil
+	})
+	defer restore()
+	restore = osutil.MockFindGid(func(string) (uint64, error) {
+		return uint64(1234), nil
+	})
+	defer restore()
+
+	err := osutil.DelUserGroup("lakatos", false)
+	c.Assert(err, check.IsNil)
+	c.Check(s.mockUserDel.Calls(), check.DeepEquals, [][]string{
+		{"userdel", "lakatos"},
+	})
+	c.Check(s.mockGroupDel.Calls(), check.DeepEquals, [][]string{
+		{"groupdel", "lakatos"},
+	})
+}
+
+func (s *ensureUserSuite) TestDelUserGroupExtraUsers(c *check.C) {
+	restore := osutil.MockFindUid(func(string) (uint64, error) {
+		return uint64(1234), nil
+	})
+	defer restore()
+	restore = osutil.MockFindGid(func(string) (uint64, error) {
+		return uint64(1234), nil
+	})
+	defer restore()
+
+	err := osutil.DelUserGroup("lakatos", true)
+	c.Assert(err, check.IsNil)
+	c.Check(s.mockUserDel.Calls(), check.DeepEquals, [][]string{
+		{"userdel", "--extrausers", "lakatos"},
+	})
+	// groupdel does not support --extrausers
+	c.Check(s.mockGroupDel.Calls(), check.HasLen, 0)
+}
+
+func (s *ensureUserSuite) TestDelUserGroupMissing(c *check.C) {
+	restore := osutil.MockFindUid(func(name string) (uint64, error) {
+		return 0, user.UnknownUserError(name)
+	})
+	defer restore()
+	restore = osutil.MockFindGid(func(name string) (uint64, error) {
+		return 0, user.UnknownGroupError(name)
+	})
+	defer restore()
+
+	err := osutil.DelUserGroup("lakatos", false)
+	c.Assert(err, check.IsNil)
+	c.Check(s.mockUserDel.Calls(), check.HasLen, 0)
+	c.Check(s.mockGroupDel.Calls(), check.HasLen, 0)
+}
+
+func (s *ensureUserSuite) TestDelUserGroupFailedUserdel(c *check.C) {
+	mockUserDel := testutil.MockCommand(c, "userdel", "echo some error; exit 1")
+	defer mockUserDel.Restore()
+	restore := osutil.MockFindUid(func(string) (uint64, error) {
+		return uint64(1234), nil
+	})
+	defer restore()
+
+	err := osutil.DelUserGroup("lakatos", false)
+	c.Assert(err, check.ErrorMatches, "userdel failed with: some error")
+	c.Check(s.mockGroupDel.Calls(), check.HasLen, 0)
+}
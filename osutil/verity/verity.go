@@ -0,0 +1,252 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package verity builds and verifies dm-verity hash trees, in the format
+// used by veritysetup, for read-only data like the snaps of the seed.
+//
+// The hash tree is stored in a separate file starting with a veritysetup
+// superblock and uses the version 1 format with SHA-256 and 4096 byte data
+// and hash blocks. The root hash of the tree is not stored with it, it must
+// come from a trusted source or be measured.
+package verity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+const (
+	// BlockSize is the size of the data and hash blocks.
+	BlockSize = 4096
+
+	superblockSize = 512
+	maxSaltSize    = 256
+	algorithm      = "sha256"
+	hashType       = 1
+)
+
+var superblockSignature = [8]byte{'v', 'e', 'r', 'i', 't', 'y', 0, 0}
+
+// superblock is the on-disk veritysetup superblock.
+type superblock struct {
+	Signature     [8]byte
+	Version       uint32
+	HashType      uint32
+	UUID          [16]byte
+	Algorithm     [32]byte
+	DataBlockSize uint32
+	HashBlockSize uint32
+	DataBlocks    uint64
+	SaltSize      uint16
+	Pad1          [6]byte
+	Salt          [maxSaltSize]byte
+	Pad2          [168]byte
+}
+
+// Info describes a dm-verity hash tree.
+type Info struct {
+	// RootHash is the root hash of the tree.
+	RootHash []byte
+	// Salt is the salt the data and hash blocks are hashed with.
+	Salt []byte
+	// DataBlocks is the number of data blocks covered by the tree.
+	DataBlocks uint64
+}
+
+func hashBlock(salt, block []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(block)
+	return h.Sum(nil)
+}
+
+// buildTree returns the levels of the hash tree of the given data, the level
+// hashing the data blocks first, and its root hash.
+func buildTree(data io.Reader, dataBlocks uint64, salt []byte) (levels [][]byte, rootHash []byte, err error) {
+	block := make([]byte, BlockSize)
+	digests := make([]byte, 0, dataBlocks*sha256.Size)
+	for i := uint64(0); i < dataBlocks; i++ {
+		if _, err := io.ReadFull(data, block); err != nil {
+			return nil, nil, err
+		}
+		digests = append(digests, hashBlock(salt, block)...)
+	}
+
+	for len(digests) > sha256.Size {
+		// the digests of a level are stored in hash blocks padded with
+		// zeros, which are hashed in turn for the level above
+		if pad := len(digests) % BlockSize; pad != 0 {
+			digests = append(digests, make([]byte, BlockSize-pad)...)
+		}
+		levels = append(levels, digests)
+		next := make([]byte, 0, len(digests)/BlockSize*sha256.Size)
+		for off := 0; off < len(digests); off += BlockSize {
+			next = append(next, hashBlock(salt, digests[off:off+BlockSize])...)
+		}
+		digests = next
+	}
+	return levels, digests, nil
+}
+
+func dataBlocksOf(dataPath string) (uint64, error) {
+	st, err := os.Stat(dataPath)
+	if err != nil {
+		return 0, err
+	}
+	if st.Size() == 0 || st.Size()%BlockSize != 0 {
+		return 0, fmt.Errorf("size of %s is not a non-zero multiple of %d", dataPath, BlockSize)
+	}
+	return uint64(st.Size() / BlockSize), nil
+}
+
+func treeOf(dataPath string, dataBlocks uint64, salt []byte) (levels [][]byte, rootHash []byte, err error) {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	levels, rootHash, err = buildTree(bufio.NewReaderSize(f, BlockSize), dataBlocks, salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read %s: %v", dataPath, err)
+	}
+	return levels, rootHash, nil
+}
+
+// encodeTree returns the content of the hash tree file, the superblock
+// padded to a hash block followed by the levels of the tree, the topmost
+// level first.
+func encodeTree(levels [][]byte, dataBlocks uint64, salt []byte) []byte {
+	sb := superblock{
+		Signature:     superblockSignature,
+		Version:       1,
+		HashType:      hashType,
+		DataBlockSize: BlockSize,
+		HashBlockSize: BlockSize,
+		DataBlocks:    dataBlocks,
+		SaltSize:      uint16(len(salt)),
+	}
+	copy(sb.Algorithm[:], algorithm)
+	copy(sb.Salt[:], salt)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &sb)
+	buf.Write(make([]byte, BlockSize-superblockSize))
+	for i := len(levels) - 1; i >= 0; i-- {
+		buf.Write(levels[i])
+	}
+	return buf.Bytes()
+}
+
+// Format builds the dm-verity hash tree of the given data file, whose size
+// must be a multiple of BlockSize, and writes it to hashPath. The salt can be
+// empty, it is at most 256 bytes.
+func Format(dataPath, hashPath string, salt []byte) (*Info, error) {
+	if len(salt) > maxSaltSize {
+		return nil, fmt.Errorf("cannot use a salt of %d bytes, the maximum is %d", len(salt), maxSaltSize)
+	}
+	dataBlocks, err := dataBlocksOf(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	levels, rootHash, err := treeOf(dataPath, dataBlocks, salt)
+	if err != nil {
+		return nil, err
+	}
+	tree := encodeTree(levels, dataBlocks, salt)
+	if err := osutil.AtomicWriteFile(hashPath, tree, 0644, 0); err != nil {
+		return nil, fmt.Errorf("cannot write hash tree: %v", err)
+	}
+	return &Info{
+		RootHash:   rootHash,
+		Salt:       append([]byte(nil), salt...),
+		DataBlocks: dataBlocks,
+	}, nil
+}
+
+func readSuperblock(r io.Reader) (*superblock, error) {
+	var sb superblock
+	if err := binary.Read(r, binary.LittleEndian, &sb); err != nil {
+		return nil, fmt.Errorf("cannot read superblock: %v", err)
+	}
+	if sb.Signature != superblockSignature {
+		return nil, fmt.Errorf("invalid superblock signature")
+	}
+	if sb.Version != 1 || sb.HashType != hashType {
+		return nil, fmt.Errorf("unsupported superblock version %d and hash type %d", sb.Version, sb.HashType)
+	}
+	if alg := string(bytes.TrimRight(sb.Algorithm[:], "\x00")); alg != algorithm {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", alg)
+	}
+	if sb.DataBlockSize != BlockSize || sb.HashBlockSize != BlockSize {
+		return nil, fmt.Errorf("unsupported block sizes %d and %d", sb.DataBlockSize, sb.HashBlockSize)
+	}
+	if sb.SaltSize > maxSaltSize {
+		return nil, fmt.Errorf("invalid salt size %d", sb.SaltSize)
+	}
+	return &sb, nil
+}
+
+// Verify checks that the hash tree in hashPath matches the given data file
+// and returns its description, including its root hash which the caller
+// must check against a trusted one or measure.
+func Verify(dataPath, hashPath string) (*Info, error) {
+	tree, err := ioutil.ReadFile(hashPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(tree) < BlockSize {
+		return nil, fmt.Errorf("cannot use hash tree %s: too short", hashPath)
+	}
+	sb, err := readSuperblock(bytes.NewReader(tree))
+	if err != nil {
+		return nil, fmt.Errorf("cannot use hash tree %s: %v", hashPath, err)
+	}
+	dataBlocks, err := dataBlocksOf(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	if dataBlocks != sb.DataBlocks {
+		return nil, fmt.Errorf("hash tree %s covers %d data blocks, %s has %d", hashPath, sb.DataBlocks, dataPath, dataBlocks)
+	}
+
+	salt := sb.Salt[:sb.SaltSize]
+	levels, rootHash, err := treeOf(dataPath, dataBlocks, salt)
+	if err != nil {
+		return nil, err
+	}
+	// only the levels are compared, the rest of the superblock, like its
+	// UUID, is not relevant
+	if !bytes.Equal(tree[BlockSize:], encodeTree(levels, dataBlocks, salt)[BlockSize:]) {
+		return nil, fmt.Errorf("hash tree %s does not match %s", hashPath, dataPath)
+	}
+	return &Info{
+		RootHash:   rootHash,
+		Salt:       append([]byte(nil), salt...),
+		DataBlocks: dataBlocks,
+	}, nil
+}
@@ -0,0 +1,161 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package verity_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil/verity"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type veritySuite struct {
+	dir string
+}
+
+var _ = Suite(&veritySuite{})
+
+func (s *veritySuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *veritySuite) writeData(c *C, blocks int) string {
+	data := make([]byte, blocks*verity.BlockSize)
+	for i := range data {
+		data[i] = byte(i / verity.BlockSize)
+	}
+	dataPath := filepath.Join(s.dir, "data")
+	c.Assert(ioutil.WriteFile(dataPath, data, 0644), IsNil)
+	return dataPath
+}
+
+func (s *veritySuite) TestFormatKnownRootHashes(c *C) {
+	dataPath := filepath.Join(s.dir, "data")
+	hashPath := filepath.Join(s.dir, "data.verity")
+
+	// a single data block is hashed directly
+	c.Assert(ioutil.WriteFile(dataPath, make([]byte, verity.BlockSize), 0644), IsNil)
+	info, err := verity.Format(dataPath, hashPath, nil)
+	c.Assert(err, IsNil)
+	c.Check(hex.EncodeToString(info.RootHash), Equals, "ad7facb2586fc6e966c004d7d1d16b024f5805ff7cb47c7a85dabd8b48892ca7")
+	c.Check(info.DataBlocks, Equals, uint64(1))
+	st, err := os.Stat(hashPath)
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(verity.BlockSize))
+
+	// the digests of two blocks fit in a single hash block
+	c.Assert(ioutil.WriteFile(dataPath, make([]byte, 2*verity.BlockSize), 0644), IsNil)
+	info, err = verity.Format(dataPath, hashPath, nil)
+	c.Assert(err, IsNil)
+	c.Check(hex.EncodeToString(info.RootHash), Equals, "90b10db56173e2e7c847f6ff3ed85a3aebb140bc0064ec9a156ae5b2e71db988")
+	st, err = os.Stat(hashPath)
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(2*verity.BlockSize))
+}
+
+func (s *veritySuite) TestFormatVerifyRoundtrip(c *C) {
+	// 130 data blocks need two hash blocks for their digests and a third
+	// one on top
+	dataPath := s.writeData(c, 130)
+	hashPath := filepath.Join(s.dir, "data.verity")
+
+	salt := []byte("salt")
+	info, err := verity.Format(dataPath, hashPath, salt)
+	c.Assert(err, IsNil)
+	c.Check(info.RootHash, HasLen, 32)
+	c.Check(info.Salt, DeepEquals, salt)
+	c.Check(info.DataBlocks, Equals, uint64(130))
+
+	tree, err := ioutil.ReadFile(hashPath)
+	c.Assert(err, IsNil)
+	c.Check(tree, HasLen, 4*verity.BlockSize)
+	c.Check(tree[:8], DeepEquals, []byte("verity\x00\x00"))
+
+	verified, err := verity.Verify(dataPath, hashPath)
+	c.Assert(err, IsNil)
+	c.Check(verified, DeepEquals, info)
+
+	// a different salt gives a different root hash
+	other, err := verity.Format(dataPath, hashPath, []byte("other"))
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(other.RootHash, info.RootHash), Equals, false)
+}
+
+func (s *veritySuite) TestVerifyModifiedData(c *C) {
+	dataPath := s.writeData(c, 3)
+	hashPath := filepath.Join(s.dir, "data.verity")
+	_, err := verity.Format(dataPath, hashPath, nil)
+	c.Assert(err, IsNil)
+
+	f, err := os.OpenFile(dataPath, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt([]byte("tampered"), verity.BlockSize+42)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = verity.Verify(dataPath, hashPath)
+	c.Check(err, ErrorMatches, `hash tree .*/data.verity does not match .*/data`)
+}
+
+func (s *veritySuite) TestVerifyResizedData(c *C) {
+	dataPath := s.writeData(c, 3)
+	hashPath := filepath.Join(s.dir, "data.verity")
+	_, err := verity.Format(dataPath, hashPath, nil)
+	c.Assert(err, IsNil)
+
+	s.writeData(c, 4)
+	_, err = verity.Verify(dataPath, hashPath)
+	c.Check(err, ErrorMatches, `hash tree .*/data.verity covers 3 data blocks, .*/data has 4`)
+}
+
+func (s *veritySuite) TestVerifyInvalidHashTree(c *C) {
+	dataPath := s.writeData(c, 1)
+	hashPath := filepath.Join(s.dir, "data.verity")
+
+	c.Assert(ioutil.WriteFile(hashPath, []byte("short"), 0644), IsNil)
+	_, err := verity.Verify(dataPath, hashPath)
+	c.Check(err, ErrorMatches, `cannot use hash tree .*/data.verity: too short`)
+
+	c.Assert(ioutil.WriteFile(hashPath, make([]byte, verity.BlockSize), 0644), IsNil)
+	_, err = verity.Verify(dataPath, hashPath)
+	c.Check(err, ErrorMatches, `cannot use hash tree .*/data.verity: invalid superblock signature`)
+}
+
+func (s *veritySuite) TestFormatErrors(c *C) {
+	dataPath := filepath.Join(s.dir, "data")
+	hashPath := filepath.Join(s.dir, "data.verity")
+
+	c.Assert(ioutil.WriteFile(dataPath, []byte("not a block"), 0644), IsNil)
+	_, err := verity.Format(dataPath, hashPath, nil)
+	c.Check(err, ErrorMatches, `size of .*/data is not a non-zero multiple of 4096`)
+
+	_, err = verity.Format(dataPath, hashPath, make([]byte, 257))
+	c.Check(err, ErrorMatches, `cannot use a salt of 257 bytes, the maximum is 256`)
+	c.Check(hashPath, testutil.FileAbsent)
+}
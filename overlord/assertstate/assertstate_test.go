@@ -29,6 +29,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -85,6 +86,9 @@ type fakeStore struct {
 
 	snapActionErr         error
 	downloadAssertionsErr error
+
+	// assertion streams may be downloaded concurrently
+	downloadMu sync.Mutex
 }
 
 func (sto *fakeStore) pokeStateLock() {
@@ -163,6 +167,9 @@ func (sto *fakeStore) SnapAction(_ context.Context, currentSnaps []*store.Curren
 func (sto *fakeStore) DownloadAssertions(urls []string, b *asserts.Batch, user *auth.UserState) error {
 	sto.pokeStateLock()
 
+	sto.downloadMu.Lock()
+	defer sto.downloadMu.Unlock()
+
 	if sto.downloadAssertionsErr != nil {
 		return sto.downloadAssertionsErr
 	}
@@ -2076,3 +2083,62 @@ func (s *assertMgrSuite) TestStore(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(store.Store(), Equals, "foo")
 }
+
+type concurrentDownloadsStore struct {
+	storetest.Store
+
+	mu         sync.Mutex
+	inFlight   int
+	maxFlight  int
+	downloaded []string
+	release    chan struct{}
+}
+
+func (sto *concurrentDownloadsStore) DownloadAssertions(urls []string, b *asserts.Batch, user *auth.UserState) error {
+	sto.mu.Lock()
+	sto.inFlight++
+	if sto.inFlight > sto.maxFlight {
+		sto.maxFlight = sto.inFlight
+	}
+	sto.downloaded = append(sto.downloaded, urls...)
+	sto.mu.Unlock()
+
+	<-sto.release
+
+	sto.mu.Lock()
+	sto.inFlight--
+	sto.mu.Unlock()
+	if urls[0] == "/assertions/bad" {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (s *assertMgrSuite) TestDownloadAssertionStreamsConcurrently(c *C) {
+	s.AddCleanup(assertstate.MockMaxConcurrentStreamDownloads(2))
+
+	sto := &concurrentDownloadsStore{release: make(chan struct{})}
+	aresults := []store.AssertionResult{
+		{Grouping: "0", StreamURLs: []string{"/assertions/a"}},
+		{Grouping: "1", StreamURLs: []string{"/assertions/bad"}},
+		{Grouping: "2", StreamURLs: []string{"/assertions/c"}},
+	}
+
+	go func() {
+		for range aresults {
+			sto.release <- struct{}{}
+		}
+	}()
+	batches, errs := assertstate.DownloadAssertionStreams(sto, aresults, nil, nil)
+	c.Assert(batches, HasLen, 3)
+	c.Assert(errs, HasLen, 3)
+	c.Check(errs[0], IsNil)
+	c.Check(errs[1], ErrorMatches, "boom")
+	c.Check(errs[2], IsNil)
+	for _, b := range batches {
+		c.Check(b, NotNil)
+	}
+	sort.Strings(sto.downloaded)
+	c.Check(sto.downloaded, DeepEquals, []string{"/assertions/a", "/assertions/bad", "/assertions/c"})
+	c.Check(sto.maxFlight <= 2, Equals, true)
+}
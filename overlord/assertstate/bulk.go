@@ -24,8 +24,10 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
@@ -43,6 +45,11 @@ const storeGroup = "store assertion"
 // that. Most systems should be done in one request anyway.
 var maxGroups = 256
 
+// maxConcurrentStreamDownloads is the maximum number of assertion streams
+// downloaded concurrently while resolving a pool, spreading the requests
+// for the groups that have updates over a few connections.
+var maxConcurrentStreamDownloads = 4
+
 func bulkRefreshSnapDeclarations(s *state.State, snapStates map[string]*snapstate.SnapState, userID int, deviceCtx snapstate.DeviceContext) error {
 	db := cachedDB(s)
 
@@ -149,6 +156,32 @@ func (e *bulkAssertionFallbackError) Error() string {
 	return fmt.Sprintf("unsuccessful bulk assertion refresh, fallback: %v", e.err)
 }
 
+// downloadAssertionStreams downloads the assertion streams of the results
+// concurrently, returning a batch or an error for each result.
+func downloadAssertionStreams(sto snapstate.StoreService, aresults []store.AssertionResult, unsupported func(*asserts.Ref, error) error, user *auth.UserState) ([]*asserts.Batch, []error) {
+	batches := make([]*asserts.Batch, len(aresults))
+	errs := make([]error, len(aresults))
+
+	sem := make(chan struct{}, maxConcurrentStreamDownloads)
+	var wg sync.WaitGroup
+	for i := range aresults {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			b := asserts.NewBatch(unsupported)
+			errs[i] = sto.DownloadAssertions(aresults[i].StreamURLs, b, user)
+			batches[i] = b
+		}(i)
+	}
+	wg.Wait()
+
+	return batches, errs
+}
+
 type resolvePoolError struct {
 	message string
 	// errors maps groups to errors
@@ -221,16 +254,15 @@ func resolvePool(s *state.State, pool *asserts.Pool, userID int, deviceCtx snaps
 			break
 		}
 
-		for _, ares := range aresults {
-			b := asserts.NewBatch(unsupported)
-			s.Unlock()
-			err := sto.DownloadAssertions(ares.StreamURLs, b, user)
-			s.Lock()
-			if err != nil {
-				pool.AddGroupingError(err, ares.Grouping)
+		s.Unlock()
+		batches, errs := downloadAssertionStreams(sto, aresults, unsupported, user)
+		s.Lock()
+		for i, ares := range aresults {
+			if errs[i] != nil {
+				pool.AddGroupingError(errs[i], ares.Grouping)
 				continue
 			}
-			_, err = pool.AddBatch(b, ares.Grouping)
+			_, err = pool.AddBatch(batches[i], ares.Grouping)
 			if err != nil {
 				return err
 			}
@@ -21,7 +21,8 @@ package assertstate
 
 // expose for testing
 var (
-	DoFetch = doFetch
+	DoFetch                  = doFetch
+	DownloadAssertionStreams = downloadAssertionStreams
 )
 
 func MockMaxGroups(n int) (restore func()) {
@@ -31,3 +32,11 @@ func MockMaxGroups(n int) (restore func()) {
 		maxGroups = oldMaxGroups
 	}
 }
+
+func MockMaxConcurrentStreamDownloads(n int) (restore func()) {
+	old := maxConcurrentStreamDownloads
+	maxConcurrentStreamDownloads = n
+	return func() {
+		maxConcurrentStreamDownloads = old
+	}
+}
@@ -29,9 +29,16 @@ import (
 	"strings"
 
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/strutil"
 )
 
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.store-tls-pins"] = true
+}
+
 func handleCertConfiguration(tr config.Conf, opts *fsOnlyContext) error {
 	// This handles the "snap revert core" case:
 	// We need to go over each pem cert on disk and check if there is
@@ -115,3 +122,18 @@ func validateCertSettings(tr config.Conf) error {
 
 	return nil
 }
+
+// the pins are consulted by the store on each new connection, so the
+// option only needs validation here
+func validateStoreTLSPins(tr config.Conf) error {
+	pins, err := coreCfg(tr, "store-tls-pins")
+	if err != nil {
+		return err
+	}
+	for _, pin := range strutil.CommaSeparatedList(pins) {
+		if err := httputil.ValidatePublicKeyPin(pin); err != nil {
+			return fmt.Errorf("cannot set store TLS pins: %v", err)
+		}
+	}
+	return nil
+}
@@ -145,3 +145,31 @@ func (s *certsSuite) TestConfigureCertsUnhappyContent(c *C) {
 	})
 	c.Assert(err, ErrorMatches, `cannot decode pem certificate "cert-bad"`)
 }
+
+func (s *certsSuite) TestConfigureStoreTLSPins(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"store-tls-pins": "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=,sha256/BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB=",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	err = configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"store-tls-pins": "",
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *certsSuite) TestConfigureStoreTLSPinsInvalid(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"store-tls-pins": "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=,sha1/AAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		},
+	})
+	c.Assert(err, ErrorMatches, `cannot set store TLS pins: invalid public key pin "sha1/AAAAAAAAAAAAAAAAAAAAAAAAAAA=": must start with "sha256/"`)
+}
@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nomanagers
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/snap/naming"
+)
+
+const defaultProvidersOverridesPrefix = "core.default-providers.overrides."
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.default-providers.policy"] = true
+}
+
+func validDefaultProvidersOverrideOption(option string) bool {
+	if !strings.HasPrefix(option, defaultProvidersOverridesPrefix) {
+		return false
+	}
+	return naming.ValidateInstance(strings.TrimPrefix(option, defaultProvidersOverridesPrefix)) == nil
+}
+
+func validateDefaultProvidersSettings(tr config.Conf) error {
+	policy, err := coreCfg(tr, "default-providers.policy")
+	if err != nil {
+		return err
+	}
+	if err := snapstate.ValidateDefaultProvidersPolicy(policy); err != nil {
+		return fmt.Errorf("default-providers.policy: %v", err)
+	}
+
+	for _, name := range tr.Changes() {
+		if !strings.HasPrefix(name, defaultProvidersOverridesPrefix) {
+			continue
+		}
+		nameWithoutSnap := strings.SplitN(name, ".", 2)[1]
+		policy, err := coreCfg(tr, nameWithoutSnap)
+		if err != nil {
+			return fmt.Errorf("internal error: cannot get data for %s: %v", nameWithoutSnap, err)
+		}
+		if err := snapstate.ValidateDefaultProvidersPolicy(policy); err != nil {
+			return fmt.Errorf("%s: %v", nameWithoutSnap, err)
+		}
+	}
+	return nil
+}
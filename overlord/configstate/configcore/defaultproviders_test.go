@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type defaultProvidersSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&defaultProvidersSuite{})
+
+func (s *defaultProvidersSuite) TestConfigureDefaultProvidersHappy(c *C) {
+	for _, policy := range []string{"", "always", "never", "prompt"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"default-providers.policy": policy,
+			},
+			changes: map[string]interface{}{
+				"default-providers.overrides.foo":     "always",
+				"default-providers.overrides.foo_bar": "never",
+			},
+		})
+		c.Check(err, IsNil, Commentf("policy %q", policy))
+	}
+}
+
+func (s *defaultProvidersSuite) TestConfigureDefaultProvidersInvalid(c *C) {
+	for _, tc := range []struct {
+		conf    map[string]interface{}
+		changes map[string]interface{}
+		err     string
+	}{
+		{
+			conf: map[string]interface{}{"default-providers.policy": "sometimes"},
+			err:  `default-providers.policy: unknown default providers policy "sometimes"`,
+		}, {
+			changes: map[string]interface{}{"default-providers.overrides.foo": "maybe"},
+			err:     `default-providers.overrides.foo: unknown default providers policy "maybe"`,
+		}, {
+			changes: map[string]interface{}{"default-providers.overrides.Foo": "never"},
+			err:     `cannot set "core.default-providers.overrides.Foo": invalid snap name`,
+		},
+	} {
+		err := configcore.Run(&mockConf{
+			state:   s.state,
+			conf:    tc.conf,
+			changes: tc.changes,
+		})
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
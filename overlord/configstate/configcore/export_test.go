@@ -19,7 +19,10 @@
 
 package configcore
 
-import "github.com/snapcore/snapd/osutil/sys"
+import (
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/osutil/sys"
+)
 
 var (
 	UpdatePiConfig       = updatePiConfig
@@ -48,3 +51,19 @@ func MockChownPath(f func(string, sys.UserID, sys.GroupID) error) func() {
 		sysChownPath = old
 	}
 }
+
+func MockBootSetCmdlineDropIn(f func(dev boot.Device, name, args string) (bool, error)) (restore func()) {
+	old := bootSetCmdlineDropIn
+	bootSetCmdlineDropIn = f
+	return func() {
+		bootSetCmdlineDropIn = old
+	}
+}
+
+func MockBootRemoveCmdlineDropIn(f func(dev boot.Device, name string) (bool, error)) (restore func()) {
+	old := bootRemoveCmdlineDropIn
+	bootRemoveCmdlineDropIn = f
+	return func() {
+		bootRemoveCmdlineDropIn = old
+	}
+}
@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nomanagers
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.health-report.url"] = true
+	supportedConfigurations["core.health-report.interval"] = true
+	supportedConfigurations["core.health-report.include-serial"] = true
+	supportedConfigurations["core.health-report.include-errors"] = true
+}
+
+// minHealthReportInterval is the shortest interval allowed between health
+// reports, to spare both the device and the endpoint.
+const minHealthReportInterval = time.Hour
+
+func validateHealthReportSettings(tr config.Conf) error {
+	urlStr, err := coreCfg(tr, "health-report.url")
+	if err != nil {
+		return err
+	}
+	if urlStr != "" {
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return fmt.Errorf("health-report.url cannot be parsed: %v", err)
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("health-report.url must be an https URL")
+		}
+	}
+
+	intervalStr, err := coreCfg(tr, "health-report.interval")
+	if err != nil {
+		return err
+	}
+	if intervalStr != "" {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			return fmt.Errorf("health-report.interval cannot be parsed: %v", err)
+		}
+		if interval < minHealthReportInterval {
+			return fmt.Errorf("health-report.interval must be at least %s", minHealthReportInterval)
+		}
+	}
+
+	for _, flag := range []string{"health-report.include-serial", "health-report.include-errors"} {
+		if err := validateBoolFlag(tr, flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type healthReportSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&healthReportSuite{})
+
+func (s *healthReportSuite) TestConfigureHealthReportHappy(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"health-report.url":            "https://health.example.com/report",
+			"health-report.interval":       "12h",
+			"health-report.include-serial": "true",
+			"health-report.include-errors": false,
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *healthReportSuite) TestConfigureHealthReportInvalid(c *C) {
+	for _, tc := range []struct {
+		conf map[string]interface{}
+		err  string
+	}{
+		{map[string]interface{}{"health-report.url": "http://health.example.com/report"}, `health-report.url must be an https URL`},
+		{map[string]interface{}{"health-report.url": "/report"}, `health-report.url must be an https URL`},
+		{map[string]interface{}{"health-report.url": "https://%zz"}, `health-report.url cannot be parsed: .*`},
+		{map[string]interface{}{"health-report.interval": "foo"}, `health-report.interval cannot be parsed: .*`},
+		{map[string]interface{}{"health-report.interval": "30m"}, `health-report.interval must be at least 1h0m0s`},
+		{map[string]interface{}{"health-report.include-serial": "yes"}, `health-report.include-serial can only be set to 'true' or 'false'`},
+		{map[string]interface{}{"health-report.include-errors": 1}, `health-report.include-errors can only be set to 'true' or 'false'`},
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf:  tc.conf,
+		})
+		c.Check(err, ErrorMatches, tc.err, Commentf("%v", tc.conf))
+	}
+}
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nomanagers
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package configcore
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+const (
+	kernelCmdlineAppendOpt = "system.kernel.cmdline-append"
+	// name of the kernel command line drop-in carrying the arguments set
+	// with the system option
+	kernelCmdlineDropIn = "system-options"
+)
+
+var (
+	bootSetCmdlineDropIn    = boot.SetCmdlineDropIn
+	bootRemoveCmdlineDropIn = boot.RemoveCmdlineDropIn
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core."+kernelCmdlineAppendOpt] = true
+}
+
+func validateKernelCmdlineAppend(tr config.Conf) error {
+	args, err := coreCfg(tr, kernelCmdlineAppendOpt)
+	if err != nil {
+		return err
+	}
+	if err := bootloader.ValidateCmdlineDropIn(kernelCmdlineDropIn, args); err != nil {
+		return fmt.Errorf("cannot set %q: %v", kernelCmdlineAppendOpt, err)
+	}
+	return nil
+}
+
+func handleKernelCmdlineAppend(tr config.Conf, opts *fsOnlyContext) error {
+	var pristineArgs, newArgs string
+	if err := tr.GetPristine("core", kernelCmdlineAppendOpt, &pristineArgs); err != nil && !config.IsNoOption(err) {
+		return err
+	}
+	if err := tr.Get("core", kernelCmdlineAppendOpt, &newArgs); err != nil && !config.IsNoOption(err) {
+		return err
+	}
+	if pristineArgs == newArgs {
+		return nil
+	}
+
+	st := tr.State()
+	st.Lock()
+	defer st.Unlock()
+
+	devCtx, err := snapstate.DeviceCtx(st, nil, nil)
+	if err != nil {
+		return err
+	}
+	if devCtx.Model().Grade() == asserts.ModelGradeUnset || !devCtx.RunMode() {
+		return fmt.Errorf("cannot set %q: only supported in run mode of UC20+ systems", kernelCmdlineAppendOpt)
+	}
+
+	// do not release the state lock, the keys may be resealed which
+	// modifies the modeenv, implicitly guarded by the state lock
+	var rebootRequired bool
+	if newArgs == "" {
+		rebootRequired, err = bootRemoveCmdlineDropIn(devCtx, kernelCmdlineDropIn)
+	} else {
+		rebootRequired, err = bootSetCmdlineDropIn(devCtx, kernelCmdlineDropIn, newArgs)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot set %q: %v", kernelCmdlineAppendOpt, err)
+	}
+	if rebootRequired {
+		// the keys were resealed for both the current and the new
+		// command line, reboot so that the new one takes effect
+		st.RequestRestart(state.RestartSystem)
+	}
+	return nil
+}
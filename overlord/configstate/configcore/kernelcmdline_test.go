@@ -0,0 +1,190 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2022 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/boot/boottest"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+)
+
+type witnessRestartReqStateBackend struct {
+	restartRequested []state.RestartType
+}
+
+func (b *witnessRestartReqStateBackend) Checkpoint([]byte) error {
+	return nil
+}
+
+func (b *witnessRestartReqStateBackend) RequestRestart(t state.RestartType) {
+	b.restartRequested = append(b.restartRequested, t)
+}
+
+func (b *witnessRestartReqStateBackend) EnsureBefore(time.Duration) {}
+
+type kernelCmdlineSuite struct {
+	configcoreSuite
+
+	backend *witnessRestartReqStateBackend
+
+	setCalls    []string
+	removeCalls []string
+	reboot      bool
+}
+
+var _ = Suite(&kernelCmdlineSuite{})
+
+func (s *kernelCmdlineSuite) SetUpTest(c *C) {
+	s.configcoreSuite.SetUpTest(c)
+	s.AddCleanup(release.MockOnClassic(false))
+
+	// proxy handling on core needs /etc/environment
+	etcEnvironment := filepath.Join(dirs.GlobalRootDir, "/etc/environment")
+	c.Assert(os.MkdirAll(filepath.Dir(etcEnvironment), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(etcEnvironment, nil, 0644), IsNil)
+
+	s.backend = &witnessRestartReqStateBackend{}
+	s.state = state.New(s.backend)
+	s.state.Lock()
+	s.state.VerifyReboot("boot-id-1")
+	s.state.Unlock()
+
+	s.AddCleanup(snapstatetest.MockDeviceModelAndMode(boottest.MakeMockUC20Model(), "run"))
+
+	s.setCalls = nil
+	s.removeCalls = nil
+	s.reboot = true
+	s.AddCleanup(configcore.MockBootSetCmdlineDropIn(func(dev boot.Device, name, args string) (bool, error) {
+		c.Check(dev.RunMode(), Equals, true)
+		s.setCalls = append(s.setCalls, name+":"+args)
+		return s.reboot, nil
+	}))
+	s.AddCleanup(configcore.MockBootRemoveCmdlineDropIn(func(dev boot.Device, name string) (bool, error) {
+		c.Check(dev.RunMode(), Equals, true)
+		s.removeCalls = append(s.removeCalls, name)
+		return s.reboot, nil
+	}))
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppend(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet splash",
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.setCalls, DeepEquals, []string{"system-options:quiet splash"})
+	c.Check(s.removeCalls, HasLen, 0)
+	c.Check(s.backend.restartRequested, DeepEquals, []state.RestartType{state.RestartSystem})
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppendUnset(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet",
+		},
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "",
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.setCalls, HasLen, 0)
+	c.Check(s.removeCalls, DeepEquals, []string{"system-options"})
+	c.Check(s.backend.restartRequested, DeepEquals, []state.RestartType{state.RestartSystem})
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppendNoReboot(c *C) {
+	s.reboot = false
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet",
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.setCalls, DeepEquals, []string{"system-options:quiet"})
+	c.Check(s.backend.restartRequested, HasLen, 0)
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppendUnchanged(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet",
+		},
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet",
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.setCalls, HasLen, 0)
+	c.Check(s.removeCalls, HasLen, 0)
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppendInvalid(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "snapd_recovery_mode=install",
+		},
+	})
+	c.Assert(err, ErrorMatches, `cannot set "system.kernel.cmdline-append": invalid command line drop-in "system-options": argument "snapd_recovery_mode" is reserved`)
+	c.Check(s.setCalls, HasLen, 0)
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppendPreUC20(c *C) {
+	s.AddCleanup(snapstatetest.MockDeviceModel(boottest.MakeMockModel()))
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet",
+		},
+	})
+	c.Assert(err, ErrorMatches, `cannot set "system.kernel.cmdline-append": only supported in run mode of UC20\+ systems`)
+	c.Check(s.setCalls, HasLen, 0)
+}
+
+func (s *kernelCmdlineSuite) TestConfigureKernelCmdlineAppendError(c *C) {
+	s.AddCleanup(configcore.MockBootSetCmdlineDropIn(func(dev boot.Device, name, args string) (bool, error) {
+		return false, errors.New("boom")
+	}))
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.kernel.cmdline-append": "quiet",
+		},
+	})
+	c.Assert(err, ErrorMatches, `cannot set "system.kernel.cmdline-append": boom`)
+	c.Check(s.backend.restartRequested, HasLen, 0)
+}
@@ -0,0 +1,54 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type publicSocketSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&publicSocketSuite{})
+
+func (s *publicSocketSuite) TestConfigureDisablePublicSocketHappy(c *C) {
+	for _, v := range []interface{}{true, false, "true", "false"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"system.disable-public-socket": v,
+			},
+		})
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *publicSocketSuite) TestConfigureDisablePublicSocketInvalid(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"system.disable-public-socket": "maybe",
+		},
+	})
+	c.Assert(err, ErrorMatches, `system.disable-public-socket can only be set to 'true' or 'false'`)
+}
@@ -38,6 +38,7 @@ func init() {
 	supportedConfigurations["core.refresh.metered"] = true
 	supportedConfigurations["core.refresh.retain"] = true
 	supportedConfigurations["core.refresh.rate-limit"] = true
+	supportedConfigurations["core.refresh.policy-approval"] = true
 }
 
 func validateRefreshSchedule(tr config.Conf) error {
@@ -72,6 +73,17 @@ func validateRefreshSchedule(tr config.Conf) error {
 		return fmt.Errorf("refresh.metered value %q is invalid", refreshOnMeteredStr)
 	}
 
+	refreshPolicyApprovalStr, err := coreCfg(tr, "refresh.policy-approval")
+	if err != nil {
+		return err
+	}
+	switch refreshPolicyApprovalStr {
+	case "", "high-risk":
+		// noop
+	default:
+		return fmt.Errorf("refresh.policy-approval value %q is invalid", refreshPolicyApprovalStr)
+	}
+
 	// check (new) refresh.timer
 	refreshTimerStr, err := coreCfg(tr, "refresh.timer")
 	if err != nil {
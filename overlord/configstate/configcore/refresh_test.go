@@ -128,6 +128,26 @@ func (s *refreshSuite) TestConfigureRefreshHoldOnMeteredHappy(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *refreshSuite) TestConfigureRefreshPolicyApproval(c *C) {
+	for _, v := range []string{"", "high-risk"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.policy-approval": v,
+			},
+		})
+		c.Check(err, IsNil)
+	}
+
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"refresh.policy-approval": "invalid",
+		},
+	})
+	c.Assert(err, ErrorMatches, `refresh\.policy-approval value "invalid" is invalid`)
+}
+
 func (s *refreshSuite) TestConfigureRefreshRetainHappy(c *C) {
 	err := configcore.Run(&mockConf{
 		state: s.state,
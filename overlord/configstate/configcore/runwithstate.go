@@ -44,6 +44,9 @@ func init() {
 	// resilience.vitality-hint
 	addWithStateHandler(validateVitalitySettings, handleVitalityConfiguration, nil)
 
+	// system.kernel.cmdline-append
+	addWithStateHandler(validateKernelCmdlineAppend, handleKernelCmdlineAppend, coreOnly)
+
 	// XXX: this should become a FSOnlyHandler. We need to
 	// add/implement Changes() to the ConfGetter interface
 	// store-certs.*
@@ -53,6 +56,11 @@ func init() {
 	addWithStateHandler(validateRefreshSchedule, nil, validateOnly)
 	addWithStateHandler(validateRefreshRateLimit, nil, validateOnly)
 	addWithStateHandler(validateAutomaticSnapshotsExpiration, nil, validateOnly)
+	addWithStateHandler(validateAutomaticSnapshotsRefresh, nil, validateOnly)
+	addWithStateHandler(validatePublicSocketSettings, nil, validateOnly)
+	addWithStateHandler(validateHealthReportSettings, nil, validateOnly)
+	addWithStateHandler(validateStoreTLSPins, nil, validateOnly)
+	addWithStateHandler(validateDefaultProvidersSettings, nil, validateOnly)
 }
 
 type withStateHandler struct {
@@ -109,6 +117,10 @@ func Run(cfg config.Conf) error {
 			if !validCertOption(k) {
 				return fmt.Errorf("cannot set store ssl certificate under name %q: name must only contain word characters or a dash", k)
 			}
+		case strings.HasPrefix(k, defaultProvidersOverridesPrefix):
+			if !validDefaultProvidersOverrideOption(k) {
+				return fmt.Errorf("cannot set %q: invalid snap name", k)
+			}
 		case !supportedConfigurations[k]:
 			return fmt.Errorf("cannot set %q: unsupported system option", k)
 		}
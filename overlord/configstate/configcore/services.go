@@ -100,7 +100,7 @@ func switchDisableConsoleConfService(sysd systemd.Systemd, serviceName string, d
 	// at runtime we can not change this setting
 	if opts == nil {
 
-		// Special case: during install mode the
+		// Special case: during install (and factory reset) mode the
 		// gadget-defaults will also be set as part of the
 		// system install change. However during install mode
 		// console-conf has no "complete" file, it just never runs
@@ -110,7 +110,7 @@ func switchDisableConsoleConfService(sysd systemd.Systemd, serviceName string, d
 		//      defaults and compare with the setting and exit if
 		//      they are the same but that requires some more changes.
 		mode, _, _ := boot.ModeAndRecoverySystemFromKernelCommandLine()
-		if mode == boot.ModeInstall {
+		if mode == boot.ModeInstall || mode == boot.ModeFactoryReset {
 			return nil
 		}
 
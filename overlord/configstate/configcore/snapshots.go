@@ -30,6 +30,7 @@ import (
 func init() {
 	// add supported configuration of this module
 	supportedConfigurations["core.snapshots.automatic.retention"] = true
+	supportedConfigurations["core.snapshots.automatic.refresh"] = true
 }
 
 func validateAutomaticSnapshotsExpiration(tr config.Conf) error {
@@ -48,3 +49,16 @@ func validateAutomaticSnapshotsExpiration(tr config.Conf) error {
 	}
 	return nil
 }
+
+func validateAutomaticSnapshotsRefresh(tr config.Conf) error {
+	refreshPolicy, err := coreCfg(tr, "snapshots.automatic.refresh")
+	if err != nil {
+		return err
+	}
+	switch refreshPolicy {
+	case "", "no", "epoch", "major":
+	default:
+		return fmt.Errorf("snapshots.automatic.refresh must be one of \"epoch\", \"major\" or \"no\"")
+	}
+	return nil
+}
@@ -70,3 +70,25 @@ func (s *refreshSuite) TestConfigureAutomaticSnapshotsExpirationInvalid(c *C) {
 	})
 	c.Assert(err, ErrorMatches, `snapshots.automatic.retention cannot be parsed:.*`)
 }
+
+func (s *snapshotsSuite) TestConfigureAutomaticSnapshotsRefreshHappy(c *C) {
+	for _, policy := range []string{"no", "epoch", "major"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"snapshots.automatic.refresh": policy,
+			},
+		})
+		c.Check(err, IsNil)
+	}
+}
+
+func (s *snapshotsSuite) TestConfigureAutomaticSnapshotsRefreshInvalid(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"snapshots.automatic.refresh": "always",
+		},
+	})
+	c.Assert(err, ErrorMatches, `snapshots.automatic.refresh must be one of "epoch", "major" or "no"`)
+}
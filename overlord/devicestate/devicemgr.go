@@ -81,6 +81,8 @@ type DeviceManager struct {
 
 	ensureInstalledRan bool
 
+	ensureFactoryResetRan bool
+
 	cloudInitAlreadyRestricted           bool
 	cloudInitErrorAttemptStart           *time.Time
 	cloudInitEnabledInactiveAttemptStart *time.Time
@@ -90,6 +92,11 @@ type DeviceManager struct {
 	registered                   bool
 	reg                          chan struct{}
 
+	// resealHeld is set while resealing of the encryption keys is held
+	// to coalesce the reseal requests of a batch of changes
+	resealHeld      bool
+	resealBatchDone time.Time
+
 	preseed bool
 }
 
@@ -127,6 +134,8 @@ func Manager(s *state.State, hookManager *hookstate.HookManager, runner *state.T
 	runner.AddHandler("mark-preseeded", m.doMarkPreseeded, nil)
 	runner.AddHandler("mark-seeded", m.doMarkSeeded, nil)
 	runner.AddHandler("setup-run-system", m.doSetupRunSystem, nil)
+	runner.AddHandler("factory-reset-run-system", m.doFactoryResetRunSystem, nil)
+	runner.AddHandler("factory-seal", m.doFactorySeal, nil)
 	runner.AddHandler("prepare-remodeling", m.doPrepareRemodeling, nil)
 	runner.AddCleanup("prepare-remodeling", m.cleanupRemodel)
 	// this *must* always run last and finalizes a remodel
@@ -139,6 +148,9 @@ func Manager(s *state.State, hookManager *hookstate.HookManager, runner *state.T
 	// or gadget snaps. There are no further changes to the boot assets,
 	// unless a new gadget update is deployed.
 	runner.AddHandler("update-gadget-assets", m.doUpdateGadgetAssets, nil)
+	// There is no undo for the boot config update either, the updated
+	// boot config is compatible with the previous snapd.
+	runner.AddHandler("update-managed-boot-config", m.doUpdateManagedBootConfig, nil)
 
 	runner.AddBlocked(gadgetUpdateBlocked)
 
@@ -161,6 +173,13 @@ func (m *DeviceManager) StartUp() error {
 		if err := m.maybeSetupUbuntuSave(); err != nil {
 			return fmt.Errorf("cannot set up ubuntu-save: %v", err)
 		}
+		if err := m.collectUnlockMetrics(); err != nil {
+			logger.Noticef("cannot collect unlock metrics: %v", err)
+		}
+	}
+
+	if err := m.resumeInitLog(); err != nil {
+		logger.Noticef("cannot resume device initialization log: %v", err)
 	}
 
 	return nil
@@ -481,6 +500,7 @@ func (m *DeviceManager) ensureOperational() error {
 
 	chg := m.state.NewChange("become-operational", i18n.G("Initialize device"))
 	chg.AddAll(state.NewTaskSet(tasks...))
+	recordInitStep(m.state, InitStepRegistration, InitStatusStarted, "change "+chg.ID())
 
 	state.TagTimingsWithChange(perfTimings, chg)
 	perfTimings.Save(m.state)
@@ -565,6 +585,7 @@ func (m *DeviceManager) ensureSeeded() error {
 		tsAll, err = populateStateFromSeed(m.state, opts, tm)
 	})
 	if err != nil {
+		recordInitStep(m.state, InitStepSeeding, InitStatusFailed, err.Error())
 		return err
 	}
 	if len(tsAll) == 0 {
@@ -575,6 +596,7 @@ func (m *DeviceManager) ensureSeeded() error {
 	for _, ts := range tsAll {
 		chg.AddAll(ts)
 	}
+	recordInitStep(m.state, InitStepSeeding, InitStatusStarted, "change "+chg.ID())
 	m.state.EnsureBefore(0)
 
 	state.TagTimingsWithChange(perfTimings, chg)
@@ -821,6 +843,45 @@ func (m *DeviceManager) ensureInstalled() error {
 	return nil
 }
 
+func (m *DeviceManager) ensureFactoryReset() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	if release.OnClassic {
+		return nil
+	}
+
+	if m.ensureFactoryResetRan {
+		return nil
+	}
+
+	if m.SystemMode() != "factory-reset" {
+		return nil
+	}
+
+	var seeded bool
+	err := m.state.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !seeded {
+		return nil
+	}
+
+	if m.changeInFlight("factory-reset") {
+		return nil
+	}
+
+	m.ensureFactoryResetRan = true
+
+	factoryReset := m.state.NewTask("factory-reset-run-system", i18n.G("Perform factory reset of the system"))
+
+	chg := m.state.NewChange("factory-reset", i18n.G("Perform factory reset"))
+	chg.AddAll(state.NewTaskSet(factoryReset))
+
+	return nil
+}
+
 var timeNow = time.Now
 
 // StartOfOperationTime returns the time when snapd started operating,
@@ -949,6 +1010,14 @@ func (m *DeviceManager) Ensure() error {
 		if err := m.ensureInstalled(); err != nil {
 			errs = append(errs, err)
 		}
+
+		if err := m.ensureFactoryReset(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := m.ensureResealCoalesced(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	if len(errs) > 0 {
@@ -1097,6 +1166,9 @@ func (m *DeviceManager) Serial() (*asserts.Serial, error) {
 type SystemAction struct {
 	Title string
 	Mode  string
+	// GadgetAction is set to the kind of action for actions declared by
+	// the gadget, see gadget.RecoveryAction
+	GadgetAction string
 }
 
 type System struct {
@@ -1119,10 +1191,12 @@ var defaultSystemActions = []SystemAction{
 var currentSystemActions = []SystemAction{
 	{Title: "Reinstall", Mode: "install"},
 	{Title: "Recover", Mode: "recover"},
+	{Title: "Factory reset", Mode: "factory-reset"},
 	{Title: "Run normally", Mode: "run"},
 }
 var recoverSystemActions = []SystemAction{
 	{Title: "Reinstall", Mode: "install"},
+	{Title: "Factory reset", Mode: "factory-reset"},
 	{Title: "Run normally", Mode: "run"},
 }
 
@@ -1214,6 +1288,10 @@ func (m *DeviceManager) RequestSystemAction(systemLabel string, action SystemAct
 		return fmt.Errorf("internal error: system label is unset")
 	}
 
+	if action.GadgetAction != "" {
+		return m.requestGadgetRecoveryAction(systemLabel, action)
+	}
+
 	nop := func() {}
 	switched := func(systemLabel string, sysAction *SystemAction) {
 		logger.Noticef("restarting into system %q for action %q", systemLabel, sysAction.Title)
@@ -1252,6 +1330,11 @@ func (m *DeviceManager) switchToSystemAndMode(systemLabel, mode string, sameSyst
 
 	var sysAction *SystemAction
 	for _, act := range system.Actions {
+		if act.GadgetAction != "" {
+			// gadget actions are only carried out when requested
+			// explicitly
+			continue
+		}
 		if mode == act.Mode {
 			sysAction = &act
 			break
@@ -1266,7 +1349,7 @@ func (m *DeviceManager) switchToSystemAndMode(systemLabel, mode string, sameSyst
 	// recover 'actions'
 
 	switch systemMode {
-	case "recover", "run":
+	case "recover", "repair", "run":
 		// if going from recover to recover or from run to run and the systems
 		// are the same do nothing
 		if systemMode == sysAction.Mode && currentSys != nil && systemLabel == currentSys.System {
@@ -1275,7 +1358,7 @@ func (m *DeviceManager) switchToSystemAndMode(systemLabel, mode string, sameSyst
 			sameSystemAndMode()
 			return nil
 		}
-	case "install":
+	case "install", "factory-reset":
 		// requesting system actions in install mode does not make sense atm
 		//
 		// TODO:UC20: maybe factory hooks will be able to something like
@@ -1294,6 +1377,13 @@ func (m *DeviceManager) switchToSystemAndMode(systemLabel, mode string, sameSyst
 	if err != nil {
 		return err
 	}
+	if mode == "factory-reset" {
+		// refuse now rather than after rebooting, the device would
+		// otherwise keep booting into factory-reset mode
+		if err := checkFactoryResetRequest(m.state, deviceCtx); err != nil {
+			return err
+		}
+	}
 	if err := boot.SetRecoveryBootSystemAndMode(deviceCtx, systemLabel, mode); err != nil {
 		return fmt.Errorf("cannot set device to boot into system %q in mode %q: %v", systemLabel, mode, err)
 	}
@@ -24,6 +24,7 @@ package devicestate
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
 
 	"github.com/snapcore/snapd/asserts"
@@ -44,8 +45,9 @@ import (
 )
 
 var (
-	snapstateInstallWithDeviceContext = snapstate.InstallWithDeviceContext
-	snapstateUpdateWithDeviceContext  = snapstate.UpdateWithDeviceContext
+	snapstateInstallWithDeviceContext     = snapstate.InstallWithDeviceContext
+	snapstateInstallPathWithDeviceContext = snapstate.InstallPathWithDeviceContext
+	snapstateUpdateWithDeviceContext      = snapstate.UpdateWithDeviceContext
 )
 
 // findModel returns the device model assertion.
@@ -232,6 +234,33 @@ func delayedCrossMgrInit() {
 	snapstate.IsOnMeteredConnection = netutil.IsOnMeteredConnection
 	snapstate.DeviceCtx = DeviceCtx
 	snapstate.Remodeling = Remodeling
+	snapstate.SystemPolicyAllows = systemPolicyAllows
+}
+
+// systemPolicyAllows returns whether the system-policy assertion for the
+// model of the device, if there is one, allows the given action.
+func systemPolicyAllows(st *state.State, action string, deviceCtx snapstate.DeviceContext) (bool, error) {
+	model := deviceCtx.Model()
+	a, err := assertstate.DB(st).Find(asserts.SystemPolicyType, map[string]string{
+		"brand-id": model.BrandID(),
+		"model":    model.Model(),
+	})
+	if asserts.IsNotFound(err) {
+		// no policy, everything is allowed
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	policy := a.(*asserts.SystemPolicy)
+
+	switch action {
+	case snapstate.SystemPolicyDangerous:
+		return !policy.DisallowDangerousInstalls(), nil
+	case snapstate.SystemPolicyDevMode:
+		return !policy.DisallowDevModeInstalls(), nil
+	}
+	return false, fmt.Errorf("internal error: unknown system policy action %q", action)
 }
 
 // proxyStore returns the store assertion for the proxy store if one is set.
@@ -346,13 +375,54 @@ func notInstalled(st *state.State, name string) (bool, error) {
 	return false, err
 }
 
-func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Model, deviceCtx snapstate.DeviceContext, fromChange string) ([]*state.TaskSet, error) {
+// LocalSnap is a snap file provided locally, with its side info derived
+// from the assertions, to be used by an offline remodel. The file is
+// removed once the snap has been installed.
+type LocalSnap struct {
+	SideInfo *snap.SideInfo
+	Path     string
+
+	// used is set when the remodel installs the snap
+	used bool
+}
+
+func installLocalSnap(st *state.State, localSnaps map[string]*LocalSnap, name string, opts *snapstate.RevisionOptions, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+	ls := localSnaps[name]
+	if ls == nil {
+		return nil, fmt.Errorf("cannot remodel offline: snap %q was not provided", name)
+	}
+	var channel string
+	if opts != nil {
+		channel = opts.Channel
+	}
+	flags.RemoveSnapPath = true
+	ls.used = true
+	return snapstateInstallPathWithDeviceContext(st, ls.SideInfo, ls.Path, channel, flags, deviceCtx, fromChange)
+}
+
+// remodelTasks returns the tasks to take the device from the current to
+// the new model. localSnaps is nil unless remodeling offline, in which
+// case any snap to install or update must be found there.
+func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Model, deviceCtx snapstate.DeviceContext, fromChange string, localSnaps map[string]*LocalSnap) ([]*state.TaskSet, error) {
 	userID := 0
 	var tss []*state.TaskSet
 
+	installSnap := func(name string, opts *snapstate.RevisionOptions, flags snapstate.Flags) (*state.TaskSet, error) {
+		if localSnaps != nil {
+			return installLocalSnap(st, localSnaps, name, opts, flags, deviceCtx, fromChange)
+		}
+		return snapstateInstallWithDeviceContext(ctx, st, name, opts, userID, flags, deviceCtx, fromChange)
+	}
+	updateSnap := func(name string, opts *snapstate.RevisionOptions, flags snapstate.Flags) (*state.TaskSet, error) {
+		if localSnaps != nil {
+			return installLocalSnap(st, localSnaps, name, opts, flags, deviceCtx, fromChange)
+		}
+		return snapstateUpdateWithDeviceContext(st, name, opts, userID, flags, deviceCtx, fromChange)
+	}
+
 	// kernel
 	if current.Kernel() == new.Kernel() && current.KernelTrack() != new.KernelTrack() {
-		ts, err := snapstateUpdateWithDeviceContext(st, new.Kernel(), &snapstate.RevisionOptions{Channel: new.KernelTrack()}, userID, snapstate.Flags{NoReRefresh: true}, deviceCtx, fromChange)
+		ts, err := updateSnap(new.Kernel(), &snapstate.RevisionOptions{Channel: new.KernelTrack()}, snapstate.Flags{NoReRefresh: true})
 		if err != nil {
 			return nil, err
 		}
@@ -366,7 +436,7 @@ func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Mo
 			return nil, err
 		}
 		if needsInstall {
-			ts, err = snapstateInstallWithDeviceContext(ctx, st, new.Kernel(), &snapstate.RevisionOptions{Channel: new.KernelTrack()}, userID, snapstate.Flags{}, deviceCtx, fromChange)
+			ts, err = installSnap(new.Kernel(), &snapstate.RevisionOptions{Channel: new.KernelTrack()}, snapstate.Flags{})
 		} else {
 			ts, err = snapstate.LinkNewBaseOrKernel(st, new.Base())
 		}
@@ -381,7 +451,7 @@ func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Mo
 			return nil, err
 		}
 		if needsInstall {
-			ts, err = snapstateInstallWithDeviceContext(ctx, st, new.Base(), nil, userID, snapstate.Flags{}, deviceCtx, fromChange)
+			ts, err = installSnap(new.Base(), nil, snapstate.Flags{})
 		} else {
 			ts, err = snapstate.LinkNewBaseOrKernel(st, new.Base())
 		}
@@ -392,14 +462,14 @@ func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Mo
 	}
 	// gadget
 	if current.Gadget() == new.Gadget() && current.GadgetTrack() != new.GadgetTrack() {
-		ts, err := snapstateUpdateWithDeviceContext(st, new.Gadget(), &snapstate.RevisionOptions{Channel: new.GadgetTrack()}, userID, snapstate.Flags{NoReRefresh: true}, deviceCtx, fromChange)
+		ts, err := updateSnap(new.Gadget(), &snapstate.RevisionOptions{Channel: new.GadgetTrack()}, snapstate.Flags{NoReRefresh: true})
 		if err != nil {
 			return nil, err
 		}
 		tss = append(tss, ts)
 	}
 	if current.Gadget() != new.Gadget() {
-		ts, err := snapstateInstallWithDeviceContext(ctx, st, new.Gadget(), &snapstate.RevisionOptions{Channel: new.GadgetTrack()}, userID, snapstate.Flags{}, deviceCtx, fromChange)
+		ts, err := installSnap(new.Gadget(), &snapstate.RevisionOptions{Channel: new.GadgetTrack()}, snapstate.Flags{})
 		if err != nil {
 			return nil, err
 		}
@@ -417,7 +487,7 @@ func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Mo
 		}
 		if needsInstall {
 			// If the snap is not installed we need to install it now.
-			ts, err := snapstateInstallWithDeviceContext(ctx, st, snapRef.SnapName(), nil, userID, snapstate.Flags{Required: true}, deviceCtx, fromChange)
+			ts, err := installSnap(snapRef.SnapName(), nil, snapstate.Flags{Required: true})
 			if err != nil {
 				return nil, err
 			}
@@ -505,6 +575,52 @@ func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Mo
 // - Make sure this works with Core 20 as well, in the Core 20 case
 //   we must enforce the default-channels from the model as well
 func Remodel(st *state.State, new *asserts.Model) (*state.Change, error) {
+	return remodel(st, new, nil)
+}
+
+// RemodelOffline is like Remodel but it never contacts the store, all
+// the snaps that need to be installed or updated must be provided as
+// localSnaps, with their assertions already in the system assertion
+// database. A change of brand or model does not request a new serial
+// as part of the remodel, the device will register again once it can
+// reach the serial vault.
+//
+// RemodelOffline takes over the snap files: they are all removed on error,
+// the ones not needed by the remodel right away, and the others once their
+// snap is installed or at the latest when the change is ready.
+func RemodelOffline(st *state.State, new *asserts.Model, localSnaps []*LocalSnap) (*state.Change, error) {
+	snaps := make(map[string]*LocalSnap, len(localSnaps))
+	for _, ls := range localSnaps {
+		snaps[ls.SideInfo.RealName] = ls
+	}
+	chg, err := remodel(st, new, snaps)
+
+	var pending []string
+	for _, ls := range localSnaps {
+		if err == nil && ls.used {
+			pending = append(pending, ls.Path)
+			continue
+		}
+		removeLocalSnapFile(ls.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the files are removed once the snaps are mounted, remember them
+	// in case the change fails before that
+	chg.Set("local-snap-files", pending)
+	return chg, nil
+}
+
+func removeLocalSnapFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logger.Noticef("cannot remove local snap file %s: %v", path, err)
+	}
+}
+
+func remodel(st *state.State, new *asserts.Model, localSnaps map[string]*LocalSnap) (*state.Change, error) {
+	offline := localSnaps != nil
+
 	var seeded bool
 	err := st.Get("seeded", &seeded)
 	if err != nil && err != state.ErrNoState {
@@ -570,6 +686,16 @@ func Remodel(st *state.State, new *asserts.Model) (*state.Change, error) {
 			}
 		}
 
+		if offline {
+			// no re-registration, the snaps are all available
+			var err error
+			tss, err = remodelTasks(context.TODO(), st, current, new, remodCtx, "", localSnaps)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
 		requestSerial := st.NewTask("request-serial", i18n.G("Request new device serial"))
 
 		prepare := st.NewTask("prepare-remodeling", i18n.G("Prepare remodeling"))
@@ -581,17 +707,20 @@ func Remodel(st *state.State, new *asserts.Model) (*state.Change, error) {
 		if sto == nil {
 			return nil, fmt.Errorf("internal error: a store switch remodeling should have built a store")
 		}
-		// ensure a new session accounting for the new brand store
-		st.Unlock()
-		_, err := sto.EnsureDeviceSession()
-		st.Lock()
-		if err != nil {
-			return nil, fmt.Errorf("cannot get a store session based on the new model assertion: %v", err)
+		// ensure a new session accounting for the new brand store,
+		// unless offline when the store is not used
+		if !offline {
+			st.Unlock()
+			_, err := sto.EnsureDeviceSession()
+			st.Lock()
+			if err != nil {
+				return nil, fmt.Errorf("cannot get a store session based on the new model assertion: %v", err)
+			}
 		}
 		fallthrough
 	case UpdateRemodel:
 		var err error
-		tss, err = remodelTasks(context.TODO(), st, current, new, remodCtx, "")
+		tss, err = remodelTasks(context.TODO(), st, current, new, remodCtx, "", localSnaps)
 		if err != nil {
 			return nil, err
 		}
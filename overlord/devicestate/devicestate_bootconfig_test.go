@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate_test
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type deviceMgrBootconfigSuite struct {
+	deviceMgrBaseSuite
+
+	updateCalls  int
+	updateResult bool
+	updateErr    error
+}
+
+var _ = Suite(&deviceMgrBootconfigSuite{})
+
+func (s *deviceMgrBootconfigSuite) SetUpTest(c *C) {
+	s.deviceMgrBaseSuite.SetUpTest(c)
+
+	s.state.Lock()
+	s.state.Set("seeded", true)
+	s.state.Unlock()
+
+	// the boot of the run mode system was already marked successful
+	m := boot.Modeenv{
+		Mode:           "run",
+		RecoverySystem: "20191127",
+		Base:           "core20_1.snap",
+		CurrentKernels: []string{"pc-kernel_1.snap"},
+	}
+	c.Assert(m.WriteTo(""), IsNil)
+	devicestate.SetBootOkRan(s.mgr, true)
+
+	s.updateCalls = 0
+	s.updateResult = false
+	s.updateErr = nil
+	s.AddCleanup(devicestate.MockBootUpdateManagedBootConfigs(func(dev boot.Device) (bool, error) {
+		s.updateCalls++
+		c.Check(dev.HasModeenv(), Equals, true)
+		c.Check(dev.RunMode(), Equals, true)
+		return s.updateResult, s.updateErr
+	}))
+}
+
+func (s *deviceMgrBootconfigSuite) setupUC20Model(c *C, grade string) {
+	extras := map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	}
+	if grade != "" {
+		extras = map[string]interface{}{
+			"display-name": "UC20 pc model",
+			"architecture": "amd64",
+			"base":         "core20",
+			"grade":        grade,
+			"snaps": []interface{}{
+				map[string]interface{}{
+					"name":            "pc-kernel",
+					"id":              "pckernelidididididididididididid",
+					"type":            "kernel",
+					"default-channel": "20",
+				},
+				map[string]interface{}{
+					"name":            "pc",
+					"id":              "pcididididididididididididididid",
+					"type":            "gadget",
+					"default-channel": "20",
+				}},
+		}
+	}
+	s.makeModelAssertionInState(c, "canonical", "pc-model", extras)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  "pc-model",
+		Serial: "serial",
+	})
+}
+
+func (s *deviceMgrBootconfigSuite) runUpdateTask(c *C, grade, mode string) (*state.Change, *state.Task) {
+	s.state.Lock()
+	s.setupUC20Model(c, grade)
+	devicestate.SetSystemMode(s.mgr, mode)
+
+	tsk := s.state.NewTask("update-managed-boot-config", "update boot config")
+	tsk.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{RealName: "snapd", Revision: snap.R(2)},
+		Type:     snap.TypeSnapd,
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(tsk)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	return chg, tsk
+}
+
+func (s *deviceMgrBootconfigSuite) TestUpdateBootConfigNoRestart(c *C) {
+	chg, tsk := s.runUpdateTask(c, "dangerous", "run")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Err(), IsNil)
+	c.Check(tsk.Status(), Equals, state.DoneStatus)
+	c.Check(s.updateCalls, Equals, 1)
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+func (s *deviceMgrBootconfigSuite) TestUpdateBootConfigRestartsWhenUpdated(c *C) {
+	s.updateResult = true
+	chg, tsk := s.runUpdateTask(c, "dangerous", "run")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Err(), IsNil)
+	c.Check(tsk.Status(), Equals, state.DoneStatus)
+	c.Check(s.updateCalls, Equals, 1)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+	c.Check(tsk.Log(), HasLen, 1)
+	c.Check(tsk.Log()[0], Matches, ".* Updated boot config assets")
+}
+
+func (s *deviceMgrBootconfigSuite) TestUpdateBootConfigError(c *C) {
+	s.updateErr = errors.New("boom")
+	chg, tsk := s.runUpdateTask(c, "dangerous", "run")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot update boot config assets: boom.*`)
+	c.Check(tsk.Status(), Equals, state.ErrorStatus)
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+func (s *deviceMgrBootconfigSuite) TestUpdateBootConfigNotUC20(c *C) {
+	chg, tsk := s.runUpdateTask(c, "", "run")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Err(), IsNil)
+	c.Check(tsk.Status(), Equals, state.DoneStatus)
+	c.Check(s.updateCalls, Equals, 0)
+}
+
+func (s *deviceMgrBootconfigSuite) TestUpdateBootConfigNotRunMode(c *C) {
+	chg, tsk := s.runUpdateTask(c, "dangerous", "recover")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Err(), IsNil)
+	c.Check(tsk.Status(), Equals, state.DoneStatus)
+	c.Check(s.updateCalls, Equals, 0)
+}
@@ -0,0 +1,280 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type deviceMgrFactorySuite struct {
+	deviceMgrBaseSuite
+}
+
+var _ = Suite(&deviceMgrFactorySuite{})
+
+func (s *deviceMgrFactorySuite) SetUpTest(c *C) {
+	s.deviceMgrBaseSuite.SetUpTest(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	devicestate.SetBootOkRan(s.mgr, true)
+}
+
+func (s *deviceMgrFactorySuite) setModel(c *C, grade string) *asserts.Model {
+	s.state.Lock()
+	defer s.state.Unlock()
+	modelName := "pc-20-" + grade
+	model := s.makeModelAssertionInState(c, "canonical", modelName, map[string]interface{}{
+		"architecture": "amd64",
+		"grade":        grade,
+		"base":         "core20",
+		"snaps": []interface{}{
+			map[string]interface{}{
+				"name":            "pc-kernel",
+				"id":              snaptest.AssertedSnapID("pc-kernel"),
+				"type":            "kernel",
+				"default-channel": "20",
+			},
+			map[string]interface{}{
+				"name":            "pc",
+				"id":              snaptest.AssertedSnapID("pc"),
+				"type":            "gadget",
+				"default-channel": "20",
+			},
+		},
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  modelName,
+		Serial: "serialserialserial",
+	})
+	s.mockGadget(c, "")
+	return model
+}
+
+func (s *deviceMgrFactorySuite) mockGadget(c *C, extraGadgetYaml string) {
+	si := &snap.SideInfo{
+		RealName: "pc",
+		Revision: snap.R(1),
+		SnapID:   snaptest.AssertedSnapID("pc"),
+	}
+	snapstate.Set(s.state, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+		Active:   true,
+	})
+	snaptest.MockSnapWithFiles(c, "name: pc\ntype: gadget", si, [][]string{
+		{"meta/gadget.yaml", uc20gadgetYaml + extraGadgetYaml},
+	})
+}
+
+func (s *deviceMgrFactorySuite) mockFactoryMode(c *C, content string) (modeFile, getty string) {
+	modeFile = filepath.Join(dirs.SnapDeviceDir, "factory-mode")
+	c.Assert(os.MkdirAll(filepath.Dir(modeFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(modeFile, []byte(content), 0644), IsNil)
+
+	getty = filepath.Join(dirs.SnapServicesDir, "getty.target.wants/serial-getty@ttyS0.service")
+	c.Assert(os.MkdirAll(filepath.Dir(getty), 0755), IsNil)
+	c.Assert(os.Symlink("/lib/systemd/system/serial-getty@.service", getty), IsNil)
+	return modeFile, getty
+}
+
+func (s *deviceMgrFactorySuite) TestFactorySealHappy(c *C) {
+	model := s.setModel(c, "secured")
+	modeFile, getty := s.mockFactoryMode(c, `{"allow-test-snaps":true,"serial-console":"ttyS0"}`)
+
+	sealCalls := 0
+	s.AddCleanup(devicestate.MockBootSealFactoryKeys(func(m *asserts.Model, tpmOpts boot.TPMOptions) error {
+		sealCalls++
+		c.Check(m, DeepEquals, model)
+		c.Check(tpmOpts, Equals, boot.TPMOptions{})
+		// the state lock is held while sealing
+		var sealed bool
+		c.Check(s.state.Get("factory-sealed", &sealed), Equals, state.ErrNoState)
+		return nil
+	}))
+
+	s.state.Lock()
+	chg, err := devicestate.FactorySeal(s.state)
+	c.Assert(err, IsNil)
+	c.Check(chg.Kind(), Equals, "factory-seal")
+
+	// only one at a time
+	_, err = devicestate.FactorySeal(s.state)
+	c.Assert(err, FitsTypeOf, &snapstate.ChangeConflictError{})
+	c.Check(err, ErrorMatches, "cannot seal the device: sealing already in progress")
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(sealCalls, Equals, 1)
+	c.Check(modeFile, testutil.FileAbsent)
+	c.Check(getty, testutil.FileAbsent)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+
+	var sealed bool
+	c.Assert(s.state.Get("factory-sealed", &sealed), IsNil)
+	c.Check(sealed, Equals, true)
+
+	// the device left factory mode for good
+	_, err = devicestate.FactorySeal(s.state)
+	c.Assert(err, ErrorMatches, "cannot seal the device: not in factory mode")
+}
+
+func (s *deviceMgrFactorySuite) TestFactorySealTPMOptions(c *C) {
+	s.setModel(c, "secured")
+	s.state.Lock()
+	s.mockGadget(c, `
+encryption:
+  tpm:
+    srk-handle: 0x81000002
+    wrap-policy-auth-key: true
+    skip-ek-verification: true
+`)
+	s.state.Unlock()
+	s.mockFactoryMode(c, `{}`)
+	// the endorsement key certificate provisioned for the device
+	ekCertFile := filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "tpm-ek-cert")
+	c.Assert(os.MkdirAll(filepath.Dir(ekCertFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(ekCertFile, []byte("ek-cert"), 0644), IsNil)
+
+	sealCalls := 0
+	s.AddCleanup(devicestate.MockBootSealFactoryKeys(func(m *asserts.Model, tpmOpts boot.TPMOptions) error {
+		sealCalls++
+		c.Check(tpmOpts, Equals, boot.TPMOptions{
+			SRKHandle:          0x81000002,
+			WrapPolicyAuthKey:  true,
+			EKCertFile:         ekCertFile,
+			SkipEKVerification: true,
+		})
+		return nil
+	}))
+
+	s.state.Lock()
+	chg, err := devicestate.FactorySeal(s.state)
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(sealCalls, Equals, 1)
+}
+
+func (s *deviceMgrFactorySuite) TestFactorySealNoFactoryKeys(c *C) {
+	s.setModel(c, "dangerous")
+	modeFile, _ := s.mockFactoryMode(c, `{}`)
+
+	s.AddCleanup(devicestate.MockBootSealFactoryKeys(func(m *asserts.Model, tpmOpts boot.TPMOptions) error {
+		return boot.ErrNoFactoryKeys
+	}))
+
+	s.state.Lock()
+	chg, err := devicestate.FactorySeal(s.state)
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(modeFile, testutil.FileAbsent)
+}
+
+func (s *deviceMgrFactorySuite) TestFactorySealError(c *C) {
+	s.setModel(c, "secured")
+	modeFile, getty := s.mockFactoryMode(c, `{"serial-console":"ttyS0"}`)
+
+	s.AddCleanup(devicestate.MockBootSealFactoryKeys(func(m *asserts.Model, tpmOpts boot.TPMOptions) error {
+		return errors.New("boom")
+	}))
+
+	s.state.Lock()
+	chg, err := devicestate.FactorySeal(s.state)
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Err(), ErrorMatches, `(?s).*\(cannot seal the device: boom\)`)
+	// still in factory mode
+	c.Check(modeFile, testutil.FilePresent)
+	c.Check(getty, testutil.FilePresent)
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+func (s *deviceMgrFactorySuite) TestCheckUnassertedSnapAllowed(c *C) {
+	s.state.Lock()
+	// no model
+	c.Check(devicestate.CheckUnassertedSnapAllowed(s.state), IsNil)
+	s.state.Unlock()
+
+	s.setModel(c, "signed")
+	s.state.Lock()
+	c.Check(devicestate.CheckUnassertedSnapAllowed(s.state), IsNil)
+	s.state.Unlock()
+
+	s.setModel(c, "secured")
+	s.state.Lock()
+	defer s.state.Unlock()
+	// never in factory mode
+	c.Check(devicestate.CheckUnassertedSnapAllowed(s.state), IsNil)
+
+	modeFile, _ := s.mockFactoryMode(c, `{"serial-console":"ttyS0"}`)
+	c.Check(devicestate.CheckUnassertedSnapAllowed(s.state), ErrorMatches,
+		"cannot install snaps without assertions on a device with a secured model")
+
+	c.Assert(ioutil.WriteFile(modeFile, []byte(`{"allow-test-snaps":true}`), 0644), IsNil)
+	c.Check(devicestate.CheckUnassertedSnapAllowed(s.state), IsNil)
+
+	// sealed out of factory mode
+	c.Assert(os.Remove(modeFile), IsNil)
+	s.state.Set("factory-sealed", true)
+	c.Check(devicestate.CheckUnassertedSnapAllowed(s.state), ErrorMatches,
+		"cannot install snaps without assertions on a device with a secured model")
+}
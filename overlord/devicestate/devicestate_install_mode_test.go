@@ -21,6 +21,7 @@ package devicestate_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -66,6 +67,15 @@ func (s *deviceMgrInstallModeSuite) findInstallSystem() *state.Change {
 	return nil
 }
 
+func (s *deviceMgrInstallModeSuite) findFactoryReset() *state.Change {
+	for _, chg := range s.state.Changes() {
+		if chg.Kind() == "factory-reset" {
+			return chg
+		}
+	}
+	return nil
+}
+
 func (s *deviceMgrInstallModeSuite) SetUpTest(c *C) {
 	s.deviceMgrBaseSuite.SetUpTest(c)
 
@@ -170,6 +180,16 @@ type encTestCase struct {
 	bypass            bool
 	encrypt           bool
 	trustedBootloader bool
+
+	optee              bool
+	caam               bool
+	gadgetKeyProtector string
+	// the key protector expected to be used instead of the TPM
+	keyProtector string
+
+	factoryMode bool
+
+	dataIntegrity bool
 }
 
 var (
@@ -227,6 +247,25 @@ func (s *deviceMgrInstallModeSuite) doRunChangeTestWithEncryption(c *C, grade st
 	})
 	defer restore()
 
+	restore = devicestate.MockSecbootCheckOPTEEKeySealingSupported(func() error {
+		if tc.optee {
+			return nil
+		}
+		return fmt.Errorf("OP-TEE not available")
+	})
+	defer restore()
+
+	restore = devicestate.MockSecbootCheckCAAMKeySealingSupported(func() error {
+		if tc.caam {
+			return nil
+		}
+		return fmt.Errorf("CAAM not available")
+	})
+	defer restore()
+
+	// keys are sealed to the boot chains with all the key protectors
+	sealToBootChains := tc.encrypt
+
 	if tc.trustedBootloader {
 		tab := bootloadertest.Mock("trusted", bootloaderRootdir).WithTrustedAssets()
 		tab.TrustedAssetsList = []string{"trusted-asset"}
@@ -240,7 +279,17 @@ func (s *deviceMgrInstallModeSuite) doRunChangeTestWithEncryption(c *C, grade st
 	}
 
 	s.state.Lock()
-	mockModel := s.makeMockInstalledPcGadget(c, grade, "")
+	gadgetEncryptionYaml := ""
+	if tc.gadgetKeyProtector != "" {
+		gadgetEncryptionYaml = "\nencryption:\n  key-protector: " + tc.gadgetKeyProtector + "\n"
+	}
+	if tc.dataIntegrity {
+		gadgetEncryptionYaml = "\nencryption:\n  luks:\n    data-integrity: hmac-sha256\n"
+	}
+	if tc.factoryMode {
+		gadgetEncryptionYaml += "\nfactory-mode:\n  allow-test-snaps: true\n  serial-console: ttyS0\n"
+	}
+	mockModel := s.makeMockInstalledPcGadget(c, grade, gadgetEncryptionYaml)
 	s.state.Unlock()
 
 	bypassEncryptionPath := filepath.Join(boot.InitramfsUbuntuSeedDir, ".force-unencrypted")
@@ -262,7 +311,7 @@ func (s *deviceMgrInstallModeSuite) doRunChangeTestWithEncryption(c *C, grade st
 		c.Check(bootWith.BasePath, Matches, ".*/var/lib/snapd/snaps/core20_2.snap")
 		c.Check(bootWith.RecoverySystemDir, Matches, "/systems/20191218")
 		c.Check(bootWith.UnpackedGadgetDir, Equals, filepath.Join(dirs.SnapMountDir, "pc/1"))
-		if tc.encrypt {
+		if sealToBootChains {
 			c.Check(seal, NotNil)
 		} else {
 			c.Check(seal, IsNil)
@@ -303,16 +352,21 @@ func (s *deviceMgrInstallModeSuite) doRunChangeTestWithEncryption(c *C, grade st
 	c.Assert(brGadgetRoot, Equals, filepath.Join(dirs.SnapMountDir, "/pc/1"))
 	c.Assert(brDevice, Equals, "")
 	if tc.encrypt {
+		var luksParams *gadget.LUKSParameters
+		if tc.dataIntegrity {
+			luksParams = &gadget.LUKSParameters{DataIntegrity: "hmac-sha256"}
+		}
 		c.Assert(brOpts, DeepEquals, install.Options{
-			Mount:   true,
-			Encrypt: true,
+			Mount:          true,
+			Encrypt:        true,
+			LUKSParameters: luksParams,
 		})
 	} else {
 		c.Assert(brOpts, DeepEquals, install.Options{
 			Mount: true,
 		})
 	}
-	if tc.encrypt {
+	if sealToBootChains {
 		// inteface is not nil
 		c.Assert(installSealingObserver, NotNil)
 		// we expect a very specific type
@@ -407,6 +461,18 @@ func (s *deviceMgrInstallModeSuite) TestInstallDangerousWithTPM(c *C) {
 	})
 	c.Assert(err, IsNil)
 	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+
+	// the initialization log is handed over to the run system
+	b, err := ioutil.ReadFile(filepath.Join(dirs.SnapDeviceDirUnder(boot.InstallHostWritableDir), "init-log.json"))
+	c.Assert(err, IsNil)
+	var initLog []*devicestate.InitLogEntry
+	c.Assert(json.Unmarshal(b, &initLog), IsNil)
+	c.Assert(initLog, HasLen, 2)
+	c.Check(initLog[0].Step, Equals, "sealing")
+	c.Check(initLog[0].Status, Equals, "started")
+	c.Check(initLog[1].Step, Equals, "sealing")
+	c.Check(initLog[1].Status, Equals, "done")
+	c.Check(initLog[1].Detail, Equals, "tpm")
 }
 
 func (s *deviceMgrInstallModeSuite) TestInstallDangerousBypassEncryption(c *C) {
@@ -450,6 +516,26 @@ func (s *deviceMgrInstallModeSuite) TestInstallSecuredWithTPM(c *C) {
 	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
 }
 
+func (s *deviceMgrInstallModeSuite) TestInstallSecuredWithTPMFactoryMode(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{
+		tpm: true, encrypt: true, trustedBootloader: true, factoryMode: true,
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+	c.Check(filepath.Join(dirs.SnapDeviceDirUnder(boot.InstallHostWritableDir), "factory-mode"), testutil.FileEquals,
+		`{"allow-test-snaps":true,"serial-console":"ttyS0"}`)
+	getty := filepath.Join(boot.InstallHostWritableDir, "_writable_defaults/etc/systemd/system/getty.target.wants/serial-getty@ttyS0.service")
+	target, err := os.Readlink(getty)
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "/lib/systemd/system/serial-getty@.service")
+}
+
+func (s *deviceMgrInstallModeSuite) TestInstallDangerousFactoryModeNoEncryption(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "dangerous", encTestCase{factoryMode: true})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(dirs.SnapDeviceDirUnder(boot.InstallHostWritableDir), "factory-mode"), testutil.FilePresent)
+}
+
 func (s *deviceMgrInstallModeSuite) TestInstallDangerousEncryptionWithTPMNoTrustedAssets(c *C) {
 	err := s.doRunChangeTestWithEncryption(c, "dangerous", encTestCase{
 		tpm: true, bypass: false, encrypt: true, trustedBootloader: false,
@@ -475,11 +561,75 @@ func (s *deviceMgrInstallModeSuite) TestInstallSecuredWithTPMAndSave(c *C) {
 	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "reinstall.key"), testutil.FileEquals, reinstallKey[:])
 }
 
+func (s *deviceMgrInstallModeSuite) TestInstallSecuredWithTPMDataIntegrity(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{
+		tpm: true, bypass: false, encrypt: true, trustedBootloader: true, dataIntegrity: true,
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+	// the integrity requirement is recorded by boot, along with the
+	// sealed keys, and not next to them
+	c.Check(filepath.Join(boot.InitramfsBootEncryptionKeyDir, "ubuntu-data.integrity"), testutil.FileAbsent)
+}
+
+func (s *deviceMgrInstallModeSuite) TestSaveKeysExtraVolumes(c *C) {
+	extraRecoveryKey := secboot.RecoveryKey{'e', 'x', 't', 'r', 'a', 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	err := devicestate.SaveKeys(map[string]*install.EncryptionKeySet{
+		gadget.SystemData: {Key: dataEncryptionKey, RecoveryKey: dataRecoveryKey},
+		gadget.SystemSave: {Key: saveKey, RecoveryKey: reinstallKey},
+	}, map[string]*install.EncryptionKeySet{
+		"extra": {RecoveryKey: extraRecoveryKey},
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "extra.recovery.key"), testutil.FileEquals, extraRecoveryKey[:])
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "ubuntu-save.key"), testutil.FileEquals, saveKey[:])
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "reinstall.key"), testutil.FileEquals, reinstallKey[:])
+}
+
 func (s *deviceMgrInstallModeSuite) TestInstallSecuredBypassEncryption(c *C) {
 	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{tpm: false, bypass: true, encrypt: false})
 	c.Assert(err, ErrorMatches, "(?s).*cannot encrypt secured device: TPM not available.*")
 }
 
+func (s *deviceMgrInstallModeSuite) TestInstallSecuredWithOPTEE(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{
+		optee: true, gadgetKeyProtector: "optee", encrypt: true, keyProtector: "optee",
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "ubuntu-save.key"), testutil.FileEquals, saveKey[:])
+}
+
+func (s *deviceMgrInstallModeSuite) TestInstallSecuredOPTEENotAvailableFallbackTPM(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{
+		tpm: true, gadgetKeyProtector: "optee", encrypt: true, trustedBootloader: true,
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+}
+
+func (s *deviceMgrInstallModeSuite) TestInstallDangerousOPTEENotSelectedByGadget(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "dangerous", encTestCase{optee: true, encrypt: false})
+	c.Assert(err, IsNil)
+}
+
+func (s *deviceMgrInstallModeSuite) TestInstallSecuredWithCAAM(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{
+		caam: true, gadgetKeyProtector: "caam", encrypt: true, keyProtector: "caam",
+	})
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "recovery.key"), testutil.FileEquals, dataRecoveryKey[:])
+	c.Check(filepath.Join(boot.InstallHostFDEDataDir, "ubuntu-save.key"), testutil.FileEquals, saveKey[:])
+}
+
+func (s *deviceMgrInstallModeSuite) TestInstallSecuredCAAMNotAvailable(c *C) {
+	err := s.doRunChangeTestWithEncryption(c, "secured", encTestCase{
+		optee: true, gadgetKeyProtector: "caam", encrypt: false,
+	})
+	c.Assert(err, ErrorMatches, "(?s).*cannot encrypt secured device: TPM not available.*")
+}
+
 func (s *deviceMgrInstallModeSuite) testInstallEncryptionSanityChecks(c *C, errMatch string) {
 	restore := release.MockOnClassic(false)
 	defer restore()
@@ -726,3 +876,187 @@ func (s *deviceMgrInstallModeSuite) TestInstallModeWritesModel(c *C) {
 
 	c.Check(filepath.Join(boot.InitramfsUbuntuBootDir, "device/model"), testutil.FileEquals, buf.String())
 }
+
+func (s *deviceMgrInstallModeSuite) TestFactoryResetTaskErrors(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	restore = devicestate.MockInstallRun(func(gadgetRoot, device string, options install.Options, _ gadget.ContentObserver) (*install.InstalledSystemSideData, error) {
+		c.Fatalf("unexpected install")
+		return nil, nil
+	})
+	defer restore()
+	restore = devicestate.MockInstallFactoryReset(func(gadgetRoot, device string, options install.Options, _ gadget.ContentObserver) (*install.InstalledSystemSideData, error) {
+		return nil, fmt.Errorf("The horror, The horror")
+	})
+	defer restore()
+
+	err := ioutil.WriteFile(filepath.Join(dirs.GlobalRootDir, "/var/lib/snapd/modeenv"),
+		[]byte("mode=factory-reset\nrecovery_system=20191218\n"), 0644)
+	c.Assert(err, IsNil)
+
+	func() {
+		s.state.Lock()
+		defer s.state.Unlock()
+		s.makeMockInstalledPcGadget(c, "dangerous", "")
+		devicestate.SetSystemMode(s.mgr, "factory-reset")
+	}()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(s.findInstallSystem(), IsNil)
+	factoryReset := s.findFactoryReset()
+	c.Assert(factoryReset, NotNil)
+	c.Check(factoryReset.Err(), ErrorMatches, `(?ms)cannot perform the following tasks:
+- Perform factory reset of the system \(cannot perform factory reset: The horror, The horror\)`)
+	// no restart request on failure
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+func (s *deviceMgrInstallModeSuite) TestFactoryResetRefusedFallsBackToRunMode(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	bl := bootloadertest.Mock("mock", c.MkDir())
+	bootloader.Force(bl)
+	defer bootloader.Force(nil)
+
+	restore = devicestate.MockInstallFactoryReset(func(gadgetRoot, device string, options install.Options, _ gadget.ContentObserver) (*install.InstalledSystemSideData, error) {
+		c.Fatalf("unexpected factory reset")
+		return nil, nil
+	})
+	defer restore()
+
+	err := ioutil.WriteFile(filepath.Join(dirs.GlobalRootDir, "/var/lib/snapd/modeenv"),
+		[]byte("mode=factory-reset\nrecovery_system=20191218\n"), 0644)
+	c.Assert(err, IsNil)
+
+	func() {
+		s.state.Lock()
+		defer s.state.Unlock()
+		s.makeMockInstalledPcGadget(c, "dangerous", "\nfactory-mode:\n  allow-test-snaps: true\n  serial-console: ttyS0\n")
+		devicestate.SetSystemMode(s.mgr, "factory-reset")
+	}()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	factoryReset := s.findFactoryReset()
+	c.Assert(factoryReset, NotNil)
+	c.Check(factoryReset.Err(), ErrorMatches, `(?ms)cannot perform the following tasks:
+- Perform factory reset of the system \(cannot perform factory reset of a system in factory mode\)`)
+	// the installed system is left untouched and booted again
+	m, err := bl.GetBootVars("snapd_recovery_mode", "snapd_recovery_system")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snapd_recovery_mode":   "run",
+		"snapd_recovery_system": "20191218",
+	})
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystemNow})
+}
+
+func (s *deviceMgrInstallModeSuite) TestFactoryResetEncryptedHappy(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	restore = devicestate.MockSecbootCheckKeySealingSupported(func() error { return nil })
+	defer restore()
+
+	tab := bootloadertest.Mock("trusted", c.MkDir()).WithTrustedAssets()
+	tab.TrustedAssetsList = []string{"trusted-asset"}
+	bootloader.Force(tab)
+	defer bootloader.Force(nil)
+	c.Assert(os.MkdirAll(boot.InitramfsUbuntuSeedDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(boot.InitramfsUbuntuSeedDir, "trusted-asset"), nil, 0644), IsNil)
+
+	// the current key of ubuntu-save, handed over by snap-bootstrap
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key"), []byte("old-save-key"), 0600), IsNil)
+
+	factoryResetCalls := 0
+	restore = devicestate.MockInstallFactoryReset(func(gadgetRoot, device string, options install.Options, obs gadget.ContentObserver) (*install.InstalledSystemSideData, error) {
+		// ensure we can grab the lock here, i.e. that it's not taken
+		s.state.Lock()
+		s.state.Unlock()
+
+		factoryResetCalls++
+		c.Check(gadgetRoot, Equals, filepath.Join(dirs.SnapMountDir, "/pc/1"))
+		c.Check(options, DeepEquals, install.Options{
+			Mount:   true,
+			Encrypt: true,
+			SaveKey: []byte("old-save-key"),
+		})
+		c.Check(obs, FitsTypeOf, &boot.TrustedAssetsInstallObserver{})
+		return &install.InstalledSystemSideData{
+			KeysForRoles: map[string]*install.EncryptionKeySet{
+				gadget.SystemData: {
+					Key:         dataEncryptionKey,
+					RecoveryKey: dataRecoveryKey,
+				},
+				gadget.SystemSave: {
+					Key:         saveKey,
+					RecoveryKey: reinstallKey,
+				},
+			},
+			DeviceForRole: map[string]string{
+				gadget.SystemSave: "/dev/vda4",
+			},
+		}, nil
+	})
+	defer restore()
+
+	var calls []string
+	restore = devicestate.MockBootMakeBootable(func(model *asserts.Model, rootdir string, bootWith *boot.BootableSet, seal *boot.TrustedAssetsInstallObserver) error {
+		calls = append(calls, "make-bootable")
+		c.Check(bootWith.FactoryReset, Equals, true)
+		c.Check(bootWith.RecoverySystemDir, Equals, "/systems/20191218")
+		c.Check(seal, NotNil)
+		return nil
+	})
+	defer restore()
+
+	restore = devicestate.MockSecbootRemoveEncryptionKey(func(node string, key []byte) error {
+		calls = append(calls, "remove-key")
+		c.Check(node, Equals, "/dev/vda4")
+		c.Check(key, DeepEquals, []byte("old-save-key"))
+		return nil
+	})
+	defer restore()
+
+	func() {
+		s.state.Lock()
+		defer s.state.Unlock()
+		s.makeMockInstalledPcGadget(c, "dangerous", "")
+	}()
+
+	modeenv := boot.Modeenv{
+		Mode:           "factory-reset",
+		RecoverySystem: "20191218",
+	}
+	c.Assert(modeenv.WriteTo(""), IsNil)
+	devicestate.SetSystemMode(s.mgr, "factory-reset")
+
+	// normally done by snap-bootstrap
+	c.Assert(os.MkdirAll(boot.InitramfsUbuntuBootDir, 0755), IsNil)
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	factoryReset := s.findFactoryReset()
+	c.Assert(factoryReset, NotNil)
+	c.Check(factoryReset.Err(), IsNil)
+	c.Check(factoryReset.Status(), Equals, state.DoneStatus)
+
+	c.Check(factoryResetCalls, Equals, 1)
+	// the previous key of ubuntu-save is removed only once the new keys
+	// are sealed
+	c.Check(calls, DeepEquals, []string{"make-bootable", "remove-key"})
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystemNow})
+}
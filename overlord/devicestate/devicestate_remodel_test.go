@@ -48,6 +48,7 @@ import (
 	"github.com/snapcore/snapd/snap/snapfile"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/store/storetest"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type deviceMgrRemodelSuite struct {
@@ -269,7 +270,7 @@ func (s *deviceMgrRemodelSuite) testRemodelTasksSwitchTrack(c *C, whatRefreshes
 
 	testDeviceCtx = &snapstatetest.TrivialDeviceContext{Remodeling: true}
 
-	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99")
+	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99", nil)
 	c.Assert(err, IsNil)
 	// 2 snaps, plus one track switch plus the remodel task, the
 	// wait chain is tested in TestRemodel*
@@ -344,7 +345,7 @@ func (s *deviceMgrRemodelSuite) testRemodelSwitchTasks(c *C, whatsNew, whatNewTr
 
 	testDeviceCtx = &snapstatetest.TrivialDeviceContext{Remodeling: true}
 
-	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99")
+	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99", nil)
 	c.Assert(err, IsNil)
 	// 1 of switch-kernel/base/gadget plus the remodel task
 	c.Assert(tss, HasLen, 2)
@@ -760,6 +761,118 @@ func (s *deviceMgrRemodelSuite) TestRemodelRereg(c *C) {
 	c.Assert(tPrepareRemodeling.WaitTasks(), DeepEquals, []*state.Task{tRequestSerial})
 }
 
+func (s *deviceMgrRemodelSuite) TestRemodelOfflineRereg(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+
+	localDir := c.MkDir()
+	localSnap := func(name string) *devicestate.LocalSnap {
+		path := filepath.Join(localDir, name+".snap")
+		c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+		return &devicestate.LocalSnap{SideInfo: &snap.SideInfo{RealName: name}, Path: path}
+	}
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Fatalf("unexpected install from the store of %q", name)
+		return nil, nil
+	})
+	defer restore()
+
+	restore = devicestate.MockSnapstateInstallPathWithDeviceContext(func(st *state.State, si *snap.SideInfo, path, channel string, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, true)
+		c.Check(flags.RemoveSnapPath, Equals, true)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+		c.Check(path, Equals, filepath.Join(localDir, si.RealName+".snap"))
+
+		tPrepare := s.state.NewTask("fake-prepare", fmt.Sprintf("Prepare %s from %s", si.RealName, path))
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", si.RealName))
+		tInstall.WaitFor(tPrepare)
+		ts := state.NewTaskSet(tPrepare, tInstall)
+		ts.MarkEdge(tPrepare, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	s.makeSerialAssertionInState(c, "canonical", "pc-model", "orig-serial")
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:           "canonical",
+		Model:           "pc-model",
+		Serial:          "orig-serial",
+		SessionMacaroon: "old-session",
+	})
+
+	new := s.brands.Model("canonical", "rereg-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"kernel":         "pc-kernel",
+		"gadget":         "pc",
+		"base":           "core18",
+		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
+	})
+
+	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
+		return nil
+	}
+
+	localSnaps := []*devicestate.LocalSnap{localSnap("new-required-snap-1")}
+	_, err := devicestate.RemodelOffline(s.state, new, localSnaps)
+	c.Assert(err, ErrorMatches, `cannot remodel offline: snap "new-required-snap-2" was not provided`)
+	// the snap files are removed on error
+	c.Check(filepath.Join(localDir, "new-required-snap-1.snap"), testutil.FileAbsent)
+
+	localSnaps = []*devicestate.LocalSnap{
+		localSnap("new-required-snap-1"),
+		localSnap("new-required-snap-2"),
+		localSnap("unneeded-snap"),
+	}
+	chg, err := devicestate.RemodelOffline(s.state, new, localSnaps)
+	c.Assert(err, IsNil)
+	c.Assert(chg.Summary(), Equals, "Remodel device to canonical/rereg-model (0)")
+
+	// the files of the snaps not needed by the remodel are removed
+	// right away, the others are left for the change
+	c.Check(filepath.Join(localDir, "unneeded-snap.snap"), testutil.FileAbsent)
+	var files []string
+	c.Assert(chg.Get("local-snap-files", &files), IsNil)
+	c.Check(files, DeepEquals, []string{
+		filepath.Join(localDir, "new-required-snap-1.snap"),
+		filepath.Join(localDir, "new-required-snap-2.snap"),
+	})
+	for _, f := range files {
+		c.Check(f, testutil.FilePresent)
+	}
+
+	// no serial is requested, the snaps come from the local files
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 2*2+1)
+	var kinds []string
+	for _, t := range tl {
+		kinds = append(kinds, t.Kind())
+	}
+	c.Check(kinds, DeepEquals, []string{"fake-prepare", "fake-install", "fake-prepare", "fake-install", "set-model"})
+	c.Check(tl[0].Summary(), Equals, "Prepare new-required-snap-1 from "+files[0])
+	c.Check(tl[2].Summary(), Equals, "Prepare new-required-snap-2 from "+files[1])
+	// everything is prepared before being installed
+	c.Check(tl[1].WaitTasks(), DeepEquals, []*state.Task{tl[0], tl[2]})
+
+	// the files left behind by a failed change are removed when it is
+	// ready
+	s.state.Unlock()
+	err = devicestate.CleanupRemodel(s.mgr, tl[4], nil)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+	for _, f := range files {
+		c.Check(f, testutil.FileAbsent)
+	}
+}
+
 func (s *deviceMgrRemodelSuite) TestRemodelClash(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1553,7 +1666,7 @@ func (s *deviceMgrSuite) TestRemodelSwitchBase(c *C) {
 
 	testDeviceCtx = &snapstatetest.TrivialDeviceContext{Remodeling: true}
 
-	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99")
+	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99", nil)
 	c.Assert(err, IsNil)
 	// 1 switch to a new base plus the remodel task
 	c.Assert(tss, HasLen, 2)
@@ -198,6 +198,18 @@ func (s *deviceMgrSerialSuite) TestFullDeviceRegistrationHappy(c *C) {
 
 	// check that keypair manager is under device
 	c.Check(osutil.IsDirectory(filepath.Join(dirs.SnapDeviceDir, "private-keys-v1")), Equals, true)
+
+	// the registration was recorded in the device initialization log
+	initLog, err := devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(initLog, HasLen, 2)
+	c.Check(initLog[0].Step, Equals, "registration")
+	c.Check(initLog[0].Status, Equals, "started")
+	c.Check(initLog[0].Detail, Equals, "change "+becomeOperational.ID())
+	c.Check(initLog[1].Step, Equals, "registration")
+	c.Check(initLog[1].Status, Equals, "done")
+	c.Check(initLog[1].Detail, Equals, "serial 9999")
+	c.Check(devicestate.Checkpoint(initLog), DeepEquals, &devicestate.InitCheckpoint{LastDone: "registration"})
 }
 
 func (s *deviceMgrSerialSuite) TestFullDeviceRegistrationHappyWithProxy(c *C) {
@@ -1343,6 +1355,14 @@ func (s *deviceMgrSerialSuite) TestFullDeviceRegistrationMismatchedSerial(c *C)
 
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
 	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*obtained serial assertion does not match provided device identity information.*`)
+
+	initLog, err := devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(initLog, HasLen, 2)
+	c.Check(initLog[1].Step, Equals, "registration")
+	c.Check(initLog[1].Status, Equals, "failed")
+	c.Check(initLog[1].Detail, Matches, `obtained serial assertion does not match provided device identity information.*`)
+	c.Check(devicestate.Checkpoint(initLog), DeepEquals, &devicestate.InitCheckpoint{})
 }
 
 func (s *deviceMgrSerialSuite) TestModelAndSerial(c *C) {
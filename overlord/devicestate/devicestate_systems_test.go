@@ -33,15 +33,20 @@ import (
 	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
+	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/seed"
 	"github.com/snapcore/snapd/seed/seedtest"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type mockedSystemSeed struct {
@@ -241,6 +246,7 @@ var defaultSystemActions []devicestate.SystemAction = []devicestate.SystemAction
 var currentSystemActions []devicestate.SystemAction = []devicestate.SystemAction{
 	{Title: "Reinstall", Mode: "install"},
 	{Title: "Recover", Mode: "recover"},
+	{Title: "Factory reset", Mode: "factory-reset"},
 	{Title: "Run normally", Mode: "run"},
 }
 
@@ -506,9 +512,34 @@ func (s *deviceMgrSystemsSuite) TestRequestModeRunInstallForRecover(c *C) {
 			BrandID: s.mockedSystemSeeds[0].brand.AccountID(),
 		},
 	})
+	s.mockInstalledGadget(c, uc20gadgetYaml)
 	s.state.Unlock()
 
-	s.testRequestModeWithRestart(c, []string{"install", "run"}, s.mockedSystemSeeds[0].label)
+	s.testRequestModeWithRestart(c, []string{"install", "factory-reset", "run"}, s.mockedSystemSeeds[0].label)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestModeRunForRepair(c *C) {
+	// we are in repair mode here
+	devicestate.SetSystemMode(s.mgr, "repair")
+	// non run modes use modeenv
+	modeenv := boot.Modeenv{
+		Mode:           "repair",
+		RecoverySystem: s.mockedSystemSeeds[0].label,
+	}
+	err := modeenv.WriteTo("")
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	s.state.Set("seeded-systems", []devicestate.SeededSystem{
+		{
+			System:  s.mockedSystemSeeds[0].label,
+			Model:   s.mockedSystemSeeds[0].model.Model(),
+			BrandID: s.mockedSystemSeeds[0].brand.AccountID(),
+		},
+	})
+	s.state.Unlock()
+
+	s.testRequestModeWithRestart(c, []string{"run"}, s.mockedSystemSeeds[0].label)
 }
 
 func (s *deviceMgrSystemsSuite) TestRequestModeInstallRecoverForCurrent(c *C) {
@@ -528,9 +559,48 @@ func (s *deviceMgrSystemsSuite) TestRequestModeInstallRecoverForCurrent(c *C) {
 			BrandID: s.mockedSystemSeeds[0].brand.AccountID(),
 		},
 	})
+	s.mockInstalledGadget(c, uc20gadgetYaml)
 	s.state.Unlock()
 
-	s.testRequestModeWithRestart(c, []string{"install", "recover"}, s.mockedSystemSeeds[0].label)
+	s.testRequestModeWithRestart(c, []string{"install", "recover", "factory-reset"}, s.mockedSystemSeeds[0].label)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestFactoryResetUnsupported(c *C) {
+	devicestate.SetSystemMode(s.mgr, "run")
+	modeenv := boot.Modeenv{
+		Mode: "run",
+	}
+	c.Assert(modeenv.WriteTo(""), IsNil)
+
+	restore := devicestate.MockSecbootCheckOPTEEKeySealingSupported(func() error { return nil })
+	defer restore()
+
+	for _, tc := range []struct {
+		gadgetYaml string
+		err        string
+	}{
+		{"\nfactory-mode:\n  allow-test-snaps: true\n  serial-console: ttyS0\n", "cannot perform factory reset of a system in factory mode"},
+		{"\nencryption:\n  key-protector: optee\n", `cannot perform factory reset of a system using "optee"`},
+	} {
+		s.state.Lock()
+		s.state.Set("seeded-systems", []devicestate.SeededSystem{
+			{
+				System:  s.mockedSystemSeeds[0].label,
+				Model:   s.mockedSystemSeeds[0].model.Model(),
+				BrandID: s.mockedSystemSeeds[0].brand.AccountID(),
+			},
+		})
+		s.mockInstalledGadget(c, uc20gadgetYaml+tc.gadgetYaml)
+		s.state.Unlock()
+
+		err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[0].label, devicestate.SystemAction{Mode: "factory-reset"})
+		c.Check(err, ErrorMatches, tc.err)
+		// the device does not reboot into factory-reset mode
+		m, err := s.bootloader.GetBootVars("snapd_recovery_mode")
+		c.Assert(err, IsNil)
+		c.Check(m["snapd_recovery_mode"], Equals, "")
+		c.Check(s.restartRequests, HasLen, 0)
+	}
 }
 
 func (s *deviceMgrSystemsSuite) TestRequestModeErrInBoot(c *C) {
@@ -661,6 +731,302 @@ func (s *deviceMgrSystemsSuite) TestRequestAction1618(c *C) {
 	c.Check(s.logbuf.String(), Equals, "")
 }
 
+const gadgetRecoveryActionsYaml = `
+recovery-actions:
+  - title: Run diagnostics
+    action: run-app
+    app: pc-diag.check
+  - title: Wipe data
+    action: wipe-data
+  - title: Previous recovery system
+    action: previous-system
+  - title: Decommission
+    action: crypto-erase
+`
+
+// mockInstalledGadget installs the pc gadget with the given gadget.yaml, the
+// state must be locked.
+func (s *deviceMgrSystemsSuite) mockInstalledGadget(c *C, gadgetYaml string) {
+	si := &snap.SideInfo{
+		RealName: "pc",
+		Revision: snap.R(1),
+		SnapID:   snaptest.AssertedSnapID("pc"),
+	}
+	snapstate.Set(s.state, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+		Active:   true,
+	})
+	snaptest.MockSnapWithFiles(c, "name: pc\ntype: gadget", si, [][]string{
+		{"meta/gadget.yaml", gadgetYaml},
+	})
+}
+
+func (s *deviceMgrSystemsSuite) mockGadgetRecoveryActions(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.mockInstalledGadget(c, uc20gadgetYaml+gadgetRecoveryActionsYaml)
+	s.state.Set("seeded-systems", []devicestate.SeededSystem{
+		{
+			System:  s.mockedSystemSeeds[1].label,
+			Model:   s.mockedSystemSeeds[1].model.Model(),
+			BrandID: s.mockedSystemSeeds[1].brand.AccountID(),
+		},
+	})
+}
+
+func (s *deviceMgrSystemsSuite) TestListSeedSystemsCurrentGadgetActions(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	systems, err := s.mgr.Systems()
+	c.Assert(err, IsNil)
+	c.Assert(systems, HasLen, 3)
+	c.Check(systems[0].Actions, DeepEquals, defaultSystemActions)
+	c.Check(systems[1].Current, Equals, true)
+	c.Check(systems[1].Actions, DeepEquals, append(append([]devicestate.SystemAction(nil), currentSystemActions...),
+		devicestate.SystemAction{Title: "Run diagnostics", Mode: "run", GadgetAction: "run-app"},
+		devicestate.SystemAction{Title: "Wipe data", Mode: "factory-reset", GadgetAction: "wipe-data"},
+		devicestate.SystemAction{Title: "Previous recovery system", Mode: "recover", GadgetAction: "previous-system"},
+		devicestate.SystemAction{Title: "Decommission", Mode: "install", GadgetAction: "crypto-erase"},
+	))
+	c.Check(systems[2].Actions, DeepEquals, defaultSystemActions)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionRunApp(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	var apps []string
+	restore := devicestate.MockRunRecoveryApp(func(snapApp string) error {
+		apps = append(apps, snapApp)
+		return nil
+	})
+	defer restore()
+
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Run diagnostics",
+		Mode:         "run",
+		GadgetAction: "run-app",
+	})
+	c.Assert(err, IsNil)
+	c.Check(apps, DeepEquals, []string{"pc-diag.check"})
+	c.Check(s.restartRequests, HasLen, 0)
+
+	restore = devicestate.MockRunRecoveryApp(func(snapApp string) error {
+		return fmt.Errorf("boom")
+	})
+	defer restore()
+	err = s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Run diagnostics",
+		Mode:         "run",
+		GadgetAction: "run-app",
+	})
+	c.Assert(err, ErrorMatches, `cannot run "pc-diag.check": boom`)
+}
+
+func (s *deviceMgrSystemsSuite) TestRunRecoveryAppUniqueUnit(c *C) {
+	systemdRun := testutil.MockCommand(c, "systemd-run", "")
+	defer systemdRun.Restore()
+
+	// running the app again while the unit of the earlier run is still
+	// around does not collide
+	c.Assert(devicestate.RunRecoveryApp("pc-diag.check"), IsNil)
+	c.Assert(devicestate.RunRecoveryApp("pc-diag.check"), IsNil)
+	calls := systemdRun.Calls()
+	c.Assert(calls, HasLen, 2)
+	wrapper := filepath.Join(dirs.SnapBinariesDir, "pc-diag.check")
+	for _, call := range calls {
+		c.Assert(call, HasLen, 5)
+		c.Check(call[:2], DeepEquals, []string{"systemd-run", "--collect"})
+		c.Check(call[2], Matches, `--unit=snap\.pc-diag\.check\.recovery-action-[0-9a-f-]+`)
+		c.Check(call[3:], DeepEquals, []string{"--", wrapper})
+	}
+	c.Check(calls[0][2], Not(Equals), calls[1][2])
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionWipeData(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Wipe data",
+		Mode:         "factory-reset",
+		GadgetAction: "wipe-data",
+	})
+	c.Assert(err, IsNil)
+	m, err := s.bootloader.GetBootVars("snapd_recovery_mode", "snapd_recovery_system")
+	c.Assert(err, IsNil)
+	// ubuntu-save is preserved by a factory reset
+	c.Check(m, DeepEquals, map[string]string{
+		"snapd_recovery_system": s.mockedSystemSeeds[1].label,
+		"snapd_recovery_mode":   "factory-reset",
+	})
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystemNow})
+	c.Check(s.logbuf.String(), Matches, `.*: restarting into system "20200318" for action "Wipe data"\n`)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionPreviousSystem(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	modeenv := boot.Modeenv{
+		Mode:                   "run",
+		CurrentRecoverySystems: []string{s.mockedSystemSeeds[0].label, s.mockedSystemSeeds[1].label},
+	}
+	c.Assert(modeenv.WriteTo(""), IsNil)
+
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Previous recovery system",
+		Mode:         "recover",
+		GadgetAction: "previous-system",
+	})
+	c.Assert(err, IsNil)
+	m, err := s.bootloader.GetBootVars("snapd_recovery_mode", "snapd_recovery_system")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snapd_recovery_system": s.mockedSystemSeeds[0].label,
+		"snapd_recovery_mode":   "recover",
+	})
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystemNow})
+	c.Check(s.logbuf.String(), Matches, `.*: restarting into system "20191119" for action "Previous recovery system"\n`)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionNoPreviousSystem(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	modeenv := boot.Modeenv{
+		Mode:                   "run",
+		CurrentRecoverySystems: []string{s.mockedSystemSeeds[1].label},
+	}
+	c.Assert(modeenv.WriteTo(""), IsNil)
+
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Previous recovery system",
+		Mode:         "recover",
+		GadgetAction: "previous-system",
+	})
+	c.Assert(err, ErrorMatches, `cannot find a recovery system preceding "20200318"`)
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+const mockEncryptedVolumesMountInfoFmt = `26 27 252:0 / %s rw,relatime shared:7 - ext4 /dev/mapper/ubuntu-data-3776bab4 rw
+27 27 252:1 / %s rw,relatime shared:8 - ext4 /dev/mapper/ubuntu-save-3776bab4 rw`
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionCryptoErase(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	restore := osutil.MockMountInfo(fmt.Sprintf(mockEncryptedVolumesMountInfoFmt, boot.InitramfsDataDir, boot.InitramfsUbuntuSaveDir))
+	defer restore()
+	restore = devicestate.MockDisksDmCryptMappingForDevice(func(node string) (*disks.DmCryptMapping, error) {
+		switch node {
+		case "/dev/mapper/ubuntu-data-3776bab4":
+			return &disks.DmCryptMapping{SourceKernelDeviceNode: "/dev/vda4", LUKSVersion: "LUKS2"}, nil
+		case "/dev/mapper/ubuntu-save-3776bab4":
+			return &disks.DmCryptMapping{SourceKernelDeviceNode: "/dev/vda5", LUKSVersion: "LUKS2"}, nil
+		}
+		return nil, fmt.Errorf("unexpected device %s", node)
+	})
+	defer restore()
+	var params []*secboot.CryptoEraseParams
+	restore = devicestate.MockSecbootCryptoEraseVolumes(func(p *secboot.CryptoEraseParams) error {
+		params = append(params, p)
+		return nil
+	})
+	defer restore()
+
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Decommission",
+		Mode:         "install",
+		GadgetAction: "crypto-erase",
+	})
+	c.Assert(err, IsNil)
+	c.Check(params, DeepEquals, []*secboot.CryptoEraseParams{{
+		Devices:  []string{"/dev/vda4", "/dev/vda5"},
+		KeyFiles: boot.SealedKeyFiles(),
+	}})
+	// the erased system cannot boot anymore, the device is reinstalled
+	m, err := s.bootloader.GetBootVars("snapd_recovery_mode", "snapd_recovery_system")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snapd_recovery_system": s.mockedSystemSeeds[1].label,
+		"snapd_recovery_mode":   "install",
+	})
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystemNow})
+	c.Check(s.logbuf.String(), Matches, `(?s).*: erasing the encrypted volumes for action "Decommission"\n.*: restarting into system "20200318" for action "Decommission"\n`)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionCryptoEraseErrors(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	restore := devicestate.MockSecbootCryptoEraseVolumes(func(p *secboot.CryptoEraseParams) error {
+		return fmt.Errorf("boom")
+	})
+	defer restore()
+	action := devicestate.SystemAction{
+		Title:        "Decommission",
+		Mode:         "install",
+		GadgetAction: "crypto-erase",
+	}
+
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, action)
+	c.Assert(err, ErrorMatches, "cannot erase the encrypted volumes: ubuntu-data is not mounted")
+
+	restore = osutil.MockMountInfo(fmt.Sprintf(mockEncryptedVolumesMountInfoFmt, boot.InitramfsDataDir, boot.InitramfsUbuntuSaveDir))
+	defer restore()
+	restore = devicestate.MockDisksDmCryptMappingForDevice(func(node string) (*disks.DmCryptMapping, error) {
+		return nil, disks.NotDmCryptMappingError{Device: node}
+	})
+	defer restore()
+	err = s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, action)
+	c.Assert(err, ErrorMatches, "cannot erase the encrypted volumes: ubuntu-data is not encrypted")
+
+	restore = devicestate.MockDisksDmCryptMappingForDevice(func(node string) (*disks.DmCryptMapping, error) {
+		return &disks.DmCryptMapping{SourceKernelDeviceNode: "/dev/vda4", LUKSVersion: "LUKS2"}, nil
+	})
+	defer restore()
+	err = s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, action)
+	c.Assert(err, ErrorMatches, "cannot erase the encrypted volumes: boom")
+
+	// the device keeps booting the installed system
+	m, err := s.bootloader.GetBootVars("snapd_recovery_mode")
+	c.Assert(err, IsNil)
+	c.Check(m["snapd_recovery_mode"], Equals, "")
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+func (s *deviceMgrSystemsSuite) TestRequestGadgetActionUnsupported(c *C) {
+	s.mockGadgetRecoveryActions(c)
+
+	restore := devicestate.MockRunRecoveryApp(func(snapApp string) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	defer restore()
+
+	// gadget actions are only offered by the current system
+	err := s.mgr.RequestSystemAction(s.mockedSystemSeeds[0].label, devicestate.SystemAction{
+		Title:        "Run diagnostics",
+		Mode:         "run",
+		GadgetAction: "run-app",
+	})
+	c.Assert(err, Equals, devicestate.ErrUnsupportedAction)
+
+	// unknown title
+	err = s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Run other diagnostics",
+		Mode:         "run",
+		GadgetAction: "run-app",
+	})
+	c.Assert(err, Equals, devicestate.ErrUnsupportedAction)
+
+	// title and kind of action do not match
+	err = s.mgr.RequestSystemAction(s.mockedSystemSeeds[1].label, devicestate.SystemAction{
+		Title:        "Run diagnostics",
+		Mode:         "install",
+		GadgetAction: "wipe-data",
+	})
+	c.Assert(err, Equals, devicestate.ErrUnsupportedAction)
+}
+
 func (s *deviceMgrSystemsSuite) TestRebootNoLabelNoModeHappy(c *C) {
 	err := s.mgr.Reboot("", "")
 	c.Assert(err, IsNil)
@@ -22,7 +22,9 @@ package devicestate_test
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -38,6 +40,7 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
@@ -53,6 +56,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
 	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/snapdenv"
@@ -1006,6 +1010,48 @@ func (s *deviceMgrSuite) TestCanManageRefreshes(c *C) {
 	c.Check(devicestate.CanManageRefreshes(st), Equals, true)
 }
 
+func (s *deviceMgrSuite) TestSystemPolicyAllows(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupBrands(c)
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: fakeMyModel(nil)}
+
+	// everything is allowed without a policy
+	for _, action := range []string{snapstate.SystemPolicyDangerous, snapstate.SystemPolicyDevMode} {
+		allowed, err := devicestate.SystemPolicyAllows(s.state, action, deviceCtx)
+		c.Assert(err, IsNil)
+		c.Check(allowed, Equals, true)
+	}
+
+	policy, err := s.brands.Signing("my-brand").Sign(asserts.SystemPolicyType, map[string]interface{}{
+		"brand-id":                    "my-brand",
+		"model":                       "my-model",
+		"disallow-dangerous-installs": "true",
+		"timestamp":                   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	assertstatetest.AddMany(s.state, policy)
+
+	allowed, err := devicestate.SystemPolicyAllows(s.state, snapstate.SystemPolicyDangerous, deviceCtx)
+	c.Assert(err, IsNil)
+	c.Check(allowed, Equals, false)
+	allowed, err = devicestate.SystemPolicyAllows(s.state, snapstate.SystemPolicyDevMode, deviceCtx)
+	c.Assert(err, IsNil)
+	c.Check(allowed, Equals, true)
+
+	// the policy of another model does not apply
+	otherCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: fakeMyModel(map[string]interface{}{
+		"model": "other-model",
+	})}
+	allowed, err = devicestate.SystemPolicyAllows(s.state, snapstate.SystemPolicyDangerous, otherCtx)
+	c.Assert(err, IsNil)
+	c.Check(allowed, Equals, true)
+
+	_, err = devicestate.SystemPolicyAllows(s.state, "foo", deviceCtx)
+	c.Check(err, ErrorMatches, `internal error: unknown system policy action "foo"`)
+}
+
 func (s *deviceMgrSuite) TestCanManageRefreshesNoRefreshScheduleManaged(c *C) {
 	st := s.state
 	st.Lock()
@@ -1260,6 +1306,360 @@ func (s *deviceMgrSuite) TestDeviceManagerStartupNonUC20NoUbuntuSave(c *C) {
 	c.Check(devicestate.SaveAvailable(mgr), Equals, false)
 }
 
+func (s *deviceMgrSuite) TestDeviceManagerStartupUC20CollectsUnlockMetrics(c *C) {
+	modeEnv := &boot.Modeenv{Mode: "run"}
+	err := modeEnv.WriteTo("")
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+
+	metrics := []*secboot.UnlockMetrics{
+		{Volume: "ubuntu-data", Method: "sealed-key", UnsealDuration: time.Second},
+	}
+	restore := devicestate.MockSecbootReadUnlockMetrics(func() ([]*secboot.UnlockMetrics, error) {
+		return metrics, nil
+	})
+	defer restore()
+	bootID := "boot-1"
+	restore = devicestate.MockOsutilBootID(func() (string, error) {
+		return bootID, nil
+	})
+	defer restore()
+
+	c.Assert(mgr.StartUp(), IsNil)
+	// the same boot is only collected once
+	c.Assert(mgr.StartUp(), IsNil)
+
+	for i := 2; i <= 12; i++ {
+		bootID = fmt.Sprintf("boot-%d", i)
+		c.Assert(mgr.StartUp(), IsNil)
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	history, err := devicestate.UnlockMetrics(s.state)
+	c.Assert(err, IsNil)
+	// only the most recent boots are kept
+	c.Assert(history, HasLen, 10)
+	c.Check(history[0].BootID, Equals, "boot-3")
+	c.Check(history[9].BootID, Equals, "boot-12")
+	c.Check(history[9].Volumes, DeepEquals, metrics)
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerStartupUC20UnlockMetricsErrorNotFatal(c *C) {
+	modeEnv := &boot.Modeenv{Mode: "run"}
+	err := modeEnv.WriteTo("")
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+
+	restore := devicestate.MockSecbootReadUnlockMetrics(func() ([]*secboot.UnlockMetrics, error) {
+		return nil, errors.New("boom")
+	})
+	defer restore()
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	c.Assert(mgr.StartUp(), IsNil)
+	c.Check(logbuf.String(), testutil.Contains, "cannot collect unlock metrics: boom")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	history, err := devicestate.UnlockMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Check(history, HasLen, 0)
+}
+
+func (s *deviceMgrSuite) mockBootReseal(c *C, releaseErr error) (holds, releases *int) {
+	holds, releases = new(int), new(int)
+	restore := devicestate.MockBootReseal(func() {
+		*holds++
+	}, func() error {
+		*releases++
+		return releaseErr
+	})
+	s.AddCleanup(restore)
+	return holds, releases
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureResealCoalescedDebounced(c *C) {
+	modeEnv := &boot.Modeenv{Mode: "run"}
+	err := modeEnv.WriteTo("")
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+	holds, releases := s.mockBootReseal(c, nil)
+
+	t0 := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := t0
+	restore := devicestate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	s.state.Lock()
+	chg := s.state.NewChange("refresh", "...")
+	kernelLink := s.state.NewTask("link-snap", "...")
+	gadgetUpdate := s.state.NewTask("update-gadget-assets", "...")
+	chg.AddTask(kernelLink)
+	chg.AddTask(gadgetUpdate)
+	s.state.Unlock()
+
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(mgr.ResealHeld(), Equals, true)
+	c.Check(*holds, Equals, 1)
+	c.Check(*releases, Equals, 0)
+
+	s.state.Lock()
+	kernelLink.SetStatus(state.DoneStatus)
+	s.state.Unlock()
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(*releases, Equals, 0)
+
+	s.state.Lock()
+	gadgetUpdate.SetStatus(state.DoneStatus)
+	s.state.Unlock()
+	// still held for a while, another change may follow
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(mgr.ResealHeld(), Equals, true)
+	c.Check(*releases, Equals, 0)
+
+	now = t0.Add(6 * time.Second)
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(mgr.ResealHeld(), Equals, false)
+	c.Check(*holds, Equals, 1)
+	c.Check(*releases, Equals, 1)
+
+	// nothing in progress
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(*holds, Equals, 1)
+	c.Check(*releases, Equals, 1)
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureResealCoalescedNotPastRestart(c *C) {
+	modeEnv := &boot.Modeenv{Mode: "run"}
+	err := modeEnv.WriteTo("")
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+	holds, releases := s.mockBootReseal(c, nil)
+
+	s.state.Lock()
+	chg := s.state.NewChange("refresh", "...")
+	chg.AddTask(s.state.NewTask("link-snap", "..."))
+	s.state.Unlock()
+
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(mgr.ResealHeld(), Equals, true)
+
+	s.state.Lock()
+	s.state.RequestRestart(state.RestartDaemon)
+	s.state.Unlock()
+
+	// the pending reseal is performed right away
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(mgr.ResealHeld(), Equals, false)
+	c.Check(*holds, Equals, 1)
+	c.Check(*releases, Equals, 1)
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureResealCoalescedError(c *C) {
+	modeEnv := &boot.Modeenv{Mode: "run"}
+	err := modeEnv.WriteTo("")
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+	_, releases := s.mockBootReseal(c, errors.New("boom"))
+	restore := devicestate.MockResealDebounceDelay(0)
+	defer restore()
+
+	s.state.Lock()
+	chg := s.state.NewChange("refresh", "...")
+	t := s.state.NewTask("link-snap", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	s.state.Lock()
+	t.SetStatus(state.DoneStatus)
+	s.state.Unlock()
+	err = devicestate.EnsureResealCoalesced(mgr)
+	c.Assert(err, ErrorMatches, "boom")
+	c.Check(*releases, Equals, 1)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	warns := s.state.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].String(), Equals, "cannot reseal the encryption keys: boom")
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerStopPerformsPendingReseal(c *C) {
+	modeEnv := &boot.Modeenv{Mode: "run"}
+	err := modeEnv.WriteTo("")
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+	holds, releases := s.mockBootReseal(c, nil)
+
+	// not held, nothing to do
+	mgr.Stop()
+	c.Check(*releases, Equals, 0)
+
+	s.state.Lock()
+	chg := s.state.NewChange("refresh", "...")
+	chg.AddTask(s.state.NewTask("update-gadget-assets", "..."))
+	s.state.Unlock()
+
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	mgr.Stop()
+	c.Check(mgr.ResealHeld(), Equals, false)
+	c.Check(*holds, Equals, 1)
+	c.Check(*releases, Equals, 1)
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureResealCoalescedNotRunMode(c *C) {
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+	holds, _ := s.mockBootReseal(c, nil)
+
+	s.state.Lock()
+	chg := s.state.NewChange("refresh", "...")
+	chg.AddTask(s.state.NewTask("link-snap", "..."))
+	s.state.Unlock()
+
+	c.Assert(devicestate.EnsureResealCoalesced(mgr), IsNil)
+	c.Check(mgr.ResealHeld(), Equals, false)
+	c.Check(*holds, Equals, 0)
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerStartupResumesInitLog(c *C) {
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+
+	t0 := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	restore := devicestate.MockTimeNow(func() time.Time { return t0 })
+	defer restore()
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	s.state.Lock()
+	chg := s.state.NewChange("become-operational", "...")
+	chg.AddTask(s.state.NewTask("request-serial", "..."))
+	devicestate.RecordInitStep(s.state, "seeding", "started", "change 1")
+	devicestate.RecordInitStep(s.state, "seeding", "done", "")
+	devicestate.RecordInitStep(s.state, "registration", "started", "change "+chg.ID())
+	s.state.Unlock()
+
+	// interrupted while registering, the change carries on
+	c.Assert(mgr.StartUp(), IsNil)
+	c.Check(logbuf.String(), testutil.Contains, `resuming interrupted device initialization step "registration"`)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	initLog, err := devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	c.Check(initLog, DeepEquals, []*devicestate.InitLogEntry{
+		{Step: "seeding", Status: "started", Time: t0, Detail: "change 1"},
+		{Step: "seeding", Status: "done", Time: t0},
+		{Step: "registration", Status: "started", Time: t0, Detail: "change " + chg.ID()},
+		{Step: "registration", Status: "resumed", Time: t0, Detail: "change " + chg.ID()},
+	})
+	c.Check(devicestate.Checkpoint(initLog), DeepEquals, &devicestate.InitCheckpoint{
+		LastDone: "seeding",
+		Pending:  []string{"registration"},
+	})
+
+	// once done nothing is pending anymore
+	devicestate.RecordInitStep(s.state, "registration", "done", "serial 1234")
+	s.state.Unlock()
+	c.Assert(mgr.StartUp(), IsNil)
+	s.state.Lock()
+	initLog, err = devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	c.Check(initLog, HasLen, 5)
+	c.Check(devicestate.Checkpoint(initLog), DeepEquals, &devicestate.InitCheckpoint{LastDone: "registration"})
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerStartupReplaysInitLog(c *C) {
+	mgr, err := devicestate.Manager(s.state, s.hookMgr, s.o.TaskRunner(), s.newStore)
+	c.Assert(err, IsNil)
+
+	t0 := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	restore := devicestate.MockTimeNow(func() time.Time { return t0 })
+	defer restore()
+
+	// handed over from install mode
+	logFile := filepath.Join(dirs.SnapDeviceDir, "init-log.json")
+	c.Assert(os.MkdirAll(filepath.Dir(logFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(logFile, []byte(`[
+{"step":"sealing","status":"started","time":"2021-01-01T09:00:00Z","detail":"tpm"},
+{"step":"sealing","status":"done","time":"2021-01-01T09:01:00Z","detail":"tpm"}
+]`), 0644), IsNil)
+
+	s.state.Lock()
+	seeding := s.state.NewChange("seed", "...")
+	seeding.AddTask(s.state.NewTask("mark-seeded", "..."))
+	seeding.SetStatus(state.DoneStatus)
+	registration := s.state.NewChange("become-operational", "...")
+	t := s.state.NewTask("request-serial", "...")
+	registration.AddTask(t)
+	t.SetStatus(state.ErrorStatus)
+	devicestate.RecordInitStep(s.state, "seeding", "started", "change "+seeding.ID())
+	devicestate.RecordInitStep(s.state, "connections", "started", "")
+	devicestate.RecordInitStep(s.state, "registration", "started", "change "+registration.ID())
+	s.state.Unlock()
+
+	c.Assert(mgr.StartUp(), IsNil)
+	c.Check(logFile, testutil.FileAbsent)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	initLog, err := devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	c.Check(initLog, DeepEquals, []*devicestate.InitLogEntry{
+		{Step: "sealing", Status: "started", Time: time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC), Detail: "tpm"},
+		{Step: "sealing", Status: "done", Time: time.Date(2021, 1, 1, 9, 1, 0, 0, time.UTC), Detail: "tpm"},
+		{Step: "seeding", Status: "started", Time: t0, Detail: "change " + seeding.ID()},
+		{Step: "connections", Status: "started", Time: t0},
+		{Step: "registration", Status: "started", Time: t0, Detail: "change " + registration.ID()},
+		// reconciled with the changes carrying out the steps
+		{Step: "seeding", Status: "done", Time: t0, Detail: "change " + seeding.ID()},
+		{Step: "connections", Status: "failed", Time: t0, Detail: "interrupted"},
+		{Step: "registration", Status: "failed", Time: t0, Detail: "change " + registration.ID() + ": Error"},
+	})
+	c.Check(devicestate.Checkpoint(initLog), DeepEquals, &devicestate.InitCheckpoint{LastDone: "seeding"})
+}
+
+func (s *deviceMgrSuite) TestRecordInitStepRepeatedAndTrimmed(c *C) {
+	t0 := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := t0
+	restore := devicestate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	devicestate.RecordInitStep(s.state, "seeding", "failed", "boom")
+	now = t0.Add(time.Minute)
+	// the same failure again only refreshes the time
+	devicestate.RecordInitStep(s.state, "seeding", "failed", "boom")
+	initLog, err := devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	c.Check(initLog, DeepEquals, []*devicestate.InitLogEntry{
+		{Step: "seeding", Status: "failed", Time: now, Detail: "boom"},
+	})
+
+	for i := 0; i < 120; i++ {
+		devicestate.RecordInitStep(s.state, "registration", "started", fmt.Sprintf("change %d", i))
+	}
+	initLog, err = devicestate.InitLog(s.state)
+	c.Assert(err, IsNil)
+	// only the most recent entries are kept
+	c.Assert(initLog, HasLen, 100)
+	c.Check(initLog[0].Detail, Equals, "change 20")
+	c.Check(initLog[99].Detail, Equals, "change 119")
+}
+
 type startOfOperationTimeSuite struct {
 	state  *state.State
 	mgr    *devicestate.DeviceManager
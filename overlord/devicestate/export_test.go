@@ -29,9 +29,12 @@ import (
 	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/gadget/install"
 	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/sysconfig"
 	"github.com/snapcore/snapd/timings"
 )
@@ -144,6 +147,14 @@ func MockSnapstateUpdateWithDeviceContext(f func(st *state.State, name string, o
 	}
 }
 
+func MockSnapstateInstallPathWithDeviceContext(f func(st *state.State, si *snap.SideInfo, path, channel string, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error)) (restore func()) {
+	old := snapstateInstallPathWithDeviceContext
+	snapstateInstallPathWithDeviceContext = f
+	return func() {
+		snapstateInstallPathWithDeviceContext = old
+	}
+}
+
 func EnsureSeeded(m *DeviceManager) error {
 	return m.ensureSeeded()
 }
@@ -199,6 +210,7 @@ var (
 	CheckGadgetRemodelCompatible = checkGadgetRemodelCompatible
 	CanAutoRefresh               = canAutoRefresh
 	NewEnoughProxy               = newEnoughProxy
+	SystemPolicyAllows           = systemPolicyAllows
 
 	IncEnsureOperationalAttempts = incEnsureOperationalAttempts
 	EnsureOperationalAttempts    = ensureOperationalAttempts
@@ -207,6 +219,7 @@ var (
 
 	RemodelCtx        = remodelCtx
 	CleanupRemodelCtx = cleanupRemodelCtx
+	CleanupRemodel    = (*DeviceManager).cleanupRemodel
 	CachedRemodelCtx  = cachedRemodelCtx
 
 	GadgetUpdateBlocked = gadgetUpdateBlocked
@@ -214,6 +227,8 @@ var (
 	PendingGadgetInfo   = pendingGadgetInfo
 
 	CriticalTaskEdges = criticalTaskEdges
+
+	SaveKeys = saveKeys
 )
 
 func MockGadgetUpdate(mock func(current, update gadget.GadgetData, path string, policy gadget.UpdatePolicyFunc, observer gadget.ContentUpdateObserver) error) (restore func()) {
@@ -240,6 +255,22 @@ func MockBootMakeBootable(f func(model *asserts.Model, rootdir string, bootWith
 	}
 }
 
+func MockBootUpdateManagedBootConfigs(f func(dev boot.Device) (bool, error)) (restore func()) {
+	old := bootUpdateManagedBootConfigs
+	bootUpdateManagedBootConfigs = f
+	return func() {
+		bootUpdateManagedBootConfigs = old
+	}
+}
+
+func MockSecbootRemoveEncryptionKey(f func(node string, key []byte) error) (restore func()) {
+	old := secbootRemoveEncryptionKey
+	secbootRemoveEncryptionKey = f
+	return func() {
+		secbootRemoveEncryptionKey = old
+	}
+}
+
 func MockSecbootCheckKeySealingSupported(f func() error) (restore func()) {
 	old := secbootCheckKeySealingSupported
 	secbootCheckKeySealingSupported = f
@@ -248,6 +279,22 @@ func MockSecbootCheckKeySealingSupported(f func() error) (restore func()) {
 	}
 }
 
+func MockSecbootCheckOPTEEKeySealingSupported(f func() error) (restore func()) {
+	old := secbootCheckOPTEEKeySealingSupported
+	secbootCheckOPTEEKeySealingSupported = f
+	return func() {
+		secbootCheckOPTEEKeySealingSupported = old
+	}
+}
+
+func MockSecbootCheckCAAMKeySealingSupported(f func() error) (restore func()) {
+	old := secbootCheckCAAMKeySealingSupported
+	secbootCheckCAAMKeySealingSupported = f
+	return func() {
+		secbootCheckCAAMKeySealingSupported = old
+	}
+}
+
 func MockHttputilNewHTTPClient(f func(opts *httputil.ClientOptions) *http.Client) (restore func()) {
 	old := httputilNewHTTPClient
 	httputilNewHTTPClient = f
@@ -272,6 +319,14 @@ func MockInstallRun(f func(gadgetRoot, device string, options install.Options, o
 	}
 }
 
+func MockInstallFactoryReset(f func(gadgetRoot, device string, options install.Options, observer gadget.ContentObserver) (*install.InstalledSystemSideData, error)) (restore func()) {
+	old := installFactoryReset
+	installFactoryReset = f
+	return func() {
+		installFactoryReset = old
+	}
+}
+
 func MockCloudInitStatus(f func() (sysconfig.CloudInitState, error)) (restore func()) {
 	old := cloudInitStatus
 	cloudInitStatus = f
@@ -287,3 +342,80 @@ func MockRestrictCloudInit(f func(sysconfig.CloudInitState, *sysconfig.CloudInit
 		restrictCloudInit = old
 	}
 }
+
+func MockBootSealFactoryKeys(f func(model *asserts.Model, tpmOpts boot.TPMOptions) error) (restore func()) {
+	old := bootSealFactoryKeys
+	bootSealFactoryKeys = f
+	return func() {
+		bootSealFactoryKeys = old
+	}
+}
+
+func MockSecbootReadUnlockMetrics(f func() ([]*secboot.UnlockMetrics, error)) (restore func()) {
+	old := secbootReadUnlockMetrics
+	secbootReadUnlockMetrics = f
+	return func() {
+		secbootReadUnlockMetrics = old
+	}
+}
+
+func MockOsutilBootID(f func() (string, error)) (restore func()) {
+	old := osutilBootID
+	osutilBootID = f
+	return func() {
+		osutilBootID = old
+	}
+}
+
+var RunRecoveryApp = runRecoveryAppImpl
+
+func MockRunRecoveryApp(f func(snapApp string) error) (restore func()) {
+	old := runRecoveryApp
+	runRecoveryApp = f
+	return func() {
+		runRecoveryApp = old
+	}
+}
+
+func MockSecbootCryptoEraseVolumes(f func(params *secboot.CryptoEraseParams) error) (restore func()) {
+	old := secbootCryptoEraseVolumes
+	secbootCryptoEraseVolumes = f
+	return func() {
+		secbootCryptoEraseVolumes = old
+	}
+}
+
+func MockDisksDmCryptMappingForDevice(f func(node string) (*disks.DmCryptMapping, error)) (restore func()) {
+	old := disksDmCryptMappingForDevice
+	disksDmCryptMappingForDevice = f
+	return func() {
+		disksDmCryptMappingForDevice = old
+	}
+}
+
+var RecordInitStep = recordInitStep
+
+func MockBootReseal(hold func(), release func() error) (restore func()) {
+	oldHold := bootHoldReseal
+	oldRelease := bootReleaseReseal
+	bootHoldReseal = hold
+	bootReleaseReseal = release
+	return func() {
+		bootHoldReseal = oldHold
+		bootReleaseReseal = oldRelease
+	}
+}
+
+func MockResealDebounceDelay(d time.Duration) (restore func()) {
+	old := resealDebounceDelay
+	resealDebounceDelay = d
+	return func() {
+		resealDebounceDelay = old
+	}
+}
+
+func (m *DeviceManager) ResealHeld() bool {
+	return m.resealHeld
+}
+
+var EnsureResealCoalesced = (*DeviceManager).ensureResealCoalesced
@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sysconfig"
+)
+
+var bootSealFactoryKeys = boot.SealFactoryKeys
+
+// factoryMode is the state of factory mode of an installed device, as
+// requested by the gadget at install time.
+type factoryMode struct {
+	AllowTestSnaps bool   `json:"allow-test-snaps,omitempty"`
+	SerialConsole  string `json:"serial-console,omitempty"`
+}
+
+func factoryModeFileUnder(rootdir string) string {
+	return filepath.Join(dirs.SnapDeviceDirUnder(rootdir), "factory-mode")
+}
+
+func serialGettyUnit(tty string) string {
+	return fmt.Sprintf("serial-getty@%s.service", tty)
+}
+
+// setupFactoryMode writes the factory mode state and artifacts to the run
+// system being installed under rootdir.
+func setupFactoryMode(rootdir string, fm *gadget.FactoryMode) error {
+	if fm.SerialConsole != "" {
+		wantsDir := sysconfig.WritableDefaultsDir(rootdir, "/etc/systemd/system/getty.target.wants")
+		if err := os.MkdirAll(wantsDir, 0755); err != nil {
+			return fmt.Errorf("cannot enable serial console: %v", err)
+		}
+		unit := filepath.Join(wantsDir, serialGettyUnit(fm.SerialConsole))
+		if err := os.Symlink("/lib/systemd/system/serial-getty@.service", unit); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("cannot enable serial console: %v", err)
+		}
+	}
+
+	b, err := json.Marshal(&factoryMode{
+		AllowTestSnaps: fm.AllowTestSnaps,
+		SerialConsole:  fm.SerialConsole,
+	})
+	if err != nil {
+		return err
+	}
+	modeFile := factoryModeFileUnder(rootdir)
+	if err := os.MkdirAll(filepath.Dir(modeFile), 0755); err != nil {
+		return fmt.Errorf("cannot store factory mode: %v", err)
+	}
+	if err := osutil.AtomicWriteFile(modeFile, b, 0644, 0); err != nil {
+		return fmt.Errorf("cannot store factory mode: %v", err)
+	}
+	return nil
+}
+
+// readFactoryMode returns the factory mode state of the device, or nil if
+// the device is not in factory mode.
+func readFactoryMode() (*factoryMode, error) {
+	b, err := ioutil.ReadFile(factoryModeFileUnder(dirs.GlobalRootDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fm factoryMode
+	if err := json.Unmarshal(b, &fm); err != nil {
+		return nil, fmt.Errorf("cannot read factory mode: %v", err)
+	}
+	return &fm, nil
+}
+
+// CheckUnassertedSnapAllowed returns an error if snaps without assertions
+// cannot be installed on the device. This is only the case for devices with
+// a model of grade secured that were installed in factory mode: while in
+// factory mode, unless it allows test snaps, and for good once sealed.
+func CheckUnassertedSnapAllowed(st *state.State) error {
+	model, err := findModel(st)
+	if err == state.ErrNoState {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if model.Grade() != asserts.ModelSecured {
+		return nil
+	}
+	fm, err := readFactoryMode()
+	if err != nil {
+		return err
+	}
+	if fm != nil {
+		if fm.AllowTestSnaps {
+			return nil
+		}
+	} else {
+		var sealed bool
+		err := st.Get("factory-sealed", &sealed)
+		if err != nil && err != state.ErrNoState {
+			return err
+		}
+		if !sealed {
+			// never in factory mode
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot install snaps without assertions on a device with a secured model")
+}
+
+// FactorySeal returns a change which takes the device out of factory mode.
+// The encryption keys are sealed to the TPM, provisioning it, the factory
+// mode artifacts are removed and the relaxed policies reverted. This cannot
+// be undone, and requires a restart of the system to complete.
+func FactorySeal(st *state.State) (*state.Change, error) {
+	fm, err := readFactoryMode()
+	if err != nil {
+		return nil, err
+	}
+	if fm == nil {
+		return nil, fmt.Errorf("cannot seal the device: not in factory mode")
+	}
+	for _, chg := range st.Changes() {
+		if chg.Kind() == "factory-seal" && !chg.IsReady() {
+			return nil, &snapstate.ChangeConflictError{Message: "cannot seal the device: sealing already in progress", ChangeKind: "factory-seal"}
+		}
+	}
+
+	chg := st.NewChange("factory-seal", i18n.G("Seal the device and leave factory mode"))
+	t := st.NewTask("factory-seal", i18n.G("Seal encryption keys and remove factory mode artifacts"))
+	chg.AddTask(t)
+	return chg, nil
+}
+
+func (m *DeviceManager) doFactorySeal(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	fm, err := readFactoryMode()
+	if err != nil {
+		return err
+	}
+	if fm == nil {
+		// sealed already
+		return nil
+	}
+
+	deviceCtx, err := DeviceCtx(st, t, nil)
+	if err != nil {
+		return fmt.Errorf("cannot get device context: %v", err)
+	}
+	// the TPM is set up as selected by the gadget, like at install
+	gadgetInfo, err := snapstate.GadgetInfo(st, deviceCtx)
+	if err != nil {
+		return fmt.Errorf("cannot get gadget info: %v", err)
+	}
+	ginfo, err := gadget.ReadInfo(gadgetInfo.MountDir(), deviceCtx.Model())
+	if err != nil {
+		return fmt.Errorf("cannot read gadget metadata: %v", err)
+	}
+	tpmOpts := tpmOptions(ginfo)
+
+	// do not release the state lock, the keys are sealed to the boot
+	// chains of the modeenv and must not race with a reseal, both are
+	// implicitly guarded by the state lock
+	err = bootSealFactoryKeys(deviceCtx.Model(), tpmOpts)
+	if err == boot.ErrNoFactoryKeys {
+		logger.Noticef("no encryption keys to seal")
+	} else if err != nil {
+		return fmt.Errorf("cannot seal the device: %v", err)
+	}
+
+	if fm.SerialConsole != "" {
+		unit := filepath.Join(dirs.SnapServicesDir, "getty.target.wants", serialGettyUnit(fm.SerialConsole))
+		if err := os.Remove(unit); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot disable serial console: %v", err)
+		}
+	}
+	// this is the point of no return, from now on the device is no
+	// longer in factory mode
+	if err := os.Remove(factoryModeFileUnder(dirs.GlobalRootDir)); err != nil {
+		return fmt.Errorf("cannot leave factory mode: %v", err)
+	}
+	st.Set("factory-sealed", true)
+
+	// the serial console and any other relaxation go away with a restart
+	logger.Noticef("device sealed, request system restart")
+	st.RequestRestart(state.RestartSystem)
+	return nil
+}
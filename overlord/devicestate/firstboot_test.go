@@ -1323,6 +1323,16 @@ snaps:
 	c.Assert(err, IsNil)
 	c.Assert(chg.Status(), Equals, state.DoneStatus)
 
+	// the end of seeding was recorded in the initialization log
+	initLog, err := devicestate.InitLog(st)
+	c.Assert(err, IsNil)
+	c.Assert(initLog, HasLen, 2)
+	c.Check(initLog[0].Step, Equals, "connections")
+	c.Check(initLog[0].Status, Equals, "done")
+	c.Check(initLog[0].Detail, Equals, "4 connect tasks")
+	c.Check(initLog[1].Step, Equals, "seeding")
+	c.Check(initLog[1].Status, Equals, "done")
+
 	// verify
 	r, err := os.Open(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
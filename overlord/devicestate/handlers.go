@@ -179,6 +179,10 @@ func (m *DeviceManager) doMarkSeeded(t *state.Task, _ *tomb.Tomb) error {
 	}
 	st.Set("seed-time", now)
 	st.Set("seeded", true)
+	// the connections of the seeded snaps were established by the
+	// tasks this one waited for
+	recordInitStep(st, InitStepConnections, InitStatusDone, fmt.Sprintf("%d connect tasks", countConnectTasks(t.Change())))
+	recordInitStep(st, InitStepSeeding, InitStatusDone, "")
 	// avoid possibly recording the same system multiple times etc.
 	t.SetStatus(state.DoneStatus)
 	// make sure we setup a fallback model/consider the next phase
@@ -186,3 +190,19 @@ func (m *DeviceManager) doMarkSeeded(t *state.Task, _ *tomb.Tomb) error {
 	st.EnsureBefore(0)
 	return nil
 }
+
+// countConnectTasks returns the number of tasks establishing interface
+// connections in the given change.
+func countConnectTasks(chg *state.Change) int {
+	if chg == nil {
+		return 0
+	}
+	n := 0
+	for _, t := range chg.Tasks() {
+		switch t.Kind() {
+		case "auto-connect", "connect":
+			n++
+		}
+	}
+	return n
+}
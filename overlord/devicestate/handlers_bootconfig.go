@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"fmt"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+)
+
+var bootUpdateManagedBootConfigs = boot.UpdateManagedBootConfigs
+
+func (m *DeviceManager) doUpdateManagedBootConfig(t *state.Task, _ *tomb.Tomb) error {
+	if release.OnClassic {
+		return fmt.Errorf("cannot run update boot config task on a classic system")
+	}
+
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	if ok, _ := st.Restarting(); ok {
+		// the built-in boot config assets are the ones of the snapd
+		// being restarted into
+		t.Logf("Waiting for automatic snapd restart...")
+		return &state.Retry{}
+	}
+
+	devCtx, err := DeviceCtx(st, t, nil)
+	if err != nil {
+		return err
+	}
+	if devCtx.Model().Grade() == asserts.ModelGradeUnset {
+		// pre UC20 devices do not use managed boot config
+		return nil
+	}
+	if !devCtx.RunMode() {
+		// boot config is updated only in run mode
+		return nil
+	}
+
+	// do not release the state lock, the keys may be resealed which
+	// modifies the modeenv, implicitly guarded by the state lock
+	updated, err := bootUpdateManagedBootConfigs(devCtx)
+	if err != nil {
+		return fmt.Errorf("cannot update boot config assets: %v", err)
+	}
+	if updated {
+		t.Logf("Updated boot config assets")
+		// the kernel command line changed, reboot so that the keys
+		// can be resealed to the new command line only
+		st.RequestRestart(state.RestartSystem)
+	}
+	// minimize wasteful redos
+	t.SetStatus(state.DoneStatus)
+	return nil
+}
@@ -21,8 +21,10 @@ package devicestate
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"gopkg.in/tomb.v2"
 
@@ -40,10 +42,13 @@ import (
 )
 
 var (
-	bootMakeBootable = boot.MakeBootable
-	installRun       = install.Run
+	bootMakeBootable    = boot.MakeBootable
+	installRun          = install.Run
+	installFactoryReset = install.FactoryReset
 
 	sysconfigConfigureTargetSystem = sysconfig.ConfigureTargetSystem
+
+	secbootRemoveEncryptionKey = secboot.RemoveEncryptionKey
 )
 
 func setSysconfigCloudOptions(opts *sysconfig.Options, gadgetDir string, model *asserts.Model) {
@@ -90,10 +95,32 @@ func writeModel(model *asserts.Model, where string) error {
 }
 
 func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
+	return m.setupRunSystem(t, false)
+}
+
+func (m *DeviceManager) doFactoryResetRunSystem(t *state.Task, _ *tomb.Tomb) error {
+	return m.setupRunSystem(t, true)
+}
+
+// setupRunSystem installs the run system, or resets it to its factory state
+// while preserving ubuntu-save when factoryReset is set.
+func (m *DeviceManager) setupRunSystem(t *state.Task, factoryReset bool) (err error) {
 	st := t.State()
 	st.Lock()
 	defer st.Unlock()
 
+	// a factory reset which fails before the partitions are touched
+	// leaves the installed system intact, boot back into it instead of
+	// trying the reset again at every boot
+	partitionsTouched := false
+	if factoryReset {
+		defer func() {
+			if err != nil && !partitionsTouched {
+				m.fallBackToRunMode(st, err)
+			}
+		}()
+	}
+
 	perfTimings := state.TimingsForTask(t)
 	defer perfTimings.Save(st)
 
@@ -125,22 +152,48 @@ func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
 	bopts := install.Options{
 		Mount: true,
 	}
-	useEncryption, err := checkEncryption(deviceCtx.Model())
+	// the gadget is checked against the model when laying out the
+	// volumes, here only the encryption settings are needed
+	ginfo, err := gadget.ReadInfo(gadgetDir, nil)
+	if err != nil {
+		return fmt.Errorf("cannot read gadget metadata: %v", err)
+	}
+	useEncryption, keyProtector, err := checkEncryption(deviceCtx.Model(), ginfo)
 	if err != nil {
 		return err
 	}
 	bopts.Encrypt = useEncryption
+	if useEncryption && ginfo.Encryption != nil {
+		bopts.LUKSParameters = ginfo.Encryption.LUKS
+	}
+	// the boot chains are tracked with all the key protectors
+	sealToBootChains := useEncryption
+
+	if factoryReset {
+		// also checked when the reset is requested
+		if err := checkFactoryResetSupported(ginfo, useEncryption, keyProtector); err != nil {
+			return err
+		}
+		if useEncryption {
+			// the key of ubuntu-save was unsealed by snap-bootstrap
+			saveKey, err := ioutil.ReadFile(filepath.Join(dirs.SnapFDEDir, "ubuntu-save.key"))
+			if err != nil {
+				return fmt.Errorf("cannot read the ubuntu-save key: %v", err)
+			}
+			bopts.SaveKey = saveKey
+		}
+	}
 
 	var trustedInstallObserver *boot.TrustedAssetsInstallObserver
 	// get a nice nil interface by default
 	var installObserver gadget.ContentObserver
-	trustedInstallObserver, err = boot.TrustedAssetsInstallObserverForModel(deviceCtx.Model(), gadgetDir, useEncryption)
+	trustedInstallObserver, err = boot.TrustedAssetsInstallObserverForModel(deviceCtx.Model(), gadgetDir, sealToBootChains)
 	if err != nil && err != boot.ErrObserverNotApplicable {
 		return fmt.Errorf("cannot setup asset install observer: %v", err)
 	}
 	if err == nil {
 		installObserver = trustedInstallObserver
-		if !useEncryption {
+		if !sealToBootChains {
 			// there will be no key sealing, so past the
 			// installation pass no other methods need to be called
 			trustedInstallObserver = nil
@@ -150,12 +203,20 @@ func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
 	var installedSystem *install.InstalledSystemSideData
 	// run the create partition code
 	logger.Noticef("create and deploy partitions")
+	partitionsTouched = true
 	func() {
 		st.Unlock()
 		defer st.Lock()
-		installedSystem, err = installRun(gadgetDir, "", bopts, installObserver)
+		if factoryReset {
+			installedSystem, err = installFactoryReset(gadgetDir, "", bopts, installObserver)
+		} else {
+			installedSystem, err = installRun(gadgetDir, "", bopts, installObserver)
+		}
 	}()
 	if err != nil {
+		if factoryReset {
+			return fmt.Errorf("cannot perform factory reset: %v", err)
+		}
 		return fmt.Errorf("cannot install system: %v", err)
 	}
 
@@ -167,16 +228,39 @@ func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
 		dataKeySet := installedSystem.KeysForRoles[gadget.SystemData]
 		saveKeySet := installedSystem.KeysForRoles[gadget.SystemSave]
 
-		// make note of the encryption keys
-		trustedInstallObserver.ChosenEncryptionKeys(dataKeySet.Key, saveKeySet.Key)
+		// make note of the encryption keys, in factory mode they are
+		// sealed to the TPM only when the device is sealed
+		if ginfo.FactoryMode != nil {
+			if len(installedSystem.KeysForExtraVolumes) != 0 {
+				return fmt.Errorf("cannot encrypt extra gadget structures in factory mode")
+			}
+			trustedInstallObserver.ChosenFactoryEncryptionKeys(dataKeySet.Key, saveKeySet.Key)
+		} else {
+			trustedInstallObserver.ChosenEncryptionKeys(dataKeySet.Key, saveKeySet.Key)
+			if keyProtector != "" {
+				logger.Noticef("seal encryption keys with %q", keyProtector)
+				trustedInstallObserver.ChosenKeyProtector(keyProtector)
+			}
+			trustedInstallObserver.ChosenTPMOptions(tpmOptions(ginfo))
+			extraKeys, err := extraVolumeKeys(ginfo, installedSystem.KeysForExtraVolumes)
+			if err != nil {
+				return err
+			}
+			trustedInstallObserver.ChosenExtraEncryptionKeys(extraKeys)
+		}
 
 		// keep track of recovery assets
 		if err := trustedInstallObserver.ObserveExistingTrustedRecoveryAssets(boot.InitramfsUbuntuSeedDir); err != nil {
 			return fmt.Errorf("cannot observe existing trusted recovery assets: err")
 		}
-		if err := saveKeys(installedSystem.KeysForRoles); err != nil {
+		if err := saveKeys(installedSystem.KeysForRoles, installedSystem.KeysForExtraVolumes); err != nil {
 			return err
 		}
+		if bopts.LUKSParameters != nil && bopts.LUKSParameters.DataIntegrity != "" {
+			// the requirement is recorded by boot along with the
+			// sealed keys
+			trustedInstallObserver.ChosenDataIntegrity()
+		}
 	}
 
 	// keep track of the model we installed
@@ -189,6 +273,13 @@ func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
 		return fmt.Errorf("cannot store the model: %v", err)
 	}
 
+	if ginfo.FactoryMode != nil {
+		logger.Noticef("enable factory mode")
+		if err := setupFactoryMode(boot.InstallHostWritableDir, ginfo.FactoryMode); err != nil {
+			return err
+		}
+	}
+
 	// configure the run system
 	opts := &sysconfig.Options{TargetRootDir: boot.InstallHostWritableDir, GadgetDir: gadgetDir}
 	// configure cloud init
@@ -211,11 +302,41 @@ func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
 		KernelPath:        kernelInfo.MountFile(),
 		RecoverySystemDir: recoverySystemDir,
 		UnpackedGadgetDir: gadgetDir,
+		FactoryReset:      factoryReset,
 	}
 	rootdir := dirs.GlobalRootDir
+	sealedWith := "tpm"
+	if keyProtector != "" {
+		sealedWith = keyProtector
+	}
+	if trustedInstallObserver != nil {
+		// the keys are sealed to the boot chains while making the
+		// system bootable
+		recordInitStep(st, InitStepSealing, InitStatusStarted, sealedWith)
+	}
 	if err := bootMakeBootable(deviceCtx.Model(), rootdir, bootWith, trustedInstallObserver); err != nil {
+		if trustedInstallObserver != nil {
+			recordInitStep(st, InitStepSealing, InitStatusFailed, err.Error())
+		}
 		return fmt.Errorf("cannot make run system bootable: %v", err)
 	}
+	if trustedInstallObserver != nil {
+		recordInitStep(st, InitStepSealing, InitStatusDone, sealedWith)
+	}
+	if factoryReset && bopts.SaveKey != nil {
+		// the new key of ubuntu-save is sealed, the previous one is
+		// not needed anymore, its other keys like the recovery key
+		// are kept
+		saveNode := installedSystem.DeviceForRole[gadget.SystemSave]
+		if err := secbootRemoveEncryptionKey(saveNode, bopts.SaveKey); err != nil {
+			return fmt.Errorf("cannot remove the previous key of ubuntu-save: %v", err)
+		}
+	}
+	// the state of install mode is discarded, hand the initialization log
+	// over to the run system
+	if err := writeInitLog(st, boot.InstallHostWritableDir); err != nil {
+		logger.Noticef("cannot save device initialization log: %v", err)
+	}
 
 	// request a restart as the last action after a successful install
 	logger.Noticef("request system restart")
@@ -224,7 +345,117 @@ func (m *DeviceManager) doSetupRunSystem(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
-func saveKeys(keysForRoles map[string]*install.EncryptionKeySet) error {
+// checkFactoryResetSupported returns an error if the system using the given
+// gadget and encryption settings cannot be factory reset.
+func checkFactoryResetSupported(ginfo *gadget.Info, useEncryption bool, keyProtector string) error {
+	if useEncryption && keyProtector != "" {
+		return fmt.Errorf("cannot perform factory reset of a system using %q", keyProtector)
+	}
+	if ginfo.FactoryMode != nil {
+		return fmt.Errorf("cannot perform factory reset of a system in factory mode")
+	}
+	return nil
+}
+
+// checkFactoryResetRequest checks that the running system can be factory
+// reset, before rebooting into factory-reset mode, so that an unsupported
+// reset does not leave the device booting into that mode.
+func checkFactoryResetRequest(st *state.State, deviceCtx snapstate.DeviceContext) error {
+	gadgetInfo, err := snapstate.GadgetInfo(st, deviceCtx)
+	if err != nil {
+		return fmt.Errorf("cannot get gadget info: %v", err)
+	}
+	ginfo, err := gadget.ReadInfo(gadgetInfo.MountDir(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot read gadget metadata: %v", err)
+	}
+	useEncryption, keyProtector, err := checkEncryption(deviceCtx.Model(), ginfo)
+	if err != nil {
+		return err
+	}
+	return checkFactoryResetSupported(ginfo, useEncryption, keyProtector)
+}
+
+// fallBackToRunMode makes the device boot into the installed system again
+// after a factory reset failed without modifying it.
+func (m *DeviceManager) fallBackToRunMode(st *state.State, resetErr error) {
+	err := func() error {
+		modeEnv, err := maybeReadModeenv()
+		if err != nil {
+			return err
+		}
+		if modeEnv == nil {
+			return fmt.Errorf("missing modeenv")
+		}
+		deviceCtx, err := DeviceCtx(st, nil, nil)
+		if err != nil {
+			return err
+		}
+		return boot.SetRecoveryBootSystemAndMode(deviceCtx, modeEnv.RecoverySystem, "run")
+	}()
+	if err != nil {
+		logger.Noticef("cannot boot into run mode after failed factory reset: %v", err)
+		return
+	}
+	logger.Noticef("factory reset failed, restarting into run mode: %v", resetErr)
+	st.RequestRestart(state.RestartSystemNow)
+}
+
+// tpmOptions returns how the TPM is provisioned and how the keys are sealed
+// to it, as selected by the gadget. The TPM is verified with the endorsement
+// key certificate provisioned on ubuntu-seed for the device, if any.
+func tpmOptions(ginfo *gadget.Info) boot.TPMOptions {
+	var opts boot.TPMOptions
+	ekCertFile := filepath.Join(boot.InitramfsSeedEncryptionKeyDir, "tpm-ek-cert")
+	if osutil.FileExists(ekCertFile) {
+		opts.EKCertFile = ekCertFile
+	}
+	if ginfo.Encryption == nil || ginfo.Encryption.TPM == nil {
+		return opts
+	}
+	tpm := ginfo.Encryption.TPM
+	opts.SRKTemplate = secboot.SRKTemplate(tpm.SRKTemplate)
+	opts.SRKHandle = tpm.SRKHandle
+	opts.WrapPolicyAuthKey = tpm.WrapPolicyAuthKey
+	opts.SkipEKVerification = tpm.SkipEKVerification
+	return opts
+}
+
+// extraVolumeKeys returns the keys of the extra encrypted structures of the
+// gadget, along with the mount points they are declared with.
+func extraVolumeKeys(ginfo *gadget.Info, keys map[string]*install.EncryptionKeySet) ([]boot.ExtraVolumeKey, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var extraKeys []boot.ExtraVolumeKey
+	for _, vol := range ginfo.Volumes {
+		for _, vs := range vol.Structure {
+			if !vs.Encrypt {
+				continue
+			}
+			keySet := keys[vs.Label]
+			if keySet == nil {
+				continue
+			}
+			extraKeys = append(extraKeys, boot.ExtraVolumeKey{
+				ExtraVolume: secboot.ExtraVolume{
+					Name:       vs.Label,
+					MountPoint: vs.MountPoint,
+				},
+				Key: keySet.Key,
+			})
+		}
+	}
+	if len(extraKeys) != len(keys) {
+		return nil, fmt.Errorf("internal error: cannot find all the encrypted structures in the gadget")
+	}
+	sort.Slice(extraKeys, func(i, j int) bool {
+		return extraKeys[i].Name < extraKeys[j].Name
+	})
+	return extraKeys, nil
+}
+
+func saveKeys(keysForRoles, keysForExtraVolumes map[string]*install.EncryptionKeySet) error {
 	dataKeySet := keysForRoles[gadget.SystemData]
 
 	// ensure directory for keys exists
@@ -238,6 +469,14 @@ func saveKeys(keysForRoles map[string]*install.EncryptionKeySet) error {
 		return fmt.Errorf("cannot store recovery key: %v", err)
 	}
 
+	// and the recovery keys of the extra encrypted volumes
+	for name, keySet := range keysForExtraVolumes {
+		vol := secboot.ExtraVolume{Name: name}
+		if err := keySet.RecoveryKey.Save(vol.RecoveryKeyFile(boot.InstallHostFDEDataDir)); err != nil {
+			return fmt.Errorf("cannot store recovery key of %s: %v", name, err)
+		}
+	}
+
 	saveKeySet := keysForRoles[gadget.SystemSave]
 	if saveKeySet == nil {
 		// no system-save support
@@ -256,27 +495,51 @@ func saveKeys(keysForRoles map[string]*install.EncryptionKeySet) error {
 	return nil
 }
 
-var secbootCheckKeySealingSupported = secboot.CheckKeySealingSupported
+var (
+	secbootCheckKeySealingSupported      = secboot.CheckKeySealingSupported
+	secbootCheckOPTEEKeySealingSupported = secboot.CheckOPTEEKeySealingSupported
+	secbootCheckCAAMKeySealingSupported  = secboot.CheckCAAMKeySealingSupported
+)
 
 // checkEncryption verifies whether encryption should be used based on the
-// model grade and the availability of a TPM device.
-func checkEncryption(model *asserts.Model) (res bool, err error) {
+// model grade, the key protector selected by the gadget and the availability
+// of a TPM device. It also returns the key protector to use instead of the
+// TPM, if any.
+func checkEncryption(model *asserts.Model, ginfo *gadget.Info) (res bool, keyProtector string, err error) {
 	secured := model.Grade() == asserts.ModelSecured
 	dangerous := model.Grade() == asserts.ModelDangerous
 
 	// check if we should disable encryption non-secured devices
 	// TODO:UC20: this is not the final mechanism to bypass encryption
 	if dangerous && osutil.FileExists(filepath.Join(boot.InitramfsUbuntuSeedDir, ".force-unencrypted")) {
-		return false, nil
+		return false, "", nil
+	}
+
+	// the key protector selected by the gadget is used when available
+	if ginfo.Encryption != nil {
+		var checkSupported func() error
+		switch ginfo.Encryption.KeyProtector {
+		case secboot.OPTEEKeyProtectorName:
+			checkSupported = secbootCheckOPTEEKeySealingSupported
+		case secboot.CAAMKeyProtectorName:
+			checkSupported = secbootCheckCAAMKeySealingSupported
+		}
+		if checkSupported != nil {
+			err := checkSupported()
+			if err == nil {
+				return true, ginfo.Encryption.KeyProtector, nil
+			}
+			logger.Noticef("cannot use %s to protect encryption keys: %v", ginfo.Encryption.KeyProtector, err)
+		}
 	}
 
 	// encryption is required in secured devices and optional in other grades
 	if err := secbootCheckKeySealingSupported(); err != nil {
 		if secured {
-			return false, fmt.Errorf("cannot encrypt secured device: %v", err)
+			return false, "", fmt.Errorf("cannot encrypt secured device: %v", err)
 		}
-		return false, nil
+		return false, "", nil
 	}
 
-	return true, nil
+	return true, "", nil
 }
@@ -125,6 +125,15 @@ func (m *DeviceManager) cleanupRemodel(t *state.Task, _ *tomb.Tomb) error {
 	defer st.Unlock()
 	// cleanup the cached remodel context
 	cleanupRemodelCtx(t.Change())
+
+	// and any snap file of an offline remodel that was not installed
+	var files []string
+	if err := t.Change().Get("local-snap-files", &files); err != nil && err != state.ErrNoState {
+		return err
+	}
+	for _, f := range files {
+		removeLocalSnapFile(f)
+	}
 	return nil
 }
 
@@ -156,7 +165,7 @@ func (m *DeviceManager) doPrepareRemodeling(t *state.Task, tmb *tomb.Tomb) error
 
 	chgID := t.Change().ID()
 
-	tss, err := remodelTasks(tmb.Context(nil), st, current, remodCtx.Model(), remodCtx, chgID)
+	tss, err := remodelTasks(tmb.Context(nil), st, current, remodCtx.Model(), remodCtx, chgID, nil)
 	if err != nil {
 		return err
 	}
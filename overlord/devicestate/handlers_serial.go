@@ -237,6 +237,7 @@ func (rc *initialRegistrationContext) FinishRegistration(serial *asserts.Serial)
 		return err
 	}
 	rc.deviceMgr.markRegistered()
+	recordInitStep(rc.deviceMgr.state, InitStepRegistration, InitStatusDone, "serial "+serial.Serial())
 
 	// make sure we timely consider anything that was blocked on
 	// registration
@@ -646,7 +647,7 @@ func getSerialRequestConfig(t *state.Task, regCtx registrationContext, client *h
 	return &cfg, nil
 }
 
-func (m *DeviceManager) doRequestSerial(t *state.Task, _ *tomb.Tomb) error {
+func (m *DeviceManager) doRequestSerial(t *state.Task, _ *tomb.Tomb) (err error) {
 	st := t.State()
 	st.Lock()
 	defer st.Unlock()
@@ -658,6 +659,13 @@ func (m *DeviceManager) doRequestSerial(t *state.Task, _ *tomb.Tomb) error {
 	if err != nil {
 		return err
 	}
+	if !regCtx.ForRemodeling() {
+		defer func() {
+			if _, ok := err.(*state.Retry); err != nil && !ok {
+				recordInitStep(st, InitStepRegistration, InitStatusFailed, err.Error())
+			}
+		}()
+	}
 
 	device, err := regCtx.Device()
 	if err != nil {
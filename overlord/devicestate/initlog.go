@@ -0,0 +1,244 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// maxInitLogEntries is the number of device initialization log entries
+// kept in the state.
+const maxInitLogEntries = 100
+
+// Steps of the device initialization recorded in the initialization log.
+const (
+	InitStepSeeding      = "seeding"
+	InitStepConnections  = "connections"
+	InitStepSealing      = "sealing"
+	InitStepRegistration = "registration"
+)
+
+// Status of a device initialization step as recorded in the log.
+const (
+	InitStatusStarted = "started"
+	InitStatusDone    = "done"
+	InitStatusFailed  = "failed"
+	// InitStatusResumed marks a step that was interrupted, typically
+	// by a reboot or a snapd restart, and is being resumed.
+	InitStatusResumed = "resumed"
+)
+
+// InitLogEntry records the progress of one step of the device
+// initialization.
+type InitLogEntry struct {
+	Step   string    `json:"step"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// InitCheckpoint summarizes the device initialization log: the last step
+// that completed and the steps that were started but did not finish.
+type InitCheckpoint struct {
+	LastDone string   `json:"last-done,omitempty"`
+	Pending  []string `json:"pending,omitempty"`
+}
+
+// InitLog returns the device initialization log, oldest entry first.
+func InitLog(st *state.State) ([]*InitLogEntry, error) {
+	var entries []*InitLogEntry
+	if err := st.Get("device-init-log", &entries); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Checkpoint returns where the device initialization got to according to
+// the given log entries.
+func Checkpoint(entries []*InitLogEntry) *InitCheckpoint {
+	cp := &InitCheckpoint{}
+	last := make(map[string]string)
+	var order []string
+	for _, e := range entries {
+		if _, ok := last[e.Step]; !ok {
+			order = append(order, e.Step)
+		}
+		last[e.Step] = e.Status
+		if e.Status == InitStatusDone {
+			cp.LastDone = e.Step
+		}
+	}
+	for _, step := range order {
+		switch last[step] {
+		case InitStatusStarted, InitStatusResumed:
+			cp.Pending = append(cp.Pending, step)
+		}
+	}
+	return cp
+}
+
+// recordInitStep appends an entry to the device initialization log. The
+// log is informational, errors are only logged.
+func recordInitStep(st *state.State, step, status, detail string) {
+	entries, err := InitLog(st)
+	if err != nil {
+		logger.Noticef("cannot record device initialization step %q: %v", step, err)
+		return
+	}
+	now := timeNow()
+	if n := len(entries); n > 0 {
+		last := entries[n-1]
+		if last.Step == step && last.Status == status && last.Detail == detail {
+			// the same thing happened again, e.g. seeding keeps
+			// failing, just refresh the time
+			last.Time = now
+			st.Set("device-init-log", entries)
+			return
+		}
+	}
+	entries = append(entries, &InitLogEntry{
+		Step:   step,
+		Status: status,
+		Time:   now,
+		Detail: detail,
+	})
+	if len(entries) > maxInitLogEntries {
+		entries = entries[len(entries)-maxInitLogEntries:]
+	}
+	st.Set("device-init-log", entries)
+}
+
+// initLogFileUnder returns the path of the device initialization log handed
+// over from install mode to the run system under rootdir.
+func initLogFileUnder(rootdir string) string {
+	return filepath.Join(dirs.SnapDeviceDirUnder(rootdir), "init-log.json")
+}
+
+// writeInitLog saves the device initialization log to the run system under
+// rootdir, the state of install mode is not carried over to it.
+func writeInitLog(st *state.State, rootdir string) error {
+	entries, err := InitLog(st)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	logFile := initLogFileUnder(rootdir)
+	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(logFile, b, 0644, 0)
+}
+
+// importInitLog prepends the device initialization log handed over from
+// install mode, if any, to the log in the state.
+func importInitLog(st *state.State) error {
+	logFile := initLogFileUnder(dirs.GlobalRootDir)
+	b, err := ioutil.ReadFile(logFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var imported []*InitLogEntry
+	if err := json.Unmarshal(b, &imported); err != nil {
+		return fmt.Errorf("cannot decode %s: %v", logFile, err)
+	}
+	entries, err := InitLog(st)
+	if err != nil {
+		return err
+	}
+	entries = append(imported, entries...)
+	if len(entries) > maxInitLogEntries {
+		entries = entries[len(entries)-maxInitLogEntries:]
+	}
+	st.Set("device-init-log", entries)
+	return os.Remove(logFile)
+}
+
+// initStepChangeID returns the ID of the change carrying out the step
+// according to the entries, or "" if the step is not carried out by a
+// change.
+func initStepChangeID(entries []*InitLogEntry, step string) string {
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Step != step || e.Status != InitStatusStarted {
+			continue
+		}
+		if !strings.HasPrefix(e.Detail, "change ") {
+			return ""
+		}
+		return strings.TrimPrefix(e.Detail, "change ")
+	}
+	return ""
+}
+
+// resumeInitLog replays the device initialization log, including the one
+// handed over from install mode, and reconciles the steps that were
+// interrupted before completing with the changes carrying them out.
+func (m *DeviceManager) resumeInitLog() error {
+	st := m.state
+	st.Lock()
+	defer st.Unlock()
+
+	if err := importInitLog(st); err != nil {
+		return err
+	}
+	entries, err := InitLog(st)
+	if err != nil {
+		return err
+	}
+	for _, step := range Checkpoint(entries).Pending {
+		id := initStepChangeID(entries, step)
+		if id == "" {
+			// the step is not carried out by a change and cannot
+			// continue after a reboot or a restart
+			recordInitStep(st, step, InitStatusFailed, "interrupted")
+			continue
+		}
+		chg := st.Change(id)
+		switch {
+		case chg == nil:
+			recordInitStep(st, step, InitStatusFailed, fmt.Sprintf("change %s is gone", id))
+		case !chg.IsReady():
+			logger.Noticef("resuming interrupted device initialization step %q", step)
+			recordInitStep(st, step, InitStatusResumed, "change "+id)
+		case chg.Status() == state.DoneStatus:
+			recordInitStep(st, step, InitStatusDone, "change "+id)
+		default:
+			recordInitStep(st, step, InitStatusFailed, fmt.Sprintf("change %s: %v", id, chg.Status()))
+		}
+	}
+	return nil
+}
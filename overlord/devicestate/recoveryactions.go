@@ -0,0 +1,279 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/disks"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/randutil"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/snap"
+)
+
+var (
+	secbootCryptoEraseVolumes    = secboot.CryptoEraseVolumes
+	disksDmCryptMappingForDevice = disks.DmCryptMappingForDevice
+)
+
+// gadgetRecoveryActions returns the recovery actions declared by the gadget
+// of the running system.
+func gadgetRecoveryActions(st *state.State) []gadget.RecoveryAction {
+	st.Lock()
+	defer st.Unlock()
+
+	deviceCtx, err := DeviceCtx(st, nil, nil)
+	if err != nil {
+		return nil
+	}
+	info, err := snapstate.GadgetInfo(st, deviceCtx)
+	if err != nil {
+		if err != state.ErrNoState {
+			logger.Noticef("cannot obtain gadget recovery actions: %v", err)
+		}
+		return nil
+	}
+	gi, err := gadget.ReadInfo(info.MountDir(), deviceCtx.Model())
+	if err != nil {
+		logger.Noticef("cannot obtain gadget recovery actions: %v", err)
+		return nil
+	}
+	return gi.RecoveryActions
+}
+
+// gadgetSystemActions maps the recovery actions declared by the gadget to
+// system actions available in the given mode.
+func gadgetSystemActions(st *state.State, mode string) []SystemAction {
+	var actions []SystemAction
+	for _, ra := range gadgetRecoveryActions(st) {
+		actionMode := mode
+		switch ra.Action {
+		case gadget.RecoveryActionWipeData:
+			actionMode = "factory-reset"
+		case gadget.RecoveryActionPreviousSystem:
+			actionMode = "recover"
+		case gadget.RecoveryActionCryptoErase:
+			actionMode = "install"
+		}
+		actions = append(actions, SystemAction{
+			Title:        ra.Title,
+			Mode:         actionMode,
+			GadgetAction: ra.Action,
+		})
+	}
+	return actions
+}
+
+var runRecoveryApp = runRecoveryAppImpl
+
+func runRecoveryAppImpl(snapApp string) error {
+	snapName, appName := snap.SplitSnapApp(snapApp)
+	wrapper := filepath.Join(dirs.SnapBinariesDir, snap.JoinSnapApp(snapName, appName))
+	// the unit of an earlier run may still be around, the name needs to be
+	// unique
+	unit := fmt.Sprintf("snap.%s.%s.recovery-action-%s", snapName, appName, randutil.RandomKernelUUID())
+	cmd := exec.Command("systemd-run", "--collect", "--unit="+unit, "--", wrapper)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return osutil.OutputErr(output, err)
+	}
+	return nil
+}
+
+// requestGadgetRecoveryAction carries out an action declared by the gadget,
+// such actions are only offered by the current system.
+func (m *DeviceManager) requestGadgetRecoveryAction(systemLabel string, action SystemAction) error {
+	currentSys, err := currentSystemForMode(m.state, m.SystemMode())
+	if err != nil || currentSys == nil || currentSys.System != systemLabel {
+		return ErrUnsupportedAction
+	}
+	var recoveryAction *gadget.RecoveryAction
+	for _, ra := range gadgetRecoveryActions(m.state) {
+		if ra.Title == action.Title && ra.Action == action.GadgetAction {
+			recoveryAction = &ra
+			break
+		}
+	}
+	if recoveryAction == nil {
+		return ErrUnsupportedAction
+	}
+
+	switch recoveryAction.Action {
+	case gadget.RecoveryActionRunApp:
+		logger.Noticef("running %q for recovery action %q", recoveryAction.App, recoveryAction.Title)
+		if err := runRecoveryApp(recoveryAction.App); err != nil {
+			return fmt.Errorf("cannot run %q: %v", recoveryAction.App, err)
+		}
+		return nil
+	case gadget.RecoveryActionWipeData:
+		// factory reset reinstalls the system but preserves ubuntu-save
+		nop := func() {}
+		switched := func(systemLabel string, sysAction *SystemAction) {
+			logger.Noticef("restarting into system %q for action %q", systemLabel, recoveryAction.Title)
+			m.state.RequestRestart(state.RestartSystemNow)
+		}
+		return m.switchToSystemAndMode(systemLabel, "factory-reset", nop, switched)
+	case gadget.RecoveryActionPreviousSystem:
+		return m.requestPreviousRecoverySystem(systemLabel, recoveryAction.Title)
+	case gadget.RecoveryActionCryptoErase:
+		return m.requestCryptoErase(systemLabel, recoveryAction.Title)
+	}
+	return ErrUnsupportedAction
+}
+
+// requestPreviousRecoverySystem reboots into the recover mode of the
+// recovery system preceding the given one.
+func (m *DeviceManager) requestPreviousRecoverySystem(systemLabel, title string) error {
+	modeEnv, err := maybeReadModeenv()
+	if err != nil {
+		return err
+	}
+	if modeEnv == nil {
+		return ErrUnsupportedAction
+	}
+	previous := ""
+	for i, label := range modeEnv.CurrentRecoverySystems {
+		if label == systemLabel && i > 0 {
+			previous = modeEnv.CurrentRecoverySystems[i-1]
+			break
+		}
+	}
+	if previous == "" {
+		return fmt.Errorf("cannot find a recovery system preceding %q", systemLabel)
+	}
+	if err := checkSystemRequestConflict(m.state, previous); err != nil {
+		return err
+	}
+
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	deviceCtx, err := DeviceCtx(m.state, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := boot.SetRecoveryBootSystemAndMode(deviceCtx, previous, "recover"); err != nil {
+		return fmt.Errorf("cannot set device to boot into system %q in mode %q: %v", previous, "recover", err)
+	}
+	logger.Noticef("restarting into system %q for action %q", previous, title)
+	m.state.RequestRestart(state.RestartSystemNow)
+	return nil
+}
+
+// mountSource returns the device mounted at the given directory, or an
+// empty string if nothing is mounted there.
+func mountSource(mountDir string) (string, error) {
+	mounts, err := osutil.LoadMountInfo()
+	if err != nil {
+		return "", err
+	}
+	for _, mnt := range mounts {
+		if mnt.MountDir == mountDir {
+			return mnt.MountSource, nil
+		}
+	}
+	return "", nil
+}
+
+// cryptoEraseParams collects the encrypted volumes of the system running in
+// the given mode, that is ubuntu-data and ubuntu-save if present, together
+// with the key material protecting them.
+func cryptoEraseParams(mode string) (*secboot.CryptoEraseParams, error) {
+	dataDir := boot.InitramfsDataDir
+	if mode != "run" {
+		// the data of the installed system is mounted under host
+		dataDir = boot.InitramfsHostUbuntuDataDir
+	}
+
+	params := &secboot.CryptoEraseParams{
+		KeyFiles: boot.SealedKeyFiles(),
+	}
+	for _, vol := range []struct {
+		name     string
+		mountDir string
+	}{
+		{"ubuntu-data", dataDir},
+		{"ubuntu-save", boot.InitramfsUbuntuSaveDir},
+	} {
+		source, err := mountSource(vol.mountDir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the device of %s: %v", vol.name, err)
+		}
+		if source == "" {
+			if vol.name == "ubuntu-save" {
+				// systems installed by older snapd have no ubuntu-save
+				continue
+			}
+			return nil, fmt.Errorf("%s is not mounted", vol.name)
+		}
+
+		mapping, err := disksDmCryptMappingForDevice(source)
+		if err != nil {
+			if _, ok := err.(disks.NotDmCryptMappingError); ok {
+				return nil, fmt.Errorf("%s is not encrypted", vol.name)
+			}
+			return nil, err
+		}
+		if mapping.LUKSVersion == "" {
+			return nil, fmt.Errorf("%s is not a LUKS volume", vol.name)
+		}
+		params.Devices = append(params.Devices, mapping.SourceKernelDeviceNode)
+	}
+	return params, nil
+}
+
+// requestCryptoErase makes the content of the encrypted volumes of the
+// running system irrecoverable and reboots into the install mode of the
+// given recovery system, the installed system cannot be booted anymore.
+func (m *DeviceManager) requestCryptoErase(systemLabel, title string) error {
+	if err := checkSystemRequestConflict(m.state, systemLabel); err != nil {
+		return err
+	}
+	params, err := cryptoEraseParams(m.SystemMode())
+	if err != nil {
+		return fmt.Errorf("cannot erase the encrypted volumes: %v", err)
+	}
+
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	deviceCtx, err := DeviceCtx(m.state, nil, nil)
+	if err != nil {
+		return err
+	}
+	logger.Noticef("erasing the encrypted volumes for action %q", title)
+	if err := secbootCryptoEraseVolumes(params); err != nil {
+		return fmt.Errorf("cannot erase the encrypted volumes: %v", err)
+	}
+	if err := boot.SetRecoveryBootSystemAndMode(deviceCtx, systemLabel, "install"); err != nil {
+		return fmt.Errorf("cannot set device to boot into system %q in mode %q: %v", systemLabel, "install", err)
+	}
+	logger.Noticef("restarting into system %q for action %q", systemLabel, title)
+	m.state.RequestRestart(state.RestartSystemNow)
+	return nil
+}
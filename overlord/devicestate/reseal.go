@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"time"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var (
+	bootHoldReseal    = boot.HoldReseal
+	bootReleaseReseal = boot.ReleaseReseal
+)
+
+// resealDebounceDelay is how long resealing stays held after the last
+// change that may reseal the keys is done, so that changes queued one after
+// the other result in a single update of the TPM policies.
+var resealDebounceDelay = 5 * time.Second
+
+// resealTaskKinds are the kinds of tasks that may reseal the encryption
+// keys when run or undone, either by updating the boot assets, the kernel or
+// the kernel command line.
+var resealTaskKinds = map[string]bool{
+	"link-snap":            true,
+	"unlink-current-snap":  true,
+	"update-gadget-assets": true,
+}
+
+// maybeResealing returns whether there are tasks in progress which may
+// reseal the encryption keys.
+func maybeResealing(st *state.State) bool {
+	for _, chg := range st.Changes() {
+		if chg.Status().Ready() {
+			continue
+		}
+		for _, t := range chg.Tasks() {
+			if !resealTaskKinds[t.Kind()] {
+				continue
+			}
+			switch t.Status() {
+			case state.DoStatus, state.DoingStatus, state.UndoStatus, state.UndoingStatus:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureResealCoalesced holds resealing of the encryption keys while
+// changes which may reseal them are in progress, and for a short while
+// after, so that the reseals dropping boot chains after a successful boot
+// made during the batch are merged into one update of the TPM policies.
+// The reseals needed before boot assets, kernels or command lines are
+// written are never held and fail the task requesting them. Resealing is
+// never held past a request for restarting the system.
+func (m *DeviceManager) ensureResealCoalesced() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	// keys are only resealed in run mode of UC20 systems, where the
+	// system mode is explicitly set
+	if m.systemMode != "run" {
+		return nil
+	}
+
+	restarting, _ := m.state.Restarting()
+	switch {
+	case restarting:
+		// not holding any longer
+	case maybeResealing(m.state):
+		if !m.resealHeld {
+			bootHoldReseal()
+			m.resealHeld = true
+		}
+		m.resealBatchDone = time.Time{}
+		return nil
+	case m.resealHeld:
+		if m.resealBatchDone.IsZero() {
+			m.resealBatchDone = timeNow()
+		}
+		if wait := resealDebounceDelay - timeNow().Sub(m.resealBatchDone); wait > 0 {
+			m.state.EnsureBefore(wait)
+			return nil
+		}
+	}
+	return m.releaseReseal()
+}
+
+// releaseReseal releases the hold on resealing, which performs the pending
+// reseal, if any. A failure leaves the keys sealed to a superset of the
+// boot chains in use, which is safe, so it is reported as a warning.
+// It must be called with the state lock held.
+func (m *DeviceManager) releaseReseal() error {
+	if !m.resealHeld {
+		return nil
+	}
+	m.resealHeld = false
+	m.resealBatchDone = time.Time{}
+	if err := bootReleaseReseal(); err != nil {
+		m.state.Warnf("cannot reseal the encryption keys: %v", err)
+		logger.Noticef("cannot reseal the encryption keys: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Stop implements StateStopper. It performs the pending reseal, if any,
+// so that the keys match the boot chains before snapd restarts the system
+// or exits.
+func (m *DeviceManager) Stop() {
+	m.state.Lock()
+	defer m.state.Unlock()
+	// errors were reported already
+	m.releaseReseal()
+}
@@ -115,12 +115,13 @@ func currentSystemForMode(st *state.State, mode string) (*currentSystem, error)
 	case "run":
 		actions = currentSystemActions
 		system, err = currentSeededSystem(st)
-	case "install":
-		// there is no current system for install mode
+	case "install", "factory-reset":
+		// there is no current system for install and factory reset
+		// modes
 		return nil, nil
-	case "recover":
+	case "recover", "repair":
 		actions = recoverSystemActions
-		// recover mode uses modeenv for reference
+		// recover and repair modes use modeenv for reference
 		system, err = seededSystemFromModeenv()
 	default:
 		return nil, fmt.Errorf("internal error: cannot identify current system for unsupported mode %q", mode)
@@ -128,6 +129,10 @@ func currentSystemForMode(st *state.State, mode string) (*currentSystem, error)
 	if err != nil {
 		return nil, err
 	}
+	if gadgetActions := gadgetSystemActions(st, mode); len(gadgetActions) != 0 {
+		// do not modify the shared list of default actions
+		actions = append(append([]SystemAction(nil), actions...), gadgetActions...)
+	}
 	currentSys := &currentSystem{
 		seededSystem: system,
 		actions:      actions,
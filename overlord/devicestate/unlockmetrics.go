@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/secboot"
+)
+
+var (
+	secbootReadUnlockMetrics = secboot.ReadUnlockMetrics
+	osutilBootID             = osutil.BootID
+)
+
+// maxUnlockMetricsBoots is the number of boots whose unlock metrics are
+// kept in the state.
+const maxUnlockMetricsBoots = 10
+
+// BootUnlockMetrics holds how the encrypted volumes were unlocked during one
+// boot.
+type BootUnlockMetrics struct {
+	BootID  string                   `json:"boot-id"`
+	Volumes []*secboot.UnlockMetrics `json:"volumes"`
+}
+
+// collectUnlockMetrics records the unlock metrics of the current boot, as
+// recorded by snap-bootstrap, in the state once per boot.
+func (m *DeviceManager) collectUnlockMetrics() error {
+	metrics, err := secbootReadUnlockMetrics()
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	bootID, err := osutilBootID()
+	if err != nil {
+		return err
+	}
+
+	st := m.state
+	st.Lock()
+	defer st.Unlock()
+
+	history, err := UnlockMetrics(st)
+	if err != nil {
+		return err
+	}
+	if len(history) > 0 && history[len(history)-1].BootID == bootID {
+		return nil
+	}
+	history = append(history, &BootUnlockMetrics{BootID: bootID, Volumes: metrics})
+	if len(history) > maxUnlockMetricsBoots {
+		history = history[len(history)-maxUnlockMetricsBoots:]
+	}
+	st.Set("unlock-metrics", history)
+	return nil
+}
+
+// UnlockMetrics returns how the encrypted volumes were unlocked during the
+// most recent boots, oldest first.
+func UnlockMetrics(st *state.State) ([]*BootUnlockMetrics, error) {
+	var history []*BootUnlockMetrics
+	if err := st.Get("unlock-metrics", &history); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return history, nil
+}
@@ -37,6 +37,25 @@ func MockEnsureInterval(d time.Duration) (restore func()) {
 	return func() { ensureInterval = old }
 }
 
+// MockDegradedRetry sets the bounds of the retry backoff of degraded managers.
+func MockDegradedRetry(min, max time.Duration) (restore func()) {
+	oldMin := degradedRetryMin
+	oldMax := degradedRetryMax
+	degradedRetryMin = min
+	degradedRetryMax = max
+	return func() {
+		degradedRetryMin = oldMin
+		degradedRetryMax = oldMax
+	}
+}
+
+// MockTimeNow mocks the time used by the state engine.
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() { timeNow = old }
+}
+
 // MockPruneInterval sets the overlord prune interval for tests.
 func MockPruneInterval(prunei, prunew, abortw time.Duration) (restore func()) {
 	oldPruneInterval := pruneInterval
@@ -32,3 +32,19 @@ func MockCheckTimeout(t time.Duration) (restore func()) {
 }
 
 var KnownStatuses = knownStatuses
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+func MockReportTimeout(d time.Duration) (restore func()) {
+	old := reportTimeout
+	reportTimeout = d
+	return func() {
+		reportTimeout = old
+	}
+}
@@ -0,0 +1,404 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/configstate/proxyconf"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snapdenv"
+)
+
+var (
+	timeNow = time.Now
+
+	httputilNewHTTPClient = httputil.NewHTTPClient
+
+	// defaultReportInterval is how often reports are sent when
+	// health-report.interval is not set.
+	defaultReportInterval = 24 * time.Hour
+	// reportRetryMin is the delay before retrying to send a report
+	// after the first failure, it is doubled on every further failure
+	// up to the report interval.
+	reportRetryMin = 5 * time.Minute
+	// reportTimeout bounds the time spent posting a report.
+	reportTimeout = 30 * time.Second
+	// maxReportEntries bounds the number of refresh results, failed
+	// changes and policy violations kept in a report, the oldest ones
+	// are dropped first.
+	maxReportEntries = 100
+)
+
+// DeviceIdentity provides the identity of the device health reports are
+// about. It is implemented by devicestate.DeviceManager.
+type DeviceIdentity interface {
+	Model() (*asserts.Model, error)
+	Serial() (*asserts.Serial, error)
+}
+
+// ReportManager batches summaries of the health of the device and
+// periodically posts them to the endpoint configured with the
+// health-report.url core option, typically set by the brand via gadget
+// defaults. Reporting is disabled unless that option is set.
+type ReportManager struct {
+	state    *state.State
+	device   DeviceIdentity
+	degraded func() []string
+
+	// reports are posted in the background, so that a slow or
+	// unresponsive endpoint does not hold up Ensure
+	sending bool
+	ctx     context.Context
+	cancel  func()
+	wg      sync.WaitGroup
+}
+
+// Manager returns a new ReportManager. The degraded function is used to
+// get the subsystems of snapd that are currently degraded.
+func Manager(st *state.State, device DeviceIdentity, degraded func() []string) *ReportManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ReportManager{
+		state:    st,
+		device:   device,
+		degraded: degraded,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// RefreshResult is the outcome of a refresh of snaps.
+type RefreshResult struct {
+	Kind   string    `json:"kind"`
+	Snaps  []string  `json:"snaps,omitempty"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// FailedChange describes a change that was not completed successfully.
+type FailedChange struct {
+	Kind  string    `json:"kind"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Report is the health summary of the device posted to the health report
+// endpoint, covering the period between From and Until.
+type Report struct {
+	BrandID       string          `json:"brand-id"`
+	Model         string          `json:"model"`
+	Serial        string          `json:"serial,omitempty"`
+	From          time.Time       `json:"from"`
+	Until         time.Time       `json:"until"`
+	Refreshes     []RefreshResult `json:"refreshes,omitempty"`
+	FailedChanges []FailedChange  `json:"failed-changes,omitempty"`
+	Degraded      []string        `json:"degraded,omitempty"`
+	// PolicyViolations are the attempts at actions disallowed by the
+	// system policy of the device.
+	PolicyViolations []snapstate.SystemPolicyViolation `json:"policy-violations,omitempty"`
+}
+
+// reportState is the state of health reporting kept in the state under
+// "health-report".
+type reportState struct {
+	// Pending is the report being batched.
+	Pending *Report `json:"pending,omitempty"`
+	// Failures is the number of consecutive failed attempts at sending
+	// the pending report.
+	Failures int       `json:"failures,omitempty"`
+	RetryAt  time.Time `json:"retry-at,omitempty"`
+}
+
+type reportConfig struct {
+	url           string
+	interval      time.Duration
+	includeSerial bool
+	includeErrors bool
+}
+
+func getReportConfig(st *state.State) (*reportConfig, error) {
+	tr := config.NewTransaction(st)
+	var cfg reportConfig
+	if err := tr.GetMaybe("core", "health-report.url", &cfg.url); err != nil {
+		return nil, err
+	}
+	if cfg.url == "" {
+		return nil, nil
+	}
+	var interval string
+	if err := tr.GetMaybe("core", "health-report.interval", &interval); err != nil {
+		return nil, err
+	}
+	cfg.interval = defaultReportInterval
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse health-report.interval: %v", err)
+		}
+		cfg.interval = d
+	}
+	var err error
+	if cfg.includeSerial, err = getBoolFlag(tr, "health-report.include-serial"); err != nil {
+		return nil, err
+	}
+	if cfg.includeErrors, err = getBoolFlag(tr, "health-report.include-errors"); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func getBoolFlag(tr *config.Transaction, flag string) (bool, error) {
+	// the flag might have been set as a string or a boolean
+	var v interface{}
+	if err := tr.GetMaybe("core", flag, &v); err != nil {
+		return false, err
+	}
+	return fmt.Sprintf("%v", v) == "true", nil
+}
+
+func isRefreshChange(kind string) bool {
+	switch kind {
+	case "auto-refresh", "refresh-snap", "refresh-snaps":
+		return true
+	}
+	return false
+}
+
+// collect adds to the report the outcome of the changes that became ready
+// and the system policy violations recorded since the last time it was
+// called.
+func collect(st *state.State, report *Report, cfg *reportConfig, now time.Time) {
+	for _, chg := range st.Changes() {
+		if !chg.IsReady() {
+			continue
+		}
+		ready := chg.ReadyTime()
+		if !ready.After(report.Until) || ready.After(now) {
+			continue
+		}
+		status := chg.Status()
+		if isRefreshChange(chg.Kind()) {
+			var snaps []string
+			if err := chg.Get("snap-names", &snaps); err != nil && err != state.ErrNoState {
+				logger.Debugf("cannot get snap names of change %s: %v", chg.ID(), err)
+			}
+			report.Refreshes = append(report.Refreshes, RefreshResult{
+				Kind:   chg.Kind(),
+				Snaps:  snaps,
+				Status: status.String(),
+				Time:   ready,
+			})
+		} else if status == state.ErrorStatus {
+			failed := FailedChange{
+				Kind: chg.Kind(),
+				Time: ready,
+			}
+			// error messages may carry details like paths or user
+			// names, they are only sent when explicitly allowed
+			if cfg.includeErrors && chg.Err() != nil {
+				failed.Error = chg.Err().Error()
+			}
+			report.FailedChanges = append(report.FailedChanges, failed)
+		}
+	}
+	violations, err := snapstate.SystemPolicyViolations(st)
+	if err != nil {
+		logger.Noticef("cannot get system policy violations: %v", err)
+	}
+	for _, v := range violations {
+		if !v.Time.After(report.Until) || v.Time.After(now) {
+			continue
+		}
+		report.PolicyViolations = append(report.PolicyViolations, v)
+	}
+	sort.SliceStable(report.Refreshes, func(i, j int) bool { return report.Refreshes[i].Time.Before(report.Refreshes[j].Time) })
+	sort.SliceStable(report.FailedChanges, func(i, j int) bool { return report.FailedChanges[i].Time.Before(report.FailedChanges[j].Time) })
+	if n := len(report.Refreshes); n > maxReportEntries {
+		report.Refreshes = report.Refreshes[n-maxReportEntries:]
+	}
+	if n := len(report.FailedChanges); n > maxReportEntries {
+		report.FailedChanges = report.FailedChanges[n-maxReportEntries:]
+	}
+	if n := len(report.PolicyViolations); n > maxReportEntries {
+		report.PolicyViolations = report.PolicyViolations[n-maxReportEntries:]
+	}
+	report.Until = now
+}
+
+// Ensure is part of the overlord.StateManager interface.
+func (m *ReportManager) Ensure() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	if m.sending {
+		// the changes that become ready meanwhile are collected once
+		// the report was sent
+		return nil
+	}
+
+	cfg, err := getReportConfig(m.state)
+	if err != nil {
+		return err
+	}
+
+	var rs reportState
+	if err := m.state.Get("health-report", &rs); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if cfg == nil {
+		if rs.Pending != nil {
+			// reporting was disabled, drop what was batched
+			m.state.Set("health-report", nil)
+		}
+		return nil
+	}
+
+	now := timeNow()
+	if rs.Pending == nil {
+		// start batching from now on, what happened before
+		// reporting was enabled is not reported
+		rs.Pending = &Report{From: now, Until: now}
+		m.state.Set("health-report", &rs)
+		return nil
+	}
+	collect(m.state, rs.Pending, cfg, now)
+	m.state.Set("health-report", &rs)
+
+	if now.Before(rs.Pending.From.Add(cfg.interval)) || now.Before(rs.RetryAt) {
+		return nil
+	}
+
+	report := *rs.Pending
+	model, err := m.device.Model()
+	if err == state.ErrNoState {
+		// nothing to report about a device without identity yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	report.BrandID = model.BrandID()
+	report.Model = model.Model()
+	if cfg.includeSerial {
+		serial, err := m.device.Serial()
+		if err != nil && err != state.ErrNoState {
+			return err
+		}
+		if serial != nil {
+			report.Serial = serial.Serial()
+		}
+	}
+	report.Degraded = m.degraded()
+
+	m.sending = true
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := m.send(cfg.url, &report)
+
+		m.state.Lock()
+		defer m.state.Unlock()
+		m.sending = false
+		if m.ctx.Err() != nil {
+			// snapd is stopping, the report is sent again later
+			return
+		}
+		m.sent(&rs, &report, cfg.interval, now, err)
+	}()
+	return nil
+}
+
+// sent records the outcome of sending the report batched in rs.
+// It must be called with the state lock held.
+func (m *ReportManager) sent(rs *reportState, report *Report, interval time.Duration, now time.Time, err error) {
+	if err != nil {
+		rs.Failures++
+		delay := reportRetryMin
+		for i := 1; i < rs.Failures && delay < interval; i++ {
+			delay *= 2
+		}
+		if delay > interval {
+			delay = interval
+		}
+		rs.RetryAt = now.Add(delay)
+		m.state.Set("health-report", rs)
+		logger.Noticef("cannot send health report, retrying in %s: %v", delay, err)
+		return
+	}
+	// start a new batch, changes that became ready while sending
+	// are collected into it by the next Ensure
+	m.state.Set("health-report", &reportState{
+		Pending: &Report{From: report.Until, Until: report.Until},
+	})
+}
+
+// Wait implements StateWaiter. It waits for the report being sent, if any.
+func (m *ReportManager) Wait() {
+	m.wg.Wait()
+}
+
+// Stop implements StateStopper. It aborts sending the report, if any, which
+// is sent again once snapd is back.
+func (m *ReportManager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *ReportManager) send(url string, report *Report) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	proxyConf := proxyconf.New(m.state)
+	client := httputilNewHTTPClient(&httputil.ClientOptions{
+		Proxy:              proxyConf.Conf,
+		ProxyConnectHeader: http.Header{"User-Agent": []string{snapdenv.UserAgent()}},
+	})
+	ctx, cancel := context.WithTimeout(m.ctx, reportTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", snapdenv.UserAgent())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
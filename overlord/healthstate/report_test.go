@@ -0,0 +1,465 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/healthstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type reportSuite struct {
+	testutil.BaseTest
+
+	state  *state.State
+	model  *asserts.Model
+	serial *asserts.Serial
+	now    time.Time
+
+	degraded []string
+	reports  []map[string]interface{}
+	status   int
+	server   *httptest.Server
+}
+
+var _ = check.Suite(&reportSuite{})
+
+var deviceKey, _ = assertstest.GenerateKey(752)
+
+func (s *reportSuite) SetUpTest(c *check.C) {
+	s.BaseTest.SetUpTest(c)
+
+	s.state = state.New(nil)
+
+	storeSigning := assertstest.NewStoreStack("canonical", nil)
+	brands := assertstest.NewSigningAccounts(storeSigning)
+	brandPrivKey, _ := assertstest.GenerateKey(752)
+	brands.Register("my-brand", brandPrivKey, nil)
+	s.model = brands.Model("my-brand", "my-model", map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+	})
+	encDevKey, err := asserts.EncodePublicKey(deviceKey.PublicKey())
+	c.Assert(err, check.IsNil)
+	serial, err := brands.Signing("my-brand").Sign(asserts.SerialType, map[string]interface{}{
+		"authority-id":        "my-brand",
+		"brand-id":            "my-brand",
+		"model":               "my-model",
+		"serial":              "7878",
+		"device-key":          string(encDevKey),
+		"device-key-sha3-384": deviceKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	s.serial = serial.(*asserts.Serial)
+
+	s.degraded = nil
+	s.reports = nil
+	s.status = 200
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/report")
+		c.Check(r.Header.Get("Content-Type"), check.Equals, "application/json")
+		if s.status != 200 {
+			w.WriteHeader(s.status)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, check.IsNil)
+		var report map[string]interface{}
+		c.Assert(json.Unmarshal(b, &report), check.IsNil)
+		s.reports = append(s.reports, report)
+	}))
+	s.AddCleanup(s.server.Close)
+
+	s.now = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.AddCleanup(healthstate.MockTimeNow(func() time.Time { return s.now }))
+}
+
+func (s *reportSuite) Model() (*asserts.Model, error) {
+	if s.model == nil {
+		return nil, state.ErrNoState
+	}
+	return s.model, nil
+}
+
+func (s *reportSuite) Serial() (*asserts.Serial, error) {
+	if s.serial == nil {
+		return nil, state.ErrNoState
+	}
+	return s.serial, nil
+}
+
+func (s *reportSuite) manager() *healthstate.ReportManager {
+	return healthstate.Manager(s.state, s, func() []string { return s.degraded })
+}
+
+// ensure runs Ensure of the manager and waits for the report it sends, if
+// any.
+func (s *reportSuite) ensure(c *check.C, m *healthstate.ReportManager) {
+	c.Assert(m.Ensure(), check.IsNil)
+	m.Wait()
+}
+
+func (s *reportSuite) configure(c *check.C, conf map[string]interface{}) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	tr := config.NewTransaction(s.state)
+	for k, v := range conf {
+		c.Assert(tr.Set("core", k, v), check.IsNil)
+	}
+	tr.Commit()
+}
+
+func (s *reportSuite) addChange(kind string, status state.Status, snaps []string) {
+	restore := state.MockTime(s.now)
+	defer restore()
+	s.state.Lock()
+	defer s.state.Unlock()
+	chg := s.state.NewChange(kind, "...")
+	t := s.state.NewTask("foo", "...")
+	chg.AddTask(t)
+	if status == state.ErrorStatus {
+		t.Errorf("boom")
+	}
+	t.SetStatus(status)
+	if snaps != nil {
+		chg.Set("snap-names", snaps)
+	}
+}
+
+func (s *reportSuite) TestReportDisabledByDefault(c *check.C) {
+	m := s.manager()
+	s.addChange("install-snap", state.ErrorStatus, nil)
+	s.now = s.now.Add(48 * time.Hour)
+	s.ensure(c, m)
+	c.Check(s.reports, check.HasLen, 0)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var rs map[string]interface{}
+	c.Check(s.state.Get("health-report", &rs), check.Equals, state.ErrNoState)
+}
+
+func (s *reportSuite) TestReportHappy(c *check.C) {
+	s.configure(c, map[string]interface{}{
+		"health-report.url": s.server.URL + "/report",
+	})
+	m := s.manager()
+
+	// what happened before reporting got enabled is not reported
+	s.addChange("install-snap", state.ErrorStatus, nil)
+	s.now = s.now.Add(time.Minute)
+	s.ensure(c, m)
+	start := s.now
+
+	s.now = s.now.Add(time.Minute)
+	s.addChange("auto-refresh", state.DoneStatus, []string{"foo", "bar"})
+	s.addChange("refresh-snap", state.ErrorStatus, []string{"baz"})
+	s.addChange("install-snap", state.DoneStatus, []string{"other"})
+	s.addChange("remove-snap", state.ErrorStatus, []string{"other"})
+	s.ensure(c, m)
+	// not yet time to send
+	c.Check(s.reports, check.HasLen, 0)
+
+	s.degraded = []string{"snapstate.SnapManager"}
+	s.now = start.Add(24 * time.Hour)
+	s.ensure(c, m)
+	c.Assert(s.reports, check.HasLen, 1)
+	report := s.reports[0]
+	c.Check(report["brand-id"], check.Equals, "my-brand")
+	c.Check(report["model"], check.Equals, "my-model")
+	// privacy by default
+	c.Check(report["serial"], check.IsNil)
+	c.Check(report["from"], check.Equals, start.UTC().Format(time.RFC3339Nano))
+	c.Check(report["until"], check.Equals, s.now.UTC().Format(time.RFC3339Nano))
+	c.Check(report["degraded"], check.DeepEquals, []interface{}{"snapstate.SnapManager"})
+
+	refreshes := report["refreshes"].([]interface{})
+	c.Assert(refreshes, check.HasLen, 2)
+	r0 := refreshes[0].(map[string]interface{})
+	r1 := refreshes[1].(map[string]interface{})
+	c.Check(r0["kind"], check.Equals, "auto-refresh")
+	c.Check(r0["snaps"], check.DeepEquals, []interface{}{"foo", "bar"})
+	c.Check(r0["status"], check.Equals, "Done")
+	c.Check(r1["kind"], check.Equals, "refresh-snap")
+	c.Check(r1["status"], check.Equals, "Error")
+
+	failed := report["failed-changes"].([]interface{})
+	c.Assert(failed, check.HasLen, 1)
+	f0 := failed[0].(map[string]interface{})
+	c.Check(f0["kind"], check.Equals, "remove-snap")
+	c.Check(f0["error"], check.IsNil)
+
+	// the next batch starts empty
+	s.now = s.now.Add(24 * time.Hour)
+	s.ensure(c, m)
+	c.Assert(s.reports, check.HasLen, 2)
+	c.Check(s.reports[1]["refreshes"], check.IsNil)
+	c.Check(s.reports[1]["failed-changes"], check.IsNil)
+}
+
+func (s *reportSuite) TestReportIncludeDetails(c *check.C) {
+	s.configure(c, map[string]interface{}{
+		"health-report.url":            s.server.URL + "/report",
+		"health-report.interval":       "2h",
+		"health-report.include-serial": true,
+		"health-report.include-errors": "true",
+	})
+	m := s.manager()
+
+	s.ensure(c, m)
+
+	s.now = s.now.Add(time.Minute)
+	s.addChange("remove-snap", state.ErrorStatus, []string{"other"})
+	s.now = s.now.Add(2 * time.Hour)
+	s.ensure(c, m)
+	c.Assert(s.reports, check.HasLen, 1)
+	report := s.reports[0]
+	c.Check(report["serial"], check.Equals, "7878")
+	failed := report["failed-changes"].([]interface{})
+	c.Assert(failed, check.HasLen, 1)
+	c.Check(failed[0].(map[string]interface{})["error"], check.Matches, `(?s)cannot perform the following tasks:.*boom.*`)
+}
+
+func (s *reportSuite) TestReportPolicyViolations(c *check.C) {
+	s.configure(c, map[string]interface{}{
+		"health-report.url":      s.server.URL + "/report",
+		"health-report.interval": "2h",
+	})
+	m := s.manager()
+
+	s.ensure(c, m)
+	start := s.now
+
+	s.state.Lock()
+	s.state.Set("system-policy-violations", []snapstate.SystemPolicyViolation{
+		// before the batch started
+		{Snap: "old-snap", Action: "dangerous", Time: start.Add(-time.Minute)},
+		{Snap: "some-snap", Action: "dangerous", Time: start.Add(time.Minute)},
+		{Snap: "other-snap", Action: "devmode", Time: start.Add(2 * time.Minute)},
+	})
+	s.state.Unlock()
+
+	s.now = start.Add(2 * time.Hour)
+	s.ensure(c, m)
+	c.Assert(s.reports, check.HasLen, 1)
+	violations := s.reports[0]["policy-violations"].([]interface{})
+	c.Assert(violations, check.HasLen, 2)
+	v0 := violations[0].(map[string]interface{})
+	c.Check(v0["snap"], check.Equals, "some-snap")
+	c.Check(v0["action"], check.Equals, "dangerous")
+	c.Check(v0["time"], check.Equals, start.Add(time.Minute).Format(time.RFC3339Nano))
+	v1 := violations[1].(map[string]interface{})
+	c.Check(v1["snap"], check.Equals, "other-snap")
+	c.Check(v1["action"], check.Equals, "devmode")
+
+	// violations are reported only once
+	s.now = s.now.Add(2 * time.Hour)
+	s.ensure(c, m)
+	c.Assert(s.reports, check.HasLen, 2)
+	c.Check(s.reports[1]["policy-violations"], check.IsNil)
+}
+
+func (s *reportSuite) TestReportSentInBackground(c *check.C) {
+	unblock := make(chan struct{})
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-unblock
+	}))
+	defer server.Close()
+	s.configure(c, map[string]interface{}{
+		"health-report.url": server.URL + "/report",
+	})
+	m := s.manager()
+	s.ensure(c, m)
+
+	s.now = s.now.Add(24 * time.Hour)
+	// Ensure does not wait for the endpoint
+	c.Assert(m.Ensure(), check.IsNil)
+	<-received
+	// and does nothing more while the report is being sent
+	s.now = s.now.Add(time.Minute)
+	c.Assert(m.Ensure(), check.IsNil)
+
+	close(unblock)
+	m.Wait()
+	s.state.Lock()
+	var rs struct {
+		Pending struct {
+			From time.Time `json:"from"`
+		} `json:"pending"`
+	}
+	c.Assert(s.state.Get("health-report", &rs), check.IsNil)
+	s.state.Unlock()
+	// a new batch started where the sent report ended
+	c.Check(rs.Pending.From.Equal(s.now.Add(-time.Minute)), check.Equals, true)
+}
+
+func (s *reportSuite) TestReportTimeout(c *check.C) {
+	restore := healthstate.MockReportTimeout(10 * time.Millisecond)
+	defer restore()
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	// the handler must return before the server can be closed
+	defer close(unblock)
+	s.configure(c, map[string]interface{}{
+		"health-report.url": server.URL + "/report",
+	})
+	m := s.manager()
+	s.ensure(c, m)
+
+	s.now = s.now.Add(24 * time.Hour)
+	s.ensure(c, m)
+
+	s.state.Lock()
+	var rs struct {
+		Failures int `json:"failures"`
+	}
+	c.Assert(s.state.Get("health-report", &rs), check.IsNil)
+	s.state.Unlock()
+	c.Check(rs.Failures, check.Equals, 1)
+}
+
+func (s *reportSuite) TestReportStop(c *check.C) {
+	unblock := make(chan struct{})
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+	s.configure(c, map[string]interface{}{
+		"health-report.url": server.URL + "/report",
+	})
+	m := s.manager()
+	s.ensure(c, m)
+
+	s.now = s.now.Add(24 * time.Hour)
+	c.Assert(m.Ensure(), check.IsNil)
+	<-received
+	m.Stop()
+
+	// the report is kept to be sent again, without counting a failure
+	s.state.Lock()
+	var rs struct {
+		Pending  *healthstate.Report `json:"pending"`
+		Failures int                 `json:"failures"`
+	}
+	c.Assert(s.state.Get("health-report", &rs), check.IsNil)
+	s.state.Unlock()
+	c.Check(rs.Pending, check.NotNil)
+	c.Check(rs.Failures, check.Equals, 0)
+}
+
+func (s *reportSuite) TestReportBackoff(c *check.C) {
+	s.configure(c, map[string]interface{}{
+		"health-report.url": s.server.URL + "/report",
+	})
+	m := s.manager()
+	s.ensure(c, m)
+	s.now = s.now.Add(time.Minute)
+	s.addChange("remove-snap", state.ErrorStatus, nil)
+
+	s.status = 500
+	s.now = s.now.Add(24 * time.Hour)
+	s.ensure(c, m)
+	c.Check(s.reports, check.HasLen, 0)
+
+	s.state.Lock()
+	var rs struct {
+		Failures int       `json:"failures"`
+		RetryAt  time.Time `json:"retry-at"`
+	}
+	c.Assert(s.state.Get("health-report", &rs), check.IsNil)
+	s.state.Unlock()
+	c.Check(rs.Failures, check.Equals, 1)
+	c.Check(rs.RetryAt.Equal(s.now.Add(5*time.Minute)), check.Equals, true)
+
+	// not retried before the backoff expired
+	s.status = 200
+	s.now = s.now.Add(4 * time.Minute)
+	s.ensure(c, m)
+	c.Check(s.reports, check.HasLen, 0)
+
+	s.status = 500
+	s.now = s.now.Add(time.Minute)
+	s.ensure(c, m)
+	s.state.Lock()
+	c.Assert(s.state.Get("health-report", &rs), check.IsNil)
+	s.state.Unlock()
+	c.Check(rs.Failures, check.Equals, 2)
+	c.Check(rs.RetryAt.Equal(s.now.Add(10*time.Minute)), check.Equals, true)
+
+	// the batched content is sent once the endpoint recovers
+	s.status = 200
+	s.now = s.now.Add(10 * time.Minute)
+	s.ensure(c, m)
+	c.Assert(s.reports, check.HasLen, 1)
+	c.Check(s.reports[0]["failed-changes"], check.HasLen, 1)
+}
+
+func (s *reportSuite) TestReportNoModelYet(c *check.C) {
+	s.configure(c, map[string]interface{}{
+		"health-report.url": s.server.URL + "/report",
+	})
+	s.model = nil
+	m := s.manager()
+	s.ensure(c, m)
+	s.now = s.now.Add(24 * time.Hour)
+	s.ensure(c, m)
+	c.Check(s.reports, check.HasLen, 0)
+}
+
+func (s *reportSuite) TestReportDisablingDropsBatch(c *check.C) {
+	s.configure(c, map[string]interface{}{
+		"health-report.url": s.server.URL + "/report",
+	})
+	m := s.manager()
+	s.ensure(c, m)
+
+	s.configure(c, map[string]interface{}{
+		"health-report.url": "",
+	})
+	s.ensure(c, m)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var rs map[string]interface{}
+	c.Check(s.state.Get("health-report", &rs), check.Equals, state.ErrNoState)
+}
@@ -113,10 +113,13 @@ func (m *InterfaceManager) doSetupProfiles(task *state.Task, tomb *tomb.Tomb) er
 	}
 
 	opts := confinementOptions(snapsup.Flags)
-	return m.setupProfilesForSnap(task, tomb, snapInfo, opts, perfTimings)
+	return m.setupProfilesForSnap(task, tomb, snapInfo, opts, snapsup, perfTimings)
 }
 
-func (m *InterfaceManager) setupProfilesForSnap(task *state.Task, _ *tomb.Tomb, snapInfo *snap.Info, opts interfaces.ConfinementOptions, tm timings.Measurer) error {
+// setupProfilesForSnap sets up the security of the snap and of the snaps
+// affected by its connections. If snapsup is not nil, the changes to the
+// security policy of the snap are reviewed first.
+func (m *InterfaceManager) setupProfilesForSnap(task *state.Task, _ *tomb.Tomb, snapInfo *snap.Info, opts interfaces.ConfinementOptions, snapsup *snapstate.SnapSetup, tm timings.Measurer) error {
 	st := task.State()
 
 	if err := addImplicitSlots(task.State(), snapInfo); err != nil {
@@ -162,6 +165,18 @@ func (m *InterfaceManager) setupProfilesForSnap(task *state.Task, _ *tomb.Tomb,
 	if err != nil {
 		return err
 	}
+
+	if snapsup != nil {
+		if err := m.reviewPolicyChanges(task, snapsup, snapInfo, opts); err != nil {
+			// nothing was set up yet, only the repository needs to
+			// go back to the current revision
+			if rerr := m.restoreCurrentSnapInRepo(st, snapName); rerr != nil {
+				task.Errorf("cannot restore the current revision of snap %q in the interfaces repository: %v", snapName, rerr)
+			}
+			return err
+		}
+	}
+
 	affectedSet := make(map[string]bool)
 	for _, name := range disconnectedSnaps {
 		affectedSet[name] = true
@@ -209,6 +224,33 @@ func (m *InterfaceManager) setupProfilesForSnap(task *state.Task, _ *tomb.Tomb,
 	return m.setupSecurityByBackend(task, affectedSnaps, confinementOpts, tm)
 }
 
+// restoreCurrentSnapInRepo puts the current revision of the snap, along with
+// its connections, back in the interfaces repository.
+func (m *InterfaceManager) restoreCurrentSnapInRepo(st *state.State, snapName string) error {
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, snapName, &snapst); err != nil {
+		return err
+	}
+	snapInfo, err := snapst.CurrentInfo()
+	if err != nil {
+		return err
+	}
+	if err := addImplicitSlots(st, snapInfo); err != nil {
+		return err
+	}
+	if _, err := m.repo.DisconnectSnap(snapName); err != nil {
+		return err
+	}
+	if err := m.repo.RemoveSnap(snapName); err != nil {
+		return err
+	}
+	if err := m.repo.AddSnap(snapInfo); err != nil {
+		return err
+	}
+	_, err = m.reloadConnections(snapName)
+	return err
+}
+
 func (m *InterfaceManager) doRemoveProfiles(task *state.Task, tomb *tomb.Tomb) error {
 	st := task.State()
 	st.Lock()
@@ -295,7 +337,7 @@ func (m *InterfaceManager) undoSetupProfiles(task *state.Task, tomb *tomb.Tomb)
 			return err
 		}
 		opts := confinementOptions(snapst.Flags)
-		return m.setupProfilesForSnap(task, tomb, snapInfo, opts, perfTimings)
+		return m.setupProfilesForSnap(task, tomb, snapInfo, opts, nil, perfTimings)
 	}
 }
 
@@ -560,6 +602,15 @@ func (m *InterfaceManager) doDisconnect(task *state.Task, _ *tomb.Tomb) error {
 		var snapst snapstate.SnapState
 		if err := snapstate.Get(st, instanceName, &snapst); err != nil {
 			if err == state.ErrNoState {
+				if conn, ok := conns[cref.ID()]; ok && forget {
+					// a stale connection of a snap that is gone
+					// entirely, just drop it from the state
+					task.Logf("forgetting connection %s %s, snap %q doesn't exist", plugRef, slotRef, instanceName)
+					task.Set("old-conn", conn)
+					delete(conns, cref.ID())
+					setConns(st, conns)
+					return nil
+				}
 				task.Logf("skipping disconnect operation for connection %s %s, snap %q doesn't exist", plugRef, slotRef, instanceName)
 				return nil
 			}
@@ -668,15 +719,6 @@ func (m *InterfaceManager) undoDisconnect(task *state.Task, _ *tomb.Tomb) error
 		return err
 	}
 
-	var plugSnapst snapstate.SnapState
-	if err := snapstate.Get(st, plugRef.Snap, &plugSnapst); err != nil {
-		return err
-	}
-	var slotSnapst snapstate.SnapState
-	if err := snapstate.Get(st, slotRef.Snap, &slotSnapst); err != nil {
-		return err
-	}
-
 	connRef := &interfaces.ConnRef{PlugRef: plugRef, SlotRef: slotRef}
 
 	plug := m.repo.Plug(connRef.PlugRef.Snap, connRef.PlugRef.Name)
@@ -689,6 +731,15 @@ func (m *InterfaceManager) undoDisconnect(task *state.Task, _ *tomb.Tomb) error
 		setConns(st, conns)
 		return nil
 	}
+
+	var plugSnapst snapstate.SnapState
+	if err := snapstate.Get(st, plugRef.Snap, &plugSnapst); err != nil {
+		return err
+	}
+	var slotSnapst snapstate.SnapState
+	if err := snapstate.Get(st, slotRef.Snap, &slotSnapst); err != nil {
+		return err
+	}
 	if plug == nil {
 		return fmt.Errorf("snap %q has no %q plug", connRef.PlugRef.Snap, connRef.PlugRef.Name)
 	}
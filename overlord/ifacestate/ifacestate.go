@@ -32,6 +32,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/policy"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
@@ -88,12 +89,80 @@ type connectOpts struct {
 
 // Connect returns a set of tasks for connecting an interface.
 //
+// If the plug holds an exclusive role of its interface, the connections of
+// other snaps holding that role on the same slot are disconnected first.
 func Connect(st *state.State, plugSnap, plugName, slotSnap, slotName string) (*state.TaskSet, error) {
-	if err := snapstate.CheckChangeConflictMany(st, []string{plugSnap, slotSnap}, ""); err != nil {
+	handover, err := exclusiveRoleHandover(ifacerepo.Get(st), plugSnap, plugName, slotSnap, slotName)
+	if err != nil {
+		return nil, err
+	}
+	snapNames := []string{plugSnap, slotSnap}
+	for _, conn := range handover {
+		snapNames = append(snapNames, conn.Plug.Snap().InstanceName())
+	}
+	if err := snapstate.CheckChangeConflictMany(st, snapNames, ""); err != nil {
+		return nil, err
+	}
+
+	ts, err := connect(st, plugSnap, plugName, slotSnap, slotName, connectOpts{})
+	if err != nil || len(handover) == 0 {
+		return ts, err
+	}
+
+	// hand the exclusive role over by disconnecting its current holders
+	// before the new connection is made
+	handoverTs := state.NewTaskSet()
+	for _, conn := range handover {
+		disconnectTs, err := disconnectTasks(st, conn, disconnectOpts{})
+		if err != nil {
+			return nil, err
+		}
+		handoverTs.AddAll(disconnectTs)
+	}
+	ts.WaitAll(handoverTs)
+	if err := handoverTs.AddAllWithEdges(ts); err != nil {
 		return nil, err
 	}
+	return handoverTs, nil
+}
 
-	return connect(st, plugSnap, plugName, slotSnap, slotName, connectOpts{})
+// exclusiveRoleHandover returns the connections of other snaps to the given
+// slot which hold the exclusive role requested by the plug, if its interface
+// has such a role.
+func exclusiveRoleHandover(repo *interfaces.Repository, plugSnap, plugName, slotSnap, slotName string) ([]*interfaces.Connection, error) {
+	plug := repo.Plug(plugSnap, plugName)
+	if plug == nil {
+		// connect reports missing plugs
+		return nil, nil
+	}
+	holder, ok := repo.Interface(plug.Interface).(interfaces.ExclusiveRoleHolder)
+	if !ok || !holder.HoldsExclusiveRole(plug) {
+		return nil, nil
+	}
+	if repo.Slot(slotSnap, slotName) == nil {
+		// connect reports missing slots
+		return nil, nil
+	}
+	connRefs, err := repo.Connected(slotSnap, slotName)
+	if err != nil {
+		return nil, err
+	}
+	var conns []*interfaces.Connection
+	for _, connRef := range connRefs {
+		if connRef.PlugRef.Snap == plugSnap {
+			continue
+		}
+		other := repo.Plug(connRef.PlugRef.Snap, connRef.PlugRef.Name)
+		if other == nil || !holder.HoldsExclusiveRole(other) {
+			continue
+		}
+		conn, err := repo.Connection(connRef)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
 }
 
 func connect(st *state.State, plugSnap, plugName, slotSnap, slotName string, flags connectOpts) (*state.TaskSet, error) {
@@ -897,6 +897,8 @@ func (s *interfaceManagerSuite) TestConnectAlreadyConnected(c *C) {
 }
 
 func (s *interfaceManagerSuite) testConnectDisconnectConflicts(c *C, f func(*state.State, string, string, string, string) (*state.TaskSet, error), snapName string, otherTaskKind string, expectedErr string) {
+	_ = s.manager(c)
+
 	s.state.Lock()
 	defer s.state.Unlock()
 
@@ -961,6 +963,7 @@ func (s *interfaceManagerSuite) TestConnectDoesConflict(c *C) {
 	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
 	s.mockSnap(c, consumerYaml)
 	s.mockSnap(c, producerYaml)
+	_ = s.manager(c)
 
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -986,6 +989,7 @@ func (s *interfaceManagerSuite) TestConnectBecomeOperationalNoConflict(c *C) {
 	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
 	s.mockSnap(c, consumerYaml)
 	s.mockSnap(c, producerYaml)
+	_ = s.manager(c)
 
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1422,6 +1426,146 @@ slots:
 	check(change)
 }
 
+type exclusiveRoleTestInterface struct {
+	ifacetest.TestInterface
+}
+
+func (iface *exclusiveRoleTestInterface) HoldsExclusiveRole(plug *snap.PlugInfo) bool {
+	var role string
+	_ = plug.Attr("role", &role)
+	return role == "owner"
+}
+
+const exclusiveOwner1Yaml = `
+name: owner1
+version: 1
+plugs:
+ plug:
+  interface: test
+  role: owner
+`
+
+const exclusiveOwner2Yaml = `
+name: owner2
+version: 1
+plugs:
+ plug:
+  interface: test
+  role: owner
+`
+
+func (s *interfaceManagerSuite) TestConnectExclusiveRoleHandover(c *C) {
+	s.MockModel(c, nil)
+	s.mockIfaces(c, &exclusiveRoleTestInterface{ifacetest.TestInterface{InterfaceName: "test"}}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, exclusiveOwner1Yaml)
+	s.mockSnap(c, exclusiveOwner2Yaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test"},
+		"owner1:plug producer:slot":   map[string]interface{}{"interface": "test"},
+	})
+	s.state.Unlock()
+
+	mgr := s.manager(c)
+
+	s.state.Lock()
+	change := s.state.NewChange("connect", "...")
+	ts, err := ifacestate.Connect(s.state, "owner2", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+
+	// the current owner is disconnected before the new one is connected
+	var disconnectTask, connectTask *state.Task
+	for _, t := range ts.Tasks() {
+		switch t.Kind() {
+		case "disconnect":
+			c.Assert(disconnectTask, IsNil)
+			disconnectTask = t
+		case "connect":
+			connectTask = t
+		}
+	}
+	c.Assert(disconnectTask, NotNil)
+	c.Assert(connectTask, NotNil)
+	var plugRef interfaces.PlugRef
+	c.Assert(disconnectTask.Get("plug", &plugRef), IsNil)
+	c.Check(plugRef, Equals, interfaces.PlugRef{Snap: "owner1", Name: "plug"})
+	c.Check(connectTask.WaitTasks(), testutil.Contains, disconnectTask)
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	c.Check(change.Status(), Equals, state.DoneStatus)
+	c.Check(disconnectTask.Status(), Equals, state.DoneStatus)
+
+	// clients stay connected, the owner is replaced
+	c.Check(mgr.Repository().Interfaces().Connections, DeepEquals, []*interfaces.ConnRef{
+		{PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"}, SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"}},
+		{PlugRef: interfaces.PlugRef{Snap: "owner2", Name: "plug"}, SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"}},
+	})
+}
+
+func (s *interfaceManagerSuite) TestConnectExclusiveRoleNoHandoverForClients(c *C) {
+	s.mockIfaces(c, &exclusiveRoleTestInterface{ifacetest.TestInterface{InterfaceName: "test"}}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, exclusiveOwner1Yaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"owner1:plug producer:slot": map[string]interface{}{"interface": "test"},
+	})
+	s.state.Unlock()
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	for _, t := range ts.Tasks() {
+		c.Check(t.Kind(), Not(Equals), "disconnect")
+	}
+}
+
+func (s *interfaceManagerSuite) TestConnectExclusiveRoleHandoverConflict(c *C) {
+	s.mockIfaces(c, &exclusiveRoleTestInterface{ifacetest.TestInterface{InterfaceName: "test"}}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, exclusiveOwner1Yaml)
+	s.mockSnap(c, exclusiveOwner2Yaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"owner1:plug producer:slot": map[string]interface{}{"interface": "test"},
+	})
+	s.state.Unlock()
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the current owner being busy blocks the handover
+	chg := s.state.NewChange("other-chg", "...")
+	t := s.state.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{RealName: "owner1"},
+	})
+	chg.AddTask(t)
+
+	_, err := ifacestate.Connect(s.state, "owner2", "plug", "producer", "slot")
+	c.Assert(err, FitsTypeOf, &snapstate.ChangeConflictError{})
+	c.Check(err, ErrorMatches, `snap "owner1" has "other-chg" change in progress`)
+}
+
 func (s *interfaceManagerSuite) TestConnectTaskCheckDeviceScopeNoStore(c *C) {
 	s.MockModel(c, nil)
 
@@ -1955,6 +2099,58 @@ func (s *interfaceManagerSuite) testForget(c *C, plugSnap, plugName, slotSnap, s
 	c.Check(change.Status(), Equals, state.DoneStatus)
 }
 
+func (s *interfaceManagerSuite) testForgetConnectionOfMissingSnap(c *C, undo bool) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+
+	mgr := s.manager(c)
+
+	// snap "gone" is not installed at all, stale connections like this
+	// one are only dropped when the manager starts
+	connState := map[string]interface{}{
+		"consumer:plug gone:slot": map[string]interface{}{"interface": "test"},
+	}
+	s.state.Lock()
+	s.state.Set("conns", connState)
+	change := s.state.NewChange("disconnect", "...")
+	ts, err := ifacestate.Forget(s.state, mgr.Repository(), &interfaces.ConnRef{
+		PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+		SlotRef: interfaces.SlotRef{Snap: "gone", Name: "slot"}})
+	c.Assert(err, IsNil)
+	change.AddAll(ts)
+	if undo {
+		terr := s.state.NewTask("error-trigger", "provoking total undo")
+		terr.WaitAll(ts)
+		change.AddTask(terr)
+	}
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var conns map[string]interface{}
+	err = s.state.Get("conns", &conns)
+	if undo {
+		c.Assert(change.Status(), Equals, state.ErrorStatus)
+		c.Assert(err, IsNil)
+		c.Check(conns, DeepEquals, connState)
+	} else {
+		c.Assert(change.Err(), IsNil)
+		c.Assert(err, IsNil)
+		c.Check(conns, HasLen, 0)
+	}
+}
+
+func (s *interfaceManagerSuite) TestForgetConnectionOfMissingSnap(c *C) {
+	s.testForgetConnectionOfMissingSnap(c, false)
+}
+
+func (s *interfaceManagerSuite) TestForgetConnectionOfMissingSnapUndo(c *C) {
+	s.testForgetConnectionOfMissingSnap(c, true)
+}
+
 func (s *interfaceManagerSuite) TestForgetInactiveConnection(c *C) {
 	// forget inactive connection, that means it's not in the repository,
 	// only in the state.
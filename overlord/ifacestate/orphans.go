@@ -0,0 +1,235 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// Reasons why a connection is orphaned.
+const (
+	// OrphanPlugMissing is used for connections whose plug is gone, e.g.
+	// because the current revision of the snap no longer declares it.
+	OrphanPlugMissing = "plug-missing"
+	// OrphanSlotMissing is used for connections whose slot is gone.
+	OrphanSlotMissing = "slot-missing"
+	// OrphanHotplugGone is used for connections of hotplug slots whose
+	// device was removed.
+	OrphanHotplugGone = "hotplug-gone"
+)
+
+// OrphanedConnection is a connection recorded in the state that is not
+// established because its plug or slot is gone.
+type OrphanedConnection struct {
+	ConnRef   *interfaces.ConnRef
+	Interface string
+	Reason    string
+	// Rebind is the slot the connection can be moved to, it is set for
+	// connections of hotplug slots whose device is present again but
+	// under a different slot.
+	Rebind *interfaces.SlotRef
+}
+
+// OrphanedConnections returns the connections recorded in the state that
+// refer to plugs or slots missing from the repository, or to hotplug
+// devices that are gone, sorted by connection. Connections that were
+// explicitly disconnected are not considered, nor are those of snaps that
+// are installed but disabled and whose current revision still declares the
+// plug or slot, as they are restored when the snap is enabled again.
+// The state must be locked by the caller.
+func OrphanedConnections(st *state.State, repo *interfaces.Repository) ([]*OrphanedConnection, error) {
+	conns, err := getConns(st)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*OrphanedConnection
+	for id, cstate := range conns {
+		if cstate.Undesired {
+			continue
+		}
+		cref, err := interfaces.ParseConnRef(id)
+		if err != nil {
+			return nil, err
+		}
+		orphan := &OrphanedConnection{
+			ConnRef:   cref,
+			Interface: cstate.Interface,
+		}
+		switch {
+		case cstate.HotplugGone:
+			orphan.Reason = OrphanHotplugGone
+			slot, err := repo.SlotForHotplugKey(cstate.Interface, cstate.HotplugKey)
+			if err != nil {
+				return nil, err
+			}
+			if slot != nil && slot.Name != cref.SlotRef.Name {
+				orphan.Rebind = &interfaces.SlotRef{Snap: slot.Snap.InstanceName(), Name: slot.Name}
+			}
+		case repo.Plug(cref.PlugRef.Snap, cref.PlugRef.Name) == nil:
+			orphan.Reason = OrphanPlugMissing
+		case repo.Slot(cref.SlotRef.Snap, cref.SlotRef.Name) == nil:
+			orphan.Reason = OrphanSlotMissing
+		default:
+			continue
+		}
+		if orphan.Reason != OrphanHotplugGone {
+			present, err := presentInDisabledSnaps(st, repo, cref)
+			if err != nil {
+				return nil, err
+			}
+			if present {
+				continue
+			}
+		}
+		orphans = append(orphans, orphan)
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].ConnRef.SortsBefore(orphans[j].ConnRef)
+	})
+	return orphans, nil
+}
+
+// presentInDisabledSnaps returns whether the plug and slot of the given
+// connection that are missing from the repository are declared by the
+// current revision of snaps that are installed but disabled, as disabled
+// snaps are not added to the repository.
+func presentInDisabledSnaps(st *state.State, repo *interfaces.Repository, cref *interfaces.ConnRef) (bool, error) {
+	if repo.Plug(cref.PlugRef.Snap, cref.PlugRef.Name) == nil {
+		info, err := disabledSnapInfo(st, cref.PlugRef.Snap)
+		if err != nil || info == nil {
+			return false, err
+		}
+		if info.Plugs[cref.PlugRef.Name] == nil {
+			return false, nil
+		}
+	}
+	if repo.Slot(cref.SlotRef.Snap, cref.SlotRef.Name) == nil {
+		info, err := disabledSnapInfo(st, cref.SlotRef.Snap)
+		if err != nil || info == nil {
+			return false, err
+		}
+		if info.Slots[cref.SlotRef.Name] == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// disabledSnapInfo returns the current revision of the given snap if it is
+// installed but disabled, or nil otherwise.
+func disabledSnapInfo(st *state.State, instanceName string) (*snap.Info, error) {
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, instanceName, &snapst); err != nil {
+		if err == state.ErrNoState {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if snapst.Active {
+		return nil, nil
+	}
+	return snapst.CurrentInfo()
+}
+
+// RepairConnections returns the task sets repairing the given orphaned
+// connections. Connections that can be rebound to a new hotplug slot are
+// moved to it and recreated, all the others are forgotten.
+func RepairConnections(st *state.State, repo *interfaces.Repository, orphans []*OrphanedConnection) ([]*state.TaskSet, error) {
+	var tss []*state.TaskSet
+	for _, orphan := range orphans {
+		if orphan.Rebind == nil {
+			ts, err := Forget(st, repo, orphan.ConnRef)
+			if err != nil {
+				return nil, err
+			}
+			tss = append(tss, ts)
+			continue
+		}
+
+		plugRef, slotRef := orphan.ConnRef.PlugRef, orphan.ConnRef.SlotRef
+		if err := snapstate.CheckChangeConflictMany(st, []string{plugRef.Snap, slotRef.Snap}, ""); err != nil {
+			return nil, err
+		}
+		slot := repo.Slot(orphan.Rebind.Snap, orphan.Rebind.Name)
+		if slot == nil {
+			return nil, fmt.Errorf("snap %q has no slot named %q", orphan.Rebind.Snap, orphan.Rebind.Name)
+		}
+		rebind := st.NewTask("rebind-connection", fmt.Sprintf(i18n.G("Rebind connection %s:%s from %s:%s to %s:%s"),
+			plugRef.Snap, plugRef.Name, slotRef.Snap, slotRef.Name, orphan.Rebind.Snap, orphan.Rebind.Name))
+		rebind.Set("plug", plugRef)
+		rebind.Set("slot", slotRef)
+		rebind.Set("new-slot", *orphan.Rebind)
+		hotplugConnect := st.NewTask("hotplug-connect", fmt.Sprintf("Recreate connections of interface %q for hotplug key %q", slot.Interface, slot.HotplugKey.ShortString()))
+		setHotplugAttrs(hotplugConnect, slot.Interface, slot.HotplugKey)
+		hotplugConnect.WaitFor(rebind)
+		tss = append(tss, state.NewTaskSet(rebind, hotplugConnect))
+	}
+	return tss, nil
+}
+
+// doRebindConnection moves a connection of a hotplug slot whose device is
+// gone to the slot the device is now present as, so that the connection is
+// recreated by the following hotplug-connect task.
+func (m *InterfaceManager) doRebindConnection(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var plugRef interfaces.PlugRef
+	var slotRef, newSlotRef interfaces.SlotRef
+	if err := task.Get("plug", &plugRef); err != nil {
+		return err
+	}
+	if err := task.Get("slot", &slotRef); err != nil {
+		return err
+	}
+	if err := task.Get("new-slot", &newSlotRef); err != nil {
+		return err
+	}
+
+	conns, err := getConns(st)
+	if err != nil {
+		return err
+	}
+	oldRef := &interfaces.ConnRef{PlugRef: plugRef, SlotRef: slotRef}
+	newRef := &interfaces.ConnRef{PlugRef: plugRef, SlotRef: newSlotRef}
+	oldID, newID := oldRef.ID(), newRef.ID()
+	conn, ok := conns[oldID]
+	if !ok {
+		task.Logf("connection %q is gone, nothing to rebind", oldID)
+		return nil
+	}
+	if _, ok := conns[newID]; !ok {
+		conns[newID] = conn
+	}
+	delete(conns, oldID)
+	setConns(st, conns)
+	return nil
+}
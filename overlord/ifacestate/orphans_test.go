@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *interfaceManagerSuite) mockOrphanedConnections(c *C) *interfaces.Repository {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, coreSnapYaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot":      map[string]interface{}{"interface": "test"},
+		"consumer:plug2 producer:slot":     map[string]interface{}{"interface": "test"},
+		"consumer:otherplug producer:gone": map[string]interface{}{"interface": "test2"},
+		"consumer:plug producer:gone":      map[string]interface{}{"interface": "test", "undesired": true},
+		"consumer:plug core:hotplug-old": map[string]interface{}{
+			"interface": "test", "hotplug-key": "key-1", "hotplug-gone": true},
+		"consumer:plug core:hotplug-other": map[string]interface{}{
+			"interface": "test", "hotplug-key": "key-2", "hotplug-gone": true},
+	})
+	// the device with key-1 came back under a new slot
+	s.state.Set("hotplug-slots", map[string]interface{}{
+		"hotplug-new": map[string]interface{}{
+			"name":        "hotplug-new",
+			"interface":   "test",
+			"hotplug-key": "key-1",
+		}})
+	s.state.Unlock()
+
+	repo := s.manager(c).Repository()
+	c.Assert(repo.Slot("core", "hotplug-new"), NotNil)
+	return repo
+}
+
+func (s *interfaceManagerSuite) TestOrphanedConnections(c *C) {
+	repo := s.mockOrphanedConnections(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	orphans, err := ifacestate.OrphanedConnections(s.state, repo)
+	c.Assert(err, IsNil)
+	c.Check(orphans, DeepEquals, []*ifacestate.OrphanedConnection{
+		{
+			ConnRef: &interfaces.ConnRef{
+				PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "otherplug"},
+				SlotRef: interfaces.SlotRef{Snap: "producer", Name: "gone"}},
+			Interface: "test2",
+			Reason:    ifacestate.OrphanSlotMissing,
+		}, {
+			ConnRef: &interfaces.ConnRef{
+				PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+				SlotRef: interfaces.SlotRef{Snap: "core", Name: "hotplug-old"}},
+			Interface: "test",
+			Reason:    ifacestate.OrphanHotplugGone,
+			Rebind:    &interfaces.SlotRef{Snap: "core", Name: "hotplug-new"},
+		}, {
+			ConnRef: &interfaces.ConnRef{
+				PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+				SlotRef: interfaces.SlotRef{Snap: "core", Name: "hotplug-other"}},
+			Interface: "test",
+			Reason:    ifacestate.OrphanHotplugGone,
+		}, {
+			ConnRef: &interfaces.ConnRef{
+				PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug2"},
+				SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"}},
+			Interface: "test",
+			Reason:    ifacestate.OrphanPlugMissing,
+		},
+	})
+}
+
+func (s *interfaceManagerSuite) TestOrphanedConnectionsDisabledSnap(c *C) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	s.state.Lock()
+	// the producer is disabled and so is not in the repository
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(s.state, "producer", &snapst), IsNil)
+	snapst.Active = false
+	snapstate.Set(s.state, "producer", &snapst)
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot":      map[string]interface{}{"interface": "test"},
+		"consumer:otherplug producer:gone": map[string]interface{}{"interface": "test2"},
+	})
+	s.state.Unlock()
+
+	repo := s.manager(c).Repository()
+	c.Assert(repo.Slot("producer", "slot"), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// only the connection to a slot the producer does not declare is
+	// orphaned
+	orphans, err := ifacestate.OrphanedConnections(s.state, repo)
+	c.Assert(err, IsNil)
+	c.Check(orphans, DeepEquals, []*ifacestate.OrphanedConnection{
+		{
+			ConnRef: &interfaces.ConnRef{
+				PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "otherplug"},
+				SlotRef: interfaces.SlotRef{Snap: "producer", Name: "gone"}},
+			Interface: "test2",
+			Reason:    ifacestate.OrphanSlotMissing,
+		},
+	})
+}
+
+func (s *interfaceManagerSuite) TestRepairConnections(c *C) {
+	s.MockModel(c, nil)
+	repo := s.mockOrphanedConnections(c)
+
+	s.state.Lock()
+	orphans, err := ifacestate.OrphanedConnections(s.state, repo)
+	c.Assert(err, IsNil)
+	c.Assert(orphans, HasLen, 4)
+	tss, err := ifacestate.RepairConnections(s.state, repo, orphans)
+	c.Assert(err, IsNil)
+	c.Assert(tss, HasLen, 4)
+	chg := s.state.NewChange("repair-connections", "...")
+	for _, ts := range tss {
+		ts.JoinLane(s.state.NewLane())
+		chg.AddAll(ts)
+	}
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+
+	var conns map[string]interface{}
+	c.Assert(s.state.Get("conns", &conns), IsNil)
+	c.Check(conns, HasLen, 3)
+	c.Check(conns["consumer:plug producer:slot"], NotNil)
+	c.Check(conns["consumer:plug producer:gone"], NotNil)
+	c.Check(conns["consumer:plug core:hotplug-new"], NotNil)
+
+	orphans, err = ifacestate.OrphanedConnections(s.state, repo)
+	c.Assert(err, IsNil)
+	c.Check(orphans, HasLen, 0)
+}
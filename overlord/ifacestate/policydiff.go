@@ -0,0 +1,307 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// ProfileDiff describes the changes to a security profile of a snap.
+type ProfileDiff struct {
+	Path string `json:"path"`
+	// Diff holds the removed and the added lines of the profile,
+	// prefixed with "-" and "+" respectively.
+	Diff string `json:"diff"`
+}
+
+// PolicyDiff describes the changes to the security policy of a snap
+// brought by a refresh.
+type PolicyDiff struct {
+	OldRevision snap.Revision `json:"old-revision"`
+	NewRevision snap.Revision `json:"new-revision"`
+	Profiles    []ProfileDiff `json:"profiles"`
+	// HighRisk describes the changes granting high-risk permissions.
+	HighRisk []string `json:"high-risk,omitempty"`
+}
+
+// the maximum number of lines compared with each other when diffing a
+// profile, beyond which the whole profile is reported as replaced
+const maxDiffCells = 4 * 1024 * 1024
+
+// the apparmor rules and seccomp syscalls which are considered high-risk
+// when added to the policy of a snap
+var (
+	highRiskAppArmorRules = []string{
+		"capability",
+		"change_profile",
+		"mount",
+		"pivot_root",
+		"ptrace",
+		"remount",
+		"umount",
+	}
+	highRiskSyscalls = map[string]bool{
+		"@complain":       true,
+		"@unrestricted":   true,
+		"bpf":             true,
+		"delete_module":   true,
+		"finit_module":    true,
+		"init_module":     true,
+		"kexec_file_load": true,
+		"kexec_load":      true,
+		"mount":           true,
+		"pivot_root":      true,
+		"ptrace":          true,
+		"reboot":          true,
+		"umount":          true,
+		"umount2":         true,
+	}
+)
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// diffLines returns the lines removed from and added to old to obtain new,
+// prefixed with "-" and "+" respectively.
+func diffLines(old, new []byte) []string {
+	a, b := splitLines(old), splitLines(new)
+
+	// only the middle part which differs is compared
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	a, b = a[start:endA], b[start:endB]
+
+	var diff []string
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			diff = append(diff, "-"+l)
+		}
+		for _, l := range b {
+			diff = append(diff, "+"+l)
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+	return diff
+}
+
+// highRiskChange returns a description of the added line of the profile at
+// the given path if it grants a high-risk permission.
+func highRiskChange(path, added string) string {
+	rule := strings.TrimSpace(added)
+	if rule == "" || strings.HasPrefix(rule, "#") {
+		return ""
+	}
+	profile := filepath.Base(path)
+	switch filepath.Dir(path) {
+	case dirs.SnapAppArmorDir:
+		for _, prefix := range highRiskAppArmorRules {
+			if rule == prefix || strings.HasPrefix(rule, prefix+" ") || strings.HasPrefix(rule, prefix+",") {
+				return fmt.Sprintf("%s: %s", profile, rule)
+			}
+		}
+		if strings.Contains(rule, "flags=") && strings.Contains(rule, "complain") {
+			return fmt.Sprintf("%s: complain mode", profile)
+		}
+	case dirs.SnapSeccompDir:
+		if highRiskSyscalls[strings.Fields(rule)[0]] {
+			return fmt.Sprintf("%s: %s", profile, rule)
+		}
+	}
+	return ""
+}
+
+// computePolicyDiff returns the changes to the security profiles of the
+// snap which setting up its security would bring, using the backends which
+// support previewing their setup.
+func (m *InterfaceManager) computePolicyDiff(snapInfo *snap.Info, opts interfaces.ConfinementOptions) (*PolicyDiff, error) {
+	current := make(map[string][]byte)
+	proposed := make(map[string][]byte)
+	for _, backend := range m.repo.Backends() {
+		previewer, ok := backend.(interfaces.SecurityBackendPreview)
+		if !ok {
+			continue
+		}
+		cur, prop, err := previewer.PreviewSetup(snapInfo, opts, m.repo)
+		if err != nil {
+			return nil, err
+		}
+		for path, content := range cur {
+			current[path] = content
+		}
+		for path, content := range prop {
+			proposed[path] = content
+		}
+	}
+
+	paths := make([]string, 0, len(proposed))
+	for path := range proposed {
+		paths = append(paths, path)
+	}
+	for path := range current {
+		if _, ok := proposed[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	pdiff := &PolicyDiff{NewRevision: snapInfo.Revision}
+	for _, path := range paths {
+		if bytes.Equal(current[path], proposed[path]) {
+			continue
+		}
+		lines := diffLines(current[path], proposed[path])
+		for _, l := range lines {
+			if !strings.HasPrefix(l, "+") {
+				continue
+			}
+			if risk := highRiskChange(path, l[1:]); risk != "" {
+				pdiff.HighRisk = append(pdiff.HighRisk, risk)
+			}
+		}
+		pdiff.Profiles = append(pdiff.Profiles, ProfileDiff{
+			Path: path,
+			Diff: strings.Join(lines, "\n") + "\n",
+		})
+	}
+	return pdiff, nil
+}
+
+// policyApproval returns the policy for approving changes to the security
+// policy of snaps on refresh.
+func policyApproval(st *state.State) (string, error) {
+	var approval string
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "refresh.policy-approval", &approval); err != nil && !config.IsNoOption(err) {
+		return "", err
+	}
+	return approval, nil
+}
+
+// reviewPolicyChanges records the changes to the security policy of a
+// refreshed snap in the change and refuses high-risk changes which were not
+// approved when the system requires so.
+func (m *InterfaceManager) reviewPolicyChanges(task *state.Task, snapsup *snapstate.SnapSetup, snapInfo *snap.Info, opts interfaces.ConfinementOptions) error {
+	st := task.State()
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, snapInfo.InstanceName(), &snapst); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !snapst.IsInstalled() || snapst.Current == snapInfo.Revision {
+		// not a refresh
+		return nil
+	}
+
+	pdiff, err := m.computePolicyDiff(snapInfo, opts)
+	if err != nil {
+		return err
+	}
+	if len(pdiff.Profiles) == 0 {
+		return nil
+	}
+	pdiff.OldRevision = snapst.Current
+
+	chg := task.Change()
+	var pdiffs map[string]*PolicyDiff
+	if err := chg.Get("policy-diffs", &pdiffs); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if pdiffs == nil {
+		pdiffs = make(map[string]*PolicyDiff)
+	}
+	pdiffs[snapInfo.InstanceName()] = pdiff
+	chg.Set("policy-diffs", pdiffs)
+
+	task.Logf("Security policy changes in %d profiles", len(pdiff.Profiles))
+	for _, risk := range pdiff.HighRisk {
+		task.Logf("High-risk security policy change: %s", risk)
+	}
+
+	if len(pdiff.HighRisk) == 0 || snapsup.ApprovePolicyChanges {
+		return nil
+	}
+	approval, err := policyApproval(st)
+	if err != nil {
+		return err
+	}
+	if approval == "high-risk" {
+		return fmt.Errorf("cannot refresh snap %q: high-risk security policy changes need approval, review them with \"snap change --policy-diff %s\" and approve them with \"snap refresh --approve-policy-changes\"", snapInfo.InstanceName(), chg.ID())
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate_test
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type policyDiffSuite struct{}
+
+var _ = Suite(&policyDiffSuite{})
+
+func (s *policyDiffSuite) TestDiffLines(c *C) {
+	for _, t := range []struct {
+		old, new string
+		diff     []string
+	}{
+		{"", "", nil},
+		{"a\nb\n", "a\nb\n", nil},
+		{"", "a\nb\n", []string{"+a", "+b"}},
+		{"a\nb\n", "", []string{"-a", "-b"}},
+		{"a\nb\nc\n", "a\nx\nc\n", []string{"-b", "+x"}},
+		{"a\nb\nc\nd\n", "a\nc\nd\ne\n", []string{"-b", "+e"}},
+		{"a\nb\nc\n", "b\nc\na\n", []string{"-a", "+a"}},
+	} {
+		diff := ifacestate.DiffLines([]byte(t.old), []byte(t.new))
+		c.Check(diff, DeepEquals, t.diff, Commentf("%q -> %q", t.old, t.new))
+	}
+}
+
+// previewSecurityBackend is a test security backend previewing its setup.
+type previewSecurityBackend struct {
+	ifacetest.TestSecurityBackend
+	current, proposed map[string][]byte
+}
+
+func (b *previewSecurityBackend) PreviewSetup(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository) (current, proposed map[string][]byte, err error) {
+	return b.current, b.proposed, nil
+}
+
+func (s *interfaceManagerSuite) testSetupProfilesPolicyDiff(c *C, approval string, flags snapstate.Flags) (*state.Change, *previewSecurityBackend) {
+	s.MockModel(c, nil)
+
+	profile := filepath.Join(dirs.SnapAppArmorDir, "snap.snap.app")
+	unchanged := filepath.Join(dirs.SnapAppArmorDir, "snap-update-ns.snap")
+	seccompProfile := filepath.Join(dirs.SnapSeccompDir, "snap.snap.app.src")
+	backend := &previewSecurityBackend{
+		TestSecurityBackend: ifacetest.TestSecurityBackend{BackendName: "preview"},
+		current: map[string][]byte{
+			profile:        []byte("#include <tunables/global>\n/foo r,\n"),
+			unchanged:      []byte("same\n"),
+			seccompProfile: []byte("read\n"),
+		},
+		proposed: map[string][]byte{
+			profile:        []byte("#include <tunables/global>\ncapability sys_admin,\n/foo rw,\n"),
+			unchanged:      []byte("same\n"),
+			seccompProfile: []byte("read\nmount\n"),
+		},
+	}
+	s.mockSecBackend(c, backend)
+
+	s.mockSnap(c, ubuntuCoreSnapYaml)
+	s.mockSnap(c, sampleSnapYaml)
+	mgr := s.manager(c)
+	// ignore the setup of the snaps on startup
+	backend.SetupCalls = nil
+	newSnapInfo := s.mockUpdatedSnap(c, sampleSnapYaml, 42)
+
+	s.state.Lock()
+	if approval != "" {
+		tr := config.NewTransaction(s.state)
+		tr.Set("core", "refresh.policy-approval", approval)
+		tr.Commit()
+	}
+	s.state.Unlock()
+
+	chg := s.addSetupSnapSecurityChange(c, &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: newSnapInfo.SnapName(),
+			Revision: newSnapInfo.Revision,
+		},
+		Flags: flags,
+	})
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var pdiffs map[string]*ifacestate.PolicyDiff
+	c.Assert(chg.Get("policy-diffs", &pdiffs), IsNil)
+	c.Check(pdiffs, DeepEquals, map[string]*ifacestate.PolicyDiff{
+		"snap": {
+			OldRevision: snap.R(1),
+			NewRevision: snap.R(42),
+			Profiles: []ifacestate.ProfileDiff{
+				{Path: profile, Diff: "-/foo r,\n+capability sys_admin,\n+/foo rw,\n"},
+				{Path: seccompProfile, Diff: "+mount\n"},
+			},
+			HighRisk: []string{
+				"snap.snap.app: capability sys_admin,",
+				"snap.snap.app.src: mount",
+			},
+		},
+	})
+
+	// the plugs of the snap in the repository are from the revision
+	// whose security was set up
+	plug := mgr.Repository().Plug("snap", "network")
+	c.Assert(plug, NotNil)
+	if chg.Status() == state.DoneStatus {
+		c.Check(plug.Snap.Revision, Equals, snap.R(42))
+	} else {
+		c.Check(plug.Snap.Revision, Equals, snap.R(1))
+	}
+
+	return chg, backend
+}
+
+func (s *interfaceManagerSuite) TestSetupProfilesRecordsPolicyDiff(c *C) {
+	chg, backend := s.testSetupProfilesPolicyDiff(c, "", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Assert(backend.SetupCalls, Not(HasLen), 0)
+	c.Check(backend.SetupCalls[0].SnapInfo.Revision, Equals, snap.R(42))
+	c.Check(chg.Tasks()[0].Log(), HasLen, 3)
+}
+
+func (s *interfaceManagerSuite) TestSetupProfilesPolicyDiffNeedsApproval(c *C) {
+	chg, backend := s.testSetupProfilesPolicyDiff(c, "high-risk", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot refresh snap "snap": high-risk security policy changes need approval, review them with "snap change --policy-diff [0-9]+" and approve them with "snap refresh --approve-policy-changes".*`)
+	// nothing was set up
+	c.Check(backend.SetupCalls, HasLen, 0)
+}
+
+func (s *interfaceManagerSuite) TestSetupProfilesPolicyDiffApproved(c *C) {
+	chg, backend := s.testSetupProfilesPolicyDiff(c, "high-risk", snapstate.Flags{ApprovePolicyChanges: true})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Assert(backend.SetupCalls, Not(HasLen), 0)
+	c.Check(backend.SetupCalls[0].SnapInfo.Revision, Equals, snap.R(42))
+}
+
+func (s *interfaceManagerSuite) TestSetupProfilesNoPolicyDiffOnInstall(c *C) {
+	s.MockModel(c, nil)
+	backend := &previewSecurityBackend{
+		TestSecurityBackend: ifacetest.TestSecurityBackend{BackendName: "preview"},
+		proposed: map[string][]byte{
+			filepath.Join(dirs.SnapAppArmorDir, "snap.snap.app"): []byte("capability sys_admin,\n"),
+		},
+	}
+	s.mockSecBackend(c, backend)
+	_ = s.manager(c)
+	snapInfo := s.mockSnap(c, sampleSnapYaml)
+
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.policy-approval", "high-risk")
+	tr.Commit()
+	s.state.Unlock()
+
+	chg := s.addSetupSnapSecurityChange(c, &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: snapInfo.SnapName(),
+			Revision: snapInfo.Revision,
+		},
+	})
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	var pdiffs map[string]*ifacestate.PolicyDiff
+	c.Check(chg.Get("policy-diffs", &pdiffs), Equals, state.ErrNoState)
+}
@@ -0,0 +1,203 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var timeNow = time.Now
+
+// promptingRulesState is the state of the prompting rules, as stored
+// under the "prompting-rules" key.
+type promptingRulesState struct {
+	Rules  []*prompting.Rule `json:"rules,omitempty"`
+	LastID uint64            `json:"last-id"`
+}
+
+func getPromptingRules(st *state.State) (*promptingRulesState, error) {
+	var rules promptingRulesState
+	err := st.Get("prompting-rules", &rules)
+	if err != nil && err != state.ErrNoState {
+		return nil, fmt.Errorf("cannot obtain prompting rules: %v", err)
+	}
+	// expired rules are dropped lazily
+	now := timeNow()
+	active := rules.Rules[:0]
+	for _, rule := range rules.Rules {
+		if !rule.Expired(now) {
+			active = append(active, rule)
+		}
+	}
+	rules.Rules = active
+	return &rules, nil
+}
+
+func setPromptingRules(st *state.State, rules *promptingRulesState) {
+	st.Set("prompting-rules", rules)
+}
+
+// PromptingRulesFilter selects prompting rules, unset fields match
+// any rule.
+type PromptingRulesFilter struct {
+	// User restricts the rules to those of the given user, when set.
+	User      *uint32
+	Snap      string
+	Interface string
+}
+
+func (f *PromptingRulesFilter) match(rule *prompting.Rule) bool {
+	if f == nil {
+		return true
+	}
+	if f.User != nil && *f.User != rule.User {
+		return false
+	}
+	if f.Snap != "" && f.Snap != rule.Snap {
+		return false
+	}
+	if f.Interface != "" && f.Interface != rule.Interface {
+		return false
+	}
+	return true
+}
+
+// PromptingRules returns the prompting rules in effect that match the
+// given filter, in the order in which they were added.
+// The state must be locked by the caller.
+func PromptingRules(st *state.State, filter *PromptingRulesFilter) ([]*prompting.Rule, error) {
+	rules, err := getPromptingRules(st)
+	if err != nil {
+		return nil, err
+	}
+	var matching []*prompting.Rule
+	for _, rule := range rules.Rules {
+		if filter.match(rule) {
+			matching = append(matching, rule)
+		}
+	}
+	return matching, nil
+}
+
+// addPromptingRule validates the rule and adds it to the given rules,
+// assigning it a new ID and timestamp.
+func addPromptingRule(rules *promptingRulesState, rule *prompting.Rule, now time.Time) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if rule.Expired(now) {
+		return fmt.Errorf("rule has already expired")
+	}
+	for _, other := range rules.Rules {
+		if rule.Overlaps(other) {
+			return fmt.Errorf("rule conflicts with existing rule %s", other.ID)
+		}
+	}
+	rules.LastID++
+	rule.ID = fmt.Sprintf("%016X", rules.LastID)
+	rule.Timestamp = now
+	rules.Rules = append(rules.Rules, rule)
+	return nil
+}
+
+// AddPromptingRule adds the given prompting rule, it is assigned a new
+// ID and timestamp. Adding a rule that applies to the same requests as
+// an existing one for any of its permissions is an error.
+// The state must be locked by the caller.
+func AddPromptingRule(st *state.State, rule *prompting.Rule) error {
+	rules, err := getPromptingRules(st)
+	if err != nil {
+		return err
+	}
+	if err := addPromptingRule(rules, rule, timeNow()); err != nil {
+		return fmt.Errorf("cannot add prompting rule: %v", err)
+	}
+	setPromptingRules(st, rules)
+	return nil
+}
+
+// RemovePromptingRule removes the prompting rule with the given ID,
+// which must belong to the given user unless user is nil, and returns
+// it.
+// The state must be locked by the caller.
+func RemovePromptingRule(st *state.State, user *uint32, id string) (*prompting.Rule, error) {
+	rules, err := getPromptingRules(st)
+	if err != nil {
+		return nil, err
+	}
+	for i, rule := range rules.Rules {
+		if rule.ID != id || (user != nil && *user != rule.User) {
+			continue
+		}
+		rules.Rules = append(rules.Rules[:i], rules.Rules[i+1:]...)
+		setPromptingRules(st, rules)
+		return rule, nil
+	}
+	return nil, &NoPromptingRuleError{ID: id}
+}
+
+// ImportPromptingRules adds the given prompting rules, as exported
+// by PromptingRules, with new IDs and timestamps. When replace is set
+// the existing rules matching the filter are removed first. Expired
+// rules are skipped. Either all the rules are imported or none.
+// The state must be locked by the caller.
+func ImportPromptingRules(st *state.State, rules []*prompting.Rule, replace *PromptingRulesFilter) (imported []*prompting.Rule, err error) {
+	current, err := getPromptingRules(st)
+	if err != nil {
+		return nil, err
+	}
+	if replace != nil {
+		kept := current.Rules[:0]
+		for _, rule := range current.Rules {
+			if !replace.match(rule) {
+				kept = append(kept, rule)
+			}
+		}
+		current.Rules = kept
+	}
+	now := timeNow()
+	for i, rule := range rules {
+		if rule.Expired(now) {
+			continue
+		}
+		// copy the rule to not modify the caller's rules on error
+		r := *rule
+		if err := addPromptingRule(current, &r, now); err != nil {
+			return nil, fmt.Errorf("cannot import prompting rule #%d: %v", i+1, err)
+		}
+		imported = append(imported, &r)
+	}
+	setPromptingRules(st, current)
+	return imported, nil
+}
+
+// NoPromptingRuleError is returned when a prompting rule does not
+// exist.
+type NoPromptingRuleError struct {
+	ID string
+}
+
+func (e *NoPromptingRuleError) Error() string {
+	return fmt.Sprintf("cannot find prompting rule %s", e.ID)
+}
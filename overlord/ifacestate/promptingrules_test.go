@@ -0,0 +1,214 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate_test
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type promptingRulesSuite struct {
+	testutil.BaseTest
+
+	st  *state.State
+	now time.Time
+}
+
+var _ = Suite(&promptingRulesSuite{})
+
+func (s *promptingRulesSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	s.st = state.New(nil)
+	s.now = time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.AddCleanup(ifacestate.MockTimeNow(func() time.Time { return s.now }))
+}
+
+func homeRule(user uint32, snapName, pattern string, outcome prompting.Outcome, perms ...string) *prompting.Rule {
+	return &prompting.Rule{
+		User:        user,
+		Snap:        snapName,
+		Interface:   "home",
+		PathPattern: pattern,
+		Permissions: perms,
+		Outcome:     outcome,
+		Lifespan:    prompting.LifespanForever,
+	}
+}
+
+func (s *promptingRulesSuite) TestAddAndList(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	rules, err := ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 0)
+
+	r1 := homeRule(1000, "foo", "/home/test/Documents/**", prompting.OutcomeAllow, "read")
+	c.Assert(ifacestate.AddPromptingRule(s.st, r1), IsNil)
+	c.Check(r1.ID, Equals, "0000000000000001")
+	c.Check(r1.Timestamp.Equal(s.now), Equals, true)
+
+	s.now = s.now.Add(time.Minute)
+	r2 := homeRule(1001, "bar", "/home/other/*", prompting.OutcomeDeny, "write")
+	c.Assert(ifacestate.AddPromptingRule(s.st, r2), IsNil)
+	c.Check(r2.ID, Equals, "0000000000000002")
+
+	rules, err = ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 2)
+	c.Check(rules[0].ID, Equals, r1.ID)
+	c.Check(rules[1].ID, Equals, r2.ID)
+
+	user := uint32(1001)
+	rules, err = ifacestate.PromptingRules(s.st, &ifacestate.PromptingRulesFilter{User: &user})
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+	c.Check(rules[0].ID, Equals, r2.ID)
+
+	rules, err = ifacestate.PromptingRules(s.st, &ifacestate.PromptingRulesFilter{Snap: "foo", Interface: "home"})
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+	c.Check(rules[0].ID, Equals, r1.ID)
+}
+
+func (s *promptingRulesSuite) TestAddInvalid(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	r := homeRule(1000, "foo", "Documents", prompting.OutcomeAllow, "read")
+	err := ifacestate.AddPromptingRule(s.st, r)
+	c.Check(err, ErrorMatches, `cannot add prompting rule: invalid path pattern "Documents": must start with '/'`)
+}
+
+func (s *promptingRulesSuite) TestAddConflict(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	c.Assert(ifacestate.AddPromptingRule(s.st, homeRule(1000, "foo", "/home/test/**", prompting.OutcomeAllow, "read", "write")), IsNil)
+
+	err := ifacestate.AddPromptingRule(s.st, homeRule(1000, "foo", "/home/test/**", prompting.OutcomeDeny, "write"))
+	c.Check(err, ErrorMatches, `cannot add prompting rule: rule conflicts with existing rule 0000000000000001`)
+
+	// other permissions, users or patterns do not conflict
+	c.Check(ifacestate.AddPromptingRule(s.st, homeRule(1000, "foo", "/home/test/**", prompting.OutcomeDeny, "execute")), IsNil)
+	c.Check(ifacestate.AddPromptingRule(s.st, homeRule(1001, "foo", "/home/test/**", prompting.OutcomeDeny, "write")), IsNil)
+	c.Check(ifacestate.AddPromptingRule(s.st, homeRule(1000, "foo", "/home/test/*", prompting.OutcomeDeny, "write")), IsNil)
+}
+
+func (s *promptingRulesSuite) TestExpiration(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	r := homeRule(1000, "foo", "/home/test/**", prompting.OutcomeAllow, "read")
+	r.Lifespan = prompting.LifespanTimespan
+	r.Expiration = s.now.Add(time.Hour)
+	c.Assert(ifacestate.AddPromptingRule(s.st, r), IsNil)
+
+	rules, err := ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 1)
+
+	s.now = s.now.Add(time.Hour)
+	rules, err = ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 0)
+
+	// the expired rule does not conflict anymore
+	c.Check(ifacestate.AddPromptingRule(s.st, homeRule(1000, "foo", "/home/test/**", prompting.OutcomeDeny, "read")), IsNil)
+
+	r = homeRule(1000, "foo", "/home/test/*", prompting.OutcomeAllow, "read")
+	r.Lifespan = prompting.LifespanTimespan
+	r.Expiration = s.now
+	err = ifacestate.AddPromptingRule(s.st, r)
+	c.Check(err, ErrorMatches, `cannot add prompting rule: rule has already expired`)
+}
+
+func (s *promptingRulesSuite) TestRemove(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	r := homeRule(1000, "foo", "/home/test/**", prompting.OutcomeAllow, "read")
+	c.Assert(ifacestate.AddPromptingRule(s.st, r), IsNil)
+
+	other := uint32(1001)
+	_, err := ifacestate.RemovePromptingRule(s.st, &other, r.ID)
+	c.Check(err, ErrorMatches, `cannot find prompting rule 0000000000000001`)
+	c.Check(err, FitsTypeOf, &ifacestate.NoPromptingRuleError{})
+
+	owner := uint32(1000)
+	removed, err := ifacestate.RemovePromptingRule(s.st, &owner, r.ID)
+	c.Assert(err, IsNil)
+	c.Check(removed.ID, Equals, r.ID)
+
+	rules, err := ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 0)
+
+	_, err = ifacestate.RemovePromptingRule(s.st, nil, r.ID)
+	c.Check(err, ErrorMatches, `cannot find prompting rule 0000000000000001`)
+}
+
+func (s *promptingRulesSuite) TestImport(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	c.Assert(ifacestate.AddPromptingRule(s.st, homeRule(1000, "foo", "/home/test/**", prompting.OutcomeAllow, "read")), IsNil)
+	c.Assert(ifacestate.AddPromptingRule(s.st, homeRule(1001, "foo", "/home/other/**", prompting.OutcomeAllow, "read")), IsNil)
+
+	expired := homeRule(1000, "bar", "/home/test/*", prompting.OutcomeAllow, "read")
+	expired.Lifespan = prompting.LifespanTimespan
+	expired.Expiration = s.now.Add(-time.Hour)
+	exported := []*prompting.Rule{
+		homeRule(1000, "foo", "/home/test/**", prompting.OutcomeDeny, "read"),
+		expired,
+		homeRule(1000, "bar", "/home/test/Music/**", prompting.OutcomeAllow, "read", "write"),
+	}
+	exported[0].ID = "00000000000000AA"
+
+	// conflicts with the existing rule of the user
+	_, err := ifacestate.ImportPromptingRules(s.st, exported, nil)
+	c.Check(err, ErrorMatches, `cannot import prompting rule #1: rule conflicts with existing rule 0000000000000001`)
+	rules, err := ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 2)
+	c.Check(exported[0].ID, Equals, "00000000000000AA")
+
+	user := uint32(1000)
+	imported, err := ifacestate.ImportPromptingRules(s.st, exported, &ifacestate.PromptingRulesFilter{User: &user})
+	c.Assert(err, IsNil)
+	c.Assert(imported, HasLen, 2)
+	c.Check(imported[0].ID, Equals, "0000000000000003")
+	c.Check(imported[0].Outcome, Equals, prompting.OutcomeDeny)
+	c.Check(imported[1].ID, Equals, "0000000000000004")
+	c.Check(imported[1].Snap, Equals, "bar")
+
+	rules, err = ifacestate.PromptingRules(s.st, nil)
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 3)
+	// the rule of the other user was kept
+	c.Check(rules[0].User, Equals, uint32(1001))
+}
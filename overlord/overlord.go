@@ -64,6 +64,9 @@ var (
 	pruneMaxChanges = 500
 
 	defaultCachedDownloads = 5
+	// defaultCachedDownloadsMaxSize bounds the space used by the
+	// cached downloads that are not referenced elsewhere
+	defaultCachedDownloadsMaxSize uint64 = 1 << 30
 
 	configstateInit = configstate.Init
 )
@@ -177,6 +180,7 @@ func New(restartBehavior RestartBehavior) (*Overlord, error) {
 
 	o.addManager(cmdstate.Manager(s, o.runner))
 	o.addManager(snapshotstate.Manager(s, o.runner))
+	o.addManager(healthstate.Manager(s, deviceMgr, o.degradedSubsystems))
 
 	if err := configstateInit(s, hookMgr); err != nil {
 		return nil, err
@@ -294,6 +298,7 @@ func verifyReboot(s *state.State, curBootID string, restartBehavior RestartBehav
 func (o *Overlord) newStoreWithContext(storeCtx store.DeviceAndAuthContext) snapstate.StoreService {
 	cfg := store.DefaultConfig()
 	cfg.Proxy = o.proxyConf
+	cfg.CacheDownloadsMaxSize = defaultCachedDownloadsMaxSize
 	sto := storeNew(cfg, storeCtx)
 	sto.SetCacheDownloads(defaultCachedDownloads)
 	return sto
@@ -433,6 +438,10 @@ func (o *Overlord) Loop() {
 				st.Lock()
 				preseedExitWithError(err)
 			}
+			// make sure degraded managers get retried when due
+			if retryAt := o.stateEng.nextDegradedRetry(); !retryAt.IsZero() {
+				o.ensureBefore(retryAt.Sub(timeNow()))
+			}
 			o.ensureDidRun()
 			pruneC := pruneTickerC(o.pruneTicker)
 			select {
@@ -572,6 +581,16 @@ func (o *Overlord) StateEngine() *StateEngine {
 	return o.stateEng
 }
 
+// degradedSubsystems returns the names of the currently degraded
+// subsystems, for health reporting.
+func (o *Overlord) degradedSubsystems() []string {
+	var names []string
+	for _, d := range o.stateEng.Degraded() {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
 // TaskRunner returns the shared task runner responsible for running
 // tasks for all managers under the overlord.
 func (o *Overlord) TaskRunner() *state.TaskRunner {
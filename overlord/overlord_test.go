@@ -381,6 +381,48 @@ func (ovs *overlordSuite) TestEnsureLoopRunAndStop(c *C) {
 	c.Check(witness.startedUp, Equals, 1)
 }
 
+func (ovs *overlordSuite) TestEnsureLoopRetriesDegradedManager(c *C) {
+	restoreIntv := overlord.MockEnsureInterval(10 * time.Minute)
+	defer restoreIntv()
+	restore := overlord.MockDegradedRetry(10*time.Millisecond, time.Second)
+	defer restore()
+	o := overlord.Mock()
+
+	panics := 2
+	witness := &witnessManager{
+		state:          o.State(),
+		expectedEnsure: 3,
+		ensureCalled:   make(chan struct{}),
+		ensureCallback: func(s *state.State) error {
+			if panics > 0 {
+				panics--
+				panic("boom")
+			}
+			return nil
+		},
+	}
+	o.AddManager(witness)
+
+	err := o.StartUp()
+	c.Assert(err, IsNil)
+
+	o.Loop()
+	defer o.Stop()
+
+	// the panicking manager is retried well before the next
+	// regular ensure
+	select {
+	case <-witness.ensureCalled:
+	case <-time.After(2 * time.Second):
+		c.Fatal("degraded manager not retried")
+	}
+
+	err = o.Stop()
+	c.Assert(err, IsNil)
+	// the manager recovered
+	c.Check(o.StateEngine().Degraded(), HasLen, 0)
+}
+
 func (ovs *overlordSuite) TestEnsureLoopMediatedEnsureBeforeImmediate(c *C) {
 	restoreIntv := overlord.MockEnsureInterval(10 * time.Minute)
 	defer restoreIntv()
@@ -183,10 +183,11 @@ func (sd *StatusDecorator) DecorateWithStatus(appInfo *client.AppInfo, snapApp *
 			appInfo.Active = st.Active
 		case ".timer":
 			appInfo.Activators = append(appInfo.Activators, client.AppActivator{
-				Name:    snapApp.Name,
-				Enabled: st.Enabled,
-				Active:  st.Active,
-				Type:    "timer",
+				Name:        snapApp.Name,
+				Enabled:     st.Enabled,
+				Active:      st.Active,
+				Type:        "timer",
+				LastTrigger: st.LastTrigger,
 			})
 		case ".socket":
 			appInfo.Activators = append(appInfo.Activators, client.AppActivator{
@@ -64,7 +64,7 @@ type SnapshotManager struct {
 func Manager(st *state.State, runner *state.TaskRunner) *SnapshotManager {
 	delayedCrossMgrInit()
 
-	runner.AddHandler("save-snapshot", doSave, doForget)
+	runner.AddHandler("save-snapshot", doSave, undoSave)
 	runner.AddHandler("forget-snapshot", doForget, nil)
 	runner.AddHandler("check-snapshot", doCheck, nil)
 	runner.AddHandler("restore-snapshot", doRestore, undoRestore)
@@ -371,6 +371,32 @@ func doCheck(task *state.Task, tomb *tomb.Tomb) error {
 	return backendCheck(reader, tomb.Context(nil), snapshot.Users)
 }
 
+// undoSave removes the snapshot taken by the task, except for automatic
+// snapshots: those protect the data of the operation being undone and are
+// kept, without expiration, so that the data can still be restored.
+func undoSave(task *state.Task, tomb *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	var snapshot snapshotSetup
+	err := task.Get("snapshot-setup", &snapshot)
+	st.Unlock()
+	if err != nil {
+		return taskGetErrMsg(task, err, "snapshot")
+	}
+
+	if !snapshot.Auto {
+		return doForget(task, tomb)
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	if err := removeSnapshotState(st, snapshot.SetID); err != nil {
+		return fmt.Errorf("internal error: cannot remove state of snapshot set %d: %v", snapshot.SetID, err)
+	}
+	task.Logf("Keeping automatic snapshot set #%d of snap %q", snapshot.SetID, snapshot.Snap)
+	return nil
+}
+
 func doForget(task *state.Task, _ *tomb.Tomb) error {
 	// note this is also undoSave
 	st := task.State()
@@ -400,6 +426,7 @@ func delayedCrossMgrInit() {
 	// hook automatic snapshots into snapstate logic
 	snapstate.AutomaticSnapshot = AutomaticSnapshot
 	snapstate.AutomaticSnapshotExpiration = AutomaticSnapshotExpiration
+	snapstate.AutomaticSnapshotBeforeRefresh = AutomaticSnapshotBeforeRefresh
 	snapstate.EstimateSnapshotSize = EstimateSnapshotSize
 }
 
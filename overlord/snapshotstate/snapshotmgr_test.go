@@ -708,6 +708,53 @@ func (rs *readerSuite) TestDoRemove(c *check.C) {
 	c.Check(rs.calls, check.DeepEquals, []string{"remove"})
 }
 
+func (rs *readerSuite) TestUndoSaveRemovesManualSnapshot(c *check.C) {
+	defer snapshotstate.MockOsRemove(func(filename string) error {
+		c.Check(filename, check.Equals, "/some/1_file.zip")
+		rs.calls = append(rs.calls, "remove")
+		return nil
+	})()
+	err := snapshotstate.UndoSave(rs.task, &tomb.Tomb{})
+	c.Assert(err, check.IsNil)
+	c.Check(rs.calls, check.DeepEquals, []string{"remove"})
+}
+
+func (rs *readerSuite) TestUndoSaveKeepsAutomaticSnapshot(c *check.C) {
+	defer snapshotstate.MockOsRemove(func(filename string) error {
+		c.Errorf("unexpected removal of %q", filename)
+		return nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	task := st.NewTask("save-snapshot", "...")
+	task.Set("snapshot-setup", map[string]interface{}{
+		"set-id":   1,
+		"filename": "a-file",
+		"snap":     "a-snap",
+		"auto":     true,
+	})
+
+	st.Set("snapshots", map[uint64]interface{}{
+		1: map[string]interface{}{
+			"expiry-time": "2001-03-11T11:24:00Z",
+		},
+	})
+
+	st.Unlock()
+	c.Assert(snapshotstate.UndoSave(task, &tomb.Tomb{}), check.IsNil)
+	st.Lock()
+
+	// the snapshot no longer expires
+	var expirations map[uint64]interface{}
+	c.Assert(st.Get("snapshots", &expirations), check.IsNil)
+	c.Check(expirations, check.HasLen, 0)
+	c.Check(task.Log(), check.HasLen, 1)
+	c.Check(task.Log()[0], check.Matches, `.* Keeping automatic snapshot set #1 of snap "a-snap"`)
+}
+
 func (rs *readerSuite) TestDoForgetRemovesAutomaticSnapshotExpiry(c *check.C) {
 	defer snapshotstate.MockOsRemove(func(filename string) error {
 		return nil
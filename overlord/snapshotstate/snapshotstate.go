@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/snapcore/snapd/client"
@@ -142,6 +143,39 @@ func AutomaticSnapshotExpiration(st *state.State) (time.Duration, error) {
 	return defaultAutomaticSnapshotExpiration, nil
 }
 
+// AutomaticSnapshotBeforeRefresh returns whether the data of a snap should be
+// saved in an automatic snapshot before refreshing it from cur to update,
+// according to the snapshots.automatic.refresh policy: "epoch" for refreshes
+// that change the epoch, "major" for those that also change the major
+// version, or "no" (the default).
+func AutomaticSnapshotBeforeRefresh(st *state.State, cur, update *snap.Info) (bool, error) {
+	var policy string
+	tr := config.NewTransaction(st)
+	err := tr.Get("core", "snapshots.automatic.refresh", &policy)
+	if err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	epochChange := !cur.Epoch.Equal(&update.Epoch)
+	switch policy {
+	case "", "no":
+		return false, nil
+	case "epoch":
+		return epochChange, nil
+	case "major":
+		return epochChange || majorVersion(cur.Version) != majorVersion(update.Version), nil
+	}
+	logger.Noticef("snapshots.automatic.refresh has unsupported value %q", policy)
+	return false, nil
+}
+
+// majorVersion returns the leading component of a snap version.
+func majorVersion(version string) string {
+	if i := strings.IndexAny(version, ".-+~:"); i >= 0 {
+		return version[:i]
+	}
+	return version
+}
+
 // saveExpiration saves expiration date of the given snapshot set, in the state.
 // The state needs to be locked by the caller.
 func saveExpiration(st *state.State, setID uint64, expiryTime time.Time) error {
@@ -1490,6 +1490,40 @@ func (snapshotSuite) TestAutomaticSnapshot(c *check.C) {
 	})
 }
 
+func (snapshotSuite) TestAutomaticSnapshotBeforeRefresh(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	mkInfo := func(version, epoch string) *snap.Info {
+		return &snap.Info{Version: version, Epoch: snap.E(epoch)}
+	}
+	cur := mkInfo("1.2", "0")
+
+	for _, tc := range []struct {
+		policy   string
+		update   *snap.Info
+		expected bool
+	}{
+		{"", mkInfo("2.0", "1*"), false},
+		{"no", mkInfo("2.0", "1*"), false},
+		{"epoch", mkInfo("1.3", "1*"), true},
+		{"epoch", mkInfo("2.0", "0"), false},
+		{"major", mkInfo("2.0", "0"), true},
+		{"major", mkInfo("1.3", "1*"), true},
+		{"major", mkInfo("1.3-beta", "0"), false},
+		{"unsupported", mkInfo("2.0", "1*"), false},
+	} {
+		tr := config.NewTransaction(st)
+		tr.Set("core", "snapshots.automatic.refresh", tc.policy)
+		tr.Commit()
+
+		needed, err := snapshotstate.AutomaticSnapshotBeforeRefresh(st, cur, tc.update)
+		c.Assert(err, check.IsNil)
+		c.Check(needed, check.Equals, tc.expected, check.Commentf("%q %s %s", tc.policy, tc.update.Version, tc.update.Epoch))
+	}
+}
+
 func (snapshotSuite) TestAutomaticSnapshotDefaultClassic(c *check.C) {
 	release.MockOnClassic(true)
 
@@ -20,11 +20,15 @@
 package backend
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/progress"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil/quantity"
 )
 
 // CopySnapData makes a copy of oldSnap data for newSnap in its data directories.
@@ -47,13 +51,58 @@ func (b Backend) CopySnapData(newSnap, oldSnap *snap.Info, meter progress.Meter)
 	}
 
 	if oldSnap == nil {
-		return os.MkdirAll(newSnap.DataDir(), 0755)
+		if err := os.MkdirAll(newSnap.DataDir(), 0755); err != nil {
+			return err
+		}
+		return ensureServicesDataDirsOwnership(newSnap)
 	} else if oldSnap.Revision == newSnap.Revision {
 		// nothing to do
 		return nil
 	}
 
-	return copySnapData(oldSnap, newSnap)
+	shared, err := copySnapData(oldSnap, newSnap)
+	if err != nil {
+		return err
+	}
+	if shared > 0 {
+		size := strings.TrimSpace(quantity.FormatAmount(shared, -1))
+		meter.Notify(fmt.Sprintf("Copied data of snap %q using reflinks, saving %sB", newSnap.InstanceName(), size))
+	}
+	return ensureServicesDataDirsOwnership(newSnap)
+}
+
+var osChown = os.Chown
+
+// ensureServicesDataDirsOwnership hands the data directories of the snap over
+// to the group of the system username its services run as, if any, so that
+// the services can write to them. Other users have no access to the data of a
+// snap made only of services, otherwise they can still read it as the other
+// apps of the snap they run need to.
+func ensureServicesDataDirsOwnership(info *snap.Info) error {
+	runAs := info.ServicesRunAs()
+	if runAs == "" {
+		return nil
+	}
+	gid, err := osutil.FindGid(runAs)
+	if err != nil {
+		return fmt.Errorf("cannot find the group of system username %q: %v", runAs, err)
+	}
+	mode := os.FileMode(0770)
+	for _, app := range info.Apps {
+		if !app.IsService() {
+			mode = 0775
+			break
+		}
+	}
+	for _, d := range []string{info.DataDir(), info.CommonDataDir()} {
+		if err := osChown(d, 0, int(gid)); err != nil {
+			return err
+		}
+		if err := os.Chmod(d, mode); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // UndoCopySnapData removes the copy that may have been done for newInfo snap of oldInfo snap data and also the data directories that may have been created for newInfo snap.
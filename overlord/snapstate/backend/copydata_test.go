@@ -32,6 +32,7 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/progress/progresstest"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
@@ -264,6 +265,101 @@ func (s *copydataSuite) TestCopyDataDoUndoFirstInstall(c *C) {
 	c.Check(os.IsNotExist(err), Equals, true)
 }
 
+func (s *copydataSuite) TestCopyDataServicesRunAs(c *C) {
+	restore := osutil.MockFindGid(func(name string) (uint64, error) {
+		c.Check(name, Equals, "snap_hello")
+		return 585300, nil
+	})
+	defer restore()
+	var chowned []string
+	restore = backend.MockOsChown(func(name string, uid, gid int) error {
+		c.Check(uid, Equals, 0)
+		c.Check(gid, Equals, 585300)
+		chowned = append(chowned, name)
+		return nil
+	})
+	defer restore()
+
+	const helloRunAsYaml = `name: hello
+version: 1.0
+system-usernames:
+  snap_hello: private
+apps:
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_hello
+`
+	v1 := snaptest.MockSnap(c, helloRunAsYaml, &snap.SideInfo{Revision: snap.R(10)})
+	err := s.be.CopySnapData(v1, nil, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(chowned, DeepEquals, []string{v1.DataDir(), v1.CommonDataDir()})
+	for _, d := range chowned {
+		st, err := os.Stat(d)
+		c.Assert(err, IsNil)
+		c.Check(st.Mode().Perm(), Equals, os.FileMode(0770))
+	}
+
+	// and on refresh
+	chowned = nil
+	v2 := snaptest.MockSnap(c, helloRunAsYaml, &snap.SideInfo{Revision: snap.R(20)})
+	err = s.be.CopySnapData(v2, v1, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(chowned, DeepEquals, []string{v2.DataDir(), v2.CommonDataDir()})
+}
+
+func (s *copydataSuite) TestCopyDataServicesRunAsWithOtherApps(c *C) {
+	restore := osutil.MockFindGid(func(name string) (uint64, error) {
+		return 585300, nil
+	})
+	defer restore()
+	restore = backend.MockOsChown(func(path string, uid, gid int) error {
+		return nil
+	})
+	defer restore()
+
+	v1 := snaptest.MockSnap(c, `name: hello
+version: 1.0
+system-usernames:
+  snap_hello: private
+apps:
+  hello:
+    command: bin/hello
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_hello
+`, &snap.SideInfo{Revision: snap.R(10)})
+	err := s.be.CopySnapData(v1, nil, progress.Null)
+	c.Assert(err, IsNil)
+	// users running the other apps can still read the data
+	for _, d := range []string{v1.DataDir(), v1.CommonDataDir()} {
+		st, err := os.Stat(d)
+		c.Assert(err, IsNil)
+		c.Check(st.Mode().Perm(), Equals, os.FileMode(0775))
+	}
+}
+
+func (s *copydataSuite) TestCopyDataServicesRunAsNoGroup(c *C) {
+	restore := osutil.MockFindGid(func(name string) (uint64, error) {
+		return 0, fmt.Errorf("no such group")
+	})
+	defer restore()
+
+	v1 := snaptest.MockSnap(c, `name: hello
+version: 1.0
+system-usernames:
+  snap_hello: private
+apps:
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_hello
+`, &snap.SideInfo{Revision: snap.R(10)})
+	err := s.be.CopySnapData(v1, nil, progress.Null)
+	c.Assert(err, ErrorMatches, `cannot find the group of system username "snap_hello": no such group`)
+}
+
 func (s *copydataSuite) TestCopyDataDoABA(c *C) {
 	v1 := snaptest.MockSnap(c, helloYaml1, &snap.SideInfo{Revision: snap.R(10)})
 	s.populateData(c, snap.R(10))
@@ -467,6 +563,90 @@ func (s *copydataSuite) TestCopyDataPartialFailure(c *C) {
 	}
 }
 
+func (s *copydataSuite) TestCopyDataReflinkReportsSavings(c *C) {
+	v1 := snaptest.MockSnap(c, helloYaml1, &snap.SideInfo{Revision: snap.R(10)})
+	s.populateData(c, snap.R(10))
+	homedir := s.populateHomeData(c, "user1", snap.R(10))
+
+	var probed []string
+	restore := backend.MockReflinkSupported(func(dir string) bool {
+		probed = append(probed, dir)
+		return true
+	})
+	defer restore()
+	var measured []string
+	restore = backend.MockSharedDataSize(func(dir string) (uint64, error) {
+		measured = append(measured, dir)
+		switch dir {
+		case filepath.Join(homedir, "hello/20"):
+			// some of the files could not be reflinked
+			return 2, nil
+		case filepath.Join(dirs.SnapDataDir, "hello/20"):
+			return 3, nil
+		}
+		return 0, nil
+	})
+	defer restore()
+
+	v2 := snaptest.MockSnap(c, helloYaml2, &snap.SideInfo{Revision: snap.R(20)})
+	meter := &progresstest.Meter{}
+	err := s.be.CopySnapData(v2, v1, meter)
+	c.Assert(err, IsNil)
+	c.Check(s.populatedData("20"), Equals, "10\n")
+
+	c.Check(probed, DeepEquals, []string{
+		filepath.Join(homedir, "hello"),
+		filepath.Join(s.tempdir, "root/snap/hello"),
+		filepath.Join(dirs.SnapDataDir, "hello"),
+	})
+	// the shared data of the copies is reported, not the size of the
+	// original data
+	c.Check(measured, DeepEquals, []string{
+		filepath.Join(homedir, "hello/20"),
+		filepath.Join(s.tempdir, "root/snap/hello/20"),
+		filepath.Join(dirs.SnapDataDir, "hello/20"),
+	})
+	c.Check(meter.Notices, DeepEquals, []string{`Copied data of snap "hello" using reflinks, saving 5B`})
+}
+
+func (s *copydataSuite) TestCopyDataReflinkNothingSharedNoReport(c *C) {
+	v1 := snaptest.MockSnap(c, helloYaml1, &snap.SideInfo{Revision: snap.R(10)})
+	s.populateData(c, snap.R(10))
+
+	restore := backend.MockReflinkSupported(func(dir string) bool {
+		return true
+	})
+	defer restore()
+	// cp fell back to copying the data
+	restore = backend.MockSharedDataSize(func(dir string) (uint64, error) {
+		return 0, nil
+	})
+	defer restore()
+
+	v2 := snaptest.MockSnap(c, helloYaml2, &snap.SideInfo{Revision: snap.R(20)})
+	meter := &progresstest.Meter{}
+	err := s.be.CopySnapData(v2, v1, meter)
+	c.Assert(err, IsNil)
+	c.Check(meter.Notices, HasLen, 0)
+}
+
+func (s *copydataSuite) TestCopyDataNoReflinkNoReport(c *C) {
+	v1 := snaptest.MockSnap(c, helloYaml1, &snap.SideInfo{Revision: snap.R(10)})
+	s.populateData(c, snap.R(10))
+
+	restore := backend.MockReflinkSupported(func(dir string) bool {
+		return false
+	})
+	defer restore()
+
+	v2 := snaptest.MockSnap(c, helloYaml2, &snap.SideInfo{Revision: snap.R(20)})
+	meter := &progresstest.Meter{}
+	err := s.be.CopySnapData(v2, v1, meter)
+	c.Assert(err, IsNil)
+	c.Check(s.populatedData("20"), Equals, "10\n")
+	c.Check(meter.Notices, HasLen, 0)
+}
+
 func (s *copydataSuite) TestCopyDataSameRevision(c *C) {
 	v1 := snaptest.MockSnap(c, helloYaml1, &snap.SideInfo{Revision: snap.R(10)})
 
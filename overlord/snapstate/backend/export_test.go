@@ -43,3 +43,27 @@ func MockCommandFromSystemSnap(f func(string, ...string) (*exec.Cmd, error)) (re
 		commandFromSystemSnap = old
 	}
 }
+
+func MockReflinkSupported(f func(dir string) bool) (restore func()) {
+	old := reflinkSupported
+	reflinkSupported = f
+	return func() {
+		reflinkSupported = old
+	}
+}
+
+func MockSharedDataSize(f func(dir string) (uint64, error)) (restore func()) {
+	old := sharedDataSize
+	sharedDataSize = f
+	return func() {
+		sharedDataSize = old
+	}
+}
+
+func MockOsChown(f func(name string, uid, gid int) error) (restore func()) {
+	old := osChown
+	osChown = f
+	return func() {
+		osChown = old
+	}
+}
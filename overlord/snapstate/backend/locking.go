@@ -19,6 +19,8 @@
 package backend
 
 import (
+	"fmt"
+
 	"github.com/snapcore/snapd/cmd/snaplock"
 	"github.com/snapcore/snapd/cmd/snaplock/runinhibit"
 	"github.com/snapcore/snapd/osutil"
@@ -38,6 +40,7 @@ func (b Backend) RunInhibitSnapForUnlink(info *snap.Info, hint runinhibit.Hint,
 	if err != nil {
 		return nil, err
 	}
+	lock.SetOperation(fmt.Sprintf("snapd: %s of snap %q", hint, info.InstanceName()))
 	// Keep a copy of lock, so that we can close it in the function below.
 	// The regular lock variable is assigned to by return, due to the named
 	// return values.
@@ -84,6 +87,7 @@ func WithSnapLock(info *snap.Info, action func() error) error {
 	if err != nil {
 		return err
 	}
+	lock.SetOperation(fmt.Sprintf("snapd: operation on snap %q", info.InstanceName()))
 	// Closing the lock also unlocks it, if locked.
 	defer lock.Close()
 	if err := lock.Lock(); err != nil {
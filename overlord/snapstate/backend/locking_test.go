@@ -54,6 +54,12 @@ version: 1
 	})
 	c.Assert(err, IsNil)
 	c.Assert(lock, NotNil)
+	// the holder of the snap lock is recorded
+	lockInfo, err := osutil.InspectLock(lock.Path())
+	c.Assert(err, IsNil)
+	c.Check(lockInfo.Locked, Equals, true)
+	c.Assert(lockInfo.Holder, NotNil)
+	c.Check(lockInfo.Holder.Operation, Equals, `snapd: hint of snap "snap-name"`)
 	lock.Close()
 	hint, err := runinhibit.IsLocked(info.InstanceName())
 	c.Assert(err, IsNil)
@@ -91,6 +97,9 @@ version: 1
 
 	err = backend.WithSnapLock(info, func() error {
 		c.Assert(lock.TryLock(), Equals, osutil.ErrAlreadyLocked) // Lock is held
+		holder, err := osutil.ReadLockHolder(lock.Path())
+		c.Assert(err, IsNil)
+		c.Check(holder.Operation, Equals, `snapd: operation on snap "snap-name"`)
 		return errors.New("error-is-propagated")
 	})
 	c.Check(err, ErrorMatches, "error-is-propagated")
@@ -130,15 +130,26 @@ func snapCommonDataDirs(snap *snap.Info) ([]string, error) {
 	// then system data
 	found = append(found, snap.CommonDataDir())
 
+	// and the credentials of the persistent mount-control mounts, which
+	// are below SNAP_COMMON too
+	found = append(found, filepath.Join(dirs.SnapMountCredentialsDir, snap.InstanceName()))
+
 	return found, nil
 }
 
+var (
+	reflinkSupported = osutil.ReflinkSupported
+	sharedDataSize   = osutil.SharedDataSize
+)
+
 // Copy all data for oldSnap to newSnap
-// (but never overwrite)
-func copySnapData(oldSnap, newSnap *snap.Info) (err error) {
+// (but never overwrite). The returned amount is the size of the copied
+// data which shares its blocks with other files, as copied using
+// reflinks.
+func copySnapData(oldSnap, newSnap *snap.Info) (shared uint64, err error) {
 	oldDataDirs, err := snapDataDirs(oldSnap)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	done := make([]string, 0, len(oldDataDirs))
 	defer func() {
@@ -161,12 +172,23 @@ func copySnapData(oldSnap, newSnap *snap.Info) (err error) {
 		// replace the trailing "../$old-suffix" with the "../$new-suffix"
 		newDir := filepath.Join(filepath.Dir(oldDir), newSuffix)
 		if err := copySnapDataDirectory(oldDir, newDir); err != nil {
-			return err
+			return 0, err
 		}
 		done = append(done, newDir)
+
+		// cp falls back to copying the data of the files which
+		// cannot be reflinked, only what is actually shared counts
+		if reflinkSupported(filepath.Dir(newDir)) {
+			size, err := sharedDataSize(newDir)
+			if err != nil {
+				logger.Noticef("cannot compute the shared data size of %q: %v", newDir, err)
+				continue
+			}
+			shared += size
+		}
 	}
 
-	return nil
+	return shared, nil
 }
 
 // trashPath returns the trash path for the given path. This will
@@ -236,7 +258,7 @@ func copySnapDataDirectory(oldPath, newPath string) (err error) {
 		}
 
 		if _, err := os.Stat(newPath); err != nil {
-			if err := osutil.CopyFile(oldPath, newPath, osutil.CopyFlagPreserveAll|osutil.CopyFlagSync); err != nil {
+			if err := osutil.CopyFile(oldPath, newPath, osutil.CopyFlagPreserveAll|osutil.CopyFlagSync|osutil.CopyFlagReflink); err != nil {
 				msg := fmt.Sprintf("cannot copy %q to %q: %v", oldPath, newPath, err)
 				// remove the directory, in case it was a partial success
 				if e := os.RemoveAll(newPath); e != nil && !os.IsNotExist(e) {
@@ -20,6 +20,7 @@
 package backend_test
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -87,6 +88,21 @@ func (s *snapdataSuite) TestRemoveSnapCommonData(c *C) {
 	c.Assert(osutil.FileExists(filepath.Dir(varCommonData)), Equals, true)
 }
 
+func (s *snapdataSuite) TestRemoveSnapCommonDataMountCredentials(c *C) {
+	credentials := filepath.Join(dirs.SnapMountCredentialsDir, "hello", "nas")
+	err := os.MkdirAll(filepath.Dir(credentials), 0700)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(credentials, []byte("password=secret"), 0600)
+	c.Assert(err, IsNil)
+
+	info := snaptest.MockSnap(c, helloYaml1, &snap.SideInfo{Revision: snap.R(10)})
+
+	err = s.be.RemoveSnapCommonData(info)
+	c.Assert(err, IsNil)
+	c.Assert(osutil.FileExists(filepath.Dir(credentials)), Equals, false)
+	c.Assert(osutil.FileExists(dirs.SnapMountCredentialsDir), Equals, true)
+}
+
 func (s *snapdataSuite) TestRemoveSnapDataDir(c *C) {
 	varBaseData := filepath.Join(dirs.SnapDataDir, "hello")
 	err := os.MkdirAll(varBaseData, 0755)
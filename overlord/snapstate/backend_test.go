@@ -809,6 +809,21 @@ apps:
   svc3:
     daemon: simple
     before: [svc2]
+`))
+		if err != nil {
+			panic(err)
+		}
+		info.SideInfo = *si
+	case "private-users-snap":
+		var err error
+		info, err = snap.InfoFromSnapYaml([]byte(`name: private-users-snap
+system-usernames:
+  snap_daemon: shared
+  snap_private-users-snap: private
+apps:
+  svc:
+    daemon: simple
+    run-as: snap_private-users-snap
 `))
 		if err != nil {
 			panic(err)
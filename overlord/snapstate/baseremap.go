@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+// remappedBase returns the directory of the distribution provided runtime
+// that stands in for the given base snap, or "" if the base is not
+// remapped. A base is only remapped on classic systems with the
+// experimental.base-remapping feature enabled, when the base snap itself is
+// not installed and the distribution ships a runtime for it under
+// dirs.SnapRuntimesDir.
+func remappedBase(st *state.State, base string) (string, error) {
+	if !release.OnClassic || base == "" || base == "none" {
+		return "", nil
+	}
+
+	tr := config.NewTransaction(st)
+	enabled, err := features.Flag(tr, features.BaseRemapping)
+	if err != nil && !config.IsNoOption(err) {
+		return "", err
+	}
+	if !enabled {
+		return "", nil
+	}
+
+	// an installed base snap always takes precedence
+	installed, err := isInstalled(st, base)
+	if err != nil {
+		return "", err
+	}
+	if installed {
+		return "", nil
+	}
+
+	runtime := filepath.Join(dirs.SnapRuntimesDir, base)
+	if !osutil.IsDirectory(runtime) {
+		return "", nil
+	}
+	// the runtime needs to look like a root filesystem
+	if !osutil.FileExists(filepath.Join(runtime, "usr/lib/os-release")) {
+		return "", fmt.Errorf("cannot use runtime %q for base %q: missing usr/lib/os-release", runtime, base)
+	}
+	return runtime, nil
+}
+
+// remappedBases returns the bases remapped to distribution provided
+// runtimes, mapped to the runtimes standing in for them.
+func remappedBases(st *state.State) (map[string]string, error) {
+	var remapped map[string]string
+	if err := st.Get("remapped-bases", &remapped); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if remapped == nil {
+		remapped = make(map[string]string)
+	}
+	return remapped, nil
+}
+
+// linkRemappedBase makes the current symlink of the base point to the
+// runtime standing in for it, which is where snap-confine looks for the
+// root filesystem of the mount namespace of snaps using the base. The
+// remapping is recorded in the state so that it can be cleaned up once no
+// snap uses the base anymore. It returns whether the symlink was created.
+func linkRemappedBase(st *state.State, base, runtime string) (created bool, err error) {
+	remapped, err := remappedBases(st)
+	if err != nil {
+		return false, err
+	}
+	current := filepath.Join(dirs.SnapMountDir, base, "current")
+	if target, err := os.Readlink(current); err != nil || target != runtime {
+		if err := os.MkdirAll(filepath.Dir(current), 0755); err != nil {
+			return false, err
+		}
+		if err := osutil.AtomicSymlink(runtime, current); err != nil {
+			return false, err
+		}
+		created = true
+	}
+	remapped[base] = runtime
+	st.Set("remapped-bases", remapped)
+	return created, nil
+}
+
+// baseInUse returns whether any revision of an installed snap uses the
+// given base, including revisions that are not current as the snap can be
+// reverted to them.
+func baseInUse(st *state.State, base string) (bool, error) {
+	snapStates, err := All(st)
+	if err != nil {
+		return false, err
+	}
+	for instanceName, snapst := range snapStates {
+		for _, si := range snapst.Sequence {
+			info, err := readInfo(instanceName, si, 0)
+			if err != nil {
+				return false, err
+			}
+			snapBase := info.Base
+			if snapBase == "" && info.Type() == snap.TypeApp {
+				snapBase = defaultCoreSnapName
+			}
+			if snapBase == base {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// cleanupRemappedBases removes the current symlinks of the remapped bases
+// that no installed snap uses anymore, along with their records. Symlinks
+// that were since replaced, e.g. by installing the base snap, are left
+// alone.
+func cleanupRemappedBases(st *state.State) error {
+	remapped, err := remappedBases(st)
+	if err != nil {
+		return err
+	}
+	for base, runtime := range remapped {
+		inUse, err := baseInUse(st, base)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			continue
+		}
+		baseDir := filepath.Join(dirs.SnapMountDir, base)
+		current := filepath.Join(baseDir, "current")
+		if target, err := os.Readlink(current); err == nil && target == runtime {
+			if err := os.Remove(current); err != nil {
+				return err
+			}
+			// only the symlink was created in the directory
+			if err := os.Remove(baseDir); err != nil && !os.IsNotExist(err) {
+				logger.Noticef("cannot remove directory of remapped base %q: %v", base, err)
+			}
+		}
+		delete(remapped, base)
+	}
+	if len(remapped) == 0 {
+		st.Set("remapped-bases", nil)
+	} else {
+		st.Set("remapped-bases", remapped)
+	}
+	return nil
+}
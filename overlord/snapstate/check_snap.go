@@ -55,8 +55,8 @@ var featureSet = map[string]bool{
 // Since the snap is mounted read-only and to avoid problems associated with
 // different systems using different uids and gids for the same user name and
 // group name, snapd will create system-usernames where 'scope' is not
-// 'external' (currently snapd only supports 'scope: shared') with the
-// following characteristics:
+// 'external' (currently snapd only supports 'scope: shared' and
+// 'scope: private') with the following characteristics:
 //
 // - uid and gid shall match for the specified system-username
 // - a snapd-allocated [ug]id for a user/group name shall never change
@@ -176,6 +176,12 @@ var supportedSystemUsernames = map[string]uint32{
 	"snap_daemon": 584788,
 }
 
+// The range 'scope: private' system-usernames are assigned from, see above.
+const (
+	privateSystemUsernamesMin = 585288
+	privateSystemUsernamesMax = 589807
+)
+
 func checkAssumes(si *snap.Info) error {
 	missing := ([]string)(nil)
 	for _, flag := range si.Assumes {
@@ -541,6 +547,14 @@ func checkBases(st *state.State, snapInfo, curInfo *snap.Info, _ snap.Container,
 		}
 	}
 
+	runtime, err := remappedBase(st, snapInfo.Base)
+	if err != nil {
+		return err
+	}
+	if runtime != "" {
+		return nil
+	}
+
 	return fmt.Errorf("cannot find required base %q", snapInfo.Base)
 }
 
@@ -579,19 +593,36 @@ func earlyEpochCheck(info *snap.Info, snapst *SnapState) error {
 }
 
 // check that the listed system users are valid
-var osutilEnsureUserGroup = osutil.EnsureUserGroup
+var (
+	osutilEnsureUserGroup        = osutil.EnsureUserGroup
+	osutilEnsureUserGroupInRange = osutil.EnsureUserGroupInRange
+	osutilDelUserGroup           = osutil.DelUserGroup
+)
+
+// isPrivateSystemUsernameOf returns whether the name is suitable for a
+// 'scope: private' system username of the snap, that is it is either
+// snap_<snap name> or starts with snap_<snap name>_. Parallel instances of a
+// snap share those.
+func isPrivateSystemUsernameOf(name string, si *snap.Info) bool {
+	prefix := "snap_" + si.SnapName()
+	return name == prefix || strings.HasPrefix(name, prefix+"_")
+}
 
 func validateSystemUsernames(si *snap.Info) error {
 	for _, user := range si.SystemUsernames {
-		if _, ok := supportedSystemUsernames[user.Name]; !ok {
-			return fmt.Errorf(`snap %q requires unsupported system username "%s"`, si.InstanceName(), user.Name)
-		}
-
 		switch user.Scope {
 		case "shared":
-			// this is supported
+			if _, ok := supportedSystemUsernames[user.Name]; !ok {
+				return fmt.Errorf(`snap %q requires unsupported system username "%s"`, si.InstanceName(), user.Name)
+			}
+			continue
+		case "private":
+			_, shared := supportedSystemUsernames[user.Name]
+			if shared || !isPrivateSystemUsernameOf(user.Name, si) {
+				return fmt.Errorf(`snap %q cannot use "%s" as private system username, it must be named "snap_%s" or start with "snap_%s_"`, si.InstanceName(), user.Name, si.SnapName(), si.SnapName())
+			}
 			continue
-		case "private", "external":
+		case "external":
 			// not supported yet
 			return fmt.Errorf(`snap %q requires unsupported user scope "%s" for this version of snapd`, si.InstanceName(), user.Scope)
 		default:
@@ -648,6 +679,34 @@ func checkAndCreateSystemUsernames(si *snap.Info) error {
 			if err := osutilEnsureUserGroup(user.Name, id, extrausers); err != nil {
 				return fmt.Errorf(`cannot ensure users for snap %q required system username "%s": %v`, si.InstanceName(), user.Name, err)
 			}
+		case "private":
+			rangeStart := uint32(privateSystemUsernamesMin & 0xFFFF0000)
+			rangeName := fmt.Sprintf("snapd-range-%d-root", rangeStart)
+			if err := osutilEnsureUserGroup(rangeName, rangeStart, extrausers); err != nil {
+				return fmt.Errorf(`cannot ensure users for snap %q required system username "%s": %v`, si.InstanceName(), user.Name, err)
+			}
+
+			// The identifier is kept if the user and group exist
+			// already, eg. when the snap is refreshed
+			if _, err := osutilEnsureUserGroupInRange(user.Name, privateSystemUsernamesMin, privateSystemUsernamesMax, extrausers); err != nil {
+				return fmt.Errorf(`cannot ensure users for snap %q required system username "%s": %v`, si.InstanceName(), user.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removePrivateSystemUsernames removes the 'scope: private' system usernames
+// of the snap, which are not shared with other snaps. It must only be called
+// once the last revision of the last instance of the snap is removed.
+func removePrivateSystemUsernames(si *snap.Info) error {
+	extrausers := !release.OnClassic
+	for _, user := range si.SystemUsernames {
+		if user.Scope != "private" {
+			continue
+		}
+		if err := osutilDelUserGroup(user.Name, extrausers); err != nil {
+			return fmt.Errorf(`cannot remove snap %q system username "%s": %v`, si.InstanceName(), user.Name, err)
 		}
 	}
 	return nil
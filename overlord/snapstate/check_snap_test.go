@@ -22,13 +22,17 @@ package snapstate_test
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
+	"path/filepath"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/arch"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	seccomp_compiler "github.com/snapcore/snapd/sandbox/seccomp"
@@ -733,6 +737,42 @@ base: some-base
 	c.Check(err, ErrorMatches, "cannot find required base \"some-base\"")
 }
 
+func (s *checkSnapSuite) TestCheckSnapBasesRemapped(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	tr := config.NewTransaction(st)
+	tr.Set("core", "experimental.base-remapping", true)
+	tr.Commit()
+
+	runtime := filepath.Join(dirs.SnapRuntimesDir, "some-base")
+	c.Assert(os.MkdirAll(filepath.Join(runtime, "usr/lib"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(runtime, "usr/lib/os-release"), nil, 0644), IsNil)
+
+	const yaml = `name: requires-base
+version: 1
+base: some-base
+`
+
+	info, err := snap.InfoFromSnapYaml([]byte(yaml))
+	c.Assert(err, IsNil)
+
+	var openSnapFile = func(path string, si *snap.SideInfo) (*snap.Info, snap.Container, error) {
+		return info, emptyContainer(c), nil
+	}
+	restore = snapstate.MockOpenSnapFile(openSnapFile)
+	defer restore()
+
+	st.Unlock()
+	err = snapstate.CheckSnap(st, "snap-path", "requires-base", nil, nil, snapstate.Flags{}, nil)
+	st.Lock()
+	c.Check(err, IsNil)
+}
+
 func (s *checkSnapSuite) TestCheckSnapBasesNoneHappy(c *C) {
 	st := state.New(nil)
 	st.Lock()
@@ -987,7 +1027,17 @@ var systemUsernamesTests = []struct {
 }, {
 	sysIDs: "snap_daemon:\n    scope: private",
 	scVer:  "dead 2.4.1 deadbeef bpf-actlog",
-	error:  `snap "foo" requires unsupported user scope "private" for this version of snapd`,
+	error:  `snap "foo" cannot use "snap_daemon" as private system username, it must be named "snap_foo" or start with "snap_foo_"`,
+}, {
+	sysIDs: "snap_foo:\n    scope: private",
+	scVer:  "dead 2.4.1 deadbeef bpf-actlog",
+}, {
+	sysIDs: "snap_foo_web: private",
+	scVer:  "dead 2.4.1 deadbeef bpf-actlog",
+}, {
+	sysIDs: "snap_foobar: private",
+	scVer:  "dead 2.4.1 deadbeef bpf-actlog",
+	error:  `snap "foo" cannot use "snap_foobar" as private system username, it must be named "snap_foo" or start with "snap_foo_"`,
 }, {
 	sysIDs: "snap_daemon:\n    scope: external",
 	scVer:  "dead 2.4.1 deadbeef bpf-actlog",
@@ -1008,7 +1058,11 @@ var systemUsernamesTests = []struct {
 	sysIDs:  "snap_daemon:\n    scope: private",
 	scVer:   "dead 2.4.1 deadbeef bpf-actlog",
 	classic: true,
-	error:   `snap "foo" requires unsupported user scope "private" for this version of snapd`,
+	error:   `snap "foo" cannot use "snap_daemon" as private system username, it must be named "snap_foo" or start with "snap_foo_"`,
+}, {
+	sysIDs:  "snap_foo:\n    scope: private",
+	scVer:   "dead 2.4.1 deadbeef bpf-actlog",
+	classic: true,
 }, {
 	sysIDs:  "snap_daemon:\n    scope: external",
 	scVer:   "dead 2.4.1 deadbeef bpf-actlog",
@@ -1110,6 +1164,14 @@ func (s *checkSnapSuite) TestCheckSnapSystemUsernames(c *C) {
 			})
 		}
 		defer restore()
+		restore = snapstate.MockOsutilEnsureUserGroupInRange(func(name string, min, max uint32, extraUsers bool) (uint32, error) {
+			c.Check(min, Equals, uint32(585288))
+			c.Check(max, Equals, uint32(589807))
+			c.Check(extraUsers, Equals, !test.classic)
+			osutilEnsureUserGroupCalls++
+			return min, nil
+		})
+		defer restore()
 
 		yaml := fmt.Sprintf("name: foo\nsystem-usernames:\n  %s\n", test.sysIDs)
 
@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// Policies for automatically installing the default content providers of
+// snaps, set with the default-providers.policy system option and
+// overridden per snap with default-providers.overrides.<snap>.
+const (
+	// DefaultProvidersAlways installs the default providers automatically,
+	// this is the default.
+	DefaultProvidersAlways = "always"
+	// DefaultProvidersNever never installs the default providers, the
+	// content plugs of the snap stay unconnected until a provider is
+	// installed explicitly.
+	DefaultProvidersNever = "never"
+	// DefaultProvidersPrompt installs the default providers only when the
+	// client approves it with the install or refresh request.
+	DefaultProvidersPrompt = "prompt"
+)
+
+// DefaultProvider records how a default content provider of a snap was
+// dealt with when the snap was installed or refreshed. It is stored in the
+// change data under the "default-providers" key, mapped by snap name.
+type DefaultProvider struct {
+	// Snap is the name of the provider snap.
+	Snap string `json:"snap"`
+	// ContentTags are the content tags of the plugs of the snap that the
+	// provider is expected to supply.
+	ContentTags []string `json:"content-tags,omitempty"`
+	// Policy is the policy that applied to the snap.
+	Policy string `json:"policy"`
+	// Installed is set when the provider was automatically installed.
+	Installed bool `json:"installed,omitempty"`
+	// NeedsApproval is set when the provider was not installed by an
+	// auto-refresh because the policy requires an approval.
+	NeedsApproval bool `json:"needs-approval,omitempty"`
+}
+
+// ValidateDefaultProvidersPolicy checks that the given policy for
+// automatically installing default providers is known, an empty policy
+// means the default.
+func ValidateDefaultProvidersPolicy(policy string) error {
+	switch policy {
+	case "", DefaultProvidersAlways, DefaultProvidersNever, DefaultProvidersPrompt:
+		return nil
+	}
+	return fmt.Errorf("unknown default providers policy %q", policy)
+}
+
+// defaultProvidersPolicy returns the policy for automatically installing the
+// default providers of the given snap.
+func defaultProvidersPolicy(st *state.State, snapName string) (string, error) {
+	tr := config.NewTransaction(st)
+	var overrides map[string]string
+	if err := tr.Get("core", "default-providers.overrides", &overrides); err != nil && !config.IsNoOption(err) {
+		return "", err
+	}
+	if policy := overrides[snapName]; policy != "" {
+		return policy, nil
+	}
+	var policy string
+	if err := tr.Get("core", "default-providers.policy", &policy); err != nil && !config.IsNoOption(err) {
+		return "", err
+	}
+	if policy == "" {
+		policy = DefaultProvidersAlways
+	}
+	return policy, nil
+}
+
+// filterDefaultProviders applies the default providers policy to the
+// prerequisites of the snap, returning the ones to install. The outcome is
+// recorded in the change of the task.
+func filterDefaultProviders(t *state.Task, snapsup *SnapSetup) ([]string, error) {
+	if len(snapsup.Prereq) == 0 {
+		return nil, nil
+	}
+	st := t.State()
+	snapName := snapsup.InstanceName()
+	policy, err := defaultProvidersPolicy(st, snapName)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, prereq := range snapsup.Prereq {
+		installed, err := isInstalled(st, prereq)
+		if err != nil {
+			return nil, err
+		}
+		if !installed {
+			missing = append(missing, prereq)
+		}
+	}
+	if len(missing) == 0 {
+		return snapsup.Prereq, nil
+	}
+
+	var install []string
+	needsApproval := false
+	switch policy {
+	case DefaultProvidersAlways:
+		install = snapsup.Prereq
+	case DefaultProvidersNever:
+		t.Logf("Not installing default providers %s: disabled by policy", strutil.Quoted(missing))
+	case DefaultProvidersPrompt:
+		if !snapsup.ApproveDefaultProviders && snapsup.IsAutoRefresh {
+			// nobody can approve an auto-refresh, which must not
+			// fail because of it
+			t.Logf("Not installing default providers %s: needs approval, install them explicitly", strutil.Quoted(missing))
+			needsApproval = true
+			break
+		}
+		if !snapsup.ApproveDefaultProviders {
+			return nil, fmt.Errorf("cannot install default providers %s of snap %q: automatic installation needs approval, retry with \"--approve-default-providers\"", strutil.Quoted(missing), snapName)
+		}
+		install = snapsup.Prereq
+	default:
+		return nil, fmt.Errorf("cannot install default providers of snap %q: unknown policy %q", snapName, policy)
+	}
+
+	providers := make([]*DefaultProvider, 0, len(missing))
+	for _, prereq := range missing {
+		p := &DefaultProvider{
+			Snap:        prereq,
+			ContentTags: snapsup.PrereqContentAttrs[prereq],
+			Policy:        policy,
+			Installed:     install != nil,
+			NeedsApproval: needsApproval,
+		}
+		if p.Installed {
+			what := "content"
+			if len(p.ContentTags) != 0 {
+				what = fmt.Sprintf("content %s", strings.Join(p.ContentTags, ", "))
+			}
+			t.Logf("Installing %q as the default provider of %s", prereq, what)
+		}
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Snap < providers[j].Snap })
+
+	chg := t.Change()
+	var all map[string][]*DefaultProvider
+	if err := chg.Get("default-providers", &all); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if all == nil {
+		all = make(map[string][]*DefaultProvider)
+	}
+	all[snapName] = providers
+	chg.Set("default-providers", all)
+
+	return install, nil
+}
@@ -41,6 +41,12 @@ func MockSnapReadInfo(mock func(name string, si *snap.SideInfo) (*snap.Info, err
 	return func() { snapReadInfo = old }
 }
 
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() { timeNow = old }
+}
+
 func MockMountPollInterval(intv time.Duration) (restore func()) {
 	old := mountPollInterval
 	mountPollInterval = intv
@@ -80,6 +86,18 @@ func MockOsutilEnsureUserGroup(mock func(name string, id uint32, extraUsers bool
 	return func() { osutilEnsureUserGroup = old }
 }
 
+func MockOsutilEnsureUserGroupInRange(mock func(name string, min, max uint32, extraUsers bool) (uint32, error)) (restore func()) {
+	old := osutilEnsureUserGroupInRange
+	osutilEnsureUserGroupInRange = mock
+	return func() { osutilEnsureUserGroupInRange = old }
+}
+
+func MockOsutilDelUserGroup(mock func(name string, extraUsers bool) error) (restore func()) {
+	old := osutilDelUserGroup
+	osutilDelUserGroup = mock
+	return func() { osutilDelUserGroup = old }
+}
+
 var (
 	CoreInfoInternal       = coreInfo
 	CheckSnap              = checkSnap
@@ -95,6 +113,7 @@ var (
 	CurrentSnaps = currentSnaps
 
 	DefaultContentPlugProviders = defaultContentPlugProviders
+	DefaultProviderContentAttrs = defaultProviderContentAttrs
 
 	HasOtherInstances = hasOtherInstances
 
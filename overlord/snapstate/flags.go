@@ -45,6 +45,15 @@ type Flags struct {
 	// IgnoreRunning is set to indicate that running apps or hooks should be ignored.
 	IgnoreRunning bool `json:"ignore-running,omitempty"`
 
+	// ApprovePolicyChanges is set when the user approved as one-off the
+	// high-risk changes to the security policy of the snap.
+	ApprovePolicyChanges bool `json:"approve-policy-changes,omitempty"`
+
+	// ApproveDefaultProviders is set when the user approved as one-off
+	// the automatic installation of the default content providers of
+	// the snap.
+	ApproveDefaultProviders bool `json:"approve-default-providers,omitempty"`
+
 	// Required is set to mark that a snap is required
 	// and cannot be removed
 	Required bool `json:"required,omitempty"`
@@ -248,10 +248,52 @@ func (m *SnapManager) doPrerequisites(t *state.Task, _ *tomb.Tomb) error {
 		base = snapsup.Base
 	}
 
-	if err := m.installPrereqs(t, base, snapsup.Prereq, snapsup.UserID, perfTimings); err != nil {
+	// a distribution provided runtime may stand in for the base
+	runtime, err := remappedBase(st, base)
+	if err != nil {
 		return err
 	}
+	if runtime != "" {
+		created, err := linkRemappedBase(st, base, runtime)
+		if err != nil {
+			return fmt.Errorf("cannot remap base %q: %v", base, err)
+		}
+		if created {
+			// undone when the install is
+			t.Set("remapped-base", base)
+		}
+		logger.Noticef("using runtime %q in place of base %q for snap %q", runtime, base, snapsup.InstanceName())
+		base = "none"
+	}
+
+	prereq, err := filterDefaultProviders(t, snapsup)
+	if err != nil {
+		return err
+	}
+
+	if err := m.installPrereqs(t, base, prereq, snapsup.UserID, perfTimings); err != nil {
+		return err
+	}
+
+	return nil
+}
 
+func (m *SnapManager) undoPrerequisites(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var base string
+	if err := t.Get("remapped-base", &base); err != nil {
+		if err == state.ErrNoState {
+			return nil
+		}
+		return err
+	}
+	// the snap whose install is undone no longer uses the base
+	if err := cleanupRemappedBases(st); err != nil {
+		return fmt.Errorf("cannot remove remapped base %q: %v", base, err)
+	}
 	return nil
 }
 
@@ -2040,6 +2082,14 @@ func (m *SnapManager) doClearSnapData(t *state.Task, _ *tomb.Tomb) error {
 		if err := m.backend.RemoveSnapDataDir(info, otherInstances); err != nil {
 			return err
 		}
+
+		// as well as the system usernames private to the snap, unless
+		// other instances still use them
+		if !otherInstances {
+			if err := removePrivateSystemUsernames(info); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -2131,6 +2181,12 @@ func (m *SnapManager) doDiscardSnap(t *state.Task, _ *tomb.Tomb) error {
 		return err
 	}
 	Set(st, snapsup.InstanceName(), snapst)
+
+	// the discarded revision may have been the last user of a remapped
+	// base
+	if err := cleanupRemappedBases(st); err != nil {
+		logger.Noticef("cannot remove unused remapped bases: %v", err)
+	}
 	return nil
 }
 
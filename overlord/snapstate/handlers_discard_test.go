@@ -20,12 +20,17 @@
 package snapstate_test
 
 import (
+	"os"
+	"path/filepath"
+
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type discardSnapSuite struct {
@@ -75,6 +80,56 @@ func (s *discardSnapSuite) TestDoDiscardSnapSuccess(c *C) {
 	c.Check(t.Status(), Equals, state.DoneStatus)
 }
 
+func (s *discardSnapSuite) TestDoDiscardSnapCleansUpRemappedBase(c *C) {
+	runtime := filepath.Join(dirs.SnapRuntimesDir, "core18")
+	current := filepath.Join(dirs.SnapMountDir, "core18/current")
+	c.Assert(os.MkdirAll(filepath.Dir(current), 0755), IsNil)
+	c.Assert(os.Symlink(runtime, current), IsNil)
+
+	s.state.Lock()
+	s.state.Set("remapped-bases", map[string]string{"core18": runtime})
+	snapstate.Set(s.state, "some-snap-with-base", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap-with-base", Revision: snap.R(3)},
+			{RealName: "some-snap-with-base", Revision: snap.R(33)},
+		},
+		Current:  snap.R(3),
+		SnapType: "app",
+	})
+	chg := s.state.NewChange("dummy", "...")
+	var prev *state.Task
+	for _, rev := range []snap.Revision{snap.R(33), snap.R(3)} {
+		t := s.state.NewTask("discard-snap", "test")
+		t.Set("snap-setup", &snapstate.SnapSetup{
+			SideInfo: &snap.SideInfo{
+				RealName: "some-snap-with-base",
+				Revision: rev,
+			},
+		})
+		if prev != nil {
+			t.WaitFor(prev)
+		}
+		chg.AddTask(t)
+		prev = t
+	}
+	s.state.Unlock()
+
+	// the remaining revision still uses the runtime
+	s.se.Ensure()
+	s.se.Wait()
+	c.Check(current, testutil.SymlinkTargetEquals, runtime)
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(current, testutil.FileAbsent)
+	var remapped map[string]string
+	c.Check(s.state.Get("remapped-bases", &remapped), Equals, state.ErrNoState)
+}
+
 func (s *discardSnapSuite) TestDoDiscardSnapToEmpty(c *C) {
 	s.state.Lock()
 	snapstate.Set(s.state, "foo", &snapstate.SnapState{
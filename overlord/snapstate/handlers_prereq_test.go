@@ -21,18 +21,23 @@ package snapstate_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/tomb.v2"
 
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type prereqSuite struct {
@@ -134,6 +139,354 @@ func (s *prereqSuite) TestDoPrereqWithBaseNone(c *C) {
 	c.Check(linkedSnaps, DeepEquals, expectedLinkedSnaps)
 }
 
+func (s *prereqSuite) runPrereqWithDefaultProviders(c *C, policy, override string, flags snapstate.Flags) (*state.Change, *state.Task, []string) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	if policy != "" {
+		c.Assert(tr.Set("core", "default-providers.policy", policy), IsNil)
+	}
+	if override != "" {
+		c.Assert(tr.Set("core", "default-providers.overrides.foo", override), IsNil)
+	}
+	tr.Commit()
+
+	t := s.state.NewTask("prerequisites", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(33),
+		},
+		Base:   "none",
+		Prereq: []string{"prereq1"},
+		PrereqContentAttrs: map[string][]string{
+			"prereq1": {"some-content"},
+		},
+		Flags: flags,
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var linkedSnaps []string
+	for _, t := range chg.Tasks() {
+		if t.Kind() == "link-snap" {
+			snapsup, err := snapstate.TaskSnapSetup(t)
+			c.Assert(err, IsNil)
+			linkedSnaps = append(linkedSnaps, snapsup.InstanceName())
+		}
+	}
+	return chg, t, linkedSnaps
+}
+
+func (s *prereqSuite) checkDefaultProvidersReport(c *C, chg *state.Change, policy string, installed bool) {
+	var report map[string][]*snapstate.DefaultProvider
+	c.Assert(chg.Get("default-providers", &report), IsNil)
+	c.Check(report, DeepEquals, map[string][]*snapstate.DefaultProvider{
+		"foo": {{
+			Snap:        "prereq1",
+			ContentTags: []string{"some-content"},
+			Policy:      policy,
+			Installed:   installed,
+		}},
+	})
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersAlways(c *C) {
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "", "", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(linked, DeepEquals, []string{"prereq1", "snapd"})
+	s.checkDefaultProvidersReport(c, chg, "always", true)
+	c.Check(t.Log(), HasLen, 1)
+	c.Check(t.Log()[0], Matches, `.* Installing "prereq1" as the default provider of content some-content`)
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersNever(c *C) {
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "never", "", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(linked, DeepEquals, []string{"snapd"})
+	s.checkDefaultProvidersReport(c, chg, "never", false)
+	c.Check(t.Log(), HasLen, 1)
+	c.Check(t.Log()[0], Matches, `.* Not installing default providers "prereq1": disabled by policy`)
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersPromptNotApproved(c *C) {
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "prompt", "", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot install default providers "prereq1" of snap "foo": automatic installation needs approval, retry with "--approve-default-providers".*`)
+	c.Check(linked, HasLen, 0)
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersPromptApproved(c *C) {
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "prompt", "", snapstate.Flags{ApproveDefaultProviders: true})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(linked, DeepEquals, []string{"prereq1", "snapd"})
+	s.checkDefaultProvidersReport(c, chg, "prompt", true)
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersPromptAutoRefresh(c *C) {
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "prompt", "", snapstate.Flags{IsAutoRefresh: true})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	// the refresh goes on without the provider
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(linked, DeepEquals, []string{"snapd"})
+	var report map[string][]*snapstate.DefaultProvider
+	c.Assert(chg.Get("default-providers", &report), IsNil)
+	c.Check(report, DeepEquals, map[string][]*snapstate.DefaultProvider{
+		"foo": {{
+			Snap:          "prereq1",
+			ContentTags:   []string{"some-content"},
+			Policy:        "prompt",
+			NeedsApproval: true,
+		}},
+	})
+	c.Check(t.Log(), HasLen, 1)
+	c.Check(t.Log()[0], Matches, `.* Not installing default providers "prereq1": needs approval, install them explicitly`)
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersOverride(c *C) {
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "never", "always", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(linked, DeepEquals, []string{"prereq1", "snapd"})
+	s.checkDefaultProvidersReport(c, chg, "always", true)
+}
+
+func (s *prereqSuite) TestDoPrereqDefaultProvidersAlreadyInstalled(c *C) {
+	s.state.Lock()
+	snapstate.Set(s.state, "prereq1", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "prereq1", Revision: snap.R(1)},
+		},
+		Current: snap.R(1),
+	})
+	s.state.Unlock()
+
+	chg, t, linked := s.runPrereqWithDefaultProviders(c, "prompt", "", snapstate.Flags{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(linked, DeepEquals, []string{"snapd"})
+	var report map[string][]*snapstate.DefaultProvider
+	c.Check(chg.Get("default-providers", &report), Equals, state.ErrNoState)
+}
+
+func (s *prereqSuite) mockRuntime(c *C, base string) string {
+	runtime := filepath.Join(dirs.SnapRuntimesDir, base)
+	c.Assert(os.MkdirAll(filepath.Join(runtime, "usr/lib"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(runtime, "usr/lib/os-release"), []byte("ID=distro\n"), 0644), IsNil)
+	return runtime
+}
+
+// runPrereqWithBase runs a prerequisites task for a snap with the given
+// base, followed in its change by pending tasks of the given kinds.
+func (s *prereqSuite) runPrereqWithBase(c *C, base string, laterKinds ...string) (*state.Change, *state.Task) {
+	s.state.Lock()
+	snapstate.Set(s.state, "core", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "core", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "os",
+	})
+
+	t := s.state.NewTask("prerequisites", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(33),
+		},
+		Base: base,
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	for _, kind := range laterKinds {
+		later := s.state.NewTask(kind, "later task")
+		later.WaitFor(t)
+		chg.AddTask(later)
+	}
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	return chg, t
+}
+
+func (s *prereqSuite) TestDoPrereqRemappedBase(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	runtime := s.mockRuntime(c, "some-base")
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "experimental.base-remapping", true)
+	tr.Commit()
+	s.state.Unlock()
+
+	chg, t := s.runPrereqWithBase(c, "some-base")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	// the base is not installed from the store
+	c.Check(s.fakeBackend.ops, HasLen, 0)
+	c.Check(chg.Tasks(), HasLen, 1)
+	target, err := os.Readlink(filepath.Join(dirs.SnapMountDir, "some-base/current"))
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, runtime)
+}
+
+func (s *prereqSuite) testUndoPrereqRemappedBase(c *C, inUse bool) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	runtime := s.mockRuntime(c, "core18")
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "experimental.base-remapping", true)
+	tr.Commit()
+
+	if inUse {
+		// another snap got installed using the runtime meanwhile
+		snapstate.Set(s.state, "some-snap-with-base", &snapstate.SnapState{
+			Active: true,
+			Sequence: []*snap.SideInfo{
+				{RealName: "some-snap-with-base", Revision: snap.R(1)},
+			},
+			Current:  snap.R(1),
+			SnapType: "app",
+		})
+	}
+	s.state.Unlock()
+
+	// a later task of the change fails and undoes the prerequisites
+	chg, t := s.runPrereqWithBase(c, "core18", "error-trigger")
+
+	current := filepath.Join(dirs.SnapMountDir, "core18/current")
+	s.state.Lock()
+	c.Assert(t.Status(), Equals, state.DoneStatus)
+	var remapped map[string]string
+	c.Assert(s.state.Get("remapped-bases", &remapped), IsNil)
+	c.Check(remapped, DeepEquals, map[string]string{"core18": runtime})
+
+	s.state.Unlock()
+
+	for i := 0; i < 3; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.UndoneStatus, Commentf("%v", chg.Err()))
+	remapped = nil
+	err := s.state.Get("remapped-bases", &remapped)
+	if inUse {
+		c.Assert(err, IsNil)
+		c.Check(remapped, DeepEquals, map[string]string{"core18": runtime})
+		target, err := os.Readlink(current)
+		c.Assert(err, IsNil)
+		c.Check(target, Equals, runtime)
+	} else {
+		c.Check(err, Equals, state.ErrNoState)
+		c.Check(current, testutil.FileAbsent)
+		c.Check(filepath.Dir(current), testutil.FileAbsent)
+	}
+}
+
+func (s *prereqSuite) TestUndoPrereqRemappedBase(c *C) {
+	s.testUndoPrereqRemappedBase(c, false)
+}
+
+func (s *prereqSuite) TestUndoPrereqRemappedBaseInUse(c *C) {
+	s.testUndoPrereqRemappedBase(c, true)
+}
+
+func (s *prereqSuite) TestDoPrereqRemappedBaseFeatureDisabled(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	s.mockRuntime(c, "some-base")
+
+	chg, t := s.runPrereqWithBase(c, "some-base")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	var linkedSnaps []string
+	for _, t := range chg.Tasks() {
+		if t.Kind() == "link-snap" {
+			snapsup, err := snapstate.TaskSnapSetup(t)
+			c.Assert(err, IsNil)
+			linkedSnaps = append(linkedSnaps, snapsup.InstanceName())
+		}
+	}
+	c.Check(linkedSnaps, DeepEquals, []string{"some-base"})
+	c.Check(filepath.Join(dirs.SnapMountDir, "some-base/current"), testutil.FileAbsent)
+}
+
+func (s *prereqSuite) TestDoPrereqRemappedBaseNotOnCore(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.mockRuntime(c, "some-base")
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "experimental.base-remapping", true)
+	tr.Commit()
+	s.state.Unlock()
+
+	chg, _ := s.runPrereqWithBase(c, "some-base")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(len(chg.Tasks()) > 1, Equals, true)
+	c.Check(filepath.Join(dirs.SnapMountDir, "some-base/current"), testutil.FileAbsent)
+}
+
+func (s *prereqSuite) TestDoPrereqRemappedBaseBadRuntime(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SnapRuntimesDir, "some-base"), 0755), IsNil)
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "experimental.base-remapping", true)
+	tr.Commit()
+	s.state.Unlock()
+
+	chg, t := s.runPrereqWithBase(c, "some-base")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot use runtime ".*/usr/lib/snapd/runtimes/some-base" for base "some-base": missing usr/lib/os-release.*`)
+}
+
 func (s *prereqSuite) TestDoPrereqTalksToStoreAndQueues(c *C) {
 	s.state.Lock()
 
@@ -88,6 +88,9 @@ type SnapSetup struct {
 	// together with this snap. Typically used when installing
 	// content-snaps with default-providers.
 	Prereq []string `json:"prereq,omitempty"`
+	// PrereqContentAttrs maps the snaps in Prereq to the content tags
+	// they are expected to provide.
+	PrereqContentAttrs map[string][]string `json:"prereq-content-attrs,omitempty"`
 
 	Flags
 
@@ -294,6 +297,10 @@ var AutomaticSnapshot func(st *state.State, instanceName string) (ts *state.Task
 var AutomaticSnapshotExpiration func(st *state.State) (time.Duration, error)
 var EstimateSnapshotSize func(st *state.State, instanceName string, users []string) (uint64, error)
 
+// AutomaticSnapshotBeforeRefresh allows to hook snapshot manager's policy
+// deciding whether to take an automatic snapshot before a refresh.
+var AutomaticSnapshotBeforeRefresh func(st *state.State, cur, update *snap.Info) (bool, error)
+
 func readInfo(name string, si *snap.SideInfo, flags int) (*snap.Info, error) {
 	info, err := snapReadInfo(name, si)
 	if err != nil && flags&errorOnBroken != 0 {
@@ -429,7 +436,7 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 
 	// TODO: no undo handler here, we may use the GC for this and just
 	// remove anything that is not referenced anymore
-	runner.AddHandler("prerequisites", m.doPrerequisites, nil)
+	runner.AddHandler("prerequisites", m.doPrerequisites, m.undoPrerequisites)
 	runner.AddHandler("prepare-snap", m.doPrepareSnap, m.undoPrepareSnap)
 	runner.AddHandler("download-snap", m.doDownloadSnap, m.undoPrepareSnap)
 	runner.AddHandler("mount-snap", m.doMountSnap, m.undoMountSnap)
@@ -54,6 +54,7 @@ import (
 // control flags for doInstall
 const (
 	skipConfigure = 1 << iota
+	autoSnapshot
 )
 
 // control flags for "Configure()"
@@ -248,6 +249,22 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 		addTask(stop)
 		prev = stop
 
+		// save the data of the current revision (needs stopped services)
+		if flags&autoSnapshot != 0 {
+			snapshotTs, err := AutomaticSnapshot(st, snapsup.InstanceName())
+			switch err {
+			case nil:
+				for _, t := range snapshotTs.Tasks() {
+					t.WaitFor(prev)
+					tasks = append(tasks, t)
+					prev = t
+				}
+			case ErrNothingToDo:
+			default:
+				return nil, err
+			}
+		}
+
 		removeAliases := st.NewTask("remove-aliases", fmt.Sprintf(i18n.G("Remove aliases for snap %q"), snapsup.InstanceName()))
 		addTask(removeAliases)
 		prev = removeAliases
@@ -281,6 +298,14 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 	addTask(linkSnap)
 	prev = linkSnap
 
+	if !release.OnClassic && snapsup.Type == snap.TypeSnapd {
+		// the built-in boot config assets of snapd may have changed,
+		// placed after link-snap so that the new snapd updates them
+		updateManaged := st.NewTask("update-managed-boot-config", fmt.Sprintf(i18n.G("Update managed boot config assets from %q%s"), snapsup.InstanceName(), revisionStr))
+		addTask(updateManaged)
+		prev = updateManaged
+	}
+
 	// auto-connections
 	autoConnect := st.NewTask("auto-connect", fmt.Sprintf(i18n.G("Automatically connect eligible plugs and slots of snap %q"), snapsup.InstanceName()))
 	addTask(autoConnect)
@@ -596,6 +621,29 @@ func defaultContentPlugProviders(st *state.State, info *snap.Info) []string {
 	return out
 }
 
+// defaultProviderContentAttrs takes a snap.Info and returns the default
+// providers there are, mapped to the content tags they are needed for.
+func defaultProviderContentAttrs(st *state.State, info *snap.Info) map[string][]string {
+	needed := snap.NeededDefaultProviders(info)
+	if len(needed) == 0 {
+		return nil
+	}
+	avail := contentIfaceAvailable(st)
+	var out map[string][]string
+	for snapInstance, contentTags := range needed {
+		for _, contentTag := range contentTags {
+			if avail[contentTag] {
+				continue
+			}
+			if out == nil {
+				out = make(map[string][]string)
+			}
+			out[snapInstance] = append(out[snapInstance], contentTag)
+		}
+	}
+	return out
+}
+
 // validateFeatureFlags validates the given snap only uses experimental
 // features that are enabled by the user.
 func validateFeatureFlags(st *state.State, info *snap.Info) error {
@@ -672,6 +720,9 @@ func ensureInstallPreconditions(st *state.State, info *snap.Info, flags Flags, s
 	if err := checkDBusServiceConflicts(st, info); err != nil {
 		return flags, err
 	}
+	if err := checkSystemPolicy(st, info, flags, snapst, deviceCtx); err != nil {
+		return flags, err
+	}
 	return flags, nil
 }
 
@@ -683,6 +734,33 @@ func ensureInstallPreconditions(st *state.State, info *snap.Info, flags Flags, s
 // local revision and sideloading, or full metadata in which case it
 // the snap will appear as installed from the store.
 func InstallPath(st *state.State, si *snap.SideInfo, path, instanceName, channel string, flags Flags) (*state.TaskSet, *snap.Info, error) {
+	return installPath(st, si, path, instanceName, channel, flags, nil, "")
+}
+
+// InstallPathWithDeviceContext returns a set of tasks for installing a snap
+// from a file path, like InstallPath, but in the context of the given
+// deviceCtx and ignoring conflicts with fromChange. It is meant to be used
+// when remodeling from snaps provided locally.
+// Note that the state must be locked by the caller.
+//
+// The returned TaskSet will contain a DownloadAndChecksDoneEdge.
+func InstallPathWithDeviceContext(st *state.State, si *snap.SideInfo, path, channel string, flags Flags, deviceCtx DeviceContext, fromChange string) (*state.TaskSet, error) {
+	ts, _, err := installPath(st, si, path, "", channel, flags, deviceCtx, fromChange)
+	if err != nil {
+		return nil, err
+	}
+	// there is nothing to download nor assertions to check for a local
+	// snap, preparing it is the last step before installing it
+	for _, t := range ts.Tasks() {
+		if t.Kind() == "prepare-snap" {
+			ts.MarkEdge(t, DownloadAndChecksDoneEdge)
+			break
+		}
+	}
+	return ts, nil
+}
+
+func installPath(st *state.State, si *snap.SideInfo, path, instanceName, channel string, flags Flags, deviceCtx DeviceContext, fromChange string) (*state.TaskSet, *snap.Info, error) {
 	if si.RealName == "" {
 		return nil, nil, fmt.Errorf("internal error: snap name to install %q not provided", path)
 	}
@@ -691,7 +769,7 @@ func InstallPath(st *state.State, si *snap.SideInfo, path, instanceName, channel
 		instanceName = si.RealName
 	}
 
-	deviceCtx, err := DeviceCtxFromState(st, nil)
+	deviceCtx, err := DeviceCtxFromState(st, deviceCtx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -750,18 +828,19 @@ func InstallPath(st *state.State, si *snap.SideInfo, path, instanceName, channel
 	}
 
 	snapsup := &SnapSetup{
-		Base:        info.Base,
-		Prereq:      defaultContentPlugProviders(st, info),
-		SideInfo:    si,
-		SnapPath:    path,
-		Channel:     channel,
-		Flags:       flags.ForSnapSetup(),
-		Type:        info.Type(),
-		PlugsOnly:   len(info.Slots) == 0,
-		InstanceKey: info.InstanceKey,
-	}
-
-	ts, err := doInstall(st, &snapst, snapsup, instFlags, "", inUseFor(deviceCtx))
+		Base:               info.Base,
+		Prereq:             defaultContentPlugProviders(st, info),
+		PrereqContentAttrs: defaultProviderContentAttrs(st, info),
+		SideInfo:           si,
+		SnapPath:           path,
+		Channel:            channel,
+		Flags:              flags.ForSnapSetup(),
+		Type:               info.Type(),
+		PlugsOnly:          len(info.Slots) == 0,
+		InstanceKey:        info.InstanceKey,
+	}
+
+	ts, err := doInstall(st, &snapst, snapsup, instFlags, fromChange, inUseFor(deviceCtx))
 	return ts, info, err
 }
 
@@ -859,16 +938,17 @@ func InstallWithDeviceContext(ctx context.Context, st *state.State, name string,
 	}
 
 	snapsup := &SnapSetup{
-		Channel:      opts.Channel,
-		Base:         info.Base,
-		Prereq:       defaultContentPlugProviders(st, info),
-		UserID:       userID,
-		Flags:        flags.ForSnapSetup(),
-		DownloadInfo: &info.DownloadInfo,
-		SideInfo:     &info.SideInfo,
-		Type:         info.Type(),
-		PlugsOnly:    len(info.Slots) == 0,
-		InstanceKey:  info.InstanceKey,
+		Channel:            opts.Channel,
+		Base:               info.Base,
+		Prereq:             defaultContentPlugProviders(st, info),
+		PrereqContentAttrs: defaultProviderContentAttrs(st, info),
+		UserID:             userID,
+		Flags:              flags.ForSnapSetup(),
+		DownloadInfo:       &info.DownloadInfo,
+		SideInfo:           &info.SideInfo,
+		Type:               info.Type(),
+		PlugsOnly:          len(info.Slots) == 0,
+		InstanceKey:        info.InstanceKey,
 		auxStoreInfo: auxStoreInfo{
 			Media:   info.Media,
 			Website: info.Website,
@@ -968,16 +1048,17 @@ func InstallMany(st *state.State, names []string, userID int) ([]string, []*stat
 		}
 
 		snapsup := &SnapSetup{
-			Channel:      channel,
-			Base:         info.Base,
-			Prereq:       defaultContentPlugProviders(st, info),
-			UserID:       userID,
-			Flags:        flags.ForSnapSetup(),
-			DownloadInfo: &info.DownloadInfo,
-			SideInfo:     &info.SideInfo,
-			Type:         info.Type(),
-			PlugsOnly:    len(info.Slots) == 0,
-			InstanceKey:  info.InstanceKey,
+			Channel:            channel,
+			Base:               info.Base,
+			Prereq:             defaultContentPlugProviders(st, info),
+			PrereqContentAttrs: defaultProviderContentAttrs(st, info),
+			UserID:             userID,
+			Flags:              flags.ForSnapSetup(),
+			DownloadInfo:       &info.DownloadInfo,
+			SideInfo:           &info.SideInfo,
+			Type:               info.Type(),
+			PlugsOnly:          len(info.Slots) == 0,
+			InstanceKey:        info.InstanceKey,
 		}
 
 		ts, err := doInstall(st, &snapst, snapsup, 0, "", inUseFor(deviceCtx))
@@ -1108,6 +1189,27 @@ func updateManyFiltered(ctx context.Context, st *state.State, names []string, us
 	return updated, tasksets, nil
 }
 
+// refreshSnapshotFlags returns the doInstall flags requesting an automatic
+// snapshot of the snap data if the snapshot policy asks for one before
+// refreshing to update.
+func refreshSnapshotFlags(st *state.State, snapst *SnapState, update *snap.Info) (int, error) {
+	if AutomaticSnapshotBeforeRefresh == nil || update.Type() != snap.TypeApp {
+		return 0, nil
+	}
+	cur, err := snapst.CurrentInfo()
+	if err != nil {
+		if err == ErrNoCurrent {
+			return 0, nil
+		}
+		return 0, err
+	}
+	needed, err := AutomaticSnapshotBeforeRefresh(st, cur, update)
+	if err != nil || !needed {
+		return 0, err
+	}
+	return autoSnapshot, nil
+}
+
 func doUpdate(ctx context.Context, st *state.State, names []string, updates []*snap.Info, params func(*snap.Info) (*RevisionOptions, Flags, *SnapState), userID int, globalFlags *Flags, deviceCtx DeviceContext, fromChange string) ([]string, []*state.TaskSet, error) {
 	if globalFlags == nil {
 		globalFlags = &Flags{}
@@ -1192,24 +1294,30 @@ func doUpdate(ctx context.Context, st *state.State, names []string, updates []*s
 		}
 
 		snapsup := &SnapSetup{
-			Base:         update.Base,
-			Prereq:       defaultContentPlugProviders(st, update),
-			Channel:      revnoOpts.Channel,
-			CohortKey:    revnoOpts.CohortKey,
-			UserID:       snapUserID,
-			Flags:        flags.ForSnapSetup(),
-			DownloadInfo: &update.DownloadInfo,
-			SideInfo:     &update.SideInfo,
-			Type:         update.Type(),
-			PlugsOnly:    len(update.Slots) == 0,
-			InstanceKey:  update.InstanceKey,
+			Base:               update.Base,
+			Prereq:             defaultContentPlugProviders(st, update),
+			PrereqContentAttrs: defaultProviderContentAttrs(st, update),
+			Channel:            revnoOpts.Channel,
+			CohortKey:          revnoOpts.CohortKey,
+			UserID:             snapUserID,
+			Flags:              flags.ForSnapSetup(),
+			DownloadInfo:       &update.DownloadInfo,
+			SideInfo:           &update.SideInfo,
+			Type:               update.Type(),
+			PlugsOnly:          len(update.Slots) == 0,
+			InstanceKey:        update.InstanceKey,
 			auxStoreInfo: auxStoreInfo{
 				Website: update.Website,
 				Media:   update.Media,
 			},
 		}
 
-		ts, err := doInstall(st, snapst, snapsup, 0, fromChange, inUseFor(deviceCtx))
+		instFlags, err := refreshSnapshotFlags(st, snapst, update)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ts, err := doInstall(st, snapst, snapsup, instFlags, fromChange, inUseFor(deviceCtx))
 		if err != nil {
 			if refreshAll {
 				// doing "refresh all", just skip this snap
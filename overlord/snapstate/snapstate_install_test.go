@@ -75,6 +75,9 @@ func verifyInstallTasks(c *C, opts, discards int, ts *state.TaskSet, st *state.S
 		"setup-profiles",
 		"link-snap",
 	)
+	if opts&updatesBootConfig != 0 {
+		expected = append(expected, "update-managed-boot-config")
+	}
 	expected = append(expected,
 		"auto-connect",
 		"set-auto-aliases",
@@ -189,6 +192,9 @@ version: 1.0
 }
 
 func (s *snapmgrTestSuite) TestInstallSnapdSnapType(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
 	s.state.Lock()
 	defer s.state.Unlock()
 
@@ -203,6 +209,20 @@ func (s *snapmgrTestSuite) TestInstallSnapdSnapType(c *C) {
 	c.Check(snapsup.Type, Equals, snap.TypeSnapd)
 }
 
+func (s *snapmgrTestSuite) TestInstallSnapdSnapTypeOnCore(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	opts := &snapstate.RevisionOptions{Channel: "some-channel"}
+	ts, err := snapstate.Install(context.Background(), s.state, "snapd", opts, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+
+	verifyInstallTasks(c, noConfigure|updatesBootConfig, 0, ts, s.state)
+}
+
 func (s *snapmgrTestSuite) TestInstallCohortTasks(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -695,6 +715,36 @@ func (s *snapmgrTestSuite) TestInstallPathConflict(c *C) {
 	c.Assert(err, ErrorMatches, `snap "some-snap" has "install" change in progress`)
 }
 
+func (s *snapmgrTestSuite) TestInstallPathWithDeviceContext(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// a conflicting change, that is the one we come from
+	ts, err := snapstate.Install(context.Background(), s.state, "other-snap", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("install", "...")
+	chg.AddAll(ts)
+
+	// no model from the state, it needs to come via the device context
+	r := snapstatetest.MockDeviceModel(nil)
+	defer r()
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: DefaultModel()}
+
+	mockSnap := makeTestSnap(c, "name: other-snap\nversion: 1.0")
+	_, err = snapstate.InstallPathWithDeviceContext(s.state, &snap.SideInfo{RealName: "other-snap"}, mockSnap, "", snapstate.Flags{}, deviceCtx, "")
+	c.Assert(err, ErrorMatches, `snap "other-snap" has "install" change in progress`)
+
+	ts, err = snapstate.InstallPathWithDeviceContext(s.state, &snap.SideInfo{RealName: "other-snap"}, mockSnap, "", snapstate.Flags{}, deviceCtx, chg.ID())
+	c.Assert(err, IsNil)
+
+	// preparing the local snap marks the end of the download phase
+	prepare, err := ts.Edge(snapstate.DownloadAndChecksDoneEdge)
+	c.Assert(err, IsNil)
+	c.Check(prepare.Kind(), Equals, "prepare-snap")
+	c.Check(ts.Tasks()[0].Kind(), Equals, "prerequisites")
+	c.Check(ts.Tasks()[2].Kind(), Equals, "mount-snap")
+}
+
 func (s *snapmgrTestSuite) TestInstallPathMissingName(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -725,6 +775,84 @@ confinement: devmode
 	c.Assert(err, ErrorMatches, `.* requires devmode or confinement override`)
 }
 
+func (s *snapmgrTestSuite) TestInstallPathSystemPolicyDisallowed(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var checked []string
+	snapstate.SystemPolicyAllows = func(st *state.State, action string, deviceCtx snapstate.DeviceContext) (bool, error) {
+		c.Check(deviceCtx, NotNil)
+		checked = append(checked, action)
+		return action != snapstate.SystemPolicyDangerous, nil
+	}
+	now := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	restore := snapstate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	mockSnap := makeTestSnap(c, "name: some-snap\nversion: 1.0")
+	_, _, err := snapstate.InstallPath(s.state, &snap.SideInfo{RealName: "some-snap"}, mockSnap, "", "", snapstate.Flags{DevMode: true})
+	c.Check(err, FitsTypeOf, &snapstate.SystemPolicyError{})
+	c.Assert(err, ErrorMatches, `cannot install snap "some-snap": dangerous installs are disallowed by the system policy`)
+	c.Check(checked, DeepEquals, []string{"dangerous"})
+
+	violations, err := snapstate.SystemPolicyViolations(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(violations, HasLen, 1)
+	c.Check(violations[0].Snap, Equals, "some-snap")
+	c.Check(violations[0].Action, Equals, "dangerous")
+	c.Check(violations[0].Time.Equal(now), Equals, true)
+
+	warns := s.state.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].String(), Equals, `attempt to install snap "some-snap" in violation of the system policy: dangerous installs are disallowed`)
+}
+
+func (s *snapmgrTestSuite) TestInstallSystemPolicyDevMode(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var checked []string
+	snapstate.SystemPolicyAllows = func(st *state.State, action string, deviceCtx snapstate.DeviceContext) (bool, error) {
+		checked = append(checked, action)
+		return action != snapstate.SystemPolicyDevMode, nil
+	}
+
+	// installs from the store without devmode are allowed
+	_, err := snapstate.Install(context.Background(), s.state, "some-snap", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	c.Check(checked, HasLen, 0)
+
+	_, err = snapstate.Install(context.Background(), s.state, "some-snap", nil, 0, snapstate.Flags{DevMode: true})
+	c.Assert(err, ErrorMatches, `cannot install snap "some-snap": devmode installs are disallowed by the system policy`)
+	c.Check(checked, DeepEquals, []string{"devmode"})
+
+	violations, err := snapstate.SystemPolicyViolations(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(violations, HasLen, 1)
+	c.Check(violations[0].Action, Equals, "devmode")
+}
+
+func (s *snapmgrTestSuite) TestInstallPathSystemPolicyNotSeeded(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.state.Set("seeded", nil)
+	snapstate.SystemPolicyAllows = func(st *state.State, action string, deviceCtx snapstate.DeviceContext) (bool, error) {
+		c.Fatalf("unexpected system policy check")
+		return false, nil
+	}
+
+	// the policy does not apply to seeding
+	mockSnap := makeTestSnap(c, "name: some-snap\nversion: 1.0")
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: DefaultModel()}
+	_, err := snapstate.InstallPathWithDeviceContext(s.state, &snap.SideInfo{RealName: "some-snap"}, mockSnap, "", snapstate.Flags{}, deviceCtx, "")
+	c.Assert(err, IsNil)
+
+	violations, err := snapstate.SystemPolicyViolations(s.state)
+	c.Assert(err, IsNil)
+	c.Check(violations, HasLen, 0)
+}
+
 func (s *snapmgrTestSuite) TestInstallPathStrictIgnoresClassic(c *C) {
 	restore := maybeMockClassicSupport(c)
 	defer restore()
@@ -225,6 +225,64 @@ func (s *snapmgrTestSuite) TestRemoveDiskSpaceForSnapshotError(c *C) {
 	c.Check(diskSpaceErr.ChangeKind, Equals, "remove")
 }
 
+func (s *snapmgrTestSuite) testRemovePrivateSystemUsernames(c *C, otherInstances bool) []string {
+	var removed []string
+	restore := snapstate.MockOsutilDelUserGroup(func(name string, extraUsers bool) error {
+		removed = append(removed, name)
+		return nil
+	})
+	defer restore()
+
+	si := snap.SideInfo{
+		SnapID:   "private-users-snap-id",
+		RealName: "private-users-snap",
+		Revision: snap.R(7),
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "private-users-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Current:  si.Revision,
+		SnapType: "app",
+	})
+	if otherInstances {
+		snapstate.Set(s.state, "private-users-snap_instance", &snapstate.SnapState{
+			Active:      true,
+			Sequence:    []*snap.SideInfo{&si},
+			Current:     si.Revision,
+			SnapType:    "app",
+			InstanceKey: "instance",
+		})
+	}
+
+	chg := s.state.NewChange("remove", "remove a snap")
+	ts, err := snapstate.Remove(s.state, "private-users-snap", snap.R(0), nil)
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.se.Stop()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	return removed
+}
+
+func (s *snapmgrTestSuite) TestRemovePrivateSystemUsernames(c *C) {
+	removed := s.testRemovePrivateSystemUsernames(c, false)
+	// the shared system username is kept
+	c.Check(removed, DeepEquals, []string{"snap_private-users-snap"})
+}
+
+func (s *snapmgrTestSuite) TestRemovePrivateSystemUsernamesOtherInstances(c *C) {
+	removed := s.testRemovePrivateSystemUsernames(c, true)
+	c.Check(removed, HasLen, 0)
+}
+
 func (s *snapmgrTestSuite) TestRemoveRunThrough(c *C) {
 	c.Assert(snapstate.KeepAuxStoreInfo("some-snap-id", nil), IsNil)
 	c.Check(snapstate.AuxStoreInfoFilename("some-snap-id"), testutil.FilePresent)
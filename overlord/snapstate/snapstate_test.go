@@ -225,6 +225,7 @@ func (s *snapmgrTestSuite) TearDownTest(c *C) {
 	snapstate.ValidateRefreshes = nil
 	snapstate.AutoAliases = nil
 	snapstate.CanAutoRefresh = nil
+	snapstate.SystemPolicyAllows = nil
 }
 
 type ForeignTaskTracker interface {
@@ -271,6 +272,9 @@ func AddForeignTaskHandlers(runner *state.TaskRunner, tracker ForeignTaskTracker
 	runner.AddHandler("configure-snapd", func(t *state.Task, _ *tomb.Tomb) error {
 		return nil
 	}, nil)
+	runner.AddHandler("update-managed-boot-config", func(t *state.Task, _ *tomb.Tomb) error {
+		return nil
+	}, nil)
 
 }
 
@@ -381,6 +385,7 @@ const (
 	doesReRefresh
 	updatesGadget
 	noConfigure
+	updatesBootConfig
 )
 
 func taskKinds(tasks []*state.Task) []string {
@@ -3998,6 +4003,13 @@ func (snapStateSuite) TestDefaultContentPlugProviders(c *C) {
 	providers := snapstate.DefaultContentPlugProviders(st, info)
 	sort.Strings(providers)
 	c.Check(providers, DeepEquals, []string{"common-themes", "some-snap"})
+
+	attrs := snapstate.DefaultProviderContentAttrs(st, info)
+	sort.Strings(attrs["common-themes"])
+	c.Check(attrs, DeepEquals, map[string][]string{
+		"common-themes": {"bar", "foo"},
+		"some-snap":     {"baz"},
+	})
 }
 
 func (s *snapmgrTestSuite) testRevertSequence(c *C, opts *opSeqOpts) *state.TaskSet {
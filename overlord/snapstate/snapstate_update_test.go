@@ -701,6 +701,49 @@ func (s *snapmgrTestSuite) TestUpdateTasks(c *C) {
 	c.Check(snapsup.Channel, Equals, "some-channel")
 }
 
+func (s *snapmgrTestSuite) TestUpdateTasksWithAutomaticSnapshot(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:          true,
+		TrackingChannel: "latest/edge",
+		Sequence:        []*snap.SideInfo{{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(7)}},
+		Current:         snap.R(7),
+		SnapType:        "app",
+	})
+
+	var curRev, updateRev snap.Revision
+	snapstate.AutomaticSnapshotBeforeRefresh = func(st *state.State, cur, update *snap.Info) (bool, error) {
+		curRev, updateRev = cur.Revision, update.Revision
+		return true, nil
+	}
+	defer func() { snapstate.AutomaticSnapshotBeforeRefresh = nil }()
+
+	ts, err := snapstate.Update(s.state, "some-snap", &snapstate.RevisionOptions{Channel: "some-channel"}, s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	c.Check(curRev, Equals, snap.R(7))
+	c.Check(updateRev, Equals, snap.R(11))
+
+	// the data is saved once the services are stopped
+	var stop, save *state.Task
+	for _, t := range ts.Tasks() {
+		switch t.Kind() {
+		case "stop-snap-services":
+			stop = t
+		case "save-snapshot":
+			save = t
+		}
+	}
+	c.Assert(save, NotNil)
+	c.Check(save.WaitTasks(), DeepEquals, []*state.Task{stop})
+	for _, t := range ts.Tasks() {
+		if t.Kind() == "remove-aliases" {
+			c.Check(t.WaitTasks(), DeepEquals, []*state.Task{save})
+		}
+	}
+}
+
 func (s *snapmgrTestSuite) TestUpdateAmendRunThrough(c *C) {
 	si := snap.SideInfo{
 		RealName: "some-snap",
@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// Actions that can be restricted by the system policy of the device.
+const (
+	// SystemPolicyDangerous is installing a snap without assertions.
+	SystemPolicyDangerous = "dangerous"
+	// SystemPolicyDevMode is installing or refreshing a snap into devmode.
+	SystemPolicyDevMode = "devmode"
+)
+
+var timeNow = time.Now
+
+// maxSystemPolicyViolations bounds the number of recorded violations,
+// the oldest ones are dropped first.
+var maxSystemPolicyViolations = 100
+
+// SystemPolicyAllows returns whether the system policy of the device
+// allows the given action. It is set by devicestate.
+//
+// The system-policy assertion is not fetched from the store, it reaches
+// the device either through the seed, which can carry it like any other
+// assertion, or by being acknowledged with snap ack.
+var SystemPolicyAllows func(st *state.State, action string, deviceCtx DeviceContext) (bool, error)
+
+// SystemPolicyViolation records an attempt at an action disallowed by the
+// system policy of the device.
+type SystemPolicyViolation struct {
+	Snap   string    `json:"snap"`
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// SystemPolicyError is returned when an action is disallowed by the system
+// policy of the device.
+type SystemPolicyError struct {
+	Snap   string
+	Action string
+}
+
+func (e *SystemPolicyError) Error() string {
+	return fmt.Sprintf("cannot install snap %q: %s installs are disallowed by the system policy", e.Snap, e.Action)
+}
+
+// checkSystemPolicy checks the install of the given snap with the given
+// flags against the system policy of the device. Disallowed attempts are
+// recorded and reported as warnings.
+func checkSystemPolicy(st *state.State, info *snap.Info, flags Flags, snapst *SnapState, deviceCtx DeviceContext) error {
+	if SystemPolicyAllows == nil {
+		return nil
+	}
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !seeded {
+		// the seed is controlled by the brand and not subject to the
+		// policy
+		return nil
+	}
+
+	var actions []string
+	if info.SnapID == "" {
+		actions = append(actions, SystemPolicyDangerous)
+	}
+	if flags.DevMode && (snapst == nil || !snapst.DevMode) {
+		actions = append(actions, SystemPolicyDevMode)
+	}
+	for _, action := range actions {
+		allowed, err := SystemPolicyAllows(st, action, deviceCtx)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			recordSystemPolicyViolation(st, info.InstanceName(), action)
+			return &SystemPolicyError{Snap: info.InstanceName(), Action: action}
+		}
+	}
+	return nil
+}
+
+func recordSystemPolicyViolation(st *state.State, snapName, action string) {
+	var violations []SystemPolicyViolation
+	if err := st.Get("system-policy-violations", &violations); err != nil && err != state.ErrNoState {
+		// the violation is still reported as a warning below
+		logger.Noticef("cannot get recorded system policy violations: %v", err)
+		violations = nil
+	}
+	violations = append(violations, SystemPolicyViolation{
+		Snap:   snapName,
+		Action: action,
+		Time:   timeNow(),
+	})
+	if n := len(violations); n > maxSystemPolicyViolations {
+		violations = violations[n-maxSystemPolicyViolations:]
+	}
+	st.Set("system-policy-violations", violations)
+	st.Warnf("attempt to install snap %q in violation of the system policy: %s installs are disallowed", snapName, action)
+}
+
+// SystemPolicyViolations returns the recorded attempts at actions
+// disallowed by the system policy of the device, oldest first.
+func SystemPolicyViolations(st *state.State) ([]SystemPolicyViolation, error) {
+	var violations []SystemPolicyViolation
+	if err := st.Get("system-policy-violations", &violations); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return violations, nil
+}
@@ -128,6 +128,10 @@ type Change struct {
 
 	spawnTime time.Time
 	readyTime time.Time
+
+	// marshalled caches the serialization of the change until it is
+	// modified, see Task.marshalled
+	marshalled []byte
 }
 
 type byReadyTime []*Change
@@ -188,7 +192,7 @@ func (c *Change) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON makes Change a json.Unmarshaller
 func (c *Change) UnmarshalJSON(data []byte) error {
 	if c.state != nil {
-		c.state.writing()
+		c.writing()
 	}
 	var unmarshalled marshalledChange
 	err := json.Unmarshal(data, &unmarshalled)
@@ -215,6 +219,26 @@ func (c *Change) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// writing is like State.writing but also discards the cached serialization
+// of the change.
+func (c *Change) writing() {
+	c.state.writing()
+	c.marshalled = nil
+}
+
+// cachedJSON returns the serialization of the change, marshalling it only
+// if it was modified since the last call.
+func (c *Change) cachedJSON() ([]byte, error) {
+	if c.marshalled == nil {
+		data, err := c.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		c.marshalled = data
+	}
+	return c.marshalled, nil
+}
+
 // finishUnmarshal is called after the state and tasks are accessible.
 func (c *Change) finishUnmarshal() {
 	if c.Status().Ready() {
@@ -240,7 +264,7 @@ func (c *Change) Summary() string {
 // Set associates value with key for future consulting by managers.
 // The provided value must properly marshal and unmarshal with encoding/json.
 func (c *Change) Set(key string, value interface{}) {
-	c.state.writing()
+	c.writing()
 	c.data.set(key, value)
 }
 
@@ -300,7 +324,7 @@ func (c *Change) Status() Status {
 
 // SetStatus sets the change status, overriding the default behavior (see Status method).
 func (c *Change) SetStatus(s Status) {
-	c.state.writing()
+	c.writing()
 	c.status = s
 	if s.Ready() {
 		c.markReady()
@@ -315,6 +339,7 @@ func (c *Change) markReady() {
 	}
 	if c.readyTime.IsZero() {
 		c.readyTime = timeNow()
+		c.marshalled = nil
 	}
 }
 
@@ -380,6 +405,7 @@ func (c *Change) taskCleanChanged() {
 		}
 	}
 	c.clean = true
+	c.marshalled = nil
 }
 
 // SpawnTime returns the time when the change was created.
@@ -454,18 +480,19 @@ func (c *Change) State() *State {
 // AddTask registers a task as required for the state change to
 // be accomplished.
 func (c *Change) AddTask(t *Task) {
-	c.state.writing()
+	c.writing()
 	if t.change != "" {
 		panic(fmt.Sprintf("internal error: cannot add one %q task to multiple changes", t.Kind()))
 	}
 	t.change = c.id
+	t.marshalled = nil
 	c.taskIDs = addOnce(c.taskIDs, t.ID())
 }
 
 // AddAll registers all tasks in the set as required for the state
 // change to be accomplished.
 func (c *Change) AddAll(ts *TaskSet) {
-	c.state.writing()
+	c.writing()
 	for _, t := range ts.tasks {
 		c.AddTask(t)
 	}
@@ -504,7 +531,7 @@ func (c *Change) LaneTasks(lanes ...int) []*Task {
 // Abort flags the change for cancellation, whether in progress or not.
 // Cancellation will proceed at the next ensure pass.
 func (c *Change) Abort() {
-	c.state.writing()
+	c.writing()
 	tasks := make([]*Task, len(c.taskIDs))
 	for i, tid := range c.taskIDs {
 		tasks[i] = c.state.tasks[tid]
@@ -516,7 +543,7 @@ func (c *Change) Abort() {
 // except for tasks that are also in a healthy lane (not aborted, and not waiting
 // on aborted).
 func (c *Change) AbortLanes(lanes []int) {
-	c.state.writing()
+	c.writing()
 	c.abortLanes(lanes, make(map[int]bool), make(map[string]bool))
 }
 
@@ -20,6 +20,7 @@
 package state
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -73,3 +74,19 @@ var (
 	ErrNoWarningExpireAfter = errNoWarningExpireAfter
 	ErrNoWarningRepeatAfter = errNoWarningRepeatAfter
 )
+
+// MarshalFull marshals the state in one go, without using the cached
+// serialization of changes and tasks.
+func (s *State) MarshalFull() ([]byte, error) {
+	s.reading()
+	return json.Marshal(marshalledState{
+		Data:     s.data,
+		Changes:  s.changes,
+		Tasks:    s.tasks,
+		Warnings: s.flattenWarnings(),
+
+		LastTaskId:   s.lastTaskId,
+		LastChangeId: s.lastChangeId,
+		LastLaneId:   s.lastLaneId,
+	})
+}
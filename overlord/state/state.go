@@ -21,6 +21,7 @@
 package state
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -112,6 +113,9 @@ type State struct {
 	warnings map[string]*Warning
 
 	modified bool
+	// marshalledSize is the size of the last serialization, used to
+	// size the buffer of the next one
+	marshalledSize int
 
 	cache map[interface{}]interface{}
 
@@ -176,16 +180,114 @@ type marshalledState struct {
 // MarshalJSON makes State a json.Marshaller
 func (s *State) MarshalJSON() ([]byte, error) {
 	s.reading()
-	return json.Marshal(marshalledState{
-		Data:     s.data,
-		Changes:  s.changes,
-		Tasks:    s.tasks,
+	return s.marshalIncremental()
+}
+
+// marshalledStateTail holds the fields of marshalledState that follow the
+// tasks.
+type marshalledStateTail struct {
+	Warnings []*Warning `json:"warnings,omitempty"`
+
+	LastChangeId int `json:"last-change-id"`
+	LastTaskId   int `json:"last-task-id"`
+	LastLaneId   int `json:"last-lane-id"`
+}
+
+// cachedEntry is the cached serialization of a change or task.
+type cachedEntry struct {
+	id   string
+	data []byte
+}
+
+// marshalIncremental produces the same serialization as marshalling a
+// marshalledState but reuses the cached serialization of the changes and
+// tasks that were not modified since the previous checkpoint, which with
+// many of them in the state dominate the cost of marshalling.
+func (s *State) marshalIncremental() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(s.marshalledSize)
+	buf.WriteString(`{"data":`)
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+
+	// nil maps, as in a state read from disk without any change or
+	// task, are marshalled as null
+	var changes []cachedEntry
+	if s.changes != nil {
+		changes = make([]cachedEntry, 0, len(s.changes))
+	}
+	for id, chg := range s.changes {
+		data, err := chg.cachedJSON()
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, cachedEntry{id, data})
+	}
+	buf.WriteString(`,"changes":`)
+	if err := writeCachedEntries(&buf, changes); err != nil {
+		return nil, err
+	}
+
+	var tasks []cachedEntry
+	if s.tasks != nil {
+		tasks = make([]cachedEntry, 0, len(s.tasks))
+	}
+	for id, t := range s.tasks {
+		data, err := t.cachedJSON()
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, cachedEntry{id, data})
+	}
+	buf.WriteString(`,"tasks":`)
+	if err := writeCachedEntries(&buf, tasks); err != nil {
+		return nil, err
+	}
+
+	tail, err := json.Marshal(marshalledStateTail{
 		Warnings: s.flattenWarnings(),
 
 		LastTaskId:   s.lastTaskId,
 		LastChangeId: s.lastChangeId,
 		LastLaneId:   s.lastLaneId,
 	})
+	if err != nil {
+		return nil, err
+	}
+	// splice the tail object in, replacing its opening brace
+	buf.WriteByte(',')
+	buf.Write(tail[1:])
+
+	s.marshalledSize = buf.Len()
+	return buf.Bytes(), nil
+}
+
+// writeCachedEntries writes a JSON object out of the given entries, sorted
+// by id like encoding/json does for maps, or null if entries is nil.
+func writeCachedEntries(buf *bytes.Buffer, entries []cachedEntry) error {
+	if entries == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+	buf.WriteByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(e.id)
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(e.data)
+	}
+	buf.WriteByte('}')
+	return nil
 }
 
 // UnmarshalJSON makes State a json.Unmarshaller
@@ -215,7 +317,9 @@ func (s *State) UnmarshalJSON(data []byte) error {
 }
 
 func (s *State) checkpointData() []byte {
-	data, err := json.Marshal(s)
+	// not json.Marshal(s), that would validate and compact the whole
+	// output again
+	data, err := s.MarshalJSON()
 	if err != nil {
 		// this shouldn't happen, because the actual delicate serializing happens at various Set()s
 		logger.Panicf("internal error: could not marshal state for checkpointing: %v", err)
@@ -231,6 +231,101 @@ func (ss *stateSuite) TestImplicitCheckpointAndRead(c *C) {
 	c.Check(&mSt2B, DeepEquals, mSt2)
 }
 
+func (ss *stateSuite) TestMarshalIncrementalNoChangesNorTasks(c *C) {
+	// a state written by an older snapd or without any change
+	st, err := state.ReadState(nil, bytes.NewBufferString(`{"data":{"v":1},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`))
+	c.Assert(err, IsNil)
+	st.Lock()
+	defer st.Unlock()
+
+	full, err := st.MarshalFull()
+	c.Assert(err, IsNil)
+	c.Check(string(full), Equals, `{"data":{"v":1},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`)
+	incremental, err := st.MarshalJSON()
+	c.Assert(err, IsNil)
+	c.Check(string(incremental), Equals, string(full))
+}
+
+func (ss *stateSuite) TestCheckpointIncremental(c *C) {
+	b := new(fakeStateBackend)
+	st := state.New(b)
+	st.Lock()
+
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("download", "1...")
+	t2 := st.NewTask("install", "2...")
+	t2.WaitFor(t1)
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+	unlinked := st.NewTask("unlinked", "...")
+	st.Set("v", 1)
+	st.Unlock()
+	c.Assert(b.checkpoints, HasLen, 1)
+
+	check := func(what string) {
+		full, err := st.MarshalFull()
+		c.Assert(err, IsNil)
+		incremental, err := st.MarshalJSON()
+		c.Assert(err, IsNil)
+		c.Check(string(incremental), Equals, string(full), Commentf(what))
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	check("initial")
+
+	for _, step := range []struct {
+		what   string
+		modify func()
+	}{
+		{"task data", func() { t1.Set("a", 1) }},
+		{"task data cleared", func() { t1.Clear("a") }},
+		{"change data", func() { chg.Set("b", "<>&") }},
+		{"task log", func() { t2.Logf("hello") }},
+		{"task lanes", func() { t2.JoinLane(st.NewLane()) }},
+		{"task wait", func() {
+			t3 := st.NewTask("check", "3...")
+			t3.WaitFor(t2)
+			chg.AddTask(t3)
+		}},
+		{"task progress", func() { t1.SetProgress("downloading", 1, 2) }},
+		{"task at", func() { t1.At(time.Now().Add(time.Hour)) }},
+		{"task doing time", func() { t1.AccumulateDoingTime(time.Second) }},
+		{"task status", func() {
+			for _, t := range chg.Tasks() {
+				t.SetStatus(state.DoneStatus)
+			}
+		}},
+		{"task clean", func() {
+			for _, t := range chg.Tasks() {
+				t.SetClean()
+			}
+		}},
+		{"change status", func() { chg.SetStatus(state.DoneStatus) }},
+		{"warnings", func() { st.Warnf("hello") }},
+		{"unlinked task", func() { unlinked.Set("c", true) }},
+		{"new change", func() {
+			chg2 := st.NewChange("remove", "...")
+			chg2.AddTask(st.NewTask("remove", "..."))
+		}},
+		{"state data", func() { st.Set("v", 2) }},
+	} {
+		step.modify()
+		check(step.what)
+	}
+
+	// what was checkpointed reads back to the same state
+	data, err := st.MarshalJSON()
+	c.Assert(err, IsNil)
+	st2, err := state.ReadState(nil, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+	data2, err := st2.MarshalJSON()
+	c.Assert(err, IsNil)
+	c.Check(string(data2), Equals, string(data))
+}
+
 func (ss *stateSuite) TestImplicitCheckpointRetry(c *C) {
 	restore := state.MockCheckpointRetryDelay(2*time.Millisecond, 1*time.Second)
 	defer restore()
@@ -1050,3 +1145,41 @@ func (ss *stateSuite) TestTimingsSupport(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(tims, DeepEquals, []int{1, 2, 3})
 }
+
+func benchmarkCheckpoint(b *testing.B, marshal func(st *state.State) ([]byte, error)) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	var tasks []*state.Task
+	for i := 0; i < 200; i++ {
+		chg := st.NewChange("change", "...")
+		for j := 0; j < 25; j++ {
+			t := st.NewTask("task", "...")
+			t.Set("data", map[string]interface{}{"snap": "foo", "revision": j})
+			t.Logf("log entry")
+			chg.AddTask(t)
+			tasks = append(tasks, t)
+		}
+	}
+	// prime the cached serializations
+	if _, err := marshal(st); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// a checkpoint typically follows a handful of modified tasks
+		tasks[i%len(tasks)].Set("progress", i)
+		if _, err := marshal(st); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCheckpointFull(b *testing.B) {
+	benchmarkCheckpoint(b, (*state.State).MarshalFull)
+}
+
+func BenchmarkCheckpointIncremental(b *testing.B) {
+	benchmarkCheckpoint(b, (*state.State).MarshalJSON)
+}
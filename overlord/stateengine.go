@@ -21,7 +21,11 @@ package overlord
 
 import (
 	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/snapcore/snapd/logger"
 
@@ -73,6 +77,39 @@ type StateEngine struct {
 	// managers in use
 	mgrLock  sync.Mutex
 	managers []StateManager
+
+	// managers disabled after a panic in Ensure
+	degradedLock sync.Mutex
+	degraded     map[StateManager]*degradedManager
+}
+
+var (
+	timeNow = time.Now
+
+	// degradedRetryMin and degradedRetryMax bound the exponential
+	// backoff before a manager that panicked in Ensure is retried.
+	degradedRetryMin = 10 * time.Second
+	degradedRetryMax = 10 * time.Minute
+)
+
+type degradedManager struct {
+	reason  string
+	since   time.Time
+	panics  int
+	retryAt time.Time
+}
+
+// DegradedSubsystem describes a manager that is not being ensured
+// because it panicked, until it is automatically retried.
+type DegradedSubsystem struct {
+	// Name is the name of the manager, eg. "snapstate.SnapManager".
+	Name string
+	// Reason is the value the manager panicked with.
+	Reason string
+	// Since is when the manager first panicked.
+	Since time.Time
+	// RetryAt is when the manager will be ensured again.
+	RetryAt time.Time
 }
 
 // NewStateEngine returns a new state engine.
@@ -145,7 +182,10 @@ func (se *StateEngine) Ensure() error {
 	}
 	var errs []error
 	for _, m := range se.managers {
-		err := m.Ensure()
+		if se.skipDegraded(m) {
+			continue
+		}
+		err := se.ensureManager(m)
 		if err != nil {
 			logger.Noticef("state ensure error: %v", err)
 			errs = append(errs, err)
@@ -157,6 +197,118 @@ func (se *StateEngine) Ensure() error {
 	return nil
 }
 
+func managerName(m StateManager) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", m), "*")
+}
+
+// ensureManager calls Ensure on the given manager, converting a panic into
+// an error and marking the manager as degraded so that a misbehaving
+// manager cannot take down the other ones.
+func (se *StateEngine) ensureManager(m StateManager) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			name := managerName(m)
+			logger.Noticef("%s panicked in Ensure: %v\n%s", name, r, debug.Stack())
+			se.markDegraded(m, fmt.Sprint(r))
+			err = fmt.Errorf("%s panicked: %v", name, r)
+			return
+		}
+		se.clearDegraded(m)
+	}()
+	return m.Ensure()
+}
+
+// skipDegraded returns whether the given degraded manager must not be
+// ensured yet.
+func (se *StateEngine) skipDegraded(m StateManager) bool {
+	se.degradedLock.Lock()
+	defer se.degradedLock.Unlock()
+	d := se.degraded[m]
+	return d != nil && timeNow().Before(d.retryAt)
+}
+
+func (se *StateEngine) markDegraded(m StateManager, reason string) {
+	now := timeNow()
+
+	se.degradedLock.Lock()
+	if se.degraded == nil {
+		se.degraded = make(map[StateManager]*degradedManager)
+	}
+	d := se.degraded[m]
+	if d == nil {
+		d = &degradedManager{since: now}
+		se.degraded[m] = d
+	}
+	d.reason = reason
+	d.panics++
+	delay := degradedRetryMin
+	for i := 1; i < d.panics && delay < degradedRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > degradedRetryMax {
+		delay = degradedRetryMax
+	}
+	d.retryAt = now.Add(delay)
+	panics := d.panics
+	se.degradedLock.Unlock()
+
+	if panics == 1 {
+		// the manager may have panicked while holding the state
+		// lock, which is not reentrant and may never be released,
+		// so the warning cannot be recorded synchronously here
+		go se.warnDegraded(managerName(m), reason)
+	}
+}
+
+// warnDegraded records a warning about a degraded manager once the state
+// lock can be taken.
+func (se *StateEngine) warnDegraded(name, reason string) {
+	se.state.Lock()
+	defer se.state.Unlock()
+	se.state.Warnf("%s is degraded after an internal error, it will be retried automatically: %s", name, reason)
+}
+
+// nextDegradedRetry returns when the earliest retry of a degraded manager is
+// due, or the zero time if no manager is degraded.
+func (se *StateEngine) nextDegradedRetry() time.Time {
+	se.degradedLock.Lock()
+	defer se.degradedLock.Unlock()
+	var next time.Time
+	for _, d := range se.degraded {
+		if next.IsZero() || d.retryAt.Before(next) {
+			next = d.retryAt
+		}
+	}
+	return next
+}
+
+func (se *StateEngine) clearDegraded(m StateManager) {
+	se.degradedLock.Lock()
+	defer se.degradedLock.Unlock()
+	if _, ok := se.degraded[m]; ok {
+		logger.Noticef("%s recovered", managerName(m))
+		delete(se.degraded, m)
+	}
+}
+
+// Degraded returns the subsystems that are currently degraded, sorted by
+// name. It does not wait for an ongoing Ensure pass.
+func (se *StateEngine) Degraded() []DegradedSubsystem {
+	se.degradedLock.Lock()
+	defer se.degradedLock.Unlock()
+	var result []DegradedSubsystem
+	for m, d := range se.degraded {
+		result = append(result, DegradedSubsystem{
+			Name:    managerName(m),
+			Reason:  d.reason,
+			Since:   d.since,
+			RetryAt: d.retryAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
 // AddManager adds the provided manager to take part in state operations.
 func (se *StateEngine) AddManager(m StateManager) {
 	se.mgrLock.Lock()
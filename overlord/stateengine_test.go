@@ -21,6 +21,7 @@ package overlord_test
 
 import (
 	"errors"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -43,6 +44,9 @@ type fakeManager struct {
 	name                      string
 	calls                     *[]string
 	ensureError, startupError error
+	ensurePanic               interface{}
+	// st is locked before panicking when set
+	st *state.State
 }
 
 func (fm *fakeManager) StartUp() error {
@@ -52,6 +56,12 @@ func (fm *fakeManager) StartUp() error {
 
 func (fm *fakeManager) Ensure() error {
 	*fm.calls = append(*fm.calls, "ensure:"+fm.name)
+	if fm.ensurePanic != nil {
+		if fm.st != nil {
+			fm.st.Lock()
+		}
+		panic(fm.ensurePanic)
+	}
 	return fm.ensureError
 }
 
@@ -156,6 +166,128 @@ func (ses *stateEngineSuite) TestEnsureError(c *C) {
 	c.Check(calls, DeepEquals, []string{"ensure:mgr1", "ensure:mgr2"})
 }
 
+func (ses *stateEngineSuite) TestEnsurePanicDegraded(c *C) {
+	s := state.New(nil)
+	se := overlord.NewStateEngine(s)
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	restore := overlord.MockTimeNow(func() time.Time { return now })
+	defer restore()
+	restore = overlord.MockDegradedRetry(10*time.Second, 30*time.Second)
+	defer restore()
+
+	calls := []string{}
+
+	mgr1 := &fakeManager{name: "mgr1", calls: &calls, ensurePanic: "boom"}
+	mgr2 := &fakeManager{name: "mgr2", calls: &calls}
+
+	se.AddManager(mgr1)
+	se.AddManager(mgr2)
+
+	c.Assert(se.StartUp(), IsNil)
+	calls = []string{}
+
+	// the panic is contained and the other managers are still ensured
+	err := se.Ensure()
+	c.Check(err, ErrorMatches, `state ensure errors: \[overlord_test.fakeManager panicked: boom\]`)
+	c.Check(calls, DeepEquals, []string{"ensure:mgr1", "ensure:mgr2"})
+	c.Check(se.Degraded(), DeepEquals, []overlord.DegradedSubsystem{{
+		Name:    "overlord_test.fakeManager",
+		Reason:  "boom",
+		Since:   now,
+		RetryAt: now.Add(10 * time.Second),
+	}})
+
+	warnings := waitForWarnings(c, s, 1)
+	c.Assert(warnings, HasLen, 1)
+	c.Check(warnings[0].String(), Equals, "overlord_test.fakeManager is degraded after an internal error, it will be retried automatically: boom")
+
+	// the degraded manager is skipped until it is due for a retry
+	calls = []string{}
+	c.Check(se.Ensure(), IsNil)
+	c.Check(calls, DeepEquals, []string{"ensure:mgr2"})
+
+	// retried, panicking again doubles the backoff
+	start := now
+	now = now.Add(10 * time.Second)
+	calls = []string{}
+	c.Check(se.Ensure(), NotNil)
+	c.Check(calls, DeepEquals, []string{"ensure:mgr1", "ensure:mgr2"})
+	degraded := se.Degraded()
+	c.Assert(degraded, HasLen, 1)
+	c.Check(degraded[0].Since, Equals, start)
+	c.Check(degraded[0].RetryAt, Equals, now.Add(20*time.Second))
+
+	// the backoff is capped
+	now = now.Add(20 * time.Second)
+	c.Check(se.Ensure(), NotNil)
+	c.Check(se.Degraded()[0].RetryAt, Equals, now.Add(30*time.Second))
+
+	// no new warnings for repeated panics
+	s.Lock()
+	c.Check(s.AllWarnings(), HasLen, 1)
+	s.Unlock()
+
+	// the manager recovers
+	mgr1.ensurePanic = nil
+	now = now.Add(30 * time.Second)
+	calls = []string{}
+	c.Check(se.Ensure(), IsNil)
+	c.Check(calls, DeepEquals, []string{"ensure:mgr1", "ensure:mgr2"})
+	c.Check(se.Degraded(), HasLen, 0)
+}
+
+func (ses *stateEngineSuite) TestEnsurePanicWithStateLocked(c *C) {
+	s := state.New(nil)
+	se := overlord.NewStateEngine(s)
+
+	calls := []string{}
+
+	mgr1 := &fakeManager{name: "mgr1", calls: &calls, ensurePanic: "boom", st: s}
+	mgr2 := &fakeManager{name: "mgr2", calls: &calls}
+
+	se.AddManager(mgr1)
+	se.AddManager(mgr2)
+
+	c.Assert(se.StartUp(), IsNil)
+	calls = []string{}
+
+	// marking the manager as degraded does not deadlock on the state
+	// lock left held by the panicking manager
+	done := make(chan error, 1)
+	go func() {
+		done <- se.Ensure()
+	}()
+	select {
+	case err := <-done:
+		c.Check(err, ErrorMatches, `state ensure errors: \[overlord_test.fakeManager panicked: boom\]`)
+	case <-time.After(10 * time.Second):
+		c.Fatal("Ensure deadlocked")
+	}
+	c.Check(calls, DeepEquals, []string{"ensure:mgr1", "ensure:mgr2"})
+	c.Check(se.Degraded(), HasLen, 1)
+
+	// the warning is recorded once the state lock is released
+	s.Unlock()
+	c.Check(waitForWarnings(c, s, 1), HasLen, 1)
+}
+
+// waitForWarnings waits for the warnings about degraded managers, which are
+// recorded asynchronously, to reach the given number.
+func waitForWarnings(c *C, s *state.State, n int) []*state.Warning {
+	for i := 0; i < 500; i++ {
+		s.Lock()
+		warnings := s.AllWarnings()
+		s.Unlock()
+		if len(warnings) >= n {
+			return warnings
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("timed out waiting for %d warnings", n)
+	return nil
+}
+
 func (ses *stateEngineSuite) TestStop(c *C) {
 	s := state.New(nil)
 	se := overlord.NewStateEngine(s)
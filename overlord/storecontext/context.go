@@ -31,6 +31,7 @@ import (
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/strutil"
 )
 
 // A Backend exposes device information and device identity
@@ -246,6 +247,30 @@ func (sc *storeContext) ProxyStoreParams(defaultURL *url.URL) (proxyStoreID stri
 	return "", defaultURL, nil
 }
 
+// StoreTLSPins returns the pins of the public keys of the store API, set
+// with the store-tls-pins system option and by the store assertion of the
+// proxy store if one is set.
+func (sc *storeContext) StoreTLSPins() ([]string, error) {
+	sc.state.Lock()
+	defer sc.state.Unlock()
+
+	tr := config.NewTransaction(sc.state)
+	var pinsOpt string
+	if err := tr.GetMaybe("core", "store-tls-pins", &pinsOpt); err != nil {
+		return nil, err
+	}
+	pins := strutil.CommaSeparatedList(pinsOpt)
+
+	sto, err := sc.proxyStoreer.ProxyStore()
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if sto != nil {
+		pins = append(pins, sto.TLSPins()...)
+	}
+	return pins, nil
+}
+
 // CloudInfo returns the cloud instance information (if available).
 func (sc *storeContext) CloudInfo() (*auth.CloudInfo, error) {
 	sc.state.Lock()
@@ -230,6 +230,37 @@ func (s *storeCtxSuite) TestCloudInfo(c *C) {
 	c.Check(cloud, DeepEquals, cloudInfo)
 }
 
+func (s *storeCtxSuite) TestStoreTLSPins(c *C) {
+	storeCtx := storecontext.New(s.state, &testBackend{nothing: true})
+
+	pins, err := storeCtx.StoreTLSPins()
+	c.Assert(err, IsNil)
+	c.Check(pins, HasLen, 0)
+
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "store-tls-pins", "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=, sha256/CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC=")
+	tr.Commit()
+	s.state.Unlock()
+
+	pins, err = storeCtx.StoreTLSPins()
+	c.Assert(err, IsNil)
+	c.Check(pins, DeepEquals, []string{
+		"sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"sha256/CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC=",
+	})
+
+	// the proxy store assertion adds its own pins
+	storeCtx = storecontext.New(s.state, &testBackend{})
+	pins, err = storeCtx.StoreTLSPins()
+	c.Assert(err, IsNil)
+	c.Check(pins, DeepEquals, []string{
+		"sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"sha256/CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC=",
+		"sha256/BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB=",
+	})
+}
+
 const (
 	exModel = `type: model
 authority-id: my-brand
@@ -282,6 +313,8 @@ authority-id: canonical
 store: foo
 operator-id: foo-operator
 url: http://foo.internal
+tls-pins:
+  - sha256/BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB=
 timestamp: 2017-11-01T10:00:00Z
 sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij
 
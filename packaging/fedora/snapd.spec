@@ -649,6 +649,7 @@ rm -fv %{buildroot}%{_unitdir}/snapd.system-shutdown.service
 rm -fv %{buildroot}%{_unitdir}/snapd.snap-repair.*
 rm -fv %{buildroot}%{_unitdir}/snapd.core-fixup.*
 rm -fv %{buildroot}%{_unitdir}/snapd.recovery-chooser-trigger.service
+rm -fv %{buildroot}%{_unitdir}/snapd.lock-tpm-sealed-keys.service
 
 # Remove snappy core specific scripts
 rm %{buildroot}%{_libexecdir}/snapd/snapd.core-fixup.sh
@@ -161,7 +161,7 @@ install::
 ifeq ($(with_core_bits),0)
 # Remove systemd units that are only used on core devices.
 install::
-	rm -f $(addprefix $(DESTDIR)$(unitdir)/,snapd.autoimport.service snapd.system-shutdown.service snapd.snap-repair.timer snapd.snap-repair.service snapd.core-fixup.service snapd.recovery-chooser-trigger.service)
+	rm -f $(addprefix $(DESTDIR)$(unitdir)/,snapd.autoimport.service snapd.system-shutdown.service snapd.snap-repair.timer snapd.snap-repair.service snapd.core-fixup.service snapd.recovery-chooser-trigger.service snapd.lock-tpm-sealed-keys.service)
 
 # Remove fixup script that is only used on core devices.
 install::
@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	sb "github.com/snapcore/secboot"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// wrappedPolicyAuthKeyMagic prefixes the content of policy auth key files
+// holding the key wrapped with a TPM-bound key instead of the key itself.
+// It is longer than a plain key, so the two cannot be mistaken.
+var wrappedPolicyAuthKeyMagic = []byte("snapd-tpm-wrapped-policy-auth-key-v1\n")
+
+var (
+	tpmWrapPolicyAuthKey   = wrapPolicyAuthKeyImpl
+	tpmUnwrapPolicyAuthKey = unwrapPolicyAuthKeyImpl
+)
+
+var policyAuthKeyObjectTemplate = tpm2.Public{
+	Type:    tpm2.ObjectTypeKeyedHash,
+	NameAlg: tpm2.HashAlgorithmSHA256,
+	Attrs:   tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrUserWithAuth | tpm2.AttrNoDA,
+	Params: tpm2.PublicParamsU{
+		Data: &tpm2.KeyedHashParams{
+			Scheme: tpm2.KeyedHashScheme{Scheme: tpm2.KeyedHashSchemeNull}}}}
+
+// wrapPolicyAuthKeyImpl seals the policy auth key into a data object under
+// the storage root key of the TPM and returns the serialized object, which
+// can only be loaded by the same TPM.
+func wrapPolicyAuthKeyImpl(tpm *sb.TPMConnection, authKey sb.TPMPolicyAuthKey) ([]byte, error) {
+	srk, err := tpm.CreateResourceContextFromTPM(srkHandle)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access the storage root key: %v", err)
+	}
+	sensitive := tpm2.SensitiveCreate{Data: tpm2.SensitiveData(authKey)}
+	priv, pub, _, _, _, err := tpm.Create(srk, &sensitive, &policyAuthKeyObjectTemplate, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the sealed object: %v", err)
+	}
+	return mu.MarshalToBytes(pub, priv)
+}
+
+// unwrapPolicyAuthKeyImpl reverses wrapPolicyAuthKeyImpl.
+func unwrapPolicyAuthKeyImpl(tpm *sb.TPMConnection, wrapped []byte) (sb.TPMPolicyAuthKey, error) {
+	var pub *tpm2.Public
+	var priv tpm2.Private
+	if _, err := mu.UnmarshalFromBytes(wrapped, &pub, &priv); err != nil {
+		return nil, fmt.Errorf("cannot decode the sealed object: %v", err)
+	}
+	srk, err := tpm.CreateResourceContextFromTPM(srkHandle)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access the storage root key: %v", err)
+	}
+	obj, err := tpm.Load(srk, priv, pub, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the sealed object: %v", err)
+	}
+	defer tpm.FlushContext(obj)
+	authKey, err := tpm.Unseal(obj, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unseal the sealed object: %v", err)
+	}
+	return sb.TPMPolicyAuthKey(authKey), nil
+}
+
+// writePolicyAuthKey writes the policy auth key to the given file, wrapped
+// with a TPM-bound key if requested, so that the content of the file is
+// useless anywhere but on this device.
+func writePolicyAuthKey(tpm *sb.TPMConnection, path string, authKey sb.TPMPolicyAuthKey, wrap bool) error {
+	content := []byte(authKey)
+	if wrap {
+		wrapped, err := tpmWrapPolicyAuthKey(tpm, authKey)
+		if err != nil {
+			return fmt.Errorf("cannot wrap the policy auth key: %v", err)
+		}
+		content = append(append([]byte(nil), wrappedPolicyAuthKeyMagic...), wrapped...)
+	}
+	if err := osutil.AtomicWriteFile(path, content, 0600, 0); err != nil {
+		return fmt.Errorf("cannot write the policy auth key file: %v", err)
+	}
+	return nil
+}
+
+// policyAuthKeyFromFileContent returns the policy auth key stored in a
+// policy auth key file with the given content, unwrapping it with the TPM
+// if it was written wrapped.
+func policyAuthKeyFromFileContent(tpm *sb.TPMConnection, content []byte) (sb.TPMPolicyAuthKey, error) {
+	if !bytes.HasPrefix(content, wrappedPolicyAuthKeyMagic) {
+		return sb.TPMPolicyAuthKey(content), nil
+	}
+	authKey, err := tpmUnwrapPolicyAuthKey(tpm, content[len(wrappedPolicyAuthKeyMagic):])
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap the policy auth key: %v", err)
+	}
+	return authKey, nil
+}
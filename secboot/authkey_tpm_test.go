@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+const wrappedPolicyAuthKeyMagic = "snapd-tpm-wrapped-policy-auth-key-v1\n"
+
+func (s *secbootSuite) TestSealKeyWrapsPolicyAuthKey(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+	myParams.TPMPolicyAuthKeyFile = filepath.Join(c.MkDir(), "policy-auth-key-file")
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+	wrapCalls := 0
+	restore = secboot.MockTPMWrapPolicyAuthKey(func(tpm *sb.TPMConnection, authKey sb.TPMPolicyAuthKey) ([]byte, error) {
+		wrapCalls++
+		c.Check(authKey, DeepEquals, sb.TPMPolicyAuthKey{1, 2, 3})
+		return []byte("wrapped"), nil
+	})
+	defer restore()
+
+	// not wrapped by default
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(wrapCalls, Equals, 0)
+	c.Check(myParams.TPMPolicyAuthKeyFile, testutil.FileEquals, []byte{1, 2, 3})
+
+	myParams.TPMWrapPolicyAuthKey = true
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(wrapCalls, Equals, 1)
+	c.Check(myParams.TPMPolicyAuthKeyFile, testutil.FileEquals, wrappedPolicyAuthKeyMagic+"wrapped")
+}
+
+func (s *secbootSuite) TestSealKeyWrapPolicyAuthKeyError(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+	myParams.TPMPolicyAuthKeyFile = filepath.Join(c.MkDir(), "policy-auth-key-file")
+	myParams.TPMWrapPolicyAuthKey = true
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+	restore = secboot.MockTPMWrapPolicyAuthKey(func(tpm *sb.TPMConnection, authKey sb.TPMPolicyAuthKey) ([]byte, error) {
+		return nil, errors.New("cannot access the storage root key: some error")
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot wrap the policy auth key: cannot access the storage root key: some error")
+	c.Check(myParams.TPMPolicyAuthKeyFile, testutil.FileAbsent)
+}
+
+func (s *secbootSuite) TestResealKeyWrappedPolicyAuthKey(c *C) {
+	tmpDir := c.MkDir()
+	mockEFI := bootloader.NewBootFile("", filepath.Join(tmpDir, "file.efi"), bootloader.RoleRecovery)
+	c.Assert(ioutil.WriteFile(mockEFI.Path, nil, 0644), IsNil)
+	authKeyFile := filepath.Join(tmpDir, "policy-auth-key-file")
+
+	tpm, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockSbAddEFISecureBootPolicyProfile(func(*sb.PCRProtectionProfile, *sb.EFISecureBootPolicyProfileParams) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockSbAddEFIBootManagerProfile(func(*sb.PCRProtectionProfile, *sb.EFIBootManagerProfileParams) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockTPMUnwrapPolicyAuthKey(func(t *sb.TPMConnection, wrapped []byte) (sb.TPMPolicyAuthKey, error) {
+		c.Check(t, Equals, tpm)
+		if string(wrapped) != "wrapped" {
+			return nil, errors.New("cannot load the sealed object: TPM_RC_INTEGRITY")
+		}
+		return sb.TPMPolicyAuthKey{1, 3, 3, 7}, nil
+	})
+	defer restore()
+	resealCalls := 0
+	restore = secboot.MockSbUpdateKeyPCRProtectionPolicyMultiple(func(t *sb.TPMConnection, keyPaths []string, authKey sb.TPMPolicyAuthKey, profile *sb.PCRProtectionProfile) error {
+		resealCalls++
+		c.Check(authKey, DeepEquals, sb.TPMPolicyAuthKey{1, 3, 3, 7})
+		return nil
+	})
+	defer restore()
+
+	params := &secboot.ResealKeysParams{
+		ModelParams: []*secboot.SealKeyModelParams{
+			{EFILoadChains: []*secboot.LoadChain{secboot.NewLoadChain(mockEFI)}},
+		},
+		KeyFiles:             []string{"keyfile"},
+		TPMPolicyAuthKeyFile: authKeyFile,
+	}
+
+	// plain keys are still supported
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte{1, 3, 3, 7}, 0600), IsNil)
+	c.Assert(secboot.ResealKeys(params), IsNil)
+	c.Check(resealCalls, Equals, 1)
+
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte(wrappedPolicyAuthKeyMagic+"wrapped"), 0600), IsNil)
+	c.Assert(secboot.ResealKeys(params), IsNil)
+	c.Check(resealCalls, Equals, 2)
+
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte(wrappedPolicyAuthKeyMagic+"tampered"), 0600), IsNil)
+	err := secboot.ResealKeys(params)
+	c.Assert(err, ErrorMatches, "cannot unwrap the policy auth key: cannot load the sealed object: TPM_RC_INTEGRITY")
+	c.Check(resealCalls, Equals, 2)
+}
+
+func (s *secbootSuite) TestCheckSealedKeyFilesWrappedPolicyAuthKey(c *C) {
+	d := c.MkDir()
+	authKeyFile := filepath.Join(d, "auth-key")
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte(wrappedPolicyAuthKeyMagic+"wrapped"), 0600), IsNil)
+	keyFile := filepath.Join(d, "sealed-key")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("USK$\x00\x00\x00\x01data"), 0600), IsNil)
+
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockTPMUnwrapPolicyAuthKey(func(t *sb.TPMConnection, wrapped []byte) (sb.TPMPolicyAuthKey, error) {
+		c.Check(wrapped, DeepEquals, []byte("wrapped"))
+		return sb.TPMPolicyAuthKey("auth-key"), nil
+	})
+	defer restore()
+	restore = secboot.MockValidateSealedKey(func(t *sb.TPMConnection, keyFile string, authKey sb.TPMPolicyAuthKey) error {
+		c.Check(authKey, DeepEquals, sb.TPMPolicyAuthKey("auth-key"))
+		return nil
+	})
+	defer restore()
+
+	c.Assert(secboot.CheckSealedKeyFiles([]string{keyFile}, authKeyFile), IsNil)
+}
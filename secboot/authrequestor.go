@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// AuthRequestor prompts the user for the secrets needed to unlock an
+// encrypted volume.
+type AuthRequestor interface {
+	// RequestRecoveryKey prompts for the recovery key of the encrypted
+	// device at the given path. If lastErr is not nil, a previous attempt
+	// failed with it and triesLeft is the number of remaining attempts.
+	RequestRecoveryKey(name, sourceDevicePath string, triesLeft int, lastErr error) (string, error)
+}
+
+// plymouthAuthRequestor prompts for secrets using the plymouth splash
+// screen, or on the console with systemd-ask-password if plymouth fails.
+type plymouthAuthRequestor struct{}
+
+func (plymouthAuthRequestor) RequestRecoveryKey(name, sourceDevicePath string, triesLeft int, lastErr error) (string, error) {
+	if lastErr != nil {
+		msg := fmt.Sprintf("Cannot unlock %s with the recovery key, %d tries left", name, triesLeft)
+		// best effort, the prompt is more important than the message
+		if output, err := exec.Command("plymouth", "display-message", "--text", msg).CombinedOutput(); err != nil {
+			logger.Noticef("cannot display message with plymouth: %v", osutil.OutputErr(output, err))
+		}
+	}
+
+	prompt := fmt.Sprintf("Please enter the recovery key for disk %s (%s):", name, sourceDevicePath)
+	output, err := exec.Command("plymouth", "ask-for-password", "--prompt", prompt).Output()
+	if err != nil {
+		// plymouth may have crashed or lost its display since it was
+		// found running, the key can still be entered on the console
+		logger.Noticef("cannot ask for the recovery key with plymouth, using systemd-ask-password: %v", err)
+		output, err = exec.Command("systemd-ask-password", "--icon", "drive-harddisk", "--id", "snapd:"+sourceDevicePath, prompt).Output()
+		if err != nil {
+			return "", fmt.Errorf("cannot ask for the recovery key with plymouth nor with systemd-ask-password: %v", err)
+		}
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func plymouthIsRunningImpl() bool {
+	return exec.Command("plymouth", "--ping").Run() == nil
+}
+
+var plymouthIsRunning = plymouthIsRunningImpl
+
+// newAuthRequestorImpl returns the AuthRequestor to use for prompting the
+// user, or nil if secboot should prompt with systemd-ask-password on the
+// console.
+func newAuthRequestorImpl() AuthRequestor {
+	if plymouthIsRunning() {
+		return plymouthAuthRequestor{}
+	}
+	return nil
+}
+
+var newAuthRequestor = newAuthRequestorImpl
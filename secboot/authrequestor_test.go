@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type authRequestorSuite struct{}
+
+var _ = Suite(&authRequestorSuite{})
+
+func (s *authRequestorSuite) TestNewAuthRequestorPlymouth(c *C) {
+	cmd := testutil.MockCommand(c, "plymouth", "")
+	defer cmd.Restore()
+
+	c.Check(secboot.NewAuthRequestor(), Equals, secboot.PlymouthAuthRequestor)
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{"plymouth", "--ping"},
+	})
+}
+
+func (s *authRequestorSuite) TestNewAuthRequestorNoPlymouth(c *C) {
+	cmd := testutil.MockCommand(c, "plymouth", "exit 1")
+	defer cmd.Restore()
+
+	c.Check(secboot.NewAuthRequestor(), IsNil)
+	c.Check(cmd.Calls(), HasLen, 1)
+}
+
+func (s *authRequestorSuite) TestPlymouthRequestRecoveryKey(c *C) {
+	cmd := testutil.MockCommand(c, "plymouth", `
+if [ "$1" = "ask-for-password" ]; then
+    echo "12345-12345-12345-12345-12345-12345-12345-12345"
+fi
+`)
+	defer cmd.Restore()
+
+	key, err := secboot.PlymouthAuthRequestor.RequestRecoveryKey("ubuntu-data", "/dev/vda4", 3, nil)
+	c.Assert(err, IsNil)
+	c.Check(key, Equals, "12345-12345-12345-12345-12345-12345-12345-12345")
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{"plymouth", "ask-for-password", "--prompt", "Please enter the recovery key for disk ubuntu-data (/dev/vda4):"},
+	})
+	cmd.ForgetCalls()
+
+	// the error of the previous attempt is displayed
+	_, err = secboot.PlymouthAuthRequestor.RequestRecoveryKey("ubuntu-data", "/dev/vda4", 2, errors.New("bad key"))
+	c.Assert(err, IsNil)
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{"plymouth", "display-message", "--text", "Cannot unlock ubuntu-data with the recovery key, 2 tries left"},
+		{"plymouth", "ask-for-password", "--prompt", "Please enter the recovery key for disk ubuntu-data (/dev/vda4):"},
+	})
+}
+
+func (s *authRequestorSuite) TestPlymouthRequestRecoveryKeyFallback(c *C) {
+	cmd := testutil.MockCommand(c, "plymouth", "exit 1")
+	defer cmd.Restore()
+	askPasswordCmd := testutil.MockCommand(c, "systemd-ask-password", `echo "12345-12345-12345-12345-12345-12345-12345-12345"`)
+	defer askPasswordCmd.Restore()
+
+	key, err := secboot.PlymouthAuthRequestor.RequestRecoveryKey("ubuntu-data", "/dev/vda4", 3, nil)
+	c.Assert(err, IsNil)
+	c.Check(key, Equals, "12345-12345-12345-12345-12345-12345-12345-12345")
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{"plymouth", "ask-for-password", "--prompt", "Please enter the recovery key for disk ubuntu-data (/dev/vda4):"},
+	})
+	c.Check(askPasswordCmd.Calls(), DeepEquals, [][]string{
+		{"systemd-ask-password", "--icon", "drive-harddisk", "--id", "snapd:/dev/vda4", "Please enter the recovery key for disk ubuntu-data (/dev/vda4):"},
+	})
+}
+
+func (s *authRequestorSuite) TestPlymouthRequestRecoveryKeyError(c *C) {
+	cmd := testutil.MockCommand(c, "plymouth", "exit 1")
+	defer cmd.Restore()
+	askPasswordCmd := testutil.MockCommand(c, "systemd-ask-password", "exit 1")
+	defer askPasswordCmd.Restore()
+
+	_, err := secboot.PlymouthAuthRequestor.RequestRecoveryKey("ubuntu-data", "/dev/vda4", 3, nil)
+	c.Assert(err, ErrorMatches, "cannot ask for the recovery key with plymouth nor with systemd-ask-password: exit status 1")
+	c.Check(askPasswordCmd.Calls(), HasLen, 1)
+}
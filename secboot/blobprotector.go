@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// blobKeyProtector is the key protector of the backends which wrap a key
+// into an opaque blob that only the hardware of the device can unwrap, like
+// OP-TEE and CAAM.
+//
+// The blobs carry no policy: the devices using these backends have no TPM
+// to measure the boot chain into, and the backends cannot measure it
+// themselves. The key is only bound to the hardware key of the device, the
+// integrity of the boot chain relies on the verified boot of the device
+// firmware, which only hands the TEE or the CAAM to an authenticated
+// kernel. For the same reason there is nothing to reseal when the boot
+// chains change.
+type blobKeyProtector struct {
+	name string
+	// backend is the name of the backend in messages
+	backend string
+	// header is the prefix of the key files sealed by the protector
+	header         []byte
+	checkSupported func() error
+	seal           func(key []byte) ([]byte, error)
+	unseal         func(blob []byte) ([]byte, error)
+}
+
+// blobSealedKey is the content of a key file sealed by a blobKeyProtector,
+// after its header.
+type blobSealedKey struct {
+	Blob []byte `json:"blob"`
+}
+
+func (p *blobKeyProtector) Name() string {
+	return p.name
+}
+
+func (p *blobKeyProtector) writeSealedKey(keyFile string, sk *blobSealedKey) error {
+	buf, err := json.Marshal(sk)
+	if err != nil {
+		return err
+	}
+	buf = append(append([]byte(nil), p.header...), buf...)
+	if err := osutil.AtomicWriteFile(keyFile, buf, 0600, 0); err != nil {
+		return fmt.Errorf("cannot write sealed key file: %v", err)
+	}
+	return nil
+}
+
+func (p *blobKeyProtector) readSealedKey(keyFile string) (*blobSealedKey, error) {
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(buf, p.header) {
+		return nil, fmt.Errorf("cannot unseal %q: not sealed with %s", keyFile, p.backend)
+	}
+	var sk blobSealedKey
+	if err := json.Unmarshal(buf[len(p.header):], &sk); err != nil {
+		return nil, fmt.Errorf("cannot unseal %q: invalid sealed key: %v", keyFile, err)
+	}
+	if len(sk.Blob) == 0 {
+		return nil, fmt.Errorf("cannot unseal %q: invalid sealed key: no blob", keyFile)
+	}
+	return &sk, nil
+}
+
+// SealKeys wraps the keys with the backend. The model parameters are
+// ignored, see blobKeyProtector.
+func (p *blobKeyProtector) SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
+	if err := p.checkSupported(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		blob, err := p.seal(k.Key[:])
+		if err != nil {
+			return fmt.Errorf("cannot seal key with %s: %v", p.backend, err)
+		}
+		if err := p.writeSealedKey(k.KeyFile, &blobSealedKey{Blob: blob}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResealKeys only checks that the key files are sealed with the backend, the
+// blobs do not depend on the boot chains.
+func (p *blobKeyProtector) ResealKeys(params *ResealKeysParams) error {
+	for _, keyFile := range params.KeyFiles {
+		if !p.IsSealedKey(keyFile) {
+			return fmt.Errorf("cannot reseal %q: not sealed with %s", keyFile, p.backend)
+		}
+	}
+	return nil
+}
+
+func (p *blobKeyProtector) IsSealedKey(keyFile string) bool {
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(buf, p.header)
+}
+
+// UnsealKey unwraps the key with the backend.
+func (p *blobKeyProtector) UnsealKey(keyFile string) ([]byte, error) {
+	sk, err := p.readSealedKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.unseal(sk.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unseal key with %s: %v", p.backend, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// CAAMKeyProtectorName is the name of the key protector wrapping keys into
+// blobs with the CAAM of NXP i.MX SoCs.
+const CAAMKeyProtectorName = "caam"
+
+var (
+	// the key modifier diversifies the key encrypting the blobs, so that
+	// blobs made by others with the same device cannot be used in place
+	// of ours, it must be exactly 16 bytes
+	caamKeyModifier = []byte("snapd-fde-key-v1")
+
+	caamSealedKeyHeader = []byte("snapd-caam-sealed-key-v1\n")
+)
+
+const (
+	// a blob holds the key encrypted with a random key, itself
+	// encrypted with a key derived from the OTP master key of the
+	// device, and a MAC
+	caamBlobOverhead = 32 + 16
+
+	caamKBMagic = 'I'
+)
+
+var (
+	caamSealKey   = caamSealKeyImpl
+	caamUnsealKey = caamUnsealKeyImpl
+)
+
+func init() {
+	RegisterKeyProtector(&blobKeyProtector{
+		name:           CAAMKeyProtectorName,
+		backend:        "CAAM",
+		header:         caamSealedKeyHeader,
+		checkSupported: CheckCAAMKeySealingSupported,
+		seal: func(key []byte) ([]byte, error) {
+			return caamSealKey(key)
+		},
+		unseal: func(blob []byte) ([]byte, error) {
+			return caamUnsealKey(blob)
+		},
+	})
+}
+
+func caamDevice() string {
+	return filepath.Join(dirs.GlobalRootDir, "/dev/caam_kb")
+}
+
+// CheckCAAMKeySealingSupported checks whether keys can be wrapped by the
+// CAAM, which is the case when the device of the key blob driver is present.
+func CheckCAAMKeySealingSupported() error {
+	if !osutil.FileExists(caamDevice()) {
+		return fmt.Errorf("CAAM key blobs are not available")
+	}
+	return nil
+}
+
+// caamKBData is struct caam_kb_data of the caam_keyblob driver.
+type caamKBData struct {
+	RawKey     uintptr
+	RawKeyLen  uintptr
+	KeyBlob    uintptr
+	KeyBlobLen uintptr
+	KeyMod     uintptr
+	KeyModLen  uintptr
+}
+
+// caamKBIoctl returns _IOWR(CAAM_KB_MAGIC, nr, struct caam_kb_data), the
+// size of the struct depends on the architecture.
+func caamKBIoctl(nr uintptr) uintptr {
+	const iocReadWrite = 3
+	return iocReadWrite<<30 | unsafe.Sizeof(caamKBData{})<<16 | caamKBMagic<<8 | nr
+}
+
+var (
+	caamKBEncrypt = caamKBIoctl(0)
+	caamKBDecrypt = caamKBIoctl(1)
+)
+
+func caamKeyBlobOp(req uintptr, rawKey, keyBlob, keyMod []byte) error {
+	f, err := os.OpenFile(caamDevice(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data := caamKBData{
+		RawKey:     uintptr(unsafe.Pointer(&rawKey[0])),
+		RawKeyLen:  uintptr(len(rawKey)),
+		KeyBlob:    uintptr(unsafe.Pointer(&keyBlob[0])),
+		KeyBlobLen: uintptr(len(keyBlob)),
+		KeyMod:     uintptr(unsafe.Pointer(&keyMod[0])),
+		KeyModLen:  uintptr(len(keyMod)),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&data)))
+	runtime.KeepAlive(rawKey)
+	runtime.KeepAlive(keyBlob)
+	runtime.KeepAlive(keyMod)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func caamSealKeyImpl(key []byte) ([]byte, error) {
+	blob := make([]byte, len(key)+caamBlobOverhead)
+	if err := caamKeyBlobOp(caamKBEncrypt, key, blob, caamKeyModifier); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func caamUnsealKeyImpl(blob []byte) ([]byte, error) {
+	if len(blob) <= caamBlobOverhead {
+		return nil, fmt.Errorf("invalid blob size %v", len(blob))
+	}
+	key := make([]byte, len(blob)-caamBlobOverhead)
+	if err := caamKeyBlobOp(caamKBDecrypt, key, blob, caamKeyModifier); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,186 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type caamSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&caamSuite{})
+
+func (s *caamSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	// a CAAM which "wraps" by xoring the key and adding a fake MAC
+	// made of the key modifier
+	mac := bytes.Repeat(secboot.CAAMKeyModifier, 3)
+	s.AddCleanup(secboot.MockCAAMSealKey(func(key []byte) ([]byte, error) {
+		blob := make([]byte, len(key))
+		for i := range key {
+			blob[i] = key[i] ^ 0xaa
+		}
+		return append(blob, mac...), nil
+	}))
+	s.AddCleanup(secboot.MockCAAMUnsealKey(func(blob []byte) ([]byte, error) {
+		if !bytes.HasSuffix(blob, mac) {
+			return nil, errors.New("invalid blob")
+		}
+		key := make([]byte, len(blob)-len(mac))
+		for i := range key {
+			key[i] = blob[i] ^ 0xaa
+		}
+		return key, nil
+	}))
+	// there is no TPM on the devices with a CAAM
+	s.AddCleanup(secboot.MockComputeBootChainPCRValues(func(modelParams []*secboot.SealKeyModelParams) ([]map[int][]byte, error) {
+		c.Errorf("unexpected call")
+		return nil, errors.New("cannot connect to TPM: no TPM")
+	}))
+}
+
+var caamModelParams = []*secboot.SealKeyModelParams{{}}
+
+func (s *caamSuite) mockCAAM(c *C) {
+	devCAAM := filepath.Join(dirs.GlobalRootDir, "/dev/caam_kb")
+	c.Assert(os.MkdirAll(filepath.Dir(devCAAM), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(devCAAM, nil, 0644), IsNil)
+}
+
+func (s *caamSuite) TestIoctls(c *C) {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		c.Check(secboot.CAAMKBEncrypt, Equals, uintptr(0xc0304900))
+		c.Check(secboot.CAAMKBDecrypt, Equals, uintptr(0xc0304901))
+	} else {
+		c.Check(secboot.CAAMKBEncrypt, Equals, uintptr(0xc0184900))
+		c.Check(secboot.CAAMKBDecrypt, Equals, uintptr(0xc0184901))
+	}
+}
+
+func (s *caamSuite) TestKeyModifier(c *C) {
+	c.Check(secboot.CAAMKeyModifier, HasLen, 16)
+}
+
+func (s *caamSuite) TestCheckCAAMKeySealingSupported(c *C) {
+	c.Check(secboot.CheckCAAMKeySealingSupported(), ErrorMatches, "CAAM key blobs are not available")
+	s.mockCAAM(c)
+	c.Check(secboot.CheckCAAMKeySealingSupported(), IsNil)
+}
+
+func (s *caamSuite) TestSealUnsealResealNoTPM(c *C) {
+	s.mockCAAM(c)
+
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	var key secboot.EncryptionKey
+	copy(key[:], "0123456789")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{Key: key, KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.CAAMKeyProtectorName,
+		ModelParams:  caamModelParams,
+	})
+	c.Assert(err, IsNil)
+
+	// the key is not stored in the clear
+	buf, err := ioutil.ReadFile(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(bytes.Contains(buf, key[:10]), Equals, false)
+
+	p, err := secboot.KeyProtectorByName(secboot.CAAMKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, true)
+	unsealed, err := p.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+
+	// not mistaken for another protector
+	optee, err := secboot.KeyProtectorByName(secboot.OPTEEKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Check(optee.IsSealedKey(keyFile), Equals, false)
+
+	// resealing picks the protector from the key files and leaves the
+	// blob alone
+	err = secboot.ResealKeys(&secboot.ResealKeysParams{KeyFiles: []string{keyFile}, ModelParams: caamModelParams})
+	c.Assert(err, IsNil)
+	c.Check(keyFile, testutil.FileEquals, buf)
+	unsealed, err = p.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+}
+
+func (s *caamSuite) TestSealNoCAAM(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.CAAMKeyProtectorName,
+	})
+	c.Assert(err, ErrorMatches, "CAAM key blobs are not available")
+	c.Check(keyFile, testutil.FileAbsent)
+}
+
+func (s *caamSuite) TestSealAndUnsealErrors(c *C) {
+	s.mockCAAM(c)
+	restore := secboot.MockCAAMSealKey(func(key []byte) ([]byte, error) {
+		return nil, errors.New("ioctl error")
+	})
+	defer restore()
+
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.CAAMKeyProtectorName,
+		ModelParams:  caamModelParams,
+	})
+	c.Assert(err, ErrorMatches, "cannot seal key with CAAM: ioctl error")
+
+	p, err := secboot.KeyProtectorByName(secboot.CAAMKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, []byte("USK$other"), 0600), IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, false)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot unseal ".*/ubuntu-data.sealed-key": not sealed with CAAM`)
+
+	// a corrupted key file
+	c.Assert(ioutil.WriteFile(keyFile, []byte("snapd-caam-sealed-key-v1\nbogus"), 0600), IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, true)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot unseal ".*": invalid sealed key: .*`)
+
+	// a blob from another device
+	sk := fmt.Sprintf(`{"blob":%q}`, base64.StdEncoding.EncodeToString([]byte("bogus")))
+	c.Assert(ioutil.WriteFile(keyFile, []byte("snapd-caam-sealed-key-v1\n"+sk), 0600), IsNil)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot unseal key with CAAM: invalid blob`)
+}
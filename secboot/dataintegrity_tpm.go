@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+)
+
+// dataIntegrityDigest is measured to the initramfs PCR when ubuntu-data is
+// expected to be integrity protected.
+func dataIntegrityDigest() []byte {
+	h := sha256.Sum256([]byte("snapd-data-integrity"))
+	return h[:]
+}
+
+// MeasureDataIntegrityWhenPossible measures that ubuntu-data is expected to
+// be integrity protected, only if the TPM device is available. It must be
+// called from the initramfs after the other measurements and before
+// unsealing the keys when booting a system whose keys were sealed with
+// SealKeyModelParams.DataIntegrity set. If there's no TPM device success is
+// returned.
+func MeasureDataIntegrityWhenPossible() error {
+	measure := func(tpm *sb.TPMConnection) error {
+		return tpmExtendPCR(tpm, initramfsPCR, dataIntegrityDigest())
+	}
+
+	if err := measureWhenPossible(measure); err != nil {
+		return fmt.Errorf("cannot measure data integrity requirement: %v", err)
+	}
+	return nil
+}
+
+// addDataIntegrityProfile binds the PCR profile to the measurement of the
+// data integrity requirement.
+func addDataIntegrityProfile(profile *sb.PCRProtectionProfile) {
+	profile.ExtendPCR(tpm2.HashAlgorithmSHA256, initramfsPCR, dataIntegrityDigest())
+}
@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+)
+
+func (s *secbootSuite) TestMeasureDataIntegrityWhenPossible(c *C) {
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+
+	expected := sha256.Sum256([]byte("snapd-data-integrity"))
+	extends := 0
+	var extendErr error
+	restore = secboot.MockTPMExtendPCR(func(tpm *sb.TPMConnection, pcr int, digest []byte) error {
+		extends++
+		c.Check(pcr, Equals, 12)
+		c.Check(digest, DeepEquals, expected[:])
+		return extendErr
+	})
+	defer restore()
+
+	err := secboot.MeasureDataIntegrityWhenPossible()
+	c.Assert(err, IsNil)
+	c.Check(extends, Equals, 1)
+
+	extendErr = errors.New("extend error")
+	err = secboot.MeasureDataIntegrityWhenPossible()
+	c.Assert(err, ErrorMatches, "cannot measure data integrity requirement: extend error")
+	c.Check(extends, Equals, 2)
+
+	// nothing is measured when the TPM is not enabled
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return false })
+	defer restore()
+	err = secboot.MeasureDataIntegrityWhenPossible()
+	c.Assert(err, IsNil)
+	c.Check(extends, Equals, 2)
+}
+
+func (s *secbootSuite) TestSealKeyDataIntegrity(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x494e5443, []string{"sha256"}))
+	myParams.ModelParams[0].SeedVerityRootHashes = mockSeedVerityRootHashes
+	myParams.ModelParams[0].DataIntegrity = true
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	expected := sha256.Sum256([]byte("snapd-data-integrity"))
+	sealCalls := 0
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		sealCalls++
+		// the requirement is measured last
+		profile := sb.NewPCRProtectionProfile().
+			ExtendPCR(tpm2.HashAlgorithmSHA256, 12, secboot.SeedVerityDigest(mockSeedVerityRootHashes)).
+			ExtendPCR(tpm2.HashAlgorithmSHA256, 12, expected[:])
+		c.Check(params.PCRProfile, DeepEquals, profile)
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(sealCalls, Equals, 1)
+}
@@ -40,6 +40,30 @@ const (
 	recoveryKeySize = 16
 )
 
+// LUKS2Options are the parameters used when formatting a LUKS2 volume, the
+// defaults are used for the ones which are unset.
+type LUKS2Options struct {
+	// Cipher is the cipher of the volume, eg. aes-xts-plain64.
+	Cipher string
+	// KeySize is the size of the volume key in bits.
+	KeySize int
+	// PBKDF is the key derivation function of the key slots, one of
+	// argon2i, argon2id or pbkdf2.
+	PBKDF string
+	// PBKDFMemoryKiB is the memory cost of argon2 in KiB, the minimal
+	// one when unset.
+	PBKDFMemoryKiB int
+	// PBKDFIterations forces the number of iterations of the key
+	// derivation function, the minimal one when unset, the cost is never
+	// benchmarked.
+	PBKDFIterations int
+	// SectorSize is the encryption sector size in bytes.
+	SectorSize int
+	// Integrity is the algorithm, eg. hmac-sha256, the volume is
+	// authenticated with using dm-integrity, none when empty.
+	Integrity string
+}
+
 // EncryptionKey is the key used to encrypt the data partition.
 type EncryptionKey [encryptionKeySize]byte
 
@@ -21,7 +21,18 @@
 package secboot
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
 	sb "github.com/snapcore/secboot"
+
+	"github.com/snapcore/snapd/osutil"
 )
 
 var (
@@ -34,8 +45,13 @@ const metadataKiBSize = 2048     // 2MB
 
 // FormatEncryptedDevice initializes an encrypted volume on the block device
 // given by node, setting the specified label. The key used to unlock the volume
-// is provided using the key argument.
-func FormatEncryptedDevice(key EncryptionKey, label, node string) error {
+// is provided using the key argument. When set, luksOpts tune the parameters
+// of the volume.
+func FormatEncryptedDevice(key EncryptionKey, label, node string, luksOpts *LUKS2Options) error {
+	if luksOpts != nil {
+		// secboot formats the volume with fixed parameters
+		return cryptsetupFormat(key, label, node, luksOpts)
+	}
 	opts := &sb.InitializeLUKS2ContainerOptions{
 		// use a lower, but still reasonable size that should give us
 		// enough room
@@ -45,6 +61,104 @@ func FormatEncryptedDevice(key EncryptionKey, label, node string) error {
 	return sbInitializeLUKS2Container(node, label, key[:], opts)
 }
 
+// integrityKeySizes are the sizes in bits of the keys of the supported
+// integrity algorithms.
+var integrityKeySizes = map[string]int{
+	"hmac-sha256": 256,
+	"hmac-sha512": 512,
+}
+
+const (
+	// the key slots are protected by high entropy keys, like secboot
+	// does the key derivation uses the minimal cost instead of
+	// benchmarking it, unless the gadget sets it explicitly
+	minArgon2MemoryKiB  = 32
+	minArgon2Iterations = 4
+	minPBKDF2Iterations = 1000
+)
+
+// cryptsetupFormat formats the volume like secboot does, with the given
+// parameters instead of the default ones.
+func cryptsetupFormat(key EncryptionKey, label, node string, opts *LUKS2Options) error {
+	cipher := opts.Cipher
+	if cipher == "" {
+		cipher = "aes-xts-plain64"
+	}
+	adiantum := strings.HasSuffix(cipher, "adiantum-plain64")
+	keySize := opts.KeySize
+	if keySize == 0 {
+		keySize = 512
+		if adiantum {
+			// adiantum only supports 256 bit keys
+			keySize = 256
+		}
+	}
+	if adiantum && keySize != 256 {
+		return fmt.Errorf("cannot use key size %d with cipher %q", keySize, cipher)
+	}
+	if opts.Integrity != "" {
+		// with authenticated encryption the key size includes the
+		// key of the integrity algorithm
+		integrityKeySize, ok := integrityKeySizes[opts.Integrity]
+		if !ok {
+			return fmt.Errorf("unsupported integrity algorithm %q", opts.Integrity)
+		}
+		keySize += integrityKeySize
+	}
+	pbkdf := opts.PBKDF
+	if pbkdf == "" {
+		pbkdf = "argon2i"
+	}
+	pbkdfMemory := opts.PBKDFMemoryKiB
+	pbkdfIterations := opts.PBKDFIterations
+	if pbkdf == "pbkdf2" {
+		if pbkdfIterations == 0 {
+			pbkdfIterations = minPBKDF2Iterations
+		}
+	} else {
+		if pbkdfMemory == 0 {
+			pbkdfMemory = minArgon2MemoryKiB
+		}
+		if pbkdfIterations == 0 {
+			pbkdfIterations = minArgon2Iterations
+		}
+	}
+	args := []string{
+		// batch processing, no password verification
+		"-q",
+		"luksFormat",
+		"--type", "luks2",
+		// read the key from stdin
+		"--key-file", "-",
+		"--cipher", cipher,
+		"--key-size", strconv.Itoa(keySize),
+		"--label", label,
+		"--pbkdf", pbkdf,
+		"--luks2-metadata-size", fmt.Sprintf("%dk", metadataKiBSize),
+		"--luks2-keyslots-size", fmt.Sprintf("%dk", keyslotsAreaKiBSize),
+	}
+	if pbkdfMemory != 0 {
+		args = append(args, "--pbkdf-memory", strconv.Itoa(pbkdfMemory))
+	}
+	args = append(args, "--pbkdf-force-iterations", strconv.Itoa(pbkdfIterations))
+	if opts.SectorSize != 0 {
+		args = append(args, "--sector-size", strconv.Itoa(opts.SectorSize))
+	}
+	if opts.Integrity != "" {
+		// the device is wiped so that the integrity tags are valid,
+		// which may take a while on large devices
+		args = append(args, "--integrity", opts.Integrity)
+	}
+	args = append(args, node)
+
+	cmd := exec.Command("cryptsetup", args...)
+	cmd.Stdin = bytes.NewReader(key[:])
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return osutil.OutputErr(output, err)
+	}
+	return nil
+}
+
 // AddRecoveryKey adds a fallback recovery key rkey to the existing encrypted
 // volume created with FormatEncryptedDevice on the block device given by node.
 // The existing key to the encrypted volume is provided in the key argument.
@@ -52,6 +166,86 @@ func AddRecoveryKey(key EncryptionKey, rkey RecoveryKey, node string) error {
 	return sbAddRecoveryKeyToLUKS2Container(node, key[:], sb.RecoveryKey(rkey))
 }
 
+var luks2KeyslotRe = regexp.MustCompile(`^\s+([0-9]+): \S+$`)
+
+// luks2Keyslots returns the key slots in use by the encrypted volume on the
+// block device given by node, as listed in the Keyslots section of the
+// header dump.
+func luks2Keyslots(node string) ([]int, error) {
+	output, err := exec.Command("cryptsetup", "luksDump", node).CombinedOutput()
+	if err != nil {
+		return nil, osutil.OutputErr(output, err)
+	}
+	var slots []int
+	inKeyslots := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			// a new section starts
+			inKeyslots = line == "Keyslots:"
+			continue
+		}
+		if !inKeyslots {
+			continue
+		}
+		if m := luks2KeyslotRe.FindStringSubmatch(line); m != nil {
+			slot, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid key slot %q", m[1])
+			}
+			slots = append(slots, slot)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return slots, nil
+}
+
+// AddEncryptionKey adds a new key to the existing encrypted volume on the
+// block device given by node, keeping its other keys. The current key to the
+// volume is provided in the key argument.
+func AddEncryptionKey(node string, key []byte, newKey EncryptionKey) error {
+	return luksAddKey(node, key, newKey)
+}
+
+// RemoveEncryptionKey removes the given key from the encrypted volume on the
+// block device given by node, along with the key slot holding it.
+func RemoveEncryptionKey(node string, key []byte) error {
+	cmd := exec.Command("cryptsetup", "-q", "luksRemoveKey", "--key-file", "-", node)
+	cmd.Stdin = bytes.NewReader(key)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot remove the key of %s: %v", node, osutil.OutputErr(output, err))
+	}
+	return nil
+}
+
+// luksAddKey adds newKey to the volume on the block device given by node in
+// the first free key slot.
+func luksAddKey(node string, key []byte, newKey EncryptionKey) error {
+	// the current key is read from stdin and the new one from a pipe, so
+	// that the keys are never written to a file
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// the key fits in the pipe buffer, so it can be written upfront
+	_, err = w.Write(newKey[:])
+	w.Close()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("cryptsetup", "-q", "luksAddKey", "--key-file", "-", node, "/dev/fd/3")
+	cmd.Stdin = bytes.NewReader(key)
+	cmd.ExtraFiles = []*os.File{r}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot add the new key to %s: %v", node, osutil.OutputErr(output, err))
+	}
+	return nil
+}
+
 func (k RecoveryKey) String() string {
 	return sb.RecoveryKey(k).String()
 }
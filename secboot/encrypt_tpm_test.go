@@ -22,11 +22,13 @@ package secboot_test
 
 import (
 	"errors"
+	"path/filepath"
 
 	sb "github.com/snapcore/secboot"
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
 )
 
 func (s *encryptSuite) TestFormatEncryptedDevice(c *C) {
@@ -58,7 +60,7 @@ func (s *encryptSuite) TestFormatEncryptedDevice(c *C) {
 		})
 		defer restore()
 
-		err := secboot.FormatEncryptedDevice(myKey, "my label", "/dev/node")
+		err := secboot.FormatEncryptedDevice(myKey, "my label", "/dev/node", nil)
 		c.Assert(calls, Equals, 1)
 		if tc.err == "" {
 			c.Assert(err, IsNil)
@@ -68,6 +70,119 @@ func (s *encryptSuite) TestFormatEncryptedDevice(c *C) {
 	}
 }
 
+func (s *encryptSuite) TestFormatEncryptedDeviceWithOptions(c *C) {
+	restore := secboot.MockSbInitializeLUKS2Container(func(devicePath, label string, key []byte,
+		opts *sb.InitializeLUKS2ContainerOptions) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	defer restore()
+
+	for _, tc := range []struct {
+		opts *secboot.LUKS2Options
+		args []string
+	}{{
+		// the minimal key derivation cost is kept by default
+		opts: &secboot.LUKS2Options{},
+		args: []string{"--cipher", "aes-xts-plain64", "--key-size", "512", "--label", "my label", "--pbkdf", "argon2i",
+			"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+			"--pbkdf-memory", "32", "--pbkdf-force-iterations", "4"},
+	}, {
+		opts: &secboot.LUKS2Options{SectorSize: 4096},
+		args: []string{"--cipher", "aes-xts-plain64", "--key-size", "512", "--label", "my label", "--pbkdf", "argon2i",
+			"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+			"--pbkdf-memory", "32", "--pbkdf-force-iterations", "4", "--sector-size", "4096"},
+	}, {
+		opts: &secboot.LUKS2Options{PBKDFMemoryKiB: 65536},
+		args: []string{"--cipher", "aes-xts-plain64", "--key-size", "512", "--label", "my label", "--pbkdf", "argon2i",
+			"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+			"--pbkdf-memory", "65536", "--pbkdf-force-iterations", "4"},
+	}, {
+		opts: &secboot.LUKS2Options{PBKDF: "pbkdf2"},
+		args: []string{"--cipher", "aes-xts-plain64", "--key-size", "512", "--label", "my label", "--pbkdf", "pbkdf2",
+			"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+			"--pbkdf-force-iterations", "1000"},
+	}, {
+		// adiantum defaults to 256 bit keys
+		opts: &secboot.LUKS2Options{Cipher: "xchacha20,aes-adiantum-plain64"},
+		args: []string{"--cipher", "xchacha20,aes-adiantum-plain64", "--key-size", "256", "--label", "my label", "--pbkdf", "argon2i",
+			"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+			"--pbkdf-memory", "32", "--pbkdf-force-iterations", "4"},
+	}, {
+		opts: &secboot.LUKS2Options{
+			Cipher:          "xchacha12,aes-adiantum-plain64",
+			KeySize:         256,
+			PBKDF:           "argon2id",
+			PBKDFMemoryKiB:  65536,
+			PBKDFIterations: 8,
+			SectorSize:      4096,
+		},
+		args: []string{"--cipher", "xchacha12,aes-adiantum-plain64", "--key-size", "256", "--label", "my label", "--pbkdf", "argon2id",
+			"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+			"--pbkdf-memory", "65536", "--pbkdf-force-iterations", "8", "--sector-size", "4096"},
+	}} {
+		mockCryptsetup := testutil.MockCommand(c, "cryptsetup", "cat - > $(dirname $0)/key")
+		defer mockCryptsetup.Restore()
+
+		myKey := secboot.EncryptionKey{}
+		for i := range myKey {
+			myKey[i] = byte(i)
+		}
+		err := secboot.FormatEncryptedDevice(myKey, "my label", "/dev/node", tc.opts)
+		c.Assert(err, IsNil)
+
+		expected := append([]string{"cryptsetup", "-q", "luksFormat", "--type", "luks2", "--key-file", "-"}, tc.args...)
+		expected = append(expected, "/dev/node")
+		c.Check(mockCryptsetup.Calls(), DeepEquals, [][]string{expected})
+		c.Check(filepath.Join(filepath.Dir(mockCryptsetup.Exe()), "key"), testutil.FileEquals, myKey[:])
+	}
+}
+
+func (s *encryptSuite) TestFormatEncryptedDeviceAdiantumKeySize(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", "")
+	defer mockCryptsetup.Restore()
+
+	opts := &secboot.LUKS2Options{
+		Cipher:  "xchacha12,aes-adiantum-plain64",
+		KeySize: 512,
+	}
+	err := secboot.FormatEncryptedDevice(secboot.EncryptionKey{}, "my label", "/dev/node", opts)
+	c.Assert(err, ErrorMatches, `cannot use key size 512 with cipher "xchacha12,aes-adiantum-plain64"`)
+	c.Check(mockCryptsetup.Calls(), HasLen, 0)
+}
+
+func (s *encryptSuite) TestFormatEncryptedDeviceWithIntegrity(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", "")
+	defer mockCryptsetup.Restore()
+
+	opts := &secboot.LUKS2Options{
+		Integrity:  "hmac-sha256",
+		SectorSize: 4096,
+	}
+	err := secboot.FormatEncryptedDevice(secboot.EncryptionKey{}, "my label", "/dev/node", opts)
+	c.Assert(err, IsNil)
+	c.Check(mockCryptsetup.Calls(), DeepEquals, [][]string{{
+		"cryptsetup", "-q", "luksFormat", "--type", "luks2", "--key-file", "-",
+		// the integrity key is part of the volume key
+		"--cipher", "aes-xts-plain64", "--key-size", "768", "--label", "my label", "--pbkdf", "argon2i",
+		"--luks2-metadata-size", "2048k", "--luks2-keyslots-size", "2560k",
+		"--pbkdf-memory", "32", "--pbkdf-force-iterations", "4",
+		"--sector-size", "4096", "--integrity", "hmac-sha256", "/dev/node",
+	}})
+
+	err = secboot.FormatEncryptedDevice(secboot.EncryptionKey{}, "my label", "/dev/node", &secboot.LUKS2Options{Integrity: "crc32c"})
+	c.Assert(err, ErrorMatches, `unsupported integrity algorithm "crc32c"`)
+	c.Check(mockCryptsetup.Calls(), HasLen, 1)
+}
+
+func (s *encryptSuite) TestFormatEncryptedDeviceWithOptionsError(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", "echo 'some error'; exit 1")
+	defer mockCryptsetup.Restore()
+
+	err := secboot.FormatEncryptedDevice(secboot.EncryptionKey{}, "my label", "/dev/node", &secboot.LUKS2Options{SectorSize: 4096})
+	c.Assert(err, ErrorMatches, "some error")
+}
+
 func (s *encryptSuite) TestAddRecoveryKey(c *C) {
 	for _, tc := range []struct {
 		addErr error
@@ -103,3 +218,72 @@ func (s *encryptSuite) TestAddRecoveryKey(c *C) {
 		}
 	}
 }
+
+const mockLuksDump = `LUKS header information
+Version:       	2
+Epoch:         	5
+UUID:          	0a2a6fe4-b1da-4fc6-bb9c-ca84d3fc7bb5
+Label:         	ubuntu-save-enc
+
+Data segments:
+  0: crypt
+	offset: 16777216 [bytes]
+	cipher: aes-xts-plain64
+
+Keyslots:
+  0: luks2
+	Key:        512 bits
+	PBKDF:      argon2i
+  1: luks2
+	Key:        512 bits
+	PBKDF:      argon2i
+Tokens:
+  0: secboot-recovery
+	Keyslot:    1
+Digests:
+  0: pbkdf2
+	Hash:       sha256
+`
+
+func (s *encryptSuite) TestAddRemoveEncryptionKey(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", `
+case "$*" in
+	*luksAddKey*)
+		cat - > "$(dirname "$0")"/old-key
+		cat /dev/fd/3 > "$(dirname "$0")"/new-key
+		;;
+	*luksRemoveKey*)
+		cat - > "$(dirname "$0")"/remove-key
+		;;
+esac
+`)
+	defer mockCryptsetup.Restore()
+	mockDir := filepath.Dir(mockCryptsetup.Exe())
+
+	oldKey := []byte("old-key")
+	newKey := secboot.EncryptionKey{}
+	for i := range newKey {
+		newKey[i] = byte(i)
+	}
+	err := secboot.AddEncryptionKey("/dev/node", oldKey, newKey)
+	c.Assert(err, IsNil)
+	err = secboot.RemoveEncryptionKey("/dev/node", oldKey)
+	c.Assert(err, IsNil)
+	c.Check(mockCryptsetup.Calls(), DeepEquals, [][]string{
+		{"cryptsetup", "-q", "luksAddKey", "--key-file", "-", "/dev/node", "/dev/fd/3"},
+		{"cryptsetup", "-q", "luksRemoveKey", "--key-file", "-", "/dev/node"},
+	})
+	c.Check(filepath.Join(mockDir, "old-key"), testutil.FileEquals, oldKey)
+	c.Check(filepath.Join(mockDir, "new-key"), testutil.FileEquals, newKey[:])
+	c.Check(filepath.Join(mockDir, "remove-key"), testutil.FileEquals, oldKey)
+}
+
+func (s *encryptSuite) TestAddRemoveEncryptionKeyErrors(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", `echo "No key available with this passphrase."; exit 2`)
+	defer mockCryptsetup.Restore()
+
+	err := secboot.AddEncryptionKey("/dev/node", []byte("old-key"), secboot.EncryptionKey{})
+	c.Check(err, ErrorMatches, "cannot add the new key to /dev/node: No key available with this passphrase.")
+	err = secboot.RemoveEncryptionKey("/dev/node", []byte("old-key"))
+	c.Check(err, ErrorMatches, "cannot remove the key of /dev/node: No key available with this passphrase.")
+}
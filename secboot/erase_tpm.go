@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+var (
+	tpmUndefineNVIndex = undefineNVIndexImpl
+	tpmEvictSRK        = evictSRKImpl
+)
+
+func undefineNVIndexImpl(tpm *sb.TPMConnection, handle uint32) error {
+	index, err := tpm.CreateResourceContextFromTPM(tpm2.Handle(handle))
+	if err != nil {
+		if tpm2.IsResourceUnavailableError(err, tpm2.Handle(handle)) {
+			// not defined, nothing to do
+			return nil
+		}
+		return err
+	}
+	return tpm.NVUndefineSpace(tpm.OwnerHandleContext(), index, tpm.HmacSession())
+}
+
+// luksErase destroys all the key slots of the encrypted volume on the block
+// device given by node, after which the volume key, and so the content of
+// the volume, cannot be recovered with any key.
+func luksErase(node string) error {
+	output, err := exec.Command("cryptsetup", "-q", "erase", node).CombinedOutput()
+	if err != nil {
+		return osutil.OutputErr(output, err)
+	}
+	// make sure that the erase is effective
+	slots, err := luks2Keyslots(node)
+	if err != nil {
+		return fmt.Errorf("cannot list the key slots: %v", err)
+	}
+	if len(slots) != 0 {
+		return fmt.Errorf("%d key slots remain after erase", len(slots))
+	}
+	return nil
+}
+
+// CryptoEraseVolumes makes the content of the given encrypted volumes
+// irrecoverable by destroying their key material, which is much faster than
+// overwriting the volumes, eg. when decommissioning a device. All the LUKS2
+// key slots of the volumes are destroyed. For keys sealed to the TPM, the NV
+// indices holding the PCR policy counters, and any other given indices, are
+// undefined and the persistent storage root key is evicted. Finally the
+// sealed key files are removed.
+func CryptoEraseVolumes(params *CryptoEraseParams) error {
+	if len(params.Devices) == 0 {
+		return fmt.Errorf("internal error: no volumes to erase")
+	}
+
+	// the PCR policy counter handles are stored in the key files, so they
+	// must be collected before anything is removed
+	var handles []uint32
+	addHandle := func(handle uint32) {
+		if handle == 0 {
+			return
+		}
+		for _, h := range handles {
+			if h == handle {
+				return
+			}
+		}
+		handles = append(handles, handle)
+	}
+	var keyFiles []string
+	for _, keyFile := range params.KeyFiles {
+		if !osutil.FileExists(keyFile) {
+			continue
+		}
+		keyFiles = append(keyFiles, keyFile)
+		if keyProtectorForSealedKey(keyFile) != nil {
+			// sealed by another key protector, nothing is kept in
+			// the TPM
+			continue
+		}
+		handle, err := sealedKeyPCRPolicyCounterHandle(keyFile)
+		if err != nil {
+			return fmt.Errorf("cannot read the PCR policy counter handle of %q: %v", keyFile, err)
+		}
+		addHandle(handle)
+	}
+	for _, handle := range params.NVIndexHandles {
+		addHandle(handle)
+	}
+
+	for _, device := range params.Devices {
+		if err := luksErase(device); err != nil {
+			return fmt.Errorf("cannot erase encrypted volume %s: %v", device, err)
+		}
+	}
+
+	if len(handles) != 0 {
+		tpm, err := sbConnectToDefaultTPM()
+		if err != nil {
+			return fmt.Errorf("cannot connect to TPM: %v", err)
+		}
+		defer tpm.Close()
+		if !isTPMEnabled(tpm) {
+			return fmt.Errorf("TPM device is not enabled")
+		}
+		for _, handle := range handles {
+			if err := tpmUndefineNVIndex(tpm, handle); err != nil {
+				return fmt.Errorf("cannot undefine NV index %#x: %v", handle, err)
+			}
+		}
+		if err := tpmEvictSRK(tpm); err != nil {
+			return fmt.Errorf("cannot evict the storage root key: %v", err)
+		}
+	}
+
+	for _, keyFile := range keyFiles {
+		if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove sealed key file: %v", err)
+		}
+	}
+	return nil
+}
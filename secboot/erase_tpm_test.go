@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/secboot/secboottest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *secbootSuite) TestCryptoEraseVolumesHappy(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", `
+case "$*" in
+	luksDump*)
+		printf 'LUKS header information\nVersion: 2\n\nKeyslots:\nTokens:\n'
+		;;
+esac
+`)
+	defer mockCryptsetup.Restore()
+
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+
+	d := c.MkDir()
+	runKey := filepath.Join(d, "ubuntu-data.sealed-key")
+	fallbackKey := filepath.Join(d, "ubuntu-data.recovery.sealed-key")
+	for _, keyFile := range []string{runKey, fallbackKey} {
+		c.Assert(ioutil.WriteFile(keyFile, []byte("sealed"), 0600), IsNil)
+	}
+	restore = secboot.MockSealedKeyPCRPolicyCounterHandle(func(keyFile string) (uint32, error) {
+		if keyFile == runKey {
+			return secboot.RunObjectPCRPolicyCounterHandle, nil
+		}
+		return secboot.FallbackObjectPCRPolicyCounterHandle, nil
+	})
+	defer restore()
+	var undefined []uint32
+	restore = secboot.MockTPMUndefineNVIndex(func(tpm *sb.TPMConnection, handle uint32) error {
+		// the volumes are erased first
+		c.Check(mockCryptsetup.Calls(), HasLen, 4)
+		undefined = append(undefined, handle)
+		return nil
+	})
+	defer restore()
+	evicted := 0
+	restore = secboot.MockTPMEvictSRK(func(tpm *sb.TPMConnection) error {
+		// after the NV indices
+		c.Check(undefined, HasLen, 3)
+		evicted++
+		return nil
+	})
+	defer restore()
+
+	err := secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{
+		Devices:  []string{"/dev/data", "/dev/save"},
+		KeyFiles: []string{runKey, fallbackKey, filepath.Join(d, "missing.sealed-key")},
+		NVIndexHandles: []uint32{
+			0x01880003,
+			// duplicates are undefined once
+			secboot.RunObjectPCRPolicyCounterHandle,
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(mockCryptsetup.Calls(), DeepEquals, [][]string{
+		{"cryptsetup", "-q", "erase", "/dev/data"},
+		{"cryptsetup", "luksDump", "/dev/data"},
+		{"cryptsetup", "-q", "erase", "/dev/save"},
+		{"cryptsetup", "luksDump", "/dev/save"},
+	})
+	c.Check(undefined, DeepEquals, []uint32{
+		secboot.RunObjectPCRPolicyCounterHandle,
+		secboot.FallbackObjectPCRPolicyCounterHandle,
+		0x01880003,
+	})
+	c.Check(evicted, Equals, 1)
+	c.Check(runKey, testutil.FileAbsent)
+	c.Check(fallbackKey, testutil.FileAbsent)
+}
+
+func (s *secbootSuite) TestCryptoEraseVolumesOtherKeyProtector(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", `
+case "$*" in
+	luksDump*)
+		printf 'LUKS header information\nVersion: 2\n\nKeyslots:\nTokens:\n'
+		;;
+esac
+`)
+	defer mockCryptsetup.Restore()
+
+	restore := secboot.MockTPMUndefineNVIndex(func(tpm *sb.TPMConnection, handle uint32) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockTPMEvictSRK(func(tpm *sb.TPMConnection) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	defer restore()
+
+	// keys sealed by other key protectors are only removed
+	p, restore := secboottest.MockPlaintextKeyProtector()
+	defer restore()
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{Key: secboot.EncryptionKey{'k'}, KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: p.Name(),
+	})
+	c.Assert(err, IsNil)
+
+	err = secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{
+		Devices:  []string{"/dev/data"},
+		KeyFiles: []string{keyFile},
+	})
+	c.Assert(err, IsNil)
+	c.Check(mockCryptsetup.Calls(), DeepEquals, [][]string{
+		{"cryptsetup", "-q", "erase", "/dev/data"},
+		{"cryptsetup", "luksDump", "/dev/data"},
+	})
+	c.Check(keyFile, testutil.FileAbsent)
+}
+
+func (s *secbootSuite) TestCryptoEraseVolumesKeyslotsRemain(c *C) {
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", `
+case "$*" in
+	luksDump*)
+		cat "$(dirname "$0")"/luks-dump
+		;;
+esac
+`)
+	defer mockCryptsetup.Restore()
+	mockDir := filepath.Dir(mockCryptsetup.Exe())
+	err := ioutil.WriteFile(filepath.Join(mockDir, "luks-dump"), []byte(mockLuksDump), 0644)
+	c.Assert(err, IsNil)
+
+	d := c.MkDir()
+	keyFile := filepath.Join(d, "ubuntu-data.sealed-key")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("sealed"), 0600), IsNil)
+	restore := secboot.MockSealedKeyPCRPolicyCounterHandle(func(keyFile string) (uint32, error) {
+		return secboot.RunObjectPCRPolicyCounterHandle, nil
+	})
+	defer restore()
+	restore = secboot.MockTPMUndefineNVIndex(func(tpm *sb.TPMConnection, handle uint32) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	defer restore()
+
+	err = secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{
+		Devices:  []string{"/dev/data"},
+		KeyFiles: []string{keyFile},
+	})
+	c.Assert(err, ErrorMatches, "cannot erase encrypted volume /dev/data: 2 key slots remain after erase")
+	// the key is kept as the volume was not erased
+	c.Check(keyFile, testutil.FilePresent)
+}
+
+func (s *secbootSuite) TestCryptoEraseVolumesErrors(c *C) {
+	err := secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{})
+	c.Assert(err, ErrorMatches, "internal error: no volumes to erase")
+
+	mockCryptsetup := testutil.MockCommand(c, "cryptsetup", `echo "Device /dev/data is not a valid LUKS device."; exit 1`)
+	defer mockCryptsetup.Restore()
+
+	err = secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{
+		Devices: []string{"/dev/data"},
+	})
+	c.Assert(err, ErrorMatches, "cannot erase encrypted volume /dev/data: Device /dev/data is not a valid LUKS device.")
+
+	mockCryptsetup = testutil.MockCommand(c, "cryptsetup", "")
+	defer mockCryptsetup.Restore()
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return false })
+	defer restore()
+
+	err = secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{
+		Devices:        []string{"/dev/data"},
+		NVIndexHandles: []uint32{0x01880003},
+	})
+	c.Assert(err, ErrorMatches, "TPM device is not enabled")
+
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockTPMUndefineNVIndex(func(tpm *sb.TPMConnection, handle uint32) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockTPMEvictSRK(func(tpm *sb.TPMConnection) error {
+		return errors.New("boom")
+	})
+	defer restore()
+
+	err = secboot.CryptoEraseVolumes(&secboot.CryptoEraseParams{
+		Devices:        []string{"/dev/data"},
+		NVIndexHandles: []uint32{0x01880003},
+	})
+	c.Assert(err, ErrorMatches, "cannot evict the storage root key: boom")
+}
@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+)
+
+const (
+	// escrowAlgorithm is how the recovery key is encrypted in an escrow
+	// blob: with a random AES-256-GCM key, itself encrypted to the
+	// organization public key with RSA-OAEP using SHA-256.
+	escrowAlgorithm = "rsa-oaep-sha256+aes-256-gcm"
+	// escrowLabel is the OAEP label of the encrypted AES key.
+	escrowLabel = "snapd recovery key escrow"
+
+	minEscrowRSAKeyBits = 2048
+)
+
+// RecoveryKeyEscrow is the blob holding the recovery key of a device
+// encrypted to the public key of an organization. The recovery key is
+// recovered by decrypting EncryptedKey with the private key, with
+// RSA-OAEP-SHA256 and the label "snapd recovery key escrow", and then
+// Ciphertext with the resulting AES-256-GCM key and Nonce. The plaintext is
+// the 16 bytes of the recovery key.
+type RecoveryKeyEscrow struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	// PublicKeySHA256 is the hex encoded SHA-256 of the DER encoded
+	// public key, identifying the key needed for recovery.
+	PublicKeySHA256 string `json:"public-key-sha256"`
+	EncryptedKey    []byte `json:"encrypted-key"`
+	Nonce           []byte `json:"nonce"`
+	Ciphertext      []byte `json:"ciphertext"`
+}
+
+// ExportRecoveryKeyEscrow encrypts the recovery key of the device to the
+// given PEM encoded RSA public key, in PKIX or PKCS #1 form, and returns
+// the JSON encoded RecoveryKeyEscrow to upload to a device management
+// service.
+func ExportRecoveryKeyEscrow(pubkey []byte) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(string(pubkey)), "age1") {
+		// X25519 and ChaCha20-Poly1305 are not available to snapd
+		return nil, fmt.Errorf("cannot export recovery key escrow: age recipients are not supported, use an RSA public key")
+	}
+	rsaKey, der, err := parseEscrowPublicKey(pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot export recovery key escrow: %v", err)
+	}
+
+	rkey, err := RecoveryKeyFromFile(filepath.Join(dirs.SnapFDEDir, "recovery.key"))
+	if err != nil {
+		return nil, err
+	}
+	escrow, err := encryptRecoveryKeyEscrow(rsaKey, der, rkey[:])
+	if err != nil {
+		return nil, fmt.Errorf("cannot export recovery key escrow: %v", err)
+	}
+	return json.Marshal(escrow)
+}
+
+func parseEscrowPublicKey(pubkey []byte) (*rsa.PublicKey, []byte, error) {
+	block, _ := pem.Decode(pubkey)
+	if block == nil {
+		return nil, nil, fmt.Errorf("cannot decode PEM public key")
+	}
+	var rsaKey *rsa.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse public key: %v", err)
+		}
+		var ok bool
+		rsaKey, ok = key.(*rsa.PublicKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse public key: %v", err)
+		}
+		rsaKey = key
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if rsaKey.N.BitLen() < minEscrowRSAKeyBits {
+		return nil, nil, fmt.Errorf("RSA public key too short: %d bits, at least %d are required", rsaKey.N.BitLen(), minEscrowRSAKeyBits)
+	}
+	// the fingerprint is that of the PKIX form whichever form was given
+	der, err := x509.MarshalPKIXPublicKey(rsaKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rsaKey, der, nil
+}
+
+func encryptRecoveryKeyEscrow(pub *rsa.PublicKey, der, plaintext []byte) (*RecoveryKeyEscrow, error) {
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, aesKey, []byte(escrowLabel))
+	if err != nil {
+		return nil, err
+	}
+	fp := sha256.Sum256(der)
+	return &RecoveryKeyEscrow{
+		Version:         1,
+		Algorithm:       escrowAlgorithm,
+		PublicKeySHA256: hex.EncodeToString(fp[:]),
+		EncryptedKey:    encryptedKey,
+		Nonce:           nonce,
+		Ciphertext:      aead.Seal(nil, nonce, plaintext, nil),
+	}, nil
+}
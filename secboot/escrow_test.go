@@ -0,0 +1,150 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type escrowSuite struct {
+	testutil.BaseTest
+
+	priv *rsa.PrivateKey
+	rkey secboot.RecoveryKey
+}
+
+var _ = Suite(&escrowSuite{})
+
+func (s *escrowSuite) SetUpSuite(c *C) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	s.priv = priv
+}
+
+func (s *escrowSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("/") })
+
+	for i := range s.rkey {
+		s.rkey[i] = byte(i + 1)
+	}
+	c.Assert(os.MkdirAll(dirs.SnapFDEDir, 0755), IsNil)
+	err := ioutil.WriteFile(filepath.Join(dirs.SnapFDEDir, "recovery.key"), s.rkey[:], 0600)
+	c.Assert(err, IsNil)
+}
+
+func (s *escrowSuite) pemPublicKey(c *C, pkcs1 bool) []byte {
+	if pkcs1 {
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(&s.priv.PublicKey),
+		})
+	}
+	der, err := x509.MarshalPKIXPublicKey(&s.priv.PublicKey)
+	c.Assert(err, IsNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func (s *escrowSuite) decrypt(c *C, blob []byte) []byte {
+	var escrow secboot.RecoveryKeyEscrow
+	c.Assert(json.Unmarshal(blob, &escrow), IsNil)
+	c.Check(escrow.Version, Equals, 1)
+	c.Check(escrow.Algorithm, Equals, "rsa-oaep-sha256+aes-256-gcm")
+
+	der, err := x509.MarshalPKIXPublicKey(&s.priv.PublicKey)
+	c.Assert(err, IsNil)
+	fp := sha256.Sum256(der)
+	c.Check(escrow.PublicKeySHA256, Equals, hex.EncodeToString(fp[:]))
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, s.priv, escrow.EncryptedKey, []byte("snapd recovery key escrow"))
+	c.Assert(err, IsNil)
+	block, err := aes.NewCipher(aesKey)
+	c.Assert(err, IsNil)
+	aead, err := cipher.NewGCM(block)
+	c.Assert(err, IsNil)
+	plaintext, err := aead.Open(nil, escrow.Nonce, escrow.Ciphertext, nil)
+	c.Assert(err, IsNil)
+	return plaintext
+}
+
+func (s *escrowSuite) TestExportRecoveryKeyEscrowHappy(c *C) {
+	for _, pkcs1 := range []bool{false, true} {
+		blob, err := secboot.ExportRecoveryKeyEscrow(s.pemPublicKey(c, pkcs1))
+		c.Assert(err, IsNil)
+		c.Check(s.decrypt(c, blob), DeepEquals, s.rkey[:])
+	}
+}
+
+func (s *escrowSuite) TestExportRecoveryKeyEscrowIsRandomized(c *C) {
+	pub := s.pemPublicKey(c, false)
+	blob1, err := secboot.ExportRecoveryKeyEscrow(pub)
+	c.Assert(err, IsNil)
+	blob2, err := secboot.ExportRecoveryKeyEscrow(pub)
+	c.Assert(err, IsNil)
+	c.Check(blob1, Not(DeepEquals), blob2)
+}
+
+func (s *escrowSuite) TestExportRecoveryKeyEscrowNoRecoveryKey(c *C) {
+	c.Assert(os.Remove(filepath.Join(dirs.SnapFDEDir, "recovery.key")), IsNil)
+	_, err := secboot.ExportRecoveryKeyEscrow(s.pemPublicKey(c, false))
+	c.Check(err, ErrorMatches, "cannot open recovery key: .*")
+}
+
+func (s *escrowSuite) TestExportRecoveryKeyEscrowBadPublicKey(c *C) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, IsNil)
+	smallPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&small.PublicKey),
+	})
+
+	for _, tc := range []struct {
+		pubkey string
+		err    string
+	}{
+		{"", "cannot export recovery key escrow: cannot decode PEM public key"},
+		{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "cannot export recovery key escrow: age recipients are not supported, use an RSA public key"},
+		{"-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n", `cannot export recovery key escrow: unsupported PEM block type "CERTIFICATE"`},
+		{"-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n", "cannot export recovery key escrow: cannot parse public key: .*"},
+		{string(smallPEM), "cannot export recovery key escrow: RSA public key too short: 1024 bits, at least 2048 are required"},
+	} {
+		_, err := secboot.ExportRecoveryKeyEscrow([]byte(tc.pubkey))
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
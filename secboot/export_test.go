@@ -22,12 +22,24 @@ package secboot
 
 import (
 	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"time"
 
 	sb "github.com/snapcore/secboot"
+
+	"github.com/snapcore/snapd/bootloader"
 )
 
 var (
 	EFIImageFromBootFile = efiImageFromBootFile
+	UKIPCRValue          = ukiPCRValue
+	UKIPCRPhaseValues    = ukiPCRPhaseValues
+	MokPCRValue          = mokPCRValue
+	ApplyMokChanges      = applyMokChanges
+	IsMokAuthorityEvent  = isMokAuthorityEvent
+	ReadUnlockState      = readUnlockState
 )
 
 func MockSbConnectToDefaultTPM(f func() (*sb.TPMConnection, error)) (restore func()) {
@@ -38,6 +50,14 @@ func MockSbConnectToDefaultTPM(f func() (*sb.TPMConnection, error)) (restore fun
 	}
 }
 
+func MockSbSecureConnectToDefaultTPM(f func(ekCertDataReader io.Reader, endorsementAuth []byte) (*sb.TPMConnection, error)) (restore func()) {
+	old := sbSecureConnectToDefaultTPM
+	sbSecureConnectToDefaultTPM = f
+	return func() {
+		sbSecureConnectToDefaultTPM = old
+	}
+}
+
 func MockProvisionTPM(f func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error) (restore func()) {
 	old := provisionTPM
 	provisionTPM = f
@@ -46,6 +66,38 @@ func MockProvisionTPM(f func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLo
 	}
 }
 
+func MockSetLockoutAuth(f func(tpm *sb.TPMConnection, lockoutAuth []byte)) (restore func()) {
+	old := setLockoutAuth
+	setLockoutAuth = f
+	return func() {
+		setLockoutAuth = old
+	}
+}
+
+func MockProvisionSRK(f func(tpm *sb.TPMConnection, template SRKTemplate, vendorHandle uint32) error) (restore func()) {
+	old := provisionSRK
+	provisionSRK = f
+	return func() {
+		provisionSRK = old
+	}
+}
+
+func MockTPMWrapPolicyAuthKey(f func(tpm *sb.TPMConnection, authKey sb.TPMPolicyAuthKey) ([]byte, error)) (restore func()) {
+	old := tpmWrapPolicyAuthKey
+	tpmWrapPolicyAuthKey = f
+	return func() {
+		tpmWrapPolicyAuthKey = old
+	}
+}
+
+func MockTPMUnwrapPolicyAuthKey(f func(tpm *sb.TPMConnection, wrapped []byte) (sb.TPMPolicyAuthKey, error)) (restore func()) {
+	old := tpmUnwrapPolicyAuthKey
+	tpmUnwrapPolicyAuthKey = f
+	return func() {
+		tpmUnwrapPolicyAuthKey = old
+	}
+}
+
 func MockSbAddEFISecureBootPolicyProfile(f func(profile *sb.PCRProtectionProfile, params *sb.EFISecureBootPolicyProfileParams) error) (restore func()) {
 	old := sbAddEFISecureBootPolicyProfile
 	sbAddEFISecureBootPolicyProfile = f
@@ -145,6 +197,26 @@ func MockSbMeasureSnapModelToTPM(f func(tpm *sb.TPMConnection, pcrIndex int, mod
 	}
 }
 
+var ReadLUKSUUID = readLUKSUUIDImpl
+
+func MockReadLUKSUUID(f func(device string) (string, error)) (restore func()) {
+	old := readLUKSUUID
+	readLUKSUUID = f
+	return func() {
+		readLUKSUUID = old
+	}
+}
+
+var ReadLUKSIntegrity = readLUKSIntegrityImpl
+
+func MockReadLUKSIntegrity(f func(device string) (string, error)) (restore func()) {
+	old := readLUKSIntegrity
+	readLUKSIntegrity = f
+	return func() {
+		readLUKSIntegrity = old
+	}
+}
+
 func MockRandomKernelUUID(f func() string) (restore func()) {
 	old := randutilRandomKernelUUID
 	randutilRandomKernelUUID = f
@@ -177,3 +249,241 @@ func MockIsTPMEnabled(f func(tpm *sb.TPMConnection) bool) (restore func()) {
 		isTPMEnabled = old
 	}
 }
+
+func MockReplayEventLog(f func(path string) (map[int][]byte, error)) (restore func()) {
+	old := replayEventLog
+	replayEventLog = f
+	return func() {
+		replayEventLog = old
+	}
+}
+
+func MockReadPCRValues(f func(tpm *sb.TPMConnection, pcrs []int) (map[int][]byte, error)) (restore func()) {
+	old := readPCRValues
+	readPCRValues = f
+	return func() {
+		readPCRValues = old
+	}
+}
+
+func MockComputeProfilePCRValues(f func(tpm *sb.TPMConnection, profile *sb.PCRProtectionProfile) ([]map[int][]byte, error)) (restore func()) {
+	old := computeProfilePCRValues
+	computeProfilePCRValues = f
+	return func() {
+		computeProfilePCRValues = old
+	}
+}
+
+func MockSealedKeyPCRPolicyCounterHandle(f func(keyFile string) (uint32, error)) (restore func()) {
+	old := sealedKeyPCRPolicyCounterHandle
+	sealedKeyPCRPolicyCounterHandle = f
+	return func() {
+		sealedKeyPCRPolicyCounterHandle = old
+	}
+}
+
+func MockComputeUnifiedKernelImagePCRValue(f func(b *bootloader.BootFile) ([]byte, error)) (restore func()) {
+	old := computeUnifiedKernelImagePCRValue
+	computeUnifiedKernelImagePCRValue = f
+	return func() {
+		computeUnifiedKernelImagePCRValue = old
+	}
+}
+
+func MockReadMokAuthorityDigests(f func(path string) ([][]byte, error)) (restore func()) {
+	old := readMokAuthorityDigests
+	readMokAuthorityDigests = f
+	return func() {
+		readMokAuthorityDigests = old
+	}
+}
+
+func MockReadMokVariable(f func(name string) ([]byte, error)) (restore func()) {
+	old := readMokVariable
+	readMokVariable = f
+	return func() {
+		readMokVariable = old
+	}
+}
+
+func MockComputeBootChainPCRValues(f func(modelParams []*SealKeyModelParams) ([]map[int][]byte, error)) (restore func()) {
+	old := computeBootChainPCRValues
+	computeBootChainPCRValues = f
+	return func() {
+		computeBootChainPCRValues = old
+	}
+}
+
+func MockOPTEESealKey(f func(key []byte) ([]byte, error)) (restore func()) {
+	old := opteeSealKey
+	opteeSealKey = f
+	return func() {
+		opteeSealKey = old
+	}
+}
+
+func MockOPTEEUnsealKey(f func(sealed []byte) ([]byte, error)) (restore func()) {
+	old := opteeUnsealKey
+	opteeUnsealKey = f
+	return func() {
+		opteeUnsealKey = old
+	}
+}
+
+func MockCAAMSealKey(f func(key []byte) ([]byte, error)) (restore func()) {
+	old := caamSealKey
+	caamSealKey = f
+	return func() {
+		caamSealKey = old
+	}
+}
+
+func MockCAAMUnsealKey(f func(blob []byte) ([]byte, error)) (restore func()) {
+	old := caamUnsealKey
+	caamUnsealKey = f
+	return func() {
+		caamUnsealKey = old
+	}
+}
+
+var (
+	CAAMKBEncrypt = caamKBEncrypt
+	CAAMKBDecrypt = caamKBDecrypt
+
+	CAAMKeyModifier = caamKeyModifier
+)
+
+func MockReadTPMInfo(f func(tpm *sb.TPMConnection) (*TPMInfo, error)) (restore func()) {
+	old := readTPMInfo
+	readTPMInfo = f
+	return func() {
+		readTPMInfo = old
+	}
+}
+
+func MockIsTPMNVRateError(f func(err error) bool) (restore func()) {
+	old := isTPMNVRateError
+	isTPMNVRateError = f
+	return func() {
+		isTPMNVRateError = old
+	}
+}
+
+func MockTPMNVRetry(attempts int, delay time.Duration) (restore func()) {
+	oldAttempts := tpmNVRetryAttempts
+	oldDelay := tpmNVRetryDelay
+	tpmNVRetryAttempts = attempts
+	tpmNVRetryDelay = delay
+	return func() {
+		tpmNVRetryAttempts = oldAttempts
+		tpmNVRetryDelay = oldDelay
+	}
+}
+
+var NewTPMInfo = newTPMInfo
+
+func MockTPMReadRollbackCounter(f func(tpm *sb.TPMConnection, handle uint32) (uint64, error)) (restore func()) {
+	old := tpmReadRollbackCounter
+	tpmReadRollbackCounter = f
+	return func() {
+		tpmReadRollbackCounter = old
+	}
+}
+
+func MockTPMUndefineNVIndex(f func(tpm *sb.TPMConnection, handle uint32) error) (restore func()) {
+	old := tpmUndefineNVIndex
+	tpmUndefineNVIndex = f
+	return func() {
+		tpmUndefineNVIndex = old
+	}
+}
+
+func MockTPMEvictSRK(f func(tpm *sb.TPMConnection) error) (restore func()) {
+	old := tpmEvictSRK
+	tpmEvictSRK = f
+	return func() {
+		tpmEvictSRK = old
+	}
+}
+
+func MockTPMExtendPCR(f func(tpm *sb.TPMConnection, pcr int, digest []byte) error) (restore func()) {
+	old := tpmExtendPCR
+	tpmExtendPCR = f
+	return func() {
+		tpmExtendPCR = old
+	}
+}
+
+var SeedVerityDigest = seedVerityDigest
+
+var EncodePCRProfileValues = encodePCRProfileValues
+
+func MockNewAuthRequestor(f func() AuthRequestor) (restore func()) {
+	old := newAuthRequestor
+	newAuthRequestor = f
+	return func() {
+		newAuthRequestor = old
+	}
+}
+
+var (
+	NewAuthRequestor      = newAuthRequestorImpl
+	PlymouthAuthRequestor = plymouthAuthRequestor{}
+)
+
+func MockValidateSealedKey(f func(tpm *sb.TPMConnection, keyFile string, authKey sb.TPMPolicyAuthKey) error) (restore func()) {
+	old := validateSealedKey
+	validateSealedKey = f
+	return func() {
+		validateSealedKey = old
+	}
+}
+
+func MockRecordSealedKeyMetadata(f func(tpm *sb.TPMConnection, keyFiles []string, pcrProfile *sb.PCRProtectionProfile, authKeyFingerprint string, resealed bool)) (restore func()) {
+	old := recordSealedKeyMetadata
+	recordSealedKeyMetadata = f
+	return func() {
+		recordSealedKeyMetadata = old
+	}
+}
+
+var RecordSealedKeyMetadata = recordSealedKeyMetadataImpl
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+var (
+	PolicyAuthKeyFingerprint            = policyAuthKeyFingerprint
+	PolicyAuthKeyFingerprintFromPrivate = policyAuthKeyFingerprintFromPrivate
+)
+
+var RecordUnlockMetrics = recordUnlockMetrics
+
+var RecordUnlockMethod = recordUnlockMethod
+
+func MockJournalNamespaceStreamFile(f func(namespace, identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error)) (restore func()) {
+	old := journalNamespaceStreamFile
+	journalNamespaceStreamFile = f
+	return func() {
+		journalNamespaceStreamFile = old
+	}
+}
+
+var (
+	SwtpmAddress   = swtpmAddress
+	OpenSwtpmTCTI  = openSwtpmTCTI
+	ConnectToSwtpm = connectToSwtpm
+)
+
+func MockNetDial(f func(network, address string) (net.Conn, error)) (restore func()) {
+	old := netDial
+	netDial = f
+	return func() {
+		netDial = old
+	}
+}
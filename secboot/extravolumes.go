@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// ExtraVolume is an encrypted volume declared by the gadget in addition to
+// ubuntu-data and ubuntu-save. It is unlocked in the initramfs with its own
+// sealed key, stored next to the one of ubuntu-data, and mounted at
+// MountPoint.
+//
+// The extra volumes are recorded on ubuntu-data, next to the key of
+// ubuntu-save, so that the record cannot be tampered with without unlocking
+// ubuntu-data first.
+type ExtraVolume struct {
+	// Name is the filesystem label of the volume, the encrypted
+	// partition is labeled with an additional -enc suffix.
+	Name string `json:"name"`
+	// MountPoint is the location where the volume is mounted in the
+	// initramfs.
+	MountPoint string `json:"mount-point"`
+}
+
+// SealedKeyFile returns the path of the sealed key of the volume in the
+// given directory of keys.
+func (v *ExtraVolume) SealedKeyFile(keyDir string) string {
+	return filepath.Join(keyDir, v.Name+".sealed-key")
+}
+
+// RecoveryKeyFile returns the path of the recovery key of the volume in the
+// given FDE directory, next to the recovery key of ubuntu-data.
+func (v *ExtraVolume) RecoveryKeyFile(fdeDir string) string {
+	return filepath.Join(fdeDir, v.Name+".recovery.key")
+}
+
+var (
+	validExtraVolumeName       = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	validExtraVolumeMountPoint = regexp.MustCompile(`^/run/mnt/[a-z0-9]+(-[a-z0-9]+)*$`)
+
+	// reservedExtraVolumeNames are the names of the volumes and mount
+	// points under /run/mnt used by the initramfs
+	reservedExtraVolumeNames = []string{
+		"ubuntu-boot", "ubuntu-seed", "ubuntu-data", "ubuntu-save",
+		"data", "host", "base", "kernel", "snapd", "gadget",
+	}
+)
+
+func (v *ExtraVolume) validate() error {
+	if !validExtraVolumeName.MatchString(v.Name) || strutil.ListContains(reservedExtraVolumeNames, v.Name) {
+		return fmt.Errorf("invalid extra volume name %q", v.Name)
+	}
+	if !validExtraVolumeMountPoint.MatchString(v.MountPoint) || strutil.ListContains(reservedExtraVolumeNames, filepath.Base(v.MountPoint)) {
+		return fmt.Errorf("invalid mount point %q of extra volume %q", v.MountPoint, v.Name)
+	}
+	return nil
+}
+
+func validateExtraVolumes(vols []ExtraVolume) error {
+	seen := make(map[string]bool, 2*len(vols))
+	for i := range vols {
+		vol := &vols[i]
+		if err := vol.validate(); err != nil {
+			return err
+		}
+		for _, what := range []string{vol.Name, vol.MountPoint} {
+			if seen[what] {
+				return fmt.Errorf("duplicate extra volume %q", what)
+			}
+			seen[what] = true
+		}
+	}
+	return nil
+}
+
+// ExtraVolumesFile returns the file where the extra volumes are recorded in
+// the given FDE directory of ubuntu-data.
+func ExtraVolumesFile(fdeDir string) string {
+	return filepath.Join(fdeDir, "extra-volumes.json")
+}
+
+// WriteExtraVolumes records the extra volumes in the given FDE directory of
+// ubuntu-data, for the initramfs to unlock them.
+func WriteExtraVolumes(fdeDir string, vols []ExtraVolume) error {
+	if err := validateExtraVolumes(vols); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(vols)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fdeDir, 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(ExtraVolumesFile(fdeDir), buf, 0600, 0)
+}
+
+// ReadExtraVolumes returns the extra volumes recorded in the given FDE
+// directory of ubuntu-data. It returns no volumes and no error if none were
+// recorded. The names and mount points of the volumes are validated.
+func ReadExtraVolumes(fdeDir string) ([]ExtraVolume, error) {
+	buf, err := ioutil.ReadFile(ExtraVolumesFile(fdeDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var vols []ExtraVolume
+	if err := json.Unmarshal(buf, &vols); err != nil {
+		return nil, fmt.Errorf("cannot decode extra volumes: %v", err)
+	}
+	if err := validateExtraVolumes(vols); err != nil {
+		return nil, err
+	}
+	return vols, nil
+}
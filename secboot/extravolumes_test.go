@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type extraVolumesSuite struct{}
+
+var _ = Suite(&extraVolumesSuite{})
+
+func (s *extraVolumesSuite) TestReadExtraVolumesNone(c *C) {
+	vols, err := secboot.ReadExtraVolumes(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Check(vols, HasLen, 0)
+}
+
+func (s *extraVolumesSuite) TestWriteReadExtraVolumes(c *C) {
+	keyDir := filepath.Join(c.MkDir(), "device/fde")
+	vols := []secboot.ExtraVolume{
+		{Name: "extra", MountPoint: "/run/mnt/extra"},
+		{Name: "other", MountPoint: "/run/mnt/other"},
+	}
+	err := secboot.WriteExtraVolumes(keyDir, vols)
+	c.Assert(err, IsNil)
+
+	read, err := secboot.ReadExtraVolumes(keyDir)
+	c.Assert(err, IsNil)
+	c.Check(read, DeepEquals, vols)
+
+	c.Check(read[0].SealedKeyFile(keyDir), Equals, filepath.Join(keyDir, "extra.sealed-key"))
+	c.Check(read[0].RecoveryKeyFile(keyDir), Equals, filepath.Join(keyDir, "extra.recovery.key"))
+}
+
+func (s *extraVolumesSuite) TestReadExtraVolumesBad(c *C) {
+	keyDir := c.MkDir()
+	err := ioutil.WriteFile(secboot.ExtraVolumesFile(keyDir), []byte("{"), 0600)
+	c.Assert(err, IsNil)
+
+	_, err = secboot.ReadExtraVolumes(keyDir)
+	c.Check(err, ErrorMatches, "cannot decode extra volumes: .*")
+}
+
+func (s *extraVolumesSuite) TestReadExtraVolumesInvalid(c *C) {
+	for _, tc := range []struct {
+		vols string
+		err  string
+	}{
+		{`[{"name":"../ubuntu-data","mount-point":"/run/mnt/extra"}]`, `invalid extra volume name "../ubuntu-data"`},
+		{`[{"name":"ubuntu-save","mount-point":"/run/mnt/extra"}]`, `invalid extra volume name "ubuntu-save"`},
+		{`[{"name":"extra","mount-point":"/sysroot"}]`, `invalid mount point "/sysroot" of extra volume "extra"`},
+		{`[{"name":"extra","mount-point":"/run/mnt/../../etc"}]`, `invalid mount point "/run/mnt/../../etc" of extra volume "extra"`},
+		{`[{"name":"extra","mount-point":"/run/mnt/data"}]`, `invalid mount point "/run/mnt/data" of extra volume "extra"`},
+		{`[{"name":"extra","mount-point":"/run/mnt/extra"},{"name":"other","mount-point":"/run/mnt/extra"}]`, `duplicate extra volume "/run/mnt/extra"`},
+	} {
+		keyDir := c.MkDir()
+		err := ioutil.WriteFile(secboot.ExtraVolumesFile(keyDir), []byte(tc.vols), 0600)
+		c.Assert(err, IsNil)
+
+		_, err = secboot.ReadExtraVolumes(keyDir)
+		c.Check(err, ErrorMatches, tc.err, Commentf(tc.vols))
+	}
+}
+
+func (s *extraVolumesSuite) TestWriteExtraVolumesInvalid(c *C) {
+	keyDir := c.MkDir()
+	err := secboot.WriteExtraVolumes(keyDir, []secboot.ExtraVolume{
+		{Name: "extra", MountPoint: "/run/mnt/ubuntu-boot"},
+	})
+	c.Assert(err, ErrorMatches, `invalid mount point "/run/mnt/ubuntu-boot" of extra volume "extra"`)
+	c.Check(secboot.ExtraVolumesFile(keyDir), testutil.FileAbsent)
+}
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// FactoryKeyProtectorName is the name of the key protector used while the
+// device is in factory mode. It does not protect the keys at all, they are
+// stored in the clear until the device is sealed and the keys are sealed to
+// the TPM.
+const FactoryKeyProtectorName = "factory"
+
+var factoryKeyHeader = []byte("snapd-factory-key-v1\n")
+
+// EnableFactoryKeyProtector makes the factory key protector available. Unlike
+// the other protectors it is not registered by default, as it accepts keys
+// stored in the clear, and must only be enabled on devices installed in
+// factory mode which were not sealed yet.
+func EnableFactoryKeyProtector() {
+	keyProtectorsMu.Lock()
+	defer keyProtectorsMu.Unlock()
+
+	if _, ok := keyProtectors[FactoryKeyProtectorName]; !ok {
+		keyProtectors[FactoryKeyProtectorName] = factoryKeyProtector{}
+	}
+}
+
+// factoryKeyProtector stores the encryption keys unprotected, so that the
+// device can boot unattended in the factory before the TPM is provisioned.
+type factoryKeyProtector struct{}
+
+func (factoryKeyProtector) Name() string {
+	return FactoryKeyProtectorName
+}
+
+func (factoryKeyProtector) SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
+	for _, k := range keys {
+		buf := append(append([]byte(nil), factoryKeyHeader...), k.Key[:]...)
+		if err := osutil.AtomicWriteFile(k.KeyFile, buf, 0600, 0); err != nil {
+			return fmt.Errorf("cannot write factory key file: %v", err)
+		}
+	}
+	return nil
+}
+
+// ResealKeys only checks the key files, as the keys are not sealed to a
+// policy.
+func (p factoryKeyProtector) ResealKeys(params *ResealKeysParams) error {
+	for _, keyFile := range params.KeyFiles {
+		if !p.IsSealedKey(keyFile) {
+			return fmt.Errorf("cannot reseal %q: not a factory key file", keyFile)
+		}
+	}
+	return nil
+}
+
+func (factoryKeyProtector) IsSealedKey(keyFile string) bool {
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(buf, factoryKeyHeader)
+}
+
+func (factoryKeyProtector) UnsealKey(keyFile string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(buf, factoryKeyHeader) {
+		return nil, fmt.Errorf("cannot read %q: not a factory key file", keyFile)
+	}
+	key := buf[len(factoryKeyHeader):]
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("cannot read %q: invalid key size %d", keyFile, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type factorySuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&factorySuite{})
+
+func (s *factorySuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	secboot.EnableFactoryKeyProtector()
+	s.AddCleanup(func() { secboot.UnregisterKeyProtector(secboot.FactoryKeyProtectorName) })
+}
+
+func (s *factorySuite) TestEnableFactoryKeyProtector(c *C) {
+	secboot.UnregisterKeyProtector(secboot.FactoryKeyProtectorName)
+
+	// not available unless enabled
+	_, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Assert(err, ErrorMatches, `unknown key protector "factory"`)
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("snapd-factory-key-v1\n0123456789"), 0600), IsNil)
+	c.Check(secboot.SealedKeyProtectorName(keyFile), Equals, "")
+
+	secboot.EnableFactoryKeyProtector()
+	// enabling is idempotent
+	secboot.EnableFactoryKeyProtector()
+	_, err = secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Check(secboot.SealedKeyProtectorName(keyFile), Equals, secboot.FactoryKeyProtectorName)
+}
+
+func (s *factorySuite) TestSealUnsealResealHappy(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	var key secboot.EncryptionKey
+	copy(key[:], "0123456789")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{Key: key, KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.FactoryKeyProtectorName,
+	})
+	c.Assert(err, IsNil)
+	c.Check(keyFile, testutil.FileEquals, append([]byte("snapd-factory-key-v1\n"), key[:]...))
+
+	p, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, true)
+	unsealed, err := p.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+
+	// resealing picks the protector from the key files
+	err = secboot.ResealKeys(&secboot.ResealKeysParams{KeyFiles: []string{keyFile}})
+	c.Assert(err, IsNil)
+}
+
+func (s *factorySuite) TestUnsealErrors(c *C) {
+	p, err := secboot.KeyProtectorByName(secboot.FactoryKeyProtectorName)
+	c.Assert(err, IsNil)
+
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, "open .*/ubuntu-data.sealed-key: no such file or directory")
+
+	c.Assert(ioutil.WriteFile(keyFile, []byte("USK$other"), 0600), IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, false)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot read ".*/ubuntu-data.sealed-key": not a factory key file`)
+
+	c.Assert(ioutil.WriteFile(keyFile, []byte("snapd-factory-key-v1\nshort"), 0600), IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, true)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot read ".*/ubuntu-data.sealed-key": invalid key size 5`)
+
+	err = p.ResealKeys(&secboot.ResealKeysParams{KeyFiles: []string{filepath.Join(c.MkDir(), "other")}})
+	c.Assert(err, ErrorMatches, `cannot reseal ".*/other": not a factory key file`)
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	sb "github.com/snapcore/secboot"
+)
+
+const (
+	// sealedKeyDataHeader is the magic value ("USK$") at the start of
+	// every sealed key file written by secboot
+	sealedKeyDataHeader uint32 = 0x55534b24
+	// maxSealedKeyDataVersion is the most recent key data version that
+	// the secboot version snapd is built with knows how to unseal
+	maxSealedKeyDataVersion uint32 = 1
+)
+
+var validateSealedKey = validateSealedKeyImpl
+
+func validateSealedKeyImpl(tpm *sb.TPMConnection, keyFile string, authKey sb.TPMPolicyAuthKey) error {
+	k, err := sbReadSealedKeyObject(keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read sealed key object: %v", err)
+	}
+	// this checks that the sealed object and its PCR policy counter are
+	// valid for this TPM and that the dynamic PCR policy is authorized
+	// by the given auth key
+	return k.Validate(tpm, authKey)
+}
+
+// checkSealedKeyDataHeader verifies the header of a sealed key file and
+// returns the version of its key data.
+func checkSealedKeyDataHeader(keyFile string) (version uint32, err error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var hdr struct {
+		Magic   uint32
+		Version uint32
+	}
+	if err := binary.Read(f, binary.BigEndian, &hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("invalid key data header: file is too short")
+		}
+		return 0, fmt.Errorf("cannot read key data header: %v", err)
+	}
+	if hdr.Magic != sealedKeyDataHeader {
+		return 0, fmt.Errorf("invalid key data header: unexpected magic %#x", hdr.Magic)
+	}
+	return hdr.Version, nil
+}
+
+// CheckSealedKeyFiles verifies that the given sealed key files are intact
+// and can still be unsealed once the system is rebooted. It checks the key
+// data header and version of each file, and asks secboot to validate the
+// sealed object, its PCR policy counter and that its PCR policy is linked
+// with the authorization policy update key at tpmPolicyAuthKeyFile. It is
+// meant to be run after resealing and before rebooting. If any of the key
+// files fails the check a *SealedKeyFilesError describing all problems
+// found is returned.
+func CheckSealedKeyFiles(keyFiles []string, tpmPolicyAuthKeyFile string) error {
+	authKeyFileContent, err := ioutil.ReadFile(tpmPolicyAuthKeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read the policy auth key file: %v", err)
+	}
+
+	tpm, err := sbConnectToDefaultTPM()
+	if err != nil {
+		return fmt.Errorf("cannot connect to TPM: %v", err)
+	}
+	defer tpm.Close()
+	if !isTPMEnabled(tpm) {
+		return fmt.Errorf("TPM device is not enabled")
+	}
+	authKey, err := policyAuthKeyFromFileContent(tpm, authKeyFileContent)
+	if err != nil {
+		return err
+	}
+
+	var problems []*SealedKeyFileError
+	for _, keyFile := range keyFiles {
+		version, err := checkSealedKeyDataHeader(keyFile)
+		if err == nil && version > maxSealedKeyDataVersion {
+			err = fmt.Errorf("unsupported key data version %d (expected at most %d)", version, maxSealedKeyDataVersion)
+		}
+		if err == nil {
+			err = validateSealedKey(tpm, keyFile, authKey)
+		}
+		if err != nil {
+			problems = append(problems, &SealedKeyFileError{KeyFile: keyFile, Err: err})
+		}
+	}
+	if len(problems) > 0 {
+		return &SealedKeyFilesError{Problems: problems}
+	}
+	return nil
+}
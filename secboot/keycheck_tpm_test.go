@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+)
+
+func (s *secbootSuite) TestCheckSealedKeyFilesHappy(c *C) {
+	d := c.MkDir()
+	authKeyFile := filepath.Join(d, "auth-key")
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte("auth-key"), 0600), IsNil)
+	keyFiles := []string{filepath.Join(d, "v0.sealed-key"), filepath.Join(d, "v1.sealed-key")}
+	c.Assert(ioutil.WriteFile(keyFiles[0], []byte("USK$\x00\x00\x00\x00data"), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(keyFiles[1], []byte("USK$\x00\x00\x00\x01data"), 0600), IsNil)
+
+	tpm, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	var validated []string
+	restore = secboot.MockValidateSealedKey(func(t *sb.TPMConnection, keyFile string, authKey sb.TPMPolicyAuthKey) error {
+		c.Check(t, Equals, tpm)
+		c.Check(authKey, DeepEquals, sb.TPMPolicyAuthKey("auth-key"))
+		validated = append(validated, keyFile)
+		return nil
+	})
+	defer restore()
+
+	err := secboot.CheckSealedKeyFiles(keyFiles, authKeyFile)
+	c.Assert(err, IsNil)
+	c.Check(validated, DeepEquals, keyFiles)
+}
+
+func (s *secbootSuite) TestCheckSealedKeyFilesProblems(c *C) {
+	d := c.MkDir()
+	authKeyFile := filepath.Join(d, "auth-key")
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte("auth-key"), 0600), IsNil)
+	write := func(name, content string) string {
+		p := filepath.Join(d, name)
+		c.Assert(ioutil.WriteFile(p, []byte(content), 0600), IsNil)
+		return p
+	}
+	good := write("good", "USK$\x00\x00\x00\x01data")
+	truncated := write("truncated", "USK$")
+	garbage := write("garbage", "garbage-data")
+	future := write("future", "USK$\x00\x00\x00\x02data")
+	revoked := write("revoked", "USK$\x00\x00\x00\x01data")
+	missing := filepath.Join(d, "missing")
+
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	var validated []string
+	restore = secboot.MockValidateSealedKey(func(t *sb.TPMConnection, keyFile string, authKey sb.TPMPolicyAuthKey) error {
+		validated = append(validated, keyFile)
+		if keyFile == revoked {
+			return errors.New("the PCR policy has been revoked")
+		}
+		return nil
+	})
+	defer restore()
+
+	err := secboot.CheckSealedKeyFiles([]string{good, truncated, garbage, future, revoked, missing}, authKeyFile)
+	c.Assert(err, FitsTypeOf, &secboot.SealedKeyFilesError{})
+	problems := err.(*secboot.SealedKeyFilesError).Problems
+	c.Assert(problems, HasLen, 5)
+	c.Check(problems[0].KeyFile, Equals, truncated)
+	c.Check(problems[0].Err, ErrorMatches, "invalid key data header: file is too short")
+	c.Check(problems[1].KeyFile, Equals, garbage)
+	c.Check(problems[1].Err, ErrorMatches, "invalid key data header: unexpected magic 0x67617262")
+	c.Check(problems[2].KeyFile, Equals, future)
+	c.Check(problems[2].Err, ErrorMatches, `unsupported key data version 2 \(expected at most 1\)`)
+	c.Check(problems[3].KeyFile, Equals, revoked)
+	c.Check(problems[3].Err, ErrorMatches, "the PCR policy has been revoked")
+	c.Check(problems[4].KeyFile, Equals, missing)
+	c.Check(problems[4].Err, ErrorMatches, "open .*/missing: no such file or directory")
+	c.Check(err, ErrorMatches, `invalid sealed key files:
+- .*/truncated: invalid key data header: file is too short
+- .*/garbage: invalid key data header: unexpected magic 0x67617262
+- .*/future: unsupported key data version 2 \(expected at most 1\)
+- .*/revoked: the PCR policy has been revoked
+- .*/missing: open .*/missing: no such file or directory`)
+	// only the files with a sane header are validated against the TPM
+	c.Check(validated, DeepEquals, []string{good, revoked})
+}
+
+func (s *secbootSuite) TestCheckSealedKeyFilesSingleProblem(c *C) {
+	d := c.MkDir()
+	authKeyFile := filepath.Join(d, "auth-key")
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte("auth-key"), 0600), IsNil)
+
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+
+	err := secboot.CheckSealedKeyFiles([]string{filepath.Join(d, "missing")}, authKeyFile)
+	c.Check(err, ErrorMatches, `invalid sealed key file .*/missing: open .*/missing: no such file or directory`)
+}
+
+func (s *secbootSuite) TestCheckSealedKeyFilesErrors(c *C) {
+	d := c.MkDir()
+	authKeyFile := filepath.Join(d, "auth-key")
+
+	err := secboot.CheckSealedKeyFiles([]string{"keyfile"}, authKeyFile)
+	c.Check(err, ErrorMatches, "cannot read the policy auth key file: open .*/auth-key: no such file or directory")
+
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte("auth-key"), 0600), IsNil)
+
+	_, restore := mockSbTPMConnection(c, errors.New("some error"))
+	defer restore()
+	err = secboot.CheckSealedKeyFiles([]string{"keyfile"}, authKeyFile)
+	c.Check(err, ErrorMatches, "cannot connect to TPM: some error")
+
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return false })
+	defer restore()
+	err = secboot.CheckSealedKeyFiles([]string{"keyfile"}, authKeyFile)
+	c.Check(err, ErrorMatches, "TPM device is not enabled")
+}
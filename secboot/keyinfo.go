@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+
+package secboot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+var timeNow = time.Now
+
+// SealedKeyDetails describes what a sealed key file is bound to.
+type SealedKeyDetails struct {
+	KeyFile string `json:"key-file"`
+	// PCRPolicyCounterHandle is the handle of the NV index used for
+	// revoking the PCR policies of the key.
+	PCRPolicyCounterHandle uint32 `json:"pcr-policy-counter-handle"`
+	// PCRSelection is the list of SHA-256 PCRs the PCR policy of the
+	// key is bound to.
+	PCRSelection []int `json:"pcr-selection,omitempty"`
+	// Created is when the key was sealed.
+	Created time.Time `json:"created,omitempty"`
+	// Resealed is when the PCR policy of the key was last updated.
+	Resealed time.Time `json:"resealed,omitempty"`
+	// AuthorizedPolicyKey is the fingerprint of the public part of the
+	// key authorizing PCR policy updates.
+	AuthorizedPolicyKey string `json:"authorized-policy-key,omitempty"`
+}
+
+// sealedKeyMetadata is what snapd records about a sealed key when sealing
+// and resealing it, as the sealed key object itself does not expose it.
+type sealedKeyMetadata struct {
+	PCRSelection        []int     `json:"pcr-selection,omitempty"`
+	Created             time.Time `json:"created,omitempty"`
+	Resealed            time.Time `json:"resealed,omitempty"`
+	AuthorizedPolicyKey string    `json:"authorized-policy-key,omitempty"`
+}
+
+func sealedKeyMetadataFile(keyFile string) string {
+	return keyFile + ".info"
+}
+
+// readSealedKeyMetadata returns the metadata recorded for the given sealed
+// key file, or nil if there is none, like for keys sealed by older
+// versions of snapd.
+func readSealedKeyMetadata(keyFile string) (*sealedKeyMetadata, error) {
+	b, err := ioutil.ReadFile(sealedKeyMetadataFile(keyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var md sealedKeyMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, fmt.Errorf("cannot decode sealed key metadata: %v", err)
+	}
+	return &md, nil
+}
+
+func writeSealedKeyMetadata(keyFile string, md *sealedKeyMetadata) error {
+	b, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(sealedKeyMetadataFile(keyFile), b, 0600, 0)
+}
+
+// policyAuthKeyFingerprint returns the fingerprint of the given public
+// authorization policy update key, the SHA-256 digest of its DER encoding.
+func policyAuthKeyFingerprint(pub *ecdsa.PublicKey) (string, error) {
+	if pub == nil || pub.Curve == nil {
+		return "", fmt.Errorf("invalid policy auth public key")
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode the policy auth public key: %v", err)
+	}
+	digest := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(digest[:]), nil
+}
+
+// policyAuthKeyFingerprintFromPrivate returns the fingerprint of the public
+// part of the NIST P-256 authorization policy update key with the given
+// private scalar, as it is stored by secboot.
+func policyAuthKeyFingerprintFromPrivate(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "", fmt.Errorf("empty policy auth key")
+	}
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(d)
+	pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if pub.X.Cmp(big.NewInt(0)) == 0 && pub.Y.Cmp(big.NewInt(0)) == 0 {
+		return "", fmt.Errorf("invalid policy auth key")
+	}
+	return policyAuthKeyFingerprint(pub)
+}
+
+// pcrSelection returns the sorted list of PCRs present in any of the given
+// sets of PCR values.
+func pcrSelection(values []map[int][]byte) []int {
+	seen := make(map[int]bool)
+	var pcrs []int
+	for _, v := range values {
+		for pcr := range v {
+			if !seen[pcr] {
+				seen[pcr] = true
+				pcrs = append(pcrs, pcr)
+			}
+		}
+	}
+	sort.Ints(pcrs)
+	return pcrs
+}
+
+// sealedKeyInfo combines the PCR policy counter handle of a sealed key
+// with the metadata recorded for it.
+func sealedKeyInfo(keyFile string, pcrPolicyCounterHandle uint32) (*SealedKeyDetails, error) {
+	info := &SealedKeyDetails{
+		KeyFile:                keyFile,
+		PCRPolicyCounterHandle: pcrPolicyCounterHandle,
+	}
+	md, err := readSealedKeyMetadata(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if md != nil {
+		info.PCRSelection = md.PCRSelection
+		info.Created = md.Created
+		info.Resealed = md.Resealed
+		info.AuthorizedPolicyKey = md.AuthorizedPolicyKey
+	}
+	return info, nil
+}
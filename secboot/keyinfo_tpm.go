@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+
+package secboot
+
+import (
+	"time"
+
+	sb "github.com/snapcore/secboot"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+// SealedKeyInfo returns information about what the sealed key file at the
+// given path is bound to. The PCR policy counter handle is read from the
+// sealed key object itself, the rest is only known for keys sealed by
+// versions of snapd that record it.
+func SealedKeyInfo(keyFile string) (*SealedKeyDetails, error) {
+	handle, err := sealedKeyPCRPolicyCounterHandle(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return sealedKeyInfo(keyFile, handle)
+}
+
+var recordSealedKeyMetadata = recordSealedKeyMetadataImpl
+
+// recordSealedKeyMetadataImpl records the PCR selection of the given profile
+// and the fingerprint of the authorization policy update key for the given
+// sealed key files. When resealing the creation time is preserved. Failing
+// to record the metadata is not fatal, it is only used for auditing keys.
+func recordSealedKeyMetadataImpl(tpm *sb.TPMConnection, keyFiles []string, pcrProfile *sb.PCRProtectionProfile, authKeyFingerprint string, resealed bool) {
+	values, err := computeProfilePCRValues(tpm, pcrProfile)
+	if err != nil {
+		logger.Noticef("cannot compute PCR selection of sealed keys: %v", err)
+	}
+	selection := pcrSelection(values)
+	now := timeNow()
+	for _, keyFile := range keyFiles {
+		md := &sealedKeyMetadata{Created: now}
+		if resealed {
+			// the creation time is not known for keys sealed by
+			// older versions of snapd
+			md.Created = time.Time{}
+			old, err := readSealedKeyMetadata(keyFile)
+			if err != nil {
+				logger.Noticef("cannot read metadata of sealed key %s: %v", keyFile, err)
+			}
+			if old != nil {
+				md.Created = old.Created
+			}
+			md.Resealed = now
+		}
+		md.PCRSelection = selection
+		md.AuthorizedPolicyKey = authKeyFingerprint
+		if err := writeSealedKeyMetadata(keyFile, md); err != nil {
+			logger.Noticef("cannot record metadata of sealed key %s: %v", keyFile, err)
+		}
+	}
+}
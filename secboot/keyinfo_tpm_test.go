@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"time"
+
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *secbootSuite) TestSealedKeyInfoHappy(c *C) {
+	d := c.MkDir()
+	keyFiles := []string{filepath.Join(d, "run.sealed-key"), filepath.Join(d, "fallback.sealed-key")}
+
+	restore := secboot.MockSealedKeyPCRPolicyCounterHandle(func(keyFile string) (uint32, error) {
+		if keyFile == keyFiles[0] {
+			return secboot.RunObjectPCRPolicyCounterHandle, nil
+		}
+		return secboot.FallbackObjectPCRPolicyCounterHandle, nil
+	})
+	defer restore()
+	restore = secboot.MockComputeProfilePCRValues(func(*sb.TPMConnection, *sb.PCRProtectionProfile) ([]map[int][]byte, error) {
+		return []map[int][]byte{
+			{7: []byte("7"), 4: []byte("4"), 12: []byte("12")},
+			{7: []byte("7"), 4: []byte("4"), 12: []byte("12"), 14: []byte("14")},
+		}, nil
+	})
+	defer restore()
+	sealTime := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	now := sealTime
+	restore = secboot.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	secboot.RecordSealedKeyMetadata(nil, keyFiles, nil, "sha256:1234", false)
+	for _, keyFile := range keyFiles {
+		c.Check(keyFile+".info", testutil.FilePresent)
+	}
+
+	info, err := secboot.SealedKeyInfo(keyFiles[0])
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &secboot.SealedKeyDetails{
+		KeyFile:                keyFiles[0],
+		PCRPolicyCounterHandle: secboot.RunObjectPCRPolicyCounterHandle,
+		PCRSelection:           []int{4, 7, 12, 14},
+		Created:                sealTime,
+		AuthorizedPolicyKey:    "sha256:1234",
+	})
+
+	// resealing preserves the creation time
+	now = sealTime.Add(time.Hour)
+	secboot.RecordSealedKeyMetadata(nil, keyFiles[1:], nil, "sha256:1234", true)
+	info, err = secboot.SealedKeyInfo(keyFiles[1])
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &secboot.SealedKeyDetails{
+		KeyFile:                keyFiles[1],
+		PCRPolicyCounterHandle: secboot.FallbackObjectPCRPolicyCounterHandle,
+		PCRSelection:           []int{4, 7, 12, 14},
+		Created:                sealTime,
+		Resealed:               now,
+		AuthorizedPolicyKey:    "sha256:1234",
+	})
+}
+
+func (s *secbootSuite) TestSealedKeyInfoNoMetadata(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "run.sealed-key")
+	restore := secboot.MockSealedKeyPCRPolicyCounterHandle(func(string) (uint32, error) {
+		return secboot.RunObjectPCRPolicyCounterHandle, nil
+	})
+	defer restore()
+
+	// keys sealed by older snapd only have the counter handle
+	info, err := secboot.SealedKeyInfo(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &secboot.SealedKeyDetails{
+		KeyFile:                keyFile,
+		PCRPolicyCounterHandle: secboot.RunObjectPCRPolicyCounterHandle,
+	})
+
+	// which is also what is recorded when they get resealed
+	secboot.RecordSealedKeyMetadata(nil, []string{keyFile}, nil, "", true)
+	info, err = secboot.SealedKeyInfo(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(info.Created.IsZero(), Equals, true)
+	c.Check(info.Resealed.IsZero(), Equals, false)
+}
+
+func (s *secbootSuite) TestSealedKeyInfoError(c *C) {
+	restore := secboot.MockSealedKeyPCRPolicyCounterHandle(func(string) (uint32, error) {
+		return 0, errors.New("cannot read sealed key object: boom")
+	})
+	defer restore()
+
+	_, err := secboot.SealedKeyInfo("keyfile")
+	c.Assert(err, ErrorMatches, "cannot read sealed key object: boom")
+}
+
+func (s *secbootSuite) TestPolicyAuthKeyFingerprint(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	fromPub, err := secboot.PolicyAuthKeyFingerprint(&key.PublicKey)
+	c.Assert(err, IsNil)
+	c.Check(fromPub, Matches, "sha256:[0-9a-f]{64}")
+	fromPriv, err := secboot.PolicyAuthKeyFingerprintFromPrivate(key.D.Bytes())
+	c.Assert(err, IsNil)
+	c.Check(fromPriv, Equals, fromPub)
+
+	_, err = secboot.PolicyAuthKeyFingerprintFromPrivate(nil)
+	c.Assert(err, ErrorMatches, "empty policy auth key")
+	_, err = secboot.PolicyAuthKeyFingerprint(&ecdsa.PublicKey{})
+	c.Assert(err, ErrorMatches, "invalid policy auth public key")
+}
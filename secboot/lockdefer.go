@@ -0,0 +1,50 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+func tpmSealedKeysLockArmedFile() string {
+	return filepath.Join(dirs.SnapBootstrapRunDir, "tpm-sealed-keys-lock-armed")
+}
+
+// ArmTPMSealedKeysLock arms locking access to the sealed keys so that it
+// happens once the boot reaches the point where no more volumes are
+// unlocked, instead of after a given volume was unlocked. The lock is then
+// carried out by LockTPMSealedKeysIfArmed, called by snap-bootstrap in the
+// initramfs and, as a backstop, from a systemd unit.
+func ArmTPMSealedKeysLock() error {
+	if err := os.MkdirAll(dirs.SnapBootstrapRunDir, 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(tpmSealedKeysLockArmedFile(), nil, 0644, 0)
+}
+
+// TPMSealedKeysLockArmed returns whether locking access to the sealed keys
+// was armed and did not happen yet.
+func TPMSealedKeysLockArmed() bool {
+	return osutil.FileExists(tpmSealedKeysLockArmedFile())
+}
@@ -0,0 +1,168 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/systemd"
+)
+
+const (
+	// unlockMetricsJournalNamespace and unlockMetricsIdentifier are the
+	// journal namespace and syslog identifier of the unlock metrics
+	// records.
+	unlockMetricsJournalNamespace = "snapd"
+	unlockMetricsIdentifier       = "snapd-unlock-metrics"
+)
+
+var journalNamespaceStreamFile = systemd.NewJournalNamespaceStreamFile
+
+// String returns the name of the unlock method as used in unlock metrics.
+func (m UnlockMethod) String() string {
+	switch m {
+	case NotUnlocked:
+		return "not-unlocked"
+	case UnlockedWithSealedKey:
+		return "sealed-key"
+	case UnlockedWithRecoveryKey:
+		return "recovery-key"
+	case UnlockedWithKeyProtector:
+		return "key-protector"
+	case UnlockedWithKey:
+		return "key"
+	default:
+		return "unknown"
+	}
+}
+
+// UnlockMetrics records how an encrypted volume was unlocked at boot.
+type UnlockMetrics struct {
+	Time   time.Time `json:"time"`
+	Volume string    `json:"volume"`
+	// Method is the name of the UnlockMethod used.
+	Method string `json:"method"`
+	// UnsealDuration is the time spent unlocking the volume with the
+	// sealed key. When secboot falls back to asking for the recovery key
+	// by itself, this includes the time spent at the prompt.
+	UnsealDuration time.Duration `json:"unseal-duration,omitempty"`
+	// RecoveryKeyAttempts is the number of times the recovery key was
+	// entered. It is not known when the recovery key was requested by
+	// secboot itself rather than by an AuthRequestor, and is zero then.
+	RecoveryKeyAttempts int `json:"recovery-key-attempts,omitempty"`
+	// KeyProtector is the name of the key protector that unsealed the
+	// key, when it was not the TPM.
+	KeyProtector string `json:"key-protector,omitempty"`
+}
+
+// UnlockMetricsFile returns the file where the unlock metrics of the
+// current boot are recorded, one JSON object per line.
+func UnlockMetricsFile() string {
+	return filepath.Join(dirs.SnapBootstrapRunDir, "unlock-metrics.json")
+}
+
+var unlockMetricsMu sync.Mutex
+
+// recordUnlockMetrics appends the metrics to UnlockMetricsFile and sends
+// them to the snapd journal namespace. Failing to record metrics is not
+// fatal to unlocking, errors are only logged.
+func recordUnlockMetrics(m *UnlockMetrics) {
+	if m.Time.IsZero() {
+		m.Time = timeNow()
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		logger.Noticef("cannot marshal unlock metrics: %v", err)
+		return
+	}
+	buf = append(buf, '\n')
+
+	unlockMetricsMu.Lock()
+	defer unlockMetricsMu.Unlock()
+
+	if err := appendUnlockMetrics(buf); err != nil {
+		logger.Noticef("cannot record unlock metrics: %v", err)
+	}
+	// journald is not necessarily running in the initramfs
+	f, err := journalNamespaceStreamFile(unlockMetricsJournalNamespace, unlockMetricsIdentifier, syslog.LOG_INFO, false)
+	if err != nil {
+		logger.Debugf("cannot send unlock metrics to the journal: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		logger.Debugf("cannot send unlock metrics to the journal: %v", err)
+	}
+}
+
+func appendUnlockMetrics(buf []byte) error {
+	if err := os.MkdirAll(dirs.SnapBootstrapRunDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(UnlockMetricsFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadUnlockMetrics returns the unlock metrics recorded during the current
+// boot, in the order the volumes were unlocked. It returns no metrics and
+// no error if none were recorded.
+func ReadUnlockMetrics() ([]*UnlockMetrics, error) {
+	f, err := os.Open(UnlockMetricsFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var metrics []*UnlockMetrics
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var m UnlockMetrics
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("cannot decode unlock metrics: %v", err)
+		}
+		metrics = append(metrics, &m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type metricsSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&metricsSuite{})
+
+func (s *metricsSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("/") })
+}
+
+func (s *metricsSuite) TestUnlockMethodString(c *C) {
+	c.Check(secboot.NotUnlocked.String(), Equals, "not-unlocked")
+	c.Check(secboot.UnlockedWithSealedKey.String(), Equals, "sealed-key")
+	c.Check(secboot.UnlockedWithRecoveryKey.String(), Equals, "recovery-key")
+	c.Check(secboot.UnlockStatusUnknown.String(), Equals, "unknown")
+	c.Check(secboot.UnlockedWithKeyProtector.String(), Equals, "key-protector")
+	c.Check(secboot.UnlockedWithKey.String(), Equals, "key")
+}
+
+func (s *metricsSuite) TestRecordAndReadUnlockMetrics(c *C) {
+	journal := filepath.Join(c.MkDir(), "journal")
+	var namespaces []string
+	restore := secboot.MockJournalNamespaceStreamFile(func(namespace, identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error) {
+		namespaces = append(namespaces, namespace)
+		c.Check(identifier, Equals, "snapd-unlock-metrics")
+		c.Check(priority, Equals, syslog.LOG_INFO)
+		return os.OpenFile(journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	})
+	defer restore()
+
+	metrics, err := secboot.ReadUnlockMetrics()
+	c.Assert(err, IsNil)
+	c.Check(metrics, HasLen, 0)
+
+	t := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	secboot.RecordUnlockMetrics(&secboot.UnlockMetrics{
+		Time:           t,
+		Volume:         "ubuntu-data",
+		Method:         "sealed-key",
+		UnsealDuration: time.Second,
+	})
+	secboot.RecordUnlockMetrics(&secboot.UnlockMetrics{
+		Time:                t,
+		Volume:              "ubuntu-save",
+		Method:              "recovery-key",
+		RecoveryKeyAttempts: 2,
+	})
+
+	expected := `{"time":"2021-06-01T10:00:00Z","volume":"ubuntu-data","method":"sealed-key","unseal-duration":1000000000}
+{"time":"2021-06-01T10:00:00Z","volume":"ubuntu-save","method":"recovery-key","recovery-key-attempts":2}
+`
+	c.Check(secboot.UnlockMetricsFile(), testutil.FileEquals, expected)
+	c.Check(secboot.UnlockMetricsFile(), Equals, filepath.Join(dirs.SnapBootstrapRunDir, "unlock-metrics.json"))
+	c.Check(journal, testutil.FileEquals, expected)
+	c.Check(namespaces, DeepEquals, []string{"snapd", "snapd"})
+
+	metrics, err = secboot.ReadUnlockMetrics()
+	c.Assert(err, IsNil)
+	c.Check(metrics, DeepEquals, []*secboot.UnlockMetrics{
+		{Time: t, Volume: "ubuntu-data", Method: "sealed-key", UnsealDuration: time.Second},
+		{Time: t, Volume: "ubuntu-save", Method: "recovery-key", RecoveryKeyAttempts: 2},
+	})
+}
+
+func (s *metricsSuite) TestRecordUnlockMetricsNoJournal(c *C) {
+	restore := secboot.MockJournalNamespaceStreamFile(func(string, string, syslog.Priority, bool) (*os.File, error) {
+		return nil, os.ErrNotExist
+	})
+	defer restore()
+
+	secboot.RecordUnlockMetrics(&secboot.UnlockMetrics{Volume: "ubuntu-data", Method: "not-unlocked"})
+
+	metrics, err := secboot.ReadUnlockMetrics()
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	c.Check(metrics[0].Method, Equals, "not-unlocked")
+	c.Check(metrics[0].Time.IsZero(), Equals, false)
+}
+
+func (s *metricsSuite) TestReadUnlockMetricsCorrupted(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapBootstrapRunDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(secboot.UnlockMetricsFile(), []byte("{\n"), 0644), IsNil)
+
+	_, err := secboot.ReadUnlockMetrics()
+	c.Assert(err, ErrorMatches, "cannot decode unlock metrics: .*")
+}
@@ -0,0 +1,267 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/snapcore/snapd/bootloader/efi"
+	"github.com/snapcore/snapd/dirs"
+)
+
+// shimLockGUID is the vendor GUID of the variables maintained by shim.
+const shimLockGUID = "605dab50-e046-4300-abb6-3dd810dd8b23"
+
+// mokPCR is the TPM PCR that shim measures the machine owner key state into.
+const mokPCR = 14
+
+// mokVariables are the runtime variables shim mirrors the machine owner key
+// state to, in the order in which it measures them.
+var mokVariables = []string{"MokListRT", "MokListXRT", "MokSBStateRT"}
+
+const (
+	// mokNewVariable and mokDelVariable hold the changes to the machine
+	// owner key list requested with mokutil, which MokManager applies
+	// on the next boot.
+	mokNewVariable = "MokNew"
+	mokDelVariable = "MokDel"
+
+	// mokAuthorityVariable is the name of the variable shim records as
+	// the authority of images it verified with a machine owner key.
+	mokAuthorityVariable = "MokListRT"
+)
+
+var readMokVariable = readMokVariableImpl
+
+// readMokVariableImpl returns the contents of the given shim variable, or nil
+// if the variable does not exist.
+func readMokVariableImpl(name string) ([]byte, error) {
+	fullName := name + "-" + shimLockGUID
+	if _, err := os.Stat(filepath.Join(dirs.GlobalRootDir, "/sys/firmware/efi/efivars", fullName)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	b, _, err := efi.ReadVarBytes(fullName)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readMokState returns the contents of the shim machine owner key state
+// variables, indexed like mokVariables.
+func readMokState() ([][]byte, error) {
+	state := make([][]byte, 0, len(mokVariables))
+	for _, name := range mokVariables {
+		b, err := readMokVariable(name)
+		if err != nil {
+			return nil, err
+		}
+		state = append(state, b)
+	}
+	return state, nil
+}
+
+// MachineOwnerKeysEnrolled returns whether any keys have been enrolled into
+// the shim machine owner key list of the running system, or are requested
+// to be on the next boot.
+func MachineOwnerKeysEnrolled() bool {
+	for _, name := range []string{mokVariables[0], mokNewVariable} {
+		b, err := readMokVariable(name)
+		if err == nil && len(b) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MachineOwnerKeyState returns a digest identifying the machine owner key
+// state shim measures on the next boot, including the changes requested
+// with mokutil, or an empty string if no machine owner keys are in use.
+// Keys sealed with the machine owner keys must be resealed when it changes.
+func MachineOwnerKeyState() (string, error) {
+	if !MachineOwnerKeysEnrolled() {
+		return "", nil
+	}
+	states, err := predictedMokStates()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, state := range states {
+		h.Write(mokPCRValue(state))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// predictedMokStates returns the machine owner key states shim may measure
+// on the next boot, the current one followed, if changes to the key list
+// were requested, by the one after MokManager applied them.
+func predictedMokStates() ([][][]byte, error) {
+	current, err := readMokState()
+	if err != nil {
+		return nil, err
+	}
+	added, err := readMokVariable(mokNewVariable)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := readMokVariable(mokDelVariable)
+	if err != nil {
+		return nil, err
+	}
+	if len(added) == 0 && len(deleted) == 0 {
+		return [][][]byte{current}, nil
+	}
+	list, err := applyMokChanges(current[0], added, deleted)
+	if err != nil {
+		return nil, err
+	}
+	next := append([][]byte{list}, current[1:]...)
+	return [][][]byte{current, next}, nil
+}
+
+// splitSignatureLists splits the given sequence of EFI_SIGNATURE_LIST
+// structures, the format of the machine owner key variables.
+func splitSignatureLists(data []byte) ([][]byte, error) {
+	var lists [][]byte
+	for len(data) != 0 {
+		// SignatureType (16 bytes) is followed by SignatureListSize
+		if len(data) < 28 {
+			return nil, fmt.Errorf("truncated signature list")
+		}
+		size := binary.LittleEndian.Uint32(data[16:20])
+		if size < 28 || uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid signature list size %d", size)
+		}
+		lists = append(lists, data[:size])
+		data = data[size:]
+	}
+	return lists, nil
+}
+
+// applyMokChanges returns the machine owner key list after MokManager
+// removed the signature lists requested to be deleted and appended the ones
+// requested to be enrolled.
+func applyMokChanges(list, added, deleted []byte) ([]byte, error) {
+	current, err := splitSignatureLists(list)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse machine owner key list: %v", err)
+	}
+	toDelete, err := splitSignatureLists(deleted)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse machine owner keys to delete: %v", err)
+	}
+	if _, err := splitSignatureLists(added); err != nil {
+		return nil, fmt.Errorf("cannot parse machine owner keys to enroll: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, l := range current {
+		keep := true
+		for _, d := range toDelete {
+			if bytes.Equal(l, d) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			buf.Write(l)
+		}
+	}
+	buf.Write(added)
+	return buf.Bytes(), nil
+}
+
+// decodeVariableData decodes the UEFI_VARIABLE_DATA structure recorded in
+// the event log for measurements of EFI variables.
+func decodeVariableData(data []byte) (guid []byte, name string, value []byte, err error) {
+	if len(data) < 32 {
+		return nil, "", nil, fmt.Errorf("truncated variable data")
+	}
+	nameLen := binary.LittleEndian.Uint64(data[16:24])
+	valueLen := binary.LittleEndian.Uint64(data[24:32])
+	rest := data[32:]
+	if nameLen > uint64(len(rest))/2 || valueLen != uint64(len(rest))-2*nameLen {
+		return nil, "", nil, fmt.Errorf("invalid variable data sizes")
+	}
+	name16 := make([]uint16, nameLen)
+	for i := range name16 {
+		name16[i] = binary.LittleEndian.Uint16(rest[2*i:])
+	}
+	return data[:16], string(utf16.Decode(name16)), rest[2*nameLen:], nil
+}
+
+// isMokAuthorityEvent returns whether the given UEFI_VARIABLE_DATA of an
+// EV_EFI_VARIABLE_AUTHORITY event records an image verified by shim with a
+// machine owner key.
+func isMokAuthorityEvent(data []byte) bool {
+	guid, name, _, err := decodeVariableData(data)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(guid, efiGUIDBytes(shimLockGUID)) && (name == mokAuthorityVariable || name == "MokList")
+}
+
+// mokValidationDisabled returns whether the MokSBState recorded in the given
+// state disables the verification of images by shim.
+func mokValidationDisabled(state [][]byte) bool {
+	sbState := state[2]
+	return len(sbState) != 0 && sbState[0] == 1
+}
+
+// mokPCRValue computes the value of the MOK PCR after shim has measured the
+// given machine owner key state, each non-empty variable being extended as
+// the digest of its contents.
+func mokPCRValue(state [][]byte) []byte {
+	value := make([]byte, sha256.Size)
+	for _, data := range state {
+		if len(data) == 0 {
+			continue
+		}
+		digest := sha256.Sum256(data)
+		h := sha256.New()
+		h.Write(value)
+		h.Write(digest[:])
+		value = h.Sum(nil)
+	}
+	return value
+}
+
+// efiGUIDBytes returns the binary form of an EFI GUID, where the first three
+// groups are little-endian.
+func efiGUIDBytes(guid string) []byte {
+	raw, err := hex.DecodeString(strings.Replace(guid, "-", "", -1))
+	if err != nil || len(raw) != 16 {
+		panic(fmt.Sprintf("internal error: invalid GUID %q", guid))
+	}
+	out := make([]byte, 16)
+	binary.LittleEndian.PutUint32(out[0:4], binary.BigEndian.Uint32(raw[0:4]))
+	binary.LittleEndian.PutUint16(out[4:6], binary.BigEndian.Uint16(raw[4:6]))
+	binary.LittleEndian.PutUint16(out[6:8], binary.BigEndian.Uint16(raw[6:8]))
+	copy(out[8:], raw[8:])
+	return out
+}
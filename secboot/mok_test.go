@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader/efi"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+)
+
+type mokSuite struct{}
+
+var _ = Suite(&mokSuite{})
+
+func (s *mokSuite) TestMokPCRValue(c *C) {
+	// no state measured
+	c.Check(secboot.MokPCRValue([][]byte{nil, nil, nil}), DeepEquals, make([]byte, 32))
+
+	// empty MokListXRT is skipped
+	value := secboot.MokPCRValue([][]byte{[]byte("mok-list"), nil, {0}})
+	c.Check(hex.EncodeToString(value), Equals, "2ece79cc6a83a2d6c3071c395141372ab4e4d814ef7e56530446f0b7712a7cec")
+}
+
+func (s *mokSuite) TestMachineOwnerKeysEnrolled(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	defer dirs.SetRootDir("")
+
+	// no efivarfs
+	c.Check(secboot.MachineOwnerKeysEnrolled(), Equals, false)
+
+	efivars := filepath.Join(dirs.GlobalRootDir, "/sys/firmware/efi/efivars")
+	c.Assert(os.MkdirAll(efivars, 0755), IsNil)
+	name := "MokListRT-605dab50-e046-4300-abb6-3dd810dd8b23"
+	c.Assert(ioutil.WriteFile(filepath.Join(efivars, name), nil, 0644), IsNil)
+
+	restore := efi.MockVars(map[string][]byte{name: []byte("mok-list")}, nil)
+	defer restore()
+	c.Check(secboot.MachineOwnerKeysEnrolled(), Equals, true)
+
+	restore = efi.MockVars(map[string][]byte{name: nil}, nil)
+	defer restore()
+	c.Check(secboot.MachineOwnerKeysEnrolled(), Equals, false)
+
+	restore = secboot.MockReadMokVariable(func(string) ([]byte, error) {
+		return nil, errors.New("some error")
+	})
+	defer restore()
+	c.Check(secboot.MachineOwnerKeysEnrolled(), Equals, false)
+}
+
+// signatureList returns an EFI_SIGNATURE_LIST with a single signature with
+// the given data.
+func signatureList(data string) []byte {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 16))
+	binary.Write(&buf, binary.LittleEndian, uint32(28+16+len(data)))
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	binary.Write(&buf, binary.LittleEndian, uint32(16+len(data)))
+	buf.Write(make([]byte, 16))
+	buf.WriteString(data)
+	return buf.Bytes()
+}
+
+func (s *mokSuite) TestApplyMokChanges(c *C) {
+	key1 := signatureList("key-1")
+	key2 := signatureList("key-2")
+	key3 := signatureList("key-3")
+	list := append(append([]byte(nil), key1...), key2...)
+
+	res, err := secboot.ApplyMokChanges(list, key3, nil)
+	c.Assert(err, IsNil)
+	c.Check(res, DeepEquals, append(append(append([]byte(nil), key1...), key2...), key3...))
+
+	res, err = secboot.ApplyMokChanges(list, key3, key1)
+	c.Assert(err, IsNil)
+	c.Check(res, DeepEquals, append(append([]byte(nil), key2...), key3...))
+
+	res, err = secboot.ApplyMokChanges(nil, key3, nil)
+	c.Assert(err, IsNil)
+	c.Check(res, DeepEquals, key3)
+
+	_, err = secboot.ApplyMokChanges([]byte("garbage"), key3, nil)
+	c.Check(err, ErrorMatches, "cannot parse machine owner key list: truncated signature list")
+	_, err = secboot.ApplyMokChanges(list, key3[:40], nil)
+	c.Check(err, ErrorMatches, "cannot parse machine owner keys to enroll: invalid signature list size 49")
+}
+
+func (s *mokSuite) TestMachineOwnerKeyState(c *C) {
+	vars := map[string][]byte{}
+	restore := secboot.MockReadMokVariable(func(name string) ([]byte, error) {
+		return vars[name], nil
+	})
+	defer restore()
+
+	// no machine owner keys in use
+	state, err := secboot.MachineOwnerKeyState()
+	c.Assert(err, IsNil)
+	c.Check(state, Equals, "")
+
+	vars["MokListRT"] = signatureList("key-1")
+	enrolled, err := secboot.MachineOwnerKeyState()
+	c.Assert(err, IsNil)
+	c.Check(enrolled, HasLen, 64)
+
+	// requesting a new key changes the state
+	vars["MokNew"] = signatureList("key-2")
+	pending, err := secboot.MachineOwnerKeyState()
+	c.Assert(err, IsNil)
+	c.Check(pending, HasLen, 64)
+	c.Check(pending, Not(Equals), enrolled)
+
+	// so does applying it
+	vars["MokListRT"] = append(signatureList("key-1"), signatureList("key-2")...)
+	delete(vars, "MokNew")
+	applied, err := secboot.MachineOwnerKeyState()
+	c.Assert(err, IsNil)
+	c.Check(applied, Not(Equals), pending)
+	c.Check(applied, Not(Equals), enrolled)
+
+	// a first key requested to be enrolled is in use
+	vars = map[string][]byte{"MokNew": signatureList("key-1")}
+	state, err = secboot.MachineOwnerKeyState()
+	c.Assert(err, IsNil)
+	c.Check(state, HasLen, 64)
+}
+
+func variableData(guid []byte, name, data string) []byte {
+	name16 := utf16.Encode([]rune(name))
+	var buf bytes.Buffer
+	buf.Write(guid)
+	binary.Write(&buf, binary.LittleEndian, uint64(len(name16)))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	binary.Write(&buf, binary.LittleEndian, name16)
+	buf.WriteString(data)
+	return buf.Bytes()
+}
+
+func (s *mokSuite) TestIsMokAuthorityEvent(c *C) {
+	// 605dab50-e046-4300-abb6-3dd810dd8b23 in its binary form
+	shimGUID := []byte{0x50, 0xab, 0x5d, 0x60, 0x46, 0xe0, 0x00, 0x43, 0xab, 0xb6, 0x3d, 0xd8, 0x10, 0xdd, 0x8b, 0x23}
+
+	c.Check(secboot.IsMokAuthorityEvent(variableData(shimGUID, "MokListRT", "cert")), Equals, true)
+	c.Check(secboot.IsMokAuthorityEvent(variableData(shimGUID, "MokList", "cert")), Equals, true)
+	// vendor certificate embedded in shim
+	c.Check(secboot.IsMokAuthorityEvent(variableData(shimGUID, "Shim", "cert")), Equals, false)
+	// firmware db
+	c.Check(secboot.IsMokAuthorityEvent(variableData(make([]byte, 16), "db", "cert")), Equals, false)
+	// garbage
+	c.Check(secboot.IsMokAuthorityEvent([]byte("garbage")), Equals, false)
+	data := variableData(shimGUID, "MokListRT", "cert")
+	c.Check(secboot.IsMokAuthorityEvent(data[:len(data)-1]), Equals, false)
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// OPTEEKeyProtectorName is the name of the key protector sealing keys with
+// the OP-TEE trusted application on ARM TrustZone devices.
+const OPTEEKeyProtectorName = "optee"
+
+var (
+	// UUID of the FDE trusted application, it must be part of the OP-TEE
+	// image of the device
+	opteeFDETAUUID = [16]byte{
+		0xfd, 0x1b, 0x2a, 0x86, 0x34, 0x68, 0x4c, 0xc5,
+		0x9b, 0x43, 0x95, 0x5e, 0x1b, 0x4f, 0x7a, 0x53,
+	}
+
+	opteeSealedKeyHeader = []byte("snapd-optee-sealed-key-v1\n")
+)
+
+const (
+	// commands of the FDE trusted application
+	opteeCmdSealKey   = 1
+	opteeCmdUnsealKey = 2
+)
+
+var (
+	opteeSealKey   = opteeSealKeyImpl
+	opteeUnsealKey = opteeUnsealKeyImpl
+)
+
+func init() {
+	RegisterKeyProtector(&blobKeyProtector{
+		name:           OPTEEKeyProtectorName,
+		backend:        "OP-TEE",
+		header:         opteeSealedKeyHeader,
+		checkSupported: CheckOPTEEKeySealingSupported,
+		seal: func(key []byte) ([]byte, error) {
+			return opteeSealKey(key)
+		},
+		unseal: func(sealed []byte) ([]byte, error) {
+			return opteeUnsealKey(sealed)
+		},
+	})
+}
+
+// CheckOPTEEKeySealingSupported checks whether OP-TEE is running, which is
+// the case when the device of its supplicant is present.
+func CheckOPTEEKeySealingSupported() error {
+	if !osutil.FileExists(filepath.Join(dirs.GlobalRootDir, "/dev/teepriv0")) {
+		return fmt.Errorf("OP-TEE is not available")
+	}
+	return nil
+}
+
+func opteeInvoke(cmd uint32, input []byte) ([]byte, error) {
+	s, err := openTEESession(filepath.Join(dirs.GlobalRootDir, "/dev/tee0"), opteeFDETAUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.invoke(cmd, input)
+}
+
+// opteeSealKeyImpl has the trusted application seal the key with the
+// hardware unique key of the device.
+func opteeSealKeyImpl(key []byte) ([]byte, error) {
+	return opteeInvoke(opteeCmdSealKey, key)
+}
+
+func opteeUnsealKeyImpl(sealed []byte) ([]byte, error) {
+	return opteeInvoke(opteeCmdUnsealKey, sealed)
+}
@@ -0,0 +1,190 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type opteeSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&opteeSuite{})
+
+func (s *opteeSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	// a trusted application which "seals" by reversing the key
+	reverse := func(in []byte) []byte {
+		out := make([]byte, len(in))
+		for i := range in {
+			out[len(in)-1-i] = in[i]
+		}
+		return out
+	}
+	s.AddCleanup(secboot.MockOPTEESealKey(func(key []byte) ([]byte, error) {
+		return append([]byte("sealed:"), reverse(key)...), nil
+	}))
+	s.AddCleanup(secboot.MockOPTEEUnsealKey(func(sealed []byte) ([]byte, error) {
+		if !bytes.HasPrefix(sealed, []byte("sealed:")) {
+			return nil, errors.New("invalid sealed key")
+		}
+		return reverse(sealed[len("sealed:"):]), nil
+	}))
+	// there is no TPM on the devices with OP-TEE
+	s.AddCleanup(secboot.MockComputeBootChainPCRValues(func(modelParams []*secboot.SealKeyModelParams) ([]map[int][]byte, error) {
+		c.Errorf("unexpected call")
+		return nil, errors.New("cannot connect to TPM: no TPM")
+	}))
+}
+
+var opteeModelParams = []*secboot.SealKeyModelParams{{}}
+
+func (s *opteeSuite) mockTEE(c *C) {
+	devTee := filepath.Join(dirs.GlobalRootDir, "/dev/teepriv0")
+	c.Assert(os.MkdirAll(filepath.Dir(devTee), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(devTee, nil, 0644), IsNil)
+}
+
+func (s *opteeSuite) TestCheckOPTEEKeySealingSupported(c *C) {
+	c.Check(secboot.CheckOPTEEKeySealingSupported(), ErrorMatches, "OP-TEE is not available")
+	s.mockTEE(c)
+	c.Check(secboot.CheckOPTEEKeySealingSupported(), IsNil)
+}
+
+func (s *opteeSuite) TestSealUnsealResealNoTPM(c *C) {
+	s.mockTEE(c)
+	c.Assert(filepath.Join(dirs.GlobalRootDir, "/dev/tpm0"), testutil.FileAbsent)
+	c.Assert(filepath.Join(dirs.GlobalRootDir, "/dev/tpmrm0"), testutil.FileAbsent)
+
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	var key secboot.EncryptionKey
+	copy(key[:], "0123456789")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{Key: key, KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.OPTEEKeyProtectorName,
+		ModelParams:  opteeModelParams,
+	})
+	c.Assert(err, IsNil)
+
+	// the key is not stored in the clear
+	buf, err := ioutil.ReadFile(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(bytes.Contains(buf, key[:10]), Equals, false)
+
+	p, err := secboot.KeyProtectorByName(secboot.OPTEEKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, true)
+	unsealed, err := p.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+
+	// resealing picks the protector from the key files, the blob does
+	// not depend on the boot chains so it is left alone
+	err = secboot.ResealKeys(&secboot.ResealKeysParams{KeyFiles: []string{keyFile}, ModelParams: opteeModelParams})
+	c.Assert(err, IsNil)
+	c.Check(keyFile, testutil.FileEquals, buf)
+	unsealed, err = p.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+}
+
+func (s *opteeSuite) TestSealNoModelParams(c *C) {
+	s.mockTEE(c)
+
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.OPTEEKeyProtectorName,
+	})
+	c.Assert(err, IsNil)
+	c.Check(keyFile, testutil.FilePresent)
+}
+
+func (s *opteeSuite) TestResealNotSealedWithOPTEE(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("snapd-caam-sealed-key-v1\n{}"), 0600), IsNil)
+	err := secboot.ResealKeys(&secboot.ResealKeysParams{
+		KeyProtector: secboot.OPTEEKeyProtectorName,
+		KeyFiles:     []string{keyFile},
+	})
+	c.Assert(err, ErrorMatches, `cannot reseal ".*/ubuntu-data.sealed-key": not sealed with OP-TEE`)
+}
+
+func (s *opteeSuite) TestUnsealInvalidBlob(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	header := []byte("snapd-optee-sealed-key-v1\n")
+	buf, err := json.Marshal(map[string][]byte{"blob": []byte("bogus")})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, append(header, buf...), 0600), IsNil)
+
+	p, err := secboot.KeyProtectorByName(secboot.OPTEEKeyProtectorName)
+	c.Assert(err, IsNil)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, "cannot unseal key with OP-TEE: invalid sealed key")
+
+	c.Assert(ioutil.WriteFile(keyFile, append(header, "{}"...), 0600), IsNil)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot unseal ".*": invalid sealed key: no blob`)
+}
+
+func (s *opteeSuite) TestSealNoTEE(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.OPTEEKeyProtectorName,
+	})
+	c.Assert(err, ErrorMatches, "OP-TEE is not available")
+	c.Check(keyFile, testutil.FileAbsent)
+}
+
+func (s *opteeSuite) TestSealAndUnsealErrors(c *C) {
+	s.mockTEE(c)
+	restore := secboot.MockOPTEESealKey(func(key []byte) ([]byte, error) {
+		return nil, errors.New("TA error")
+	})
+	defer restore()
+
+	keyFile := filepath.Join(c.MkDir(), "ubuntu-data.sealed-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: secboot.OPTEEKeyProtectorName,
+		ModelParams:  opteeModelParams,
+	})
+	c.Assert(err, ErrorMatches, "cannot seal key with OP-TEE: TA error")
+
+	p, err := secboot.KeyProtectorByName(secboot.OPTEEKeyProtectorName)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, []byte("USK$other"), 0600), IsNil)
+	c.Check(p.IsSealedKey(keyFile), Equals, false)
+	_, err = p.UnsealKey(keyFile)
+	c.Assert(err, ErrorMatches, `cannot unseal ".*/ubuntu-data.sealed-key": not sealed with OP-TEE`)
+}
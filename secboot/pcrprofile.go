@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// encodePCRProfileValues returns the canonical text form of the SHA-256 PCR
+// values computed for the branches of a PCR protection profile. Each branch
+// is a line listing its PCRs in increasing order with their values, eg.:
+//
+//	4=<hex> 7=<hex> 12=<hex>
+//
+// The lines are sorted and duplicated branches are dropped, so that the form
+// only depends on the set of PCR states allowed by the profile and not on
+// the way the profile was built.
+func encodePCRProfileValues(branches []map[int][]byte) []byte {
+	lines := make([]string, 0, len(branches))
+	seen := make(map[string]bool, len(branches))
+	for _, values := range branches {
+		pcrs := make([]int, 0, len(values))
+		for pcr := range values {
+			pcrs = append(pcrs, pcr)
+		}
+		sort.Ints(pcrs)
+
+		var line bytes.Buffer
+		for i, pcr := range pcrs {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			fmt.Fprintf(&line, "%d=%s", pcr, hex.EncodeToString(values[pcr]))
+		}
+		if seen[line.String()] {
+			continue
+		}
+		seen[line.String()] = true
+		lines = append(lines, line.String())
+	}
+	sort.Strings(lines)
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
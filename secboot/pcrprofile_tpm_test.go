@@ -0,0 +1,324 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+// updateGoldenEnvVar regenerates the golden PCR profiles instead of checking
+// them when set, the changes must then be reviewed as they change the PCR
+// policy of the sealed keys.
+const updateGoldenEnvVar = "SNAPD_SECBOOT_UPDATE_GOLDEN"
+
+type pcrProfileSuite struct {
+	testutil.BaseTest
+
+	dir string
+}
+
+var _ = Suite(&pcrProfileSuite{})
+
+func (s *pcrProfileSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.dir = c.MkDir()
+
+	// the profiles added by secboot depend on the actual EFI images and
+	// on the state of the firmware, they are replaced by fakes deriving
+	// the PCR values from their parameters only, so that the golden
+	// profiles cover how snapd builds the profile
+	s.AddCleanup(secboot.MockSbAddEFISecureBootPolicyProfile(func(profile *sb.PCRProtectionProfile, params *sb.EFISecureBootPolicyProfileParams) error {
+		var keystores []string
+		for _, dir := range params.SignatureDbUpdateKeystores {
+			keystores = append(keystores, filepath.Base(dir))
+		}
+		addGoldenLoadPathsProfile(profile, 7, "secure-boot", keystores, params.LoadSequences)
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbAddEFIBootManagerProfile(func(profile *sb.PCRProtectionProfile, params *sb.EFIBootManagerProfileParams) error {
+		addGoldenLoadPathsProfile(profile, 4, "boot-manager", nil, params.LoadSequences)
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbAddSystemdEFIStubProfile(func(profile *sb.PCRProtectionProfile, params *sb.SystemdEFIStubProfileParams) error {
+		var branches []*sb.PCRProtectionProfile
+		for _, cmdline := range params.KernelCmdlines {
+			branches = append(branches, sb.NewPCRProtectionProfile().ExtendPCR(tpm2.HashAlgorithmSHA256, params.PCRIndex, goldenDigest("cmdline", cmdline)))
+		}
+		profile.AddProfileOR(branches...)
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbAddSnapModelProfile(func(profile *sb.PCRProtectionProfile, params *sb.SnapModelProfileParams) error {
+		var branches []*sb.PCRProtectionProfile
+		for _, m := range params.Models {
+			model := m.(*asserts.Model)
+			digest := goldenDigest("model", model.Series(), model.BrandID(), model.Model(), string(model.Grade()))
+			branches = append(branches, sb.NewPCRProtectionProfile().ExtendPCR(tpm2.HashAlgorithmSHA256, params.PCRIndex, digest))
+		}
+		profile.AddProfileOR(branches...)
+		return nil
+	}))
+	s.AddCleanup(secboot.MockComputeUnifiedKernelImagePCRValue(func(b *bootloader.BootFile) ([]byte, error) {
+		return goldenDigest("uki", filepath.Base(b.Path)), nil
+	}))
+	s.AddCleanup(secboot.MockReadMokVariable(func(name string) ([]byte, error) {
+		if name == "MokListRT" {
+			return []byte("mok-list"), nil
+		}
+		return nil, nil
+	}))
+}
+
+// goldenDigest returns the SHA-256 digest of the NUL terminated parts.
+func goldenDigest(parts ...string) []byte {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
+func goldenImageName(image sb.EFIImage) string {
+	switch img := image.(type) {
+	case sb.FileEFIImage:
+		return filepath.Base(string(img))
+	case sb.SnapFileEFIImage:
+		return filepath.Base(img.Path) + ":" + img.FileName
+	}
+	return fmt.Sprintf("%T", image)
+}
+
+// goldenLoadPaths returns the names of the images loaded along each path of
+// the given load event trees.
+func goldenLoadPaths(events []*sb.EFIImageLoadEvent, prefix []string) [][]string {
+	var paths [][]string
+	for _, ev := range events {
+		path := append(append([]string(nil), prefix...), goldenImageName(ev.Image))
+		if len(ev.Next) == 0 {
+			paths = append(paths, path)
+			continue
+		}
+		paths = append(paths, goldenLoadPaths(ev.Next, path)...)
+	}
+	return paths
+}
+
+// addGoldenLoadPathsProfile adds alternative values of the given PCR, one
+// for each path of the load event trees.
+func addGoldenLoadPathsProfile(profile *sb.PCRProtectionProfile, pcr int, what string, extra []string, events []*sb.EFIImageLoadEvent) {
+	var branches []*sb.PCRProtectionProfile
+	for _, path := range goldenLoadPaths(events, nil) {
+		parts := append(append([]string{what}, extra...), path...)
+		branches = append(branches, sb.NewPCRProtectionProfile().AddPCRValue(tpm2.HashAlgorithmSHA256, pcr, goldenDigest(parts...)))
+	}
+	profile.AddProfileOR(branches...)
+}
+
+func (s *pcrProfileSuite) bootFile(c *C, name string) bootloader.BootFile {
+	path := filepath.Join(s.dir, name)
+	c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+	return bootloader.NewBootFile("", path, bootloader.RoleRecovery)
+}
+
+func goldenModel(name, grade string) *asserts.Model {
+	return assertstest.FakeAssertion(map[string]interface{}{
+		"type":         "model",
+		"authority-id": "my-brand",
+		"series":       "16",
+		"brand-id":     "my-brand",
+		"model":        name,
+		"architecture": "amd64",
+		"base":         "core20",
+		"grade":        grade,
+		"timestamp":    "2020-10-01T08:00:00+00:00",
+		"snaps": []interface{}{
+			map[string]interface{}{
+				"name": "pc-kernel",
+				"id":   "pckernelidididididididididididid",
+				"type": "kernel",
+			},
+			map[string]interface{}{
+				"name": "pc",
+				"id":   "pcididididididididididididididid",
+				"type": "gadget",
+			},
+		},
+	}).(*asserts.Model)
+}
+
+// runChain is the usual shim -> grub -> kernel chain of run mode.
+func (s *pcrProfileSuite) runChain(c *C) *secboot.LoadChain {
+	return secboot.NewLoadChain(s.bootFile(c, "bootx64.efi"),
+		secboot.NewLoadChain(s.bootFile(c, "grubx64.efi"),
+			secboot.NewLoadChain(s.bootFile(c, "kernel.efi"))))
+}
+
+const goldenRunCmdline = "snapd_recovery_mode=run console=ttyS0 panic=-1"
+
+func (s *pcrProfileSuite) checkGolden(c *C, name string, modelParams []*secboot.SealKeyModelParams) {
+	profile, err := secboot.ComputePCRProfile(modelParams)
+	c.Assert(err, IsNil)
+	c.Assert(profile, Not(HasLen), 0)
+
+	golden := filepath.Join("testdata", "pcr-profiles", name+".golden")
+	if os.Getenv(updateGoldenEnvVar) != "" {
+		c.Assert(os.MkdirAll(filepath.Dir(golden), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(golden, profile, 0644), IsNil)
+		return
+	}
+	c.Check(golden, testutil.FileEquals, string(profile), Commentf("the PCR profile %q changed, "+
+		"the sealed keys would be bound to other PCR values, set %s=1 to update it if intended", name, updateGoldenEnvVar))
+}
+
+func (s *pcrProfileSuite) TestGoldenRunSingleKernel(c *C) {
+	s.checkGolden(c, "run-single-kernel", []*secboot.SealKeyModelParams{{
+		Model:          goldenModel("my-model", "signed"),
+		EFILoadChains:  []*secboot.LoadChain{s.runChain(c)},
+		KernelCmdlines: []string{goldenRunCmdline},
+	}})
+}
+
+func (s *pcrProfileSuite) TestGoldenRecoveryAndRun(c *C) {
+	chain := secboot.NewLoadChain(s.bootFile(c, "bootx64.efi"),
+		secboot.NewLoadChain(s.bootFile(c, "recovery-grubx64.efi"),
+			secboot.NewLoadChain(s.bootFile(c, "recovery-kernel.efi")),
+			secboot.NewLoadChain(s.bootFile(c, "run-grubx64.efi"),
+				secboot.NewLoadChain(s.bootFile(c, "kernel-good.efi")),
+				secboot.NewLoadChain(s.bootFile(c, "kernel-try.efi")))))
+	s.checkGolden(c, "recovery-and-run", []*secboot.SealKeyModelParams{{
+		Model:         goldenModel("my-model", "signed"),
+		EFILoadChains: []*secboot.LoadChain{chain},
+		KernelCmdlines: []string{
+			goldenRunCmdline,
+			"snapd_recovery_mode=recover snapd_recovery_system=20201001 console=ttyS0 panic=-1",
+		},
+	}})
+}
+
+func (s *pcrProfileSuite) TestGoldenRemodel(c *C) {
+	s.checkGolden(c, "remodel", []*secboot.SealKeyModelParams{{
+		Model:          goldenModel("my-model", "signed"),
+		EFILoadChains:  []*secboot.LoadChain{s.runChain(c)},
+		KernelCmdlines: []string{goldenRunCmdline},
+	}, {
+		Model:          goldenModel("my-new-model", "secured"),
+		EFILoadChains:  []*secboot.LoadChain{s.runChain(c)},
+		KernelCmdlines: []string{goldenRunCmdline},
+	}})
+}
+
+func (s *pcrProfileSuite) TestGoldenMachineOwnerKeys(c *C) {
+	s.checkGolden(c, "mok", []*secboot.SealKeyModelParams{{
+		Model:               goldenModel("my-model", "signed"),
+		EFILoadChains:       []*secboot.LoadChain{s.runChain(c)},
+		KernelCmdlines:      []string{goldenRunCmdline},
+		EFIMachineOwnerKeys: true,
+	}})
+}
+
+func (s *pcrProfileSuite) TestGoldenUnifiedKernelImages(c *C) {
+	chain := secboot.NewLoadChain(s.bootFile(c, "bootx64.efi"),
+		secboot.NewUnifiedKernelImageLoadChain(s.bootFile(c, "uki-good.efi")),
+		secboot.NewUnifiedKernelImageLoadChain(s.bootFile(c, "uki-try.efi")))
+	s.checkGolden(c, "unified-kernel-images", []*secboot.SealKeyModelParams{{
+		Model:         goldenModel("my-model", "signed"),
+		EFILoadChains: []*secboot.LoadChain{chain},
+	}})
+}
+
+func (s *pcrProfileSuite) TestGoldenPendingDbxUpdate(c *C) {
+	s.checkGolden(c, "pending-dbx-update", []*secboot.SealKeyModelParams{{
+		Model:                         goldenModel("my-model", "signed"),
+		EFILoadChains:                 []*secboot.LoadChain{s.runChain(c)},
+		KernelCmdlines:                []string{goldenRunCmdline},
+		EFISignatureDbUpdateKeystores: []string{filepath.Join(s.dir, "dbx-updates")},
+	}})
+}
+
+func (s *pcrProfileSuite) TestComputePCRProfileDeterministic(c *C) {
+	// the same profile is computed whatever the order the alternative
+	// boot chains are listed in
+	kernels := func(names ...string) []*secboot.LoadChain {
+		var chains []*secboot.LoadChain
+		for _, name := range names {
+			chains = append(chains, secboot.NewLoadChain(s.bootFile(c, name)))
+		}
+		return chains
+	}
+	params := func(names ...string) []*secboot.SealKeyModelParams {
+		return []*secboot.SealKeyModelParams{{
+			Model: goldenModel("my-model", "signed"),
+			EFILoadChains: []*secboot.LoadChain{
+				secboot.NewLoadChain(s.bootFile(c, "bootx64.efi"),
+					secboot.NewLoadChain(s.bootFile(c, "grubx64.efi"), kernels(names...)...)),
+			},
+			KernelCmdlines: []string{goldenRunCmdline},
+		}}
+	}
+	profile1, err := secboot.ComputePCRProfile(params("kernel-good.efi", "kernel-try.efi"))
+	c.Assert(err, IsNil)
+	profile2, err := secboot.ComputePCRProfile(params("kernel-try.efi", "kernel-good.efi"))
+	c.Assert(err, IsNil)
+	c.Check(string(profile1), Equals, string(profile2))
+
+	// but a different chain leads to a different profile
+	profile3, err := secboot.ComputePCRProfile(params("kernel-good.efi"))
+	c.Assert(err, IsNil)
+	c.Check(string(profile1), Not(Equals), string(profile3))
+}
+
+func (s *pcrProfileSuite) TestComputePCRProfileErrors(c *C) {
+	_, err := secboot.ComputePCRProfile(nil)
+	c.Assert(err, ErrorMatches, "at least one set of model-specific parameters is required")
+
+	_, err = secboot.ComputePCRProfile([]*secboot.SealKeyModelParams{{
+		EFILoadChains: []*secboot.LoadChain{
+			secboot.NewLoadChain(bootloader.NewBootFile("", filepath.Join(s.dir, "missing.efi"), bootloader.RoleRecovery)),
+		},
+	}})
+	c.Assert(err, ErrorMatches, "cannot build EFI image load sequences: file .*/missing.efi does not exist")
+}
+
+func (s *pcrProfileSuite) TestEncodePCRProfileValues(c *C) {
+	encoded := secboot.EncodePCRProfileValues([]map[int][]byte{
+		{12: {0xaa}, 4: {0x01, 0x02}, 7: {0xff}},
+		{4: {0x00}, 7: {0x10}},
+		// duplicated branch
+		{7: {0xff}, 4: {0x01, 0x02}, 12: {0xaa}},
+	})
+	c.Check(string(encoded), Equals, "4=00 7=10\n4=0102 7=ff 12=aa\n")
+
+	c.Check(secboot.EncodePCRProfileValues(nil), HasLen, 0)
+}
@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"sort"
+)
+
+var computeBootChainPCRValues = computeBootChainPCRValuesImpl
+
+// PCRSelectionForModelParams returns the sorted list of SHA-256 PCRs that a
+// key sealed with the given model parameters is bound to, as selected by the
+// PCR protection profile built for them.
+func PCRSelectionForModelParams(modelParams []*SealKeyModelParams) ([]int, error) {
+	if len(modelParams) == 0 {
+		return nil, nil
+	}
+	states, err := computeBootChainPCRValues(modelParams)
+	if err != nil {
+		return nil, err
+	}
+	pcrs := make(map[int]bool)
+	for _, state := range states {
+		for pcr := range state {
+			pcrs[pcr] = true
+		}
+	}
+	selection := make([]int, 0, len(pcrs))
+	for pcr := range pcrs {
+		selection = append(selection, pcr)
+	}
+	sort.Ints(selection)
+	return selection, nil
+}
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+)
+
+type pcrsSuite struct{}
+
+var _ = Suite(&pcrsSuite{})
+
+func (s *pcrsSuite) TestPCRSelectionForModelParams(c *C) {
+	pcrs, err := secboot.PCRSelectionForModelParams(nil)
+	c.Assert(err, IsNil)
+	c.Check(pcrs, IsNil)
+
+	modelParams := []*secboot.SealKeyModelParams{
+		{KernelCmdlines: []string{"snapd_recovery_mode=run"}},
+		{KernelCmdlines: []string{"snapd_recovery_mode=recover"}},
+	}
+	restore := secboot.MockComputeBootChainPCRValues(func(mp []*secboot.SealKeyModelParams) ([]map[int][]byte, error) {
+		c.Check(mp, DeepEquals, modelParams)
+		// the PCRs of all the states allowed by the profile
+		return []map[int][]byte{
+			{7: []byte("7"), 4: []byte("4"), 12: []byte("12-run")},
+			{7: []byte("7"), 4: []byte("4"), 12: []byte("12-recover"), 14: []byte("14")},
+		}, nil
+	})
+	defer restore()
+	pcrs, err = secboot.PCRSelectionForModelParams(modelParams)
+	c.Assert(err, IsNil)
+	c.Check(pcrs, DeepEquals, []int{4, 7, 12, 14})
+
+	restore = secboot.MockComputeBootChainPCRValues(func(mp []*secboot.SealKeyModelParams) ([]map[int][]byte, error) {
+		return nil, errors.New("boom")
+	})
+	defer restore()
+	_, err = secboot.PCRSelectionForModelParams(modelParams)
+	c.Assert(err, ErrorMatches, "boom")
+}
@@ -0,0 +1,179 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// KeyProtector protects encryption keys by sealing them to a hardware or
+// software backend, so that they can only be recovered on the device they
+// were sealed on.
+type KeyProtector interface {
+	// Name returns the name under which the protector is registered.
+	Name() string
+	// SealKeys seals the keys according to the parameters, writing the
+	// sealed key files of the requests.
+	SealKeys(keys []SealKeyRequest, params *SealKeysParams) error
+	// ResealKeys updates the policy of the sealed key files according to
+	// the parameters.
+	ResealKeys(params *ResealKeysParams) error
+	// IsSealedKey returns whether the key file was sealed by the
+	// protector.
+	IsSealedKey(keyFile string) bool
+	// UnsealKey recovers the encryption key sealed in the key file.
+	UnsealKey(keyFile string) ([]byte, error)
+}
+
+var (
+	keyProtectorsMu sync.Mutex
+	keyProtectors   = map[string]KeyProtector{}
+)
+
+// RegisterKeyProtector makes the key protector available under its name. It
+// panics if a protector with the same name was registered already.
+func RegisterKeyProtector(p KeyProtector) {
+	keyProtectorsMu.Lock()
+	defer keyProtectorsMu.Unlock()
+
+	name := p.Name()
+	if _, ok := keyProtectors[name]; ok {
+		panic(fmt.Sprintf("key protector %q already registered", name))
+	}
+	keyProtectors[name] = p
+}
+
+// UnregisterKeyProtector removes the key protector with the given name, it is
+// meant to be used by tests.
+func UnregisterKeyProtector(name string) {
+	keyProtectorsMu.Lock()
+	defer keyProtectorsMu.Unlock()
+
+	delete(keyProtectors, name)
+}
+
+// KeyProtectorByName returns the key protector registered under the given
+// name. The empty name refers to the default protector of the build.
+func KeyProtectorByName(name string) (KeyProtector, error) {
+	if name == "" {
+		if defaultKeyProtector == "" {
+			return nil, fmt.Errorf("build without secboot support")
+		}
+		name = defaultKeyProtector
+	}
+
+	keyProtectorsMu.Lock()
+	defer keyProtectorsMu.Unlock()
+
+	p, ok := keyProtectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown key protector %q", name)
+	}
+	return p, nil
+}
+
+// KeyProtectorNames returns the sorted names of the registered key
+// protectors.
+func KeyProtectorNames() []string {
+	keyProtectorsMu.Lock()
+	defer keyProtectorsMu.Unlock()
+
+	names := make([]string, 0, len(keyProtectors))
+	for name := range keyProtectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyProtectorForSealedKey returns the protector other than the default one
+// which sealed the key file, or nil if there is none.
+func keyProtectorForSealedKey(keyFile string) KeyProtector {
+	for _, name := range KeyProtectorNames() {
+		if name == defaultKeyProtector {
+			continue
+		}
+		p, err := KeyProtectorByName(name)
+		if err != nil {
+			// unregistered in the meantime
+			continue
+		}
+		if p.IsSealedKey(keyFile) {
+			return p
+		}
+	}
+	return nil
+}
+
+// SealedKeyProtectorName returns the name of the key protector which sealed
+// the key file, or the empty name if it is the default protector of the
+// build.
+func SealedKeyProtectorName(keyFile string) string {
+	if p := keyProtectorForSealedKey(keyFile); p != nil {
+		return p.Name()
+	}
+	return ""
+}
+
+// SealKeys seals the encryption keys with the key protector selected by the
+// parameters, by default the TPM. If a sealed key already exists, SealKeys will
+// fail and return an error.
+func SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
+	p, err := KeyProtectorByName(params.KeyProtector)
+	if err != nil {
+		return err
+	}
+	return p.SealKeys(keys, params)
+}
+
+// ResealKeys updates the policy of the sealed encryption keys with the key
+// protector selected by the parameters. If none is selected the protector which
+// sealed the key files is used, by default the TPM.
+func ResealKeys(params *ResealKeysParams) error {
+	name := params.KeyProtector
+	if name == "" && len(params.KeyFiles) > 0 {
+		if p := keyProtectorForSealedKey(params.KeyFiles[0]); p != nil {
+			name = p.Name()
+		}
+	}
+	p, err := KeyProtectorByName(name)
+	if err != nil {
+		return err
+	}
+	return p.ResealKeys(params)
+}
+
+// UnsealKey recovers the encryption key sealed in the key file with the key
+// protector which sealed it, by default the TPM. Volumes are normally
+// unlocked without the key leaving secboot, it is meant for the cases where
+// the key itself is needed, like to replace it with a new one.
+func UnsealKey(keyFile string) ([]byte, error) {
+	p := keyProtectorForSealedKey(keyFile)
+	if p == nil {
+		var err error
+		p, err = KeyProtectorByName("")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.UnsealKey(keyFile)
+}
@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/secboot/secboottest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type protectorSuite struct{}
+
+var _ = Suite(&protectorSuite{})
+
+func (s *protectorSuite) TestRegistry(c *C) {
+	_, err := secboot.KeyProtectorByName("plaintext")
+	c.Check(err, ErrorMatches, `unknown key protector "plaintext"`)
+
+	p, restore := secboottest.MockPlaintextKeyProtector()
+	defer restore()
+
+	c.Check(secboot.KeyProtectorNames(), testutil.Contains, "plaintext")
+	found, err := secboot.KeyProtectorByName("plaintext")
+	c.Assert(err, IsNil)
+	c.Check(found, Equals, p)
+
+	c.Check(func() { secboot.RegisterKeyProtector(p) }, PanicMatches, `key protector "plaintext" already registered`)
+
+	restore()
+	c.Check(secboot.KeyProtectorNames(), Not(testutil.Contains), "plaintext")
+}
+
+func (s *protectorSuite) TestSealAndResealWithProtector(c *C) {
+	p, restore := secboottest.MockPlaintextKeyProtector()
+	defer restore()
+
+	d := c.MkDir()
+	keyFile := filepath.Join(d, "data.sealed-key")
+	var key secboot.EncryptionKey
+	copy(key[:], "key")
+
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{Key: key, KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: "plaintext",
+	})
+	c.Assert(err, IsNil)
+	c.Check(p.SealCalls, Equals, 1)
+	c.Check(p.IsSealedKey(keyFile), Equals, true)
+	unsealed, err := p.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+
+	params := &secboot.ResealKeysParams{
+		KeyProtector: "plaintext",
+		KeyFiles:     []string{keyFile},
+	}
+	err = secboot.ResealKeys(params)
+	c.Assert(err, IsNil)
+	c.Check(p.ResealCalls, Equals, 1)
+	c.Check(p.ResealParams, Equals, params)
+}
+
+func (s *protectorSuite) TestUnknownProtector(c *C) {
+	err := secboot.SealKeys(nil, &secboot.SealKeysParams{KeyProtector: "unknown"})
+	c.Check(err, ErrorMatches, `unknown key protector "unknown"`)
+	err = secboot.ResealKeys(&secboot.ResealKeysParams{KeyProtector: "unknown"})
+	c.Check(err, ErrorMatches, `unknown key protector "unknown"`)
+}
+
+func (s *protectorSuite) TestUnsealKey(c *C) {
+	p, restore := secboottest.MockPlaintextKeyProtector()
+	defer restore()
+
+	keyFile := filepath.Join(c.MkDir(), "save.recovery.sealed-key")
+	var key secboot.EncryptionKey
+	copy(key[:], "save-key")
+	err := secboot.SealKeys([]secboot.SealKeyRequest{{Key: key, KeyFile: keyFile}}, &secboot.SealKeysParams{
+		KeyProtector: "plaintext",
+	})
+	c.Assert(err, IsNil)
+
+	// the protector which sealed the key is found from the key file
+	unsealed, err := secboot.UnsealKey(keyFile)
+	c.Assert(err, IsNil)
+	c.Check(unsealed, DeepEquals, key[:])
+	c.Check(p.UnsealCalls, Equals, 1)
+}
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+)
+
+// Sealed keys are protected against a rollback to older, vulnerable boot
+// assets with the PCR policy counter secboot creates for them. Each PCR
+// policy of the keys carries a TPM2_PolicyNV assertion against the counter,
+// which the TPM checks when unsealing, and resealing advances the counter so
+// that the TPM rejects all the policies created before.
+
+var tpmReadRollbackCounter = readRollbackCounterImpl
+
+func readRollbackCounterImpl(tpm *sb.TPMConnection, handle uint32) (uint64, error) {
+	index, err := tpm.CreateResourceContextFromTPM(tpm2.Handle(handle))
+	if err != nil {
+		return 0, err
+	}
+	return tpm.NVReadCounter(index, index, nil)
+}
+
+// ReadRollbackCounter returns the current value of the PCR policy counter at
+// the given handle, eg. RunObjectPCRPolicyCounterHandle. PCR policies of the
+// sealed keys created before the counter reached that value are rejected by
+// the TPM, see ResealKeysParams.RollbackCounterMinValue.
+func ReadRollbackCounter(handle uint32) (uint64, error) {
+	tpm, err := sbConnectToDefaultTPM()
+	if err != nil {
+		return 0, fmt.Errorf("cannot connect to TPM: %v", err)
+	}
+	defer tpm.Close()
+	if !isTPMEnabled(tpm) {
+		return 0, fmt.Errorf("TPM device is not enabled")
+	}
+
+	value, err := tpmReadRollbackCounter(tpm, handle)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read rollback counter at handle %#x: %v", handle, err)
+	}
+	return value, nil
+}
+
+// maxRollbackCounterAdvance is the largest number of reseals done to advance
+// the PCR policy counter, every reseal writes to the TPM NV memory which
+// wears out.
+const maxRollbackCounterAdvance = 16
+
+// advanceRollbackCounter reseals the keys until the PCR policy counter of the
+// given key file reaches minValue. Every reseal increments the counter, so
+// the TPM rejects the PCR policies created before it reached minValue. It
+// refuses to reseal more than maxRollbackCounterAdvance times.
+func advanceRollbackCounter(tpm *sb.TPMConnection, keyFile string, minValue uint64, reseal func() error) error {
+	handle, err := sealedKeyPCRPolicyCounterHandle(keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read the PCR policy counter handle of %q: %v", keyFile, err)
+	}
+	if handle == uint32(tpm2.HandleNull) {
+		return fmt.Errorf("cannot enforce rollback counter minimum value: sealed key has no PCR policy counter")
+	}
+	value, err := tpmReadRollbackCounter(tpm, handle)
+	if err != nil {
+		return fmt.Errorf("cannot read rollback counter at handle %#x: %v", handle, err)
+	}
+	if value < minValue && minValue-value > maxRollbackCounterAdvance {
+		return fmt.Errorf("cannot advance rollback counter at handle %#x from %d to %d: more than %d reseals needed", handle, value, minValue, maxRollbackCounterAdvance)
+	}
+	for value < minValue {
+		if err := reseal(); err != nil {
+			return err
+		}
+		advanced, err := tpmReadRollbackCounter(tpm, handle)
+		if err != nil {
+			return fmt.Errorf("cannot read rollback counter at handle %#x: %v", handle, err)
+		}
+		if advanced <= value {
+			return fmt.Errorf("cannot advance rollback counter at handle %#x: value %d did not change after resealing", handle, value)
+		}
+		value = advanced
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/secboot"
+)
+
+func (s *secbootSuite) TestReadRollbackCounter(c *C) {
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockTPMReadRollbackCounter(func(tpm *sb.TPMConnection, handle uint32) (uint64, error) {
+		c.Check(handle, Equals, uint32(secboot.RunObjectPCRPolicyCounterHandle))
+		return 7, nil
+	})
+	defer restore()
+
+	v, err := secboot.ReadRollbackCounter(secboot.RunObjectPCRPolicyCounterHandle)
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, uint64(7))
+}
+
+func (s *secbootSuite) TestReadRollbackCounterErrors(c *C) {
+	_, restore := mockSbTPMConnection(c, errors.New("no tpm"))
+	defer restore()
+
+	_, err := secboot.ReadRollbackCounter(secboot.RunObjectPCRPolicyCounterHandle)
+	c.Check(err, ErrorMatches, "cannot connect to TPM: no tpm")
+
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return false })
+	defer restore()
+	_, err = secboot.ReadRollbackCounter(secboot.RunObjectPCRPolicyCounterHandle)
+	c.Check(err, ErrorMatches, "TPM device is not enabled")
+
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockTPMReadRollbackCounter(func(tpm *sb.TPMConnection, handle uint32) (uint64, error) {
+		return 0, errors.New("NV index not found")
+	})
+	defer restore()
+	_, err = secboot.ReadRollbackCounter(secboot.RunObjectPCRPolicyCounterHandle)
+	c.Check(err, ErrorMatches, "cannot read rollback counter at handle 0x1880001: NV index not found")
+}
+
+func (s *secbootSuite) mockResealWithRollbackCounter(c *C, value *uint64, advance uint64) (resealCalls *int, authKeyFile string) {
+	authKeyFile = filepath.Join(c.MkDir(), "policy-auth-key-file")
+	c.Assert(ioutil.WriteFile(authKeyFile, []byte{1, 3, 3, 7}, 0600), IsNil)
+
+	_, restore := mockSbTPMConnection(c, nil)
+	s.AddCleanup(restore)
+	s.AddCleanup(secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true }))
+	s.AddCleanup(secboot.MockSbAddEFISecureBootPolicyProfile(func(*sb.PCRProtectionProfile, *sb.EFISecureBootPolicyProfileParams) error {
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbAddEFIBootManagerProfile(func(*sb.PCRProtectionProfile, *sb.EFIBootManagerProfileParams) error {
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbAddSnapModelProfile(func(*sb.PCRProtectionProfile, *sb.SnapModelProfileParams) error {
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSealedKeyPCRPolicyCounterHandle(func(keyFile string) (uint32, error) {
+		c.Check(keyFile, Equals, "keyfile")
+		return secboot.RunObjectPCRPolicyCounterHandle, nil
+	}))
+	s.AddCleanup(secboot.MockTPMReadRollbackCounter(func(tpm *sb.TPMConnection, handle uint32) (uint64, error) {
+		c.Check(handle, Equals, uint32(secboot.RunObjectPCRPolicyCounterHandle))
+		return *value, nil
+	}))
+	resealCalls = new(int)
+	s.AddCleanup(secboot.MockSbUpdateKeyPCRProtectionPolicyMultiple(func(t *sb.TPMConnection, keyPaths []string, authKey sb.TPMPolicyAuthKey, profile *sb.PCRProtectionProfile) error {
+		*resealCalls++
+		// updating the policy increments the PCR policy counter
+		*value += advance
+		return nil
+	}))
+	return resealCalls, authKeyFile
+}
+
+func (s *secbootSuite) TestResealKeyRollbackCounterMinValue(c *C) {
+	value := uint64(3)
+	resealCalls, authKeyFile := s.mockResealWithRollbackCounter(c, &value, 1)
+
+	params := &secboot.ResealKeysParams{
+		ModelParams:             []*secboot.SealKeyModelParams{{Model: &asserts.Model{}}},
+		KeyFiles:                []string{"keyfile"},
+		TPMPolicyAuthKeyFile:    authKeyFile,
+		RollbackCounterMinValue: 4,
+	}
+	// resealing once is enough to reach the minimum
+	err := secboot.ResealKeys(params)
+	c.Assert(err, IsNil)
+	c.Check(*resealCalls, Equals, 1)
+	c.Check(value, Equals, uint64(4))
+
+	// the keys are resealed again until the policies created before the
+	// counter reached the minimum are revoked
+	params.RollbackCounterMinValue = 7
+	err = secboot.ResealKeys(params)
+	c.Assert(err, IsNil)
+	c.Check(*resealCalls, Equals, 4)
+	c.Check(value, Equals, uint64(7))
+}
+
+func (s *secbootSuite) TestResealKeyRollbackCounterNotAdvancing(c *C) {
+	value := uint64(3)
+	resealCalls, authKeyFile := s.mockResealWithRollbackCounter(c, &value, 0)
+
+	err := secboot.ResealKeys(&secboot.ResealKeysParams{
+		ModelParams:             []*secboot.SealKeyModelParams{{Model: &asserts.Model{}}},
+		KeyFiles:                []string{"keyfile"},
+		TPMPolicyAuthKeyFile:    authKeyFile,
+		RollbackCounterMinValue: 5,
+	})
+	c.Assert(err, ErrorMatches, "cannot advance rollback counter at handle 0x1880001: value 3 did not change after resealing")
+	c.Check(*resealCalls, Equals, 2)
+}
+
+func (s *secbootSuite) TestResealKeyRollbackCounterTooFarBehind(c *C) {
+	value := uint64(3)
+	resealCalls, authKeyFile := s.mockResealWithRollbackCounter(c, &value, 1)
+
+	params := &secboot.ResealKeysParams{
+		ModelParams:             []*secboot.SealKeyModelParams{{Model: &asserts.Model{}}},
+		KeyFiles:                []string{"keyfile"},
+		TPMPolicyAuthKeyFile:    authKeyFile,
+		RollbackCounterMinValue: 21,
+	}
+	// the keys are resealed as usual, but not again and again to advance
+	// the counter
+	err := secboot.ResealKeys(params)
+	c.Assert(err, ErrorMatches, "cannot advance rollback counter at handle 0x1880001 from 4 to 21: more than 16 reseals needed")
+	c.Check(*resealCalls, Equals, 1)
+	c.Check(value, Equals, uint64(4))
+
+	// right at the bound
+	params.RollbackCounterMinValue = 20
+	err = secboot.ResealKeys(params)
+	c.Assert(err, IsNil)
+	c.Check(*resealCalls, Equals, 17)
+	c.Check(value, Equals, uint64(20))
+}
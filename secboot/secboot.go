@@ -26,6 +26,8 @@ package secboot
 
 import (
 	"crypto/ecdsa"
+	"fmt"
+	"strings"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/bootloader"
@@ -37,11 +39,19 @@ const (
 	FallbackObjectPCRPolicyCounterHandle = 0x01880002
 )
 
+// TPM2KeyProtectorName is the name of the key protector sealing keys to the
+// TPM, it is the default key protector.
+const TPM2KeyProtectorName = "tpm2"
+
 type LoadChain struct {
 	*bootloader.BootFile
 	// Next is a list of alternative chains that can be loaded
 	// following the boot file.
 	Next []*LoadChain
+	// UnifiedKernelImage is set when the boot file is a systemd-stub
+	// unified kernel image, bundling the kernel, initrd and kernel
+	// command line in a single EFI binary. Such a chain has no Next.
+	UnifiedKernelImage bool
 }
 
 // NewLoadChain returns a LoadChain corresponding to loading the given
@@ -53,6 +63,15 @@ func NewLoadChain(bf bootloader.BootFile, next ...*LoadChain) *LoadChain {
 	}
 }
 
+// NewUnifiedKernelImageLoadChain returns a LoadChain corresponding to loading
+// the given BootFile, which is a systemd-stub unified kernel image.
+func NewUnifiedKernelImageLoadChain(bf bootloader.BootFile) *LoadChain {
+	return &LoadChain{
+		BootFile:           &bf,
+		UnifiedKernelImage: true,
+	}
+}
+
 type SealKeyRequest struct {
 	// The key to seal
 	Key EncryptionKey
@@ -66,11 +85,47 @@ type SealKeyModelParams struct {
 	// The set of EFI binary load chains for the current device
 	// configuration
 	EFILoadChains []*LoadChain
-	// The kernel command line
+	// The kernel command line, not relevant for unified kernel images which
+	// carry the command line themselves
 	KernelCmdlines []string
+	// Directories holding pending EFI signature database (db, dbx, KEK)
+	// updates which have been staged but not yet applied, the secure boot
+	// policy profile also covers the state after applying them
+	EFISignatureDbUpdateKeystores []string
+	// Whether the images in EFILoadChains which are loaded by shim may be
+	// signed by keys enrolled in the machine owner key list (MokListRT),
+	// the profile is then also bound to the machine owner key state
+	EFIMachineOwnerKeys bool
+	// The root hashes of the dm-verity hash trees of the seed contents,
+	// keyed by their path relative to the seed, measured by
+	// MeasureSeedVerityWhenPossible after the model. The
+	// profile is not bound to the seed contents when empty
+	SeedVerityRootHashes map[string][]byte
+	// Whether ubuntu-data is integrity protected, the profile is then
+	// bound to the measurement of the requirement by
+	// MeasureDataIntegrityWhenPossible, so that it cannot be dropped
+	// offline
+	DataIntegrity bool
 }
 
+// SRKTemplate is the template of the storage root key the encryption keys
+// are sealed under.
+type SRKTemplate string
+
+const (
+	// SRKTemplateRSA2048 is the RSA-2048 template from the TCG TPM v2.0
+	// Provisioning Guidance.
+	SRKTemplateRSA2048 SRKTemplate = "rsa-2048"
+	// SRKTemplateECCP256 is the ECC NIST P-256 template from the TCG TPM
+	// v2.0 Provisioning Guidance, creating it and sealing under it is
+	// considerably faster on slow TPMs.
+	SRKTemplateECCP256 SRKTemplate = "ecc-p256"
+)
+
 type SealKeysParams struct {
+	// The name of the key protector sealing the keys, the default
+	// protector of the build (the TPM) is used when empty
+	KeyProtector string
 	// The parameters we're sealing the key to
 	ModelParams []*SealKeyModelParams
 	// The authorization policy update key file (only relevant for TPM)
@@ -78,22 +133,73 @@ type SealKeysParams struct {
 	// The path to the authorization policy update key file (only relevant for TPM,
 	// if empty the key will not be saved)
 	TPMPolicyAuthKeyFile string
+	// Whether to wrap the policy auth key with a key bound to the TPM
+	// before saving it to TPMPolicyAuthKeyFile, so that the saved key
+	// cannot be used off the device (only relevant for TPM)
+	TPMWrapPolicyAuthKey bool
 	// The path to the lockout authorization file (only relevant for TPM and only
 	// used if TPMProvision is set to true)
 	TPMLockoutAuthFile string
 	// Whether we should provision the TPM
 	TPMProvision bool
+	// Whether to clear the TPM before provisioning it again, using the
+	// lockout authorization of the previous provisioning read from
+	// TPMLockoutAuthFile (only relevant for TPM and only used if
+	// TPMProvision is set to true)
+	TPMClear bool
+	// The template of the storage root key created when provisioning the
+	// TPM, the RSA-2048 template is used when empty (only relevant for TPM
+	// and only used if TPMProvision is set to true)
+	TPMSRKTemplate SRKTemplate
+	// The persistent handle of a storage root key provisioned by the
+	// device vendor to reuse instead of creating one from TPMSRKTemplate
+	// (only relevant for TPM and only used if TPMProvision is set to true)
+	TPMSRKHandle uint32
+	// The path to the TPM endorsement key certificate used to verify the
+	// TPM before sealing (only relevant for TPM). If empty the TPM is not
+	// verified. The file may only be empty for virtual TPMs, which are
+	// commonly set up without a certificate.
+	TPMEKCertFile string
+	// Whether to skip verification of the TPM endorsement key even if
+	// TPMEKCertFile is set, eg. when sealing against a swtpm or a cloud
+	// vTPM whose certificate cannot be verified (only relevant for TPM)
+	TPMSkipEKVerification bool
 	// The handle at which to create a NV index for dynamic authorization policy revocation support
 	PCRPolicyCounterHandle uint32
 }
 
 type ResealKeysParams struct {
+	// The name of the key protector which sealed the keys, the default
+	// protector of the build (the TPM) is used when empty
+	KeyProtector string
 	// The snap model parameters
 	ModelParams []*SealKeyModelParams
 	// The path to the sealed key files
 	KeyFiles []string
+	// The keys sealed in the key files, in the same order, for the key
+	// protectors which seal the keys again and cannot unseal the key files
+	// in the current state of the boot chains (not relevant for TPM)
+	Keys []EncryptionKey
 	// The path to the authorization policy update key file (only relevant for TPM)
 	TPMPolicyAuthKeyFile string
+	// The minimum value the PCR policy counter of the keys must reach
+	// after resealing, the keys are resealed again until it does. The
+	// TPM rejects the PCR policies created before the counter reached
+	// it, protecting against a rollback to older boot assets (only
+	// relevant for TPM)
+	RollbackCounterMinValue uint64
+}
+
+// CryptoEraseParams contains the parameters for CryptoEraseVolumes.
+type CryptoEraseParams struct {
+	// The block devices of the encrypted volumes to erase, usually those
+	// of ubuntu-data and ubuntu-save
+	Devices []string
+	// The sealed key files of the volumes, which are removed and whose PCR
+	// policy counters are undefined (only relevant for TPM)
+	KeyFiles []string
+	// Additional TPM NV indices to undefine (only relevant for TPM)
+	NVIndexHandles []uint32
 }
 
 // UnlockVolumeUsingSealedKeyOptions contains options for unlocking encrypted
@@ -105,6 +211,37 @@ type UnlockVolumeUsingSealedKeyOptions struct {
 	// AllowRecoveryKey when true indicates activation with the recovery key
 	// will be attempted if activation with the sealed key failed.
 	AllowRecoveryKey bool
+	// KeyringPrefix is the prefix of the description of the keys added
+	// to the kernel keyring for unlocked volumes, "ubuntu-fde" is used
+	// when empty.
+	KeyringPrefix string
+	// PassphraseTries is the number of times the user is asked for the
+	// passphrase of the sealed key, once when 0.
+	PassphraseTries int
+	// RecoveryKeyTries is the number of times the user is asked for the
+	// recovery key when AllowRecoveryKey is set, 3 times when 0.
+	RecoveryKeyTries int
+	// Location locates the partition of the volume when the gadget does
+	// not use the standard filesystem labels.
+	Location VolumeLocation
+	// RequireIntegrity when true indicates that an encrypted volume is
+	// only unlocked if it is integrity protected with dm-integrity, so
+	// that offline modifications of its content are detected.
+	RequireIntegrity bool
+}
+
+// VolumeLocation locates the partition of a volume on a disk by other means
+// than the filesystem label derived from the volume name. At most one of the
+// fields may be set, when none is the filesystem label is used. The volume
+// is then considered encrypted if the partition holds a LUKS container.
+type VolumeLocation struct {
+	// PartitionUUID is the partition UUID of the volume.
+	PartitionUUID string
+	// PartitionLabel is the GPT partition label of the volume.
+	PartitionLabel string
+	// PartitionType is the partition type of the volume, like its GPT
+	// partition type GUID, it must be unique on the disk.
+	PartitionType string
 }
 
 // UnlockMethod is the method that was used to unlock a volume.
@@ -122,6 +259,13 @@ const (
 	UnlockedWithRecoveryKey
 	// UnlockStatusUnknown indicates that the unlock status of the device is not clear.
 	UnlockStatusUnknown
+	// UnlockedWithKeyProtector indicates that the device was unlocked with
+	// a key unsealed by a key protector other than the TPM, like OP-TEE,
+	// see KeyProtector.
+	UnlockedWithKeyProtector
+	// UnlockedWithKey indicates that the device was unlocked with a plain
+	// key, like the key of ubuntu-save stored on ubuntu-data.
+	UnlockedWithKey
 )
 
 // UnlockResult is the result of trying to unlock a volume.
@@ -136,6 +280,68 @@ type UnlockResult struct {
 	// - NotUnlocked
 	// - UnlockedWithRecoveryKey
 	// - UnlockedWithSealedKey
-	// - UnlockedWithUnsealedKey
+	// - UnlockedWithKeyProtector
 	UnlockMethod UnlockMethod
+	// KeyProtector is the name of the key protector that unsealed the key
+	// of the device when it was unlocked with UnlockedWithKeyProtector.
+	KeyProtector string
+	// PartUUID is the partition UUID of the partition holding the volume,
+	// encrypted or not.
+	PartUUID string
+	// PartDevice is the device node of the partition holding the volume,
+	// for an encrypted volume this is the device that was unlocked.
+	PartDevice string
+	// MapperName is the device mapper name of the decrypted device, it is
+	// only set if the device was unlocked.
+	MapperName string
+	// LUKSUUID is the UUID of the LUKS container of an unlocked encrypted
+	// device.
+	LUKSUUID string
+}
+
+// EventLogError is returned by SealKeys when the TCG event log of the
+// firmware cannot be used to predict the PCR values, either because it is
+// truncated or because it does not match the values of the TPM. Keys sealed
+// with a profile computed on such a system would never unseal.
+type EventLogError struct {
+	// PCR is the PCR for which an inconsistency was found, or -1 if
+	// the problem is not specific to a single PCR.
+	PCR int
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *EventLogError) Error() string {
+	if e.PCR < 0 {
+		return fmt.Sprintf("invalid TCG event log: %s", e.Msg)
+	}
+	return fmt.Sprintf("invalid TCG event log for PCR %d: %s", e.PCR, e.Msg)
+}
+
+// SealedKeyFileError describes a problem found with a sealed key file by
+// CheckSealedKeyFiles.
+type SealedKeyFileError struct {
+	KeyFile string
+	Err     error
+}
+
+func (e *SealedKeyFileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.KeyFile, e.Err)
+}
+
+// SealedKeyFilesError is returned by CheckSealedKeyFiles when any of the
+// checked sealed key files is corrupted or of an unsupported version.
+type SealedKeyFilesError struct {
+	Problems []*SealedKeyFileError
+}
+
+func (e *SealedKeyFilesError) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("invalid sealed key file %v", e.Problems[0])
+	}
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = fmt.Sprintf("- %v", p)
+	}
+	return fmt.Sprintf("invalid sealed key files:\n%s", strings.Join(msgs, "\n"))
 }
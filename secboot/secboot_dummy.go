@@ -28,10 +28,49 @@ func CheckKeySealingSupported() error {
 	return fmt.Errorf("build without secboot support")
 }
 
-func SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
+// there is no default key protector without secboot support
+const defaultKeyProtector = ""
+
+func ComputePCRProfile(modelParams []*SealKeyModelParams) ([]byte, error) {
+	return nil, fmt.Errorf("build without secboot support")
+}
+
+func computeBootChainPCRValuesImpl(modelParams []*SealKeyModelParams) ([]map[int][]byte, error) {
+	return nil, fmt.Errorf("build without secboot support")
+}
+
+func CheckSealedKeyFiles(keyFiles []string, tpmPolicyAuthKeyFile string) error {
+	return fmt.Errorf("build without secboot support")
+}
+
+func TPMCapabilities() (*TPMInfo, error) {
+	return nil, fmt.Errorf("build without secboot support")
+}
+
+func ReadRollbackCounter(handle uint32) (uint64, error) {
+	return 0, fmt.Errorf("build without secboot support")
+}
+
+func MeasureSeedVerityWhenPossible(findRootHashes func() (map[string][]byte, error)) error {
+	return fmt.Errorf("build without secboot support")
+}
+
+func MeasureDataIntegrityWhenPossible() error {
+	return fmt.Errorf("build without secboot support")
+}
+
+func SealedKeyInfo(keyFile string) (*SealedKeyDetails, error) {
+	return nil, fmt.Errorf("build without secboot support")
+}
+
+func CryptoEraseVolumes(params *CryptoEraseParams) error {
+	return fmt.Errorf("build without secboot support")
+}
+
+func AddEncryptionKey(node string, key []byte, newKey EncryptionKey) error {
 	return fmt.Errorf("build without secboot support")
 }
 
-func ResealKeys(params *ResealKeysParams) error {
+func RemoveEncryptionKey(node string, key []byte) error {
 	return fmt.Errorf("build without secboot support")
 }
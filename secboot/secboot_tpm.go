@@ -21,20 +21,28 @@
 package secboot
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/canonical/go-tpm2"
+	"github.com/canonical/tcglog-parser"
 	sb "github.com/snapcore/secboot"
 	"golang.org/x/xerrors"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/bootloader/efi"
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/osutil/disks"
@@ -44,10 +52,14 @@ import (
 
 const (
 	keyringPrefix = "ubuntu-fde"
+
+	defaultPassphraseTries  = 1
+	defaultRecoveryKeyTries = 3
 )
 
 var (
 	sbConnectToDefaultTPM                  = sb.ConnectToDefaultTPM
+	sbSecureConnectToDefaultTPM            = sb.SecureConnectToDefaultTPM
 	sbMeasureSnapSystemEpochToTPM          = sb.MeasureSnapSystemEpochToTPM
 	sbMeasureSnapModelToTPM                = sb.MeasureSnapModelToTPM
 	sbBlockPCRProtectionPolicies           = sb.BlockPCRProtectionPolicies
@@ -60,13 +72,34 @@ var (
 	sbAddSnapModelProfile                  = sb.AddSnapModelProfile
 	sbSealKeyToTPMMultiple                 = sb.SealKeyToTPMMultiple
 	sbUpdateKeyPCRProtectionPolicyMultiple = sb.UpdateKeyPCRProtectionPolicyMultiple
+	sbReadSealedKeyObject                  = sb.ReadSealedKeyObject
+	sbUnsealFromTPM                        = sbUnsealFromTPMImpl
 
 	randutilRandomKernelUUID = randutil.RandomKernelUUID
 
-	isTPMEnabled = isTPMEnabledImpl
-	provisionTPM = provisionTPMImpl
+	isTPMEnabled   = isTPMEnabledImpl
+	provisionTPM   = provisionTPMImpl
+	setLockoutAuth = setLockoutAuthImpl
+	provisionSRK   = provisionSRKImpl
+
+	replayEventLog          = replayEventLogImpl
+	readMokAuthorityDigests = readMokAuthorityDigestsImpl
+	readPCRValues           = readPCRValuesImpl
+	computeProfilePCRValues = computeProfilePCRValuesImpl
+
+	computeUnifiedKernelImagePCRValue = computeUnifiedKernelImagePCRValueImpl
+
+	sealedKeyPCRPolicyCounterHandle = sealedKeyPCRPolicyCounterHandleImpl
+
+	readTPMInfo      = readTPMInfoImpl
+	isTPMNVRateError = isTPMNVRateErrorImpl
 )
 
+func sbUnsealFromTPMImpl(k *sb.SealedKeyObject, tpm *sb.TPMConnection) ([]byte, error) {
+	key, _, err := k.UnsealFromTPM(tpm, "")
+	return key, err
+}
+
 func isTPMEnabledImpl(tpm *sb.TPMConnection) bool {
 	return tpm.IsEnabled()
 }
@@ -94,10 +127,94 @@ func CheckKeySealingSupported() error {
 	}
 
 	logger.Noticef("TPM device detected and enabled")
+	identifyTPM(tpm)
 
 	return nil
 }
 
+// TPMCapabilities returns information about the TPM of the device, including
+// the detected firmware TPM implementation and its known quirks.
+func TPMCapabilities() (*TPMInfo, error) {
+	tpm, err := sbConnectToDefaultTPM()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to TPM device: %v", err)
+	}
+	defer tpm.Close()
+
+	return readTPMInfo(tpm)
+}
+
+func readTPMInfoImpl(tpm *sb.TPMConnection) (*TPMInfo, error) {
+	props, err := tpm.GetCapabilityTPMProperties(tpm2.PropertyManufacturer, 1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read TPM manufacturer: %v", err)
+	}
+	if len(props) == 0 || props[0].Property != tpm2.PropertyManufacturer {
+		return nil, fmt.Errorf("cannot read TPM manufacturer: property not reported")
+	}
+	selections, err := tpm.GetCapabilityPCRs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read TPM PCR banks: %v", err)
+	}
+	var banks []string
+	for _, selection := range selections {
+		if len(selection.Select) == 0 {
+			// bank is not allocated
+			continue
+		}
+		banks = append(banks, pcrBankName(selection.Hash))
+	}
+	return newTPMInfo(props[0].Value, banks), nil
+}
+
+func pcrBankName(alg tpm2.HashAlgorithmId) string {
+	switch alg {
+	case tpm2.HashAlgorithmSHA1:
+		return "sha1"
+	case tpm2.HashAlgorithmSHA256:
+		return "sha256"
+	case tpm2.HashAlgorithmSHA384:
+		return "sha384"
+	case tpm2.HashAlgorithmSHA512:
+		return "sha512"
+	default:
+		return fmt.Sprintf("0x%04x", uint16(alg))
+	}
+}
+
+func isTPMNVRateErrorImpl(err error) bool {
+	return tpm2.IsTPMWarning(err, tpm2.WarningNVRate, tpm2.AnyCommandCode) ||
+		tpm2.IsTPMWarning(err, tpm2.WarningNVUnavailable, tpm2.AnyCommandCode)
+}
+
+// identifyTPM returns information about the TPM, if the implementation
+// cannot be identified no quirks are assumed.
+func identifyTPM(tpm *sb.TPMConnection) *TPMInfo {
+	info, err := readTPMInfo(tpm)
+	if err != nil {
+		logger.Noticef("cannot identify TPM implementation: %v", err)
+		return &TPMInfo{}
+	}
+	if info.Firmware {
+		logger.Noticef("detected %s firmware TPM (quirks: %+v)", info.Vendor, info.Quirks)
+	}
+	return info
+}
+
+// checkPCRBanks verifies that the PCR bank used for sealing is allocated.
+func checkPCRBanks(info *TPMInfo) error {
+	if len(info.PCRBanks) == 0 {
+		// unknown
+		return nil
+	}
+	for _, bank := range info.PCRBanks {
+		if bank == "sha256" {
+			return nil
+		}
+	}
+	return fmt.Errorf("TPM does not have an allocated SHA-256 PCR bank (allocated: %s)", strings.Join(info.PCRBanks, ", "))
+}
+
 func checkSecureBootEnabled() error {
 	// 8be4df61-93ca-11d2-aa0d-00e098032b8c is the EFI Global Variable vendor GUID
 	b, _, err := efi.ReadVarBytes("SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c")
@@ -117,18 +234,36 @@ func checkSecureBootEnabled() error {
 	return nil
 }
 
+// secureBootPolicyPCR is the TPM PCR that the firmware measures the secure
+// boot policy and the authorities used for verifying the EFI images into.
+const secureBootPolicyPCR = 7
+
 // initramfsPCR is the TPM PCR that we reserve for the EFI image and use
 // for measurement from the initramfs.
 const initramfsPCR = 12
 
 func secureConnectToTPM(ekcfile string) (*sb.TPMConnection, error) {
-	ekCertReader, err := os.Open(ekcfile)
+	ekCert, err := ioutil.ReadFile(ekcfile)
 	if err != nil {
-		return nil, fmt.Errorf("cannot open endorsement key certificate file: %v", err)
+		return nil, fmt.Errorf("cannot read endorsement key certificate file: %v", err)
+	}
+	if len(ekCert) == 0 {
+		// virtual TPMs (swtpm, cloud vTPMs) are often set up without
+		// an EK certificate, there is nothing to verify against, but
+		// other TPMs must be verified
+		tpm, err := insecureConnectToTPM()
+		if err != nil {
+			return nil, err
+		}
+		if !identifyTPM(tpm).Virtual {
+			tpm.Close()
+			return nil, fmt.Errorf("cannot verify TPM: endorsement key certificate file %s is empty", ekcfile)
+		}
+		logger.Noticef("endorsement key certificate file %s is empty, not verifying the virtual TPM", ekcfile)
+		return tpm, nil
 	}
-	defer ekCertReader.Close()
 
-	return sb.SecureConnectToDefaultTPM(ekCertReader, nil)
+	return sbSecureConnectToDefaultTPM(bytes.NewReader(ekCert), nil)
 }
 
 func insecureConnectToTPM() (*sb.TPMConnection, error) {
@@ -219,6 +354,21 @@ func LockTPMSealedKeys() error {
 	return sbBlockPCRProtectionPolicies(tpm, []int{initramfsPCR})
 }
 
+// LockTPMSealedKeysIfArmed locks access to the sealed keys if it was armed
+// with ArmTPMSealedKeysLock, disarming it afterwards.
+func LockTPMSealedKeysIfArmed() error {
+	if !TPMSealedKeysLockArmed() {
+		return nil
+	}
+	if err := LockTPMSealedKeys(); err != nil {
+		return err
+	}
+	if err := os.Remove(tpmSealedKeysLockArmedFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // UnlockVolumeUsingSealedKeyIfEncrypted verifies whether an encrypted volume
 // with the specified name exists and unlocks it using a sealed key in a file
 // with a corresponding name. The options control whether the access to to the
@@ -233,17 +383,105 @@ func LockTPMSealedKeys() error {
 func UnlockVolumeUsingSealedKeyIfEncrypted(
 	disk disks.Disk, name string, sealedEncryptionKeyFile string, opts *UnlockVolumeUsingSealedKeyOptions,
 ) (UnlockResult, error) {
+	if opts == nil {
+		opts = &UnlockVolumeUsingSealedKeyOptions{}
+	}
+
+	res, err := findVolumeToUnlock(disk, name, opts.Location)
+	if err != nil {
+		return res, err
+	}
+
+	tpm, tpmDeviceAvailable, err := connectToTPMForUnlock()
+	if err != nil {
+		return res, fmt.Errorf("cannot unlock encrypted device %q: %v", name, err)
+	}
+	if tpm != nil {
+		defer tpm.Close()
+	}
+
+	var lockErr error
+	var mapperName string
+	err = func() error {
+		defer func() {
+			if opts.LockKeysOnFinish && tpmDeviceAvailable {
+				// Lock access to the sealed keys. This should be called whenever there
+				// is a TPM device detected, regardless of whether secure boot is enabled
+				// or there is an encrypted volume to unlock. Note that snap-bootstrap can
+				// be called several times during initialization, and if there are multiple
+				// volumes to unlock we should lock access to the sealed keys only after
+				// the last encrypted volume is unlocked, in which case lockKeysOnFinish
+				// should be set to true.
+				//
+				// We should only touch the PCR that we've currently reserved for the kernel
+				// EFI image. Touching others will break the ability to perform any kind of
+				// attestation using the TPM because it will make the log inconsistent.
+				lockErr = sbBlockPCRProtectionPolicies(tpm, []int{initramfsPCR})
+			}
+		}()
+
+		var err error
+		mapperName, err = unlockFoundVolume(tpm, tpmDeviceAvailable, &res, name, sealedEncryptionKeyFile, opts)
+		return err
+	}()
+	if err != nil {
+		return res, err
+	}
+	if lockErr != nil {
+		return res, fmt.Errorf("cannot lock access to sealed keys: %v", lockErr)
+	}
+
+	if res.IsDecryptedDevice {
+		// return the encrypted device if the device we are maybe unlocking is
+		// an encrypted device
+		res.Device = filepath.Join("/dev/mapper", mapperName)
+	}
+
+	return res, nil
+}
+
+// UnlockVolumeUsingRecoveryKeyIfEncrypted verifies whether an encrypted volume
+// with the specified name exists on the disk and unlocks it with the recovery
+// key, prompting the user for it. The sealed keys are not used, as this is
+// meant for repairing an installed system after booting from external media,
+// when the boot chain does not match the one the keys were sealed to. The
+// volume is located like with UnlockVolumeUsingSealedKeyIfEncrypted.
+func UnlockVolumeUsingRecoveryKeyIfEncrypted(disk disks.Disk, name string, loc VolumeLocation) (UnlockResult, error) {
+	res, err := findVolumeToUnlock(disk, name, loc)
+	if err != nil {
+		return res, err
+	}
+	if !res.IsDecryptedDevice {
+		return res, nil
+	}
+
+	mapperName := name + "-" + randutilRandomKernelUUID()
+	attempts, err := unlockEncryptedVolumeWithRecoveryKey(mapperName, res.Device, &UnlockVolumeUsingSealedKeyOptions{})
+	if err != nil {
+		return res, err
+	}
+	res.UnlockMethod = UnlockedWithRecoveryKey
+	recordUnlockMetrics(&UnlockMetrics{
+		Volume:              name,
+		Method:              res.UnlockMethod.String(),
+		RecoveryKeyAttempts: attempts,
+	})
+	recordUnlockMethod(name, res.UnlockMethod)
+	setUnlockedDevice(&res, mapperName)
+	res.Device = filepath.Join("/dev/mapper", mapperName)
+	return res, nil
+}
+
+// findVolumeToUnlock locates the partition of the named volume on the disk,
+// preferring the encrypted one, or at the given location if set.
+func findVolumeToUnlock(disk disks.Disk, name string, loc VolumeLocation) (UnlockResult, error) {
 	res := UnlockResult{
 		UnlockMethod: NotUnlocked,
 	}
 
-	if opts == nil {
-		opts = &UnlockVolumeUsingSealedKeyOptions{}
+	if loc.PartitionUUID != "" || loc.PartitionLabel != "" || loc.PartitionType != "" {
+		return findVolumeToUnlockAtLocation(disk, name, loc)
 	}
-	// TODO:UC20: use sb.SecureConnectToDefaultTPM() if we decide there's benefit in doing that or
-	//            we have a hard requirement for a valid EK cert chain for every boot (ie, panic
-	//            if there isn't one). But we can't do that as long as we need to download
-	//            intermediate certs from the manufacturer.
 
 	// find the encrypted device using the disk we were provided - note that
 	// we do not specify IsDecryptedDevice in opts because here we are
@@ -269,81 +507,274 @@ func UnlockVolumeUsingSealedKeyIfEncrypted(
 	}
 
 	res.Device = filepath.Join("/dev/disk/by-partuuid", partUUID)
+	res.PartUUID = partUUID
+	res.PartDevice = res.Device
+	return res, nil
+}
 
-	tpm, tpmErr := sbConnectToDefaultTPM()
-	if tpmErr != nil {
-		if !xerrors.Is(tpmErr, sb.ErrNoTPM2Device) {
-			return res, fmt.Errorf("cannot unlock encrypted device %q: %v", name, tpmErr)
+// findVolumeToUnlockAtLocation locates the partition of the named volume by
+// its partition UUID, GPT partition label or partition type, the volume being
+// encrypted if the partition holds a LUKS container.
+func findVolumeToUnlockAtLocation(disk disks.Disk, name string, loc VolumeLocation) (UnlockResult, error) {
+	res := UnlockResult{
+		UnlockMethod: NotUnlocked,
+	}
+
+	set := 0
+	for _, v := range []string{loc.PartitionUUID, loc.PartitionLabel, loc.PartitionType} {
+		if v != "" {
+			set++
 		}
-		logger.Noticef("cannot open TPM connection: %v", tpmErr)
-	} else {
-		defer tpm.Close()
+	}
+	if set > 1 {
+		return res, fmt.Errorf("cannot locate device %q by more than one of partition uuid, partition label and partition type", name)
+	}
+	partUUID := loc.PartitionUUID
+	var err error
+	switch {
+	case loc.PartitionLabel != "":
+		partUUID, err = disk.FindMatchingPartitionUUIDWithPartLabel(loc.PartitionLabel)
+	case loc.PartitionType != "":
+		partUUID, err = disk.FindMatchingPartitionUUIDWithPartType(loc.PartitionType)
+	}
+	if err != nil {
+		return res, fmt.Errorf("error enumerating partitions for disk to find device %q: %v", name, err)
+	}
+	// this also verifies that a partition uuid is on the disk
+	fsType, err := disk.FilesystemTypeOfPartition(partUUID)
+	if err != nil {
+		return res, fmt.Errorf("error enumerating partitions for disk to find device %q: %v", name, err)
+	}
+	res.IsDecryptedDevice = fsType == "crypto_LUKS"
+
+	res.Device = filepath.Join("/dev/disk/by-partuuid", partUUID)
+	res.PartUUID = partUUID
+	res.PartDevice = res.Device
+	return res, nil
+}
+
+// connectToTPMForUnlock connects to the TPM, returning whether it can be used
+// to unseal keys. A nil connection without error is returned if there is no
+// TPM device.
+func connectToTPMForUnlock() (tpm *sb.TPMConnection, available bool, err error) {
+	// TODO:UC20: use sb.SecureConnectToDefaultTPM() if we decide there's benefit in doing that or
+	//            we have a hard requirement for a valid EK cert chain for every boot (ie, panic
+	//            if there isn't one). But we can't do that as long as we need to download
+	//            intermediate certs from the manufacturer.
+	tpm, err = sbConnectToDefaultTPM()
+	if err != nil {
+		if !xerrors.Is(err, sb.ErrNoTPM2Device) {
+			return nil, false, err
+		}
+		logger.Noticef("cannot open TPM connection: %v", err)
+		return nil, false, nil
 	}
 
 	// Also check if the TPM device is enabled. The platform firmware may disable the storage
 	// and endorsement hierarchies, but the device will remain visible to the operating system.
-	tpmDeviceAvailable := tpmErr == nil && isTPMEnabled(tpm)
+	return tpm, isTPMEnabled(tpm), nil
+}
 
-	var lockErr error
-	var mapperName string
-	err = func() error {
-		defer func() {
-			if opts.LockKeysOnFinish && tpmDeviceAvailable {
-				// Lock access to the sealed keys. This should be called whenever there
-				// is a TPM device detected, regardless of whether secure boot is enabled
-				// or there is an encrypted volume to unlock. Note that snap-bootstrap can
-				// be called several times during initialization, and if there are multiple
-				// volumes to unlock we should lock access to the sealed keys only after
-				// the last encrypted volume is unlocked, in which case lockKeysOnFinish
-				// should be set to true.
-				//
-				// We should only touch the PCR that we've currently reserved for the kernel
-				// EFI image. Touching others will break the ability to perform any kind of
-				// attestation using the TPM because it will make the log inconsistent.
-				lockErr = sbBlockPCRProtectionPolicies(tpm, []int{initramfsPCR})
-			}
-		}()
+// unlockFoundVolume unlocks the volume located by findVolumeToUnlock if it
+// is encrypted, updating the unlock method of the result and returning the
+// name of the mapped device.
+func unlockFoundVolume(tpm *sb.TPMConnection, tpmDeviceAvailable bool, res *UnlockResult, name, sealedEncryptionKeyFile string, opts *UnlockVolumeUsingSealedKeyOptions) (string, error) {
+	if !res.IsDecryptedDevice {
+		// if we didn't find an encrypted device just return, don't try to
+		// unlock it
+		return "", nil
+	}
 
-		if !res.IsDecryptedDevice {
-			// if we didn't find an encrypted device just return, don't try to
-			// unlock it
-			return nil
+	if opts.RequireIntegrity {
+		integrity, err := readLUKSIntegrity(res.Device)
+		if err != nil {
+			return "", fmt.Errorf("cannot check integrity of %q: %v", res.Device, err)
 		}
+		if integrity == "" {
+			return "", fmt.Errorf("cannot unlock %q: volume is not integrity protected", res.Device)
+		}
+	}
 
-		mapperName = name + "-" + randutilRandomKernelUUID()
-		// if we don't have a tpm, and we allow using a recovery key, do that
-		// directly
-		if !tpmDeviceAvailable && opts.AllowRecoveryKey {
-			err := UnlockEncryptedVolumeWithRecoveryKey(mapperName, res.Device)
-			if err != nil {
-				return err
-			}
-			res.UnlockMethod = UnlockedWithRecoveryKey
-			return nil
+	mapperName := name + "-" + randutilRandomKernelUUID()
+	metrics := &UnlockMetrics{Volume: name}
+	defer func() {
+		metrics.Method = res.UnlockMethod.String()
+		recordUnlockMetrics(metrics)
+		recordUnlockMethod(name, res.UnlockMethod)
+	}()
+
+	// keys sealed by other protectors do not need the tpm
+	if p := keyProtectorForSealedKey(sealedEncryptionKeyFile); p != nil {
+		if err := unlockEncryptedPartitionWithKeyProtector(p, res, mapperName, sealedEncryptionKeyFile, opts, metrics); err != nil {
+			return mapperName, err
 		}
+		setUnlockedDevice(res, mapperName)
+		return mapperName, nil
+	}
 
-		// otherwise we have a tpm and we should use the sealed key first, but
-		// this method will fallback to using the recovery key if enabled
-		method, err := unlockEncryptedPartitionWithSealedKey(tpm, mapperName, res.Device, sealedEncryptionKeyFile, "", opts.AllowRecoveryKey)
-		res.UnlockMethod = method
+	// if we don't have a tpm, and we allow using a recovery key, do that
+	// directly
+	if !tpmDeviceAvailable && opts.AllowRecoveryKey {
+		attempts, err := unlockEncryptedVolumeWithRecoveryKey(mapperName, res.Device, opts)
+		metrics.RecoveryKeyAttempts = attempts
+		if err != nil {
+			return "", err
+		}
+		res.UnlockMethod = UnlockedWithRecoveryKey
+		setUnlockedDevice(res, mapperName)
+		return mapperName, nil
+	}
+
+	// otherwise we have a tpm and we should use the sealed key first, but
+	// this method will fallback to using the recovery key if enabled
+	start := timeNow()
+	method, attempts, err := unlockEncryptedPartitionWithSealedKey(tpm, mapperName, res.Device, sealedEncryptionKeyFile, "", opts)
+	metrics.UnsealDuration = timeNow().Sub(start)
+	metrics.RecoveryKeyAttempts = attempts
+	res.UnlockMethod = method
+	if err == nil {
+		setUnlockedDevice(res, mapperName)
+	}
+	return mapperName, err
+}
+
+// unlockEncryptedPartitionWithKeyProtector unlocks the partition of the
+// result using the key unsealed by the key protector, falling back to the
+// recovery key if enabled.
+func unlockEncryptedPartitionWithKeyProtector(p KeyProtector, res *UnlockResult, mapperName, keyFile string, opts *UnlockVolumeUsingSealedKeyOptions, metrics *UnlockMetrics) error {
+	start := timeNow()
+	key, err := p.UnsealKey(keyFile)
+	if err == nil {
+		err = unlockEncryptedPartitionWithKey(mapperName, res.Device, key)
+	}
+	metrics.UnsealDuration = timeNow().Sub(start)
+	if err == nil {
+		res.UnlockMethod = UnlockedWithKeyProtector
+		res.KeyProtector = p.Name()
+		metrics.KeyProtector = p.Name()
+		return nil
+	}
+	if !opts.AllowRecoveryKey {
+		return fmt.Errorf("cannot activate encrypted device %q: %v", res.Device, err)
+	}
+
+	logger.Noticef("cannot unlock encrypted device %q with key sealed by %q: %v", res.Device, p.Name(), err)
+	attempts, err := unlockEncryptedVolumeWithRecoveryKey(mapperName, res.Device, opts)
+	metrics.RecoveryKeyAttempts = attempts
+	if err != nil {
 		return err
-	}()
+	}
+	res.UnlockMethod = UnlockedWithRecoveryKey
+	return nil
+}
+
+// setUnlockedDevice records the details of the device unlocked from the
+// partition of the result. Failing to read the LUKS UUID is not fatal as the
+// device was unlocked already.
+func setUnlockedDevice(res *UnlockResult, mapperName string) {
+	res.MapperName = mapperName
+	luksUUID, err := readLUKSUUID(res.PartDevice)
 	if err != nil {
-		return res, err
+		logger.Noticef("cannot read LUKS UUID of %q: %v", res.PartDevice, err)
+		return
 	}
-	if lockErr != nil {
-		return res, fmt.Errorf("cannot lock access to sealed keys: %v", lockErr)
+	res.LUKSUUID = luksUUID
+}
+
+const (
+	// both LUKS1 and LUKS2 headers keep the UUID as a NUL terminated
+	// string at the same offset
+	luksUUIDOffset = 168
+	luksUUIDLen    = 40
+)
+
+var luksMagic = []byte("LUKS\xba\xbe")
+
+func readLUKSUUIDImpl(device string) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
 
-	if res.IsDecryptedDevice {
-		// return the encrypted device if the device we are maybe unlocking is
-		// an encrypted device
-		res.Device = filepath.Join("/dev/mapper", mapperName)
+	hdr := make([]byte, luksUUIDOffset+luksUUIDLen)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return "", fmt.Errorf("cannot read LUKS header: %v", err)
 	}
+	if !bytes.HasPrefix(hdr, luksMagic) {
+		return "", fmt.Errorf("not a LUKS device")
+	}
+	uuid := hdr[luksUUIDOffset:]
+	if i := bytes.IndexByte(uuid, 0); i >= 0 {
+		uuid = uuid[:i]
+	}
+	return string(uuid), nil
+}
 
-	return res, nil
+var readLUKSUUID = readLUKSUUIDImpl
+
+const (
+	// the JSON metadata of LUKS2 follows the binary header, whose
+	// hdr_size field is the size of both
+	luks2BinaryHeaderSize = 4096
+	luks2HeaderSizeOffset = 8
+	// the largest LUKS2 metadata area cryptsetup supports
+	luks2MaxHeaderSize = 4 * 1024 * 1024
+)
+
+// readLUKSIntegrityImpl returns the dm-integrity algorithm, eg.
+// hmac(sha256), of the LUKS2 container on the device, or an empty string
+// when the container is not integrity protected.
+func readLUKSIntegrityImpl(device string) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, luks2BinaryHeaderSize)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return "", fmt.Errorf("cannot read LUKS header: %v", err)
+	}
+	if !bytes.HasPrefix(hdr, luksMagic) {
+		return "", fmt.Errorf("not a LUKS device")
+	}
+	if version := binary.BigEndian.Uint16(hdr[len(luksMagic):]); version != 2 {
+		// only LUKS2 supports integrity protection
+		return "", nil
+	}
+	hdrSize := binary.BigEndian.Uint64(hdr[luks2HeaderSizeOffset:])
+	if hdrSize <= luks2BinaryHeaderSize || hdrSize > luks2MaxHeaderSize {
+		return "", fmt.Errorf("invalid LUKS2 header size %d", hdrSize)
+	}
+	metadata := make([]byte, hdrSize-luks2BinaryHeaderSize)
+	if _, err := io.ReadFull(f, metadata); err != nil {
+		return "", fmt.Errorf("cannot read LUKS2 metadata: %v", err)
+	}
+	if i := bytes.IndexByte(metadata, 0); i >= 0 {
+		metadata = metadata[:i]
+	}
+	var luks2 struct {
+		Segments map[string]struct {
+			Type      string `json:"type"`
+			Integrity *struct {
+				Type string `json:"type"`
+			} `json:"integrity"`
+		} `json:"segments"`
+	}
+	if err := json.Unmarshal(metadata, &luks2); err != nil {
+		return "", fmt.Errorf("cannot decode LUKS2 metadata: %v", err)
+	}
+	for _, segment := range luks2.Segments {
+		if segment.Type == "crypt" && segment.Integrity != nil {
+			return segment.Integrity.Type, nil
+		}
+	}
+	return "", nil
 }
 
+var readLUKSIntegrity = readLUKSIntegrityImpl
+
 // UnlockEncryptedVolumeUsingKey unlocks an existing volume using the provided key. The
 // path to the device node is returned.
 // TODO: use UnlockResult here too?
@@ -364,22 +795,82 @@ func UnlockEncryptedVolumeUsingKey(disk disks.Disk, name string, key []byte) (st
 	if err := unlockEncryptedPartitionWithKey(mapperName, encdev, key); err != nil {
 		return "", err
 	}
+	recordUnlockMethod(name, UnlockedWithKey)
 	return filepath.Join("/dev/mapper", mapperName), nil
 }
 
 // UnlockEncryptedVolumeWithRecoveryKey prompts for the recovery key and uses it
 // to open an encrypted device.
 func UnlockEncryptedVolumeWithRecoveryKey(name, device string) error {
+	_, err := unlockEncryptedVolumeWithRecoveryKey(name, device, &UnlockVolumeUsingSealedKeyOptions{})
+	return err
+}
+
+// activateVolumeKeyringPrefix returns the keyring prefix to use with the
+// given unlock options.
+func activateVolumeKeyringPrefix(opts *UnlockVolumeUsingSealedKeyOptions) string {
+	if opts.KeyringPrefix != "" {
+		return opts.KeyringPrefix
+	}
+	return keyringPrefix
+}
+
+// activateVolumeRecoveryKeyTries returns the number of times the user is
+// asked for the recovery key with the given unlock options.
+func activateVolumeRecoveryKeyTries(opts *UnlockVolumeUsingSealedKeyOptions) int {
+	if opts.RecoveryKeyTries > 0 {
+		return opts.RecoveryKeyTries
+	}
+	return defaultRecoveryKeyTries
+}
+
+// unlockEncryptedVolumeWithRecoveryKey opens an encrypted device with the
+// recovery key and returns the number of times it was entered, zero if that
+// is not known.
+func unlockEncryptedVolumeWithRecoveryKey(name, device string, opts *UnlockVolumeUsingSealedKeyOptions) (attempts int, err error) {
+	tries := activateVolumeRecoveryKeyTries(opts)
+	if requestor := newAuthRequestor(); requestor != nil {
+		return unlockEncryptedVolumeWithRequestedRecoveryKey(requestor, name, device, tries, opts)
+	}
+
+	// secboot prompts on the console using systemd-ask-password
 	options := sb.ActivateVolumeOptions{
-		RecoveryKeyTries: 3,
-		KeyringPrefix:    keyringPrefix,
+		RecoveryKeyTries: tries,
+		KeyringPrefix:    activateVolumeKeyringPrefix(opts),
 	}
 
 	if err := sbActivateVolumeWithRecoveryKey(name, device, nil, &options); err != nil {
-		return fmt.Errorf("cannot unlock encrypted device %q: %v", device, err)
+		return 0, fmt.Errorf("cannot unlock encrypted device %q: %v", device, err)
 	}
 
-	return nil
+	return 0, nil
+}
+
+// unlockEncryptedVolumeWithRequestedRecoveryKey opens an encrypted device
+// with the recovery key obtained from the requestor, asking for it again up
+// to the given number of tries if it is not accepted. It returns the number of
+// keys that were tried.
+func unlockEncryptedVolumeWithRequestedRecoveryKey(requestor AuthRequestor, name, device string, tries int, opts *UnlockVolumeUsingSealedKeyOptions) (attempts int, err error) {
+	options := sb.ActivateVolumeOptions{
+		// the key is read from the reader only
+		RecoveryKeyTries: 1,
+		KeyringPrefix:    activateVolumeKeyringPrefix(opts),
+	}
+
+	var lastErr error
+	for triesLeft := tries; triesLeft > 0; triesLeft-- {
+		key, err := requestor.RequestRecoveryKey(name, device, triesLeft, lastErr)
+		if err != nil {
+			return attempts, fmt.Errorf("cannot unlock encrypted device %q: %v", device, err)
+		}
+		attempts++
+		lastErr = sbActivateVolumeWithRecoveryKey(name, device, strings.NewReader(key+"\n"), &options)
+		if lastErr == nil {
+			return attempts, nil
+		}
+		logger.Noticef("cannot unlock encrypted device %q with the recovery key: %v", device, lastErr)
+	}
+	return attempts, fmt.Errorf("cannot unlock encrypted device %q: %v", device, lastErr)
 }
 
 func isActivatedWithRecoveryKey(err error) bool {
@@ -398,17 +889,27 @@ func isActivatedWithRecoveryKey(err error) bool {
 
 // unlockEncryptedPartitionWithSealedKey unseals the keyfile and opens an encrypted
 // device. If activation with the sealed key fails, this function will attempt to
-// activate it with the fallback recovery key instead.
-func unlockEncryptedPartitionWithSealedKey(tpm *sb.TPMConnection, name, device, keyfile, pinfile string, allowRecovery bool) (UnlockMethod, error) {
+// activate it with the fallback recovery key instead, prompting for it with
+// the auth requestor if there is one. It returns the number of recovery keys
+// that were tried, zero if that is not known.
+func unlockEncryptedPartitionWithSealedKey(tpm *sb.TPMConnection, name, device, keyfile, pinfile string, opts *UnlockVolumeUsingSealedKeyOptions) (method UnlockMethod, recoveryKeyAttempts int, err error) {
 	options := sb.ActivateVolumeOptions{
-		PassphraseTries: 1,
+		PassphraseTries: defaultPassphraseTries,
 		// disable recovery key by default
 		RecoveryKeyTries: 0,
-		KeyringPrefix:    keyringPrefix,
+		KeyringPrefix:    activateVolumeKeyringPrefix(opts),
+	}
+	if opts.PassphraseTries > 0 {
+		options.PassphraseTries = opts.PassphraseTries
 	}
-	if allowRecovery {
-		// enable recovery key only when explicitly allowed
-		options.RecoveryKeyTries = 3
+	var requestor AuthRequestor
+	if opts.AllowRecoveryKey {
+		// enable recovery key only when explicitly allowed, secboot
+		// prompts for it on the console unless there is a better way
+		requestor = newAuthRequestor()
+		if requestor == nil {
+			options.RecoveryKeyTries = activateVolumeRecoveryKeyTries(opts)
+		}
 	}
 
 	// XXX: pinfile is currently not used
@@ -419,16 +920,25 @@ func unlockEncryptedPartitionWithSealedKey(tpm *sb.TPMConnection, name, device,
 		// recovery key
 		if err == nil {
 			logger.Noticef("successfully activated encrypted device %q with TPM", device)
-			return UnlockedWithSealedKey, nil
+			return UnlockedWithSealedKey, 0, nil
 		} else if isActivatedWithRecoveryKey(err) {
 			logger.Noticef("successfully activated encrypted device %q using a fallback activation method", device)
-			return UnlockedWithRecoveryKey, nil
+			return UnlockedWithRecoveryKey, 0, nil
 		}
 		// no other error is possible when activation succeeded
-		return UnlockStatusUnknown, fmt.Errorf("internal error: volume activated with unexpected error: %v", err)
+		return UnlockStatusUnknown, 0, fmt.Errorf("internal error: volume activated with unexpected error: %v", err)
+	}
+	if requestor != nil {
+		logger.Noticef("cannot activate encrypted device %q with TPM: %v", device, err)
+		attempts, err := unlockEncryptedVolumeWithRequestedRecoveryKey(requestor, name, device, activateVolumeRecoveryKeyTries(opts), opts)
+		if err != nil {
+			return NotUnlocked, attempts, err
+		}
+		logger.Noticef("successfully activated encrypted device %q using a fallback activation method", device)
+		return UnlockedWithRecoveryKey, attempts, nil
 	}
 	// ActivateVolumeWithTPMSealedKey should always return an error if activated == false
-	return NotUnlocked, fmt.Errorf("cannot activate encrypted device %q: %v", device, err)
+	return NotUnlocked, 0, fmt.Errorf("cannot activate encrypted device %q: %v", device, err)
 }
 
 // unlockEncryptedPartitionWithKey unlocks encrypted partition with the provided
@@ -443,16 +953,58 @@ func unlockEncryptedPartitionWithKey(name, device string, key []byte) error {
 	return err
 }
 
+const defaultKeyProtector = TPM2KeyProtectorName
+
+func init() {
+	RegisterKeyProtector(tpm2KeyProtector{})
+}
+
+// tpm2KeyProtector seals the encryption keys to the TPM.
+type tpm2KeyProtector struct{}
+
+func (tpm2KeyProtector) Name() string {
+	return TPM2KeyProtectorName
+}
+
+func (tpm2KeyProtector) IsSealedKey(keyFile string) bool {
+	_, err := sbReadSealedKeyObject(keyFile)
+	return err == nil
+}
+
+// UnsealKey unseals the key directly, volumes are normally unlocked by
+// activating them with the TPM sealed key instead, so that the key never
+// leaves secboot.
+func (tpm2KeyProtector) UnsealKey(keyFile string) ([]byte, error) {
+	k, err := sbReadSealedKeyObject(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tpm, err := sbConnectToDefaultTPM()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to TPM: %v", err)
+	}
+	defer tpm.Close()
+	if !isTPMEnabled(tpm) {
+		return nil, fmt.Errorf("TPM device is not enabled")
+	}
+	return sbUnsealFromTPM(k, tpm)
+}
+
 // SealKeys provisions the TPM and seals the encryption keys according to the
 // specified parameters. If the TPM is already provisioned, or a sealed key already
 // exists, SealKeys will fail and return an error.
-func SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
+func (tpm2KeyProtector) SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
 	numModels := len(params.ModelParams)
 	if numModels < 1 {
 		return fmt.Errorf("at least one set of model-specific parameters is required")
 	}
+	switch params.TPMSRKTemplate {
+	case "", SRKTemplateRSA2048, SRKTemplateECCP256:
+	default:
+		return fmt.Errorf("unsupported storage root key template %q", params.TPMSRKTemplate)
+	}
 
-	tpm, err := sbConnectToDefaultTPM()
+	tpm, err := connectToTPMForSealing(params)
 	if err != nil {
 		return fmt.Errorf("cannot connect to TPM: %v", err)
 	}
@@ -460,17 +1012,33 @@ func SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
 	if !isTPMEnabled(tpm) {
 		return fmt.Errorf("TPM device is not enabled")
 	}
+	tpmInfo := identifyTPM(tpm)
+	if tpmInfo.Virtual {
+		logger.Noticef("sealing keys to a virtual TPM (%s)", tpmInfo.Manufacturer)
+	}
+	if err := checkPCRBanks(tpmInfo); err != nil {
+		return err
+	}
 
 	pcrProfile, err := buildPCRProtectionProfile(params.ModelParams)
 	if err != nil {
 		return err
 	}
 
+	// Refuse to seal if the profile cannot be trusted to match what the
+	// firmware measures on the next boot
+	if err := checkEventLogConsistency(tpm, pcrProfile); err != nil {
+		return err
+	}
+
 	if params.TPMProvision {
 		// Provision the TPM as late as possible
-		if err := tpmProvision(tpm, params.TPMLockoutAuthFile); err != nil {
+		if err := tpmProvision(tpm, params.TPMLockoutAuthFile, params.TPMClear, tpmInfo.Quirks); err != nil {
 			return err
 		}
+		if err := provisionSRK(tpm, params.TPMSRKTemplate, params.TPMSRKHandle); err != nil {
+			return fmt.Errorf("cannot provision the storage root key: %v", err)
+		}
 	}
 
 	// Seal the provided keys to the TPM
@@ -488,22 +1056,48 @@ func SealKeys(keys []SealKeyRequest, params *SealKeysParams) error {
 		})
 	}
 
-	authKey, err := sbSealKeyToTPMMultiple(tpm, sbKeys, &creationParams)
+	var authKey sb.TPMPolicyAuthKey
+	err = retryOnNVRate(tpmInfo.Quirks, isTPMNVRateError, "sealing", func() error {
+		authKey, err = sbSealKeyToTPMMultiple(tpm, sbKeys, &creationParams)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	if params.TPMPolicyAuthKeyFile != "" {
-		if err := osutil.AtomicWriteFile(params.TPMPolicyAuthKeyFile, authKey, 0600, 0); err != nil {
-			return fmt.Errorf("cannot write the policy auth key file: %v", err)
+		if err := writePolicyAuthKey(tpm, params.TPMPolicyAuthKeyFile, authKey, params.TPMWrapPolicyAuthKey); err != nil {
+			return err
 		}
 	}
 
+	var fingerprint string
+	if params.TPMPolicyAuthKey != nil {
+		fingerprint, err = policyAuthKeyFingerprint(&params.TPMPolicyAuthKey.PublicKey)
+	} else {
+		fingerprint, err = policyAuthKeyFingerprintFromPrivate(authKey)
+	}
+	if err != nil {
+		logger.Noticef("cannot compute the policy auth key fingerprint: %v", err)
+	}
+	keyFiles := make([]string, 0, len(keys))
+	for i := range keys {
+		keyFiles = append(keyFiles, keys[i].KeyFile)
+	}
+	recordSealedKeyMetadata(tpm, keyFiles, pcrProfile, fingerprint, false)
+
 	return nil
 }
 
+func connectToTPMForSealing(params *SealKeysParams) (*sb.TPMConnection, error) {
+	if params.TPMEKCertFile == "" || params.TPMSkipEKVerification {
+		return insecureConnectToTPM()
+	}
+	return secureConnectToTPM(params.TPMEKCertFile)
+}
+
 // ResealKeys updates the PCR protection policy for the sealed encryption keys
 // according to the specified parameters.
-func ResealKeys(params *ResealKeysParams) error {
+func (tpm2KeyProtector) ResealKeys(params *ResealKeysParams) error {
 	numModels := len(params.ModelParams)
 	if numModels < 1 {
 		return fmt.Errorf("at least one set of model-specific parameters is required")
@@ -523,12 +1117,45 @@ func ResealKeys(params *ResealKeysParams) error {
 		return err
 	}
 
-	authKey, err := ioutil.ReadFile(params.TPMPolicyAuthKeyFile)
+	authKeyFileContent, err := ioutil.ReadFile(params.TPMPolicyAuthKeyFile)
 	if err != nil {
 		return fmt.Errorf("cannot read the policy auth key file: %v", err)
 	}
+	authKey, err := policyAuthKeyFromFileContent(tpm, authKeyFileContent)
+	if err != nil {
+		return err
+	}
+
+	tpmInfo := identifyTPM(tpm)
+	// updating the policy increments the PCR policy counter in NV storage
+	reseal := func() error {
+		return retryOnNVRate(tpmInfo.Quirks, isTPMNVRateError, "resealing", func() error {
+			return sbUpdateKeyPCRProtectionPolicyMultiple(tpm, params.KeyFiles, authKey, pcrProfile)
+		})
+	}
+	if err := reseal(); err != nil {
+		return err
+	}
+	if params.RollbackCounterMinValue != 0 && len(params.KeyFiles) > 0 {
+		if err := advanceRollbackCounter(tpm, params.KeyFiles[0], params.RollbackCounterMinValue, reseal); err != nil {
+			return err
+		}
+	}
+
+	fingerprint, err := policyAuthKeyFingerprintFromPrivate(authKey)
+	if err != nil {
+		logger.Noticef("cannot compute the policy auth key fingerprint: %v", err)
+	}
+	recordSealedKeyMetadata(tpm, params.KeyFiles, pcrProfile, fingerprint, true)
+	return nil
+}
 
-	return sbUpdateKeyPCRProtectionPolicyMultiple(tpm, params.KeyFiles, authKey, pcrProfile)
+func sealedKeyPCRPolicyCounterHandleImpl(keyFile string) (uint32, error) {
+	k, err := sbReadSealedKeyObject(keyFile)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read sealed key object: %v", err)
+	}
+	return uint32(k.PCRPolicyCounterHandle()), nil
 }
 
 func buildPCRProtectionProfile(modelParams []*SealKeyModelParams) (*sb.PCRProtectionProfile, error) {
@@ -547,10 +1174,9 @@ func buildPCRProtectionProfile(modelParams []*SealKeyModelParams) (*sb.PCRProtec
 		policyParams := sb.EFISecureBootPolicyProfileParams{
 			PCRAlgorithm:  tpm2.HashAlgorithmSHA256,
 			LoadSequences: loadSequences,
-			// TODO:UC20: set SignatureDbUpdateKeystore to support applying forbidden
-			//            signature updates to blacklist signing keys (after rotating them).
-			//            This also requires integration of sbkeysync, and some work to
-			//            ensure that the PCR profile is updated before/after sbkeysync executes.
+			// pending updates, eg. dbx revocations, are included so that
+			// applying them with sbkeysync does not prevent unsealing
+			SignatureDbUpdateKeystores: mp.EFISignatureDbUpdateKeystores,
 		}
 
 		if err := sbAddEFISecureBootPolicyProfile(modelProfile, &policyParams); err != nil {
@@ -566,6 +1192,18 @@ func buildPCRProtectionProfile(modelParams []*SealKeyModelParams) (*sb.PCRProtec
 			return nil, fmt.Errorf("cannot add EFI boot manager profile: %v", err)
 		}
 
+		// Add machine owner key profile
+		if mp.EFIMachineOwnerKeys {
+			if err := addMachineOwnerKeyProfile(modelProfile); err != nil {
+				return nil, err
+			}
+		}
+
+		// Add unified kernel image profile
+		if err := addUnifiedKernelImageProfile(modelProfile, mp.EFILoadChains); err != nil {
+			return nil, err
+		}
+
 		// Add systemd EFI stub profile
 		if len(mp.KernelCmdlines) != 0 {
 			systemdStubParams := sb.SystemdEFIStubProfileParams{
@@ -590,6 +1228,16 @@ func buildPCRProtectionProfile(modelParams []*SealKeyModelParams) (*sb.PCRProtec
 			}
 		}
 
+		// Add seed verity profile, measured after the model
+		if len(mp.SeedVerityRootHashes) != 0 {
+			addSeedVerityProfile(modelProfile, mp.SeedVerityRootHashes)
+		}
+
+		// Add data integrity profile, measured last
+		if mp.DataIntegrity {
+			addDataIntegrityProfile(modelProfile)
+		}
+
 		modelPCRProfiles = append(modelPCRProfiles, modelProfile)
 	}
 
@@ -605,7 +1253,304 @@ func buildPCRProtectionProfile(modelParams []*SealKeyModelParams) (*sb.PCRProtec
 	return pcrProfile, nil
 }
 
-func tpmProvision(tpm *sb.TPMConnection, lockoutAuthFile string) error {
+// ComputePCRProfile returns the canonical form of the PCR values allowed by
+// the PCR protection profile built for the given model parameters, which is
+// the profile keys are sealed with. Two sets of parameters for which the
+// same form is returned lead to the same sealed PCR policy. The values are
+// computed without the TPM.
+func ComputePCRProfile(modelParams []*SealKeyModelParams) ([]byte, error) {
+	if len(modelParams) == 0 {
+		return nil, fmt.Errorf("at least one set of model-specific parameters is required")
+	}
+	pcrProfile, err := buildPCRProtectionProfile(modelParams)
+	if err != nil {
+		return nil, err
+	}
+	values, err := computeProfilePCRValues(nil, pcrProfile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute PCR values from profile: %v", err)
+	}
+	return encodePCRProfileValues(values), nil
+}
+
+// computeBootChainPCRValuesImpl returns the states of the PCRs allowed by the
+// PCR protection profile built for the given model parameters, computed
+// without the TPM.
+func computeBootChainPCRValuesImpl(modelParams []*SealKeyModelParams) ([]map[int][]byte, error) {
+	pcrProfile, err := buildPCRProtectionProfile(modelParams)
+	if err != nil {
+		return nil, err
+	}
+	return computeProfilePCRValues(nil, pcrProfile)
+}
+
+// addMachineOwnerKeyProfile adds the values of the MOK PCR measured by shim
+// for the current machine owner key state and, if changes to the key list
+// were requested with mokutil, for the state after MokManager applied them
+// on the next boot. The authorities of the images shim verified with
+// machine owner keys are extended into the secure boot policy PCR.
+func addMachineOwnerKeyProfile(profile *sb.PCRProtectionProfile) error {
+	states, err := predictedMokStates()
+	if err != nil {
+		return fmt.Errorf("cannot read machine owner key state: %v", err)
+	}
+	enrolled := false
+	branches := make([]*sb.PCRProtectionProfile, 0, len(states))
+	for _, state := range states {
+		if len(state[0]) != 0 {
+			enrolled = true
+		}
+		if mokValidationDisabled(state) {
+			return fmt.Errorf("cannot use machine owner keys: shim validation is disabled")
+		}
+		branches = append(branches, sb.NewPCRProtectionProfile().AddPCRValue(tpm2.HashAlgorithmSHA256, mokPCR, mokPCRValue(state)))
+	}
+	if !enrolled {
+		return fmt.Errorf("cannot use machine owner keys: no keys are enrolled")
+	}
+
+	logPath := filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements")
+	authorities, err := readMokAuthorityDigests(logPath)
+	if err != nil {
+		return fmt.Errorf("cannot read machine owner key authorities from event log: %v", err)
+	}
+	// shim measures each authority once, when it is first used to
+	// verify an image
+	for _, digest := range authorities {
+		profile.ExtendPCR(tpm2.HashAlgorithmSHA256, secureBootPolicyPCR, digest)
+	}
+
+	profile.AddProfileOR(branches...)
+	return nil
+}
+
+// addUnifiedKernelImageProfile adds the alternative values of the UKI PCR
+// expected in the initrd after booting any of the unified kernel images that
+// terminate the given load chains. Nothing is added if no unified kernel
+// images are used.
+func addUnifiedKernelImageProfile(profile *sb.PCRProtectionProfile, chains []*LoadChain) error {
+	var ukis, others []*LoadChain
+	var collect func(chains []*LoadChain)
+	collect = func(chains []*LoadChain) {
+		for _, lc := range chains {
+			switch {
+			case lc.UnifiedKernelImage:
+				ukis = append(ukis, lc)
+			case len(lc.Next) == 0:
+				others = append(others, lc)
+			default:
+				collect(lc.Next)
+			}
+		}
+	}
+	collect(chains)
+
+	if len(ukis) == 0 {
+		return nil
+	}
+	if len(others) != 0 {
+		return fmt.Errorf("cannot mix unified kernel images with other kernel load chains")
+	}
+
+	var ukiProfiles []*sb.PCRProtectionProfile
+	for _, uki := range ukis {
+		value, err := computeUnifiedKernelImagePCRValue(uki.BootFile)
+		if err != nil {
+			return fmt.Errorf("cannot compute PCR %d value of unified kernel image %s: %v", ukiPCR, uki.Path, err)
+		}
+		for _, v := range ukiPCRPhaseValues(value) {
+			ukiProfiles = append(ukiProfiles, sb.NewPCRProtectionProfile().AddPCRValue(tpm2.HashAlgorithmSHA256, ukiPCR, v))
+		}
+	}
+	profile.AddProfileOR(ukiProfiles...)
+	return nil
+}
+
+func computeUnifiedKernelImagePCRValueImpl(b *bootloader.BootFile) ([]byte, error) {
+	if b.Snap == "" {
+		f, err := os.Open(b.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ukiPCRValue(f)
+	}
+
+	snapf, err := snapfile.Open(b.Snap)
+	if err != nil {
+		return nil, err
+	}
+	content, err := snapf.ReadFile(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	return ukiPCRValue(bytes.NewReader(content))
+}
+
+// eventLogPCRs are the PCRs covered by the computed PCR profile which are
+// extended by the firmware and recorded in the TCG event log.
+var eventLogPCRs = []int{4, 7}
+
+// checkEventLogConsistency verifies that the TCG event log replays to the
+// current values of the firmware PCRs and that those values are among the
+// ones predicted by the given PCR profile.
+func checkEventLogConsistency(tpm *sb.TPMConnection, pcrProfile *sb.PCRProtectionProfile) error {
+	logPath := filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements")
+	replayed, err := replayEventLog(logPath)
+	if err != nil {
+		if _, ok := err.(*EventLogError); ok {
+			return err
+		}
+		return &EventLogError{PCR: -1, Msg: fmt.Sprintf("cannot replay event log: %v", err)}
+	}
+
+	current, err := readPCRValues(tpm, eventLogPCRs)
+	if err != nil {
+		return fmt.Errorf("cannot read PCR values: %v", err)
+	}
+	for _, pcr := range eventLogPCRs {
+		if !bytes.Equal(replayed[pcr], current[pcr]) {
+			return &EventLogError{PCR: pcr, Msg: "event log does not replay to the current PCR value"}
+		}
+	}
+
+	predicted, err := computeProfilePCRValues(tpm, pcrProfile)
+	if err != nil {
+		return fmt.Errorf("cannot compute PCR values from profile: %v", err)
+	}
+	for _, values := range predicted {
+		if pcrValuesMatch(values, current, eventLogPCRs) {
+			return nil
+		}
+	}
+	return &EventLogError{PCR: -1, Msg: "current PCR values do not match the computed EFI profile"}
+}
+
+func pcrValuesMatch(a, b map[int][]byte, pcrs []int) bool {
+	for _, pcr := range pcrs {
+		if !bytes.Equal(a[pcr], b[pcr]) {
+			return false
+		}
+	}
+	return true
+}
+
+// replayEventLogImpl reads the TCG event log at the given path and returns
+// the SHA-256 values of the PCRs in eventLogPCRs computed from it.
+func replayEventLogImpl(path string) (map[int][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	log, err := tcglog.ParseLog(f, &tcglog.LogOptions{})
+	if err != nil {
+		return nil, &EventLogError{PCR: -1, Msg: fmt.Sprintf("log is truncated or corrupted: %v", err)}
+	}
+
+	values := make(map[int][]byte, len(eventLogPCRs))
+	for _, pcr := range eventLogPCRs {
+		values[pcr] = make([]byte, sha256.Size)
+	}
+	for _, event := range log.Events {
+		pcr := int(event.PCRIndex)
+		current, ok := values[pcr]
+		if !ok || event.EventType == tcglog.EventTypeNoAction {
+			continue
+		}
+		digest, ok := event.Digests[tcglog.AlgorithmSha256]
+		if !ok {
+			return nil, &EventLogError{PCR: pcr, Msg: "event without a SHA-256 digest"}
+		}
+		h := sha256.New()
+		h.Write(current)
+		h.Write(digest)
+		values[pcr] = h.Sum(nil)
+	}
+	return values, nil
+}
+
+// readMokAuthorityDigestsImpl returns the digests of the events recorded
+// in the given TCG event log by shim in the secure boot policy PCR for the
+// images it verified with machine owner keys, in measurement order.
+func readMokAuthorityDigestsImpl(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	log, err := tcglog.ParseLog(f, &tcglog.LogOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("log is truncated or corrupted: %v", err)
+	}
+
+	var digests [][]byte
+	for _, event := range log.Events {
+		if int(event.PCRIndex) != secureBootPolicyPCR || event.EventType != tcglog.EventTypeEFIVariableAuthority {
+			continue
+		}
+		if !isMokAuthorityEvent(event.Data.Bytes()) {
+			continue
+		}
+		digest, ok := event.Digests[tcglog.AlgorithmSha256]
+		if !ok {
+			return nil, fmt.Errorf("authority event without a SHA-256 digest")
+		}
+		digests = append(digests, []byte(digest))
+	}
+	return digests, nil
+}
+
+func readPCRValuesImpl(tpm *sb.TPMConnection, pcrs []int) (map[int][]byte, error) {
+	selection := tpm2.PCRSelectionList{{Hash: tpm2.HashAlgorithmSHA256, Select: pcrs}}
+	_, values, err := tpm.PCRRead(selection)
+	if err != nil {
+		return nil, err
+	}
+	return sha256PCRValues(values), nil
+}
+
+func computeProfilePCRValuesImpl(tpm *sb.TPMConnection, pcrProfile *sb.PCRProtectionProfile) ([]map[int][]byte, error) {
+	// the profiles built by snapd never read the current PCR values so
+	// they can be computed without a TPM
+	var tpmCtx *tpm2.TPMContext
+	if tpm != nil {
+		tpmCtx = tpm.TPMContext
+	}
+	values, err := pcrProfile.ComputePCRValues(tpmCtx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]map[int][]byte, 0, len(values))
+	for _, v := range values {
+		res = append(res, sha256PCRValues(v))
+	}
+	return res, nil
+}
+
+func sha256PCRValues(values tpm2.PCRValues) map[int][]byte {
+	res := make(map[int][]byte)
+	for pcr, digest := range values[tpm2.HashAlgorithmSHA256] {
+		res[pcr] = digest
+	}
+	return res
+}
+
+func tpmProvision(tpm *sb.TPMConnection, lockoutAuthFile string, clear bool, quirks TPMQuirks) error {
+	mode := sb.ProvisionModeFull
+	if clear {
+		// clearing the TPM requires the lockout authorization it was
+		// provisioned with, read it before it is replaced below
+		oldLockoutAuth, err := ioutil.ReadFile(lockoutAuthFile)
+		if err != nil {
+			return fmt.Errorf("cannot read the lockout authorization file: %v", err)
+		}
+		setLockoutAuth(tpm, oldLockoutAuth)
+		mode = sb.ProvisionModeClear
+	}
+
 	// Create and save the lockout authorization file
 	lockoutAuth := make([]byte, 16)
 	// crypto rand is protected against short reads
@@ -620,7 +1565,11 @@ func tpmProvision(tpm *sb.TPMConnection, lockoutAuthFile string) error {
 	// TODO:UC20: ideally we should ask the firmware to clear the TPM and then reboot
 	//            if the device has previously been provisioned, see
 	//            https://godoc.org/github.com/snapcore/secboot#RequestTPMClearUsingPPI
-	if err := provisionTPM(tpm, sb.ProvisionModeFull, lockoutAuth); err != nil {
+	// provisioning creates NV indices
+	err = retryOnNVRate(quirks, isTPMNVRateError, "provisioning", func() error {
+		return provisionTPM(tpm, mode, lockoutAuth)
+	})
+	if err != nil {
 		logger.Noticef("TPM provisioning error: %v", err)
 		return fmt.Errorf("cannot provision TPM: %v", err)
 	}
@@ -631,6 +1580,10 @@ func provisionTPMImpl(tpm *sb.TPMConnection, mode sb.ProvisionMode, lockoutAuth
 	return tpm.EnsureProvisioned(mode, lockoutAuth)
 }
 
+func setLockoutAuthImpl(tpm *sb.TPMConnection, lockoutAuth []byte) {
+	tpm.LockoutHandleContext().SetAuthValue(lockoutAuth)
+}
+
 // buildLoadSequences builds EFI load image event trees from this package LoadChains
 func buildLoadSequences(chains []*LoadChain) (loadseqs []*sb.EFIImageLoadEvent, err error) {
 	// this will build load event trees for the current
@@ -664,6 +1617,9 @@ func (lc *LoadChain) loadEvent(source sb.EFIImageLoadEventSource) (*sb.EFIImageL
 		}
 		next = append(next, ev)
 	}
+	if lc.UnifiedKernelImage && len(lc.Next) != 0 {
+		return nil, fmt.Errorf("unified kernel image %s cannot load other images", lc.Path)
+	}
 	image, err := efiImageFromBootFile(lc.BootFile)
 	if err != nil {
 		return nil, err
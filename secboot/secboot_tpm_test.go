@@ -21,14 +21,19 @@
 package secboot_test
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/syslog"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/canonical/go-tpm2"
 	sb "github.com/snapcore/secboot"
@@ -38,9 +43,11 @@ import (
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/bootloader/efi"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/osutil/disks"
 	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/secboot/secboottest"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snapfile"
 	"github.com/snapcore/snapd/snap/squashfs"
@@ -55,9 +62,41 @@ type secbootSuite struct {
 
 var _ = Suite(&secbootSuite{})
 
+var mockPCRValues = map[int][]byte{
+	4: []byte("pcr4-value"),
+	7: []byte("pcr7-value"),
+}
+
 func (s *secbootSuite) SetUpTest(c *C) {
 	dirs.SetRootDir(c.MkDir())
 	s.AddCleanup(func() { dirs.SetRootDir("/") })
+
+	// by default secboot prompts for the recovery key on the console
+	s.AddCleanup(secboot.MockNewAuthRequestor(func() secboot.AuthRequestor { return nil }))
+
+	// unlock metrics are not sent to the journal
+	s.AddCleanup(secboot.MockJournalNamespaceStreamFile(func(string, string, syslog.Priority, bool) (*os.File, error) {
+		return nil, errors.New("no journal")
+	}))
+
+	// sealed key metadata is tested separately
+	s.AddCleanup(secboot.MockRecordSealedKeyMetadata(func(*sb.TPMConnection, []string, *sb.PCRProtectionProfile, string, bool) {}))
+
+	// by default the event log is consistent with the TPM and the
+	// computed profiles
+	s.AddCleanup(secboot.MockReplayEventLog(func(string) (map[int][]byte, error) {
+		return mockPCRValues, nil
+	}))
+	s.AddCleanup(secboot.MockReadPCRValues(func(*sb.TPMConnection, []int) (map[int][]byte, error) {
+		return mockPCRValues, nil
+	}))
+	s.AddCleanup(secboot.MockComputeProfilePCRValues(func(*sb.TPMConnection, *sb.PCRProtectionProfile) ([]map[int][]byte, error) {
+		return []map[int][]byte{mockPCRValues}, nil
+	}))
+	// by default the TPM is a discrete one without quirks
+	s.AddCleanup(secboot.MockReadTPMInfo(func(*sb.TPMConnection) (*secboot.TPMInfo, error) {
+		return secboot.NewTPMInfo(0x49465800, []string{"sha1", "sha256"}), nil
+	}))
 }
 
 func (s *secbootSuite) TestCheckKeySealingSupported(c *C) {
@@ -307,6 +346,46 @@ func (s *secbootSuite) TestLockTPMSealedKeys(c *C) {
 	}
 }
 
+func (s *secbootSuite) TestLockTPMSealedKeysIfArmed(c *C) {
+	mockSbTPM, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(tpm *sb.TPMConnection) bool { return true })
+	defer restore()
+	blockErr := errors.New("block failed")
+	blockCalls := 0
+	restore = secboot.MockSbBlockPCRProtectionPolicies(func(tpm *sb.TPMConnection, pcrs []int) error {
+		blockCalls++
+		c.Check(tpm, Equals, mockSbTPM)
+		c.Check(pcrs, DeepEquals, []int{12})
+		return blockErr
+	})
+	defer restore()
+
+	// nothing happens when not armed
+	c.Check(secboot.TPMSealedKeysLockArmed(), Equals, false)
+	c.Assert(secboot.LockTPMSealedKeysIfArmed(), IsNil)
+	c.Check(blockCalls, Equals, 0)
+
+	c.Assert(secboot.ArmTPMSealedKeysLock(), IsNil)
+	c.Check(secboot.TPMSealedKeysLockArmed(), Equals, true)
+	c.Check(filepath.Join(dirs.SnapBootstrapRunDir, "tpm-sealed-keys-lock-armed"), testutil.FilePresent)
+
+	// the lock stays armed when locking failed
+	err := secboot.LockTPMSealedKeysIfArmed()
+	c.Assert(err, ErrorMatches, "block failed")
+	c.Check(blockCalls, Equals, 1)
+	c.Check(secboot.TPMSealedKeysLockArmed(), Equals, true)
+
+	blockErr = nil
+	c.Assert(secboot.LockTPMSealedKeysIfArmed(), IsNil)
+	c.Check(blockCalls, Equals, 2)
+	c.Check(secboot.TPMSealedKeysLockArmed(), Equals, false)
+
+	// and is disarmed now
+	c.Assert(secboot.LockTPMSealedKeysIfArmed(), IsNil)
+	c.Check(blockCalls, Equals, 2)
+}
+
 func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncrypted(c *C) {
 
 	// setup mock disks to use for locating the partition
@@ -575,6 +654,12 @@ func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncrypted(c *C) {
 		})
 		defer restore()
 
+		restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+			c.Check(device, Equals, devicePath)
+			return "luks-uuid", nil
+		})
+		defer restore()
+
 		opts := &secboot.UnlockVolumeUsingSealedKeyOptions{
 			LockKeysOnFinish: tc.lockRequest,
 			AllowRecoveryKey: tc.rkAllow,
@@ -583,10 +668,16 @@ func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncrypted(c *C) {
 		if tc.err == "" {
 			c.Assert(err, IsNil)
 			c.Assert(unlockRes.IsDecryptedDevice, Equals, tc.hasEncdev)
+			c.Check(unlockRes.PartUUID, Equals, partuuid)
+			c.Check(unlockRes.PartDevice, Equals, devicePath)
 			if tc.hasEncdev {
 				c.Assert(unlockRes.Device, Equals, filepath.Join("/dev/mapper", defaultDevice+"-"+randomUUID))
+				c.Check(unlockRes.MapperName, Equals, defaultDevice+"-"+randomUUID)
+				c.Check(unlockRes.LUKSUUID, Equals, "luks-uuid")
 			} else {
 				c.Assert(unlockRes.Device, Equals, devicePath)
+				c.Check(unlockRes.MapperName, Equals, "")
+				c.Check(unlockRes.LUKSUUID, Equals, "")
 			}
 		} else {
 			c.Assert(err, ErrorMatches, tc.err)
@@ -612,6 +703,252 @@ func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncrypted(c *C) {
 	}
 }
 
+func (s *secbootSuite) TestDefaultKeyProtector(c *C) {
+	p, err := secboot.KeyProtectorByName("")
+	c.Assert(err, IsNil)
+	c.Check(p.Name(), Equals, "tpm2")
+	c.Check(secboot.KeyProtectorNames(), testutil.Contains, "tpm2")
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingKeyProtector(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "data-partuuid",
+		},
+	}
+	p, restore := secboottest.MockPlaintextKeyProtector()
+	defer restore()
+
+	keyFile := filepath.Join(c.MkDir(), "data.sealed-key")
+	var key secboot.EncryptionKey
+	copy(key[:], "key")
+	err := p.SealKeys([]secboot.SealKeyRequest{{Key: key, KeyFile: keyFile}}, nil)
+	c.Assert(err, IsNil)
+
+	for _, tc := range []struct {
+		unsealErr     error
+		allowRecovery bool
+		method        secboot.UnlockMethod
+		err           string
+	}{
+		{method: secboot.UnlockedWithKeyProtector},
+		{
+			unsealErr: errors.New("unseal error"),
+			method:    secboot.NotUnlocked,
+			err:       `cannot activate encrypted device "/dev/disk/by-partuuid/data-partuuid": unseal error`,
+		}, {
+			unsealErr:     errors.New("unseal error"),
+			allowRecovery: true,
+			method:        secboot.UnlockedWithRecoveryKey,
+		},
+	} {
+		p.UnsealErr = tc.unsealErr
+
+		restore := secboot.MockRandomKernelUUID(func() string {
+			return "random-uuid"
+		})
+		defer restore()
+		// the key protector does not need the TPM
+		_, restore = mockSbTPMConnection(c, sb.ErrNoTPM2Device)
+		defer restore()
+		restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+			return "luks-uuid", nil
+		})
+		defer restore()
+		restore = secboot.MockSbActivateVolumeWithTPMSealedKey(func(tpm *sb.TPMConnection, volumeName, sourceDevicePath,
+			keyPath string, pinReader io.Reader, options *sb.ActivateVolumeOptions) (bool, error) {
+			c.Fatalf("unexpected activation with the TPM")
+			return false, nil
+		})
+		defer restore()
+		keyActivations := 0
+		restore = secboot.MockSbActivateVolumeWithKey(func(volumeName, sourceDevicePath string, k []byte,
+			options *sb.ActivateVolumeOptions) error {
+			keyActivations++
+			c.Check(volumeName, Equals, "ubuntu-data-random-uuid")
+			c.Check(sourceDevicePath, Equals, "/dev/disk/by-partuuid/data-partuuid")
+			c.Check(k, DeepEquals, key[:])
+			return nil
+		})
+		defer restore()
+		recoveryActivations := 0
+		restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(volumeName, sourceDevicePath string,
+			keyReader io.Reader, options *sb.ActivateVolumeOptions) error {
+			recoveryActivations++
+			c.Check(volumeName, Equals, "ubuntu-data-random-uuid")
+			return nil
+		})
+		defer restore()
+
+		opts := &secboot.UnlockVolumeUsingSealedKeyOptions{AllowRecoveryKey: tc.allowRecovery}
+		res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", keyFile, opts)
+		if tc.err == "" {
+			c.Assert(err, IsNil)
+			c.Check(res.MapperName, Equals, "ubuntu-data-random-uuid")
+			c.Check(res.LUKSUUID, Equals, "luks-uuid")
+		} else {
+			c.Assert(err, ErrorMatches, tc.err)
+		}
+		c.Check(res.UnlockMethod, Equals, tc.method)
+		if tc.method == secboot.UnlockedWithKeyProtector {
+			c.Check(res.KeyProtector, Equals, p.Name())
+		} else {
+			c.Check(res.KeyProtector, Equals, "")
+		}
+		state, err := secboot.ReadUnlockState()
+		c.Assert(err, IsNil)
+		c.Check(state, DeepEquals, map[string]secboot.UnlockMethod{"ubuntu-data": tc.method})
+		if tc.unsealErr == nil {
+			c.Check(keyActivations, Equals, 1)
+		} else {
+			c.Check(keyActivations, Equals, 0)
+		}
+		if tc.allowRecovery {
+			c.Check(recoveryActivations, Equals, 1)
+		} else {
+			c.Check(recoveryActivations, Equals, 0)
+		}
+	}
+}
+
+func (s *secbootSuite) TestReadLUKSUUID(c *C) {
+	d := c.MkDir()
+
+	hdr := make([]byte, 4096)
+	copy(hdr, "LUKS\xba\xbe\x00\x02")
+	copy(hdr[168:], "4ce7f6bd-0e33-4a76-9a1d-b3b4e1d0a9c8")
+	luksDev := filepath.Join(d, "luks")
+	c.Assert(ioutil.WriteFile(luksDev, hdr, 0644), IsNil)
+	uuid, err := secboot.ReadLUKSUUID(luksDev)
+	c.Assert(err, IsNil)
+	c.Check(uuid, Equals, "4ce7f6bd-0e33-4a76-9a1d-b3b4e1d0a9c8")
+
+	plainDev := filepath.Join(d, "plain")
+	c.Assert(ioutil.WriteFile(plainDev, make([]byte, 4096), 0644), IsNil)
+	_, err = secboot.ReadLUKSUUID(plainDev)
+	c.Check(err, ErrorMatches, "not a LUKS device")
+
+	shortDev := filepath.Join(d, "short")
+	c.Assert(ioutil.WriteFile(shortDev, hdr[:100], 0644), IsNil)
+	_, err = secboot.ReadLUKSUUID(shortDev)
+	c.Check(err, ErrorMatches, "cannot read LUKS header: unexpected EOF")
+}
+
+func (s *secbootSuite) TestReadLUKSIntegrity(c *C) {
+	d := c.MkDir()
+
+	luks2Dev := func(name, metadata string) string {
+		hdr := make([]byte, 4096+12288)
+		copy(hdr, "LUKS\xba\xbe\x00\x02")
+		binary.BigEndian.PutUint64(hdr[8:], uint64(len(hdr)))
+		copy(hdr[4096:], metadata)
+		dev := filepath.Join(d, name)
+		c.Assert(ioutil.WriteFile(dev, hdr, 0644), IsNil)
+		return dev
+	}
+
+	dev := luks2Dev("integrity", `{"segments":{"0":{"type":"crypt","encryption":"aes-xts-plain64","integrity":{"type":"hmac(sha256)","journal_encryption":"none","journal_integrity":"none"}}}}`)
+	integrity, err := secboot.ReadLUKSIntegrity(dev)
+	c.Assert(err, IsNil)
+	c.Check(integrity, Equals, "hmac(sha256)")
+
+	dev = luks2Dev("plain-luks2", `{"segments":{"0":{"type":"crypt","encryption":"aes-xts-plain64"}}}`)
+	integrity, err = secboot.ReadLUKSIntegrity(dev)
+	c.Assert(err, IsNil)
+	c.Check(integrity, Equals, "")
+
+	dev = luks2Dev("garbage", `{"segments":`)
+	_, err = secboot.ReadLUKSIntegrity(dev)
+	c.Check(err, ErrorMatches, "cannot decode LUKS2 metadata: unexpected end of JSON input")
+
+	// LUKS1 has no integrity protection
+	hdr := make([]byte, 4096)
+	copy(hdr, "LUKS\xba\xbe\x00\x01")
+	luks1Dev := filepath.Join(d, "luks1")
+	c.Assert(ioutil.WriteFile(luks1Dev, hdr, 0644), IsNil)
+	integrity, err = secboot.ReadLUKSIntegrity(luks1Dev)
+	c.Assert(err, IsNil)
+	c.Check(integrity, Equals, "")
+
+	// the metadata is truncated
+	copy(hdr, "LUKS\xba\xbe\x00\x02")
+	binary.BigEndian.PutUint64(hdr[8:], 16384)
+	truncatedDev := filepath.Join(d, "truncated")
+	c.Assert(ioutil.WriteFile(truncatedDev, hdr, 0644), IsNil)
+	_, err = secboot.ReadLUKSIntegrity(truncatedDev)
+	c.Check(err, ErrorMatches, "cannot read LUKS2 metadata: EOF")
+
+	binary.BigEndian.PutUint64(hdr[8:], 1<<40)
+	c.Assert(ioutil.WriteFile(truncatedDev, hdr, 0644), IsNil)
+	_, err = secboot.ReadLUKSIntegrity(truncatedDev)
+	c.Check(err, ErrorMatches, "invalid LUKS2 header size 1099511627776")
+
+	plainDev := filepath.Join(d, "plain")
+	c.Assert(ioutil.WriteFile(plainDev, make([]byte, 4096), 0644), IsNil)
+	_, err = secboot.ReadLUKSIntegrity(plainDev)
+	c.Check(err, ErrorMatches, "not a LUKS device")
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncryptedRequireIntegrity(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(tpm *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockSbBlockPCRProtectionPolicies(func(tpm *sb.TPMConnection, pcrs []int) error {
+		return nil
+	})
+	defer restore()
+	activations := 0
+	restore = secboot.MockSbActivateVolumeWithTPMSealedKey(func(tpm *sb.TPMConnection, volumeName, sourceDevicePath,
+		keyPath string, pinReader io.Reader, options *sb.ActivateVolumeOptions) (bool, error) {
+		activations++
+		return true, nil
+	})
+	defer restore()
+
+	for _, tc := range []struct {
+		integrity   string
+		readErr     error
+		activations int
+		err         string
+	}{
+		{integrity: "hmac(sha256)", activations: 1},
+		{integrity: "", err: `cannot unlock "/dev/disk/by-partuuid/123-123-123": volume is not integrity protected`},
+		{readErr: errors.New("boom"), err: `cannot check integrity of "/dev/disk/by-partuuid/123-123-123": boom`},
+	} {
+		activations = 0
+		restore = secboot.MockReadLUKSIntegrity(func(device string) (string, error) {
+			c.Check(device, Equals, "/dev/disk/by-partuuid/123-123-123")
+			return tc.integrity, tc.readErr
+		})
+		defer restore()
+
+		opts := &secboot.UnlockVolumeUsingSealedKeyOptions{RequireIntegrity: true}
+		res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile", opts)
+		if tc.err == "" {
+			c.Assert(err, IsNil)
+			c.Check(res.UnlockMethod, Equals, secboot.UnlockedWithSealedKey)
+		} else {
+			c.Assert(err, ErrorMatches, tc.err)
+			c.Check(res.UnlockMethod, Equals, secboot.NotUnlocked)
+		}
+		c.Check(activations, Equals, tc.activations)
+	}
+}
+
 func (s *secbootSuite) TestEFIImageFromBootFile(c *C) {
 	tmpDir := c.MkDir()
 
@@ -747,8 +1084,9 @@ func (s *secbootSuite) TestSealKey(c *C) {
 							secboot.NewLoadChain(mockBF[3],
 								secboot.NewLoadChain(mockBF[4]))),
 					},
-					KernelCmdlines: []string{"cmdline2", "cmdline3"},
-					Model:          &asserts.Model{},
+					KernelCmdlines:                []string{"cmdline2", "cmdline3"},
+					Model:                         &asserts.Model{},
+					EFISignatureDbUpdateKeystores: []string{"/path/to/db-updates"},
 				},
 			},
 			TPMPolicyAuthKey:       myAuthKey,
@@ -858,8 +1196,10 @@ func (s *secbootSuite) TestSealKey(c *C) {
 			switch addEFISbPolicyCalls {
 			case 1:
 				c.Assert(params.LoadSequences, DeepEquals, sequences1)
+				c.Assert(params.SignatureDbUpdateKeystores, HasLen, 0)
 			case 2:
 				c.Assert(params.LoadSequences, DeepEquals, sequences2)
+				c.Assert(params.SignatureDbUpdateKeystores, DeepEquals, []string{"/path/to/db-updates"})
 			default:
 				c.Error("AddEFISecureBootPolicyProfile shouldn't be called a third time")
 			}
@@ -968,141 +1308,897 @@ func (s *secbootSuite) TestSealKey(c *C) {
 	}
 }
 
-func (s *secbootSuite) TestResealKey(c *C) {
-	mockErr := errors.New("some error")
-
-	for _, tc := range []struct {
-		tpmErr               error
-		tpmEnabled           bool
-		missingFile          bool
-		addEFISbPolicyErr    error
-		addEFIBootManagerErr error
-		addSystemdEFIStubErr error
-		addSnapModelErr      error
-		provisioningErr      error
-		resealErr            error
-		resealCalls          int
-		expectedErr          string
-	}{
-		{tpmErr: mockErr, expectedErr: "cannot connect to TPM: some error"},
-		{tpmEnabled: false, expectedErr: "TPM device is not enabled"},
-		{tpmEnabled: true, missingFile: true, expectedErr: "cannot build EFI image load sequences: file .*/file.efi does not exist"},
-		{tpmEnabled: true, addEFISbPolicyErr: mockErr, expectedErr: "cannot add EFI secure boot policy profile: some error"},
-		{tpmEnabled: true, addEFIBootManagerErr: mockErr, expectedErr: "cannot add EFI boot manager profile: some error"},
-		{tpmEnabled: true, addSystemdEFIStubErr: mockErr, expectedErr: "cannot add systemd EFI stub profile: some error"},
-		{tpmEnabled: true, addSnapModelErr: mockErr, expectedErr: "cannot add snap model profile: some error"},
-		{tpmEnabled: true, resealErr: mockErr, resealCalls: 1, expectedErr: "some error"},
-		{tpmEnabled: true, resealCalls: 1, expectedErr: ""},
-	} {
-		mockTPMPolicyAuthKey := []byte{1, 3, 3, 7}
-		mockTPMPolicyAuthKeyFile := filepath.Join(c.MkDir(), "policy-auth-key-file")
-		err := ioutil.WriteFile(mockTPMPolicyAuthKeyFile, mockTPMPolicyAuthKey, 0600)
-		c.Assert(err, IsNil)
-
-		mockEFI := bootloader.NewBootFile("", filepath.Join(c.MkDir(), "file.efi"), bootloader.RoleRecovery)
-		if !tc.missingFile {
-			err := ioutil.WriteFile(mockEFI.Path, nil, 0644)
-			c.Assert(err, IsNil)
-		}
-
-		myParams := &secboot.ResealKeysParams{
-			ModelParams: []*secboot.SealKeyModelParams{
-				{
-					EFILoadChains:  []*secboot.LoadChain{secboot.NewLoadChain(mockEFI)},
-					KernelCmdlines: []string{"cmdline"},
-					Model:          &asserts.Model{},
-				},
-			},
-			KeyFiles:             []string{"keyfile", "keyfile2"},
-			TPMPolicyAuthKeyFile: mockTPMPolicyAuthKeyFile,
-		}
+func (s *secbootSuite) TestSealKeyEventLogInconsistent(c *C) {
+	tmpDir := c.MkDir()
+	mockEFI := bootloader.NewBootFile("", filepath.Join(tmpDir, "file.efi"), bootloader.RoleRecovery)
+	err := ioutil.WriteFile(mockEFI.Path, nil, 0644)
+	c.Assert(err, IsNil)
 
-		sequences := []*sb.EFIImageLoadEvent{
+	myKeys := []secboot.SealKeyRequest{
+		{
+			Key:     secboot.EncryptionKey{},
+			KeyFile: "keyfile",
+		},
+	}
+	myParams := secboot.SealKeysParams{
+		ModelParams: []*secboot.SealKeyModelParams{
 			{
-				Source: sb.Firmware,
-				Image:  sb.FileEFIImage(mockEFI.Path),
+				EFILoadChains: []*secboot.LoadChain{secboot.NewLoadChain(mockEFI)},
 			},
-		}
+		},
+		TPMPolicyAuthKeyFile: filepath.Join(tmpDir, "policy-auth-key-file"),
+		TPMLockoutAuthFile:   filepath.Join(tmpDir, "lockout-auth-file"),
+		TPMProvision:         true,
+	}
 
-		// mock TPM connection
-		tpm, restore := mockSbTPMConnection(c, tc.tpmErr)
-		defer restore()
+	otherPCRValues := map[int][]byte{
+		4: []byte("pcr4-value"),
+		7: []byte("other-pcr7-value"),
+	}
 
-		// mock TPM enabled check
-		restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool {
-			return tc.tpmEnabled
+	for _, tc := range []struct {
+		replayErr   error
+		replayed    map[int][]byte
+		readErr     error
+		computeErr  error
+		computed    []map[int][]byte
+		errPCR      int
+		expectedErr string
+	}{
+		{
+			replayErr:   &secboot.EventLogError{PCR: -1, Msg: "log is truncated or corrupted: unexpected EOF"},
+			errPCR:      -1,
+			expectedErr: "invalid TCG event log: log is truncated or corrupted: unexpected EOF",
+		},
+		{
+			replayErr:   os.ErrNotExist,
+			errPCR:      -1,
+			expectedErr: "invalid TCG event log: cannot replay event log: file does not exist",
+		},
+		{
+			replayed:    otherPCRValues,
+			errPCR:      7,
+			expectedErr: "invalid TCG event log for PCR 7: event log does not replay to the current PCR value",
+		},
+		{
+			computed:    []map[int][]byte{otherPCRValues},
+			errPCR:      -1,
+			expectedErr: "invalid TCG event log: current PCR values do not match the computed EFI profile",
+		},
+		{
+			readErr:     errors.New("some error"),
+			expectedErr: "cannot read PCR values: some error",
+		},
+		{
+			computeErr:  errors.New("some error"),
+			expectedErr: "cannot compute PCR values from profile: some error",
+		},
+	} {
+		_, restore := mockSbTPMConnection(c, nil)
+		defer restore()
+		restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+		defer restore()
+		restore = secboot.MockSbAddEFISecureBootPolicyProfile(func(*sb.PCRProtectionProfile, *sb.EFISecureBootPolicyProfileParams) error {
+			return nil
 		})
 		defer restore()
-
-		// mock adding EFI secure boot policy profile
-		var pcrProfile *sb.PCRProtectionProfile
-		addEFISbPolicyCalls := 0
-		restore = secboot.MockSbAddEFISecureBootPolicyProfile(func(profile *sb.PCRProtectionProfile, params *sb.EFISecureBootPolicyProfileParams) error {
-			addEFISbPolicyCalls++
-			pcrProfile = profile
-			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
-			c.Assert(params.LoadSequences, DeepEquals, sequences)
-			return tc.addEFISbPolicyErr
+		restore = secboot.MockSbAddEFIBootManagerProfile(func(*sb.PCRProtectionProfile, *sb.EFIBootManagerProfileParams) error {
+			return nil
 		})
 		defer restore()
 
-		// mock adding EFI boot manager profile
-		addEFIBootManagerCalls := 0
-		restore = secboot.MockSbAddEFIBootManagerProfile(func(profile *sb.PCRProtectionProfile, params *sb.EFIBootManagerProfileParams) error {
-			addEFIBootManagerCalls++
-			c.Assert(profile, Equals, pcrProfile)
-			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
-			c.Assert(params.LoadSequences, DeepEquals, sequences)
-			return tc.addEFIBootManagerErr
+		restore = secboot.MockReplayEventLog(func(path string) (map[int][]byte, error) {
+			c.Check(path, Equals, filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements"))
+			if tc.replayed != nil {
+				return tc.replayed, nil
+			}
+			return mockPCRValues, tc.replayErr
 		})
 		defer restore()
-
-		// mock adding systemd EFI stub profile
-		addSystemdEfiStubCalls := 0
-		restore = secboot.MockSbAddSystemdEFIStubProfile(func(profile *sb.PCRProtectionProfile, params *sb.SystemdEFIStubProfileParams) error {
-			addSystemdEfiStubCalls++
-			c.Assert(profile, Equals, pcrProfile)
-			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
-			c.Assert(params.PCRIndex, Equals, 12)
-			c.Assert(params.KernelCmdlines, DeepEquals, myParams.ModelParams[0].KernelCmdlines)
-			return tc.addSystemdEFIStubErr
+		restore = secboot.MockReadPCRValues(func(tpm *sb.TPMConnection, pcrs []int) (map[int][]byte, error) {
+			c.Check(pcrs, DeepEquals, []int{4, 7})
+			return mockPCRValues, tc.readErr
 		})
 		defer restore()
-
-		// mock adding snap model profile
-		addSnapModelCalls := 0
-		restore = secboot.MockSbAddSnapModelProfile(func(profile *sb.PCRProtectionProfile, params *sb.SnapModelProfileParams) error {
-			addSnapModelCalls++
-			c.Assert(profile, Equals, pcrProfile)
-			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
-			c.Assert(params.PCRIndex, Equals, 12)
-			c.Assert(params.Models[0], DeepEquals, myParams.ModelParams[0].Model)
-			return tc.addSnapModelErr
+		restore = secboot.MockComputeProfilePCRValues(func(*sb.TPMConnection, *sb.PCRProtectionProfile) ([]map[int][]byte, error) {
+			if tc.computed != nil {
+				return tc.computed, nil
+			}
+			return []map[int][]byte{otherPCRValues, mockPCRValues}, tc.computeErr
 		})
 		defer restore()
 
-		// mock PCR protection policy update
-		resealCalls := 0
-		restore = secboot.MockSbUpdateKeyPCRProtectionPolicyMultiple(func(t *sb.TPMConnection, keyPaths []string, authKey sb.TPMPolicyAuthKey, profile *sb.PCRProtectionProfile) error {
-			resealCalls++
-			c.Assert(t, Equals, tpm)
-			c.Assert(keyPaths, DeepEquals, []string{"keyfile", "keyfile2"})
-			c.Assert(authKey, DeepEquals, sb.TPMPolicyAuthKey(mockTPMPolicyAuthKey))
-			c.Assert(profile, Equals, pcrProfile)
-			return tc.resealErr
+		restore = secboot.MockProvisionTPM(func(t *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+			c.Error("unexpected provisioning call")
+			return nil
+		})
+		defer restore()
+		restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+			c.Error("unexpected sealing call")
+			return nil, nil
 		})
 		defer restore()
 
-		err = secboot.ResealKeys(myParams)
-		if tc.expectedErr == "" {
+		err := secboot.SealKeys(myKeys, &myParams)
+		c.Assert(err, ErrorMatches, tc.expectedErr)
+		if elErr, ok := err.(*secboot.EventLogError); ok {
+			c.Check(elErr.PCR, Equals, tc.errPCR)
+		} else {
+			c.Check(tc.errPCR, Equals, 0)
+		}
+		c.Check(myParams.TPMLockoutAuthFile, testutil.FileAbsent)
+	}
+}
+
+func (s *secbootSuite) mockSealingWithTPMInfo(c *C, info *secboot.TPMInfo) (myKeys []secboot.SealKeyRequest, myParams *secboot.SealKeysParams) {
+	tmpDir := c.MkDir()
+	mockEFI := bootloader.NewBootFile("", filepath.Join(tmpDir, "file.efi"), bootloader.RoleRecovery)
+	err := ioutil.WriteFile(mockEFI.Path, nil, 0644)
+	c.Assert(err, IsNil)
+
+	myKeys = []secboot.SealKeyRequest{
+		{
+			Key:     secboot.EncryptionKey{},
+			KeyFile: "keyfile",
+		},
+	}
+	myParams = &secboot.SealKeysParams{
+		ModelParams: []*secboot.SealKeyModelParams{
+			{
+				EFILoadChains: []*secboot.LoadChain{secboot.NewLoadChain(mockEFI)},
+			},
+		},
+		TPMLockoutAuthFile: filepath.Join(tmpDir, "lockout-auth-file"),
+		TPMProvision:       true,
+	}
+
+	_, restore := mockSbTPMConnection(c, nil)
+	s.AddCleanup(restore)
+	s.AddCleanup(secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true }))
+	s.AddCleanup(secboot.MockSbAddEFISecureBootPolicyProfile(func(*sb.PCRProtectionProfile, *sb.EFISecureBootPolicyProfileParams) error {
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbAddEFIBootManagerProfile(func(*sb.PCRProtectionProfile, *sb.EFIBootManagerProfileParams) error {
+		return nil
+	}))
+	s.AddCleanup(secboot.MockReadTPMInfo(func(*sb.TPMConnection) (*secboot.TPMInfo, error) {
+		return info, nil
+	}))
+	s.AddCleanup(secboot.MockTPMNVRetry(5, time.Nanosecond))
+	return myKeys, myParams
+}
+
+func (s *secbootSuite) TestSealKeyProvisionsSRK(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+
+	for _, tc := range []struct {
+		template secboot.SRKTemplate
+		handle   uint32
+	}{
+		{template: ""},
+		{template: secboot.SRKTemplateRSA2048},
+		{template: secboot.SRKTemplateECCP256},
+		{handle: 0x81000002},
+	} {
+		calls := 0
+		restore = secboot.MockProvisionSRK(func(tpm *sb.TPMConnection, template secboot.SRKTemplate, vendorHandle uint32) error {
+			calls++
+			c.Check(template, Equals, tc.template)
+			c.Check(vendorHandle, Equals, tc.handle)
+			return nil
+		})
+		defer restore()
+
+		myParams.TPMSRKTemplate = tc.template
+		myParams.TPMSRKHandle = tc.handle
+		err := secboot.SealKeys(myKeys, myParams)
+		c.Assert(err, IsNil)
+		c.Check(calls, Equals, 1)
+	}
+}
+
+func (s *secbootSuite) TestSealKeyProvisionSRKError(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+	myParams.TPMSRKHandle = 0x81000002
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockProvisionSRK(func(tpm *sb.TPMConnection, template secboot.SRKTemplate, vendorHandle uint32) error {
+		return errors.New("cannot access the key at handle 0x81000002: handle not found")
+	})
+	defer restore()
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		c.Error("unexpected sealing call")
+		return nil, nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot provision the storage root key: cannot access the key at handle 0x81000002: handle not found")
+}
+
+func (s *secbootSuite) TestSealKeyUnsupportedSRKTemplate(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+	myParams.TPMSRKTemplate = "rsa-4096"
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		c.Error("unexpected provisioning call")
+		return nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, `unsupported storage root key template "rsa-4096"`)
+}
+
+func (s *secbootSuite) TestSealKeyClearsTPM(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+	myParams.TPMClear = true
+	err := ioutil.WriteFile(myParams.TPMLockoutAuthFile, []byte("old-lockout-auth"), 0600)
+	c.Assert(err, IsNil)
+
+	var lockoutAuth []byte
+	restore := secboot.MockSetLockoutAuth(func(tpm *sb.TPMConnection, auth []byte) {
+		lockoutAuth = auth
+	})
+	defer restore()
+	provisionCalls := 0
+	restore = secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		provisionCalls++
+		// the TPM is cleared with the previous lockout authorization
+		c.Check(lockoutAuth, DeepEquals, []byte("old-lockout-auth"))
+		c.Check(mode, Equals, sb.ProvisionModeClear)
+		c.Check(myParams.TPMLockoutAuthFile, testutil.FileEquals, newLockoutAuth)
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(provisionCalls, Equals, 1)
+	c.Check(myParams.TPMLockoutAuthFile, Not(testutil.FileEquals), "old-lockout-auth")
+
+	// the TPM cannot be cleared without the previous lockout authorization
+	c.Assert(os.Remove(myParams.TPMLockoutAuthFile), IsNil)
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot read the lockout authorization file: .* no such file or directory")
+	c.Check(provisionCalls, Equals, 1)
+}
+
+var errMockNVRate = errors.New("NV rate")
+
+func (s *secbootSuite) TestSealKeyRetriesOnSlowNVWrites(c *C) {
+	// AMD fTPM
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x414d4400, []string{"sha256"}))
+
+	restore := secboot.MockIsTPMNVRateError(func(err error) bool { return err == errMockNVRate })
+	defer restore()
+
+	provisionCalls := 0
+	restore = secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		provisionCalls++
+		if provisionCalls < 3 {
+			return errMockNVRate
+		}
+		return nil
+	})
+	defer restore()
+	sealCalls := 0
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		sealCalls++
+		if sealCalls < 2 {
+			return nil, errMockNVRate
+		}
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(provisionCalls, Equals, 3)
+	c.Check(sealCalls, Equals, 2)
+}
+
+func (s *secbootSuite) TestSealKeyRetriesOnSlowNVWritesGivesUp(c *C) {
+	// Pluton
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x4d534654, []string{"sha256"}))
+
+	restore := secboot.MockIsTPMNVRateError(func(err error) bool { return err == errMockNVRate })
+	defer restore()
+
+	provisionCalls := 0
+	restore = secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		provisionCalls++
+		return errMockNVRate
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot provision TPM: NV rate")
+	c.Check(provisionCalls, Equals, 5)
+}
+
+func (s *secbootSuite) TestSealKeyNoRetriesWithoutQuirk(c *C) {
+	// discrete TPM
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x49465800, []string{"sha256"}))
+
+	restore := secboot.MockIsTPMNVRateError(func(err error) bool { return err == errMockNVRate })
+	defer restore()
+
+	provisionCalls := 0
+	restore = secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		provisionCalls++
+		return errMockNVRate
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot provision TPM: NV rate")
+	c.Check(provisionCalls, Equals, 1)
+}
+
+func (s *secbootSuite) TestSealKeyNoSHA256Bank(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x494e5443, []string{"sha1"}))
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		c.Fatalf("unexpected provisioning")
+		return nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, `TPM does not have an allocated SHA-256 PCR bank \(allocated: sha1\)`)
+}
+
+func (s *secbootSuite) TestSealKeyUnidentifiedTPM(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, nil)
+	// the TPM cannot be identified, sealing goes ahead without quirks
+	restore := secboot.MockReadTPMInfo(func(*sb.TPMConnection) (*secboot.TPMInfo, error) {
+		return nil, errors.New("cannot read")
+	})
+	defer restore()
+	restore = secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	sealCalls := 0
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		sealCalls++
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(sealCalls, Equals, 1)
+}
+
+func (s *secbootSuite) mockSealingNoProvision(c *C) {
+	s.AddCleanup(secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	}))
+	s.AddCleanup(secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	}))
+}
+
+func (s *secbootSuite) TestSealKeyVerifiesEK(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x49465800, []string{"sha256"}))
+	s.mockSealingNoProvision(c)
+
+	tpm, restore := mockSbTPMConnection(c, nil)
+	restore()
+	restore = secboot.MockSbConnectToDefaultTPM(func() (*sb.TPMConnection, error) {
+		c.Fatalf("unexpected unverified connection")
+		return nil, nil
+	})
+	defer restore()
+	secureConnects := 0
+	restore = secboot.MockSbSecureConnectToDefaultTPM(func(ekCertDataReader io.Reader, endorsementAuth []byte) (*sb.TPMConnection, error) {
+		secureConnects++
+		data, err := ioutil.ReadAll(ekCertDataReader)
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, "ek-cert-data")
+		c.Check(endorsementAuth, IsNil)
+		return tpm, nil
+	})
+	defer restore()
+
+	myParams.TPMEKCertFile = filepath.Join(c.MkDir(), "ek-cert")
+	err := ioutil.WriteFile(myParams.TPMEKCertFile, []byte("ek-cert-data"), 0644)
+	c.Assert(err, IsNil)
+
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(secureConnects, Equals, 1)
+}
+
+func (s *secbootSuite) TestSealKeyVerifyEKError(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x49465800, []string{"sha256"}))
+	s.mockSealingNoProvision(c)
+
+	restore := secboot.MockSbSecureConnectToDefaultTPM(func(ekCertDataReader io.Reader, endorsementAuth []byte) (*sb.TPMConnection, error) {
+		return nil, errors.New("cannot verify TPM")
+	})
+	defer restore()
+
+	myParams.TPMEKCertFile = filepath.Join(c.MkDir(), "ek-cert")
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot connect to TPM: cannot read endorsement key certificate file: open .*/ek-cert: no such file or directory")
+
+	err = ioutil.WriteFile(myParams.TPMEKCertFile, []byte("ek-cert-data"), 0644)
+	c.Assert(err, IsNil)
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot connect to TPM: cannot verify TPM")
+}
+
+func (s *secbootSuite) TestSealKeyEmptyEKCertVirtualTPM(c *C) {
+	// swtpm
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x49424d00, []string{"sha256"}))
+	s.mockSealingNoProvision(c)
+
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+	restore = secboot.MockSbSecureConnectToDefaultTPM(func(ekCertDataReader io.Reader, endorsementAuth []byte) (*sb.TPMConnection, error) {
+		c.Fatalf("unexpected verified connection")
+		return nil, nil
+	})
+	defer restore()
+
+	myParams.TPMEKCertFile = filepath.Join(c.MkDir(), "ek-cert")
+	err := ioutil.WriteFile(myParams.TPMEKCertFile, nil, 0644)
+	c.Assert(err, IsNil)
+
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(logbuf.String(), testutil.Contains, "endorsement key certificate file "+myParams.TPMEKCertFile+" is empty, not verifying the virtual TPM")
+	c.Check(logbuf.String(), testutil.Contains, "sealing keys to a virtual TPM (IBM)")
+}
+
+func (s *secbootSuite) TestSealKeyEmptyEKCertHardwareTPM(c *C) {
+	// Infineon discrete TPM
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x49465800, []string{"sha256"}))
+	sealCalls := 0
+	s.AddCleanup(secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		sealCalls++
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	}))
+
+	myParams.TPMEKCertFile = filepath.Join(c.MkDir(), "ek-cert")
+	err := ioutil.WriteFile(myParams.TPMEKCertFile, nil, 0644)
+	c.Assert(err, IsNil)
+
+	// an empty certificate does not downgrade to an unverified connection
+	err = secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, ErrorMatches, "cannot connect to TPM: cannot verify TPM: endorsement key certificate file .*/ek-cert is empty")
+	c.Check(sealCalls, Equals, 0)
+}
+
+func (s *secbootSuite) TestSealKeySkipEKVerification(c *C) {
+	// Google Compute Engine vTPM
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x474f4f47, []string{"sha256"}))
+	s.mockSealingNoProvision(c)
+
+	restore := secboot.MockSbSecureConnectToDefaultTPM(func(ekCertDataReader io.Reader, endorsementAuth []byte) (*sb.TPMConnection, error) {
+		c.Fatalf("unexpected verified connection")
+		return nil, nil
+	})
+	defer restore()
+
+	// the certificate is not even looked at
+	myParams.TPMEKCertFile = filepath.Join(c.MkDir(), "ek-cert")
+	myParams.TPMSkipEKVerification = true
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+}
+
+func (s *secbootSuite) TestTPMCapabilities(c *C) {
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockReadTPMInfo(func(*sb.TPMConnection) (*secboot.TPMInfo, error) {
+		return secboot.NewTPMInfo(0x414d4400, []string{"sha1", "sha256"}), nil
+	})
+	defer restore()
+
+	info, err := secboot.TPMCapabilities()
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &secboot.TPMInfo{
+		Manufacturer: "AMD",
+		Vendor:       secboot.TPMVendorAMDfTPM,
+		Firmware:     true,
+		PCRBanks:     []string{"sha1", "sha256"},
+		Quirks: secboot.TPMQuirks{
+			SlowNVWrites:      true,
+			MissingSHA384Bank: true,
+		},
+	})
+
+	_, restore = mockSbTPMConnection(c, errors.New("no tpm"))
+	defer restore()
+	_, err = secboot.TPMCapabilities()
+	c.Assert(err, ErrorMatches, "cannot connect to TPM device: no tpm")
+}
+
+func (s *secbootSuite) TestResealKey(c *C) {
+	mockErr := errors.New("some error")
+
+	for _, tc := range []struct {
+		tpmErr               error
+		tpmEnabled           bool
+		missingFile          bool
+		addEFISbPolicyErr    error
+		addEFIBootManagerErr error
+		addSystemdEFIStubErr error
+		addSnapModelErr      error
+		provisioningErr      error
+		resealErr            error
+		resealCalls          int
+		expectedErr          string
+	}{
+		{tpmErr: mockErr, expectedErr: "cannot connect to TPM: some error"},
+		{tpmEnabled: false, expectedErr: "TPM device is not enabled"},
+		{tpmEnabled: true, missingFile: true, expectedErr: "cannot build EFI image load sequences: file .*/file.efi does not exist"},
+		{tpmEnabled: true, addEFISbPolicyErr: mockErr, expectedErr: "cannot add EFI secure boot policy profile: some error"},
+		{tpmEnabled: true, addEFIBootManagerErr: mockErr, expectedErr: "cannot add EFI boot manager profile: some error"},
+		{tpmEnabled: true, addSystemdEFIStubErr: mockErr, expectedErr: "cannot add systemd EFI stub profile: some error"},
+		{tpmEnabled: true, addSnapModelErr: mockErr, expectedErr: "cannot add snap model profile: some error"},
+		{tpmEnabled: true, resealErr: mockErr, resealCalls: 1, expectedErr: "some error"},
+		{tpmEnabled: true, resealCalls: 1, expectedErr: ""},
+	} {
+		mockTPMPolicyAuthKey := []byte{1, 3, 3, 7}
+		mockTPMPolicyAuthKeyFile := filepath.Join(c.MkDir(), "policy-auth-key-file")
+		err := ioutil.WriteFile(mockTPMPolicyAuthKeyFile, mockTPMPolicyAuthKey, 0600)
+		c.Assert(err, IsNil)
+
+		mockEFI := bootloader.NewBootFile("", filepath.Join(c.MkDir(), "file.efi"), bootloader.RoleRecovery)
+		if !tc.missingFile {
+			err := ioutil.WriteFile(mockEFI.Path, nil, 0644)
+			c.Assert(err, IsNil)
+		}
+
+		myParams := &secboot.ResealKeysParams{
+			ModelParams: []*secboot.SealKeyModelParams{
+				{
+					EFILoadChains:  []*secboot.LoadChain{secboot.NewLoadChain(mockEFI)},
+					KernelCmdlines: []string{"cmdline"},
+					Model:          &asserts.Model{},
+				},
+			},
+			KeyFiles:             []string{"keyfile", "keyfile2"},
+			TPMPolicyAuthKeyFile: mockTPMPolicyAuthKeyFile,
+		}
+
+		sequences := []*sb.EFIImageLoadEvent{
+			{
+				Source: sb.Firmware,
+				Image:  sb.FileEFIImage(mockEFI.Path),
+			},
+		}
+
+		// mock TPM connection
+		tpm, restore := mockSbTPMConnection(c, tc.tpmErr)
+		defer restore()
+
+		// mock TPM enabled check
+		restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool {
+			return tc.tpmEnabled
+		})
+		defer restore()
+
+		// mock adding EFI secure boot policy profile
+		var pcrProfile *sb.PCRProtectionProfile
+		addEFISbPolicyCalls := 0
+		restore = secboot.MockSbAddEFISecureBootPolicyProfile(func(profile *sb.PCRProtectionProfile, params *sb.EFISecureBootPolicyProfileParams) error {
+			addEFISbPolicyCalls++
+			pcrProfile = profile
+			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
+			c.Assert(params.LoadSequences, DeepEquals, sequences)
+			return tc.addEFISbPolicyErr
+		})
+		defer restore()
+
+		// mock adding EFI boot manager profile
+		addEFIBootManagerCalls := 0
+		restore = secboot.MockSbAddEFIBootManagerProfile(func(profile *sb.PCRProtectionProfile, params *sb.EFIBootManagerProfileParams) error {
+			addEFIBootManagerCalls++
+			c.Assert(profile, Equals, pcrProfile)
+			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
+			c.Assert(params.LoadSequences, DeepEquals, sequences)
+			return tc.addEFIBootManagerErr
+		})
+		defer restore()
+
+		// mock adding systemd EFI stub profile
+		addSystemdEfiStubCalls := 0
+		restore = secboot.MockSbAddSystemdEFIStubProfile(func(profile *sb.PCRProtectionProfile, params *sb.SystemdEFIStubProfileParams) error {
+			addSystemdEfiStubCalls++
+			c.Assert(profile, Equals, pcrProfile)
+			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
+			c.Assert(params.PCRIndex, Equals, 12)
+			c.Assert(params.KernelCmdlines, DeepEquals, myParams.ModelParams[0].KernelCmdlines)
+			return tc.addSystemdEFIStubErr
+		})
+		defer restore()
+
+		// mock adding snap model profile
+		addSnapModelCalls := 0
+		restore = secboot.MockSbAddSnapModelProfile(func(profile *sb.PCRProtectionProfile, params *sb.SnapModelProfileParams) error {
+			addSnapModelCalls++
+			c.Assert(profile, Equals, pcrProfile)
+			c.Assert(params.PCRAlgorithm, Equals, tpm2.HashAlgorithmSHA256)
+			c.Assert(params.PCRIndex, Equals, 12)
+			c.Assert(params.Models[0], DeepEquals, myParams.ModelParams[0].Model)
+			return tc.addSnapModelErr
+		})
+		defer restore()
+
+		// mock PCR protection policy update
+		resealCalls := 0
+		restore = secboot.MockSbUpdateKeyPCRProtectionPolicyMultiple(func(t *sb.TPMConnection, keyPaths []string, authKey sb.TPMPolicyAuthKey, profile *sb.PCRProtectionProfile) error {
+			resealCalls++
+			c.Assert(t, Equals, tpm)
+			c.Assert(keyPaths, DeepEquals, []string{"keyfile", "keyfile2"})
+			c.Assert(authKey, DeepEquals, sb.TPMPolicyAuthKey(mockTPMPolicyAuthKey))
+			c.Assert(profile, Equals, pcrProfile)
+			return tc.resealErr
+		})
+		defer restore()
+
+		err = secboot.ResealKeys(myParams)
+		if tc.expectedErr == "" {
+			c.Assert(err, IsNil)
+			c.Assert(addEFISbPolicyCalls, Equals, 1)
+			c.Assert(addSystemdEfiStubCalls, Equals, 1)
+			c.Assert(addSnapModelCalls, Equals, 1)
+		} else {
+			c.Assert(err, ErrorMatches, tc.expectedErr)
+		}
+		c.Assert(resealCalls, Equals, tc.resealCalls)
+	}
+}
+
+func (s *secbootSuite) TestResealKeyUnifiedKernelImage(c *C) {
+	tmpDir := c.MkDir()
+	mockTPMPolicyAuthKeyFile := filepath.Join(tmpDir, "policy-auth-key-file")
+	c.Assert(ioutil.WriteFile(mockTPMPolicyAuthKeyFile, []byte{1, 3, 3, 7}, 0600), IsNil)
+
+	mockBootFile := func(name string) bootloader.BootFile {
+		bf := bootloader.NewBootFile("", filepath.Join(tmpDir, name), bootloader.RoleRecovery)
+		c.Assert(ioutil.WriteFile(bf.Path, nil, 0644), IsNil)
+		return bf
+	}
+	shim := mockBootFile("shim.efi")
+	grub := mockBootFile("grub.efi")
+	kernel := mockBootFile("kernel.efi")
+	uki1 := mockBootFile("uki1.efi")
+	uki2 := mockBootFile("uki2.efi")
+
+	for _, tc := range []struct {
+		chains      []*secboot.LoadChain
+		computeErr  error
+		computed    []string
+		expectedErr string
+	}{
+		{
+			chains: []*secboot.LoadChain{
+				secboot.NewLoadChain(shim,
+					secboot.NewUnifiedKernelImageLoadChain(uki1),
+					secboot.NewUnifiedKernelImageLoadChain(uki2)),
+			},
+			computed: []string{uki1.Path, uki2.Path},
+		}, {
+			chains: []*secboot.LoadChain{
+				secboot.NewLoadChain(shim,
+					secboot.NewUnifiedKernelImageLoadChain(uki1),
+					secboot.NewLoadChain(grub, secboot.NewLoadChain(kernel))),
+			},
+			expectedErr: "cannot mix unified kernel images with other kernel load chains",
+		}, {
+			chains: []*secboot.LoadChain{
+				secboot.NewLoadChain(shim, secboot.NewUnifiedKernelImageLoadChain(uki1)),
+			},
+			computeErr:  errors.New("some error"),
+			computed:    []string{uki1.Path},
+			expectedErr: `cannot compute PCR 11 value of unified kernel image .*/uki1.efi: some error`,
+		}, {
+			chains: []*secboot.LoadChain{
+				secboot.NewLoadChain(shim, &secboot.LoadChain{
+					BootFile:           &uki1,
+					Next:               []*secboot.LoadChain{secboot.NewLoadChain(kernel)},
+					UnifiedKernelImage: true,
+				}),
+			},
+			expectedErr: `cannot build EFI image load sequences: unified kernel image .*/uki1.efi cannot load other images`,
+		},
+	} {
+		_, restore := mockSbTPMConnection(c, nil)
+		defer restore()
+		restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+		defer restore()
+		restore = secboot.MockSbAddEFISecureBootPolicyProfile(func(*sb.PCRProtectionProfile, *sb.EFISecureBootPolicyProfileParams) error {
+			return nil
+		})
+		defer restore()
+		restore = secboot.MockSbAddEFIBootManagerProfile(func(*sb.PCRProtectionProfile, *sb.EFIBootManagerProfileParams) error {
+			return nil
+		})
+		defer restore()
+		var computed []string
+		restore = secboot.MockComputeUnifiedKernelImagePCRValue(func(b *bootloader.BootFile) ([]byte, error) {
+			computed = append(computed, b.Path)
+			return make([]byte, 32), tc.computeErr
+		})
+		defer restore()
+		resealCalls := 0
+		restore = secboot.MockSbUpdateKeyPCRProtectionPolicyMultiple(func(t *sb.TPMConnection, keyPaths []string, authKey sb.TPMPolicyAuthKey, profile *sb.PCRProtectionProfile) error {
+			resealCalls++
+			return nil
+		})
+		defer restore()
+
+		err := secboot.ResealKeys(&secboot.ResealKeysParams{
+			ModelParams: []*secboot.SealKeyModelParams{
+				{EFILoadChains: tc.chains},
+			},
+			KeyFiles:             []string{"keyfile"},
+			TPMPolicyAuthKeyFile: mockTPMPolicyAuthKeyFile,
+		})
+		if tc.expectedErr == "" {
+			c.Check(err, IsNil)
+			c.Check(resealCalls, Equals, 1)
+		} else {
+			c.Check(err, ErrorMatches, tc.expectedErr)
+			c.Check(resealCalls, Equals, 0)
+		}
+		c.Check(computed, DeepEquals, tc.computed)
+	}
+}
+
+func (s *secbootSuite) TestResealKeyMachineOwnerKeys(c *C) {
+	tmpDir := c.MkDir()
+	mockTPMPolicyAuthKeyFile := filepath.Join(tmpDir, "policy-auth-key-file")
+	c.Assert(ioutil.WriteFile(mockTPMPolicyAuthKeyFile, []byte{1, 3, 3, 7}, 0600), IsNil)
+
+	shim := bootloader.NewBootFile("", filepath.Join(tmpDir, "shim.efi"), bootloader.RoleRecovery)
+	kernel := bootloader.NewBootFile("", filepath.Join(tmpDir, "kernel.efi"), bootloader.RoleRecovery)
+
+	for _, tc := range []struct {
+		mokUsed     bool
+		vars        map[string][]byte
+		readErr     error
+		authorities [][]byte
+		branches    int
+		expectedErr string
+	}{
+		{
+			// machine owner keys not in use, nothing is read
+			mokUsed: false,
+		}, {
+			mokUsed:  true,
+			vars:     map[string][]byte{"MokListRT": []byte("mok-list"), "MokSBStateRT": {0}},
+			branches: 1,
+		}, {
+			// images verified with machine owner keys
+			mokUsed:     true,
+			vars:        map[string][]byte{"MokListRT": []byte("mok-list"), "MokSBStateRT": {0}},
+			authorities: [][]byte{bytes.Repeat([]byte{1}, 32)},
+			branches:    1,
+		}, {
+			// a key requested to be enrolled on the next boot
+			mokUsed:  true,
+			vars:     map[string][]byte{"MokNew": signatureList("key"), "MokSBStateRT": {0}},
+			branches: 2,
+		}, {
+			mokUsed:     true,
+			vars:        map[string][]byte{"MokListRT": []byte("mok-list"), "MokNew": []byte("garbage")},
+			expectedErr: "cannot read machine owner key state: cannot parse machine owner key list: truncated signature list",
+		}, {
+			mokUsed:     true,
+			vars:        map[string][]byte{"MokSBStateRT": {0}},
+			expectedErr: "cannot use machine owner keys: no keys are enrolled",
+		}, {
+			mokUsed:     true,
+			vars:        map[string][]byte{"MokListRT": []byte("mok-list"), "MokSBStateRT": {1}},
+			expectedErr: "cannot use machine owner keys: shim validation is disabled",
+		}, {
+			mokUsed:     true,
+			readErr:     errors.New("some error"),
+			expectedErr: "cannot read machine owner key state: some error",
+		},
+	} {
+		_, restore := mockSbTPMConnection(c, nil)
+		defer restore()
+		restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+		defer restore()
+		restore = secboot.MockSbAddEFISecureBootPolicyProfile(func(*sb.PCRProtectionProfile, *sb.EFISecureBootPolicyProfileParams) error {
+			return nil
+		})
+		defer restore()
+		restore = secboot.MockSbAddEFIBootManagerProfile(func(*sb.PCRProtectionProfile, *sb.EFIBootManagerProfileParams) error {
+			return nil
+		})
+		defer restore()
+		var read []string
+		restore = secboot.MockReadMokVariable(func(name string) ([]byte, error) {
+			read = append(read, name)
+			return tc.vars[name], tc.readErr
+		})
+		defer restore()
+		restore = secboot.MockReadMokAuthorityDigests(func(path string) ([][]byte, error) {
+			c.Check(path, Equals, filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements"))
+			return tc.authorities, nil
+		})
+		defer restore()
+		resealCalls := 0
+		restore = secboot.MockSbUpdateKeyPCRProtectionPolicyMultiple(func(t *sb.TPMConnection, keyPaths []string, authKey sb.TPMPolicyAuthKey, profile *sb.PCRProtectionProfile) error {
+			resealCalls++
+			// one alternative per predicted machine owner key state
+			values, err := profile.ComputePCRValues(nil)
 			c.Assert(err, IsNil)
-			c.Assert(addEFISbPolicyCalls, Equals, 1)
-			c.Assert(addSystemdEfiStubCalls, Equals, 1)
-			c.Assert(addSnapModelCalls, Equals, 1)
+			c.Check(values, HasLen, tc.branches)
+			for _, v := range values {
+				if len(tc.authorities) == 0 {
+					_, ok := v[tpm2.HashAlgorithmSHA256][7]
+					c.Check(ok, Equals, false)
+					continue
+				}
+				// the authorities are extended into PCR 7
+				expected := make([]byte, 32)
+				for _, digest := range tc.authorities {
+					h := sha256.New()
+					h.Write(expected)
+					h.Write(digest)
+					expected = h.Sum(nil)
+				}
+				c.Check([]byte(v[tpm2.HashAlgorithmSHA256][7]), DeepEquals, expected)
+			}
+			return nil
+		})
+		defer restore()
+
+		err := secboot.ResealKeys(&secboot.ResealKeysParams{
+			ModelParams: []*secboot.SealKeyModelParams{
+				{
+					EFILoadChains: []*secboot.LoadChain{
+						secboot.NewLoadChain(shim, secboot.NewLoadChain(kernel)),
+					},
+					EFIMachineOwnerKeys: tc.mokUsed,
+				},
+			},
+			KeyFiles:             []string{"keyfile"},
+			TPMPolicyAuthKeyFile: mockTPMPolicyAuthKeyFile,
+		})
+		if tc.expectedErr == "" {
+			c.Check(err, IsNil)
+			c.Check(resealCalls, Equals, 1)
 		} else {
-			c.Assert(err, ErrorMatches, tc.expectedErr)
+			c.Check(err, ErrorMatches, tc.expectedErr)
+			c.Check(resealCalls, Equals, 0)
+		}
+		switch {
+		case !tc.mokUsed:
+			c.Check(read, HasLen, 0)
+		case tc.readErr != nil:
+			c.Check(read, DeepEquals, []string{"MokListRT"})
+		default:
+			c.Check(read, DeepEquals, []string{"MokListRT", "MokListXRT", "MokSBStateRT", "MokNew", "MokDel"})
 		}
-		c.Assert(resealCalls, Equals, tc.resealCalls)
 	}
 }
 
@@ -1152,6 +2248,515 @@ func mockSbTPMConnection(c *C, tpmErr error) (*sb.TPMConnection, func()) {
 	return tpm, restore
 }
 
+type mockAuthRequestor struct {
+	keys      []string
+	triesLeft []int
+	lastErrs  []error
+}
+
+func (r *mockAuthRequestor) RequestRecoveryKey(name, sourceDevicePath string, triesLeft int, lastErr error) (string, error) {
+	r.triesLeft = append(r.triesLeft, triesLeft)
+	r.lastErrs = append(r.lastErrs, lastErr)
+	if len(r.keys) == 0 {
+		return "", errors.New("prompt cancelled")
+	}
+	key := r.keys[0]
+	r.keys = r.keys[1:]
+	return key, nil
+}
+
+func (s *secbootSuite) TestUnlockEncryptedVolumeWithRecoveryKeyAuthRequestor(c *C) {
+	requestor := &mockAuthRequestor{keys: []string{"bad-key", "good-key"}}
+	restore := secboot.MockNewAuthRequestor(func() secboot.AuthRequestor { return requestor })
+	defer restore()
+
+	badKeyErr := errors.New("invalid recovery key")
+	var keys []string
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		c.Check(name, Equals, "name")
+		c.Check(device, Equals, "/dev/vda4")
+		c.Check(*options, DeepEquals, sb.ActivateVolumeOptions{
+			RecoveryKeyTries: 1,
+			KeyringPrefix:    "ubuntu-fde",
+		})
+		key, err := ioutil.ReadAll(keyReader)
+		c.Assert(err, IsNil)
+		keys = append(keys, string(key))
+		if string(key) != "good-key\n" {
+			return badKeyErr
+		}
+		return nil
+	})
+	defer restore()
+
+	err := secboot.UnlockEncryptedVolumeWithRecoveryKey("name", "/dev/vda4")
+	c.Assert(err, IsNil)
+	c.Check(keys, DeepEquals, []string{"bad-key\n", "good-key\n"})
+	c.Check(requestor.triesLeft, DeepEquals, []int{3, 2})
+	c.Check(requestor.lastErrs, DeepEquals, []error{nil, badKeyErr})
+
+	// all tries are used up
+	requestor = &mockAuthRequestor{keys: []string{"bad-key", "bad-key", "bad-key", "good-key"}}
+	keys = nil
+	err = secboot.UnlockEncryptedVolumeWithRecoveryKey("name", "/dev/vda4")
+	c.Assert(err, ErrorMatches, `cannot unlock encrypted device "/dev/vda4": invalid recovery key`)
+	c.Check(keys, HasLen, 3)
+	c.Check(requestor.triesLeft, DeepEquals, []int{3, 2, 1})
+
+	// the prompt fails
+	requestor = &mockAuthRequestor{}
+	err = secboot.UnlockEncryptedVolumeWithRecoveryKey("name", "/dev/vda4")
+	c.Assert(err, ErrorMatches, `cannot unlock encrypted device "/dev/vda4": prompt cancelled`)
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncryptedTuning(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(tpm *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockSbBlockPCRProtectionPolicies(func(tpm *sb.TPMConnection, pcrs []int) error {
+		return nil
+	})
+	defer restore()
+	activations := 0
+	restore = secboot.MockSbActivateVolumeWithTPMSealedKey(func(tpm *sb.TPMConnection, volumeName, sourceDevicePath,
+		keyPath string, pinReader io.Reader, options *sb.ActivateVolumeOptions) (bool, error) {
+		activations++
+		c.Check(*options, DeepEquals, sb.ActivateVolumeOptions{
+			PassphraseTries:  3,
+			RecoveryKeyTries: 5,
+			KeyringPrefix:    "brand-fde",
+		})
+		return true, nil
+	})
+	defer restore()
+
+	opts := &secboot.UnlockVolumeUsingSealedKeyOptions{
+		AllowRecoveryKey: true,
+		KeyringPrefix:    "brand-fde",
+		PassphraseTries:  3,
+		RecoveryKeyTries: 5,
+	}
+	res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile", opts)
+	c.Assert(err, IsNil)
+	c.Check(res.UnlockMethod, Equals, secboot.UnlockedWithSealedKey)
+	c.Check(activations, Equals, 1)
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncryptedFallbackAuthRequestor(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(tpm *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockSbBlockPCRProtectionPolicies(func(tpm *sb.TPMConnection, pcrs []int) error {
+		return nil
+	})
+	defer restore()
+	requestor := &mockAuthRequestor{keys: []string{"bad-key", "good-key"}}
+	restore = secboot.MockNewAuthRequestor(func() secboot.AuthRequestor { return requestor })
+	defer restore()
+	restore = secboot.MockSbActivateVolumeWithTPMSealedKey(func(tpm *sb.TPMConnection, volumeName, sourceDevicePath,
+		keyPath string, pinReader io.Reader, options *sb.ActivateVolumeOptions) (bool, error) {
+		// secboot does not prompt on the console
+		c.Check(*options, DeepEquals, sb.ActivateVolumeOptions{
+			PassphraseTries:  1,
+			RecoveryKeyTries: 0,
+			KeyringPrefix:    "ubuntu-fde",
+		})
+		return false, errors.New("cannot unseal key")
+	})
+	defer restore()
+	var keys []string
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		c.Check(name, Equals, "ubuntu-data-random-uuid-123-123")
+		c.Check(device, Equals, "/dev/disk/by-partuuid/123-123-123")
+		key, err := ioutil.ReadAll(keyReader)
+		c.Assert(err, IsNil)
+		keys = append(keys, string(key))
+		if string(key) != "good-key\n" {
+			return errors.New("invalid recovery key")
+		}
+		return nil
+	})
+	defer restore()
+
+	opts := &secboot.UnlockVolumeUsingSealedKeyOptions{AllowRecoveryKey: true}
+	res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile", opts)
+	c.Assert(err, IsNil)
+	c.Check(res.UnlockMethod, Equals, secboot.UnlockedWithRecoveryKey)
+	c.Check(keys, DeepEquals, []string{"bad-key\n", "good-key\n"})
+	c.Check(requestor.triesLeft, DeepEquals, []int{3, 2})
+
+	// the requestor is not used when the recovery key is not allowed
+	requestor = &mockAuthRequestor{keys: []string{"good-key"}}
+	keys = nil
+	_, err = secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile", &secboot.UnlockVolumeUsingSealedKeyOptions{})
+	c.Assert(err, ErrorMatches, `.*cannot activate encrypted device "/dev/disk/by-partuuid/123-123-123": cannot unseal key`)
+	c.Check(requestor.triesLeft, HasLen, 0)
+	c.Check(keys, HasLen, 0)
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncryptedTuningNoTPM(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	_, restore = mockSbTPMConnection(c, sb.ErrNoTPM2Device)
+	defer restore()
+	activations := 0
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		activations++
+		c.Check(*options, DeepEquals, sb.ActivateVolumeOptions{
+			RecoveryKeyTries: 5,
+			KeyringPrefix:    "brand-fde",
+		})
+		return nil
+	})
+	defer restore()
+
+	opts := &secboot.UnlockVolumeUsingSealedKeyOptions{
+		AllowRecoveryKey: true,
+		KeyringPrefix:    "brand-fde",
+		RecoveryKeyTries: 5,
+	}
+	res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile", opts)
+	c.Assert(err, IsNil)
+	c.Check(res.UnlockMethod, Equals, secboot.UnlockedWithRecoveryKey)
+	c.Check(activations, Equals, 1)
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncryptedAtLocation(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"custom-boot": "456-456-456",
+		},
+		PartitionLabelToPartUUID: map[string]string{
+			"data": "123-123-123",
+			"boot": "456-456-456",
+		},
+		PartUUIDToFilesystemType: map[string]string{
+			"123-123-123": "crypto_LUKS",
+			"456-456-456": "ext4",
+		},
+		PartUUIDToPartitionType: map[string]string{
+			"123-123-123": "0fc63daf-8483-4772-8e79-3d69d8477de4",
+			"456-456-456": "bc13c2ff-59e6-4262-a352-b275fd6f7172",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(tpm *sb.TPMConnection) bool { return true })
+	defer restore()
+	activations := 0
+	restore = secboot.MockSbActivateVolumeWithTPMSealedKey(func(tpm *sb.TPMConnection, volumeName, sourceDevicePath,
+		keyPath string, pinReader io.Reader, options *sb.ActivateVolumeOptions) (bool, error) {
+		activations++
+		c.Check(volumeName, Equals, "ubuntu-data-random-uuid-123-123")
+		c.Check(sourceDevicePath, Equals, "/dev/disk/by-partuuid/123-123-123")
+		return true, nil
+	})
+	defer restore()
+
+	for _, loc := range []secboot.VolumeLocation{
+		{PartitionLabel: "data"},
+		{PartitionUUID: "123-123-123"},
+		{PartitionType: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"},
+	} {
+		res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile",
+			&secboot.UnlockVolumeUsingSealedKeyOptions{Location: loc})
+		c.Assert(err, IsNil)
+		c.Check(res, DeepEquals, secboot.UnlockResult{
+			Device:            "/dev/mapper/ubuntu-data-random-uuid-123-123",
+			IsDecryptedDevice: true,
+			UnlockMethod:      secboot.UnlockedWithSealedKey,
+			PartUUID:          "123-123-123",
+			PartDevice:        "/dev/disk/by-partuuid/123-123-123",
+		})
+	}
+	c.Check(activations, Equals, 3)
+
+	// not encrypted
+	res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-boot", "",
+		&secboot.UnlockVolumeUsingSealedKeyOptions{Location: secboot.VolumeLocation{PartitionLabel: "boot"}})
+	c.Assert(err, IsNil)
+	c.Check(res, DeepEquals, secboot.UnlockResult{
+		Device:       "/dev/disk/by-partuuid/456-456-456",
+		UnlockMethod: secboot.NotUnlocked,
+		PartUUID:     "456-456-456",
+		PartDevice:   "/dev/disk/by-partuuid/456-456-456",
+	})
+	c.Check(activations, Equals, 3)
+
+	for _, tc := range []struct {
+		loc secboot.VolumeLocation
+		err string
+	}{
+		{secboot.VolumeLocation{PartitionLabel: "other"}, `error enumerating partitions for disk to find device "ubuntu-data": partition label "other" not found`},
+		{secboot.VolumeLocation{PartitionUUID: "789"}, `error enumerating partitions for disk to find device "ubuntu-data": partition uuid "789" not found`},
+		{secboot.VolumeLocation{PartitionType: "c12a7328-f81f-11d2-ba4b-00a0c93ec93b"}, `error enumerating partitions for disk to find device "ubuntu-data": partition type "c12a7328-f81f-11d2-ba4b-00a0c93ec93b" not found`},
+		{secboot.VolumeLocation{PartitionUUID: "123-123-123", PartitionLabel: "data"}, `cannot locate device "ubuntu-data" by more than one of partition uuid, partition label and partition type`},
+		{secboot.VolumeLocation{PartitionLabel: "data", PartitionType: "0fc63daf-8483-4772-8e79-3d69d8477de4"}, `cannot locate device "ubuntu-data" by more than one of partition uuid, partition label and partition type`},
+	} {
+		_, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile",
+			&secboot.UnlockVolumeUsingSealedKeyOptions{Location: tc.loc})
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingRecoveryKeyIfEncrypted(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		c.Check(device, Equals, "/dev/disk/by-partuuid/123-123-123")
+		return "luks-uuid", nil
+	})
+	defer restore()
+	activations := 0
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		activations++
+		c.Check(options.RecoveryKeyTries, Equals, 3)
+		c.Check(name, Equals, "ubuntu-data-random-uuid-123-123")
+		c.Check(device, Equals, "/dev/disk/by-partuuid/123-123-123")
+		return nil
+	})
+	defer restore()
+	restore = secboot.MockSbConnectToDefaultTPM(func() (*sb.TPMConnection, error) {
+		c.Fatalf("unexpected TPM connection")
+		return nil, nil
+	})
+	defer restore()
+
+	res, err := secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted(disk, "ubuntu-data", secboot.VolumeLocation{})
+	c.Assert(err, IsNil)
+	c.Check(activations, Equals, 1)
+	c.Check(res, DeepEquals, secboot.UnlockResult{
+		Device:            "/dev/mapper/ubuntu-data-random-uuid-123-123",
+		IsDecryptedDevice: true,
+		UnlockMethod:      secboot.UnlockedWithRecoveryKey,
+		PartUUID:          "123-123-123",
+		PartDevice:        "/dev/disk/by-partuuid/123-123-123",
+		MapperName:        "ubuntu-data-random-uuid-123-123",
+		LUKSUUID:          "luks-uuid",
+	})
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingRecoveryKeyIfEncryptedAtLocation(c *C) {
+	disk := &disks.MockDiskMapping{
+		PartitionLabelToPartUUID: map[string]string{
+			"data": "123-123-123",
+		},
+		PartUUIDToFilesystemType: map[string]string{
+			"123-123-123": "crypto_LUKS",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	activations := 0
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		activations++
+		c.Check(name, Equals, "ubuntu-data-random-uuid-123-123")
+		c.Check(device, Equals, "/dev/disk/by-partuuid/123-123-123")
+		return nil
+	})
+	defer restore()
+
+	res, err := secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted(disk, "ubuntu-data", secboot.VolumeLocation{PartitionLabel: "data"})
+	c.Assert(err, IsNil)
+	c.Check(activations, Equals, 1)
+	c.Check(res.UnlockMethod, Equals, secboot.UnlockedWithRecoveryKey)
+	c.Check(res.PartUUID, Equals, "123-123-123")
+	c.Check(res.Device, Equals, "/dev/mapper/ubuntu-data-random-uuid-123-123")
+
+	// the filesystem label is not looked up
+	_, err = secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted(disk, "ubuntu-data", secboot.VolumeLocation{PartitionLabel: "other"})
+	c.Check(err, ErrorMatches, `error enumerating partitions for disk to find device "ubuntu-data": partition label "other" not found`)
+	c.Check(activations, Equals, 1)
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingSealedKeyIfEncryptedRecordsMetrics(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockReadLUKSUUID(func(device string) (string, error) {
+		return "luks-uuid", nil
+	})
+	defer restore()
+	_, restore = mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(tpm *sb.TPMConnection) bool { return true })
+	defer restore()
+	restore = secboot.MockSbActivateVolumeWithTPMSealedKey(func(tpm *sb.TPMConnection, volumeName, sourceDevicePath,
+		keyPath string, pinReader io.Reader, options *sb.ActivateVolumeOptions) (bool, error) {
+		return true, nil
+	})
+	defer restore()
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	restore = secboot.MockTimeNow(func() time.Time {
+		now = now.Add(250 * time.Millisecond)
+		return now
+	})
+	defer restore()
+
+	res, err := secboot.UnlockVolumeUsingSealedKeyIfEncrypted(disk, "ubuntu-data", "keyfile", nil)
+	c.Assert(err, IsNil)
+	c.Check(res.UnlockMethod, Equals, secboot.UnlockedWithSealedKey)
+	state, err := secboot.ReadUnlockState()
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, map[string]secboot.UnlockMethod{
+		"ubuntu-data": secboot.UnlockedWithSealedKey,
+	})
+
+	// the unlock with the recovery key is recorded too
+	requestor := &mockAuthRequestor{keys: []string{"bad-key", "good-key"}}
+	restore = secboot.MockNewAuthRequestor(func() secboot.AuthRequestor { return requestor })
+	defer restore()
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		key, err := ioutil.ReadAll(keyReader)
+		c.Assert(err, IsNil)
+		if string(key) != "good-key\n" {
+			return errors.New("invalid recovery key")
+		}
+		return nil
+	})
+	defer restore()
+	_, err = secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted(disk, "ubuntu-data", secboot.VolumeLocation{})
+	c.Assert(err, IsNil)
+
+	metrics, err := secboot.ReadUnlockMetrics()
+	c.Assert(err, IsNil)
+	c.Check(metrics, DeepEquals, []*secboot.UnlockMetrics{
+		{
+			Time:           time.Date(2021, 6, 1, 10, 0, 0, 750000000, time.UTC),
+			Volume:         "ubuntu-data",
+			Method:         "sealed-key",
+			UnsealDuration: 250 * time.Millisecond,
+		}, {
+			Time:                time.Date(2021, 6, 1, 10, 0, 1, 0, time.UTC),
+			Volume:              "ubuntu-data",
+			Method:              "recovery-key",
+			RecoveryKeyAttempts: 2,
+		},
+	})
+	state, err = secboot.ReadUnlockState()
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, map[string]secboot.UnlockMethod{
+		"ubuntu-data": secboot.UnlockedWithRecoveryKey,
+	})
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingRecoveryKeyIfEncryptedNotEncrypted(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data": "123-123-123",
+		},
+	}
+	restore := secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		c.Fatalf("unexpected activation")
+		return nil
+	})
+	defer restore()
+
+	res, err := secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted(disk, "ubuntu-data", secboot.VolumeLocation{})
+	c.Assert(err, IsNil)
+	c.Check(res, DeepEquals, secboot.UnlockResult{
+		Device:       "/dev/disk/by-partuuid/123-123-123",
+		UnlockMethod: secboot.NotUnlocked,
+		PartUUID:     "123-123-123",
+		PartDevice:   "/dev/disk/by-partuuid/123-123-123",
+	})
+}
+
+func (s *secbootSuite) TestUnlockVolumeUsingRecoveryKeyIfEncryptedError(c *C) {
+	disk := &disks.MockDiskMapping{
+		FilesystemLabelToPartUUID: map[string]string{
+			"ubuntu-data-enc": "123-123-123",
+		},
+	}
+	restore := secboot.MockRandomKernelUUID(func() string {
+		return "random-uuid-123-123"
+	})
+	defer restore()
+	restore = secboot.MockSbActivateVolumeWithRecoveryKey(func(name, device string, keyReader io.Reader,
+		options *sb.ActivateVolumeOptions) error {
+		return fmt.Errorf("invalid recovery key")
+	})
+	defer restore()
+
+	res, err := secboot.UnlockVolumeUsingRecoveryKeyIfEncrypted(disk, "ubuntu-data", secboot.VolumeLocation{})
+	c.Assert(err, ErrorMatches, `cannot unlock encrypted device "/dev/disk/by-partuuid/123-123-123": invalid recovery key`)
+	c.Check(res.IsDecryptedDevice, Equals, true)
+	c.Check(res.UnlockMethod, Equals, secboot.NotUnlocked)
+}
+
 func (s *secbootSuite) TestUnlockEncryptedVolumeUsingKeyBadDisk(c *C) {
 	disk := &disks.MockDiskMapping{
 		FilesystemLabelToPartUUID: map[string]string{},
@@ -1183,6 +2788,11 @@ func (s *secbootSuite) TestUnlockEncryptedVolumeUsingKeyHappy(c *C) {
 	dev, err := secboot.UnlockEncryptedVolumeUsingKey(disk, "ubuntu-save", []byte("fooo"))
 	c.Assert(err, IsNil)
 	c.Check(dev, Equals, "/dev/mapper/ubuntu-save-random-uuid-123-123")
+	state, err := secboot.ReadUnlockState()
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, map[string]secboot.UnlockMethod{
+		"ubuntu-save": secboot.UnlockedWithKey,
+	})
 }
 
 func (s *secbootSuite) TestUnlockEncryptedVolumeUsingKeyErr(c *C) {
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package secboottest provides helpers for testing code using secboot.
+package secboottest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/secboot"
+)
+
+// PlaintextKeyProtectorName is the name of the plaintext key protector.
+const PlaintextKeyProtectorName = "plaintext"
+
+var plaintextKeyHeader = []byte("PLAINTEXT-KEY-FOR-TESTS\n")
+
+// PlaintextKeyProtector is a key protector for tests which stores the keys
+// unprotected in the key files. It records the parameters it was called with.
+type PlaintextKeyProtector struct {
+	SealCalls   int
+	ResealCalls int
+	UnsealCalls int
+
+	SealErr   error
+	ResealErr error
+	UnsealErr error
+
+	ResealParams *secboot.ResealKeysParams
+}
+
+// ensure PlaintextKeyProtector implements the KeyProtector interface
+var _ secboot.KeyProtector = (*PlaintextKeyProtector)(nil)
+
+// MockPlaintextKeyProtector registers a new plaintext key protector and
+// returns it along with a function to unregister it.
+func MockPlaintextKeyProtector() (p *PlaintextKeyProtector, restore func()) {
+	p = &PlaintextKeyProtector{}
+	secboot.RegisterKeyProtector(p)
+	return p, func() {
+		secboot.UnregisterKeyProtector(PlaintextKeyProtectorName)
+	}
+}
+
+func (p *PlaintextKeyProtector) Name() string {
+	return PlaintextKeyProtectorName
+}
+
+func (p *PlaintextKeyProtector) SealKeys(keys []secboot.SealKeyRequest, params *secboot.SealKeysParams) error {
+	p.SealCalls++
+	if p.SealErr != nil {
+		return p.SealErr
+	}
+	for _, k := range keys {
+		buf := append(append([]byte(nil), plaintextKeyHeader...), k.Key[:]...)
+		if err := osutil.AtomicWriteFile(k.KeyFile, buf, 0600, 0); err != nil {
+			return fmt.Errorf("cannot write key file: %v", err)
+		}
+	}
+	return nil
+}
+
+func (p *PlaintextKeyProtector) ResealKeys(params *secboot.ResealKeysParams) error {
+	p.ResealCalls++
+	p.ResealParams = params
+	if p.ResealErr != nil {
+		return p.ResealErr
+	}
+	for _, keyFile := range params.KeyFiles {
+		if !p.IsSealedKey(keyFile) {
+			return fmt.Errorf("cannot reseal %q: not a plaintext key file", keyFile)
+		}
+	}
+	return nil
+}
+
+func (p *PlaintextKeyProtector) IsSealedKey(keyFile string) bool {
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(buf, plaintextKeyHeader)
+}
+
+func (p *PlaintextKeyProtector) UnsealKey(keyFile string) ([]byte, error) {
+	p.UnsealCalls++
+	if p.UnsealErr != nil {
+		return nil, p.UnsealErr
+	}
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(buf, plaintextKeyHeader) {
+		return nil, fmt.Errorf("cannot unseal %q: not a plaintext key file", keyFile)
+	}
+	return buf[len(plaintextKeyHeader):], nil
+}
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+)
+
+var tpmExtendPCR = extendPCRImpl
+
+func extendPCRImpl(tpm *sb.TPMConnection, pcr int, digest []byte) error {
+	digests := tpm2.TaggedHashList{{HashAlg: tpm2.HashAlgorithmSHA256, Digest: digest}}
+	return tpm.PCRExtend(tpm.PCRHandleContext(pcr), digests, nil)
+}
+
+// seedVerityDigest returns the digest measured to the initramfs PCR for the
+// given root hashes of the dm-verity hash trees of the seed contents, keyed
+// by their path relative to the seed.
+func seedVerityDigest(rootHashes map[string][]byte) []byte {
+	paths := make([]string, 0, len(rootHashes))
+	for path := range rootHashes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	h.Write([]byte("snapd-seed-verity"))
+	for _, path := range paths {
+		h.Write([]byte(path))
+		h.Write([]byte{0})
+		rootHash := sha256.Sum256(rootHashes[path])
+		h.Write(rootHash[:])
+	}
+	return h.Sum(nil)
+}
+
+// MeasureSeedVerityWhenPossible measures the root hashes of the dm-verity
+// hash trees of the seed contents, once they were verified, only if the TPM
+// device is available. It must be called from the initramfs after the snap
+// model was measured when booting a system whose keys were sealed with
+// SealKeyModelParams.SeedVerityRootHashes set. If there's no TPM device
+// success is returned.
+func MeasureSeedVerityWhenPossible(findRootHashes func() (map[string][]byte, error)) error {
+	measure := func(tpm *sb.TPMConnection) error {
+		rootHashes, err := findRootHashes()
+		if err != nil {
+			return err
+		}
+		return tpmExtendPCR(tpm, initramfsPCR, seedVerityDigest(rootHashes))
+	}
+
+	if err := measureWhenPossible(measure); err != nil {
+		return fmt.Errorf("cannot measure seed verity root hashes: %v", err)
+	}
+	return nil
+}
+
+// addSeedVerityProfile binds the PCR profile to the measurement of the given
+// root hashes of the seed contents.
+func addSeedVerityProfile(profile *sb.PCRProtectionProfile, rootHashes map[string][]byte) {
+	profile.ExtendPCR(tpm2.HashAlgorithmSHA256, initramfsPCR, seedVerityDigest(rootHashes))
+}
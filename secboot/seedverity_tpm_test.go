@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+)
+
+var mockSeedVerityRootHashes = map[string][]byte{
+	"snaps/pc-kernel_1.snap": []byte("kernel-root-hash"),
+	"snaps/core20_1.snap":    []byte("base-root-hash"),
+}
+
+func (s *secbootSuite) TestSeedVerityDigest(c *C) {
+	kernel := sha256.Sum256([]byte("kernel-root-hash"))
+	base := sha256.Sum256([]byte("base-root-hash"))
+	h := sha256.New()
+	h.Write([]byte("snapd-seed-verity"))
+	// sorted by path
+	h.Write([]byte("snaps/core20_1.snap\x00"))
+	h.Write(base[:])
+	h.Write([]byte("snaps/pc-kernel_1.snap\x00"))
+	h.Write(kernel[:])
+	c.Check(secboot.SeedVerityDigest(mockSeedVerityRootHashes), DeepEquals, h.Sum(nil))
+
+	// any change to the root hashes changes the digest
+	for _, other := range []map[string][]byte{
+		{"snaps/pc-kernel_1.snap": []byte("kernel-root-hash")},
+		{"snaps/pc-kernel_2.snap": []byte("kernel-root-hash"), "snaps/core20_1.snap": []byte("base-root-hash")},
+		{"snaps/pc-kernel_1.snap": []byte("other-root-hash"), "snaps/core20_1.snap": []byte("base-root-hash")},
+	} {
+		c.Check(secboot.SeedVerityDigest(other), Not(DeepEquals), secboot.SeedVerityDigest(mockSeedVerityRootHashes))
+	}
+}
+
+func (s *secbootSuite) TestMeasureSeedVerityWhenPossible(c *C) {
+	_, restore := mockSbTPMConnection(c, nil)
+	defer restore()
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return true })
+	defer restore()
+
+	extends := 0
+	restore = secboot.MockTPMExtendPCR(func(tpm *sb.TPMConnection, pcr int, digest []byte) error {
+		extends++
+		c.Check(pcr, Equals, 12)
+		c.Check(digest, DeepEquals, secboot.SeedVerityDigest(mockSeedVerityRootHashes))
+		return nil
+	})
+	defer restore()
+
+	findRootHashes := func() (map[string][]byte, error) {
+		return mockSeedVerityRootHashes, nil
+	}
+	err := secboot.MeasureSeedVerityWhenPossible(findRootHashes)
+	c.Assert(err, IsNil)
+	c.Check(extends, Equals, 1)
+
+	// errors verifying the seed are reported
+	err = secboot.MeasureSeedVerityWhenPossible(func() (map[string][]byte, error) {
+		return nil, errors.New("hash tree does not match")
+	})
+	c.Assert(err, ErrorMatches, "cannot measure seed verity root hashes: hash tree does not match")
+	c.Check(extends, Equals, 1)
+
+	// nothing is measured when the TPM is not enabled
+	restore = secboot.MockIsTPMEnabled(func(t *sb.TPMConnection) bool { return false })
+	defer restore()
+	err = secboot.MeasureSeedVerityWhenPossible(findRootHashes)
+	c.Assert(err, IsNil)
+	c.Check(extends, Equals, 1)
+}
+
+func (s *secbootSuite) TestSealKeySeedVerity(c *C) {
+	myKeys, myParams := s.mockSealingWithTPMInfo(c, secboot.NewTPMInfo(0x494e5443, []string{"sha256"}))
+	myParams.ModelParams[0].SeedVerityRootHashes = mockSeedVerityRootHashes
+
+	restore := secboot.MockProvisionTPM(func(tpm *sb.TPMConnection, mode sb.ProvisionMode, newLockoutAuth []byte) error {
+		return nil
+	})
+	defer restore()
+	sealCalls := 0
+	restore = secboot.MockSbSealKeyToTPMMultiple(func(t *sb.TPMConnection, kr []*sb.SealKeyRequest, params *sb.KeyCreationParams) (sb.TPMPolicyAuthKey, error) {
+		sealCalls++
+		// the policy is bound to the seed contents
+		expected := sb.NewPCRProtectionProfile().
+			ExtendPCR(tpm2.HashAlgorithmSHA256, 12, secboot.SeedVerityDigest(mockSeedVerityRootHashes))
+		c.Check(params.PCRProfile, DeepEquals, expected)
+		return sb.TPMPolicyAuthKey{1, 2, 3}, nil
+	})
+	defer restore()
+
+	err := secboot.SealKeys(myKeys, myParams)
+	c.Assert(err, IsNil)
+	c.Check(sealCalls, Equals, 1)
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+)
+
+// srkHandle is the persistent handle of the storage root key from the TCG
+// TPM v2.0 Provisioning Guidance, secboot always seals keys under the key
+// at this handle.
+const srkHandle tpm2.Handle = 0x81000001
+
+var eccSRKTemplate = tpm2.Public{
+	Type:    tpm2.ObjectTypeECC,
+	NameAlg: tpm2.HashAlgorithmSHA256,
+	Attrs:   tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrNoDA | tpm2.AttrRestricted | tpm2.AttrDecrypt,
+	Params: tpm2.PublicParamsU{
+		Data: &tpm2.ECCParams{
+			Symmetric: tpm2.SymDefObject{
+				Algorithm: tpm2.SymObjectAlgorithmAES,
+				KeyBits:   tpm2.SymKeyBitsU{Data: uint16(128)},
+				Mode:      tpm2.SymModeU{Data: tpm2.SymModeCFB}},
+			Scheme:  tpm2.ECCScheme{Scheme: tpm2.ECCSchemeNull},
+			CurveID: tpm2.ECCCurveNIST_P256,
+			KDF:     tpm2.KDFScheme{Scheme: tpm2.KDFAlgorithmNull}}},
+	Unique: tpm2.PublicIDU{Data: &tpm2.ECCPoint{}}}
+
+// provisionSRKImpl replaces the RSA-2048 storage root key created by secboot
+// when provisioning the TPM with one created from the given template, or
+// with the storage root key provisioned by the device vendor at the given
+// handle.
+func provisionSRKImpl(tpm *sb.TPMConnection, template SRKTemplate, vendorHandle uint32) error {
+	var public *tpm2.Public
+	var expectedName tpm2.Name
+	switch {
+	case vendorHandle != 0:
+		if tpm2.Handle(vendorHandle) == srkHandle {
+			// already where secboot expects it
+			return nil
+		}
+		vendorSRK, err := tpm.CreateResourceContextFromTPM(tpm2.Handle(vendorHandle))
+		if err != nil {
+			return fmt.Errorf("cannot access the key at handle %#x: %v", vendorHandle, err)
+		}
+		vendorPublic, name, _, err := tpm.ReadPublic(vendorSRK)
+		if err != nil {
+			return fmt.Errorf("cannot read the public area of the key at handle %#x: %v", vendorHandle, err)
+		}
+		// a persistent object cannot be moved to another persistent
+		// handle, but primary keys are derived from the hierarchy seed
+		// and the template, so recreating the key from its public area
+		// yields the same key as long as no unique data was used
+		switch vendorPublic.Type {
+		case tpm2.ObjectTypeRSA:
+			vendorPublic.Unique = tpm2.PublicIDU{Data: tpm2.PublicKeyRSA{}}
+		case tpm2.ObjectTypeECC:
+			vendorPublic.Unique = tpm2.PublicIDU{Data: &tpm2.ECCPoint{}}
+		default:
+			return fmt.Errorf("cannot use the key at handle %#x: unsupported key type %v", vendorHandle, vendorPublic.Type)
+		}
+		public = vendorPublic
+		expectedName = name
+	case template == SRKTemplateECCP256:
+		public = &eccSRKTemplate
+	default:
+		// secboot already created the RSA-2048 key
+		return nil
+	}
+
+	srk, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), nil, public, nil, nil, tpm.HmacSession())
+	if err != nil {
+		return fmt.Errorf("cannot create the key: %v", err)
+	}
+	defer tpm.FlushContext(srk)
+	if expectedName != nil && !bytes.Equal(srk.Name(), expectedName) {
+		return fmt.Errorf("cannot recreate the key at handle %#x from its public area", vendorHandle)
+	}
+
+	if current, err := tpm.CreateResourceContextFromTPM(srkHandle); err == nil {
+		if _, err := tpm.EvictControl(tpm.OwnerHandleContext(), current, srkHandle, tpm.HmacSession()); err != nil {
+			return fmt.Errorf("cannot evict the key at handle %#x: %v", srkHandle, err)
+		}
+	}
+	if _, err := tpm.EvictControl(tpm.OwnerHandleContext(), srk, srkHandle, tpm.HmacSession()); err != nil {
+		return fmt.Errorf("cannot persist the key at handle %#x: %v", srkHandle, err)
+	}
+	return nil
+}
+
+// evictSRKImpl evicts the persistent storage root key, all the keys sealed
+// under it become unusable.
+func evictSRKImpl(tpm *sb.TPMConnection) error {
+	srk, err := tpm.CreateResourceContextFromTPM(srkHandle)
+	if err != nil {
+		if tpm2.IsResourceUnavailableError(err, srkHandle) {
+			// not provisioned, nothing to do
+			return nil
+		}
+		return err
+	}
+	if _, err := tpm.EvictControl(tpm.OwnerHandleContext(), srk, srkHandle, tpm.HmacSession()); err != nil {
+		return fmt.Errorf("cannot evict the key at handle %#x: %v", srkHandle, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/snapdenv"
+)
+
+// swtpmEnvVar selects the swtpm integration test mode. When set, and snapd
+// runs in testing mode (see snapdenv.Testing), all TPM operations, sealing,
+// resealing and unsealing included, are performed against the swtpm
+// instance listening on the given socket instead of the TPM device of the
+// host. The value is either a path to a unix socket, optionally prefixed
+// with "unix:", or "tcp:host:port".
+//
+// swtpm must be started in socket mode with the TPM already initialised,
+// eg.:
+//
+//	swtpm socket --tpm2 --tpmstate dir=<dir> --flags not-need-init,startup-clear \
+//	    --server type=unixio,path=<socket>
+const swtpmEnvVar = "SNAPD_SECBOOT_SWTPM"
+
+// swtpmAddress returns the network and address of the swtpm socket to use in
+// the integration test mode, ok is false when the mode is not enabled.
+func swtpmAddress() (network, address string, ok bool, err error) {
+	v := os.Getenv(swtpmEnvVar)
+	if v == "" {
+		return "", "", false, nil
+	}
+	if !snapdenv.Testing() {
+		return "", "", false, fmt.Errorf("cannot use swtpm at %q outside of testing mode", v)
+	}
+	switch {
+	case strings.HasPrefix(v, "tcp:"):
+		address = strings.TrimPrefix(v, "tcp:")
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", false, fmt.Errorf("invalid swtpm address %q: %v", v, err)
+		}
+		return "tcp", address, true, nil
+	case strings.HasPrefix(v, "unix:"):
+		address = strings.TrimPrefix(v, "unix:")
+	default:
+		address = v
+	}
+	if address == "" {
+		return "", "", false, fmt.Errorf("invalid swtpm address %q: missing socket path", v)
+	}
+	return "unix", address, true, nil
+}
+
+// commands of the TPM simulator protocol spoken by swtpm (and the reference
+// TPM simulator) on its server socket
+const (
+	simCmdSendCommand uint32 = 8
+	simCmdSessionEnd  uint32 = 20
+)
+
+// swtpmConnTimeout bounds the time a single TPM command may take.
+var swtpmConnTimeout = 2 * time.Minute
+
+var netDial = net.Dial
+
+// swtpmTCTI is a TPM command transmission interface to a swtpm instance
+// using the TPM simulator protocol on a stream socket.
+type swtpmTCTI struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	locality uint8
+	rsp      *bytes.Reader
+}
+
+func openSwtpmTCTI(network, address string) (*swtpmTCTI, error) {
+	conn, err := netDial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to swtpm: %v", err)
+	}
+	return &swtpmTCTI{conn: conn}, nil
+}
+
+// Write submits a complete TPM command and waits for its response, which is
+// then returned by subsequent calls to Read.
+func (t *swtpmTCTI) Write(cmd []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.rsp != nil && t.rsp.Len() > 0 {
+		return 0, errors.New("cannot submit TPM command: previous response not read")
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, simCmdSendCommand)
+	buf.WriteByte(t.locality)
+	binary.Write(&buf, binary.BigEndian, uint32(len(cmd)))
+	buf.Write(cmd)
+
+	t.conn.SetDeadline(time.Now().Add(swtpmConnTimeout))
+	defer t.conn.SetDeadline(time.Time{})
+
+	if _, err := t.conn.Write(buf.Bytes()); err != nil {
+		return 0, fmt.Errorf("cannot send TPM command to swtpm: %v", err)
+	}
+
+	var size uint32
+	if err := binary.Read(t.conn, binary.BigEndian, &size); err != nil {
+		return 0, fmt.Errorf("cannot read TPM response size from swtpm: %v", err)
+	}
+	rsp := make([]byte, size)
+	if _, err := io.ReadFull(t.conn, rsp); err != nil {
+		return 0, fmt.Errorf("cannot read TPM response from swtpm: %v", err)
+	}
+	var ack uint32
+	if err := binary.Read(t.conn, binary.BigEndian, &ack); err != nil {
+		return 0, fmt.Errorf("cannot read TPM response trailer from swtpm: %v", err)
+	}
+	if ack != 0 {
+		return 0, fmt.Errorf("swtpm failed to process TPM command: %d", ack)
+	}
+	t.rsp = bytes.NewReader(rsp)
+	return len(cmd), nil
+}
+
+// Read returns the response to the last command submitted with Write.
+func (t *swtpmTCTI) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(p)
+}
+
+// Close ends the session with swtpm and closes the connection.
+func (t *swtpmTCTI) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// swtpm closes its end on session end, errors are not interesting
+	binary.Write(t.conn, binary.BigEndian, simCmdSessionEnd)
+	return t.conn.Close()
+}
+
+// SetLocality sets the locality used for subsequent commands.
+func (t *swtpmTCTI) SetLocality(locality uint8) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.locality = locality
+	return nil
+}
@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/snapdenv"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type swtpmSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&swtpmSuite{})
+
+func (s *swtpmSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.AddCleanup(snapdenv.MockTesting(true))
+	old, isSet := os.LookupEnv("SNAPD_SECBOOT_SWTPM")
+	s.AddCleanup(func() {
+		if isSet {
+			os.Setenv("SNAPD_SECBOOT_SWTPM", old)
+		} else {
+			os.Unsetenv("SNAPD_SECBOOT_SWTPM")
+		}
+	})
+}
+
+func (s *swtpmSuite) TestSwtpmAddress(c *C) {
+	for _, tc := range []struct {
+		env, network, address string
+		ok                    bool
+		err                   string
+	}{
+		{env: ""},
+		{env: "/run/swtpm.sock", network: "unix", address: "/run/swtpm.sock", ok: true},
+		{env: "unix:/run/swtpm.sock", network: "unix", address: "/run/swtpm.sock", ok: true},
+		{env: "tcp:localhost:2321", network: "tcp", address: "localhost:2321", ok: true},
+		{env: "unix:", err: `invalid swtpm address "unix:": missing socket path`},
+		{env: "tcp:localhost", err: `invalid swtpm address "tcp:localhost": .*missing port.*`},
+	} {
+		os.Setenv("SNAPD_SECBOOT_SWTPM", tc.env)
+		network, address, ok, err := secboot.SwtpmAddress()
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, tc.err, Commentf("%q", tc.env))
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(network, Equals, tc.network)
+		c.Check(address, Equals, tc.address)
+		c.Check(ok, Equals, tc.ok)
+	}
+}
+
+func (s *swtpmSuite) TestSwtpmAddressNotTesting(c *C) {
+	s.AddCleanup(snapdenv.MockTesting(false))
+	os.Setenv("SNAPD_SECBOOT_SWTPM", "/run/swtpm.sock")
+
+	_, _, ok, err := secboot.SwtpmAddress()
+	c.Check(err, ErrorMatches, `cannot use swtpm at "/run/swtpm.sock" outside of testing mode`)
+	c.Check(ok, Equals, false)
+}
+
+func (s *swtpmSuite) mockSwtpm(c *C, serve func(conn net.Conn)) {
+	s.AddCleanup(secboot.MockNetDial(func(network, address string) (net.Conn, error) {
+		c.Check(network, Equals, "unix")
+		c.Check(address, Equals, "/run/swtpm.sock")
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			serve(server)
+		}()
+		return client, nil
+	}))
+}
+
+func (s *swtpmSuite) TestSwtpmTCTIRoundTrip(c *C) {
+	done := make(chan struct{})
+	s.mockSwtpm(c, func(conn net.Conn) {
+		defer close(done)
+		var hdr struct {
+			Cmd      uint32
+			Locality uint8
+			Size     uint32
+		}
+		c.Assert(binary.Read(conn, binary.BigEndian, &hdr), IsNil)
+		c.Check(hdr.Cmd, Equals, uint32(8))
+		c.Check(hdr.Locality, Equals, uint8(3))
+		cmd := make([]byte, hdr.Size)
+		_, err := io.ReadFull(conn, cmd)
+		c.Assert(err, IsNil)
+		c.Check(cmd, DeepEquals, []byte("command"))
+
+		var rsp bytes.Buffer
+		binary.Write(&rsp, binary.BigEndian, uint32(len("response")))
+		rsp.WriteString("response")
+		binary.Write(&rsp, binary.BigEndian, uint32(0))
+		_, err = conn.Write(rsp.Bytes())
+		c.Assert(err, IsNil)
+
+		var end uint32
+		c.Assert(binary.Read(conn, binary.BigEndian, &end), IsNil)
+		c.Check(end, Equals, uint32(20))
+	})
+
+	tcti, err := secboot.OpenSwtpmTCTI("unix", "/run/swtpm.sock")
+	c.Assert(err, IsNil)
+	c.Assert(tcti.SetLocality(3), IsNil)
+
+	n, err := tcti.Write([]byte("command"))
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, len("command"))
+	rsp, err := ioutil.ReadAll(tcti)
+	c.Assert(err, IsNil)
+	c.Check(string(rsp), Equals, "response")
+
+	c.Assert(tcti.Close(), IsNil)
+	<-done
+}
+
+func (s *swtpmSuite) TestSwtpmTCTICommandFailed(c *C) {
+	s.mockSwtpm(c, func(conn net.Conn) {
+		var hdr [9]byte
+		io.ReadFull(conn, hdr[:])
+		io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(hdr[5:])))
+		var rsp bytes.Buffer
+		binary.Write(&rsp, binary.BigEndian, uint32(0))
+		binary.Write(&rsp, binary.BigEndian, uint32(1))
+		conn.Write(rsp.Bytes())
+	})
+
+	tcti, err := secboot.OpenSwtpmTCTI("unix", "/run/swtpm.sock")
+	c.Assert(err, IsNil)
+	_, err = tcti.Write([]byte("command"))
+	c.Check(err, ErrorMatches, "swtpm failed to process TPM command: 1")
+}
+
+func (s *swtpmSuite) TestConnectToSwtpmError(c *C) {
+	s.AddCleanup(secboot.MockNetDial(func(network, address string) (net.Conn, error) {
+		return nil, os.ErrNotExist
+	}))
+
+	tpm, err := secboot.ConnectToSwtpm("unix", "/run/swtpm.sock")
+	c.Check(err, ErrorMatches, "cannot connect to swtpm: file does not exist")
+	c.Check(tpm, IsNil)
+}
@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"errors"
+	"io"
+
+	"github.com/canonical/go-tpm2"
+	sb "github.com/snapcore/secboot"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+// MakeSticky is not supported, there is no resource manager in between.
+func (t *swtpmTCTI) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+// connectToSwtpm returns a connection to the swtpm instance at the given
+// address. Unlike a connection to the TPM device of the host the connection
+// is neither verified against an endorsement key certificate nor protected
+// by a HMAC session, it must only be used for testing.
+func connectToSwtpm(network, address string) (*sb.TPMConnection, error) {
+	tcti, err := openSwtpmTCTI(network, address)
+	if err != nil {
+		return nil, err
+	}
+	tpm, err := tpm2.NewTPMContext(tcti)
+	if err != nil {
+		tcti.Close()
+		return nil, err
+	}
+	return &sb.TPMConnection{TPMContext: tpm}, nil
+}
+
+func init() {
+	network, address, ok, err := swtpmAddress()
+	if err != nil {
+		logger.Noticef("WARNING: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	logger.Noticef("using swtpm at %s:%s for TPM operations", network, address)
+	sbConnectToDefaultTPM = func() (*sb.TPMConnection, error) {
+		return connectToSwtpm(network, address)
+	}
+	sbSecureConnectToDefaultTPM = func(ekCertDataReader io.Reader, endorsementAuth []byte) (*sb.TPMConnection, error) {
+		// swtpm is set up without an EK certificate
+		return connectToSwtpm(network, address)
+	}
+}
@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Minimal client of the Linux TEE subsystem, see include/uapi/linux/tee.h,
+// sufficient to invoke commands of a trusted application taking memory
+// references as parameters.
+
+const (
+	teeIocVersion      = 0x800ca400 // _IOR(0xa4, 0, struct tee_ioctl_version_data)
+	teeIocShmAlloc     = 0xc010a401 // _IOWR(0xa4, 1, struct tee_ioctl_shm_alloc_data)
+	teeIocOpenSession  = 0x8010a402 // _IOR(0xa4, 2, struct tee_ioctl_buf_data)
+	teeIocInvoke       = 0x8010a403 // _IOR(0xa4, 3, struct tee_ioctl_buf_data)
+	teeIocCloseSession = 0x8004a405 // _IOR(0xa4, 5, struct tee_ioctl_close_session_arg)
+
+	teeImplIDOPTEE = 1
+	teeLoginPublic = 0
+
+	teeParamAttrMemrefInput  = 5
+	teeParamAttrMemrefOutput = 6
+
+	// maximum size of the buffers exchanged with the trusted application
+	teeMaxBufferSize = 4096
+)
+
+type teeVersionData struct {
+	ImplID   uint32
+	ImplCaps uint32
+	GenCaps  uint32
+}
+
+type teeShmAllocData struct {
+	Size  uint64
+	Flags uint32
+	ID    int32
+}
+
+type teeBufData struct {
+	BufPtr uint64
+	BufLen uint64
+}
+
+type teeParam struct {
+	Attr uint64
+	A    uint64
+	B    uint64
+	C    uint64
+}
+
+type teeOpenSessionArg struct {
+	UUID      [16]byte
+	ClntUUID  [16]byte
+	ClntLogin uint32
+	CancelID  uint32
+	Session   uint32
+	Ret       uint32
+	RetOrigin uint32
+	NumParams uint32
+}
+
+type teeInvokeArg struct {
+	Func      uint32
+	Session   uint32
+	CancelID  uint32
+	Ret       uint32
+	RetOrigin uint32
+	NumParams uint32
+	Params    [2]teeParam
+}
+
+type teeCloseSessionArg struct {
+	Session uint32
+}
+
+func teeIoctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// teeSharedMem is memory shared with the trusted application.
+type teeSharedMem struct {
+	id  int32
+	fd  int
+	buf []byte
+}
+
+func (m *teeSharedMem) free() {
+	syscall.Munmap(m.buf)
+	syscall.Close(m.fd)
+}
+
+// teeSession is an open session with a trusted application.
+type teeSession struct {
+	dev *os.File
+	id  uint32
+}
+
+// openTEESession opens a session with the trusted application with the given
+// UUID through the given TEE device.
+func openTEESession(devPath string, uuid [16]byte) (*teeSession, error) {
+	dev, err := os.OpenFile(devPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var version teeVersionData
+	if err := teeIoctl(dev.Fd(), teeIocVersion, unsafe.Pointer(&version)); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("cannot get TEE version: %v", err)
+	}
+	if version.ImplID != teeImplIDOPTEE {
+		dev.Close()
+		return nil, fmt.Errorf("unsupported TEE implementation %d", version.ImplID)
+	}
+
+	arg := teeOpenSessionArg{
+		UUID:      uuid,
+		ClntLogin: teeLoginPublic,
+	}
+	buf := teeBufData{
+		BufPtr: uint64(uintptr(unsafe.Pointer(&arg))),
+		BufLen: uint64(unsafe.Sizeof(arg)),
+	}
+	err = teeIoctl(dev.Fd(), teeIocOpenSession, unsafe.Pointer(&buf))
+	runtime.KeepAlive(&arg)
+	if err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("cannot open TEE session: %v", err)
+	}
+	if arg.Ret != 0 {
+		dev.Close()
+		return nil, fmt.Errorf("cannot open TEE session: error %#x from origin %d", arg.Ret, arg.RetOrigin)
+	}
+	return &teeSession{dev: dev, id: arg.Session}, nil
+}
+
+func (s *teeSession) allocSharedMem(size int) (*teeSharedMem, error) {
+	data := teeShmAllocData{Size: uint64(size)}
+	fd, _, errno := syscall.Syscall(syscall.SYS_IOCTL, s.dev.Fd(), teeIocShmAlloc, uintptr(unsafe.Pointer(&data)))
+	if errno != 0 {
+		return nil, fmt.Errorf("cannot allocate TEE shared memory: %v", errno)
+	}
+	buf, err := syscall.Mmap(int(fd), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		syscall.Close(int(fd))
+		return nil, fmt.Errorf("cannot map TEE shared memory: %v", err)
+	}
+	return &teeSharedMem{id: data.ID, fd: int(fd), buf: buf}, nil
+}
+
+// invoke invokes the command of the trusted application with the input
+// buffer as first parameter and returns the content of the output buffer
+// passed as second parameter.
+func (s *teeSession) invoke(cmd uint32, input []byte) ([]byte, error) {
+	if len(input) > teeMaxBufferSize {
+		return nil, fmt.Errorf("TEE command input too large")
+	}
+	in, err := s.allocSharedMem(teeMaxBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	defer in.free()
+	out, err := s.allocSharedMem(teeMaxBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	defer out.free()
+	copy(in.buf, input)
+
+	arg := teeInvokeArg{
+		Func:      cmd,
+		Session:   s.id,
+		NumParams: 2,
+		Params: [2]teeParam{
+			{Attr: teeParamAttrMemrefInput, B: uint64(len(input)), C: uint64(in.id)},
+			{Attr: teeParamAttrMemrefOutput, B: teeMaxBufferSize, C: uint64(out.id)},
+		},
+	}
+	buf := teeBufData{
+		BufPtr: uint64(uintptr(unsafe.Pointer(&arg))),
+		BufLen: uint64(unsafe.Sizeof(arg)),
+	}
+	err = teeIoctl(s.dev.Fd(), teeIocInvoke, unsafe.Pointer(&buf))
+	runtime.KeepAlive(&arg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot invoke TEE command: %v", err)
+	}
+	if arg.Ret != 0 {
+		return nil, fmt.Errorf("TEE command failed: error %#x from origin %d", arg.Ret, arg.RetOrigin)
+	}
+	// the size of the output is updated by the trusted application
+	outLen := arg.Params[1].B
+	if outLen > teeMaxBufferSize {
+		return nil, fmt.Errorf("TEE command output too large")
+	}
+	return append([]byte(nil), out.buf[:outLen]...), nil
+}
+
+func (s *teeSession) close() error {
+	arg := teeCloseSessionArg{Session: s.id}
+	err := teeIoctl(s.dev.Fd(), teeIocCloseSession, unsafe.Pointer(&arg))
+	s.dev.Close()
+	return err
+}
@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// TPMVendor identifies a firmware or virtual TPM implementation with known
+// quirks.
+type TPMVendor string
+
+const (
+	// TPMVendorUnknown is used for TPMs without known quirks, typically
+	// discrete TPMs.
+	TPMVendorUnknown TPMVendor = ""
+	// TPMVendorIntelPTT is the Intel Platform Trust Technology firmware
+	// TPM.
+	TPMVendorIntelPTT TPMVendor = "intel-ptt"
+	// TPMVendorAMDfTPM is the AMD firmware TPM.
+	TPMVendorAMDfTPM TPMVendor = "amd-ftpm"
+	// TPMVendorPluton is the Microsoft Pluton security processor.
+	TPMVendorPluton TPMVendor = "microsoft-pluton"
+	// TPMVendorSWTPM is the swtpm software TPM, as used with QEMU.
+	TPMVendorSWTPM TPMVendor = "swtpm"
+	// TPMVendorGoogleVTPM is the virtual TPM of Google Compute Engine.
+	TPMVendorGoogleVTPM TPMVendor = "google-vtpm"
+)
+
+// TPMQuirks describes known deviations of a TPM implementation that
+// provisioning and sealing need to accommodate.
+type TPMQuirks struct {
+	// SlowNVWrites is set when the NV storage is backed by firmware
+	// flash, in which case NV writes are slow and rate limited and may
+	// need to be retried.
+	SlowNVWrites bool `json:"slow-nv-writes,omitempty"`
+	// MissingSHA384Bank is set when the TPM does not provide a SHA-384
+	// PCR bank, in which case only the SHA-256 bank can be used.
+	MissingSHA384Bank bool `json:"missing-sha384-bank,omitempty"`
+}
+
+// TPMInfo describes the TPM of the device.
+type TPMInfo struct {
+	// Manufacturer is the manufacturer ID reported by the TPM, eg. "INTC".
+	Manufacturer string `json:"manufacturer"`
+	// Vendor identifies a firmware or virtual TPM implementation with
+	// known quirks.
+	Vendor TPMVendor `json:"vendor,omitempty"`
+	// Firmware is set for firmware TPM implementations.
+	Firmware bool `json:"firmware"`
+	// Virtual is set for software TPMs exposed to virtual machines.
+	Virtual bool `json:"virtual,omitempty"`
+	// PCRBanks lists the allocated PCR banks, eg. "sha256".
+	PCRBanks []string `json:"pcr-banks,omitempty"`
+	// Quirks are the known deviations of the implementation.
+	Quirks TPMQuirks `json:"quirks"`
+}
+
+// manufacturer IDs as reported in the TPM_PT_MANUFACTURER property
+const (
+	tpmManufacturerIntel     = 0x494e5443 // "INTC"
+	tpmManufacturerAMD       = 0x414d4400 // "AMD\0"
+	tpmManufacturerMicrosoft = 0x4d534654 // "MSFT"
+	tpmManufacturerIBM       = 0x49424d00 // "IBM\0", used by libtpms
+	tpmManufacturerGoogle    = 0x474f4f47 // "GOOG"
+)
+
+var firmwareTPMs = map[uint32]struct {
+	vendor TPMVendor
+	quirks TPMQuirks
+}{
+	tpmManufacturerIntel: {vendor: TPMVendorIntelPTT},
+	// the AMD fTPM and Pluton keep the NV storage in the SPI flash
+	tpmManufacturerAMD:       {vendor: TPMVendorAMDfTPM, quirks: TPMQuirks{SlowNVWrites: true}},
+	tpmManufacturerMicrosoft: {vendor: TPMVendorPluton, quirks: TPMQuirks{SlowNVWrites: true}},
+}
+
+// virtualTPMs are the TPMs known to be implemented in software for virtual
+// machines. The Hyper-V/Azure vTPM reports the same manufacturer as Pluton
+// and cannot be told apart from it.
+var virtualTPMs = map[uint32]TPMVendor{
+	tpmManufacturerIBM:    TPMVendorSWTPM,
+	tpmManufacturerGoogle: TPMVendorGoogleVTPM,
+}
+
+func tpmManufacturerString(manufacturer uint32) string {
+	b := []byte{
+		byte(manufacturer >> 24),
+		byte(manufacturer >> 16),
+		byte(manufacturer >> 8),
+		byte(manufacturer),
+	}
+	return strings.TrimRight(string(b), "\x00 ")
+}
+
+// newTPMInfo identifies the TPM implementation from its manufacturer ID and
+// the allocated PCR banks.
+func newTPMInfo(manufacturer uint32, pcrBanks []string) *TPMInfo {
+	info := &TPMInfo{
+		Manufacturer: tpmManufacturerString(manufacturer),
+		PCRBanks:     pcrBanks,
+	}
+	if vendor, ok := virtualTPMs[manufacturer]; ok {
+		info.Vendor = vendor
+		info.Virtual = true
+		return info
+	}
+	known, ok := firmwareTPMs[manufacturer]
+	if !ok {
+		return info
+	}
+	info.Vendor = known.vendor
+	info.Firmware = true
+	info.Quirks = known.quirks
+	info.Quirks.MissingSHA384Bank = !strutil.ListContains(pcrBanks, "sha384")
+	return info
+}
+
+var (
+	tpmNVRetryAttempts = 5
+	tpmNVRetryDelay    = 500 * time.Millisecond
+)
+
+// retryOnNVRate runs the operation, retrying it with an increasing delay
+// while it fails because NV writes are rate limited, when the TPM is known
+// to have slow NV writes.
+func retryOnNVRate(quirks TPMQuirks, isNVRateErr func(error) bool, what string, op func() error) error {
+	delay := tpmNVRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !quirks.SlowNVWrites || !isNVRateErr(err) || attempt == tpmNVRetryAttempts {
+			return err
+		}
+		logger.Noticef("%s: TPM NV writes are rate limited, retrying in %v", what, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+)
+
+type tpmQuirksSuite struct{}
+
+var _ = Suite(&tpmQuirksSuite{})
+
+func (s *tpmQuirksSuite) TestNewTPMInfo(c *C) {
+	for _, tc := range []struct {
+		manufacturer uint32
+		banks        []string
+		info         *secboot.TPMInfo
+	}{
+		{
+			// Intel PTT
+			manufacturer: 0x494e5443,
+			banks:        []string{"sha1", "sha256"},
+			info: &secboot.TPMInfo{
+				Manufacturer: "INTC",
+				Vendor:       secboot.TPMVendorIntelPTT,
+				Firmware:     true,
+				PCRBanks:     []string{"sha1", "sha256"},
+				Quirks:       secboot.TPMQuirks{MissingSHA384Bank: true},
+			},
+		}, {
+			// AMD fTPM
+			manufacturer: 0x414d4400,
+			banks:        []string{"sha256", "sha384"},
+			info: &secboot.TPMInfo{
+				Manufacturer: "AMD",
+				Vendor:       secboot.TPMVendorAMDfTPM,
+				Firmware:     true,
+				PCRBanks:     []string{"sha256", "sha384"},
+				Quirks:       secboot.TPMQuirks{SlowNVWrites: true},
+			},
+		}, {
+			// Pluton
+			manufacturer: 0x4d534654,
+			banks:        []string{"sha256"},
+			info: &secboot.TPMInfo{
+				Manufacturer: "MSFT",
+				Vendor:       secboot.TPMVendorPluton,
+				Firmware:     true,
+				PCRBanks:     []string{"sha256"},
+				Quirks:       secboot.TPMQuirks{SlowNVWrites: true, MissingSHA384Bank: true},
+			},
+		}, {
+			// swtpm
+			manufacturer: 0x49424d00,
+			banks:        []string{"sha1", "sha256", "sha384", "sha512"},
+			info: &secboot.TPMInfo{
+				Manufacturer: "IBM",
+				Vendor:       secboot.TPMVendorSWTPM,
+				Virtual:      true,
+				PCRBanks:     []string{"sha1", "sha256", "sha384", "sha512"},
+			},
+		}, {
+			// Google Compute Engine vTPM
+			manufacturer: 0x474f4f47,
+			banks:        []string{"sha1", "sha256"},
+			info: &secboot.TPMInfo{
+				Manufacturer: "GOOG",
+				Vendor:       secboot.TPMVendorGoogleVTPM,
+				Virtual:      true,
+				PCRBanks:     []string{"sha1", "sha256"},
+			},
+		}, {
+			// discrete Infineon TPM
+			manufacturer: 0x49465800,
+			banks:        []string{"sha256"},
+			info: &secboot.TPMInfo{
+				Manufacturer: "IFX",
+				PCRBanks:     []string{"sha256"},
+			},
+		},
+	} {
+		c.Check(secboot.NewTPMInfo(tc.manufacturer, tc.banks), DeepEquals, tc.info)
+	}
+}
@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"crypto/sha256"
+	"debug/pe"
+	"fmt"
+	"io"
+)
+
+// ukiPCR is the TPM PCR that systemd-stub extends with the sections of the
+// unified kernel image it boots.
+const ukiPCR = 11
+
+// ukiMeasuredSections are the PE sections of a unified kernel image that
+// systemd-stub measures, in the order they are measured.
+var ukiMeasuredSections = []string{
+	".linux",
+	".osrel",
+	".cmdline",
+	".initrd",
+	".splash",
+	".dtb",
+	".uname",
+	".sbat",
+	".pcrpkey",
+}
+
+// ukiInitrdPhases are the boot phases that systemd-pcrphase measures to the
+// UKI PCR in the initrd before the keys may be unsealed, in order.
+var ukiInitrdPhases = []string{
+	"enter-initrd",
+}
+
+// extendSHA256PCR returns the value of a SHA-256 PCR with the given value
+// after it is extended with the digest of the given data.
+func extendSHA256PCR(pcr, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	h := sha256.New()
+	h.Write(pcr)
+	h.Write(digest[:])
+	return h.Sum(nil)
+}
+
+// ukiPCRPhaseValues returns the values the UKI PCR may have when the keys are
+// unsealed, given its value as left by systemd-stub. systemd-pcrphase only
+// measures the boot phases if it is part of the initrd, and it may do so
+// before or after the keys are unsealed, so the value before each of the
+// initrd phases is accepted too.
+func ukiPCRPhaseValues(value []byte) [][]byte {
+	values := [][]byte{value}
+	for _, phase := range ukiInitrdPhases {
+		value = extendSHA256PCR(value, []byte(phase))
+		values = append(values, value)
+	}
+	return values
+}
+
+// ukiPCRValue computes the SHA-256 value of the UKI PCR as left by
+// systemd-stub after booting the given unified kernel image. For each
+// measured section present in the image, the PCR is extended with the digest
+// of the NUL terminated section name followed by the digest of the section
+// contents. The PCR is assumed to be zero before systemd-stub runs, the
+// boot phases measured later on are accounted for by ukiPCRPhaseValues.
+func ukiPCRValue(r io.ReaderAt) ([]byte, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse unified kernel image: %v", err)
+	}
+	defer f.Close()
+
+	if f.Section(".linux") == nil {
+		return nil, fmt.Errorf("cannot use unified kernel image without a .linux section")
+	}
+
+	pcr := make([]byte, sha256.Size)
+	for _, name := range ukiMeasuredSections {
+		section := f.Section(name)
+		if section == nil {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s section of unified kernel image: %v", name, err)
+		}
+		// the raw data is padded to the file alignment, only the
+		// virtual size is measured
+		if section.VirtualSize != 0 && int(section.VirtualSize) < len(data) {
+			data = data[:section.VirtualSize]
+		}
+		pcr = extendSHA256PCR(pcr, append([]byte(name), 0))
+		pcr = extendSHA256PCR(pcr, data)
+	}
+	return pcr, nil
+}
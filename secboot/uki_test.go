@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/secboot"
+)
+
+type ukiSuite struct{}
+
+var _ = Suite(&ukiSuite{})
+
+type ukiSection struct {
+	name string
+	data []byte
+}
+
+// mockUKI returns a minimal PE image with the given sections
+func mockUKI(c *C, sections []ukiSection) []byte {
+	var buf bytes.Buffer
+	// DOS header with the offset of the PE signature at 0x3c
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+	err := binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:          pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections: uint16(len(sections)),
+	})
+	c.Assert(err, IsNil)
+	offset := uint32(buf.Len() + 40*len(sections))
+	for _, s := range sections {
+		var hdr pe.SectionHeader32
+		copy(hdr.Name[:], s.name)
+		hdr.VirtualSize = uint32(len(s.data))
+		hdr.SizeOfRawData = uint32(len(s.data)) + 1
+		hdr.PointerToRawData = offset
+		c.Assert(binary.Write(&buf, binary.LittleEndian, hdr), IsNil)
+		offset += hdr.SizeOfRawData
+	}
+	for _, s := range sections {
+		buf.Write(s.data)
+		// padding which is not measured
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func (s *ukiSuite) TestUKIPCRValue(c *C) {
+	// sections are measured in the systemd-stub order, not in file
+	// order, and unknown sections are ignored
+	uki := mockUKI(c, []ukiSection{
+		{".cmdline", []byte("snapd_recovery_mode=run")},
+		{".text", []byte("stub")},
+		{".linux", []byte("kernel")},
+		{".osrel", []byte("ID=ubuntu")},
+	})
+
+	value, err := secboot.UKIPCRValue(bytes.NewReader(uki))
+	c.Assert(err, IsNil)
+	c.Check(hex.EncodeToString(value), Equals, "020a638903b3e91af9cfba08a7612a958d3b76273953009fc5c9a33c95b08a31")
+}
+
+func (s *ukiSuite) TestUKIPCRValueErrors(c *C) {
+	_, err := secboot.UKIPCRValue(bytes.NewReader([]byte("not a PE")))
+	c.Check(err, ErrorMatches, "cannot parse unified kernel image: .*")
+
+	uki := mockUKI(c, []ukiSection{
+		{".cmdline", []byte("snapd_recovery_mode=run")},
+	})
+	_, err = secboot.UKIPCRValue(bytes.NewReader(uki))
+	c.Check(err, ErrorMatches, "cannot use unified kernel image without a .linux section")
+}
+
+func (s *ukiSuite) TestUKIPCRPhaseValues(c *C) {
+	value := make([]byte, sha256.Size)
+	value[0] = 1
+
+	enterInitrd := sha256.Sum256([]byte("enter-initrd"))
+	h := sha256.New()
+	h.Write(value)
+	h.Write(enterInitrd[:])
+
+	// the value left by systemd-stub is accepted, as well as the one
+	// after systemd-pcrphase measured entering the initrd
+	c.Check(secboot.UKIPCRPhaseValues(value), DeepEquals, [][]byte{value, h.Sum(nil)})
+}
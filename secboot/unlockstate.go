@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (m UnlockMethod) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *UnlockMethod) UnmarshalText(text []byte) error {
+	for method := NotUnlocked; method <= UnlockedWithKey; method++ {
+		if method.String() == string(text) {
+			*m = method
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid unlock method %q", text)
+}
+
+// UnlockStateFile returns the file where the method used to unlock each
+// volume during the current boot is recorded, as a JSON object mapping the
+// volume names to the unlock methods.
+func UnlockStateFile() string {
+	return filepath.Join(dirs.SnapBootstrapRunDir, "unlock-state.json")
+}
+
+var unlockStateMu sync.Mutex
+
+// recordUnlockMethod records the method used to unlock the volume in
+// UnlockStateFile. Failing to record it is not fatal to unlocking, errors
+// are only logged.
+func recordUnlockMethod(volume string, method UnlockMethod) {
+	unlockStateMu.Lock()
+	defer unlockStateMu.Unlock()
+
+	methods, err := readUnlockState()
+	if err != nil {
+		logger.Noticef("cannot read unlock state: %v", err)
+	}
+	if methods == nil {
+		methods = make(map[string]UnlockMethod)
+	}
+	methods[volume] = method
+
+	buf, err := json.Marshal(methods)
+	if err != nil {
+		logger.Noticef("cannot marshal unlock state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(dirs.SnapBootstrapRunDir, 0755); err != nil {
+		logger.Noticef("cannot record unlock state: %v", err)
+		return
+	}
+	if err := osutil.AtomicWriteFile(UnlockStateFile(), buf, 0644, 0); err != nil {
+		logger.Noticef("cannot record unlock state: %v", err)
+	}
+}
+
+// readUnlockState returns the methods used to unlock the volumes during the
+// current boot, by volume name. It returns no methods and no error if no
+// volume was unlocked.
+func readUnlockState() (map[string]UnlockMethod, error) {
+	buf, err := ioutil.ReadFile(UnlockStateFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var methods map[string]UnlockMethod
+	if err := json.Unmarshal(buf, &methods); err != nil {
+		return nil, fmt.Errorf("cannot decode unlock state: %v", err)
+	}
+	return methods, nil
+}
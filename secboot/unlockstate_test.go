@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build !nosecboot
+
+/*
+ * Copyright (C) 2021 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type unlockStateSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&unlockStateSuite{})
+
+func (s *unlockStateSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("/") })
+}
+
+func (s *unlockStateSuite) TestUnlockMethodJSON(c *C) {
+	for _, m := range []secboot.UnlockMethod{
+		secboot.NotUnlocked,
+		secboot.UnlockedWithSealedKey,
+		secboot.UnlockedWithRecoveryKey,
+		secboot.UnlockStatusUnknown,
+		secboot.UnlockedWithKeyProtector,
+		secboot.UnlockedWithKey,
+	} {
+		buf, err := json.Marshal(m)
+		c.Assert(err, IsNil)
+		c.Check(string(buf), Equals, `"`+m.String()+`"`)
+		var unmarshaled secboot.UnlockMethod
+		c.Assert(json.Unmarshal(buf, &unmarshaled), IsNil)
+		c.Check(unmarshaled, Equals, m)
+	}
+
+	var m secboot.UnlockMethod
+	err := json.Unmarshal([]byte(`"magic"`), &m)
+	c.Check(err, ErrorMatches, `invalid unlock method "magic"`)
+}
+
+func (s *unlockStateSuite) TestRecordAndReadUnlockState(c *C) {
+	state, err := secboot.ReadUnlockState()
+	c.Assert(err, IsNil)
+	c.Check(state, HasLen, 0)
+
+	secboot.RecordUnlockMethod("ubuntu-data", secboot.UnlockedWithSealedKey)
+	secboot.RecordUnlockMethod("ubuntu-save", secboot.UnlockedWithKey)
+	// the last method used for a volume is kept
+	secboot.RecordUnlockMethod("ubuntu-data", secboot.UnlockedWithRecoveryKey)
+
+	c.Check(secboot.UnlockStateFile(), Equals, filepath.Join(dirs.SnapBootstrapRunDir, "unlock-state.json"))
+	c.Check(secboot.UnlockStateFile(), testutil.FileEquals, `{"ubuntu-data":"recovery-key","ubuntu-save":"key"}`)
+
+	state, err = secboot.ReadUnlockState()
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, map[string]secboot.UnlockMethod{
+		"ubuntu-data": secboot.UnlockedWithRecoveryKey,
+		"ubuntu-save": secboot.UnlockedWithKey,
+	})
+}
+
+func (s *unlockStateSuite) TestReadUnlockStateCorrupted(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapBootstrapRunDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(secboot.UnlockStateFile(), []byte(`{"ubuntu-data":"magic"}`), 0644), IsNil)
+
+	_, err := secboot.ReadUnlockState()
+	c.Assert(err, ErrorMatches, `cannot decode unlock state: invalid unlock method "magic"`)
+
+	// a corrupted state is replaced when recording
+	secboot.RecordUnlockMethod("ubuntu-data", secboot.UnlockedWithSealedKey)
+	state, err := secboot.ReadUnlockState()
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, map[string]secboot.UnlockMethod{
+		"ubuntu-data": secboot.UnlockedWithSealedKey,
+	})
+}
@@ -524,6 +524,17 @@ func (s *Info) Services() []*AppInfo {
 	return svcs
 }
 
+// ServicesRunAs returns the system username the services of the snap run
+// as, or an empty string if they all run as root.
+func (s *Info) ServicesRunAs() string {
+	for _, app := range s.Apps {
+		if app.IsService() && app.RunAs != "" {
+			return app.RunAs
+		}
+	}
+	return ""
+}
+
 // ExpandSnapVariables resolves $SNAP, $SNAP_DATA and $SNAP_COMMON inside the
 // snap's mount namespace.
 func (s *Info) ExpandSnapVariables(path string) string {
@@ -812,6 +823,9 @@ type TimerInfo struct {
 	App *AppInfo
 
 	Timer string
+	// Persistent is true if a run missed while the system was down is
+	// triggered once when the system is up again.
+	Persistent bool
 }
 
 // StopModeType is the type for the "stop-mode:" of a snap app
@@ -865,6 +879,8 @@ type AppInfo struct {
 	Completer       string
 	RefreshMode     string
 	StopMode        StopModeType
+	// RunAs is the system username the service runs as instead of root.
+	RunAs string
 
 	// TODO: this should go away once we have more plumbing and can change
 	// things vs refactor
@@ -933,8 +949,8 @@ type HookInfo struct {
 // username wrt the snap and the system. Defined scopes:
 // - shared    static, snapd-managed user/group shared between host and all
 //             snaps
-// - private   static, snapd-managed user/group private to a particular snap
-//             (currently not implemented)
+// - private   static, snapd-managed user/group private to a particular snap,
+//             its name must be prefixed with snap_<snap name>
 // - external  dynamic user/group shared between host and all snaps (currently
 //             not implented)
 type SystemUsernameInfo struct {
@@ -74,6 +74,7 @@ type appYaml struct {
 
 	Daemon      string      `yaml:"daemon"`
 	DaemonScope DaemonScope `yaml:"daemon-scope"`
+	RunAs       string      `yaml:"run-as,omitempty"`
 
 	StopCommand     string          `yaml:"stop-command,omitempty"`
 	ReloadCommand   string          `yaml:"reload-command,omitempty"`
@@ -101,7 +102,8 @@ type appYaml struct {
 	After  []string `yaml:"after,omitempty"`
 	Before []string `yaml:"before,omitempty"`
 
-	Timer string `yaml:"timer,omitempty"`
+	Timer           string `yaml:"timer,omitempty"`
+	TimerPersistent bool   `yaml:"timer-persistent,omitempty"`
 
 	Autostart string `yaml:"autostart,omitempty"`
 }
@@ -352,6 +354,7 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 			StartTimeout:    yApp.StartTimeout,
 			Daemon:          yApp.Daemon,
 			DaemonScope:     yApp.DaemonScope,
+			RunAs:           yApp.RunAs,
 			StopTimeout:     yApp.StopTimeout,
 			StopCommand:     yApp.StopCommand,
 			ReloadCommand:   yApp.ReloadCommand,
@@ -448,9 +451,12 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 		}
 		if yApp.Timer != "" {
 			app.Timer = &TimerInfo{
-				App:   app,
-				Timer: yApp.Timer,
+				App:        app,
+				Timer:      yApp.Timer,
+				Persistent: yApp.TimerPersistent,
 			}
+		} else if yApp.TimerPersistent {
+			return fmt.Errorf("cannot use timer-persistent without timer on app %q", appName)
 		}
 		// collect all common IDs
 		if app.CommonID != "" {
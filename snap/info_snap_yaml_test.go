@@ -1855,6 +1855,35 @@ apps:
 	c.Check(app.Timer, DeepEquals, &snap.TimerInfo{App: app, Timer: "mon,10:00-12:00"})
 }
 
+func (s *YamlSuite) TestSnapYamlAppTimerPersistent(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ foo:
+   daemon: oneshot
+   timer: mon,10:00-12:00
+   timer-persistent: true
+
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	app := info.Apps["foo"]
+	c.Check(app.Timer, DeepEquals, &snap.TimerInfo{App: app, Timer: "mon,10:00-12:00", Persistent: true})
+}
+
+func (s *YamlSuite) TestSnapYamlAppTimerPersistentWithoutTimer(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ foo:
+   daemon: oneshot
+   timer-persistent: true
+
+`)
+	_, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, ErrorMatches, `cannot use timer-persistent without timer on app "foo"`)
+}
+
 func (s *YamlSuite) TestSnapYamlAppAutostart(c *C) {
 	yAutostart := []byte(`name: wat
 version: 42
@@ -1978,6 +2007,26 @@ system-usernames:
 	})
 }
 
+func (s *YamlSuite) TestSnapYamlRunAs(c *C) {
+	y := []byte(`name: binary
+version: 1.0
+system-usernames:
+  snap_binary: private
+apps:
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_binary
+  app:
+    command: bin/app
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.Apps["svc"].RunAs, Equals, "snap_binary")
+	c.Check(info.Apps["app"].RunAs, Equals, "")
+	c.Check(info.ServicesRunAs(), Equals, "snap_binary")
+}
+
 func (s *YamlSuite) TestSnapYamlSystemUsernamesParsingBadType(c *C) {
 	y := []byte(`name: binary
 version: 1.0
@@ -126,9 +126,13 @@ func loadAndValidate(sourceDir string) (*snap.Info, error) {
 	}
 
 	if info.SnapType == snap.TypeGadget {
-		if err := gadget.Validate(sourceDir, nil); err != nil {
+		warnings, err := gadget.ValidateWithWarnings(sourceDir, nil)
+		if err != nil {
 			return nil, err
 		}
+		for _, w := range warnings {
+			logger.Noticef("WARNING: %s", w)
+		}
 	}
 	if info.SnapType == snap.TypeKernel {
 		if err := kernel.Validate(sourceDir); err != nil {
@@ -714,6 +714,10 @@ func ValidateApp(app *AppInfo) error {
 		return fmt.Errorf(`invalid "daemon-scope": %q`, app.DaemonScope)
 	}
 
+	if app.RunAs != "" && app.DaemonScope != SystemDaemon {
+		return fmt.Errorf(`"run-as" can only be set for system daemons`)
+	}
+
 	// Validate app name
 	if !ValidAppName(app.Name) {
 		return fmt.Errorf("cannot have %q as app name - use letters, digits, and dash as separator", app.Name)
@@ -1065,6 +1069,27 @@ func ValidateSystemUsernames(info *Info) error {
 			return fmt.Errorf("invalid system username %q", username)
 		}
 	}
+	// the data directories of the snap are shared by its services, so
+	// they can only be handed over to a single system username
+	appNames := make([]string, 0, len(info.Apps))
+	for name := range info.Apps {
+		appNames = append(appNames, name)
+	}
+	sort.Strings(appNames)
+	runAs := ""
+	for _, name := range appNames {
+		app := info.Apps[name]
+		if app.RunAs == "" {
+			continue
+		}
+		if _, ok := info.SystemUsernames[app.RunAs]; !ok {
+			return fmt.Errorf("cannot run app %q as system username %q not listed in system-usernames", app.Name, app.RunAs)
+		}
+		if runAs != "" && runAs != app.RunAs {
+			return fmt.Errorf("cannot run services as different system usernames %q and %q", runAs, app.RunAs)
+		}
+		runAs = app.RunAs
+	}
 	return nil
 }
 
@@ -503,6 +503,17 @@ func (s *ValidateSuite) TestAppDaemonScopeValue(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestAppRunAs(c *C) {
+	app := &AppInfo{Name: "foo", Daemon: "simple", DaemonScope: SystemDaemon, RunAs: "snap_foo"}
+	c.Check(ValidateApp(app), IsNil)
+
+	app = &AppInfo{Name: "foo", Daemon: "simple", DaemonScope: UserDaemon, RunAs: "snap_foo"}
+	c.Check(ValidateApp(app), ErrorMatches, `"run-as" can only be set for system daemons`)
+
+	app = &AppInfo{Name: "foo", RunAs: "snap_foo"}
+	c.Check(ValidateApp(app), ErrorMatches, `"run-as" can only be set for system daemons`)
+}
+
 func (s *ValidateSuite) TestAppStopMode(c *C) {
 	// check services
 	for _, t := range []struct {
@@ -1853,6 +1864,56 @@ system-usernames:
 	c.Assert(err, ErrorMatches, `invalid system username "b@d"`)
 }
 
+func (s *ValidateSuite) TestValidateSystemUsernamesRunAs(c *C) {
+	for _, t := range []struct {
+		apps string
+		err  string
+	}{
+		{apps: `
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_binary
+  other:
+    command: bin/other
+    daemon: simple
+    run-as: snap_binary
+`},
+		{apps: `
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_daemon
+`, err: `cannot run app "svc" as system username "snap_daemon" not listed in system-usernames`},
+		{apps: `
+  svc:
+    command: bin/svc
+    daemon: simple
+    run-as: snap_binary
+  web:
+    command: bin/web
+    daemon: simple
+    run-as: snap_binary_web
+`, err: `cannot run services as different system usernames "snap_binary" and "snap_binary_web"`},
+	} {
+		yaml := `name: binary
+version: 1.0
+system-usernames:
+  snap_binary: private
+  snap_binary_web: private
+apps:` + t.apps
+		info, err := InfoFromSnapYamlWithSideInfo([]byte(yaml), nil, NewScopedTracker())
+		c.Assert(err, IsNil)
+		err = Validate(info)
+		if t.err == "" {
+			c.Check(err, IsNil)
+			c.Check(info.ServicesRunAs(), Equals, "snap_binary")
+		} else {
+			c.Check(err, ErrorMatches, t.err)
+		}
+	}
+}
+
 const yamlNeedDf = `name: need-df
 version: 1.0
 plugs:
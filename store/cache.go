@@ -63,10 +63,30 @@ func (s changesByMtime) Len() int           { return len(s) }
 func (s changesByMtime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s changesByMtime) Less(i, j int) bool { return s[i].ModTime().Before(s[j].ModTime()) }
 
+// CachePolicy bounds what a CacheManager keeps around. Only the cached
+// files that are not referenced elsewhere in the filesystem count towards
+// the limits, as the others take no additional space.
+type CachePolicy struct {
+	// MaxItems is the maximum number of unreferenced files to keep.
+	MaxItems int
+	// MaxSizeBytes is the maximum total size of the unreferenced files
+	// to keep, there is no limit when 0.
+	MaxSizeBytes uint64
+}
+
+// overBudget returns whether the given number and total size of
+// unreferenced files exceed the policy.
+func (cp *CachePolicy) overBudget(items int, size uint64) bool {
+	if items > cp.MaxItems {
+		return true
+	}
+	return cp.MaxSizeBytes > 0 && size > cp.MaxSizeBytes
+}
+
 // cacheManager implements a downloadCache via content based hard linking
 type CacheManager struct {
 	cacheDir string
-	maxItems int
+	policy   CachePolicy
 }
 
 // NewCacheManager returns a new CacheManager with the given cacheDir
@@ -83,10 +103,21 @@ type CacheManager struct {
 //
 // The caching part is done here, the downloading happens in the store.go
 // code.
+//
+// As the cache is addressed by the digest of the content, the same file is
+// shared by all the revisions and users downloading it through the store,
+// and the hard link count of a cached file serves as its reference count.
 func NewCacheManager(cacheDir string, maxItems int) *CacheManager {
+	return NewCacheManagerWithPolicy(cacheDir, CachePolicy{MaxItems: maxItems})
+}
+
+// NewCacheManagerWithPolicy returns a new CacheManager with the given
+// cacheDir, evicting the least recently used unreferenced files according
+// to the given policy.
+func NewCacheManagerWithPolicy(cacheDir string, policy CachePolicy) *CacheManager {
 	return &CacheManager{
 		cacheDir: cacheDir,
-		maxItems: maxItems,
+		policy:   policy,
 	}
 }
 
@@ -121,6 +152,12 @@ func (cm *CacheManager) Put(cacheKey, sourcePath string) error {
 	}
 
 	err := os.Link(sourcePath, cm.path(cacheKey))
+	if isCrossDevice(err) {
+		// the file is on another filesystem, like an image being
+		// prepared, there is no way to share it
+		logger.Debugf("cannot cache %s: %v", sourcePath, err)
+		return nil
+	}
 	if os.IsExist(err) {
 		now := time.Now()
 		err := os.Chtimes(cm.path(cacheKey), now, now)
@@ -153,47 +190,42 @@ func (cm *CacheManager) path(cacheKey string) string {
 	return filepath.Join(cm.cacheDir, cacheKey)
 }
 
-// cleanup ensures that only maxItems are stored in the cache
+// cleanup ensures that the files stored in the cache and not referenced
+// elsewhere stay within the policy
 func (cm *CacheManager) cleanup() error {
 	fil, err := ioutil.ReadDir(cm.cacheDir)
 	if err != nil {
 		return err
 	}
-	if len(fil) <= cm.maxItems {
+	if len(fil) <= cm.policy.MaxItems && cm.policy.MaxSizeBytes == 0 {
 		return nil
 	}
 
-	numOwned := 0
+	var owned []os.FileInfo
+	var ownedSize uint64
 	for _, fi := range fil {
 		n, err := hardLinkCount(fi)
 		if err != nil {
 			logger.Noticef("cannot inspect cache: %s", err)
 		}
-		// Only count the file if it is not referenced elsewhere in the filesystem
+		// Only count the file if it is not referenced elsewhere in the
+		// filesystem, if there is any error we count it as well (it is
+		// just a cache afterall).
 		if n <= 1 {
-			numOwned++
+			owned = append(owned, fi)
+			ownedSize += uint64(fi.Size())
 		}
 	}
 
-	if numOwned <= cm.maxItems {
+	numOwned := len(owned)
+	if !cm.policy.overBudget(numOwned, ownedSize) {
 		return nil
 	}
 
 	var lastErr error
-	sort.Sort(changesByMtime(fil))
-	deleted := 0
-	for _, fi := range fil {
+	sort.Sort(changesByMtime(owned))
+	for _, fi := range owned {
 		path := cm.path(fi.Name())
-		n, err := hardLinkCount(fi)
-		if err != nil {
-			logger.Noticef("cannot inspect cache: %s", err)
-		}
-		// If the file is referenced in the filesystem somewhere
-		// else our copy is "free" so skip it. If there is any
-		// error we cleanup the file (it is just a cache afterall).
-		if n > 1 {
-			continue
-		}
 		if err := osRemove(path); err != nil {
 			if !os.IsNotExist(err) {
 				logger.Noticef("cannot cleanup cache: %s", err)
@@ -201,14 +233,24 @@ func (cm *CacheManager) cleanup() error {
 			}
 			continue
 		}
-		deleted++
-		if numOwned-deleted <= cm.maxItems {
+		numOwned--
+		ownedSize -= uint64(fi.Size())
+		if !cm.policy.overBudget(numOwned, ownedSize) {
 			break
 		}
 	}
 	return lastErr
 }
 
+// isCrossDevice returns whether the given error of a link is due to the
+// source and target being on different filesystems
+func isCrossDevice(err error) bool {
+	if linkErr, ok := err.(*os.LinkError); ok {
+		return linkErr.Err == syscall.EXDEV
+	}
+	return false
+}
+
 // hardLinkCount returns the number of hardlinks for the given path
 func hardLinkCount(fi os.FileInfo) (uint64, error) {
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok && stat != nil {
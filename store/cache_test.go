@@ -183,6 +183,46 @@ func (s *cacheSuite) TestClenaupContinuesOnError(c *C) {
 	c.Check(osutil.FileExists(filepath.Join(s.cm.CacheDir(), cacheKeys[0])), Equals, true)
 }
 
+func (s *cacheSuite) TestCleanupMaxSize(c *C) {
+	s.cm = store.NewCacheManagerWithPolicy(c.MkDir(), store.CachePolicy{
+		MaxItems: s.maxItems,
+		// room for two of the test files
+		MaxSizeBytes: 2,
+	})
+	cacheKeys, testFiles := s.makeTestFiles(c, 4)
+	// the files are referenced elsewhere so nothing is removed
+	c.Check(s.cm.Count(), Equals, 4)
+
+	// keep the newest file referenced outside of the cache
+	for _, p := range testFiles[:3] {
+		err := os.Remove(p)
+		c.Assert(err, IsNil)
+	}
+	err := s.cm.Cleanup()
+	c.Assert(err, IsNil)
+
+	// the oldest unreferenced file is removed
+	c.Check(osutil.FileExists(filepath.Join(s.cm.CacheDir(), cacheKeys[0])), Equals, false)
+	for _, cacheKey := range cacheKeys[1:] {
+		c.Check(osutil.FileExists(filepath.Join(s.cm.CacheDir(), cacheKey)), Equals, true)
+	}
+}
+
+func (s *cacheSuite) TestCleanupMaxSizeUnderBudget(c *C) {
+	s.cm = store.NewCacheManagerWithPolicy(c.MkDir(), store.CachePolicy{
+		MaxItems:     s.maxItems,
+		MaxSizeBytes: 1024,
+	})
+	_, testFiles := s.makeTestFiles(c, 3)
+	for _, p := range testFiles {
+		err := os.Remove(p)
+		c.Assert(err, IsNil)
+	}
+	err := s.cm.Cleanup()
+	c.Assert(err, IsNil)
+	c.Check(s.cm.Count(), Equals, 3)
+}
+
 func (s *cacheSuite) TestHardLinkCount(c *C) {
 	p := filepath.Join(s.tmp, "foo")
 	err := ioutil.WriteFile(p, nil, 0644)
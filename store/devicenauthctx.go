@@ -39,6 +39,9 @@ type DeviceAndAuthContext interface {
 
 	DeviceSessionRequestParams(nonce string) (*DeviceSessionRequestParams, error)
 	ProxyStoreParams(defaultURL *url.URL) (proxyStoreID string, proxySroreURL *url.URL, err error)
+	// StoreTLSPins returns the pins of the public keys the certificates of
+	// the store API must match, see httputil.PublicKeyPin.
+	StoreTLSPins() ([]string, error)
 
 	CloudInfo() (*auth.CloudInfo, error)
 }
@@ -60,6 +60,11 @@ var (
 	SnapActionFields = snapActionFields
 )
 
+// TLSPins returns the public key pins applied to connections to host.
+func (s *Store) TLSPins(host string) ([]string, error) {
+	return (&storeTLSPins{s: s}).Pins(host)
+}
+
 // MockDefaultRetryStrategy mocks the retry strategy used by several store requests
 func MockDefaultRetryStrategy(t *testutil.BaseTest, strategy retry.Strategy) {
 	originalDefaultRetryStrategy := defaultRetryStrategy
@@ -102,6 +102,9 @@ type Config struct {
 
 	// CacheDownloads is the number of downloads that should be cached
 	CacheDownloads int
+	// CacheDownloadsMaxSize is the maximum total size in bytes of the
+	// cached downloads, there is no limit when 0
+	CacheDownloadsMaxSize uint64
 
 	// Proxy returns the HTTP proxy to use when talking to the store
 	Proxy func(*http.Request) (*url.URL, error)
@@ -156,6 +159,8 @@ type Store struct {
 	proxyConnectHeader http.Header
 
 	userAgent string
+
+	assertionETags assertionETags
 }
 
 var ErrTooManyRequests = errors.New("too many requests")
@@ -428,9 +433,40 @@ func (s *Store) newHTTPClient(opts *httputil.ClientOptions) *http.Client {
 	opts.ExtraSSLCerts = &httputil.ExtraSSLCertsFromDir{
 		Dir: dirs.SnapdStoreSSLCertsDir,
 	}
+	opts.PinnedPublicKeys = &storeTLSPins{s: s}
 	return httputil.NewHTTPClient(opts)
 }
 
+// storeTLSPins implements httputil.PinnedPublicKeys, pinning the hosts
+// of the store API to the public keys provided by the
+// DeviceAndAuthContext.
+type storeTLSPins struct {
+	s *Store
+}
+
+func (p *storeTLSPins) Pins(host string) ([]string, error) {
+	s := p.s
+	if s.dauthCtx == nil || !s.isAPIHost(host) {
+		return nil, nil
+	}
+	return s.dauthCtx.StoreTLSPins()
+}
+
+// isAPIHost returns whether the given host serves the store API or
+// assertions, other hosts like the CDN ones used for downloads are
+// never pinned.
+func (s *Store) isAPIHost(host string) bool {
+	for _, defaultURL := range []*url.URL{s.cfg.StoreBaseURL, s.cfg.AssertionsBaseURL} {
+		if defaultURL == nil {
+			continue
+		}
+		if u := s.baseURL(defaultURL); u.Hostname() == host {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Store) defaultSnapQuery() url.Values {
 	q := url.Values{}
 	if len(s.detailFields) != 0 {
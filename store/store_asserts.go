@@ -23,12 +23,14 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
+	"sync"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/httputil"
@@ -74,7 +76,48 @@ func (e *assertionSvcError) toError() error {
 	return fmt.Errorf("assertion service error: [%s] %q", e.Title, e.Detail)
 }
 
+// maxAssertionETags is the maximum number of assertions remembered
+// together with their ETag for conditional requests.
+const maxAssertionETags = 1024
+
+type assertionETag struct {
+	etag      string
+	assertion asserts.Assertion
+}
+
+// assertionETags remembers the assertions last retrieved by URL together
+// with their ETag, so that they can be requested conditionally.
+type assertionETags struct {
+	mu      sync.Mutex
+	entries map[string]assertionETag
+}
+
+func (e *assertionETags) get(u string) (assertionETag, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry, ok := e.entries[u]
+	return entry, ok
+}
+
+func (e *assertionETags) set(u string, entry assertionETag) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.entries == nil || len(e.entries) >= maxAssertionETags {
+		// start over rather than tracking usage, most assertions
+		// are refreshed together anyway
+		e.entries = make(map[string]assertionETag)
+	}
+	e.entries[u] = entry
+}
+
+// errAssertionNotModified is returned by downloadAssertions when the
+// assertion matching the ETag of a conditional request did not change.
+var errAssertionNotModified = errors.New("assertion not modified")
+
 // Assertion retrieves the assertion for the given type and primary key.
+// If the same assertion was retrieved before, it is requested
+// conditionally with its ETag, and the assertion retrieved before is
+// returned if the store reports that it was not modified.
 func (s *Store) Assertion(assertType *asserts.AssertionType, primaryKey []string, user *auth.UserState) (asserts.Assertion, error) {
 	v := url.Values{}
 	v.Set("max-format", strconv.Itoa(assertType.MaxSupportedFormat()))
@@ -82,7 +125,8 @@ func (s *Store) Assertion(assertType *asserts.AssertionType, primaryKey []string
 
 	var asrt asserts.Assertion
 
-	err := s.downloadAssertions(u, func(r io.Reader) error {
+	cached, haveCached := s.assertionETags.get(u.String())
+	etag, err := s.downloadAssertions(u, cached.etag, func(r io.Reader) error {
 		// decode assertion
 		dec := asserts.NewDecoder(r)
 		var e error
@@ -101,18 +145,30 @@ func (s *Store) Assertion(assertType *asserts.AssertionType, primaryKey []string
 		// default error
 		return nil
 	}, "fetch assertion", user)
+	if err == errAssertionNotModified && haveCached {
+		return cached.assertion, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	if etag != "" {
+		s.assertionETags.set(u.String(), assertionETag{etag: etag, assertion: asrt})
+	}
 	return asrt, nil
 }
 
-func (s *Store) downloadAssertions(u *url.URL, decodeBody func(io.Reader) error, handleSvcErr func(*assertionSvcError) error, what string, user *auth.UserState) error {
+// downloadAssertions downloads the assertions at the given URL, if etag is
+// set the request is conditional and errAssertionNotModified is returned if
+// the assertions did not change. The ETag of the response is returned.
+func (s *Store) downloadAssertions(u *url.URL, etag string, decodeBody func(io.Reader) error, handleSvcErr func(*assertionSvcError) error, what string, user *auth.UserState) (respETag string, err error) {
 	reqOptions := &requestOptions{
 		Method: "GET",
 		URL:    u,
 		Accept: asserts.MediaType,
 	}
+	if etag != "" {
+		reqOptions.addHeader("If-None-Match", etag)
+	}
 
 	resp, err := httputil.RetryRequest(reqOptions.URL.String(), func() (*http.Response, error) {
 		return s.doRequest(context.TODO(), s.client, reqOptions, user)
@@ -141,14 +197,17 @@ func (s *Store) downloadAssertions(u *url.URL, decodeBody func(io.Reader) error,
 	}, defaultRetryStrategy)
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return etag, errAssertionNotModified
+	}
 	if resp.StatusCode != 200 {
-		return respToError(resp, what)
+		return "", respToError(resp, what)
 	}
 
-	return nil
+	return resp.Header.Get("ETag"), nil
 }
 
 // DownloadAssertions download the assertion streams at the given URLs
@@ -160,7 +219,7 @@ func (s *Store) DownloadAssertions(streamURLs []string, b *asserts.Batch, user *
 			return fmt.Errorf("invalid assertions stream URL: %v", err)
 		}
 
-		err = s.downloadAssertions(u, func(r io.Reader) error {
+		_, err = s.downloadAssertions(u, "", func(r io.Reader) error {
 			// decode stream
 			_, e := b.AddStream(r)
 			return e
@@ -118,6 +118,67 @@ func (s *storeAssertsSuite) TestAssertion(c *C) {
 	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
 }
 
+func (s *storeAssertsSuite) TestAssertionConditionalWithETag(c *C) {
+	restore := asserts.MockMaxSupportedFormat(asserts.SnapDeclarationType, 88)
+	defer restore()
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", "/api/v1/snaps/assertions/.*")
+		n++
+		switch n {
+		case 1:
+			c.Check(r.Header.Get("If-None-Match"), Equals, "")
+			w.Header().Set("ETag", `"rev-1"`)
+			io.WriteString(w, testAssertion)
+		case 2:
+			c.Check(r.Header.Get("If-None-Match"), Equals, `"rev-1"`)
+			w.WriteHeader(304)
+		default:
+			c.Fatalf("unexpected request %d", n)
+		}
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	a, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
+
+	// not modified, the assertion retrieved before is returned
+	a1, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a1, Equals, a)
+	c.Check(n, Equals, 2)
+}
+
+func (s *storeAssertsSuite) TestAssertionNotModifiedUnconditional(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("If-None-Match"), Equals, "")
+		w.WriteHeader(304)
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	_, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, nil)
+	c.Assert(err, ErrorMatches, `cannot fetch assertion: got unexpected HTTP status code 304 via GET to "http://.*/snap-declaration/16/snapidfoo.*"`)
+}
+
 func (s *storeAssertsSuite) TestAssertionProxyStoreFromAuthContext(c *C) {
 	restore := asserts.MockMaxSupportedFormat(asserts.SnapDeclarationType, 88)
 	defer restore()
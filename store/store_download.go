@@ -572,7 +572,10 @@ func (s *Store) CacheDownloads() int {
 func (s *Store) SetCacheDownloads(fileCount int) {
 	s.cfg.CacheDownloads = fileCount
 	if fileCount > 0 {
-		s.cacher = NewCacheManager(dirs.SnapDownloadCacheDir, fileCount)
+		s.cacher = NewCacheManagerWithPolicy(dirs.SnapDownloadCacheDir, CachePolicy{
+			MaxItems:     fileCount,
+			MaxSizeBytes: s.cfg.CacheDownloadsMaxSize,
+		})
 	} else {
 		s.cacher = &nullCache{}
 	}
@@ -243,6 +243,8 @@ type testDauthContext struct {
 	storeID string
 
 	cloudInfo *auth.CloudInfo
+
+	tlsPins []string
 }
 
 func (dac *testDauthContext) Device() (*auth.DeviceState, error) {
@@ -316,6 +318,10 @@ func (dac *testDauthContext) CloudInfo() (*auth.CloudInfo, error) {
 	return dac.cloudInfo, nil
 }
 
+func (dac *testDauthContext) StoreTLSPins() ([]string, error) {
+	return dac.tlsPins, nil
+}
+
 func makeTestMacaroon() (*macaroon.Macaroon, error) {
 	m, err := macaroon.New([]byte("secret"), "some-id", "location")
 	if err != nil {
@@ -1779,6 +1785,51 @@ func (s *storeTestSuite) TestProxyStoreFromAuthContextURLFallback(c *C) {
 	c.Check(result.InstanceName(), Equals, "hello-world")
 }
 
+func (s *storeTestSuite) TestStoreTLSPinsOnlyForAPIHosts(c *C) {
+	pins := []string{"sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}
+	apiURL, err := url.Parse("https://api.example.com")
+	c.Assert(err, IsNil)
+	assertionsURL, err := url.Parse("https://assertions.example.com")
+	c.Assert(err, IsNil)
+	cfg := store.DefaultConfig()
+	cfg.StoreBaseURL = apiURL
+	cfg.AssertionsBaseURL = assertionsURL
+	dauthCtx := &testDauthContext{c: c, device: s.device, tlsPins: pins}
+	sto := store.New(cfg, dauthCtx)
+
+	for _, t := range []struct {
+		host string
+		pins []string
+	}{
+		{"api.example.com", pins},
+		{"assertions.example.com", pins},
+		// downloads from the CDN are not pinned
+		{"cdn.example.com", nil},
+	} {
+		hostPins, err := sto.TLSPins(t.host)
+		c.Assert(err, IsNil)
+		c.Check(hostPins, DeepEquals, t.pins, Commentf(t.host))
+	}
+
+	// a proxy store takes over the API hosts
+	proxyURL, err := url.Parse("https://proxy.internal:8443")
+	c.Assert(err, IsNil)
+	dauthCtx.proxyStoreID = "foo"
+	dauthCtx.proxyStoreURL = proxyURL
+	hostPins, err := sto.TLSPins("proxy.internal")
+	c.Assert(err, IsNil)
+	c.Check(hostPins, DeepEquals, pins)
+	hostPins, err = sto.TLSPins("api.example.com")
+	c.Assert(err, IsNil)
+	c.Check(hostPins, IsNil)
+
+	// nothing is pinned without a device and auth context
+	sto = store.New(cfg, nil)
+	hostPins, err = sto.TLSPins("api.example.com")
+	c.Assert(err, IsNil)
+	c.Check(hostPins, IsNil)
+}
+
 func (s *storeTestSuite) TestInfoOopses(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assertRequest(c, r, "GET", infoPathPattern)
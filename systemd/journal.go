@@ -25,6 +25,7 @@ import (
 	"log/syslog"
 	"net"
 	"os"
+	"path/filepath"
 )
 
 var journalStdoutPath = "/run/systemd/journal/stdout"
@@ -32,7 +33,20 @@ var journalStdoutPath = "/run/systemd/journal/stdout"
 // NewJournalStreamFile creates log stream file descriptor to the journal. The
 // semantics is identical to that of sd_journal_stream_fd(3) call.
 func NewJournalStreamFile(identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error) {
-	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: journalStdoutPath})
+	return newJournalStreamFile(journalStdoutPath, identifier, priority, levelPrefix)
+}
+
+// NewJournalNamespaceStreamFile is like NewJournalStreamFile but creates a
+// stream to the journal of the given namespace, see
+// systemd-journald.service(8).
+func NewJournalNamespaceStreamFile(namespace, identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error) {
+	// the socket of namespace foo is /run/systemd/journal.foo/stdout
+	stdoutPath := filepath.Join(filepath.Dir(journalStdoutPath)+"."+namespace, filepath.Base(journalStdoutPath))
+	return newJournalStreamFile(stdoutPath, identifier, priority, levelPrefix)
+}
+
+func newJournalStreamFile(stdoutPath, identifier string, priority syslog.Priority, levelPrefix bool) (*os.File, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: stdoutPath})
 	if err != nil {
 		return nil, err
 	}
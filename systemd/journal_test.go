@@ -20,8 +20,10 @@
 package systemd_test
 
 import (
+	"io/ioutil"
 	"log/syslog"
 	"net"
+	"os"
 	"path"
 
 	. "gopkg.in/check.v1"
@@ -87,3 +89,37 @@ func (j *journalTestSuite) TestStreamFileHeader(c *C) {
 
 	<-doneCh
 }
+
+func (j *journalTestSuite) TestNamespaceStreamFile(c *C) {
+	d := c.MkDir()
+	restore := MockJournalStdoutPath(path.Join(d, "journal", "stdout"))
+	defer restore()
+
+	jout, err := NewJournalNamespaceStreamFile("snapd", "foobar", syslog.LOG_INFO, false)
+	c.Assert(err, ErrorMatches, `.*/journal.snapd/stdout: connect: no such file or directory`)
+	c.Assert(jout, IsNil)
+
+	c.Assert(os.MkdirAll(path.Join(d, "journal.snapd"), 0755), IsNil)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path.Join(d, "journal.snapd", "stdout")})
+	c.Assert(err, IsNil)
+	defer listener.Close()
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		conn, err := listener.AcceptUnix()
+		c.Assert(err, IsNil)
+		defer conn.Close()
+		data, err := ioutil.ReadAll(conn)
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, "foobar\n\n6\n0\n0\n0\n0\nhello")
+	}()
+
+	jout, err = NewJournalNamespaceStreamFile("snapd", "foobar", syslog.LOG_INFO, false)
+	c.Assert(err, IsNil)
+	_, err = jout.WriteString("hello")
+	c.Assert(err, IsNil)
+	jout.Close()
+
+	<-doneCh
+}
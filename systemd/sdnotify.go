@@ -24,6 +24,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"syscall"
 )
 
 var osGetenv = os.Getenv
@@ -32,6 +33,13 @@ var osGetenv = os.Getenv
 //
 // inspired by libsystemd/sd-daemon/sd-daemon.c from the systemd source
 func SdNotify(notifyState string) error {
+	return SdNotifyWithFds(notifyState)
+}
+
+// SdNotifyWithFds sends the given state string notification to systemd
+// along with the given file descriptors, eg. to store them in the file
+// descriptor store of the service with FDSTORE=1.
+func SdNotifyWithFds(notifyState string, fds ...int) error {
 	if notifyState == "" {
 		return fmt.Errorf("cannot use empty notify state")
 	}
@@ -48,12 +56,28 @@ func SdNotify(notifyState string) error {
 		Name: notifySocket,
 		Net:  "unixgram",
 	}
-	conn, err := net.DialUnix("unixgram", nil, raddr)
-	if err != nil {
+	if len(fds) == 0 {
+		conn, err := net.DialUnix("unixgram", nil, raddr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(notifyState))
 		return err
 	}
-	defer conn.Close()
 
-	_, err = conn.Write([]byte(notifyState))
-	return err
+	// the descriptors are sent from an unconnected socket with an
+	// explicit destination, as done by sd_pid_notify_with_fds()
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("cannot create notify socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	dest := &syscall.SockaddrUnix{Name: notifySocket}
+	if err := syscall.Sendmsg(fd, []byte(notifyState), syscall.UnixRights(fds...), dest, 0); err != nil {
+		return &net.OpError{Op: "write", Net: "unixgram", Addr: raddr, Err: err}
+	}
+	return nil
 }
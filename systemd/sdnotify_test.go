@@ -20,9 +20,11 @@
 package systemd_test
 
 import (
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"syscall"
 
 	. "gopkg.in/check.v1"
 
@@ -85,3 +87,51 @@ func (sd *sdNotifyTestSuite) TestSdNotifyIntegration(c *C) {
 		c.Check(<-ch, Equals, "something")
 	}
 }
+
+func (sd *sdNotifyTestSuite) TestSdNotifyWithFds(c *C) {
+	sockPath := filepath.Join(c.MkDir(), "socket")
+	restore := systemd.MockOsGetenv(func(k string) string {
+		if k == "NOTIFY_SOCKET" {
+			return sockPath
+		}
+		return ""
+	})
+	defer restore()
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Name: sockPath,
+		Net:  "unixgram",
+	})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	f, err := ioutil.TempFile(c.MkDir(), "fd")
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = f.WriteString("stored")
+	c.Assert(err, IsNil)
+
+	err = systemd.SdNotifyWithFds("FDSTORE=1\nFDNAME=foo", int(f.Fd()))
+	c.Assert(err, IsNil)
+
+	var buf [128]byte
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf[:], oob)
+	c.Assert(err, IsNil)
+	c.Check(string(buf[:n]), Equals, "FDSTORE=1\nFDNAME=foo")
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	c.Assert(err, IsNil)
+	c.Assert(msgs, HasLen, 1)
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	c.Assert(err, IsNil)
+	c.Assert(fds, HasLen, 1)
+	received := os.NewFile(uintptr(fds[0]), "received")
+	defer received.Close()
+	// the received descriptor refers to the same file
+	_, err = received.Seek(0, 0)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(received)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "stored")
+}
@@ -429,9 +429,20 @@ type UnitStatus struct {
 	UnitName string
 	Enabled  bool
 	Active   bool
+	// LastTrigger is the time a timer unit last triggered its service,
+	// it is the zero time if it never did or for other units.
+	LastTrigger time.Time
 }
 
 var baseProperties = []string{"Id", "ActiveState", "UnitFileState"}
+
+// timer and socket units are queried together, the trigger time is only
+// reported for timers and is optional
+var limitedProperties = []string{"Id", "ActiveState", "UnitFileState", "LastTriggerUSec"}
+
+// the format of timestamps in ‘systemctl show’ output
+const systemctlTimestampFormat = "Mon 2006-01-02 15:04:05 MST"
+
 var extendedProperties = []string{"Id", "ActiveState", "UnitFileState", "Type"}
 var unitProperties = map[string][]string{
 	".timer":  baseProperties,
@@ -493,6 +504,18 @@ func (s *systemd) getUnitStatus(properties []string, unitNames []string) ([]*Uni
 		k := string(bs[1])
 		v := string(bs[2])
 
+		if k == "LastTriggerUSec" {
+			// empty or n/a if the timer never triggered, values
+			// that cannot be parsed are reported as never as well
+			// rather than failing the status of all the units
+			if v != "" && v != "n/a" {
+				if t, err := time.Parse(systemctlTimestampFormat, v); err == nil {
+					cur.LastTrigger = t
+				}
+			}
+			continue
+		}
+
 		if v == "" {
 			return nil, fmt.Errorf("cannot get unit status: empty field %q in ‘systemctl show’ output", k)
 		}
@@ -546,7 +569,7 @@ func (s *systemd) Status(unitNames ...string) ([]*UnitStatus, error) {
 		properties []string
 	}{
 		{units: extendedUnits, properties: extendedProperties},
-		{units: limitedUnits, properties: baseProperties},
+		{units: limitedUnits, properties: limitedProperties},
 	} {
 		if len(set.units) == 0 {
 			continue
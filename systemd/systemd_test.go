@@ -247,10 +247,70 @@ UnitFileState=disabled
 	c.Check(s.rep.msgs, IsNil)
 	c.Assert(s.argses, DeepEquals, [][]string{
 		{"show", "--property=Id,ActiveState,UnitFileState,Type", "foo.service", "bar.service", "baz.service"},
-		{"show", "--property=Id,ActiveState,UnitFileState", "some.timer", "other.socket"},
+		{"show", "--property=Id,ActiveState,UnitFileState,LastTriggerUSec", "some.timer", "other.socket"},
 	})
 }
 
+func (s *SystemdTestSuite) TestStatusTimerLastTrigger(c *C) {
+	s.outs = [][]byte{
+		[]byte(`
+Id=some.timer
+ActiveState=active
+UnitFileState=enabled
+LastTriggerUSec=Tue 2021-05-04 10:00:01 UTC
+
+Id=never.timer
+ActiveState=active
+UnitFileState=enabled
+LastTriggerUSec=n/a
+
+Id=other.timer
+ActiveState=active
+UnitFileState=enabled
+LastTriggerUSec=
+`[1:]),
+	}
+	s.errors = []error{nil}
+	out, err := New(SystemMode, s.rep).Status("some.timer", "never.timer", "other.timer")
+	c.Assert(err, IsNil)
+	c.Check(out, DeepEquals, []*UnitStatus{
+		{
+			UnitName:    "some.timer",
+			Active:      true,
+			Enabled:     true,
+			LastTrigger: time.Date(2021, 5, 4, 10, 0, 1, 0, time.UTC),
+		}, {
+			UnitName: "never.timer",
+			Active:   true,
+			Enabled:  true,
+		}, {
+			UnitName: "other.timer",
+			Active:   true,
+			Enabled:  true,
+		},
+	})
+}
+
+func (s *SystemdTestSuite) TestStatusTimerLastTriggerInvalid(c *C) {
+	s.outs = [][]byte{
+		[]byte(`
+Id=some.timer
+ActiveState=active
+UnitFileState=enabled
+LastTriggerUSec=yesterday
+`[1:]),
+	}
+	s.errors = []error{nil}
+	out, err := New(SystemMode, s.rep).Status("some.timer")
+	c.Assert(err, IsNil)
+	// treated as never triggered
+	c.Check(out, DeepEquals, []*UnitStatus{{
+		UnitName: "some.timer",
+		Active:   true,
+		Enabled:  true,
+	}})
+}
+
 func (s *SystemdTestSuite) TestStatusBadNumberOfValues(c *C) {
 	s.outs = [][]byte{
 		[]byte(`
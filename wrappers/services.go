@@ -709,6 +709,10 @@ Restart={{.Restart}}
 RestartSec={{.App.RestartDelay.Seconds}}
 {{- end}}
 WorkingDirectory={{.WorkingDir}}
+{{- if .App.RunAs}}
+User={{.App.RunAs}}
+Group={{.App.RunAs}}
+{{- end}}
 {{- if .App.StopCommand}}
 ExecStop={{.App.LauncherStopCommand}}
 {{- end}}
@@ -960,6 +964,8 @@ X-Snappy=yes
 Unit={{.ServiceFileName}}
 {{ range .Schedules }}OnCalendar={{ . }}
 {{ end }}
+{{- if .App.Timer.Persistent}}Persistent=true
+{{ end }}
 [Install]
 WantedBy={{.TimersTarget}}
 `
@@ -275,6 +275,48 @@ apps:
 	c.Assert(string(generatedWrapper), Equals, expectedDbusService)
 }
 
+func (s *servicesWrapperGenSuite) TestGenServiceFileRunAs(c *C) {
+	info := snaptest.MockInfo(c, `
+name: snap
+version: 1.0
+system-usernames:
+    snap_snap: private
+apps:
+    app:
+        command: bin/start
+        daemon: simple
+        run-as: snap_snap
+`, &snap.SideInfo{Revision: snap.R(44)})
+
+	app := info.Apps["app"]
+
+	generatedWrapper, err := wrappers.GenerateSnapServiceFile(app, nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(string(generatedWrapper), Equals, fmt.Sprintf(`[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Service for snap application snap.app
+Requires=%[1]s-snap-44.mount
+Wants=network.target
+After=%[1]s-snap-44.mount network.target snapd.apparmor.service
+X-Snappy=yes
+
+[Service]
+EnvironmentFile=-/etc/environment
+ExecStart=/usr/bin/snap run snap.app
+SyslogIdentifier=snap.app
+Restart=on-failure
+WorkingDirectory=/var/snap/snap/44
+User=snap_snap
+Group=snap_snap
+TimeoutStopSec=30
+Type=simple
+
+[Install]
+WantedBy=multi-user.target
+`, mountUnitPrefix))
+}
+
 func (s *servicesWrapperGenSuite) TestGenOneshotServiceFile(c *C) {
 
 	info := snaptest.MockInfo(c, `
@@ -535,6 +577,37 @@ WantedBy=timers.target
 	c.Assert(string(generatedWrapper), Equals, expectedService)
 }
 
+func (s *servicesWrapperGenSuite) TestServiceTimerUnitPersistent(c *C) {
+	service := &snap.AppInfo{
+		Snap: &snap.Info{
+			SuggestedName: "snap",
+			Version:       "0.3.4",
+			SideInfo:      snap.SideInfo{Revision: snap.R(44)},
+		},
+		Name:        "app",
+		Command:     "bin/foo start",
+		Daemon:      "oneshot",
+		DaemonScope: snap.SystemDaemon,
+		StopTimeout: timeout.DefaultTimeout,
+		Timer: &snap.TimerInfo{
+			Timer:      "10:00",
+			Persistent: true,
+		},
+	}
+	service.Timer.App = service
+
+	generatedWrapper, err := wrappers.GenerateSnapTimerFile(service)
+	c.Assert(err, IsNil)
+	c.Check(string(generatedWrapper), testutil.Contains, `
+[Timer]
+Unit=snap.snap.app.service
+OnCalendar=*-*-* 10:00
+Persistent=true
+
+[Install]
+`)
+}
+
 func (s *servicesWrapperGenSuite) TestServiceTimerUnitBadTimer(c *C) {
 	service := &snap.AppInfo{
 		Snap: &snap.Info{